@@ -6,6 +6,7 @@
 package addrmgr
 
 import (
+	"bytes"
 	"container/list"
 	crand "crypto/rand" // for seeding
 	"encoding/base32"
@@ -23,6 +24,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/btcsuite/golangcrypto/sha3"
 	"github.com/tinhnguyenhn/colxd/wire"
 )
 
@@ -49,11 +51,17 @@ type AddrManager struct {
 
 type serializedKnownAddress struct {
 	Addr        string
+	Network     string
 	Src         string
+	SrcNetwork  string
 	Attempts    int
 	TimeStamp   int64
 	LastAttempt int64
 	LastSuccess int64
+	Refused     int
+	Timeouts    int
+	Rejected    int
+	Successes   int
 	// no refcount or tried, that is available from context.
 }
 
@@ -156,7 +164,15 @@ const (
 	getAddrPercent = 23
 
 	// serialisationVersion is the current version of the on-disk format.
-	serialisationVersion = 1
+	//
+	// Version 2 records the network of every address so Tor v3 and I2P
+	// addresses can be told apart from host names when they are loaded.
+	// Version 1 files are upgraded when they are loaded.
+	serialisationVersion = 2
+
+	// torV3Version is the version byte which is encoded in Tor v3 onion
+	// addresses.
+	torV3Version = 0x03
 )
 
 // updateAddress is a helper function to either update an address already known
@@ -371,11 +387,17 @@ func (a *AddrManager) savePeers() {
 	for k, v := range a.addrIndex {
 		ska := new(serializedKnownAddress)
 		ska.Addr = k
+		ska.Network = addrNetwork(v.na)
 		ska.TimeStamp = v.na.Timestamp.Unix()
 		ska.Src = NetAddressKey(v.srcAddr)
+		ska.SrcNetwork = addrNetwork(v.srcAddr)
 		ska.Attempts = v.attempts
 		ska.LastAttempt = v.lastattempt.Unix()
 		ska.LastSuccess = v.lastsuccess.Unix()
+		ska.Refused = v.refused
+		ska.Timeouts = v.timeouts
+		ska.Rejected = v.rejected
+		ska.Successes = v.successes
 		// Tried and refs are implicit in the rest of the structure
 		// and will be worked out from context on unserialisation.
 		sam.Addresses[i] = ska
@@ -452,20 +474,29 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 		return fmt.Errorf("error reading %s: %v", filePath, err)
 	}
 
-	if sam.Version != serialisationVersion {
+	// Version 1 files do not record the network of the addresses, so it
+	// is worked out from the address itself.  That is safe since those
+	// files can only contain IP and onioncat addresses.
+	if sam.Version != 1 && sam.Version != serialisationVersion {
 		return fmt.Errorf("unknown version %v in serialized "+
 			"addrmanager", sam.Version)
 	}
 	copy(a.key[:], sam.Key[:])
 
+	deserialize := func(addr, network string) (*wire.NetAddress, error) {
+		if sam.Version == 1 {
+			return a.DeserializeNetAddress(addr)
+		}
+		return deserializeNetworkAddress(addr, network)
+	}
 	for _, v := range sam.Addresses {
 		ka := new(KnownAddress)
-		ka.na, err = a.DeserializeNetAddress(v.Addr)
+		ka.na, err = deserialize(v.Addr, v.Network)
 		if err != nil {
 			return fmt.Errorf("failed to deserialize netaddress "+
 				"%s: %v", v.Addr, err)
 		}
-		ka.srcAddr, err = a.DeserializeNetAddress(v.Src)
+		ka.srcAddr, err = deserialize(v.Src, v.SrcNetwork)
 		if err != nil {
 			return fmt.Errorf("failed to deserialize netaddress "+
 				"%s: %v", v.Src, err)
@@ -473,6 +504,10 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 		ka.attempts = v.Attempts
 		ka.lastattempt = time.Unix(v.LastAttempt, 0)
 		ka.lastsuccess = time.Unix(v.LastSuccess, 0)
+		ka.refused = v.Refused
+		ka.timeouts = v.Timeouts
+		ka.rejected = v.Rejected
+		ka.successes = v.Successes
 		a.addrIndex[NetAddressKey(ka.na)] = ka
 	}
 
@@ -518,9 +553,50 @@ func (a *AddrManager) deserializePeers(filePath string) error {
 		}
 	}
 
+	if sam.Version != serialisationVersion {
+		log.Infof("Upgrading %s from version %d to version %d",
+			filePath, sam.Version, serialisationVersion)
+	}
+
 	return nil
 }
 
+// deserializeNetworkAddress converts the passed address string which belongs
+// to the passed network to a *wire.NetAddress.  Unlike DeserializeNetAddress,
+// host names are never resolved and an error is returned if the address does
+// not belong to the network.
+func deserializeNetworkAddress(addr, network string) (*wire.NetAddress, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	var ip net.IP
+	switch network {
+	case networkIPv4, networkIPv6:
+		ip = net.ParseIP(host)
+	default:
+		ip, err = decodeOverlayHost(host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if ip == nil {
+		return nil, fmt.Errorf("invalid %s address %s", network, host)
+	}
+
+	na := wire.NewNetAddressIPPort(ip, uint16(port), wire.SFNodeNetwork)
+	if addrNetwork(na) != network {
+		return nil, fmt.Errorf("address %s is not a %s address", host,
+			network)
+	}
+	return na, nil
+}
+
 // DeserializeNetAddress converts a given address string to a *wire.NetAddress
 func (a *AddrManager) DeserializeNetAddress(addr string) (*wire.NetAddress, error) {
 	host, portStr, err := net.SplitHostPort(addr)
@@ -644,12 +720,14 @@ func (a *AddrManager) AddressCache() []*wire.NetAddress {
 		return nil
 	}
 
-	allAddr := make([]*wire.NetAddress, a.nNew+a.nTried)
-	i := 0
+	allAddr := make([]*wire.NetAddress, 0, a.nNew+a.nTried)
 	// Iteration order is undefined here, but we randomise it anyway.
 	for _, v := range a.addrIndex {
-		allAddr[i] = v.na
-		i++
+		// Tor v3 and I2P addresses can't be encoded in addr messages.
+		if IsTorV3(v.na) || IsI2P(v.na) {
+			continue
+		}
+		allAddr = append(allAddr, v.na)
 	}
 
 	numAddresses := len(allAddr) * getAddrPercent / 100
@@ -689,19 +767,13 @@ func (a *AddrManager) reset() {
 // a tor .onion address this will be taken care of. else if the host is not an
 // IP address it will be resolved (via tor if required).
 func (a *AddrManager) HostToNetAddress(host string, port uint16, services wire.ServiceFlag) (*wire.NetAddress, error) {
-	// tor address is 16 char base32 + ".onion"
 	var ip net.IP
-	if len(host) == 22 && host[16:] == ".onion" {
-		// go base32 encoding uses capitals (as does the rfc
-		// but tor and bitcoind tend to user lowercase, so we switch
-		// case here.
-		data, err := base32.StdEncoding.DecodeString(
-			strings.ToUpper(host[:16]))
+	if strings.HasSuffix(host, ".onion") || strings.HasSuffix(host, ".i2p") {
+		var err error
+		ip, err = decodeOverlayHost(host)
 		if err != nil {
 			return nil, err
 		}
-		prefix := []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
-		ip = net.IP(append(prefix, data...))
 	} else if ip = net.ParseIP(host); ip == nil {
 		ips, err := a.lookupFunc(host)
 		if err != nil {
@@ -716,15 +788,92 @@ func (a *AddrManager) HostToNetAddress(host string, port uint16, services wire.S
 	return wire.NewNetAddressIPPort(ip, port, services), nil
 }
 
+// torV3Checksum returns the checksum which is encoded in the Tor v3 onion
+// address for the passed public key.
+func torV3Checksum(pubKey []byte) []byte {
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(pubKey)
+	h.Write([]byte{torV3Version})
+	return h.Sum(nil)[:2]
+}
+
+// decodeOverlayHost converts the passed Tor or I2P host name to the IP
+// representation used for the network.  Tor v2 addresses are converted to the
+// onioncat IPv6 range while Tor v3 and I2P addresses are converted to their
+// network identifier followed by the 32 byte destination.
+func decodeOverlayHost(host string) (net.IP, error) {
+	// go base32 encoding uses capitals (as does the rfc
+	// but tor and bitcoind tend to user lowercase, so we switch
+	// case here.
+	switch {
+	// tor v2 address is 16 char base32 + ".onion"
+	case len(host) == 22 && host[16:] == ".onion":
+		data, err := base32.StdEncoding.DecodeString(
+			strings.ToUpper(host[:16]))
+		if err != nil {
+			return nil, err
+		}
+		prefix := []byte{0xfd, 0x87, 0xd8, 0x7e, 0xeb, 0x43}
+		return net.IP(append(prefix, data...)), nil
+
+	// tor v3 address is 56 char base32 of the 32 byte public key, a 2
+	// byte checksum, and the version byte + ".onion"
+	case len(host) == 62 && host[56:] == ".onion":
+		data, err := base32.StdEncoding.DecodeString(
+			strings.ToUpper(host[:56]))
+		if err != nil {
+			return nil, err
+		}
+		pubKey, checksum, version := data[:32], data[32:34], data[34]
+		if version != torV3Version {
+			return nil, fmt.Errorf("unsupported onion address "+
+				"version %d", version)
+		}
+		if !bytes.Equal(checksum, torV3Checksum(pubKey)) {
+			return nil, fmt.Errorf("invalid onion address "+
+				"checksum for %s", host)
+		}
+		return net.IP(append([]byte{torV3NetID}, pubKey...)), nil
+
+	// i2p address is 52 char unpadded base32 of the 32 byte destination
+	// hash + ".b32.i2p"
+	case len(host) == 60 && host[52:] == ".b32.i2p":
+		data, err := base32.StdEncoding.DecodeString(
+			strings.ToUpper(host[:52]) + "====")
+		if err != nil {
+			return nil, err
+		}
+		return net.IP(append([]byte{i2pNetID}, data...)), nil
+	}
+
+	return nil, fmt.Errorf("invalid overlay network address %s", host)
+}
+
 // ipString returns a string for the ip from the provided NetAddress. If the
 // ip is in the range used for tor addresses then it will be transformed into
-// the relevant .onion address.
+// the relevant .onion address.  Likewise, Tor v3 and I2P addresses are
+// transformed into their .onion and .b32.i2p addresses.
 func ipString(na *wire.NetAddress) string {
 	if IsOnionCatTor(na) {
 		// We know now that na.IP is long enogh.
 		base32 := base32.StdEncoding.EncodeToString(na.IP[6:])
 		return strings.ToLower(base32) + ".onion"
 	}
+	if IsTorV3(na) {
+		pubKey := na.IP[1:]
+		data := make([]byte, 0, 35)
+		data = append(data, pubKey...)
+		data = append(data, torV3Checksum(pubKey)...)
+		data = append(data, torV3Version)
+		base32 := base32.StdEncoding.EncodeToString(data)
+		return strings.ToLower(base32) + ".onion"
+	}
+	if IsI2P(na) {
+		base32 := base32.StdEncoding.EncodeToString(na.IP[1:])
+		return strings.ToLower(strings.TrimRight(base32, "=")) +
+			".b32.i2p"
+	}
 
 	return na.IP.String()
 }
@@ -751,21 +900,28 @@ func (a *AddrManager) GetAddress(class string) *KnownAddress {
 	}
 
 	// Use a 50% chance for choosing between tried and new table entries.
+	//
+	// Within a table, the entry which is considered is picked uniformly at
+	// random by choosing the bucket weighted by the number of entries it
+	// holds.  Choosing the bucket uniformly instead would favour addresses
+	// in sparsely populated buckets, so networks with few groups such as
+	// Tor and I2P would be selected out of proportion to their share of
+	// the table.
 	if a.nTried > 0 && (a.nNew == 0 || a.rand.Intn(2) == 0) {
 		// Tried entry.
 		large := 1 << 30
 		factor := 1.0
 		for {
-			// pick a random bucket.
-			bucket := a.rand.Intn(len(a.addrTried))
-			if a.addrTried[bucket].Len() == 0 {
-				continue
+			// Pick a random entry and find the bucket holding it.
+			nth := a.rand.Intn(a.nTried)
+			bucket := 0
+			for ; nth >= a.addrTried[bucket].Len(); bucket++ {
+				nth -= a.addrTried[bucket].Len()
 			}
 
-			// Pick a random entry in the list
+			// Pick the entry in the list
 			e := a.addrTried[bucket].Front()
-			for i :=
-				a.rand.Int63n(int64(a.addrTried[bucket].Len())); i > 0; i-- {
+			for ; nth > 0; nth-- {
 				e = e.Next()
 			}
 			ka := e.Value.(*KnownAddress)
@@ -782,15 +938,23 @@ func (a *AddrManager) GetAddress(class string) *KnownAddress {
 		// XXX use a closure/function to avoid repeating this.
 		large := 1 << 30
 		factor := 1.0
+
+		// An address may be referenced by more than one new bucket,
+		// so the total number of entries is counted.
+		var numEntries int
+		for i := range a.addrNew {
+			numEntries += len(a.addrNew[i])
+		}
 		for {
-			// Pick a random bucket.
-			bucket := a.rand.Intn(len(a.addrNew))
-			if len(a.addrNew[bucket]) == 0 {
-				continue
+			// Pick a random entry and find the bucket holding it.
+			nth := a.rand.Intn(numEntries)
+			bucket := 0
+			for ; nth >= len(a.addrNew[bucket]); bucket++ {
+				nth -= len(a.addrNew[bucket])
 			}
-			// Then, a random entry in it.
+
+			// Then, the entry in it.
 			var ka *KnownAddress
-			nth := a.rand.Intn(len(a.addrNew[bucket]))
 			for _, value := range a.addrNew[bucket] {
 				if nth == 0 {
 					ka = value
@@ -829,6 +993,47 @@ func (a *AddrManager) Attempt(addr *wire.NetAddress) {
 	ka.lastattempt = time.Now()
 }
 
+// MarkOutcome records the outcome of an attempt to connect to the given
+// address.  Failed outcomes make the address less likely to be selected by
+// GetAddress until a successful outcome is recorded, with rejected handshakes
+// penalised the most.  The address must already be known to AddrManager else it
+// will be ignored.
+func (a *AddrManager) MarkOutcome(addr *wire.NetAddress, outcome ConnectionOutcome) {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	ka := a.find(addr)
+	if ka == nil {
+		return
+	}
+	ka.markOutcome(outcome)
+}
+
+// AddressInfo returns statistics about the address with the passed key, which
+// is in the form returned by NetAddressKey.  It returns nil if the address is
+// not known to the address manager.
+func (a *AddrManager) AddressInfo(addr string) *AddressInfo {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	ka, ok := a.addrIndex[addr]
+	if !ok {
+		return nil
+	}
+	return &AddressInfo{
+		Addr:        addr,
+		Tried:       ka.tried,
+		Attempts:    ka.attempts,
+		LastAttempt: ka.lastattempt,
+		LastSuccess: ka.lastsuccess,
+		Refused:     ka.refused,
+		Timeouts:    ka.timeouts,
+		Rejected:    ka.rejected,
+		Successes:   ka.successes,
+		Chance:      ka.chance(),
+	}
+}
+
 // Connected Marks the given address as currently connected and working at the
 // current time.  The address must already be known to AddrManager else it will
 // be ignored.
@@ -983,8 +1188,15 @@ func getReachabilityFrom(localAddr, remoteAddr *wire.NetAddress) int {
 		return Unreachable
 	}
 
-	if IsOnionCatTor(remoteAddr) {
-		if IsOnionCatTor(localAddr) {
+	if IsI2P(remoteAddr) {
+		if IsI2P(localAddr) {
+			return Private
+		}
+		return Unreachable
+	}
+
+	if IsOnionCatTor(remoteAddr) || IsTorV3(remoteAddr) {
+		if IsOnionCatTor(localAddr) || IsTorV3(localAddr) {
 			return Private
 		}
 
@@ -1076,7 +1288,8 @@ func (a *AddrManager) GetBestLocalAddress(remoteAddr *wire.NetAddress) *wire.Net
 			Services:  wire.SFNodeNetwork,
 			Port:      0,
 		}
-		if !IsIPv4(remoteAddr) && !IsOnionCatTor(remoteAddr) {
+		if !IsIPv4(remoteAddr) && !IsOnionCatTor(remoteAddr) &&
+			!IsTorV3(remoteAddr) && !IsI2P(remoteAddr) {
 			bestAddress.IP = net.IPv6zero
 		} else {
 			bestAddress.IP = net.IPv4zero
@@ -6,13 +6,22 @@
 package addrmgr_test
 
 import (
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
+	"math"
 	"net"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/btcsuite/golangcrypto/sha3"
 	"github.com/tinhnguyenhn/colxd/addrmgr"
 	"github.com/tinhnguyenhn/colxd/wire"
 )
@@ -487,3 +496,355 @@ func TestNetAddressKey(t *testing.T) {
 	}
 
 }
+
+// torV3Host returns a valid Tor v3 onion host name for the passed public key.
+func torV3Host(pubKey []byte) string {
+	h := sha3.New256()
+	h.Write([]byte(".onion checksum"))
+	h.Write(pubKey)
+	h.Write([]byte{0x03})
+	data := append(append(append([]byte{}, pubKey...), h.Sum(nil)[:2]...),
+		0x03)
+	return strings.ToLower(base32.StdEncoding.EncodeToString(data)) +
+		".onion"
+}
+
+// i2pHost returns an I2P host name for the passed destination hash.
+func i2pHost(dest []byte) string {
+	encoded := base32.StdEncoding.EncodeToString(dest)
+	return strings.ToLower(strings.TrimRight(encoded, "=")) + ".b32.i2p"
+}
+
+// TestOverlayAddresses ensures Tor v3 and I2P host names are converted to
+// net addresses which identify their network, produce the same host name
+// again, and are grouped by their network.
+func TestOverlayAddresses(t *testing.T) {
+	n := addrmgr.New("testoverlayaddresses", lookupFunc)
+
+	tests := []struct {
+		host  string
+		torV3 bool
+		i2p   bool
+		group string
+	}{
+		{
+			host:  "zklycewkdo64v6wcggzzui64jwtyn37ycr6e44vzqb3yll7ojc567tyd.onion",
+			torV3: true,
+			group: "torv3:10",
+		},
+		{
+			host:  "hyr6qfqahfmuum4jj5swjynrgsf326qardkcyswlopxk5vm4aco726yd.onion",
+			torV3: true,
+			group: "torv3:14",
+		},
+		{
+			host:  "2pjhfnl2frfcbiol3dcawwn645vonvwrpo464hgnm6fz2v3cgwga.b32.i2p",
+			i2p:   true,
+			group: "i2p:3",
+		},
+		{
+			host:  "aaaaaaaaaaaaaaaa.onion",
+			group: "tor:0",
+		},
+	}
+
+	for i, test := range tests {
+		na, err := n.HostToNetAddress(test.host, 8333, wire.SFNodeNetwork)
+		if err != nil {
+			t.Errorf("HostToNetAddress #%d: unexpected error: %v", i,
+				err)
+			continue
+		}
+		if addrmgr.IsTorV3(na) != test.torV3 {
+			t.Errorf("IsTorV3 #%d: got %v, want %v", i,
+				addrmgr.IsTorV3(na), test.torV3)
+		}
+		if addrmgr.IsI2P(na) != test.i2p {
+			t.Errorf("IsI2P #%d: got %v, want %v", i,
+				addrmgr.IsI2P(na), test.i2p)
+		}
+		if !addrmgr.IsRoutable(na) {
+			t.Errorf("IsRoutable #%d: %s is not routable", i,
+				test.host)
+		}
+		if key := addrmgr.GroupKey(na); key != test.group {
+			t.Errorf("GroupKey #%d: got %s, want %s", i, key,
+				test.group)
+		}
+		want := test.host + ":8333"
+		if key := addrmgr.NetAddressKey(na); key != want {
+			t.Errorf("NetAddressKey #%d: got %s, want %s", i, key,
+				want)
+		}
+	}
+
+	// Tor v3 addresses with a bad checksum or version must be rejected.
+	badHosts := []string{
+		"zklycewkdo64v6wcggzzui64jwtyn37ycr6e44vzqb3yll7ojc567tya.onion",
+		"zklycewkdo64v6wcggzzui64jwtyn37ycr6e44vzqb3yll7ojc567tyc.onion",
+		"zklycewkdo64v6wcggzzui64jwtyn37ycr6e44vzqb3yll7ojc56.onion",
+	}
+	for _, host := range badHosts {
+		if _, err := n.HostToNetAddress(host, 8333, 0); err == nil {
+			t.Errorf("HostToNetAddress: expected error for %s", host)
+		}
+	}
+}
+
+// TestPeersFileUpgrade ensures a peers file in the original format is loaded
+// without losing any addresses and is written back in the current format, and
+// that Tor v3 and I2P addresses survive a save and load.
+func TestPeersFileUpgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testpeersfileupgrade")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	peersFile := filepath.Join(dir, "peers.json")
+
+	readPeersFile := func() map[string]interface{} {
+		data, err := ioutil.ReadFile(peersFile)
+		if err != nil {
+			t.Fatalf("unable to read peers file: %v", err)
+		}
+		var sam map[string]interface{}
+		if err := json.Unmarshal(data, &sam); err != nil {
+			t.Fatalf("unable to decode peers file: %v", err)
+		}
+		return sam
+	}
+	addrNetworks := func(sam map[string]interface{}) map[string]string {
+		networks := make(map[string]string)
+		for _, v := range sam["Addresses"].([]interface{}) {
+			ska := v.(map[string]interface{})
+			network, _ := ska["Network"].(string)
+			networks[ska["Addr"].(string)] = network
+		}
+		return networks
+	}
+
+	// Create a peers file with addresses from the networks which could be
+	// stored by the original format and convert it to that format.
+	n := addrmgr.New(dir, lookupFunc)
+	n.Start()
+	want := map[string]string{
+		"173.194.115.66:8333":         "ipv4",
+		"12.1.2.3:8333":               "ipv4",
+		"[2602:100::1]:8333":          "ipv6",
+		"aaaaaaaaaaaaaaaa.onion:8333": "torv2",
+	}
+	for addr := range want {
+		na, err := n.DeserializeNetAddress(addr)
+		if err != nil {
+			t.Fatalf("DeserializeNetAddress %s: %v", addr, err)
+		}
+		na.Timestamp = time.Now()
+		n.AddAddress(na, na)
+	}
+	good, _ := n.DeserializeNetAddress("12.1.2.3:8333")
+	n.Good(good)
+	n.Stop()
+
+	sam := readPeersFile()
+	sam["Version"] = 1
+	for _, v := range sam["Addresses"].([]interface{}) {
+		ska := v.(map[string]interface{})
+		delete(ska, "Network")
+		delete(ska, "SrcNetwork")
+	}
+	data, err := json.Marshal(sam)
+	if err != nil {
+		t.Fatalf("unable to encode peers file: %v", err)
+	}
+	if err := ioutil.WriteFile(peersFile, data, 0644); err != nil {
+		t.Fatalf("unable to write peers file: %v", err)
+	}
+
+	// Load the original format and ensure it is upgraded.
+	n = addrmgr.New(dir, lookupFunc)
+	n.Start()
+	if got := n.NumAddresses(); got != len(want) {
+		t.Fatalf("unexpected number of addresses after upgrade - "+
+			"got %d, want %d", got, len(want))
+	}
+
+	// Add Tor v3 and I2P addresses to ensure they are persisted.
+	overlayHosts := map[string]string{
+		"zklycewkdo64v6wcggzzui64jwtyn37ycr6e44vzqb3yll7ojc567tyd.onion": "torv3",
+		"2pjhfnl2frfcbiol3dcawwn645vonvwrpo464hgnm6fz2v3cgwga.b32.i2p":   "i2p",
+	}
+	for host, network := range overlayHosts {
+		na, err := n.HostToNetAddress(host, 8333, wire.SFNodeNetwork)
+		if err != nil {
+			t.Fatalf("HostToNetAddress %s: %v", host, err)
+		}
+		na.Timestamp = time.Now()
+		n.AddAddress(na, na)
+		want[host+":8333"] = network
+	}
+	n.Stop()
+
+	sam = readPeersFile()
+	if version := sam["Version"].(float64); version != 2 {
+		t.Fatalf("unexpected peers file version - got %v, want 2",
+			version)
+	}
+	if got := addrNetworks(sam); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected addresses after upgrade - got %v, want %v",
+			got, want)
+	}
+
+	// Ensure the upgraded file loads with every address.
+	n = addrmgr.New(dir, lookupFunc)
+	n.Start()
+	defer n.Stop()
+	if got := n.NumAddresses(); got != len(want) {
+		t.Fatalf("unexpected number of addresses after reload - "+
+			"got %d, want %d", got, len(want))
+	}
+}
+
+// TestGetAddressNetworkProportions ensures addresses are selected in rough
+// proportion to the number of addresses known for each network so Tor v3 and
+// I2P addresses are neither starved nor over-selected.
+func TestGetAddressNetworkProportions(t *testing.T) {
+	n := addrmgr.New("testgetaddressnetworkproportions", lookupFunc)
+
+	const (
+		numIPv4  = 200
+		numTorV3 = 100
+		numI2P   = 100
+	)
+	var hosts []string
+	for i := 0; i < numIPv4; i++ {
+		hosts = append(hosts, fmt.Sprintf("%d.%d.1.1", 12+i%100,
+			i/100+1))
+	}
+	for i := 0; i < numTorV3; i++ {
+		dest := sha256.Sum256([]byte(fmt.Sprintf("torv3 %d", i)))
+		hosts = append(hosts, torV3Host(dest[:]))
+	}
+	for i := 0; i < numI2P; i++ {
+		dest := sha256.Sum256([]byte(fmt.Sprintf("i2p %d", i)))
+		hosts = append(hosts, i2pHost(dest[:]))
+	}
+	for i, host := range hosts {
+		na, err := n.HostToNetAddress(host, 8333, wire.SFNodeNetwork)
+		if err != nil {
+			t.Fatalf("HostToNetAddress %s: %v", host, err)
+		}
+		na.Timestamp = time.Now()
+		n.AddAddress(na, na)
+
+		// Move some of the addresses from every network to the tried
+		// table so both tables are sampled.
+		if i%2 == 0 {
+			n.Good(na)
+		}
+	}
+	if got := n.NumAddresses(); got != len(hosts) {
+		t.Fatalf("unexpected number of addresses - got %d, want %d",
+			got, len(hosts))
+	}
+
+	const numSamples = 4000
+	counts := make(map[string]int)
+	for i := 0; i < numSamples; i++ {
+		na := n.GetAddress("any").NetAddress()
+		switch {
+		case addrmgr.IsTorV3(na):
+			counts["torv3"]++
+		case addrmgr.IsI2P(na):
+			counts["i2p"]++
+		default:
+			counts["ipv4"]++
+		}
+	}
+
+	wantFractions := map[string]float64{
+		"ipv4":  float64(numIPv4) / float64(len(hosts)),
+		"torv3": float64(numTorV3) / float64(len(hosts)),
+		"i2p":   float64(numI2P) / float64(len(hosts)),
+	}
+	for network, want := range wantFractions {
+		got := float64(counts[network]) / numSamples
+		if math.Abs(got-want) > 0.05 {
+			t.Errorf("unexpected fraction of %s addresses selected "+
+				"- got %.3f, want %.3f", network, got, want)
+		}
+	}
+}
+
+// TestMarkOutcome ensures connection outcomes are reflected by AddressInfo,
+// persisted, and that a repeatedly refused address is selected less often than
+// a fresh one.
+func TestMarkOutcome(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testmarkoutcome")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	n := addrmgr.New(dir, lookupFunc)
+	n.Start()
+	fresh, _ := n.DeserializeNetAddress("173.194.115.66:8333")
+	refused, _ := n.DeserializeNetAddress("12.1.2.3:8333")
+	for _, na := range []*wire.NetAddress{fresh, refused} {
+		na.Timestamp = time.Now()
+		n.AddAddress(na, na)
+	}
+	freshKey := addrmgr.NetAddressKey(fresh)
+	refusedKey := addrmgr.NetAddressKey(refused)
+
+	// Unknown addresses are ignored.
+	unknown, _ := n.DeserializeNetAddress("12.1.2.4:8333")
+	n.MarkOutcome(unknown, addrmgr.OutcomeRefused)
+	if info := n.AddressInfo(addrmgr.NetAddressKey(unknown)); info != nil {
+		t.Fatalf("AddressInfo: unexpected info for unknown address %v",
+			info)
+	}
+
+	// A success must reset the failures.
+	n.MarkOutcome(fresh, addrmgr.OutcomeRejected)
+	n.MarkOutcome(fresh, addrmgr.OutcomeSuccess)
+	for i := 0; i < 3; i++ {
+		n.MarkOutcome(refused, addrmgr.OutcomeRefused)
+	}
+	n.MarkOutcome(refused, addrmgr.OutcomeTimeout)
+
+	checkInfo := func(desc string) {
+		info := n.AddressInfo(freshKey)
+		if info == nil || info.Rejected != 0 || info.Successes != 1 {
+			t.Fatalf("%s: unexpected info for fresh address %+v",
+				desc, info)
+		}
+		info = n.AddressInfo(refusedKey)
+		if info == nil || info.Refused != 3 || info.Timeouts != 1 ||
+			info.Rejected != 0 || info.Successes != 0 {
+			t.Fatalf("%s: unexpected info for refused address %+v",
+				desc, info)
+		}
+		if want := 1.0 / 2 / 2 / 2 / 1.5; math.Abs(info.Chance-want) > 0.0001 {
+			t.Fatalf("%s: unexpected chance for refused address - "+
+				"got %f, want %f", desc, info.Chance, want)
+		}
+	}
+	checkInfo("before restart")
+
+	// The outcomes must survive a restart.
+	n.Stop()
+	n = addrmgr.New(dir, lookupFunc)
+	n.Start()
+	defer n.Stop()
+	checkInfo("after restart")
+
+	// The refused address must be selected noticeably less often.
+	counts := make(map[string]int)
+	for i := 0; i < 2000; i++ {
+		counts[addrmgr.NetAddressKey(n.GetAddress("any").NetAddress())]++
+	}
+	if counts[refusedKey]*2 >= counts[freshKey] {
+		t.Fatalf("refused address selected too often - refused %d, "+
+			"fresh %d", counts[refusedKey], counts[freshKey])
+	}
+}
@@ -30,7 +30,12 @@ drastically reduces the chances an attacker is able to coerce your peer into
 only connecting to nodes they control.
 
 The address manager also understands routability and tor addresses and tries
-hard to only return routable addresses.  In addition, it uses the information
+hard to only return routable addresses.  Tor v3 and I2P addresses, which can't
+be represented as an IP address, are stored in the IP field of a
+wire.NetAddress as their BIP155 network identifier followed by the 32 byte
+destination.  They are grouped separately from IP addresses and selected in
+proportion to their share of the known addresses.  Since they can't be encoded
+in addr messages, they are not returned by AddressCache.  In addition, it uses the information
 provided by the caller about connected, known good, and attempted addresses to
 periodically purge peers which no longer appear to be good peers as well as
 bias the selection toward known good peers.  The general idea is to make a best
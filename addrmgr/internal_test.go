@@ -24,3 +24,7 @@ func TstNewKnownAddress(na *wire.NetAddress, attempts int,
 	return &KnownAddress{na: na, attempts: attempts, lastattempt: lastattempt,
 		lastsuccess: lastsuccess, tried: tried, refs: refs}
 }
+
+func TstKnownAddressMarkOutcome(ka *KnownAddress, outcome ConnectionOutcome) {
+	ka.markOutcome(outcome)
+}
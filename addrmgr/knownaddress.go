@@ -6,6 +6,7 @@
 package addrmgr
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/tinhnguyenhn/colxd/wire"
@@ -21,6 +22,67 @@ type KnownAddress struct {
 	lastsuccess time.Time
 	tried       bool
 	refs        int // reference count of new buckets
+
+	// The following track the outcomes of connections to the address.
+	// The failure counts are reset by a successful connection.
+	refused   int
+	timeouts  int
+	rejected  int
+	successes int
+}
+
+// ConnectionOutcome describes the result of an attempt to connect to and
+// complete the version handshake with an address.
+type ConnectionOutcome int
+
+// These constants define the outcomes which can be reported to MarkOutcome.
+const (
+	// OutcomeSuccess indicates the connection was established and the
+	// version handshake completed.
+	OutcomeSuccess ConnectionOutcome = iota
+
+	// OutcomeRefused indicates the connection was refused or otherwise
+	// could not be established.
+	OutcomeRefused
+
+	// OutcomeTimeout indicates the connection or the version handshake
+	// timed out.
+	OutcomeTimeout
+
+	// OutcomeRejected indicates the connection was established but the
+	// version handshake failed, such as when the remote peer advertises
+	// an unacceptable protocol version.
+	OutcomeRejected
+)
+
+// Map of connection outcomes back to their constant names for pretty printing.
+var outcomeStrings = map[ConnectionOutcome]string{
+	OutcomeSuccess:  "OutcomeSuccess",
+	OutcomeRefused:  "OutcomeRefused",
+	OutcomeTimeout:  "OutcomeTimeout",
+	OutcomeRejected: "OutcomeRejected",
+}
+
+// String returns the ConnectionOutcome in human-readable form.
+func (o ConnectionOutcome) String() string {
+	if s, ok := outcomeStrings[o]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown ConnectionOutcome (%d)", int(o))
+}
+
+// AddressInfo houses statistics about an address known to the address manager.
+type AddressInfo struct {
+	Addr        string
+	Tried       bool
+	Attempts    int
+	LastAttempt time.Time
+	LastSuccess time.Time
+	Refused     int
+	Timeouts    int
+	Rejected    int
+	Successes   int
+	Chance      float64
 }
 
 // NetAddress returns the underlying wire.NetAddress associated with the
@@ -61,9 +123,41 @@ func (ka *KnownAddress) chance() float64 {
 		c /= 1.5
 	}
 
+	// Connection failures since the last success deprioritise further
+	// depending on how unlikely the address is to become usable.  Refused
+	// connections and timeouts are often transient, while a rejected
+	// handshake, such as for an obsolete protocol version, is unlikely to
+	// change any time soon.
+	for i := ka.refused; i > 0; i-- {
+		c /= 2
+	}
+	for i := ka.timeouts; i > 0; i-- {
+		c /= 1.5
+	}
+	for i := ka.rejected; i > 0; i-- {
+		c /= 4
+	}
+
 	return c
 }
 
+// markOutcome records the passed connection outcome for the address.
+func (ka *KnownAddress) markOutcome(outcome ConnectionOutcome) {
+	switch outcome {
+	case OutcomeSuccess:
+		ka.successes++
+		ka.refused = 0
+		ka.timeouts = 0
+		ka.rejected = 0
+	case OutcomeRefused:
+		ka.refused++
+	case OutcomeTimeout:
+		ka.timeouts++
+	case OutcomeRejected:
+		ka.rejected++
+	}
+}
+
 // isBad returns true if the address in question has not been tried in the last
 // minute and meets one of the following criteria:
 // 1) It claims to be from the future
@@ -47,6 +47,32 @@ func TestChance(t *testing.T) {
 		},
 	}
 
+	// Connection failures deprioritise based on the outcome and are
+	// forgotten after a success.
+	outcomeTests := []struct {
+		outcomes []addrmgr.ConnectionOutcome
+		expected float64
+	}{
+		{[]addrmgr.ConnectionOutcome{addrmgr.OutcomeRefused}, 1.0 / 2},
+		{[]addrmgr.ConnectionOutcome{addrmgr.OutcomeTimeout,
+			addrmgr.OutcomeTimeout}, 1 / 1.5 / 1.5},
+		{[]addrmgr.ConnectionOutcome{addrmgr.OutcomeRejected,
+			addrmgr.OutcomeRefused}, 1.0 / 4 / 2},
+		{[]addrmgr.ConnectionOutcome{addrmgr.OutcomeRejected,
+			addrmgr.OutcomeSuccess}, 1.0},
+	}
+	for _, test := range outcomeTests {
+		ka := addrmgr.TstNewKnownAddress(&wire.NetAddress{Timestamp: time.Now().Add(-35 * time.Second)},
+			0, time.Now().Add(-30*time.Minute), time.Now(), false, 0)
+		for _, outcome := range test.outcomes {
+			addrmgr.TstKnownAddressMarkOutcome(ka, outcome)
+		}
+		tests = append(tests, struct {
+			addr     *addrmgr.KnownAddress
+			expected float64
+		}{ka, test.expected})
+	}
+
 	err := .0001
 	for i, test := range tests {
 		chance := addrmgr.TstKnownAddressChance(test.addr)
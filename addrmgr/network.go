@@ -93,6 +93,31 @@ var (
 	heNet = ipNet("2001:470::", 32, 128)
 )
 
+const (
+	// torV3NetID and i2pNetID are the network identifiers assigned to Tor
+	// v3 and I2P addresses by BIP155.  Addresses on these networks can't
+	// be represented as an IP address, so they are stored in the IP field
+	// of a wire.NetAddress as the network identifier followed by the 32
+	// byte destination.  Such addresses have a length which does not
+	// match any IP address so they are never mistaken for one.
+	torV3NetID = 0x04
+	i2pNetID   = 0x05
+
+	// extendedAddrLen is the length of the IP field of a Tor v3 or I2P
+	// address.
+	extendedAddrLen = 33
+)
+
+const (
+	// The following are the names of the networks an address can belong
+	// to as recorded in the serialized address manager.
+	networkIPv4  = "ipv4"
+	networkIPv6  = "ipv6"
+	networkTorV2 = "torv2"
+	networkTorV3 = "torv3"
+	networkI2P   = "i2p"
+)
+
 // ipNet returns a net.IPNet struct given the passed IP address string, number
 // of one bits to include at the start of the mask, and the total number of bits
 // for the mask.
@@ -118,6 +143,32 @@ func IsOnionCatTor(na *wire.NetAddress) bool {
 	return onionCatNet.Contains(na.IP)
 }
 
+// IsTorV3 returns whether or not the passed address is a Tor v3 onion
+// address.
+func IsTorV3(na *wire.NetAddress) bool {
+	return len(na.IP) == extendedAddrLen && na.IP[0] == torV3NetID
+}
+
+// IsI2P returns whether or not the passed address is an I2P address.
+func IsI2P(na *wire.NetAddress) bool {
+	return len(na.IP) == extendedAddrLen && na.IP[0] == i2pNetID
+}
+
+// addrNetwork returns the name of the network the passed address belongs to.
+func addrNetwork(na *wire.NetAddress) string {
+	switch {
+	case IsTorV3(na):
+		return networkTorV3
+	case IsI2P(na):
+		return networkI2P
+	case IsOnionCatTor(na):
+		return networkTorV2
+	case IsIPv4(na):
+		return networkIPv4
+	}
+	return networkIPv6
+}
+
 // IsRFC1918 returns whether or not the passed address is part of the IPv4
 // private network address space as defined by RFC1918 (10.0.0.0/8,
 // 172.16.0.0/12, or 192.168.0.0/16).
@@ -233,9 +284,21 @@ func IsRoutable(na *wire.NetAddress) bool {
 // GroupKey returns a string representing the network group an address is part
 // of.  This is the /16 for IPv4, the /32 (/36 for he.net) for IPv6, the string
 // "local" for a local address, the string "tor:key" where key is the /4 of the
-// onion address for tor address, and the string "unroutable" for an unroutable
-// address.
+// onion address for tor address, the strings "torv3:key" and "i2p:key" where
+// key is the /4 of the destination for Tor v3 and I2P addresses, and the
+// string "unroutable" for an unroutable address.
 func GroupKey(na *wire.NetAddress) string {
+	// Tor v3 and I2P destinations are not IP addresses, so there are no
+	// meaningful subnets.  Instead, the destinations are split over a
+	// small number of groups so they are spread over the buckets the same
+	// way as onioncat addresses without any single network being able to
+	// take over the tables.
+	if IsTorV3(na) {
+		return fmt.Sprintf("torv3:%d", na.IP[1]&((1<<4)-1))
+	}
+	if IsI2P(na) {
+		return fmt.Sprintf("i2p:%d", na.IP[1]&((1<<4)-1))
+	}
 	if IsLocal(na) {
 		return "local"
 	}
@@ -5,7 +5,11 @@
 
 package blockchain
 
-import "github.com/tinhnguyenhn/colxutil"
+import (
+	"context"
+
+	"github.com/tinhnguyenhn/colxutil"
+)
 
 // maybeAcceptBlock potentially accepts a block into the memory block chain.
 // It performs several validation checks which depend on its position within
@@ -19,8 +23,12 @@ import "github.com/tinhnguyenhn/colxutil"
 // The flags are also passed to checkBlockContext and connectBestChain.  See
 // their documentation for how the flags modify their behavior.
 //
+// The context is checked before the memory chain index is modified and is
+// passed to connectBestChain, so the block is not accepted and the chain state
+// is left untouched when it is canceled before the block is connected.
+//
 // This function MUST be called with the chain state lock held (for writes).
-func (b *BlockChain) maybeAcceptBlock(block *colxutil.Block, flags BehaviorFlags) error {
+func (b *BlockChain) maybeAcceptBlock(ctx context.Context, block *colxutil.Block, flags BehaviorFlags) error {
 	dryRun := flags&BFDryRun == BFDryRun
 
 	// Get a block node for the block previous to this one.  Will be nil
@@ -45,6 +53,9 @@ func (b *BlockChain) maybeAcceptBlock(block *colxutil.Block, flags BehaviorFlags
 	if err != nil {
 		return err
 	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	// Prune block nodes which are no longer needed before creating
 	// a new node.
@@ -65,14 +76,27 @@ func (b *BlockChain) maybeAcceptBlock(block *colxutil.Block, flags BehaviorFlags
 		newNode.workSum.Add(prevNode.workSum, newNode.workSum)
 	}
 
+	// Cache the median time of the block now that it is linked to its
+	// parent, which derives it from the window of timestamps cached on the
+	// parent rather than walking the previous blocks again.
+	if _, err := b.calcPastMedianTime(newNode); err != nil {
+		return err
+	}
+
 	// Connect the passed block to the chain while respecting proper chain
 	// selection according to the chain with the most proof of work.  This
 	// also handles validation of the transaction scripts.
-	err = b.connectBestChain(newNode, block, flags)
+	err = b.connectBestChain(ctx, newNode, block, flags)
 	if err != nil {
 		return err
 	}
 
+	// The header of the block no longer needs to be tracked separately
+	// now that the block is connected.
+	if !dryRun {
+		delete(b.headerIndex, *block.Sha())
+	}
+
 	// Notify the caller that the new block was accepted into the block
 	// chain.  The caller would typically want to react by relaying the
 	// inventory to other peers.
@@ -0,0 +1,151 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+// assumeValidMinDepth is the minimum number of blocks the best known header
+// must be ahead of a block in order for the scripts of the block to be assumed
+// valid due to it being an ancestor of the assumevalid block.  It roughly
+// corresponds to two weeks worth of blocks and ensures an attacker would have
+// to produce a substantial amount of work on top of invalid blocks in order to
+// take advantage of the skipped checks.
+const assumeValidMinDepth = 2016
+
+// Blocks which are ancestors of the assumevalid block skip script validation
+// when they are connected, however all of the other checks such as those for
+// the amounts, signature operation counts, and the utxo accounting are still
+// performed.  Since the assumevalid block is typically well ahead of the blocks
+// being connected during the initial download, the information needed to
+// determine the ancestry is cached as follows:
+//
+//   - The node for the assumevalid block is resolved once its header is known,
+//     either via the header index or because the block itself is known
+//   - The ancestors of the assumevalid block are cached by height as they are
+//     needed, walking back from the furthest ancestor found so far
+//   - Whether or not the best known header chain contains the assumevalid
+//     block is cached along with the header it was determined for, and
+//     determined again whenever the best header changes
+//
+// The cached state is cleared on every reorganize so it is determined again in
+// case the reorganize orphaned the assumevalid block.
+
+// resetAssumeValid clears the cached state used to determine whether or not
+// blocks are ancestors of the assumevalid block.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) resetAssumeValid() {
+	b.assumeValidNode = nil
+	b.assumeValidPath = nil
+	b.assumeValidTip = nil
+	b.assumeValidInTip = false
+}
+
+// assumeValidAncestor returns the ancestor of the assumevalid block at the
+// passed height, or nil when the height is after the assumevalid block.  The
+// node for the assumevalid block MUST have been resolved.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) assumeValidAncestor(height int32) (*blockNode, error) {
+	avNode := b.assumeValidNode
+	if height > avNode.height || height < 0 {
+		return nil, nil
+	}
+
+	// Extend the cached ancestors back to the requested height as needed.
+	// The cached ancestors are in order of descending height starting with
+	// the assumevalid block itself.
+	offset := int(avNode.height - height)
+	for len(b.assumeValidPath) <= offset {
+		last := b.assumeValidPath[len(b.assumeValidPath)-1]
+		prevNode, err := b.getPrevNodeFromNode(last)
+		if err != nil {
+			return nil, err
+		}
+		if prevNode == nil {
+			return nil, nil
+		}
+		b.assumeValidPath = append(b.assumeValidPath, prevNode)
+	}
+	return b.assumeValidPath[offset], nil
+}
+
+// isAncestor returns whether or not the passed ancestor node is an ancestor of,
+// or the same as, the passed node.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) isAncestor(ancestor, node *blockNode) (bool, error) {
+	for node != nil && node.height > ancestor.height {
+		var err error
+		node, err = b.getPrevNodeFromNode(node)
+		if err != nil {
+			return false, err
+		}
+	}
+	return node != nil && node.hash.IsEqual(ancestor.hash), nil
+}
+
+// isAssumedValid returns whether or not the scripts of the block represented by
+// the passed node, which is being connected to the main chain, are assumed to
+// be valid.  That is the case when the node is an ancestor of the assumevalid
+// block, the best known header chain contains the assumevalid block, and the
+// node is buried at least assumeValidMinDepth blocks under the best known
+// header.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) isAssumedValid(node *blockNode) (bool, error) {
+	if b.assumeValid == nil {
+		return false, nil
+	}
+
+	// Resolve the node for the assumevalid block once its header is known.
+	if b.assumeValidNode == nil {
+		avNode, err := b.lookupHeaderNode(b.assumeValid)
+		if err != nil || avNode == nil {
+			return false, err
+		}
+		b.assumeValidNode = avNode
+		b.assumeValidPath = []*blockNode{avNode}
+	}
+
+	// Blocks after the assumevalid block and blocks which are not buried
+	// deeply enough under the best known header are always checked.
+	if node.height > b.assumeValidNode.height {
+		return false, nil
+	}
+	tip := b.bestHeaderNode()
+	if tip.height-node.height < b.assumeValidDepth {
+		return false, nil
+	}
+
+	// The block must be an ancestor of the assumevalid block.
+	ancestor, err := b.assumeValidAncestor(node.height)
+	if err != nil || ancestor == nil {
+		return false, err
+	}
+	if !ancestor.hash.IsEqual(node.hash) {
+		return false, nil
+	}
+
+	// The assumevalid block must be part of the best known header chain.
+	if b.assumeValidTip != tip {
+		inTip, err := b.isAncestor(b.assumeValidNode, tip)
+		if err != nil {
+			return false, err
+		}
+		b.assumeValidTip = tip
+		b.assumeValidInTip = inTip
+	}
+	if !b.assumeValidInTip {
+		return false, nil
+	}
+
+	// Release the cached ancestors once the assumevalid block itself has
+	// been reached since there is nothing left to skip.
+	if node.hash.IsEqual(b.assumeValidNode.hash) {
+		b.assumeValidPath = []*blockNode{b.assumeValidNode}
+	}
+
+	return true, nil
+}
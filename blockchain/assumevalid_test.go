@@ -0,0 +1,99 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestAssumeValid ensures script validation is only skipped for blocks which
+// are ancestors of the assumevalid block buried deeply enough under the best
+// known header chain containing it, and never for blocks after the assumevalid
+// block or on side chains.
+func TestAssumeValid(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chainA, err := generateChain(params, 20)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("assumevalid", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Assume the scripts of the ancestors of the block at height 15 are
+	// valid once they are buried at least 3 blocks under the best header.
+	const depth = 3
+	assumeValid := chainA[14]
+	blockchain.TstSetAssumeValid(chain, assumeValid.Sha(), depth)
+
+	ranScripts := make(map[wire.ShaHash]bool)
+	blockchain.TstSetScriptsHook(chain, func(hash *wire.ShaHash, runScripts bool) {
+		ranScripts[*hash] = runScripts
+	})
+	processBlocks := func(blocks []*colxutil.Block) {
+		for _, block := range blocks {
+			_, err := chain.ProcessBlock(block, blockchain.BFNone)
+			if err != nil {
+				t.Fatalf("ProcessBlock: unexpected error: %v", err)
+			}
+		}
+	}
+	assertScripts := func(blocks []*colxutil.Block, want bool) {
+		for _, block := range blocks {
+			got, ok := ranScripts[*block.Sha()]
+			if !ok {
+				t.Fatalf("block %v was not checked", block.Sha())
+			}
+			if got != want {
+				t.Fatalf("block %v: unexpected script validation "+
+					"- got %v, want %v", block.Sha(), got, want)
+			}
+		}
+	}
+
+	// Ensure scripts are validated while the header of the assumevalid
+	// block is not known yet.
+	processBlocks(chainA[:5])
+	assertScripts(chainA[:5], true)
+
+	// Ensure scripts are skipped for the ancestors of the assumevalid block
+	// once its header is known, but not for the blocks after it.
+	headers := make([]*wire.BlockHeader, 0, len(chainA))
+	for _, block := range chainA {
+		headers = append(headers, &block.MsgBlock().Header)
+	}
+	if err := chain.ProcessBlockHeaders(headers); err != nil {
+		t.Fatalf("ProcessBlockHeaders: unexpected error: %v", err)
+	}
+	processBlocks(chainA[5:])
+	assertScripts(chainA[5:15], false)
+	assertScripts(chainA[15:], true)
+
+	// Ensure scripts are validated for the blocks of a side chain which
+	// forks below the assumevalid block when it becomes the main chain.
+	forkHeight := int32(10)
+	forkParent := chainA[forkHeight-1]
+	chainB, err := generateChainFrom(params, &forkParent.MsgBlock().Header,
+		forkHeight, 12, 1)
+	if err != nil {
+		t.Fatalf("unable to generate fork: %v", err)
+	}
+	processBlocks(chainB)
+	best := chain.BestSnapshot()
+	if !best.Hash.IsEqual(chainB[len(chainB)-1].Sha()) {
+		t.Fatalf("unexpected tip - got %v, want %v", best.Hash,
+			chainB[len(chainB)-1].Sha())
+	}
+	assertScripts(chainB, true)
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TxContext houses a transaction of a block along with the values and public
+// key scripts of the previous outputs its inputs spend.  It is created by
+// NewBlockContext and all of its values are precomputed, so the accessors never
+// fail.
+type TxContext struct {
+	tx               *colxutil.Tx
+	index            int
+	inputValue       int64
+	outputValue      int64
+	prevScripts      [][]byte
+	isCoinBase       bool
+	hasMissingInputs bool
+}
+
+// Tx returns the transaction.
+func (c *TxContext) Tx() *colxutil.Tx {
+	return c.tx
+}
+
+// Index returns the index of the transaction within the block.
+func (c *TxContext) Index() int {
+	return c.index
+}
+
+// IsCoinBase returns whether or not the transaction is the coinbase of the
+// block.
+func (c *TxContext) IsCoinBase() bool {
+	return c.isCoinBase
+}
+
+// HasMissingInputs returns whether or not the previous output of any input of
+// the transaction is unknown.  The input value only accounts for the known
+// previous outputs in that case.
+func (c *TxContext) HasMissingInputs() bool {
+	return c.hasMissingInputs
+}
+
+// InputValue returns the total value of the previous outputs spent by the
+// transaction.  It is zero for the coinbase since it does not spend any
+// previous outputs.
+func (c *TxContext) InputValue() int64 {
+	return c.inputValue
+}
+
+// OutputValue returns the total value of the outputs of the transaction.
+func (c *TxContext) OutputValue() int64 {
+	return c.outputValue
+}
+
+// Fee returns the fee paid by the transaction, which is the difference between
+// its input and output values.  It is zero for the coinbase and for
+// transactions with missing inputs since their fee is not known.
+func (c *TxContext) Fee() int64 {
+	if c.isCoinBase || c.hasMissingInputs {
+		return 0
+	}
+	return c.inputValue - c.outputValue
+}
+
+// PrevScript returns the public key script of the previous output spent by the
+// input with the passed index.  It returns nil for the input of the coinbase,
+// for missing previous outputs, and for input indexes which are out of range.
+func (c *TxContext) PrevScript(txInIndex int) []byte {
+	if txInIndex < 0 || txInIndex >= len(c.prevScripts) {
+		return nil
+	}
+	return c.prevScripts[txInIndex]
+}
+
+// BlockContext houses the transactions of a block along with the values and
+// public key scripts of the previous outputs spent by their inputs.  It pairs
+// each input with its previous output once, including outputs created earlier
+// in the same block, so consumers don't need to deal with the utxo view
+// themselves.
+type BlockContext struct {
+	block     *colxutil.Block
+	txns      []TxContext
+	totalFees int64
+}
+
+// Block returns the block.
+func (c *BlockContext) Block() *colxutil.Block {
+	return c.block
+}
+
+// NumTxns returns the number of transactions in the block.
+func (c *BlockContext) NumTxns() int {
+	return len(c.txns)
+}
+
+// Tx returns the context of the transaction with the passed index within the
+// block.  The index must be less than the number of transactions.
+func (c *BlockContext) Tx(txIndex int) *TxContext {
+	return &c.txns[txIndex]
+}
+
+// TotalFees returns the total fees paid by the transactions of the block whose
+// fee is known.
+func (c *BlockContext) TotalFees() int64 {
+	return c.totalFees
+}
+
+// checkAmount returns an error when the passed amount, described by the passed
+// description, is not in the range of valid amounts.
+func checkAmount(amount int64, desc string) error {
+	if amount < 0 || amount > colxutil.MaxSatoshi {
+		str := fmt.Sprintf("%s of %d is outside of the valid range "+
+			"of 0 to %d", desc, amount, int64(colxutil.MaxSatoshi))
+		return ruleError(ErrBadTxOutValue, str)
+	}
+	return nil
+}
+
+// NewBlockContext returns the context of the passed block with the previous
+// outputs spent by its transactions looked up in the passed view.  Outputs
+// created by earlier transactions in the block are taken from the block itself,
+// so the view only needs to contain the outputs which existed before it.
+// Previous outputs which are not available from either are flagged as missing
+// rather than causing an error.
+//
+// An error is returned when any of the values of the outputs or their totals
+// are outside of the range of valid amounts.
+func NewBlockContext(block *colxutil.Block, view *UtxoViewpoint) (*BlockContext, error) {
+	transactions := block.Transactions()
+	blockTxns := make(map[wire.ShaHash]*colxutil.Tx, len(transactions))
+	ctx := &BlockContext{
+		block: block,
+		txns:  make([]TxContext, len(transactions)),
+	}
+	for txIdx, tx := range transactions {
+		msgTx := tx.MsgTx()
+		txCtx := &ctx.txns[txIdx]
+		txCtx.tx = tx
+		txCtx.index = txIdx
+		txCtx.isCoinBase = txIdx == 0 && IsCoinBase(tx)
+
+		for _, txOut := range msgTx.TxOut {
+			if err := checkAmount(txOut.Value, "output value"); err != nil {
+				return nil, err
+			}
+			txCtx.outputValue += txOut.Value
+			err := checkAmount(txCtx.outputValue, "total output value")
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// The coinbase does not spend any previous outputs.
+		if txCtx.isCoinBase {
+			blockTxns[*tx.Sha()] = tx
+			continue
+		}
+
+		txCtx.prevScripts = make([][]byte, len(msgTx.TxIn))
+		for txInIdx, txIn := range msgTx.TxIn {
+			prevOut := &txIn.PreviousOutPoint
+			var amount int64
+			var pkScript []byte
+			if prevTx, ok := blockTxns[prevOut.Hash]; ok {
+				prevTxOuts := prevTx.MsgTx().TxOut
+				if prevOut.Index >= uint32(len(prevTxOuts)) {
+					txCtx.hasMissingInputs = true
+					continue
+				}
+				amount = prevTxOuts[prevOut.Index].Value
+				pkScript = prevTxOuts[prevOut.Index].PkScript
+			} else {
+				var entry *UtxoEntry
+				if view != nil {
+					entry = view.LookupEntry(&prevOut.Hash)
+				}
+				if entry == nil || entry.output(prevOut.Index) == nil {
+					txCtx.hasMissingInputs = true
+					continue
+				}
+				amount = entry.AmountByIndex(prevOut.Index)
+				pkScript = entry.PkScriptByIndex(prevOut.Index)
+			}
+
+			if err := checkAmount(amount, "input value"); err != nil {
+				return nil, err
+			}
+			txCtx.inputValue += amount
+			err := checkAmount(txCtx.inputValue, "total input value")
+			if err != nil {
+				return nil, err
+			}
+			txCtx.prevScripts[txInIdx] = pkScript
+		}
+
+		ctx.totalFees += txCtx.Fee()
+		blockTxns[*tx.Sha()] = tx
+	}
+
+	return ctx, nil
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestBlockContext ensures the context of a block pairs the inputs of its
+// transactions with the previous outputs they spend, including outputs created
+// earlier in the same block, and computes the expected values and fees.
+func TestBlockContext(t *testing.T) {
+	scriptA := []byte{txscript.OP_TRUE}
+	scriptB := []byte{txscript.OP_TRUE, txscript.OP_TRUE}
+	scriptC := []byte{txscript.OP_2}
+
+	// Create a view with two outputs which existed before the block.
+	fundingTx := wire.NewMsgTx()
+	fundingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil))
+	fundingTx.AddTxOut(wire.NewTxOut(5000, scriptA))
+	fundingTx.AddTxOut(wire.NewTxOut(3000, scriptB))
+	funding := colxutil.NewTx(fundingTx)
+	view := blockchain.NewUtxoViewpoint()
+	view.AddTxOuts(funding, 1)
+
+	// Create a block with a coinbase, a transaction which spends both
+	// funding outputs, a transaction which spends an output of the
+	// previous transaction, and a transaction with an unknown input.
+	coinbaseTx := wire.NewMsgTx()
+	coinbaseTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&wire.ShaHash{},
+		math.MaxUint32), []byte{0x51, 0x51}))
+	coinbaseTx.AddTxOut(wire.NewTxOut(6000, scriptA))
+	parentTx := wire.NewMsgTx()
+	parentTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(funding.Sha(), 0), nil))
+	parentTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(funding.Sha(), 1), nil))
+	parentTx.AddTxOut(wire.NewTxOut(4000, scriptC))
+	parentTx.AddTxOut(wire.NewTxOut(3500, scriptA))
+	parentHash := parentTx.TxSha()
+	childTx := wire.NewMsgTx()
+	childTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&parentHash, 0), nil))
+	childTx.AddTxOut(wire.NewTxOut(3900, scriptB))
+	orphanTx := wire.NewMsgTx()
+	orphanTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&parentHash, 5), nil))
+	orphanTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(funding.Sha(), 1), nil))
+	orphanTx.AddTxOut(wire.NewTxOut(100, scriptA))
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{})
+	for _, tx := range []*wire.MsgTx{coinbaseTx, parentTx, childTx, orphanTx} {
+		msgBlock.AddTransaction(tx)
+	}
+	block := colxutil.NewBlock(msgBlock)
+
+	blockCtx, err := blockchain.NewBlockContext(block, view)
+	if err != nil {
+		t.Fatalf("NewBlockContext: unexpected error: %v", err)
+	}
+	if blockCtx.Block() != block || blockCtx.NumTxns() != 4 {
+		t.Fatalf("unexpected block context for %d transactions",
+			blockCtx.NumTxns())
+	}
+
+	tests := []struct {
+		name        string
+		coinbase    bool
+		missing     bool
+		inputValue  int64
+		outputValue int64
+		fee         int64
+		prevScripts [][]byte
+	}{
+		{
+			name:        "coinbase",
+			coinbase:    true,
+			outputValue: 6000,
+			prevScripts: [][]byte{nil},
+		},
+		{
+			name:        "spends outputs before the block",
+			inputValue:  8000,
+			outputValue: 7500,
+			fee:         500,
+			prevScripts: [][]byte{scriptA, scriptB},
+		},
+		{
+			name:        "spends output created in the block",
+			inputValue:  4000,
+			outputValue: 3900,
+			fee:         100,
+			prevScripts: [][]byte{scriptC},
+		},
+		{
+			name:        "missing input",
+			missing:     true,
+			inputValue:  3000,
+			outputValue: 100,
+			prevScripts: [][]byte{nil, scriptB},
+		},
+	}
+	for i, test := range tests {
+		txCtx := blockCtx.Tx(i)
+		if txCtx.Tx() != block.Transactions()[i] || txCtx.Index() != i {
+			t.Errorf("%s: unexpected transaction", test.name)
+		}
+		if txCtx.IsCoinBase() != test.coinbase {
+			t.Errorf("%s: unexpected coinbase flag - got %v, want %v",
+				test.name, txCtx.IsCoinBase(), test.coinbase)
+		}
+		if txCtx.HasMissingInputs() != test.missing {
+			t.Errorf("%s: unexpected missing inputs flag - got %v, "+
+				"want %v", test.name, txCtx.HasMissingInputs(),
+				test.missing)
+		}
+		if txCtx.InputValue() != test.inputValue {
+			t.Errorf("%s: unexpected input value - got %d, want %d",
+				test.name, txCtx.InputValue(), test.inputValue)
+		}
+		if txCtx.OutputValue() != test.outputValue {
+			t.Errorf("%s: unexpected output value - got %d, want %d",
+				test.name, txCtx.OutputValue(), test.outputValue)
+		}
+		if txCtx.Fee() != test.fee {
+			t.Errorf("%s: unexpected fee - got %d, want %d",
+				test.name, txCtx.Fee(), test.fee)
+		}
+		for txInIdx, want := range test.prevScripts {
+			got := txCtx.PrevScript(txInIdx)
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: unexpected previous script for "+
+					"input %d - got %x, want %x", test.name,
+					txInIdx, got, want)
+			}
+		}
+		if txCtx.PrevScript(len(test.prevScripts)) != nil {
+			t.Errorf("%s: previous script for input out of range",
+				test.name)
+		}
+	}
+	if fees := blockCtx.TotalFees(); fees != 600 {
+		t.Errorf("unexpected total fees - got %d, want 600", fees)
+	}
+
+	// Ensure output values outside of the valid range are rejected.
+	childTx.TxOut[0].Value = colxutil.MaxSatoshi + 1
+	_, err = blockchain.NewBlockContext(colxutil.NewBlock(msgBlock), view)
+	if rerr, ok := err.(blockchain.RuleError); !ok ||
+		rerr.ErrorCode != blockchain.ErrBadTxOutValue {
+
+		t.Fatalf("NewBlockContext: unexpected error - got %v, want %v",
+			err, blockchain.ErrBadTxOutValue)
+	}
+}
@@ -0,0 +1,62 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// CacheInvalidator is invoked when the main chain is reorganized so a cache of
+// data derived from the main chain can drop or update the entries which are
+// affected by it.  It is passed the blocks being disconnected, in order from
+// the current tip back to the fork point, and the blocks being connected, in
+// order from the fork point to the new tip.
+//
+// Caches whose entries are keyed by everything they were derived from, such as
+// the signature cache, which is keyed by the signature, public key, and
+// signature hash, and the deployment threshold state caches, which are keyed by
+// block hash, remain valid across reorganizes and do not need to register an
+// invalidator.
+type CacheInvalidator func(detached, attached []*colxutil.Block)
+
+// namedCacheInvalidator houses a registered cache invalidator along with the
+// name of the cache it invalidates for logging purposes.
+type namedCacheInvalidator struct {
+	name       string
+	invalidate CacheInvalidator
+}
+
+// RegisterCacheInvalidator registers the passed function to be invoked for
+// every reorganize of the main chain.  Invalidators are invoked in the order
+// they were registered once the reorganize is known to be valid, but before
+// the chain is modified and before any notifications about the reorganize or
+// the blocks it disconnects and connects are sent.  That way, no caller can
+// observe a stale entry once it learns about the reorganize.
+//
+// Invalidators are invoked with the chain lock held, so they MUST NOT call
+// back into the chain.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) RegisterCacheInvalidator(name string, invalidator CacheInvalidator) {
+	b.chainLock.Lock()
+	b.cacheInvalidators = append(b.cacheInvalidators,
+		namedCacheInvalidator{name: name, invalidate: invalidator})
+	b.chainLock.Unlock()
+}
+
+// invalidateCaches invokes all registered cache invalidators with the passed
+// blocks which are about to be disconnected from and connected to the main
+// chain.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) invalidateCaches(detached, attached []*colxutil.Block) {
+	for _, inv := range b.cacheInvalidators {
+		log.Debugf("Invalidating %s for reorganize which disconnects %d "+
+			"and connects %d blocks", inv.name, len(detached),
+			len(attached))
+		inv.invalidate(detached, attached)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestCacheInvalidator ensures registered cache invalidators are invoked once
+// for every reorganize with the detached and attached blocks in the order they
+// are disconnected and connected, before the reorganize notification is sent,
+// and that they are not invoked for blocks which extend the main chain or for
+// reorganizes which are only checked with the dry run flag.
+func TestCacheInvalidator(t *testing.T) {
+	// Create a main chain along with a fork from it which has more work
+	// and a fork from the main chain again which has more work than that.
+	//
+	//   genesis -> a1 -> a2 -> a3 -> a4 -> a5 -> a6 -> a7 -> a8
+	//                           \                \-> c7 -> ... -> c11
+	//                            \-> b4 -> ... -> b10
+	params := &chaincfg.RegressionNetParams
+	chainA, err := generateChain(params, 8)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+	chainB, err := generateChainFrom(params, &chainA[2].MsgBlock().Header,
+		3, 7, 1)
+	if err != nil {
+		t.Fatalf("unable to generate fork: %v", err)
+	}
+	chainC, err := generateChainFrom(params, &chainA[5].MsgBlock().Header,
+		6, 5, 2)
+	if err != nil {
+		t.Fatalf("unable to generate fork: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("cacheinvalidator", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Record the invalidations along with the reorganize notifications in
+	// a single log so their relative order is known.
+	type event struct {
+		invalidate bool
+		detached   []*wire.ShaHash
+		attached   []*wire.ShaHash
+	}
+	var events []event
+	blockHashes := func(blocks []*colxutil.Block) []*wire.ShaHash {
+		hashes := make([]*wire.ShaHash, 0, len(blocks))
+		for _, block := range blocks {
+			hashes = append(hashes, block.Sha())
+		}
+		return hashes
+	}
+	chain.RegisterCacheInvalidator("test",
+		func(detached, attached []*colxutil.Block) {
+			events = append(events, event{
+				invalidate: true,
+				detached:   blockHashes(detached),
+				attached:   blockHashes(attached),
+			})
+		})
+	blockchain.TstSetNotifications(chain, func(n *blockchain.Notification) {
+		if n.Type == blockchain.NTChainReorg {
+			reorg := n.Data.(*blockchain.ReorgData)
+			events = append(events, event{
+				detached: reorg.Detached,
+				attached: reorg.Attached,
+			})
+		}
+	})
+
+	process := func(blocks []*colxutil.Block, flags blockchain.BehaviorFlags) {
+		for _, block := range blocks {
+			_, err := chain.ProcessBlock(block, flags)
+			if err != nil {
+				t.Fatalf("ProcessBlock: unexpected error: %v", err)
+			}
+		}
+	}
+	checkHashes := func(name string, got, want []*wire.ShaHash) {
+		if len(got) != len(want) {
+			t.Fatalf("unexpected number of %s blocks - got %d, "+
+				"want %d", name, len(got), len(want))
+		}
+		for i := range want {
+			if !got[i].IsEqual(want[i]) {
+				t.Fatalf("unexpected %s block #%d - got %v, "+
+					"want %v", name, i, got[i], want[i])
+			}
+		}
+	}
+	reversed := func(blocks []*colxutil.Block) []*wire.ShaHash {
+		hashes := blockHashes(blocks)
+		for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+			hashes[i], hashes[j] = hashes[j], hashes[i]
+		}
+		return hashes
+	}
+	checkReorg := func(wantDetached, wantAttached []*wire.ShaHash) {
+		if len(events) != 2 {
+			t.Fatalf("unexpected number of events - got %d, want 2",
+				len(events))
+		}
+		if !events[0].invalidate || events[1].invalidate {
+			t.Fatal("caches were not invalidated before the " +
+				"reorganize notification")
+		}
+		for _, e := range events {
+			checkHashes("detached", e.detached, wantDetached)
+			checkHashes("attached", e.attached, wantAttached)
+		}
+		events = nil
+	}
+
+	// Extending the main chain does not invalidate anything.
+	process(chainA, blockchain.BFNone)
+	if len(events) != 0 {
+		t.Fatalf("unexpected events when extending the main chain: %d",
+			len(events))
+	}
+
+	// Checking the block which would cause a reorganize to the first fork
+	// with the dry run flag does not invalidate anything either.
+	process(chainB[:len(chainB)-2], blockchain.BFNone)
+	process(chainB[len(chainB)-2:len(chainB)-1], blockchain.BFDryRun)
+	if len(events) != 0 {
+		t.Fatalf("unexpected events for dry run: %d", len(events))
+	}
+
+	// Reorganize to the first fork and then to the second one, which
+	// detaches the whole first fork and reattaches part of the original
+	// main chain.
+	process(chainB[len(chainB)-2:len(chainB)-1], blockchain.BFNone)
+	checkReorg(reversed(chainA[3:]), blockHashes(chainB[:len(chainB)-1]))
+	process(chainB[len(chainB)-1:], blockchain.BFNone)
+	if len(events) != 0 {
+		t.Fatalf("unexpected events when extending the main chain: %d",
+			len(events))
+	}
+	process(chainC, blockchain.BFNone)
+	checkReorg(reversed(chainB), append(blockHashes(chainA[3:6]),
+		blockHashes(chainC)...))
+
+	best := chain.BestSnapshot()
+	if !best.Hash.IsEqual(chainC[len(chainC)-1].Sha()) {
+		t.Fatalf("unexpected best block %v", best.Hash)
+	}
+}
@@ -7,6 +7,8 @@ package blockchain
 
 import (
 	"container/list"
+	"context"
+	"errors"
 	"fmt"
 	"math/big"
 	"sort"
@@ -21,10 +23,20 @@ import (
 )
 
 const (
-	// maxOrphanBlocks is the maximum number of orphan blocks that can be
-	// queued.
-	maxOrphanBlocks = 100
+	// DefaultMaxOrphanBlocks is the default maximum number of orphan blocks
+	// that can be queued.
+	DefaultMaxOrphanBlocks = 100
 
+	// DefaultMaxOrphanBytes is the default maximum combined serialized size
+	// of the orphan blocks that can be queued.
+	DefaultMaxOrphanBytes = 20 * wire.MaxBlockPayload
+
+	// DefaultMaxOrphanAge is the default amount of time orphan blocks are
+	// queued before they expire.
+	DefaultMaxOrphanAge = time.Hour
+)
+
+const (
 	// minMemoryNodes is the minimum number of consecutive nodes needed
 	// in memory in order to perform all necessary validation.  It is used
 	// to determine when it's safe to prune nodes from memory without
@@ -69,6 +81,21 @@ type blockNode struct {
 	version   int32
 	bits      uint32
 	timestamp time.Time
+
+	// preciousSeq is used to prefer the node over other nodes with the
+	// same cumulative work.  It is set when the node is marked precious
+	// and nodes which were marked more recently have higher values.  It is
+	// zero for nodes which were never marked and is not persisted.
+	preciousSeq int32
+
+	// timeWindow holds the unix timestamps of the block and up to
+	// medianTimeBlocks-1 blocks prior to it, oldest first, and medianTime
+	// is the median of them.  They are calculated once, typically when
+	// the node is connected to its parent, and are nil and zero until
+	// then.  Since the ancestors of a block never change, they remain
+	// valid across chain reorganizations.
+	timeWindow []int64
+	medianTime time.Time
 }
 
 // newBlockNode returns a new block node for the given block header.  It is
@@ -97,6 +124,7 @@ func newBlockNode(blockHeader *wire.BlockHeader, blockSha *wire.ShaHash, height
 // forever.
 type orphanBlock struct {
 	block      *colxutil.Block
+	size       int
 	expiration time.Time
 }
 
@@ -162,6 +190,7 @@ type BlockChain struct {
 	// The following fields are set when the instance is created and can't
 	// be changed afterwards, so there is no need to protect them with a
 	// separate mutex.
+	checkpoints         []chaincfg.Checkpoint
 	checkpointsByHeight map[int32]*chaincfg.Checkpoint
 	db                  database.DB
 	chainParams         *chaincfg.Params
@@ -169,6 +198,28 @@ type BlockChain struct {
 	notifications       NotificationCallback
 	sigCache            *txscript.SigCache
 	indexManager        IndexManager
+	auditUtxoDeltas     bool
+
+	// These fields track blocks which are currently being processed so
+	// concurrent submissions of the same block wait for and share the
+	// result of the first one instead of validating it again.  They are
+	// protected by the in-flight lock.
+	inFlightLock sync.Mutex
+	inFlight     map[inFlightKey]*inFlightBlock
+
+	// validateHook is invoked with the hash of each block that undergoes
+	// full validation.  It is only set by tests.
+	validateHook func(*wire.ShaHash)
+
+	// scriptsHook is invoked with the hash of each block whose transactions
+	// are checked as part of connecting it along with whether or not its
+	// scripts are validated.  It is only set by tests.
+	scriptsHook func(*wire.ShaHash, bool)
+
+	// auditHook is invoked with the hash of each block and the utxo view
+	// it is being connected or disconnected with before the utxo delta
+	// audit is performed.  It is only set by tests.
+	auditHook func(*wire.ShaHash, *UtxoViewpoint)
 
 	// chainLock protects concurrent access to the vast majority of the
 	// fields in this struct below this point.
@@ -179,6 +230,31 @@ type BlockChain struct {
 	noVerify      bool
 	noCheckpoints bool
 
+	// preciousSeq is the sequence number assigned to the block most
+	// recently marked precious.  It is protected by the chain lock.
+	preciousSeq int32
+
+	// These fields are related to pruning the data for old blocks.  The
+	// prune target is in bytes and is zero when pruning is disabled, while
+	// the prune state is nil when the chain has never been pruned.  They
+	// are protected by the chain lock.
+	pruneTarget uint64
+	pruneDepth  int32
+	pruneState  *pruneState
+
+	// These fields are related to storing the blocks which failed the
+	// checks performed when connecting them.  The blocks themselves are
+	// only kept in the database, while the details needed to reject them
+	// and their descendants are kept in memory.  The entries are evicted
+	// in the order of their sequence numbers once one of the limits is
+	// exceeded.  They are protected by the chain lock.
+	storeInvalid     bool
+	invalidBlocks    map[wire.ShaHash]*invalidBlock
+	invalidBytes     int
+	invalidSeq       uint64
+	maxInvalidBlocks int
+	maxInvalidBytes  int
+
 	// These fields are related to the memory block index.  They are
 	// protected by the chain lock.
 	bestNode *blockNode
@@ -187,17 +263,62 @@ type BlockChain struct {
 
 	// These fields are related to handling of orphan blocks.  They are
 	// protected by a combination of the chain lock and the orphan lock.
-	orphanLock   sync.RWMutex
-	orphans      map[wire.ShaHash]*orphanBlock
-	prevOrphans  map[wire.ShaHash][]*orphanBlock
-	oldestOrphan *orphanBlock
-	blockCache   map[wire.ShaHash]*colxutil.Block
+	// The orphans are ordered by the time they were added, starting with
+	// the oldest, so they are evicted in that order once one of the limits
+	// is exceeded.
+	orphanLock      sync.RWMutex
+	orphans         map[wire.ShaHash]*orphanBlock
+	prevOrphans     map[wire.ShaHash][]*orphanBlock
+	orphanOrder     []*orphanBlock
+	orphanBytes     int
+	maxOrphanBlocks int
+	maxOrphanBytes  int
+	maxOrphanAge    time.Duration
+	blockCache      map[wire.ShaHash]*colxutil.Block
+
+	// These fields are related to handling of blocks which are held until
+	// their timestamp is no longer too far in the future.  They are
+	// protected by the future block lock.  The schedule function is used
+	// to release the held blocks at that time.
+	futureLock     sync.Mutex
+	futureBlocks   map[wire.ShaHash]*futureBlock
+	futureBytes    int
+	futureStopped  bool
+	futureSchedule func(time.Duration, func()) futureBlockTimer
+
+	// deploymentCaches caches the current deployment threshold state for
+	// blocks in each of the actively defined deployments.  It is protected
+	// by the chain lock.
+	deploymentCaches map[uint32]*thresholdStateCache
+
+	// cacheInvalidators houses the functions which are invoked to
+	// invalidate caches of data derived from the main chain when it is
+	// reorganized.  It is protected by the chain lock.
+	cacheInvalidators []namedCacheInvalidator
+
+	// These fields are related to handling of block headers which were
+	// validated ahead of their blocks.  Entries are removed from the
+	// header index once their block is connected.  They are protected by
+	// the chain lock.
+	headerIndex map[wire.ShaHash]*blockNode
+	bestHeader  *blockNode
 
 	// These fields are related to checkpoint handling.  They are protected
 	// by the chain lock.
 	nextCheckpoint  *chaincfg.Checkpoint
 	checkpointBlock *colxutil.Block
 
+	// These fields are related to skipping script validation for the
+	// ancestors of the assumevalid block.  The assumevalid hash is nil
+	// when disabled.  The remaining fields cache the ancestry of the
+	// assumevalid block.  They are protected by the chain lock.
+	assumeValid      *wire.ShaHash
+	assumeValidDepth int32
+	assumeValidNode  *blockNode
+	assumeValidPath  []*blockNode
+	assumeValidTip   *blockNode
+	assumeValidInTip bool
+
 	// The state is used as a fairly efficient way to cache information
 	// about the current best chain state that is returned to callers when
 	// requested.  It operates on the principle of MVCC such that any time a
@@ -228,7 +349,8 @@ func (b *BlockChain) DisableVerify(disable bool) {
 
 // HaveBlock returns whether or not the chain instance has the block represented
 // by the passed hash.  This includes checking the various places a block can
-// be like part of the main chain, on a side chain, or in the orphan pool.
+// be like part of the main chain, on a side chain, in the orphan pool, or held
+// until its timestamp is no longer too far in the future.
 //
 // This function is safe for concurrent access.
 func (b *BlockChain) HaveBlock(hash *wire.ShaHash) (bool, error) {
@@ -239,7 +361,8 @@ func (b *BlockChain) HaveBlock(hash *wire.ShaHash) (bool, error) {
 	if err != nil {
 		return false, err
 	}
-	return exists || b.IsKnownOrphan(hash), nil
+	return exists || b.IsKnownOrphan(hash) || b.IsKnownFutureBlock(hash),
+		nil
 }
 
 // IsKnownOrphan returns whether the passed hash is currently a known orphan.
@@ -270,6 +393,19 @@ func (b *BlockChain) IsKnownOrphan(hash *wire.ShaHash) bool {
 //
 // This function is safe for concurrent access.
 func (b *BlockChain) GetOrphanRoot(hash *wire.ShaHash) *wire.ShaHash {
+	if orphanRoot, ok := b.OrphanRoot(hash); ok {
+		return orphanRoot
+	}
+	return hash
+}
+
+// OrphanRoot returns the hash of the first block of the chain of orphan blocks
+// which ends with the block with the passed hash, along with whether or not the
+// block is a known orphan.  The parent of the returned block is the missing
+// block which prevents the chain of orphans from being processed.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) OrphanRoot(hash *wire.ShaHash) (*wire.ShaHash, bool) {
 	// Protect concurrent access.  Using a read lock only so multiple
 	// readers can query without blocking each other.
 	b.orphanLock.RLock()
@@ -277,7 +413,7 @@ func (b *BlockChain) GetOrphanRoot(hash *wire.ShaHash) *wire.ShaHash {
 
 	// Keep looping while the parent of each orphaned block is
 	// known and is an orphan itself.
-	orphanRoot := hash
+	var orphanRoot *wire.ShaHash
 	prevHash := hash
 	for {
 		orphan, exists := b.orphans[*prevHash]
@@ -288,7 +424,7 @@ func (b *BlockChain) GetOrphanRoot(hash *wire.ShaHash) *wire.ShaHash {
 		prevHash = &orphan.block.MsgBlock().Header.PrevBlock
 	}
 
-	return orphanRoot
+	return orphanRoot, orphanRoot != nil
 }
 
 // removeOrphanBlock removes the passed orphan block from the orphan pool and
@@ -301,6 +437,15 @@ func (b *BlockChain) removeOrphanBlock(orphan *orphanBlock) {
 	// Remove the orphan block from the orphan pool.
 	orphanHash := orphan.block.Sha()
 	delete(b.orphans, *orphanHash)
+	b.orphanBytes -= orphan.size
+	for i, o := range b.orphanOrder {
+		if o == orphan {
+			copy(b.orphanOrder[i:], b.orphanOrder[i+1:])
+			b.orphanOrder[len(b.orphanOrder)-1] = nil
+			b.orphanOrder = b.orphanOrder[:len(b.orphanOrder)-1]
+			break
+		}
+	}
 
 	// Remove the reference from the previous orphan index too.  An indexing
 	// for loop is intentionally used over a range here as range does not
@@ -326,54 +471,77 @@ func (b *BlockChain) removeOrphanBlock(orphan *orphanBlock) {
 	}
 }
 
+// evictOrphanBlock removes the passed orphan block from the orphan pool for the
+// passed reason and sends a notification about it.
+func (b *BlockChain) evictOrphanBlock(orphan *orphanBlock, reason OrphanEvictReason) {
+	b.removeOrphanBlock(orphan)
+	log.Debugf("Evicted orphan block %v (%v)", orphan.block.Sha(), reason)
+	b.sendNotification(NTOrphanEvicted, &OrphanEviction{
+		Block:  orphan.block,
+		Reason: reason,
+	})
+}
+
 // addOrphanBlock adds the passed block (which is already determined to be
 // an orphan prior calling this function) to the orphan pool.  It lazily cleans
 // up any expired blocks so a separate cleanup poller doesn't need to be run.
-// It also imposes a maximum limit on the number of outstanding orphan
-// blocks and will remove the oldest received orphan block if the limit is
-// exceeded.
+// It also imposes a maximum limit on the number and the combined size of the
+// outstanding orphan blocks and will remove the oldest received orphan blocks
+// while a limit is exceeded.  A notification is sent for every orphan block
+// which is removed, which includes the passed block when it is larger than the
+// size limit by itself.
 func (b *BlockChain) addOrphanBlock(block *colxutil.Block) {
-	// Remove expired orphan blocks.
-	for _, oBlock := range b.orphans {
-		if time.Now().After(oBlock.expiration) {
-			b.removeOrphanBlock(oBlock)
-			continue
-		}
-
-		// Update the oldest orphan block pointer so it can be discarded
-		// in case the orphan pool fills up.
-		if b.oldestOrphan == nil || oBlock.expiration.Before(b.oldestOrphan.expiration) {
-			b.oldestOrphan = oBlock
-		}
+	// Remove expired orphan blocks.  They expire in the order they were
+	// added.
+	now := time.Now()
+	for len(b.orphanOrder) > 0 && now.After(b.orphanOrder[0].expiration) {
+		b.evictOrphanBlock(b.orphanOrder[0], OrphanEvictExpired)
+	}
+
+	// Don't queue the block at all when it is larger than the size limit
+	// by itself.
+	size := block.MsgBlock().SerializeSize()
+	if size > b.maxOrphanBytes {
+		log.Debugf("Evicted orphan block %v (%v)", block.Sha(),
+			OrphanEvictMaxBytes)
+		b.sendNotification(NTOrphanEvicted, &OrphanEviction{
+			Block:  block,
+			Reason: OrphanEvictMaxBytes,
+		})
+		return
 	}
 
-	// Limit orphan blocks to prevent memory exhaustion.
-	if len(b.orphans)+1 > maxOrphanBlocks {
-		// Remove the oldest orphan to make room for the new one.
-		b.removeOrphanBlock(b.oldestOrphan)
-		b.oldestOrphan = nil
+	// Limit orphan blocks to prevent memory exhaustion by removing the
+	// oldest orphans to make room for the new one.
+	for len(b.orphanOrder) > 0 {
+		reason := OrphanEvictMaxBlocks
+		if len(b.orphans)+1 <= b.maxOrphanBlocks {
+			if b.orphanBytes+size <= b.maxOrphanBytes {
+				break
+			}
+			reason = OrphanEvictMaxBytes
+		}
+		b.evictOrphanBlock(b.orphanOrder[0], reason)
 	}
 
 	// Protect concurrent access.  This is intentionally done here instead
-	// of near the top since removeOrphanBlock does its own locking and
-	// the range iterator is not invalidated by removing map entries.
+	// of near the top since removeOrphanBlock does its own locking.
 	b.orphanLock.Lock()
 	defer b.orphanLock.Unlock()
 
-	// Insert the block into the orphan map with an expiration time
-	// 1 hour from now.
-	expiration := time.Now().Add(time.Hour)
+	// Insert the block into the orphan map along with its expiration time.
 	oBlock := &orphanBlock{
 		block:      block,
-		expiration: expiration,
+		size:       size,
+		expiration: now.Add(b.maxOrphanAge),
 	}
 	b.orphans[*block.Sha()] = oBlock
+	b.orphanOrder = append(b.orphanOrder, oBlock)
+	b.orphanBytes += size
 
 	// Add to previous hash lookup index for faster dependency lookups.
 	prevHash := &block.MsgBlock().Header.PrevBlock
 	b.prevOrphans[*prevHash] = append(b.prevOrphans[*prevHash], oBlock)
-
-	return
 }
 
 // loadBlockNode loads the block identified by hash from the block database,
@@ -615,25 +783,34 @@ func (b *BlockChain) isMajorityVersion(minVer int32, startNode *blockNode, numRe
 	return numFound >= numRequired
 }
 
-// calcPastMedianTime calculates the median time of the previous few blocks
-// prior to, and including, the passed block node.  It is primarily used to
-// validate new blocks have sane timestamps.
+// calcTimeWindow returns the unix timestamps of the passed block node and up to
+// medianTimeBlocks-1 blocks prior to it, oldest first, and caches them on the
+// node.  When the parent of the node already has its window cached, the window
+// is derived from it by dropping its oldest timestamp as needed and appending
+// the timestamp of the node.  Otherwise, the previous nodes are walked.
 //
 // This function MUST be called with the chain state lock held (for writes).
-func (b *BlockChain) calcPastMedianTime(startNode *blockNode) (time.Time, error) {
-	// Genesis block.
-	if startNode == nil {
-		return b.chainParams.GenesisBlock.Header.Timestamp, nil
+func (b *BlockChain) calcTimeWindow(node *blockNode) ([]int64, error) {
+	if node.timeWindow != nil {
+		return node.timeWindow, nil
 	}
 
-	// Create a slice of the previous few block timestamps used to calculate
-	// the median per the number defined by the constant medianTimeBlocks.
-	timestamps := make([]time.Time, medianTimeBlocks)
-	numNodes := 0
-	iterNode := startNode
+	window := make([]int64, 0, medianTimeBlocks)
+	if parent := node.parent; parent != nil && parent.timeWindow != nil {
+		parentWindow := parent.timeWindow
+		if len(parentWindow) == medianTimeBlocks {
+			parentWindow = parentWindow[1:]
+		}
+		window = append(window, parentWindow...)
+		window = append(window, node.timestamp.Unix())
+		node.timeWindow = window
+		return window, nil
+	}
+
+	// Collect the timestamps newest first and reverse them afterwards.
+	iterNode := node
 	for i := 0; i < medianTimeBlocks && iterNode != nil; i++ {
-		timestamps[i] = iterNode.timestamp
-		numNodes++
+		window = append(window, iterNode.timestamp.Unix())
 
 		// Get the previous block node.  This function is used over
 		// simply accessing iterNode.parent directly as it will
@@ -644,15 +821,42 @@ func (b *BlockChain) calcPastMedianTime(startNode *blockNode) (time.Time, error)
 		iterNode, err = b.getPrevNodeFromNode(iterNode)
 		if err != nil {
 			log.Errorf("getPrevNodeFromNode: %v", err)
-			return time.Time{}, err
+			return nil, err
 		}
 	}
+	for i, j := 0, len(window)-1; i < j; i, j = i+1, j-1 {
+		window[i], window[j] = window[j], window[i]
+	}
+	node.timeWindow = window
+	return window, nil
+}
 
-	// Prune the slice to the actual number of available timestamps which
-	// will be fewer than desired near the beginning of the block chain
-	// and sort them.
-	timestamps = timestamps[:numNodes]
-	sort.Sort(timeSorter(timestamps))
+// calcPastMedianTime calculates the median time of the previous few blocks
+// prior to, and including, the passed block node.  It is primarily used to
+// validate new blocks have sane timestamps.  The result is cached on the node,
+// so every time-based consensus check which refers to the same block uses the
+// same value without walking its ancestors again.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) calcPastMedianTime(startNode *blockNode) (time.Time, error) {
+	// Genesis block.
+	if startNode == nil {
+		return b.chainParams.GenesisBlock.Header.Timestamp, nil
+	}
+	if !startNode.medianTime.IsZero() {
+		return startNode.medianTime, nil
+	}
+
+	// Sort a copy of the timestamps of the block and the blocks prior to it
+	// per the number defined by the constant medianTimeBlocks.  There will
+	// be fewer near the beginning of the block chain.
+	window, err := b.calcTimeWindow(startNode)
+	if err != nil {
+		return time.Time{}, err
+	}
+	timestamps := make([]int64, len(window))
+	copy(timestamps, window)
+	sort.Sort(int64Sorter(timestamps))
 
 	// NOTE: bitcoind incorrectly calculates the median for even numbers of
 	// blocks.  A true median averages the middle two elements for a set
@@ -666,8 +870,8 @@ func (b *BlockChain) calcPastMedianTime(startNode *blockNode) (time.Time, error)
 	// This code follows suit to ensure the same rules are used as bitcoind
 	// however, be aware that should the medianTimeBlocks constant ever be
 	// changed to an even number, this code will be wrong.
-	medianTimestamp := timestamps[numNodes/2]
-	return medianTimestamp, nil
+	startNode.medianTime = time.Unix(timestamps[len(timestamps)/2], 0)
+	return startNode.medianTime, nil
 }
 
 // CalcPastMedianTime calculates the median time of the previous few blocks
@@ -682,6 +886,48 @@ func (b *BlockChain) CalcPastMedianTime() (time.Time, error) {
 	return b.calcPastMedianTime(b.bestNode)
 }
 
+// MedianTimeByHash returns the median time of the previous few blocks prior
+// to, and including, the block with the passed hash, which may be in the main
+// chain or a side chain.  This is the time the time based lock times of the
+// transactions in the blocks which build on the block are compared against
+// once BIP0113 applies.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) MedianTimeByHash(hash *wire.ShaHash) (time.Time, error) {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	node, err := b.headerInfoNode(hash)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if node == nil {
+		return time.Time{}, fmt.Errorf("block %v is not known", hash)
+	}
+	return b.calcPastMedianTime(node)
+}
+
+// BlockPastMedianTime calculates the median time of the previous few blocks
+// prior to, and including, the block with the passed hash, which may be any
+// block in the main chain or a side chain as well as any validated header.
+// Once BIP0113 applies, the transactions in a block which extends the passed
+// block must be finalized as of this time.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) BlockPastMedianTime(hash *wire.ShaHash) (time.Time, error) {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	node, err := b.lookupHeaderNode(hash)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if node == nil {
+		return time.Time{}, fmt.Errorf("block %v is not known", hash)
+	}
+	return b.calcPastMedianTime(node)
+}
+
 // getReorganizeNodes finds the fork point between the main chain and the passed
 // node and returns a list of block nodes that would need to be detached from
 // the main chain and a list of block nodes that would need to be attached to
@@ -769,6 +1015,15 @@ func (b *BlockChain) connectBlock(node *blockNode, block *colxutil.Block, view *
 			"spent transaction out information")
 	}
 
+	// Cross-check the changes applied to the utxo view against those the
+	// block makes before anything is committed when auditing is enabled.
+	if b.auditUtxoDeltas {
+		err := b.auditConnectDeltas(node, block, view, stxos)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Generate a new best state snapshot that will be used to update the
 	// database and later memory if all database updates are successful.
 	b.stateLock.RLock()
@@ -779,6 +1034,7 @@ func (b *BlockChain) connectBlock(node *blockNode, block *colxutil.Block, view *
 	state := newBestState(node, blockSize, numTxns, curTotalTxns+numTxns)
 
 	// Atomically insert info into the database.
+	var newPruneState *pruneState
 	err := b.db.Update(func(dbTx database.Tx) error {
 		// Update best block state.
 		err := dbPutBestState(dbTx, state, node.workSum)
@@ -814,6 +1070,23 @@ func (b *BlockChain) connectBlock(node *blockNode, block *colxutil.Block, view *
 			return err
 		}
 
+		// Account for the stored block and prune the data for the
+		// oldest blocks as needed when the chain is pruned.
+		if b.pruneState != nil {
+			prune := *b.pruneState
+			prune.storedBytes += blockSize
+			prune, _, err = b.dbPruneBlocks(dbTx, prune,
+				node.height)
+			if err != nil {
+				return err
+			}
+			err = dbPutPruneState(dbTx, prune)
+			if err != nil {
+				return err
+			}
+			newPruneState = &prune
+		}
+
 		// Allow the index manager to call each of the currently active
 		// optional indexes with the block being connected so they can
 		// update themselves accordingly.
@@ -833,6 +1106,9 @@ func (b *BlockChain) connectBlock(node *blockNode, block *colxutil.Block, view *
 	// Prune fully spent entries and mark all entries in the view unmodified
 	// now that the modifications have been committed to the database.
 	view.commit()
+	if newPruneState != nil {
+		b.pruneState = newPruneState
+	}
 
 	// Add the new node to the memory main chain indices for faster
 	// lookups.
@@ -863,16 +1139,27 @@ func (b *BlockChain) connectBlock(node *blockNode, block *colxutil.Block, view *
 }
 
 // disconnectBlock handles disconnecting the passed node/block from the end of
-// the main (best) chain.
+// the main (best) chain.  The passed utxo view must have all of the txos the
+// block spent restored and the txos it created removed using the passed stxos,
+// which are the spent txout details loaded from the spend journal.
 //
 // This function MUST be called with the chain state lock held (for writes).
-func (b *BlockChain) disconnectBlock(node *blockNode, block *colxutil.Block, view *UtxoViewpoint) error {
+func (b *BlockChain) disconnectBlock(node *blockNode, block *colxutil.Block, view *UtxoViewpoint, stxos []spentTxOut) error {
 	// Make sure the node being disconnected is the end of the best chain.
 	if !node.hash.IsEqual(b.bestNode.hash) {
 		return AssertError("disconnectBlock must be called with the " +
 			"block at the end of the main chain")
 	}
 
+	// Cross-check the changes undone in the utxo view against those the
+	// block made before anything is committed when auditing is enabled.
+	if b.auditUtxoDeltas {
+		err := b.auditDisconnectDeltas(node, block, view, stxos)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Get the previous block node.  This function is used over simply
 	// accessing node.parent directly as it will dynamically create previous
 	// block nodes as needed.  This helps allow only the pieces of the chain
@@ -903,6 +1190,7 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *colxutil.Block, vie
 	newTotalTxns := curTotalTxns - uint64(len(block.MsgBlock().Transactions))
 	state := newBestState(prevNode, blockSize, numTxns, newTotalTxns)
 
+	var newPruneState *pruneState
 	err = b.db.Update(func(dbTx database.Tx) error {
 		// Update best block state.
 		err := dbPutBestState(dbTx, state, node.workSum)
@@ -932,6 +1220,22 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *colxutil.Block, vie
 			return err
 		}
 
+		// The data for the block no longer counts towards the stored
+		// main chain blocks when the chain is pruned.
+		if b.pruneState != nil {
+			prune := *b.pruneState
+			size := uint64(block.MsgBlock().SerializeSize())
+			if size > prune.storedBytes {
+				size = prune.storedBytes
+			}
+			prune.storedBytes -= size
+			err = dbPutPruneState(dbTx, prune)
+			if err != nil {
+				return err
+			}
+			newPruneState = &prune
+		}
+
 		// Allow the index manager to call each of the currently active
 		// optional indexes with the block being disconnected so they
 		// can update themselves accordingly.
@@ -951,6 +1255,9 @@ func (b *BlockChain) disconnectBlock(node *blockNode, block *colxutil.Block, vie
 	// Prune fully spent entries and mark all entries in the view unmodified
 	// now that the modifications have been committed to the database.
 	view.commit()
+	if newPruneState != nil {
+		b.pruneState = newPruneState
+	}
 
 	// Put block in the side chain cache.
 	node.inMainChain = false
@@ -988,6 +1295,31 @@ func countSpentOutputs(block *colxutil.Block) int {
 	return numSpent
 }
 
+// newReorgData returns the data describing a reorganize which disconnects the
+// nodes in the passed detach list and connects the nodes in the passed attach
+// list.  The lists must be in the order expected by reorganizeChain.
+func newReorgData(detachNodes, attachNodes *list.List) *ReorgData {
+	reorg := &ReorgData{
+		Detached: make([]*wire.ShaHash, 0, detachNodes.Len()),
+		Attached: make([]*wire.ShaHash, 0, attachNodes.Len()),
+	}
+	for e := detachNodes.Front(); e != nil; e = e.Next() {
+		reorg.Detached = append(reorg.Detached, e.Value.(*blockNode).hash)
+	}
+	for e := attachNodes.Front(); e != nil; e = e.Next() {
+		reorg.Attached = append(reorg.Attached, e.Value.(*blockNode).hash)
+	}
+	if e := detachNodes.Front(); e != nil {
+		n := e.Value.(*blockNode)
+		reorg.OldTip, reorg.OldHeight = n.hash, n.height
+	}
+	if e := attachNodes.Back(); e != nil {
+		n := e.Value.(*blockNode)
+		reorg.NewTip, reorg.NewHeight = n.hash, n.height
+	}
+	return reorg
+}
+
 // reorganizeChain reorganizes the block chain by disconnecting the nodes in the
 // detachNodes list and connecting the nodes in the attach list.  It expects
 // that the lists are already in the correct order and are in sync with the
@@ -1000,8 +1332,25 @@ func countSpentOutputs(block *colxutil.Block) int {
 //  - BFDryRun: Only the checks which ensure the reorganize can be completed
 //    successfully are performed.  The chain is not reorganized.
 //
+// The chain is not reorganized and the error of the passed context is returned
+// when it is canceled while the blocks to attach are being checked.
+//
 // This function MUST be called with the chain state lock held (for writes).
-func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List, flags BehaviorFlags) error {
+func (b *BlockChain) reorganizeChain(ctx context.Context, detachNodes, attachNodes *list.List, flags BehaviorFlags) error {
+	// Disconnecting blocks requires their data along with the data for the
+	// block at the fork point, so refuse to reorganize past the point the
+	// chain has been pruned to.
+	if b.pruneState != nil && detachNodes.Len() > 0 {
+		forkHeight := detachNodes.Back().Value.(*blockNode).height - 1
+		if forkHeight < b.pruneState.height {
+			str := fmt.Sprintf("reorganize to the fork at height %d "+
+				"is deeper than the retained block data which "+
+				"starts at height %d", forkHeight,
+				b.pruneState.height)
+			return ruleError(ErrPrunedReorg, str)
+		}
+	}
+
 	// Ensure all of the needed side chain blocks are in the cache.
 	for e := attachNodes.Front(); e != nil; e = e.Next() {
 		n := e.Value.(*blockNode)
@@ -1033,6 +1382,9 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List, flags
 			block, err = dbFetchBlockByHash(dbTx, n.hash)
 			return err
 		})
+		if err != nil {
+			return err
+		}
 
 		// Load all of the utxos referenced by the block that aren't
 		// already in the view.
@@ -1082,18 +1434,42 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List, flags
 		// thus will not be generated.  This is done because the state
 		// is not being immediately written to the database, so it is
 		// not needed.
-		err := b.checkConnectBlock(n, block, view, nil)
+		err := b.checkConnectBlock(ctx, n, block, view, nil)
 		if err != nil {
+			if flags&BFDryRun != BFDryRun {
+				b.markInvalidSideChain(n, err)
+			}
 			return err
 		}
 	}
 
+	// Stop before modifying the chain and database when the context was
+	// canceled during the checks.  Once the blocks start being
+	// disconnected, the reorganize must run to completion.
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	// Skip disconnecting and connecting the blocks when running with the
 	// dry run flag set.
 	if flags&BFDryRun == BFDryRun {
 		return nil
 	}
 
+	// Invalidate the entries of caches which are affected by the
+	// reorganize before anything about it is observable.
+	attachBlocks := make([]*colxutil.Block, 0, attachNodes.Len())
+	for e := attachNodes.Front(); e != nil; e = e.Next() {
+		attachBlocks = append(attachBlocks,
+			b.blockCache[*e.Value.(*blockNode).hash])
+	}
+	b.invalidateCaches(detachBlocks, attachBlocks)
+
+	// Notify the caller of the reorganize as a whole before the individual
+	// blocks are disconnected and connected so it can be handled
+	// atomically.
+	b.sendNotification(NTChainReorg, newReorgData(detachNodes, attachNodes))
+
 	// Reset the view for the actual connection code below.  This is
 	// required because the view was previously modified when checking if
 	// the reorg would be successful and the connection code requires the
@@ -1122,7 +1498,8 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List, flags
 		}
 
 		// Update the database and chain state.
-		err = b.disconnectBlock(n, block, view)
+		err = b.disconnectBlock(n, block, view,
+			detachSpentTxOuts[i])
 		if err != nil {
 			return err
 		}
@@ -1174,6 +1551,17 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List, flags
 	return nil
 }
 
+// isBetterTip returns whether or not the passed node should replace the current
+// best node as the tip of the main chain.  This is the case when it has more
+// cumulative work, or the same cumulative work and it was marked precious more
+// recently than the current best node.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) isBetterTip(node *blockNode) bool {
+	cmp := node.workSum.Cmp(b.bestNode.workSum)
+	return cmp > 0 || (cmp == 0 && node.preciousSeq > b.bestNode.preciousSeq)
+}
+
 // connectBestChain handles connecting the passed block to the chain while
 // respecting proper chain selection according to the chain with the most
 // proof of work.  In the typical case, the new block simply extends the main
@@ -1189,7 +1577,7 @@ func (b *BlockChain) reorganizeChain(detachNodes, attachNodes *list.List, flags
 //    modifying the state are avoided.
 //
 // This function MUST be called with the chain state lock held (for writes).
-func (b *BlockChain) connectBestChain(node *blockNode, block *colxutil.Block, flags BehaviorFlags) error {
+func (b *BlockChain) connectBestChain(ctx context.Context, node *blockNode, block *colxutil.Block, flags BehaviorFlags) error {
 	fastAdd := flags&BFFastAdd == BFFastAdd
 	dryRun := flags&BFDryRun == BFDryRun
 
@@ -1203,12 +1591,27 @@ func (b *BlockChain) connectBestChain(node *blockNode, block *colxutil.Block, fl
 		view.SetBestHash(node.parentHash)
 		stxos := make([]spentTxOut, 0, countSpentOutputs(block))
 		if !fastAdd {
-			err := b.checkConnectBlock(node, block, view, &stxos)
+			err := b.checkConnectBlock(ctx, node, block, view, &stxos)
 			if err != nil {
+				if errors.As(err, new(RuleError)) && !dryRun {
+					err := b.storeInvalidBlock(block,
+						node.height, err.Error())
+					if err != nil {
+						log.Warnf("Unable to store invalid "+
+							"block %v: %v", node.hash, err)
+					}
+				}
 				return err
 			}
 		}
 
+		// Don't connect the block when the context was canceled during
+		// the checks.  This is the last point the chain state is left
+		// untouched.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		// Don't connect the block if performing a dry run.
 		if dryRun {
 			return nil
@@ -1276,7 +1679,7 @@ func (b *BlockChain) connectBestChain(node *blockNode, block *colxutil.Block, fl
 
 	// We're extending (or creating) a side chain, but the cumulative
 	// work for this new side chain is not enough to make it the new chain.
-	if node.workSum.Cmp(b.bestNode.workSum) <= 0 {
+	if !b.isBetterTip(node) {
 		// Skip Logging info when the dry run flag is set.
 		if dryRun {
 			return nil
@@ -1318,14 +1721,70 @@ func (b *BlockChain) connectBestChain(node *blockNode, block *colxutil.Block, fl
 		log.Infof("REORGANIZE: Block %v is causing a reorganize.",
 			node.hash)
 	}
-	err := b.reorganizeChain(detachNodes, attachNodes, flags)
+	err := b.reorganizeChain(ctx, detachNodes, attachNodes, flags)
 	if err != nil {
+		// Forget the block when processing it was canceled so it can
+		// be processed again rather than being rejected as a
+		// duplicate.
+		if ctx.Err() != nil && !dryRun {
+			node.parent.children = removeChildNode(
+				node.parent.children, node)
+			delete(b.index, *node.hash)
+			delete(b.blockCache, *node.hash)
+		}
 		return err
 	}
 
 	return nil
 }
 
+// PreciousBlock treats the block identified by the passed hash as if it were
+// received before any other block with the same cumulative work.  When the
+// block is on a side chain with the same cumulative work as the main chain, the
+// chain is reorganized so the block becomes the new tip.  Blocks with less
+// cumulative work than the main chain are ignored, so a chain with strictly
+// more work is never overridden.
+//
+// Calling this again with another block gives that block precedence instead.
+// The preference only lasts until the block is beaten by more work and is not
+// persisted across restarts.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) PreciousBlock(hash *wire.ShaHash) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	// All side chain blocks are in memory, so a block which is not must
+	// either be an older block in the main chain or unknown.
+	node, ok := b.index[*hash]
+	if !ok {
+		exists, err := b.blockExists(hash)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return fmt.Errorf("block %v is not known", hash)
+		}
+		return nil
+	}
+
+	// Nothing to do when the block has less work than the main chain.
+	if node.workSum.Cmp(b.bestNode.workSum) < 0 {
+		return nil
+	}
+
+	b.preciousSeq++
+	node.preciousSeq = b.preciousSeq
+	if node.inMainChain || !b.isBetterTip(node) {
+		return nil
+	}
+
+	log.Infof("REORGANIZE: Block %v was marked precious", node.hash)
+	detachNodes, attachNodes := b.getReorganizeNodes(node)
+	return b.reorganizeChain(context.Background(), detachNodes,
+		attachNodes, BFNone)
+}
+
 // IsCurrent returns whether or not the chain believes it is current.  Several
 // factors are used to guess, but the key factors that allow the chain to
 // believe it is current are:
@@ -1373,8 +1832,11 @@ func (b *BlockChain) BestSnapshot() *BestState {
 // purpose of supporting optional indexes.
 type IndexManager interface {
 	// Init is invoked during chain initialize in order to allow the index
-	// manager to initialize itself and any indexes it is managing.
-	Init(*BlockChain) error
+	// manager to initialize itself and any indexes it is managing.  The
+	// channel parameter specifies a channel the caller can close to signal
+	// that the process should be interrupted.  It can be nil if that
+	// behavior is not desired.
+	Init(*BlockChain, <-chan struct{}) error
 
 	// ConnectBlock is invoked when a new block has been connected to the
 	// main chain.
@@ -1431,6 +1893,107 @@ type Config struct {
 	// This field can be nil if the caller does not wish to make use of an
 	// index manager.
 	IndexManager IndexManager
+
+	// Interrupt specifies a channel the caller can close to signal that
+	// long running operations performed while loading the chain, such as
+	// catching up the optional indexes, should be interrupted.
+	//
+	// This field can be nil if the caller does not desire the behavior.
+	Interrupt <-chan struct{}
+
+	// PruneTarget is the target size in megabytes for the data of the main
+	// chain blocks stored in the database.  When it is set, the data and
+	// spend journal entries for the oldest blocks are deleted while the
+	// stored data exceeds the target.  The block headers, the block index,
+	// and the utxo set are always retained.
+	//
+	// Once pruned, the chain is unable to reorganize to a fork point below
+	// the lowest block whose data is still stored and the data for the
+	// pruned blocks can no longer be fetched.
+	//
+	// This field can be zero to disable pruning.
+	PruneTarget uint64
+
+	// PruneDepth is the number of the most recent main chain blocks whose
+	// data is never pruned.  It also limits how deep a reorganize a pruned
+	// chain is able to perform.
+	//
+	// This field can be zero to use DefaultPruneDepth.
+	PruneDepth int32
+
+	// AssumeValid is the hash of a block whose ancestors are assumed to
+	// have valid scripts.  Script validation is skipped when connecting an
+	// ancestor of the block once the best known header chain contains it
+	// and the ancestor is buried deeply enough under the best header.  All
+	// other validation is still performed.
+	//
+	// This field can be nil or the zero hash to always validate scripts.
+	AssumeValid *wire.ShaHash
+
+	// AdditionalCheckpoints defines checkpoints which are merged with the
+	// checkpoints of the chain parameters.  An additional checkpoint may
+	// repeat one of the chain parameters, however it must not specify a
+	// different block at the same height.
+	//
+	// This field can be nil if the caller does not wish to add checkpoints.
+	AdditionalCheckpoints []chaincfg.Checkpoint
+
+	// MaxOrphanBlocks is the maximum number of orphan blocks which are
+	// queued until their parent is known.  The oldest orphans are evicted
+	// to make room for new ones.
+	//
+	// This field can be zero to use DefaultMaxOrphanBlocks.
+	MaxOrphanBlocks int
+
+	// MaxOrphanBytes is the maximum combined serialized size of the queued
+	// orphan blocks.  The oldest orphans are evicted to make room for new
+	// ones.
+	//
+	// This field can be zero to use DefaultMaxOrphanBytes.
+	MaxOrphanBytes int
+
+	// MaxOrphanAge is the amount of time orphan blocks are queued before
+	// they expire and are evicted.
+	//
+	// This field can be zero to use DefaultMaxOrphanAge.
+	MaxOrphanAge time.Duration
+
+	// AuditUtxoDeltas specifies whether the changes made to the utxo set
+	// when connecting and disconnecting blocks are audited.  The number and
+	// value of the outputs each block creates and consumes are tallied
+	// independently from the block and its spent txouts and cross-checked
+	// against the changes actually applied to the utxo view before they are
+	// committed to the database, and an AssertError is returned when they
+	// do not balance.
+	//
+	// This field can be false to disable the audit, which has a runtime
+	// cost and is intended for testing and diagnosing database issues.
+	AuditUtxoDeltas bool
+
+	// StoreInvalidBlocks specifies whether blocks which fail the checks
+	// performed when connecting them, such as script validation, are
+	// stored in the database along with the reason they were rejected
+	// instead of being discarded.  Stored blocks and their descendants are
+	// rejected and never considered for the best chain until they are
+	// validated again via ReconsiderBlock, which allows recovering from a
+	// local validation bug without downloading them again.
+	//
+	// Blocks which were stored before are rejected regardless of this
+	// field.
+	StoreInvalidBlocks bool
+
+	// MaxInvalidBlocks is the maximum number of blocks which are stored as
+	// invalid.  The oldest ones are evicted to make room for new ones.
+	//
+	// This field can be zero to use DefaultMaxInvalidBlocks.
+	MaxInvalidBlocks int
+
+	// MaxInvalidBlockBytes is the maximum combined serialized size of the
+	// blocks which are stored as invalid.  The oldest ones are evicted to
+	// make room for new ones.
+	//
+	// This field can be zero to use DefaultMaxInvalidBlockBytes.
+	MaxInvalidBlockBytes int
 }
 
 // New returns a BlockChain instance using the provided configuration details.
@@ -1443,18 +2006,25 @@ func New(config *Config) (*BlockChain, error) {
 		return nil, AssertError("blockchain.New chain parameters nil")
 	}
 
-	// Generate a checkpoint by height map from the provided checkpoints.
+	// Merge the additional checkpoints with those of the chain parameters
+	// and generate a checkpoint by height map from the result.
 	params := config.ChainParams
+	checkpoints, err := mergeCheckpoints(params.Checkpoints,
+		config.AdditionalCheckpoints)
+	if err != nil {
+		return nil, err
+	}
 	var checkpointsByHeight map[int32]*chaincfg.Checkpoint
-	if len(params.Checkpoints) > 0 {
+	if len(checkpoints) > 0 {
 		checkpointsByHeight = make(map[int32]*chaincfg.Checkpoint)
-		for i := range params.Checkpoints {
-			checkpoint := &params.Checkpoints[i]
+		for i := range checkpoints {
+			checkpoint := &checkpoints[i]
 			checkpointsByHeight[checkpoint.Height] = checkpoint
 		}
 	}
 
 	b := BlockChain{
+		checkpoints:         checkpoints,
 		checkpointsByHeight: checkpointsByHeight,
 		db:                  config.DB,
 		chainParams:         params,
@@ -1462,13 +2032,63 @@ func New(config *Config) (*BlockChain, error) {
 		notifications:       config.Notifications,
 		sigCache:            config.SigCache,
 		indexManager:        config.IndexManager,
+		auditUtxoDeltas:     config.AuditUtxoDeltas,
+		inFlight:            make(map[inFlightKey]*inFlightBlock),
 		bestNode:            nil,
 		index:               make(map[wire.ShaHash]*blockNode),
 		depNodes:            make(map[wire.ShaHash][]*blockNode),
 		orphans:             make(map[wire.ShaHash]*orphanBlock),
 		prevOrphans:         make(map[wire.ShaHash][]*orphanBlock),
 		blockCache:          make(map[wire.ShaHash]*colxutil.Block),
-	}
+		futureBlocks:        make(map[wire.ShaHash]*futureBlock),
+		futureSchedule:      scheduleFutureBlock,
+		headerIndex:         make(map[wire.ShaHash]*blockNode),
+		pruneTarget:         config.PruneTarget * 1024 * 1024,
+		pruneDepth:          config.PruneDepth,
+		maxOrphanBlocks:     config.MaxOrphanBlocks,
+		maxOrphanBytes:      config.MaxOrphanBytes,
+		maxOrphanAge:        config.MaxOrphanAge,
+		storeInvalid:        config.StoreInvalidBlocks,
+		maxInvalidBlocks:    config.MaxInvalidBlocks,
+		maxInvalidBytes:     config.MaxInvalidBlockBytes,
+	}
+	if b.pruneDepth <= 0 {
+		b.pruneDepth = DefaultPruneDepth
+	}
+	if b.maxOrphanBlocks <= 0 {
+		b.maxOrphanBlocks = DefaultMaxOrphanBlocks
+	}
+	if b.maxOrphanBytes <= 0 {
+		b.maxOrphanBytes = DefaultMaxOrphanBytes
+	}
+	if b.maxOrphanAge <= 0 {
+		b.maxOrphanAge = DefaultMaxOrphanAge
+	}
+	if b.maxInvalidBlocks <= 0 {
+		b.maxInvalidBlocks = DefaultMaxInvalidBlocks
+	}
+	if b.maxInvalidBytes <= 0 {
+		b.maxInvalidBytes = DefaultMaxInvalidBlockBytes
+	}
+	b.deploymentCaches = make(map[uint32]*thresholdStateCache,
+		len(params.Deployments))
+	for id := range params.Deployments {
+		b.deploymentCaches[id] = newThresholdCache()
+	}
+	if config.AssumeValid != nil && !config.AssumeValid.IsEqual(zeroHash) {
+		assumeValid := *config.AssumeValid
+		b.assumeValid = &assumeValid
+		b.assumeValidDepth = assumeValidMinDepth
+		log.Infof("Assuming valid scripts for the ancestors of block %v",
+			assumeValid)
+	}
+
+	// The reorganize might orphan the assumevalid block, so its ancestry
+	// needs to be determined again.
+	b.RegisterCacheInvalidator("assumevalid ancestry",
+		func(detached, attached []*colxutil.Block) {
+			b.resetAssumeValid()
+		})
 
 	// Initialize the chain state from the passed database.  When the db
 	// does not yet contain any chain state, both it and the chain state
@@ -1477,14 +2097,26 @@ func New(config *Config) (*BlockChain, error) {
 		return nil, err
 	}
 
+	// Load the blocks which were stored as invalid so they remain rejected.
+	if err := b.initInvalidBlocks(); err != nil {
+		return nil, err
+	}
+
 	// Initialize and catch up all of the currently active optional indexes
 	// as needed.
 	if config.IndexManager != nil {
-		if err := config.IndexManager.Init(&b); err != nil {
+		if err := config.IndexManager.Init(&b, config.Interrupt); err != nil {
 			return nil, err
 		}
 	}
 
+	// Load the prune state and prune the data for old blocks as needed.
+	// This is done after the optional indexes have caught up since they
+	// require the data for the blocks they index.
+	if err := b.initPruneState(); err != nil {
+		return nil, err
+	}
+
 	log.Infof("Chain state (height %d, hash %v, totaltx %d, work %v)",
 		b.bestNode.height, b.bestNode.hash, b.stateSnapshot.TotalTxns,
 		b.bestNode.workSum)
@@ -6,6 +6,7 @@
 package blockchain_test
 
 import (
+	"sync"
 	"testing"
 
 	"github.com/tinhnguyenhn/colxd/blockchain"
@@ -37,7 +38,8 @@ func TestHaveBlock(t *testing.T) {
 	}
 
 	// Create a new database and chain instance to run tests against.
-	chain, teardownFunc, err := chainSetup("haveblock")
+	chain, teardownFunc, err := chainSetup("haveblock",
+		&chaincfg.MainNetParams)
 	if err != nil {
 		t.Errorf("Failed to setup chain instance: %v", err)
 		return
@@ -111,3 +113,154 @@ func TestHaveBlock(t *testing.T) {
 		}
 	}
 }
+
+// TestBlocksByHeightRange ensures the blocks returned by BlockByHeight,
+// BlockByHash and BlocksByHeightRange have their heights set, that ranges are
+// limited by the passed byte budget, and that ranges may be fetched while
+// blocks are connected concurrently.
+func TestBlocksByHeightRange(t *testing.T) {
+	const numInitial = 10
+	const numLater = 20
+
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, numInitial+numLater)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("blocksbyheightrange", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	for _, block := range blocks[:numInitial] {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: %v", err)
+		}
+	}
+
+	for i, block := range blocks[:numInitial] {
+		height := int32(i + 1)
+		byHeight, err := chain.BlockByHeight(height)
+		if err != nil {
+			t.Fatalf("BlockByHeight(%d): %v", height, err)
+		}
+		if byHeight.Height() != height || !byHeight.Sha().IsEqual(block.Sha()) {
+			t.Errorf("BlockByHeight(%d): got block %v at height %d",
+				height, byHeight.Sha(), byHeight.Height())
+		}
+		byHash, err := chain.BlockByHash(block.Sha())
+		if err != nil {
+			t.Fatalf("BlockByHash(%v): %v", block.Sha(), err)
+		}
+		if byHash.Height() != height {
+			t.Errorf("BlockByHash(%v): unexpected height - got %d, "+
+				"want %d", block.Sha(), byHash.Height(), height)
+		}
+	}
+
+	// checkRange ensures the passed blocks are the blocks of the generated
+	// chain starting at the passed height with their heights set.
+	checkRange := func(name string, got []*colxutil.Block, start int32) bool {
+		for i, block := range got {
+			height := start + int32(i)
+			want := blocks[height-1]
+			if block.Height() != height || !block.Sha().IsEqual(want.Sha()) {
+				t.Errorf("%s: got block %v at height %d, want block "+
+					"%v at height %d", name, block.Sha(),
+					block.Height(), want.Sha(), height)
+				return false
+			}
+		}
+		return true
+	}
+
+	blockSize := blocks[0].MsgBlock().SerializeSize()
+	tests := []struct {
+		name     string
+		start    int32
+		end      int32
+		maxBytes int
+		want     int
+	}{
+		{"whole chain", 1, numInitial + 1, 0, numInitial},
+		{"end past tip", 5, 100, 0, numInitial - 4},
+		{"start past tip", numInitial + 1, 100, 0, 0},
+		{"budget of three blocks", 1, numInitial + 1, blockSize*3 + blockSize/2, 3},
+		{"exact budget", 2, numInitial + 1, blockSize * 2, 2},
+		{"budget below one block", 4, numInitial + 1, 1, 1},
+	}
+	for _, test := range tests {
+		got, err := chain.BlocksByHeightRange(test.start, test.end,
+			test.maxBytes)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if len(got) != test.want {
+			t.Errorf("%s: unexpected number of blocks - got %d, "+
+				"want %d", test.name, len(got), test.want)
+			continue
+		}
+		checkRange(test.name, got, test.start)
+	}
+	if _, err := chain.BlocksByHeightRange(5, 4, 0); err == nil {
+		t.Errorf("BlocksByHeightRange: unexpected success with the end " +
+			"before the start")
+	}
+
+	// Fetch ranges while the remaining blocks are connected.  Every range
+	// must be a prefix of the generated chain no longer than the chain was
+	// when the fetch finished.
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				got, err := chain.BlocksByHeightRange(1, 1000, 0)
+				if err != nil {
+					t.Errorf("concurrent fetch: unexpected error: %v",
+						err)
+					return
+				}
+				if len(got) < numInitial {
+					t.Errorf("concurrent fetch: got %d blocks, "+
+						"want at least %d", len(got), numInitial)
+					return
+				}
+				if !checkRange("concurrent fetch", got, 1) {
+					return
+				}
+			}
+		}()
+	}
+	for _, block := range blocks[numInitial:] {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			close(done)
+			wg.Wait()
+			t.Fatalf("ProcessBlock: %v", err)
+		}
+	}
+	close(done)
+	wg.Wait()
+
+	got, err := chain.BlocksByHeightRange(1, 1000, 0)
+	if err != nil {
+		t.Fatalf("BlocksByHeightRange: unexpected error: %v", err)
+	}
+	if len(got) != len(blocks) {
+		t.Fatalf("BlocksByHeightRange: got %d blocks, want %d", len(got),
+			len(blocks))
+	}
+	checkRange("after connecting", got, 1)
+}
@@ -0,0 +1,307 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+/*
+Package chaingen provides facilities for generating chains of valid blocks to
+use in tests.
+
+The Generator creates blocks which build on its current tip with coinbases that
+pay to an OP_TRUE script, solves their proof of work, and names them so tests
+can refer to them and create forks by moving the tip to any block it generated.
+The coinbase outputs of the generated blocks may be collected and spent by later
+blocks.  Since the proof of work is solved by brute force, the generator is
+only suitable for networks with a trivial proof of work limit such as the
+regression test network.
+
+The blocks of the generated scenarios may be exported as JSON test vectors which
+describe the expected result of processing each block.  The vectors are meant to
+be shared with other implementations for consensus testing, so the format is
+documented by the Vector type, and they are loaded with ReadVectors and run
+with the Run method of a Vector.
+*/
+package chaingen
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+var (
+	// opTrueScript is the public key script all generated outputs pay to.
+	// It can be spent with an empty signature script.
+	opTrueScript = []byte{txscript.OP_TRUE}
+)
+
+// SpendableOut represents a transaction output which is spendable along with
+// its amount.
+type SpendableOut struct {
+	PrevOut wire.OutPoint
+	Amount  colxutil.Amount
+}
+
+// MakeSpendableOut returns a spendable output for the passed transaction and
+// output index.
+func MakeSpendableOut(tx *wire.MsgTx, txOutIndex uint32) SpendableOut {
+	return SpendableOut{
+		PrevOut: wire.OutPoint{Hash: tx.TxSha(), Index: txOutIndex},
+		Amount:  colxutil.Amount(tx.TxOut[txOutIndex].Value),
+	}
+}
+
+// CreateSpendTx returns a transaction which spends the passed output and pays
+// its amount less the passed fee to an OP_TRUE script.
+func CreateSpendTx(spend *SpendableOut, fee colxutil.Amount) *wire.MsgTx {
+	spendTx := wire.NewMsgTx()
+	spendTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: spend.PrevOut,
+		Sequence:         wire.MaxTxInSequenceNum,
+	})
+	spendTx.AddTxOut(wire.NewTxOut(int64(spend.Amount-fee), opTrueScript))
+	return spendTx
+}
+
+// CalcMerkleRoot returns the merkle root of the passed transactions.  It is
+// useful for block mungers which modify the transactions of a block.
+func CalcMerkleRoot(txns []*wire.MsgTx) wire.ShaHash {
+	utilTxns := make([]*colxutil.Tx, 0, len(txns))
+	for _, tx := range txns {
+		utilTxns = append(utilTxns, colxutil.NewTx(tx))
+	}
+	merkles := blockchain.BuildMerkleTreeStore(utilTxns)
+	return *merkles[len(merkles)-1]
+}
+
+// solveBlock increments the nonce of the passed block header until it hashes
+// to a value less than the target difficulty claimed by its bits.
+func solveBlock(header *wire.BlockHeader) {
+	target := blockchain.CompactToBig(header.Bits)
+	for {
+		hash := header.BlockSha()
+		if blockchain.ShaHashToBig(&hash).Cmp(target) <= 0 {
+			return
+		}
+		header.Nonce++
+	}
+}
+
+// Generator houses the state used to generate chains of named blocks.  It is
+// not safe for concurrent access.
+type Generator struct {
+	params       *chaincfg.Params
+	tip          *wire.MsgBlock
+	tipName      string
+	blocks       map[wire.ShaHash]*wire.MsgBlock
+	blockHeights map[wire.ShaHash]int32
+	blocksByName map[string]*wire.MsgBlock
+	extraNonce   int64
+
+	// spendableOuts are the coinbase outputs which were collected and not
+	// returned by OldestCoinbaseOut yet.  prevCollectedHash is the hash of
+	// the block the outputs were last collected up to.
+	spendableOuts     []SpendableOut
+	prevCollectedHash wire.ShaHash
+}
+
+// NewGenerator returns a generator whose tip is the genesis block of the passed
+// network, which is named "genesis".
+func NewGenerator(params *chaincfg.Params) *Generator {
+	genesis := params.GenesisBlock
+	genesisHash := genesis.BlockSha()
+	return &Generator{
+		params:            params,
+		tip:               genesis,
+		tipName:           "genesis",
+		blocks:            map[wire.ShaHash]*wire.MsgBlock{genesisHash: genesis},
+		blockHeights:      map[wire.ShaHash]int32{genesisHash: 0},
+		blocksByName:      map[string]*wire.MsgBlock{"genesis": genesis},
+		prevCollectedHash: genesisHash,
+	}
+}
+
+// Params returns the network parameters the generator was created with.
+func (g *Generator) Params() *chaincfg.Params {
+	return g.params
+}
+
+// Tip returns the current tip block of the generator.
+func (g *Generator) Tip() *wire.MsgBlock {
+	return g.tip
+}
+
+// TipName returns the name of the current tip block of the generator.
+func (g *Generator) TipName() string {
+	return g.tipName
+}
+
+// TipHeight returns the height of the current tip block of the generator.
+func (g *Generator) TipHeight() int32 {
+	return g.blockHeights[g.tip.BlockSha()]
+}
+
+// BlockByName returns the generated block with the passed name or nil when
+// there is no such block.
+func (g *Generator) BlockByName(name string) *wire.MsgBlock {
+	return g.blocksByName[name]
+}
+
+// BlockByHash returns the generated block with the passed hash or nil when
+// there is no such block.
+func (g *Generator) BlockByHash(hash *wire.ShaHash) *wire.MsgBlock {
+	return g.blocks[*hash]
+}
+
+// BlockHeight returns the height of the generated block with the passed hash
+// and whether or not the block is known.
+func (g *Generator) BlockHeight(hash *wire.ShaHash) (int32, bool) {
+	height, ok := g.blockHeights[*hash]
+	return height, ok
+}
+
+// createCoinbaseTx returns a coinbase transaction for a block at the passed
+// height which pays the subsidy along with the passed fees to an OP_TRUE
+// script.  The signature script includes an extra nonce which is unique to the
+// generator, so the coinbases of blocks at the same height on different forks
+// differ.
+func (g *Generator) createCoinbaseTx(height int32, fees colxutil.Amount) (*wire.MsgTx, error) {
+	g.extraNonce++
+	coinbaseScript, err := txscript.NewScriptBuilder().
+		AddInt64(int64(height)).AddInt64(g.extraNonce).Script()
+	if err != nil {
+		return nil, err
+	}
+
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&wire.ShaHash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: coinbaseScript,
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	subsidy := blockchain.CalcBlockSubsidy(height, g.params)
+	tx.AddTxOut(wire.NewTxOut(subsidy+int64(fees), opTrueScript))
+	return tx, nil
+}
+
+// NextBlock generates a block which builds on the current tip, names it with the
+// passed name, and makes it the new tip.
+//
+// The block contains a coinbase which pays the subsidy and fees to an OP_TRUE
+// script.  When the passed output is not nil, the block also contains a
+// transaction which spends it with a fee of one atom.  The passed mungers are
+// applied in order after the merkle root is calculated and before the proof of
+// work is solved, so they may modify the block to make it invalid in ways other
+// than its proof of work.  Mungers which modify the transactions must update
+// the merkle root themselves when it is intended to be valid, for example with
+// CalcMerkleRoot.
+//
+// It panics when the name is already in use or the coinbase can't be created,
+// since both are programming errors in the test code which uses it.
+func (g *Generator) NextBlock(name string, spend *SpendableOut, mungers ...func(*wire.MsgBlock)) *wire.MsgBlock {
+	if _, ok := g.blocksByName[name]; ok {
+		panic(fmt.Sprintf("block name %s is already in use", name))
+	}
+
+	nextHeight := g.TipHeight() + 1
+	var fees colxutil.Amount
+	var spendTx *wire.MsgTx
+	if spend != nil {
+		fees = 1
+		spendTx = CreateSpendTx(spend, fees)
+	}
+	coinbaseTx, err := g.createCoinbaseTx(nextHeight, fees)
+	if err != nil {
+		panic(fmt.Sprintf("unable to create coinbase for block %s: %v",
+			name, err))
+	}
+	txns := []*wire.MsgTx{coinbaseTx}
+	if spendTx != nil {
+		txns = append(txns, spendTx)
+	}
+
+	block := &wire.MsgBlock{
+		Header: wire.BlockHeader{
+			Version:    4,
+			PrevBlock:  g.tip.BlockSha(),
+			MerkleRoot: CalcMerkleRoot(txns),
+			Timestamp:  g.tip.Header.Timestamp.Add(time.Second),
+			Bits:       g.params.PowLimitBits,
+		},
+		Transactions: txns,
+	}
+	for _, munger := range mungers {
+		munger(block)
+	}
+	solveBlock(&block.Header)
+
+	blockHash := block.BlockSha()
+	g.blocks[blockHash] = block
+	g.blockHeights[blockHash] = nextHeight
+	g.blocksByName[name] = block
+	g.tip = block
+	g.tipName = name
+	return block
+}
+
+// SetTip makes the generated block with the passed name the current tip, which
+// allows creating forks from any generated block.
+//
+// It panics when there is no block with the passed name since that is a
+// programming error in the test code which uses it.
+func (g *Generator) SetTip(name string) {
+	block, ok := g.blocksByName[name]
+	if !ok {
+		panic(fmt.Sprintf("tip block name %s does not exist", name))
+	}
+	g.tip = block
+	g.tipName = name
+}
+
+// SaveTipCoinbaseOut adds the coinbase output of the current tip block to the
+// list of spendable outputs.
+func (g *Generator) SaveTipCoinbaseOut() {
+	g.spendableOuts = append(g.spendableOuts,
+		MakeSpendableOut(g.tip.Transactions[0], 0))
+	g.prevCollectedHash = g.tip.BlockSha()
+}
+
+// SaveSpendableCoinbaseOuts adds the coinbase outputs of all blocks from the
+// block after the one outputs were last collected up to, through the current
+// tip, to the list of spendable outputs in the order of the blocks.  The tip
+// must build on the block the outputs were last collected up to.
+func (g *Generator) SaveSpendableCoinbaseOuts() {
+	var collect []*wire.MsgBlock
+	for block := g.tip; block.BlockSha() != g.prevCollectedHash; {
+		collect = append(collect, block)
+		block = g.blocks[block.Header.PrevBlock]
+		if block == nil {
+			panic("tip does not build on the block coinbase " +
+				"outputs were last collected up to")
+		}
+	}
+	for i := len(collect) - 1; i >= 0; i-- {
+		g.spendableOuts = append(g.spendableOuts,
+			MakeSpendableOut(collect[i].Transactions[0], 0))
+	}
+	g.prevCollectedHash = g.tip.BlockSha()
+}
+
+// OldestCoinbaseOut removes and returns the oldest spendable coinbase output.
+// It returns nil when there are no spendable outputs.  Coinbase outputs may
+// only be spent once they have matured, so callers must generate enough blocks
+// after a coinbase before spending it.
+func (g *Generator) OldestCoinbaseOut() *SpendableOut {
+	if len(g.spendableOuts) == 0 {
+		return nil
+	}
+	op := g.spendableOuts[0]
+	g.spendableOuts = g.spendableOuts[1:]
+	return &op
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package chaingen_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/blockchain/chaingen"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	_ "github.com/tinhnguyenhn/colxd/database/ffldb"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// newTestChain returns a chain for the passed network in a temporary database
+// along with a function which closes and removes it.  The chain audits the
+// changes every block makes to the utxo set so the scenarios also ensure the
+// utxo view stays consistent with the blocks.
+func newTestChain(t *testing.T, params *chaincfg.Params) (*blockchain.BlockChain, func()) {
+	dbPath, err := ioutil.TempDir("", "chaingen")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		params.Net)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("unable to create db: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:              db,
+		ChainParams:     params,
+		TimeSource:      blockchain.NewMedianTime(),
+		AuditUtxoDeltas: true,
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create chain: %v", err)
+	}
+	return chain, teardown
+}
+
+// TestGeneratorReorg ensures the generator creates a chain of valid blocks and
+// a fork from one of them which causes a reorganization once it has more work.
+func TestGeneratorReorg(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chain, teardown := newTestChain(t, params)
+	defer teardown()
+
+	// accepted processes the current tip of the generator and ensures it
+	// is accepted and becomes the best block when expected.
+	g := chaingen.NewGenerator(params)
+	accepted := func(wantBest bool) {
+		block := colxutil.NewBlock(g.Tip())
+		isOrphan, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil || isOrphan {
+			t.Fatalf("block %s: ProcessBlock: unexpected result - "+
+				"orphan %v, error %v", g.TipName(), isOrphan, err)
+		}
+		best := chain.BestSnapshot()
+		isBest := best.Hash.IsEqual(block.Sha())
+		if isBest != wantBest {
+			t.Fatalf("block %s: unexpected best block %v (height %d)",
+				g.TipName(), best.Hash, best.Height)
+		}
+		if isBest && best.Height != g.TipHeight() {
+			t.Fatalf("block %s: unexpected best height - got %d, "+
+				"want %d", g.TipName(), best.Height, g.TipHeight())
+		}
+	}
+
+	// Create a chain of 10 blocks.
+	//
+	//   genesis -> b1 -> ... -> b10
+	for i := 1; i <= 10; i++ {
+		g.NextBlock(fmt.Sprintf("b%d", i), nil)
+		accepted(true)
+	}
+
+	// Create a fork from b7 which has less work than the main chain until
+	// its fourth block, which causes a reorganization of three blocks.
+	//
+	//   genesis -> ... -> b7 -> b8 -> b9 -> b10
+	//                        \-> b8a -> b9a -> b10a -> b11a
+	g.SetTip("b7")
+	for i := 8; i <= 10; i++ {
+		g.NextBlock(fmt.Sprintf("b%da", i), nil)
+		accepted(false)
+	}
+	g.NextBlock("b11a", nil)
+	accepted(true)
+
+	mainChain := map[string]bool{
+		"b7": true, "b8": false, "b9": false, "b10": false,
+		"b8a": true, "b9a": true, "b10a": true,
+	}
+	for name, want := range mainChain {
+		hash := g.BlockByName(name).BlockSha()
+		got, err := chain.MainChainHasBlock(&hash)
+		if err != nil {
+			t.Fatalf("MainChainHasBlock: unexpected error: %v", err)
+		}
+		if got != want {
+			t.Fatalf("block %s: unexpected main chain status - got "+
+				"%v, want %v", name, got, want)
+		}
+	}
+}
+
+// TestGeneratorSpend ensures the generator tracks the coinbase outputs of the
+// generated blocks and creates valid blocks which spend them, and that mungers
+// are able to make blocks invalid.
+func TestGeneratorSpend(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chain, teardown := newTestChain(t, params)
+	defer teardown()
+
+	process := func(block *wire.MsgBlock) error {
+		_, err := chain.ProcessBlock(colxutil.NewBlock(block),
+			blockchain.BFNone)
+		return err
+	}
+
+	// Generate enough blocks for the first coinbase to mature.
+	g := chaingen.NewGenerator(params)
+	for i := 1; i <= blockchain.CoinbaseMaturity; i++ {
+		if err := process(g.NextBlock(fmt.Sprintf("b%d", i), nil)); err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+	g.SaveSpendableCoinbaseOuts()
+	out := g.OldestCoinbaseOut()
+	firstCoinbase := g.BlockByName("b1").Transactions[0]
+	if out == nil || out.PrevOut.Hash != firstCoinbase.TxSha() {
+		t.Fatalf("OldestCoinbaseOut: unexpected output %v", out)
+	}
+
+	// A block which spends the output more than it is worth is rejected.
+	tip := g.TipName()
+	bad := g.NextBlock("bad", out, func(b *wire.MsgBlock) {
+		b.Transactions[1].TxOut[0].Value = int64(out.Amount) + 1
+		b.Header.MerkleRoot = chaingen.CalcMerkleRoot(b.Transactions)
+	})
+	err := process(bad)
+	var rerr blockchain.RuleError
+	if !errors.As(err, &rerr) ||
+		rerr.ErrorCode != blockchain.ErrSpendTooHigh {
+
+		t.Fatalf("ProcessBlock: unexpected error for block which "+
+			"overspends - got %v, want %v", err,
+			blockchain.ErrSpendTooHigh)
+	}
+
+	// A valid block spends the output and pays the fee to the coinbase.
+	g.SetTip(tip)
+	good := g.NextBlock("good", out)
+	if err := process(good); err != nil {
+		t.Fatalf("ProcessBlock: unexpected error: %v", err)
+	}
+	subsidy := blockchain.CalcBlockSubsidy(g.TipHeight(), params)
+	if got := good.Transactions[0].TxOut[0].Value; got != subsidy+1 {
+		t.Fatalf("unexpected coinbase value - got %d, want %d", got,
+			subsidy+1)
+	}
+	entry, err := chain.FetchUtxoEntry(&out.PrevOut.Hash)
+	if err != nil {
+		t.Fatalf("FetchUtxoEntry: unexpected error: %v", err)
+	}
+	if entry != nil && !entry.IsOutputSpent(out.PrevOut.Index) {
+		t.Fatal("spent coinbase output is still unspent")
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package chaingen
+
+import (
+	"fmt"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// vectorBuilder generates the blocks of a test vector with a generator and
+// adds them to the vector.  The first error is kept and all later additions
+// are ignored, so the scenarios don't need to check every step.
+type vectorBuilder struct {
+	g      *Generator
+	vector Vector
+	err    error
+}
+
+// next generates the next block with the passed name and mungers and adds it
+// to the vector with the passed disposition.
+func (b *vectorBuilder) next(name string, disposition Disposition, mungers ...func(*wire.MsgBlock)) *wire.MsgBlock {
+	block := b.g.NextBlock(name, nil, mungers...)
+	if b.err == nil {
+		b.err = b.vector.AddBlock(name, block, disposition)
+	}
+	return block
+}
+
+// reject generates the next block with the passed name and mungers and adds it
+// to the vector as a block which is rejected with the passed error code.  The
+// tip is restored afterwards since the block is never part of the chain.
+func (b *vectorBuilder) reject(name string, code blockchain.ErrorCode, mungers ...func(*wire.MsgBlock)) {
+	tipName := b.g.TipName()
+	block := b.g.NextBlock(name, nil, mungers...)
+	if b.err == nil {
+		b.err = b.vector.AddRejectedBlock(name, block, code)
+	}
+	b.g.SetTip(tipName)
+}
+
+// reorgScenario returns a vector in which a fork from the main chain becomes
+// the main chain once it has more work, which reorganizes three blocks.
+func reorgScenario(params *chaincfg.Params) (*Vector, error) {
+	b := vectorBuilder{g: NewGenerator(params), vector: Vector{
+		Name: "reorg",
+		Description: "A chain of 10 blocks and a fork from the seventh " +
+			"block which reorganizes the last three blocks once " +
+			"it has more work.",
+		Network: params.Name,
+	}}
+
+	// genesis -> b1 -> ... -> b7 -> b8 -> b9 -> b10
+	//                            \-> b8a -> b9a -> b10a -> b11a
+	for i := 1; i <= 10; i++ {
+		b.next(fmt.Sprintf("b%d", i), AcceptMain)
+	}
+	b.g.SetTip("b7")
+	for i := 8; i <= 10; i++ {
+		b.next(fmt.Sprintf("b%da", i), AcceptSide)
+	}
+	b.next("b11a", AcceptMain)
+	return &b.vector, b.err
+}
+
+// orphanScenario returns a vector in which a block is processed before its
+// parent, so it is an orphan until the parent connects it.
+func orphanScenario(params *chaincfg.Params) (*Vector, error) {
+	b := vectorBuilder{g: NewGenerator(params), vector: Vector{
+		Name: "orphan",
+		Description: "A block which is processed before its parent " +
+			"is an orphan until the parent is processed.",
+		Network: params.Name,
+	}}
+
+	// genesis -> b1 -> b2 -> b3 with b2 processed before b1.
+	b1 := b.g.NextBlock("b1", nil)
+	b2 := b.g.NextBlock("b2", nil)
+	if b.err == nil {
+		b.err = b.vector.AddBlock("b2", b2, Orphan)
+	}
+	if b.err == nil {
+		b.err = b.vector.AddBlock("b1", b1, AcceptMain)
+	}
+	b.next("b3", AcceptMain)
+	return &b.vector, b.err
+}
+
+// rejectScenario returns a vector with blocks which are rejected for breaking
+// consensus rules followed by a valid block at the same height.
+func rejectScenario(params *chaincfg.Params) (*Vector, error) {
+	b := vectorBuilder{g: NewGenerator(params), vector: Vector{
+		Name: "reject",
+		Description: "Blocks with an invalid merkle root and a " +
+			"coinbase which pays more than the subsidy are rejected " +
+			"while a valid block at the same height is accepted.",
+		Network: params.Name,
+	}}
+
+	// genesis -> b1 -> b2 with rejected siblings of b2.
+	b.next("b1", AcceptMain)
+	b.reject("b2-bad-merkle-root", blockchain.ErrBadMerkleRoot,
+		func(block *wire.MsgBlock) {
+			block.Header.MerkleRoot = wire.ShaHash{}
+		})
+	b.reject("b2-bad-coinbase-value", blockchain.ErrBadCoinbaseValue,
+		func(block *wire.MsgBlock) {
+			block.Transactions[0].TxOut[0].Value++
+			block.Header.MerkleRoot = CalcMerkleRoot(
+				block.Transactions)
+		})
+	b.next("b2", AcceptMain)
+	return &b.vector, b.err
+}
+
+// Scenarios returns the consensus test vectors of the scenarios generated for
+// the passed network.  They are the source of the vectors which are shared with
+// other implementations.
+func Scenarios(params *chaincfg.Params) ([]Vector, error) {
+	scenarios := []func(*chaincfg.Params) (*Vector, error){
+		reorgScenario,
+		orphanScenario,
+		rejectScenario,
+	}
+	vectors := make([]Vector, 0, len(scenarios))
+	for _, scenario := range scenarios {
+		vector, err := scenario(params)
+		if err != nil {
+			return nil, err
+		}
+		vectors = append(vectors, *vector)
+	}
+	return vectors, nil
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package chaingen
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// Disposition describes the expected result of processing a block of a test
+// vector.
+type Disposition string
+
+// These constants define the expected results of processing a block.
+const (
+	// AcceptMain indicates the block is accepted and is part of the main
+	// chain once it is processed.
+	AcceptMain Disposition = "accept-main"
+
+	// AcceptSide indicates the block is accepted to a side chain.
+	AcceptSide Disposition = "accept-side"
+
+	// Orphan indicates the block is accepted as an orphan since its parent
+	// is not known.
+	Orphan Disposition = "orphan"
+
+	// Reject indicates the block is rejected with the rule error code of the
+	// block.
+	Reject Disposition = "reject"
+)
+
+// VectorBlock describes a block of a test vector along with the expected
+// result of processing it.
+type VectorBlock struct {
+	// Name identifies the block in failure messages.
+	Name string `json:"name"`
+
+	// Block is the hex encoded serialized block.
+	Block string `json:"block"`
+
+	// Disposition is the expected result of processing the block.
+	Disposition Disposition `json:"disposition"`
+
+	// ErrorCode is the name of the expected rule error code, such as
+	// ErrBadMerkleRoot, of a rejected block.
+	ErrorCode string `json:"errorCode,omitempty"`
+}
+
+// Vector describes a consensus test case as a sequence of blocks which are
+// processed in order by a chain which only contains the genesis block of the
+// network when the vector starts.
+//
+// Vectors are encoded as JSON so they can be shared with other implementations.
+// A file of vectors is a JSON array of objects with the fields "name",
+// "description", "network", which is the name of the network parameters such as
+// "simnet", and "blocks".  The blocks are an array of objects with the fields
+// "name", "block", which is the hex encoded serialized block, "disposition",
+// which is one of "accept-main", "accept-side", "orphan", and "reject", and for
+// rejected blocks, "errorCode", which is the name of the expected rule error
+// code.
+type Vector struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Network     string        `json:"network"`
+	Blocks      []VectorBlock `json:"blocks"`
+}
+
+// AddBlock adds the passed block with the passed name and expected disposition
+// to the vector.  Use AddRejectedBlock for blocks which are expected to be
+// rejected.
+func (v *Vector) AddBlock(name string, block *wire.MsgBlock, disposition Disposition) error {
+	var buf bytes.Buffer
+	if err := block.Serialize(&buf); err != nil {
+		return err
+	}
+	v.Blocks = append(v.Blocks, VectorBlock{
+		Name:        name,
+		Block:       hex.EncodeToString(buf.Bytes()),
+		Disposition: disposition,
+	})
+	return nil
+}
+
+// AddRejectedBlock adds the passed block with the passed name to the vector
+// along with the rule error code it is expected to be rejected with.
+func (v *Vector) AddRejectedBlock(name string, block *wire.MsgBlock, code blockchain.ErrorCode) error {
+	if err := v.AddBlock(name, block, Reject); err != nil {
+		return err
+	}
+	v.Blocks[len(v.Blocks)-1].ErrorCode = code.String()
+	return nil
+}
+
+// Run processes the blocks of the vector in order with the passed chain and
+// returns an error which describes the first block whose result differs from
+// its expected disposition.  The chain must use the network parameters of the
+// vector and only contain the genesis block.
+func (v *Vector) Run(chain *blockchain.BlockChain) error {
+	for _, vb := range v.Blocks {
+		serialized, err := hex.DecodeString(vb.Block)
+		if err != nil {
+			return fmt.Errorf("%s: block %s: %v", v.Name, vb.Name, err)
+		}
+		block, err := colxutil.NewBlockFromBytes(serialized)
+		if err != nil {
+			return fmt.Errorf("%s: block %s: %v", v.Name, vb.Name, err)
+		}
+		isOrphan, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if vb.Disposition == Reject {
+			var rerr blockchain.RuleError
+			ok := errors.As(err, &rerr)
+			if !ok || rerr.ErrorCode.String() != vb.ErrorCode {
+				return fmt.Errorf("%s: block %s: unexpected "+
+					"result - got error %v, want %s", v.Name,
+					vb.Name, err, vb.ErrorCode)
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: block %s: unexpected error: %v",
+				v.Name, vb.Name, err)
+		}
+
+		inMainChain, err := chain.MainChainHasBlock(block.Sha())
+		if err != nil {
+			return err
+		}
+		var got Disposition
+		switch {
+		case isOrphan:
+			got = Orphan
+		case inMainChain:
+			got = AcceptMain
+		default:
+			got = AcceptSide
+		}
+		if got != vb.Disposition {
+			return fmt.Errorf("%s: block %s: unexpected disposition "+
+				"- got %s, want %s", v.Name, vb.Name, got,
+				vb.Disposition)
+		}
+	}
+	return nil
+}
+
+// ReadVectors reads a JSON encoded array of test vectors from the passed
+// reader.
+func ReadVectors(r io.Reader) ([]Vector, error) {
+	var vectors []Vector
+	if err := json.NewDecoder(r).Decode(&vectors); err != nil {
+		return nil, err
+	}
+	return vectors, nil
+}
+
+// WriteVectors writes the passed test vectors to the passed writer as an
+// indented JSON array.
+func WriteVectors(w io.Writer, vectors []Vector) error {
+	serialized, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		return err
+	}
+	serialized = append(serialized, '\n')
+	_, err = w.Write(serialized)
+	return err
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package chaingen_test
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain/chaingen"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+)
+
+// vectorsFile is the file with the test vectors generated from the scenarios
+// for the simulation test network.
+var vectorsFile = filepath.Join("testdata", "vectors.json")
+
+// updateVectors regenerates the test vectors file from the scenarios.
+var updateVectors = flag.Bool("updatevectors", false, "regenerate "+
+	"testdata/vectors.json from the scenarios")
+
+// vectorParams maps the networks test vectors may use to their parameters.
+var vectorParams = map[string]*chaincfg.Params{
+	chaincfg.SimNetParams.Name:        &chaincfg.SimNetParams,
+	chaincfg.RegressionNetParams.Name: &chaincfg.RegressionNetParams,
+}
+
+// runVectors runs each of the passed test vectors with a new chain for its
+// network.
+func runVectors(t *testing.T, vectors []chaingen.Vector) {
+	for _, vector := range vectors {
+		params, ok := vectorParams[vector.Network]
+		if !ok {
+			t.Errorf("%s: unsupported network %q", vector.Name,
+				vector.Network)
+			continue
+		}
+		chain, teardown := newTestChain(t, params)
+		if err := vector.Run(chain); err != nil {
+			t.Errorf("Run: %v", err)
+		}
+		teardown()
+	}
+}
+
+// TestScenarioVectors ensures the vectors exported from the scenarios survive a
+// round trip through JSON and have the expected results when they are run.
+func TestScenarioVectors(t *testing.T) {
+	vectors, err := chaingen.Scenarios(&chaincfg.SimNetParams)
+	if err != nil {
+		t.Fatalf("Scenarios: unexpected error: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := chaingen.WriteVectors(&buf, vectors); err != nil {
+		t.Fatalf("WriteVectors: unexpected error: %v", err)
+	}
+	if *updateVectors {
+		err := ioutil.WriteFile(vectorsFile, buf.Bytes(), 0644)
+		if err != nil {
+			t.Fatalf("unable to write %s: %v", vectorsFile, err)
+		}
+	}
+
+	readVectors, err := chaingen.ReadVectors(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadVectors: unexpected error: %v", err)
+	}
+	runVectors(t, readVectors)
+
+	// The committed vectors must match the scenarios so they stay in sync.
+	file, err := ioutil.ReadFile(vectorsFile)
+	if err != nil {
+		t.Fatalf("unable to read %s: %v", vectorsFile, err)
+	}
+	if !bytes.Equal(file, buf.Bytes()) {
+		t.Fatalf("%s does not match the scenarios - regenerate it with "+
+			"go test -updatevectors", vectorsFile)
+	}
+}
+
+// TestVectorsFile ensures the test vectors in the vectors file have the
+// expected results when they are run.
+func TestVectorsFile(t *testing.T) {
+	f, err := os.Open(vectorsFile)
+	if err != nil {
+		t.Fatalf("unable to open %s: %v", vectorsFile, err)
+	}
+	defer f.Close()
+
+	vectors, err := chaingen.ReadVectors(f)
+	if err != nil {
+		t.Fatalf("ReadVectors: unexpected error: %v", err)
+	}
+	if len(vectors) == 0 {
+		t.Fatalf("%s has no vectors", vectorsFile)
+	}
+	runVectors(t, vectors)
+}
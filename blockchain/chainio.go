@@ -10,7 +10,6 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math/big"
-	"sort"
 
 	"github.com/tinhnguyenhn/colxd/database"
 	"github.com/tinhnguyenhn/colxd/wire"
@@ -601,16 +600,12 @@ func serializeUtxoEntry(entry *UtxoEntry) ([]byte, error) {
 		return nil, nil
 	}
 
-	// Determine the output order by sorting the sparse output index keys.
-	outputOrder := make([]int, 0, len(entry.sparseOutputs))
-	for outputIndex := range entry.sparseOutputs {
-		outputOrder = append(outputOrder, int(outputIndex))
-	}
-	sort.Ints(outputOrder)
+	// Determine the output order from the sorted output indexes.
+	outputOrder := entry.outputIndexes()
 
 	// Encode the header code and determine the number of bytes the
 	// unspentness bitmap needs.
-	highIndex := uint32(outputOrder[len(outputOrder)-1])
+	highIndex := outputOrder[len(outputOrder)-1]
 	headerCode, numBitmapBytes, err := utxoEntryHeaderCode(entry, highIndex)
 	if err != nil {
 		return nil, err
@@ -621,7 +616,7 @@ func serializeUtxoEntry(entry *UtxoEntry) ([]byte, error) {
 		serializeSizeVLQ(uint64(entry.blockHeight)) +
 		serializeSizeVLQ(headerCode) + numBitmapBytes
 	for _, outputIndex := range outputOrder {
-		out := entry.sparseOutputs[uint32(outputIndex)]
+		out := entry.output(outputIndex)
 		if out.spent {
 			continue
 		}
@@ -653,7 +648,7 @@ func serializeUtxoEntry(entry *UtxoEntry) ([]byte, error) {
 	// Serialize the compressed unspent transaction outputs.  Outputs that
 	// are already compressed are serialized without modifications.
 	for _, outputIndex := range outputOrder {
-		out := entry.sparseOutputs[uint32(outputIndex)]
+		out := entry.output(outputIndex)
 		if out.spent {
 			continue
 		}
@@ -717,7 +712,7 @@ func deserializeUtxoEntry(serialized []byte) (*UtxoEntry, error) {
 	// all of the utxos.
 	entry := newUtxoEntry(int32(version), isCoinBase, int32(blockHeight))
 
-	// Add sparse output for unspent outputs 0 and 1 as needed based on the
+	// Add the indexes of unspent outputs 0 and 1 as needed based on the
 	// details provided by the header code.
 	var outputIndexes []uint32
 	if output0Unspent {
@@ -727,7 +722,7 @@ func deserializeUtxoEntry(serialized []byte) (*UtxoEntry, error) {
 		outputIndexes = append(outputIndexes, 1)
 	}
 
-	// Decode the unspentness bitmap adding a sparse output for each unspent
+	// Decode the unspentness bitmap adding the index of each unspent
 	// output.
 	for i := uint32(0); i < uint32(numBitmapBytes); i++ {
 		unspentBits := serialized[offset]
@@ -744,6 +739,18 @@ func deserializeUtxoEntry(serialized []byte) (*UtxoEntry, error) {
 		offset++
 	}
 
+	// Choose how to store the utxos up front since all of their indexes
+	// are known.
+	if len(outputIndexes) > 0 {
+		highIndex := outputIndexes[len(outputIndexes)-1]
+		if preferDenseOutputs(len(outputIndexes), highIndex) {
+			entry.outputs = make([]utxoOutput, 0, highIndex+1)
+		} else {
+			entry.sparseOutputs = make(map[uint32]*utxoOutput,
+				len(outputIndexes))
+		}
+	}
+
 	// Decode and add all of the utxos.
 	for i, outputIndex := range outputIndexes {
 		// Decode the next utxo.  The script and amount fields of the
@@ -759,12 +766,12 @@ func deserializeUtxoEntry(serialized []byte) (*UtxoEntry, error) {
 		}
 		offset += bytesRead
 
-		entry.sparseOutputs[outputIndex] = &utxoOutput{
+		entry.addOutput(outputIndex, utxoOutput{
 			spent:      false,
 			compressed: true,
 			pkScript:   compScript,
 			amount:     int64(compAmount),
-		}
+		})
 	}
 
 	return entry, nil
@@ -1395,3 +1402,53 @@ func (b *BlockChain) HeightRange(startHeight, endHeight int32) ([]wire.ShaHash,
 	})
 	return hashList, err
 }
+
+// BlocksByHeightRange returns the blocks of the main chain for the given start
+// and end heights with their heights set.  It is inclusive of the start height
+// and exclusive of the end height.  The end height will be limited to the
+// current main chain height.  When maxBytes is positive, the returned blocks
+// stop before the first block which would make their total serialized size
+// exceed it, except the first block is always returned so callers which page
+// through a range make progress.
+//
+// The hashes of the range are snapshotted with HeightRange before any of the
+// blocks are loaded, so the chain lock is not held while the blocks are read
+// from the database and blocks may be connected concurrently.  Thus, the
+// returned blocks are the blocks of the main chain at the time the hashes were
+// snapshotted, even if some of them are reorganized out of it while they are
+// being loaded.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) BlocksByHeightRange(startHeight, endHeight int32, maxBytes int) ([]*colxutil.Block, error) {
+	hashList, err := b.HeightRange(startHeight, endHeight)
+	if err != nil || len(hashList) == 0 {
+		return nil, err
+	}
+
+	var blocks []*colxutil.Block
+	err = b.db.View(func(dbTx database.Tx) error {
+		var totalBytes int
+		for i := range hashList {
+			blockBytes, err := dbTx.FetchBlock(&hashList[i])
+			if err != nil {
+				return err
+			}
+			totalBytes += len(blockBytes)
+			if maxBytes > 0 && i > 0 && totalBytes > maxBytes {
+				break
+			}
+
+			block, err := colxutil.NewBlockFromBytes(blockBytes)
+			if err != nil {
+				return err
+			}
+			block.SetHeight(startHeight + int32(i))
+			blocks = append(blocks, block)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
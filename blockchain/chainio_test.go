@@ -780,17 +780,17 @@ func TestUtxoSerialization(t *testing.T) {
 
 			}
 		}
-		if len(utxoEntry.sparseOutputs) != numUnspent {
+		if utxoEntry.numOutputs() != numUnspent {
 			t.Errorf("deserializeUtxoEntry #%d (%s): mismatched "+
 				"number of unspent outputs: got %d, want %d", i,
-				test.name, len(utxoEntry.sparseOutputs),
+				test.name, utxoEntry.numOutputs(),
 				numUnspent)
 			continue
 		}
 
 		// Ensure all of the amounts and scripts of the utxos in the
 		// deserialized entry match the ones in the test entry.
-		for outputIndex := range utxoEntry.sparseOutputs {
+		for _, outputIndex := range utxoEntry.outputIndexes() {
 			gotAmount := utxoEntry.AmountByIndex(outputIndex)
 			wantAmount := test.entry.AmountByIndex(outputIndex)
 			if gotAmount != wantAmount {
@@ -7,6 +7,7 @@ package blockchain
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/tinhnguyenhn/colxd/chaincfg"
 	"github.com/tinhnguyenhn/colxd/database"
@@ -19,6 +20,10 @@ import (
 // best block chain that a good checkpoint candidate must be.
 const CheckpointConfirmations = 2016
 
+// checkpointForkWindow is the number of blocks on either side of a checkpoint
+// candidate which must not have any known side chain blocks at the same height.
+const checkpointForkWindow = 10
+
 // newShaHashFromStr converts the passed big-endian hex string into a
 // wire.ShaHash.  It only differs from the one available in wire in that
 // it ignores the error since it will only (and must only) be called with
@@ -28,6 +33,65 @@ func newShaHashFromStr(hexStr string) *wire.ShaHash {
 	return sha
 }
 
+// checkpointSorter implements sort.Interface to allow a slice of checkpoints to
+// be sorted by height.
+type checkpointSorter []chaincfg.Checkpoint
+
+// Len returns the number of checkpoints in the slice.  It is part of the
+// sort.Interface implementation.
+func (s checkpointSorter) Len() int {
+	return len(s)
+}
+
+// Swap swaps the checkpoints at the passed indices.  It is part of the
+// sort.Interface implementation.
+func (s checkpointSorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Less returns whether the checkpoint with index i is at a lower height than
+// the checkpoint with index j.  It is part of the sort.Interface
+// implementation.
+func (s checkpointSorter) Less(i, j int) bool {
+	return s[i].Height < s[j].Height
+}
+
+// mergeCheckpoints returns the passed checkpoints merged with the passed
+// additional checkpoints and sorted by height.  Additional checkpoints which
+// repeat an existing checkpoint are ignored, while an error is returned for
+// any which specifies a different block at the height of another checkpoint.
+func mergeCheckpoints(checkpoints, additional []chaincfg.Checkpoint) ([]chaincfg.Checkpoint, error) {
+	if len(additional) == 0 {
+		return checkpoints, nil
+	}
+
+	merged := make([]chaincfg.Checkpoint, 0, len(checkpoints)+
+		len(additional))
+	byHeight := make(map[int32]*wire.ShaHash, cap(merged))
+	for _, list := range [][]chaincfg.Checkpoint{checkpoints, additional} {
+		for _, checkpoint := range list {
+			if checkpoint.Hash == nil {
+				str := fmt.Sprintf("checkpoint at height %d "+
+					"does not have a hash", checkpoint.Height)
+				return nil, AssertError(str)
+			}
+			hash, ok := byHeight[checkpoint.Height]
+			if ok && !hash.IsEqual(checkpoint.Hash) {
+				return nil, fmt.Errorf("checkpoint %v at height "+
+					"%d conflicts with checkpoint %v",
+					checkpoint.Hash, checkpoint.Height, hash)
+			}
+			if ok {
+				continue
+			}
+			byHeight[checkpoint.Height] = checkpoint.Hash
+			merged = append(merged, checkpoint)
+		}
+	}
+	sort.Sort(checkpointSorter(merged))
+	return merged, nil
+}
+
 // DisableCheckpoints provides a mechanism to disable validation against
 // checkpoints which you DO NOT want to do in production.  It is provided only
 // for debug purposes.
@@ -40,19 +104,21 @@ func (b *BlockChain) DisableCheckpoints(disable bool) {
 }
 
 // Checkpoints returns a slice of checkpoints (regardless of whether they are
-// already known).  When checkpoints are disabled or there are no checkpoints
-// for the active network, it will return nil.
+// already known).  It includes both the checkpoints of the active network and
+// the additional checkpoints the chain was configured with sorted by height.
+// When checkpoints are disabled or there are no checkpoints, it will return
+// nil.
 //
 // This function is safe for concurrent access.
 func (b *BlockChain) Checkpoints() []chaincfg.Checkpoint {
 	b.chainLock.RLock()
 	defer b.chainLock.RUnlock()
 
-	if b.noCheckpoints || len(b.chainParams.Checkpoints) == 0 {
+	if b.noCheckpoints || len(b.checkpoints) == 0 {
 		return nil
 	}
 
-	return b.chainParams.Checkpoints
+	return b.checkpoints
 }
 
 // latestCheckpoint returns the most recent checkpoint (regardless of whether it
@@ -61,11 +127,11 @@ func (b *BlockChain) Checkpoints() []chaincfg.Checkpoint {
 //
 // This function MUST be called with the chain state lock held (for reads).
 func (b *BlockChain) latestCheckpoint() *chaincfg.Checkpoint {
-	if b.noCheckpoints || len(b.chainParams.Checkpoints) == 0 {
+	if b.noCheckpoints || len(b.checkpoints) == 0 {
 		return nil
 	}
 
-	checkpoints := b.chainParams.Checkpoints
+	checkpoints := b.checkpoints
 	return &checkpoints[len(checkpoints)-1]
 }
 
@@ -82,12 +148,12 @@ func (b *BlockChain) LatestCheckpoint() *chaincfg.Checkpoint {
 }
 
 // verifyCheckpoint returns whether the passed block height and hash combination
-// match the hard-coded checkpoint data.  It also returns true if there is no
+// match the checkpoint data.  It also returns true if there is no
 // checkpoint data for the passed block height.
 //
 // This function MUST be called with the chain lock held (for reads).
 func (b *BlockChain) verifyCheckpoint(height int32, hash *wire.ShaHash) bool {
-	if b.noCheckpoints || len(b.chainParams.Checkpoints) == 0 {
+	if b.noCheckpoints || len(b.checkpoints) == 0 {
 		return true
 	}
 
@@ -106,6 +172,29 @@ func (b *BlockChain) verifyCheckpoint(height int32, hash *wire.ShaHash) bool {
 	return true
 }
 
+// dbFetchCheckpointBlock uses an existing database transaction to retrieve the
+// checkpoint block for the provided hash.  Only the header and height of
+// checkpoint blocks are needed, so a block with only the header is returned
+// when the data for the block has been pruned.
+func dbFetchCheckpointBlock(dbTx database.Tx, hash *wire.ShaHash) (*colxutil.Block, error) {
+	block, err := dbFetchBlockByHash(dbTx, hash)
+	if !isPrunedErr(err) {
+		return block, err
+	}
+
+	header, err := dbFetchHeaderByHash(dbTx, hash)
+	if err != nil {
+		return nil, err
+	}
+	height, err := dbFetchHeightByHash(dbTx, hash)
+	if err != nil {
+		return nil, err
+	}
+	block = colxutil.NewBlock(&wire.MsgBlock{Header: *header})
+	block.SetHeight(height)
+	return block, nil
+}
+
 // findPreviousCheckpoint finds the most recent checkpoint that is already
 // available in the downloaded portion of the block chain and returns the
 // associated block.  It returns nil if a checkpoint can't be found (this should
@@ -113,12 +202,12 @@ func (b *BlockChain) verifyCheckpoint(height int32, hash *wire.ShaHash) bool {
 //
 // This function MUST be called with the chain lock held (for reads).
 func (b *BlockChain) findPreviousCheckpoint() (*colxutil.Block, error) {
-	if b.noCheckpoints || len(b.chainParams.Checkpoints) == 0 {
+	if b.noCheckpoints || len(b.checkpoints) == 0 {
 		return nil, nil
 	}
 
 	// No checkpoints.
-	checkpoints := b.chainParams.Checkpoints
+	checkpoints := b.checkpoints
 	numCheckpoints := len(checkpoints)
 	if numCheckpoints == 0 {
 		return nil, nil
@@ -156,7 +245,7 @@ func (b *BlockChain) findPreviousCheckpoint() (*colxutil.Block, error) {
 		// Cache the latest known checkpoint block for future lookups.
 		checkpoint := checkpoints[checkpointIndex]
 		err = b.db.View(func(dbTx database.Tx) error {
-			block, err := dbFetchBlockByHash(dbTx, checkpoint.Hash)
+			block, err := dbFetchCheckpointBlock(dbTx, checkpoint.Hash)
 			if err != nil {
 				return err
 			}
@@ -201,7 +290,7 @@ func (b *BlockChain) findPreviousCheckpoint() (*colxutil.Block, error) {
 	// has already passed the checkpoint which was verified as accurate
 	// before inserting it.
 	err := b.db.View(func(tx database.Tx) error {
-		block, err := dbFetchBlockByHash(tx, b.nextCheckpoint.Hash)
+		block, err := dbFetchCheckpointBlock(tx, b.nextCheckpoint.Hash)
 		if err != nil {
 			return err
 		}
@@ -241,6 +330,75 @@ func isNonstandardTransaction(tx *colxutil.Tx) bool {
 	return false
 }
 
+// isCheckpointCandidate returns whether or not the passed block is a good
+// checkpoint candidate which is at least the passed number of blocks prior to
+// the end of the main chain.  See IsCheckpointCandidate for the factors used to
+// determine a good checkpoint.
+//
+// This function MUST be called with the chain lock held (for reads).
+func (b *BlockChain) isCheckpointCandidate(dbTx database.Tx, block *colxutil.Block, minDepth int32) (bool, error) {
+	// A checkpoint must be in the main chain.
+	blockHeight, err := dbFetchHeightByHash(dbTx, block.Sha())
+	if err != nil {
+		// Only return an error if it's not due to the block not
+		// being in the main chain.
+		if !isNotInMainChainErr(err) {
+			return false, err
+		}
+		return false, nil
+	}
+
+	// Ensure the height of the passed block and the entry for the
+	// block in the main chain match.  This should always be the
+	// case unless the caller provided an invalid block.
+	if blockHeight != block.Height() {
+		return false, fmt.Errorf("passed block height of %d does not "+
+			"match the main chain height of %d",
+			block.Height(), blockHeight)
+	}
+
+	// A checkpoint must be at least the passed number of blocks before
+	// the end of the main chain.
+	mainChainHeight := b.bestNode.height
+	if blockHeight > (mainChainHeight - minDepth) {
+		return false, nil
+	}
+
+	// Get the previous block header.
+	prevHash := &block.MsgBlock().Header.PrevBlock
+	prevHeader, err := dbFetchHeaderByHash(dbTx, prevHash)
+	if err != nil {
+		return false, err
+	}
+
+	// Get the next block header.
+	nextHeader, err := dbFetchHeaderByHeight(dbTx, blockHeight+1)
+	if err != nil {
+		return false, err
+	}
+
+	// A checkpoint must have timestamps for the block and the
+	// blocks on either side of it in order (due to the median time
+	// allowance this is not always the case).
+	prevTime := prevHeader.Timestamp
+	curTime := block.MsgBlock().Header.Timestamp
+	nextTime := nextHeader.Timestamp
+	if prevTime.After(curTime) || nextTime.Before(curTime) {
+		return false, nil
+	}
+
+	// A checkpoint must have transactions that only contain
+	// standard scripts.
+	for _, tx := range block.Transactions() {
+		if isNonstandardTransaction(tx) {
+			return false, nil
+		}
+	}
+
+	// All of the checks passed, so the block is a candidate.
+	return true, nil
+}
+
 // IsCheckpointCandidate returns whether or not the passed block is a good
 // checkpoint candidate.
 //
@@ -269,67 +427,98 @@ func (b *BlockChain) IsCheckpointCandidate(block *colxutil.Block) (bool, error)
 
 	var isCandidate bool
 	err := b.db.View(func(dbTx database.Tx) error {
-		// A checkpoint must be in the main chain.
-		blockHeight, err := dbFetchHeightByHash(dbTx, block.Sha())
-		if err != nil {
-			// Only return an error if it's not due to the block not
-			// being in the main chain.
-			if !isNotInMainChainErr(err) {
-				return err
-			}
-			return nil
-		}
+		var err error
+		isCandidate, err = b.isCheckpointCandidate(dbTx, block,
+			CheckpointConfirmations)
+		return err
+	})
+	return isCandidate, err
+}
 
-		// Ensure the height of the passed block and the entry for the
-		// block in the main chain match.  This should always be the
-		// case unless the caller provided an invalid block.
-		if blockHeight != block.Height() {
-			return fmt.Errorf("passed block height of %d does not "+
-				"match the main chain height of %d",
-				block.Height(), blockHeight)
-		}
+// CheckpointCandidates returns the main chain blocks after the latest
+// checkpoint which are good checkpoint candidates as described by
+// IsCheckpointCandidate, except they only need to be at least the passed number
+// of blocks prior to the end of the main chain, which is raised to one when it
+// is less.  Blocks whose data has been pruned are not candidates since their
+// transactions can't be checked.
+//
+// In addition, there must not be any known side chain blocks within a few
+// blocks of a candidate in either direction, since the main chain is not yet
+// settled around recent forks.  Only the side chain blocks which have been
+// seen since the chain instance was created are known.
+//
+// The candidates are returned in ascending order of height and are intended to
+// help maintainers choose the checkpoints to add for a release.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CheckpointCandidates(minDepth int) ([]chaincfg.Checkpoint, error) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
 
-		// A checkpoint must be at least CheckpointConfirmations blocks
-		// before the end of the main chain.
-		mainChainHeight := b.bestNode.height
-		if blockHeight > (mainChainHeight - CheckpointConfirmations) {
-			return nil
-		}
+	// Checkpoints must be enabled.
+	if b.noCheckpoints {
+		return nil, fmt.Errorf("checkpoints are disabled")
+	}
 
-		// Get the previous block header.
-		prevHash := &block.MsgBlock().Header.PrevBlock
-		prevHeader, err := dbFetchHeaderByHash(dbTx, prevHash)
-		if err != nil {
-			return err
-		}
+	// The block after a candidate is needed to check the timestamps, so
+	// it can't be the end of the main chain.
+	if minDepth < 1 {
+		minDepth = 1
+	}
+	startHeight := int32(1)
+	if checkpoint := b.latestCheckpoint(); checkpoint != nil {
+		startHeight = checkpoint.Height + 1
+	}
+	endHeight := b.bestNode.height - int32(minDepth)
 
-		// Get the next block header.
-		nextHeader, err := dbFetchHeaderByHeight(dbTx, blockHeight+1)
-		if err != nil {
-			return err
+	// Find the heights of the known side chain blocks.
+	var forkHeights []int32
+	for _, node := range b.index {
+		if !node.inMainChain {
+			forkHeights = append(forkHeights, node.height)
 		}
+	}
+	nearFork := func(height int32) bool {
+		for _, forkHeight := range forkHeights {
+			if forkHeight >= height-checkpointForkWindow &&
+				forkHeight <= height+checkpointForkWindow {
 
-		// A checkpoint must have timestamps for the block and the
-		// blocks on either side of it in order (due to the median time
-		// allowance this is not always the case).
-		prevTime := prevHeader.Timestamp
-		curTime := block.MsgBlock().Header.Timestamp
-		nextTime := nextHeader.Timestamp
-		if prevTime.After(curTime) || nextTime.Before(curTime) {
-			return nil
+				return true
+			}
 		}
+		return false
+	}
 
-		// A checkpoint must have transactions that only contain
-		// standard scripts.
-		for _, tx := range block.Transactions() {
-			if isNonstandardTransaction(tx) {
-				return nil
+	var candidates []chaincfg.Checkpoint
+	err := b.db.View(func(dbTx database.Tx) error {
+		for height := startHeight; height <= endHeight; height++ {
+			if nearFork(height) {
+				continue
 			}
-		}
 
-		// All of the checks passed, so the block is a candidate.
-		isCandidate = true
+			block, err := dbFetchBlockByHeight(dbTx, height)
+			if isPrunedErr(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			isCandidate, err := b.isCheckpointCandidate(dbTx, block,
+				int32(minDepth))
+			if err != nil {
+				return err
+			}
+			if isCandidate {
+				candidates = append(candidates, chaincfg.Checkpoint{
+					Height: height,
+					Hash:   block.Sha(),
+				})
+			}
+		}
 		return nil
 	})
-	return isCandidate, err
+	if err != nil {
+		return nil, err
+	}
+	return candidates, nil
 }
@@ -0,0 +1,250 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestAdditionalCheckpoints ensures additional checkpoints which conflict with
+// the checkpoints of the chain parameters are rejected, that the others are
+// merged with them, and that the merged checkpoints are enforced.
+func TestAdditionalCheckpoints(t *testing.T) {
+	params := chaincfg.RegressionNetParams
+	blocks, err := generateChain(&params, 5)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+	params.Checkpoints = []chaincfg.Checkpoint{
+		{Height: 2, Hash: blocks[1].Sha()},
+	}
+	newConfig := func(additional ...chaincfg.Checkpoint) *blockchain.Config {
+		return &blockchain.Config{
+			ChainParams:           &params,
+			TimeSource:            blockchain.NewMedianTime(),
+			AdditionalCheckpoints: additional,
+		}
+	}
+
+	// A checkpoint for a different block at the height of a checkpoint of
+	// the chain parameters must be rejected.
+	_, _, err = chainSetupWithConfig("addcheckpoints", newConfig(
+		chaincfg.Checkpoint{Height: 2, Hash: blocks[2].Sha()}))
+	if err == nil {
+		t.Fatal("New: did not reject conflicting checkpoint")
+	}
+
+	// Repeating a checkpoint is allowed and the merged checkpoints are
+	// sorted by height.
+	chain, teardownFunc, err := chainSetupWithConfig("addcheckpoints",
+		newConfig(
+			chaincfg.Checkpoint{Height: 4, Hash: blocks[3].Sha()},
+			chaincfg.Checkpoint{Height: 2, Hash: blocks[1].Sha()},
+			chaincfg.Checkpoint{Height: 1, Hash: blocks[0].Sha()},
+		))
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer func() { teardownFunc() }()
+
+	checkpoints := chain.Checkpoints()
+	wantHeights := []int32{1, 2, 4}
+	if len(checkpoints) != len(wantHeights) {
+		t.Fatalf("Checkpoints: unexpected number of checkpoints - got "+
+			"%d, want %d", len(checkpoints), len(wantHeights))
+	}
+	for i, checkpoint := range checkpoints {
+		want := blocks[wantHeights[i]-1].Sha()
+		if checkpoint.Height != wantHeights[i] ||
+			!checkpoint.Hash.IsEqual(want) {
+
+			t.Fatalf("Checkpoints #%d: got %v at height %d, want %v "+
+				"at height %d", i, checkpoint.Hash,
+				checkpoint.Height, want, wantHeights[i])
+		}
+	}
+	if latest := chain.LatestCheckpoint(); latest.Height != 4 {
+		t.Fatalf("LatestCheckpoint: unexpected height %d", latest.Height)
+	}
+
+	// Once the chain passes the additional checkpoint, forking the chain
+	// before it must be rejected.  The timestamp of the forking block is
+	// after the checkpoint so it is not rejected for being too old.
+	for _, block := range blocks {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+	forkBlocks, err := generateChainFrom(&params,
+		&blocks[1].MsgBlock().Header, 2, 1, 1)
+	if err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	forkHeader := &forkBlocks[0].MsgBlock().Header
+	forkHeader.Timestamp = blocks[4].MsgBlock().Header.Timestamp
+	solveBlock(forkHeader)
+	forkBlocks[0] = colxutil.NewBlock(forkBlocks[0].MsgBlock())
+	_, err = chain.ProcessBlock(forkBlocks[0], blockchain.BFNone)
+	if rerr, ok := err.(blockchain.RuleError); !ok ||
+		rerr.ErrorCode != blockchain.ErrForkTooOld {
+
+		t.Fatalf("ProcessBlock: unexpected error - got %v, want %v",
+			err, blockchain.ErrForkTooOld)
+	}
+
+	// A block which does not match an additional checkpoint must be
+	// rejected.  The chain above is torn down first since tearing down a
+	// chain removes the root directory of all test databases.
+	teardownFunc()
+	teardownFunc = func() {}
+	wrongChain, wrongTeardownFunc, err := chainSetupWithConfig(
+		"addcheckpointswrong", newConfig(
+			chaincfg.Checkpoint{Height: 3, Hash: forkBlocks[0].Sha()},
+		))
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer wrongTeardownFunc()
+	for _, block := range blocks[:2] {
+		_, err := wrongChain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+	_, err = wrongChain.ProcessBlock(blocks[2], blockchain.BFNone)
+	if rerr, ok := err.(blockchain.RuleError); !ok ||
+		rerr.ErrorCode != blockchain.ErrBadCheckpoint {
+
+		t.Fatalf("ProcessBlock: unexpected error - got %v, want %v",
+			err, blockchain.ErrBadCheckpoint)
+	}
+}
+
+// TestCheckpointCandidates ensures the checkpoint candidates are the main chain
+// blocks after the latest checkpoint which are deep enough, are not close to a
+// known fork, have timestamps in order with the blocks around them, and only
+// contain standard transactions.
+func TestCheckpointCandidates(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	payScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).
+		AddOp(txscript.OP_HASH160).AddData(make([]byte, 20)).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).
+		Script()
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+
+	// nextBlock returns a block which extends the passed parent.  The
+	// coinbase pays to a standard script unless the block is at the passed
+	// nonstandard height, and the timestamp is adjusted by the passed
+	// offset.
+	const nonstandardHeight = 5
+	nextBlock := func(parent *wire.BlockHeader, height int32, extraNonce int64, offset time.Duration) *colxutil.Block {
+		generated, err := generateChainFrom(params, parent, height-1,
+			1, extraNonce)
+		if err != nil {
+			t.Fatalf("unable to generate block %d: %v", height, err)
+		}
+		msgBlock := generated[0].MsgBlock()
+		msgBlock.Header.Timestamp = msgBlock.Header.Timestamp.Add(offset)
+		if height != nonstandardHeight {
+			msgBlock.Transactions[0].TxOut[0].PkScript = payScript
+			merkles := blockchain.BuildMerkleTreeStore(
+				colxutil.NewBlock(msgBlock).Transactions())
+			msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+		}
+		solveBlock(&msgBlock.Header)
+		return colxutil.NewBlock(msgBlock)
+	}
+
+	// Create a main chain of 40 blocks where the block at height 8 has a
+	// timestamp before the one of its parent, and a side chain block at
+	// height 30.
+	var blocks []*colxutil.Block
+	parent := &params.GenesisBlock.Header
+	for height := int32(1); height <= 40; height++ {
+		var offset time.Duration
+		if height == 8 {
+			offset = -time.Minute * 11
+		}
+		block := nextBlock(parent, height, 0, offset)
+		blocks = append(blocks, block)
+		parent = &block.MsgBlock().Header
+	}
+	sideBlock := nextBlock(&blocks[28].MsgBlock().Header, 30, 1, 0)
+
+	tests := []struct {
+		name        string
+		additional  []chaincfg.Checkpoint
+		wantHeights []int32
+	}{
+		{
+			name:        "no checkpoints",
+			wantHeights: []int32{1, 2, 3, 4, 6, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19},
+		},
+		{
+			name: "after checkpoint",
+			additional: []chaincfg.Checkpoint{
+				{Height: 10, Hash: blocks[9].Sha()},
+			},
+			wantHeights: []int32{11, 12, 13, 14, 15, 16, 17, 18, 19},
+		},
+	}
+
+	for _, test := range tests {
+		chain, teardownFunc, err := chainSetupWithConfig("checkpointcands",
+			&blockchain.Config{
+				ChainParams:           params,
+				TimeSource:            blockchain.NewMedianTime(),
+				AdditionalCheckpoints: test.additional,
+			})
+		if err != nil {
+			t.Fatalf("%s: Failed to setup chain instance: %v",
+				test.name, err)
+		}
+		for _, block := range append(blocks, sideBlock) {
+			_, err := chain.ProcessBlock(block, blockchain.BFNone)
+			if err != nil {
+				teardownFunc()
+				t.Fatalf("%s: ProcessBlock: unexpected error: %v",
+					test.name, err)
+			}
+		}
+
+		// Only the blocks at least five blocks before the end of the
+		// main chain and more than ten blocks from the fork qualify.
+		candidates, err := chain.CheckpointCandidates(5)
+		teardownFunc()
+		if err != nil {
+			t.Fatalf("%s: CheckpointCandidates: unexpected error: %v",
+				test.name, err)
+		}
+		if len(candidates) != len(test.wantHeights) {
+			t.Fatalf("%s: unexpected number of candidates - got %d, "+
+				"want %d", test.name, len(candidates),
+				len(test.wantHeights))
+		}
+		for i, candidate := range candidates {
+			height := test.wantHeights[i]
+			want := blocks[height-1].Sha()
+			if candidate.Height != height ||
+				!candidate.Hash.IsEqual(want) {
+
+				t.Fatalf("%s: candidate #%d: got %v at height %d, "+
+					"want %v at height %d", test.name, i,
+					candidate.Hash, candidate.Height, want, height)
+			}
+		}
+	}
+}
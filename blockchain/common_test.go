@@ -56,9 +56,21 @@ func isSupportedDbType(dbType string) bool {
 }
 
 // chainSetup is used to create a new db and chain instance with the genesis
-// block already inserted.  In addition to the new chain instnce, it returns
-// a teardown function the caller should invoke when done testing to clean up.
-func chainSetup(dbName string) (*blockchain.BlockChain, func(), error) {
+// block for the passed network parameters already inserted.  In addition to
+// the new chain instnce, it returns a teardown function the caller should
+// invoke when done testing to clean up.
+func chainSetup(dbName string, params *chaincfg.Params) (*blockchain.BlockChain, func(), error) {
+	return chainSetupWithConfig(dbName, &blockchain.Config{
+		ChainParams: params,
+		TimeSource:  blockchain.NewMedianTime(),
+	})
+}
+
+// chainSetupWithConfig is the same as chainSetup except the chain instance is
+// created with the passed configuration, whose database is replaced with the
+// new one.
+func chainSetupWithConfig(dbName string, config *blockchain.Config) (*blockchain.BlockChain, func(), error) {
+	params := config.ChainParams
 	if !isSupportedDbType(testDbType) {
 		return nil, nil, fmt.Errorf("unsupported db type %v", testDbType)
 	}
@@ -92,7 +104,7 @@ func chainSetup(dbName string) (*blockchain.BlockChain, func(), error) {
 		// Create a new database to store the accepted blocks into.
 		dbPath := filepath.Join(testDbRoot, dbName)
 		_ = os.RemoveAll(dbPath)
-		ndb, err := database.Create(testDbType, dbPath, blockDataNet)
+		ndb, err := database.Create(testDbType, dbPath, params.Net)
 		if err != nil {
 			return nil, nil, fmt.Errorf("error creating db: %v", err)
 		}
@@ -108,11 +120,9 @@ func chainSetup(dbName string) (*blockchain.BlockChain, func(), error) {
 	}
 
 	// Create the main chain instance.
-	chain, err := blockchain.New(&blockchain.Config{
-		DB:          db,
-		ChainParams: &chaincfg.MainNetParams,
-		TimeSource:  blockchain.NewMedianTime(),
-	})
+	chainConfig := *config
+	chainConfig.DB = db
+	chain, err := blockchain.New(&chainConfig)
 	if err != nil {
 		teardown()
 		err := fmt.Errorf("failed to create chain instance: %v", err)
@@ -0,0 +1,255 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/btcsuite/fastsha256"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxd/wire/gcs"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// CompactBlockTxSource provides the transactions which are available to
+// reconstruct compact blocks from, such as the ones in the memory pool.
+type CompactBlockTxSource interface {
+	// TxnsByShortID returns the available transactions keyed by their
+	// short IDs as computed by ShortTxID with the passed SipHash keys.
+	// Transactions whose short IDs collide must all be included under
+	// their shared short ID.
+	TxnsByShortID(k0, k1 uint64) map[uint64][]*colxutil.Tx
+}
+
+// CompactBlockKeys returns the SipHash keys used to compute the short IDs of
+// the transactions of the compact block with the passed header and nonce.  They
+// are the first two little-endian 64-bit words of the SHA256 of the serialized
+// header followed by the little-endian nonce as defined by BIP0152.
+func CompactBlockKeys(header *wire.BlockHeader, nonce uint64) (uint64, uint64) {
+	var buf bytes.Buffer
+	buf.Grow(wire.MaxBlockHeaderPayload + 8)
+	_ = header.Serialize(&buf)
+	var nonceBytes [8]byte
+	binary.LittleEndian.PutUint64(nonceBytes[:], nonce)
+	buf.Write(nonceBytes[:])
+
+	hash := fastsha256.Sum256(buf.Bytes())
+	return binary.LittleEndian.Uint64(hash[0:8]),
+		binary.LittleEndian.Uint64(hash[8:16])
+}
+
+// ShortTxID returns the short ID of the transaction with the passed hash for
+// the passed SipHash keys, which are computed by CompactBlockKeys.  It is the
+// SipHash-2-4 of the transaction hash truncated to wire.ShortIDSize bytes.
+func ShortTxID(k0, k1 uint64, txHash *wire.ShaHash) uint64 {
+	return gcs.SipHash(k0, k1, txHash[:]) & wire.MaxShortID
+}
+
+// ShortIDTxns returns the passed transactions keyed by their short IDs for the
+// passed SipHash keys.  It is a convenience function for implementations of
+// CompactBlockTxSource.
+func ShortIDTxns(txns []*colxutil.Tx, k0, k1 uint64) map[uint64][]*colxutil.Tx {
+	byShortID := make(map[uint64][]*colxutil.Tx, len(txns))
+	for _, tx := range txns {
+		shortID := ShortTxID(k0, k1, tx.Sha())
+		byShortID[shortID] = append(byShortID[shortID], tx)
+	}
+	return byShortID
+}
+
+// NewCompactBlock returns a compact block message for the passed block which
+// uses the passed nonce to compute the short IDs of its transactions.  Only
+// the coinbase is prefilled since the receiver can't have it already.
+func NewCompactBlock(block *colxutil.Block, nonce uint64) *wire.MsgCmpctBlock {
+	msgBlock := block.MsgBlock()
+	msg := wire.NewMsgCmpctBlock(&msgBlock.Header, nonce)
+	k0, k1 := CompactBlockKeys(&msgBlock.Header, nonce)
+	for i, tx := range block.Transactions() {
+		if i == 0 {
+			msg.PrefilledTxs = append(msg.PrefilledTxs,
+				wire.PrefilledTx{Index: 0, Tx: tx.MsgTx()})
+			continue
+		}
+		msg.ShortIDs = append(msg.ShortIDs, ShortTxID(k0, k1, tx.Sha()))
+	}
+	return msg
+}
+
+// PartialBlock houses a block which is being reconstructed from a compact block
+// message and the transactions available to the local node.  The transactions
+// which could not be matched unambiguously are requested via the message
+// returned by RequestMissing and filled in with the transactions of the
+// blocktxn message answering it.
+type PartialBlock struct {
+	header  wire.BlockHeader
+	txns    []*wire.MsgTx
+	missing []uint32
+}
+
+// NewPartialBlock starts the reconstruction of the block described by the
+// passed compact block message by matching the short IDs of its transactions
+// against the transactions provided by the passed source, which may be nil
+// when no transactions are available.
+//
+// A transaction is only taken from the source when its short ID is unique both
+// within the compact block and among the available transactions.  Otherwise
+// the transaction is treated as missing, so short ID collisions are resolved
+// by requesting the affected transactions from the peer.
+//
+// An error with ErrBadCompactBlock is returned when the message does not
+// describe a valid set of transactions, such as when a prefilled transaction
+// index is out of range.
+func NewPartialBlock(msg *wire.MsgCmpctBlock, source CompactBlockTxSource) (*PartialBlock, error) {
+	numTxns := msg.TxCount()
+	if numTxns == 0 {
+		return nil, ruleError(ErrBadCompactBlock, "compact block does "+
+			"not contain any transactions")
+	}
+
+	// Place the prefilled transactions at their indexes.  The short IDs
+	// describe the remaining transactions in order.
+	txns := make([]*wire.MsgTx, numTxns)
+	for _, prefilled := range msg.PrefilledTxs {
+		if int(prefilled.Index) >= numTxns {
+			str := fmt.Sprintf("prefilled transaction index %d is "+
+				"out of range for %d transactions",
+				prefilled.Index, numTxns)
+			return nil, ruleError(ErrBadCompactBlock, str)
+		}
+		if prefilled.Tx == nil || txns[prefilled.Index] != nil {
+			str := fmt.Sprintf("prefilled transaction index %d is "+
+				"invalid or duplicated", prefilled.Index)
+			return nil, ruleError(ErrBadCompactBlock, str)
+		}
+		txns[prefilled.Index] = prefilled.Tx
+	}
+
+	// Short IDs which occur more than once in the block can't be matched
+	// to a single transaction.
+	shortIDCounts := make(map[uint64]int, len(msg.ShortIDs))
+	for _, shortID := range msg.ShortIDs {
+		shortIDCounts[shortID]++
+	}
+
+	var available map[uint64][]*colxutil.Tx
+	if source != nil {
+		available = source.TxnsByShortID(CompactBlockKeys(&msg.Header,
+			msg.Nonce))
+	}
+	var missing []uint32
+	nextShortID := 0
+	for i := range txns {
+		if txns[i] != nil {
+			continue
+		}
+		shortID := msg.ShortIDs[nextShortID]
+		nextShortID++
+
+		candidates := available[shortID]
+		if shortIDCounts[shortID] == 1 && len(candidates) == 1 {
+			txns[i] = candidates[0].MsgTx()
+			continue
+		}
+		missing = append(missing, uint32(i))
+	}
+
+	return &PartialBlock{
+		header:  msg.Header,
+		txns:    txns,
+		missing: missing,
+	}, nil
+}
+
+// BlockSha returns the hash of the block being reconstructed.
+func (pb *PartialBlock) BlockSha() wire.ShaHash {
+	return pb.header.BlockSha()
+}
+
+// MissingIndexes returns the indexes of the transactions of the block which are
+// still missing in increasing order.
+func (pb *PartialBlock) MissingIndexes() []uint32 {
+	return pb.missing
+}
+
+// RequestMissing returns a getblocktxn message which requests the missing
+// transactions of the block.  It returns nil when no transactions are missing.
+func (pb *PartialBlock) RequestMissing() *wire.MsgGetBlockTxn {
+	if len(pb.missing) == 0 {
+		return nil
+	}
+	blockHash := pb.BlockSha()
+	indexes := make([]uint32, len(pb.missing))
+	copy(indexes, pb.missing)
+	return wire.NewMsgGetBlockTxn(&blockHash, indexes)
+}
+
+// FillMissing fills in the missing transactions of the block with the
+// transactions of the passed blocktxn message, which must answer the message
+// returned by RequestMissing.  An error with ErrBadCompactBlock is returned
+// when the message is for another block or does not contain exactly the
+// missing transactions, in which case the partial block is left unchanged.
+func (pb *PartialBlock) FillMissing(msg *wire.MsgBlockTxn) error {
+	blockHash := pb.BlockSha()
+	if !msg.BlockHash.IsEqual(&blockHash) {
+		str := fmt.Sprintf("blocktxn message for block %v does not "+
+			"match block %v", msg.BlockHash, blockHash)
+		return ruleError(ErrBadCompactBlock, str)
+	}
+	if len(msg.Transactions) != len(pb.missing) {
+		str := fmt.Sprintf("blocktxn message contains %d "+
+			"transactions, but %d are missing",
+			len(msg.Transactions), len(pb.missing))
+		return ruleError(ErrBadCompactBlock, str)
+	}
+	for _, tx := range msg.Transactions {
+		if tx == nil {
+			return ruleError(ErrBadCompactBlock, "blocktxn message "+
+				"contains an invalid transaction")
+		}
+	}
+
+	for i, index := range pb.missing {
+		pb.txns[index] = msg.Transactions[i]
+	}
+	pb.missing = nil
+	return nil
+}
+
+// Block returns the reconstructed block once no transactions are missing.  The
+// merkle root of the transactions is verified against the block header before
+// the block is returned, so it can be handed to ProcessBlock.
+//
+// An error with ErrBadMerkleRoot is returned when the merkle root does not
+// match, which happens when a transaction of the local node was matched by a
+// short ID although it is not part of the block.  The block must be requested
+// in full in that case.
+func (pb *PartialBlock) Block() (*colxutil.Block, error) {
+	if len(pb.missing) != 0 {
+		str := fmt.Sprintf("block %v is missing %d transactions",
+			pb.BlockSha(), len(pb.missing))
+		return nil, ruleError(ErrBadCompactBlock, str)
+	}
+
+	msgBlock := &wire.MsgBlock{
+		Header:       pb.header,
+		Transactions: make([]*wire.MsgTx, len(pb.txns)),
+	}
+	copy(msgBlock.Transactions, pb.txns)
+	block := colxutil.NewBlock(msgBlock)
+
+	merkles := BuildMerkleTreeStore(block.Transactions())
+	calculatedMerkleRoot := merkles[len(merkles)-1]
+	if !pb.header.MerkleRoot.IsEqual(calculatedMerkleRoot) {
+		str := fmt.Sprintf("reconstructed block merkle root is invalid "+
+			"- block header indicates %v, but calculated value is "+
+			"%v", pb.header.MerkleRoot, calculatedMerkleRoot)
+		return nil, ruleError(ErrBadMerkleRoot, str)
+	}
+
+	return block, nil
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// txSliceSource is a blockchain.CompactBlockTxSource which provides the
+// transactions of a slice.
+type txSliceSource []*colxutil.Tx
+
+// TxnsByShortID returns the transactions of the slice keyed by their short IDs.
+func (s txSliceSource) TxnsByShortID(k0, k1 uint64) map[uint64][]*colxutil.Tx {
+	return blockchain.ShortIDTxns(s, k0, k1)
+}
+
+// txMapSource is a blockchain.CompactBlockTxSource which provides a fixed set
+// of transactions keyed by short ID regardless of the keys.
+type txMapSource map[uint64][]*colxutil.Tx
+
+// TxnsByShortID returns the fixed transactions of the source.
+func (s txMapSource) TxnsByShortID(k0, k1 uint64) map[uint64][]*colxutil.Tx {
+	return s
+}
+
+// reconstructBlock reconstructs the block described by the passed compact
+// block from the passed source and, when transactions are missing, from the
+// transactions of the passed block requested from it.  It returns the indexes
+// which had to be requested along with the reconstructed block.
+func reconstructBlock(t *testing.T, msg *wire.MsgCmpctBlock, source blockchain.CompactBlockTxSource, block *colxutil.Block) ([]uint32, *colxutil.Block, error) {
+	partial, err := blockchain.NewPartialBlock(msg, source)
+	if err != nil {
+		t.Fatalf("NewPartialBlock: unexpected error: %v", err)
+	}
+
+	var requested []uint32
+	if getBlockTxn := partial.RequestMissing(); getBlockTxn != nil {
+		requested = getBlockTxn.Indexes
+		txns := make([]*wire.MsgTx, 0, len(requested))
+		for _, index := range requested {
+			txns = append(txns, block.MsgBlock().Transactions[index])
+		}
+		blockHash := block.Sha()
+		err := partial.FillMissing(wire.NewMsgBlockTxn(blockHash, txns))
+		if err != nil {
+			t.Fatalf("FillMissing: unexpected error: %v", err)
+		}
+	}
+
+	reconstructed, err := partial.Block()
+	return requested, reconstructed, err
+}
+
+// TestCompactBlockReconstruction ensures blocks are reconstructed from compact
+// blocks with the available transactions and the ones requested for the short
+// IDs which are unavailable or ambiguous.
+func TestCompactBlockReconstruction(t *testing.T) {
+	block := colxutil.NewBlock(&Block100000)
+	txns := block.Transactions()
+	msg := blockchain.NewCompactBlock(block, 0x1122334455667788)
+	if len(msg.PrefilledTxs) != 1 || msg.PrefilledTxs[0].Index != 0 ||
+		len(msg.ShortIDs) != len(txns)-1 {
+
+		t.Fatalf("NewCompactBlock: unexpected message %v", msg)
+	}
+
+	// The first non-coinbase transaction is claimed by two transactions of
+	// the source.
+	k0, k1 := blockchain.CompactBlockKeys(&block.MsgBlock().Header, msg.Nonce)
+	collidingID := blockchain.ShortTxID(k0, k1, txns[1].Sha())
+	colliding := txMapSource{
+		collidingID:     {txns[1], txns[2]},
+		msg.ShortIDs[1]: {txns[2]},
+		msg.ShortIDs[2]: {txns[3]},
+	}
+
+	tests := []struct {
+		name      string
+		source    blockchain.CompactBlockTxSource
+		requested []uint32
+	}{
+		{"no source", nil, []uint32{1, 2, 3}},
+		{"no transactions", txSliceSource(nil), []uint32{1, 2, 3}},
+		{"some transactions", txSliceSource(txns[2:3]), []uint32{1, 3}},
+		{"all transactions", txSliceSource(txns[1:]), nil},
+		{"unrelated transactions", txSliceSource(txns[:1]), []uint32{1, 2, 3}},
+		{"colliding short ID", colliding, []uint32{1}},
+	}
+	for _, test := range tests {
+		requested, reconstructed, err := reconstructBlock(t, msg,
+			test.source, block)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(requested, test.requested) {
+			t.Errorf("%s: unexpected requested indexes - got %v, "+
+				"want %v", test.name, requested, test.requested)
+		}
+		if !reconstructed.Sha().IsEqual(block.Sha()) ||
+			!reflect.DeepEqual(reconstructed.MsgBlock(), block.MsgBlock()) {
+
+			t.Errorf("%s: reconstructed block does not match",
+				test.name)
+		}
+	}
+
+	// Short IDs which occur more than once in the block are requested even
+	// when the source has a single transaction for them.
+	duplicated := *msg
+	duplicated.ShortIDs = []uint64{msg.ShortIDs[0], msg.ShortIDs[0],
+		msg.ShortIDs[2]}
+	partial, err := blockchain.NewPartialBlock(&duplicated,
+		txSliceSource(txns[1:]))
+	if err != nil {
+		t.Fatalf("NewPartialBlock: unexpected error: %v", err)
+	}
+	if got := partial.MissingIndexes(); !reflect.DeepEqual(got,
+		[]uint32{1, 2}) {
+
+		t.Errorf("duplicated short ID: unexpected missing indexes - got "+
+			"%v, want [1 2]", got)
+	}
+}
+
+// TestCompactBlockReconstructionErrors ensures invalid compact blocks, invalid
+// blocktxn messages, and transactions falsely matched by short ID are
+// rejected with the expected errors.
+func TestCompactBlockReconstructionErrors(t *testing.T) {
+	block := colxutil.NewBlock(&Block100000)
+	txns := block.Transactions()
+	msg := blockchain.NewCompactBlock(block, 0)
+
+	// A transaction which is not part of the block matched by short ID
+	// results in a merkle root mismatch.
+	falseMatch := txMapSource{
+		msg.ShortIDs[0]: {txns[1]},
+		msg.ShortIDs[1]: {txns[3]},
+		msg.ShortIDs[2]: {txns[2]},
+	}
+	_, _, err := reconstructBlock(t, msg, falseMatch, block)
+	if !isRuleError(err, blockchain.ErrBadMerkleRoot) {
+		t.Errorf("false match: unexpected error - got %v, want %v", err,
+			blockchain.ErrBadMerkleRoot)
+	}
+
+	// Prefilled transaction indexes must be in range.
+	outOfRange := *msg
+	outOfRange.PrefilledTxs = []wire.PrefilledTx{
+		{Index: uint32(len(txns)), Tx: txns[0].MsgTx()},
+	}
+	_, err = blockchain.NewPartialBlock(&outOfRange, nil)
+	if !isRuleError(err, blockchain.ErrBadCompactBlock) {
+		t.Errorf("out of range prefilled index: unexpected error - got "+
+			"%v, want %v", err, blockchain.ErrBadCompactBlock)
+	}
+
+	// The blocktxn message must be for the block and deliver exactly the
+	// missing transactions.  The partial block is left unchanged when it
+	// does not.
+	partial, err := blockchain.NewPartialBlock(msg, txSliceSource(txns[2:3]))
+	if err != nil {
+		t.Fatalf("NewPartialBlock: unexpected error: %v", err)
+	}
+	blockHash := block.Sha()
+	otherHash := wire.ShaHash{0x01}
+	badBlockTxns := []struct {
+		name string
+		msg  *wire.MsgBlockTxn
+	}{
+		{"other block", wire.NewMsgBlockTxn(&otherHash,
+			[]*wire.MsgTx{txns[1].MsgTx(), txns[3].MsgTx()})},
+		{"too few transactions", wire.NewMsgBlockTxn(blockHash,
+			[]*wire.MsgTx{txns[1].MsgTx()})},
+		{"too many transactions", wire.NewMsgBlockTxn(blockHash,
+			[]*wire.MsgTx{txns[1].MsgTx(), txns[2].MsgTx(),
+				txns[3].MsgTx()})},
+	}
+	for _, test := range badBlockTxns {
+		err := partial.FillMissing(test.msg)
+		if !isRuleError(err, blockchain.ErrBadCompactBlock) {
+			t.Errorf("%s: unexpected error - got %v, want %v",
+				test.name, err, blockchain.ErrBadCompactBlock)
+		}
+	}
+	if _, err := partial.Block(); !isRuleError(err,
+		blockchain.ErrBadCompactBlock) {
+
+		t.Errorf("incomplete block: unexpected error - got %v, want %v",
+			err, blockchain.ErrBadCompactBlock)
+	}
+	if got := partial.MissingIndexes(); !reflect.DeepEqual(got,
+		[]uint32{1, 3}) {
+
+		t.Errorf("unexpected missing indexes after bad blocktxn "+
+			"messages - got %v, want [1 3]", got)
+	}
+}
+
+// isRuleError returns whether the passed error is a rule error with the passed
+// error code.
+func isRuleError(err error, code blockchain.ErrorCode) bool {
+	rerr, ok := err.(blockchain.RuleError)
+	return ok && rerr.ErrorCode == code
+}
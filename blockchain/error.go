@@ -7,6 +7,8 @@ package blockchain
 
 import (
 	"fmt"
+
+	"github.com/tinhnguyenhn/colxd/wire"
 )
 
 // AssertError identifies an error that indicates an internal code consistency
@@ -19,6 +21,16 @@ func (e AssertError) Error() string {
 	return "assertion failed: " + string(e)
 }
 
+// DeploymentError identifies an error that indicates a deployment ID was
+// specified that does not exist.
+type DeploymentError uint32
+
+// Error returns the deployment error as a human-readable string and satisfies
+// the error interface.
+func (e DeploymentError) Error() string {
+	return fmt.Sprintf("deployment ID %d does not exist", uint32(e))
+}
+
 // ErrorCode identifies a kind of error.
 type ErrorCode int
 
@@ -28,8 +40,8 @@ const (
 	// exists.
 	ErrDuplicateBlock ErrorCode = iota
 
-	// ErrBlockTooBig indicates the serialized block size exceeds the
-	// maximum allowed size.
+	// ErrBlockTooBig indicates the block weight exceeds the maximum
+	// allowed weight.
 	ErrBlockTooBig
 
 	// ErrBlockVersionTooOld indicates the block version is too old and is
@@ -150,7 +162,8 @@ const (
 	ErrBadFees
 
 	// ErrTooManySigOps indicates the total number of signature operations
-	// for a transaction or block exceed the maximum allowed limits.
+	// for a transaction or the signature operation cost of a block exceed
+	// the maximum allowed limits.
 	ErrTooManySigOps
 
 	// ErrFirstTxNotCoinbase indicates the first transaction in a block
@@ -189,6 +202,38 @@ const (
 	// such signature verification failures and execution past the end of
 	// the stack.
 	ErrScriptValidation
+
+	// ErrMissingParent indicates a block header does not connect to a
+	// known block or header.  This includes a batch of headers where a
+	// header does not reference the one before it.
+	ErrMissingParent
+
+	// ErrPrunedReorg indicates a reorganize would require disconnecting
+	// blocks back to a fork point at or below the height the chain has
+	// been pruned to, so the data needed to disconnect them is no longer
+	// available.
+	ErrPrunedReorg
+
+	// ErrPrevBlockNotBest indicates that the block's previous block is not
+	// the current chain tip.  This is not a block validation rule, but is
+	// required for block templates, which are only checked against the
+	// current tip.
+	ErrPrevBlockNotBest
+
+	// ErrBadCompactBlock indicates a compact block or the transactions
+	// delivered for it do not describe a valid set of transactions, such
+	// as a prefilled transaction index which is out of range or a
+	// mismatched number of transactions delivered.
+	ErrBadCompactBlock
+
+	// ErrKnownInvalidBlock indicates the block was previously found to
+	// violate the rules checked when connecting it and was stored as
+	// invalid.  It is not validated again until it is reconsidered.
+	ErrKnownInvalidBlock
+
+	// ErrInvalidAncestorBlock indicates the block descends from a block
+	// which is stored as invalid.
+	ErrInvalidAncestorBlock
 )
 
 // Map of ErrorCode values back to their constant names for pretty printing.
@@ -231,6 +276,12 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrBadCoinbaseHeight:     "ErrBadCoinbaseHeight",
 	ErrScriptMalformed:       "ErrScriptMalformed",
 	ErrScriptValidation:      "ErrScriptValidation",
+	ErrMissingParent:         "ErrMissingParent",
+	ErrPrunedReorg:           "ErrPrunedReorg",
+	ErrPrevBlockNotBest:      "ErrPrevBlockNotBest",
+	ErrBadCompactBlock:       "ErrBadCompactBlock",
+	ErrKnownInvalidBlock:     "ErrKnownInvalidBlock",
+	ErrInvalidAncestorBlock:  "ErrInvalidAncestorBlock",
 }
 
 // String returns the ErrorCode as a human-readable name.
@@ -246,9 +297,15 @@ func (e ErrorCode) String() string {
 // rules.  The caller can use type assertions to determine if a failure was
 // specifically due to a rule violation and access the ErrorCode field to
 // ascertain the specific reason for the rule violation.
+//
+// The TxIndex and TxInIndex fields identify the transaction within the block and
+// the input of the transaction which caused errors related to validating
+// transaction scripts.  They are -1 when not applicable.
 type RuleError struct {
 	ErrorCode   ErrorCode // Describes the kind of error
 	Description string    // Human readable description of the issue
+	TxIndex     int       // Index of the transaction within the block
+	TxInIndex   int       // Index of the transaction input
 }
 
 // Error satisfies the error interface and prints human-readable errors.
@@ -258,5 +315,54 @@ func (e RuleError) Error() string {
 
 // ruleError creates an RuleError given a set of arguments.
 func ruleError(c ErrorCode, desc string) RuleError {
-	return RuleError{ErrorCode: c, Description: desc}
+	return RuleError{ErrorCode: c, Description: desc, TxIndex: -1,
+		TxInIndex: -1}
+}
+
+// inputRuleError creates a RuleError which identifies the transaction input at
+// the passed index as responsible for the rule violation.
+func inputRuleError(c ErrorCode, desc string, txInIndex int) RuleError {
+	err := ruleError(c, desc)
+	err.TxInIndex = txInIndex
+	return err
+}
+
+// TxValidationError identifies the transaction, and the input of it where
+// applicable, which caused a block to be rejected.  It embeds the RuleError
+// describing the violated rule, with the TxIndex field set to the index of the
+// transaction within the block.  The RuleError is also returned by Unwrap, so
+// callers which are only interested in the violated rule can extract it with
+// errors.As.
+type TxValidationError struct {
+	RuleError
+	TxHash wire.ShaHash // Hash of the transaction
+}
+
+// Error satisfies the error interface and prints human-readable errors which
+// identify the transaction and input that caused them.
+func (e TxValidationError) Error() string {
+	if e.TxInIndex >= 0 {
+		return fmt.Sprintf("transaction %v (index %d in block), input "+
+			"%d: %s", e.TxHash, e.TxIndex, e.TxInIndex,
+			e.Description)
+	}
+	return fmt.Sprintf("transaction %v (index %d in block): %s", e.TxHash,
+		e.TxIndex, e.Description)
+}
+
+// Unwrap returns the RuleError describing the violated rule.
+func (e TxValidationError) Unwrap() error {
+	return e.RuleError
+}
+
+// txValidationError wraps the passed error which resulted from validating the
+// passed transaction at the passed index within a block in a
+// TxValidationError.  Errors other than rule violations are returned as is.
+func txValidationError(err error, tx *wire.MsgTx, txIndex int) error {
+	rerr, ok := err.(RuleError)
+	if !ok {
+		return err
+	}
+	rerr.TxIndex = txIndex
+	return TxValidationError{RuleError: rerr, TxHash: tx.TxSha()}
 }
@@ -6,9 +6,11 @@
 package blockchain_test
 
 import (
+	"errors"
 	"testing"
 
 	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/wire"
 )
 
 // TestErrorCodeStringer tests the stringized output for the ErrorCode type.
@@ -56,6 +58,12 @@ func TestErrorCodeStringer(t *testing.T) {
 		{blockchain.ErrBadCoinbaseHeight, "ErrBadCoinbaseHeight"},
 		{blockchain.ErrScriptMalformed, "ErrScriptMalformed"},
 		{blockchain.ErrScriptValidation, "ErrScriptValidation"},
+		{blockchain.ErrMissingParent, "ErrMissingParent"},
+		{blockchain.ErrPrunedReorg, "ErrPrunedReorg"},
+		{blockchain.ErrPrevBlockNotBest, "ErrPrevBlockNotBest"},
+		{blockchain.ErrBadCompactBlock, "ErrBadCompactBlock"},
+		{blockchain.ErrKnownInvalidBlock, "ErrKnownInvalidBlock"},
+		{blockchain.ErrInvalidAncestorBlock, "ErrInvalidAncestorBlock"},
 		{0xffff, "Unknown ErrorCode (65535)"},
 	}
 
@@ -96,3 +104,58 @@ func TestRuleError(t *testing.T) {
 		}
 	}
 }
+
+// TestTxValidationError tests the error output for the TxValidationError type
+// and that the violated rule can be extracted from it.
+func TestTxValidationError(t *testing.T) {
+	hash := wire.ShaHash{0x01}
+	tests := []struct {
+		in   blockchain.TxValidationError
+		want string
+	}{
+		{
+			blockchain.TxValidationError{
+				RuleError: blockchain.RuleError{
+					ErrorCode:   blockchain.ErrSpendTooHigh,
+					Description: "spends too much",
+					TxIndex:     3,
+					TxInIndex:   -1,
+				},
+				TxHash: hash,
+			},
+			"transaction " + hash.String() + " (index 3 in block): " +
+				"spends too much",
+		},
+		{
+			blockchain.TxValidationError{
+				RuleError: blockchain.RuleError{
+					ErrorCode:   blockchain.ErrMissingTx,
+					Description: "missing input",
+					TxIndex:     1,
+					TxInIndex:   2,
+				},
+				TxHash: hash,
+			},
+			"transaction " + hash.String() + " (index 1 in block), " +
+				"input 2: missing input",
+		},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		result := test.in.Error()
+		if result != test.want {
+			t.Errorf("Error #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+
+		var rerr blockchain.RuleError
+		if !errors.As(test.in, &rerr) ||
+			rerr.ErrorCode != test.in.ErrorCode {
+
+			t.Errorf("Error #%d: unable to extract rule error from %v",
+				i, test.in)
+		}
+	}
+}
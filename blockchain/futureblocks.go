@@ -0,0 +1,238 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+const (
+	// maxFutureBlocks is the maximum number of blocks which are held until
+	// their timestamp is no longer too far in the future.
+	maxFutureBlocks = 20
+
+	// maxFutureBlockBytes is the maximum combined serialized size of the
+	// blocks which are held until their timestamp is no longer too far in
+	// the future.
+	maxFutureBlockBytes = 8 * wire.MaxBlockPayload
+
+	// maxFutureBlockDelay is the maximum amount of time a block may have
+	// to wait until its timestamp is no longer too far in the future for
+	// it to be held rather than only rejected.  Blocks which are further
+	// in the future are unlikely to be the result of clock skew.
+	maxFutureBlockDelay = time.Minute * 10
+)
+
+// futureBlockTimer is a scheduled processing of a held block which can be
+// canceled.  It is satisfied by *time.Timer.
+type futureBlockTimer interface {
+	Stop() bool
+}
+
+// scheduleFutureBlock calls the passed function in its own goroutine once the
+// passed duration elapses.  It is the default function used to schedule the
+// release of held blocks.
+func scheduleFutureBlock(d time.Duration, f func()) futureBlockTimer {
+	return time.AfterFunc(d, f)
+}
+
+// futureBlock houses a block which failed the sanity checks only because its
+// timestamp was too far in the future along with the earliest time it no
+// longer is.
+type futureBlock struct {
+	block   *colxutil.Block
+	flags   BehaviorFlags
+	size    int
+	validAt time.Time
+	timer   futureBlockTimer
+}
+
+// fixedTimeSource is a median time source whose adjusted time is fixed.  It
+// is used to check the sanity of a block as of the time its timestamp is no
+// longer too far in the future.
+type fixedTimeSource struct {
+	MedianTimeSource
+	adjustedTime time.Time
+}
+
+// AdjustedTime returns the fixed adjusted time of the time source.
+//
+// This is part of the MedianTimeSource interface.
+func (s *fixedTimeSource) AdjustedTime() time.Time {
+	return s.adjustedTime
+}
+
+// futureBlockValidAt returns the earliest adjusted time at which the timestamp
+// of the passed block is no longer too far in the future.
+func futureBlockValidAt(block *colxutil.Block) time.Time {
+	timestamp := block.MsgBlock().Header.Timestamp
+	return timestamp.Add(-time.Second * MaxTimeOffsetSeconds)
+}
+
+// IsKnownFutureBlock returns whether the passed hash is a block which is
+// currently held until its timestamp is no longer too far in the future, at
+// which point it is released with an NTFutureBlockReady notification.  Only a
+// limited number of
+// such blocks are held, so this function must not be used as an absolute way
+// to test if a block was rejected for that reason.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsKnownFutureBlock(hash *wire.ShaHash) bool {
+	b.futureLock.Lock()
+	_, exists := b.futureBlocks[*hash]
+	b.futureLock.Unlock()
+	return exists
+}
+
+// removeFutureBlock removes the passed held block and cancels its scheduled
+// release.
+//
+// This function MUST be called with the future block lock held.
+func (b *BlockChain) removeFutureBlock(fb *futureBlock) {
+	fb.timer.Stop()
+	delete(b.futureBlocks, *fb.block.Sha())
+	b.futureBytes -= fb.size
+}
+
+// takeFutureBlock checks whether the block with the passed hash is held until
+// its timestamp is no longer too far in the future and, when it is held and
+// that time has come, removes it so it can be processed.  It returns whether
+// the block is held and, if so, whether it was removed.  When the passed dry
+// run flag is set, the block is never removed.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) takeFutureBlock(hash *wire.ShaHash, dryRun bool) (bool, bool) {
+	b.futureLock.Lock()
+	defer b.futureLock.Unlock()
+
+	fb, exists := b.futureBlocks[*hash]
+	if !exists {
+		return false, false
+	}
+	if b.timeSource.AdjustedTime().Before(fb.validAt) || dryRun {
+		return true, false
+	}
+	b.removeFutureBlock(fb)
+	return true, true
+}
+
+// maybeHoldFutureBlock holds the passed block, which failed the sanity checks
+// with the passed flags because its timestamp is too far in the future, until
+// that is no longer the case and schedules it to be released at that time.
+// The block is only held when it would pass the sanity checks at that time and
+// the wait is short enough that the timestamp is likely the result of clock
+// skew.  No blocks are held once StopFutureBlocks has been called.  It returns
+// whether or not the block is held.
+//
+// When holding the block exceeds the limits on held blocks, the blocks which
+// become valid the latest are evicted, which may be the passed block itself.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) maybeHoldFutureBlock(block *colxutil.Block, flags, sanityFlags BehaviorFlags) bool {
+	validAt := futureBlockValidAt(block)
+	delay := validAt.Sub(b.timeSource.AdjustedTime())
+	if delay > maxFutureBlockDelay {
+		return false
+	}
+	timeSource := &fixedTimeSource{b.timeSource, validAt}
+	err := checkBlockSanity(block, b.chainParams, timeSource,
+		sanityFlags)
+	if err != nil {
+		return false
+	}
+
+	b.futureLock.Lock()
+	defer b.futureLock.Unlock()
+	if b.futureStopped {
+		return false
+	}
+
+	// Evict the held blocks which become valid the latest until there is
+	// room for the block.
+	size := block.MsgBlock().SerializeSize()
+	for len(b.futureBlocks)+1 > maxFutureBlocks ||
+		b.futureBytes+size > maxFutureBlockBytes {
+
+		var latest *futureBlock
+		for _, fb := range b.futureBlocks {
+			if latest == nil || fb.validAt.After(latest.validAt) {
+				latest = fb
+			}
+		}
+		if latest == nil || !latest.validAt.After(validAt) {
+			return false
+		}
+		log.Debugf("Evicting held future block %v", latest.block.Sha())
+		b.removeFutureBlock(latest)
+	}
+
+	fb := &futureBlock{
+		block:   block,
+		flags:   flags,
+		size:    size,
+		validAt: validAt,
+	}
+	fb.timer = b.futureSchedule(delay, func() {
+		b.releaseFutureBlock(fb)
+	})
+	b.futureBlocks[*block.Sha()] = fb
+	b.futureBytes += size
+	log.Infof("Holding block %v with a timestamp too far in the future "+
+		"until %v", block.Sha(), validAt)
+	return true
+}
+
+// releaseFutureBlock stops holding the passed block once its timestamp is no
+// longer too far in the future and sends an NTFutureBlockReady notification so
+// the receiver processes it again along with the other blocks it processes.
+// The release is rescheduled when the adjusted time has not reached the time
+// the block becomes valid yet, which happens when the adjusted time does not
+// advance at the same rate as the local clock.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) releaseFutureBlock(fb *futureBlock) {
+	hash := fb.block.Sha()
+	b.futureLock.Lock()
+	if b.futureBlocks[*hash] != fb {
+		b.futureLock.Unlock()
+		return
+	}
+	if delay := fb.validAt.Sub(b.timeSource.AdjustedTime()); delay > 0 {
+		fb.timer = b.futureSchedule(delay, func() {
+			b.releaseFutureBlock(fb)
+		})
+		b.futureLock.Unlock()
+		return
+	}
+	b.removeFutureBlock(fb)
+	b.futureLock.Unlock()
+
+	log.Debugf("Releasing held future block %v", hash)
+	b.sendNotification(NTFutureBlockReady, &FutureBlockRelease{
+		Block: fb.block,
+		Flags: fb.flags,
+	})
+}
+
+// StopFutureBlocks discards the blocks which are held until their timestamp is
+// no longer too far in the future and cancels their scheduled release.  No
+// more blocks are held afterwards.  It is intended to be called when shutting
+// down so no NTFutureBlockReady notifications are sent once the receiver is no
+// longer processing blocks.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) StopFutureBlocks() {
+	b.futureLock.Lock()
+	defer b.futureLock.Unlock()
+
+	b.futureStopped = true
+	for _, fb := range b.futureBlocks {
+		b.removeFutureBlock(fb)
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// fakeTimer is a function scheduled to be called by a fake clock.
+type fakeTimer struct {
+	at      time.Time
+	f       func()
+	stopped bool
+}
+
+// fakeClock is a median time source whose time only advances when the test
+// advances it and which calls the functions scheduled with it once their time
+// comes.
+type fakeClock struct {
+	mtx    sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// AdjustedTime returns the current time of the fake clock.
+//
+// This is part of the blockchain.MedianTimeSource interface.
+func (c *fakeClock) AdjustedTime() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+// AddTimeSample is ignored by the fake clock.
+//
+// This is part of the blockchain.MedianTimeSource interface.
+func (c *fakeClock) AddTimeSample(id string, timeVal time.Time) {}
+
+// Offset always returns zero for the fake clock.
+//
+// This is part of the blockchain.MedianTimeSource interface.
+func (c *fakeClock) Offset() time.Duration {
+	return 0
+}
+
+// Stop cancels the timer.
+func (t *fakeTimer) Stop() bool {
+	stopped := t.stopped
+	t.stopped = true
+	return !stopped
+}
+
+// schedule schedules the passed function to be called once the clock advances
+// by the passed duration.
+func (c *fakeClock) schedule(d time.Duration, f func()) blockchain.TstFutureBlockTimer {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	timer := &fakeTimer{at: c.now.Add(d), f: f}
+	c.timers = append(c.timers, timer)
+	return timer
+}
+
+// pending returns the number of scheduled functions which have not been called
+// or canceled.
+func (c *fakeClock) pending() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	var n int
+	for _, timer := range c.timers {
+		if !timer.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+// advance advances the clock by the passed duration and calls the scheduled
+// functions whose time has come in the order they were scheduled.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mtx.Lock()
+	c.now = c.now.Add(d)
+	var due []*fakeTimer
+	for _, timer := range c.timers {
+		if !timer.stopped && !timer.at.After(c.now) {
+			timer.stopped = true
+			due = append(due, timer)
+		}
+	}
+	c.mtx.Unlock()
+
+	for _, timer := range due {
+		timer.f()
+	}
+}
+
+// TestFutureBlocks ensures blocks which are rejected only because their
+// timestamp is too far in the future are held and released to be processed
+// again exactly when that is no longer the case, while blocks which fail other
+// checks are not held or are discarded once they are processed again.  It
+// also ensures no blocks are held or released once held blocks are stopped.
+func TestFutureBlocks(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, 3)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("futureblocks", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Start the clock five minutes before the timestamp of the last block
+	// is no longer too far in the future.
+	early := blocks[2]
+	validAt := early.MsgBlock().Header.Timestamp.Add(-time.Second *
+		blockchain.MaxTimeOffsetSeconds)
+	clock := &fakeClock{now: validAt.Add(-time.Minute * 5)}
+	blockchain.TstSetTimeSource(chain, clock)
+	blockchain.TstSetFutureBlockSchedule(chain, clock.schedule)
+
+	// Process the released blocks again the way the block manager does.
+	var released []*colxutil.Block
+	blockchain.TstSetNotifications(chain, func(n *blockchain.Notification) {
+		if n.Type != blockchain.NTFutureBlockReady {
+			return
+		}
+		release := n.Data.(*blockchain.FutureBlockRelease)
+		released = append(released, release.Block)
+		chain.ProcessBlock(release.Block, release.Flags)
+	})
+
+	for _, block := range blocks[:2] {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+
+	// processRejected processes the passed block and ensures it is rejected
+	// with the passed error code.
+	processRejected := func(name string, block *colxutil.Block, code blockchain.ErrorCode) {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		rerr, ok := err.(blockchain.RuleError)
+		if !ok || rerr.ErrorCode != code {
+			t.Fatalf("%s: unexpected error - got %v, want %v", name,
+				err, code)
+		}
+	}
+
+	// assertHeld ensures the passed block is or is not held.
+	assertHeld := func(name string, block *colxutil.Block, want bool) {
+		if got := chain.IsKnownFutureBlock(block.Sha()); got != want {
+			t.Fatalf("%s: unexpected held state - got %v, want %v",
+				name, got, want)
+		}
+		have, err := chain.HaveBlock(block.Sha())
+		if err != nil {
+			t.Fatalf("%s: HaveBlock: unexpected error: %v", name, err)
+		}
+		if want && !have {
+			t.Fatalf("%s: held block is not known", name)
+		}
+	}
+
+	// assertTip ensures the best chain ends at the passed block.
+	assertTip := func(name string, block *colxutil.Block) {
+		best := chain.BestSnapshot()
+		if !best.Hash.IsEqual(block.Sha()) {
+			t.Fatalf("%s: unexpected best block - got %v, want %v",
+				name, best.Hash, block.Sha())
+		}
+	}
+
+	// The early block is rejected, but held, and processing it again while
+	// it is held is rejected as a duplicate.
+	processRejected("early", early, blockchain.ErrTimeTooNew)
+	assertHeld("early", early, true)
+	processRejected("early duplicate", early, blockchain.ErrDuplicateBlock)
+	if clock.pending() != 1 {
+		t.Fatalf("unexpected number of scheduled blocks - got %d, want 1",
+			clock.pending())
+	}
+
+	// A block which also fails another check is not held.
+	badMerkle, err := generateChainFrom(params,
+		&blocks[1].MsgBlock().Header, 2, 1, 1)
+	if err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	badMerkle[0].MsgBlock().Header.MerkleRoot = wire.ShaHash{}
+	solveBlock(&badMerkle[0].MsgBlock().Header)
+	badMerkleBlock := colxutil.NewBlock(badMerkle[0].MsgBlock())
+	processRejected("bad merkle root", badMerkleBlock,
+		blockchain.ErrTimeTooNew)
+	assertHeld("bad merkle root", badMerkleBlock, false)
+
+	// A block which is too far in the future to be the result of clock
+	// skew is not held.
+	distant, err := generateChainFrom(params, &early.MsgBlock().Header, 3,
+		1, 0)
+	if err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	processRejected("distant", distant[0], blockchain.ErrTimeTooNew)
+	assertHeld("distant", distant[0], false)
+
+	// A block which only fails the contextual checks once it is processed
+	// again is held and then discarded.
+	badBits, err := generateChainFrom(params, &blocks[1].MsgBlock().Header,
+		2, 1, 2)
+	if err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	badBits[0].MsgBlock().Header.Bits = params.PowLimitBits - 1
+	solveBlock(&badBits[0].MsgBlock().Header)
+	badBitsBlock := colxutil.NewBlock(badBits[0].MsgBlock())
+	processRejected("bad bits", badBitsBlock, blockchain.ErrTimeTooNew)
+	assertHeld("bad bits", badBitsBlock, true)
+
+	// Nothing is processed until the window opens.
+	clock.advance(time.Minute*5 - time.Second)
+	assertTip("before window", blocks[1])
+	assertHeld("before window", early, true)
+
+	// The early block is accepted and the block with the wrong difficulty
+	// is discarded exactly when the window opens.
+	if len(released) != 0 {
+		t.Fatalf("unexpected number of released blocks - got %d, want 0",
+			len(released))
+	}
+	clock.advance(time.Second)
+	if len(released) != 2 {
+		t.Fatalf("unexpected number of released blocks - got %d, want 2",
+			len(released))
+	}
+	assertTip("window open", early)
+	assertHeld("window open", early, false)
+	assertHeld("window open", badBitsBlock, false)
+	have, err := chain.HaveBlock(badBitsBlock.Sha())
+	if err != nil {
+		t.Fatalf("HaveBlock: unexpected error: %v", err)
+	}
+	if have {
+		t.Fatal("discarded block is known")
+	}
+	if clock.pending() != 0 {
+		t.Fatalf("unexpected number of scheduled blocks - got %d, want 0",
+			clock.pending())
+	}
+
+	// The block which was too far in the future before the window opened
+	// is held now, but it is discarded without being released when held
+	// blocks are stopped, after which it is no longer held.
+	processRejected("distant after window", distant[0],
+		blockchain.ErrTimeTooNew)
+	assertHeld("distant after window", distant[0], true)
+	chain.StopFutureBlocks()
+	assertHeld("stopped", distant[0], false)
+	if clock.pending() != 0 {
+		t.Fatalf("unexpected number of scheduled blocks - got %d, want 0",
+			clock.pending())
+	}
+	processRejected("stopped", distant[0], blockchain.ErrTimeTooNew)
+	assertHeld("stopped", distant[0], false)
+	clock.advance(time.Minute * 10)
+	if len(released) != 2 {
+		t.Fatalf("unexpected number of released blocks - got %d, want 2",
+			len(released))
+	}
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// fuzzCheckBlockSanity runs the context free sanity checks against the block
+// decoded from the passed data using the main network parameters.  The checks
+// must never panic no matter how malformed the block is.  Data which fails to
+// decode results in 0 and blocks which fail the checks in 0 as well, while 1
+// is returned for blocks which pass them to tell the fuzzer they are
+// interesting.
+func fuzzCheckBlockSanity(data []byte) int {
+	var msgBlock wire.MsgBlock
+	if err := msgBlock.Deserialize(bytes.NewReader(data)); err != nil {
+		return 0
+	}
+
+	block := colxutil.NewBlock(&msgBlock)
+	err := CheckBlockSanity(block, &chaincfg.MainNetParams, NewMedianTime())
+	if err != nil {
+		return 0
+	}
+	return 1
+}
@@ -0,0 +1,16 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// This file is ignored during the regular build due to the following build tag.
+// The tag is set by go-fuzz-build.
+// +build gofuzz
+
+package blockchain
+
+// FuzzCheckBlockSanity is the go-fuzz entry point which checks that the context
+// free sanity checks of blocks never panic.
+func FuzzCheckBlockSanity(data []byte) int {
+	return fuzzCheckBlockSanity(data)
+}
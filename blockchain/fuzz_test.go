@@ -0,0 +1,92 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+)
+
+// fuzzCorpus is the directory the seed corpus of the go-fuzz entry point is
+// written to when it is set.  The seeds are written to the corpus subdirectory
+// of a directory named after the entry point, which is the layout of the
+// go-fuzz working directory.
+var fuzzCorpus = flag.String("fuzzcorpus", "", "write the seed corpus of "+
+	"the fuzz entry point to the passed directory")
+
+// fuzzBlockSeeds returns the serialized test blocks used to seed the fuzzing of
+// the block sanity checks.
+func fuzzBlockSeeds(t *testing.T) [][]byte {
+	var buf bytes.Buffer
+	if err := Block100000.Serialize(&buf); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	seeds := [][]byte{buf.Bytes()}
+
+	testFiles := []string{
+		"blk_0_to_4.dat.bz2",
+		"blk_3A.dat.bz2",
+		"blk_4A.dat.bz2",
+		"blk_5A.dat.bz2",
+	}
+	for _, file := range testFiles {
+		blocks, err := loadBlocks(file)
+		if err != nil {
+			t.Fatalf("Error loading file %s: %v", file, err)
+		}
+		for _, block := range blocks {
+			serialized, err := block.Bytes()
+			if err != nil {
+				t.Fatalf("Bytes: %v", err)
+			}
+			seeds = append(seeds, serialized)
+		}
+	}
+	return seeds
+}
+
+// TestFuzzCheckBlockSanity ensures the block sanity checks pass for the seed
+// block from the main network and never panic for the other seeds or for
+// truncated and corrupted variations of all of them.  It also writes the seed
+// corpus out when requested.
+func TestFuzzCheckBlockSanity(t *testing.T) {
+	seeds := fuzzBlockSeeds(t)
+	if got := blockchain.TstFuzzCheckBlockSanity(seeds[0]); got != 1 {
+		t.Errorf("block 100000 did not pass the sanity checks")
+	}
+
+	// Any panic fails the test, so only the variations are run here.
+	for _, seed := range seeds {
+		for n := 0; n < len(seed); n += 13 {
+			blockchain.TstFuzzCheckBlockSanity(seed[:n])
+
+			corrupted := append([]byte(nil), seed...)
+			corrupted[n] ^= 0xff
+			blockchain.TstFuzzCheckBlockSanity(corrupted)
+		}
+	}
+
+	if *fuzzCorpus == "" {
+		return
+	}
+	dir := filepath.Join(*fuzzCorpus, "FuzzCheckBlockSanity", "corpus")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatalf("unable to create corpus dir: %v", err)
+	}
+	for i, seed := range seeds {
+		name := filepath.Join(dir, fmt.Sprintf("block-%d", i))
+		if err := ioutil.WriteFile(name, seed, 0600); err != nil {
+			t.Fatalf("unable to write seed %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// BlockHeaderInfo houses the header of a block known to the chain along with
+// details about its position in the chain which are available without loading
+// the full block.
+type BlockHeaderInfo struct {
+	// Header is the header of the block.
+	Header wire.BlockHeader
+
+	// Height is the height of the block.
+	Height int32
+
+	// ChainWork is the total work of the chain which ends with the block.
+	ChainWork *big.Int
+
+	// MedianTime is the median time of the previous few blocks prior to,
+	// and including, the block.
+	MedianTime time.Time
+
+	// Confirmations is the number of main chain blocks which build on the
+	// block, including the block itself, or -1 when the block is not in
+	// the main chain.
+	Confirmations int64
+
+	// NextHash is the hash of the main chain block which extends the block.
+	// It is nil for the end of the main chain and side chain blocks.
+	NextHash *wire.ShaHash
+}
+
+// headerInfoNode returns the memory block node for the passed hash.  Main chain
+// blocks which are not in memory are loaded by walking back from the end of the
+// main chain, which links the nodes so their work sums are known.  It returns
+// nil when the block is neither in memory nor in the main chain.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) headerInfoNode(hash *wire.ShaHash) (*blockNode, error) {
+	if node, ok := b.index[*hash]; ok {
+		return node, nil
+	}
+
+	var height int32
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		height, err = dbFetchHeightByHash(dbTx, hash)
+		return err
+	})
+	if err != nil {
+		if isNotInMainChainErr(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return b.ancestorNode(b.bestNode, height)
+}
+
+// BlockHeaderInfo returns the header of the block with the passed hash, which
+// may be in the main chain or a side chain, along with its height, chain work,
+// median time, number of confirmations, and the hash of the next main chain
+// block.  The details are taken from the memory block index and the main chain
+// height index as of a single snapshot of the chain, so they are consistent with
+// each other.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) BlockHeaderInfo(hash *wire.ShaHash) (*BlockHeaderInfo, error) {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	node, err := b.headerInfoNode(hash)
+	if err != nil {
+		return nil, err
+	}
+	if node == nil {
+		return nil, fmt.Errorf("block %v is not known", hash)
+	}
+	medianTime, err := b.calcPastMedianTime(node)
+	if err != nil {
+		return nil, err
+	}
+
+	info := &BlockHeaderInfo{
+		Height:        node.height,
+		ChainWork:     new(big.Int).Set(node.workSum),
+		MedianTime:    medianTime,
+		Confirmations: -1,
+	}
+	if node.inMainChain {
+		info.Confirmations = int64(b.bestNode.height-node.height) + 1
+	}
+
+	// Side chain blocks are only held in memory, so their headers are not
+	// in the database.
+	if block, ok := b.blockCache[*hash]; ok {
+		info.Header = block.MsgBlock().Header
+	}
+	err = b.db.View(func(dbTx database.Tx) error {
+		if !node.inMainChain {
+			return nil
+		}
+		header, err := dbFetchHeaderByHash(dbTx, hash)
+		if err != nil {
+			return err
+		}
+		info.Header = *header
+
+		if node.height == b.bestNode.height {
+			return nil
+		}
+		info.NextHash, err = dbFetchHashByHeight(dbTx, node.height+1)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// SideChainBlock returns the side chain block with the passed hash.  Unlike
+// main chain blocks, side chain blocks are only held in memory until they
+// become part of the main chain, so they are not available from the database.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) SideChainBlock(hash *wire.ShaHash) (*colxutil.Block, error) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	block, ok := b.blockCache[*hash]
+	if !ok {
+		return nil, fmt.Errorf("block %v is not a known side chain "+
+			"block", hash)
+	}
+	return block, nil
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// lookupHeaderNode returns the node for the passed hash from either the index
+// of validated headers or the memory block chain, loading it from the database
+// when the block is known but not in memory.  It returns nil when neither the
+// header nor the block is known.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) lookupHeaderNode(hash *wire.ShaHash) (*blockNode, error) {
+	if node, ok := b.headerIndex[*hash]; ok {
+		return node, nil
+	}
+	if node, ok := b.index[*hash]; ok {
+		return node, nil
+	}
+
+	exists, err := b.blockExists(hash)
+	if err != nil || !exists {
+		return nil, err
+	}
+	var node *blockNode
+	err = b.db.View(func(dbTx database.Tx) error {
+		var err error
+		node, err = b.loadBlockNode(dbTx, hash)
+		return err
+	})
+	return node, err
+}
+
+// bestHeaderNode returns the node with the most cumulative work out of the
+// validated headers and the current best block.
+//
+// This function MUST be called with the chain state lock held (for reads).
+func (b *BlockChain) bestHeaderNode() *blockNode {
+	if b.bestHeader != nil && b.bestHeader.workSum.Cmp(b.bestNode.workSum) > 0 {
+		return b.bestHeader
+	}
+	return b.bestNode
+}
+
+// ProcessBlockHeaders validates the passed chain of block headers ahead of the
+// blocks they commit to and adds them to the header index.  This allows the
+// blocks to be downloaded out of order once their headers are known to form a
+// valid chain.
+//
+// The first header must connect to a known block or previously processed
+// header and each following header must connect to the one before it.  Every
+// header is checked for sane proof of work and timestamps along with the
+// difficulty retarget, median time, checkpoint, and version rules which depend
+// on its position in the chain.  Headers which are already known are skipped.
+// Processing stops at the first invalid header, however all of the headers
+// before it remain in the header index.
+//
+// Blocks whose headers are in the header index skip the header checks when
+// they are later processed by ProcessBlock.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ProcessBlockHeaders(headers []*wire.BlockHeader) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	if len(headers) == 0 {
+		return nil
+	}
+
+	prevNode, err := b.lookupHeaderNode(&headers[0].PrevBlock)
+	if err != nil {
+		return err
+	}
+	if prevNode == nil {
+		str := fmt.Sprintf("header %v references unknown previous "+
+			"block %v", headers[0].BlockSha(), headers[0].PrevBlock)
+		return ruleError(ErrMissingParent, str)
+	}
+
+	for _, header := range headers {
+		hash := header.BlockSha()
+		if !header.PrevBlock.IsEqual(prevNode.hash) {
+			str := fmt.Sprintf("header %v does not connect to the "+
+				"previous header %v", hash, prevNode.hash)
+			return ruleError(ErrMissingParent, str)
+		}
+
+		// Skip headers which are already known.
+		node, err := b.lookupHeaderNode(&hash)
+		if err != nil {
+			return err
+		}
+		if node != nil {
+			prevNode = node
+			continue
+		}
+
+		// Perform the context free and contextual header checks.
+		err = checkBlockHeaderSanity(header, b.chainParams.PowLimit,
+			b.timeSource, BFNone)
+		if err != nil {
+			return err
+		}
+		err = b.checkBlockHeaderContext(header, prevNode, BFNone)
+		if err != nil {
+			return err
+		}
+
+		// Add a node for the header to the header index.  The node is
+		// linked to its parent so the contextual checks of the headers
+		// which follow it can be performed, however it is not added as
+		// a child since there is no block for it yet.
+		node = newBlockNode(header, &hash, prevNode.height+1)
+		node.parent = prevNode
+		node.workSum.Add(prevNode.workSum, node.workSum)
+		if _, err := b.calcPastMedianTime(node); err != nil {
+			return err
+		}
+		b.headerIndex[hash] = node
+		if node.workSum.Cmp(b.bestHeaderNode().workSum) > 0 {
+			b.bestHeader = node
+		}
+		prevNode = node
+	}
+
+	log.Debugf("Processed %d headers (best header %v, height %d)",
+		len(headers), b.bestHeaderNode().hash,
+		b.bestHeaderNode().height)
+
+	return nil
+}
+
+// HaveHeader returns whether or not the header identified by the passed hash
+// has been validated by ProcessBlockHeaders without its block being connected
+// yet.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) HaveHeader(hash *wire.ShaHash) bool {
+	b.chainLock.RLock()
+	_, ok := b.headerIndex[*hash]
+	b.chainLock.RUnlock()
+	return ok
+}
+
+// BestHeader returns the hash and height of the header with the most
+// cumulative work which is either the tip of the headers validated by
+// ProcessBlockHeaders or the current best block when no header is ahead of it.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) BestHeader() (*wire.ShaHash, int32) {
+	b.chainLock.RLock()
+	node := b.bestHeaderNode()
+	b.chainLock.RUnlock()
+	return node.hash, node.height
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// blockHeaders returns the headers of the passed blocks.
+func blockHeaders(blocks []*colxutil.Block) []*wire.BlockHeader {
+	headers := make([]*wire.BlockHeader, 0, len(blocks))
+	for _, block := range blocks {
+		header := block.MsgBlock().Header
+		headers = append(headers, &header)
+	}
+	return headers
+}
+
+// assertBestHeader ensures the best header of the passed chain is the passed
+// block at the given height.
+func assertBestHeader(t *testing.T, chain *blockchain.BlockChain, block *colxutil.Block, height int32) {
+	hash, gotHeight := chain.BestHeader()
+	if !hash.IsEqual(block.Sha()) || gotHeight != height {
+		t.Fatalf("unexpected best header - got %v (height %d), want "+
+			"%v (height %d)", hash, gotHeight, block.Sha(), height)
+	}
+}
+
+// TestProcessBlockHeaders ensures a valid chain of headers is accepted ahead of
+// the blocks, that the blocks can then be processed in any order, and that the
+// header index no longer tracks them once they are connected.
+func TestProcessBlockHeaders(t *testing.T) {
+	const numBlocks = 20
+
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, numBlocks)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("processheaders", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Headers which do not connect to a known block must be rejected.
+	headers := blockHeaders(blocks)
+	err = chain.ProcessBlockHeaders(headers[1:])
+	if rerr, ok := err.(blockchain.RuleError); !ok ||
+		rerr.ErrorCode != blockchain.ErrMissingParent {
+		t.Fatalf("ProcessBlockHeaders: expected ErrMissingParent for "+
+			"unconnected headers - got %v", err)
+	}
+
+	// Headers which do not form a chain must be rejected.
+	unordered := []*wire.BlockHeader{headers[0], headers[2]}
+	err = chain.ProcessBlockHeaders(unordered)
+	if rerr, ok := err.(blockchain.RuleError); !ok ||
+		rerr.ErrorCode != blockchain.ErrMissingParent {
+		t.Fatalf("ProcessBlockHeaders: expected ErrMissingParent for "+
+			"headers which do not form a chain - got %v", err)
+	}
+
+	// Process the headers in two batches where the second one overlaps the
+	// first to ensure known headers are skipped.
+	if err := chain.ProcessBlockHeaders(headers[:10]); err != nil {
+		t.Fatalf("ProcessBlockHeaders: unexpected error: %v", err)
+	}
+	if err := chain.ProcessBlockHeaders(headers[5:]); err != nil {
+		t.Fatalf("ProcessBlockHeaders: unexpected error: %v", err)
+	}
+	assertBestHeader(t, chain, blocks[numBlocks-1], numBlocks)
+	for i, block := range blocks {
+		if !chain.HaveHeader(block.Sha()) {
+			t.Fatalf("header %d is not in the header index", i+1)
+		}
+	}
+	if best := chain.BestSnapshot(); best.Height != 0 {
+		t.Fatalf("best block height changed to %d by headers",
+			best.Height)
+	}
+
+	// Process the blocks in reverse order as though they were downloaded
+	// out of order.  All but the first one will be orphans until the first
+	// one connects them.
+	for i := numBlocks - 1; i >= 0; i-- {
+		isOrphan, err := chain.ProcessBlock(blocks[i], blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error for block %d: "+
+				"%v", i+1, err)
+		}
+		if isOrphan != (i != 0) {
+			t.Fatalf("ProcessBlock: unexpected orphan status for "+
+				"block %d - got %v", i+1, isOrphan)
+		}
+	}
+
+	best := chain.BestSnapshot()
+	if best.Height != numBlocks || !best.Hash.IsEqual(blocks[numBlocks-1].Sha()) {
+		t.Fatalf("unexpected best block - got %v (height %d)",
+			best.Hash, best.Height)
+	}
+	for i, block := range blocks {
+		if chain.HaveHeader(block.Sha()) {
+			t.Fatalf("header %d is still in the header index after "+
+				"its block was connected", i+1)
+		}
+	}
+	assertBestHeader(t, chain, blocks[numBlocks-1], numBlocks)
+}
+
+// TestProcessBlockHeadersBadPoW ensures a header with insufficient proof of
+// work in the middle of a chain of headers is rejected along with the headers
+// after it while the ones before it are kept.
+func TestProcessBlockHeadersBadPoW(t *testing.T) {
+	const numBlocks = 20
+	const badIndex = 9
+
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, numBlocks)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("headersbadpow", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Change the nonce of the header until it no longer hashes to a value
+	// below the target.
+	headers := blockHeaders(blocks)
+	badHeader := headers[badIndex]
+	target := blockchain.CompactToBig(badHeader.Bits)
+	for {
+		badHeader.Nonce++
+		hash := badHeader.BlockSha()
+		if blockchain.ShaHashToBig(&hash).Cmp(target) > 0 {
+			break
+		}
+	}
+
+	err = chain.ProcessBlockHeaders(headers)
+	if rerr, ok := err.(blockchain.RuleError); !ok ||
+		rerr.ErrorCode != blockchain.ErrHighHash {
+		t.Fatalf("ProcessBlockHeaders: expected ErrHighHash - got %v",
+			err)
+	}
+	assertBestHeader(t, chain, blocks[badIndex-1], badIndex)
+	for i, block := range blocks {
+		if chain.HaveHeader(block.Sha()) != (i < badIndex) {
+			t.Fatalf("unexpected header index status for header %d",
+				i+1)
+		}
+	}
+}
+
+// TestProcessBlockHeadersFork ensures a chain of headers which forks from the
+// current chain of headers becomes the best header once it has more work.
+func TestProcessBlockHeadersFork(t *testing.T) {
+	const numBlocks = 10
+	const forkHeight = 5
+
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, numBlocks)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+	forkParent := &blocks[forkHeight-1].MsgBlock().Header
+	forkBlocks, err := generateChainFrom(params, forkParent, forkHeight,
+		numBlocks-forkHeight+2, 1)
+	if err != nil {
+		t.Fatalf("unable to generate fork: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("headersfork", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	if err := chain.ProcessBlockHeaders(blockHeaders(blocks)); err != nil {
+		t.Fatalf("ProcessBlockHeaders: unexpected error: %v", err)
+	}
+	assertBestHeader(t, chain, blocks[numBlocks-1], numBlocks)
+
+	// A fork with the same amount of work must not replace the best header.
+	forkHeaders := blockHeaders(forkBlocks)
+	sameWork := numBlocks - forkHeight
+	if err := chain.ProcessBlockHeaders(forkHeaders[:sameWork]); err != nil {
+		t.Fatalf("ProcessBlockHeaders: unexpected error: %v", err)
+	}
+	assertBestHeader(t, chain, blocks[numBlocks-1], numBlocks)
+
+	// The fork must become the best header once it has more work.
+	if err := chain.ProcessBlockHeaders(forkHeaders[sameWork:]); err != nil {
+		t.Fatalf("ProcessBlockHeaders: unexpected error: %v", err)
+	}
+	assertBestHeader(t, chain, forkBlocks[len(forkBlocks)-1],
+		numBlocks+2)
+}
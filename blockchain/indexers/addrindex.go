@@ -73,8 +73,11 @@ var (
 // according to their order of appearance in the blockchain.  That is to say
 // first by block height and then by offset inside the block.  It is also
 // important to note that this implementation requires the transaction index
-// since it is needed in order to catch up old blocks due to the fact the spent
-// outputs will already be pruned from the utxo set.
+// since it refers to blocks by the IDs the transaction index assigns and it is
+// needed in order to remove blocks which are no longer in the main chain due to
+// the fact the spent outputs are neither in the utxo set nor the spend journal
+// anymore.  The spent outputs of old blocks are loaded from the spend journal
+// when catching up.
 //
 // The approach used to store the index is similar to a log-structured merge
 // tree (LSM tree) and is thus similar to how leveldb works internally.
@@ -246,10 +249,12 @@ func dbFetchAddrIndexEntries(bucket internalBucket, addrKey [addrKeySize]byte, n
 	// because numToSkip and numRequested are counted from the oldest
 	// transactions (highest level) and thus the total count is needed.
 	// However, when the reverse flag is set, only enough records to satisfy
-	// the requested amount are needed.
+	// the requested amount are needed.  The number of needed bytes is
+	// calculated with 64 bits so large requests can't overflow it.
+	neededBytes := (uint64(numToSkip) + uint64(numRequested)) * txEntrySize
 	var level uint8
 	var serialized []byte
-	for !reverse || len(serialized) < int(numToSkip+numRequested)*txEntrySize {
+	for !reverse || uint64(len(serialized)) < neededBytes {
 		curLevelKey := keyForLevel(addrKey, level)
 		levelData := bucket.Get(curLevelKey[:])
 		if levelData == nil {
@@ -662,32 +667,31 @@ func (idx *AddrIndex) indexPkScript(data writeIndexData, pkScript []byte, txIdx
 // indexBlock extract all of the standard addresses from all of the transactions
 // in the passed block and maps each of them to the assocaited transaction using
 // the passed map.
-func (idx *AddrIndex) indexBlock(data writeIndexData, block *colxutil.Block, view *blockchain.UtxoViewpoint) {
-	for txIdx, tx := range block.Transactions() {
-		// Coinbases do not reference any inputs.  Since the block is
-		// required to have already gone through full validation, it has
-		// already been proven on the first transaction in the block is
-		// a coinbase.
-		if txIdx != 0 {
-			for _, txIn := range tx.MsgTx().TxIn {
-				// The view should always have the input since
-				// the index contract requires it, however, be
-				// safe and simply ignore any missing entries.
-				origin := &txIn.PreviousOutPoint
-				entry := view.LookupEntry(&origin.Hash)
-				if entry == nil {
-					continue
-				}
-
-				pkScript := entry.PkScriptByIndex(origin.Index)
-				idx.indexPkScript(data, pkScript, txIdx)
+func (idx *AddrIndex) indexBlock(data writeIndexData, block *colxutil.Block, view *blockchain.UtxoViewpoint) error {
+	blockCtx, err := blockchain.NewBlockContext(block, view)
+	if err != nil {
+		return err
+	}
+	for txIdx := 0; txIdx < blockCtx.NumTxns(); txIdx++ {
+		// The view should always have the inputs since the index
+		// contract requires it, however, be safe and simply ignore any
+		// missing entries, which have no previous script.  Coinbases
+		// do not reference any inputs either.
+		txCtx := blockCtx.Tx(txIdx)
+		msgTx := txCtx.Tx().MsgTx()
+		for txInIdx := range msgTx.TxIn {
+			pkScript := txCtx.PrevScript(txInIdx)
+			if pkScript == nil {
+				continue
 			}
+			idx.indexPkScript(data, pkScript, txIdx)
 		}
 
-		for _, txOut := range tx.MsgTx().TxOut {
+		for _, txOut := range msgTx.TxOut {
 			idx.indexPkScript(data, txOut.PkScript, txIdx)
 		}
 	}
+	return nil
 }
 
 // ConnectBlock is invoked by the index manager when a new block has been
@@ -711,7 +715,9 @@ func (idx *AddrIndex) ConnectBlock(dbTx database.Tx, block *colxutil.Block, view
 
 	// Build all of the address to transaction mappings in a local map.
 	addrsToTxns := make(writeIndexData)
-	idx.indexBlock(addrsToTxns, block, view)
+	if err := idx.indexBlock(addrsToTxns, block, view); err != nil {
+		return err
+	}
 
 	// Add all of the index entries for each address.
 	addrIdxBucket := dbTx.Metadata().Bucket(addrIndexKey)
@@ -736,7 +742,9 @@ func (idx *AddrIndex) ConnectBlock(dbTx database.Tx, block *colxutil.Block, view
 func (idx *AddrIndex) DisconnectBlock(dbTx database.Tx, block *colxutil.Block, view *blockchain.UtxoViewpoint) error {
 	// Build all of the address to transaction mappings in a local map.
 	addrsToTxns := make(writeIndexData)
-	idx.indexBlock(addrsToTxns, block, view)
+	if err := idx.indexBlock(addrsToTxns, block, view); err != nil {
+		return err
+	}
 
 	// Remove all of the index entries for each address.
 	bucket := dbTx.Metadata().Bucket(addrIndexKey)
@@ -756,6 +764,12 @@ func (idx *AddrIndex) DisconnectBlock(dbTx database.Tx, block *colxutil.Block, v
 // reversed.  It also returns the number actually skipped since it could be less
 // in the case where there are not enough entries.
 //
+// The lookups are performed with the passed database transaction, so the
+// returned regions are consistent with any other data the caller loads with
+// it, such as the transactions they identify.  Paging through the results
+// newest first is done by setting the reverse flag and increasing the number to
+// skip by the number of results of the previous page.
+//
 // NOTE: These results only include transactions confirmed in blocks.  See the
 // UnconfirmedTxnsForAddress method for obtaining unconfirmed transactions
 // that involve a given address.
@@ -767,25 +781,15 @@ func (idx *AddrIndex) TxRegionsForAddress(dbTx database.Tx, addr colxutil.Addres
 		return nil, 0, err
 	}
 
-	var regions []database.BlockRegion
-	var skipped uint32
-	err = idx.db.View(func(dbTx database.Tx) error {
-		// Create closure to lookup the block hash given the ID using
-		// the database transaction.
-		fetchBlockHash := func(id []byte) (*wire.ShaHash, error) {
-			// Deserialize and populate the result.
-			return dbFetchBlockHashBySerializedID(dbTx, id)
-		}
-
-		var err error
-		addrIdxBucket := dbTx.Metadata().Bucket(addrIndexKey)
-		regions, skipped, err = dbFetchAddrIndexEntries(addrIdxBucket,
-			addrKey, numToSkip, numRequested, reverse,
-			fetchBlockHash)
-		return err
-	})
+	// Create closure to lookup the block hash given the ID using the
+	// database transaction.
+	fetchBlockHash := func(id []byte) (*wire.ShaHash, error) {
+		return dbFetchBlockHashBySerializedID(dbTx, id)
+	}
 
-	return regions, skipped, err
+	addrIdxBucket := dbTx.Metadata().Bucket(addrIndexKey)
+	return dbFetchAddrIndexEntries(addrIdxBucket, addrKey, numToSkip,
+		numRequested, reverse, fetchBlockHash)
 }
 
 // indexUnconfirmedAddresses modifies the unconfirmed (memory-only) address
@@ -927,7 +931,8 @@ func NewAddrIndex(db database.DB, chainParams *chaincfg.Params) *AddrIndex {
 }
 
 // DropAddrIndex drops the address index from the provided database if it
-// exists.
-func DropAddrIndex(db database.DB) error {
-	return dropIndex(db, addrIndexKey, addrIndexName)
+// exists.  The drop is stopped when the passed interrupt channel is closed and
+// resumed the next time the index is dropped or enabled.
+func DropAddrIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, addrIndexKey, addrIndexName, interrupt)
 }
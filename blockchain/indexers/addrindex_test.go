@@ -8,9 +8,17 @@ package indexers
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/txscript"
 	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
 )
 
 // addrIndexBucket provides a mock address index database bucket by implementing
@@ -274,3 +282,337 @@ nextTest:
 		}
 	}
 }
+
+// testFetchBlockHash is a block hash fetching function for the tests which
+// returns a hash that starts with the serialized block ID.
+func testFetchBlockHash(serializedID []byte) (*wire.ShaHash, error) {
+	var hash wire.ShaHash
+	copy(hash[:], serializedID)
+	return &hash, nil
+}
+
+// TestAddrIndexEntrySerialization ensures address index entries round trip
+// through serialization and that truncated entries are detected.
+func TestAddrIndexEntrySerialization(t *testing.T) {
+	t.Parallel()
+
+	txLoc := wire.TxLoc{TxStart: 0x01020304, TxLen: 0x0a0b0c0d}
+	serialized := serializeAddrIndexEntry(0x11223344, txLoc)
+	want := []byte{
+		0x44, 0x33, 0x22, 0x11, // block id
+		0x04, 0x03, 0x02, 0x01, // start offset
+		0x0d, 0x0c, 0x0b, 0x0a, // tx length
+	}
+	if !bytes.Equal(serialized, want) {
+		t.Fatalf("serializeAddrIndexEntry: unexpected entry - got %x, "+
+			"want %x", serialized, want)
+	}
+
+	var region database.BlockRegion
+	err := deserializeAddrIndexEntry(serialized, &region,
+		testFetchBlockHash)
+	if err != nil {
+		t.Fatalf("deserializeAddrIndexEntry: unexpected error: %v", err)
+	}
+	wantHash, _ := testFetchBlockHash(serialized[:4])
+	if !region.Hash.IsEqual(wantHash) ||
+		region.Offset != uint32(txLoc.TxStart) ||
+		region.Len != uint32(txLoc.TxLen) {
+
+		t.Fatalf("deserializeAddrIndexEntry: unexpected region %+v",
+			region)
+	}
+
+	err = deserializeAddrIndexEntry(serialized[:txEntrySize-1], &region,
+		testFetchBlockHash)
+	if !isDeserializeErr(err) {
+		t.Fatalf("deserializeAddrIndexEntry: unexpected error for "+
+			"truncated entry - got %v, want errDeserialize", err)
+	}
+}
+
+// TestAddrIndexPagination ensures fetching address index entries returns the
+// expected entries in the expected order along with the number of skipped
+// entries at the boundaries of the requested ranges.
+func TestAddrIndexPagination(t *testing.T) {
+	t.Parallel()
+
+	// Populate several levels with entries whose block ID and offset are
+	// the order they were inserted in.
+	const numEntries = level0MaxEntries*3 + 3
+	var key [addrKeySize]byte
+	bucket := &addrIndexBucket{
+		levels: make(map[[levelKeySize]byte][]byte),
+	}
+	for i := 0; i < numEntries; i++ {
+		err := dbPutAddrIndexEntry(bucket, key, uint32(i),
+			wire.TxLoc{TxStart: i})
+		if err != nil {
+			t.Fatalf("dbPutAddrIndexEntry: unexpected error: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		numToSkip    uint32
+		numRequested uint32
+		reverse      bool
+		want         []uint32 // offsets of the expected entries
+		wantSkipped  uint32
+	}{
+		{
+			name:         "oldest first",
+			numRequested: 3,
+			want:         []uint32{0, 1, 2},
+		},
+		{
+			name:         "newest first",
+			numRequested: 3,
+			reverse:      true,
+			want:         []uint32{26, 25, 24},
+		},
+		{
+			name:         "oldest first across levels",
+			numToSkip:    7,
+			numRequested: 3,
+			want:         []uint32{7, 8, 9},
+			wantSkipped:  7,
+		},
+		{
+			name:         "newest first across levels",
+			numToSkip:    2,
+			numRequested: 3,
+			reverse:      true,
+			want:         []uint32{24, 23, 22},
+			wantSkipped:  2,
+		},
+		{
+			name:         "last entry oldest first",
+			numToSkip:    numEntries - 1,
+			numRequested: 5,
+			want:         []uint32{26},
+			wantSkipped:  numEntries - 1,
+		},
+		{
+			name:         "last entry newest first",
+			numToSkip:    numEntries - 1,
+			numRequested: 5,
+			reverse:      true,
+			want:         []uint32{0},
+			wantSkipped:  numEntries - 1,
+		},
+		{
+			name:         "skip all",
+			numToSkip:    numEntries,
+			numRequested: 5,
+			reverse:      true,
+			wantSkipped:  numEntries,
+		},
+		{
+			name:         "skip more than available",
+			numToSkip:    numEntries + 10,
+			numRequested: 5,
+			wantSkipped:  numEntries,
+		},
+		{
+			name:        "none requested",
+			numToSkip:   3,
+			reverse:     true,
+			wantSkipped: 3,
+		},
+		{
+			name:         "request overflowing the needed size",
+			numToSkip:    1,
+			numRequested: math.MaxUint32,
+			reverse:      true,
+			want: []uint32{25, 24, 23, 22, 21, 20, 19, 18, 17, 16,
+				15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 3, 2,
+				1, 0},
+			wantSkipped: 1,
+		},
+	}
+
+	for _, test := range tests {
+		regions, skipped, err := dbFetchAddrIndexEntries(bucket, key,
+			test.numToSkip, test.numRequested, test.reverse,
+			testFetchBlockHash)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if skipped != test.wantSkipped {
+			t.Errorf("%s: unexpected number skipped - got %d, "+
+				"want %d", test.name, skipped, test.wantSkipped)
+		}
+		var got []uint32
+		for _, region := range regions {
+			// The block ID the hash starts with must match the
+			// offset of the entry.
+			blockID := byteOrder.Uint32(region.Hash[:])
+			if blockID != region.Offset {
+				t.Errorf("%s: unexpected region %+v", test.name,
+					region)
+			}
+			got = append(got, region.Offset)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: unexpected entries - got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+
+	// Ensure paging through all entries newest first returns every entry
+	// exactly once in order.
+	const pageSize = 5
+	var paged []uint32
+	for {
+		regions, _, err := dbFetchAddrIndexEntries(bucket, key,
+			uint32(len(paged)), pageSize, true, testFetchBlockHash)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(regions) == 0 {
+			break
+		}
+		for _, region := range regions {
+			paged = append(paged, region.Offset)
+		}
+	}
+	if len(paged) != numEntries {
+		t.Fatalf("unexpected number of paged entries - got %d, want %d",
+			len(paged), numEntries)
+	}
+	for i, offset := range paged {
+		if offset != uint32(numEntries-1-i) {
+			t.Fatalf("unexpected paged entries %v", paged)
+		}
+	}
+}
+
+// TestAddrIndexBlockEntries ensures the entries indexed for a block contain a
+// single entry per transaction and address, even when a transaction involves
+// the same address several times, so disconnecting the block removes exactly
+// the entries connecting it added.
+func TestAddrIndexBlockEntries(t *testing.T) {
+	t.Parallel()
+
+	params := &chaincfg.RegressionNetParams
+	newAddr := func(b byte) (colxutil.Address, []byte) {
+		addr, err := colxutil.NewAddressPubKeyHash(
+			bytes.Repeat([]byte{b}, 20), params)
+		if err != nil {
+			t.Fatalf("NewAddressPubKeyHash: unexpected error: %v", err)
+		}
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			t.Fatalf("PayToAddrScript: unexpected error: %v", err)
+		}
+		return addr, pkScript
+	}
+	addrA, scriptA := newAddr(0x0a)
+	addrB, scriptB := newAddr(0x0b)
+
+	// Create a block with a coinbase which pays the first address twice
+	// and a transaction which spends an output paying the first address
+	// and pays both addresses, the first one twice.
+	fundingTx := wire.NewMsgTx()
+	fundingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil))
+	fundingTx.AddTxOut(wire.NewTxOut(100, scriptA))
+	funding := colxutil.NewTx(fundingTx)
+	view := blockchain.NewUtxoViewpoint()
+	view.AddTxOuts(funding, 1)
+
+	coinbaseTx := wire.NewMsgTx()
+	coinbaseTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&wire.ShaHash{},
+		math.MaxUint32), []byte{0x51, 0x51}))
+	coinbaseTx.AddTxOut(wire.NewTxOut(1, scriptA))
+	coinbaseTx.AddTxOut(wire.NewTxOut(1, scriptA))
+	spendTx := wire.NewMsgTx()
+	spendTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(funding.Sha(), 0), nil))
+	spendTx.AddTxOut(wire.NewTxOut(10, scriptA))
+	spendTx.AddTxOut(wire.NewTxOut(10, scriptB))
+	spendTx.AddTxOut(wire.NewTxOut(10, scriptA))
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{
+		Timestamp: time.Unix(1401292357, 0),
+	})
+	msgBlock.AddTransaction(coinbaseTx)
+	msgBlock.AddTransaction(spendTx)
+	block := colxutil.NewBlock(msgBlock)
+
+	idx := &AddrIndex{chainParams: params}
+	data := make(writeIndexData)
+	if err := idx.indexBlock(data, block, view); err != nil {
+		t.Fatalf("indexBlock: unexpected error: %v", err)
+	}
+
+	keyA, _ := addrToKey(addrA)
+	keyB, _ := addrToKey(addrB)
+	want := writeIndexData{keyA: {0, 1}, keyB: {1}}
+	if !reflect.DeepEqual(data, want) {
+		t.Fatalf("indexBlock: unexpected entries - got %v, want %v",
+			data, want)
+	}
+
+	// Add the entries for the block on top of existing entries like
+	// connecting it does and ensure removing the number of entries per
+	// address like disconnecting it does restores the previous state.
+	bucket := &addrIndexBucket{
+		levels: make(map[[levelKeySize]byte][]byte),
+	}
+	for i := 0; i < level0MaxEntries-1; i++ {
+		err := dbPutAddrIndexEntry(bucket, keyA, uint32(i),
+			wire.TxLoc{TxStart: i})
+		if err != nil {
+			t.Fatalf("dbPutAddrIndexEntry: unexpected error: %v", err)
+		}
+	}
+	before := bucket.Clone()
+	txLocs, err := block.TxLoc()
+	if err != nil {
+		t.Fatalf("TxLoc: unexpected error: %v", err)
+	}
+	for addrKey, txIdxs := range data {
+		for _, txIdx := range txIdxs {
+			err := dbPutAddrIndexEntry(bucket, addrKey,
+				level0MaxEntries, txLocs[txIdx])
+			if err != nil {
+				t.Fatalf("dbPutAddrIndexEntry: unexpected "+
+					"error: %v", err)
+			}
+		}
+	}
+	for addrKey, want := range map[[addrKeySize]byte]int{
+		keyA: level0MaxEntries + 1,
+		keyB: 1,
+	} {
+		regions, _, err := dbFetchAddrIndexEntries(bucket, addrKey, 0,
+			math.MaxUint32, false, testFetchBlockHash)
+		if err != nil {
+			t.Fatalf("dbFetchAddrIndexEntries: unexpected error: %v",
+				err)
+		}
+		if len(regions) != want {
+			t.Fatalf("unexpected number of entries for %x - got %d, "+
+				"want %d", addrKey, len(regions), want)
+		}
+	}
+	for addrKey, txIdxs := range data {
+		err := dbRemoveAddrIndexEntries(bucket, addrKey, len(txIdxs))
+		if err != nil {
+			t.Fatalf("dbRemoveAddrIndexEntries: unexpected error: %v",
+				err)
+		}
+	}
+	for key, want := range before.levels {
+		if got := bucket.levels[key]; !bytes.Equal(got, want) {
+			t.Fatalf("level %x: unexpected entries after removing "+
+				"the block - got %x, want %x", key, got, want)
+		}
+	}
+	for key, got := range bucket.levels {
+		if _, ok := before.levels[key]; !ok && len(got) != 0 {
+			t.Fatalf("level %x: unexpected entries %x after "+
+				"removing the block", key, got)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package indexers
+
+import (
+	"fmt"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxd/wire/gcs"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+const (
+	// cfIndexName is the human-readable name for the index.
+	cfIndexName = "committed filter index"
+
+	// cfEntryHeaderSize is the number of bytes the filter header and the
+	// filter hash consume at the start of an entry of the index.
+	cfEntryHeaderSize = wire.HashSize * 2
+)
+
+var (
+	// cfIndexKey is the key of the committed filter index and the db
+	// bucket used to house it.
+	cfIndexKey = []byte("cfbasicbyhashidx")
+)
+
+// -----------------------------------------------------------------------------
+// The committed filter index maps the hash of every block in the main chain to
+// its basic filter as defined by BIP0158, which contains the public key scripts
+// of the outputs of the block and of the previous outputs spent by it.  Light
+// clients match the scripts they are interested in against the filters in order
+// to determine which blocks they need to download.
+//
+// Along with the filter, each entry stores the hash of the filter and the
+// filter header, which commits to the filter hash and the header of the filter
+// of the previous block.  The filter header of the genesis block commits to a
+// previous header of all zeros.
+//
+// The serialized value format is:
+//
+//   <filter header><filter hash><filter>
+//
+//   Field           Type        Size
+//   filter header   [32]byte    32
+//   filter hash     [32]byte    32
+//   filter          []byte      variable
+// -----------------------------------------------------------------------------
+
+// cfIndexEntry describes an entry of the committed filter index.
+type cfIndexEntry struct {
+	header     wire.ShaHash
+	filterHash wire.ShaHash
+	filter     []byte
+}
+
+// serializeCfIndexEntry returns the passed entry serialized for storage in the
+// index.
+func serializeCfIndexEntry(entry *cfIndexEntry) []byte {
+	serialized := make([]byte, cfEntryHeaderSize+len(entry.filter))
+	copy(serialized, entry.header[:])
+	copy(serialized[wire.HashSize:], entry.filterHash[:])
+	copy(serialized[cfEntryHeaderSize:], entry.filter)
+	return serialized
+}
+
+// deserializeCfIndexEntry decodes the passed serialized entry of the index.
+func deserializeCfIndexEntry(serialized []byte) (*cfIndexEntry, error) {
+	if len(serialized) < cfEntryHeaderSize {
+		return nil, errDeserialize("unexpected end of data")
+	}
+
+	var entry cfIndexEntry
+	copy(entry.header[:], serialized)
+	copy(entry.filterHash[:], serialized[wire.HashSize:])
+	entry.filter = make([]byte, len(serialized)-cfEntryHeaderSize)
+	copy(entry.filter, serialized[cfEntryHeaderSize:])
+	return &entry, nil
+}
+
+// dbFetchCfIndexEntry returns the entry of the committed filter index for the
+// block with the passed hash.  When there is no entry for the provided hash,
+// nil will be returned for both the entry and the error.
+func dbFetchCfIndexEntry(dbTx database.Tx, blockHash *wire.ShaHash) (*cfIndexEntry, error) {
+	serialized := dbTx.Metadata().Bucket(cfIndexKey).Get(blockHash[:])
+	if serialized == nil {
+		return nil, nil
+	}
+	entry, err := deserializeCfIndexEntry(serialized)
+	if err != nil {
+		return nil, database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt committed filter "+
+				"entry for %s: %v", blockHash, err),
+		}
+	}
+	return entry, nil
+}
+
+// CfIndex implements an index of the BIP0158 basic filters of the blocks in the
+// main chain along with the chain of their filter headers.
+type CfIndex struct {
+	db          database.DB
+	chainParams *chaincfg.Params
+}
+
+// Ensure the CfIndex type implements the Indexer interface.
+var _ Indexer = (*CfIndex)(nil)
+
+// Ensure the CfIndex type implements the NeedsInputser interface.
+var _ NeedsInputser = (*CfIndex)(nil)
+
+// NeedsInputs signals that the index requires the referenced inputs in order
+// to properly create the index.
+//
+// This implements the NeedsInputser interface.
+func (idx *CfIndex) NeedsInputs() bool {
+	return true
+}
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) Init() error {
+	// Nothing to do.
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) Key() []byte {
+	return cfIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) Name() string {
+	return cfIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the index.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) Create(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucket(cfIndexKey)
+	return err
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer builds the basic filter of the
+// block from its outputs and the previous outputs in the passed view, and stores
+// it along with its hash and its header, which is chained to the filter header
+// of the parent block.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) ConnectBlock(dbTx database.Tx, block *colxutil.Block, view *blockchain.UtxoViewpoint) error {
+	blockCtx, err := blockchain.NewBlockContext(block, view)
+	if err != nil {
+		return err
+	}
+	var prevScripts [][]byte
+	for txIdx := 0; txIdx < blockCtx.NumTxns(); txIdx++ {
+		txCtx := blockCtx.Tx(txIdx)
+		if txCtx.IsCoinBase() {
+			continue
+		}
+		if txCtx.HasMissingInputs() {
+			return AssertError(fmt.Sprintf("missing input for "+
+				"transaction %v", txCtx.Tx().Sha()))
+		}
+		for txInIdx := range txCtx.Tx().MsgTx().TxIn {
+			prevScripts = append(prevScripts,
+				txCtx.PrevScript(txInIdx))
+		}
+	}
+
+	filter, err := gcs.BuildBasicFilter(block.MsgBlock(), prevScripts)
+	if err != nil {
+		return err
+	}
+
+	// The filter header of the genesis block commits to a previous header
+	// of all zeros.  Every other block must have its parent indexed.
+	var prevHeader wire.ShaHash
+	if block.Height() != 0 {
+		parentHash := &block.MsgBlock().Header.PrevBlock
+		parent, err := dbFetchCfIndexEntry(dbTx, parentHash)
+		if err != nil {
+			return err
+		}
+		if parent == nil {
+			return AssertError(fmt.Sprintf("missing filter header "+
+				"for parent %v of block %v", parentHash,
+				block.Sha()))
+		}
+		prevHeader = parent.header
+	}
+
+	entry := cfIndexEntry{
+		filterHash: gcs.FilterHash(filter),
+		filter:     filter.NBytes(),
+	}
+	entry.header = gcs.MakeHeaderForFilterHash(&entry.filterHash,
+		&prevHeader)
+	return dbTx.Metadata().Bucket(cfIndexKey).Put(block.Sha()[:],
+		serializeCfIndexEntry(&entry))
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the filter of the
+// block.
+//
+// This is part of the Indexer interface.
+func (idx *CfIndex) DisconnectBlock(dbTx database.Tx, block *colxutil.Block, view *blockchain.UtxoViewpoint) error {
+	return dbTx.Metadata().Bucket(cfIndexKey).Delete(block.Sha()[:])
+}
+
+// fetchEntry returns the entry of the index for the block with the passed hash
+// or nil when there is none.
+func (idx *CfIndex) fetchEntry(blockHash *wire.ShaHash) (*cfIndexEntry, error) {
+	var entry *cfIndexEntry
+	err := idx.db.View(func(dbTx database.Tx) error {
+		var err error
+		entry, err = dbFetchCfIndexEntry(dbTx, blockHash)
+		return err
+	})
+	return entry, err
+}
+
+// FilterByBlockHash returns the serialized basic filter of the block with the
+// passed hash as defined by BIP0158.  It can be deserialized with
+// gcs.FromNBytes.  When there is no entry for the provided hash, nil will be
+// returned for both the filter and the error.
+//
+// This function is safe for concurrent access.
+func (idx *CfIndex) FilterByBlockHash(blockHash *wire.ShaHash) ([]byte, error) {
+	entry, err := idx.fetchEntry(blockHash)
+	if entry == nil || err != nil {
+		return nil, err
+	}
+	return entry.filter, nil
+}
+
+// FilterHashByBlockHash returns the hash of the basic filter of the block with
+// the passed hash.  When there is no entry for the provided hash, nil will be
+// returned for both the hash and the error.
+//
+// This function is safe for concurrent access.
+func (idx *CfIndex) FilterHashByBlockHash(blockHash *wire.ShaHash) (*wire.ShaHash, error) {
+	entry, err := idx.fetchEntry(blockHash)
+	if entry == nil || err != nil {
+		return nil, err
+	}
+	return &entry.filterHash, nil
+}
+
+// FilterHeaderByBlockHash returns the header of the basic filter of the block
+// with the passed hash, which commits to the filter and to the filter headers
+// of all of its ancestors.  When there is no entry for the provided hash, nil
+// will be returned for both the header and the error.
+//
+// This function is safe for concurrent access.
+func (idx *CfIndex) FilterHeaderByBlockHash(blockHash *wire.ShaHash) (*wire.ShaHash, error) {
+	entry, err := idx.fetchEntry(blockHash)
+	if entry == nil || err != nil {
+		return nil, err
+	}
+	return &entry.header, nil
+}
+
+// NewCfIndex returns a new instance of an indexer that is used to create a
+// mapping of the hashes of all blocks in the main chain to their BIP0158 basic
+// filters and filter headers.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewCfIndex(db database.DB, chainParams *chaincfg.Params) *CfIndex {
+	return &CfIndex{
+		db:          db,
+		chainParams: chainParams,
+	}
+}
+
+// DropCfIndex drops the committed filter index from the provided database if it
+// exists.  The drop is stopped when the passed interrupt channel is closed and
+// resumed the next time the index is dropped or enabled.
+func DropCfIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, cfIndexKey, cfIndexName, interrupt)
+}
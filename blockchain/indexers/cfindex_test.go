@@ -0,0 +1,180 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package indexers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxd/wire/gcs"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// cfTestBlock returns a block like utxoTestBlock which builds on the passed
+// parent block.  Its header commits to its transactions so blocks with the same
+// parent have distinct hashes.
+func cfTestBlock(parent *colxutil.Block, coinbaseScripts [][]byte, txns ...*wire.MsgTx) *colxutil.Block {
+	block := utxoTestBlock(parent.Height()+1, coinbaseScripts, txns...)
+	header := &block.MsgBlock().Header
+	header.PrevBlock = *parent.Sha()
+	merkles := blockchain.BuildMerkleTreeStore(block.Transactions())
+	header.MerkleRoot = *merkles[len(merkles)-1]
+	return block
+}
+
+// TestCfIndex ensures the committed filter index stores the basic filter of
+// every connected block along with its hash and a chain of filter headers, and
+// removes them again when blocks are disconnected.
+func TestCfIndex(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "cfindex")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		wire.MainNet)
+	if err != nil {
+		t.Fatalf("unable to create database: %v", err)
+	}
+	defer db.Close()
+	idx := NewCfIndex(db, &chaincfg.MainNetParams)
+	if err := db.Update(idx.Create); err != nil {
+		t.Fatalf("unable to create index: %v", err)
+	}
+
+	scriptA := []byte{0x51}
+	scriptB := []byte{0x52}
+	scriptC := []byte{0x53}
+	nullData := []byte{0x6a, 0x01}
+
+	// The first block has a null data output which must not be part of
+	// its filter.  The block of the first branch spends the output paying
+	// to script A, while the block of the second branch does not spend
+	// any outputs.
+	block0 := utxoTestBlock(0, [][]byte{scriptA, nullData})
+	cb0 := block0.MsgBlock().Transactions[0]
+	block1X := cfTestBlock(block0, [][]byte{scriptB},
+		utxoTestSpend(cb0, 0, 9e7, scriptC))
+	block1Y := cfTestBlock(block0, [][]byte{scriptC})
+
+	// checkEntry ensures the index has the expected entry for the passed
+	// block and returns its filter header.
+	checkEntry := func(name string, block *colxutil.Block, prevHeader *wire.ShaHash, matches, misses [][]byte) *wire.ShaHash {
+		serialized, err := idx.FilterByBlockHash(block.Sha())
+		if err != nil || serialized == nil {
+			t.Fatalf("%s: FilterByBlockHash: unexpected result - "+
+				"got %x, %v", name, serialized, err)
+		}
+		filter, err := gcs.FromNBytes(gcs.DefaultP, gcs.DefaultM,
+			serialized)
+		if err != nil {
+			t.Fatalf("%s: unable to deserialize filter: %v", name,
+				err)
+		}
+		if filter.N() != uint32(len(matches)) {
+			t.Fatalf("%s: unexpected number of filter elements - "+
+				"got %d, want %d", name, filter.N(), len(matches))
+		}
+		key := gcs.DeriveKey(block.Sha())
+		for _, script := range matches {
+			if match, _ := filter.Match(key, script); !match {
+				t.Errorf("%s: script %x does not match", name,
+					script)
+			}
+		}
+		for _, script := range misses {
+			if match, _ := filter.Match(key, script); match {
+				t.Errorf("%s: script %x unexpectedly matches",
+					name, script)
+			}
+		}
+
+		filterHash, err := idx.FilterHashByBlockHash(block.Sha())
+		if err != nil || filterHash == nil ||
+			*filterHash != gcs.FilterHash(filter) {
+
+			t.Fatalf("%s: FilterHashByBlockHash: unexpected result "+
+				"- got %v, %v", name, filterHash, err)
+		}
+		header, err := idx.FilterHeaderByBlockHash(block.Sha())
+		wantHeader := gcs.MakeHeaderForFilter(filter, prevHeader)
+		if err != nil || header == nil || *header != wantHeader {
+			t.Fatalf("%s: FilterHeaderByBlockHash: unexpected result "+
+				"- got %v, %v, want %v", name, header, err,
+				wantHeader)
+		}
+		return header
+	}
+
+	// checkNoEntry ensures the index has no entry for the passed block.
+	checkNoEntry := func(name string, block *colxutil.Block) {
+		filter, err := idx.FilterByBlockHash(block.Sha())
+		if filter != nil || err != nil {
+			t.Fatalf("%s: FilterByBlockHash: unexpected result - "+
+				"got %x, %v", name, filter, err)
+		}
+		header, err := idx.FilterHeaderByBlockHash(block.Sha())
+		if header != nil || err != nil {
+			t.Fatalf("%s: FilterHeaderByBlockHash: unexpected result "+
+				"- got %v, %v", name, header, err)
+		}
+	}
+
+	// The filter header of the first block commits to a previous header
+	// of all zeros.
+	utxos := newTestUtxoSet()
+	if err := utxos.connect(db, idx, block0); err != nil {
+		t.Fatalf("unable to connect block 0: %v", err)
+	}
+	header0 := checkEntry("block 0", block0, &wire.ShaHash{},
+		[][]byte{scriptA}, [][]byte{nullData, scriptB})
+
+	// The filter of a block contains the scripts of the outputs it spends
+	// and its header commits to the header of its parent.
+	if err := utxos.connect(db, idx, block1X); err != nil {
+		t.Fatalf("unable to connect block 1X: %v", err)
+	}
+	checkEntry("block 1X", block1X, header0,
+		[][]byte{scriptA, scriptB, scriptC}, nil)
+
+	// Disconnecting the block removes its entry, while the entry of its
+	// parent is unaffected.
+	if err := utxos.disconnect(db, idx, block1X); err != nil {
+		t.Fatalf("unable to disconnect block 1X: %v", err)
+	}
+	checkNoEntry("disconnected block 1X", block1X)
+	if header, _ := idx.FilterHeaderByBlockHash(block0.Sha()); *header != *header0 {
+		t.Fatalf("unexpected filter header of block 0 - got %v, "+
+			"want %v", header, header0)
+	}
+
+	// The block of the other branch commits to the same parent header.
+	if err := utxos.connect(db, idx, block1Y); err != nil {
+		t.Fatalf("unable to connect block 1Y: %v", err)
+	}
+	header1Y := checkEntry("block 1Y", block1Y, header0,
+		[][]byte{scriptC}, [][]byte{scriptA, scriptB})
+	if bytes.Equal(header1Y[:], header0[:]) {
+		t.Fatalf("filter headers of blocks 0 and 1Y are identical")
+	}
+
+	// A block whose parent is not indexed can't be connected since its
+	// filter header would not be part of the chain.
+	orphan := cfTestBlock(block1X, [][]byte{scriptA})
+	err = utxos.connect(db, idx, orphan)
+	if _, ok := err.(AssertError); !ok {
+		t.Fatalf("unexpected error connecting block with unindexed "+
+			"parent - got %v, want AssertError", err)
+	}
+	checkNoEntry("block with unindexed parent", orphan)
+}
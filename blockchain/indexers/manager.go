@@ -122,12 +122,30 @@ func dbIndexDisconnectBlock(dbTx database.Tx, indexer Indexer, block *colxutil.B
 	return dbPutIndexerTip(dbTx, idxKey, prevHash, block.Height()-1)
 }
 
+// ReadyCallback is the type of function the index manager invokes to report
+// whether or not an index is synced with the current best chain and therefore
+// able to serve queries for all of the blocks in it.
+type ReadyCallback func(indexer Indexer, ready bool)
+
+// ProgressCallback is the type of function the index manager invokes to report
+// the progress of an index which is catching up to the best chain.  It is
+// passed the name of the index, the height of the block the index has caught
+// up to and the height of the best chain.
+type ProgressCallback func(indexName string, height, bestHeight int32)
+
+// defaultCatchUpBatchSize is the number of blocks which are connected to the
+// indexes in a single database transaction while they are catching up.
+const defaultCatchUpBatchSize = 1000
+
 // Manager defines an index manager that manages multiple optional indexes and
 // implements the blockchain.IndexManager interface so it can be seamlessly
 // plugged into normal chain processing.
 type Manager struct {
-	db             database.DB
-	enabledIndexes []Indexer
+	db               database.DB
+	enabledIndexes   []Indexer
+	readyCallback    ReadyCallback
+	progressCallback ProgressCallback
+	catchUpBatchSize int
 }
 
 // Ensure the Manager type implements the blockchain.IndexManager interface.
@@ -146,7 +164,7 @@ func indexDropKey(idxKey []byte) []byte {
 // of being dropped and finishes dropping them when the are.  This is necessary
 // because dropping and index has to be done in several atomic steps rather than
 // one big atomic step due to the massive number of entries.
-func (m *Manager) maybeFinishDrops() error {
+func (m *Manager) maybeFinishDrops(interrupt <-chan struct{}) error {
 	indexNeedsDrop := make([]bool, len(m.enabledIndexes))
 	err := m.db.View(func(dbTx database.Tx) error {
 		// None of the indexes needs to be dropped if the index tips
@@ -179,7 +197,8 @@ func (m *Manager) maybeFinishDrops() error {
 		}
 
 		log.Infof("Resuming %s drop", indexer.Name())
-		err := dropIndex(m.db, indexer.Key(), indexer.Name())
+		err := dropIndex(m.db, indexer.Key(), indexer.Name(),
+			interrupt)
 		if err != nil {
 			return err
 		}
@@ -224,15 +243,19 @@ func (m *Manager) maybeCreateIndexes(dbTx database.Tx) error {
 // time new blocks are being downloaded would lead to an overall longer time to
 // catch up due to the I/O contention.
 //
+// The catch up is stopped once the passed interrupt channel is closed, in which
+// case the indexes retain the progress made so far and continue from there the
+// next time they are initialized.
+//
 // This is part of the blockchain.IndexManager interface.
-func (m *Manager) Init(chain *blockchain.BlockChain) error {
+func (m *Manager) Init(chain *blockchain.BlockChain, interrupt <-chan struct{}) error {
 	// Nothing to do when no indexes are enabled.
 	if len(m.enabledIndexes) == 0 {
 		return nil
 	}
 
 	// Finish and drops that were previously interrupted.
-	if err := m.maybeFinishDrops(); err != nil {
+	if err := m.maybeFinishDrops(interrupt); err != nil {
 		return err
 	}
 
@@ -379,10 +402,18 @@ func (m *Manager) Init(chain *blockchain.BlockChain) error {
 		return err
 	}
 
+	// Report which indexes are already synced and which ones still need to
+	// catch up before they are able to serve queries.
+	for i, indexer := range m.enabledIndexes {
+		m.notifyReady(indexer, indexerHeights[i] == bestHeight)
+	}
+
 	// Nothing to index if all of the indexes are caught up.
 	if lowestHeight == bestHeight {
 		return nil
 	}
+	initialHeights := make([]int32, len(indexerHeights))
+	copy(initialHeights, indexerHeights)
 
 	// Create a progress logger for the indexing process below.
 	progressLogger := newBlockProgressLogger("Indexed", log)
@@ -390,54 +421,120 @@ func (m *Manager) Init(chain *blockchain.BlockChain) error {
 	// At this point, one or more indexes are behind the current best chain
 	// tip and need to be caught up, so log the details and loop through
 	// each block that needs to be indexed.
+	//
+	// The blocks are connected in batches, each of which is committed in a
+	// single database transaction along with the updated index tips.  This
+	// is significantly faster than a transaction per block and it also
+	// means an interrupted catch up resumes from the last committed batch
+	// on the next start.
 	log.Infof("Catching up indexes from height %d to %d", lowestHeight,
 		bestHeight)
-	for height := lowestHeight + 1; height <= bestHeight; height++ {
-		// Load the block for the height since it is required to index
-		// it.
-		block, err := chain.BlockByHeight(height)
-		if err != nil {
-			return err
+	for height := lowestHeight + 1; height <= bestHeight; {
+		if interruptRequested(interrupt) {
+			return errInterruptRequested
 		}
 
-		// Connect the block for all indexes that need it.
-		var view *blockchain.UtxoViewpoint
-		for i, indexer := range m.enabledIndexes {
-			// Skip indexes that don't need to be updated with this
-			// block.
-			if indexerHeights[i] >= height {
-				continue
+		// Load the blocks of the batch along with the referenced
+		// txouts when any of the indexes which still need the block
+		// require them.  This is done before starting the database
+		// transaction which connects them since loading them requires
+		// separate database transactions.
+		var blocks []*colxutil.Block
+		var views []*blockchain.UtxoViewpoint
+		for height <= bestHeight && len(blocks) < m.catchUpBatchSize {
+			block, err := chain.BlockByHeight(height)
+			if err != nil {
+				return err
 			}
 
-			err := m.db.Update(func(dbTx database.Tx) error {
-				// When the index requires all of the referenced
-				// txouts and they haven't been loaded yet, they
-				// need to be retrieved from the transaction
-				// index.
-				if view == nil && indexNeedsInputs(indexer) {
-					var err error
-					view, err = makeUtxoView(dbTx, block)
+			var view *blockchain.UtxoViewpoint
+			for i, indexer := range m.enabledIndexes {
+				if indexerHeights[i] < height &&
+					indexNeedsInputs(indexer) {
+
+					view, err = makeSpendJournalView(chain,
+						block)
 					if err != nil {
 						return err
 					}
+					break
 				}
-				return dbIndexConnectBlock(dbTx, indexer, block,
-					view)
-			})
-			if err != nil {
-				return err
 			}
-			indexerHeights[i] = height
+
+			blocks = append(blocks, block)
+			views = append(views, view)
+			height++
 		}
 
-		// Log indexing progress.
-		progressLogger.LogBlockHeight(block)
+		// Connect the blocks of the batch for all indexes that need
+		// them.
+		batchHeights := make([]int32, len(indexerHeights))
+		copy(batchHeights, indexerHeights)
+		err := m.db.Update(func(dbTx database.Tx) error {
+			for i, block := range blocks {
+				for j, indexer := range m.enabledIndexes {
+					// Skip indexes that don't need to be
+					// updated with this block.
+					if batchHeights[j] >= block.Height() {
+						continue
+					}
+
+					err := dbIndexConnectBlock(dbTx, indexer,
+						block, views[i])
+					if err != nil {
+						return err
+					}
+					batchHeights[j] = block.Height()
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Log indexing progress and report it for the indexes which
+		// were updated by the batch.
+		for _, block := range blocks {
+			progressLogger.LogBlockHeight(block)
+		}
+		for i, indexer := range m.enabledIndexes {
+			if batchHeights[i] != indexerHeights[i] {
+				m.notifyProgress(indexer, batchHeights[i],
+					bestHeight)
+			}
+		}
+		indexerHeights = batchHeights
 	}
 
 	log.Infof("Indexes caught up to height %d", bestHeight)
+
+	// Report the indexes which were behind as ready now that they are
+	// caught up.
+	for i, indexer := range m.enabledIndexes {
+		if initialHeights[i] != bestHeight {
+			m.notifyReady(indexer, true)
+		}
+	}
 	return nil
 }
 
+// notifyProgress invokes the progress callback, if any, with the height the
+// passed index has caught up to.
+func (m *Manager) notifyProgress(indexer Indexer, height, bestHeight int32) {
+	if m.progressCallback != nil {
+		m.progressCallback(indexer.Name(), height, bestHeight)
+	}
+}
+
+// notifyReady invokes the ready callback, if any, with whether or not the
+// passed index is synced with the best chain.
+func (m *Manager) notifyReady(indexer Indexer, ready bool) {
+	if m.readyCallback != nil {
+		m.readyCallback(indexer, ready)
+	}
+}
+
 // indexNeedsInputs returns whether or not the index needs access to the txouts
 // referenced by the transaction inputs being indexed.
 func indexNeedsInputs(index Indexer) bool {
@@ -478,9 +575,11 @@ func dbFetchTx(dbTx database.Tx, hash *wire.ShaHash) (*wire.MsgTx, error) {
 
 // makeUtxoView creates a mock unspent transaction output view by using the
 // transaction index in order to look up all inputs referenced by the
-// transactions in the block.  This is sometimes needed when catching indexes up
-// because many of the txouts could actually already be spent however the
-// associated scripts are still required to index them.
+// transactions in the block.  This is needed when removing orphaned blocks from
+// indexes since the spend journal entries of blocks are removed along with them
+// when they are disconnected from the main chain, and many of the txouts could
+// actually already be spent however the associated scripts are still required
+// to remove them from the indexes.
 func makeUtxoView(dbTx database.Tx, block *colxutil.Block) (*blockchain.UtxoViewpoint, error) {
 	view := blockchain.NewUtxoViewpoint()
 	for txIdx, tx := range block.Transactions() {
@@ -508,6 +607,22 @@ func makeUtxoView(dbTx database.Tx, block *colxutil.Block) (*blockchain.UtxoView
 	return view, nil
 }
 
+// makeSpendJournalView creates an unspent transaction output view with all of
+// the outputs referenced by the inputs of the passed main chain block by using
+// the spend journal of the chain.
+func makeSpendJournalView(chain *blockchain.BlockChain, block *colxutil.Block) (*blockchain.UtxoViewpoint, error) {
+	stxos, err := chain.FetchSpendJournal(block)
+	if err != nil {
+		return nil, err
+	}
+
+	view := blockchain.NewUtxoViewpoint()
+	if err := view.AddSpentTxOuts(block, stxos); err != nil {
+		return nil, err
+	}
+	return view, nil
+}
+
 // ConnectBlock must be invoked when a block is extending the main chain.  It
 // keeps track of the state of each index it is managing, performs some sanity
 // checks, and invokes each indexer.
@@ -544,22 +659,58 @@ func (m *Manager) DisconnectBlock(dbTx database.Tx, block *colxutil.Block, view
 }
 
 // NewManager returns a new index manager with the provided indexes enabled.
+// The ready callback, which may be nil, is invoked whenever an index becomes
+// synced with the best chain or starts catching up to it.  The progress
+// callback, which may also be nil, is invoked each time a batch of blocks has
+// been indexed while the indexes are catching up.
 //
 // The manager returned satisfies the blockchain.IndexManager interface and thus
 // cleanly plugs into the normal blockchain processing path.
-func NewManager(db database.DB, enabledIndexes []Indexer) *Manager {
+func NewManager(db database.DB, enabledIndexes []Indexer, readyCallback ReadyCallback, progressCallback ProgressCallback) *Manager {
 	return &Manager{
-		db:             db,
-		enabledIndexes: enabledIndexes,
+		db:               db,
+		enabledIndexes:   enabledIndexes,
+		readyCallback:    readyCallback,
+		progressCallback: progressCallback,
+		catchUpBatchSize: defaultCatchUpBatchSize,
 	}
 }
 
+// DropIndex drops the index with the passed key, which does not need to be one
+// of the indexes provided by this package, from the provided database if it
+// exists.  This allows the space used by indexes which are no longer needed to
+// be reclaimed without resyncing the chain.  Dropping the transaction index
+// also drops the indexes which rely on it as described by DropTxIndex.
+//
+// The drop is stopped when the passed interrupt channel is closed, in which
+// case it is resumed the next time the index is dropped or enabled.
+func DropIndex(db database.DB, idxKey []byte, interrupt <-chan struct{}) error {
+	switch {
+	case bytes.Equal(idxKey, txIndexKey):
+		return DropTxIndex(db, interrupt)
+	case bytes.Equal(idxKey, addrIndexKey):
+		return DropAddrIndex(db, interrupt)
+	case bytes.Equal(idxKey, utxoByScriptIndexKey):
+		return DropUtxoByScriptIndex(db, interrupt)
+	case bytes.Equal(idxKey, cfIndexKey):
+		return DropCfIndex(db, interrupt)
+	case bytes.Equal(idxKey, spendIndexKey):
+		return DropSpendIndex(db, interrupt)
+	case bytes.Equal(idxKey, timeIndexKey):
+		return DropTimeIndex(db, interrupt)
+	}
+
+	idxName := fmt.Sprintf("index %q", idxKey)
+	return dropIndex(db, idxKey, idxName, interrupt)
+}
+
 // dropIndex drops the passed index from the database.  Since indexes can be
 // massive, it deletes the index in multiple database transactions in order to
 // keep memory usage to reasonable levels.  It also marks the drop in progress
 // so the drop can be resumed if it is stopped before it is done before the
-// index can be used again.
-func dropIndex(db database.DB, idxKey []byte, idxName string) error {
+// index can be used again.  The drop is stopped, and errInterruptRequested is
+// returned, when the passed interrupt channel is closed.
+func dropIndex(db database.DB, idxKey []byte, idxName string, interrupt <-chan struct{}) error {
 	// Nothing to do if the index doesn't already exist.
 	var needsDelete bool
 	err := db.View(func(dbTx database.Tx) error {
@@ -598,6 +749,12 @@ func dropIndex(db database.DB, idxKey []byte, idxName string) error {
 	const maxDeletions = 2000000
 	var totalDeleted uint64
 	for numDeleted := maxDeletions; numDeleted == maxDeletions; {
+		if interruptRequested(interrupt) {
+			log.Infof("Interrupted dropping %s after deleting %d "+
+				"keys", idxName, totalDeleted)
+			return errInterruptRequested
+		}
+
 		numDeleted = 0
 		err := db.Update(func(dbTx database.Tx) error {
 			bucket := dbTx.Metadata().Bucket(idxKey)
@@ -624,7 +781,7 @@ func dropIndex(db database.DB, idxKey []byte, idxName string) error {
 	}
 
 	// Call extra index specific deinitialization for the transaction index.
-	if idxName == txIndexName {
+	if bytes.Equal(idxKey, txIndexKey) {
 		if err := dropBlockIDIndex(db); err != nil {
 			return err
 		}
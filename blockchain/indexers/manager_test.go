@@ -0,0 +1,446 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package indexers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/blockchain/chaingen"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	_ "github.com/tinhnguyenhn/colxd/database/ffldb"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// readyEvent is a call of the ready callback of the index manager.
+type readyEvent struct {
+	name  string
+	ready bool
+}
+
+// TestManagerReadyCallback ensures the index manager reports indexes which are
+// behind the best chain as not ready until they caught up, and indexes which
+// are already synced as ready right away, every time the chain is loaded.
+func TestManagerReadyCallback(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "indexready")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	params := &chaincfg.RegressionNetParams
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		params.Net)
+	if err != nil {
+		t.Fatalf("unable to create db: %v", err)
+	}
+	defer db.Close()
+
+	// loadChain loads the chain from the database, optionally with the
+	// transaction index enabled, and returns it along with the calls of
+	// the ready callback made while loading it.
+	loadChain := func(withIndex bool) (*blockchain.BlockChain, []readyEvent) {
+		var events []readyEvent
+		var indexManager blockchain.IndexManager
+		if withIndex {
+			indexManager = NewManager(db, []Indexer{NewTxIndex(db)},
+				func(indexer Indexer, ready bool) {
+					events = append(events, readyEvent{
+						name:  indexer.Name(),
+						ready: ready,
+					})
+				}, nil)
+		}
+		chain, err := blockchain.New(&blockchain.Config{
+			DB:           db,
+			ChainParams:  params,
+			TimeSource:   blockchain.NewMedianTime(),
+			IndexManager: indexManager,
+		})
+		if err != nil {
+			t.Fatalf("unable to load chain: %v", err)
+		}
+		return chain, events
+	}
+
+	// extendChain processes the passed number of new blocks with the
+	// passed chain.
+	g := chaingen.NewGenerator(params)
+	extendChain := func(chain *blockchain.BlockChain, numBlocks int) {
+		for i := 0; i < numBlocks; i++ {
+			name := fmt.Sprintf("b%d", g.TipHeight()+1)
+			block := colxutil.NewBlock(g.NextBlock(name, nil))
+			_, err := chain.ProcessBlock(block, blockchain.BFNone)
+			if err != nil {
+				t.Fatalf("ProcessBlock: unexpected error: %v", err)
+			}
+		}
+	}
+
+	catchingUp := []readyEvent{
+		{name: txIndexName, ready: false},
+		{name: txIndexName, ready: true},
+	}
+	synced := []readyEvent{
+		{name: txIndexName, ready: true},
+	}
+	tests := []struct {
+		name      string
+		numBlocks int
+		withIndex bool
+		want      []readyEvent
+	}{
+		{
+			name:      "index created",
+			numBlocks: 5,
+			withIndex: true,
+			want:      catchingUp,
+		},
+		{
+			name:      "index synced",
+			numBlocks: 5,
+			withIndex: true,
+			want:      synced,
+		},
+		{
+			name:      "index disabled",
+			numBlocks: 5,
+			withIndex: false,
+		},
+		{
+			name:      "index reenabled behind the chain",
+			withIndex: true,
+			want:      catchingUp,
+		},
+		{
+			name:      "index synced after catching up",
+			withIndex: true,
+			want:      synced,
+		},
+	}
+
+	// The chain is extended after it is loaded for each test, so the
+	// index is only behind when it was disabled while extending it.
+	for _, test := range tests {
+		chain, events := loadChain(test.withIndex)
+		if !reflect.DeepEqual(events, test.want) {
+			t.Fatalf("%s: unexpected ready callbacks - got %v, "+
+				"want %v", test.name, events, test.want)
+		}
+		extendChain(chain, test.numBlocks)
+	}
+}
+
+// progressEvent is a call of the progress callback of the index manager.
+type progressEvent struct {
+	name       string
+	height     int32
+	bestHeight int32
+}
+
+// TestManagerCatchUpInterrupt ensures interrupting the index manager while the
+// indexes are catching up retains the batches indexed so far, and that resuming
+// the catch up later on produces the same indexes as an uninterrupted run.
+func TestManagerCatchUpInterrupt(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "indexcatchup")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	params := &chaincfg.RegressionNetParams
+
+	// Generate the blocks which are processed by all of the chains.
+	const numBlocks = 10
+	g := chaingen.NewGenerator(params)
+	var blocks []*colxutil.Block
+	for i := 0; i < numBlocks; i++ {
+		name := fmt.Sprintf("b%d", g.TipHeight()+1)
+		blocks = append(blocks, colxutil.NewBlock(g.NextBlock(name, nil)))
+	}
+
+	// loadChain loads the chain from the passed database with the
+	// transaction and committed filter indexes enabled when an interrupt
+	// channel is passed and returns the result along with the calls of the
+	// progress callback made while loading it.  The progress callback
+	// closes the interrupt channel after the passed number of calls.
+	loadChain := func(db database.DB, interrupt chan struct{}, interruptAfter int) (*blockchain.BlockChain, []progressEvent, error) {
+		var events []progressEvent
+		var indexManager blockchain.IndexManager
+		if interrupt != nil {
+			m := NewManager(db, []Indexer{NewTxIndex(db),
+				NewCfIndex(db, params)}, nil,
+				func(indexName string, height, bestHeight int32) {
+					events = append(events, progressEvent{
+						name:       indexName,
+						height:     height,
+						bestHeight: bestHeight,
+					})
+					if len(events) == interruptAfter {
+						close(interrupt)
+					}
+				})
+			m.catchUpBatchSize = 4
+			indexManager = m
+		}
+		chain, err := blockchain.New(&blockchain.Config{
+			DB:           db,
+			ChainParams:  params,
+			TimeSource:   blockchain.NewMedianTime(),
+			IndexManager: indexManager,
+			Interrupt:    interrupt,
+		})
+		return chain, events, err
+	}
+
+	// createDB creates a database with the passed name which contains the
+	// generated blocks but none of the indexes.
+	createDB := func(name string) database.DB {
+		db, err := database.Create("ffldb", filepath.Join(dbPath, name),
+			params.Net)
+		if err != nil {
+			t.Fatalf("%s: unable to create db: %v", name, err)
+		}
+		chain, _, err := loadChain(db, nil, 0)
+		if err != nil {
+			t.Fatalf("%s: unable to load chain: %v", name, err)
+		}
+		for _, block := range blocks {
+			_, err := chain.ProcessBlock(block, blockchain.BFNone)
+			if err != nil {
+				t.Fatalf("%s: ProcessBlock: unexpected error: %v",
+					name, err)
+			}
+		}
+		return db
+	}
+
+	// dumpIndexes returns the contents of the buckets of the indexes and
+	// their tips in the passed database.
+	dumpIndexes := func(db database.DB) map[string]string {
+		contents := make(map[string]string)
+		err := db.View(func(dbTx database.Tx) error {
+			for _, bucketName := range [][]byte{indexTipsBucketName,
+				txIndexKey, idByHashIndexBucketName,
+				hashByIDIndexBucketName, cfIndexKey} {
+
+				bucket := dbTx.Metadata().Bucket(bucketName)
+				if bucket == nil {
+					return fmt.Errorf("missing bucket %s",
+						bucketName)
+				}
+				err := bucket.ForEach(func(k, v []byte) error {
+					key := string(bucketName) + "/" + string(k)
+					contents[key] = string(v)
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("unable to dump indexes: %v", err)
+		}
+		return contents
+	}
+
+	// Catch up the indexes of the first database in a single run.
+	db := createDB("uninterrupted")
+	defer db.Close()
+	_, events, err := loadChain(db, make(chan struct{}), 0)
+	if err != nil {
+		t.Fatalf("uninterrupted: unable to load chain: %v", err)
+	}
+	want := []progressEvent{
+		{txIndexName, 3, numBlocks}, {cfIndexName, 3, numBlocks},
+		{txIndexName, 7, numBlocks}, {cfIndexName, 7, numBlocks},
+		{txIndexName, 10, numBlocks}, {cfIndexName, 10, numBlocks},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("uninterrupted: unexpected progress callbacks - got "+
+			"%v, want %v", events, want)
+	}
+
+	// Interrupt the indexes of the second database after the first batch
+	// and ensure they retain it.
+	interruptedDB := createDB("interrupted")
+	defer interruptedDB.Close()
+	_, events, err = loadChain(interruptedDB, make(chan struct{}), 2)
+	if err != errInterruptRequested {
+		t.Fatalf("interrupted: unexpected error - got %v, want %v",
+			err, errInterruptRequested)
+	}
+	if !reflect.DeepEqual(events, want[:2]) {
+		t.Fatalf("interrupted: unexpected progress callbacks - got "+
+			"%v, want %v", events, want[:2])
+	}
+	err = interruptedDB.View(func(dbTx database.Tx) error {
+		for _, idxKey := range [][]byte{txIndexKey, cfIndexKey} {
+			_, height, err := dbFetchIndexerTip(dbTx, idxKey)
+			if err != nil {
+				return err
+			}
+			if height != 3 {
+				return fmt.Errorf("unexpected tip height of "+
+					"%s - got %d, want 3", idxKey, height)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("interrupted: %v", err)
+	}
+
+	// Resuming the catch up only indexes the remaining blocks and results
+	// in the same indexes as the uninterrupted run.
+	_, events, err = loadChain(interruptedDB, make(chan struct{}), 0)
+	if err != nil {
+		t.Fatalf("resumed: unable to load chain: %v", err)
+	}
+	if !reflect.DeepEqual(events, want[2:]) {
+		t.Fatalf("resumed: unexpected progress callbacks - got %v, "+
+			"want %v", events, want[2:])
+	}
+	got, wantIndexes := dumpIndexes(interruptedDB), dumpIndexes(db)
+	if !reflect.DeepEqual(got, wantIndexes) {
+		t.Fatalf("resumed: indexes differ from the uninterrupted run")
+	}
+}
+
+// TestDropIndex ensures dropping an index removes its bucket and tip, can be
+// interrupted and resumed, and leaves the other indexes intact.
+func TestDropIndex(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "dropindex")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	params := &chaincfg.RegressionNetParams
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		params.Net)
+	if err != nil {
+		t.Fatalf("unable to create db: %v", err)
+	}
+	defer db.Close()
+
+	// Create the transaction and committed filter indexes over a small
+	// chain.
+	indexManager := NewManager(db, []Indexer{NewTxIndex(db),
+		NewCfIndex(db, params)}, nil, nil)
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:           db,
+		ChainParams:  params,
+		TimeSource:   blockchain.NewMedianTime(),
+		IndexManager: indexManager,
+	})
+	if err != nil {
+		t.Fatalf("unable to load chain: %v", err)
+	}
+	g := chaingen.NewGenerator(params)
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("b%d", g.TipHeight()+1)
+		block := colxutil.NewBlock(g.NextBlock(name, nil))
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+
+	// Add the bucket and tip of an index which is not provided by this
+	// package, such as one left behind by an older version.
+	staleIndexKey := []byte("staleidx")
+	err = db.Update(func(dbTx database.Tx) error {
+		bucket, err := dbTx.Metadata().CreateBucket(staleIndexKey)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte("key"), []byte("value")); err != nil {
+			return err
+		}
+		return dbPutIndexerTip(dbTx, staleIndexKey, &wire.ShaHash{}, 0)
+	})
+	if err != nil {
+		t.Fatalf("unable to create stale index: %v", err)
+	}
+
+	// indexState returns the number of entries of the index with the passed
+	// key, or -1 when its bucket does not exist, along with whether or not
+	// its tip and drop marker exist.
+	indexState := func(idxKey []byte) (int, bool, bool) {
+		numEntries := -1
+		var hasTip, hasDropMarker bool
+		err := db.View(func(dbTx database.Tx) error {
+			meta := dbTx.Metadata()
+			tips := meta.Bucket(indexTipsBucketName)
+			hasTip = tips.Get(idxKey) != nil
+			hasDropMarker = tips.Get(indexDropKey(idxKey)) != nil
+			bucket := meta.Bucket(idxKey)
+			if bucket == nil {
+				return nil
+			}
+			numEntries = 0
+			return bucket.ForEach(func(k, v []byte) error {
+				numEntries++
+				return nil
+			})
+		})
+		if err != nil {
+			t.Fatalf("unable to fetch index state: %v", err)
+		}
+		return numEntries, hasTip, hasDropMarker
+	}
+	numTxEntries, _, _ := indexState(txIndexKey)
+	if numTxEntries != 6 {
+		t.Fatalf("unexpected number of transaction index entries - "+
+			"got %d, want 6", numTxEntries)
+	}
+
+	// An interrupted drop leaves the drop marker behind so the drop can be
+	// resumed.
+	interrupt := make(chan struct{})
+	close(interrupt)
+	err = DropIndex(db, cfIndexKey, interrupt)
+	if err != errInterruptRequested {
+		t.Fatalf("unexpected error of interrupted drop - got %v, "+
+			"want %v", err, errInterruptRequested)
+	}
+	numEntries, hasTip, hasDropMarker := indexState(cfIndexKey)
+	if numEntries != 6 || !hasTip || !hasDropMarker {
+		t.Fatalf("unexpected state of interrupted drop - got %d "+
+			"entries, tip %v, drop marker %v", numEntries, hasTip,
+			hasDropMarker)
+	}
+
+	// Dropping the indexes removes all of their data, while the other
+	// indexes remain intact.  Dropping them again is a no-op.
+	for _, idxKey := range [][]byte{cfIndexKey, staleIndexKey} {
+		for i := 0; i < 2; i++ {
+			if err := DropIndex(db, idxKey, nil); err != nil {
+				t.Fatalf("DropIndex(%s): unexpected error: %v",
+					idxKey, err)
+			}
+			numEntries, hasTip, hasDropMarker := indexState(idxKey)
+			if numEntries != -1 || hasTip || hasDropMarker {
+				t.Fatalf("unexpected state of dropped index %s "+
+					"- got %d entries, tip %v, drop marker %v",
+					idxKey, numEntries, hasTip, hasDropMarker)
+			}
+		}
+	}
+	numEntries, hasTip, hasDropMarker = indexState(txIndexKey)
+	if numEntries != numTxEntries || !hasTip || hasDropMarker {
+		t.Fatalf("unexpected state of transaction index - got %d "+
+			"entries, tip %v, drop marker %v", numEntries, hasTip,
+			hasDropMarker)
+	}
+}
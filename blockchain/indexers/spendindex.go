@@ -0,0 +1,247 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package indexers
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+const (
+	// spendIndexName is the human-readable name for the index.
+	spendIndexName = "spent outputs index"
+
+	// spendKeySize is the number of bytes a key of the index consumes.  It
+	// consists of 32 bytes transaction hash + 4 bytes output index.
+	spendKeySize = wire.HashSize + 4
+
+	// spendValueFixedSize is the number of bytes the fixed width fields of
+	// a value of the index consume.  It consists of 4 bytes block height +
+	// 32 bytes spending transaction hash.
+	spendValueFixedSize = 4 + wire.HashSize
+)
+
+var (
+	// spendIndexKey is the key of the spent outputs index and the db bucket
+	// used to house it.
+	spendIndexKey = []byte("spendbyoutpointidx")
+)
+
+// -----------------------------------------------------------------------------
+// The spent outputs index maps the outpoint of every transaction output spent
+// in the main chain to the transaction input which spends it, so the spender
+// of an output can be found without scanning the chain.  Entries are added
+// when the block with the spending transaction is connected and removed again
+// when it is disconnected, which makes the output unspent again.
+//
+// The serialized key format is:
+//
+//   <tx hash><output index>
+//
+//   Field          Type        Size
+//   tx hash        [32]byte    32
+//   output index   uint32      4
+//   -----
+//   Total: 36 bytes
+//
+// The serialized value format is:
+//
+//   <block height><spending tx hash><input index>
+//
+//   Field              Type        Size
+//   block height       int32       4
+//   spending tx hash   [32]byte    32
+//   input index        varint      1-5
+// -----------------------------------------------------------------------------
+
+// SpendInfo describes the transaction input which spends an output found in
+// the spent outputs index.
+type SpendInfo struct {
+	// TxHash is the hash of the transaction which spends the output.
+	TxHash wire.ShaHash
+
+	// InputIndex is the index of the input of the spending transaction
+	// which spends the output.
+	InputIndex uint32
+
+	// Height is the height of the block which contains the spending
+	// transaction.
+	Height int32
+}
+
+// spendKey returns the key of the index entry for the passed outpoint.
+func spendKey(outPoint *wire.OutPoint) []byte {
+	key := make([]byte, spendKeySize)
+	copy(key, outPoint.Hash[:])
+	byteOrder.PutUint32(key[wire.HashSize:], outPoint.Index)
+	return key
+}
+
+// serializeSpendInfo returns the passed spend info serialized for storage in
+// the index.
+func serializeSpendInfo(info *SpendInfo) []byte {
+	serialized := make([]byte, spendValueFixedSize+binary.MaxVarintLen32)
+	byteOrder.PutUint32(serialized, uint32(info.Height))
+	copy(serialized[4:], info.TxHash[:])
+	n := binary.PutUvarint(serialized[spendValueFixedSize:],
+		uint64(info.InputIndex))
+	return serialized[:spendValueFixedSize+n]
+}
+
+// deserializeSpendInfo decodes the passed serialized value of an index entry.
+func deserializeSpendInfo(serialized []byte) (*SpendInfo, error) {
+	if len(serialized) < spendValueFixedSize {
+		return nil, errDeserialize("unexpected end of data")
+	}
+
+	var info SpendInfo
+	info.Height = int32(byteOrder.Uint32(serialized))
+	copy(info.TxHash[:], serialized[4:])
+	inputIndex, n := binary.Uvarint(serialized[spendValueFixedSize:])
+	if n <= 0 || inputIndex > uint64(^uint32(0)) {
+		return nil, errDeserialize("malformed input index")
+	}
+	if spendValueFixedSize+n != len(serialized) {
+		return nil, errDeserialize("unexpected trailing data")
+	}
+	info.InputIndex = uint32(inputIndex)
+	return &info, nil
+}
+
+// SpendIndex implements an index of the transaction inputs which spend the
+// outputs spent in the main chain.
+type SpendIndex struct {
+	db          database.DB
+	chainParams *chaincfg.Params
+}
+
+// Ensure the SpendIndex type implements the Indexer interface.
+var _ Indexer = (*SpendIndex)(nil)
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *SpendIndex) Init() error {
+	// Nothing to do.
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *SpendIndex) Key() []byte {
+	return spendIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *SpendIndex) Name() string {
+	return spendIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the index.
+//
+// This is part of the Indexer interface.
+func (idx *SpendIndex) Create(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucket(spendIndexKey)
+	return err
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer adds an entry for every output
+// spent by the transactions in the block.  It only relies on the transactions
+// of the block, so the passed view is not used.
+//
+// This is part of the Indexer interface.
+func (idx *SpendIndex) ConnectBlock(dbTx database.Tx, block *colxutil.Block, view *blockchain.UtxoViewpoint) error {
+	bucket := dbTx.Metadata().Bucket(spendIndexKey)
+	for _, tx := range block.Transactions()[1:] {
+		for txInIdx, txIn := range tx.MsgTx().TxIn {
+			info := SpendInfo{
+				TxHash:     *tx.Sha(),
+				InputIndex: uint32(txInIdx),
+				Height:     block.Height(),
+			}
+			err := bucket.Put(spendKey(&txIn.PreviousOutPoint),
+				serializeSpendInfo(&info))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the entries for the
+// outputs spent by the transactions in the block since they are unspent again.
+//
+// This is part of the Indexer interface.
+func (idx *SpendIndex) DisconnectBlock(dbTx database.Tx, block *colxutil.Block, view *blockchain.UtxoViewpoint) error {
+	bucket := dbTx.Metadata().Bucket(spendIndexKey)
+	for _, tx := range block.Transactions()[1:] {
+		for _, txIn := range tx.MsgTx().TxIn {
+			err := bucket.Delete(spendKey(&txIn.PreviousOutPoint))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// SpendingTx uses an existing database transaction to look up the transaction
+// input which spends the passed outpoint in the main chain.  When the output is
+// not spent, or is not known, nil will be returned for both the spend info and
+// the error.
+func (idx *SpendIndex) SpendingTx(dbTx database.Tx, outPoint *wire.OutPoint) (*SpendInfo, error) {
+	serialized := dbTx.Metadata().Bucket(spendIndexKey).Get(
+		spendKey(outPoint))
+	if serialized == nil {
+		return nil, nil
+	}
+	info, err := deserializeSpendInfo(serialized)
+	if err != nil {
+		return nil, database.Error{
+			ErrorCode: database.ErrCorruption,
+			Description: fmt.Sprintf("corrupt spent output entry "+
+				"for %v: %v", outPoint, err),
+		}
+	}
+	return info, nil
+}
+
+// NewSpendIndex returns a new instance of an indexer that is used to create a
+// mapping of the outpoints of all outputs spent in the main chain to the
+// transaction inputs which spend them.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewSpendIndex(db database.DB, chainParams *chaincfg.Params) *SpendIndex {
+	return &SpendIndex{
+		db:          db,
+		chainParams: chainParams,
+	}
+}
+
+// DropSpendIndex drops the spent outputs index from the provided database if
+// it exists.  The drop is stopped when the passed interrupt channel is closed
+// and resumed the next time the index is dropped or enabled.
+func DropSpendIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, spendIndexKey, spendIndexName, interrupt)
+}
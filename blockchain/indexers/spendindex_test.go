@@ -0,0 +1,213 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package indexers
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// TestSpendInfoSerialization ensures serializing and deserializing the values
+// of the spent outputs index works as expected, including the handling of
+// malformed values.
+func TestSpendInfoSerialization(t *testing.T) {
+	txHash := wire.ShaHash{0x01, 0x02, 0x03}
+	hashBytes := txHash[:]
+
+	tests := []struct {
+		name       string
+		info       SpendInfo
+		serialized []byte
+	}{
+		{
+			name: "first input",
+			info: SpendInfo{TxHash: txHash, InputIndex: 0, Height: 1},
+			serialized: append(append([]byte{0x01, 0x00, 0x00, 0x00},
+				hashBytes...), 0x00),
+		},
+		{
+			name: "multi-byte input index",
+			info: SpendInfo{TxHash: txHash, InputIndex: 300,
+				Height: 0x010203},
+			serialized: append(append([]byte{0x03, 0x02, 0x01, 0x00},
+				hashBytes...), 0xac, 0x02),
+		},
+		{
+			name: "max input index",
+			info: SpendInfo{TxHash: txHash, InputIndex: ^uint32(0),
+				Height: 0x7fffffff},
+			serialized: append(append([]byte{0xff, 0xff, 0xff, 0x7f},
+				hashBytes...), 0xff, 0xff, 0xff, 0xff, 0x0f),
+		},
+	}
+
+	for _, test := range tests {
+		serialized := serializeSpendInfo(&test.info)
+		if !bytes.Equal(serialized, test.serialized) {
+			t.Errorf("%s: unexpected serialization - got %x, want %x",
+				test.name, serialized, test.serialized)
+			continue
+		}
+
+		info, err := deserializeSpendInfo(serialized)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(*info, test.info) {
+			t.Errorf("%s: mismatched spend info - got %+v, want %+v",
+				test.name, *info, test.info)
+		}
+	}
+
+	valid := serializeSpendInfo(&SpendInfo{TxHash: txHash, InputIndex: 300})
+	malformed := []struct {
+		name       string
+		serialized []byte
+	}{
+		{"empty", nil},
+		{"truncated hash", valid[:spendValueFixedSize-1]},
+		{"missing input index", valid[:spendValueFixedSize]},
+		{"truncated input index", valid[:len(valid)-1]},
+		{"trailing data", append(valid[:len(valid):len(valid)], 0x00)},
+		{"input index overflow", append(valid[:spendValueFixedSize:spendValueFixedSize],
+			0x80, 0x80, 0x80, 0x80, 0x10)},
+	}
+	for _, test := range malformed {
+		_, err := deserializeSpendInfo(test.serialized)
+		if !isDeserializeErr(err) {
+			t.Errorf("%s: unexpected error - got %v, want "+
+				"errDeserialize", test.name, err)
+		}
+	}
+}
+
+// TestSpendIndex ensures the spent outputs index records the transaction input
+// which spends every output, and that outputs become unspent again when the
+// blocks which spend them are disconnected during a reorganize.
+func TestSpendIndex(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "spendindex")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		wire.MainNet)
+	if err != nil {
+		t.Fatalf("unable to create database: %v", err)
+	}
+	defer db.Close()
+	idx := NewSpendIndex(db, &chaincfg.MainNetParams)
+	if err := db.Update(idx.Create); err != nil {
+		t.Fatalf("unable to create index: %v", err)
+	}
+
+	scriptA := []byte{0x51}
+	scriptB := []byte{0x52}
+
+	// The block of the first branch spends the first output of the first
+	// block along with an output created in the same block, while the
+	// block of the second branch spends the first output from a different
+	// input.
+	block0 := utxoTestBlock(0, [][]byte{scriptA, scriptB})
+	cb0 := block0.MsgBlock().Transactions[0]
+	spend1X := utxoTestSpend(cb0, 0, 9e7, scriptA)
+	spendAgain1X := utxoTestSpend(spend1X, 0, 8e7, scriptB)
+	block1X := cfTestBlock(block0, [][]byte{scriptA}, spend1X,
+		spendAgain1X)
+	spend1Y := utxoTestSpend(cb0, 1, 1e8, scriptA)
+	spend1Y.AddTxIn(wire.NewTxIn(&spend1X.TxIn[0].PreviousOutPoint, nil))
+	block1Y := cfTestBlock(block0, [][]byte{scriptB}, spend1Y)
+
+	cb0Hash := cb0.TxSha()
+	spend1XHash := spend1X.TxSha()
+	outPoints := []wire.OutPoint{
+		{Hash: cb0Hash, Index: 0},
+		{Hash: cb0Hash, Index: 1},
+		{Hash: spend1XHash, Index: 0},
+	}
+
+	// checkSpends ensures the index has the expected spend info for each
+	// of the outpoints, where nil means the output is unspent.
+	checkSpends := func(name string, want []*SpendInfo) {
+		err := db.View(func(dbTx database.Tx) error {
+			for i := range outPoints {
+				info, err := idx.SpendingTx(dbTx, &outPoints[i])
+				if err != nil {
+					return err
+				}
+				if !reflect.DeepEqual(info, want[i]) {
+					t.Errorf("%s: unexpected spend info for %v "+
+						"- got %+v, want %+v", name,
+						outPoints[i], info, want[i])
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: SpendingTx: unexpected error: %v", name, err)
+		}
+	}
+
+	utxos := newTestUtxoSet()
+	if err := utxos.connect(db, idx, block0); err != nil {
+		t.Fatalf("unable to connect block 0: %v", err)
+	}
+	checkSpends("block 0", []*SpendInfo{nil, nil, nil})
+
+	if err := utxos.connect(db, idx, block1X); err != nil {
+		t.Fatalf("unable to connect block 1X: %v", err)
+	}
+	checkSpends("block 1X", []*SpendInfo{
+		{TxHash: spend1XHash, InputIndex: 0, Height: 1},
+		nil,
+		{TxHash: spendAgain1X.TxSha(), InputIndex: 0, Height: 1},
+	})
+
+	// Reorganizing to the other branch makes the outputs spent by the
+	// first branch unspent again before the other branch spends them.
+	if err := utxos.disconnect(db, idx, block1X); err != nil {
+		t.Fatalf("unable to disconnect block 1X: %v", err)
+	}
+	checkSpends("disconnected block 1X", []*SpendInfo{nil, nil, nil})
+
+	if err := utxos.connect(db, idx, block1Y); err != nil {
+		t.Fatalf("unable to connect block 1Y: %v", err)
+	}
+	spend1YHash := spend1Y.TxSha()
+	checkSpends("block 1Y", []*SpendInfo{
+		{TxHash: spend1YHash, InputIndex: 1, Height: 1},
+		{TxHash: spend1YHash, InputIndex: 0, Height: 1},
+		nil,
+	})
+
+	// A corrupt entry is reported as database corruption.
+	err = db.Update(func(dbTx database.Tx) error {
+		return dbTx.Metadata().Bucket(spendIndexKey).Put(
+			spendKey(&outPoints[0]), []byte{0x01})
+	})
+	if err != nil {
+		t.Fatalf("unable to corrupt entry: %v", err)
+	}
+	err = db.View(func(dbTx database.Tx) error {
+		_, err := idx.SpendingTx(dbTx, &outPoints[0])
+		return err
+	})
+	if dbErr, ok := err.(database.Error); !ok ||
+		dbErr.ErrorCode != database.ErrCorruption {
+
+		t.Fatalf("SpendingTx: unexpected error for corrupt entry - "+
+			"got %v, want ErrCorruption", err)
+	}
+}
@@ -0,0 +1,327 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package indexers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+const (
+	// timeIndexName is the human-readable name for the index.
+	timeIndexName = "block time index"
+
+	// timeKeySize is the number of bytes a key of the index consumes.  It
+	// consists of 1 byte key type + 8 bytes timestamp + 4 bytes block
+	// height.
+	timeKeySize = 1 + 8 + 4
+
+	// timeKeyRaw and timeKeyMedian are the key types of the entries keyed
+	// by the timestamp in the header of a block and by its median time
+	// past, respectively.
+	timeKeyRaw    = 0x00
+	timeKeyMedian = 0x01
+
+	// timeIndexMedianBlocks is the number of blocks, including the block
+	// itself, whose timestamps the median time past of a block is
+	// calculated from.  It matches the value used by the chain.
+	timeIndexMedianBlocks = 11
+)
+
+var (
+	// timeIndexKey is the key of the block time index and the db bucket
+	// used to house it.
+	timeIndexKey = []byte("blockbytimeidx")
+)
+
+// -----------------------------------------------------------------------------
+// The block time index maps the timestamps of the blocks in the main chain to
+// their hashes so the blocks of a time range can be found without scanning the
+// chain.
+//
+// The timestamps in block headers are not monotonic since the consensus rules
+// only require them to exceed the median time past of the previous block, so
+// consecutive blocks may well have decreasing timestamps.  The index therefore
+// has two entries for every block: one keyed by the timestamp in its header and
+// one keyed by its median time past, which is the median of the timestamps of
+// the block and the 10 blocks before it and is practically monotonic.  Ranges
+// of either kind of timestamp are found by seeking to the start of the range
+// and iterating the keys in order.
+//
+// The serialized key format is:
+//
+//   <key type><timestamp><block height>
+//
+//   Field          Type      Size
+//   key type       byte      1
+//   timestamp      uint64    8
+//   block height   uint32    4
+//   -----
+//   Total: 13 bytes
+//
+// The timestamp and the block height are big endian so the keys sort by the
+// timestamp first and by the height for blocks with the same timestamp.
+//
+// The serialized value format is:
+//
+//   <block hash>
+//
+//   Field        Type        Size
+//   block hash   [32]byte    32
+// -----------------------------------------------------------------------------
+
+// timeKey returns the key of the index entry of the passed type for the block at
+// the passed height with the passed timestamp.
+func timeKey(keyType byte, timestamp time.Time, height int32) []byte {
+	key := make([]byte, timeKeySize)
+	key[0] = keyType
+	binary.BigEndian.PutUint64(key[1:], unixTime(timestamp))
+	binary.BigEndian.PutUint32(key[9:], uint32(height))
+	return key
+}
+
+// unixTime returns the passed time as the number of seconds since the unix
+// epoch, using zero for times before it.
+func unixTime(t time.Time) uint64 {
+	if t.Unix() < 0 {
+		return 0
+	}
+	return uint64(t.Unix())
+}
+
+// calcMedianTime returns the median time past of the block with the passed
+// header, which is the median of the timestamps of the block and the blocks
+// before it, up to timeIndexMedianBlocks of them.  The headers of the previous
+// blocks are loaded from the database.
+func calcMedianTime(dbTx database.Tx, header *wire.BlockHeader) (time.Time, error) {
+	timestamps := make([]int64, 0, timeIndexMedianBlocks)
+	timestamps = append(timestamps, header.Timestamp.Unix())
+	prevHash := header.PrevBlock
+	for len(timestamps) < timeIndexMedianBlocks &&
+		prevHash != (wire.ShaHash{}) {
+
+		serialized, err := dbTx.FetchBlockHeader(&prevHash)
+		if err != nil {
+			return time.Time{}, err
+		}
+		var prevHeader wire.BlockHeader
+		err = prevHeader.Deserialize(bytes.NewReader(serialized))
+		if err != nil {
+			return time.Time{}, err
+		}
+		timestamps = append(timestamps, prevHeader.Timestamp.Unix())
+		prevHash = prevHeader.PrevBlock
+	}
+
+	sort.Sort(int64Sorter(timestamps))
+	return time.Unix(timestamps[len(timestamps)/2], 0), nil
+}
+
+// int64Sorter implements sort.Interface to allow a slice of 64-bit integers to
+// be sorted.
+type int64Sorter []int64
+
+// Len returns the number of 64-bit integers in the slice.  It is part of the
+// sort.Interface implementation.
+func (s int64Sorter) Len() int {
+	return len(s)
+}
+
+// Swap swaps the 64-bit integers at the passed indices.  It is part of the
+// sort.Interface implementation.
+func (s int64Sorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Less returns whether the 64-bit integer with index i should sort before the
+// 64-bit integer with index j.  It is part of the sort.Interface
+// implementation.
+func (s int64Sorter) Less(i, j int) bool {
+	return s[i] < s[j]
+}
+
+// TimeIndex implements an index of the blocks in the main chain by their
+// timestamps and median times past.
+type TimeIndex struct {
+	db          database.DB
+	chainParams *chaincfg.Params
+}
+
+// Ensure the TimeIndex type implements the Indexer interface.
+var _ Indexer = (*TimeIndex)(nil)
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *TimeIndex) Init() error {
+	// Nothing to do.
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *TimeIndex) Key() []byte {
+	return timeIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *TimeIndex) Name() string {
+	return timeIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the index.
+//
+// This is part of the Indexer interface.
+func (idx *TimeIndex) Create(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucket(timeIndexKey)
+	return err
+}
+
+// blockTimeKeys returns the keys of the entries of the index for the passed
+// block.
+func blockTimeKeys(dbTx database.Tx, block *colxutil.Block) ([][]byte, error) {
+	header := &block.MsgBlock().Header
+	medianTime, err := calcMedianTime(dbTx, header)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{
+		timeKey(timeKeyRaw, header.Timestamp, block.Height()),
+		timeKey(timeKeyMedian, medianTime, block.Height()),
+	}, nil
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer adds the entries keyed by the
+// timestamp and by the median time past of the block.
+//
+// This is part of the Indexer interface.
+func (idx *TimeIndex) ConnectBlock(dbTx database.Tx, block *colxutil.Block, view *blockchain.UtxoViewpoint) error {
+	keys, err := blockTimeKeys(dbTx, block)
+	if err != nil {
+		return err
+	}
+
+	bucket := dbTx.Metadata().Bucket(timeIndexKey)
+	for _, key := range keys {
+		if err := bucket.Put(key, block.Sha()[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the entries of the
+// block.
+//
+// This is part of the Indexer interface.
+func (idx *TimeIndex) DisconnectBlock(dbTx database.Tx, block *colxutil.Block, view *blockchain.UtxoViewpoint) error {
+	keys, err := blockTimeKeys(dbTx, block)
+	if err != nil {
+		return err
+	}
+
+	bucket := dbTx.Metadata().Bucket(timeIndexKey)
+	for _, key := range keys {
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockHashesInRange returns the hashes of the blocks with entries of the
+// passed type whose timestamps are in the passed range.
+func blockHashesInRange(dbTx database.Tx, keyType byte, startTime, endTime time.Time, limit int) ([]wire.ShaHash, error) {
+	if !startTime.Before(endTime) {
+		return nil, nil
+	}
+
+	var hashes []wire.ShaHash
+	start := timeKey(keyType, startTime, 0)
+	end := timeKey(keyType, endTime, 0)
+	cursor := dbTx.Metadata().Bucket(timeIndexKey).Cursor()
+	for ok := cursor.Seek(start); ok; ok = cursor.Next() {
+		if limit > 0 && len(hashes) == limit {
+			break
+		}
+		if bytes.Compare(cursor.Key(), end) >= 0 {
+			break
+		}
+
+		serialized := cursor.Value()
+		if len(serialized) != wire.HashSize {
+			return nil, database.Error{
+				ErrorCode: database.ErrCorruption,
+				Description: fmt.Sprintf("corrupt block time "+
+					"entry %x: unexpected length %d",
+					cursor.Key(), len(serialized)),
+			}
+		}
+		var hash wire.ShaHash
+		copy(hash[:], serialized)
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// BlockHashesInRange uses an existing database transaction to return the hashes
+// of the main chain blocks whose header timestamps are at or after the passed
+// start time and before the passed end time.  The hashes are ordered by the
+// timestamps and by height for blocks with the same timestamp, which is not
+// necessarily the order of the blocks in the chain.  At most limit hashes are
+// returned when it is greater than zero.
+func (idx *TimeIndex) BlockHashesInRange(dbTx database.Tx, startTime, endTime time.Time, limit int) ([]wire.ShaHash, error) {
+	return blockHashesInRange(dbTx, timeKeyRaw, startTime, endTime, limit)
+}
+
+// BlockHashesInMedianTimeRange uses an existing database transaction to return
+// the hashes of the main chain blocks whose median times past are at or after
+// the passed start time and before the passed end time.  It is otherwise the
+// same as BlockHashesInRange, however, since the median time past of the
+// blocks practically never decreases, the hashes are ordered like the blocks
+// in the chain.
+func (idx *TimeIndex) BlockHashesInMedianTimeRange(dbTx database.Tx, startTime, endTime time.Time, limit int) ([]wire.ShaHash, error) {
+	return blockHashesInRange(dbTx, timeKeyMedian, startTime, endTime,
+		limit)
+}
+
+// NewTimeIndex returns a new instance of an indexer that is used to create a
+// mapping of the timestamps and median times past of all blocks in the main
+// chain to their hashes.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewTimeIndex(db database.DB, chainParams *chaincfg.Params) *TimeIndex {
+	return &TimeIndex{
+		db:          db,
+		chainParams: chainParams,
+	}
+}
+
+// DropTimeIndex drops the block time index from the provided database if it
+// exists.  The drop is stopped when the passed interrupt channel is closed and
+// resumed the next time the index is dropped or enabled.
+func DropTimeIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, timeIndexKey, timeIndexName, interrupt)
+}
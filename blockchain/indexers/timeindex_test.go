@@ -0,0 +1,259 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package indexers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestTimeIndex ensures the block time index finds the blocks of time ranges by
+// both their header timestamps and their median times past when the timestamps
+// of consecutive blocks are out of order, and that the entries of blocks are
+// removed when they are disconnected during a reorganize.
+func TestTimeIndex(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "timeindex")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		wire.MainNet)
+	if err != nil {
+		t.Fatalf("unable to create database: %v", err)
+	}
+	defer db.Close()
+	idx := NewTimeIndex(db, &chaincfg.MainNetParams)
+	if err := db.Update(idx.Create); err != nil {
+		t.Fatalf("unable to create index: %v", err)
+	}
+
+	// at returns the time the passed number of seconds after a base time.
+	base := time.Unix(1460000000, 0)
+	at := func(secs int64) time.Time {
+		return base.Add(time.Duration(secs) * time.Second)
+	}
+
+	// newBlock returns a block which extends the passed parent block, or
+	// is the first block when it is nil, with the passed timestamp and
+	// stores it in the database so its header is available to the blocks
+	// after it.
+	newBlock := func(parent *colxutil.Block, secs int64) *colxutil.Block {
+		var block *colxutil.Block
+		if parent == nil {
+			block = utxoTestBlock(0, nil)
+		} else {
+			block = cfTestBlock(parent, nil)
+		}
+		block.MsgBlock().Header.Timestamp = at(secs)
+		err := db.Update(func(dbTx database.Tx) error {
+			return dbTx.StoreBlock(block)
+		})
+		if err != nil {
+			t.Fatalf("unable to store block: %v", err)
+		}
+		return block
+	}
+
+	// The timestamps of the blocks at heights 3 and 5 are before the
+	// timestamps of the blocks before them, while still being after the
+	// median time past of the previous block as required by consensus.
+	// The median times past of the blocks are:
+	//
+	//   height:      0     1     2     3     4     5     6
+	//   timestamp:   1000  1100  1500  1200  1300  1250  1700
+	//   median time: 1000  1100  1100  1200  1200  1250  1250
+	secs := []int64{1000, 1100, 1500, 1200, 1300, 1250, 1700}
+	var blocks []*colxutil.Block
+	var parent *colxutil.Block
+	for _, s := range secs {
+		parent = newBlock(parent, s)
+		blocks = append(blocks, parent)
+	}
+
+	// The blocks at heights 5 and 6 are reorganized away in favor of
+	// blocks with out of order timestamps which both have the median time
+	// past 1300.
+	block5Y := newBlock(blocks[4], 1450)
+	block6Y := newBlock(block5Y, 1350)
+
+	connect := func(block *colxutil.Block) {
+		err := db.Update(func(dbTx database.Tx) error {
+			return idx.ConnectBlock(dbTx, block, nil)
+		})
+		if err != nil {
+			t.Fatalf("ConnectBlock: unexpected error: %v", err)
+		}
+	}
+	disconnect := func(block *colxutil.Block) {
+		err := db.Update(func(dbTx database.Tx) error {
+			return idx.DisconnectBlock(dbTx, block, nil)
+		})
+		if err != nil {
+			t.Fatalf("DisconnectBlock: unexpected error: %v", err)
+		}
+	}
+
+	type rangeTest struct {
+		name   string
+		median bool
+		start  int64
+		end    int64
+		limit  int
+		want   []*colxutil.Block
+	}
+	checkRanges := func(tests []rangeTest) {
+		for _, test := range tests {
+			var hashes []wire.ShaHash
+			err := db.View(func(dbTx database.Tx) error {
+				var err error
+				if test.median {
+					hashes, err = idx.BlockHashesInMedianTimeRange(
+						dbTx, at(test.start), at(test.end),
+						test.limit)
+				} else {
+					hashes, err = idx.BlockHashesInRange(dbTx,
+						at(test.start), at(test.end),
+						test.limit)
+				}
+				return err
+			})
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", test.name, err)
+			}
+			if len(hashes) != len(test.want) {
+				t.Errorf("%s: got %d hashes, want %d", test.name,
+					len(hashes), len(test.want))
+				continue
+			}
+			for i, block := range test.want {
+				if hashes[i] != *block.Sha() {
+					t.Errorf("%s: hash #%d is %v, want %v "+
+						"(height %d)", test.name, i, hashes[i],
+						block.Sha(), block.Height())
+				}
+			}
+		}
+	}
+
+	for _, block := range blocks {
+		connect(block)
+	}
+	checkRanges([]rangeTest{
+		{
+			name:  "timestamps out of order",
+			start: 1200,
+			end:   1400,
+			want:  []*colxutil.Block{blocks[3], blocks[5], blocks[4]},
+		},
+		{
+			name:  "end is exclusive",
+			start: 1000,
+			end:   1100,
+			want:  []*colxutil.Block{blocks[0]},
+		},
+		{
+			name:  "all blocks",
+			start: 0,
+			end:   2000,
+			want: []*colxutil.Block{blocks[0], blocks[1], blocks[3],
+				blocks[5], blocks[4], blocks[2], blocks[6]},
+		},
+		{
+			name:  "limit",
+			start: 1200,
+			end:   1400,
+			limit: 2,
+			want:  []*colxutil.Block{blocks[3], blocks[5]},
+		},
+		{
+			name:  "empty range",
+			start: 1500,
+			end:   1500,
+		},
+		{
+			name:  "no blocks in range",
+			start: 1800,
+			end:   3000,
+		},
+		{
+			name:   "median times",
+			median: true,
+			start:  1100,
+			end:    1250,
+			want: []*colxutil.Block{blocks[1], blocks[2], blocks[3],
+				blocks[4]},
+		},
+		{
+			name:   "median times with limit",
+			median: true,
+			start:  1100,
+			end:    1250,
+			limit:  3,
+			want:   []*colxutil.Block{blocks[1], blocks[2], blocks[3]},
+		},
+		{
+			name:   "all median times",
+			median: true,
+			start:  0,
+			end:    2000,
+			want:   blocks,
+		},
+	})
+
+	// Reorganize the last two blocks away.
+	disconnect(blocks[6])
+	disconnect(blocks[5])
+	connect(block5Y)
+	connect(block6Y)
+	checkRanges([]rangeTest{
+		{
+			name:  "reorganized timestamps",
+			start: 1200,
+			end:   1500,
+			want:  []*colxutil.Block{blocks[3], blocks[4], block6Y, block5Y},
+		},
+		{
+			name:  "disconnected timestamps",
+			start: 1250,
+			end:   1300,
+		},
+		{
+			name:   "reorganized median times",
+			median: true,
+			start:  1250,
+			end:    2000,
+			want:   []*colxutil.Block{block5Y, block6Y},
+		},
+	})
+
+	// A corrupt entry is reported as database corruption.
+	err = db.Update(func(dbTx database.Tx) error {
+		key := timeKey(timeKeyRaw, at(1000), 0)
+		return dbTx.Metadata().Bucket(timeIndexKey).Put(key, []byte{0x01})
+	})
+	if err != nil {
+		t.Fatalf("unable to corrupt entry: %v", err)
+	}
+	err = db.View(func(dbTx database.Tx) error {
+		_, err := idx.BlockHashesInRange(dbTx, at(0), at(2000), 0)
+		return err
+	})
+	if dbErr, ok := err.(database.Error); !ok ||
+		dbErr.ErrorCode != database.ErrCorruption {
+
+		t.Fatalf("BlockHashesInRange: unexpected error for corrupt entry "+
+			"- got %v, want ErrCorruption", err)
+	}
+}
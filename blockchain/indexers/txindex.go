@@ -6,6 +6,7 @@
 package indexers
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 
@@ -18,6 +19,23 @@ import (
 const (
 	// txIndexName is the human-readable name for the index.
 	txIndexName = "transaction index"
+
+	// txIndexVersion is the current version of the layout of the
+	// transaction index.  Version 1 keyed the entries by the transaction
+	// hashes in their internal byte order, while version 2 keys them by the
+	// hashes in the byte order they are displayed in so the entries are
+	// ordered like the displayed hashes and can be found by their prefixes.
+	txIndexVersion = 2
+
+	// txIndexMigrateBatchSize is the maximum number of entries moved in a
+	// single database transaction while migrating the transaction index to
+	// the current version.
+	txIndexMigrateBatchSize = 500000
+
+	// MinTxPrefixLen is the minimum number of bytes of a transaction hash
+	// prefix that can be looked up in the transaction index.  It bounds the
+	// number of entries scanned for a lookup.
+	MinTxPrefixLen = 4
 )
 
 var (
@@ -33,9 +51,29 @@ var (
 	// the block hash -> block id index.
 	hashByIDIndexBucketName = []byte("hashbyididx")
 
+	// txIndexVersionKeyName is the name of the db key used to house the
+	// version of the layout of the transaction index.  Indexes created
+	// before the version was stored do not have it and are version 1.
+	txIndexVersionKeyName = []byte("txbyhashidxversion")
+
+	// txIndexMigrateBucketName is the name of the db bucket used to house
+	// the entries of the transaction index while it is migrated to the
+	// current version.
+	txIndexMigrateBucketName = []byte("txbyhashidxmigrate")
+
 	// errNoBlockIDEntry is an error that indicates a requested entry does
 	// not exist in the block ID index.
 	errNoBlockIDEntry = errors.New("no entry in the block ID index")
+
+	// ErrTxPrefixTooShort is returned when a transaction hash prefix that
+	// is shorter than MinTxPrefixLen is looked up.
+	ErrTxPrefixTooShort = fmt.Errorf("transaction hash prefix is shorter "+
+		"than the minimum of %d bytes", MinTxPrefixLen)
+
+	// ErrAmbiguousTxPrefix is returned when a unique transaction is looked
+	// up by a hash prefix that more than one transaction hash starts with.
+	ErrAmbiguousTxPrefix = errors.New("transaction hash prefix matches " +
+		"more than one transaction")
 )
 
 // -----------------------------------------------------------------------------
@@ -83,14 +121,42 @@ var (
 //   <txhash> = <block id><start offset><tx length>
 //
 //   Field           Type            Size
-//   txhash          wire.ShaHash    32 bytes
+//   txhash          [32]byte        32 bytes
 //   block id        uint32          4 bytes
 //   start offset    uint32          4 bytes
 //   tx length       uint32          4 bytes
 //   -----
 //   Total: 44 bytes
+//
+// The transaction hash in the key is in the byte order the hash is displayed
+// in, which is the reverse of the internal byte order of wire.ShaHash.  That
+// way the entries are ordered like the displayed hashes, so the transactions
+// whose hashes start with a given prefix, such as the first few characters of
+// a transaction id shown by a debugging tool, are found by seeking to the
+// prefix.  Version 1 of the index keyed the entries by the internal byte order
+// instead, and such indexes are migrated when the index is initialized.
 // -----------------------------------------------------------------------------
 
+// txIndexEntryKey returns the key of the transaction index entry for the passed
+// transaction hash, which is the hash in the byte order it is displayed in.
+func txIndexEntryKey(txHash *wire.ShaHash) []byte {
+	key := make([]byte, wire.HashSize)
+	for i := range key {
+		key[i] = txHash[wire.HashSize-1-i]
+	}
+	return key
+}
+
+// txHashFromEntryKey returns the transaction hash of the passed transaction
+// index entry key.  The key must be wire.HashSize bytes.
+func txHashFromEntryKey(key []byte) wire.ShaHash {
+	var txHash wire.ShaHash
+	for i := range txHash {
+		txHash[i] = key[wire.HashSize-1-i]
+	}
+	return txHash
+}
+
 // dbPutBlockIDIndexEntry uses an existing database transaction to update or add
 // the index entries for the hash to id and id to hash mappings for the provided
 // values.
@@ -179,7 +245,7 @@ func putTxIndexEntry(target []byte, blockID uint32, txLoc wire.TxLoc) {
 // been serialized putTxIndexEntry.
 func dbPutTxIndexEntry(dbTx database.Tx, txHash *wire.ShaHash, serializedData []byte) error {
 	txIndex := dbTx.Metadata().Bucket(txIndexKey)
-	return txIndex.Put(txHash[:], serializedData)
+	return txIndex.Put(txIndexEntryKey(txHash), serializedData)
 }
 
 // dbFetchTxIndexEntry uses an existing database transaction to fetch the block
@@ -189,7 +255,7 @@ func dbPutTxIndexEntry(dbTx database.Tx, txHash *wire.ShaHash, serializedData []
 func dbFetchTxIndexEntry(dbTx database.Tx, txHash *wire.ShaHash) (*database.BlockRegion, error) {
 	// Load the record from the database and return now if it doesn't exist.
 	txIndex := dbTx.Metadata().Bucket(txIndexKey)
-	serializedData := txIndex.Get(txHash[:])
+	serializedData := txIndex.Get(txIndexEntryKey(txHash))
 	if len(serializedData) == 0 {
 		return nil, nil
 	}
@@ -257,13 +323,14 @@ func dbAddTxIndexEntries(dbTx database.Tx, block *colxutil.Block, blockID uint32
 // recent transaction index entry for the given hash.
 func dbRemoveTxIndexEntry(dbTx database.Tx, txHash *wire.ShaHash) error {
 	txIndex := dbTx.Metadata().Bucket(txIndexKey)
-	serializedData := txIndex.Get(txHash[:])
+	key := txIndexEntryKey(txHash)
+	serializedData := txIndex.Get(key)
 	if len(serializedData) == 0 {
 		return fmt.Errorf("can't remove non-existent transaction %s "+
 			"from the transaction index", txHash)
 	}
 
-	return txIndex.Delete(txHash[:])
+	return txIndex.Delete(key)
 }
 
 // dbRemoveTxIndexEntries uses an existing database transaction to remove the
@@ -279,6 +346,141 @@ func dbRemoveTxIndexEntries(dbTx database.Tx, block *colxutil.Block) error {
 	return nil
 }
 
+// dbFetchTxIndexVersion uses an existing database transaction to fetch the
+// version of the layout of the transaction index.  Indexes which do not have a
+// stored version are version 1.
+func dbFetchTxIndexVersion(dbTx database.Tx) uint32 {
+	serialized := dbTx.Metadata().Get(txIndexVersionKeyName)
+	if len(serialized) < 4 {
+		return 1
+	}
+	return byteOrder.Uint32(serialized)
+}
+
+// dbPutTxIndexVersion uses an existing database transaction to store the passed
+// version of the layout of the transaction index.
+func dbPutTxIndexVersion(dbTx database.Tx, version uint32) error {
+	var serialized [4]byte
+	byteOrder.PutUint32(serialized[:], version)
+	return dbTx.Metadata().Put(txIndexVersionKeyName, serialized[:])
+}
+
+// moveTxIndexEntries moves up to the passed maximum number of entries from one
+// bucket to another, reversing the byte order of their keys when requested,
+// and returns the number of entries moved.
+func moveTxIndexEntries(from, to database.Bucket, max int, reverseKeys bool) (int, error) {
+	var numMoved int
+	cursor := from.Cursor()
+	for ok := cursor.First(); ok && numMoved < max; ok = cursor.Next() {
+		key := make([]byte, len(cursor.Key()))
+		copy(key, cursor.Key())
+		if reverseKeys {
+			for i, j := 0, len(key)-1; i < j; i, j = i+1, j-1 {
+				key[i], key[j] = key[j], key[i]
+			}
+		}
+		if err := to.Put(key, cursor.Value()); err != nil {
+			return numMoved, err
+		}
+		if err := cursor.Delete(); err != nil {
+			return numMoved, err
+		}
+		numMoved++
+	}
+	return numMoved, nil
+}
+
+// migrateTxIndex migrates the layout of the transaction index to the current
+// version when it is older.  Since the index can be massive, the entries are
+// moved in multiple database transactions of up to the passed batch size each.
+// They are first moved to a separate bucket under their new keys, and then
+// back to the index once all of them have been moved.  Each step is atomic and
+// the version is updated along with the last entry moved out of the index, so
+// an interrupted migration is resumed at the right step the next time the
+// index is initialized.
+func migrateTxIndex(db database.DB, batchSize int) error {
+	var version uint32
+	var migrating bool
+	err := db.View(func(dbTx database.Tx) error {
+		version = dbFetchTxIndexVersion(dbTx)
+		migrating = dbTx.Metadata().Bucket(txIndexMigrateBucketName) != nil
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if version == txIndexVersion && !migrating {
+		return nil
+	}
+	if version > txIndexVersion {
+		return fmt.Errorf("the %s is version %d which is newer than the "+
+			"supported version %d", txIndexName, version,
+			txIndexVersion)
+	}
+
+	log.Infof("Migrating the %s to version %d.  This might take a "+
+		"while...", txIndexName, txIndexVersion)
+
+	// Move the entries keyed by the old layout out of the index and key
+	// them by the new layout.
+	if version < txIndexVersion {
+		err := db.Update(func(dbTx database.Tx) error {
+			_, err := dbTx.Metadata().CreateBucketIfNotExists(
+				txIndexMigrateBucketName)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+
+		for numMoved := batchSize; numMoved == batchSize; {
+			err := db.Update(func(dbTx database.Tx) error {
+				meta := dbTx.Metadata()
+				var err error
+				numMoved, err = moveTxIndexEntries(
+					meta.Bucket(txIndexKey),
+					meta.Bucket(txIndexMigrateBucketName),
+					batchSize, true)
+				if err != nil {
+					return err
+				}
+				if numMoved < batchSize {
+					return dbPutTxIndexVersion(dbTx,
+						txIndexVersion)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Move the entries keyed by the new layout back into the index.
+	for numMoved := batchSize; numMoved == batchSize; {
+		err := db.Update(func(dbTx database.Tx) error {
+			meta := dbTx.Metadata()
+			var err error
+			numMoved, err = moveTxIndexEntries(
+				meta.Bucket(txIndexMigrateBucketName),
+				meta.Bucket(txIndexKey), batchSize, false)
+			if err != nil {
+				return err
+			}
+			if numMoved < batchSize {
+				return meta.DeleteBucket(txIndexMigrateBucketName)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Migrated the %s to version %d", txIndexName, txIndexVersion)
+	return nil
+}
+
 // TxIndex implements a transaction by hash index.  That is to say, it supports
 // querying all transactions by their hash.
 type TxIndex struct {
@@ -289,12 +491,18 @@ type TxIndex struct {
 // Ensure the TxIndex type implements the Indexer interface.
 var _ Indexer = (*TxIndex)(nil)
 
-// Init initializes the hash-based transaction index.  In particular, it finds
-// the highest used block ID and stores it for later use when connecting or
+// Init initializes the hash-based transaction index.  In particular, it
+// migrates the index to the current layout when it is older and finds the
+// highest used block ID and stores it for later use when connecting or
 // disconnecting blocks.
 //
 // This is part of the Indexer interface.
 func (idx *TxIndex) Init() error {
+	// Migrate the index to the current layout as needed.
+	if err := migrateTxIndex(idx.db, txIndexMigrateBatchSize); err != nil {
+		return err
+	}
+
 	// Find the latest known block id field for the internal block id
 	// index and initialize it.  This is done because it's a lot more
 	// efficient to do a single search at initialize time than it is to
@@ -368,7 +576,8 @@ func (idx *TxIndex) Name() string {
 
 // Create is invoked when the indexer manager determines the index needs
 // to be created for the first time.  It creates the buckets for the hash-based
-// transaction index and the internal block ID indexes.
+// transaction index and the internal block ID indexes and stores the version
+// of the layout of the index.
 //
 // This is part of the Indexer interface.
 func (idx *TxIndex) Create(dbTx database.Tx) error {
@@ -379,8 +588,10 @@ func (idx *TxIndex) Create(dbTx database.Tx) error {
 	if _, err := meta.CreateBucket(hashByIDIndexBucketName); err != nil {
 		return err
 	}
-	_, err := meta.CreateBucket(txIndexKey)
-	return err
+	if _, err := meta.CreateBucket(txIndexKey); err != nil {
+		return err
+	}
+	return dbPutTxIndexVersion(dbTx, txIndexVersion)
 }
 
 // ConnectBlock is invoked by the index manager when a new block has been
@@ -442,6 +653,60 @@ func (idx *TxIndex) TxBlockRegion(hash *wire.ShaHash) (*database.BlockRegion, er
 	return region, err
 }
 
+// TxHashesByPrefix uses an existing database transaction to return the hashes
+// of the transactions in the index whose hashes start with the passed prefix,
+// ordered by hash.  The prefix is in the byte order hashes are displayed in,
+// such as the decoded first characters of a transaction id, and must be at
+// least MinTxPrefixLen bytes, or ErrTxPrefixTooShort is returned.  At most
+// limit hashes are returned when it is greater than zero.
+func (idx *TxIndex) TxHashesByPrefix(dbTx database.Tx, prefix []byte, limit int) ([]wire.ShaHash, error) {
+	if len(prefix) < MinTxPrefixLen {
+		return nil, ErrTxPrefixTooShort
+	}
+
+	var hashes []wire.ShaHash
+	cursor := dbTx.Metadata().Bucket(txIndexKey).Cursor()
+	for ok := cursor.Seek(prefix); ok; ok = cursor.Next() {
+		if limit > 0 && len(hashes) == limit {
+			break
+		}
+		key := cursor.Key()
+		if !bytes.HasPrefix(key, prefix) {
+			break
+		}
+
+		if len(key) != wire.HashSize {
+			return nil, database.Error{
+				ErrorCode: database.ErrCorruption,
+				Description: fmt.Sprintf("corrupt transaction "+
+					"index key %x: unexpected length %d",
+					key, len(key)),
+			}
+		}
+		hashes = append(hashes, txHashFromEntryKey(key))
+	}
+	return hashes, nil
+}
+
+// TxHashByPrefix uses an existing database transaction to return the hash of
+// the only transaction in the index whose hash starts with the passed prefix.
+// ErrAmbiguousTxPrefix is returned when the hashes of more than one transaction
+// start with it, while nil is returned for both the hash and the error when
+// none does.  See TxHashesByPrefix for the format of the prefix.
+func (idx *TxIndex) TxHashByPrefix(dbTx database.Tx, prefix []byte) (*wire.ShaHash, error) {
+	hashes, err := idx.TxHashesByPrefix(dbTx, prefix, 2)
+	if err != nil {
+		return nil, err
+	}
+	switch len(hashes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &hashes[0], nil
+	}
+	return nil, ErrAmbiguousTxPrefix
+}
+
 // NewTxIndex returns a new instance of an indexer that is used to create a
 // mapping of the hashes of all transactions in the blockchain to the respective
 // block, location within the block, and size of the transaction.
@@ -453,7 +718,8 @@ func NewTxIndex(db database.DB) *TxIndex {
 	return &TxIndex{db: db}
 }
 
-// dropBlockIDIndex drops the internal block id index.
+// dropBlockIDIndex drops the internal block id index along with the version of
+// the transaction index and the entries of an unfinished migration of it.
 func dropBlockIDIndex(db database.DB) error {
 	return db.Update(func(dbTx database.Tx) error {
 		meta := dbTx.Metadata()
@@ -461,18 +727,40 @@ func dropBlockIDIndex(db database.DB) error {
 		if err != nil {
 			return err
 		}
+		err = meta.DeleteBucket(hashByIDIndexBucketName)
+		if err != nil {
+			return err
+		}
 
-		return meta.DeleteBucket(hashByIDIndexBucketName)
+		if meta.Bucket(txIndexMigrateBucketName) != nil {
+			err := meta.DeleteBucket(txIndexMigrateBucketName)
+			if err != nil {
+				return err
+			}
+		}
+		return meta.Delete(txIndexVersionKeyName)
 	})
 }
 
 // DropTxIndex drops the transaction index from the provided database if it
-// exists.  Since the address index relies on it, the address index will also be
-// dropped when it exists.
-func DropTxIndex(db database.DB) error {
-	if err := dropIndex(db, addrIndexKey, addrIndexName); err != nil {
+// exists.  Since the address index, the unspent outputs by script index, and
+// the committed filter index rely on it, they will also be dropped when they
+// exist.  The drop is stopped when the passed interrupt channel is closed and
+// resumed the next time the index is dropped or enabled.
+func DropTxIndex(db database.DB, interrupt <-chan struct{}) error {
+	err := dropIndex(db, addrIndexKey, addrIndexName, interrupt)
+	if err != nil {
+		return err
+	}
+	err = dropIndex(db, cfIndexKey, cfIndexName, interrupt)
+	if err != nil {
+		return err
+	}
+	err = dropIndex(db, utxoByScriptIndexKey, utxoByScriptIndexName,
+		interrupt)
+	if err != nil {
 		return err
 	}
 
-	return dropIndex(db, txIndexKey, txIndexName)
+	return dropIndex(db, txIndexKey, txIndexName, interrupt)
 }
@@ -0,0 +1,365 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package indexers
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// createTxIndexTestDB creates a database with the passed name in the passed
+// directory which contains an empty transaction index.
+func createTxIndexTestDB(t *testing.T, dbPath, name string) (database.DB, *TxIndex) {
+	db, err := database.Create("ffldb", filepath.Join(dbPath, name),
+		wire.MainNet)
+	if err != nil {
+		t.Fatalf("%s: unable to create database: %v", name, err)
+	}
+	idx := NewTxIndex(db)
+	if err := db.Update(idx.Create); err != nil {
+		db.Close()
+		t.Fatalf("%s: unable to create index: %v", name, err)
+	}
+	return db, idx
+}
+
+// txIndexTestHash returns the hash which is displayed as the passed first and
+// last characters with zeros in between.
+func txIndexTestHash(t *testing.T, first, last string) wire.ShaHash {
+	zeros := strings.Repeat("0", wire.MaxHashStringSize-len(first)-len(last))
+	hash, err := wire.NewShaHashFromStr(first + zeros + last)
+	if err != nil {
+		t.Fatalf("NewShaHashFromStr: %v", err)
+	}
+	return *hash
+}
+
+// TestTxHashesByPrefix ensures the transactions whose displayed hashes start
+// with a prefix are found, including when the prefixes of several hashes
+// collide, and that unique lookups report ambiguous prefixes.
+func TestTxHashesByPrefix(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "txindexprefix")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	db, idx := createTxIndexTestDB(t, dbPath, "db")
+	defer db.Close()
+
+	hashes := []wire.ShaHash{
+		txIndexTestHash(t, "deadbe00", "ff"),
+		txIndexTestHash(t, "deadbeef00", "01"),
+		txIndexTestHash(t, "deadbeef00", "02"),
+		txIndexTestHash(t, "deadbeefff", "00"),
+		txIndexTestHash(t, "ffffffff", "00"),
+	}
+	err = db.Update(func(dbTx database.Tx) error {
+		// Add the hashes out of order to ensure the lookups are
+		// ordered by the displayed hashes.
+		for _, i := range []int{3, 0, 4, 2, 1} {
+			err := dbPutTxIndexEntry(dbTx, &hashes[i], make([]byte, 12))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unable to add entries: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		prefix string
+		limit  int
+		want   []wire.ShaHash
+		err    error
+	}{
+		{
+			name:   "colliding prefix",
+			prefix: "deadbeef",
+			want:   hashes[1:4],
+		},
+		{
+			name:   "colliding prefix with limit",
+			prefix: "deadbeef",
+			limit:  2,
+			want:   hashes[1:3],
+		},
+		{
+			name:   "longer colliding prefix",
+			prefix: "deadbeef00",
+			want:   hashes[1:3],
+		},
+		{
+			name:   "unique prefix",
+			prefix: "deadbe00",
+			want:   hashes[:1],
+		},
+		{
+			name:   "last hash",
+			prefix: "ffffffff",
+			want:   hashes[4:],
+		},
+		{
+			name:   "full hash",
+			prefix: hashes[2].String(),
+			want:   hashes[2:3],
+		},
+		{
+			name:   "no match",
+			prefix: "01020304",
+		},
+		{
+			name:   "prefix too short",
+			prefix: "deadbe",
+			err:    ErrTxPrefixTooShort,
+		},
+	}
+
+	for _, test := range tests {
+		prefix, err := hex.DecodeString(test.prefix)
+		if err != nil {
+			t.Fatalf("%s: unable to decode prefix: %v", test.name, err)
+		}
+		var got []wire.ShaHash
+		err = db.View(func(dbTx database.Tx) error {
+			var err error
+			got, err = idx.TxHashesByPrefix(dbTx, prefix, test.limit)
+			return err
+		})
+		if err != test.err {
+			t.Errorf("%s: unexpected error - got %v, want %v",
+				test.name, err, test.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: unexpected hashes - got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+
+	uniqueTests := []struct {
+		name   string
+		prefix string
+		want   *wire.ShaHash
+		err    error
+	}{
+		{"unique", "deadbeefff", &hashes[3], nil},
+		{"ambiguous", "deadbeef", nil, ErrAmbiguousTxPrefix},
+		{"no match", "01020304", nil, nil},
+		{"too short", "dead", nil, ErrTxPrefixTooShort},
+	}
+	for _, test := range uniqueTests {
+		prefix, err := hex.DecodeString(test.prefix)
+		if err != nil {
+			t.Fatalf("%s: unable to decode prefix: %v", test.name, err)
+		}
+		var got *wire.ShaHash
+		err = db.View(func(dbTx database.Tx) error {
+			var err error
+			got, err = idx.TxHashByPrefix(dbTx, prefix)
+			return err
+		})
+		if err != test.err {
+			t.Errorf("TxHashByPrefix %s: unexpected error - got %v, "+
+				"want %v", test.name, err, test.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("TxHashByPrefix %s: unexpected hash - got %v, "+
+				"want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// TestTxIndexMigration ensures transaction indexes keyed by the version 1
+// layout are migrated to the current layout in batches, including when a
+// previous migration was interrupted at any step.
+func TestTxIndexMigration(t *testing.T) {
+	dbPath, err := ioutil.TempDir("", "txindexmigration")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+
+	blockHash := wire.ShaHash{0xbb}
+	txHashes := make([]wire.ShaHash, 5)
+	for i := range txHashes {
+		txHashes[i] = wire.ShaHash{byte(i), 0xaa, 0xaa, 0xaa}
+		txHashes[i][wire.HashSize-1] = byte(5 - i)
+	}
+
+	// moveOut moves entries out of the index like the first step of a
+	// migration.  It is used to simulate migrations which were interrupted
+	// at the various steps.
+	moveOut := func(dbTx database.Tx, max int) error {
+		meta := dbTx.Metadata()
+		_, err := moveTxIndexEntries(meta.Bucket(txIndexKey),
+			meta.Bucket(txIndexMigrateBucketName), max, true)
+		return err
+	}
+	tests := []struct {
+		name        string
+		interrupted func(dbTx database.Tx) error
+	}{
+		{
+			name: "not started",
+		},
+		{
+			name: "moving out of the index",
+			interrupted: func(dbTx database.Tx) error {
+				meta := dbTx.Metadata()
+				_, err := meta.CreateBucket(txIndexMigrateBucketName)
+				if err != nil {
+					return err
+				}
+				return moveOut(dbTx, 2)
+			},
+		},
+		{
+			name: "moving back into the index",
+			interrupted: func(dbTx database.Tx) error {
+				meta := dbTx.Metadata()
+				_, err := meta.CreateBucket(txIndexMigrateBucketName)
+				if err != nil {
+					return err
+				}
+				if err := moveOut(dbTx, len(txHashes)); err != nil {
+					return err
+				}
+				err = dbPutTxIndexVersion(dbTx, txIndexVersion)
+				if err != nil {
+					return err
+				}
+				_, err = moveTxIndexEntries(
+					meta.Bucket(txIndexMigrateBucketName),
+					meta.Bucket(txIndexKey), 2, false)
+				return err
+			},
+		},
+	}
+
+	for _, test := range tests {
+		db, idx := createTxIndexTestDB(t, dbPath, test.name)
+		defer db.Close()
+
+		// Store the entries with the version 1 layout and remove the
+		// stored version like an index created before it was stored.
+		err := db.Update(func(dbTx database.Tx) error {
+			err := dbPutBlockIDIndexEntry(dbTx, &blockHash, 1)
+			if err != nil {
+				return err
+			}
+			meta := dbTx.Metadata()
+			txIndex := meta.Bucket(txIndexKey)
+			for i := range txHashes {
+				entry := make([]byte, 12)
+				putTxIndexEntry(entry, 1, wire.TxLoc{
+					TxStart: 100 * i,
+					TxLen:   i + 1,
+				})
+				err := txIndex.Put(txHashes[i][:], entry)
+				if err != nil {
+					return err
+				}
+			}
+			if err := meta.Delete(txIndexVersionKeyName); err != nil {
+				return err
+			}
+
+			if test.interrupted != nil {
+				return test.interrupted(dbTx)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: unable to store version 1 index: %v",
+				test.name, err)
+		}
+
+		if err := migrateTxIndex(db, 2); err != nil {
+			t.Fatalf("%s: migrateTxIndex: unexpected error: %v",
+				test.name, err)
+		}
+
+		// Initializing the migrated index leaves it as it is.
+		if err := idx.Init(); err != nil {
+			t.Fatalf("%s: Init: unexpected error: %v", test.name, err)
+		}
+
+		err = db.View(func(dbTx database.Tx) error {
+			meta := dbTx.Metadata()
+			if version := dbFetchTxIndexVersion(dbTx); version !=
+				txIndexVersion {
+
+				t.Errorf("%s: unexpected version - got %d, "+
+					"want %d", test.name, version,
+					txIndexVersion)
+			}
+			if meta.Bucket(txIndexMigrateBucketName) != nil {
+				t.Errorf("%s: migration bucket was not removed",
+					test.name)
+			}
+
+			var numEntries int
+			err := meta.Bucket(txIndexKey).ForEach(func(k, v []byte) error {
+				numEntries++
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if numEntries != len(txHashes) {
+				t.Errorf("%s: unexpected number of entries - got "+
+					"%d, want %d", test.name, numEntries,
+					len(txHashes))
+			}
+
+			for i := range txHashes {
+				region, err := dbFetchTxIndexEntry(dbTx, &txHashes[i])
+				if err != nil {
+					return err
+				}
+				want := &database.BlockRegion{
+					Hash:   &blockHash,
+					Offset: uint32(100 * i),
+					Len:    uint32(i + 1),
+				}
+				if !reflect.DeepEqual(region, want) {
+					t.Errorf("%s: unexpected region of %v - got "+
+						"%+v, want %+v", test.name,
+						txHashes[i], region, want)
+				}
+
+				// The last byte of the internal byte order is
+				// the first byte of the displayed hash.
+				prefix := []byte{byte(5 - i), 0, 0, 0}
+				hash, err := idx.TxHashByPrefix(dbTx, prefix)
+				if err != nil {
+					return err
+				}
+				if hash == nil || *hash != txHashes[i] {
+					t.Errorf("%s: unexpected hash for prefix "+
+						"%x - got %v, want %v", test.name,
+						prefix, hash, txHashes[i])
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,483 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package indexers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/btcsuite/fastsha256"
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+const (
+	// utxoByScriptIndexName is the human-readable name for the index.
+	utxoByScriptIndexName = "unspent outputs by script index"
+
+	// scriptKeySize is the number of bytes a script key consumes in the
+	// index.  It is the sha256 of the public key script.
+	scriptKeySize = fastsha256.Size
+
+	// utxoOutPointSize is the number of bytes the outpoint of an output
+	// consumes in a key of the index.  It consists of 32 bytes transaction
+	// hash + 4 bytes output index.
+	utxoOutPointSize = wire.HashSize + 4
+
+	// utxoKeySize is the number of bytes a key of the index consumes.  It
+	// consists of the script key + the outpoint.
+	utxoKeySize = scriptKeySize + utxoOutPointSize
+
+	// utxoValueSize is the number of bytes a value of the index consumes.
+	// It consists of 8 bytes amount + 4 bytes block height + 1 byte flags.
+	utxoValueSize = 8 + 4 + 1
+
+	// utxoFlagCoinBase is set in the flags of an entry when the output is
+	// part of a coinbase.
+	utxoFlagCoinBase = 0x01
+)
+
+var (
+	// utxoByScriptIndexKey is the key of the unspent outputs by script
+	// index and the db bucket used to house it.
+	utxoByScriptIndexKey = []byte("utxobyscriptidx")
+)
+
+// -----------------------------------------------------------------------------
+// The unspent outputs by script index maps the public key script of every
+// unspent transaction output in the main chain to the outputs which pay to it,
+// so the unspent outputs of a script can be listed without scanning the whole
+// utxo set.  Outputs are added when the transaction which creates them is
+// connected and removed again when they are spent.
+//
+// Each output is stored under its own key which starts with the sha256 of the
+// script it pays to, so all outputs of a script are adjacent in the bucket and
+// can be iterated with a cursor.  The outpoint index is serialized big endian
+// so the outputs of a transaction are iterated in order.
+//
+// The serialized key format is:
+//
+//   <script hash><tx hash><output index>
+//
+//   Field          Type        Size
+//   script hash    [32]byte    32
+//   tx hash        [32]byte    32
+//   output index   uint32      4
+//   -----
+//   Total: 68 bytes
+//
+// The serialized value format is:
+//
+//   <amount><block height><flags>
+//
+//   Field          Type        Size
+//   amount         int64       8
+//   block height   int32       4
+//   flags          byte        1
+//   -----
+//   Total: 13 bytes
+// -----------------------------------------------------------------------------
+
+// UnspentOutput describes an unspent transaction output found in the unspent
+// outputs by script index.
+type UnspentOutput struct {
+	// OutPoint identifies the output.
+	OutPoint wire.OutPoint
+
+	// Amount is the value of the output.
+	Amount int64
+
+	// Height is the height of the block which contains the transaction the
+	// output is part of.
+	Height int32
+
+	// IsCoinBase is whether or not the output is part of a coinbase.
+	IsCoinBase bool
+}
+
+// scriptKey returns the key which identifies the passed public key script in
+// the index.
+func scriptKey(pkScript []byte) [scriptKeySize]byte {
+	return fastsha256.Sum256(pkScript)
+}
+
+// utxoKey returns the key of the index entry for the passed outpoint of an
+// output which pays to the script with the passed key.
+func utxoKey(scriptKey [scriptKeySize]byte, outPoint *wire.OutPoint) []byte {
+	key := make([]byte, utxoKeySize)
+	copy(key, scriptKey[:])
+	copy(key[scriptKeySize:], outPoint.Hash[:])
+	binary.BigEndian.PutUint32(key[scriptKeySize+wire.HashSize:],
+		outPoint.Index)
+	return key
+}
+
+// serializeUtxoValue returns the value of the index entry for an output with
+// the passed details.
+func serializeUtxoValue(amount int64, height int32, isCoinBase bool) []byte {
+	serialized := make([]byte, utxoValueSize)
+	byteOrder.PutUint64(serialized, uint64(amount))
+	byteOrder.PutUint32(serialized[8:], uint32(height))
+	if isCoinBase {
+		serialized[12] |= utxoFlagCoinBase
+	}
+	return serialized
+}
+
+// deserializeUtxoEntry decodes the passed key and value of an index entry into
+// the unspent output they describe.
+func deserializeUtxoEntry(key, serialized []byte) (UnspentOutput, error) {
+	var output UnspentOutput
+	if len(key) != utxoKeySize || len(serialized) != utxoValueSize {
+		return output, errDeserialize("unexpected size of unspent " +
+			"output entry")
+	}
+	copy(output.OutPoint.Hash[:], key[scriptKeySize:])
+	output.OutPoint.Index = binary.BigEndian.Uint32(
+		key[scriptKeySize+wire.HashSize:])
+	output.Amount = int64(byteOrder.Uint64(serialized))
+	output.Height = int32(byteOrder.Uint32(serialized[8:]))
+	output.IsCoinBase = serialized[12]&utxoFlagCoinBase != 0
+	return output, nil
+}
+
+// UtxoByScriptIndex implements an index of the unspent transaction outputs in
+// the main chain keyed by the public key script they pay to.
+type UtxoByScriptIndex struct {
+	db          database.DB
+	chainParams *chaincfg.Params
+}
+
+// Ensure the UtxoByScriptIndex type implements the Indexer interface.
+var _ Indexer = (*UtxoByScriptIndex)(nil)
+
+// Ensure the UtxoByScriptIndex type implements the NeedsInputser interface.
+var _ NeedsInputser = (*UtxoByScriptIndex)(nil)
+
+// NeedsInputs signals that the index requires the referenced inputs in order
+// to properly create the index.
+//
+// This implements the NeedsInputser interface.
+func (idx *UtxoByScriptIndex) NeedsInputs() bool {
+	return true
+}
+
+// Init is only provided to satisfy the Indexer interface as there is nothing to
+// initialize for this index.
+//
+// This is part of the Indexer interface.
+func (idx *UtxoByScriptIndex) Init() error {
+	// Nothing to do.
+	return nil
+}
+
+// Key returns the database key to use for the index as a byte slice.
+//
+// This is part of the Indexer interface.
+func (idx *UtxoByScriptIndex) Key() []byte {
+	return utxoByScriptIndexKey
+}
+
+// Name returns the human-readable name of the index.
+//
+// This is part of the Indexer interface.
+func (idx *UtxoByScriptIndex) Name() string {
+	return utxoByScriptIndexName
+}
+
+// Create is invoked when the indexer manager determines the index needs
+// to be created for the first time.  It creates the bucket for the index.
+//
+// This is part of the Indexer interface.
+func (idx *UtxoByScriptIndex) Create(dbTx database.Tx) error {
+	_, err := dbTx.Metadata().CreateBucket(utxoByScriptIndexKey)
+	return err
+}
+
+// ConnectBlock is invoked by the index manager when a new block has been
+// connected to the main chain.  This indexer removes the outputs spent by the
+// transactions in the block and adds the outputs they create.  The transactions
+// are processed in order, so outputs which are created and spent within the
+// block are never left in the index.
+//
+// This is part of the Indexer interface.
+func (idx *UtxoByScriptIndex) ConnectBlock(dbTx database.Tx, block *colxutil.Block, view *blockchain.UtxoViewpoint) error {
+	blockCtx, err := blockchain.NewBlockContext(block, view)
+	if err != nil {
+		return err
+	}
+	bucket := dbTx.Metadata().Bucket(utxoByScriptIndexKey)
+	for txIdx := 0; txIdx < blockCtx.NumTxns(); txIdx++ {
+		txCtx := blockCtx.Tx(txIdx)
+		tx := txCtx.Tx()
+		if txCtx.HasMissingInputs() {
+			return AssertError(fmt.Sprintf("missing input for "+
+				"transaction %v", tx.Sha()))
+		}
+
+		// Coinbases do not spend any outputs.
+		if !txCtx.IsCoinBase() {
+			for txInIdx, txIn := range tx.MsgTx().TxIn {
+				originOut := &txIn.PreviousOutPoint
+				pkScript := txCtx.PrevScript(txInIdx)
+				key := utxoKey(scriptKey(pkScript), originOut)
+				if err := bucket.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+
+		outPoint := wire.OutPoint{Hash: *tx.Sha()}
+		for txOutIdx, txOut := range tx.MsgTx().TxOut {
+			outPoint.Index = uint32(txOutIdx)
+			key := utxoKey(scriptKey(txOut.PkScript), &outPoint)
+			value := serializeUtxoValue(txOut.Value, block.Height(),
+				txIdx == 0)
+			if err := bucket.Put(key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// DisconnectBlock is invoked by the index manager when a block has been
+// disconnected from the main chain.  This indexer removes the outputs created
+// by the transactions in the block and restores the outputs they spent from the
+// details in the passed view.
+//
+// NOTE: The view the index manager creates when it disconnects blocks the index
+// is ahead of the main chain by on startup does not know the heights of the
+// spent outputs, so those are restored with a height of zero.
+//
+// This is part of the Indexer interface.
+func (idx *UtxoByScriptIndex) DisconnectBlock(dbTx database.Tx, block *colxutil.Block, view *blockchain.UtxoViewpoint) error {
+	// Outputs which are created and spent within the block are not
+	// restored since they are removed along with the other outputs created
+	// by the block.  The view no longer has their details either.
+	transactions := block.Transactions()
+	blockTxns := make(map[wire.ShaHash]struct{}, len(transactions))
+	for _, tx := range transactions {
+		blockTxns[*tx.Sha()] = struct{}{}
+	}
+
+	bucket := dbTx.Metadata().Bucket(utxoByScriptIndexKey)
+	for txIdx, tx := range transactions {
+		outPoint := wire.OutPoint{Hash: *tx.Sha()}
+		for txOutIdx, txOut := range tx.MsgTx().TxOut {
+			outPoint.Index = uint32(txOutIdx)
+			key := utxoKey(scriptKey(txOut.PkScript), &outPoint)
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+
+		// Coinbases do not spend any outputs.
+		if txIdx == 0 {
+			continue
+		}
+		for _, txIn := range tx.MsgTx().TxIn {
+			originOut := &txIn.PreviousOutPoint
+			if _, ok := blockTxns[originOut.Hash]; ok {
+				continue
+			}
+			entry := view.LookupEntry(&originOut.Hash)
+			if entry == nil {
+				return AssertError(fmt.Sprintf("missing input %v "+
+					"for transaction %v", originOut, tx.Sha()))
+			}
+			pkScript := entry.PkScriptByIndex(originOut.Index)
+			key := utxoKey(scriptKey(pkScript), originOut)
+			value := serializeUtxoValue(
+				entry.AmountByIndex(originOut.Index),
+				entry.BlockHeight(), entry.IsCoinBase())
+			if err := bucket.Put(key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// UnspentOutputsForScript returns up to the passed limit of unspent outputs
+// which pay to the passed public key script, along with a cursor to pass in
+// order to continue with the outputs after them.  A nil cursor starts with the
+// first output and a nil cursor is returned once there are no more outputs.  A
+// limit of zero or less returns all of the remaining outputs.
+//
+// Every call reflects the main chain at the time it is made, so the outputs
+// returned by multiple calls are not a single consistent snapshot when blocks
+// are connected in the meantime.
+//
+// This function is safe for concurrent access.
+func (idx *UtxoByScriptIndex) UnspentOutputsForScript(pkScript []byte, limit int, cursor []byte) ([]UnspentOutput, []byte, error) {
+	if cursor != nil && len(cursor) != utxoOutPointSize {
+		return nil, nil, fmt.Errorf("invalid cursor of %d bytes",
+			len(cursor))
+	}
+
+	prefix := scriptKey(pkScript)
+	seek := make([]byte, scriptKeySize, utxoKeySize)
+	copy(seek, prefix[:])
+	seek = append(seek, cursor...)
+
+	var outputs []UnspentOutput
+	var next []byte
+	err := idx.db.View(func(dbTx database.Tx) error {
+		c := dbTx.Metadata().Bucket(utxoByScriptIndexKey).Cursor()
+		ok := c.Seek(seek)
+		if ok && cursor != nil && bytes.Equal(c.Key(), seek) {
+			ok = c.Next()
+		}
+		for ; ok; ok = c.Next() {
+			key := c.Key()
+			if !bytes.HasPrefix(key, prefix[:]) {
+				break
+			}
+			if limit > 0 && len(outputs) == limit {
+				last := outputs[len(outputs)-1].OutPoint
+				next = make([]byte, utxoOutPointSize)
+				copy(next, last.Hash[:])
+				binary.BigEndian.PutUint32(next[wire.HashSize:],
+					last.Index)
+				break
+			}
+			output, err := deserializeUtxoEntry(key, c.Value())
+			if err != nil {
+				return err
+			}
+			outputs = append(outputs, output)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return outputs, next, nil
+}
+
+// UtxoEntryFetcher returns the utxo set entry for the transaction with the
+// passed hash, or nil when the transaction has no unspent outputs.  The
+// FetchUtxoEntry method of the blockchain satisfies it.
+type UtxoEntryFetcher func(txHash *wire.ShaHash) (*blockchain.UtxoEntry, error)
+
+// CheckConsistency verifies up to the passed number of entries of the index
+// against the utxo set as provided by the passed fetch function and returns the
+// number of entries which were verified.  The entries are sampled starting from
+// a random position in the index, wrapping around at its end, so repeated
+// checks cover different parts of it.  An error describing the first entry
+// found which is not an unspent output in the utxo set with the same script,
+// amount, height, and coinbase flag is returned.
+//
+// The index and the utxo set must not be modified while they are checked,
+// which is the case when the chain is not processing blocks.
+func (idx *UtxoByScriptIndex) CheckConsistency(fetchEntry UtxoEntryFetcher, maxSamples int) (int, error) {
+	start := make([]byte, scriptKeySize)
+	if _, err := rand.Read(start); err != nil {
+		return 0, err
+	}
+
+	var samples []UnspentOutput
+	var scriptKeys [][scriptKeySize]byte
+	err := idx.db.View(func(dbTx database.Tx) error {
+		c := dbTx.Metadata().Bucket(utxoByScriptIndexKey).Cursor()
+		ok := c.Seek(start)
+		wrapped := false
+		for len(samples) < maxSamples {
+			if !ok {
+				// Stop once the whole index was sampled.
+				if wrapped {
+					break
+				}
+				wrapped = true
+				ok = c.First()
+				continue
+			}
+			key := c.Key()
+			if wrapped && bytes.Compare(key, start) >= 0 {
+				break
+			}
+			output, err := deserializeUtxoEntry(key, c.Value())
+			if err != nil {
+				return err
+			}
+			var sk [scriptKeySize]byte
+			copy(sk[:], key)
+			samples = append(samples, output)
+			scriptKeys = append(scriptKeys, sk)
+			ok = c.Next()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, output := range samples {
+		outPoint := &output.OutPoint
+		entry, err := fetchEntry(&outPoint.Hash)
+		if err != nil {
+			return i, err
+		}
+		if entry == nil || entry.IsOutputSpent(outPoint.Index) {
+			return i, fmt.Errorf("indexed output %v is not in the "+
+				"utxo set", outPoint)
+		}
+		pkScript := entry.PkScriptByIndex(outPoint.Index)
+		if scriptKey(pkScript) != scriptKeys[i] {
+			return i, fmt.Errorf("indexed output %v has a different "+
+				"script in the utxo set", outPoint)
+		}
+		if amount := entry.AmountByIndex(outPoint.Index); amount != output.Amount {
+			return i, fmt.Errorf("indexed output %v has amount %d, "+
+				"but %d in the utxo set", outPoint, output.Amount,
+				amount)
+		}
+		if height := entry.BlockHeight(); height != output.Height {
+			return i, fmt.Errorf("indexed output %v has height %d, "+
+				"but %d in the utxo set", outPoint, output.Height,
+				height)
+		}
+		if entry.IsCoinBase() != output.IsCoinBase {
+			return i, fmt.Errorf("indexed output %v has coinbase "+
+				"flag %v, but %v in the utxo set", outPoint,
+				output.IsCoinBase, entry.IsCoinBase())
+		}
+	}
+
+	return len(samples), nil
+}
+
+// NewUtxoByScriptIndex returns a new instance of an indexer that is used to
+// create a mapping of the public key scripts of all unspent transaction outputs
+// in the main chain to the outputs which pay to them.
+//
+// It implements the Indexer interface which plugs into the IndexManager that in
+// turn is used by the blockchain package.  This allows the index to be
+// seamlessly maintained along with the chain.
+func NewUtxoByScriptIndex(db database.DB, chainParams *chaincfg.Params) *UtxoByScriptIndex {
+	return &UtxoByScriptIndex{
+		db:          db,
+		chainParams: chainParams,
+	}
+}
+
+// DropUtxoByScriptIndex drops the unspent outputs by script index from the
+// provided database if it exists.  The drop is stopped when the passed
+// interrupt channel is closed and resumed the next time the index is dropped or
+// enabled.
+func DropUtxoByScriptIndex(db database.DB, interrupt <-chan struct{}) error {
+	return dropIndex(db, utxoByScriptIndexKey, utxoByScriptIndexName,
+		interrupt)
+}
@@ -0,0 +1,429 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package indexers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	_ "github.com/tinhnguyenhn/colxd/database/ffldb"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// testUtxoSet tracks the utxo set of a simulated chain in order to provide the
+// views the chain passes to the indexers and to check the index against it.
+type testUtxoSet struct {
+	txns    map[wire.ShaHash]*colxutil.Tx
+	heights map[wire.ShaHash]int32
+	spent   map[wire.OutPoint]struct{}
+}
+
+// newTestUtxoSet returns an empty simulated utxo set.
+func newTestUtxoSet() *testUtxoSet {
+	return &testUtxoSet{
+		txns:    make(map[wire.ShaHash]*colxutil.Tx),
+		heights: make(map[wire.ShaHash]int32),
+		spent:   make(map[wire.OutPoint]struct{}),
+	}
+}
+
+// inputView returns a view with the outputs spent by the passed block which are
+// not created by the block itself, as they were before the block connected.
+func (s *testUtxoSet) inputView(block *colxutil.Block) *blockchain.UtxoViewpoint {
+	view := blockchain.NewUtxoViewpoint()
+	for _, tx := range block.Transactions()[1:] {
+		for _, txIn := range tx.MsgTx().TxIn {
+			origin, ok := s.txns[txIn.PreviousOutPoint.Hash]
+			if ok {
+				view.AddTxOuts(origin, s.heights[*origin.Sha()])
+			}
+		}
+	}
+	return view
+}
+
+// connect connects the passed block to the index and the simulated utxo set.
+func (s *testUtxoSet) connect(db database.DB, idx Indexer, block *colxutil.Block) error {
+	view := s.inputView(block)
+	for _, tx := range block.Transactions() {
+		view.AddTxOuts(tx, block.Height())
+	}
+	err := db.Update(func(dbTx database.Tx) error {
+		return idx.ConnectBlock(dbTx, block, view)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range block.Transactions() {
+		s.txns[*tx.Sha()] = tx
+		s.heights[*tx.Sha()] = block.Height()
+		if blockchain.IsCoinBase(tx) {
+			continue
+		}
+		for _, txIn := range tx.MsgTx().TxIn {
+			s.spent[txIn.PreviousOutPoint] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// disconnect disconnects the passed block from the index and the simulated
+// utxo set.
+func (s *testUtxoSet) disconnect(db database.DB, idx Indexer, block *colxutil.Block) error {
+	for _, tx := range block.Transactions() {
+		delete(s.txns, *tx.Sha())
+		delete(s.heights, *tx.Sha())
+		if blockchain.IsCoinBase(tx) {
+			continue
+		}
+		for _, txIn := range tx.MsgTx().TxIn {
+			delete(s.spent, txIn.PreviousOutPoint)
+		}
+	}
+
+	view := s.inputView(block)
+	return db.Update(func(dbTx database.Tx) error {
+		return idx.DisconnectBlock(dbTx, block, view)
+	})
+}
+
+// fetchEntry returns the utxo set entry for the transaction with the passed
+// hash in the simulated utxo set.
+//
+// This satisfies the UtxoEntryFetcher type.
+func (s *testUtxoSet) fetchEntry(txHash *wire.ShaHash) (*blockchain.UtxoEntry, error) {
+	tx, ok := s.txns[*txHash]
+	if !ok {
+		return nil, nil
+	}
+	view := blockchain.NewUtxoViewpoint()
+	view.AddTxOuts(tx, s.heights[*txHash])
+	entry := view.LookupEntry(txHash)
+	for i := range tx.MsgTx().TxOut {
+		outPoint := wire.OutPoint{Hash: *txHash, Index: uint32(i)}
+		if _, ok := s.spent[outPoint]; ok {
+			entry.SpendOutput(uint32(i))
+		}
+	}
+	if entry.IsFullySpent() {
+		return nil, nil
+	}
+	return entry, nil
+}
+
+// utxoTestBlock returns a block at the passed height with a coinbase which pays
+// increasing amounts to each of the passed scripts, followed by the passed
+// transactions.  The coinbase signature script commits to the height and the
+// number of transactions so blocks of competing branches have different
+// coinbases.
+func utxoTestBlock(height int32, coinbaseScripts [][]byte, txns ...*wire.MsgTx) *colxutil.Block {
+	coinbase := wire.NewMsgTx()
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&wire.ShaHash{},
+		wire.MaxPrevOutIndex), []byte{byte(height), byte(len(txns))}))
+	for i, pkScript := range coinbaseScripts {
+		coinbase.AddTxOut(wire.NewTxOut(int64(i+1)*1e8, pkScript))
+	}
+	msgBlock := &wire.MsgBlock{
+		Transactions: append([]*wire.MsgTx{coinbase}, txns...),
+	}
+	block := colxutil.NewBlock(msgBlock)
+	block.SetHeight(height)
+	return block
+}
+
+// utxoTestSpend returns a transaction which spends the passed output and pays
+// the passed amount to the passed script.
+func utxoTestSpend(tx *wire.MsgTx, index uint32, amount int64, pkScript []byte) *wire.MsgTx {
+	txHash := tx.TxSha()
+	spend := wire.NewMsgTx()
+	spend.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&txHash, index), nil))
+	spend.AddTxOut(wire.NewTxOut(amount, pkScript))
+	return spend
+}
+
+// setupUtxoByScriptIndex creates a database with the unspent outputs by script
+// index and returns it along with a function to remove it.
+func setupUtxoByScriptIndex(t *testing.T) (database.DB, *UtxoByScriptIndex, func()) {
+	dbPath, err := ioutil.TempDir("", "utxobyscriptidx")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		wire.MainNet)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("unable to create database: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+
+	idx := NewUtxoByScriptIndex(db, &chaincfg.MainNetParams)
+	if err := db.Update(idx.Create); err != nil {
+		teardown()
+		t.Fatalf("unable to create index: %v", err)
+	}
+	return db, idx, teardown
+}
+
+// TestUtxoByScriptIndexReorg ensures outputs are added to and removed from the
+// unspent outputs by script index as they flip between spent and unspent while
+// blocks of competing branches are connected and disconnected, and that the
+// index stays consistent with the utxo set.
+func TestUtxoByScriptIndexReorg(t *testing.T) {
+	db, idx, teardown := setupUtxoByScriptIndex(t)
+	defer teardown()
+
+	scriptA := []byte{0x51}
+	scriptB := []byte{0x52}
+	scriptC := []byte{0x53}
+
+	// Two blocks which create the outputs the branches spend.
+	block1 := utxoTestBlock(1, [][]byte{scriptA, scriptB})
+	block2 := utxoTestBlock(2, [][]byte{scriptA})
+	cb1 := block1.MsgBlock().Transactions[0]
+	cb2 := block2.MsgBlock().Transactions[0]
+
+	// The block of the first branch spends the output of the first block
+	// paying to script A, and spends the new output again in the same
+	// block.  The block of the second branch spends the output paying to
+	// script B instead.
+	spendX := utxoTestSpend(cb1, 0, 9e7, scriptB)
+	spendSpendX := utxoTestSpend(spendX, 0, 8e7, scriptC)
+	block3X := utxoTestBlock(3, [][]byte{scriptC}, spendX, spendSpendX)
+	spendY := utxoTestSpend(cb1, 1, 15e7, scriptA)
+	block3Y := utxoTestBlock(3, [][]byte{scriptC}, spendY)
+
+	out := func(tx *wire.MsgTx, index uint32, amount int64, height int32, coinbase bool) UnspentOutput {
+		return UnspentOutput{
+			OutPoint:   wire.OutPoint{Hash: tx.TxSha(), Index: index},
+			Amount:     amount,
+			Height:     height,
+			IsCoinBase: coinbase,
+		}
+	}
+	cb1A := out(cb1, 0, 1e8, 1, true)
+	cb1B := out(cb1, 1, 2e8, 1, true)
+	cb2A := out(cb2, 0, 1e8, 2, true)
+
+	utxos := newTestUtxoSet()
+	tests := []struct {
+		name       string
+		connect    *colxutil.Block
+		disconnect *colxutil.Block
+		want       map[string][]UnspentOutput
+	}{
+		{
+			name:    "connect block 1",
+			connect: block1,
+			want: map[string][]UnspentOutput{
+				"A": {cb1A},
+				"B": {cb1B},
+			},
+		},
+		{
+			name:    "connect block 2",
+			connect: block2,
+			want: map[string][]UnspentOutput{
+				"A": {cb1A, cb2A},
+				"B": {cb1B},
+			},
+		},
+		{
+			name:    "connect branch X",
+			connect: block3X,
+			want: map[string][]UnspentOutput{
+				"A": {cb2A},
+				"B": {cb1B},
+				"C": {
+					out(block3X.MsgBlock().Transactions[0], 0,
+						1e8, 3, true),
+					out(spendSpendX, 0, 8e7, 3, false),
+				},
+			},
+		},
+		{
+			name:       "disconnect branch X",
+			disconnect: block3X,
+			want: map[string][]UnspentOutput{
+				"A": {cb1A, cb2A},
+				"B": {cb1B},
+			},
+		},
+		{
+			name:    "connect branch Y",
+			connect: block3Y,
+			want: map[string][]UnspentOutput{
+				"A": {cb1A, cb2A, out(spendY, 0, 15e7, 3, false)},
+				"C": {out(block3Y.MsgBlock().Transactions[0], 0,
+					1e8, 3, true)},
+			},
+		},
+		{
+			name:       "disconnect branch Y",
+			disconnect: block3Y,
+			want: map[string][]UnspentOutput{
+				"A": {cb1A, cb2A},
+				"B": {cb1B},
+			},
+		},
+		{
+			name:    "reconnect branch X",
+			connect: block3X,
+			want: map[string][]UnspentOutput{
+				"A": {cb2A},
+				"B": {cb1B},
+				"C": {
+					out(block3X.MsgBlock().Transactions[0], 0,
+						1e8, 3, true),
+					out(spendSpendX, 0, 8e7, 3, false),
+				},
+			},
+		},
+	}
+
+	scripts := map[string][]byte{"A": scriptA, "B": scriptB, "C": scriptC}
+	for _, test := range tests {
+		var err error
+		if test.connect != nil {
+			err = utxos.connect(db, idx, test.connect)
+		} else {
+			err = utxos.disconnect(db, idx, test.disconnect)
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+
+		numOutputs := 0
+		for name, pkScript := range scripts {
+			outputs, cursor, err := idx.UnspentOutputsForScript(
+				pkScript, 0, nil)
+			if err != nil {
+				t.Fatalf("%s: UnspentOutputsForScript: unexpected "+
+					"error: %v", test.name, err)
+			}
+			if cursor != nil {
+				t.Fatalf("%s: UnspentOutputsForScript: unexpected "+
+					"cursor %x", test.name, cursor)
+			}
+			numOutputs += len(outputs)
+
+			// The order of the outputs of different transactions
+			// depends on their hashes.
+			want := test.want[name]
+			if len(outputs) != len(want) {
+				t.Fatalf("%s: unexpected number of outputs for "+
+					"script %s - got %d, want %d", test.name,
+					name, len(outputs), len(want))
+			}
+			for _, wantOutput := range want {
+				found := false
+				for _, output := range outputs {
+					if output == wantOutput {
+						found = true
+					}
+				}
+				if !found {
+					t.Fatalf("%s: missing output %+v for script "+
+						"%s - got %+v", test.name, wantOutput,
+						name, outputs)
+				}
+			}
+		}
+
+		checked, err := idx.CheckConsistency(utxos.fetchEntry, 100)
+		if err != nil {
+			t.Fatalf("%s: CheckConsistency: unexpected error: %v",
+				test.name, err)
+		}
+		if checked != numOutputs {
+			t.Fatalf("%s: CheckConsistency: checked %d entries, want "+
+				"%d", test.name, checked, numOutputs)
+		}
+	}
+
+	// Spending an output in the utxo set without updating the index must
+	// be detected by the consistency check.
+	utxos.spent[wire.OutPoint{Hash: cb2.TxSha()}] = struct{}{}
+	if _, err := idx.CheckConsistency(utxos.fetchEntry, 100); err == nil {
+		t.Fatal("CheckConsistency: did not detect spent output")
+	}
+}
+
+// TestUnspentOutputsForScriptPagination ensures the unspent outputs of a script
+// are returned in pages of the requested size which together contain every
+// output once.
+func TestUnspentOutputsForScriptPagination(t *testing.T) {
+	db, idx, teardown := setupUtxoByScriptIndex(t)
+	defer teardown()
+
+	pkScript := []byte{0x51}
+	coinbaseScripts := make([][]byte, 10)
+	for i := range coinbaseScripts {
+		coinbaseScripts[i] = pkScript
+	}
+	block := utxoTestBlock(1, append(coinbaseScripts, []byte{0x52}))
+	utxos := newTestUtxoSet()
+	if err := utxos.connect(db, idx, block); err != nil {
+		t.Fatalf("ConnectBlock: unexpected error: %v", err)
+	}
+
+	all, _, err := idx.UnspentOutputsForScript(pkScript, 0, nil)
+	if err != nil {
+		t.Fatalf("UnspentOutputsForScript: unexpected error: %v", err)
+	}
+	if len(all) != len(coinbaseScripts) {
+		t.Fatalf("UnspentOutputsForScript: unexpected number of outputs "+
+			"- got %d, want %d", len(all), len(coinbaseScripts))
+	}
+	for i, output := range all {
+		if output.OutPoint.Index != uint32(i) {
+			t.Fatalf("UnspentOutputsForScript: unexpected output #%d "+
+				"- got %v", i, output.OutPoint)
+		}
+	}
+
+	var paged []UnspentOutput
+	var cursor []byte
+	for pages := 0; ; pages++ {
+		if pages > len(all) {
+			t.Fatal("UnspentOutputsForScript: cursor never ends")
+		}
+		var outputs []UnspentOutput
+		outputs, cursor, err = idx.UnspentOutputsForScript(pkScript, 3,
+			cursor)
+		if err != nil {
+			t.Fatalf("UnspentOutputsForScript: unexpected error: %v",
+				err)
+		}
+		if len(outputs) > 3 {
+			t.Fatalf("UnspentOutputsForScript: got %d outputs with "+
+				"limit 3", len(outputs))
+		}
+		paged = append(paged, outputs...)
+		if cursor == nil {
+			break
+		}
+	}
+	if !reflect.DeepEqual(paged, all) {
+		t.Fatalf("UnspentOutputsForScript: pages %+v do not match all "+
+			"outputs %+v", paged, all)
+	}
+
+	_, _, err = idx.UnspentOutputsForScript(pkScript, 3, []byte{0x01})
+	if err == nil {
+		t.Fatal("UnspentOutputsForScript: did not reject invalid cursor")
+	}
+}
@@ -14,8 +14,14 @@ run.
 package blockchain
 
 import (
+	"context"
 	"sort"
 	"time"
+
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
 )
 
 // TstSetCoinbaseMaturity makes the ability to set the coinbase maturity
@@ -42,8 +48,94 @@ func TstSetMaxMedianTimeEntries(val int) {
 
 // TstCheckBlockScripts makes the internal checkBlockScripts function available
 // to the test package.
-var TstCheckBlockScripts = checkBlockScripts
+func TstCheckBlockScripts(block *colxutil.Block, utxoView *UtxoViewpoint, scriptFlags txscript.ScriptFlags, sigCache *txscript.SigCache) error {
+	return checkBlockScripts(context.Background(), block, utxoView,
+		scriptFlags, sigCache)
+}
+
+// TstCheckBlockScriptsCtx makes the internal checkBlockScripts function
+// available to the test package along with the context it accepts.
+var TstCheckBlockScriptsCtx = checkBlockScripts
 
 // TstDeserializeUtxoEntry makes the internal deserializeUtxoEntry function
 // available to the test package.
 var TstDeserializeUtxoEntry = deserializeUtxoEntry
+
+// TstSetValidateHook sets a function which is invoked with the hash of each
+// block the passed chain instance fully validates.
+func TstSetValidateHook(chain *BlockChain, hook func(*wire.ShaHash)) {
+	chain.validateHook = hook
+}
+
+// TstSetNotifications sets the notification callback for the passed chain
+// instance.
+func TstSetNotifications(chain *BlockChain, callback NotificationCallback) {
+	chain.notifications = callback
+}
+
+// TstSetScriptsHook sets a function which is invoked with the hash of each
+// block the passed chain instance checks the transactions of while connecting
+// it along with whether or not its scripts are validated.
+func TstSetScriptsHook(chain *BlockChain, hook func(*wire.ShaHash, bool)) {
+	chain.scriptsHook = hook
+}
+
+// TstSetAuditHook sets a function which is invoked with the hash of each block
+// the passed chain instance connects or disconnects along with the utxo view
+// used to do so before the utxo delta audit is performed.
+func TstSetAuditHook(chain *BlockChain, hook func(*wire.ShaHash, *UtxoViewpoint)) {
+	chain.auditHook = hook
+}
+
+// TstSetAssumeValid sets the assumevalid block for the passed chain instance
+// along with the depth a block must be buried under the best known header for
+// its scripts to be assumed valid.
+func TstSetAssumeValid(chain *BlockChain, hash *wire.ShaHash, depth int32) {
+	chain.chainLock.Lock()
+	chain.assumeValid = hash
+	chain.assumeValidDepth = depth
+	chain.resetAssumeValid()
+	chain.chainLock.Unlock()
+}
+
+// TstSetPrune enables pruning for the passed chain instance with the given
+// target size in bytes and depth and prunes the chain down to the target.
+func TstSetPrune(chain *BlockChain, target uint64, depth int32) error {
+	chain.chainLock.Lock()
+	defer chain.chainLock.Unlock()
+	chain.pruneTarget = target
+	chain.pruneDepth = depth
+	return chain.initPruneState()
+}
+
+// TstDB returns the database of the passed chain instance so a new instance
+// can be created on it in order to test restarting the chain.
+func TstDB(chain *BlockChain) database.DB {
+	return chain.db
+}
+
+// TstSetTimeSource sets the median time source of the passed chain instance.
+func TstSetTimeSource(chain *BlockChain, timeSource MedianTimeSource) {
+	chain.timeSource = timeSource
+}
+
+// TstFutureBlockTimer is a scheduled processing of a held future block which
+// can be canceled.
+type TstFutureBlockTimer interface {
+	Stop() bool
+}
+
+// TstSetFutureBlockSchedule sets the function the passed chain instance uses
+// to schedule blocks which are held until their timestamp is no longer too far
+// in the future to be processed again.
+func TstSetFutureBlockSchedule(chain *BlockChain, schedule func(time.Duration, func()) TstFutureBlockTimer) {
+	chain.futureSchedule = func(d time.Duration, f func()) futureBlockTimer {
+		return schedule(d, f)
+	}
+}
+
+// TstFuzzCheckBlockSanity makes the internal fuzzCheckBlockSanity function
+// available to the test package.
+func TstFuzzCheckBlockSanity(data []byte) int {
+	return fuzzCheckBlockSanity(data)
+}
@@ -0,0 +1,361 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+const (
+	// DefaultMaxInvalidBlocks is the default maximum number of blocks
+	// which are stored as invalid.
+	DefaultMaxInvalidBlocks = 10
+
+	// DefaultMaxInvalidBlockBytes is the default maximum combined
+	// serialized size of the blocks which are stored as invalid.
+	DefaultMaxInvalidBlockBytes = 10 * wire.MaxBlockPayload
+)
+
+var (
+	// invalidBlocksBucketName is the name of the db bucket used to house
+	// the blocks which failed the checks performed when connecting them.
+	invalidBlocksBucketName = []byte("invalidblocks")
+)
+
+// -----------------------------------------------------------------------------
+// The invalid blocks bucket houses the blocks which failed the checks performed
+// when connecting them along with the reason they were rejected.  The key of
+// each entry is the hash of the block.
+//
+// The serialized format is:
+//
+//   <sequence><height><reason len><reason><block>
+//
+//   Field             Type     Size
+//   sequence          uint64   8 bytes
+//   height            uint32   4 bytes
+//   reason len        uint32   4 bytes
+//   reason            string   reason len
+//   block             []byte   variable
+//
+// The sequence number orders the entries by the time they were stored so the
+// oldest ones are evicted first.
+// -----------------------------------------------------------------------------
+
+// invalidBlock houses the details of a block which is stored as invalid.  The
+// block itself is only kept in the database.
+type invalidBlock struct {
+	hash     wire.ShaHash
+	prevHash wire.ShaHash
+	height   int32
+	seq      uint64
+	size     int
+	reason   string
+}
+
+// serializeInvalidBlock returns the serialization of the passed invalid block
+// entry along with the passed serialized block.  This is data to be stored in
+// the invalid blocks bucket.
+func serializeInvalidBlock(entry *invalidBlock, blockBytes []byte) []byte {
+	serialized := make([]byte, 16+len(entry.reason)+len(blockBytes))
+	byteOrder.PutUint64(serialized[0:8], entry.seq)
+	byteOrder.PutUint32(serialized[8:12], uint32(entry.height))
+	byteOrder.PutUint32(serialized[12:16], uint32(len(entry.reason)))
+	offset := 16 + copy(serialized[16:], entry.reason)
+	copy(serialized[offset:], blockBytes)
+	return serialized
+}
+
+// deserializeInvalidBlock deserializes the passed serialized invalid block
+// entry for the block with the passed hash.  It returns the entry along with
+// the serialized block, which references the passed slice.
+func deserializeInvalidBlock(hash *wire.ShaHash, serialized []byte) (*invalidBlock, []byte, error) {
+	corruptErr := database.Error{
+		ErrorCode: database.ErrCorruption,
+		Description: fmt.Sprintf("corrupt invalid block entry for %v",
+			hash),
+	}
+	if len(serialized) < 16 {
+		return nil, nil, corruptErr
+	}
+	reasonLen := int(byteOrder.Uint32(serialized[12:16]))
+	if len(serialized)-16 < reasonLen {
+		return nil, nil, corruptErr
+	}
+	blockBytes := serialized[16+reasonLen:]
+
+	var header wire.BlockHeader
+	if err := header.Deserialize(bytes.NewReader(blockBytes)); err != nil {
+		return nil, nil, corruptErr
+	}
+
+	entry := &invalidBlock{
+		hash:     *hash,
+		prevHash: header.PrevBlock,
+		height:   int32(byteOrder.Uint32(serialized[8:12])),
+		seq:      byteOrder.Uint64(serialized[0:8]),
+		size:     len(serialized),
+		reason:   string(serialized[16 : 16+reasonLen]),
+	}
+	return entry, blockBytes, nil
+}
+
+// initInvalidBlocks loads the details of the blocks stored as invalid from the
+// database.  They are loaded even when storing invalid blocks is disabled so
+// the blocks remain rejected until they are reconsidered.
+func (b *BlockChain) initInvalidBlocks() error {
+	b.invalidBlocks = make(map[wire.ShaHash]*invalidBlock)
+	return b.db.View(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(invalidBlocksBucketName)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var hash wire.ShaHash
+			copy(hash[:], k)
+			entry, _, err := deserializeInvalidBlock(&hash, v)
+			if err != nil {
+				return err
+			}
+			b.invalidBlocks[hash] = entry
+			b.invalidBytes += entry.size
+			if entry.seq >= b.invalidSeq {
+				b.invalidSeq = entry.seq + 1
+			}
+			return nil
+		})
+	})
+}
+
+// storeInvalidBlock stores the passed block as invalid along with the reason it
+// was rejected when storing invalid blocks is enabled.  The oldest stored
+// blocks are evicted as needed to respect the configured limits.  Blocks which
+// are larger than the limit on their combined size are not stored.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) storeInvalidBlock(block *colxutil.Block, height int32, reason string) error {
+	if !b.storeInvalid {
+		return nil
+	}
+	blockHash := block.Sha()
+	if _, exists := b.invalidBlocks[*blockHash]; exists {
+		return nil
+	}
+
+	blockBytes, err := block.Bytes()
+	if err != nil {
+		return err
+	}
+	entry := &invalidBlock{
+		hash:     *blockHash,
+		prevHash: block.MsgBlock().Header.PrevBlock,
+		height:   height,
+		seq:      b.invalidSeq,
+		reason:   reason,
+	}
+	serialized := serializeInvalidBlock(entry, blockBytes)
+	entry.size = len(serialized)
+	if entry.size > b.maxInvalidBytes {
+		log.Debugf("Not storing invalid block %v since its size of %d "+
+			"bytes exceeds the limit", blockHash, entry.size)
+		return nil
+	}
+
+	// Evict the oldest entries until the new one fits within the limits.
+	remaining := len(b.invalidBlocks)
+	remainingBytes := b.invalidBytes
+	var evicted []*invalidBlock
+	for remaining+1 > b.maxInvalidBlocks ||
+		remainingBytes+entry.size > b.maxInvalidBytes {
+
+		var oldest *invalidBlock
+		for _, e := range b.invalidBlocks {
+			if containsInvalidBlock(evicted, e) {
+				continue
+			}
+			if oldest == nil || e.seq < oldest.seq {
+				oldest = e
+			}
+		}
+		evicted = append(evicted, oldest)
+		remaining--
+		remainingBytes -= oldest.size
+	}
+
+	err = b.db.Update(func(dbTx database.Tx) error {
+		bucket, err := dbTx.Metadata().CreateBucketIfNotExists(
+			invalidBlocksBucketName)
+		if err != nil {
+			return err
+		}
+		for _, e := range evicted {
+			if err := bucket.Delete(e.hash[:]); err != nil {
+				return err
+			}
+		}
+		return bucket.Put(blockHash[:], serialized)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range evicted {
+		log.Debugf("Evicted invalid block %v", e.hash)
+		delete(b.invalidBlocks, e.hash)
+		b.invalidBytes -= e.size
+	}
+	b.invalidBlocks[*blockHash] = entry
+	b.invalidBytes += entry.size
+	b.invalidSeq++
+
+	log.Infof("Stored invalid block %v (height %d): %s", blockHash, height,
+		reason)
+	return nil
+}
+
+// containsInvalidBlock returns whether the passed entry is in the passed slice.
+func containsInvalidBlock(entries []*invalidBlock, entry *invalidBlock) bool {
+	for _, e := range entries {
+		if e == entry {
+			return true
+		}
+	}
+	return false
+}
+
+// markInvalidSideChain removes the passed side chain node, which failed the
+// checks performed when connecting it with the passed error, along with all of
+// its descendants from the memory chain and stores their blocks as invalid so
+// the side chain is no longer considered for the best chain.  Nothing is done
+// when storing invalid blocks is disabled or the error is not a rule error.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) markInvalidSideChain(node *blockNode, err error) {
+	if !errors.As(err, new(RuleError)) || !b.storeInvalid {
+		return
+	}
+
+	// Unlink the node from its parent and collect it along with all of its
+	// descendants, parents first.
+	if node.parent != nil {
+		node.parent.children = removeChildNode(node.parent.children,
+			node)
+	}
+	nodes := []*blockNode{node}
+	for i := 0; i < len(nodes); i++ {
+		nodes = append(nodes, nodes[i].children...)
+	}
+
+	for _, n := range nodes {
+		block, ok := b.blockCache[*n.hash]
+		delete(b.index, *n.hash)
+		delete(b.blockCache, *n.hash)
+		if !ok {
+			continue
+		}
+
+		reason := err.Error()
+		if n != node {
+			reason = fmt.Sprintf("block %v descends from invalid "+
+				"block %v", n.hash, node.hash)
+		}
+		if err := b.storeInvalidBlock(block, n.height, reason); err != nil {
+			log.Warnf("Unable to store invalid block %v: %v", n.hash,
+				err)
+		}
+	}
+}
+
+// InvalidBlockReason returns the reason the block with the passed hash was
+// rejected and whether or not it is stored as invalid.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) InvalidBlockReason(hash *wire.ShaHash) (string, bool) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	entry, ok := b.invalidBlocks[*hash]
+	if !ok {
+		return "", false
+	}
+	return entry.reason, true
+}
+
+// ReconsiderBlock removes the block identified by the passed hash along with
+// its descendants from the blocks stored as invalid so they can be processed
+// again, for instance after a local validation bug which caused them to be
+// rejected has been fixed.  The removed blocks are returned in an order
+// suitable for processing, starting with the block itself.  They are not
+// processed by this function, so the caller is expected to process them via
+// ProcessBlock along with the other blocks it processes.  Blocks which still
+// fail validation are stored as invalid again at that point.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ReconsiderBlock(hash *wire.ShaHash) ([]*colxutil.Block, error) {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	entry, ok := b.invalidBlocks[*hash]
+	if !ok {
+		return nil, fmt.Errorf("block %v is not marked invalid", hash)
+	}
+
+	// Collect the stored descendants of the block.  Each entry is at a
+	// greater height than the one it descends from, so they are collected
+	// in an order suitable for processing.
+	entries := []*invalidBlock{entry}
+	for i := 0; i < len(entries); i++ {
+		for _, e := range b.invalidBlocks {
+			if e.prevHash == entries[i].hash {
+				entries = append(entries, e)
+			}
+		}
+	}
+
+	// Load the blocks and remove them from the stored invalid blocks.
+	blocks := make([]*colxutil.Block, 0, len(entries))
+	err := b.db.Update(func(dbTx database.Tx) error {
+		bucket := dbTx.Metadata().Bucket(invalidBlocksBucketName)
+		if bucket == nil {
+			return AssertError("invalid blocks bucket does not exist")
+		}
+		for _, e := range entries {
+			serialized := bucket.Get(e.hash[:])
+			_, blockBytes, err := deserializeInvalidBlock(&e.hash,
+				serialized)
+			if err != nil {
+				return err
+			}
+			blockBytes = append([]byte(nil), blockBytes...)
+			block, err := colxutil.NewBlockFromBytes(blockBytes)
+			if err != nil {
+				return err
+			}
+			blocks = append(blocks, block)
+			if err := bucket.Delete(e.hash[:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		delete(b.invalidBlocks, e.hash)
+		b.invalidBytes -= e.size
+	}
+
+	log.Infof("Reconsidering block %v along with %d descendants", hash,
+		len(blocks)-1)
+	return blocks, nil
+}
@@ -0,0 +1,273 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// invalidScriptBlock returns a block which extends the passed block at the
+// given height with a transaction spending the coinbase of the passed block
+// with a signature script which fails validation.  The extra nonce is included
+// in the coinbase so several such blocks with the same parent differ.
+func invalidScriptBlock(t *testing.T, params *chaincfg.Params, parent *colxutil.Block, height int32, spend *colxutil.Block, extraNonce int64) *colxutil.Block {
+	generated, err := generateChainFrom(params, &parent.MsgBlock().Header,
+		height-1, 1, extraNonce)
+	if err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	tx := newSequenceLockTx(1,
+		[]*wire.MsgTx{spend.Transactions()[0].MsgTx()},
+		[]uint32{wire.MaxTxInSequenceNum})
+	tx.TxIn[0].SignatureScript = []byte{txscript.OP_RETURN}
+
+	msgBlock := generated[0].MsgBlock()
+	msgBlock.AddTransaction(tx)
+	merkles := blockchain.BuildMerkleTreeStore(
+		colxutil.NewBlock(msgBlock).Transactions())
+	msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+	solveBlock(&msgBlock.Header)
+	return colxutil.NewBlock(msgBlock)
+}
+
+// hasRuleError returns whether the passed error is or wraps a rule error with
+// the passed error code.
+func hasRuleError(err error, code blockchain.ErrorCode) bool {
+	var rerr blockchain.RuleError
+	return errors.As(err, &rerr) && rerr.ErrorCode == code
+}
+
+// assertInvalidBlock ensures the passed block is stored as invalid by the passed
+// chain instance as requested.
+func assertInvalidBlock(t *testing.T, chain *blockchain.BlockChain, block *colxutil.Block, want bool) {
+	reason, ok := chain.InvalidBlockReason(block.Sha())
+	if ok != want {
+		t.Fatalf("InvalidBlockReason(%v): got stored %v, want %v",
+			block.Sha(), ok, want)
+	}
+	if ok && reason == "" {
+		t.Fatalf("InvalidBlockReason(%v): empty reason", block.Sha())
+	}
+}
+
+// TestStoreInvalidBlocks ensures blocks which fail script validation when
+// connecting them, either directly to the main chain or while reorganizing to
+// a side chain, are stored as invalid along with their descendants, that they
+// are rejected and excluded from the best chain across restarts, and that
+// reconsidering them validates them again.
+func TestStoreInvalidBlocks(t *testing.T) {
+	blockchain.TstSetCoinbaseMaturity(1)
+	defer blockchain.TstSetCoinbaseMaturity(blockchain.CoinbaseMaturity)
+
+	params := &chaincfg.RegressionNetParams
+	config := blockchain.Config{
+		ChainParams:        params,
+		TimeSource:         blockchain.NewMedianTime(),
+		StoreInvalidBlocks: true,
+	}
+	chain, teardownFunc, err := chainSetupWithConfig("storeinvalid",
+		&config)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	blocks, err := generateChain(params, 3)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+	for _, block := range blocks {
+		if _, err := chain.ProcessBlock(block, blockchain.BFNone); err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+	tip := blocks[2]
+
+	processErr := func(chain *blockchain.BlockChain, block *colxutil.Block, code blockchain.ErrorCode) {
+		t.Helper()
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if !hasRuleError(err, code) {
+			t.Fatalf("ProcessBlock(%v): unexpected error - got %v, "+
+				"want %v", block.Sha(), err, code)
+		}
+	}
+	assertTip := func(chain *blockchain.BlockChain) {
+		best := chain.BestSnapshot()
+		if !best.Hash.IsEqual(tip.Sha()) {
+			t.Fatalf("unexpected tip - got %v (height %d), want %v",
+				best.Hash, best.Height, tip.Sha())
+		}
+	}
+
+	// A block which extends the main chain and fails script validation is
+	// stored, as is its child, and neither is accepted again.
+	bad := invalidScriptBlock(t, params, tip, 4, blocks[0], 0)
+	badChild, err := generateChainFrom(params, &bad.MsgBlock().Header, 4,
+		1, 0)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+	processErr(chain, bad, blockchain.ErrScriptValidation)
+	assertInvalidBlock(t, chain, bad, true)
+	processErr(chain, badChild[0], blockchain.ErrInvalidAncestorBlock)
+	assertInvalidBlock(t, chain, badChild[0], true)
+	processErr(chain, bad, blockchain.ErrKnownInvalidBlock)
+	processErr(chain, badChild[0], blockchain.ErrKnownInvalidBlock)
+	assertTip(chain)
+
+	// A side chain with more work which contains a block that fails
+	// script validation is stored from that block onwards when the
+	// reorganize to it fails, while its valid blocks remain.
+	fork, err := generateChainFrom(params, &blocks[1].MsgBlock().Header,
+		2, 1, 1)
+	if err != nil {
+		t.Fatalf("unable to generate fork: %v", err)
+	}
+	forkBad := invalidScriptBlock(t, params, fork[0], 4, blocks[1], 1)
+	forkChild, err := generateChainFrom(params,
+		&forkBad.MsgBlock().Header, 4, 1, 1)
+	if err != nil {
+		t.Fatalf("unable to generate fork: %v", err)
+	}
+	if _, err := chain.ProcessBlock(fork[0], blockchain.BFNone); err != nil {
+		t.Fatalf("ProcessBlock: unexpected error: %v", err)
+	}
+	processErr(chain, forkBad, blockchain.ErrScriptValidation)
+	assertInvalidBlock(t, chain, fork[0], false)
+	assertInvalidBlock(t, chain, forkBad, true)
+	processErr(chain, forkChild[0], blockchain.ErrInvalidAncestorBlock)
+	assertInvalidBlock(t, chain, forkChild[0], true)
+	assertTip(chain)
+
+	// The blocks remain stored and rejected after restarting the chain.
+	config.DB = blockchain.TstDB(chain)
+	chain, err = blockchain.New(&config)
+	if err != nil {
+		t.Fatalf("Failed to restart chain instance: %v", err)
+	}
+	assertTip(chain)
+	for _, block := range []*colxutil.Block{bad, badChild[0], forkBad,
+		forkChild[0]} {
+
+		assertInvalidBlock(t, chain, block, true)
+		processErr(chain, block, blockchain.ErrKnownInvalidBlock)
+	}
+
+	// Reconsidering a block validates it again along with its stored
+	// descendants, which are stored again since it is still invalid.
+	validated := make(map[wire.ShaHash]int)
+	blockchain.TstSetValidateHook(chain, func(hash *wire.ShaHash) {
+		validated[*hash]++
+	})
+	reconsidered, err := chain.ReconsiderBlock(bad.Sha())
+	if err != nil {
+		t.Fatalf("ReconsiderBlock: unexpected error: %v", err)
+	}
+	if len(reconsidered) != 2 || !reconsidered[0].Sha().IsEqual(bad.Sha()) ||
+		!reconsidered[1].Sha().IsEqual(badChild[0].Sha()) {
+
+		t.Fatalf("ReconsiderBlock: unexpected blocks %v", reconsidered)
+	}
+	for _, block := range reconsidered {
+		assertInvalidBlock(t, chain, block, false)
+	}
+	processErr(chain, reconsidered[0], blockchain.ErrScriptValidation)
+	processErr(chain, reconsidered[1], blockchain.ErrInvalidAncestorBlock)
+	for _, block := range []*colxutil.Block{bad, badChild[0]} {
+		if validated[*block.Sha()] != 1 {
+			t.Fatalf("block %v validated %d times, want 1",
+				block.Sha(), validated[*block.Sha()])
+		}
+		assertInvalidBlock(t, chain, block, true)
+	}
+	if validated[*forkBad.Sha()] != 0 {
+		t.Fatalf("unrelated block %v was validated", forkBad.Sha())
+	}
+	assertTip(chain)
+
+	// Blocks which are not stored as invalid can't be reconsidered.
+	if _, err := chain.ReconsiderBlock(tip.Sha()); err == nil {
+		t.Fatalf("ReconsiderBlock: expected an error for a valid block")
+	}
+}
+
+// TestInvalidBlockLimits ensures the oldest blocks stored as invalid are evicted
+// once the configured number of blocks is exceeded, that blocks exceeding the
+// configured size are not stored, and that nothing is stored when storing
+// invalid blocks is disabled.
+func TestInvalidBlockLimits(t *testing.T) {
+	blockchain.TstSetCoinbaseMaturity(1)
+	defer blockchain.TstSetCoinbaseMaturity(blockchain.CoinbaseMaturity)
+
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, 1)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+	tip := blocks[0]
+	badBlocks := make([]*colxutil.Block, 0, 3)
+	for i := int64(0); i < 3; i++ {
+		badBlocks = append(badBlocks, invalidScriptBlock(t, params, tip,
+			2, tip, i))
+	}
+
+	tests := []struct {
+		name    string
+		store   bool
+		max     int
+		maxSize int
+		want    []bool
+	}{
+		{"count", true, 2, 0, []bool{false, true, true}},
+		{"size", true, 0, 1, []bool{false, false, false}},
+		{"disabled", false, 0, 0, []bool{false, false, false}},
+	}
+	for _, test := range tests {
+		chain, teardownFunc, err := chainSetupWithConfig(
+			"invalidlimits"+test.name, &blockchain.Config{
+				ChainParams:          params,
+				TimeSource:           blockchain.NewMedianTime(),
+				StoreInvalidBlocks:   test.store,
+				MaxInvalidBlocks:     test.max,
+				MaxInvalidBlockBytes: test.maxSize,
+			})
+		if err != nil {
+			t.Fatalf("%s: failed to setup chain instance: %v",
+				test.name, err)
+		}
+		if _, err := chain.ProcessBlock(tip, blockchain.BFNone); err != nil {
+			teardownFunc()
+			t.Fatalf("%s: ProcessBlock: unexpected error: %v",
+				test.name, err)
+		}
+		for _, block := range badBlocks {
+			_, err := chain.ProcessBlock(block, blockchain.BFNone)
+			if !hasRuleError(err, blockchain.ErrScriptValidation) {
+				teardownFunc()
+				t.Fatalf("%s: ProcessBlock(%v): unexpected "+
+					"error - got %v, want %v", test.name,
+					block.Sha(), err,
+					blockchain.ErrScriptValidation)
+			}
+		}
+		for i, block := range badBlocks {
+			_, ok := chain.InvalidBlockReason(block.Sha())
+			if ok != test.want[i] {
+				teardownFunc()
+				t.Fatalf("%s: block %d: got stored %v, want %v",
+					test.name, i, ok, test.want[i])
+			}
+		}
+		teardownFunc()
+	}
+}
@@ -6,6 +6,7 @@
 package blockchain
 
 import (
+	"fmt"
 	"math"
 
 	"github.com/tinhnguyenhn/colxd/wire"
@@ -105,3 +106,95 @@ func BuildMerkleTreeStore(transactions []*colxutil.Tx) []*wire.ShaHash {
 
 	return merkles
 }
+
+// MerkleBranch returns the merkle branch which proves the transaction at the
+// passed index is committed to by the merkle root of the passed transactions
+// along with its position bits.  The branch is the sibling hashes from the
+// transaction up to, but not including, the root, and bit i of the position is
+// set when the node at level i is the right child of its parent.  The branch is
+// calculated one level at a time without building the full tree, so it is
+// cheaper than BuildMerkleTreeStore when serving a single transaction.
+//
+// When a level has an odd number of nodes, the last node is paired with itself,
+// so its sibling in the branch is its own hash.  Since that rule allows the
+// transactions of a block to be mutated by duplicating the last ones without
+// changing the merkle root (CVE-2012-2459), an error is returned when any level
+// has two identical adjacent nodes.
+func MerkleBranch(transactions []*colxutil.Tx, txIndex int) ([]wire.ShaHash, uint32, error) {
+	if txIndex < 0 || txIndex >= len(transactions) {
+		return nil, 0, fmt.Errorf("transaction index %d is out of range "+
+			"for %d transactions", txIndex, len(transactions))
+	}
+
+	level := make([]wire.ShaHash, 0, len(transactions))
+	for _, tx := range transactions {
+		level = append(level, *tx.Sha())
+	}
+
+	var branch []wire.ShaHash
+	var position uint32
+	for depth := uint(0); len(level) > 1; depth++ {
+		// Reject mutated transaction lists which duplicate nodes.
+		for i := 0; i+1 < len(level); i += 2 {
+			if level[i] == level[i+1] {
+				return nil, 0, fmt.Errorf("merkle tree has duplicate "+
+					"nodes %v at index %d of level %d", level[i],
+					i, depth)
+			}
+		}
+
+		// The sibling of a left node is the node to its right, or the
+		// node itself when it is the last node of an odd level.
+		sibling := txIndex ^ 1
+		if sibling >= len(level) {
+			sibling = txIndex
+		}
+		branch = append(branch, level[sibling])
+		if txIndex&1 == 1 {
+			position |= 1 << depth
+		}
+
+		// Calculate the next level up.
+		next := make([]wire.ShaHash, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			right := i + 1
+			if right == len(level) {
+				right = i
+			}
+			next = append(next, *HashMerkleBranches(&level[i],
+				&level[right]))
+		}
+		level = next
+		txIndex >>= 1
+	}
+
+	return branch, position, nil
+}
+
+// VerifyMerkleBranch returns whether the passed merkle branch and position bits,
+// as returned by MerkleBranch, prove the transaction with the passed hash is
+// committed to by the passed merkle root.
+//
+// A branch in which a right node is identical to its left sibling is rejected
+// since a valid tree only pairs a node with itself when it is the last left node
+// of an odd level (CVE-2012-2459).
+func VerifyMerkleBranch(root, txHash *wire.ShaHash, branch []wire.ShaHash, index uint32) bool {
+	// The position bits may not reference levels beyond the branch.
+	if len(branch) < 32 && index>>uint(len(branch)) != 0 {
+		return false
+	}
+
+	hash := *txHash
+	for depth := range branch {
+		sibling := &branch[depth]
+		if index&(1<<uint(depth)) != 0 {
+			if *sibling == hash {
+				return false
+			}
+			hash = *HashMerkleBranches(sibling, &hash)
+		} else {
+			hash = *HashMerkleBranches(&hash, sibling)
+		}
+	}
+	return hash == *root
+}
@@ -23,3 +23,97 @@ func TestMerkle(t *testing.T) {
 			"got %v, want %v", calculatedMerkleRoot, wantMerkle)
 	}
 }
+
+// TestMerkleBranch ensures the merkle branches of every transaction of a block
+// prove the transaction against the merkle root of the block and that invalid
+// branches are rejected.
+func TestMerkleBranch(t *testing.T) {
+	block := colxutil.NewBlock(&Block100000)
+	txns := block.Transactions()
+	root := &Block100000.Header.MerkleRoot
+
+	for i, tx := range txns {
+		branch, position, err := blockchain.MerkleBranch(txns, i)
+		if err != nil {
+			t.Fatalf("MerkleBranch #%d: unexpected error: %v", i, err)
+		}
+		if len(branch) != 2 || position != uint32(i) {
+			t.Fatalf("MerkleBranch #%d: unexpected branch length %d "+
+				"and position %d", i, len(branch), position)
+		}
+		if !blockchain.VerifyMerkleBranch(root, tx.Sha(), branch, position) {
+			t.Errorf("VerifyMerkleBranch #%d: valid branch rejected", i)
+		}
+
+		// The branch must not prove the transaction at another
+		// position.
+		wrongPosition := position ^ 1
+		if blockchain.VerifyMerkleBranch(root, tx.Sha(), branch, wrongPosition) {
+			t.Errorf("VerifyMerkleBranch #%d: branch with wrong "+
+				"position accepted", i)
+		}
+
+		// Nor another transaction.
+		other := txns[(i+1)%len(txns)].Sha()
+		if blockchain.VerifyMerkleBranch(root, other, branch, position) {
+			t.Errorf("VerifyMerkleBranch #%d: branch for other "+
+				"transaction accepted", i)
+		}
+
+		// Nor a position beyond the levels of the branch.
+		if blockchain.VerifyMerkleBranch(root, tx.Sha(), branch, position|4) {
+			t.Errorf("VerifyMerkleBranch #%d: position beyond branch "+
+				"accepted", i)
+		}
+	}
+
+	if _, _, err := blockchain.MerkleBranch(txns, len(txns)); err == nil {
+		t.Error("MerkleBranch: out of range index accepted")
+	}
+}
+
+// TestMerkleBranchOddLevels ensures merkle branches pair the last node of odd
+// levels with itself and reject the transaction lists and branches which the
+// rule allows to be mutated without changing the merkle root (CVE-2012-2459).
+func TestMerkleBranchOddLevels(t *testing.T) {
+	block := colxutil.NewBlock(&Block100000)
+	txns := block.Transactions()[:3]
+	merkles := blockchain.BuildMerkleTreeStore(txns)
+	root := merkles[len(merkles)-1]
+
+	for i, tx := range txns {
+		branch, position, err := blockchain.MerkleBranch(txns, i)
+		if err != nil {
+			t.Fatalf("MerkleBranch #%d: unexpected error: %v", i, err)
+		}
+		if !blockchain.VerifyMerkleBranch(root, tx.Sha(), branch, position) {
+			t.Errorf("VerifyMerkleBranch #%d: valid branch rejected", i)
+		}
+	}
+
+	// The last transaction of the odd level is its own sibling.
+	last := txns[2].Sha()
+	branch, position, err := blockchain.MerkleBranch(txns, 2)
+	if err != nil {
+		t.Fatalf("MerkleBranch: unexpected error: %v", err)
+	}
+	if !branch[0].IsEqual(last) {
+		t.Fatalf("MerkleBranch: unexpected sibling of last transaction "+
+			"- got %v, want %v", branch[0], last)
+	}
+
+	// Duplicating the last transaction results in the same merkle root, so
+	// the mutated transaction list must be rejected, as must a branch which
+	// claims the duplicate is a right node.
+	mutated := append(txns[:3:3], txns[2])
+	mutatedMerkles := blockchain.BuildMerkleTreeStore(mutated)
+	if !mutatedMerkles[len(mutatedMerkles)-1].IsEqual(root) {
+		t.Fatal("mutated transactions have a different merkle root")
+	}
+	if _, _, err := blockchain.MerkleBranch(mutated, 3); err == nil {
+		t.Error("MerkleBranch: mutated transactions accepted")
+	}
+	if blockchain.VerifyMerkleBranch(root, last, branch, position|1) {
+		t.Error("VerifyMerkleBranch: duplicated right node accepted")
+	}
+}
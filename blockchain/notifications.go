@@ -7,6 +7,9 @@ package blockchain
 
 import (
 	"fmt"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
 )
 
 // NotificationType represents the type of a notification message.
@@ -30,6 +33,24 @@ const (
 	// NTBlockDisconnected indicates the associated block was disconnected
 	// from the main chain.
 	NTBlockDisconnected
+
+	// NTChainReorg indicates the main chain is being reorganized.  It is
+	// sent once per reorganize before the NTBlockDisconnected and
+	// NTBlockConnected notifications for the individual blocks.
+	NTChainReorg
+
+	// NTOrphanEvicted indicates the associated orphan block was removed
+	// from the orphan pool without being processed because it expired or
+	// the orphan pool exceeded one of its limits.
+	NTOrphanEvicted
+
+	// NTFutureBlockReady indicates the associated block, which was held
+	// because its timestamp was too far in the future, no longer is and is
+	// ready to be processed again.  The chain does not process it on its
+	// own, so the receiver is expected to hand it to whatever processes the
+	// other blocks.  It is sent from the goroutine of the timer which
+	// released the block.
+	NTFutureBlockReady
 )
 
 // notificationTypeStrings is a map of notification types back to their constant
@@ -38,6 +59,9 @@ var notificationTypeStrings = map[NotificationType]string{
 	NTBlockAccepted:     "NTBlockAccepted",
 	NTBlockConnected:    "NTBlockConnected",
 	NTBlockDisconnected: "NTBlockDisconnected",
+	NTChainReorg:        "NTChainReorg",
+	NTOrphanEvicted:     "NTOrphanEvicted",
+	NTFutureBlockReady:  "NTFutureBlockReady",
 }
 
 // String returns the NotificationType in human-readable form.
@@ -54,11 +78,95 @@ func (n NotificationType) String() string {
 // 	- NTBlockAccepted:     *colxutil.Block
 // 	- NTBlockConnected:    *colxutil.Block
 // 	- NTBlockDisconnected: *colxutil.Block
+// 	- NTChainReorg:        *ReorgData
+// 	- NTOrphanEvicted:     *OrphanEviction
+// 	- NTFutureBlockReady:  *FutureBlockRelease
 type Notification struct {
 	Type NotificationType
 	Data interface{}
 }
 
+// ReorgData describes a reorganize of the main chain.  It is the data sent with
+// NTChainReorg notifications.
+type ReorgData struct {
+	// OldTip and OldHeight are the hash and height of the main chain tip
+	// before the reorganize.
+	OldTip    *wire.ShaHash
+	OldHeight int32
+
+	// NewTip and NewHeight are the hash and height of the main chain tip
+	// after the reorganize.
+	NewTip    *wire.ShaHash
+	NewHeight int32
+
+	// Detached houses the hashes of the blocks disconnected from the main
+	// chain in the order they are disconnected, starting with the old tip.
+	Detached []*wire.ShaHash
+
+	// Attached houses the hashes of the blocks connected to the main chain
+	// in the order they are connected, ending with the new tip.
+	Attached []*wire.ShaHash
+}
+
+// OrphanEvictReason describes why an orphan block was evicted from the orphan
+// pool.
+type OrphanEvictReason int
+
+// These constants define the reasons an orphan block is evicted.
+const (
+	// OrphanEvictExpired indicates the orphan block was queued for longer
+	// than the maximum orphan age.
+	OrphanEvictExpired OrphanEvictReason = iota
+
+	// OrphanEvictMaxBlocks indicates the orphan block was evicted to make
+	// room for a newer one because the maximum number of orphan blocks was
+	// reached.
+	OrphanEvictMaxBlocks
+
+	// OrphanEvictMaxBytes indicates the orphan block was evicted to make
+	// room for a newer one because the maximum combined size of the orphan
+	// blocks was reached, or that it is larger than the maximum by itself.
+	OrphanEvictMaxBytes
+)
+
+// orphanEvictReasonStrings is a map of orphan eviction reasons back to their
+// constant names for pretty printing.
+var orphanEvictReasonStrings = map[OrphanEvictReason]string{
+	OrphanEvictExpired:   "OrphanEvictExpired",
+	OrphanEvictMaxBlocks: "OrphanEvictMaxBlocks",
+	OrphanEvictMaxBytes:  "OrphanEvictMaxBytes",
+}
+
+// String returns the OrphanEvictReason in human-readable form.
+func (r OrphanEvictReason) String() string {
+	if s, ok := orphanEvictReasonStrings[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown OrphanEvictReason (%d)", int(r))
+}
+
+// OrphanEviction describes an orphan block which was evicted from the orphan
+// pool.  It is the data sent with NTOrphanEvicted notifications.
+type OrphanEviction struct {
+	// Block is the evicted orphan block.
+	Block *colxutil.Block
+
+	// Reason is why the orphan block was evicted.
+	Reason OrphanEvictReason
+}
+
+// FutureBlockRelease describes a block which is no longer held until its
+// timestamp is not too far in the future.  It is the data sent with
+// NTFutureBlockReady notifications.
+type FutureBlockRelease struct {
+	// Block is the released block.
+	Block *colxutil.Block
+
+	// Flags are the behavior flags the block was originally processed
+	// with, which it is expected to be processed with again.
+	Flags BehaviorFlags
+}
+
 // sendNotification sends a notification with the passed type and data if the
 // caller requested notifications by providing a callback function in the call
 // to New.
@@ -0,0 +1,143 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestChainReorgNotification ensures a reorganize sends a single NTChainReorg
+// notification describing the whole reorganize before the notifications for
+// the individual blocks, and that the detached and attached blocks are in the
+// order they are disconnected and connected.
+func TestChainReorgNotification(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chainA, err := generateChain(params, 5)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+	forkHeight := int32(2)
+	forkParent := chainA[forkHeight-1]
+	chainB, err := generateChainFrom(params, &forkParent.MsgBlock().Header,
+		forkHeight, 4, 1)
+	if err != nil {
+		t.Fatalf("unable to generate fork: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("reorgntfn", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	processBlocks := func(blocks []*colxutil.Block) {
+		for _, block := range blocks {
+			_, err := chain.ProcessBlock(block, blockchain.BFNone)
+			if err != nil {
+				t.Fatalf("ProcessBlock: unexpected error: %v", err)
+			}
+		}
+	}
+	processBlocks(chainA)
+
+	// Only record the notifications for the side chain blocks, the last of
+	// which causes the reorganize.
+	var ntfns []*blockchain.Notification
+	blockchain.TstSetNotifications(chain, func(n *blockchain.Notification) {
+		if n.Type != blockchain.NTBlockAccepted {
+			ntfns = append(ntfns, n)
+		}
+	})
+	processBlocks(chainB)
+
+	// The old chain blocks after the fork point are detached starting with
+	// the old tip and all of the side chain blocks are attached.
+	var wantDetached, wantAttached []*wire.ShaHash
+	for i := len(chainA) - 1; i >= int(forkHeight); i-- {
+		wantDetached = append(wantDetached, chainA[i].Sha())
+	}
+	for _, block := range chainB {
+		wantAttached = append(wantAttached, block.Sha())
+	}
+	wantNtfns := 1 + len(wantDetached) + len(wantAttached)
+	if len(ntfns) != wantNtfns {
+		t.Fatalf("unexpected number of notifications - got %d, want %d",
+			len(ntfns), wantNtfns)
+	}
+
+	// Ensure the reorganize notification is first and describes the
+	// reorganize.
+	if ntfns[0].Type != blockchain.NTChainReorg {
+		t.Fatalf("unexpected first notification - got %v, want %v",
+			ntfns[0].Type, blockchain.NTChainReorg)
+	}
+	reorg, ok := ntfns[0].Data.(*blockchain.ReorgData)
+	if !ok {
+		t.Fatalf("unexpected reorganize notification data type %T",
+			ntfns[0].Data)
+	}
+	oldTip := chainA[len(chainA)-1]
+	newTip := chainB[len(chainB)-1]
+	if !reorg.OldTip.IsEqual(oldTip.Sha()) ||
+		reorg.OldHeight != int32(len(chainA)) {
+
+		t.Fatalf("unexpected old tip - got %v (height %d), want %v "+
+			"(height %d)", reorg.OldTip, reorg.OldHeight,
+			oldTip.Sha(), len(chainA))
+	}
+	wantNewHeight := forkHeight + int32(len(chainB))
+	if !reorg.NewTip.IsEqual(newTip.Sha()) ||
+		reorg.NewHeight != wantNewHeight {
+
+		t.Fatalf("unexpected new tip - got %v (height %d), want %v "+
+			"(height %d)", reorg.NewTip, reorg.NewHeight,
+			newTip.Sha(), wantNewHeight)
+	}
+	checkHashes := func(name string, got, want []*wire.ShaHash) {
+		if len(got) != len(want) {
+			t.Fatalf("unexpected number of %s blocks - got %d, "+
+				"want %d", name, len(got), len(want))
+		}
+		for i := range want {
+			if !got[i].IsEqual(want[i]) {
+				t.Fatalf("unexpected %s block #%d - got %v, "+
+					"want %v", name, i, got[i], want[i])
+			}
+		}
+	}
+	checkHashes("detached", reorg.Detached, wantDetached)
+	checkHashes("attached", reorg.Attached, wantAttached)
+
+	// Ensure the individual block notifications follow in the same order.
+	var gotDetached, gotAttached []*wire.ShaHash
+	for i, n := range ntfns[1:] {
+		block, ok := n.Data.(*colxutil.Block)
+		if !ok {
+			t.Fatalf("unexpected notification #%d data type %T",
+				i+1, n.Data)
+		}
+		switch n.Type {
+		case blockchain.NTBlockDisconnected:
+			if len(gotAttached) != 0 {
+				t.Fatalf("block %v disconnected after blocks "+
+					"were connected", block.Sha())
+			}
+			gotDetached = append(gotDetached, block.Sha())
+		case blockchain.NTBlockConnected:
+			gotAttached = append(gotAttached, block.Sha())
+		default:
+			t.Fatalf("unexpected notification #%d type %v", i+1,
+				n.Type)
+		}
+	}
+	checkHashes("disconnected", gotDetached, wantDetached)
+	checkHashes("connected", gotAttached, wantAttached)
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestOrphanEviction ensures the oldest orphan blocks are evicted once the
+// orphan pool exceeds the configured limits, that expired orphans are evicted,
+// and that a notification describing each eviction is sent.
+func TestOrphanEviction(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, 8)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	maxSize := 0
+	for _, block := range blocks {
+		if size := block.MsgBlock().SerializeSize(); size > maxSize {
+			maxSize = size
+		}
+	}
+
+	// Every block except the first one is an orphan since the first one is
+	// never processed.
+	type eviction struct {
+		block  *colxutil.Block
+		reason blockchain.OrphanEvictReason
+	}
+	tests := []struct {
+		name      string
+		config    blockchain.Config
+		wait      time.Duration
+		orphans   []*colxutil.Block
+		evictions []eviction
+		remaining []*colxutil.Block
+	}{
+		{
+			name:    "max blocks",
+			config:  blockchain.Config{MaxOrphanBlocks: 3},
+			orphans: blocks[1:6],
+			evictions: []eviction{
+				{blocks[1], blockchain.OrphanEvictMaxBlocks},
+				{blocks[2], blockchain.OrphanEvictMaxBlocks},
+			},
+			remaining: blocks[3:6],
+		},
+		{
+			// Any two blocks fit within the limit, while three of
+			// them do not.
+			name:    "max bytes",
+			config:  blockchain.Config{MaxOrphanBytes: maxSize * 2},
+			orphans: blocks[1:5],
+			evictions: []eviction{
+				{blocks[1], blockchain.OrphanEvictMaxBytes},
+				{blocks[2], blockchain.OrphanEvictMaxBytes},
+			},
+			remaining: blocks[3:5],
+		},
+		{
+			name:    "larger than max bytes",
+			config:  blockchain.Config{MaxOrphanBytes: maxSize / 2},
+			orphans: blocks[1:2],
+			evictions: []eviction{
+				{blocks[1], blockchain.OrphanEvictMaxBytes},
+			},
+		},
+		{
+			name:    "expired",
+			config:  blockchain.Config{MaxOrphanAge: time.Millisecond},
+			wait:    time.Millisecond * 10,
+			orphans: blocks[1:4],
+			evictions: []eviction{
+				{blocks[1], blockchain.OrphanEvictExpired},
+				{blocks[2], blockchain.OrphanEvictExpired},
+			},
+			remaining: blocks[3:4],
+		},
+	}
+
+	for _, test := range tests {
+		var evictions []eviction
+		config := test.config
+		config.ChainParams = params
+		config.TimeSource = blockchain.NewMedianTime()
+		config.Notifications = func(n *blockchain.Notification) {
+			if n.Type != blockchain.NTOrphanEvicted {
+				return
+			}
+			data, ok := n.Data.(*blockchain.OrphanEviction)
+			if !ok {
+				t.Errorf("%s: unexpected notification data %T",
+					test.name, n.Data)
+				return
+			}
+			evictions = append(evictions,
+				eviction{data.Block, data.Reason})
+		}
+		chain, teardownFunc, err := chainSetupWithConfig("orphaneviction",
+			&config)
+		if err != nil {
+			t.Fatalf("%s: Failed to setup chain instance: %v",
+				test.name, err)
+		}
+
+		for i, block := range test.orphans {
+			if i != 0 {
+				time.Sleep(test.wait)
+			}
+			isOrphan, err := chain.ProcessBlock(block,
+				blockchain.BFNone)
+			if err != nil || !isOrphan {
+				teardownFunc()
+				t.Fatalf("%s: ProcessBlock: unexpected result - "+
+					"orphan %v, error %v", test.name, isOrphan,
+					err)
+			}
+		}
+
+		if len(evictions) != len(test.evictions) {
+			teardownFunc()
+			t.Fatalf("%s: unexpected number of evictions - got %d, "+
+				"want %d", test.name, len(evictions),
+				len(test.evictions))
+		}
+		for i, want := range test.evictions {
+			got := evictions[i]
+			if !got.block.Sha().IsEqual(want.block.Sha()) ||
+				got.reason != want.reason {
+
+				teardownFunc()
+				t.Fatalf("%s: unexpected eviction #%d - got %v "+
+					"(%v), want %v (%v)", test.name, i,
+					got.block.Sha(), got.reason,
+					want.block.Sha(), want.reason)
+			}
+			if chain.IsKnownOrphan(want.block.Sha()) {
+				teardownFunc()
+				t.Fatalf("%s: evicted block %v is still an "+
+					"orphan", test.name, want.block.Sha())
+			}
+		}
+
+		// The remaining orphans are still known and the first of them
+		// is the root of the chain of orphans ending with the others.
+		for _, block := range test.remaining {
+			if !chain.IsKnownOrphan(block.Sha()) {
+				teardownFunc()
+				t.Fatalf("%s: block %v is not an orphan",
+					test.name, block.Sha())
+			}
+			root, ok := chain.OrphanRoot(block.Sha())
+			if !ok || !root.IsEqual(test.remaining[0].Sha()) {
+				teardownFunc()
+				t.Fatalf("%s: OrphanRoot: unexpected root %v "+
+					"(%v) for %v, want %v", test.name, root, ok,
+					block.Sha(), test.remaining[0].Sha())
+			}
+		}
+		teardownFunc()
+	}
+}
+
+// TestOrphanRoot ensures the root of a chain of orphan blocks is only reported
+// for known orphans.
+func TestOrphanRoot(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, 3)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+	chain, teardownFunc, err := chainSetup("orphanroot", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	for _, block := range blocks[1:] {
+		isOrphan, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil || !isOrphan {
+			t.Fatalf("ProcessBlock: unexpected result - orphan %v, "+
+				"error %v", isOrphan, err)
+		}
+	}
+
+	root, ok := chain.OrphanRoot(blocks[2].Sha())
+	if !ok || !root.IsEqual(blocks[1].Sha()) {
+		t.Fatalf("OrphanRoot: unexpected root %v (%v), want %v", root,
+			ok, blocks[1].Sha())
+	}
+
+	// The missing parent is not an orphan, so it has no orphan root and
+	// is its own root as far as GetOrphanRoot is concerned.
+	if root, ok := chain.OrphanRoot(blocks[0].Sha()); ok {
+		t.Fatalf("OrphanRoot: unexpected root %v for block which is "+
+			"not an orphan", root)
+	}
+	root = chain.GetOrphanRoot(blocks[0].Sha())
+	if !root.IsEqual(blocks[0].Sha()) {
+		t.Fatalf("GetOrphanRoot: unexpected root %v, want %v", root,
+			blocks[0].Sha())
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// medianOfLast returns the median of the last 11 of the passed timestamps the
+// same way the past median time of a block is defined, which is recomputed
+// from scratch to check the values cached by the chain against.
+func medianOfLast(timestamps []time.Time) time.Time {
+	if len(timestamps) > 11 {
+		timestamps = timestamps[len(timestamps)-11:]
+	}
+	sorted := make([]time.Time, len(timestamps))
+	copy(sorted, timestamps)
+	sort.Sort(blockchain.TstTimeSorter(sorted))
+	return sorted[len(sorted)/2]
+}
+
+// adversarialTimes returns timestamps for the passed number of blocks which
+// build on a chain with the passed timestamps.  They jump back and forth,
+// repeat, and sit exactly one second after the past median time, while still
+// being accepted by the chain.  The offset selects where in the pattern to
+// start, so branches built from different offsets differ.
+func adversarialTimes(chainTimes []time.Time, numBlocks int, offset int) []time.Time {
+	pattern := []time.Duration{3 * time.Hour, -2 * time.Hour, 0,
+		-time.Hour, 20 * time.Minute, -24 * time.Hour, time.Second}
+
+	times := make([]time.Time, 0, len(chainTimes)+numBlocks)
+	times = append(times, chainTimes...)
+	for i := 0; i < numBlocks; i++ {
+		prev := times[len(times)-1]
+		minTime := medianOfLast(times).Add(time.Second)
+		timestamp := prev.Add(pattern[(i+offset)%len(pattern)])
+		if timestamp.Before(minTime) {
+			timestamp = minTime
+		}
+		times = append(times, timestamp)
+	}
+	return times[len(chainTimes):]
+}
+
+// TestMedianTimeByHash ensures the past median times cached on the block nodes
+// match the ones recomputed from the block timestamps for blocks with
+// adversarial timestamp orderings on both the main chain and a side chain, and
+// that they remain correct as the chain reorganizes between them.
+func TestMedianTimeByHash(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	genesisTimes := []time.Time{params.GenesisBlock.Header.Timestamp}
+	mainTimes := append(genesisTimes, adversarialTimes(genesisTimes, 30,
+		0)...)
+	mainBlocks, err := generateChainWithTimes(params,
+		&params.GenesisBlock.Header, 0, mainTimes[1:], 0)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	// The side chain forks from the block at height 10 and has more work
+	// than the main chain.
+	forkTimes := append([]time.Time(nil), mainTimes[:11]...)
+	forkTimes = append(forkTimes, adversarialTimes(forkTimes, 25, 3)...)
+	forkBlocks, err := generateChainWithTimes(params,
+		&mainBlocks[9].MsgBlock().Header, 10, forkTimes[11:], 1)
+	if err != nil {
+		t.Fatalf("unable to generate fork: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("mediantimebyhash", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	processBlocks := func(blocks []*colxutil.Block) {
+		for _, block := range blocks {
+			_, err := chain.ProcessBlock(block, blockchain.BFNone)
+			if err != nil {
+				t.Fatalf("ProcessBlock: unexpected error: %v", err)
+			}
+		}
+	}
+
+	// assertMedianTimes ensures the median times of the passed blocks,
+	// which build on the block at the passed height, match the ones
+	// recomputed from the passed timestamps of the chain they are part of,
+	// which start with the genesis block.
+	assertMedianTimes := func(desc string, blocks []*colxutil.Block, height int, chainTimes []time.Time) {
+		for i, block := range blocks {
+			blockHeight := height + i + 1
+			want := medianOfLast(chainTimes[:blockHeight+1])
+			got, err := chain.MedianTimeByHash(block.Sha())
+			if err != nil {
+				t.Fatalf("%s: MedianTimeByHash at height %d: "+
+					"unexpected error: %v", desc, blockHeight, err)
+			}
+			if !got.Equal(want) {
+				t.Errorf("%s: unexpected median time at height "+
+					"%d - got %v, want %v", desc, blockHeight,
+					got, want)
+			}
+		}
+	}
+
+	// assertTip ensures the chain ends with the passed block and that the
+	// median time of the chain is the one recomputed from the passed
+	// timestamps.
+	assertTip := func(desc string, block *colxutil.Block, chainTimes []time.Time) {
+		best := chain.BestSnapshot()
+		if !best.Hash.IsEqual(block.Sha()) {
+			t.Fatalf("%s: unexpected tip - got %v (height %d), want "+
+				"%v", desc, best.Hash, best.Height, block.Sha())
+		}
+		got, err := chain.CalcPastMedianTime()
+		if err != nil {
+			t.Fatalf("%s: CalcPastMedianTime: unexpected error: %v",
+				desc, err)
+		}
+		if want := medianOfLast(chainTimes); !got.Equal(want) {
+			t.Errorf("%s: unexpected median time of the chain - got "+
+				"%v, want %v", desc, got, want)
+		}
+	}
+
+	processBlocks(mainBlocks)
+	assertTip("main chain", mainBlocks[len(mainBlocks)-1], mainTimes)
+	assertMedianTimes("main chain", mainBlocks, 0, mainTimes)
+
+	// Reorganize to the side chain.
+	processBlocks(forkBlocks)
+	assertTip("side chain", forkBlocks[len(forkBlocks)-1], forkTimes)
+	assertMedianTimes("side chain", forkBlocks, 10, forkTimes)
+	assertMedianTimes("former main chain", mainBlocks, 0, mainTimes)
+
+	// Extend the original chain so the chain reorganizes back to it.
+	extendTimes := adversarialTimes(mainTimes, 6, 5)
+	extendBlocks, err := generateChainWithTimes(params,
+		&mainBlocks[len(mainBlocks)-1].MsgBlock().Header,
+		int32(len(mainBlocks)), extendTimes, 0)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+	mainTimes = append(mainTimes, extendTimes...)
+	mainBlocks = append(mainBlocks, extendBlocks...)
+	processBlocks(extendBlocks)
+	assertTip("reorganized main chain", mainBlocks[len(mainBlocks)-1],
+		mainTimes)
+	assertMedianTimes("reorganized main chain", mainBlocks, 0, mainTimes)
+	assertMedianTimes("former side chain", forkBlocks, 10, forkTimes)
+
+	// The median time of the genesis block is its own timestamp.
+	got, err := chain.MedianTimeByHash(params.GenesisHash)
+	if err != nil {
+		t.Fatalf("MedianTimeByHash genesis: unexpected error: %v", err)
+	}
+	if !got.Equal(genesisTimes[0]) {
+		t.Errorf("unexpected genesis median time - got %v, want %v", got,
+			genesisTimes[0])
+	}
+}
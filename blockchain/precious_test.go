@@ -0,0 +1,98 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestPreciousBlock ensures marking a block precious reorganizes the chain onto
+// its branch when it has the same work as the main chain, that it never
+// overrides a chain with more work, and that normal work based selection
+// resumes once the other branch is extended.
+func TestPreciousBlock(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chainA, err := generateChain(params, 5)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+	chainB, err := generateChainFrom(params, &chainA[2].MsgBlock().Header,
+		3, 2, 1)
+	if err != nil {
+		t.Fatalf("unable to generate fork: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("preciousblock", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	processBlocks := func(blocks []*colxutil.Block) {
+		for _, block := range blocks {
+			_, err := chain.ProcessBlock(block, blockchain.BFNone)
+			if err != nil {
+				t.Fatalf("ProcessBlock: unexpected error: %v", err)
+			}
+		}
+	}
+	assertTip := func(block *colxutil.Block) {
+		best := chain.BestSnapshot()
+		if !best.Hash.IsEqual(block.Sha()) {
+			t.Fatalf("unexpected tip - got %v (height %d), want %v",
+				best.Hash, best.Height, block.Sha())
+		}
+	}
+	markPrecious := func(block *colxutil.Block) {
+		if err := chain.PreciousBlock(block.Sha()); err != nil {
+			t.Fatalf("PreciousBlock: unexpected error: %v", err)
+		}
+	}
+
+	// The first branch seen remains the tip when both have equal work.
+	processBlocks(chainA)
+	processBlocks(chainB)
+	tipA, tipB := chainA[len(chainA)-1], chainB[len(chainB)-1]
+	assertTip(tipA)
+
+	// Marking the tip of the inactive branch precious must reorganize onto
+	// it and marking the other tip afterwards must switch back.
+	markPrecious(tipB)
+	assertTip(tipB)
+	markPrecious(tipA)
+	assertTip(tipA)
+	markPrecious(tipB)
+	assertTip(tipB)
+
+	// Marking a block with less work than the tip must not do anything.
+	markPrecious(chainA[3])
+	assertTip(tipB)
+
+	// A block extending the inactive branch has more work, so it must
+	// become the tip regardless of the precious block.
+	extendA, err := generateChainFrom(params, &tipA.MsgBlock().Header, 5,
+		1, 0)
+	if err != nil {
+		t.Fatalf("unable to extend chain: %v", err)
+	}
+	processBlocks(extendA)
+	assertTip(extendA[0])
+
+	// Marking the old precious block again must not override the branch
+	// with more work.
+	markPrecious(tipB)
+	assertTip(extendA[0])
+
+	// Unknown blocks must be reported.
+	if err := chain.PreciousBlock(&wire.ShaHash{0x01}); err == nil {
+		t.Fatalf("PreciousBlock: expected error for unknown block")
+	}
+}
@@ -6,6 +6,7 @@
 package blockchain
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/tinhnguyenhn/colxd/database"
@@ -34,6 +35,12 @@ const (
 	// without modifying the current state.
 	BFDryRun
 
+	// BFMedianTimePast may be set to indicate the transactions in the block
+	// must be finalized as of the median time of the previous blocks rather
+	// than the timestamp of the block.  It is implied once the BIP0113
+	// deployment is active.
+	BFMedianTimePast
+
 	// BFNone is a convenience value to specifically indicate no flags.
 	BFNone BehaviorFlags = 0
 )
@@ -102,7 +109,11 @@ func (b *BlockChain) processOrphans(hash *wire.ShaHash, flags BehaviorFlags) err
 			i--
 
 			// Potentially accept the block into the block chain.
-			err := b.maybeAcceptBlock(orphan.block, flags)
+			// The block which connected the orphans is already
+			// committed, so the orphans are processed to completion
+			// regardless of the context of the caller.
+			err := b.maybeAcceptBlock(context.Background(),
+				orphan.block, flags)
 			if err != nil {
 				return err
 			}
@@ -116,6 +127,23 @@ func (b *BlockChain) processOrphans(hash *wire.ShaHash, flags BehaviorFlags) err
 	return nil
 }
 
+// inFlightKey identifies a block submission which is currently being
+// processed.  The behavior flags are part of the key since, for example, a dry
+// run of a block must not stand in for actually processing it.
+type inFlightKey struct {
+	hash  wire.ShaHash
+	flags BehaviorFlags
+}
+
+// inFlightBlock houses the result of processing a block which is shared with
+// any duplicate submissions of the same block that arrive while it is being
+// processed.  The done channel is closed once the result fields are set.
+type inFlightBlock struct {
+	done     chan struct{}
+	isOrphan bool
+	err      error
+}
+
 // ProcessBlock is the main workhorse for handling insertion of new blocks into
 // the block chain.  It includes functionality such as rejecting duplicate
 // blocks, ensuring blocks follow all rules, orphan handling, and insertion into
@@ -127,9 +155,81 @@ func (b *BlockChain) processOrphans(hash *wire.ShaHash, flags BehaviorFlags) err
 //
 // This function is safe for concurrent access.
 func (b *BlockChain) ProcessBlock(block *colxutil.Block, flags BehaviorFlags) (bool, error) {
+	return b.ProcessBlockCtx(context.Background(), block, flags)
+}
+
+// isContextError returns whether the passed error is the error of a canceled
+// or expired context.
+func isContextError(err error) bool {
+	return err == context.Canceled || err == context.DeadlineExceeded
+}
+
+// ProcessBlockCtx is the same as ProcessBlock except processing the block is
+// abandoned when the passed context is canceled.  The context is checked
+// between the validation phases and while the script validation work is
+// dispatched.  When it is canceled before the block is committed to the
+// database, the error of the context is returned and the chain state is left
+// untouched, so the block may be processed again later.  Once the block is
+// being committed, processing runs to completion.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ProcessBlockCtx(ctx context.Context, block *colxutil.Block, flags BehaviorFlags) (bool, error) {
+	// Wait for and return the result of processing the block when another
+	// caller is already processing it rather than validating it again.
+	// The block is processed again when the other caller abandoned
+	// processing it.
+	key := inFlightKey{hash: *block.Sha(), flags: flags}
+	b.inFlightLock.Lock()
+	for {
+		entry, ok := b.inFlight[key]
+		if !ok {
+			break
+		}
+		b.inFlightLock.Unlock()
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+		if !isContextError(entry.err) {
+			return entry.isOrphan, entry.err
+		}
+		b.inFlightLock.Lock()
+	}
+	entry := &inFlightBlock{done: make(chan struct{})}
+	b.inFlight[key] = entry
+	b.inFlightLock.Unlock()
+
+	// Remove the block from the in-flight set and release any waiters on
+	// all exit paths.  The error is set up front so waiters are given an
+	// error instead of a false success should processing panic.
+	entry.err = AssertError(fmt.Sprintf("processing of block %v did "+
+		"not complete", key.hash))
+	defer func() {
+		b.inFlightLock.Lock()
+		delete(b.inFlight, key)
+		b.inFlightLock.Unlock()
+		close(entry.done)
+	}()
+
+	entry.isOrphan, entry.err = b.processBlock(ctx, block, flags)
+	return entry.isOrphan, entry.err
+}
+
+// processBlock performs the actual work of ProcessBlockCtx once it has been
+// determined the block is not already being processed by another caller.
+//
+// This function MUST NOT be called with the chain lock held (for writes).
+func (b *BlockChain) processBlock(ctx context.Context, block *colxutil.Block, flags BehaviorFlags) (bool, error) {
 	b.chainLock.Lock()
 	defer b.chainLock.Unlock()
 
+	// Don't bother processing the block when the context was canceled
+	// while waiting for the chain lock.
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
 	fastAdd := flags&BFFastAdd == BFFastAdd
 	dryRun := flags&BFDryRun == BFDryRun
 
@@ -152,9 +252,45 @@ func (b *BlockChain) ProcessBlock(block *colxutil.Block, flags BehaviorFlags) (b
 		return false, ruleError(ErrDuplicateBlock, str)
 	}
 
+	// The block must not already be held until its timestamp is no longer
+	// too far in the future unless that time has come, in which case it is
+	// no longer held and is processed now.
+	if held, taken := b.takeFutureBlock(blockHash, dryRun); held && !taken {
+		str := fmt.Sprintf("already have block (future) %v", blockHash)
+		return false, ruleError(ErrDuplicateBlock, str)
+	}
+
+	// The block must not have been stored as invalid.  Such blocks are only
+	// validated again when they are reconsidered.
+	if entry, ok := b.invalidBlocks[*blockHash]; ok {
+		str := fmt.Sprintf("block %v was previously found invalid: %s",
+			blockHash, entry.reason)
+		return false, ruleError(ErrKnownInvalidBlock, str)
+	}
+
+	if b.validateHook != nil {
+		b.validateHook(blockHash)
+	}
+
+	// The header checks were already performed when the header of the
+	// block was processed on its own, so skip them.
+	_, headerValidated := b.headerIndex[*blockHash]
+	sanityFlags := flags
+	if headerValidated {
+		sanityFlags |= BFNoPoWCheck
+	}
+
 	// Perform preliminary sanity checks on the block and its transactions.
-	err = checkBlockSanity(block, b.chainParams.PowLimit, b.timeSource, flags)
+	err = checkBlockSanity(block, b.chainParams, b.timeSource,
+		sanityFlags)
 	if err != nil {
+		// Hold blocks which only fail the checks because their timestamp
+		// is too far in the future so they are processed again once it
+		// no longer is.  The block is still reported as rejected.
+		rerr, ok := err.(RuleError)
+		if ok && rerr.ErrorCode == ErrTimeTooNew && !dryRun {
+			b.maybeHoldFutureBlock(block, flags, sanityFlags)
+		}
 		return false, err
 	}
 
@@ -169,7 +305,7 @@ func (b *BlockChain) ProcessBlock(block *colxutil.Block, flags BehaviorFlags) (b
 	if err != nil {
 		return false, err
 	}
-	if checkpointBlock != nil {
+	if checkpointBlock != nil && !headerValidated {
 		// Ensure the block timestamp is after the checkpoint timestamp.
 		checkpointHeader := &checkpointBlock.MsgBlock().Header
 		checkpointTime := checkpointHeader.Timestamp
@@ -199,8 +335,30 @@ func (b *BlockChain) ProcessBlock(block *colxutil.Block, flags BehaviorFlags) (b
 		}
 	}
 
-	// Handle orphan blocks.
+	// Stop before modifying any state when the context was canceled
+	// during the context independent checks.
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	// Reject blocks which descend from a block stored as invalid and store
+	// them as well so they are validated again along with their ancestor
+	// when it is reconsidered.
 	prevHash := &blockHeader.PrevBlock
+	if parent, ok := b.invalidBlocks[*prevHash]; ok {
+		str := fmt.Sprintf("block %v descends from invalid block %v",
+			blockHash, prevHash)
+		if !dryRun {
+			err := b.storeInvalidBlock(block, parent.height+1, str)
+			if err != nil {
+				log.Warnf("Unable to store invalid block %v: %v",
+					blockHash, err)
+			}
+		}
+		return false, ruleError(ErrInvalidAncestorBlock, str)
+	}
+
+	// Handle orphan blocks.
 	if !prevHash.IsEqual(zeroHash) {
 		prevHashExists, err := b.blockExists(prevHash)
 		if err != nil {
@@ -219,7 +377,7 @@ func (b *BlockChain) ProcessBlock(block *colxutil.Block, flags BehaviorFlags) (b
 
 	// The block has passed all context independent checks and appears sane
 	// enough to potentially accept it into the block chain.
-	err = b.maybeAcceptBlock(block, flags)
+	err = b.maybeAcceptBlock(ctx, block, flags)
 	if err != nil {
 		return false, err
 	}
@@ -0,0 +1,395 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/blockchain/chaingen"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// solveBlock increments the nonce of the passed block header until it hashes
+// to a value less than the target difficulty.
+func solveBlock(header *wire.BlockHeader) {
+	target := blockchain.CompactToBig(header.Bits)
+	for {
+		hash := header.BlockSha()
+		if blockchain.ShaHashToBig(&hash).Cmp(target) <= 0 {
+			return
+		}
+		header.Nonce++
+	}
+}
+
+// generateChain returns a chain of valid blocks with the passed number of
+// blocks built on the genesis block of the passed network parameters.  The
+// parameters must have a proof of work limit which makes solving the blocks
+// trivial.
+func generateChain(params *chaincfg.Params, numBlocks int) ([]*colxutil.Block, error) {
+	return generateChainFrom(params, &params.GenesisBlock.Header, 0,
+		numBlocks, 0)
+}
+
+// generateChainFrom returns a chain of valid blocks with the passed number of
+// blocks built on the passed parent block header at the given height.  The
+// extra nonce is included in the coinbase of every block so chains which fork
+// from the same parent are made up of different blocks.
+func generateChainFrom(params *chaincfg.Params, parent *wire.BlockHeader, parentHeight int32, numBlocks int, extraNonce int64) ([]*colxutil.Block, error) {
+	timestamps := make([]time.Time, 0, numBlocks)
+	prevTime := parent.Timestamp
+	for i := 0; i < numBlocks; i++ {
+		prevTime = prevTime.Add(time.Minute * 10)
+		timestamps = append(timestamps, prevTime)
+	}
+	return generateChainWithTimes(params, parent, parentHeight, timestamps,
+		extraNonce)
+}
+
+// generateChainWithTimes returns a chain of valid blocks built on the passed
+// parent block header at the given height which has a block with each of the
+// passed timestamps.  The timestamps must be after the median time of the
+// blocks prior to each block for the blocks to be accepted.
+func generateChainWithTimes(params *chaincfg.Params, parent *wire.BlockHeader, parentHeight int32, timestamps []time.Time, extraNonce int64) ([]*colxutil.Block, error) {
+	blocks := make([]*colxutil.Block, 0, len(timestamps))
+	prevHash := parent.BlockSha()
+	for i, timestamp := range timestamps {
+		height := parentHeight + int32(i) + 1
+		coinbaseScript, err := txscript.NewScriptBuilder().
+			AddInt64(int64(height)).AddInt64(extraNonce).Script()
+		if err != nil {
+			return nil, err
+		}
+		coinbaseTx := wire.NewMsgTx()
+		coinbaseTx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: *wire.NewOutPoint(&wire.ShaHash{},
+				wire.MaxPrevOutIndex),
+			SignatureScript: coinbaseScript,
+			Sequence:        wire.MaxTxInSequenceNum,
+		})
+		coinbaseTx.AddTxOut(wire.NewTxOut(blockchain.CalcBlockSubsidy(
+			height, params), []byte{txscript.OP_TRUE}))
+
+		msgBlock := wire.MsgBlock{
+			Header: wire.BlockHeader{
+				Version:   4,
+				PrevBlock: prevHash,
+				Timestamp: timestamp,
+				Bits:      params.PowLimitBits,
+			},
+		}
+		if err := msgBlock.AddTransaction(coinbaseTx); err != nil {
+			return nil, err
+		}
+		block := colxutil.NewBlock(&msgBlock)
+		merkles := blockchain.BuildMerkleTreeStore(block.Transactions())
+		msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+		solveBlock(&msgBlock.Header)
+
+		block = colxutil.NewBlock(&msgBlock)
+		blocks = append(blocks, block)
+		prevHash = *block.Sha()
+	}
+	return blocks, nil
+}
+
+// TestProcessBlockConcurrentDuplicates ensures submitting the same chain of
+// blocks from several goroutines at once results in every block being fully
+// validated exactly once and the expected final chain state.
+func TestProcessBlockConcurrentDuplicates(t *testing.T) {
+	const numBlocks = 50
+	const numSubmitters = 8
+
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, numBlocks)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("concurrentdups", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	var mtx sync.Mutex
+	validated := make(map[wire.ShaHash]int)
+	blockchain.TstSetValidateHook(chain, func(hash *wire.ShaHash) {
+		mtx.Lock()
+		validated[*hash]++
+		mtx.Unlock()
+	})
+
+	// Submit the full chain from every goroutine at the same time.  Each
+	// submission must either be accepted or, when it arrives after the
+	// block has already been processed, be rejected as a duplicate.
+	start := make(chan struct{})
+	errChan := make(chan error, numSubmitters*numBlocks)
+	var wg sync.WaitGroup
+	for i := 0; i < numSubmitters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			for _, block := range blocks {
+				isOrphan, err := chain.ProcessBlock(block,
+					blockchain.BFNone)
+				if rerr, ok := err.(blockchain.RuleError); ok &&
+					rerr.ErrorCode == blockchain.ErrDuplicateBlock {
+					continue
+				}
+				if err != nil {
+					errChan <- err
+					continue
+				}
+				if isOrphan {
+					errChan <- blockchain.AssertError(
+						"block unexpectedly an orphan")
+				}
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(errChan)
+	for err := range errChan {
+		t.Errorf("ProcessBlock: unexpected error: %v", err)
+	}
+
+	for i, block := range blocks {
+		if count := validated[*block.Sha()]; count != 1 {
+			t.Errorf("block %d (%v) validated %d times, want 1", i+1,
+				block.Sha(), count)
+		}
+	}
+
+	best := chain.BestSnapshot()
+	wantHash := blocks[numBlocks-1].Sha()
+	if best.Height != numBlocks || !best.Hash.IsEqual(wantHash) {
+		t.Fatalf("unexpected best chain state - got height %d hash %v, "+
+			"want height %d hash %v", best.Height, best.Hash,
+			numBlocks, wantHash)
+	}
+}
+
+// TestProcessBlockPanicCleanup ensures a block whose processing panics is
+// removed from the set of blocks being processed so it can be submitted again.
+func TestProcessBlockPanicCleanup(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, 1)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("panicCleanup", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	blockchain.TstSetValidateHook(chain, func(*wire.ShaHash) {
+		panic("validation panic")
+	})
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("ProcessBlock did not panic")
+			}
+		}()
+		chain.ProcessBlock(blocks[0], blockchain.BFNone)
+	}()
+
+	blockchain.TstSetValidateHook(chain, nil)
+	done := make(chan error, 1)
+	go func() {
+		_, err := chain.ProcessBlock(blocks[0], blockchain.BFNone)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatalf("ProcessBlock did not return after earlier panic")
+	}
+}
+
+// fanOutMunger returns a block munger which replaces the output of the spending
+// transaction of a generated block with the passed number of outputs and adds
+// a transaction which spends each of them to the block.
+func fanOutMunger(numOutputs int) func(*wire.MsgBlock) {
+	return func(b *wire.MsgBlock) {
+		parent := b.Transactions[1]
+		value := parent.TxOut[0].Value / int64(numOutputs)
+		pkScript := parent.TxOut[0].PkScript
+		parent.TxOut = nil
+		for i := 0; i < numOutputs; i++ {
+			parent.AddTxOut(wire.NewTxOut(value, pkScript))
+		}
+		for i := 0; i < numOutputs; i++ {
+			out := chaingen.MakeSpendableOut(parent, uint32(i))
+			b.AddTransaction(chaingen.CreateSpendTx(&out, 0))
+		}
+		b.Header.MerkleRoot = chaingen.CalcMerkleRoot(b.Transactions)
+	}
+}
+
+// TestProcessBlockCtxCancel ensures canceling the context passed to
+// ProcessBlockCtx while a large block is being validated abandons processing
+// it without modifying the chain state and that the block is accepted when it
+// is processed again.
+func TestProcessBlockCtxCancel(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chain, teardownFunc, err := chainSetup("processctxcancel", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// Generate enough blocks for the first coinbase to mature.
+	g := chaingen.NewGenerator(params)
+	for i := 1; i <= blockchain.CoinbaseMaturity; i++ {
+		block := colxutil.NewBlock(g.NextBlock(fmt.Sprintf("b%d", i), nil))
+		if _, err := chain.ProcessBlock(block, blockchain.BFNone); err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+	g.SaveSpendableCoinbaseOuts()
+	tip := chain.BestSnapshot()
+
+	// Create a block with a few thousand inputs to validate and cancel the
+	// context once its scripts are about to be validated.
+	big := colxutil.NewBlock(g.NextBlock("big", g.OldestCoinbaseOut(),
+		fanOutMunger(2000)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blockchain.TstSetScriptsHook(chain, func(hash *wire.ShaHash, runScripts bool) {
+		if hash.IsEqual(big.Sha()) {
+			cancel()
+		}
+	})
+	_, err = chain.ProcessBlockCtx(ctx, big, blockchain.BFNone)
+	if err != context.Canceled {
+		t.Fatalf("ProcessBlockCtx: unexpected error - got %v, want %v",
+			err, context.Canceled)
+	}
+
+	// Ensure the tip did not advance and the block is not known, so it is
+	// not rejected as a duplicate when it is processed again.
+	best := chain.BestSnapshot()
+	if !best.Hash.IsEqual(tip.Hash) || best.Height != tip.Height {
+		t.Fatalf("unexpected best block after canceling - got %v "+
+			"(height %d), want %v (height %d)", best.Hash,
+			best.Height, tip.Hash, tip.Height)
+	}
+	have, err := chain.HaveBlock(big.Sha())
+	if err != nil {
+		t.Fatalf("HaveBlock: unexpected error: %v", err)
+	}
+	if have {
+		t.Fatal("canceled block is known to the chain")
+	}
+
+	// Ensure the block is accepted once it is processed without canceling.
+	blockchain.TstSetScriptsHook(chain, nil)
+	if _, err := chain.ProcessBlock(big, blockchain.BFNone); err != nil {
+		t.Fatalf("ProcessBlock: unexpected error: %v", err)
+	}
+	best = chain.BestSnapshot()
+	if !best.Hash.IsEqual(big.Sha()) {
+		t.Fatalf("unexpected best block - got %v, want %v", best.Hash,
+			big.Sha())
+	}
+
+	// Ensure a block is not processed at all when the context is already
+	// canceled.
+	next := colxutil.NewBlock(g.NextBlock("next", nil))
+	_, err = chain.ProcessBlockCtx(ctx, next, blockchain.BFNone)
+	if err != context.Canceled {
+		t.Fatalf("ProcessBlockCtx: unexpected error - got %v, want %v",
+			err, context.Canceled)
+	}
+	if best := chain.BestSnapshot(); !best.Hash.IsEqual(big.Sha()) {
+		t.Fatalf("unexpected best block - got %v, want %v", best.Hash,
+			big.Sha())
+	}
+}
+
+// TestProcessBlockCtxCancelReorg ensures canceling the context passed to
+// ProcessBlockCtx while the blocks of a reorganization are being validated
+// leaves the main chain untouched and that the reorganization happens once the
+// block which causes it is processed again.
+func TestProcessBlockCtxCancelReorg(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chain, teardownFunc, err := chainSetup("processctxreorg", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	process := func(block *wire.MsgBlock) {
+		_, err := chain.ProcessBlock(colxutil.NewBlock(block),
+			blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+
+	// genesis -> b1 -> b2 -> b3
+	//              \-> b2a -> b3a -> b4a
+	g := chaingen.NewGenerator(params)
+	for i := 1; i <= 3; i++ {
+		process(g.NextBlock(fmt.Sprintf("b%d", i), nil))
+	}
+	tip := chain.BestSnapshot()
+	g.SetTip("b1")
+	b2a := g.NextBlock("b2a", nil)
+	process(b2a)
+	process(g.NextBlock("b3a", nil))
+	b4a := colxutil.NewBlock(g.NextBlock("b4a", nil))
+
+	// Cancel the context while the first block of the side chain is being
+	// validated for the reorganization.
+	b2aHash := b2a.BlockSha()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	blockchain.TstSetScriptsHook(chain, func(hash *wire.ShaHash, runScripts bool) {
+		if hash.IsEqual(&b2aHash) {
+			cancel()
+		}
+	})
+	_, err = chain.ProcessBlockCtx(ctx, b4a, blockchain.BFNone)
+	if err != context.Canceled {
+		t.Fatalf("ProcessBlockCtx: unexpected error - got %v, want %v",
+			err, context.Canceled)
+	}
+	best := chain.BestSnapshot()
+	if !best.Hash.IsEqual(tip.Hash) {
+		t.Fatalf("unexpected best block after canceling - got %v, "+
+			"want %v", best.Hash, tip.Hash)
+	}
+
+	// Ensure the reorganization happens once the block is processed
+	// again.
+	blockchain.TstSetScriptsHook(chain, nil)
+	if _, err := chain.ProcessBlock(b4a, blockchain.BFNone); err != nil {
+		t.Fatalf("ProcessBlock: unexpected error: %v", err)
+	}
+	best = chain.BestSnapshot()
+	if !best.Hash.IsEqual(b4a.Sha()) {
+		t.Fatalf("unexpected best block - got %v, want %v", best.Hash,
+			b4a.Sha())
+	}
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+const (
+	// DefaultPruneDepth is the default number of the most recent main
+	// chain blocks whose data is never pruned.  It also limits how deep a
+	// reorganize a pruned chain is able to perform.
+	DefaultPruneDepth = 288
+
+	// maxPruneBlocksPerTx is the maximum number of blocks which are pruned
+	// in a single database transaction.
+	maxPruneBlocksPerTx = 1000
+)
+
+var (
+	// pruneStateKeyName is the name of the db key used to store the prune
+	// state.
+	pruneStateKeyName = []byte("prunestate")
+)
+
+// -----------------------------------------------------------------------------
+// The prune state consists of the height of the lowest main chain block whose
+// data is still stored along with the total size of the data for the stored
+// main chain blocks.  It is only present once pruning has been enabled for the
+// database.
+//
+// The serialized format is:
+//
+//   <prune height><stored bytes>
+//
+//   Field             Type     Size
+//   prune height      uint32   4 bytes
+//   stored bytes      uint64   8 bytes
+// -----------------------------------------------------------------------------
+
+// pruneState represents the prune state stored in the database.
+type pruneState struct {
+	height      int32
+	storedBytes uint64
+}
+
+// serializePruneState returns the serialization of the passed prune state.
+// This is data to be stored in the metadata bucket.
+func serializePruneState(state pruneState) []byte {
+	serialized := make([]byte, 12)
+	byteOrder.PutUint32(serialized[0:4], uint32(state.height))
+	byteOrder.PutUint64(serialized[4:12], state.storedBytes)
+	return serialized
+}
+
+// deserializePruneState deserializes the passed serialized prune state.
+func deserializePruneState(serialized []byte) (pruneState, error) {
+	if len(serialized) < 12 {
+		return pruneState{}, database.Error{
+			ErrorCode:   database.ErrCorruption,
+			Description: "corrupt prune state",
+		}
+	}
+
+	return pruneState{
+		height:      int32(byteOrder.Uint32(serialized[0:4])),
+		storedBytes: byteOrder.Uint64(serialized[4:12]),
+	}, nil
+}
+
+// dbPutPruneState uses an existing database transaction to update the prune
+// state.
+func dbPutPruneState(dbTx database.Tx, state pruneState) error {
+	return dbTx.Metadata().Put(pruneStateKeyName, serializePruneState(state))
+}
+
+// dbFetchPruneState uses an existing database transaction to load the prune
+// state.  It returns nil when the database has never been pruned.
+func dbFetchPruneState(dbTx database.Tx) (*pruneState, error) {
+	serialized := dbTx.Metadata().Get(pruneStateKeyName)
+	if serialized == nil {
+		return nil, nil
+	}
+
+	state, err := deserializePruneState(serialized)
+	if err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// isPrunedErr returns whether or not the passed error is a database error
+// indicating the requested block data has been pruned.
+func isPrunedErr(err error) bool {
+	dbErr, ok := err.(database.Error)
+	return ok && dbErr.ErrorCode == database.ErrBlockPruned
+}
+
+// dbPruneBlocks uses an existing database transaction to prune the data for the
+// oldest stored main chain blocks, along with their spend journal entries,
+// until the stored data no longer exceeds the prune target.  The data for the
+// prune depth most recent blocks as of the passed best height is never pruned.
+// It returns the updated prune state, which the caller is responsible for
+// storing, and the number of blocks pruned.
+func (b *BlockChain) dbPruneBlocks(dbTx database.Tx, state pruneState, bestHeight int32) (pruneState, int, error) {
+	if b.pruneTarget == 0 {
+		return state, 0, nil
+	}
+
+	var hashes []wire.ShaHash
+	for state.storedBytes > b.pruneTarget &&
+		state.height <= bestHeight-b.pruneDepth &&
+		len(hashes) < maxPruneBlocksPerTx {
+
+		hash, err := dbFetchHashByHeight(dbTx, state.height)
+		if err != nil {
+			return state, 0, err
+		}
+		blockBytes, err := dbTx.FetchBlock(hash)
+		if err != nil {
+			return state, 0, err
+		}
+
+		// Remove the spend journal entry for the block since it is
+		// only needed to disconnect the block.
+		if err := dbRemoveSpendJournalEntry(dbTx, hash); err != nil {
+			return state, 0, err
+		}
+
+		hashes = append(hashes, *hash)
+		blockSize := uint64(len(blockBytes))
+		if blockSize > state.storedBytes {
+			blockSize = state.storedBytes
+		}
+		state.storedBytes -= blockSize
+		state.height++
+	}
+	if len(hashes) == 0 {
+		return state, 0, nil
+	}
+
+	if err := dbTx.PruneBlocks(hashes); err != nil {
+		return state, 0, err
+	}
+	return state, len(hashes), nil
+}
+
+// initPruneState loads the prune state from the database and prunes the chain
+// down to the prune target as needed.  When pruning is enabled for the first
+// time, the prune state is created from the size of the currently stored main
+// chain blocks.
+func (b *BlockChain) initPruneState() error {
+	var state *pruneState
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		state, err = dbFetchPruneState(dbTx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// There is nothing to do when the chain has never been pruned and
+	// pruning is not enabled.
+	if state == nil && b.pruneTarget == 0 {
+		return nil
+	}
+
+	// Calculate the size of the stored main chain blocks when pruning is
+	// enabled for the first time.
+	bestHeight := b.bestNode.height
+	if state == nil {
+		log.Infof("Calculating size of stored blocks for pruning")
+		state = &pruneState{}
+		err := b.db.Update(func(dbTx database.Tx) error {
+			for height := int32(0); height <= bestHeight; height++ {
+				hash, err := dbFetchHashByHeight(dbTx, height)
+				if err != nil {
+					return err
+				}
+				blockBytes, err := dbTx.FetchBlock(hash)
+				if err != nil {
+					return err
+				}
+				state.storedBytes += uint64(len(blockBytes))
+			}
+			return dbPutPruneState(dbTx, *state)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	b.pruneState = state
+
+	// Prune the chain down to the target in batches.
+	var totalPruned int
+	for {
+		newState := *b.pruneState
+		var numPruned int
+		err := b.db.Update(func(dbTx database.Tx) error {
+			var err error
+			newState, numPruned, err = b.dbPruneBlocks(dbTx, newState,
+				bestHeight)
+			if err != nil || numPruned == 0 {
+				return err
+			}
+			return dbPutPruneState(dbTx, newState)
+		})
+		if err != nil {
+			return err
+		}
+		if numPruned == 0 {
+			break
+		}
+		b.pruneState = &newState
+		totalPruned += numPruned
+	}
+	if totalPruned > 0 {
+		log.Infof("Pruned data for %d blocks (stored block data now "+
+			"starts at height %d)", totalPruned, b.pruneState.height)
+	}
+
+	return nil
+}
+
+// IsPruned returns whether or not the data for old blocks is being, or has
+// previously been, pruned from the database.  A pruned chain is unable to
+// serve the data for blocks below PruneHeight.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) IsPruned() bool {
+	b.chainLock.RLock()
+	isPruned := b.pruneState != nil
+	b.chainLock.RUnlock()
+	return isPruned
+}
+
+// PruneHeight returns the height of the lowest main chain block whose data is
+// still stored in the database.  It is zero when the chain is not pruned.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) PruneHeight() int32 {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+	if b.pruneState == nil {
+		return 0
+	}
+	return b.pruneState.height
+}
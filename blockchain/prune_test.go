@@ -0,0 +1,132 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestPruning ensures pruning a chain removes the data for old blocks while
+// retaining the data for the most recent blocks, that a reorganize within the
+// retained blocks still works, and that a reorganize deeper than the retained
+// blocks is refused.
+func TestPruning(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chainA, err := generateChain(params, 30)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("pruning", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	processBlocks := func(blocks []*colxutil.Block) {
+		for _, block := range blocks {
+			_, err := chain.ProcessBlock(block, blockchain.BFNone)
+			if err != nil {
+				t.Fatalf("ProcessBlock: unexpected error: %v", err)
+			}
+		}
+	}
+	assertTip := func(block *colxutil.Block) {
+		best := chain.BestSnapshot()
+		if !best.Hash.IsEqual(block.Sha()) {
+			t.Fatalf("unexpected tip - got %v (height %d), want %v",
+				best.Hash, best.Height, block.Sha())
+		}
+	}
+	assertPruneHeight := func(want int32) {
+		if !chain.IsPruned() {
+			t.Fatalf("IsPruned: chain is not pruned")
+		}
+		if got := chain.PruneHeight(); got != want {
+			t.Fatalf("PruneHeight: unexpected height - got %d, "+
+				"want %d", got, want)
+		}
+	}
+
+	processBlocks(chainA)
+	if chain.IsPruned() {
+		t.Fatalf("IsPruned: chain is pruned before enabling pruning")
+	}
+
+	// Prune everything except for the 5 most recent blocks by using a
+	// target that is always exceeded.
+	const pruneDepth = 5
+	if err := blockchain.TstSetPrune(chain, 1, pruneDepth); err != nil {
+		t.Fatalf("TstSetPrune: unexpected error: %v", err)
+	}
+	assertPruneHeight(int32(len(chainA)) - pruneDepth + 1)
+
+	// Ensure fetching the data for a pruned block fails with the expected
+	// error while the headers and retained blocks remain available.
+	prunedBlock := chainA[10]
+	_, err = chain.BlockByHash(prunedBlock.Sha())
+	if dbErr, ok := err.(database.Error); !ok ||
+		dbErr.ErrorCode != database.ErrBlockPruned {
+
+		t.Fatalf("BlockByHash: unexpected error for pruned block - "+
+			"got %v, want %v", err, database.ErrBlockPruned)
+	}
+	if _, err := chain.BlockByHeight(11); err == nil {
+		t.Fatalf("BlockByHeight: pruned block was returned")
+	}
+	hasBlock, err := chain.MainChainHasBlock(prunedBlock.Sha())
+	if err != nil {
+		t.Fatalf("MainChainHasBlock: unexpected error: %v", err)
+	}
+	if !hasBlock {
+		t.Fatalf("MainChainHasBlock: pruned block is not in the main " +
+			"chain")
+	}
+	retained := chainA[len(chainA)-pruneDepth]
+	if _, err := chain.BlockByHash(retained.Sha()); err != nil {
+		t.Fatalf("BlockByHash: unexpected error for retained block: "+
+			"%v", err)
+	}
+
+	// Reorganize to a branch forking within the retained blocks and ensure
+	// pruning continues as the chain grows.
+	forkHeight := int32(len(chainA)) - 2
+	forkParent := chainA[forkHeight-1]
+	chainB, err := generateChainFrom(params, &forkParent.MsgBlock().Header,
+		forkHeight, 4, 1)
+	if err != nil {
+		t.Fatalf("unable to generate fork: %v", err)
+	}
+	processBlocks(chainB)
+	tipB := chainB[len(chainB)-1]
+	assertTip(tipB)
+	tipHeight := forkHeight + int32(len(chainB))
+	assertPruneHeight(tipHeight - pruneDepth + 1)
+
+	// Ensure a reorganize to a branch forking below the retained blocks is
+	// refused with the expected error and the tip remains unchanged.
+	deepHeight := int32(21)
+	deepParent := chainA[deepHeight-1]
+	chainC, err := generateChainFrom(params, &deepParent.MsgBlock().Header,
+		deepHeight, int(tipHeight-deepHeight)+1, 2)
+	if err != nil {
+		t.Fatalf("unable to generate fork: %v", err)
+	}
+	processBlocks(chainC[:len(chainC)-1])
+	_, err = chain.ProcessBlock(chainC[len(chainC)-1], blockchain.BFNone)
+	if rerr, ok := err.(blockchain.RuleError); !ok ||
+		rerr.ErrorCode != blockchain.ErrPrunedReorg {
+
+		t.Fatalf("ProcessBlock: unexpected error for deep reorganize "+
+			"- got %v, want %v", err, blockchain.ErrPrunedReorg)
+	}
+	assertTip(tipB)
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// ChainQuery provides read access to a snapshot of the main chain and the
+// optional indexes which is consistent for as long as the query is running.
+// Blocks which are connected or disconnected while the query is running are
+// not visible to it since the chain state and the indexes are updated
+// atomically with the blocks.
+//
+// A ChainQuery is only valid within the function passed to Query.
+type ChainQuery struct {
+	dbTx  database.Tx
+	state bestChainState
+}
+
+// DBTx returns the database transaction the query reads from.  It is provided
+// so the optional indexes can serve lookups from the same snapshot.
+func (q *ChainQuery) DBTx() database.Tx {
+	return q.dbTx
+}
+
+// BestHash returns the hash of the tip of the main chain in the snapshot.
+func (q *ChainQuery) BestHash() *wire.ShaHash {
+	hash := q.state.hash
+	return &hash
+}
+
+// BestHeight returns the height of the tip of the main chain in the snapshot.
+func (q *ChainQuery) BestHeight() int32 {
+	return int32(q.state.height)
+}
+
+// BlockHeightByHash returns the height of the block with the given hash in the
+// main chain of the snapshot.
+func (q *ChainQuery) BlockHeightByHash(hash *wire.ShaHash) (int32, error) {
+	return dbFetchHeightByHash(q.dbTx, hash)
+}
+
+// BlockHashByHeight returns the hash of the block at the given height in the
+// main chain of the snapshot.
+func (q *ChainQuery) BlockHashByHeight(height int32) (*wire.ShaHash, error) {
+	return dbFetchHashByHeight(q.dbTx, height)
+}
+
+// Query invokes the passed function with a consistent snapshot of the main
+// chain and returns the error it returns, if any.  All of the lookups done
+// with the ChainQuery reflect the same best chain, so results which combine
+// several lookups do not mix data from both sides of a reorganize.
+//
+// The passed function must not retain the ChainQuery since it is only valid
+// until the function returns.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) Query(fn func(q *ChainQuery) error) error {
+	return b.db.View(func(dbTx database.Tx) error {
+		serialized := dbTx.Metadata().Get(chainStateKeyName)
+		state, err := deserializeBestChainState(serialized)
+		if err != nil {
+			return err
+		}
+		return fn(&ChainQuery{dbTx: dbTx, state: state})
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestQuery ensures a chain query reports the best chain and the heights of
+// its blocks both before and after a reorganize, and that the error returned
+// by the query function is passed through.
+func TestQuery(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	mainBlocks, err := generateChain(params, 6)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+	sideBlocks, err := generateChainFrom(params,
+		&mainBlocks[2].MsgBlock().Header, 3, 4, 1)
+	if err != nil {
+		t.Fatalf("unable to generate side chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("query", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// assertQuery ensures a query reports the passed blocks, which start at
+	// height 1, as the main chain and the passed blocks which are not part
+	// of it as unknown.
+	assertQuery := func(desc string, main, notMain []*colxutil.Block) {
+		err := chain.Query(func(q *blockchain.ChainQuery) error {
+			tip := main[len(main)-1]
+			if !q.BestHash().IsEqual(tip.Sha()) ||
+				q.BestHeight() != int32(len(main)) {
+
+				t.Fatalf("%s: unexpected best block - got %v (%d), "+
+					"want %v (%d)", desc, q.BestHash(),
+					q.BestHeight(), tip.Sha(), len(main))
+			}
+			for i, block := range main {
+				height, err := q.BlockHeightByHash(block.Sha())
+				if err != nil || height != int32(i+1) {
+					t.Fatalf("%s: unexpected height of block %v - "+
+						"got %d (%v), want %d", desc,
+						block.Sha(), height, err, i+1)
+				}
+				hash, err := q.BlockHashByHeight(int32(i + 1))
+				if err != nil || !hash.IsEqual(block.Sha()) {
+					t.Fatalf("%s: unexpected block at height %d - "+
+						"got %v (%v), want %v", desc, i+1,
+						hash, err, block.Sha())
+				}
+			}
+			for _, block := range notMain {
+				_, err := q.BlockHeightByHash(block.Sha())
+				if err == nil {
+					t.Fatalf("%s: block %v not in the main chain "+
+						"has a height", desc, block.Sha())
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("%s: Query: unexpected error: %v", desc, err)
+		}
+	}
+
+	for _, block := range mainBlocks {
+		if _, err := chain.ProcessBlock(block, blockchain.BFNone); err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+	assertQuery("main chain", mainBlocks, sideBlocks)
+
+	for _, block := range sideBlocks {
+		if _, err := chain.ProcessBlock(block, blockchain.BFNone); err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+	reorged := append(append([]*colxutil.Block(nil), mainBlocks[:3]...),
+		sideBlocks...)
+	assertQuery("after reorganize", reorged, mainBlocks[3:])
+
+	wantErr := blockchain.AssertError("query failed")
+	err = chain.Query(func(q *blockchain.ChainQuery) error {
+		return wantErr
+	})
+	if err != wantErr {
+		t.Fatalf("unexpected query error - got %v, want %v", err, wantErr)
+	}
+}
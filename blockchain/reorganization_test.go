@@ -15,6 +15,7 @@ import (
 	"testing"
 
 	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
 	"github.com/tinhnguyenhn/colxd/wire"
 	"github.com/tinhnguyenhn/colxutil"
 )
@@ -47,7 +48,8 @@ func TestReorganization(t *testing.T) {
 	t.Logf("Number of blocks: %v\n", len(blocks))
 
 	// Create a new database and chain instance to run tests against.
-	chain, teardownFunc, err := chainSetup("reorg")
+	chain, teardownFunc, err := chainSetup("reorg",
+		&chaincfg.MainNetParams)
 	if err != nil {
 		t.Errorf("Failed to setup chain instance: %v", err)
 		return
@@ -6,6 +6,7 @@
 package blockchain
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"runtime"
@@ -17,9 +18,101 @@ import (
 
 // txValidateItem holds a transaction along with which input to validate.
 type txValidateItem struct {
+	txIndex   int
 	txInIndex int
 	txIn      *wire.TxIn
 	tx        *colxutil.Tx
+	sigHashes *txscript.TxSigHashes
+}
+
+// txValidateResult holds the result of validating a transaction input.
+type txValidateResult struct {
+	item *txValidateItem
+	err  error
+}
+
+// txValidateNode tracks the validation of a transaction within the dependency
+// graph of the transactions being validated.  A transaction is only scheduled
+// for validation once all of the earlier transactions it spends outputs from
+// have been fully validated since there is no point in validating it when one
+// of them turns out to be invalid.
+type txValidateNode struct {
+	items      []*txValidateItem
+	remaining  int
+	numParents int
+	children   []*txValidateNode
+}
+
+// newTxValidateGraph returns the dependency graph for validating the scripts of
+// the passed transactions, which must be in the order they appear in a block,
+// along with the total number of inputs to validate.  The node for each
+// transaction is at the same index as the transaction.
+func newTxValidateGraph(txns []*colxutil.Tx) ([]*txValidateNode, int) {
+	var txIndexByHash map[wire.ShaHash]int
+	if len(txns) > 1 {
+		txIndexByHash = make(map[wire.ShaHash]int, len(txns))
+	}
+
+	numItems := 0
+	nodes := make([]*txValidateNode, len(txns))
+	for txIdx, tx := range txns {
+		txIns := tx.MsgTx().TxIn
+		node := &txValidateNode{
+			items: make([]*txValidateItem, 0, len(txIns)),
+		}
+		var parents []int
+		var sigHashes *txscript.TxSigHashes
+		for txInIdx, txIn := range txIns {
+			// Skip coinbases.
+			prevOut := &txIn.PreviousOutPoint
+			if prevOut.Index == math.MaxUint32 {
+				continue
+			}
+
+			// The serialization of the transaction used to compute
+			// signature hashes is shared by all of its inputs.
+			if sigHashes == nil {
+				sigHashes = txscript.NewTxSigHashes(tx.MsgTx())
+			}
+			node.items = append(node.items, &txValidateItem{
+				txIndex:   txIdx,
+				txInIndex: txInIdx,
+				txIn:      txIn,
+				tx:        tx,
+				sigHashes: sigHashes,
+			})
+
+			// Add a dependency on the earlier transaction in the
+			// block which creates the output being spent, if any,
+			// unless it was already added.
+			parentIdx, ok := txIndexByHash[prevOut.Hash]
+			if !ok {
+				continue
+			}
+			var known bool
+			for _, idx := range parents {
+				if idx == parentIdx {
+					known = true
+					break
+				}
+			}
+			if !known {
+				parents = append(parents, parentIdx)
+				parent := nodes[parentIdx]
+				parent.children = append(parent.children, node)
+				node.numParents++
+			}
+		}
+		node.remaining = len(node.items)
+		numItems += len(node.items)
+
+		nodes[txIdx] = node
+		if txIndexByHash != nil {
+			txIndexByHash[*tx.Sha()] = txIdx
+		}
+	}
+
+	return nodes, numItems
 }
 
 // txValidator provides a type which asynchronously validates transaction
@@ -27,103 +120,115 @@ type txValidateItem struct {
 // function that is intended to be in run multiple goroutines.
 type txValidator struct {
 	validateChan chan *txValidateItem
-	quitChan     chan struct{}
-	resultChan   chan error
+	resultChan   chan txValidateResult
 	utxoView     *UtxoViewpoint
 	flags        txscript.ScriptFlags
 	sigCache     *txscript.SigCache
 }
 
-// sendResult sends the result of a script pair validation on the internal
-// result channel while respecting the quit channel.  The allows orderly
-// shutdown when the validation process is aborted early due to a validation
-// error in one of the other goroutines.
-func (v *txValidator) sendResult(result error) {
-	select {
-	case v.resultChan <- result:
-	case <-v.quitChan:
+// validateItem validates the script pair for the passed transaction input.
+// The returned rule error identifies the transaction and input which failed.
+func (v *txValidator) validateItem(txVI *txValidateItem) error {
+	// Ensure the referenced input transaction is available.
+	txIn := txVI.txIn
+	originTxHash := &txIn.PreviousOutPoint.Hash
+	originTxIndex := txIn.PreviousOutPoint.Index
+	txEntry := v.utxoView.LookupEntry(originTxHash)
+	if txEntry == nil {
+		str := fmt.Sprintf("unable to find input transaction %v "+
+			"referenced from transaction %v", originTxHash,
+			txVI.tx.Sha())
+		return txInRuleError(ErrMissingTx, str, txVI)
+	}
+
+	// Ensure the referenced input transaction public key script is
+	// available.
+	pkScript := txEntry.PkScriptByIndex(originTxIndex)
+	if pkScript == nil {
+		str := fmt.Sprintf("unable to find unspent output %v script "+
+			"referenced from transaction %s:%d",
+			txIn.PreviousOutPoint, txVI.tx.Sha(), txVI.txInIndex)
+		return txInRuleError(ErrBadTxInput, str, txVI)
+	}
+
+	// Create a new script engine for the script pair.
+	sigScript := txIn.SignatureScript
+	vm, err := txscript.NewEngineWithOptions(pkScript, txVI.tx.MsgTx(),
+		txVI.txInIndex, v.flags, &txscript.EngineOptions{
+			SigCache:  v.sigCache,
+			SigHashes: txVI.sigHashes,
+		})
+	if err != nil {
+		str := fmt.Sprintf("failed to parse input %s:%d which "+
+			"references output %s:%d - %v (input script bytes %x, "+
+			"prev output script bytes %x)", txVI.tx.Sha(),
+			txVI.txInIndex, originTxHash, originTxIndex, err,
+			sigScript, pkScript)
+		return txInRuleError(ErrScriptMalformed, str, txVI)
+	}
+
+	// Execute the script pair.
+	if err := vm.Execute(); err != nil {
+		str := fmt.Sprintf("failed to validate input %s:%d which "+
+			"references output %s:%d - %v (input script bytes %x, "+
+			"prev output script bytes %x)", txVI.tx.Sha(),
+			txVI.txInIndex, originTxHash, originTxIndex, err,
+			sigScript, pkScript)
+		return txInRuleError(ErrScriptValidation, str, txVI)
 	}
+
+	return nil
+}
+
+// txInRuleError creates a RuleError which identifies the transaction and input
+// of the passed item as responsible for the rule violation.
+func txInRuleError(c ErrorCode, desc string, txVI *txValidateItem) RuleError {
+	err := ruleError(c, desc)
+	err.TxIndex = txVI.txIndex
+	err.TxInIndex = txVI.txInIndex
+	return err
 }
 
 // validateHandler consumes items to validate from the internal validate channel
-// and returns the result of the validation on the internal result channel. It
-// must be run as a goroutine.
-func (v *txValidator) validateHandler() {
-out:
+// and returns the result of the validation on the internal result channel until
+// the passed context is canceled.  It must be run as a goroutine.
+func (v *txValidator) validateHandler(ctx context.Context) {
 	for {
 		select {
 		case txVI := <-v.validateChan:
-			// Ensure the referenced input transaction is available.
-			txIn := txVI.txIn
-			originTxHash := &txIn.PreviousOutPoint.Hash
-			originTxIndex := txIn.PreviousOutPoint.Index
-			txEntry := v.utxoView.LookupEntry(originTxHash)
-			if txEntry == nil {
-				str := fmt.Sprintf("unable to find input "+
-					"transaction %v referenced from "+
-					"transaction %v", originTxHash,
-					txVI.tx.Sha())
-				err := ruleError(ErrMissingTx, str)
-				v.sendResult(err)
-				break out
+			// Don't bother validating the input when the
+			// validation was canceled while waiting for it.
+			if ctx.Err() != nil {
+				return
 			}
 
-			// Ensure the referenced input transaction public key
-			// script is available.
-			pkScript := txEntry.PkScriptByIndex(originTxIndex)
-			if pkScript == nil {
-				str := fmt.Sprintf("unable to find unspent "+
-					"output %v script referenced from "+
-					"transaction %s:%d",
-					txIn.PreviousOutPoint, txVI.tx.Sha(),
-					txVI.txInIndex)
-				err := ruleError(ErrBadTxInput, str)
-				v.sendResult(err)
-				break out
+			result := txValidateResult{
+				item: txVI,
+				err:  v.validateItem(txVI),
 			}
-
-			// Create a new script engine for the script pair.
-			sigScript := txIn.SignatureScript
-			vm, err := txscript.NewEngine(pkScript, txVI.tx.MsgTx(),
-				txVI.txInIndex, v.flags, v.sigCache)
-			if err != nil {
-				str := fmt.Sprintf("failed to parse input "+
-					"%s:%d which references output %s:%d - "+
-					"%v (input script bytes %x, prev output "+
-					"script bytes %x)", txVI.tx.Sha(),
-					txVI.txInIndex, originTxHash,
-					originTxIndex, err, sigScript, pkScript)
-				err := ruleError(ErrScriptMalformed, str)
-				v.sendResult(err)
-				break out
-			}
-
-			// Execute the script pair.
-			if err := vm.Execute(); err != nil {
-				str := fmt.Sprintf("failed to validate input "+
-					"%s:%d which references output %s:%d - "+
-					"%v (input script bytes %x, prev output "+
-					"script bytes %x)", txVI.tx.Sha(),
-					txVI.txInIndex, originTxHash,
-					originTxIndex, err, sigScript, pkScript)
-				err := ruleError(ErrScriptValidation, str)
-				v.sendResult(err)
-				break out
+			select {
+			case v.resultChan <- result:
+			case <-ctx.Done():
+				return
 			}
 
-			// Validation succeeded.
-			v.sendResult(nil)
-
-		case <-v.quitChan:
-			break out
+		case <-ctx.Done():
+			return
 		}
 	}
 }
 
-// Validate validates the scripts for all of the passed transaction inputs using
-// multiple goroutines.
-func (v *txValidator) Validate(items []*txValidateItem) error {
-	if len(items) == 0 {
+// Validate validates the scripts for all of the inputs of the passed
+// transactions, which must be in the order they appear in a block, using
+// multiple goroutines.  Transactions which do not depend on each other are
+// validated fully in parallel, while transactions which spend outputs created
+// by earlier transactions are only validated once those transactions have been
+// fully validated.  All outstanding work is canceled as soon as any input fails
+// to validate or the passed context is canceled, in which case the error of the
+// context is returned.
+func (v *txValidator) Validate(ctx context.Context, txns []*colxutil.Tx) error {
+	nodes, numItems := newTxValidateGraph(txns)
+	if numItems == 0 {
 		return nil
 	}
 
@@ -134,57 +239,106 @@ func (v *txValidator) Validate(items []*txValidateItem) error {
 	if maxGoRoutines <= 0 {
 		maxGoRoutines = 1
 	}
-	if maxGoRoutines > len(items) {
-		maxGoRoutines = len(items)
+	if maxGoRoutines > numItems {
+		maxGoRoutines = numItems
 	}
 
 	// Start up validation handlers that are used to asynchronously
-	// validate each transaction input.
+	// validate each transaction input.  The context is canceled when any
+	// errors occur, once all of the inputs are validated, or when the
+	// parent context is canceled, so all processing goroutines exit
+	// regardless of which input had the validation error.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	for i := 0; i < maxGoRoutines; i++ {
-		go v.validateHandler()
+		go v.validateHandler(ctx)
+	}
+
+	// Queue the inputs of all transactions which do not depend on any
+	// other transactions being validated.
+	var queue []*txValidateItem
+	for _, node := range nodes {
+		if node.numParents == 0 {
+			queue = completeOrQueue(node, queue)
+		}
 	}
 
-	// Validate each of the inputs.  The quit channel is closed when any
-	// errors occur so all processing goroutines exit regardless of which
-	// input had the validation error.
-	numInputs := len(items)
-	currentItem := 0
+	// Validate each of the inputs, queueing the inputs of transactions as
+	// the transactions they depend on are fully validated.
 	processedItems := 0
-	for processedItems < numInputs {
-		// Only send items while there are still items that need to
-		// be processed.  The select statement will never select a nil
+	for processedItems < numItems {
+		// Only send items while there are queued items that need to be
+		// processed.  The select statement will never select a nil
 		// channel.
 		var validateChan chan *txValidateItem
 		var item *txValidateItem
-		if currentItem < numInputs {
+		if len(queue) > 0 {
 			validateChan = v.validateChan
-			item = items[currentItem]
+			item = queue[0]
+		}
+
+		// Stop dispatching work as soon as the context is canceled.
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
 		select {
 		case validateChan <- item:
-			currentItem++
+			queue[0] = nil
+			queue = queue[1:]
 
-		case err := <-v.resultChan:
+		case result := <-v.resultChan:
 			processedItems++
-			if err != nil {
-				close(v.quitChan)
-				return err
+			if result.err != nil {
+				return result.err
 			}
+
+			node := nodes[result.item.txIndex]
+			node.remaining--
+			if node.remaining == 0 {
+				queue = completeChildren(node, queue)
+			}
+
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 	}
 
-	close(v.quitChan)
 	return nil
 }
 
+// completeOrQueue appends the inputs of the passed transaction node, whose
+// dependencies have all been validated, to the passed queue.  When the
+// transaction does not have any inputs to validate, it is complete, so the
+// inputs of its children are queued instead as applicable.  The updated queue
+// is returned.
+func completeOrQueue(node *txValidateNode, queue []*txValidateItem) []*txValidateItem {
+	if len(node.items) == 0 {
+		return completeChildren(node, queue)
+	}
+	return append(queue, node.items...)
+}
+
+// completeChildren marks the passed transaction node, whose inputs have all
+// been validated, as complete for its children and queues the inputs of the
+// children which no longer have any outstanding dependencies.  The updated
+// queue is returned.
+func completeChildren(node *txValidateNode, queue []*txValidateItem) []*txValidateItem {
+	for _, child := range node.children {
+		child.numParents--
+		if child.numParents == 0 {
+			queue = completeOrQueue(child, queue)
+		}
+	}
+	return queue
+}
+
 // newTxValidator returns a new instance of txValidator to be used for
 // validating transaction scripts asynchronously.
 func newTxValidator(utxoView *UtxoViewpoint, flags txscript.ScriptFlags, sigCache *txscript.SigCache) *txValidator {
 	return &txValidator{
 		validateChan: make(chan *txValidateItem),
-		quitChan:     make(chan struct{}),
-		resultChan:   make(chan error),
+		resultChan:   make(chan txValidateResult),
 		utxoView:     utxoView,
 		sigCache:     sigCache,
 		flags:        flags,
@@ -192,29 +346,18 @@ func newTxValidator(utxoView *UtxoViewpoint, flags txscript.ScriptFlags, sigCach
 }
 
 // ValidateTransactionScripts validates the scripts for the passed transaction
-// using multiple goroutines.
+// using multiple goroutines.  The TxInIndex field of a returned RuleError
+// identifies the input which failed to validate.
 func ValidateTransactionScripts(tx *colxutil.Tx, utxoView *UtxoViewpoint, flags txscript.ScriptFlags, sigCache *txscript.SigCache) error {
-	// Collect all of the transaction inputs and required information for
-	// validation.
-	txIns := tx.MsgTx().TxIn
-	txValItems := make([]*txValidateItem, 0, len(txIns))
-	for txInIdx, txIn := range txIns {
-		// Skip coinbases.
-		if txIn.PreviousOutPoint.Index == math.MaxUint32 {
-			continue
-		}
-
-		txVI := &txValidateItem{
-			txInIndex: txInIdx,
-			txIn:      txIn,
-			tx:        tx,
-		}
-		txValItems = append(txValItems, txVI)
-	}
-
 	// Validate all of the inputs.
 	validator := newTxValidator(utxoView, flags, sigCache)
-	if err := validator.Validate(txValItems); err != nil {
+	err := validator.Validate(context.Background(), []*colxutil.Tx{tx})
+	if err != nil {
+		// The transaction is not part of a block.
+		if rerr, ok := err.(RuleError); ok {
+			rerr.TxIndex = -1
+			return rerr
+		}
 		return err
 	}
 
@@ -222,34 +365,14 @@ func ValidateTransactionScripts(tx *colxutil.Tx, utxoView *UtxoViewpoint, flags
 }
 
 // checkBlockScripts executes and validates the scripts for all transactions in
-// the passed block using multiple goroutines.
-func checkBlockScripts(block *colxutil.Block, utxoView *UtxoViewpoint, scriptFlags txscript.ScriptFlags, sigCache *txscript.SigCache) error {
-	// Collect all of the transaction inputs and required information for
-	// validation for all transactions in the block into a single slice.
-	numInputs := 0
-	for _, tx := range block.Transactions() {
-		numInputs += len(tx.MsgTx().TxIn)
-	}
-	txValItems := make([]*txValidateItem, 0, numInputs)
-	for _, tx := range block.Transactions() {
-		for txInIdx, txIn := range tx.MsgTx().TxIn {
-			// Skip coinbases.
-			if txIn.PreviousOutPoint.Index == math.MaxUint32 {
-				continue
-			}
-
-			txVI := &txValidateItem{
-				txInIndex: txInIdx,
-				txIn:      txIn,
-				tx:        tx,
-			}
-			txValItems = append(txValItems, txVI)
-		}
-	}
-
+// the passed block using multiple goroutines.  The TxIndex and TxInIndex fields
+// of a returned RuleError identify the transaction and input which failed to
+// validate.  Validation stops with the error of the passed context when it is
+// canceled.
+func checkBlockScripts(ctx context.Context, block *colxutil.Block, utxoView *UtxoViewpoint, scriptFlags txscript.ScriptFlags, sigCache *txscript.SigCache) error {
 	// Validate all of the inputs.
 	validator := newTxValidator(utxoView, scriptFlags, sigCache)
-	if err := validator.Validate(txValItems); err != nil {
+	if err := validator.Validate(ctx, block.Transactions()); err != nil {
 		return err
 	}
 
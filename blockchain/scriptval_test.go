@@ -6,12 +6,19 @@
 package blockchain_test
 
 import (
+	"context"
 	"fmt"
+	"math"
 	"runtime"
 	"testing"
+	"time"
 
 	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/btcec"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
 	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
 )
 
 // TestCheckBlockScripts ensures that validating the all of the scripts in a
@@ -45,3 +52,428 @@ func TestCheckBlockScripts(t *testing.T) {
 		return
 	}
 }
+
+// TestMinimalDataPolicyOnly ensures the minimal data push policy does not
+// change consensus validation by executing every input script of a known-good
+// block with the consensus flags and ensuring the only difference when the
+// minimal data flag is additionally enforced is the minimal data rule itself.
+func TestMinimalDataPolicyOnly(t *testing.T) {
+	testBlockNum := 277647
+	blockDataFile := fmt.Sprintf("%d.dat.bz2", testBlockNum)
+	blocks, err := loadBlocks(blockDataFile)
+	if err != nil {
+		t.Fatalf("Error loading file: %v\n", err)
+	}
+	storeDataFile := fmt.Sprintf("%d.utxostore.bz2", testBlockNum)
+	view, err := loadUtxoView(storeDataFile)
+	if err != nil {
+		t.Fatalf("Error loading txstore: %v\n", err)
+	}
+
+	consensusFlags := txscript.ScriptBip16
+	policyFlags := consensusFlags | txscript.ScriptVerifyMinimalData
+	var numInputs, numNonMinimal int
+	for _, tx := range blocks[0].Transactions() {
+		if blockchain.IsCoinBase(tx) {
+			continue
+		}
+		for txInIdx, txIn := range tx.MsgTx().TxIn {
+			prevOut := &txIn.PreviousOutPoint
+			entry := view.LookupEntry(&prevOut.Hash)
+			if entry == nil {
+				t.Fatalf("Missing input %v for tx %v", prevOut,
+					tx.Sha())
+			}
+			pkScript := entry.PkScriptByIndex(prevOut.Index)
+			numInputs++
+
+			vm, err := txscript.NewEngine(pkScript, tx.MsgTx(),
+				txInIdx, consensusFlags, nil)
+			if err != nil {
+				t.Fatalf("NewEngine: unexpected error: %v", err)
+			}
+			if err := vm.Execute(); err != nil {
+				t.Fatalf("Input %d of tx %v failed consensus "+
+					"validation: %v", txInIdx, tx.Sha(), err)
+			}
+
+			vm, err = txscript.NewEngine(pkScript, tx.MsgTx(),
+				txInIdx, policyFlags, nil)
+			if err != nil {
+				t.Fatalf("NewEngine: unexpected error: %v", err)
+			}
+			err = vm.Execute()
+			if err == txscript.ErrStackMinimalData {
+				numNonMinimal++
+			} else if err != nil {
+				t.Fatalf("Input %d of tx %v failed with minimal "+
+					"data enforced: %v", txInIdx, tx.Sha(), err)
+			}
+		}
+	}
+	t.Logf("Checked %d inputs, %d with non-minimal pushes", numInputs,
+		numNonMinimal)
+}
+
+// scriptTestKey houses a private key along with the pay-to-pubkey-hash script
+// which pays to it for use in creating test transactions.
+type scriptTestKey struct {
+	privKey  *btcec.PrivateKey
+	pkScript []byte
+}
+
+// newScriptTestKey returns a new random key for creating test transactions.
+func newScriptTestKey() (*scriptTestKey, error) {
+	privKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		return nil, err
+	}
+	pkHash := colxutil.Hash160(privKey.PubKey().SerializeCompressed())
+	addr, err := colxutil.NewAddressPubKeyHash(pkHash,
+		&chaincfg.RegressionNetParams)
+	if err != nil {
+		return nil, err
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &scriptTestKey{privKey: privKey, pkScript: pkScript}, nil
+}
+
+// signInput signs the passed input of the transaction, which must spend an
+// output paying to the passed key, with the given signing key.
+func signInput(tx *wire.MsgTx, txInIdx int, payee, signer *scriptTestKey) error {
+	sigScript, err := txscript.SignatureScript(tx, txInIdx, payee.pkScript,
+		txscript.SigHashAll, signer.privKey, true)
+	if err != nil {
+		return err
+	}
+	tx.TxIn[txInIdx].SignatureScript = sigScript
+	return nil
+}
+
+// newScriptTestTx returns a transaction which spends the passed outpoints, all
+// of which must pay to the passed key, to the given number of outputs paying
+// to the key.  The inputs are signed with the signing key.
+func newScriptTestTx(prevOuts []wire.OutPoint, numOutputs int, key, signer *scriptTestKey) (*colxutil.Tx, error) {
+	tx := wire.NewMsgTx()
+	for i := range prevOuts {
+		tx.AddTxIn(wire.NewTxIn(&prevOuts[i], nil))
+	}
+	for i := 0; i < numOutputs; i++ {
+		tx.AddTxOut(wire.NewTxOut(colxutil.SatoshiPerBitcoin/10,
+			key.pkScript))
+	}
+	for i := range prevOuts {
+		if err := signInput(tx, i, key, signer); err != nil {
+			return nil, err
+		}
+	}
+	return colxutil.NewTx(tx), nil
+}
+
+// newScriptTestFunding returns a transaction with the passed number of outputs
+// paying to the key along with a view which contains its outputs.
+func newScriptTestFunding(numOutputs int, key *scriptTestKey) (*colxutil.Tx, *blockchain.UtxoViewpoint) {
+	fundingTx := wire.NewMsgTx()
+	fundingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 0}, nil))
+	for i := 0; i < numOutputs; i++ {
+		fundingTx.AddTxOut(wire.NewTxOut(colxutil.SatoshiPerBitcoin,
+			key.pkScript))
+	}
+	funding := colxutil.NewTx(fundingTx)
+	view := blockchain.NewUtxoViewpoint()
+	view.AddTxOuts(funding, 1)
+	return funding, view
+}
+
+// newScriptTestBlock returns a block made up of a coinbase followed by the
+// passed transactions and adds the outputs of the transactions to the passed
+// view like connecting the block does.
+func newScriptTestBlock(txns []*colxutil.Tx, view *blockchain.UtxoViewpoint) *colxutil.Block {
+	coinbaseTx := wire.NewMsgTx()
+	coinbaseTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&wire.ShaHash{},
+		math.MaxUint32), []byte{0x51, 0x51}))
+	coinbaseTx.AddTxOut(wire.NewTxOut(0, []byte{txscript.OP_TRUE}))
+
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{})
+	msgBlock.AddTransaction(coinbaseTx)
+	for _, tx := range txns {
+		msgBlock.AddTransaction(tx.MsgTx())
+		view.AddTxOuts(tx, 2)
+	}
+	return colxutil.NewBlock(msgBlock)
+}
+
+// TestCheckBlockScriptsDependencies ensures validating the scripts of a block
+// with transactions that spend outputs created earlier in the same block works
+// as expected and that failures identify the transaction and input at fault.
+func TestCheckBlockScriptsDependencies(t *testing.T) {
+	key, err := newScriptTestKey()
+	if err != nil {
+		t.Fatalf("unable to create key: %v", err)
+	}
+	wrongKey, err := newScriptTestKey()
+	if err != nil {
+		t.Fatalf("unable to create key: %v", err)
+	}
+
+	// buildBlock returns a block where the transaction at index 1 spends a
+	// funding output, the transaction at index 2 spends both outputs of the
+	// transaction at index 1, and the transaction at index 3 spends another
+	// funding output independently.
+	buildBlock := func(badParent, badChildInput bool) (*colxutil.Block, *blockchain.UtxoViewpoint) {
+		funding, view := newScriptTestFunding(2, key)
+		parentSigner := key
+		if badParent {
+			parentSigner = wrongKey
+		}
+		parent, err := newScriptTestTx([]wire.OutPoint{
+			{Hash: *funding.Sha(), Index: 0},
+		}, 2, key, parentSigner)
+		if err != nil {
+			t.Fatalf("unable to create tx: %v", err)
+		}
+		child, err := newScriptTestTx([]wire.OutPoint{
+			{Hash: *parent.Sha(), Index: 0},
+			{Hash: *parent.Sha(), Index: 1},
+		}, 1, key, key)
+		if err != nil {
+			t.Fatalf("unable to create tx: %v", err)
+		}
+		if badChildInput {
+			// Reuse the signature of the first input for the
+			// second one which invalidates only the second one.
+			msgTx := child.MsgTx()
+			msgTx.TxIn[1].SignatureScript = msgTx.TxIn[0].SignatureScript
+			child = colxutil.NewTx(msgTx)
+		}
+		independent, err := newScriptTestTx([]wire.OutPoint{
+			{Hash: *funding.Sha(), Index: 1},
+		}, 1, key, key)
+		if err != nil {
+			t.Fatalf("unable to create tx: %v", err)
+		}
+		txns := []*colxutil.Tx{parent, child, independent}
+		return newScriptTestBlock(txns, view), view
+	}
+
+	tests := []struct {
+		name          string
+		badParent     bool
+		badChildInput bool
+		wantTxIndex   int
+		wantTxInIndex int
+	}{
+		{name: "valid", wantTxIndex: -1},
+		{
+			name:          "invalid in-block parent",
+			badParent:     true,
+			wantTxIndex:   1,
+			wantTxInIndex: 0,
+		},
+		{
+			name:          "invalid child input",
+			badChildInput: true,
+			wantTxIndex:   2,
+			wantTxInIndex: 1,
+		},
+	}
+
+	scriptFlags := txscript.ScriptBip16 | txscript.ScriptVerifyDERSignatures
+	for _, test := range tests {
+		block, view := buildBlock(test.badParent, test.badChildInput)
+		err := blockchain.TstCheckBlockScripts(block, view, scriptFlags,
+			nil)
+		if test.wantTxIndex == -1 {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", test.name, err)
+			}
+			continue
+		}
+
+		rerr, ok := err.(blockchain.RuleError)
+		if !ok || rerr.ErrorCode != blockchain.ErrScriptValidation {
+			t.Errorf("%s: unexpected error - got %v, want %v",
+				test.name, err, blockchain.ErrScriptValidation)
+			continue
+		}
+		if rerr.TxIndex != test.wantTxIndex ||
+			rerr.TxInIndex != test.wantTxInIndex {
+
+			t.Errorf("%s: unexpected failing input - got %d:%d, "+
+				"want %d:%d", test.name, rerr.TxIndex,
+				rerr.TxInIndex, test.wantTxIndex,
+				test.wantTxInIndex)
+		}
+	}
+}
+
+// TestCheckBlockScriptsCanceled ensures validating the scripts of a block stops
+// dispatching work and returns the error of the context once it is canceled
+// or its deadline is exceeded.
+func TestCheckBlockScriptsCanceled(t *testing.T) {
+	key, err := newScriptTestKey()
+	if err != nil {
+		t.Fatalf("unable to create key: %v", err)
+	}
+	const numTxns = 200
+	funding, view := newScriptTestFunding(numTxns, key)
+	txns := make([]*colxutil.Tx, 0, numTxns)
+	for i := 0; i < numTxns; i++ {
+		tx, err := newScriptTestTx([]wire.OutPoint{
+			{Hash: *funding.Sha(), Index: uint32(i)},
+		}, 1, key, key)
+		if err != nil {
+			t.Fatalf("unable to create tx: %v", err)
+		}
+		txns = append(txns, tx)
+	}
+	block := newScriptTestBlock(txns, view)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithDeadline(context.Background(),
+		time.Now().Add(-time.Second))
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want error
+	}{
+		{name: "canceled", ctx: canceled, want: context.Canceled},
+		{name: "expired", ctx: expired, want: context.DeadlineExceeded},
+	}
+
+	scriptFlags := txscript.ScriptBip16 | txscript.ScriptVerifyDERSignatures
+	for _, test := range tests {
+		err := blockchain.TstCheckBlockScriptsCtx(test.ctx, block, view,
+			scriptFlags, nil)
+		if err != test.want {
+			t.Errorf("%s: unexpected error - got %v, want %v",
+				test.name, err, test.want)
+		}
+	}
+}
+
+// TestCheckBlockScriptsSigCache ensures validating the scripts of a block adds
+// the verified signatures to the signature cache and validating the block again
+// finds all of them there.
+func TestCheckBlockScriptsSigCache(t *testing.T) {
+	key, err := newScriptTestKey()
+	if err != nil {
+		t.Fatalf("unable to create key: %v", err)
+	}
+	const numTxns = 20
+	funding, view := newScriptTestFunding(numTxns, key)
+	txns := make([]*colxutil.Tx, 0, numTxns)
+	for i := 0; i < numTxns; i++ {
+		tx, err := newScriptTestTx([]wire.OutPoint{
+			{Hash: *funding.Sha(), Index: uint32(i)},
+		}, 1, key, key)
+		if err != nil {
+			t.Fatalf("unable to create tx: %v", err)
+		}
+		txns = append(txns, tx)
+	}
+	block := newScriptTestBlock(txns, view)
+
+	sigCache := txscript.NewSigCache(1000)
+	scriptFlags := txscript.ScriptBip16 | txscript.ScriptVerifyDERSignatures
+	for i := 0; i < 2; i++ {
+		err := blockchain.TstCheckBlockScripts(block, view, scriptFlags,
+			sigCache)
+		if err != nil {
+			t.Fatalf("validation #%d: unexpected error: %v", i, err)
+		}
+		if n := sigCache.Len(); n != numTxns {
+			t.Fatalf("validation #%d: unexpected number of cached "+
+				"signatures - got %d, want %d", i, n, numTxns)
+		}
+	}
+
+	// An invalid signature is not cached.
+	wrongKey, err := newScriptTestKey()
+	if err != nil {
+		t.Fatalf("unable to create key: %v", err)
+	}
+	funding, view = newScriptTestFunding(1, key)
+	badTx, err := newScriptTestTx([]wire.OutPoint{
+		{Hash: *funding.Sha(), Index: 0},
+	}, 1, key, wrongKey)
+	if err != nil {
+		t.Fatalf("unable to create tx: %v", err)
+	}
+	block = newScriptTestBlock([]*colxutil.Tx{badTx}, view)
+	err = blockchain.TstCheckBlockScripts(block, view, scriptFlags, sigCache)
+	if err == nil {
+		t.Fatal("block with invalid signature was accepted")
+	}
+	if n := sigCache.Len(); n != numTxns {
+		t.Fatalf("unexpected number of cached signatures after invalid "+
+			"block - got %d, want %d", n, numTxns)
+	}
+}
+
+// BenchmarkCheckBlockScripts benchmarks validating the scripts of a large
+// synthetic block made up of chains of transactions which spend outputs created
+// earlier in the block mixed with independent transactions.  The block is
+// validated both without a signature cache and with a signature cache which
+// already contains all of its signatures, like when a block is validated after
+// its transactions were accepted to the memory pool.
+func BenchmarkCheckBlockScripts(b *testing.B) {
+	const numTxns = 1000
+	key, err := newScriptTestKey()
+	if err != nil {
+		b.Fatalf("unable to create key: %v", err)
+	}
+	funding, view := newScriptTestFunding(numTxns, key)
+
+	// Every transaction spends a funding output and all except every
+	// fourth one also spend an output of the transaction before it.
+	txns := make([]*colxutil.Tx, 0, numTxns)
+	for i := 0; i < numTxns; i++ {
+		prevOuts := []wire.OutPoint{{Hash: *funding.Sha(), Index: uint32(i)}}
+		if i%4 != 0 {
+			prevOuts = append(prevOuts, wire.OutPoint{
+				Hash:  *txns[i-1].Sha(),
+				Index: 0,
+			})
+		}
+		tx, err := newScriptTestTx(prevOuts, 1, key, key)
+		if err != nil {
+			b.Fatalf("unable to create tx: %v", err)
+		}
+		txns = append(txns, tx)
+	}
+	block := newScriptTestBlock(txns, view)
+
+	scriptFlags := txscript.ScriptBip16 | txscript.ScriptVerifyDERSignatures
+	warmSigCache := txscript.NewSigCache(2 * numTxns)
+	err = blockchain.TstCheckBlockScripts(block, view, scriptFlags,
+		warmSigCache)
+	if err != nil {
+		b.Fatalf("unexpected error: %v", err)
+	}
+
+	benches := []struct {
+		name     string
+		sigCache *txscript.SigCache
+	}{
+		{"no sigcache", nil},
+		{"warm sigcache", warmSigCache},
+	}
+	for _, bench := range benches {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				err := blockchain.TstCheckBlockScripts(block,
+					view, scriptFlags, bench.sigCache)
+				if err != nil {
+					b.Fatalf("unexpected error: %v", err)
+				}
+			}
+		})
+	}
+}
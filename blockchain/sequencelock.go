@@ -0,0 +1,166 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// SequenceLock represents the relative lock-times imposed on a transaction by
+// the sequence numbers of its inputs as defined by BIP0068.  A transaction may
+// only be included in a block once both locks are satisfied.
+type SequenceLock struct {
+	// MinHeight is the minimum height of a block the transaction may be
+	// included in.
+	MinHeight int32
+
+	// MinTime is the minimum past median time, in seconds since 1 Jan 1970
+	// GMT, the block prior to the one the transaction is included in must
+	// have.
+	MinTime int64
+}
+
+// IsSatisfied returns whether or not the sequence lock allows the transaction
+// to be included in a block at the passed height whose previous block has the
+// passed past median time.
+func (lock *SequenceLock) IsSatisfied(blockHeight int32, medianTimePast time.Time) bool {
+	return blockHeight >= lock.MinHeight &&
+		medianTimePast.Unix() >= lock.MinTime
+}
+
+// ancestorNode returns the ancestor of the passed node at the provided height.
+// It returns nil when the height is after the node or the ancestor could not
+// be found.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) ancestorNode(node *blockNode, height int32) (*blockNode, error) {
+	for node != nil && node.height > height {
+		var err error
+		node, err = b.getPrevNodeFromNode(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if node == nil || node.height != height {
+		return nil, nil
+	}
+	return node, nil
+}
+
+// calcSequenceLock computes the relative lock-times of the passed transaction
+// from the point of view of the passed block node using the passed view to
+// find the blocks the referenced outputs are in.  When mempool is false, the
+// transaction is part of the block the node represents, and otherwise the node
+// is the end of the main chain and the transaction is evaluated for inclusion
+// in the next block.  In that case, outputs which are not in the main chain yet
+// are assumed to be included in the next block as well.
+//
+// The locks are only imposed on transactions with a version which supports
+// them, as reported by wire.TxVersionSupports, once BIP0068 is active for the
+// block the transaction is included in, and the returned lock is satisfied by
+// any block otherwise.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) calcSequenceLock(node *blockNode, tx *colxutil.Tx, view *UtxoViewpoint, mempool bool) (*SequenceLock, error) {
+	sequenceLock := &SequenceLock{}
+	blockHeight := node.height
+	if mempool {
+		blockHeight++
+	}
+	msgTx := tx.MsgTx()
+	if blockHeight < b.chainParams.BIP0068Height ||
+		!wire.TxVersionSupports(wire.TxFeatureSequenceLocks,
+			msgTx.Version) || IsCoinBase(tx) {
+
+		return sequenceLock, nil
+	}
+
+	for txInIndex, txIn := range msgTx.TxIn {
+		// Inputs with the disable flag set don't impose a lock.
+		sequence := txIn.Sequence
+		if sequence&wire.SequenceLockTimeDisabled != 0 {
+			continue
+		}
+
+		prevOut := &txIn.PreviousOutPoint
+		entry := view.LookupEntry(&prevOut.Hash)
+		if entry == nil {
+			str := fmt.Sprintf("unable to find input transaction "+
+				"%v referenced from transaction %v", prevOut.Hash,
+				tx.Sha())
+			return nil, ruleError(ErrMissingTx, str)
+		}
+		inputHeight := entry.BlockHeight()
+		if inputHeight > blockHeight {
+			if !mempool {
+				str := fmt.Sprintf("input %d of transaction %v "+
+					"references an output at height %d after "+
+					"the block at height %d", txInIndex,
+					tx.Sha(), inputHeight, blockHeight)
+				return nil, AssertError(str)
+			}
+			inputHeight = blockHeight
+		}
+
+		relativeLock := int64(sequence & wire.SequenceLockTimeMask)
+		if sequence&wire.SequenceLockTimeIsSeconds != 0 {
+			// Time based locks are relative to the past median
+			// time of the block prior to the one which contains
+			// the referenced output.
+			prevInputHeight := inputHeight - 1
+			if prevInputHeight < 0 {
+				prevInputHeight = 0
+			}
+			prevInputNode, err := b.ancestorNode(node, prevInputHeight)
+			if err != nil {
+				return nil, err
+			}
+			if prevInputNode == nil {
+				str := fmt.Sprintf("unable to find the ancestor "+
+					"at height %d of block %v", prevInputHeight,
+					node.hash)
+				return nil, AssertError(str)
+			}
+			medianTime, err := b.calcPastMedianTime(prevInputNode)
+			if err != nil {
+				return nil, err
+			}
+			minTime := medianTime.Unix() +
+				relativeLock<<wire.SequenceLockTimeGranularity
+			if minTime > sequenceLock.MinTime {
+				sequenceLock.MinTime = minTime
+			}
+			continue
+		}
+
+		minHeight := inputHeight + int32(relativeLock)
+		if minHeight > sequenceLock.MinHeight {
+			sequenceLock.MinHeight = minHeight
+		}
+	}
+
+	return sequenceLock, nil
+}
+
+// CalcSequenceLock computes the relative lock-times imposed on the passed
+// transaction by the sequence numbers of its inputs as defined by BIP0068 using
+// the passed view to find the blocks the referenced outputs are in.  When
+// mempool is true, the transaction is evaluated for inclusion in the block
+// after the end of the main chain and outputs which are not in the main chain
+// yet are assumed to be included in that block as well.  Otherwise, it is
+// evaluated as part of the block at the end of the main chain.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CalcSequenceLock(tx *colxutil.Tx, view *UtxoViewpoint, mempool bool) (*SequenceLock, error) {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	return b.calcSequenceLock(b.bestNode, tx, view, mempool)
+}
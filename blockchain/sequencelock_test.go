@@ -0,0 +1,353 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// newSequenceLockTx returns a transaction with the passed version which spends
+// the first output of each of the passed transactions using the provided
+// sequence numbers to a single output paying their combined value to a script
+// anyone can spend.
+func newSequenceLockTx(version int32, prevTxns []*wire.MsgTx, sequences []uint32) *wire.MsgTx {
+	tx := wire.NewMsgTx()
+	tx.Version = version
+	var value int64
+	for i, prevTx := range prevTxns {
+		prevHash := prevTx.TxSha()
+		txIn := wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil)
+		txIn.Sequence = sequences[i]
+		tx.AddTxIn(txIn)
+		value += prevTx.TxOut[0].Value
+	}
+	tx.AddTxOut(wire.NewTxOut(value, []byte{txscript.OP_TRUE}))
+	return tx
+}
+
+// pastMedianTime returns the past median time of the block at the passed
+// height of the passed chain built on the genesis block of the parameters.
+func pastMedianTime(params *chaincfg.Params, blocks []*colxutil.Block, height int32) time.Time {
+	var timestamps []time.Time
+	for h := height; h >= 0 && h > height-11; h-- {
+		if h == 0 {
+			timestamps = append(timestamps,
+				params.GenesisBlock.Header.Timestamp)
+			continue
+		}
+		timestamps = append(timestamps,
+			blocks[h-1].MsgBlock().Header.Timestamp)
+	}
+	sort.Sort(blockchain.TstTimeSorter(timestamps))
+	return timestamps[len(timestamps)/2]
+}
+
+// TestCalcSequenceLock ensures the relative lock-times imposed by the sequence
+// numbers of transaction inputs are calculated as defined by BIP0068.
+func TestCalcSequenceLock(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, 20)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("calcseqlock", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	for _, block := range blocks {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+
+	// Create a view with outputs from the coinbases at heights 5 and 15
+	// along with an output from a transaction which is not in the main
+	// chain yet.
+	coinbase := func(height int32) *wire.MsgTx {
+		return blocks[height-1].Transactions()[0].MsgTx()
+	}
+	unconfirmed := newSequenceLockTx(1, []*wire.MsgTx{coinbase(20)},
+		[]uint32{wire.MaxTxInSequenceNum})
+	view := blockchain.NewUtxoViewpoint()
+	view.AddTxOuts(colxutil.NewTx(coinbase(5)), 5)
+	view.AddTxOuts(colxutil.NewTx(coinbase(15)), 15)
+	view.AddTxOuts(colxutil.NewTx(unconfirmed), 0x7fffffff)
+
+	const tipHeight = 20
+	timeLock := func(units uint32) uint32 {
+		return wire.SequenceLockTimeIsSeconds | units
+	}
+	minTime := func(inputHeight int32, units int64) int64 {
+		medianTime := pastMedianTime(params, blocks, inputHeight-1)
+		return medianTime.Unix() + units*512
+	}
+	tests := []struct {
+		name      string
+		version   int32
+		prevTxns  []*wire.MsgTx
+		sequences []uint32
+		want      blockchain.SequenceLock
+	}{
+		{
+			name:      "version 1 ignores sequence locks",
+			version:   1,
+			prevTxns:  []*wire.MsgTx{coinbase(5)},
+			sequences: []uint32{3},
+			want:      blockchain.SequenceLock{},
+		},
+		{
+			name:      "height lock",
+			version:   2,
+			prevTxns:  []*wire.MsgTx{coinbase(5)},
+			sequences: []uint32{3},
+			want:      blockchain.SequenceLock{MinHeight: 8},
+		},
+		{
+			name:      "negative version is treated as unsigned",
+			version:   -1,
+			prevTxns:  []*wire.MsgTx{coinbase(5)},
+			sequences: []uint32{3},
+			want:      blockchain.SequenceLock{MinHeight: 8},
+		},
+		{
+			name:     "disabled height lock",
+			version:  2,
+			prevTxns: []*wire.MsgTx{coinbase(5)},
+			sequences: []uint32{
+				wire.SequenceLockTimeDisabled | 3,
+			},
+			want: blockchain.SequenceLock{},
+		},
+		{
+			name:     "disabled time lock",
+			version:  2,
+			prevTxns: []*wire.MsgTx{coinbase(5)},
+			sequences: []uint32{
+				wire.SequenceLockTimeDisabled | timeLock(3),
+			},
+			want: blockchain.SequenceLock{},
+		},
+		{
+			name:      "bits outside the lock are ignored",
+			version:   2,
+			prevTxns:  []*wire.MsgTx{coinbase(5)},
+			sequences: []uint32{1<<30 | 1<<16 | 3},
+			want:      blockchain.SequenceLock{MinHeight: 8},
+		},
+		{
+			name:      "time lock relative to past median time",
+			version:   2,
+			prevTxns:  []*wire.MsgTx{coinbase(15)},
+			sequences: []uint32{timeLock(2)},
+			want:      blockchain.SequenceLock{MinTime: minTime(15, 2)},
+		},
+		{
+			name:     "latest of multiple locks",
+			version:  2,
+			prevTxns: []*wire.MsgTx{coinbase(5), coinbase(15), coinbase(5)},
+			sequences: []uint32{
+				20, timeLock(1), timeLock(40),
+			},
+			want: blockchain.SequenceLock{
+				MinHeight: 25,
+				MinTime:   minTime(5, 40),
+			},
+		},
+		{
+			name:      "unconfirmed input height lock",
+			version:   2,
+			prevTxns:  []*wire.MsgTx{unconfirmed},
+			sequences: []uint32{2},
+			want:      blockchain.SequenceLock{MinHeight: tipHeight + 3},
+		},
+		{
+			name:      "unconfirmed input time lock",
+			version:   2,
+			prevTxns:  []*wire.MsgTx{unconfirmed},
+			sequences: []uint32{timeLock(1)},
+			want: blockchain.SequenceLock{
+				MinTime: minTime(tipHeight+1, 1),
+			},
+		},
+	}
+	for _, test := range tests {
+		tx := newSequenceLockTx(test.version, test.prevTxns,
+			test.sequences)
+		got, err := chain.CalcSequenceLock(colxutil.NewTx(tx), view, true)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if *got != test.want {
+			t.Errorf("%s: unexpected sequence lock - got %+v, "+
+				"want %+v", test.name, *got, test.want)
+		}
+	}
+
+	// Ensure the locks are satisfied starting at the minimum height and
+	// once the past median time reaches the minimum time.
+	lock := blockchain.SequenceLock{MinHeight: 10, MinTime: 1000}
+	satisfiedTests := []struct {
+		height     int32
+		medianTime int64
+		want       bool
+	}{
+		{9, 1000, false},
+		{10, 999, false},
+		{10, 1000, true},
+		{11, 2000, true},
+	}
+	for _, test := range satisfiedTests {
+		got := lock.IsSatisfied(test.height, time.Unix(test.medianTime, 0))
+		if got != test.want {
+			t.Errorf("IsSatisfied(%d, %d): got %v, want %v",
+				test.height, test.medianTime, got, test.want)
+		}
+	}
+}
+
+// TestSequenceLockConnectBlock ensures blocks which contain transactions whose
+// sequence locks are not met, including locks on outputs created earlier in
+// the same block, are rejected while blocks which satisfy them are accepted.
+func TestSequenceLockConnectBlock(t *testing.T) {
+	blockchain.TstSetCoinbaseMaturity(1)
+	defer blockchain.TstSetCoinbaseMaturity(blockchain.CoinbaseMaturity)
+
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, 3)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("seqlockconnect", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	for _, block := range blocks {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+
+	// newBlock returns a block on top of the current tip which contains a
+	// transaction spending the coinbase of the block at the passed height
+	// followed by a version 2 transaction spending it with the passed
+	// sequence number.
+	tip := blocks[len(blocks)-1]
+	tipHeight := int32(len(blocks))
+	newBlock := func(coinbaseHeight int32, sequence uint32) *colxutil.Block {
+		parent := blocks[coinbaseHeight-1].Transactions()[0].MsgTx()
+		tx1 := newSequenceLockTx(1, []*wire.MsgTx{parent},
+			[]uint32{wire.MaxTxInSequenceNum})
+		tx2 := newSequenceLockTx(2, []*wire.MsgTx{tx1},
+			[]uint32{sequence})
+
+		generated, err := generateChainFrom(params,
+			&tip.MsgBlock().Header, tipHeight, 1,
+			int64(coinbaseHeight)<<32|int64(sequence))
+		if err != nil {
+			t.Fatalf("unable to generate block: %v", err)
+		}
+		msgBlock := generated[0].MsgBlock()
+		msgBlock.AddTransaction(tx1)
+		msgBlock.AddTransaction(tx2)
+		block := colxutil.NewBlock(msgBlock)
+		merkles := blockchain.BuildMerkleTreeStore(block.Transactions())
+		msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+		solveBlock(&msgBlock.Header)
+		return colxutil.NewBlock(msgBlock)
+	}
+
+	// Each accepted block becomes the new tip, so the blocks which are
+	// accepted spend different coinbases.
+	tests := []struct {
+		name           string
+		coinbaseHeight int32
+		sequence       uint32
+		accepted       bool
+	}{
+		{
+			name:           "same block height lock",
+			coinbaseHeight: 1,
+			sequence:       1,
+			accepted:       false,
+		},
+		{
+			name:           "same block time lock",
+			coinbaseHeight: 1,
+			sequence:       wire.SequenceLockTimeIsSeconds | 1,
+			accepted:       false,
+		},
+		{
+			name:           "same block disabled lock",
+			coinbaseHeight: 1,
+			sequence: wire.SequenceLockTimeDisabled |
+				wire.SequenceLockTimeIsSeconds | 1,
+			accepted: true,
+		},
+		{
+			name:           "same block zero height lock",
+			coinbaseHeight: 2,
+			sequence:       0,
+			accepted:       true,
+		},
+		{
+			name:           "same block zero time lock",
+			coinbaseHeight: 3,
+			sequence:       wire.SequenceLockTimeIsSeconds,
+			accepted:       true,
+		},
+	}
+	for _, test := range tests {
+		block := newBlock(test.coinbaseHeight, test.sequence)
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if test.accepted {
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", test.name, err)
+			}
+			best := chain.BestSnapshot()
+			if !best.Hash.IsEqual(block.Sha()) {
+				t.Fatalf("%s: block was not connected", test.name)
+			}
+			tip = block
+			tipHeight++
+			continue
+		}
+		var txErr blockchain.TxValidationError
+		if !errors.As(err, &txErr) ||
+			txErr.ErrorCode != blockchain.ErrUnfinalizedTx {
+
+			t.Errorf("%s: unexpected error - got %v, want %v",
+				test.name, err, blockchain.ErrUnfinalizedTx)
+			continue
+		}
+		if txErr.TxIndex != 2 {
+			t.Errorf("%s: unexpected transaction index - got %d, "+
+				"want 2", test.name, txErr.TxIndex)
+		}
+		wantHash := block.Transactions()[2].Sha()
+		if txErr.TxHash != *wantHash {
+			t.Errorf("%s: unexpected transaction hash - got %v, "+
+				"want %v", test.name, txErr.TxHash, wantHash)
+		}
+	}
+}
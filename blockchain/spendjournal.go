@@ -0,0 +1,288 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// unknownTxVersion is the version passed when decoding a spent txout which does
+// not encode the version of its containing transaction and the version is not
+// known yet.  The version does not affect how the txout itself is decoded, so
+// it is only used to satisfy decodeSpentTxOut and replaced once it is known.
+const unknownTxVersion = -1
+
+// SpentTxOut describes a transaction output spent by a block as recorded in the
+// spend journal.
+type SpentTxOut struct {
+	// Amount is the value of the output.
+	Amount int64
+
+	// PkScript is the public key script of the output.
+	PkScript []byte
+
+	// Height is the height of the block which contains the transaction the
+	// output is part of.
+	Height int32
+
+	// IsCoinBase is whether or not the output is part of a coinbase.
+	IsCoinBase bool
+
+	// version is the version of the transaction the output is part of.
+	version int32
+}
+
+// decodeSpendJournal decodes the passed serialized spend journal entry for the
+// passed transactions like deserializeSpendJournalEntry, except that the spent
+// txouts which neither encode the details of their containing transaction nor
+// have them available from the passed view or a later spend in the same entry
+// are decoded without them.  Those are returned with a version and height of
+// zero.
+func decodeSpendJournal(serialized []byte, txns []*wire.MsgTx, view *UtxoViewpoint) ([]spentTxOut, error) {
+	var numStxos int
+	for _, tx := range txns {
+		numStxos += len(tx.TxIn)
+	}
+	if len(serialized) == 0 {
+		if numStxos != 0 {
+			return nil, AssertError(fmt.Sprintf("mismatched spend "+
+				"journal serialization - no serialization for "+
+				"expected %d stxos", numStxos))
+		}
+		return nil, nil
+	}
+
+	// The entries are serialized in reverse order, so the final spend of
+	// a transaction, which encodes its details, is read before the other
+	// spends of it in the same entry.
+	stxoIdx := numStxos - 1
+	finalSpends := make(map[wire.ShaHash]int)
+	offset := 0
+	stxos := make([]spentTxOut, numStxos)
+	for txIdx := len(txns) - 1; txIdx > -1; txIdx-- {
+		tx := txns[txIdx]
+		for txInIdx := len(tx.TxIn) - 1; txInIdx > -1; txInIdx-- {
+			txIn := tx.TxIn[txInIdx]
+			stxo := &stxos[stxoIdx]
+			n, err := decodeSpentTxOut(serialized[offset:], stxo,
+				unknownTxVersion)
+			offset += n
+			if err != nil {
+				return nil, errDeserialize(fmt.Sprintf("unable "+
+					"to decode stxo for %v: %v",
+					txIn.PreviousOutPoint, err))
+			}
+
+			originHash := &txIn.PreviousOutPoint.Hash
+			switch idx, ok := finalSpends[*originHash]; {
+			case stxo.height != 0:
+				finalSpends[*originHash] = stxoIdx
+
+			case ok:
+				stxo.version = stxos[idx].version
+				stxo.height = stxos[idx].height
+				stxo.isCoinBase = stxos[idx].isCoinBase
+
+			default:
+				stxo.version = 0
+				if entry := view.LookupEntry(originHash); entry != nil {
+					stxo.version = entry.Version()
+					stxo.height = entry.BlockHeight()
+					stxo.isCoinBase = entry.IsCoinBase()
+				}
+			}
+			stxoIdx--
+		}
+	}
+
+	return stxos, nil
+}
+
+// dbResolveSpentTxOuts fills in the details of the containing transactions of
+// the passed spent txouts of the block at the passed height which are not
+// known yet.  Those outputs are part of transactions which still had unspent
+// outputs after the block and were fully spent by a later block, so the spend
+// journal entries of the later main chain blocks are searched for the final
+// spends which encode the details.
+func dbResolveSpentTxOuts(dbTx database.Tx, block *colxutil.Block, stxos []spentTxOut, bestHeight int32) error {
+	unresolved := make(map[wire.ShaHash][]int)
+	stxoIdx := 0
+	for _, tx := range block.MsgBlock().Transactions[1:] {
+		for _, txIn := range tx.TxIn {
+			if stxos[stxoIdx].version == 0 {
+				originHash := txIn.PreviousOutPoint.Hash
+				unresolved[originHash] = append(
+					unresolved[originHash], stxoIdx)
+			}
+			stxoIdx++
+		}
+	}
+
+	emptyView := NewUtxoViewpoint()
+	for height := block.Height() + 1; height <= bestHeight &&
+		len(unresolved) > 0; height++ {
+
+		laterBlock, err := dbFetchBlockByHeight(dbTx, height)
+		if err != nil {
+			return err
+		}
+		spendBucket := dbTx.Metadata().Bucket(spendJournalBucketName)
+		serialized := spendBucket.Get(laterBlock.Sha()[:])
+		txns := laterBlock.MsgBlock().Transactions[1:]
+		laterStxos, err := decodeSpendJournal(serialized, txns, emptyView)
+		if err != nil {
+			return err
+		}
+
+		laterIdx := 0
+		for _, tx := range txns {
+			for _, txIn := range tx.TxIn {
+				final := &laterStxos[laterIdx]
+				laterIdx++
+				originHash := txIn.PreviousOutPoint.Hash
+				idxs, ok := unresolved[originHash]
+				if !ok || final.height == 0 {
+					continue
+				}
+				for _, idx := range idxs {
+					stxos[idx].version = final.version
+					stxos[idx].height = final.height
+					stxos[idx].isCoinBase = final.isCoinBase
+				}
+				delete(unresolved, originHash)
+			}
+		}
+	}
+
+	if len(unresolved) != 0 {
+		return AssertError(fmt.Sprintf("the spend journal does not "+
+			"have the final spend of %d transactions spent by "+
+			"block %v", len(unresolved), block.Sha()))
+	}
+	return nil
+}
+
+// FetchSpendJournal returns the outputs spent by the passed main chain block as
+// recorded in the spend journal.  The spent outputs are in the order of the
+// inputs which spend them, that is the inputs of the transactions in the order
+// they appear in the block, skipping the coinbase, and the inputs of each
+// transaction in order.
+//
+// The spend journal only records the height and coinbase flag of a spent output
+// when it is the final unspent output of its transaction.  Otherwise they are
+// taken from the block itself or the utxo set, and when the transaction was
+// fully spent by a later block, from the spend journal of that block.  This
+// requires loading the later blocks, so the spend journal of blocks deep in the
+// main chain is more expensive to fetch.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) FetchSpendJournal(targetBlock *colxutil.Block) ([]SpentTxOut, error) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	var height int32
+	err := b.db.View(func(dbTx database.Tx) error {
+		var err error
+		height, err = dbFetchHeightByHash(dbTx, targetBlock.Sha())
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the details of the transactions which are spent by the block
+	// and created earlier in the block or still have unspent outputs.  Use
+	// a copy of the block so the height of the passed block is untouched.
+	block := colxutil.NewBlock(targetBlock.MsgBlock())
+	block.SetHeight(height)
+	view := NewUtxoViewpoint()
+	if err := view.fetchInputUtxos(b.db, block); err != nil {
+		return nil, err
+	}
+
+	var stxos []spentTxOut
+	err = b.db.View(func(dbTx database.Tx) error {
+		spendBucket := dbTx.Metadata().Bucket(spendJournalBucketName)
+		serialized := spendBucket.Get(block.Sha()[:])
+		var err error
+		stxos, err = decodeSpendJournal(serialized,
+			block.MsgBlock().Transactions[1:], view)
+		if err != nil {
+			if isDeserializeErr(err) {
+				return database.Error{
+					ErrorCode: database.ErrCorruption,
+					Description: fmt.Sprintf("corrupt spend "+
+						"information for %v: %v",
+						block.Sha(), err),
+				}
+			}
+			return err
+		}
+		return dbResolveSpentTxOuts(dbTx, block, stxos,
+			b.bestNode.height)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	spent := make([]SpentTxOut, len(stxos))
+	for i := range stxos {
+		stxo := &stxos[i]
+		amount, pkScript := stxo.amount, stxo.pkScript
+		if stxo.compressed {
+			amount = int64(decompressTxOutAmount(uint64(amount)))
+			pkScript = decompressScript(pkScript, stxo.version)
+		}
+		spent[i] = SpentTxOut{
+			Amount:     amount,
+			PkScript:   pkScript,
+			Height:     stxo.height,
+			IsCoinBase: stxo.isCoinBase,
+			version:    stxo.version,
+		}
+	}
+	return spent, nil
+}
+
+// AddSpentTxOuts adds the outputs spent by the passed block, as returned by
+// FetchSpendJournal, to the view as unspent outputs.  This provides the view of
+// the outputs referenced by the block as it was before the block connected.
+func (view *UtxoViewpoint) AddSpentTxOuts(block *colxutil.Block, stxos []SpentTxOut) error {
+	stxoIdx := 0
+	for _, tx := range block.MsgBlock().Transactions[1:] {
+		for _, txIn := range tx.TxIn {
+			if stxoIdx >= len(stxos) {
+				return AssertError(fmt.Sprintf("block %v spends "+
+					"more than the %d passed outputs",
+					block.Sha(), len(stxos)))
+			}
+			stxo := &stxos[stxoIdx]
+			stxoIdx++
+
+			originHash := &txIn.PreviousOutPoint.Hash
+			entry := view.entries[*originHash]
+			if entry == nil {
+				entry = newUtxoEntry(stxo.version, stxo.IsCoinBase,
+					stxo.Height)
+				view.entries[*originHash] = entry
+			}
+			entry.addOutput(txIn.PreviousOutPoint.Index, utxoOutput{
+				amount:   stxo.Amount,
+				pkScript: stxo.PkScript,
+			})
+		}
+	}
+	if stxoIdx != len(stxos) {
+		return AssertError(fmt.Sprintf("block %v spends %d outputs "+
+			"instead of the %d passed outputs", block.Sha(), stxoIdx,
+			len(stxos)))
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestFetchSpendJournal ensures the spend journal of main chain blocks lists
+// the outputs spent by the blocks in the order of the inputs which spend them
+// with their details, including outputs spent in the same block and outputs of
+// transactions which are only fully spent by later blocks.
+func TestFetchSpendJournal(t *testing.T) {
+	blockchain.TstSetCoinbaseMaturity(1)
+	defer blockchain.TstSetCoinbaseMaturity(blockchain.CoinbaseMaturity)
+
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, 5)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("fetchspendjournal", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	for _, block := range blocks {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+
+	// nextBlock returns a block which extends the passed block with the
+	// passed transactions.
+	nextBlock := func(parent *colxutil.Block, txns ...*wire.MsgTx) *colxutil.Block {
+		generated, err := generateChainFrom(params,
+			&parent.MsgBlock().Header, parent.Height(), 1, 0)
+		if err != nil {
+			t.Fatalf("unable to generate block: %v", err)
+		}
+		msgBlock := generated[0].MsgBlock()
+		for _, tx := range txns {
+			msgBlock.AddTransaction(tx)
+		}
+		merkles := blockchain.BuildMerkleTreeStore(
+			colxutil.NewBlock(msgBlock).Transactions())
+		msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+		solveBlock(&msgBlock.Header)
+		block := colxutil.NewBlock(msgBlock)
+		block.SetHeight(parent.Height() + 1)
+		return block
+	}
+	for i, block := range blocks {
+		block.SetHeight(int32(i) + 1)
+	}
+	coinbase := func(block *colxutil.Block) *wire.MsgTx {
+		return block.Transactions()[0].MsgTx()
+	}
+
+	// The block at height 6 spends the first coinbase, which is its final
+	// output, and splits it into two outputs.
+	split := newSequenceLockTx(1, []*wire.MsgTx{coinbase(blocks[0])},
+		[]uint32{wire.MaxTxInSequenceNum})
+	half := split.TxOut[0].Value / 2
+	split.TxOut[0].Value -= half
+	split.AddTxOut(wire.NewTxOut(half, []byte{txscript.OP_TRUE}))
+	block6 := nextBlock(blocks[len(blocks)-1], split)
+
+	// The block at height 7 spends one output of the split transaction,
+	// which is not its final one, along with the second coinbase, and
+	// spends the new output again in the same block.
+	spend := newSequenceLockTx(1,
+		[]*wire.MsgTx{split, coinbase(blocks[1])},
+		[]uint32{wire.MaxTxInSequenceNum, wire.MaxTxInSequenceNum})
+	spendAgain := newSequenceLockTx(1, []*wire.MsgTx{spend},
+		[]uint32{wire.MaxTxInSequenceNum})
+	block7 := nextBlock(block6, spend, spendAgain)
+
+	// The block at height 8 spends the final output of the split
+	// transaction.
+	final := wire.NewMsgTx()
+	splitHash := split.TxSha()
+	final.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&splitHash, 1), nil))
+	final.AddTxOut(wire.NewTxOut(half, []byte{txscript.OP_TRUE}))
+	block8 := nextBlock(block7, final)
+
+	opTrue := []byte{txscript.OP_TRUE}
+	want6 := []blockchain.SpentTxOut{
+		{Amount: coinbase(blocks[0]).TxOut[0].Value, PkScript: opTrue,
+			Height: 1, IsCoinBase: true},
+	}
+	want7 := []blockchain.SpentTxOut{
+		{Amount: split.TxOut[0].Value, PkScript: opTrue, Height: 6},
+		{Amount: coinbase(blocks[1]).TxOut[0].Value, PkScript: opTrue,
+			Height: 2, IsCoinBase: true},
+		{Amount: spend.TxOut[0].Value, PkScript: opTrue, Height: 7},
+	}
+	want8 := []blockchain.SpentTxOut{
+		{Amount: half, PkScript: opTrue, Height: 6},
+	}
+
+	check := func(name string, block *colxutil.Block, want []blockchain.SpentTxOut) {
+		stxos, err := chain.FetchSpendJournal(block)
+		if err != nil {
+			t.Fatalf("%s: FetchSpendJournal: unexpected error: %v",
+				name, err)
+		}
+		if len(stxos) != len(want) {
+			t.Fatalf("%s: unexpected number of spent outputs - got "+
+				"%d, want %d", name, len(stxos), len(want))
+		}
+		for i, stxo := range stxos {
+			if stxo.Amount != want[i].Amount ||
+				!bytes.Equal(stxo.PkScript, want[i].PkScript) ||
+				stxo.Height != want[i].Height ||
+				stxo.IsCoinBase != want[i].IsCoinBase {
+
+				t.Fatalf("%s: unexpected spent output #%d - got "+
+					"%+v, want %+v", name, i, stxo, want[i])
+			}
+		}
+	}
+
+	// Blocks which only have a coinbase don't spend any outputs.
+	check("block 1", blocks[0], nil)
+
+	for _, block := range []*colxutil.Block{block6, block7} {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+	check("block 6", block6, want6)
+
+	// The details of the split transaction are still in the utxo set.
+	check("block 7 at tip", block7, want7)
+
+	// Once the split transaction is fully spent, its details are only in
+	// the spend journal of the later block.
+	if _, err := chain.ProcessBlock(block8, blockchain.BFNone); err != nil {
+		t.Fatalf("ProcessBlock: unexpected error: %v", err)
+	}
+	check("block 7", block7, want7)
+	check("block 8", block8, want8)
+
+	// The spend journal may be used to recreate the view of the outputs
+	// the block spends.
+	stxos, err := chain.FetchSpendJournal(block7)
+	if err != nil {
+		t.Fatalf("FetchSpendJournal: unexpected error: %v", err)
+	}
+	view := blockchain.NewUtxoViewpoint()
+	if err := view.AddSpentTxOuts(block7, stxos); err != nil {
+		t.Fatalf("AddSpentTxOuts: unexpected error: %v", err)
+	}
+	entry := view.LookupEntry(&splitHash)
+	if entry == nil || entry.IsOutputSpent(0) || entry.BlockHeight() != 6 ||
+		entry.AmountByIndex(0) != split.TxOut[0].Value {
+
+		t.Fatalf("AddSpentTxOuts: unexpected entry for %v", splitHash)
+	}
+	if err := view.AddSpentTxOuts(block7, stxos[1:]); err == nil {
+		t.Fatal("AddSpentTxOuts: did not reject missing spent outputs")
+	}
+
+	// Blocks which are not in the main chain don't have a spend journal.
+	orphan := nextBlock(block8)
+	if _, err := chain.FetchSpendJournal(orphan); err == nil {
+		t.Fatal("FetchSpendJournal: did not reject block which is not " +
+			"in the main chain")
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestThresholdStateStringer tests the stringized output for the
+// ThresholdState type.
+func TestThresholdStateStringer(t *testing.T) {
+	tests := []struct {
+		in   blockchain.ThresholdState
+		want string
+	}{
+		{blockchain.ThresholdDefined, "ThresholdDefined"},
+		{blockchain.ThresholdStarted, "ThresholdStarted"},
+		{blockchain.ThresholdLockedIn, "ThresholdLockedIn"},
+		{blockchain.ThresholdActive, "ThresholdActive"},
+		{blockchain.ThresholdFailed, "ThresholdFailed"},
+		{0xff, "Unknown ThresholdState (255)"},
+	}
+
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+			continue
+		}
+	}
+}
+
+// TestThresholdState ensures the threshold state of deployments progresses as
+// expected on a simulated chain which signals for them across several
+// confirmation windows, including a deployment which fails by timing out and
+// one which does not receive enough votes to lock in.
+func TestThresholdState(t *testing.T) {
+	const (
+		deployLockIn = iota
+		deployTimeout
+		deployNoVotes
+	)
+
+	// Use a short confirmation window so the test runs quickly.  The
+	// generated blocks are ten minutes apart, so the median time of the
+	// block at height h is five blocks prior to its own timestamp.  The
+	// deployments start at the median time of the last block of the
+	// second window, so voting happens during the third window.
+	params := chaincfg.RegressionNetParams
+	params.MinerConfirmationWindow = 10
+	params.RuleChangeActivationThreshold = 8
+	genesisTime := params.GenesisBlock.Header.Timestamp
+	startTime := uint64(genesisTime.Add(time.Minute * 10 * 14).Unix())
+	params.Deployments = map[uint32]chaincfg.ConsensusDeployment{
+		deployLockIn: {
+			BitNumber:  0,
+			StartTime:  startTime,
+			ExpireTime: math.MaxUint64,
+		},
+		deployTimeout: {
+			BitNumber: 1,
+			StartTime: startTime,
+			// The median time of the last block of the third
+			// window.
+			ExpireTime: startTime + 10*60*10,
+		},
+		deployNoVotes: {
+			BitNumber:  2,
+			StartTime:  startTime,
+			ExpireTime: math.MaxUint64,
+		},
+	}
+
+	chain, teardownFunc, err := chainSetup("thresholdstate", &params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// signalBits returns the deployment bits signaled by the block at the
+	// passed height.  All deployments are signaled during the first window
+	// after the genesis window, which must not count since the deployments
+	// have not started yet.  During the third window, the first seven
+	// blocks signal all deployments and the eighth one only the deployment
+	// which is expected to lock in.
+	signalBits := func(height int32) int32 {
+		switch {
+		case height >= 10 && height < 20:
+			return 1<<0 | 1<<1 | 1<<2
+		case height >= 20 && height < 27:
+			return 1<<0 | 1<<1 | 1<<2
+		case height == 27:
+			return 1 << 0
+		}
+		return 0
+	}
+
+	// assertStates ensures the threshold states of the deployments for the
+	// block after the current tip are the passed ones.
+	assertStates := func(height int32, want map[uint32]blockchain.ThresholdState) {
+		for id, wantState := range want {
+			state, err := chain.ThresholdState(id)
+			if err != nil {
+				t.Fatalf("ThresholdState(%d) at height %d: "+
+					"unexpected error: %v", id, height, err)
+			}
+			if state != wantState {
+				t.Fatalf("ThresholdState(%d) at height %d: got %v, "+
+					"want %v", id, height, state, wantState)
+			}
+		}
+	}
+
+	parent := &params.GenesisBlock.Header
+	for height := int32(1); height < 40; height++ {
+		blocks, err := generateChainFrom(&params, parent, height-1, 1, 0)
+		if err != nil {
+			t.Fatalf("unable to generate block %d: %v", height, err)
+		}
+		msgBlock := blocks[0].MsgBlock()
+		msgBlock.Header.Version = 0x20000000 | signalBits(height)
+		solveBlock(&msgBlock.Header)
+		block := colxutil.NewBlock(msgBlock)
+		isOrphan, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock %d: unexpected error: %v",
+				height, err)
+		}
+		if isOrphan {
+			t.Fatalf("ProcessBlock %d: unexpected orphan", height)
+		}
+		parent = &msgBlock.Header
+
+		switch {
+		case height < 19:
+			assertStates(height, map[uint32]blockchain.ThresholdState{
+				deployLockIn:  blockchain.ThresholdDefined,
+				deployTimeout: blockchain.ThresholdDefined,
+				deployNoVotes: blockchain.ThresholdDefined,
+			})
+		case height < 29:
+			assertStates(height, map[uint32]blockchain.ThresholdState{
+				deployLockIn:  blockchain.ThresholdStarted,
+				deployTimeout: blockchain.ThresholdStarted,
+				deployNoVotes: blockchain.ThresholdStarted,
+			})
+		case height < 39:
+			assertStates(height, map[uint32]blockchain.ThresholdState{
+				deployLockIn:  blockchain.ThresholdLockedIn,
+				deployTimeout: blockchain.ThresholdFailed,
+				deployNoVotes: blockchain.ThresholdStarted,
+			})
+		default:
+			assertStates(height, map[uint32]blockchain.ThresholdState{
+				deployLockIn:  blockchain.ThresholdActive,
+				deployTimeout: blockchain.ThresholdFailed,
+				deployNoVotes: blockchain.ThresholdStarted,
+			})
+		}
+	}
+
+	active, err := chain.IsDeploymentActive(deployLockIn)
+	if err != nil {
+		t.Fatalf("IsDeploymentActive: unexpected error: %v", err)
+	}
+	if !active {
+		t.Fatal("IsDeploymentActive: deployment is not active")
+	}
+
+	// Requesting the state of a deployment which does not exist must fail.
+	_, err = chain.ThresholdState(100)
+	if _, ok := err.(blockchain.DeploymentError); !ok {
+		t.Fatalf("ThresholdState: unexpected error - got %v <%T>, "+
+			"want blockchain.DeploymentError", err, err)
+	}
+}
@@ -0,0 +1,260 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"fmt"
+
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// utxoTally houses the number of outputs and their total value counted by
+// one side of a utxo delta audit.
+type utxoTally struct {
+	count int
+	value int64
+}
+
+// add counts the passed amount towards the tally.
+func (t *utxoTally) add(amount int64) {
+	t.count++
+	t.value += amount
+}
+
+// stxoAmount returns the decompressed amount of the passed spent txout.
+func stxoAmount(stxo *spentTxOut) int64 {
+	if stxo.compressed {
+		return int64(decompressTxOutAmount(uint64(stxo.amount)))
+	}
+	return stxo.amount
+}
+
+// blockUtxoDeltas independently tallies the changes the passed block makes to
+// the utxo set from the block itself and the spent txouts it consumes.  It
+// returns the tally of the spendable outputs the block creates, the tally of
+// the outputs it consumes, the set of outputs which are both created and
+// consumed by the block, and the total value of all of its outputs, including
+// the provably unspendable ones.
+func blockUtxoDeltas(block *colxutil.Block, stxos []spentTxOut) (utxoTally, utxoTally, map[wire.OutPoint]struct{}, int64) {
+	var created, consumed utxoTally
+	var totalOut int64
+	blockTxns := make(map[wire.ShaHash]struct{})
+	inBlockSpends := make(map[wire.OutPoint]struct{})
+	stxoIdx := 0
+	for txIdx, tx := range block.Transactions() {
+		if txIdx != 0 {
+			for _, txIn := range tx.MsgTx().TxIn {
+				prevOut := txIn.PreviousOutPoint
+				if _, ok := blockTxns[prevOut.Hash]; ok {
+					inBlockSpends[prevOut] = struct{}{}
+				}
+				consumed.add(stxoAmount(&stxos[stxoIdx]))
+				stxoIdx++
+			}
+		}
+
+		for _, txOut := range tx.MsgTx().TxOut {
+			totalOut += txOut.Value
+			if !txscript.IsUnspendable(txOut.PkScript) {
+				created.add(txOut.Value)
+			}
+		}
+		blockTxns[*tx.Sha()] = struct{}{}
+	}
+
+	return created, consumed, inBlockSpends, totalOut
+}
+
+// auditBlockValue ensures the value created by the block at the passed height
+// does not exceed the value its transactions destroy plus the subsidy, which
+// is the same as the coinbase claiming no more than the subsidy plus the fees.
+func (b *BlockChain) auditBlockValue(block *colxutil.Block, height int32, consumed utxoTally, totalOut int64) error {
+	subsidy := CalcBlockSubsidy(height, b.chainParams)
+	if totalOut > consumed.value+subsidy {
+		str := fmt.Sprintf("utxo audit of block %v failed: outputs "+
+			"create %v which exceeds the %v spent plus the "+
+			"subsidy of %v", block.Sha(), totalOut, consumed.value,
+			subsidy)
+		return AssertError(str)
+	}
+	return nil
+}
+
+// auditConnectDeltas cross-checks the changes the passed block makes to the
+// utxo set, as tallied from the block and its spent txouts, against the
+// changes actually applied to the passed view when the block was connected.
+// An AssertError is returned when they do not balance.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) auditConnectDeltas(node *blockNode, block *colxutil.Block, view *UtxoViewpoint, stxos []spentTxOut) error {
+	if b.auditHook != nil {
+		b.auditHook(block.Sha(), view)
+	}
+
+	if len(stxos) != countSpentOutputs(block) {
+		return AssertError(fmt.Sprintf("utxo audit of block %v failed: "+
+			"%d spent txouts for %d inputs", block.Sha(), len(stxos),
+			countSpentOutputs(block)))
+	}
+	created, consumed, inBlockSpends, totalOut := blockUtxoDeltas(block,
+		stxos)
+
+	// Tally the outputs the view holds for the transactions in the block.
+	// Every spendable output must be present with its amount, and it must
+	// only be spent when a later transaction in the block spends it.
+	var viewCreated, viewConsumed utxoTally
+	for _, tx := range block.Transactions() {
+		entry := view.LookupEntry(tx.Sha())
+		if entry == nil {
+			return AssertError(fmt.Sprintf("utxo audit of block %v "+
+				"failed: view missing transaction %v",
+				block.Sha(), tx.Sha()))
+		}
+		var numSpendable int
+		for txOutIdx, txOut := range tx.MsgTx().TxOut {
+			if txscript.IsUnspendable(txOut.PkScript) {
+				continue
+			}
+			numSpendable++
+			outIdx := uint32(txOutIdx)
+			prevOut := wire.OutPoint{Hash: *tx.Sha(), Index: outIdx}
+			output := entry.output(outIdx)
+			if output == nil {
+				return AssertError(fmt.Sprintf("utxo audit of "+
+					"block %v failed: view missing created "+
+					"output %v", block.Sha(), prevOut))
+			}
+			_, spentInBlock := inBlockSpends[prevOut]
+			if output.spent != spentInBlock {
+				return AssertError(fmt.Sprintf("utxo audit of "+
+					"block %v failed: created output %v has "+
+					"spent state %v", block.Sha(), prevOut,
+					output.spent))
+			}
+			viewCreated.add(entry.AmountByIndex(outIdx))
+		}
+		if entry.numOutputs() != numSpendable {
+			return AssertError(fmt.Sprintf("utxo audit of block %v "+
+				"failed: view holds unexpected outputs for "+
+				"transaction %v", block.Sha(), tx.Sha()))
+		}
+	}
+
+	// Tally the outputs the view marks spent for the inputs of the block
+	// and ensure each one matches the spent txout recorded for it.
+	stxoIdx := 0
+	for _, tx := range block.Transactions()[1:] {
+		for _, txIn := range tx.MsgTx().TxIn {
+			prevOut := txIn.PreviousOutPoint
+			stxo := &stxos[stxoIdx]
+			stxoIdx++
+
+			entry := view.LookupEntry(&prevOut.Hash)
+			if entry == nil || !entry.IsOutputSpent(prevOut.Index) {
+				return AssertError(fmt.Sprintf("utxo audit of "+
+					"block %v failed: consumed output %v is "+
+					"not spent in the view", block.Sha(),
+					prevOut))
+			}
+			amount := entry.AmountByIndex(prevOut.Index)
+			if amount != stxoAmount(stxo) {
+				return AssertError(fmt.Sprintf("utxo audit of "+
+					"block %v failed: consumed output %v "+
+					"amount %v does not match spent txout "+
+					"amount %v", block.Sha(), prevOut, amount,
+					stxoAmount(stxo)))
+			}
+			viewConsumed.add(amount)
+		}
+	}
+
+	if viewCreated != created || viewConsumed != consumed {
+		str := fmt.Sprintf("utxo audit of block %v failed: view "+
+			"created %d outputs worth %v and consumed %d worth %v "+
+			"while the block creates %d worth %v and consumes %d "+
+			"worth %v", block.Sha(), viewCreated.count,
+			viewCreated.value, viewConsumed.count, viewConsumed.value,
+			created.count, created.value, consumed.count,
+			consumed.value)
+		return AssertError(str)
+	}
+
+	return b.auditBlockValue(block, node.height, consumed, totalOut)
+}
+
+// auditDisconnectDeltas cross-checks the changes the passed block makes to the
+// utxo set, as tallied from the block and its spent txouts, against the
+// changes actually undone in the passed view when the block was disconnected.
+// An AssertError is returned when they do not balance.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) auditDisconnectDeltas(node *blockNode, block *colxutil.Block, view *UtxoViewpoint, stxos []spentTxOut) error {
+	if b.auditHook != nil {
+		b.auditHook(block.Sha(), view)
+	}
+
+	if len(stxos) != countSpentOutputs(block) {
+		return AssertError(fmt.Sprintf("utxo audit of block %v failed: "+
+			"%d spent txouts for %d inputs", block.Sha(), len(stxos),
+			countSpentOutputs(block)))
+	}
+	_, consumed, inBlockSpends, totalOut := blockUtxoDeltas(block, stxos)
+
+	// None of the outputs created by the block may remain in the view.
+	for _, tx := range block.Transactions() {
+		entry := view.LookupEntry(tx.Sha())
+		if entry != nil && entry.numOutputs() != 0 {
+			return AssertError(fmt.Sprintf("utxo audit of block %v "+
+				"failed: view still holds outputs of transaction "+
+				"%v", block.Sha(), tx.Sha()))
+		}
+	}
+
+	// Every output consumed from before the block must be restored
+	// unspent with the amount recorded by its spent txout.
+	var restored, wantRestored utxoTally
+	stxoIdx := 0
+	for _, tx := range block.Transactions()[1:] {
+		for _, txIn := range tx.MsgTx().TxIn {
+			prevOut := txIn.PreviousOutPoint
+			stxo := &stxos[stxoIdx]
+			stxoIdx++
+			if _, ok := inBlockSpends[prevOut]; ok {
+				continue
+			}
+			wantRestored.add(stxoAmount(stxo))
+
+			entry := view.LookupEntry(&prevOut.Hash)
+			if entry == nil || entry.IsOutputSpent(prevOut.Index) {
+				return AssertError(fmt.Sprintf("utxo audit of "+
+					"block %v failed: consumed output %v is "+
+					"not restored in the view", block.Sha(),
+					prevOut))
+			}
+			amount := entry.AmountByIndex(prevOut.Index)
+			if amount != stxoAmount(stxo) {
+				return AssertError(fmt.Sprintf("utxo audit of "+
+					"block %v failed: restored output %v "+
+					"amount %v does not match spent txout "+
+					"amount %v", block.Sha(), prevOut, amount,
+					stxoAmount(stxo)))
+			}
+			restored.add(amount)
+		}
+	}
+
+	if restored != wantRestored {
+		str := fmt.Sprintf("utxo audit of block %v failed: view "+
+			"restored %d outputs worth %v while the spend journal "+
+			"records %d worth %v", block.Sha(), restored.count,
+			restored.value, wantRestored.count, wantRestored.value)
+		return AssertError(str)
+	}
+
+	return b.auditBlockValue(block, node.height, consumed, totalOut)
+}
@@ -0,0 +1,192 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// auditTestChain houses a chain of blocks used to test the utxo delta audit
+// along with the transactions of the blocks which spend outputs.
+type auditTestChain struct {
+	blocks     []*colxutil.Block // Main chain blocks starting at height 1.
+	fork       []*colxutil.Block // Longer fork of the last coinbase block.
+	split      *wire.MsgTx       // Spends a coinbase at height 6.
+	spendAgain *wire.MsgTx       // Spends an output of the same block.
+}
+
+// generateAuditTestChain returns a chain of blocks which create and spend
+// outputs, including outputs spent in the same block and provably unspendable
+// outputs, along with a fork that reorganizes the blocks which spend outputs.
+func generateAuditTestChain(t *testing.T, params *chaincfg.Params) *auditTestChain {
+	blocks, err := generateChain(params, 5)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+	for i, block := range blocks {
+		block.SetHeight(int32(i) + 1)
+	}
+
+	// nextBlock returns a block which extends the passed block with the
+	// passed transactions.
+	nextBlock := func(parent *colxutil.Block, txns ...*wire.MsgTx) *colxutil.Block {
+		generated, err := generateChainFrom(params,
+			&parent.MsgBlock().Header, parent.Height(), 1, 0)
+		if err != nil {
+			t.Fatalf("unable to generate block: %v", err)
+		}
+		msgBlock := generated[0].MsgBlock()
+		for _, tx := range txns {
+			msgBlock.AddTransaction(tx)
+		}
+		merkles := blockchain.BuildMerkleTreeStore(
+			colxutil.NewBlock(msgBlock).Transactions())
+		msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+		solveBlock(&msgBlock.Header)
+		block := colxutil.NewBlock(msgBlock)
+		block.SetHeight(parent.Height() + 1)
+		return block
+	}
+	coinbase := func(block *colxutil.Block) *wire.MsgTx {
+		return block.Transactions()[0].MsgTx()
+	}
+
+	// The block at height 6 splits the first coinbase into two outputs
+	// and a provably unspendable one which burns part of the value.
+	split := newSequenceLockTx(1, []*wire.MsgTx{coinbase(blocks[0])},
+		[]uint32{wire.MaxTxInSequenceNum})
+	half := split.TxOut[0].Value / 2
+	split.TxOut[0].Value -= half
+	split.AddTxOut(wire.NewTxOut(half-1000, []byte{txscript.OP_TRUE}))
+	split.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_RETURN}))
+	block6 := nextBlock(blocks[len(blocks)-1], split)
+
+	// The block at height 7 spends an output of the split transaction
+	// along with the second coinbase and spends the new output again in
+	// the same block.
+	spend := newSequenceLockTx(1,
+		[]*wire.MsgTx{split, coinbase(blocks[1])},
+		[]uint32{wire.MaxTxInSequenceNum, wire.MaxTxInSequenceNum})
+	spendAgain := newSequenceLockTx(1, []*wire.MsgTx{spend},
+		[]uint32{wire.MaxTxInSequenceNum})
+	block7 := nextBlock(block6, spend, spendAgain)
+
+	fork, err := generateChainFrom(params, &blocks[4].MsgBlock().Header,
+		5, 3, 1)
+	if err != nil {
+		t.Fatalf("unable to generate fork: %v", err)
+	}
+
+	return &auditTestChain{
+		blocks:     append(blocks, block6, block7),
+		fork:       fork,
+		split:      split,
+		spendAgain: spendAgain,
+	}
+}
+
+// TestAuditUtxoDeltas ensures connecting and disconnecting blocks which create
+// and spend outputs, including reorganizing them away, works with the utxo
+// delta audit enabled and that the audit fails with an AssertError before
+// anything is committed when the utxo view is corrupted.
+func TestAuditUtxoDeltas(t *testing.T) {
+	blockchain.TstSetCoinbaseMaturity(1)
+	defer blockchain.TstSetCoinbaseMaturity(blockchain.CoinbaseMaturity)
+
+	params := &chaincfg.RegressionNetParams
+	tc := generateAuditTestChain(t, params)
+
+	setup := func(name string) (*blockchain.BlockChain, func()) {
+		chain, teardownFunc, err := chainSetupWithConfig(name,
+			&blockchain.Config{
+				ChainParams:     params,
+				TimeSource:      blockchain.NewMedianTime(),
+				AuditUtxoDeltas: true,
+			})
+		if err != nil {
+			t.Fatalf("Failed to setup chain instance: %v", err)
+		}
+		return chain, teardownFunc
+	}
+	processBlocks := func(chain *blockchain.BlockChain, blocks []*colxutil.Block) {
+		for _, block := range blocks {
+			_, err := chain.ProcessBlock(block, blockchain.BFNone)
+			if err != nil {
+				t.Fatalf("ProcessBlock: unexpected error: %v", err)
+			}
+		}
+	}
+	assertTip := func(chain *blockchain.BlockChain, block *colxutil.Block) {
+		best := chain.BestSnapshot()
+		if !best.Hash.IsEqual(block.Sha()) {
+			t.Fatalf("unexpected tip - got %v (height %d), want %v",
+				best.Hash, best.Height, block.Sha())
+		}
+	}
+	assertAuditFailure := func(err error) {
+		if _, ok := err.(blockchain.AssertError); !ok {
+			t.Fatalf("ProcessBlock: unexpected error - got %v (%T), "+
+				"want AssertError", err, err)
+		}
+	}
+
+	// Connect the blocks and reorganize them away with auditing enabled.
+	chain, teardownFunc := setup("auditutxodeltas")
+	processBlocks(chain, tc.blocks)
+	assertTip(chain, tc.blocks[len(tc.blocks)-1])
+	processBlocks(chain, tc.fork)
+	assertTip(chain, tc.fork[len(tc.fork)-1])
+	teardownFunc()
+
+	// Spending a created output in the view which nothing in the block
+	// spends must be caught when the block is connected.
+	chain, teardownFunc = setup("auditutxodeltasconnect")
+	processBlocks(chain, tc.blocks[:5])
+	block6 := tc.blocks[5]
+	blockchain.TstSetAuditHook(chain, func(hash *wire.ShaHash, view *blockchain.UtxoViewpoint) {
+		if !hash.IsEqual(block6.Sha()) {
+			return
+		}
+		splitHash := tc.split.TxSha()
+		view.LookupEntry(&splitHash).SpendOutput(1)
+	})
+	_, err := chain.ProcessBlock(block6, blockchain.BFNone)
+	assertAuditFailure(err)
+	assertTip(chain, tc.blocks[4])
+	teardownFunc()
+
+	// Leaving the outputs created by a block in the view must be caught
+	// when the block is disconnected.
+	chain, teardownFunc = setup("auditutxodeltasdisconnect")
+	processBlocks(chain, tc.blocks)
+	block7 := tc.blocks[6]
+	blockchain.TstSetAuditHook(chain, func(hash *wire.ShaHash, view *blockchain.UtxoViewpoint) {
+		if !hash.IsEqual(block7.Sha()) {
+			return
+		}
+		view.AddTxOuts(colxutil.NewTx(tc.spendAgain), block7.Height())
+	})
+	var sawFailure bool
+	for _, block := range tc.fork {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			assertAuditFailure(err)
+			sawFailure = true
+			break
+		}
+	}
+	if !sawFailure {
+		t.Fatalf("ProcessBlock: reorganize did not fail the audit")
+	}
+	assertTip(chain, block7)
+	teardownFunc()
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// UtxoScanOutput describes an unspent transaction output found while scanning
+// the utxo set.
+type UtxoScanOutput struct {
+	// Hash and Index identify the output.
+	Hash  wire.ShaHash
+	Index uint32
+
+	// PkScript and Amount are the public key script and value of the
+	// output.
+	PkScript []byte
+	Amount   int64
+
+	// Height is the height of the block which contains the transaction the
+	// output is part of.
+	Height int32
+
+	// IsCoinBase is whether or not the output is part of a coinbase.
+	IsCoinBase bool
+}
+
+// UtxoScanChunk describes the result of scanning a chunk of the utxo set.
+type UtxoScanChunk struct {
+	// Outputs houses the unspent outputs in the chunk which matched.
+	Outputs []UtxoScanOutput
+
+	// Searched is the number of utxo set entries which were examined.  Each
+	// entry houses the unspent outputs of a single transaction.
+	Searched int
+
+	// NextKey is the resume key to pass in order to scan the next chunk.
+	// It is nil once the end of the utxo set has been reached.
+	NextKey []byte
+}
+
+// ScanUtxoSet scans up to the passed maximum number of entries of the utxo set
+// which come after the passed resume key, which may be nil to start from the
+// beginning, and returns the unspent outputs with a public key script for which
+// the match function returns true.
+//
+// Every chunk is scanned from the point of view of the end of the main chain at
+// the time it is scanned, so a scan made up of multiple chunks does not reflect
+// a single consistent snapshot when blocks are connected while it is ongoing.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) ScanUtxoSet(resumeKey []byte, maxEntries int, match func(pkScript []byte) bool) (*UtxoScanChunk, error) {
+	b.chainLock.RLock()
+	defer b.chainLock.RUnlock()
+
+	var chunk UtxoScanChunk
+	err := b.db.View(func(dbTx database.Tx) error {
+		cursor := dbTx.Metadata().Bucket(utxoSetBucketName).Cursor()
+		var ok bool
+		if resumeKey == nil {
+			ok = cursor.First()
+		} else {
+			ok = cursor.Seek(resumeKey)
+			if ok && bytes.Equal(cursor.Key(), resumeKey) {
+				ok = cursor.Next()
+			}
+		}
+		for ; ok; ok = cursor.Next() {
+			key := cursor.Key()
+			entry, err := deserializeUtxoEntry(cursor.Value())
+			if err != nil {
+				return err
+			}
+			chunk.Searched++
+
+			for _, outputIndex := range entry.outputIndexes() {
+				if entry.IsOutputSpent(outputIndex) {
+					continue
+				}
+				pkScript := entry.PkScriptByIndex(outputIndex)
+				if !match(pkScript) {
+					continue
+				}
+				output := UtxoScanOutput{
+					Index:      outputIndex,
+					PkScript:   pkScript,
+					Amount:     entry.AmountByIndex(outputIndex),
+					Height:     entry.BlockHeight(),
+					IsCoinBase: entry.IsCoinBase(),
+				}
+				copy(output.Hash[:], key)
+				chunk.Outputs = append(chunk.Outputs, output)
+			}
+
+			// The key is only valid for the life of the transaction,
+			// so copy it when it becomes the resume key.
+			if chunk.Searched >= maxEntries {
+				chunk.NextKey = make([]byte, len(key))
+				copy(chunk.NextKey, key)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &chunk, nil
+}
+
+// UtxoScanProgress returns the approximate fraction, from 0 to 1, of the utxo
+// set which has been scanned when the passed resume key is reached.  It relies
+// on the utxo set being keyed by transaction hashes, which are uniformly
+// distributed, so a nil key, which signifies the end of the scan, is reported
+// as complete.
+func UtxoScanProgress(resumeKey []byte) float64 {
+	if resumeKey == nil {
+		return 1
+	}
+	var prefix [4]byte
+	copy(prefix[:], resumeKey)
+	return float64(binary.BigEndian.Uint32(prefix[:])) / (1 << 32)
+}
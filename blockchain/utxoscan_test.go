@@ -0,0 +1,117 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// TestScanUtxoSet ensures scanning the utxo set in chunks finds every matching
+// unspent output exactly once and that the progress derived from the resume
+// keys only increases.
+func TestScanUtxoSet(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, 10)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("scanutxoset", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	for _, block := range blocks {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+
+	// The coinbase of every generated block pays to a single output.
+	want := make(map[wire.ShaHash]int32)
+	for i, block := range blocks {
+		want[*block.Transactions()[0].Sha()] = int32(i + 1)
+	}
+	opTrue := []byte{txscript.OP_TRUE}
+	matchAll := func(pkScript []byte) bool {
+		return bytes.Equal(pkScript, opTrue)
+	}
+
+	var resumeKey []byte
+	var searched int
+	var progress float64
+	got := make(map[wire.ShaHash]int32)
+	for i := 0; ; i++ {
+		chunk, err := chain.ScanUtxoSet(resumeKey, 3, matchAll)
+		if err != nil {
+			t.Fatalf("ScanUtxoSet: unexpected error: %v", err)
+		}
+		if chunk.Searched > 3 {
+			t.Fatalf("chunk %d: searched %d entries, want at most 3",
+				i, chunk.Searched)
+		}
+		searched += chunk.Searched
+		for _, output := range chunk.Outputs {
+			if _, ok := got[output.Hash]; ok {
+				t.Fatalf("output %v:%d found more than once",
+					output.Hash, output.Index)
+			}
+			if output.Index != 0 || !output.IsCoinBase ||
+				output.Amount != blockchain.CalcBlockSubsidy(
+					output.Height, params) {
+
+				t.Fatalf("unexpected output %+v", output)
+			}
+			got[output.Hash] = output.Height
+		}
+
+		newProgress := blockchain.UtxoScanProgress(chunk.NextKey)
+		if newProgress < progress {
+			t.Fatalf("chunk %d: progress went backwards - got %v, "+
+				"previous %v", i, newProgress, progress)
+		}
+		progress = newProgress
+		if chunk.NextKey == nil {
+			break
+		}
+		resumeKey = chunk.NextKey
+	}
+	if progress != 1 {
+		t.Fatalf("unexpected final progress - got %v, want 1", progress)
+	}
+	if searched != len(want) {
+		t.Fatalf("unexpected number of searched entries - got %d, "+
+			"want %d", searched, len(want))
+	}
+	if len(got) != len(want) {
+		t.Fatalf("unexpected number of outputs - got %d, want %d",
+			len(got), len(want))
+	}
+	for hash, height := range want {
+		if got[hash] != height {
+			t.Fatalf("output %v: unexpected height - got %d, want %d",
+				hash, got[hash], height)
+		}
+	}
+
+	// Ensure nothing is returned when no scripts match.
+	chunk, err := chain.ScanUtxoSet(nil, len(want)+1,
+		func([]byte) bool { return false })
+	if err != nil {
+		t.Fatalf("ScanUtxoSet: unexpected error: %v", err)
+	}
+	if len(chunk.Outputs) != 0 || chunk.NextKey != nil {
+		t.Fatalf("unexpected chunk when nothing matches: %+v", chunk)
+	}
+}
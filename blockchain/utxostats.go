@@ -0,0 +1,190 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/btcsuite/fastsha256"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// utxoStatsCheckInterval is the number of utxo set entries which are processed
+// between checks for cancellation and progress reports while calculating the
+// utxo set statistics.
+const utxoStatsCheckInterval = 1000
+
+// UtxoStats houses statistics about the utxo set as of a specific block along
+// with a hash which commits to its entire contents.
+type UtxoStats struct {
+	// Height and Hash identify the block the statistics are as of.
+	Height int32
+	Hash   wire.ShaHash
+
+	// Transactions is the number of transactions with unspent outputs and
+	// Outputs is the total number of unspent outputs.
+	Transactions int64
+	Outputs      int64
+
+	// TotalAmount is the sum of the amounts of all unspent outputs.
+	TotalAmount int64
+
+	// SerializedSize is the total size of the keys and values of the utxo
+	// set entries as stored in the database.
+	SerializedSize int64
+
+	// SetHash is the SHA-256 hash of the unspent outputs of all entries in
+	// key order.  It only depends on the unspent outputs, so two nodes
+	// with the same utxo set calculate the same hash regardless of how the
+	// entries are stored.  See writeUtxoStatsEntry for the serialization
+	// of each entry.
+	SetHash wire.ShaHash
+}
+
+// UtxoStatsProgressFunc is the function called periodically while the utxo set
+// statistics are calculated with the statistics of the entries processed so
+// far along with the approximate fraction, from 0 to 1, of the utxo set they
+// make up.
+type UtxoStatsProgressFunc func(partial *UtxoStats, progress float64)
+
+// writeUtxoStatsEntry writes the serialization of the unspent outputs of the
+// passed utxo entry which the utxo set hash commits to.  The serialization is
+// the transaction hash, the transaction version, and the block height shifted
+// left by one with the coinbase flag in the lowest bit, followed by the index
+// plus one, amount, and public key script of each unspent output in ascending
+// index order, and a final zero.  The integers other than the amounts, which
+// are 8-byte little endian, are variable length integers.
+func writeUtxoStatsEntry(w *bytes.Buffer, hash []byte, entry *UtxoEntry) (int64, int64, error) {
+	w.Write(hash)
+	err := wire.WriteVarInt(w, 0, uint64(uint32(entry.Version())))
+	if err != nil {
+		return 0, 0, err
+	}
+	heightCode := uint64(entry.BlockHeight()) << 1
+	if entry.IsCoinBase() {
+		heightCode |= 1
+	}
+	if err := wire.WriteVarInt(w, 0, heightCode); err != nil {
+		return 0, 0, err
+	}
+
+	var numOutputs, totalAmount int64
+	var amount [8]byte
+	for _, outputIndex := range entry.outputIndexes() {
+		if entry.IsOutputSpent(outputIndex) {
+			continue
+		}
+		err := wire.WriteVarInt(w, 0, uint64(outputIndex)+1)
+		if err != nil {
+			return 0, 0, err
+		}
+		value := entry.AmountByIndex(outputIndex)
+		binary.LittleEndian.PutUint64(amount[:], uint64(value))
+		w.Write(amount[:])
+		err = wire.WriteVarBytes(w, 0, entry.PkScriptByIndex(outputIndex))
+		if err != nil {
+			return 0, 0, err
+		}
+		numOutputs++
+		totalAmount += value
+	}
+	err = wire.WriteVarInt(w, 0, 0)
+	return numOutputs, totalAmount, err
+}
+
+// UtxoSetStats calculates statistics about the entire utxo set as of the end of
+// the current main chain along with a hash which commits to its contents.  The
+// statistics may be compared against those calculated by other nodes with the
+// same main chain, or against the expected supply, in order to detect utxo set
+// corruption.
+//
+// The calculation scans the entire utxo set, so it can take a long time.  It
+// stops with the error of the passed context once the context is done.  The
+// utxo set is read from a consistent snapshot of the database, so blocks may
+// be connected while the calculation is ongoing.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) UtxoSetStats(ctx context.Context) (*UtxoStats, error) {
+	return b.UtxoSetStatsWithProgress(ctx, nil)
+}
+
+// UtxoSetStatsWithProgress is the same as UtxoSetStats except the passed
+// progress function, when it is not nil, is called periodically while the utxo
+// set is scanned.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) UtxoSetStatsWithProgress(ctx context.Context, progress UtxoStatsProgressFunc) (*UtxoStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var stats UtxoStats
+	err := b.db.View(func(dbTx database.Tx) error {
+		// The chain state is read from the same database transaction as
+		// the utxo set, so they are consistent with each other.
+		serializedState := dbTx.Metadata().Get(chainStateKeyName)
+		state, err := deserializeBestChainState(serializedState)
+		if err != nil {
+			return err
+		}
+		stats.Height = int32(state.height)
+		stats.Hash = state.hash
+
+		hasher := fastsha256.New()
+		var buf bytes.Buffer
+		cursor := dbTx.Metadata().Bucket(utxoSetBucketName).Cursor()
+		for ok := cursor.First(); ok; ok = cursor.Next() {
+			key, value := cursor.Key(), cursor.Value()
+			entry, err := deserializeUtxoEntry(value)
+			if err != nil {
+				return database.Error{
+					ErrorCode: database.ErrCorruption,
+					Description: fmt.Sprintf("corrupt utxo "+
+						"entry for %x: %v", key, err),
+				}
+			}
+
+			buf.Reset()
+			numOutputs, amount, err := writeUtxoStatsEntry(&buf,
+				key, entry)
+			if err != nil {
+				return err
+			}
+			hasher.Write(buf.Bytes())
+			stats.Transactions++
+			stats.Outputs += numOutputs
+			stats.TotalAmount += amount
+			stats.SerializedSize += int64(len(key) + len(value))
+
+			if stats.Transactions%utxoStatsCheckInterval != 0 {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+			}
+			if progress != nil {
+				partial := stats
+				progress(&partial, UtxoScanProgress(key))
+			}
+		}
+		copy(stats.SetHash[:], hasher.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if progress != nil {
+		progress(&stats, 1)
+	}
+	return &stats, nil
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestUtxoSetStats ensures the utxo set statistics of a small deterministic
+// chain account for all of the generated subsidies minus the fees which were
+// burned by not being claimed by the coinbase, and that the utxo set hash is
+// deterministic and commits to the contents of the utxo set.
+func TestUtxoSetStats(t *testing.T) {
+	blockchain.TstSetCoinbaseMaturity(1)
+	defer blockchain.TstSetCoinbaseMaturity(blockchain.CoinbaseMaturity)
+
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, 5)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("utxosetstats", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	for _, block := range blocks {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+
+	// The genesis coinbase is not part of the utxo set, so every other
+	// coinbase makes up the utxo set.
+	var subsidies int64
+	for height := int32(1); height <= int32(len(blocks)); height++ {
+		subsidies += blockchain.CalcBlockSubsidy(height, params)
+	}
+	var progressCalls int
+	stats, err := chain.UtxoSetStatsWithProgress(context.Background(),
+		func(partial *blockchain.UtxoStats, progress float64) {
+			progressCalls++
+			if progress != 1 {
+				t.Errorf("unexpected progress %v", progress)
+			}
+		})
+	if err != nil {
+		t.Fatalf("UtxoSetStats: unexpected error: %v", err)
+	}
+	if progressCalls != 1 {
+		t.Fatalf("unexpected number of progress calls - got %d, want 1",
+			progressCalls)
+	}
+	tip := blocks[len(blocks)-1]
+	if stats.Height != int32(len(blocks)) || stats.Hash != *tip.Sha() {
+		t.Fatalf("unexpected block - got %v (%d), want %v (%d)",
+			stats.Hash, stats.Height, tip.Sha(), len(blocks))
+	}
+	if stats.Transactions != 5 || stats.Outputs != 5 {
+		t.Fatalf("unexpected number of transactions and outputs - got "+
+			"%d and %d, want 5 and 5", stats.Transactions,
+			stats.Outputs)
+	}
+	if stats.TotalAmount != subsidies {
+		t.Fatalf("unexpected total amount - got %d, want %d",
+			stats.TotalAmount, subsidies)
+	}
+	if stats.SerializedSize <= 5*wire.HashSize {
+		t.Fatalf("unexpected serialized size %d", stats.SerializedSize)
+	}
+
+	// Calculating the statistics again without any changes must result in
+	// the same hash.
+	again, err := chain.UtxoSetStats(context.Background())
+	if err != nil {
+		t.Fatalf("UtxoSetStats: unexpected error: %v", err)
+	}
+	if *again != *stats {
+		t.Fatalf("statistics are not deterministic - got %+v, want %+v",
+			again, stats)
+	}
+
+	// Connect a block with a transaction which spends the first coinbase
+	// and pays a fee the coinbase of the block does not claim, which burns
+	// the fee.
+	const fee = 10000
+	spendTx := newSequenceLockTx(1,
+		[]*wire.MsgTx{blocks[0].Transactions()[0].MsgTx()},
+		[]uint32{wire.MaxTxInSequenceNum})
+	spendTx.TxOut[0].Value -= fee
+	generated, err := generateChainFrom(params, &tip.MsgBlock().Header,
+		int32(len(blocks)), 1, 0)
+	if err != nil {
+		t.Fatalf("unable to generate block: %v", err)
+	}
+	msgBlock := generated[0].MsgBlock()
+	msgBlock.AddTransaction(spendTx)
+	merkles := blockchain.BuildMerkleTreeStore(
+		colxutil.NewBlock(msgBlock).Transactions())
+	msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+	solveBlock(&msgBlock.Header)
+	block := colxutil.NewBlock(msgBlock)
+	if _, err := chain.ProcessBlock(block, blockchain.BFNone); err != nil {
+		t.Fatalf("ProcessBlock: unexpected error: %v", err)
+	}
+
+	subsidies += blockchain.CalcBlockSubsidy(int32(len(blocks))+1, params)
+	stats, err = chain.UtxoSetStats(context.Background())
+	if err != nil {
+		t.Fatalf("UtxoSetStats: unexpected error: %v", err)
+	}
+	if stats.Height != int32(len(blocks))+1 || stats.Hash != *block.Sha() {
+		t.Fatalf("unexpected block - got %v (%d), want %v (%d)",
+			stats.Hash, stats.Height, block.Sha(), len(blocks)+1)
+	}
+	if stats.Transactions != 6 || stats.Outputs != 6 {
+		t.Fatalf("unexpected number of transactions and outputs - got "+
+			"%d and %d, want 6 and 6", stats.Transactions,
+			stats.Outputs)
+	}
+	if stats.TotalAmount != subsidies-fee {
+		t.Fatalf("unexpected total amount - got %d, want %d",
+			stats.TotalAmount, subsidies-fee)
+	}
+	if stats.SetHash == again.SetHash {
+		t.Fatal("utxo set hash did not change with the utxo set")
+	}
+
+	// A canceled context stops the calculation.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := chain.UtxoSetStats(ctx); err != context.Canceled {
+		t.Fatalf("UtxoSetStats: unexpected error - got %v, want %v",
+			err, context.Canceled)
+	}
+}
@@ -7,6 +7,7 @@ package blockchain
 
 import (
 	"fmt"
+	"sort"
 
 	"github.com/tinhnguyenhn/colxd/database"
 	"github.com/tinhnguyenhn/colxd/txscript"
@@ -14,6 +15,38 @@ import (
 	"github.com/tinhnguyenhn/colxutil"
 )
 
+const (
+	// maxAlwaysDenseOutputs is the highest number of output slots an entry
+	// stores densely regardless of how many of them are actually part of
+	// the entry.  The slots for entries this small take less memory than a
+	// map.
+	maxAlwaysDenseOutputs = 8
+
+	// utxoEntryFieldsSize is the size the fields of each utxo entry takes
+	// excluding its outputs.  It assumes 64-bit pointers so technically it
+	// is smaller on 32-bit platforms, but overestimating the size in that
+	// case is acceptable since it avoids the need to import unsafe.  It
+	// consists of 16 bytes for the modified, version, coinbase, and height
+	// fields, 24 bytes for the dense outputs slice, 8 bytes for the number
+	// of dense outputs, and 8 bytes for the sparse outputs map (16 + 24 +
+	// 8 + 8).
+	utxoEntryFieldsSize = 56
+
+	// utxoOutputFieldsSize is the size the fields of each utxo output takes
+	// excluding the contents of its public key script.  It consists of 8
+	// bytes for the flags, 8 bytes for the amount, and 24 bytes for the
+	// script (8 + 8 + 24).
+	utxoOutputFieldsSize = 40
+
+	// sparseMapSize and sparseMapEntrySize are estimates of the memory a
+	// map of sparse outputs takes for the map header and for each output in
+	// it excluding the output itself.  The entry size covers the 4 byte
+	// key, the 8 byte pointer, and the bucket overhead and unused slots
+	// which result from the map load factor.
+	sparseMapSize      = 48
+	sparseMapEntrySize = 24
+)
+
 // utxoOutput houses details about an individual unspent transaction output such
 // as whether or not it is spent, its public key script, and how much it pays.
 //
@@ -30,6 +63,7 @@ import (
 type utxoOutput struct {
 	spent      bool   // Output is spent.
 	compressed bool   // The amount and public key script are compressed.
+	pruned     bool   // Dense slot without an output in the entry.
 	amount     int64  // The amount of the output.
 	pkScript   []byte // The public key script for the output.
 }
@@ -50,14 +84,188 @@ func (o *utxoOutput) maybeDecompress(version int32) {
 // UtxoEntry contains contextual information about an unspent transaction such
 // as whether or not it is a coinbase transaction, which block it was found in,
 // and the spent status of its outputs.
+//
+// The outputs are stored in a slice indexed directly by the output index when
+// most of the outputs of the transaction are part of the entry, which avoids
+// an allocation per output for transactions with many outputs.  Entries which
+// only hold a few outputs spread over a wide range of indexes instead store
+// them in a sparse map.
 type UtxoEntry struct {
 	modified      bool                   // Entry changed since load.
 	version       int32                  // The version of this tx.
 	isCoinBase    bool                   // Whether entry is a coinbase tx.
 	blockHeight   int32                  // Height of block containing tx.
+	outputs       []utxoOutput           // Dense outputs by output index.
+	numDense      int                    // Number of unpruned dense outputs.
 	sparseOutputs map[uint32]*utxoOutput // Sparse map of unspent outputs.
 }
 
+// uint32Sorter implements sort.Interface to allow a slice of output indexes to
+// be sorted.
+type uint32Sorter []uint32
+
+// Len returns the number of output indexes in the slice.  It is part of the
+// sort.Interface implementation.
+func (s uint32Sorter) Len() int {
+	return len(s)
+}
+
+// Swap swaps the output indexes at the passed indices.  It is part of the
+// sort.Interface implementation.
+func (s uint32Sorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Less returns whether the output index with index i should sort before the
+// output index with index j.  It is part of the sort.Interface implementation.
+func (s uint32Sorter) Less(i, j int) bool {
+	return s[i] < s[j]
+}
+
+// preferDenseOutputs returns whether an entry with the passed number of
+// outputs and highest output index should store its outputs densely.
+func preferDenseOutputs(numOutputs int, highIndex uint32) bool {
+	numSlots := uint64(highIndex) + 1
+	return numSlots <= maxAlwaysDenseOutputs ||
+		uint64(numOutputs)*2 >= numSlots
+}
+
+// output returns the output at the provided index or nil when the output is
+// not part of the entry.
+func (entry *UtxoEntry) output(outputIndex uint32) *utxoOutput {
+	if entry.sparseOutputs != nil {
+		return entry.sparseOutputs[outputIndex]
+	}
+
+	if uint64(outputIndex) >= uint64(len(entry.outputs)) ||
+		entry.outputs[outputIndex].pruned {
+		return nil
+	}
+	return &entry.outputs[outputIndex]
+}
+
+// numOutputs returns the number of outputs which are part of the entry.
+func (entry *UtxoEntry) numOutputs() int {
+	if entry.sparseOutputs != nil {
+		return len(entry.sparseOutputs)
+	}
+	return entry.numDense
+}
+
+// outputIndexes returns the indexes of all outputs which are part of the entry
+// in ascending order.
+func (entry *UtxoEntry) outputIndexes() []uint32 {
+	outputIndexes := make([]uint32, 0, entry.numOutputs())
+	if entry.sparseOutputs != nil {
+		for outputIndex := range entry.sparseOutputs {
+			outputIndexes = append(outputIndexes, outputIndex)
+		}
+		sort.Sort(uint32Sorter(outputIndexes))
+		return outputIndexes
+	}
+
+	for i := range entry.outputs {
+		if !entry.outputs[i].pruned {
+			outputIndexes = append(outputIndexes, uint32(i))
+		}
+	}
+	return outputIndexes
+}
+
+// addOutput adds the passed output to the entry at the provided index,
+// replacing any existing output at that index.  Dense entries are converted to
+// sparse ones when the new output is too far past the existing ones.
+func (entry *UtxoEntry) addOutput(outputIndex uint32, output utxoOutput) {
+	output.pruned = false
+	if existing := entry.output(outputIndex); existing != nil {
+		*existing = output
+		return
+	}
+
+	if entry.sparseOutputs != nil {
+		entry.sparseOutputs[outputIndex] = &output
+		return
+	}
+
+	// Fill the slot when it is already allocated.
+	if uint64(outputIndex) < uint64(len(entry.outputs)) {
+		entry.outputs[outputIndex] = output
+		entry.numDense++
+		return
+	}
+
+	// Convert to sparse storage when extending the slots to the new index
+	// would leave most of them empty.
+	if !preferDenseOutputs(entry.numDense+1, outputIndex) {
+		entry.unpackOutputs()
+		entry.sparseOutputs[outputIndex] = &output
+		return
+	}
+
+	for uint32(len(entry.outputs)) < outputIndex {
+		entry.outputs = append(entry.outputs, utxoOutput{pruned: true})
+	}
+	entry.outputs = append(entry.outputs, output)
+	entry.numDense++
+}
+
+// unpackOutputs converts the entry to store its outputs in a sparse map.
+func (entry *UtxoEntry) unpackOutputs() {
+	if entry.sparseOutputs != nil {
+		return
+	}
+
+	sparseOutputs := make(map[uint32]*utxoOutput, entry.numDense)
+	for i := range entry.outputs {
+		if entry.outputs[i].pruned {
+			continue
+		}
+		output := entry.outputs[i]
+		sparseOutputs[uint32(i)] = &output
+	}
+	entry.sparseOutputs = sparseOutputs
+	entry.outputs = nil
+	entry.numDense = 0
+}
+
+// packOutputs converts an entry which stores its outputs in a sparse map to
+// dense storage when enough of the outputs are part of the entry.
+func (entry *UtxoEntry) packOutputs() {
+	if entry.sparseOutputs == nil {
+		return
+	}
+
+	var highIndex uint32
+	for outputIndex := range entry.sparseOutputs {
+		if outputIndex > highIndex {
+			highIndex = outputIndex
+		}
+	}
+	if !preferDenseOutputs(len(entry.sparseOutputs), highIndex) {
+		return
+	}
+
+	outputs := make([]utxoOutput, 0, highIndex+1)
+	for i := uint32(0); i <= highIndex; i++ {
+		output, ok := entry.sparseOutputs[i]
+		if !ok {
+			outputs = append(outputs, utxoOutput{pruned: true})
+			continue
+		}
+		outputs = append(outputs, *output)
+	}
+	entry.outputs = outputs
+	entry.numDense = len(entry.sparseOutputs)
+	entry.sparseOutputs = nil
+}
+
+// clearOutputs removes all outputs from the entry.
+func (entry *UtxoEntry) clearOutputs() {
+	entry.outputs = nil
+	entry.numDense = 0
+	entry.sparseOutputs = nil
+}
+
 // Version returns the version of the transaction the utxo represents.
 func (entry *UtxoEntry) Version() int32 {
 	return entry.version
@@ -83,8 +291,8 @@ func (entry *UtxoEntry) BlockHeight() int32 {
 // either due to it being invalid or because the output is not part of the view
 // due to previously being spent/pruned.
 func (entry *UtxoEntry) IsOutputSpent(outputIndex uint32) bool {
-	output, ok := entry.sparseOutputs[outputIndex]
-	if !ok {
+	output := entry.output(outputIndex)
+	if output == nil {
 		return true
 	}
 
@@ -94,8 +302,8 @@ func (entry *UtxoEntry) IsOutputSpent(outputIndex uint32) bool {
 // SpendOutput marks the output at the provided index as spent.  Specifying an
 // output index that does not exist will not have any effect.
 func (entry *UtxoEntry) SpendOutput(outputIndex uint32) {
-	output, ok := entry.sparseOutputs[outputIndex]
-	if !ok {
+	output := entry.output(outputIndex)
+	if output == nil {
 		return
 	}
 
@@ -118,6 +326,11 @@ func (entry *UtxoEntry) IsFullySpent() bool {
 			return false
 		}
 	}
+	for i := range entry.outputs {
+		if !entry.outputs[i].pruned && !entry.outputs[i].spent {
+			return false
+		}
+	}
 
 	return true
 }
@@ -128,8 +341,8 @@ func (entry *UtxoEntry) IsFullySpent() bool {
 // either due to it being invalid or because the output is not part of the view
 // due to previously being spent/pruned.
 func (entry *UtxoEntry) AmountByIndex(outputIndex uint32) int64 {
-	output, ok := entry.sparseOutputs[outputIndex]
-	if !ok {
+	output := entry.output(outputIndex)
+	if output == nil {
 		return 0
 	}
 
@@ -144,8 +357,8 @@ func (entry *UtxoEntry) AmountByIndex(outputIndex uint32) int64 {
 // either due to it being invalid or because the output is not part of the view
 // due to previously being spent/pruned.
 func (entry *UtxoEntry) PkScriptByIndex(outputIndex uint32) []byte {
-	output, ok := entry.sparseOutputs[outputIndex]
-	if !ok {
+	output := entry.output(outputIndex)
+	if output == nil {
 		return nil
 	}
 
@@ -154,14 +367,33 @@ func (entry *UtxoEntry) PkScriptByIndex(outputIndex uint32) []byte {
 	return output.pkScript
 }
 
+// MemorySize returns an estimate of the number of bytes the entry occupies in
+// memory including its outputs and their public key scripts.
+func (entry *UtxoEntry) MemorySize() uint64 {
+	size := uint64(utxoEntryFieldsSize)
+	if entry.sparseOutputs != nil {
+		size += sparseMapSize
+		for _, output := range entry.sparseOutputs {
+			size += sparseMapEntrySize + utxoOutputFieldsSize +
+				uint64(cap(output.pkScript))
+		}
+		return size
+	}
+
+	size += uint64(cap(entry.outputs)) * utxoOutputFieldsSize
+	for i := range entry.outputs {
+		size += uint64(cap(entry.outputs[i].pkScript))
+	}
+	return size
+}
+
 // newUtxoEntry returns a new unspent transaction output entry with the provided
 // coinbase flag and block height ready to have unspent outputs added.
 func newUtxoEntry(version int32, isCoinBase bool, blockHeight int32) *UtxoEntry {
 	return &UtxoEntry{
-		version:       version,
-		isCoinBase:    isCoinBase,
-		blockHeight:   blockHeight,
-		sparseOutputs: make(map[uint32]*utxoOutput),
+		version:     version,
+		isCoinBase:  isCoinBase,
+		blockHeight: blockHeight,
 	}
 }
 
@@ -219,6 +451,13 @@ func (view *UtxoViewpoint) AddTxOuts(tx *colxutil.Tx, blockHeight int32) {
 	}
 	entry.modified = true
 
+	// Allocate the slots for all of the outputs at once when the entry
+	// does not already have any.
+	if entry.numOutputs() == 0 {
+		entry.clearOutputs()
+		entry.outputs = make([]utxoOutput, 0, len(tx.MsgTx().TxOut))
+	}
+
 	// Loop all of the transaction outputs and add those which are not
 	// provably unspendable.
 	for txOutIdx, txOut := range tx.MsgTx().TxOut {
@@ -226,27 +465,19 @@ func (view *UtxoViewpoint) AddTxOuts(tx *colxutil.Tx, blockHeight int32) {
 			continue
 		}
 
-		// Update existing entries.  All fields are updated because it's
-		// possible (although extremely unlikely) that the existing
-		// entry is being replaced by a different transaction with the
-		// same hash.  This is allowed so long as the previous
-		// transaction is fully spent.
-		if output, ok := entry.sparseOutputs[uint32(txOutIdx)]; ok {
-			output.spent = false
-			output.compressed = false
-			output.amount = txOut.Value
-			output.pkScript = txOut.PkScript
-			continue
-		}
-
-		// Add the unspent transaction output.
-		entry.sparseOutputs[uint32(txOutIdx)] = &utxoOutput{
+		// Add the unspent transaction output.  All fields of existing
+		// outputs are replaced because it's possible (although
+		// extremely unlikely) that the existing entry is being replaced
+		// by a different transaction with the same hash.  This is
+		// allowed so long as the previous transaction is fully spent.
+		entry.addOutput(uint32(txOutIdx), utxoOutput{
 			spent:      false,
 			compressed: false,
 			amount:     txOut.Value,
 			pkScript:   txOut.PkScript,
-		}
+		})
 	}
+	entry.packOutputs()
 	return
 }
 
@@ -357,7 +588,7 @@ func (view *UtxoViewpoint) disconnectTransactions(block *colxutil.Block, stxos [
 			view.entries[*tx.Sha()] = entry
 		}
 		entry.modified = true
-		entry.sparseOutputs = make(map[uint32]*utxoOutput)
+		entry.clearOutputs()
 
 		// Loop backwards through all of the transaction inputs (except
 		// for the coinbase which has no inputs) and unspend the
@@ -392,15 +623,15 @@ func (view *UtxoViewpoint) disconnectTransactions(block *colxutil.Block, stxos [
 			// Restore the specific utxo using the stxo data from
 			// the spend journal if it doesn't already exist in the
 			// view.
-			output, ok := entry.sparseOutputs[originIndex]
-			if !ok {
+			output := entry.output(originIndex)
+			if output == nil {
 				// Add the unspent transaction output.
-				entry.sparseOutputs[originIndex] = &utxoOutput{
+				entry.addOutput(originIndex, utxoOutput{
 					spent:      false,
 					compressed: stxo.compressed,
 					amount:     stxo.amount,
 					pkScript:   stxo.pkScript,
-				}
+				})
 				continue
 			}
 
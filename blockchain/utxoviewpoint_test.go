@@ -0,0 +1,287 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// wideTx returns a transaction with the passed number of outputs which each
+// pay to a standard pay-to-pubkey-hash script.
+func wideTx(numOutputs int) *colxutil.Tx {
+	pkScript := hexToBytes("76a914a983ad7c92c38fc0e2025212e9f972204c6e687088ac")
+	msgTx := wire.NewMsgTx()
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&wire.ShaHash{0x01}, 0),
+		nil))
+	for i := 0; i < numOutputs; i++ {
+		msgTx.AddTxOut(wire.NewTxOut(int64(i+1)*1000, pkScript))
+	}
+	return colxutil.NewTx(msgTx)
+}
+
+// TestUtxoEntryDenseSparse ensures utxo entries switch between dense and
+// sparse storage of their outputs as outputs are added and that the accessors
+// behave the same regardless of how the outputs are stored.
+func TestUtxoEntryDenseSparse(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		indexes    []uint32
+		pack       bool
+		wantSparse bool
+	}{
+		{
+			name:       "contiguous",
+			indexes:    []uint32{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+			wantSparse: false,
+		},
+		{
+			name:       "small with gaps",
+			indexes:    []uint32{1, 5},
+			wantSparse: false,
+		},
+		{
+			name:       "half populated",
+			indexes:    []uint32{0, 2, 4, 6, 8, 10, 12, 14},
+			wantSparse: false,
+		},
+		{
+			name:       "single high index",
+			indexes:    []uint32{100000},
+			wantSparse: true,
+		},
+		{
+			name:       "dense then far output",
+			indexes:    []uint32{0, 1, 2, 3, 1000},
+			wantSparse: true,
+		},
+		{
+			name:       "descending without packing",
+			indexes:    []uint32{19, 18, 17, 16, 15, 14, 13, 12, 11, 10},
+			wantSparse: true,
+		},
+		{
+			name:       "descending with packing",
+			indexes:    []uint32{19, 18, 17, 16, 15, 14, 13, 12, 11, 10},
+			pack:       true,
+			wantSparse: false,
+		},
+	}
+
+	for _, test := range tests {
+		entry := newUtxoEntry(1, false, 100)
+		for _, outputIndex := range test.indexes {
+			entry.addOutput(outputIndex, utxoOutput{
+				amount:   int64(outputIndex) + 1,
+				pkScript: []byte{byte(outputIndex)},
+			})
+		}
+		if test.pack {
+			entry.packOutputs()
+		}
+
+		if gotSparse := entry.sparseOutputs != nil; gotSparse != test.wantSparse {
+			t.Errorf("%s: unexpected sparse storage - got %v, want %v",
+				test.name, gotSparse, test.wantSparse)
+			continue
+		}
+		if entry.numOutputs() != len(test.indexes) {
+			t.Errorf("%s: unexpected number of outputs - got %d, "+
+				"want %d", test.name, entry.numOutputs(),
+				len(test.indexes))
+			continue
+		}
+
+		// Ensure every added output is reported unspent with the
+		// expected amount and script and the indexes between them are
+		// not part of the entry.
+		want := make(map[uint32]struct{})
+		for _, outputIndex := range test.indexes {
+			want[outputIndex] = struct{}{}
+		}
+		for outputIndex := uint32(0); outputIndex < 25; outputIndex++ {
+			_, ok := want[outputIndex]
+			if entry.IsOutputSpent(outputIndex) == ok {
+				t.Errorf("%s: output %d unexpected spent state",
+					test.name, outputIndex)
+			}
+			if !ok {
+				if entry.AmountByIndex(outputIndex) != 0 ||
+					entry.PkScriptByIndex(outputIndex) != nil {
+					t.Errorf("%s: output %d unexpectedly "+
+						"exists", test.name, outputIndex)
+				}
+				continue
+			}
+			if entry.AmountByIndex(outputIndex) != int64(outputIndex)+1 {
+				t.Errorf("%s: output %d unexpected amount %d",
+					test.name, outputIndex,
+					entry.AmountByIndex(outputIndex))
+			}
+			if !bytes.Equal(entry.PkScriptByIndex(outputIndex),
+				[]byte{byte(outputIndex)}) {
+				t.Errorf("%s: output %d unexpected script %x",
+					test.name, outputIndex,
+					entry.PkScriptByIndex(outputIndex))
+			}
+		}
+
+		// Ensure the indexes are reported in ascending order.
+		outputIndexes := entry.outputIndexes()
+		for i := 1; i < len(outputIndexes); i++ {
+			if outputIndexes[i-1] >= outputIndexes[i] {
+				t.Errorf("%s: output indexes not ascending: %v",
+					test.name, outputIndexes)
+				break
+			}
+		}
+
+		// Ensure spending every output results in a fully spent entry.
+		for _, outputIndex := range test.indexes {
+			if entry.IsFullySpent() {
+				t.Errorf("%s: entry fully spent before output %d "+
+					"was spent", test.name, outputIndex)
+			}
+			entry.SpendOutput(outputIndex)
+		}
+		if !entry.IsFullySpent() {
+			t.Errorf("%s: entry not fully spent", test.name)
+		}
+	}
+}
+
+// TestUtxoEntryWideTx ensures the outputs of a transaction with many outputs
+// are stored densely, that the entry serializes identically regardless of how
+// its outputs are stored, and that dense storage takes less memory.
+func TestUtxoEntryWideTx(t *testing.T) {
+	t.Parallel()
+
+	const numOutputs = 2000
+	tx := wideTx(numOutputs)
+	view := NewUtxoViewpoint()
+	view.AddTxOuts(tx, 100)
+	entry := view.LookupEntry(tx.Sha())
+	if entry.sparseOutputs != nil || len(entry.outputs) != numOutputs {
+		t.Fatalf("outputs of wide transaction not stored densely")
+	}
+
+	// Spend all but a few outputs spread across the transaction.
+	for i := uint32(0); i < numOutputs; i++ {
+		if i%500 != 7 {
+			entry.SpendOutput(i)
+		}
+	}
+	serialized, err := serializeUtxoEntry(entry)
+	if err != nil {
+		t.Fatalf("serializeUtxoEntry: unexpected error: %v", err)
+	}
+
+	// Ensure the same outputs stored sparsely serialize to the same bytes.
+	sparseEntry := newUtxoEntry(entry.version, entry.isCoinBase,
+		entry.blockHeight)
+	sparseEntry.sparseOutputs = make(map[uint32]*utxoOutput)
+	for _, outputIndex := range entry.outputIndexes() {
+		output := *entry.output(outputIndex)
+		sparseEntry.sparseOutputs[outputIndex] = &output
+	}
+	sparseSerialized, err := serializeUtxoEntry(sparseEntry)
+	if err != nil {
+		t.Fatalf("serializeUtxoEntry: unexpected error: %v", err)
+	}
+	if !bytes.Equal(serialized, sparseSerialized) {
+		t.Fatalf("mismatched serialization - got %x, want %x",
+			sparseSerialized, serialized)
+	}
+	if sparseEntry.MemorySize() <= entry.MemorySize() {
+		t.Fatalf("sparse entry size %d not larger than dense entry "+
+			"size %d", sparseEntry.MemorySize(), entry.MemorySize())
+	}
+
+	// Ensure the few remaining unspent outputs are loaded sparsely and
+	// round trip through the serialization.
+	loaded, err := deserializeUtxoEntry(serialized)
+	if err != nil {
+		t.Fatalf("deserializeUtxoEntry: unexpected error: %v", err)
+	}
+	if loaded.sparseOutputs == nil || loaded.numOutputs() != 4 {
+		t.Fatalf("unexpected loaded outputs %v", loaded.outputIndexes())
+	}
+	for i := uint32(7); i < numOutputs; i += 500 {
+		if loaded.IsOutputSpent(i) {
+			t.Fatalf("loaded output %d is spent", i)
+		}
+		if loaded.AmountByIndex(i) != entry.AmountByIndex(i) {
+			t.Fatalf("loaded output %d amount mismatch - got %d, "+
+				"want %d", i, loaded.AmountByIndex(i),
+				entry.AmountByIndex(i))
+		}
+	}
+	if loaded.MemorySize() >= entry.MemorySize() {
+		t.Fatalf("loaded entry size %d not smaller than full entry "+
+			"size %d", loaded.MemorySize(), entry.MemorySize())
+	}
+}
+
+// BenchmarkAddTxOutsWide benchmarks adding the outputs of a transaction with
+// many outputs to a view.
+func BenchmarkAddTxOutsWide(b *testing.B) {
+	tx := wideTx(5000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		view := NewUtxoViewpoint()
+		view.AddTxOuts(tx, 100)
+	}
+}
+
+// BenchmarkSpendWide benchmarks spending every output of a transaction with
+// many outputs from a view.
+func BenchmarkSpendWide(b *testing.B) {
+	tx := wideTx(5000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		view := NewUtxoViewpoint()
+		view.AddTxOuts(tx, 100)
+		entry := view.LookupEntry(tx.Sha())
+		b.StartTimer()
+
+		for outputIndex := range tx.MsgTx().TxOut {
+			entry.AmountByIndex(uint32(outputIndex))
+			entry.SpendOutput(uint32(outputIndex))
+		}
+	}
+}
+
+// BenchmarkFetchWide benchmarks loading the serialized utxo entry for a
+// transaction with many unspent outputs and accessing all of them.
+func BenchmarkFetchWide(b *testing.B) {
+	tx := wideTx(5000)
+	view := NewUtxoViewpoint()
+	view.AddTxOuts(tx, 100)
+	serialized, err := serializeUtxoEntry(view.LookupEntry(tx.Sha()))
+	if err != nil {
+		b.Fatalf("serializeUtxoEntry: unexpected error: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entry, err := deserializeUtxoEntry(serialized)
+		if err != nil {
+			b.Fatalf("deserializeUtxoEntry: unexpected error: %v", err)
+		}
+		for outputIndex := range tx.MsgTx().TxOut {
+			entry.PkScriptByIndex(uint32(outputIndex))
+		}
+	}
+}
@@ -6,6 +6,7 @@
 package blockchain
 
 import (
+	"context"
 	"encoding/binary"
 	"fmt"
 	"math"
@@ -128,6 +129,10 @@ func IsCoinBase(tx *colxutil.Tx) bool {
 }
 
 // IsFinalizedTransaction determines whether or not a transaction is finalized.
+// The passed block time is the time transactions with a time based lock time
+// are compared against, which is the median time of the blocks prior to the
+// block at the passed height once BIP0113 applies and the block timestamp
+// otherwise.
 func IsFinalizedTransaction(tx *colxutil.Tx, blockHeight int32, blockTime time.Time) bool {
 	msgTx := tx.MsgTx()
 
@@ -462,10 +467,11 @@ func checkBlockHeaderSanity(header *wire.BlockHeader, powLimit *big.Int, timeSou
 //
 // The flags do not modify the behavior of this function directly, however they
 // are needed to pass along to checkBlockHeaderSanity.
-func checkBlockSanity(block *colxutil.Block, powLimit *big.Int, timeSource MedianTimeSource, flags BehaviorFlags) error {
+func checkBlockSanity(block *colxutil.Block, chainParams *chaincfg.Params, timeSource MedianTimeSource, flags BehaviorFlags) error {
 	msgBlock := block.MsgBlock()
 	header := &msgBlock.Header
-	err := checkBlockHeaderSanity(header, powLimit, timeSource, flags)
+	err := checkBlockHeaderSanity(header, chainParams.PowLimit, timeSource,
+		flags)
 	if err != nil {
 		return err
 	}
@@ -484,12 +490,13 @@ func checkBlockSanity(block *colxutil.Block, powLimit *big.Int, timeSource Media
 		return ruleError(ErrTooManyTransactions, str)
 	}
 
-	// A block must not exceed the maximum allowed block payload when
-	// serialized.
-	serializedSize := msgBlock.SerializeSize()
-	if serializedSize > wire.MaxBlockPayload {
-		str := fmt.Sprintf("serialized block is too big - got %d, "+
-			"max %d", serializedSize, wire.MaxBlockPayload)
+	// A block must not exceed the maximum allowed block weight of the
+	// network.
+	maxWeight, maxSigOpsCost := chainParams.GetBlockWeightLimits()
+	blockWeight := CalcBlockWeight(block)
+	if blockWeight > maxWeight {
+		str := fmt.Sprintf("block weight is too high - got %d, max %d",
+			blockWeight, maxWeight)
 		return ruleError(ErrBlockTooBig, str)
 	}
 
@@ -547,18 +554,22 @@ func checkBlockSanity(block *colxutil.Block, powLimit *big.Int, timeSource Media
 		existingTxHashes[*hash] = struct{}{}
 	}
 
-	// The number of signature operations must be less than the maximum
-	// allowed per block.
-	totalSigOps := 0
+	// The signature operation cost must be less than the maximum allowed
+	// per block.  The pay-to-script-hash signature operations can't be
+	// counted without the referenced outputs, so they are only included by
+	// the check when the block is connected.
+	totalSigOpCost := int64(0)
 	for _, tx := range transactions {
 		// We could potentially overflow the accumulator so check for
 		// overflow.
-		lastSigOps := totalSigOps
-		totalSigOps += CountSigOps(tx)
-		if totalSigOps < lastSigOps || totalSigOps > MaxSigOpsPerBlock {
+		lastSigOpCost := totalSigOpCost
+		totalSigOpCost += int64(CountSigOps(tx)) * WitnessScaleFactor
+		if totalSigOpCost < lastSigOpCost ||
+			totalSigOpCost > maxSigOpsCost {
+
 			str := fmt.Sprintf("block contains too many signature "+
-				"operations - got %v, max %v", totalSigOps,
-				MaxSigOpsPerBlock)
+				"operations - got cost %v, max %v",
+				totalSigOpCost, maxSigOpsCost)
 			return ruleError(ErrTooManySigOps, str)
 		}
 	}
@@ -568,8 +579,10 @@ func checkBlockSanity(block *colxutil.Block, powLimit *big.Int, timeSource Media
 
 // CheckBlockSanity performs some preliminary checks on a block to ensure it is
 // sane before continuing with block processing.  These checks are context free.
-func CheckBlockSanity(block *colxutil.Block, powLimit *big.Int, timeSource MedianTimeSource) error {
-	return checkBlockSanity(block, powLimit, timeSource, BFNone)
+// The block weight and signature operation cost limits are those of the passed
+// network.
+func CheckBlockSanity(block *colxutil.Block, chainParams *chaincfg.Params, timeSource MedianTimeSource) error {
+	return checkBlockSanity(block, chainParams, timeSource, BFNone)
 }
 
 // ExtractCoinbaseHeight attempts to extract the height of the block from the
@@ -725,12 +738,28 @@ func (b *BlockChain) checkBlockHeaderContext(header *wire.BlockHeader, prevNode
 	return nil
 }
 
+// useMedianTimePast returns whether or not the transactions in the block AFTER
+// the provided node must be finalized as of the median time of the previous
+// blocks instead of the block timestamp.  This is the case when the passed
+// flags include BFMedianTimePast or once the BIP0113 deployment is active.
+//
+// This function MUST be called with the chain state lock held (for writes).
+func (b *BlockChain) useMedianTimePast(prevNode *blockNode, flags BehaviorFlags) (bool, error) {
+	if flags&BFMedianTimePast == BFMedianTimePast {
+		return true, nil
+	}
+	return b.isDeploymentActive(prevNode, chaincfg.DeploymentMedianTimePast)
+}
+
 // checkBlockContext peforms several validation checks on the block which depend
 // on its position within the block chain.
 //
 // The flags modify the behavior of this function as follows:
 //  - BFFastAdd: The transaction are not checked to see if they are finalized
 //    and the somewhat expensive BIP0034 validation is not performed.
+//  - BFMedianTimePast: The transactions must be finalized as of the median
+//    time of the previous blocks instead of the block timestamp.  This is
+//    implied once the BIP0113 deployment is active.
 //
 // The flags are also passed to checkBlockHeaderContext.  See its documentation
 // for how the flags modify its behavior.
@@ -742,11 +771,14 @@ func (b *BlockChain) checkBlockContext(block *colxutil.Block, prevNode *blockNod
 		return nil
 	}
 
-	// Perform all block header related validation checks.
+	// Perform all block header related validation checks unless they were
+	// already performed when the header was processed on its own.
 	header := &block.MsgBlock().Header
-	err := b.checkBlockHeaderContext(header, prevNode, flags)
-	if err != nil {
-		return err
+	if _, ok := b.headerIndex[*block.Sha()]; !ok {
+		err := b.checkBlockHeaderContext(header, prevNode, flags)
+		if err != nil {
+			return err
+		}
 	}
 
 	fastAdd := flags&BFFastAdd == BFFastAdd
@@ -755,10 +787,27 @@ func (b *BlockChain) checkBlockContext(block *colxutil.Block, prevNode *blockNod
 		// previous block.
 		blockHeight := prevNode.height + 1
 
+		// Transactions with a time based lock time are finalized as of
+		// the block timestamp unless the median time of the previous
+		// blocks is required instead.  Using the median time prevents
+		// miners from including transactions which are not finalized
+		// yet by inflating the block timestamp.  This is part of
+		// BIP0113.
+		blockTime := header.Timestamp
+		useMedianTime, err := b.useMedianTimePast(prevNode, flags)
+		if err != nil {
+			return err
+		}
+		if useMedianTime {
+			blockTime, err = b.calcPastMedianTime(prevNode)
+			if err != nil {
+				return err
+			}
+		}
+
 		// Ensure all transactions in the block are finalized.
 		for _, tx := range block.Transactions() {
-			if !IsFinalizedTransaction(tx, blockHeight,
-				header.Timestamp) {
+			if !IsFinalizedTransaction(tx, blockHeight, blockTime) {
 
 				str := fmt.Sprintf("block contains unfinalized "+
 					"transaction %v", tx.Sha())
@@ -849,7 +898,7 @@ func CheckTransactionInputs(tx *colxutil.Tx, txHeight int32, utxoView *UtxoViewp
 			str := fmt.Sprintf("unable to find unspent output "+
 				"%v referenced from transaction %s:%d",
 				txIn.PreviousOutPoint, tx.Sha(), txInIndex)
-			return 0, ruleError(ErrMissingTx, str)
+			return 0, inputRuleError(ErrMissingTx, str, txInIndex)
 		}
 
 		// Ensure the transaction is not spending coins which have not
@@ -864,7 +913,8 @@ func CheckTransactionInputs(tx *colxutil.Tx, txHeight int32, utxoView *UtxoViewp
 					"of %v blocks", originTxHash,
 					originHeight, txHeight,
 					coinbaseMaturity)
-				return 0, ruleError(ErrImmatureSpend, str)
+				return 0, inputRuleError(ErrImmatureSpend, str,
+					txInIndex)
 			}
 		}
 
@@ -874,7 +924,7 @@ func CheckTransactionInputs(tx *colxutil.Tx, txHeight int32, utxoView *UtxoViewp
 			str := fmt.Sprintf("transaction %s:%d tried to double "+
 				"spend output %v", txHash, txInIndex,
 				txIn.PreviousOutPoint)
-			return 0, ruleError(ErrDoubleSpend, str)
+			return 0, inputRuleError(ErrDoubleSpend, str, txInIndex)
 		}
 
 		// Ensure the transaction amounts are in range.  Each of the
@@ -887,14 +937,16 @@ func CheckTransactionInputs(tx *colxutil.Tx, txHeight int32, utxoView *UtxoViewp
 		if originTxSatoshi < 0 {
 			str := fmt.Sprintf("transaction output has negative "+
 				"value of %v", colxutil.Amount(originTxSatoshi))
-			return 0, ruleError(ErrBadTxOutValue, str)
+			return 0, inputRuleError(ErrBadTxOutValue, str,
+				txInIndex)
 		}
 		if originTxSatoshi > colxutil.MaxSatoshi {
 			str := fmt.Sprintf("transaction output value of %v is "+
 				"higher than max allowed value of %v",
 				colxutil.Amount(originTxSatoshi),
 				colxutil.MaxSatoshi)
-			return 0, ruleError(ErrBadTxOutValue, str)
+			return 0, inputRuleError(ErrBadTxOutValue, str,
+				txInIndex)
 		}
 
 		// The total of all outputs must not be more than the max
@@ -908,7 +960,8 @@ func CheckTransactionInputs(tx *colxutil.Tx, txHeight int32, utxoView *UtxoViewp
 				"inputs is %v which is higher than max "+
 				"allowed value of %v", totalSatoshiIn,
 				colxutil.MaxSatoshi)
-			return 0, ruleError(ErrBadTxOutValue, str)
+			return 0, inputRuleError(ErrBadTxOutValue, str,
+				txInIndex)
 		}
 	}
 
@@ -951,8 +1004,11 @@ func CheckTransactionInputs(tx *colxutil.Tx, txHeight int32, utxoView *UtxoViewp
 // See the comments for CheckConnectBlock for some examples of the type of
 // checks performed by this function.
 //
+// Script validation stops with the error of the passed context when it is
+// canceled.
+//
 // This function MUST be called with the chain state lock held (for writes).
-func (b *BlockChain) checkConnectBlock(node *blockNode, block *colxutil.Block, view *UtxoViewpoint, stxos *[]spentTxOut) error {
+func (b *BlockChain) checkConnectBlock(ctx context.Context, node *blockNode, block *colxutil.Block, view *UtxoViewpoint, stxos *[]spentTxOut) error {
 	// If the side chain blocks end up in the database, a call to
 	// CheckBlockSanity should be done here in case a previous version
 	// allowed a block that is no longer valid.  However, since the
@@ -1005,38 +1061,35 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *colxutil.Block, v
 	// https://en.bitcoin.it/wiki/BIP_0016 for more details.
 	enforceBIP0016 := node.timestamp.After(txscript.Bip16Activation)
 
-	// The number of signature operations must be less than the maximum
-	// allowed per block.  Note that the preliminary sanity checks on a
-	// block also include a check similar to this one, but this check
-	// expands the count to include a precise count of pay-to-script-hash
-	// signature operations in each of the input transaction public key
-	// scripts.
+	// The signature operation cost must be less than the maximum allowed
+	// per block.  Note that the preliminary sanity checks on a block also
+	// include a check similar to this one, but this check expands the
+	// cost to include a precise count of pay-to-script-hash signature
+	// operations in each of the input transaction public key scripts.
+	_, maxSigOpsCost := b.chainParams.GetBlockWeightLimits()
 	transactions := block.Transactions()
-	totalSigOps := 0
+	totalSigOpCost := int64(0)
 	for i, tx := range transactions {
-		numsigOps := CountSigOps(tx)
-		if enforceBIP0016 {
-			// Since the first (and only the first) transaction has
-			// already been verified to be a coinbase transaction,
-			// use i == 0 as an optimization for the flag to
-			// countP2SHSigOps for whether or not the transaction is
-			// a coinbase transaction rather than having to do a
-			// full coinbase check again.
-			numP2SHSigOps, err := CountP2SHSigOps(tx, i == 0, view)
-			if err != nil {
-				return err
-			}
-			numsigOps += numP2SHSigOps
+		// Since the first (and only the first) transaction has already
+		// been verified to be a coinbase transaction, use i == 0 as an
+		// optimization for the flag to GetSigOpCost for whether or not
+		// the transaction is a coinbase transaction rather than having
+		// to do a full coinbase check again.
+		sigOpCost, err := GetSigOpCost(tx, i == 0, view, enforceBIP0016)
+		if err != nil {
+			return txValidationError(err, tx.MsgTx(), i)
 		}
 
 		// Check for overflow or going over the limits.  We have to do
 		// this on every loop iteration to avoid overflow.
-		lastSigops := totalSigOps
-		totalSigOps += numsigOps
-		if totalSigOps < lastSigops || totalSigOps > MaxSigOpsPerBlock {
+		lastSigOpCost := totalSigOpCost
+		totalSigOpCost += int64(sigOpCost)
+		if totalSigOpCost < lastSigOpCost ||
+			totalSigOpCost > maxSigOpsCost {
+
 			str := fmt.Sprintf("block contains too many "+
-				"signature operations - got %v, max %v",
-				totalSigOps, MaxSigOpsPerBlock)
+				"signature operations - got cost %v, max %v",
+				totalSigOpCost, maxSigOpsCost)
 			return ruleError(ErrTooManySigOps, str)
 		}
 	}
@@ -1048,11 +1101,42 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *colxutil.Block, v
 	// still relatively cheap as compared to running the scripts) checks
 	// against all the inputs when the signature operations are out of
 	// bounds.
+	//
+	// The relative lock-times of the transaction inputs are also enforced
+	// once BIP0068 is active.  They are evaluated against the past median
+	// time of the block prior to this one.
+	var medianTime time.Time
+	enforceSequenceLocks := node.height >= b.chainParams.BIP0068Height
+	if enforceSequenceLocks {
+		prevNode, err := b.getPrevNodeFromNode(node)
+		if err != nil {
+			return err
+		}
+		medianTime, err = b.calcPastMedianTime(prevNode)
+		if err != nil {
+			return err
+		}
+	}
 	var totalFees int64
-	for _, tx := range transactions {
+	for txIndex, tx := range transactions {
 		txFee, err := CheckTransactionInputs(tx, node.height, view)
 		if err != nil {
-			return err
+			return txValidationError(err, tx.MsgTx(), txIndex)
+		}
+
+		if enforceSequenceLocks {
+			sequenceLock, err := b.calcSequenceLock(node, tx, view,
+				false)
+			if err != nil {
+				return txValidationError(err, tx.MsgTx(), txIndex)
+			}
+			if !sequenceLock.IsSatisfied(node.height, medianTime) {
+				str := fmt.Sprintf("block contains transaction "+
+					"%v whose input sequence locks are not met",
+					tx.Sha())
+				err := ruleError(ErrUnfinalizedTx, str)
+				return txValidationError(err, tx.MsgTx(), txIndex)
+			}
 		}
 
 		// Sum the total fees and ensure we don't overflow the
@@ -1104,6 +1188,19 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *colxutil.Block, v
 		runScripts = false
 	}
 
+	// Similarly, don't run scripts for ancestors of the assumevalid block
+	// which are buried deeply enough under the best known header chain.
+	if runScripts {
+		assumedValid, err := b.isAssumedValid(node)
+		if err != nil {
+			return err
+		}
+		runScripts = !assumedValid
+	}
+	if b.scriptsHook != nil {
+		b.scriptsHook(node.hash, runScripts)
+	}
+
 	// Get the previous block node.  This function is used over simply
 	// accessing node.parent directly as it will dynamically create previous
 	// block nodes as needed.  This helps allow only the pieces of the chain
@@ -1116,6 +1213,10 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *colxutil.Block, v
 
 	// Blocks created after the BIP0016 activation time need to have the
 	// pay-to-script-hash checks enabled.
+	//
+	// Note that policy-only flags such as ScriptVerifyMinimalData must
+	// never be added here since historical blocks contain scripts which
+	// violate them.
 	var scriptFlags txscript.ScriptFlags
 	if enforceBIP0016 {
 		scriptFlags |= txscript.ScriptBip16
@@ -1140,12 +1241,35 @@ func (b *BlockChain) checkConnectBlock(node *blockNode, block *colxutil.Block, v
 		scriptFlags |= txscript.ScriptVerifyCheckLockTimeVerify
 	}
 
+	// Enforce CHECKSEQUENCEVERIFY along with the relative lock-times it
+	// verifies once BIP0068 is active.  This is part of BIP0112.
+	if enforceSequenceLocks {
+		scriptFlags |= txscript.ScriptVerifyCheckSequenceVerify
+	}
+
+	// Enforce the NULLDUMMY rule, which requires the dummy element consumed
+	// by OP_CHECKMULTISIG to be empty, once the deployment voted on via the
+	// version bits is active.  This is part of BIP0147.
+	nullDummyActive, err := b.isDeploymentActive(prevNode,
+		chaincfg.DeploymentNullDummy)
+	if err != nil {
+		return err
+	}
+	if nullDummyActive {
+		scriptFlags |= txscript.ScriptStrictMultiSig
+	}
+
 	// Now that the inexpensive checks are done and have passed, verify the
 	// transactions are actually allowed to spend the coins by running the
 	// expensive ECDSA signature check scripts.  Doing this last helps
 	// prevent CPU exhaustion attacks.
 	if runScripts {
-		err := checkBlockScripts(block, view, scriptFlags, b.sigCache)
+		err := checkBlockScripts(ctx, block, view, scriptFlags,
+			b.sigCache)
+		if rerr, ok := err.(RuleError); ok && rerr.TxIndex >= 0 {
+			tx := block.MsgBlock().Transactions[rerr.TxIndex]
+			return txValidationError(rerr, tx, rerr.TxIndex)
+		}
 		if err != nil {
 			return err
 		}
@@ -1181,5 +1305,65 @@ func (b *BlockChain) CheckConnectBlock(block *colxutil.Block) error {
 	// is not needed and thus extra work can be avoided.
 	view := NewUtxoViewpoint()
 	view.SetBestHash(prevNode.hash)
-	return b.checkConnectBlock(newNode, block, view, nil)
+	return b.checkConnectBlock(context.Background(), newNode, block, view,
+		nil)
+}
+
+// CheckConnectBlockTemplate fully validates that connecting the passed block to
+// the main chain does not violate any consensus rules, aside from the proof of
+// work requirement.  Unlike processing the block with the BFDryRun flag, the
+// block must connect to the current tip of the main chain and neither the
+// memory chain index nor any other chain state is modified, which makes it
+// suitable for checking block templates and block proposals.
+//
+// This function is safe for concurrent access.
+func (b *BlockChain) CheckConnectBlockTemplate(block *colxutil.Block) error {
+	b.chainLock.Lock()
+	defer b.chainLock.Unlock()
+
+	// Skip the proof of work check as this is just a block template.
+	flags := BFNoPoWCheck
+
+	// This only checks whether the block can be connected to the tip of
+	// the current chain.
+	tip := b.bestNode
+	header := &block.MsgBlock().Header
+	if !tip.hash.IsEqual(&header.PrevBlock) {
+		str := fmt.Sprintf("previous block must be the current chain "+
+			"tip %v, instead got %v", tip.hash, header.PrevBlock)
+		return ruleError(ErrPrevBlockNotBest, str)
+	}
+
+	// The block must not already exist in the main chain or side chains.
+	blockHash := block.Sha()
+	exists, err := b.blockExists(blockHash)
+	if err != nil {
+		return err
+	}
+	if exists {
+		str := fmt.Sprintf("already have block %v", blockHash)
+		return ruleError(ErrDuplicateBlock, str)
+	}
+
+	err = checkBlockSanity(block, b.chainParams, b.timeSource, flags)
+	if err != nil {
+		return err
+	}
+
+	block.SetHeight(tip.height + 1)
+	err = b.checkBlockContext(block, tip, flags)
+	if err != nil {
+		return err
+	}
+
+	newNode := newBlockNode(header, blockHash, tip.height+1)
+	newNode.parent = tip
+	newNode.workSum.Add(tip.workSum, newNode.workSum)
+
+	// Leave the spent txouts entry nil in the state since the information
+	// is not needed and thus extra work can be avoided.
+	view := NewUtxoViewpoint()
+	view.SetBestHash(tip.hash)
+	return b.checkConnectBlock(context.Background(), newNode, block, view,
+		nil)
 }
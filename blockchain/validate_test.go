@@ -21,7 +21,8 @@ import (
 // fails.
 func TestCheckConnectBlock(t *testing.T) {
 	// Create a new database and chain instance to run tests against.
-	chain, teardownFunc, err := chainSetup("checkconnectblock")
+	chain, teardownFunc, err := chainSetup("checkconnectblock",
+		&chaincfg.MainNetParams)
 	if err != nil {
 		t.Errorf("Failed to setup chain instance: %v", err)
 		return
@@ -36,13 +37,68 @@ func TestCheckConnectBlock(t *testing.T) {
 	}
 }
 
+// TestCheckConnectBlockTemplate ensures block templates are only accepted when
+// they build on the current tip and are valid, and that checking them does not
+// modify the chain.
+func TestCheckConnectBlockTemplate(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, 3)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+	chain, teardownFunc, err := chainSetup("checkconnectblocktemplate",
+		params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+	for _, block := range blocks[:2] {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+
+	// checkErrorCode ensures the passed error is a rule error with the
+	// passed code.
+	checkErrorCode := func(err error, want blockchain.ErrorCode) {
+		rerr, ok := err.(blockchain.RuleError)
+		if !ok || rerr.ErrorCode != want {
+			t.Fatalf("CheckConnectBlockTemplate: unexpected error "+
+				"%v, want %v", err, want)
+		}
+	}
+
+	// A block which does not build on the tip is rejected.
+	checkErrorCode(chain.CheckConnectBlockTemplate(blocks[1]),
+		blockchain.ErrPrevBlockNotBest)
+
+	// A block with a bad merkle root is rejected.
+	badBlock := *blocks[2].MsgBlock()
+	badBlock.Header.MerkleRoot = wire.ShaHash{0x01}
+	checkErrorCode(chain.CheckConnectBlockTemplate(
+		colxutil.NewBlock(&badBlock)), blockchain.ErrBadMerkleRoot)
+
+	// A valid block is accepted without being added to the chain.
+	best := chain.BestSnapshot()
+	if err := chain.CheckConnectBlockTemplate(blocks[2]); err != nil {
+		t.Fatalf("CheckConnectBlockTemplate: unexpected error: %v", err)
+	}
+	if have, err := chain.HaveBlock(blocks[2].Sha()); err != nil || have {
+		t.Fatalf("checked block template was added to the chain")
+	}
+	if !chain.BestSnapshot().Hash.IsEqual(best.Hash) {
+		t.Fatalf("best chain changed after checking a block template")
+	}
+}
+
 // TestCheckBlockSanity tests the CheckBlockSanity function to ensure it works
 // as expected.
 func TestCheckBlockSanity(t *testing.T) {
-	powLimit := chaincfg.MainNetParams.PowLimit
+	params := &chaincfg.MainNetParams
 	block := colxutil.NewBlock(&Block100000)
 	timeSource := blockchain.NewMedianTime()
-	err := blockchain.CheckBlockSanity(block, powLimit, timeSource)
+	err := blockchain.CheckBlockSanity(block, params, timeSource)
 	if err != nil {
 		t.Errorf("CheckBlockSanity: %v", err)
 	}
@@ -51,7 +107,7 @@ func TestCheckBlockSanity(t *testing.T) {
 	// second fails.
 	timestamp := block.MsgBlock().Header.Timestamp
 	block.MsgBlock().Header.Timestamp = timestamp.Add(time.Nanosecond)
-	err = blockchain.CheckBlockSanity(block, powLimit, timeSource)
+	err = blockchain.CheckBlockSanity(block, params, timeSource)
 	if err == nil {
 		t.Errorf("CheckBlockSanity: error is nil when it shouldn't be")
 	}
@@ -374,3 +430,129 @@ var Block100000 = wire.MsgBlock{
 		},
 	},
 }
+
+// TestMedianTimePastFinality ensures transactions with a time based lock time
+// are finalized as of the block timestamp by default and as of the median time
+// of the previous blocks when BFMedianTimePast is set, including near the
+// genesis block where fewer blocks than usual are available to calculate the
+// median time.
+func TestMedianTimePastFinality(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	blocks, err := generateChain(params, 12)
+	if err != nil {
+		t.Fatalf("unable to generate chain: %v", err)
+	}
+
+	chain, teardownFunc, err := chainSetup("mtpfinality", params)
+	if err != nil {
+		t.Fatalf("Failed to setup chain instance: %v", err)
+	}
+	defer teardownFunc()
+
+	// The blocks are ten minutes apart, so the median time of a block is
+	// five blocks prior to its own timestamp once there are at least
+	// eleven blocks and the timestamp of the block halfway back otherwise.
+	genesisTime := params.GenesisBlock.Header.Timestamp
+	blockTime := func(height int32) time.Time {
+		return genesisTime.Add(time.Minute * 10 * time.Duration(height))
+	}
+
+	// lockedBlock returns a block extending the passed block whose coinbase
+	// has the passed lock time and is not finalized by its sequence number.
+	lockedBlock := func(parent *colxutil.Block, parentHeight int32, lockTime time.Time) *colxutil.Block {
+		generated, err := generateChainFrom(params,
+			&parent.MsgBlock().Header, parentHeight, 1, 1)
+		if err != nil {
+			t.Fatalf("unable to generate block: %v", err)
+		}
+		msgBlock := generated[0].MsgBlock()
+		coinbase := msgBlock.Transactions[0]
+		coinbase.TxIn[0].Sequence = 0
+		coinbase.LockTime = uint32(lockTime.Unix())
+		merkles := blockchain.BuildMerkleTreeStore(
+			colxutil.NewBlock(msgBlock).Transactions())
+		msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+		solveBlock(&msgBlock.Header)
+		return colxutil.NewBlock(msgBlock)
+	}
+
+	// assertFinality ensures a block extending the passed block whose
+	// coinbase has the passed lock time is only accepted when the lock
+	// time is before the median time of its previous blocks or
+	// BFMedianTimePast is not set.
+	assertFinality := func(name string, parent *colxutil.Block, parentHeight int32, lockTime time.Time, wantMTPFinal bool) {
+		block := lockedBlock(parent, parentHeight, lockTime)
+		flags := blockchain.BFDryRun | blockchain.BFMedianTimePast
+		_, err := chain.ProcessBlock(block, flags)
+		if wantMTPFinal && err != nil {
+			t.Fatalf("%s: ProcessBlock with median time past: "+
+				"unexpected error: %v", name, err)
+		}
+		if !wantMTPFinal {
+			rerr, ok := err.(blockchain.RuleError)
+			if !ok || rerr.ErrorCode != blockchain.ErrUnfinalizedTx {
+				t.Fatalf("%s: ProcessBlock with median time past: "+
+					"unexpected error - got %v, want %v", name,
+					err, blockchain.ErrUnfinalizedTx)
+			}
+		}
+		_, err = chain.ProcessBlock(block, blockchain.BFDryRun)
+		if err != nil {
+			t.Fatalf("%s: ProcessBlock: unexpected error: %v", name,
+				err)
+		}
+	}
+
+	// With only the genesis block and the first block available, the
+	// median time of the first block is its own timestamp.
+	if _, err := chain.ProcessBlock(blocks[0], blockchain.BFNone); err != nil {
+		t.Fatalf("ProcessBlock: unexpected error: %v", err)
+	}
+	medianTime, err := chain.BlockPastMedianTime(blocks[0].Sha())
+	if err != nil {
+		t.Fatalf("BlockPastMedianTime: unexpected error: %v", err)
+	}
+	if !medianTime.Equal(blockTime(1)) {
+		t.Fatalf("BlockPastMedianTime: unexpected median time - got %v, "+
+			"want %v", medianTime, blockTime(1))
+	}
+	assertFinality("genesis adjacent before median time", blocks[0], 1,
+		blockTime(1).Add(-time.Second), true)
+	assertFinality("genesis adjacent at median time", blocks[0], 1,
+		blockTime(1), false)
+
+	// With a full set of previous blocks, a lock time between the median
+	// time and the block timestamp is only finalized as of the timestamp.
+	for _, block := range blocks[1:] {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+	medianTime, err = chain.BlockPastMedianTime(blocks[11].Sha())
+	if err != nil {
+		t.Fatalf("BlockPastMedianTime: unexpected error: %v", err)
+	}
+	if !medianTime.Equal(blockTime(7)) {
+		t.Fatalf("BlockPastMedianTime: unexpected median time - got %v, "+
+			"want %v", medianTime, blockTime(7))
+	}
+	bestMedianTime, err := chain.CalcPastMedianTime()
+	if err != nil {
+		t.Fatalf("CalcPastMedianTime: unexpected error: %v", err)
+	}
+	if !bestMedianTime.Equal(medianTime) {
+		t.Fatalf("CalcPastMedianTime: unexpected median time - got %v, "+
+			"want %v", bestMedianTime, medianTime)
+	}
+	assertFinality("before median time", blocks[11], 12,
+		blockTime(7).Add(-time.Second), true)
+	assertFinality("between median time and timestamp", blocks[11], 12,
+		blockTime(10), false)
+
+	// The median time of blocks which are not known can't be calculated.
+	if _, err := chain.BlockPastMedianTime(&wire.ShaHash{}); err == nil {
+		t.Fatal("BlockPastMedianTime: unexpected success for unknown " +
+			"block")
+	}
+}
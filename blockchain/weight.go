@@ -0,0 +1,46 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain
+
+import (
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// WitnessScaleFactor is the number of weight units for each serialized byte of
+// a block or transaction as well as the cost of each signature operation.  The
+// chain has no witness data, so the weight of a block is always its serialized
+// size scaled by this factor and the weight limits are equivalent to the size
+// limits.
+const WitnessScaleFactor = 4
+
+// CalcTxWeight returns the weight of the passed transaction, which counts
+// towards the maximum weight of the block which contains it.
+func CalcTxWeight(tx *colxutil.Tx) int64 {
+	return int64(tx.MsgTx().SerializeSize()) * WitnessScaleFactor
+}
+
+// CalcBlockWeight returns the weight of the passed block, which is limited by
+// the maximum block weight of the network.
+func CalcBlockWeight(block *colxutil.Block) int64 {
+	return int64(block.MsgBlock().SerializeSize()) * WitnessScaleFactor
+}
+
+// GetSigOpCost returns the signature operation cost of the passed transaction,
+// which counts towards the maximum signature operation cost of the block which
+// contains it.  The cost includes the signature operations of the
+// pay-to-script-hash inputs when countP2SH is set, which requires the outputs
+// the transaction spends to be in the passed view.
+func GetSigOpCost(tx *colxutil.Tx, isCoinBaseTx bool, utxoView *UtxoViewpoint, countP2SH bool) (int, error) {
+	numSigOps := CountSigOps(tx)
+	if countP2SH {
+		numP2SHSigOps, err := CountP2SHSigOps(tx, isCoinBaseTx, utxoView)
+		if err != nil {
+			return 0, err
+		}
+		numSigOps += numP2SHSigOps
+	}
+	return numSigOps * WitnessScaleFactor, nil
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package blockchain_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestCalcTxWeight ensures the weight of transactions is four units for every
+// serialized byte.
+func TestCalcTxWeight(t *testing.T) {
+	p2pkhScript := bytes.Repeat([]byte{0x00}, 25)
+	prevOut := wire.NewOutPoint(&wire.ShaHash{0x01}, 0)
+
+	// One input with an empty signature script and one pay-to-pubkey-hash
+	// output:
+	//   version 4 + input count 1 + input (36 + 1 + 0 + 4) +
+	//   output count 1 + output (8 + 1 + 25) + lock time 4 = 85 bytes
+	oneInOneOut := wire.NewMsgTx()
+	oneInOneOut.AddTxIn(wire.NewTxIn(prevOut, nil))
+	oneInOneOut.AddTxOut(wire.NewTxOut(1000, p2pkhScript))
+
+	// Two inputs with typical 107 byte pay-to-pubkey-hash signature scripts
+	// and two pay-to-pubkey-hash outputs:
+	//   version 4 + input count 1 + 2 * input (36 + 1 + 107 + 4) +
+	//   output count 1 + 2 * output (8 + 1 + 25) + lock time 4 = 374 bytes
+	twoInTwoOut := wire.NewMsgTx()
+	for i := 0; i < 2; i++ {
+		sigScript := bytes.Repeat([]byte{0x00}, 107)
+		twoInTwoOut.AddTxIn(wire.NewTxIn(prevOut, sigScript))
+		twoInTwoOut.AddTxOut(wire.NewTxOut(1000, p2pkhScript))
+	}
+
+	tests := []struct {
+		name   string
+		tx     *wire.MsgTx
+		weight int64
+	}{
+		{"one input and output", oneInOneOut, 85 * 4},
+		{"two inputs and outputs", twoInTwoOut, 374 * 4},
+	}
+	for _, test := range tests {
+		weight := blockchain.CalcTxWeight(colxutil.NewTx(test.tx))
+		if weight != test.weight {
+			t.Errorf("%s: unexpected weight - got %d, want %d",
+				test.name, weight, test.weight)
+		}
+	}
+}
+
+// TestBlockWeightLimits ensures blocks at exactly the maximum weight and
+// signature operation cost of the network are sane while blocks above them are
+// rejected.
+func TestBlockWeightLimits(t *testing.T) {
+	// Work on a copy of the block with its original timestamp since other
+	// tests modify the shared block.
+	msgBlock := Block100000
+	msgBlock.Header.Timestamp = time.Unix(1293623863, 0)
+	block := colxutil.NewBlock(&msgBlock)
+	timeSource := blockchain.NewMedianTime()
+
+	// Block 100000 is 957 bytes.
+	weight := blockchain.CalcBlockWeight(block)
+	if weight != 957*4 {
+		t.Fatalf("CalcBlockWeight: unexpected weight - got %d, want %d",
+			weight, 957*4)
+	}
+
+	// The main network uses the default limits.
+	maxWeight, maxSigOpsCost := chaincfg.MainNetParams.GetBlockWeightLimits()
+	if maxWeight != chaincfg.DefaultMaxBlockWeight ||
+		maxSigOpsCost != chaincfg.DefaultMaxBlockSigOpsCost {
+
+		t.Fatalf("GetBlockWeightLimits: unexpected limits - got %d and "+
+			"%d", maxWeight, maxSigOpsCost)
+	}
+
+	sigOpCost := 0
+	for _, tx := range block.Transactions() {
+		cost, err := blockchain.GetSigOpCost(tx, false, nil, false)
+		if err != nil {
+			t.Fatalf("GetSigOpCost: unexpected error: %v", err)
+		}
+		sigOpCost += cost
+	}
+
+	tests := []struct {
+		name          string
+		maxWeight     int64
+		maxSigOpsCost int64
+		errCode       blockchain.ErrorCode
+		wantErr       bool
+	}{
+		{
+			name:          "at limits",
+			maxWeight:     weight,
+			maxSigOpsCost: int64(sigOpCost),
+		},
+		{
+			name:          "above max weight",
+			maxWeight:     weight - 1,
+			maxSigOpsCost: int64(sigOpCost),
+			errCode:       blockchain.ErrBlockTooBig,
+			wantErr:       true,
+		},
+		{
+			name:          "above max sigop cost",
+			maxWeight:     weight,
+			maxSigOpsCost: int64(sigOpCost) - 1,
+			errCode:       blockchain.ErrTooManySigOps,
+			wantErr:       true,
+		},
+	}
+	for _, test := range tests {
+		params := chaincfg.MainNetParams
+		params.MaxBlockWeight = test.maxWeight
+		params.MaxBlockSigOpsCost = test.maxSigOpsCost
+		err := blockchain.CheckBlockSanity(block, &params, timeSource)
+		if !test.wantErr {
+			if err != nil {
+				t.Errorf("%s: CheckBlockSanity: unexpected error: %v",
+					test.name, err)
+			}
+			continue
+		}
+		rerr, ok := err.(blockchain.RuleError)
+		if !ok || rerr.ErrorCode != test.errCode {
+			t.Errorf("%s: CheckBlockSanity: unexpected error - got %v, "+
+				"want %v", test.name, err, test.errCode)
+		}
+	}
+}
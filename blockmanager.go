@@ -7,6 +7,7 @@ package main
 
 import (
 	"container/list"
+	"errors"
 	"net"
 	"os"
 	"path/filepath"
@@ -17,6 +18,7 @@ import (
 	"github.com/tinhnguyenhn/colxd/blockchain"
 	"github.com/tinhnguyenhn/colxd/chaincfg"
 	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/netsync"
 	"github.com/tinhnguyenhn/colxd/wire"
 	"github.com/tinhnguyenhn/colxutil"
 )
@@ -45,6 +47,15 @@ const (
 	// maxRequestedTxns is the maximum number of requested transactions
 	// shas to store in memory.
 	maxRequestedTxns = wire.MaxInvPerMsg
+
+	// minHeaderSkeletonLen is the minimum number of headers of a header
+	// skeleton for the headers up to the next checkpoint to be downloaded
+	// with a header skeleton sync rather than from the sync peer alone.
+	minHeaderSkeletonLen = 2
+
+	// headerSkeletonTickInterval is the interval at which the header
+	// skeleton sync checks for stalled requests.
+	headerSkeletonTickInterval = 5 * time.Second
 )
 
 // zeroHash is the zero value hash (all zeros).  It is defined as a convenience.
@@ -84,8 +95,9 @@ type donePeerMsg struct {
 // txMsg packages a bitcoin tx message and the peer it came from together
 // so the block handler has access to that information.
 type txMsg struct {
-	tx   *colxutil.Tx
-	peer *serverPeer
+	tx     *colxutil.Tx
+	txHash *wire.ShaHash
+	peer   *serverPeer
 }
 
 // getSyncPeerMsg is a message type to be sent across the message channel for
@@ -184,6 +196,38 @@ type blockManager struct {
 	headerList       *list.List
 	startHeader      *list.Element
 	nextCheckpoint   *chaincfg.Checkpoint
+
+	// skeletonSync downloads the headers up to the next checkpoint from
+	// multiple peers in parallel while in headers-first mode when the sync
+	// peer serves header skeletons.
+	skeletonSync *netsync.HeaderSkeletonSync
+}
+
+// headerListProcessor is a netsync.HeaderProcessor which validates the headers
+// downloaded by a header skeleton sync with the chain and adds them to the
+// list of headers whose blocks are fetched in headers-first mode.
+type headerListProcessor struct {
+	b *blockManager
+}
+
+// ProcessBlockHeaders validates the passed headers, which connect to the last
+// header of the header list, and adds them to the list.  This is part of the
+// netsync.HeaderProcessor interface implementation.
+func (p headerListProcessor) ProcessBlockHeaders(headers []*wire.BlockHeader) error {
+	b := p.b
+	if err := b.chain.ProcessBlockHeaders(headers); err != nil {
+		return err
+	}
+	for _, header := range headers {
+		blockHash := header.BlockSha()
+		prevNode := b.headerList.Back().Value.(*headerNode)
+		node := headerNode{height: prevNode.height + 1, sha: &blockHash}
+		e := b.headerList.PushBack(&node)
+		if b.startHeader == nil {
+			b.startHeader = e
+		}
+	}
+	return nil
 }
 
 // resetHeaderState sets the headers-first mode state to values appropriate for
@@ -192,6 +236,7 @@ func (b *blockManager) resetHeaderState(newestHash *wire.ShaHash, newestHeight i
 	b.headersFirstMode = false
 	b.headerList.Init()
 	b.startHeader = nil
+	b.skeletonSync = nil
 
 	// When there is a next checkpoint, add an entry for the latest known
 	// block into the header pool.  This allows the next downloaded header
@@ -230,7 +275,7 @@ func (b *blockManager) findNextHeaderCheckpoint(height int32) *chaincfg.Checkpoi
 	if cfg.DisableCheckpoints {
 		return nil
 	}
-	checkpoints := b.server.chainParams.Checkpoints
+	checkpoints := b.chain.Checkpoints()
 	if len(checkpoints) == 0 {
 		return nil
 	}
@@ -265,6 +310,7 @@ func (b *blockManager) startSync(peers *list.List) {
 
 	best := b.chain.BestSnapshot()
 	var bestPeer *serverPeer
+	var bestScore float64
 	var enext *list.Element
 	for e := peers.Front(); e != nil; e = enext {
 		enext = e.Next()
@@ -281,9 +327,13 @@ func (b *blockManager) startSync(peers *list.List) {
 			continue
 		}
 
-		// TODO(davec): Use a better algorithm to choose the best peer.
-		// For now, just pick the first available candidate.
-		bestPeer = sp
+		// Prefer the candidate with the highest quality score, keeping
+		// the earlier candidate on ties.
+		score := sp.Quality().Score
+		if bestPeer == nil || score > bestScore {
+			bestPeer = sp
+			bestScore = score
+		}
 	}
 
 	// Start syncing from the best peer if one was selected.
@@ -324,20 +374,96 @@ func (b *blockManager) startSync(peers *list.List) {
 			best.Height < b.nextCheckpoint.Height &&
 			!cfg.RegressionTest && !cfg.DisableCheckpoints {
 
-			bestPeer.PushGetHeadersMsg(locator, b.nextCheckpoint.Hash)
 			b.headersFirstMode = true
-			bmgrLog.Infof("Downloading headers for blocks %d to "+
-				"%d from peer %s", best.Height+1,
-				b.nextCheckpoint.Height, bestPeer.Addr())
+			if !b.startHeaderSkeletonSync(peers, bestPeer, best) {
+				bestPeer.PushGetHeadersMsg(locator,
+					b.nextCheckpoint.Hash)
+				bmgrLog.Infof("Downloading headers for blocks "+
+					"%d to %d from peer %s", best.Height+1,
+					b.nextCheckpoint.Height, bestPeer.Addr())
+			}
 		} else {
 			bestPeer.PushGetBlocksMsg(locator, &zeroHash)
 		}
 		b.syncPeer = bestPeer
+		atomic.StoreInt32(&bestPeer.isSyncPeer, 1)
 	} else {
 		bmgrLog.Warnf("No sync peer candidates available")
 	}
 }
 
+// startHeaderSkeletonSync starts downloading the headers up to the next
+// checkpoint with a header skeleton sync which requests the skeleton from the
+// passed sync peer and fills its gaps from all of the passed candidate peers
+// which have the headers.  It returns false when the sync peer does not serve
+// header skeletons or the next checkpoint is too close for a skeleton, in
+// which case the headers are downloaded from the sync peer alone.
+//
+// The skeleton ends before the next checkpoint, so the final headers,
+// including the checkpoint, are always downloaded from the sync peer once the
+// header skeleton sync is done and verified against the checkpoint as usual.
+func (b *blockManager) startHeaderSkeletonSync(peers *list.List, syncPeer *serverPeer, best *blockchain.BestState) bool {
+	if syncPeer.Services()&wire.SFNodeHeaderSkeleton == 0 {
+		return false
+	}
+	interval := netsync.DefaultHeaderSkeletonInterval
+	count := int(b.nextCheckpoint.Height-best.Height-1) / interval
+	if count < minHeaderSkeletonLen {
+		return false
+	}
+
+	now := time.Now()
+	b.skeletonSync = netsync.NewHeaderSkeletonSync(headerListProcessor{b},
+		best.Hash, interval, count, 0)
+	if err := b.skeletonSync.Start(syncPeer, now); err != nil {
+		b.skeletonSync = nil
+		return false
+	}
+	for e := peers.Front(); e != nil; e = e.Next() {
+		sp := e.Value.(*serverPeer)
+		if sp != syncPeer && sp.LastBlock() >= b.nextCheckpoint.Height {
+			b.skeletonSync.AddPeer(sp, now)
+		}
+	}
+	bmgrLog.Infof("Downloading a skeleton of the headers for blocks %d "+
+		"to %d from peer %s", best.Height+1,
+		best.Height+int32(count*interval), syncPeer.Addr())
+	return true
+}
+
+// finishHeaderSkeletonSync continues downloading the headers up to the next
+// checkpoint from the sync peer once the header skeleton sync is done.  When
+// the header skeleton sync failed, the headers following the ones it was able
+// to process are downloaded from the sync peer instead.  It does nothing while
+// the header skeleton sync is still running.
+func (b *blockManager) finishHeaderSkeletonSync() {
+	skeletonSync := b.skeletonSync
+	if skeletonSync == nil ||
+		(!skeletonSync.Done() && skeletonSync.Err() == nil) {
+		return
+	}
+	b.skeletonSync = nil
+
+	lastNode := b.headerList.Back().Value.(*headerNode)
+	if err := skeletonSync.Err(); err != nil {
+		bmgrLog.Warnf("Header skeleton sync failed after %d headers: %v",
+			b.headerList.Len()-1, err)
+	} else {
+		bmgrLog.Infof("Downloaded %d headers with the header skeleton "+
+			"sync", b.headerList.Len()-1)
+	}
+
+	locator := blockchain.BlockLocator([]*wire.ShaHash{lastNode.sha})
+	err := b.syncPeer.PushGetHeadersMsg(locator, b.nextCheckpoint.Hash)
+	if err != nil {
+		bmgrLog.Warnf("Failed to send getheaders message to peer %s: %v",
+			b.syncPeer.Addr(), err)
+		return
+	}
+	bmgrLog.Infof("Downloading headers for blocks %d to %d from peer %s",
+		lastNode.height+1, b.nextCheckpoint.Height, b.syncPeer.Addr())
+}
+
 // isSyncCandidate returns whether or not the peer is a candidate to consider
 // syncing from.
 func (b *blockManager) isSyncCandidate(sp *serverPeer) bool {
@@ -387,6 +513,15 @@ func (b *blockManager) handleNewPeerMsg(peers *list.List, sp *serverPeer) {
 
 	// Start syncing by choosing the best candidate if needed.
 	b.startSync(peers)
+
+	// Have the peer help with filling the gaps of the header skeleton when
+	// it has the headers.
+	if b.skeletonSync != nil && sp != b.syncPeer &&
+		sp.LastBlock() >= b.nextCheckpoint.Height {
+
+		b.skeletonSync.AddPeer(sp, time.Now())
+		b.finishHeaderSkeletonSync()
+	}
 }
 
 // handleDonePeerMsg deals with peers that have signalled they are done.  It
@@ -418,10 +553,19 @@ func (b *blockManager) handleDonePeerMsg(peers *list.List, sp *serverPeer) {
 		delete(b.requestedBlocks, k)
 	}
 
+	// Reassign the gap of the header skeleton the peer was filling, if
+	// any.  The header skeleton sync is restarted along with the rest of
+	// the headers-first state when the sync peer is lost.
+	if b.skeletonSync != nil && sp != b.syncPeer {
+		b.skeletonSync.RemovePeer(sp, time.Now())
+		b.finishHeaderSkeletonSync()
+	}
+
 	// Attempt to find a new peer to sync from if the quitting peer is the
 	// sync peer.  Also, reset the headers-first state if in headers-first
 	// mode so
 	if b.syncPeer != nil && b.syncPeer == sp {
+		atomic.StoreInt32(&sp.isSyncPeer, 0)
 		b.syncPeer = nil
 		if b.headersFirstMode {
 			best := b.chain.BestSnapshot()
@@ -441,7 +585,7 @@ func (b *blockManager) handleTxMsg(tmsg *txMsg) {
 	// spec to proliferate.  While this is not ideal, there is no check here
 	// to disconnect peers for sending unsolicited transactions to provide
 	// interoperability.
-	txHash := tmsg.tx.Sha()
+	txHash := tmsg.txHash
 
 	// Ignore transactions that we have already rejected.  Do not
 	// send a reject message here because if the transaction was already
@@ -456,7 +600,7 @@ func (b *blockManager) handleTxMsg(tmsg *txMsg) {
 	// memory pool, orphan handling, etc.
 	allowOrphans := cfg.MaxOrphanTxs > 0
 	acceptedTxs, err := b.server.txMemPool.ProcessTransaction(tmsg.tx,
-		allowOrphans, true)
+		allowOrphans, true, nil)
 
 	// Remove transaction from request maps. Either the mempool/chain
 	// already knows about it and as such we shouldn't have any more
@@ -571,7 +715,7 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 		// rejected as opposed to something actually going wrong, so log
 		// it as such.  Otherwise, something really did go wrong, so log
 		// it as an actual error.
-		if _, ok := err.(blockchain.RuleError); ok {
+		if errors.As(err, new(blockchain.RuleError)) {
 			bmgrLog.Infof("Rejected block %v from %s: %v", blockSha,
 				bmsg.peer, err)
 		} else {
@@ -655,10 +799,8 @@ func (b *blockManager) handleBlockMsg(bmsg *blockMsg) {
 		// Allow any clients performing long polling via the
 		// getblocktemplate RPC to be notified when the new block causes
 		// their old block template to become stale.
-		rpcServer := b.server.rpcServer
-		if rpcServer != nil {
-			rpcServer.gbtWorkState.NotifyBlockConnected(blockSha)
-		}
+		b.server.templateNotifier.NotifyBlockConnected(best.Hash,
+			b.server.txMemPool.TotalFees())
 	}
 
 	// Update the block height for this peer. But only send a message to
@@ -780,6 +922,24 @@ func (b *blockManager) handleHeadersMsg(hmsg *headersMsg) {
 		return
 	}
 
+	// Hand the headers to the header skeleton sync while it is running.
+	// This includes empty headers messages since they are the response to
+	// requests for empty skeletons.
+	if b.skeletonSync != nil {
+		b.skeletonSync.HandleHeaders(hmsg.peer, msg.Headers, time.Now())
+		b.finishHeaderSkeletonSync()
+		return
+	}
+
+	// Only the sync peer is asked for headers outside of the header
+	// skeleton sync, so ignore the late responses of peers which stalled
+	// while taking part in it.
+	if hmsg.peer != b.syncPeer {
+		bmgrLog.Debugf("Ignoring %d headers from non-sync peer %s",
+			numHeaders, hmsg.peer)
+		return
+	}
+
 	// Nothing to do for an empty headers message.
 	if numHeaders == 0 {
 		return
@@ -1091,6 +1251,8 @@ func (b *blockManager) limitMap(m map[wire.ShaHash]struct{}, limit int) {
 // the fetching should proceed.
 func (b *blockManager) blockHandler() {
 	candidatePeers := list.New()
+	skeletonTicker := time.NewTicker(headerSkeletonTickInterval)
+	defer skeletonTicker.Stop()
 out:
 	for {
 		select {
@@ -1138,10 +1300,8 @@ out:
 				// Allow any clients performing long polling via the
 				// getblocktemplate RPC to be notified when the new block causes
 				// their old block template to become stale.
-				rpcServer := b.server.rpcServer
-				if rpcServer != nil {
-					rpcServer.gbtWorkState.NotifyBlockConnected(msg.block.Sha())
-				}
+				b.server.templateNotifier.NotifyBlockConnected(
+					best.Hash, b.server.txMemPool.TotalFees())
 
 				msg.reply <- processBlockResponse{
 					isOrphan: isOrphan,
@@ -1160,6 +1320,12 @@ out:
 					"handler: %T", msg)
 			}
 
+		case <-skeletonTicker.C:
+			if b.skeletonSync != nil {
+				b.skeletonSync.Tick(time.Now())
+				b.finishHeaderSkeletonSync()
+			}
+
 		case <-b.quit:
 			break out
 		}
@@ -1200,6 +1366,10 @@ func (b *blockManager) handleNotifyMsg(notification *blockchain.Notification) {
 			break
 		}
 
+		// Count the transactions mined by the block for fee estimation
+		// before they are removed from the transaction pool.
+		b.server.feeEstimator.RegisterBlock(block)
+
 		// Remove all of the transactions (except the coinbase) in the
 		// connected block from the transaction pool.  Secondly, remove any
 		// transactions which are now double spends as a result of these
@@ -1211,19 +1381,13 @@ func (b *blockManager) handleNotifyMsg(notification *blockchain.Notification) {
 			b.server.txMemPool.RemoveTransaction(tx, false)
 			b.server.txMemPool.RemoveDoubleSpends(tx)
 			b.server.txMemPool.RemoveOrphan(tx.Sha())
-			acceptedTxs := b.server.txMemPool.ProcessOrphans(tx.Sha())
-			b.server.AnnounceNewTransactions(acceptedTxs)
+
+			// Any orphans accepted as a result are announced by the
+			// OnOrphansResolved callback of the pool.
+			b.server.txMemPool.ProcessOrphans(tx.Sha())
 		}
 
 		if r := b.server.rpcServer; r != nil {
-			// Now that this block is in the blockchain we can mark
-			// all the transactions (except the coinbase) as no
-			// longer needing rebroadcasting.
-			for _, tx := range block.Transactions()[1:] {
-				iv := wire.NewInvVect(wire.InvTypeTx, tx.Sha())
-				b.server.RemoveRebroadcastInventory(iv)
-			}
-
 			// Notify registered websocket clients of incoming block.
 			r.ntfnMgr.NotifyBlockConnected(block)
 		}
@@ -1236,6 +1400,11 @@ func (b *blockManager) handleNotifyMsg(notification *blockchain.Notification) {
 			break
 		}
 
+		// Return the transactions mined by the block to the fee
+		// estimator before they are reinserted into the transaction
+		// pool so they keep the height they were first seen at.
+		b.server.feeEstimator.RollbackBlock(block)
+
 		// Reinsert all of the transactions (except the coinbase) into
 		// the transaction pool.
 		for _, tx := range block.Transactions()[1:] {
@@ -1253,6 +1422,67 @@ func (b *blockManager) handleNotifyMsg(notification *blockchain.Notification) {
 		if r := b.server.rpcServer; r != nil {
 			r.ntfnMgr.NotifyBlockDisconnected(block)
 		}
+
+	// The main chain is being reorganized.  The individual blocks are
+	// handled by the disconnected and connected notifications which follow.
+	case blockchain.NTChainReorg:
+		reorg, ok := notification.Data.(*blockchain.ReorgData)
+		if !ok {
+			bmgrLog.Warnf("Chain reorganize notification is not reorg " +
+				"data.")
+			break
+		}
+
+		bmgrLog.Debugf("Chain reorganizing from %v (height %d) to %v "+
+			"(height %d) -- %d blocks detached, %d blocks attached",
+			reorg.OldTip, reorg.OldHeight, reorg.NewTip,
+			reorg.NewHeight, len(reorg.Detached),
+			len(reorg.Attached))
+
+		// Notify the notifiers registered with the RPC server.
+		if r := b.server.rpcServer; r != nil {
+			r.ntfnMgr.NotifyChainReorg(reorg)
+		}
+
+	// An orphan block was evicted from the orphan pool.  It is no longer
+	// known to the chain, so it is requested again when it is announced.
+	case blockchain.NTOrphanEvicted:
+		eviction, ok := notification.Data.(*blockchain.OrphanEviction)
+		if !ok {
+			bmgrLog.Warnf("Orphan evicted notification is not an " +
+				"orphan eviction.")
+			break
+		}
+
+		bmgrLog.Debugf("Orphan block %v evicted (%v)",
+			eviction.Block.Sha(), eviction.Reason)
+
+	// A block which was held because its timestamp was too far in the
+	// future is ready to be processed again.  The notification is sent
+	// from the goroutine of the timer which released the block, so the
+	// block is queued to be processed by the block handler like the
+	// blocks submitted via RPC.
+	case blockchain.NTFutureBlockReady:
+		release, ok := notification.Data.(*blockchain.FutureBlockRelease)
+		if !ok {
+			bmgrLog.Warnf("Future block ready notification is not " +
+				"a future block release.")
+			break
+		}
+
+		// Ignore if we are shutting down.
+		if atomic.LoadInt32(&b.shutdown) != 0 {
+			break
+		}
+
+		blockSha := release.Block.Sha()
+		_, err := b.ProcessBlock(release.Block, release.Flags)
+		if err != nil {
+			bmgrLog.Infof("Discarding held future block %v: %v",
+				blockSha, err)
+			break
+		}
+		bmgrLog.Debugf("Processed held future block %v", blockSha)
 	}
 }
 
@@ -1266,15 +1496,16 @@ func (b *blockManager) NewPeer(sp *serverPeer) {
 }
 
 // QueueTx adds the passed transaction message and peer to the block handling
-// queue.
-func (b *blockManager) QueueTx(tx *colxutil.Tx, sp *serverPeer) {
+// queue.  The passed hash of the transaction is used to look up previously
+// rejected transactions without hashing the transaction again.
+func (b *blockManager) QueueTx(tx *colxutil.Tx, txHash *wire.ShaHash, sp *serverPeer) {
 	// Don't accept more transactions if we're shutting down.
 	if atomic.LoadInt32(&b.shutdown) != 0 {
 		sp.txProcessed <- struct{}{}
 		return
 	}
 
-	b.msgChan <- &txMsg{tx: tx, peer: sp}
+	b.msgChan <- &txMsg{tx: tx, txHash: txHash, peer: sp}
 }
 
 // QueueBlock adds the passed block message and peer to the block handling queue.
@@ -1343,6 +1574,7 @@ func (b *blockManager) Stop() error {
 	}
 
 	bmgrLog.Infof("Block manager shutting down")
+	b.chain.StopFutureBlocks()
 	close(b.quit)
 	b.wg.Wait()
 	return nil
@@ -1384,8 +1616,10 @@ func (b *blockManager) Pause() chan<- struct{} {
 }
 
 // newBlockManager returns a new bitcoin block manager.
-// Use Start to begin processing asynchronous block and inv updates.
-func newBlockManager(s *server, indexManager blockchain.IndexManager) (*blockManager, error) {
+// Use Start to begin processing asynchronous block and inv updates.  Closing
+// the passed interrupt channel stops the optional indexes from catching up
+// while the chain is being loaded.
+func newBlockManager(s *server, indexManager blockchain.IndexManager, interrupt <-chan struct{}) (*blockManager, error) {
 	bm := blockManager{
 		server:          s,
 		rejectedTxns:    make(map[wire.ShaHash]struct{}),
@@ -1400,12 +1634,17 @@ func newBlockManager(s *server, indexManager blockchain.IndexManager) (*blockMan
 	// Create a new block chain instance with the appropriate configuration.
 	var err error
 	bm.chain, err = blockchain.New(&blockchain.Config{
-		DB:            s.db,
-		ChainParams:   s.chainParams,
-		TimeSource:    s.timeSource,
-		Notifications: bm.handleNotifyMsg,
-		SigCache:      s.sigCache,
-		IndexManager:  indexManager,
+		DB:                    s.db,
+		ChainParams:           s.chainParams,
+		TimeSource:            s.timeSource,
+		Notifications:         bm.handleNotifyMsg,
+		SigCache:              s.sigCache,
+		IndexManager:          indexManager,
+		Interrupt:             interrupt,
+		PruneTarget:           cfg.Prune,
+		AssumeValid:           cfg.assumeValid,
+		StoreInvalidBlocks:    cfg.StoreInvalidBlocks,
+		AdditionalCheckpoints: cfg.addCheckpoints,
 	})
 	if err != nil {
 		return nil, err
@@ -0,0 +1,152 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/blockchain/chaingen"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/netsync"
+	"github.com/tinhnguyenhn/colxd/peer"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// TestHeaderSkeletonSyncHeadersFirst ensures the headers downloaded by a header
+// skeleton sync in headers-first mode are validated and added to the header
+// list in order, that headers from peers which are not taking part in it are
+// ignored, and that the final headers up to the checkpoint are then accepted
+// from the sync peer so the blocks are fetched.
+func TestHeaderSkeletonSyncHeadersFirst(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	dbPath, err := ioutil.TempDir("", "bmgrtest")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dbPath)
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		params.Net)
+	if err != nil {
+		t.Fatalf("unable to create db: %v", err)
+	}
+	defer db.Close()
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: params,
+		TimeSource:  blockchain.NewMedianTime(),
+	})
+	if err != nil {
+		t.Fatalf("unable to create chain: %v", err)
+	}
+
+	// Generate the headers of two gaps of a skeleton along with the final
+	// header, which is the checkpoint.
+	const interval = 5
+	g := chaingen.NewGenerator(params)
+	headers := make([]*wire.BlockHeader, 0, 2*interval+1)
+	for i := 1; i <= 2*interval+1; i++ {
+		block := g.NextBlock(fmt.Sprintf("b%d", i), nil)
+		headers = append(headers, &block.Header)
+	}
+	checkpointHash := headers[2*interval].BlockSha()
+
+	newPeer := func(addr string) *serverPeer {
+		p, err := peer.NewOutboundPeer(&peer.Config{}, addr)
+		if err != nil {
+			t.Fatalf("NewOutboundPeer: unexpected error: %v", err)
+		}
+		return &serverPeer{
+			Peer:            p,
+			requestedBlocks: make(map[wire.ShaHash]struct{}),
+		}
+	}
+	syncPeer := newPeer("10.0.0.1:8333")
+	gapPeer := newPeer("10.0.0.2:8333")
+	otherPeer := newPeer("10.0.0.3:8333")
+
+	b := &blockManager{
+		chain:           chain,
+		requestedBlocks: make(map[wire.ShaHash]struct{}),
+		progressLogger:  newBlockProgressLogger("Processed", bmgrLog),
+		syncPeer:        syncPeer,
+		headerList:      list.New(),
+		nextCheckpoint: &chaincfg.Checkpoint{
+			Height: 2*interval + 1,
+			Hash:   &checkpointHash,
+		},
+	}
+	b.resetHeaderState(params.GenesisHash, 0)
+	b.headersFirstMode = true
+	now := time.Now()
+	b.skeletonSync = netsync.NewHeaderSkeletonSync(headerListProcessor{b},
+		params.GenesisHash, interval, 2, 0)
+	if err := b.skeletonSync.Start(syncPeer, now); err != nil {
+		t.Fatalf("Start: unexpected error: %v", err)
+	}
+	b.skeletonSync.AddPeer(gapPeer, now)
+
+	handleHeaders := func(sp *serverPeer, headers []*wire.BlockHeader) {
+		msg := wire.NewMsgHeaders()
+		for _, header := range headers {
+			msg.AddBlockHeader(header)
+		}
+		b.handleHeadersMsg(&headersMsg{headers: msg, peer: sp})
+	}
+	checkHeaderList := func(wantLen int) {
+		if b.headerList.Len() != wantLen {
+			t.Fatalf("unexpected header list length - got %d, "+
+				"want %d", b.headerList.Len(), wantLen)
+		}
+	}
+
+	// The skeleton assigns the first gap to the sync peer and the second
+	// one to the other peer taking part in the sync.  The second gap is
+	// not processed before the first one is.
+	handleHeaders(syncPeer, []*wire.BlockHeader{headers[interval-1],
+		headers[2*interval-1]})
+	handleHeaders(otherPeer, headers[:interval])
+	checkHeaderList(1)
+	handleHeaders(gapPeer, headers[interval:2*interval])
+	checkHeaderList(1)
+	handleHeaders(syncPeer, headers[:interval])
+	if b.skeletonSync != nil {
+		t.Fatalf("header skeleton sync not finished")
+	}
+	checkHeaderList(2*interval + 1)
+	for e, height := b.headerList.Front(), int32(0); e != nil; e, height =
+		e.Next(), height+1 {
+
+		node := e.Value.(*headerNode)
+		if node.height != height {
+			t.Fatalf("unexpected header height - got %d, want %d",
+				node.height, height)
+		}
+	}
+	if _, height := chain.BestHeader(); height != 2*interval {
+		t.Fatalf("unexpected best header height - got %d, want %d",
+			height, 2*interval)
+	}
+
+	// Late headers from peers other than the sync peer are ignored while
+	// the final header from the sync peer verifies the checkpoint and
+	// starts fetching the blocks.
+	handleHeaders(gapPeer, headers[2*interval:])
+	checkHeaderList(2*interval + 1)
+	handleHeaders(syncPeer, headers[2*interval:])
+	checkHeaderList(2*interval + 1)
+	if len(b.requestedBlocks) != 2*interval+1 {
+		t.Fatalf("unexpected number of requested blocks - got %d, "+
+			"want %d", len(b.requestedBlocks), 2*interval+1)
+	}
+}
@@ -90,12 +90,53 @@ func btcdMain(serverChan chan<- *server) error {
 		db.Close()
 	})
 
+	// Stop long running startup work, such as dropping or catching up the
+	// optional indexes, on Ctrl+C.  Since interrupt handlers run in LIFO
+	// order, this happens before the database is closed.
+	interrupt := make(chan struct{})
+	addInterruptHandler(func() {
+		close(interrupt)
+	})
+
 	// Drop indexes and exit if requested.
 	//
 	// NOTE: The order is important here because dropping the tx index also
-	// drops the address index since it relies on it.
+	// drops the address index, the unspent outputs by script index, and the
+	// committed filter index since they rely on it.
+	if cfg.DropCFIndex {
+		if err := indexers.DropCfIndex(db, interrupt); err != nil {
+			btcdLog.Errorf("%v", err)
+			return err
+		}
+
+		return nil
+	}
+	if cfg.DropSpendIndex {
+		if err := indexers.DropSpendIndex(db, interrupt); err != nil {
+			btcdLog.Errorf("%v", err)
+			return err
+		}
+
+		return nil
+	}
+	if cfg.DropTimeIndex {
+		if err := indexers.DropTimeIndex(db, interrupt); err != nil {
+			btcdLog.Errorf("%v", err)
+			return err
+		}
+
+		return nil
+	}
+	if cfg.DropUtxoByScript {
+		if err := indexers.DropUtxoByScriptIndex(db, interrupt); err != nil {
+			btcdLog.Errorf("%v", err)
+			return err
+		}
+
+		return nil
+	}
 	if cfg.DropAddrIndex {
-		if err := indexers.DropAddrIndex(db); err != nil {
+		if err := indexers.DropAddrIndex(db, interrupt); err != nil {
 			btcdLog.Errorf("%v", err)
 			return err
 		}
@@ -103,7 +144,7 @@ func btcdMain(serverChan chan<- *server) error {
 		return nil
 	}
 	if cfg.DropTxIndex {
-		if err := indexers.DropTxIndex(db); err != nil {
+		if err := indexers.DropTxIndex(db, interrupt); err != nil {
 			btcdLog.Errorf("%v", err)
 			return err
 		}
@@ -112,8 +153,14 @@ func btcdMain(serverChan chan<- *server) error {
 	}
 
 	// Create server and start it.
-	server, err := newServer(cfg.Listeners, db, activeNetParams.Params)
+	server, err := newServer(cfg.Listeners, db, activeNetParams.Params,
+		interrupt)
 	if err != nil {
+		// Nothing more to do when the server creation was interrupted.
+		if interruptRequested(interrupt) {
+			return nil
+		}
+
 		// TODO(oga) this logging could do with some beautifying.
 		btcdLog.Errorf("Unable to start server on %v: %v",
 			cfg.Listeners, err)
@@ -136,6 +136,17 @@ func TestBtcdExtCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"getcurrentnet","params":[],"id":1}`,
 			unmarshalled: &btcjson.GetCurrentNetCmd{},
 		},
+		{
+			name: "version",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("version")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewVersionCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"version","params":[],"id":1}`,
+			unmarshalled: &btcjson.VersionCmd{},
+		},
 	}
 
 	t.Logf("Running %d tests", len(tests))
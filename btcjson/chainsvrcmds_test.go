@@ -128,6 +128,79 @@ func TestChainSvrCmds(t *testing.T) {
 				Node: btcjson.String("127.0.0.1"),
 			},
 		},
+		{
+			name: "getaddressbalance",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getaddressbalance", []string{"1Address"})
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetAddressBalanceCmd([]string{"1Address"})
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getaddressbalance","params":[["1Address"]],"id":1}`,
+			unmarshalled: &btcjson.GetAddressBalanceCmd{
+				Addresses: []string{"1Address"},
+			},
+		},
+		{
+			name: "getaddressdeltas",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getaddressdeltas", []string{"1Address", "1Other"})
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetAddressDeltasCmd([]string{"1Address", "1Other"}, nil, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getaddressdeltas","params":[["1Address","1Other"]],"id":1}`,
+			unmarshalled: &btcjson.GetAddressDeltasCmd{
+				Addresses: []string{"1Address", "1Other"},
+			},
+		},
+		{
+			name: "getaddressdeltas optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getaddressdeltas", []string{"1Address"}, 10, 20)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetAddressDeltasCmd([]string{"1Address"},
+					btcjson.Int32(10), btcjson.Int32(20))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getaddressdeltas","params":[["1Address"],10,20],"id":1}`,
+			unmarshalled: &btcjson.GetAddressDeltasCmd{
+				Addresses: []string{"1Address"},
+				Start:     btcjson.Int32(10),
+				End:       btcjson.Int32(20),
+			},
+		},
+		{
+			name: "getaddressutxos",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getaddressutxos", []string{"1Address"})
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetAddressUtxosCmd([]string{"1Address"}, nil, nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getaddressutxos","params":[["1Address"]],"id":1}`,
+			unmarshalled: &btcjson.GetAddressUtxosCmd{
+				Addresses: []string{"1Address"},
+				Skip:      btcjson.Int(0),
+				Count:     btcjson.Int(100),
+			},
+		},
+		{
+			name: "getaddressutxos optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getaddressutxos", []string{"1Address"}, 5, 10)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetAddressUtxosCmd([]string{"1Address"},
+					btcjson.Int(5), btcjson.Int(10))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getaddressutxos","params":[["1Address"],5,10],"id":1}`,
+			unmarshalled: &btcjson.GetAddressUtxosCmd{
+				Addresses: []string{"1Address"},
+				Skip:      btcjson.Int(5),
+				Count:     btcjson.Int(10),
+			},
+		},
 		{
 			name: "getbestblockhash",
 			newCmd: func() (interface{}, error) {
@@ -384,16 +457,44 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"getinfo","params":[],"id":1}`,
 			unmarshalled: &btcjson.GetInfoCmd{},
 		},
+		{
+			name: "getmempoolentry",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getmempoolentry", "123")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetMempoolEntryCmd("123")
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getmempoolentry","params":["123"],"id":1}`,
+			unmarshalled: &btcjson.GetMempoolEntryCmd{
+				TxID: "123",
+			},
+		},
 		{
 			name: "getmempoolinfo",
 			newCmd: func() (interface{}, error) {
 				return btcjson.NewCmd("getmempoolinfo")
 			},
 			staticCmd: func() interface{} {
-				return btcjson.NewGetMempoolInfoCmd()
+				return btcjson.NewGetMempoolInfoCmd(nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getmempoolinfo","params":[],"id":1}`,
+			unmarshalled: &btcjson.GetMempoolInfoCmd{
+				Verbose: btcjson.Bool(false),
+			},
+		},
+		{
+			name: "getmempoolinfo optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getmempoolinfo", true)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetMempoolInfoCmd(btcjson.Bool(true))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"getmempoolinfo","params":[true],"id":1}`,
+			unmarshalled: &btcjson.GetMempoolInfoCmd{
+				Verbose: btcjson.Bool(true),
 			},
-			marshalled:   `{"jsonrpc":"1.0","method":"getmempoolinfo","params":[],"id":1}`,
-			unmarshalled: &btcjson.GetMempoolInfoCmd{},
 		},
 		{
 			name: "getmininginfo",
@@ -521,6 +622,17 @@ func TestChainSvrCmds(t *testing.T) {
 				Verbose: btcjson.Int(0),
 			},
 		},
+		{
+			name: "getrpcinfo",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("getrpcinfo")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewGetRPCInfoCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"getrpcinfo","params":[],"id":1}`,
+			unmarshalled: &btcjson.GetRPCInfoCmd{},
+		},
 		{
 			name: "getrawtransaction optional",
 			newCmd: func() (interface{}, error) {
@@ -658,6 +770,34 @@ func TestChainSvrCmds(t *testing.T) {
 				Command: btcjson.String("getblock"),
 			},
 		},
+		{
+			name: "importmempool",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("importmempool", "/tmp/mempool.dat")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewImportMempoolCmd("/tmp/mempool.dat", nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"importmempool","params":["/tmp/mempool.dat"],"id":1}`,
+			unmarshalled: &btcjson.ImportMempoolCmd{
+				FilePath:      "/tmp/mempool.dat",
+				SkipFeeLimits: btcjson.Bool(false),
+			},
+		},
+		{
+			name: "importmempool optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("importmempool", "/tmp/mempool.dat", true)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewImportMempoolCmd("/tmp/mempool.dat", btcjson.Bool(true))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"importmempool","params":["/tmp/mempool.dat",true],"id":1}`,
+			unmarshalled: &btcjson.ImportMempoolCmd{
+				FilePath:      "/tmp/mempool.dat",
+				SkipFeeLimits: btcjson.Bool(true),
+			},
+		},
 		{
 			name: "invalidateblock",
 			newCmd: func() (interface{}, error) {
@@ -682,6 +822,33 @@ func TestChainSvrCmds(t *testing.T) {
 			marshalled:   `{"jsonrpc":"1.0","method":"ping","params":[],"id":1}`,
 			unmarshalled: &btcjson.PingCmd{},
 		},
+		{
+			name: "preciousblock",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("preciousblock", "123")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewPreciousBlockCmd("123")
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"preciousblock","params":["123"],"id":1}`,
+			unmarshalled: &btcjson.PreciousBlockCmd{
+				BlockHash: "123",
+			},
+		},
+		{
+			name: "prioritisetransaction",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("prioritisetransaction", "123", -1000)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewPrioritiseTransactionCmd("123", -1000)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"prioritisetransaction","params":["123",-1000],"id":1}`,
+			unmarshalled: &btcjson.PrioritiseTransactionCmd{
+				TxID:     "123",
+				FeeDelta: -1000,
+			},
+		},
 		{
 			name: "reconsiderblock",
 			newCmd: func() (interface{}, error) {
@@ -695,6 +862,47 @@ func TestChainSvrCmds(t *testing.T) {
 				BlockHash: "123",
 			},
 		},
+		{
+			name: "savemempool",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("savemempool")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewSaveMempoolCmd()
+			},
+			marshalled:   `{"jsonrpc":"1.0","method":"savemempool","params":[],"id":1}`,
+			unmarshalled: &btcjson.SaveMempoolCmd{},
+		},
+		{
+			name: "scantxoutset",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("scantxoutset", "status")
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewScanTxOutSetCmd("status", nil)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"scantxoutset","params":["status"],"id":1}`,
+			unmarshalled: &btcjson.ScanTxOutSetCmd{
+				Action:      "status",
+				ScanObjects: nil,
+			},
+		},
+		{
+			name: "scantxoutset optional",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("scantxoutset", "start",
+					`["addr(1Address)","raw(51)"]`)
+			},
+			staticCmd: func() interface{} {
+				objects := []string{"addr(1Address)", "raw(51)"}
+				return btcjson.NewScanTxOutSetCmd("start", &objects)
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"scantxoutset","params":["start",["addr(1Address)","raw(51)"]],"id":1}`,
+			unmarshalled: &btcjson.ScanTxOutSetCmd{
+				Action:      "start",
+				ScanObjects: &[]string{"addr(1Address)", "raw(51)"},
+			},
+		},
 		{
 			name: "searchrawtransactions",
 			newCmd: func() (interface{}, error) {
@@ -840,12 +1048,14 @@ func TestChainSvrCmds(t *testing.T) {
 				return btcjson.NewCmd("sendrawtransaction", "1122")
 			},
 			staticCmd: func() interface{} {
-				return btcjson.NewSendRawTransactionCmd("1122", nil)
+				return btcjson.NewSendRawTransactionCmd("1122", nil, nil, nil)
 			},
 			marshalled: `{"jsonrpc":"1.0","method":"sendrawtransaction","params":["1122"],"id":1}`,
 			unmarshalled: &btcjson.SendRawTransactionCmd{
 				HexTx:         "1122",
 				AllowHighFees: btcjson.Bool(false),
+				AcceptNonStd:  btcjson.Bool(false),
+				SkipFeeLimits: btcjson.Bool(false),
 			},
 		},
 		{
@@ -854,12 +1064,31 @@ func TestChainSvrCmds(t *testing.T) {
 				return btcjson.NewCmd("sendrawtransaction", "1122", false)
 			},
 			staticCmd: func() interface{} {
-				return btcjson.NewSendRawTransactionCmd("1122", btcjson.Bool(false))
+				return btcjson.NewSendRawTransactionCmd("1122", btcjson.Bool(false), nil, nil)
 			},
 			marshalled: `{"jsonrpc":"1.0","method":"sendrawtransaction","params":["1122",false],"id":1}`,
 			unmarshalled: &btcjson.SendRawTransactionCmd{
 				HexTx:         "1122",
 				AllowHighFees: btcjson.Bool(false),
+				AcceptNonStd:  btcjson.Bool(false),
+				SkipFeeLimits: btcjson.Bool(false),
+			},
+		},
+		{
+			name: "sendrawtransaction local policy",
+			newCmd: func() (interface{}, error) {
+				return btcjson.NewCmd("sendrawtransaction", "1122", true, true, true)
+			},
+			staticCmd: func() interface{} {
+				return btcjson.NewSendRawTransactionCmd("1122", btcjson.Bool(true),
+					btcjson.Bool(true), btcjson.Bool(true))
+			},
+			marshalled: `{"jsonrpc":"1.0","method":"sendrawtransaction","params":["1122",true,true,true],"id":1}`,
+			unmarshalled: &btcjson.SendRawTransactionCmd{
+				HexTx:         "1122",
+				AllowHighFees: btcjson.Bool(true),
+				AcceptNonStd:  btcjson.Bool(true),
+				SkipFeeLimits: btcjson.Bool(true),
 			},
 		},
 		{
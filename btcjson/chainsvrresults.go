@@ -12,14 +12,16 @@ import "encoding/json"
 // returns a hex-encoded string.
 type GetBlockHeaderVerboseResult struct {
 	Hash          string  `json:"hash"`
-	Confirmations uint64  `json:"confirmations"`
+	Confirmations int64   `json:"confirmations"`
 	Height        int32   `json:"height"`
 	Version       int32   `json:"version"`
 	MerkleRoot    string  `json:"merkleroot"`
 	Time          int64   `json:"time"`
+	MedianTime    int64   `json:"mediantime"`
 	Nonce         uint64  `json:"nonce"`
 	Bits          string  `json:"bits"`
 	Difficulty    float64 `json:"difficulty"`
+	ChainWork     string  `json:"chainwork"`
 	PreviousHash  string  `json:"previousblockhash,omitempty"`
 	NextHash      string  `json:"nextblockhash,omitempty"`
 }
@@ -29,7 +31,7 @@ type GetBlockHeaderVerboseResult struct {
 // hex-encoded string.
 type GetBlockVerboseResult struct {
 	Hash          string        `json:"hash"`
-	Confirmations uint64        `json:"confirmations"`
+	Confirmations int64         `json:"confirmations"`
 	Size          int32         `json:"size"`
 	Height        int64         `json:"height"`
 	Version       int32         `json:"version"`
@@ -37,9 +39,11 @@ type GetBlockVerboseResult struct {
 	Tx            []string      `json:"tx,omitempty"`
 	RawTx         []TxRawResult `json:"rawtx,omitempty"`
 	Time          int64         `json:"time"`
+	MedianTime    int64         `json:"mediantime"`
 	Nonce         uint32        `json:"nonce"`
 	Bits          string        `json:"bits"`
 	Difficulty    float64       `json:"difficulty"`
+	ChainWork     string        `json:"chainwork"`
 	PreviousHash  string        `json:"previousblockhash"`
 	NextHash      string        `json:"nextblockhash,omitempty"`
 }
@@ -74,6 +78,56 @@ type GetAddedNodeInfoResult struct {
 	Addresses *[]GetAddedNodeInfoResultAddr `json:"addresses,omitempty"`
 }
 
+// GetAddressBalanceResult models the data returned from the getaddressbalance
+// command.  The amounts are in satoshis and reflect the main chain ending with
+// the block identified by the hash and height.
+type GetAddressBalanceResult struct {
+	Balance  int64  `json:"balance"`
+	Received int64  `json:"received"`
+	Hash     string `json:"hash"`
+	Height   int32  `json:"height"`
+}
+
+// AddressDeltaResult models a credit to or a debit from an address as
+// returned by the getaddressdeltas command.  Debits have a negative amount and
+// the index of the spending input while credits have the index of the output.
+type AddressDeltaResult struct {
+	Address  string `json:"address"`
+	TxID     string `json:"txid"`
+	Index    uint32 `json:"index"`
+	Satoshis int64  `json:"satoshis"`
+	Height   int32  `json:"height"`
+}
+
+// GetAddressDeltasResult models the data returned from the getaddressdeltas
+// command.
+type GetAddressDeltasResult struct {
+	Deltas []AddressDeltaResult `json:"deltas"`
+	Hash   string               `json:"hash"`
+	Height int32                `json:"height"`
+}
+
+// AddressUtxoResult models an unspent output paying to an address as returned
+// by the getaddressutxos command.
+type AddressUtxoResult struct {
+	Address     string `json:"address"`
+	TxID        string `json:"txid"`
+	OutputIndex uint32 `json:"outputIndex"`
+	Script      string `json:"script"`
+	Satoshis    int64  `json:"satoshis"`
+	Height      int32  `json:"height"`
+	Coinbase    bool   `json:"coinbase"`
+}
+
+// GetAddressUtxosResult models the data returned from the getaddressutxos
+// command.  Total is the number of unspent outputs before pagination.
+type GetAddressUtxosResult struct {
+	Utxos  []AddressUtxoResult `json:"utxos"`
+	Total  int                 `json:"total"`
+	Hash   string              `json:"hash"`
+	Height int32               `json:"height"`
+}
+
 // GetBlockChainInfoResult models the data returned from the getblockchaininfo
 // command.
 type GetBlockChainInfoResult struct {
@@ -143,8 +197,47 @@ type GetBlockTemplateResult struct {
 // GetMempoolInfoResult models the data returned from the getmempoolinfo
 // command.
 type GetMempoolInfoResult struct {
-	Size  int64 `json:"size"`
-	Bytes int64 `json:"bytes"`
+	Size             int64   `json:"size"`
+	Bytes            int64   `json:"bytes"`
+	Usage            int64   `json:"usage"`
+	MaxMempool       int64   `json:"maxmempool"`
+	MempoolMinFee    float64 `json:"mempoolminfee"`
+	UnbroadcastCount int64   `json:"unbroadcastcount"`
+
+	// AcceptanceStats is only set when the verbose flag is set.
+	AcceptanceStats *MempoolAcceptanceStats `json:"acceptancestats,omitempty"`
+}
+
+// MempoolAcceptanceStats models the statistics about the transactions which
+// have been considered for acceptance into the mempool as returned by the
+// verbose getmempoolinfo command.
+type MempoolAcceptanceStats struct {
+	Processed uint64                   `json:"processed"`
+	Accepted  uint64                   `json:"accepted"`
+	Orphans   uint64                   `json:"orphans"`
+	Rejected  uint64                   `json:"rejected"`
+	Stages    []MempoolAcceptanceStage `json:"stages"`
+}
+
+// MempoolAcceptanceStage models the statistics for a single stage of the
+// mempool transaction acceptance pipeline.
+type MempoolAcceptanceStage struct {
+	Name     string  `json:"name"`
+	Reached  uint64  `json:"reached"`
+	Rejected uint64  `json:"rejected"`
+	AvgTime  float64 `json:"avgtime"`
+}
+
+// ImportMempoolResult models the data returned from the importmempool
+// command.
+type ImportMempoolResult struct {
+	Accepted int64 `json:"accepted"`
+	Skipped  int64 `json:"skipped"`
+}
+
+// SaveMempoolResult models the data returned from the savemempool command.
+type SaveMempoolResult struct {
+	Filename string `json:"filename"`
 }
 
 // GetNetworkInfoResult models the data returned from the getnetworkinfo
@@ -159,40 +252,66 @@ type GetNetworkInfoResult struct {
 	LocalAddresses  []LocalAddressesResult `json:"localaddresses"`
 }
 
+// PingPercentilesResult models the percentiles of the recent ping round trip
+// times of a peer returned as part of the getpeerinfo command.
+type PingPercentilesResult struct {
+	P50 float64 `json:"p50"`
+	P90 float64 `json:"p90"`
+	P99 float64 `json:"p99"`
+}
+
+// PeerQualityResult models the quality score of a peer along with the
+// components it is made of returned as part of the getpeerinfo command.
+type PeerQualityResult struct {
+	Score      float64 `json:"score"`
+	Latency    float64 `json:"latency"`
+	Usefulness float64 `json:"usefulness"`
+	Stalls     float64 `json:"stalls"`
+	Behavior   float64 `json:"behavior"`
+}
+
 // GetPeerInfoResult models the data returned from the getpeerinfo command.
 type GetPeerInfoResult struct {
-	ID             int32   `json:"id"`
-	Addr           string  `json:"addr"`
-	AddrLocal      string  `json:"addrlocal,omitempty"`
-	Services       string  `json:"services"`
-	LastSend       int64   `json:"lastsend"`
-	LastRecv       int64   `json:"lastrecv"`
-	BytesSent      uint64  `json:"bytessent"`
-	BytesRecv      uint64  `json:"bytesrecv"`
-	ConnTime       int64   `json:"conntime"`
-	TimeOffset     int64   `json:"timeoffset"`
-	PingTime       float64 `json:"pingtime"`
-	PingWait       float64 `json:"pingwait,omitempty"`
-	Version        uint32  `json:"version"`
-	SubVer         string  `json:"subver"`
-	Inbound        bool    `json:"inbound"`
-	StartingHeight int32   `json:"startingheight"`
-	CurrentHeight  int32   `json:"currentheight,omitempty"`
-	BanScore       int32   `json:"banscore"`
-	SyncNode       bool    `json:"syncnode"`
+	ID              int32                  `json:"id"`
+	Addr            string                 `json:"addr"`
+	AddrLocal       string                 `json:"addrlocal,omitempty"`
+	Services        string                 `json:"services"`
+	LastSend        int64                  `json:"lastsend"`
+	LastRecv        int64                  `json:"lastrecv"`
+	BytesSent       uint64                 `json:"bytessent"`
+	BytesRecv       uint64                 `json:"bytesrecv"`
+	BytesSentPerMsg map[string]uint64      `json:"bytessent_per_msg"`
+	BytesRecvPerMsg map[string]uint64      `json:"bytesrecv_per_msg"`
+	ConnTime        int64                  `json:"conntime"`
+	TimeOffset      int64                  `json:"timeoffset"`
+	PingTime        float64                `json:"pingtime"`
+	PingWait        float64                `json:"pingwait,omitempty"`
+	PingPercentiles *PingPercentilesResult `json:"pingpercentiles,omitempty"`
+	Version         uint32                 `json:"version"`
+	SubVer          string                 `json:"subver"`
+	Inbound         bool                   `json:"inbound"`
+	StartingHeight  int32                  `json:"startingheight"`
+	CurrentHeight   int32                  `json:"currentheight,omitempty"`
+	BanScore        int32                  `json:"banscore"`
+	StallCount      uint32                 `json:"stallcount"`
+	Quality         *PeerQualityResult     `json:"quality"`
+	SyncNode        bool                   `json:"syncnode"`
 }
 
 // GetRawMempoolVerboseResult models the data returned from the getrawmempool
 // command when the verbose flag is set.  When the verbose flag is not set,
-// getrawmempool returns an array of transaction hashes.
+// getrawmempool returns an array of transaction hashes.  It is also the data
+// returned from the getmempoolentry command.
 type GetRawMempoolVerboseResult struct {
 	Size             int32    `json:"size"`
 	Fee              float64  `json:"fee"`
+	ModifiedFee      float64  `json:"modifiedfee"`
 	Time             int64    `json:"time"`
 	Height           int64    `json:"height"`
 	StartingPriority float64  `json:"startingpriority"`
 	CurrentPriority  float64  `json:"currentpriority"`
 	Depends          []string `json:"depends"`
+	Unbroadcast      bool     `json:"unbroadcast"`
 }
 
 // ScriptPubKeyResult models the scriptPubKey data of a tx script.  It is
@@ -215,6 +334,17 @@ type GetTxOutResult struct {
 	Coinbase      bool               `json:"coinbase"`
 }
 
+// GetTxOutSetInfoResult models the data from the gettxoutsetinfo command.
+type GetTxOutSetInfoResult struct {
+	Height          int32   `json:"height"`
+	BestBlock       string  `json:"bestblock"`
+	Transactions    int64   `json:"transactions"`
+	TxOuts          int64   `json:"txouts"`
+	BytesSerialized int64   `json:"bytes_serialized"`
+	HashSerialized  string  `json:"hash_serialized"`
+	TotalAmount     float64 `json:"total_amount"`
+}
+
 // GetNetTotalsResult models the data returned from the getnettotals command.
 type GetNetTotalsResult struct {
 	TotalBytesRecv uint64 `json:"totalbytesrecv"`
@@ -222,6 +352,56 @@ type GetNetTotalsResult struct {
 	TimeMillis     int64  `json:"timemillis"`
 }
 
+// NotifierInfoResult models the delivery statistics of a notifier returned as
+// part of the getrpcinfo command.
+type NotifierInfoResult struct {
+	Name         string  `json:"name"`
+	Queued       int     `json:"queued"`
+	Delivered    uint64  `json:"delivered"`
+	Failed       uint64  `json:"failed"`
+	Dropped      uint64  `json:"dropped"`
+	AvgLatencyMs float64 `json:"avglatencyms"`
+	MaxLatencyMs float64 `json:"maxlatencyms"`
+	LastError    string  `json:"lasterror,omitempty"`
+}
+
+// GetRPCInfoResult models the data returned from the getrpcinfo command.
+type GetRPCInfoResult struct {
+	Notifiers []NotifierInfoResult `json:"notifiers"`
+}
+
+// VersionResult models the semantic version of a component of the node
+// returned as part of the version command.
+type VersionResult struct {
+	VersionString string `json:"versionstring"`
+	Major         uint32 `json:"major"`
+	Minor         uint32 `json:"minor"`
+	Patch         uint32 `json:"patch"`
+	Prerelease    string `json:"prerelease"`
+	BuildMetadata string `json:"buildmetadata"`
+}
+
+// VersionFeaturesResult models the optional subsystems of the node which are
+// enabled returned as part of the version command.
+type VersionFeaturesResult struct {
+	TxIndex     bool `json:"txindex"`
+	AddrIndex   bool `json:"addrindex"`
+	CfIndex     bool `json:"cfindex"`
+	Pruning     bool `json:"pruning"`
+	Compression bool `json:"compression"`
+	REST        bool `json:"rest"`
+}
+
+// NodeVersionResult models the data returned from the version command.  The
+// versions are keyed by the name of the component.
+type NodeVersionResult struct {
+	Colxd           VersionResult         `json:"colxd"`
+	Protocol        VersionResult         `json:"protocol"`
+	Database        VersionResult         `json:"database"`
+	DatabaseBackend string                `json:"databasebackend"`
+	Features        VersionFeaturesResult `json:"features"`
+}
+
 // ScriptSig models a signature script.  It is defined separately since it only
 // applies to non-coinbase.  Therefore the field in the Vin structure needs
 // to be a pointer.
@@ -398,6 +578,31 @@ type TxRawResult struct {
 	Blocktime     int64  `json:"blocktime,omitempty"`
 }
 
+// ScanTxOutSetUnspent models an unspent transaction output found by the
+// scantxoutset command.
+type ScanTxOutSetUnspent struct {
+	Txid         string  `json:"txid"`
+	Vout         uint32  `json:"vout"`
+	ScriptPubKey string  `json:"scriptPubKey"`
+	Amount       float64 `json:"amount"`
+	Height       int32   `json:"height"`
+}
+
+// ScanTxOutSetResult models the data returned from the scantxoutset command
+// when a scan is started.
+type ScanTxOutSetResult struct {
+	Success       bool                  `json:"success"`
+	SearchedItems int64                 `json:"searched_items"`
+	Unspents      []ScanTxOutSetUnspent `json:"unspents"`
+	TotalAmount   float64               `json:"total_amount"`
+}
+
+// ScanTxOutSetStatusResult models the data returned from the scantxoutset
+// command when the status of a scan in progress is requested.
+type ScanTxOutSetStatusResult struct {
+	Progress float64 `json:"progress"`
+}
+
 // SearchRawTransactionsResult models the data from the searchrawtransaction
 // command.
 type SearchRawTransactionsResult struct {
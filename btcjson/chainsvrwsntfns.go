@@ -17,6 +17,17 @@ const (
 	// the chain server that a block has been disconnected.
 	BlockDisconnectedNtfnMethod = "blockdisconnected"
 
+	// FilteredBlockConnectedNtfnMethod is the method used for
+	// notifications from the chain server that a block has been connected.
+	// It includes the transactions of the block which match the filter
+	// loaded by the client.
+	FilteredBlockConnectedNtfnMethod = "filteredblockconnected"
+
+	// FilteredBlockDisconnectedNtfnMethod is the method used for
+	// notifications from the chain server that a block has been
+	// disconnected.
+	FilteredBlockDisconnectedNtfnMethod = "filteredblockdisconnected"
+
 	// RecvTxNtfnMethod is the method used for notifications from the chain
 	// server that a transaction which pays to a registered address has been
 	// processed.
@@ -27,6 +38,11 @@ const (
 	// has been processed.
 	RedeemingTxNtfnMethod = "redeemingtx"
 
+	// RelevantTxAcceptedNtfnMethod is the method used for notifications
+	// from the chain server that a transaction which matches the filter
+	// loaded by the client has been accepted into the mempool.
+	RelevantTxAcceptedNtfnMethod = "relevanttxaccepted"
+
 	// RescanFinishedNtfnMethod is the method used for notifications from
 	// the chain server that a rescan operation has finished.
 	RescanFinishedNtfnMethod = "rescanfinished"
@@ -45,6 +61,11 @@ const (
 	// mempool.  This differs from TxAcceptedNtfnMethod in that it provides
 	// more details in the notification.
 	TxAcceptedVerboseNtfnMethod = "txacceptedverbose"
+
+	// TxEvictedNtfnMethod is the method used for notifications from the
+	// chain server that a transaction has been evicted from the mempool
+	// because the mempool exceeded its size limit.
+	TxEvictedNtfnMethod = "txevicted"
 )
 
 // BlockConnectedNtfn defines the blockconnected JSON-RPC notification.
@@ -81,6 +102,40 @@ func NewBlockDisconnectedNtfn(hash string, height int32, time int64) *BlockDisco
 	}
 }
 
+// FilteredBlockConnectedNtfn defines the filteredblockconnected JSON-RPC
+// notification.
+type FilteredBlockConnectedNtfn struct {
+	Height        int32
+	Header        string
+	SubscribedTxs []string
+}
+
+// NewFilteredBlockConnectedNtfn returns a new instance which can be used to
+// issue a filteredblockconnected JSON-RPC notification.
+func NewFilteredBlockConnectedNtfn(height int32, header string, subscribedTxs []string) *FilteredBlockConnectedNtfn {
+	return &FilteredBlockConnectedNtfn{
+		Height:        height,
+		Header:        header,
+		SubscribedTxs: subscribedTxs,
+	}
+}
+
+// FilteredBlockDisconnectedNtfn defines the filteredblockdisconnected JSON-RPC
+// notification.
+type FilteredBlockDisconnectedNtfn struct {
+	Height int32
+	Header string
+}
+
+// NewFilteredBlockDisconnectedNtfn returns a new instance which can be used to
+// issue a filteredblockdisconnected JSON-RPC notification.
+func NewFilteredBlockDisconnectedNtfn(height int32, header string) *FilteredBlockDisconnectedNtfn {
+	return &FilteredBlockDisconnectedNtfn{
+		Height: height,
+		Header: header,
+	}
+}
+
 // BlockDetails describes details of a tx in a block.
 type BlockDetails struct {
 	Height int32  `json:"height"`
@@ -119,6 +174,17 @@ func NewRedeemingTxNtfn(hexTx string, block *BlockDetails) *RedeemingTxNtfn {
 	}
 }
 
+// RelevantTxAcceptedNtfn defines the relevanttxaccepted JSON-RPC notification.
+type RelevantTxAcceptedNtfn struct {
+	Transaction string
+}
+
+// NewRelevantTxAcceptedNtfn returns a new instance which can be used to issue a
+// relevanttxaccepted JSON-RPC notification.
+func NewRelevantTxAcceptedNtfn(txHex string) *RelevantTxAcceptedNtfn {
+	return &RelevantTxAcceptedNtfn{Transaction: txHex}
+}
+
 // RescanFinishedNtfn defines the rescanfinished JSON-RPC notification.
 type RescanFinishedNtfn struct {
 	Hash   string
@@ -181,6 +247,17 @@ func NewTxAcceptedVerboseNtfn(rawTx TxRawResult) *TxAcceptedVerboseNtfn {
 	}
 }
 
+// TxEvictedNtfn defines the txevicted JSON-RPC notification.
+type TxEvictedNtfn struct {
+	TxID string
+}
+
+// NewTxEvictedNtfn returns a new instance which can be used to issue a
+// txevicted JSON-RPC notification.
+func NewTxEvictedNtfn(txHash string) *TxEvictedNtfn {
+	return &TxEvictedNtfn{TxID: txHash}
+}
+
 func init() {
 	// The commands in this file are only usable by websockets and are
 	// notifications.
@@ -188,10 +265,14 @@ func init() {
 
 	MustRegisterCmd(BlockConnectedNtfnMethod, (*BlockConnectedNtfn)(nil), flags)
 	MustRegisterCmd(BlockDisconnectedNtfnMethod, (*BlockDisconnectedNtfn)(nil), flags)
+	MustRegisterCmd(FilteredBlockConnectedNtfnMethod, (*FilteredBlockConnectedNtfn)(nil), flags)
+	MustRegisterCmd(FilteredBlockDisconnectedNtfnMethod, (*FilteredBlockDisconnectedNtfn)(nil), flags)
 	MustRegisterCmd(RecvTxNtfnMethod, (*RecvTxNtfn)(nil), flags)
 	MustRegisterCmd(RedeemingTxNtfnMethod, (*RedeemingTxNtfn)(nil), flags)
+	MustRegisterCmd(RelevantTxAcceptedNtfnMethod, (*RelevantTxAcceptedNtfn)(nil), flags)
 	MustRegisterCmd(RescanFinishedNtfnMethod, (*RescanFinishedNtfn)(nil), flags)
 	MustRegisterCmd(RescanProgressNtfnMethod, (*RescanProgressNtfn)(nil), flags)
 	MustRegisterCmd(TxAcceptedNtfnMethod, (*TxAcceptedNtfn)(nil), flags)
 	MustRegisterCmd(TxAcceptedVerboseNtfnMethod, (*TxAcceptedVerboseNtfn)(nil), flags)
+	MustRegisterCmd(TxEvictedNtfnMethod, (*TxEvictedNtfn)(nil), flags)
 }
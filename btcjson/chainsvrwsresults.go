@@ -9,3 +9,10 @@ package btcjson
 type SessionResult struct {
 	SessionID uint64 `json:"sessionid"`
 }
+
+// RescannedBlock contains the hash and all discovered transactions of a single
+// rescanned block.
+type RescannedBlock struct {
+	Hash         string   `json:"hash"`
+	Transactions []string `json:"transactions"`
+}
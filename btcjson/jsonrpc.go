@@ -17,10 +17,13 @@ import (
 type RPCErrorCode int
 
 // RPCError represents an error that is used as a part of a JSON-RPC Response
-// object.
+// object.  The optional data carries machine-readable details about the error,
+// such as a TxRejectErrorData, and is only serialized when it is set so clients
+// which don't know about it are unaffected.
 type RPCError struct {
 	Code    RPCErrorCode `json:"code,omitempty"`
 	Message string       `json:"message,omitempty"`
+	Data    interface{}  `json:"data,omitempty"`
 }
 
 // Guarantee RPCError satisifies the builtin error interface.
@@ -41,6 +44,17 @@ func NewRPCError(code RPCErrorCode, message string) *RPCError {
 	}
 }
 
+// NewRPCErrorWithData constructs and returns a new JSON-RPC error with the
+// passed machine-readable details that is suitable for use in a JSON-RPC
+// Response object.
+func NewRPCErrorWithData(code RPCErrorCode, message string, data interface{}) *RPCError {
+	return &RPCError{
+		Code:    code,
+		Message: message,
+		Data:    data,
+	}
+}
+
 // IsValidIDType checks that the ID field (which can go in any of the JSON-RPC
 // requests, responses, or notifications) is valid.  JSON-RPC 1.0 allows any
 // valid JSON type.  JSON-RPC 2.0 (which bitcoind follows for some parts) only
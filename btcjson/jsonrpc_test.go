@@ -79,6 +79,18 @@ func TestMarshalResponse(t *testing.T) {
 			}(),
 			expected: []byte(`{"result":null,"error":{"code":-5,"message":"123 not found"},"id":1}`),
 		},
+		{
+			name:   "result with error data",
+			result: nil,
+			jsonErr: func() *btcjson.RPCError {
+				return btcjson.NewRPCErrorWithData(btcjson.ErrRPCVerify,
+					"TX rejected", &btcjson.TxRejectErrorData{
+						RejectCode:    "REJECT_DUPLICATE",
+						MissingInputs: []string{"1234:1"},
+					})
+			}(),
+			expected: []byte(`{"result":null,"error":{"code":-25,"message":"TX rejected","data":{"rejectcode":"REJECT_DUPLICATE","missinginputs":["1234:1"]}},"id":1}`),
+		},
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -160,3 +172,43 @@ func TestRPCError(t *testing.T) {
 		}
 	}
 }
+
+// TestErrorCodeCatalog ensures the error code catalog is sorted by code and has
+// unique codes and names which can be looked up.
+func TestErrorCodeCatalog(t *testing.T) {
+	t.Parallel()
+
+	names := make(map[string]struct{})
+	for i, info := range btcjson.ErrorCodeCatalog {
+		if i > 0 && info.Code <= btcjson.ErrorCodeCatalog[i-1].Code {
+			t.Errorf("catalog entry %s is not sorted by code",
+				info.Name)
+		}
+		if _, ok := names[info.Name]; ok || info.Name == "" {
+			t.Errorf("catalog entry %d has duplicate or empty name "+
+				"%q", info.Code, info.Name)
+		}
+		names[info.Name] = struct{}{}
+		if info.Description == "" {
+			t.Errorf("catalog entry %s has no description",
+				info.Name)
+		}
+
+		got, ok := btcjson.LookupErrorCode(info.Code)
+		if !ok || got != info {
+			t.Errorf("LookupErrorCode(%d): unexpected result - got "+
+				"%v, %v", info.Code, got, ok)
+		}
+	}
+
+	// Codes which are shared by several constants resolve to the general
+	// error.
+	info, ok := btcjson.LookupErrorCode(btcjson.ErrRPCBlockNotFound)
+	if !ok || info.Name != "RPC_INVALID_ADDRESS_OR_KEY" {
+		t.Errorf("LookupErrorCode: unexpected result for block not "+
+			"found - got %v, %v", info, ok)
+	}
+	if _, ok := btcjson.LookupErrorCode(-12345); ok {
+		t.Errorf("LookupErrorCode: unexpected result for unknown code")
+	}
+}
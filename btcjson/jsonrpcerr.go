@@ -40,12 +40,14 @@ const (
 	ErrRPCDatabase            RPCErrorCode = -20
 	ErrRPCDeserialization     RPCErrorCode = -22
 	ErrRPCVerify              RPCErrorCode = -25
+	ErrRPCVerifyRejected      RPCErrorCode = -26
 )
 
 // Peer-to-peer client errors.
 const (
 	ErrRPCClientNotConnected      RPCErrorCode = -9
 	ErrRPCClientInInitialDownload RPCErrorCode = -10
+	ErrRPCClientNodeNotAdded      RPCErrorCode = -24
 )
 
 // Wallet JSON errors
@@ -82,3 +84,94 @@ const (
 	ErrRPCNoWallet      RPCErrorCode = -1
 	ErrRPCUnimplemented RPCErrorCode = -1
 )
+
+// RPCErrorCodeInfo describes an error code returned by the chain server.
+type RPCErrorCodeInfo struct {
+	Code        RPCErrorCode
+	Name        string
+	Description string
+}
+
+// ErrorCodeCatalog lists the error codes returned by the chain server along
+// with a stable name and a description of when they are returned, sorted by
+// code.  Clients should rely on the codes and the data of the errors rather
+// than their messages, which are meant for humans and may change.
+//
+// Several of the command specific constants above share a code with one of the
+// general errors, so only the general errors are listed.
+var ErrorCodeCatalog = []RPCErrorCodeInfo{
+	{ErrRPCParse.Code, "RPC_PARSE_ERROR",
+		"The request is not valid JSON"},
+	{ErrRPCInternal.Code, "RPC_INTERNAL_ERROR",
+		"The server failed to handle the request"},
+	{ErrRPCInvalidParams.Code, "RPC_INVALID_PARAMS",
+		"The parameters do not match the method or the client is not " +
+			"authorized to call it"},
+	{ErrRPCMethodNotFound.Code, "RPC_METHOD_NOT_FOUND",
+		"The method does not exist"},
+	{ErrRPCInvalidRequest.Code, "RPC_INVALID_REQUEST",
+		"The request is not a valid JSON-RPC request"},
+	{ErrRPCVerifyRejected, "RPC_VERIFY_REJECTED",
+		"The transaction was rejected by the memory pool; the data " +
+			"is a TxRejectErrorData"},
+	{ErrRPCVerify, "RPC_VERIFY_ERROR",
+		"The transaction or block could not be verified, such as " +
+			"when inputs are missing; the data is a " +
+			"TxRejectErrorData or BlockRejectErrorData"},
+	{ErrRPCClientNodeNotAdded, "RPC_CLIENT_NODE_NOT_ADDED",
+		"The node has not been added"},
+	{ErrRPCDeserialization, "RPC_DESERIALIZATION_ERROR",
+		"A transaction, block, or hex string failed to decode"},
+	{ErrRPCDatabase, "RPC_DATABASE_ERROR",
+		"The database failed to handle the request"},
+	{ErrRPCClientInInitialDownload, "RPC_CLIENT_IN_INITIAL_DOWNLOAD",
+		"The node is still downloading the block chain"},
+	{ErrRPCClientNotConnected, "RPC_CLIENT_NOT_CONNECTED",
+		"The node is not connected to any peers"},
+	{ErrRPCInvalidParameter, "RPC_INVALID_PARAMETER",
+		"A parameter has an invalid value"},
+	{ErrRPCInvalidAddressOrKey, "RPC_INVALID_ADDRESS_OR_KEY",
+		"An address or key is invalid, or the requested block or " +
+			"transaction does not exist"},
+	{ErrRPCType, "RPC_TYPE_ERROR",
+		"A parameter has an unexpected type or form"},
+	{ErrRPCMisc, "RPC_MISC_ERROR",
+		"The request can't be served in the current state of the " +
+			"server, such as when a required index is disabled or " +
+			"a value is out of range"},
+}
+
+// LookupErrorCode returns the entry of the error code catalog for the passed
+// code and whether or not the code is in the catalog.
+func LookupErrorCode(code RPCErrorCode) (RPCErrorCodeInfo, bool) {
+	for _, info := range ErrorCodeCatalog {
+		if info.Code == code {
+			return info, true
+		}
+	}
+	return RPCErrorCodeInfo{}, false
+}
+
+// TxRejectErrorData is the data of the errors returned when a transaction is
+// rejected, such as by sendrawtransaction.
+type TxRejectErrorData struct {
+	// RejectCode is the name of the reject code the transaction would
+	// be rejected with in a reject message, such as REJECT_NONSTANDARD.
+	RejectCode string `json:"rejectcode"`
+
+	// ErrorCode is the name of the consensus rule which was violated,
+	// such as ErrDoubleSpend, when the rejection is due to one.
+	ErrorCode string `json:"errorcode,omitempty"`
+
+	// MissingInputs are the outpoints spent by the transaction which
+	// are unknown or already spent, formatted as txid:vout.
+	MissingInputs []string `json:"missinginputs,omitempty"`
+}
+
+// BlockRejectErrorData is the data of the errors returned when a submitted or
+// proposed block fails to be processed.  Blocks which violate a consensus rule
+// are not errors, they are rejected with a BIP0022 result instead.
+type BlockRejectErrorData struct {
+	// Reason is the BIP0022 rejection reason, such as bad-txnmrklroot.
+	Reason string `json:"reason"`
+}
@@ -7,6 +7,7 @@ package chaincfg
 
 import (
 	"errors"
+	"math"
 	"math/big"
 
 	"github.com/tinhnguyenhn/colxd/wire"
@@ -49,6 +50,43 @@ type Checkpoint struct {
 	Hash   *wire.ShaHash
 }
 
+// ConsensusDeployment defines details related to a specific consensus rule
+// change that is voted in by miners signalling a bit in the version of the
+// blocks they create.  This is part of BIP0009.
+type ConsensusDeployment struct {
+	// BitNumber defines the specific bit number within the block version
+	// this particular soft-fork deployment refers to.
+	BitNumber uint8
+
+	// StartTime is the median block time after which voting on the
+	// deployment starts.
+	StartTime uint64
+
+	// ExpireTime is the median block time after which the attempted
+	// deployment expires.
+	ExpireTime uint64
+}
+
+// Constants that define the deployment offset in the deployments field of the
+// parameters for each deployment.  This is useful to be able to get the details
+// of a specific deployment by name.
+const (
+	// DeploymentTestDummy defines the rule change deployment ID for testing
+	// purposes.
+	DeploymentTestDummy uint32 = iota
+
+	// DeploymentNullDummy defines the rule change deployment ID for the
+	// requirement that the extra stack item consumed by
+	// OP_CHECKMULTISIG be empty as defined by BIP0147.
+	DeploymentNullDummy
+
+	// DeploymentMedianTimePast defines the rule change deployment ID for
+	// the requirement that transaction lock times be compared against the
+	// median time of the previous blocks instead of the block timestamp as
+	// defined by BIP0113.
+	DeploymentMedianTimePast
+)
+
 // Params defines a Bitcoin network by its parameters.  These parameters may be
 // used by Bitcoin applications to differentiate networks as well as addresses
 // and keys for one network from those intended for use on another network.
@@ -81,6 +119,40 @@ type Params struct {
 	// The number of nodes to check.  This is part of BIP0034.
 	BlockUpgradeNumToCheck uint64
 
+	// BIP0068Height is the height of the first block in which the relative
+	// lock-time semantics of transaction input sequence numbers defined by
+	// BIP0068 are enforced along with OP_CHECKSEQUENCEVERIFY defined by
+	// BIP0112.  It is math.MaxInt32 on networks where they are not
+	// deployed.
+	BIP0068Height int32
+
+	// MaxStandardTxVersion is the highest transaction version which is
+	// relayed and mined by default.  Transactions with higher versions are
+	// valid according to the consensus rules, but are not relayed since the
+	// rules future versions will be subject to are not known yet.
+	MaxStandardTxVersion int32
+
+	// RuleChangeActivationThreshold is the number of blocks in a threshold
+	// state retarget window for which a positive vote for a rule change
+	// must be cast in order to lock in a rule change.  It is typically 95%
+	// for the main network and 75% for test networks.
+	RuleChangeActivationThreshold uint32
+
+	// MinerConfirmationWindow is the number of blocks in each threshold
+	// state retarget window.
+	MinerConfirmationWindow uint32
+
+	// Deployments define the specific consensus rule changes to be voted
+	// on keyed by their deployment ID.  This is part of BIP0009.
+	Deployments map[uint32]ConsensusDeployment
+
+	// MaxBlockWeight is the maximum weight of a block and
+	// MaxBlockSigOpsCost is the maximum total signature operation cost of
+	// the transactions in a block.  Zero values mean the default limits.
+	// See GetBlockWeightLimits.
+	MaxBlockWeight     int64
+	MaxBlockSigOpsCost int64
+
 	// Mempool parameters
 	RelayNonStdTxs bool
 
@@ -98,6 +170,34 @@ type Params struct {
 	HDCoinType uint32
 }
 
+const (
+	// DefaultMaxBlockWeight is the maximum weight of a block on networks
+	// which don't set their own limit.  Every serialized byte of a block
+	// weighs four units, so it allows blocks up to the max block payload.
+	DefaultMaxBlockWeight = 4 * wire.MaxBlockPayload
+
+	// DefaultMaxBlockSigOpsCost is the maximum total signature operation
+	// cost of the transactions in a block on networks which don't set their
+	// own limit.  Every signature operation costs four units, so it allows
+	// one signature operation for every 50 bytes of the max block payload.
+	DefaultMaxBlockSigOpsCost = 4 * (wire.MaxBlockPayload / 50)
+)
+
+// GetBlockWeightLimits returns the maximum weight of a block and the maximum
+// total signature operation cost of its transactions on the network.  The
+// defaults are returned for the limits the network does not set.
+func (p *Params) GetBlockWeightLimits() (maxWeight, maxSigOpsCost int64) {
+	maxWeight = p.MaxBlockWeight
+	if maxWeight <= 0 {
+		maxWeight = DefaultMaxBlockWeight
+	}
+	maxSigOpsCost = p.MaxBlockSigOpsCost
+	if maxSigOpsCost <= 0 {
+		maxSigOpsCost = DefaultMaxBlockSigOpsCost
+	}
+	return maxWeight, maxSigOpsCost
+}
+
 // MainNetParams defines the network parameters for the main Bitcoin network.
 var MainNetParams = Params{
 	Name:        "mainnet",
@@ -154,6 +254,37 @@ var MainNetParams = Params{
 	BlockRejectNumRequired:  950,
 	BlockUpgradeNumToCheck:  1000,
 
+	// Relative lock-time enforcement (BIP0068).
+	BIP0068Height: math.MaxInt32,
+
+	// Transaction versions with defined rules on the network.
+	MaxStandardTxVersion: 1,
+
+	// Consensus rule change deployments.
+	//
+	// The miner confirmation window matches the difficulty retarget
+	// interval, which is the two week target timespan divided by the ten
+	// minute target block spacing, so each window spans about two weeks.
+	RuleChangeActivationThreshold: 1916, // 95% of MinerConfirmationWindow
+	MinerConfirmationWindow:       2016,
+	Deployments: map[uint32]ConsensusDeployment{
+		DeploymentTestDummy: {
+			BitNumber:  28,
+			StartTime:  1199145601, // January 1, 2008 UTC
+			ExpireTime: 1230767999, // December 31, 2008 UTC
+		},
+		DeploymentNullDummy: {
+			BitNumber:  1,
+			StartTime:  math.MaxUint64, // Not yet scheduled
+			ExpireTime: math.MaxUint64,
+		},
+		DeploymentMedianTimePast: {
+			BitNumber:  2,
+			StartTime:  math.MaxUint64, // Not yet scheduled
+			ExpireTime: math.MaxUint64,
+		},
+	},
+
 	// Mempool parameters
 	RelayNonStdTxs: false,
 
@@ -202,6 +333,37 @@ var RegressionNetParams = Params{
 	BlockRejectNumRequired:  950,
 	BlockUpgradeNumToCheck:  1000,
 
+	// Relative lock-time enforcement (BIP0068).
+	BIP0068Height: 0,
+
+	// Transaction versions with defined rules on the network.
+	MaxStandardTxVersion: 2,
+
+	// Consensus rule change deployments.
+	//
+	// The miner confirmation window is one day worth of blocks at the ten
+	// minute target block spacing, which matches the reference regression
+	// test network and keeps deployments quick to activate in tests.
+	RuleChangeActivationThreshold: 108, // 75% of MinerConfirmationWindow
+	MinerConfirmationWindow:       144,
+	Deployments: map[uint32]ConsensusDeployment{
+		DeploymentTestDummy: {
+			BitNumber:  28,
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires
+		},
+		DeploymentNullDummy: {
+			BitNumber:  1,
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires
+		},
+		DeploymentMedianTimePast: {
+			BitNumber:  2,
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires
+		},
+	},
+
 	// Mempool parameters
 	RelayNonStdTxs: true,
 
@@ -256,6 +418,37 @@ var TestNet3Params = Params{
 	BlockRejectNumRequired:  75,
 	BlockUpgradeNumToCheck:  100,
 
+	// Relative lock-time enforcement (BIP0068).
+	BIP0068Height: math.MaxInt32,
+
+	// Transaction versions with defined rules on the network.
+	MaxStandardTxVersion: 1,
+
+	// Consensus rule change deployments.
+	//
+	// The miner confirmation window matches the difficulty retarget
+	// interval, which is the two week target timespan divided by the ten
+	// minute target block spacing, so each window spans about two weeks.
+	RuleChangeActivationThreshold: 1512, // 75% of MinerConfirmationWindow
+	MinerConfirmationWindow:       2016,
+	Deployments: map[uint32]ConsensusDeployment{
+		DeploymentTestDummy: {
+			BitNumber:  28,
+			StartTime:  1199145601, // January 1, 2008 UTC
+			ExpireTime: 1230767999, // December 31, 2008 UTC
+		},
+		DeploymentNullDummy: {
+			BitNumber:  1,
+			StartTime:  math.MaxUint64, // Not yet scheduled
+			ExpireTime: math.MaxUint64,
+		},
+		DeploymentMedianTimePast: {
+			BitNumber:  2,
+			StartTime:  math.MaxUint64, // Not yet scheduled
+			ExpireTime: math.MaxUint64,
+		},
+	},
+
 	// Mempool parameters
 	RelayNonStdTxs: true,
 
@@ -308,6 +501,36 @@ var SimNetParams = Params{
 	BlockRejectNumRequired:  75,
 	BlockUpgradeNumToCheck:  100,
 
+	// Relative lock-time enforcement (BIP0068).
+	BIP0068Height: 0,
+
+	// Transaction versions with defined rules on the network.
+	MaxStandardTxVersion: 2,
+
+	// Consensus rule change deployments.
+	//
+	// The miner confirmation window is a short round number of blocks so
+	// deployments can be activated quickly on the simulation test network.
+	RuleChangeActivationThreshold: 75, // 75% of MinerConfirmationWindow
+	MinerConfirmationWindow:       100,
+	Deployments: map[uint32]ConsensusDeployment{
+		DeploymentTestDummy: {
+			BitNumber:  28,
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires
+		},
+		DeploymentNullDummy: {
+			BitNumber:  1,
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires
+		},
+		DeploymentMedianTimePast: {
+			BitNumber:  2,
+			StartTime:  0,             // Always available for vote
+			ExpireTime: math.MaxInt64, // Never expires
+		},
+	},
+
 	// Mempool parameters
 	RelayNonStdTxs: true,
 
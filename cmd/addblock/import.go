@@ -322,7 +322,7 @@ func newBlockImporter(db database.DB, r io.ReadSeeker) (*blockImporter, error) {
 	// Create an index manager if any of the optional indexes are enabled.
 	var indexManager blockchain.IndexManager
 	if len(indexes) > 0 {
-		indexManager = indexers.NewManager(db, indexes)
+		indexManager = indexers.NewManager(db, indexes, nil, nil)
 	}
 
 	chain, err := blockchain.New(&blockchain.Config{
@@ -23,8 +23,11 @@ import (
 
 	flags "github.com/btcsuite/go-flags"
 	"github.com/btcsuite/go-socks/socks"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
 	"github.com/tinhnguyenhn/colxd/database"
 	_ "github.com/tinhnguyenhn/colxd/database/ffldb"
+	"github.com/tinhnguyenhn/colxd/peer"
+	"github.com/tinhnguyenhn/colxd/txscript"
 	"github.com/tinhnguyenhn/colxd/wire"
 	"github.com/tinhnguyenhn/colxutil"
 )
@@ -36,9 +39,11 @@ const (
 	defaultLogDirname            = "logs"
 	defaultLogFilename           = "btcd.log"
 	defaultMaxPeers              = 125
+	defaultMaxConnsPerNetGroup   = 1
 	defaultBanDuration           = time.Hour * 24
 	defaultBanThreshold          = 100
 	defaultMaxRPCClients         = 10
+	defaultRPCLongPollTimeout    = time.Minute
 	defaultMaxRPCWebsockets      = 25
 	defaultVerifyEnabled         = false
 	defaultDbType                = "ffldb"
@@ -48,12 +53,23 @@ const (
 	blockMaxSizeMin              = 1000
 	blockMaxSizeMax              = wire.MaxBlockPayload - 1000
 	defaultBlockPrioritySize     = 50000
+	defaultTemplateFeeDelta      = colxutil.Amount(100000)
 	defaultGenerate              = false
+	defaultGenProcLimit          = -1
 	defaultMaxOrphanTransactions = 1000
+	defaultDataCarrierSize       = txscript.DefaultMaxNullDataSize
 	defaultMaxOrphanTxSize       = 5000
+	defaultMaxOrphanBytes        = 5000000
+	defaultOrphanTTL             = time.Minute * 20
+	defaultLimitAncestorCount    = 25
+	defaultLimitAncestorSize     = 101
+	defaultLimitDescendantCount  = 25
+	defaultLimitDescendantSize   = 101
+	defaultMaxMempool            = 300
 	defaultSigCacheMaxSize       = 100000
 	defaultTxIndex               = false
 	defaultAddrIndex             = false
+	minPruneTarget               = 550
 )
 
 var (
@@ -83,71 +99,107 @@ func minUint32(a, b uint32) uint32 {
 //
 // See loadConfig for details on the configuration load process.
 type config struct {
-	ShowVersion        bool          `short:"V" long:"version" description:"Display version information and exit"`
-	ConfigFile         string        `short:"C" long:"configfile" description:"Path to configuration file"`
-	DataDir            string        `short:"b" long:"datadir" description:"Directory to store data"`
-	LogDir             string        `long:"logdir" description:"Directory to log output."`
-	AddPeers           []string      `short:"a" long:"addpeer" description:"Add a peer to connect with at startup"`
-	ConnectPeers       []string      `long:"connect" description:"Connect only to the specified peers at startup"`
-	DisableListen      bool          `long:"nolisten" description:"Disable listening for incoming connections -- NOTE: Listening is automatically disabled if the --connect or --proxy options are used without also specifying listen interfaces via --listen"`
-	Listeners          []string      `long:"listen" description:"Add an interface/port to listen for connections (default all interfaces port: 8333, testnet: 18333)"`
-	MaxPeers           int           `long:"maxpeers" description:"Max number of inbound and outbound peers"`
-	DisableBanning     bool          `long:"nobanning" description:"Disable banning of misbehaving peers"`
-	BanDuration        time.Duration `long:"banduration" description:"How long to ban misbehaving peers.  Valid time units are {s, m, h}.  Minimum 1 second"`
-	BanThreshold       uint32        `long:"banthreshold" description:"Maximum allowed ban score before disconnecting and banning misbehaving peers."`
-	RPCUser            string        `short:"u" long:"rpcuser" description:"Username for RPC connections"`
-	RPCPass            string        `short:"P" long:"rpcpass" default-mask:"-" description:"Password for RPC connections"`
-	RPCLimitUser       string        `long:"rpclimituser" description:"Username for limited RPC connections"`
-	RPCLimitPass       string        `long:"rpclimitpass" default-mask:"-" description:"Password for limited RPC connections"`
-	RPCListeners       []string      `long:"rpclisten" description:"Add an interface/port to listen for RPC connections (default port: 8334, testnet: 18334)"`
-	RPCCert            string        `long:"rpccert" description:"File containing the certificate file"`
-	RPCKey             string        `long:"rpckey" description:"File containing the certificate key"`
-	RPCMaxClients      int           `long:"rpcmaxclients" description:"Max number of RPC clients for standard connections"`
-	RPCMaxWebsockets   int           `long:"rpcmaxwebsockets" description:"Max number of RPC websocket connections"`
-	DisableRPC         bool          `long:"norpc" description:"Disable built-in RPC server -- NOTE: The RPC server is disabled by default if no rpcuser/rpcpass or rpclimituser/rpclimitpass is specified"`
-	DisableTLS         bool          `long:"notls" description:"Disable TLS for the RPC server -- NOTE: This is only allowed if the RPC server is bound to localhost"`
-	DisableDNSSeed     bool          `long:"nodnsseed" description:"Disable DNS seeding for peers"`
-	ExternalIPs        []string      `long:"externalip" description:"Add an ip to the list of local addresses we claim to listen on to peers"`
-	Proxy              string        `long:"proxy" description:"Connect via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
-	ProxyUser          string        `long:"proxyuser" description:"Username for proxy server"`
-	ProxyPass          string        `long:"proxypass" default-mask:"-" description:"Password for proxy server"`
-	OnionProxy         string        `long:"onion" description:"Connect to tor hidden services via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
-	OnionProxyUser     string        `long:"onionuser" description:"Username for onion proxy server"`
-	OnionProxyPass     string        `long:"onionpass" default-mask:"-" description:"Password for onion proxy server"`
-	NoOnion            bool          `long:"noonion" description:"Disable connecting to tor hidden services"`
-	TorIsolation       bool          `long:"torisolation" description:"Enable Tor stream isolation by randomizing user credentials for each connection."`
-	TestNet3           bool          `long:"testnet" description:"Use the test network"`
-	RegressionTest     bool          `long:"regtest" description:"Use the regression test network"`
-	SimNet             bool          `long:"simnet" description:"Use the simulation test network"`
-	DisableCheckpoints bool          `long:"nocheckpoints" description:"Disable built-in checkpoints.  Don't do this unless you know what you're doing."`
-	DbType             string        `long:"dbtype" description:"Database backend to use for the Block Chain"`
-	Profile            string        `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65536"`
-	CPUProfile         string        `long:"cpuprofile" description:"Write CPU profile to the specified file"`
-	DebugLevel         string        `short:"d" long:"debuglevel" description:"Logging level for all subsystems {trace, debug, info, warn, error, critical} -- You may also specify <subsystem>=<level>,<subsystem2>=<level>,... to set the log level for individual subsystems -- Use show to list available subsystems"`
-	Upnp               bool          `long:"upnp" description:"Use UPnP to map our listening port outside of NAT"`
-	MinRelayTxFee      float64       `long:"minrelaytxfee" description:"The minimum transaction fee in BTC/kB to be considered a non-zero fee."`
-	FreeTxRelayLimit   float64       `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
-	NoRelayPriority    bool          `long:"norelaypriority" description:"Do not require free or low-fee transactions to have high priority for relaying"`
-	MaxOrphanTxs       int           `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
-	Generate           bool          `long:"generate" description:"Generate (mine) bitcoins using the CPU"`
-	MiningAddrs        []string      `long:"miningaddr" description:"Add the specified payment address to the list of addresses to use for generated blocks -- At least one address is required if the generate option is set"`
-	BlockMinSize       uint32        `long:"blockminsize" description:"Mininum block size in bytes to be used when creating a block"`
-	BlockMaxSize       uint32        `long:"blockmaxsize" description:"Maximum block size in bytes to be used when creating a block"`
-	BlockPrioritySize  uint32        `long:"blockprioritysize" description:"Size in bytes for high-priority/low-fee transactions when creating a block"`
-	GetWorkKeys        []string      `long:"getworkkey" description:"DEPRECATED -- Use the --miningaddr option instead"`
-	NoPeerBloomFilters bool          `long:"nopeerbloomfilters" description:"Disable bloom filtering support"`
-	SigCacheMaxSize    uint          `long:"sigcachemaxsize" description:"The maximum number of entries in the signature verification cache"`
-	BlocksOnly         bool          `long:"blocksonly" description:"Do not accept transactions from remote peers."`
-	TxIndex            bool          `long:"txindex" description:"Maintain a full hash-based transaction index which makes all transactions available via the getrawtransaction RPC"`
-	DropTxIndex        bool          `long:"droptxindex" description:"Deletes the hash-based transaction index from the database on start up and then exits."`
-	AddrIndex          bool          `long:"addrindex" description:"Maintain a full address-based transaction index which makes the searchrawtransactions RPC available"`
-	DropAddrIndex      bool          `long:"dropaddrindex" description:"Deletes the address-based transaction index from the database on start up and then exits."`
-	onionlookup        func(string) ([]net.IP, error)
-	lookup             func(string) ([]net.IP, error)
-	oniondial          func(string, string) (net.Conn, error)
-	dial               func(string, string) (net.Conn, error)
-	miningAddrs        []colxutil.Address
-	minRelayTxFee      colxutil.Amount
+	ShowVersion         bool          `short:"V" long:"version" description:"Display version information and exit"`
+	ConfigFile          string        `short:"C" long:"configfile" description:"Path to configuration file"`
+	DataDir             string        `short:"b" long:"datadir" description:"Directory to store data"`
+	LogDir              string        `long:"logdir" description:"Directory to log output."`
+	AddPeers            []string      `short:"a" long:"addpeer" description:"Add a peer to connect with at startup"`
+	ConnectPeers        []string      `long:"connect" description:"Connect only to the specified peers at startup"`
+	DisableListen       bool          `long:"nolisten" description:"Disable listening for incoming connections -- NOTE: Listening is automatically disabled if the --connect or --proxy options are used without also specifying listen interfaces via --listen"`
+	Listeners           []string      `long:"listen" description:"Add an interface/port to listen for connections (default all interfaces port: 8333, testnet: 18333)"`
+	MaxPeers            int           `long:"maxpeers" description:"Max number of inbound and outbound peers"`
+	DisableBanning      bool          `long:"nobanning" description:"Disable banning of misbehaving peers"`
+	BanDuration         time.Duration `long:"banduration" description:"How long to ban misbehaving peers.  Valid time units are {s, m, h}.  Minimum 1 second"`
+	BanThreshold        uint32        `long:"banthreshold" description:"Maximum allowed ban score before disconnecting and banning misbehaving peers."`
+	MinProtocolVersion  uint32        `long:"minprotocolversion" description:"Minimum protocol version remote peers must advertise to be accepted"`
+	MaxConnsPerNetGroup int           `long:"maxconnspernetgroup" description:"Max number of automatic outbound connections to peers in the same network group (such as an IPv4 /16)"`
+	MaxRecvPayload      uint32        `long:"maxrecvpayload" description:"Maximum payload in bytes of messages accepted from peers -- Peers repeatedly sending larger messages are disconnected (0 for the protocol maximum)"`
+	RPCUser             string        `short:"u" long:"rpcuser" description:"Username for RPC connections"`
+	RPCPass             string        `short:"P" long:"rpcpass" default-mask:"-" description:"Password for RPC connections"`
+	RPCLimitUser        string        `long:"rpclimituser" description:"Username for limited RPC connections"`
+	RPCLimitPass        string        `long:"rpclimitpass" default-mask:"-" description:"Password for limited RPC connections"`
+	RPCLimitMethods     []string      `long:"rpclimitmethod" description:"Add a method the limited RPC user is allowed to call -- NOTE: When specified, only the listed methods are available to the limited user instead of the default set"`
+	RPCAuditMethods     []string      `long:"rpcauditmethod" description:"Add a method whose RPC calls are recorded in the audit log (default: node, stop, submitblock)"`
+	RPCAuditFile        string        `long:"rpcauditfile" description:"Additionally write the RPC audit log to this file, which is rotated like the main log file"`
+	RPCListeners        []string      `long:"rpclisten" description:"Add an interface/port to listen for RPC connections (default port: 8334, testnet: 18334)"`
+	RPCCert             string        `long:"rpccert" description:"File containing the certificate file"`
+	RPCKey              string        `long:"rpckey" description:"File containing the certificate key"`
+	RPCMaxClients       int           `long:"rpcmaxclients" description:"Max number of RPC clients for standard connections"`
+	RPCMaxWebsockets    int           `long:"rpcmaxwebsockets" description:"Max number of RPC websocket connections"`
+	RPCLongPollTimeout  time.Duration `long:"rpclongpolltimeout" description:"Maximum time a getblocktemplate long poll request waits for the template to become stale before the current template is returned.  Valid time units are {s, m, h}"`
+	RPCNotifyURL        string        `long:"rpcnotifyurl" description:"Post JSON notifications about connected blocks, new mempool transactions, and reorganizes to the HTTP endpoint at this URL"`
+	RPCNotifyKey        string        `long:"rpcnotifykey" default-mask:"-" description:"Key used to sign the notifications posted to --rpcnotifyurl with HMAC-SHA256"`
+	DisableRPC          bool          `long:"norpc" description:"Disable built-in RPC server -- NOTE: The RPC server is disabled by default if no rpcuser/rpcpass or rpclimituser/rpclimitpass is specified"`
+	DisableTLS          bool          `long:"notls" description:"Disable TLS for the RPC server -- NOTE: This is only allowed if the RPC server is bound to localhost"`
+	DisableDNSSeed      bool          `long:"nodnsseed" description:"Disable DNS seeding for peers"`
+	ExternalIPs         []string      `long:"externalip" description:"Add an ip to the list of local addresses we claim to listen on to peers"`
+	NoSelfAdvertise     bool          `long:"noselfadvertise" description:"Do not advertise our own listening address to peers shortly after connecting to them and once a day"`
+	Proxy               string        `long:"proxy" description:"Connect via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
+	ProxyUser           string        `long:"proxyuser" description:"Username for proxy server"`
+	ProxyPass           string        `long:"proxypass" default-mask:"-" description:"Password for proxy server"`
+	OnionProxy          string        `long:"onion" description:"Connect to tor hidden services via SOCKS5 proxy (eg. 127.0.0.1:9050)"`
+	OnionProxyUser      string        `long:"onionuser" description:"Username for onion proxy server"`
+	OnionProxyPass      string        `long:"onionpass" default-mask:"-" description:"Password for onion proxy server"`
+	NoOnion             bool          `long:"noonion" description:"Disable connecting to tor hidden services"`
+	TorIsolation        bool          `long:"torisolation" description:"Enable Tor stream isolation by randomizing user credentials for each connection."`
+	TestNet3            bool          `long:"testnet" description:"Use the test network"`
+	RegressionTest      bool          `long:"regtest" description:"Use the regression test network"`
+	SimNet              bool          `long:"simnet" description:"Use the simulation test network"`
+	AddCheckpoints      []string      `long:"addcheckpoint" description:"Add a custom checkpoint.  Format: '<height>:<hash>'"`
+	DisableCheckpoints  bool          `long:"nocheckpoints" description:"Disable built-in checkpoints.  Don't do this unless you know what you're doing."`
+	DbType              string        `long:"dbtype" description:"Database backend to use for the Block Chain"`
+	Profile             string        `long:"profile" description:"Enable HTTP profiling on given port -- NOTE port must be between 1024 and 65536"`
+	CPUProfile          string        `long:"cpuprofile" description:"Write CPU profile to the specified file"`
+	DebugLevel          string        `short:"d" long:"debuglevel" description:"Logging level for all subsystems {trace, debug, info, warn, error, critical} -- You may also specify <subsystem>=<level>,<subsystem2>=<level>,... to set the log level for individual subsystems -- Use show to list available subsystems"`
+	Upnp                bool          `long:"upnp" description:"Use UPnP to map our listening port outside of NAT"`
+	MinRelayTxFee       float64       `long:"minrelaytxfee" description:"The minimum transaction fee in BTC/kB to be considered a non-zero fee."`
+	FreeTxRelayLimit    float64       `long:"limitfreerelay" description:"Limit relay of transactions with no transaction fee to the given amount in thousands of bytes per minute"`
+	NoRelayPriority     bool          `long:"norelaypriority" description:"Do not require free or low-fee transactions to have high priority for relaying"`
+	MaxTxVersion        int32         `long:"maxtxversion" description:"Do not relay or mine transactions with a version above this value, although blocks containing them are still accepted (default: the highest transaction version defined on the network)"`
+	DataCarrierSize     int           `long:"datacarriersize" description:"Maximum size in bytes of the data carrier (nulldata) output scripts to relay and mine -- 0 disables relaying and mining them"`
+	MaxOrphanTxs        int           `long:"maxorphantx" description:"Max number of orphan transactions to keep in memory"`
+	OrphanTTL           time.Duration `long:"orphanttl" description:"How long to keep orphan transactions in memory before they expire.  Valid time units are {s, m, h}.  0 disables expiration"`
+	AncestorLimit       int           `long:"limitancestorcount" description:"Do not accept transactions if the number of unconfirmed transactions in the memory pool they depend on, including themselves, exceeds this value -- 0 disables the limit"`
+	AncestorSizeLimit   int           `long:"limitancestorsize" description:"Do not accept transactions if the total size in kilobytes of the unconfirmed transactions in the memory pool they depend on, including themselves, exceeds this value -- 0 disables the limit"`
+	DescendantLimit     int           `long:"limitdescendantcount" description:"Do not accept transactions if any unconfirmed transaction in the memory pool they depend on would have more than this many transactions depending on it, including itself -- 0 disables the limit"`
+	DescendantSizeLimit int           `long:"limitdescendantsize" description:"Do not accept transactions if any unconfirmed transaction in the memory pool they depend on would have more than this many kilobytes of transactions depending on it, including itself -- 0 disables the limit"`
+	MaxMempool          int           `long:"maxmempool" description:"Evict the transactions with the lowest fee rates once the total size in megabytes of the transactions in the memory pool exceeds this value -- 0 disables the limit"`
+	Generate            bool          `long:"generate" description:"Generate (mine) bitcoins using the CPU"`
+	GenProcLimit        int           `long:"genproclimit" description:"Number of workers which search for a solution of a block in parallel when generating bitcoins using the CPU -- -1 uses one per processor core"`
+	MiningAddrs         []string      `long:"miningaddr" description:"Add the specified payment address to the list of addresses to use for generated blocks -- At least one address is required if the generate option is set"`
+	BlockMinSize        uint32        `long:"blockminsize" description:"Mininum block size in bytes to be used when creating a block"`
+	BlockMaxSize        uint32        `long:"blockmaxsize" description:"Maximum block size in bytes to be used when creating a block"`
+	BlockPrioritySize   uint32        `long:"blockprioritysize" description:"Size in bytes for high-priority/low-fee transactions when creating a block"`
+	TemplateFeeDelta    float64       `long:"templatefeedelta" description:"Change in BTC of the total fees of the transactions in the memory pool which makes block templates stale for getblocktemplate long poll clients"`
+	GetWorkKeys         []string      `long:"getworkkey" description:"DEPRECATED -- Use the --miningaddr option instead"`
+	NoPeerBloomFilters  bool          `long:"nopeerbloomfilters" description:"Disable bloom filtering support"`
+	SigCacheMaxSize     uint          `long:"sigcachemaxsize" description:"The maximum number of entries in the signature verification cache"`
+	BlocksOnly          bool          `long:"blocksonly" description:"Do not accept transactions from remote peers."`
+	PersistMempool      bool          `long:"persistmempool" description:"Save the memory pool to the data directory on shutdown and load it on startup"`
+	TxIndex             bool          `long:"txindex" description:"Maintain a full hash-based transaction index which makes all transactions available via the getrawtransaction RPC"`
+	DropTxIndex         bool          `long:"droptxindex" description:"Deletes the hash-based transaction index from the database on start up and then exits."`
+	AddrIndex           bool          `long:"addrindex" description:"Maintain a full address-based transaction index which makes the searchrawtransactions RPC available"`
+	DropAddrIndex       bool          `long:"dropaddrindex" description:"Deletes the address-based transaction index from the database on start up and then exits."`
+	UtxoByScriptIndex   bool          `long:"utxobyscriptindex" description:"Maintain an index of the unspent transaction outputs by the script they pay to"`
+	DropUtxoByScript    bool          `long:"droputxobyscriptindex" description:"Deletes the unspent outputs by script index from the database on start up and then exits."`
+	CFIndex             bool          `long:"cfindex" description:"Maintain an index of the BIP0158 committed filters of all blocks for light clients"`
+	DropCFIndex         bool          `long:"dropcfindex" description:"Deletes the committed filter index from the database on start up and then exits."`
+	SpendIndex          bool          `long:"spendindex" description:"Maintain an index of the transaction inputs which spend every spent output"`
+	DropSpendIndex      bool          `long:"dropspendindex" description:"Deletes the spent outputs index from the database on start up and then exits."`
+	TimeIndex           bool          `long:"timeindex" description:"Maintain an index of all blocks by their timestamps and median times past"`
+	DropTimeIndex       bool          `long:"droptimeindex" description:"Deletes the block time index from the database on start up and then exits."`
+	Prune               uint64        `long:"prune" description:"Reduce storage requirements by deleting the data for old blocks once the stored block data exceeds the target size in MiB -- The minimum target is 550 and 0 disables pruning"`
+	StoreInvalidBlocks  bool          `long:"storeinvalidblocks" description:"Store blocks which fail validation when connecting them, such as due to a local validation bug, instead of discarding them so they can be validated again with the reconsiderblock RPC"`
+	AssumeValid         string        `long:"assumevalid" description:"Skip script validation for the ancestors of the block with the given hash once they are buried deeply enough under the best known header chain containing it -- The zero hash disables the optimization"`
+	onionlookup         func(string) ([]net.IP, error)
+	lookup              func(string) ([]net.IP, error)
+	oniondial           func(string, string) (net.Conn, error)
+	dial                func(string, string) (net.Conn, error)
+	miningAddrs         []colxutil.Address
+	minRelayTxFee       colxutil.Amount
+	templateFeeDelta    colxutil.Amount
+	assumeValid         *wire.ShaHash
+	addCheckpoints      []chaincfg.Checkpoint
 }
 
 // serviceOptions defines the configuration options for btcd as a service on
@@ -299,6 +351,53 @@ func normalizeAddresses(addrs []string, defaultPort string) []string {
 	return removeDuplicateAddresses(addrs)
 }
 
+// newCheckpointFromStr parses a checkpoint in the form of "<height>:<hash>".
+func newCheckpointFromStr(checkpoint string) (chaincfg.Checkpoint, error) {
+	parts := strings.Split(checkpoint, ":")
+	if len(parts) != 2 {
+		return chaincfg.Checkpoint{}, fmt.Errorf("unable to parse "+
+			"checkpoint %q -- use the syntax <height>:<hash>",
+			checkpoint)
+	}
+
+	height, err := strconv.ParseInt(parts[0], 10, 32)
+	if err != nil || height < 0 {
+		return chaincfg.Checkpoint{}, fmt.Errorf("unable to parse "+
+			"checkpoint %q due to malformed height", checkpoint)
+	}
+	if len(parts[1]) != wire.MaxHashStringSize {
+		return chaincfg.Checkpoint{}, fmt.Errorf("unable to parse "+
+			"checkpoint %q due to malformed hash", checkpoint)
+	}
+	hash, err := wire.NewShaHashFromStr(parts[1])
+	if err != nil {
+		return chaincfg.Checkpoint{}, fmt.Errorf("unable to parse "+
+			"checkpoint %q due to malformed hash", checkpoint)
+	}
+
+	return chaincfg.Checkpoint{
+		Height: int32(height),
+		Hash:   hash,
+	}, nil
+}
+
+// parseCheckpoints checks the checkpoint strings for valid syntax
+// ('<height>:<hash>') and parses them to chaincfg.Checkpoint instances.
+func parseCheckpoints(checkpointStrings []string) ([]chaincfg.Checkpoint, error) {
+	if len(checkpointStrings) == 0 {
+		return nil, nil
+	}
+	checkpoints := make([]chaincfg.Checkpoint, len(checkpointStrings))
+	for i, cpString := range checkpointStrings {
+		checkpoint, err := newCheckpointFromStr(cpString)
+		if err != nil {
+			return nil, err
+		}
+		checkpoints[i] = checkpoint
+	}
+	return checkpoints, nil
+}
+
 // filesExists reports whether the named file or directory exists.
 func fileExists(name string) bool {
 	if _, err := os.Stat(name); err != nil {
@@ -333,28 +432,40 @@ func newConfigParser(cfg *config, so *serviceOptions, options flags.Options) *fl
 func loadConfig() (*config, []string, error) {
 	// Default config.
 	cfg := config{
-		ConfigFile:        defaultConfigFile,
-		DebugLevel:        defaultLogLevel,
-		MaxPeers:          defaultMaxPeers,
-		BanDuration:       defaultBanDuration,
-		BanThreshold:      defaultBanThreshold,
-		RPCMaxClients:     defaultMaxRPCClients,
-		RPCMaxWebsockets:  defaultMaxRPCWebsockets,
-		DataDir:           defaultDataDir,
-		LogDir:            defaultLogDir,
-		DbType:            defaultDbType,
-		RPCKey:            defaultRPCKeyFile,
-		RPCCert:           defaultRPCCertFile,
-		MinRelayTxFee:     defaultMinRelayTxFee.ToBTC(),
-		FreeTxRelayLimit:  defaultFreeTxRelayLimit,
-		BlockMinSize:      defaultBlockMinSize,
-		BlockMaxSize:      defaultBlockMaxSize,
-		BlockPrioritySize: defaultBlockPrioritySize,
-		MaxOrphanTxs:      defaultMaxOrphanTransactions,
-		SigCacheMaxSize:   defaultSigCacheMaxSize,
-		Generate:          defaultGenerate,
-		TxIndex:           defaultTxIndex,
-		AddrIndex:         defaultAddrIndex,
+		ConfigFile:          defaultConfigFile,
+		DebugLevel:          defaultLogLevel,
+		MaxPeers:            defaultMaxPeers,
+		MaxConnsPerNetGroup: defaultMaxConnsPerNetGroup,
+		BanDuration:         defaultBanDuration,
+		BanThreshold:        defaultBanThreshold,
+		MinProtocolVersion:  peer.DefaultMinAcceptableProtocolVersion,
+		RPCMaxClients:       defaultMaxRPCClients,
+		RPCLongPollTimeout:  defaultRPCLongPollTimeout,
+		RPCMaxWebsockets:    defaultMaxRPCWebsockets,
+		DataDir:             defaultDataDir,
+		LogDir:              defaultLogDir,
+		DbType:              defaultDbType,
+		RPCKey:              defaultRPCKeyFile,
+		RPCCert:             defaultRPCCertFile,
+		MinRelayTxFee:       defaultMinRelayTxFee.ToBTC(),
+		FreeTxRelayLimit:    defaultFreeTxRelayLimit,
+		BlockMinSize:        defaultBlockMinSize,
+		BlockMaxSize:        defaultBlockMaxSize,
+		BlockPrioritySize:   defaultBlockPrioritySize,
+		TemplateFeeDelta:    defaultTemplateFeeDelta.ToBTC(),
+		MaxOrphanTxs:        defaultMaxOrphanTransactions,
+		DataCarrierSize:     defaultDataCarrierSize,
+		OrphanTTL:           defaultOrphanTTL,
+		AncestorLimit:       defaultLimitAncestorCount,
+		AncestorSizeLimit:   defaultLimitAncestorSize,
+		DescendantLimit:     defaultLimitDescendantCount,
+		DescendantSizeLimit: defaultLimitDescendantSize,
+		MaxMempool:          defaultMaxMempool,
+		SigCacheMaxSize:     defaultSigCacheMaxSize,
+		Generate:            defaultGenerate,
+		GenProcLimit:        defaultGenProcLimit,
+		TxIndex:             defaultTxIndex,
+		AddrIndex:           defaultAddrIndex,
 	}
 
 	// Service options which are only added on Windows.
@@ -543,6 +654,44 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Don't allow a minimum protocol version which is newer than the
+	// version we support since no peer could ever be accepted.
+	if cfg.MinProtocolVersion > peer.MaxProtocolVersion {
+		str := "%s: The minprotocolversion option may not be greater " +
+			"than %d -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, peer.MaxProtocolVersion,
+			cfg.MinProtocolVersion)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// There must be at least one outbound connection allowed per network
+	// group.
+	if cfg.MaxConnsPerNetGroup < 1 {
+		str := "%s: The maxconnspernetgroup option may not be less " +
+			"than 1 -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxConnsPerNetGroup)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The maximum receive payload must allow blocks to be received and
+	// may not exceed the protocol maximum.
+	if cfg.MaxRecvPayload != 0 &&
+		(cfg.MaxRecvPayload < wire.MaxBlockPayload ||
+			cfg.MaxRecvPayload > wire.MaxMessagePayload) {
+
+		str := "%s: The maxrecvpayload option must be 0 or in the " +
+			"range %d to %d -- parsed [%d]"
+		err := fmt.Errorf(str, funcName, wire.MaxBlockPayload,
+			wire.MaxMessagePayload, cfg.MaxRecvPayload)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// --addPeer and --connect do not mix.
 	if len(cfg.AddPeers) > 0 && len(cfg.ConnectPeers) > 0 {
 		str := "%s: the --addpeer and --connect options can not be " +
@@ -593,6 +742,32 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Audit the default set of sensitive RPC methods if none were
+	// specified.
+	if len(cfg.RPCAuditMethods) == 0 {
+		cfg.RPCAuditMethods = defaultRPCAuditMethods
+	}
+	if cfg.RPCAuditFile != "" {
+		cfg.RPCAuditFile = cleanAndExpandPath(cfg.RPCAuditFile)
+	}
+
+	// Validate the methods available to the limited user and the audited
+	// methods.
+	for _, methods := range [][]string{cfg.RPCLimitMethods,
+		cfg.RPCAuditMethods} {
+
+		for _, method := range methods {
+			if !isRPCMethod(method) {
+				str := "%s: unknown RPC method %q in " +
+					"--rpclimitmethod or --rpcauditmethod"
+				err := fmt.Errorf(str, funcName, method)
+				fmt.Fprintln(os.Stderr, err)
+				fmt.Fprintln(os.Stderr, usageMessage)
+				return nil, nil, err
+			}
+		}
+	}
+
 	// The RPC server is disabled if no username or password is provided.
 	if (cfg.RPCUser == "" || cfg.RPCPass == "") &&
 		(cfg.RPCLimitUser == "" || cfg.RPCLimitPass == "") {
@@ -612,6 +787,30 @@ func loadConfig() (*config, []string, error) {
 		}
 	}
 
+	// Default to relaying the transaction versions defined on the network
+	// and validate the maxtxversion.
+	if cfg.MaxTxVersion == 0 {
+		cfg.MaxTxVersion = activeNetParams.MaxStandardTxVersion
+	}
+	if cfg.MaxTxVersion < 1 {
+		str := "%s: the maxtxversion option may not be less than 1 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxTxVersion)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Validate the datacarriersize.
+	if cfg.DataCarrierSize < 0 {
+		str := "%s: the datacarriersize option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.DataCarrierSize)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Validate the the minrelaytxfee.
 	cfg.minRelayTxFee, err = colxutil.NewAmount(cfg.MinRelayTxFee)
 	if err != nil {
@@ -622,6 +821,19 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// Validate the the templatefeedelta.
+	cfg.templateFeeDelta, err = colxutil.NewAmount(cfg.TemplateFeeDelta)
+	if err == nil && cfg.templateFeeDelta < 0 {
+		err = errors.New("the delta may not be negative")
+	}
+	if err != nil {
+		str := "%s: invalid templatefeedelta: %v"
+		err := fmt.Errorf(str, funcName, err)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Limit the max block size to a sane value.
 	if cfg.BlockMaxSize < blockMaxSizeMin || cfg.BlockMaxSize >
 		blockMaxSizeMax {
@@ -645,6 +857,58 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// The orphan expiration may not be negative.
+	if cfg.OrphanTTL < 0 {
+		str := "%s: The orphanttl option may not be less than 0 " +
+			"-- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.OrphanTTL)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Long poll requests must time out.
+	if cfg.RPCLongPollTimeout <= 0 {
+		str := "%s: The rpclongpolltimeout option must be greater " +
+			"than 0 -- parsed [%v]"
+		err := fmt.Errorf(str, funcName, cfg.RPCLongPollTimeout)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The memory pool size limit may not be negative.
+	if cfg.MaxMempool < 0 {
+		str := "%s: The maxmempool option may not be less than 0 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.MaxMempool)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// The transaction chain limits may not be negative.
+	chainLimits := []struct {
+		option string
+		value  int
+	}{
+		{"limitancestorcount", cfg.AncestorLimit},
+		{"limitancestorsize", cfg.AncestorSizeLimit},
+		{"limitdescendantcount", cfg.DescendantLimit},
+		{"limitdescendantsize", cfg.DescendantSizeLimit},
+	}
+	for _, limit := range chainLimits {
+		if limit.value < 0 {
+			str := "%s: The %s option may not be less than 0 " +
+				"-- parsed [%d]"
+			err := fmt.Errorf(str, funcName, limit.option,
+				limit.value)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+	}
+
 	// Limit the block priority and minimum block sizes to max block size.
 	cfg.BlockPrioritySize = minUint32(cfg.BlockPrioritySize, cfg.BlockMaxSize)
 	cfg.BlockMinSize = minUint32(cfg.BlockMinSize, cfg.BlockMaxSize)
@@ -681,6 +945,113 @@ func loadConfig() (*config, []string, error) {
 		return nil, nil, err
 	}
 
+	// --utxobyscriptindex and --droputxobyscriptindex do not mix.
+	if cfg.UtxoByScriptIndex && cfg.DropUtxoByScript {
+		err := fmt.Errorf("%s: the --utxobyscriptindex and "+
+			"--droputxobyscriptindex options may not be activated "+
+			"at the same time", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --utxobyscriptindex and --droptxindex do not mix.
+	if cfg.UtxoByScriptIndex && cfg.DropTxIndex {
+		err := fmt.Errorf("%s: the --utxobyscriptindex and --droptxindex "+
+			"options may not be activated at the same time "+
+			"because the unspent outputs by script index relies on "+
+			"the transaction index", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --cfindex and --dropcfindex do not mix.
+	if cfg.CFIndex && cfg.DropCFIndex {
+		err := fmt.Errorf("%s: the --cfindex and --dropcfindex options "+
+			"may not be activated at the same time", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --cfindex and --droptxindex do not mix.
+	if cfg.CFIndex && cfg.DropTxIndex {
+		err := fmt.Errorf("%s: the --cfindex and --droptxindex options "+
+			"may not be activated at the same time because the "+
+			"committed filter index relies on the transaction index",
+			funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --spendindex and --dropspendindex do not mix.
+	if cfg.SpendIndex && cfg.DropSpendIndex {
+		err := fmt.Errorf("%s: the --spendindex and --dropspendindex "+
+			"options may not be activated at the same time", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --timeindex and --droptimeindex do not mix.
+	if cfg.TimeIndex && cfg.DropTimeIndex {
+		err := fmt.Errorf("%s: the --timeindex and --droptimeindex "+
+			"options may not be activated at the same time", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --prune must have a reasonable target.
+	if cfg.Prune != 0 && cfg.Prune < minPruneTarget {
+		str := "%s: the --prune target must be at least %d MiB"
+		err := fmt.Errorf(str, funcName, minPruneTarget)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// --prune does not mix with the optional indexes since they require
+	// the data for all blocks.
+	if cfg.Prune != 0 && (cfg.TxIndex || cfg.AddrIndex ||
+		cfg.UtxoByScriptIndex || cfg.CFIndex || cfg.SpendIndex ||
+		cfg.TimeIndex) {
+
+		err := fmt.Errorf("%s: the --prune option may not be activated "+
+			"at the same time as the --txindex, --addrindex, "+
+			"--utxobyscriptindex, --cfindex, --spendindex, or "+
+			"--timeindex options because the indexes require the data "+
+			"for all blocks", funcName)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
+	// Parse the assumevalid block hash.
+	if cfg.AssumeValid != "" {
+		hash, err := wire.NewShaHashFromStr(cfg.AssumeValid)
+		if err != nil {
+			str := "%s: assumevalid hash '%s' failed to decode: %v"
+			err := fmt.Errorf(str, funcName, cfg.AssumeValid, err)
+			fmt.Fprintln(os.Stderr, err)
+			fmt.Fprintln(os.Stderr, usageMessage)
+			return nil, nil, err
+		}
+		cfg.assumeValid = hash
+	}
+
+	// Parse the additional checkpoints.  Conflicts with the checkpoints of
+	// the active network are detected when the chain is created.
+	cfg.addCheckpoints, err = parseCheckpoints(cfg.AddCheckpoints)
+	if err != nil {
+		err := fmt.Errorf("%s: %v", funcName, err)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Check getwork keys are valid and saved parsed versions.
 	cfg.miningAddrs = make([]colxutil.Address, 0, len(cfg.GetWorkKeys)+
 		len(cfg.MiningAddrs))
@@ -724,6 +1095,17 @@ func loadConfig() (*config, []string, error) {
 		cfg.miningAddrs = append(cfg.miningAddrs, addr)
 	}
 
+	// Ensure the number of mining workers is either positive or -1 for the
+	// default.
+	if cfg.GenProcLimit == 0 || cfg.GenProcLimit < -1 {
+		str := "%s: the genproclimit option must be positive or -1 " +
+			"-- parsed [%d]"
+		err := fmt.Errorf(str, funcName, cfg.GenProcLimit)
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, usageMessage)
+		return nil, nil, err
+	}
+
 	// Ensure there is at least one mining address when the generate flag is
 	// set.
 	if cfg.Generate && len(cfg.MiningAddrs) == 0 {
@@ -816,13 +1198,8 @@ func loadConfig() (*config, []string, error) {
 				"specified proxy user credentials")
 		}
 
-		proxy := &socks.Proxy{
-			Addr:         cfg.Proxy,
-			Username:     cfg.ProxyUser,
-			Password:     cfg.ProxyPass,
-			TorIsolation: cfg.TorIsolation,
-		}
-		cfg.dial = proxy.Dial
+		cfg.dial = proxyDial(cfg.Proxy, cfg.ProxyUser, cfg.ProxyPass,
+			cfg.TorIsolation)
 		if !cfg.NoOnion {
 			cfg.lookup = func(host string) ([]net.IP, error) {
 				return torLookupIP(host, cfg.Proxy)
@@ -854,15 +1231,8 @@ func loadConfig() (*config, []string, error) {
 				"specified onionproxy user credentials ")
 		}
 
-		cfg.oniondial = func(a, b string) (net.Conn, error) {
-			proxy := &socks.Proxy{
-				Addr:         cfg.OnionProxy,
-				Username:     cfg.OnionProxyUser,
-				Password:     cfg.OnionProxyPass,
-				TorIsolation: cfg.TorIsolation,
-			}
-			return proxy.Dial(a, b)
-		}
+		cfg.oniondial = proxyDial(cfg.OnionProxy, cfg.OnionProxyUser,
+			cfg.OnionProxyPass, cfg.TorIsolation)
 		cfg.onionlookup = func(host string) ([]net.IP, error) {
 			return torLookupIP(host, cfg.OnionProxy)
 		}
@@ -952,6 +1322,21 @@ func createDefaultConfigFile(destinationPath string) error {
 	return nil
 }
 
+// proxyDial returns a dial function which connects through the SOCKS5 proxy at
+// the passed address with the passed credentials.  When Tor stream isolation is
+// enabled, the credentials are ignored and every connection is made with its
+// own random credentials instead, which makes Tor use a separate circuit for
+// each of them.
+func proxyDial(proxyAddr, username, password string, torIsolation bool) func(string, string) (net.Conn, error) {
+	proxy := &socks.Proxy{
+		Addr:         proxyAddr,
+		Username:     username,
+		Password:     password,
+		TorIsolation: torIsolation,
+	}
+	return proxy.Dial
+}
+
 // btcdDial connects to the address on the named network using the appropriate
 // dial function depending on the address and configuration options.  For
 // example, .onion addresses will be dialed using the onion specific proxy if
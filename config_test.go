@@ -1,11 +1,18 @@
 package main
 
 import (
+	"errors"
+	"io"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"sync"
 	"testing"
+
+	"github.com/btcsuite/go-socks/socks"
 )
 
 var (
@@ -45,3 +52,322 @@ func TestCreateDefaultConfigFile(t *testing.T) {
 		t.Error("Could not find rpcpass in generated default config file.")
 	}
 }
+
+// TestParseCheckpoints ensures checkpoints in the form of <height>:<hash> are
+// parsed and malformed ones are rejected.
+func TestParseCheckpoints(t *testing.T) {
+	const hash = "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+	tests := []struct {
+		in      string
+		height  int32
+		invalid bool
+	}{
+		{in: "0:" + hash, height: 0},
+		{in: "11111:" + hash, height: 11111},
+		{in: hash, invalid: true},
+		{in: "1:2:" + hash, invalid: true},
+		{in: "-1:" + hash, invalid: true},
+		{in: "x:" + hash, invalid: true},
+		{in: "1:" + hash[:62], invalid: true},
+		{in: "1:" + hash[:63] + "z", invalid: true},
+	}
+
+	for i, test := range tests {
+		checkpoints, err := parseCheckpoints([]string{test.in})
+		if test.invalid {
+			if err == nil {
+				t.Errorf("parseCheckpoints #%d: did not reject %q",
+					i, test.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCheckpoints #%d: unexpected error: %v", i,
+				err)
+			continue
+		}
+		if len(checkpoints) != 1 || checkpoints[0].Height != test.height ||
+			checkpoints[0].Hash.String() != hash {
+
+			t.Errorf("parseCheckpoints #%d: unexpected result %v", i,
+				checkpoints)
+		}
+	}
+}
+
+// socksCredentials describes the credentials a client authenticated to a mock
+// SOCKS5 proxy with.  Clients which did not authenticate have empty
+// credentials.
+type socksCredentials struct {
+	username string
+	password string
+}
+
+// mockSOCKSServer is a minimal SOCKS5 proxy which records the credentials of
+// every connect request and then closes the connection.
+type mockSOCKSServer struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mtx         sync.Mutex
+	credentials []socksCredentials
+	targets     []string
+}
+
+// newMockSOCKSServer starts a mock SOCKS5 proxy listening on a local port.
+func newMockSOCKSServer(t *testing.T) *mockSOCKSServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	s := &mockSOCKSServer{listener: listener}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				defer conn.Close()
+				s.handle(conn)
+			}()
+		}
+	}()
+	return s
+}
+
+// handle serves a single client connection.  Username and password
+// authentication is selected whenever the client offers it.
+func (s *mockSOCKSServer) handle(conn net.Conn) error {
+	// Greeting: version, number of methods, and the methods.
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return err
+	}
+	methods := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return err
+	}
+	method := byte(0x00)
+	for _, m := range methods {
+		if m == 0x02 {
+			method = m
+		}
+	}
+	if _, err := conn.Write([]byte{0x05, method}); err != nil {
+		return err
+	}
+
+	// Username and password authentication per RFC 1929.
+	var creds socksCredentials
+	if method == 0x02 {
+		readString := func() (string, error) {
+			if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+				return "", err
+			}
+			str := make([]byte, buf[0])
+			_, err := io.ReadFull(conn, str)
+			return string(str), err
+		}
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		var err error
+		if creds.username, err = readString(); err != nil {
+			return err
+		}
+		if creds.password, err = readString(); err != nil {
+			return err
+		}
+		if _, err := conn.Write([]byte{0x01, 0x00}); err != nil {
+			return err
+		}
+	}
+
+	// Connect request: version, command, reserved, and the address.
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	var host string
+	switch header[3] {
+	case 0x01:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return err
+		}
+		host = net.IP(ip).String()
+	case 0x03:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return err
+		}
+		name := make([]byte, buf[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return err
+		}
+		host = string(name)
+	case 0x04:
+		ip := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return err
+		}
+		host = net.IP(ip).String()
+	default:
+		return errors.New("unsupported address type")
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return err
+	}
+
+	s.mtx.Lock()
+	s.credentials = append(s.credentials, creds)
+	s.targets = append(s.targets, net.JoinHostPort(host,
+		strconv.Itoa(int(port[0])<<8|int(port[1]))))
+	s.mtx.Unlock()
+
+	// Report success with an unspecified bound address.
+	_, err := conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// Close stops the mock proxy and waits for its connections to finish.
+func (s *mockSOCKSServer) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// TestProxyDialTorIsolation ensures connections made through a SOCKS5 proxy
+// with Tor stream isolation enabled each use distinct random credentials, even
+// when they are made concurrently, while connections without isolation use the
+// configured credentials and connections which don't go through a proxy are
+// not affected.
+func TestProxyDialTorIsolation(t *testing.T) {
+	server := newMockSOCKSServer(t)
+	defer server.Close()
+	proxyAddr := server.listener.Addr().String()
+
+	// dialAll makes the passed number of concurrent connections with the
+	// passed dial function and returns the credentials recorded for them.
+	dialAll := func(name string, dial func(string, string) (net.Conn, error), num int) []socksCredentials {
+		server.mtx.Lock()
+		server.credentials = nil
+		server.targets = nil
+		server.mtx.Unlock()
+
+		var wg sync.WaitGroup
+		errs := make(chan error, num)
+		for i := 0; i < num; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				conn, err := dial("tcp", "exampleonionaddr.onion:9999")
+				if err != nil {
+					errs <- err
+					return
+				}
+				defer conn.Close()
+
+				// The remote address is the address dialed through
+				// the proxy, which is what peers are created with.
+				addr, ok := conn.RemoteAddr().(*socks.ProxiedAddr)
+				if !ok || addr.Host != "exampleonionaddr.onion" ||
+					addr.Port != 9999 {
+
+					errs <- errors.New("unexpected remote address " +
+						conn.RemoteAddr().String())
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatalf("%s: unexpected dial error: %v", name, err)
+		}
+
+		server.mtx.Lock()
+		defer server.mtx.Unlock()
+		for _, target := range server.targets {
+			if target != "exampleonionaddr.onion:9999" {
+				t.Errorf("%s: unexpected target %s", name, target)
+			}
+		}
+		return append([]socksCredentials(nil), server.credentials...)
+	}
+
+	const numConns = 20
+	creds := dialAll("isolation", proxyDial(proxyAddr, "user", "pass", true),
+		numConns)
+	if len(creds) != numConns {
+		t.Fatalf("isolation: got %d connections, want %d", len(creds),
+			numConns)
+	}
+	seenUsers := make(map[string]struct{})
+	seen := make(map[socksCredentials]struct{})
+	for _, c := range creds {
+		if c.username == "" || c.password == "" || c.username == "user" {
+			t.Errorf("isolation: connection did not use random "+
+				"credentials: %+v", c)
+		}
+		seenUsers[c.username] = struct{}{}
+		seen[c] = struct{}{}
+	}
+	if len(seen) != numConns || len(seenUsers) != numConns {
+		t.Errorf("isolation: got %d distinct credentials for %d "+
+			"connections", len(seen), numConns)
+	}
+
+	// Without isolation the configured credentials are used for every
+	// connection, or none when there are none.
+	creds = dialAll("no isolation", proxyDial(proxyAddr, "user", "pass",
+		false), 3)
+	for _, c := range creds {
+		if c != (socksCredentials{"user", "pass"}) {
+			t.Errorf("no isolation: unexpected credentials %+v", c)
+		}
+	}
+	creds = dialAll("no credentials", proxyDial(proxyAddr, "", "", false), 3)
+	for _, c := range creds {
+		if c != (socksCredentials{}) {
+			t.Errorf("no credentials: unexpected credentials %+v", c)
+		}
+	}
+
+	// Onion addresses are dialed through the isolating onion proxy while
+	// other addresses are dialed directly without going through it.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer listener.Close()
+	defer func(c *config) { cfg = c }(cfg)
+	cfg = &config{
+		oniondial: proxyDial(proxyAddr, "", "", true),
+		dial:      net.Dial,
+	}
+	creds = dialAll("onion", btcdDial, 2)
+	if len(creds) != 2 || creds[0] == creds[1] ||
+		creds[0] == (socksCredentials{}) {
+
+		t.Errorf("onion: unexpected credentials %+v", creds)
+	}
+	conn, err := btcdDial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to dial directly: %v", err)
+	}
+	conn.Close()
+	if _, ok := conn.RemoteAddr().(*net.TCPAddr); !ok {
+		t.Errorf("direct dial has unexpected remote address %v",
+			conn.RemoteAddr())
+	}
+	server.mtx.Lock()
+	numProxied := len(server.credentials)
+	server.mtx.Unlock()
+	if numProxied != 2 {
+		t.Errorf("direct dial went through the proxy")
+	}
+}
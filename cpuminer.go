@@ -8,9 +8,11 @@ package main
 import (
 	"errors"
 	"fmt"
+	"math/big"
 	"math/rand"
 	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/tinhnguyenhn/colxd/blockchain"
@@ -31,12 +33,19 @@ const (
 	// update to the hashes per second monitor.
 	hpsUpdateSecs = 10
 
-	// hashUpdateSec is the number of seconds each worker waits in between
+	// hashUpdateSec is the number of seconds the miner waits in between
 	// notifying the speed monitor with how many hashes have been completed
-	// while they are actively searching for a solution.  This is done to
+	// while it is actively searching for a solution.  This is done to
 	// reduce the amount of syncs between the workers that must be done to
-	// keep track of the hashes per second.
+	// keep track of the hashes per second.  The miner also checks for
+	// stale work and updates the timestamp of the block at this interval.
 	hashUpdateSecs = 15
+
+	// nonceCheckInterval is the number of nonces each worker searches in
+	// between checking whether it should stop searching and adding the
+	// hashes it has completed to the total of the search.  It must be a
+	// power of two.
+	nonceCheckInterval = 1 << 16
 )
 
 var (
@@ -48,28 +57,127 @@ var (
 
 // CPUMiner provides facilities for solving blocks (mining) using the CPU in
 // a concurrency-safe manner.  It consists of two main goroutines -- a speed
-// monitor and a controller for worker goroutines which generate and solve
-// blocks.  The number of goroutines can be set via the SetMaxGoRoutines
-// function, but the default is based on the number of processor cores in the
-// system which is typically sufficient.
+// monitor and a block generator which generates block templates and solves
+// them.  The nonce space of each block is searched by several worker
+// goroutines in parallel.  The number of workers can be set via the
+// SetNumWorkers function, but the default is based on the number of processor
+// cores in the system which is typically sufficient.
 type CPUMiner struct {
 	sync.Mutex
 	policy            *mining.Policy
 	txSource          mining.TxSource
 	server            *server
-	numWorkers        uint32
+	numWorkers        uint32 // Atomic access only.
 	started           bool
 	discreteMining    bool
 	submitBlockLock   sync.Mutex
 	wg                sync.WaitGroup
-	workerWg          sync.WaitGroup
-	updateNumWorkers  chan struct{}
 	queryHashesPerSec chan float64
 	updateHashes      chan uint64
 	speedMonitorQuit  chan struct{}
 	quit              chan struct{}
 }
 
+// nonceSearch houses the state of a search through the nonce space of a block
+// header by several workers which each search a separate part of it.
+type nonceSearch struct {
+	wg sync.WaitGroup
+
+	// solved receives the first nonce found by any of the workers which
+	// solves the block.  Only the first one is kept, so there is only ever
+	// a single solution for a header.
+	solved chan uint32
+
+	// done is closed once all workers have returned, either because they
+	// searched their entire part of the nonce space, found a solution, or
+	// were stopped.
+	done chan struct{}
+
+	// quit is closed to stop the workers.
+	quit chan struct{}
+}
+
+// startNonceSearch starts the passed number of workers which search the nonce
+// space of the passed header for a nonce which makes it hash to a value less
+// than the passed target difficulty.  The nonce space is split into equally
+// sized parts, one for each worker.  The workers add the number of hashes they
+// complete to the passed counter.
+func startNonceSearch(header wire.BlockHeader, targetDifficulty *big.Int, numWorkers uint32, hashesCompleted *uint64) *nonceSearch {
+	s := &nonceSearch{
+		solved: make(chan uint32, 1),
+		done:   make(chan struct{}),
+		quit:   make(chan struct{}),
+	}
+	partSize := (uint64(maxNonce) + 1) / uint64(numWorkers)
+	for i := uint64(0); i < uint64(numWorkers); i++ {
+		start := uint32(i * partSize)
+		end := uint32((i+1)*partSize - 1)
+		if i == uint64(numWorkers)-1 {
+			end = maxNonce
+		}
+		s.wg.Add(1)
+		go s.searchNonces(header, targetDifficulty, start, end,
+			hashesCompleted)
+	}
+	go func() {
+		s.wg.Wait()
+		close(s.done)
+	}()
+	return s
+}
+
+// searchNonces searches the nonces from start through end, inclusive, for one
+// which solves the passed header.  It must be run as a goroutine.
+func (s *nonceSearch) searchNonces(header wire.BlockHeader, targetDifficulty *big.Int, start, end uint32, hashesCompleted *uint64) {
+	defer s.wg.Done()
+
+	var hashes uint64
+	defer func() {
+		atomic.AddUint64(hashesCompleted, hashes)
+	}()
+	for nonce := start; ; nonce++ {
+		// Periodically check whether the search was stopped and
+		// account for the completed hashes.
+		if nonce%nonceCheckInterval == 0 {
+			select {
+			case <-s.quit:
+				return
+			default:
+			}
+			atomic.AddUint64(hashesCompleted, hashes)
+			hashes = 0
+		}
+
+		// Update the nonce and hash the block header.  Each hash is
+		// actually a double sha256 (two hashes), so increment the
+		// number of hashes completed for each attempt accordingly.
+		header.Nonce = nonce
+		hash := header.BlockSha()
+		hashes += 2
+
+		// The block is solved when the new block hash is less than the
+		// target difficulty.  Yay!  Another worker might have found a
+		// solution already, in which case this one is discarded.
+		if blockchain.ShaHashToBig(&hash).Cmp(targetDifficulty) <= 0 {
+			select {
+			case s.solved <- nonce:
+			default:
+			}
+			return
+		}
+
+		if nonce == end {
+			return
+		}
+	}
+}
+
+// stop stops all of the workers of the search and waits for them to return.
+func (s *nonceSearch) stop() {
+	close(s.quit)
+	<-s.done
+}
+
 // speedMonitor handles tracking the number of hashes per second the mining
 // process is performing.  It must be run as a goroutine.
 func (m *CPUMiner) speedMonitor() {
@@ -139,7 +247,7 @@ func (m *CPUMiner) submitBlock(block *colxutil.Block) bool {
 	if err != nil {
 		// Anything other than a rule violation is an unexpected error,
 		// so log that error as an internal error.
-		if _, ok := err.(blockchain.RuleError); !ok {
+		if !errors.As(err, new(blockchain.RuleError)) {
 			minrLog.Errorf("Unexpected error while processing "+
 				"block submitted via CPU miner: %v", err)
 			return false
@@ -162,18 +270,20 @@ func (m *CPUMiner) submitBlock(block *colxutil.Block) bool {
 
 // solveBlock attempts to find some combination of a nonce, extra nonce, and
 // current timestamp which makes the passed block hash to a value less than the
-// target difficulty.  The timestamp is updated periodically and the passed
-// block is modified with all tweaks during this process.  This means that
-// when the function returns true, the block is ready for submission.
+// target difficulty.  The nonce space is searched by the configured number of
+// workers in parallel, and the extra nonce is rolled once it is exhausted.  The
+// timestamp is updated periodically and the passed block is modified with all
+// tweaks during this process.  This means that when the function returns true,
+// the block is ready for submission.
 //
 // This function will return early with false when conditions that trigger a
 // stale block such as a new block showing up or periodically when there are
 // new transactions and enough time has elapsed without finding a solution.
+// New blocks are detected as soon as the template notifier announces them.
 func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 	ticker *time.Ticker, quit chan struct{}) bool {
 
-	// Choose a random extra nonce offset for this block template and
-	// worker.
+	// Choose a random extra nonce offset for this block template.
 	enOffset, err := wire.RandomUint64()
 	if err != nil {
 		minrLog.Errorf("Unexpected error while generating random "+
@@ -185,10 +295,29 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 	header := &msgBlock.Header
 	targetDifficulty := blockchain.CompactToBig(header.Bits)
 
+	// Subscribe to notifications about new work so the search can be
+	// stopped as soon as a new block shows up instead of waiting for the
+	// next periodic check.
+	sub := m.server.templateNotifier.Subscribe()
+	defer sub.Stop()
+
 	// Initial state.
 	lastGenerated := time.Now()
 	lastTxUpdate := m.txSource.LastUpdated()
-	hashesCompleted := uint64(0)
+	var hashesCompleted uint64
+
+	// startSearch starts searching the nonce space of the current header
+	// with the configured number of workers.  The number of workers is
+	// read every time a search is started, so changes to it take effect
+	// once the extra nonce or the timestamp is updated.
+	startSearch := func() *nonceSearch {
+		numWorkers := atomic.LoadUint32(&m.numWorkers)
+		if numWorkers == 0 {
+			numWorkers = 1
+		}
+		return startNonceSearch(*header, targetDifficulty, numWorkers,
+			&hashesCompleted)
+	}
 
 	// Note that the entire extra nonce range is iterated and the offset is
 	// added relying on the fact that overflow will wrap around 0 as
@@ -196,25 +325,38 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 	for extraNonce := uint64(0); extraNonce < maxExtraNonce; extraNonce++ {
 		// Update the extra nonce in the block template with the
 		// new value by regenerating the coinbase script and
-		// setting the merkle root to the new value.  The
+		// setting the merkle root to the new value.
 		UpdateExtraNonce(msgBlock, blockHeight, extraNonce+enOffset)
 
 		// Search through the entire nonce range for a solution while
-		// periodically checking for early quit and stale block
-		// conditions along with updates to the speed monitor.
-		for i := uint32(0); i <= maxNonce; i++ {
+		// checking for early quit and stale block conditions along
+		// with periodic updates to the speed monitor.  The workers
+		// search a copy of the header, so they are stopped before it
+		// is modified.
+		search := startSearch()
+	searchLoop:
+		for {
 			select {
 			case <-quit:
+				search.stop()
 				return false
 
+			// The current block is stale once there is a new best
+			// block.
+			case id := <-sub.C():
+				if id.PrevHash != header.PrevBlock {
+					search.stop()
+					return false
+				}
+
 			case <-ticker.C:
-				m.updateHashes <- hashesCompleted
-				hashesCompleted = 0
+				m.updateHashes <- atomic.SwapUint64(&hashesCompleted, 0)
 
 				// The current block is stale if the best block
 				// has changed.
 				bestHash, _ := m.server.blockManager.chainState.Best()
 				if !header.PrevBlock.IsEqual(bestHash) {
+					search.stop()
 					return false
 				}
 
@@ -225,28 +367,34 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 				if lastTxUpdate != m.txSource.LastUpdated() &&
 					time.Now().After(lastGenerated.Add(time.Minute)) {
 
+					search.stop()
 					return false
 				}
 
+				// Restart the search with the updated timestamp.
+				search.stop()
 				UpdateBlockTime(msgBlock, m.server.blockManager)
+				search = startSearch()
 
-			default:
-				// Non-blocking select to fall through
-			}
-
-			// Update the nonce and hash the block header.  Each
-			// hash is actually a double sha256 (two hashes), so
-			// increment the number of hashes completed for each
-			// attempt accordingly.
-			header.Nonce = i
-			hash := header.BlockSha()
-			hashesCompleted += 2
-
-			// The block is solved when the new block hash is less
-			// than the target difficulty.  Yay!
-			if blockchain.ShaHashToBig(&hash).Cmp(targetDifficulty) <= 0 {
-				m.updateHashes <- hashesCompleted
+			case nonce := <-search.solved:
+				search.stop()
+				header.Nonce = nonce
+				m.updateHashes <- atomic.SwapUint64(&hashesCompleted, 0)
 				return true
+
+			// Roll the extra nonce once the entire nonce range was
+			// searched without a solution.  A solution might have
+			// been found right before the workers returned though.
+			case <-search.done:
+				select {
+				case nonce := <-search.solved:
+					header.Nonce = nonce
+					m.updateHashes <- atomic.SwapUint64(
+						&hashesCompleted, 0)
+					return true
+				default:
+				}
+				break searchLoop
 			}
 		}
 	}
@@ -254,13 +402,11 @@ func (m *CPUMiner) solveBlock(msgBlock *wire.MsgBlock, blockHeight int32,
 	return false
 }
 
-// generateBlocks is a worker that is controlled by the miningWorkerController.
-// It is self contained in that it creates block templates and attempts to solve
-// them while detecting when it is performing stale work and reacting
-// accordingly by generating a new block template.  When a block is solved, it
-// is submitted.
-//
-// It must be run as a goroutine.
+// generateBlocks is the block generator which is run by the
+// miningWorkerController.  It is self contained in that it creates block
+// templates and attempts to solve them with the workers while detecting when
+// it is performing stale work and reacting accordingly by generating a new
+// block template.  When a block is solved, it is submitted.
 func (m *CPUMiner) generateBlocks(quit chan struct{}) {
 	minrLog.Tracef("Starting generate blocks worker")
 
@@ -325,68 +471,18 @@ out:
 		}
 	}
 
-	m.workerWg.Done()
 	minrLog.Tracef("Generate blocks worker done")
 }
 
-// miningWorkerController launches the worker goroutines that are used to
-// generate block templates and solve them.  It also provides the ability to
-// dynamically adjust the number of running worker goroutines.
+// miningWorkerController runs the block generator, whose workers solve the
+// generated blocks, until the miner is stopped.
 //
 // It must be run as a goroutine.
 func (m *CPUMiner) miningWorkerController() {
-	// launchWorkers groups common code to launch a specified number of
-	// workers for generating blocks.
-	var runningWorkers []chan struct{}
-	launchWorkers := func(numWorkers uint32) {
-		for i := uint32(0); i < numWorkers; i++ {
-			quit := make(chan struct{})
-			runningWorkers = append(runningWorkers, quit)
-
-			m.workerWg.Add(1)
-			go m.generateBlocks(quit)
-		}
-	}
-
-	// Launch the current number of workers by default.
-	runningWorkers = make([]chan struct{}, 0, m.numWorkers)
-	launchWorkers(m.numWorkers)
-
-out:
-	for {
-		select {
-		// Update the number of running workers.
-		case <-m.updateNumWorkers:
-			// No change.
-			numRunning := uint32(len(runningWorkers))
-			if m.numWorkers == numRunning {
-				continue
-			}
+	m.generateBlocks(m.quit)
 
-			// Add new workers.
-			if m.numWorkers > numRunning {
-				launchWorkers(m.numWorkers - numRunning)
-				continue
-			}
-
-			// Signal the most recently created goroutines to exit.
-			for i := numRunning - 1; i >= m.numWorkers; i-- {
-				close(runningWorkers[i])
-				runningWorkers[i] = nil
-				runningWorkers = runningWorkers[:i]
-			}
-
-		case <-m.quit:
-			for _, quit := range runningWorkers {
-				close(quit)
-			}
-			break out
-		}
-	}
-
-	// Wait until all workers shut down to stop the speed monitor since
-	// they rely on being able to send updates to it.
-	m.workerWg.Wait()
+	// Stop the speed monitor once the block generator has returned since
+	// it relies on being able to send updates to it.
 	close(m.speedMonitorQuit)
 	m.wg.Done()
 }
@@ -480,17 +576,13 @@ func (m *CPUMiner) SetNumWorkers(numWorkers int32) {
 	m.Lock()
 	defer m.Unlock()
 
-	// Use default if provided value is negative.
+	// Use default if provided value is negative.  A running miner picks up
+	// the change the next time it starts searching the nonce space of a
+	// block, which happens at least every hashUpdateSecs seconds.
 	if numWorkers < 0 {
-		m.numWorkers = defaultNumWorkers
+		atomic.StoreUint32(&m.numWorkers, defaultNumWorkers)
 	} else {
-		m.numWorkers = uint32(numWorkers)
-	}
-
-	// When the miner is already running, notify the controller about the
-	// the change.
-	if m.started {
-		m.updateNumWorkers <- struct{}{}
+		atomic.StoreUint32(&m.numWorkers, uint32(numWorkers))
 	}
 }
 
@@ -501,7 +593,7 @@ func (m *CPUMiner) NumWorkers() int32 {
 	m.Lock()
 	defer m.Unlock()
 
-	return int32(m.numWorkers)
+	return int32(atomic.LoadUint32(&m.numWorkers))
 }
 
 // GenerateNBlocks generates the requested number of blocks. It is self
@@ -547,14 +639,6 @@ func (m *CPUMiner) GenerateNBlocks(n uint32) ([]*wire.ShaHash, error) {
 	defer ticker.Stop()
 
 	for {
-		// Read updateNumWorkers in case someone tries a `setgenerate` while
-		// we're generating. We can ignore it as the `generate` RPC call only
-		// uses 1 worker.
-		select {
-		case <-m.updateNumWorkers:
-		default:
-		}
-
 		// Grab the lock used for block submission, since the current block will
 		// be changing and this would otherwise end up building a new block
 		// template on a block that is in the process of becoming stale.
@@ -600,16 +684,16 @@ func (m *CPUMiner) GenerateNBlocks(n uint32) ([]*wire.ShaHash, error) {
 	}
 }
 
-// newCPUMiner returns a new instance of a CPU miner for the provided server.
+// newCPUMiner returns a new instance of a CPU miner for the provided server
+// which searches the nonce space of blocks with the passed number of workers.
 // Use Start to begin the mining process.  See the documentation for CPUMiner
 // type for more details.
-func newCPUMiner(policy *mining.Policy, s *server) *CPUMiner {
+func newCPUMiner(policy *mining.Policy, s *server, numWorkers uint32) *CPUMiner {
 	return &CPUMiner{
 		policy:            policy,
 		txSource:          s.txMemPool,
 		server:            s,
-		numWorkers:        defaultNumWorkers,
-		updateNumWorkers:  make(chan struct{}),
+		numWorkers:        numWorkers,
 		queryHashesPerSec: make(chan float64),
 		updateHashes:      make(chan uint64),
 	}
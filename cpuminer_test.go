@@ -0,0 +1,157 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/mining"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// newTestCPUMiner returns a CPU miner with the passed number of workers for the
+// server of the passed harness.  The block manager of the server processes the
+// blocks submitted by the miner on the chain of the harness, and the number of
+// submitted blocks is sent on the returned channel.
+func newTestCPUMiner(t *testing.T, h *templateHarness, numWorkers uint32) (*CPUMiner, <-chan int, func()) {
+	bm := h.server.blockManager
+	bm.msgChan = make(chan interface{})
+	submitted := make(chan int, 1)
+	quit := make(chan struct{})
+	go func() {
+		var numSubmitted int
+		for {
+			select {
+			case m := <-bm.msgChan:
+				msg := m.(processBlockMsg)
+				numSubmitted++
+				isOrphan, err := bm.chain.ProcessBlock(msg.block,
+					msg.flags)
+				best := bm.chain.BestSnapshot()
+				bm.updateChainState(best.Hash, best.Height)
+				msg.reply <- processBlockResponse{
+					isOrphan: isOrphan,
+					err:      err,
+				}
+
+			case <-quit:
+				submitted <- numSubmitted
+				return
+			}
+		}
+	}()
+
+	policy := &mining.Policy{
+		BlockMaxSize: wire.MaxBlockPayload,
+		TxMinFreeFee: 1000,
+	}
+	m := newCPUMiner(policy, h.server, numWorkers)
+	return m, submitted, func() { close(quit) }
+}
+
+// TestCPUMinerWorkers ensures the CPU miner solves blocks with several workers
+// searching the nonce space of the same block, where only a single solution is
+// submitted even though all of the workers find one right away on the
+// regression test network.
+func TestCPUMinerWorkers(t *testing.T) {
+	h := newTemplateHarness(t, 1)
+	defer h.teardown()
+
+	defer func(c *config) { cfg = c }(cfg)
+	addr, err := colxutil.DecodeAddress("mrX9vMRYLfVy1BnZbc5gZjuyaqH3ZW2ZHz",
+		&chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatalf("DecodeAddress: unexpected error: %v", err)
+	}
+	cfg = &config{miningAddrs: []colxutil.Address{addr}}
+
+	const numWorkers = 4
+	m, submitted, stop := newTestCPUMiner(t, h, numWorkers)
+	_, startHeight := h.server.blockManager.chainState.Best()
+	hashes, err := m.GenerateNBlocks(2)
+	stop()
+	if err != nil {
+		t.Fatalf("GenerateNBlocks: unexpected error: %v", err)
+	}
+
+	// Every generated block was submitted exactly once and extends the
+	// chain.
+	if n := <-submitted; n != len(hashes) {
+		t.Fatalf("unexpected number of submitted blocks - got %d, "+
+			"want %d", n, len(hashes))
+	}
+	best := h.server.blockManager.chain.BestSnapshot()
+	if !best.Hash.IsEqual(hashes[len(hashes)-1]) ||
+		best.Height != startHeight+int32(len(hashes)) {
+
+		t.Fatalf("unexpected best block %v (height %d) - want %v "+
+			"(height %d)", best.Hash, best.Height,
+			hashes[len(hashes)-1], startHeight+int32(len(hashes)))
+	}
+	if got := m.NumWorkers(); got != numWorkers {
+		t.Fatalf("unexpected number of workers - got %d, want %d", got,
+			numWorkers)
+	}
+}
+
+// TestCPUMinerStaleTip ensures the CPU miner stops searching for a solution of
+// a block as soon as it is notified about a new best block instead of waiting
+// for its periodic checks.
+func TestCPUMinerStaleTip(t *testing.T) {
+	h := newTemplateHarness(t, 1)
+	defer h.teardown()
+
+	m, _, stop := newTestCPUMiner(t, h, 2)
+	defer stop()
+	m.speedMonitorQuit = make(chan struct{})
+	m.wg.Add(1)
+	go m.speedMonitor()
+	defer func() {
+		close(m.speedMonitorQuit)
+		m.wg.Wait()
+	}()
+
+	// Create a block with a difficulty which makes it practically
+	// impossible to solve.
+	template, err := NewBlockTemplate(m.policy, h.server, nil)
+	if err != nil {
+		t.Fatalf("NewBlockTemplate: unexpected error: %v", err)
+	}
+	template.Block.Header.Bits = chaincfg.MainNetParams.PowLimitBits
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	solved := make(chan bool)
+	go func() {
+		solved <- m.solveBlock(template.Block, template.Height, ticker,
+			nil)
+	}()
+
+	// Wait for the miner to subscribe to notifications about new work and
+	// then announce a new best block.
+	notifier := h.server.templateNotifier
+	deadline := time.Now().Add(5 * time.Second)
+	for notifier.NumSubscribers() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("miner did not subscribe to template notifications")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	newTip := wire.ShaHash{0x01}
+	notifier.NotifyBlockConnected(&newTip, 0)
+
+	select {
+	case ok := <-solved:
+		if ok {
+			t.Fatal("solveBlock unexpectedly solved the block")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("solveBlock did not stop after a new best block")
+	}
+}
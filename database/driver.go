@@ -18,6 +18,10 @@ type Driver struct {
 	// database driver.  There can be only one driver with the same name.
 	DbType string
 
+	// Version is the version of the storage format of the driver as a
+	// semantic version string.  It is optional.
+	Version string
+
 	// Create is the function that will be invoked with all user-specified
 	// arguments to create the database.  This function must return
 	// ErrDbExists if the database already exists.
@@ -59,6 +63,21 @@ func SupportedDrivers() []string {
 	return supportedDBs
 }
 
+// DriverVersion returns the version of the storage format of the database
+// driver for the specified type as a semantic version string.  It is empty when
+// the driver does not report a version.
+//
+// ErrDbUnknownType will be returned if the the database type is not registered.
+func DriverVersion(dbType string) (string, error) {
+	drv, exists := drivers[dbType]
+	if !exists {
+		str := fmt.Sprintf("driver %q is not registered", dbType)
+		return "", makeError(ErrDbUnknownType, str, nil)
+	}
+
+	return drv.Version, nil
+}
+
 // Create initializes and opens a database for the specified type.  The
 // arguments are specific to the database type driver.  See the documentation
 // for the database driver for further details.
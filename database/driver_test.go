@@ -18,7 +18,7 @@ var (
 	// that iterate all supported DB types.  This allows some tests to add
 	// bogus drivers for testing purposes while still allowing other tests
 	// to easily iterate all supported drivers.
-	ignoreDbTypes = map[string]bool{"createopenfail": true}
+	ignoreDbTypes = map[string]bool{"createopenfail": true, "versionless": true}
 )
 
 // checkDbError ensures the passed error is a database.Error with an error code
@@ -135,3 +135,34 @@ func TestCreateOpenUnsupported(t *testing.T) {
 		return
 	}
 }
+
+// TestDriverVersion ensures the version of the storage format reported by a
+// driver is returned and that the version of an unsupported database type is
+// handled properly.
+func TestDriverVersion(t *testing.T) {
+	version, err := database.DriverVersion("ffldb")
+	if err != nil || version == "" {
+		t.Errorf("DriverVersion: unexpected result for ffldb - got %q, "+
+			"%v", version, err)
+	}
+
+	// A driver which does not report a version has an empty version.
+	dbType := "versionless"
+	bogusCreateDB := func(args ...interface{}) (database.DB, error) {
+		return nil, fmt.Errorf("unexpected create or open")
+	}
+	database.RegisterDriver(database.Driver{
+		DbType: dbType,
+		Create: bogusCreateDB,
+		Open:   bogusCreateDB,
+	})
+	version, err = database.DriverVersion(dbType)
+	if err != nil || version != "" {
+		t.Errorf("DriverVersion: unexpected result for %s - got %q, "+
+			"%v", dbType, version, err)
+	}
+
+	testName := "version of unsupported database type"
+	_, err = database.DriverVersion("unsupported")
+	checkDbError(t, testName, err, database.ErrDbUnknownType)
+}
@@ -51,6 +51,11 @@ const (
 	// means the database is corrupt.
 	ErrCorruption
 
+	// ErrInvalidKey indicates the database is encrypted and the provided
+	// encryption key is either missing or does not match the one the
+	// database was encrypted with.
+	ErrInvalidKey
+
 	// ****************************************
 	// Errors related to database transactions.
 	// ****************************************
@@ -118,6 +123,11 @@ const (
 	// ErrBlockNotFound instead.
 	ErrBlockRegionInvalid
 
+	// ErrBlockPruned indicates the data for a block with the provided hash
+	// has been pruned from the database.  The block header is still
+	// available.
+	ErrBlockPruned
+
 	// ***********************************
 	// Support for driver-specific errors.
 	// ***********************************
@@ -142,6 +152,7 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrDbAlreadyOpen:      "ErrDbAlreadyOpen",
 	ErrInvalid:            "ErrInvalid",
 	ErrCorruption:         "ErrCorruption",
+	ErrInvalidKey:         "ErrInvalidKey",
 	ErrTxClosed:           "ErrTxClosed",
 	ErrTxNotWritable:      "ErrTxNotWritable",
 	ErrBucketNotFound:     "ErrBucketNotFound",
@@ -154,6 +165,7 @@ var errorCodeStrings = map[ErrorCode]string{
 	ErrBlockNotFound:      "ErrBlockNotFound",
 	ErrBlockExists:        "ErrBlockExists",
 	ErrBlockRegionInvalid: "ErrBlockRegionInvalid",
+	ErrBlockPruned:        "ErrBlockPruned",
 	ErrDriverSpecific:     "ErrDriverSpecific",
 }
 
@@ -26,6 +26,7 @@ func TestErrorCodeStringer(t *testing.T) {
 		{database.ErrDbAlreadyOpen, "ErrDbAlreadyOpen"},
 		{database.ErrInvalid, "ErrInvalid"},
 		{database.ErrCorruption, "ErrCorruption"},
+		{database.ErrInvalidKey, "ErrInvalidKey"},
 		{database.ErrTxClosed, "ErrTxClosed"},
 		{database.ErrTxNotWritable, "ErrTxNotWritable"},
 		{database.ErrBucketNotFound, "ErrBucketNotFound"},
@@ -38,6 +39,7 @@ func TestErrorCodeStringer(t *testing.T) {
 		{database.ErrBlockNotFound, "ErrBlockNotFound"},
 		{database.ErrBlockExists, "ErrBlockExists"},
 		{database.ErrBlockRegionInvalid, "ErrBlockRegionInvalid"},
+		{database.ErrBlockPruned, "ErrBlockPruned"},
 		{database.ErrDriverSpecific, "ErrDriverSpecific"},
 
 		{0xffff, "Unknown ErrorCode (65535)"},
@@ -102,3 +102,89 @@ func BenchmarkBlock(b *testing.B) {
 	// Don't benchmark teardown.
 	b.StopTimer()
 }
+
+// createEncryptedBenchDB creates a new encrypted database populated with the
+// mainnet genesis block at the passed path.  The database is reopened before
+// it is returned so the metadata is read from the underlying encrypted storage
+// rather than the database cache.
+func createEncryptedBenchDB(b *testing.B, dbPath string) database.DB {
+	_ = os.RemoveAll(dbPath)
+	key := EncryptionKey{0x01}
+	db, err := database.Create("ffldb", dbPath, blockDataNet, key)
+	if err != nil {
+		b.Fatal(err)
+	}
+	err = db.Update(func(tx database.Tx) error {
+		block := colxutil.NewBlock(chaincfg.MainNetParams.GenesisBlock)
+		return tx.StoreBlock(block)
+	})
+	if err != nil {
+		db.Close()
+		b.Fatal(err)
+	}
+	if err := db.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	db, err = database.Open("ffldb", dbPath, blockDataNet, key)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return db
+}
+
+// BenchmarkBlockHeaderEncrypted benchmarks how long it takes to load the
+// mainnet genesis block header from an encrypted database.
+func BenchmarkBlockHeaderEncrypted(b *testing.B) {
+	dbPath := filepath.Join(os.TempDir(), "ffldb-benchblkhdrenc")
+	db := createEncryptedBenchDB(b, dbPath)
+	defer os.RemoveAll(dbPath)
+	defer db.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	err := db.View(func(tx database.Tx) error {
+		blockHash := chaincfg.MainNetParams.GenesisHash
+		for i := 0; i < b.N; i++ {
+			_, err := tx.FetchBlockHeader(blockHash)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// Don't benchmark teardown.
+	b.StopTimer()
+}
+
+// BenchmarkBlockEncrypted benchmarks how long it takes to load the mainnet
+// genesis block from an encrypted database.
+func BenchmarkBlockEncrypted(b *testing.B) {
+	dbPath := filepath.Join(os.TempDir(), "ffldb-benchblkenc")
+	db := createEncryptedBenchDB(b, dbPath)
+	defer os.RemoveAll(dbPath)
+	defer db.Close()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	err := db.View(func(tx database.Tx) error {
+		blockHash := chaincfg.MainNetParams.GenesisHash
+		for i := 0; i < b.N; i++ {
+			_, err := tx.FetchBlock(blockHash)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// Don't benchmark teardown.
+	b.StopTimer()
+}
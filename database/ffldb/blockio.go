@@ -398,12 +398,19 @@ func (s *blockStore) writeData(data []byte, fieldName string) error {
 // The write cursor will also be advanced the number of bytes actually written
 // in the event of failure.
 //
+// When the passed encryption state is not nil and the block is written to an
+// encrypted block file, the serialized block is replaced with its encrypted
+// form which is keyed to the hash and location of the block.
+//
 // Format: <network><block length><serialized block><checksum>
-func (s *blockStore) writeBlock(rawBlock []byte) (blockLocation, error) {
+func (s *blockStore) writeBlock(hash *wire.ShaHash, rawBlock []byte, enc *encryptionState) (blockLocation, error) {
 	// Compute how many bytes will be written.
 	// 4 bytes each for block network + 4 bytes for block length +
 	// length of raw block + 4 bytes for checksum.
 	blockLen := uint32(len(rawBlock))
+	if enc != nil {
+		blockLen += encTagSize
+	}
 	fullLen := blockLen + 12
 
 	// Move to the next block file if adding the new block would exceed the
@@ -439,6 +446,13 @@ func (s *blockStore) writeBlock(rawBlock []byte) (blockLocation, error) {
 		wc.Unlock()
 	}
 
+	// Encrypt the block now that its final location is known.  Blocks are
+	// only ever written to encrypted files once encryption is enabled.
+	if enc != nil {
+		rawBlock = enc.sealBlock(hash, wc.curFileNum, wc.curOffset,
+			rawBlock)
+	}
+
 	// All writes are done under the write lock for the file to ensure any
 	// readers are finished and blocked first.
 	wc.curFile.Lock()
@@ -500,12 +514,15 @@ func (s *blockStore) writeBlock(rawBlock []byte) (blockLocation, error) {
 // and closing files as necessary to stay within the maximum allowed open files
 // limit.
 //
+// Blocks stored in encrypted block files according to the passed encryption
+// state, if any, are decrypted.
+//
 // Returns ErrDriverSpecific if the data fails to read for any reason and
 // ErrCorruption if the checksum of the read data doesn't match the checksum
-// read from the file.
+// read from the file or the block fails to decrypt.
 //
 // Format: <network><block length><serialized block><checksum>
-func (s *blockStore) readBlock(hash *wire.ShaHash, loc blockLocation) ([]byte, error) {
+func (s *blockStore) readBlock(hash *wire.ShaHash, loc blockLocation, enc *encryptionState) ([]byte, error) {
 	// Get the referenced block file handle opening the file as needed.  The
 	// function also handles closing files as needed to avoid going over the
 	// max allowed open files.
@@ -550,7 +567,11 @@ func (s *blockStore) readBlock(hash *wire.ShaHash, loc blockLocation) ([]byte, e
 
 	// The raw block excludes the network, length of the block, and
 	// checksum.
-	return serializedData[8 : n-4], nil
+	rawBlock := serializedData[8 : n-4]
+	if enc != nil && enc.blockEncrypted(loc.blockFileNum) {
+		return enc.openBlock(hash, loc, rawBlock)
+	}
+	return rawBlock, nil
 }
 
 // readBlockRegion reads the specified amount of data at the provided offset for
@@ -560,8 +581,33 @@ func (s *blockStore) readBlock(hash *wire.ShaHash, loc blockLocation) ([]byte, e
 // closing files as necessary to stay within the maximum allowed open files
 // limit.
 //
-// Returns ErrDriverSpecific if the data fails to read for any reason.
-func (s *blockStore) readBlockRegion(loc blockLocation, offset, numBytes uint32) ([]byte, error) {
+// Regions of blocks stored in encrypted block files according to the passed
+// encryption state, if any, can't be read directly, so the entire block is read
+// and decrypted instead.
+//
+// Returns ErrDriverSpecific if the data fails to read for any reason and
+// ErrBlockRegionInvalid if the region exceeds the bounds of an encrypted block.
+func (s *blockStore) readBlockRegion(hash *wire.ShaHash, loc blockLocation, offset, numBytes uint32, enc *encryptionState) ([]byte, error) {
+	if enc != nil && enc.blockEncrypted(loc.blockFileNum) {
+		rawBlock, err := s.readBlock(hash, loc, enc)
+		if err != nil {
+			return nil, err
+		}
+
+		// The bounds checked by the caller include the encryption
+		// overhead, so check them against the decrypted block.
+		blockLen := uint32(len(rawBlock))
+		endOffset := offset + numBytes
+		if endOffset < offset || endOffset > blockLen {
+			str := fmt.Sprintf("block %s region offset %d, length "+
+				"%d exceeds block length of %d", hash, offset,
+				numBytes, blockLen)
+			return nil, makeDbErr(database.ErrBlockRegionInvalid,
+				str, nil)
+		}
+		return rawBlock[offset:endOffset:endOffset], nil
+	}
+
 	// Get the referenced block file handle opening the file as needed.  The
 	// function also handles closing files as needed to avoid going over the
 	// max allowed open files.
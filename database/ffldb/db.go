@@ -966,6 +966,13 @@ type transaction struct {
 	pendingBlocks    map[wire.ShaHash]int
 	pendingBlockData []pendingBlock
 
+	// The number of unpruned blocks in each block file along with the files
+	// which no longer house any unpruned blocks due to blocks pruned by the
+	// transaction.  The counts are only loaded when the database has been
+	// pruned.
+	fileLive    map[uint32]uint32
+	prunedFiles []uint32
+
 	// Keys that need to be stored or deleted on commit.
 	pendingKeys   *treap.Mutable
 	pendingRemove *treap.Mutable
@@ -1238,6 +1245,24 @@ func (tx *transaction) fetchBlockRow(hash *wire.ShaHash) ([]byte, error) {
 	return blockRow, nil
 }
 
+// fetchBlockLoc fetches the location of the block data for the provided hash
+// from the block index.  It will return ErrBlockNotFound if there is no entry
+// and ErrBlockPruned if the block data has been pruned.
+func (tx *transaction) fetchBlockLoc(hash *wire.ShaHash) (blockLocation, error) {
+	blockRow, err := tx.fetchBlockRow(hash)
+	if err != nil {
+		return blockLocation{}, err
+	}
+	location := deserializeBlockLoc(blockRow)
+	if location.blockLen == 0 {
+		str := fmt.Sprintf("block %s has been pruned", hash)
+		return blockLocation{}, makeDbErr(database.ErrBlockPruned, str,
+			nil)
+	}
+
+	return location, nil
+}
+
 // FetchBlockHeader returns the raw serialized bytes for the block header
 // identified by the given hash.  The raw bytes are in the format returned by
 // Serialize on a wire.BlockHeader.
@@ -1362,15 +1387,15 @@ func (tx *transaction) FetchBlock(hash *wire.ShaHash) ([]byte, error) {
 	}
 
 	// Lookup the location of the block in the files from the block index.
-	blockRow, err := tx.fetchBlockRow(hash)
+	location, err := tx.fetchBlockLoc(hash)
 	if err != nil {
 		return nil, err
 	}
-	location := deserializeBlockLoc(blockRow)
 
 	// Read the block from the appropriate location.  The function also
 	// performs a checksum over the data to detect data corruption.
-	blockBytes, err := tx.db.store.readBlock(hash, location)
+	blockBytes, err := tx.db.store.readBlock(hash, location,
+		tx.snapshot.enc)
 	if err != nil {
 		return nil, err
 	}
@@ -1493,11 +1518,10 @@ func (tx *transaction) FetchBlockRegion(region *database.BlockRegion) ([]byte, e
 	}
 
 	// Lookup the location of the block in the files from the block index.
-	blockRow, err := tx.fetchBlockRow(region.Hash)
+	location, err := tx.fetchBlockLoc(region.Hash)
 	if err != nil {
 		return nil, err
 	}
-	location := deserializeBlockLoc(blockRow)
 
 	// Ensure the region is within the bounds of the block.
 	endOffset := region.Offset + region.Len
@@ -1510,8 +1534,8 @@ func (tx *transaction) FetchBlockRegion(region *database.BlockRegion) ([]byte, e
 	}
 
 	// Read the region from the appropriate disk block file.
-	regionBytes, err := tx.db.store.readBlockRegion(location, region.Offset,
-		region.Len)
+	regionBytes, err := tx.db.store.readBlockRegion(region.Hash, location,
+		region.Offset, region.Len, tx.snapshot.enc)
 	if err != nil {
 		return nil, err
 	}
@@ -1591,11 +1615,10 @@ func (tx *transaction) FetchBlockRegions(regions []database.BlockRegion) ([][]by
 
 		// Lookup the location of the block in the files from the block
 		// index.
-		blockRow, err := tx.fetchBlockRow(region.Hash)
+		location, err := tx.fetchBlockLoc(region.Hash)
 		if err != nil {
 			return nil, err
 		}
-		location := deserializeBlockLoc(blockRow)
 
 		// Ensure the region is within the bounds of the block.
 		endOffset := region.Offset + region.Len
@@ -1616,8 +1639,8 @@ func (tx *transaction) FetchBlockRegions(regions []database.BlockRegion) ([][]by
 		ri := fetchData.replyIndex
 		region := &regions[ri]
 		location := fetchData.blockLocation
-		regionBytes, err := tx.db.store.readBlockRegion(*location,
-			region.Offset, region.Len)
+		regionBytes, err := tx.db.store.readBlockRegion(region.Hash,
+			*location, region.Offset, region.Len, tx.snapshot.enc)
 		if err != nil {
 			return nil, err
 		}
@@ -1636,6 +1659,8 @@ func (tx *transaction) close() {
 	// Clear pending blocks that would have been written on commit.
 	tx.pendingBlocks = nil
 	tx.pendingBlockData = nil
+	tx.fileLive = nil
+	tx.prunedFiles = nil
 
 	// Clear pending keys that would have been written or deleted on commit.
 	tx.pendingKeys = nil
@@ -1693,10 +1718,19 @@ func (tx *transaction) writePendingAndCommit() error {
 		tx.db.store.handleRollback(oldBlkFileNum, oldBlkOffset)
 	}
 
+	// Load the per-file unpruned block counts so they can be updated for
+	// the new blocks when the database has been pruned.
+	if len(tx.pendingBlockData) > 0 {
+		if err := tx.loadFileLiveCounts(false); err != nil {
+			return err
+		}
+	}
+
 	// Loop through all of the pending blocks to store and write them.
 	for _, blockData := range tx.pendingBlockData {
 		log.Tracef("Storing block %s", blockData.hash)
-		location, err := tx.db.store.writeBlock(blockData.bytes)
+		location, err := tx.db.store.writeBlock(blockData.hash,
+			blockData.bytes, tx.snapshot.enc)
 		if err != nil {
 			rollback()
 			return err
@@ -1713,6 +1747,9 @@ func (tx *transaction) writePendingAndCommit() error {
 			rollback()
 			return err
 		}
+		if tx.fileLive != nil {
+			tx.fileLive[location.blockFileNum]++
+		}
 	}
 
 	// Update the metadata for the current write file and offset.
@@ -1722,9 +1759,29 @@ func (tx *transaction) writePendingAndCommit() error {
 		return convertErr("failed to store write cursor", err)
 	}
 
+	// Update the per-file unpruned block counts when the database has been
+	// pruned.
+	if tx.fileLive != nil {
+		serialized := serializeFileLiveCounts(tx.fileLive)
+		err := tx.metaBucket.Put(fileLiveKeyName, serialized)
+		if err != nil {
+			rollback()
+			return convertErr("failed to store block file counts", err)
+		}
+	}
+
 	// Atomically update the database cache.  The cache automatically
 	// handles flushing to the underlying persistent storage database.
-	return tx.db.cache.commitTx(tx)
+	if err := tx.db.cache.commitTx(tx); err != nil {
+		return err
+	}
+
+	// Reclaim the space used by any block files which no longer house any
+	// unpruned blocks.
+	if len(tx.prunedFiles) > 0 {
+		return tx.db.reclaimPrunedFiles(tx.prunedFiles)
+	}
+	return nil
 }
 
 // Commit commits all changes that have been made to the root metadata bucket
@@ -2040,7 +2097,11 @@ func initDB(ldb *leveldb.DB) error {
 
 // openDB opens the database at the provided path.  database.ErrDbDoesNotExist
 // is returned if the database doesn't exist and the create flag is not set.
-func openDB(dbPath string, network wire.BitcoinNet, create bool) (database.DB, error) {
+//
+// When an encryption secret is provided, it must match the one an existing
+// database was encrypted with, and a newly created database is encrypted with
+// it.  database.ErrInvalidKey is returned otherwise.
+func openDB(dbPath string, network wire.BitcoinNet, secret EncryptionSecret, create bool) (database.DB, error) {
 	// Error if the database doesn't exist and the create flag is not set.
 	metadataDbPath := filepath.Join(dbPath, metadataDbName)
 	dbExists := fileExists(metadataDbPath)
@@ -2069,6 +2130,14 @@ func openDB(dbPath string, network wire.BitcoinNet, create bool) (database.DB, e
 		return nil, convertErr(err.Error(), err)
 	}
 
+	// Load the encryption state, if any, before anything else since it is
+	// needed to read the metadata.
+	enc, err := loadEncryptionState(ldb, secret, create)
+	if err != nil {
+		_ = ldb.Close()
+		return nil, err
+	}
+
 	// Create the block store which includes scanning the existing flat
 	// block files to find what the current write cursor position is
 	// according to the data that is actually on disk.  Also create the
@@ -2076,9 +2145,25 @@ func openDB(dbPath string, network wire.BitcoinNet, create bool) (database.DB, e
 	// write caching.
 	store := newBlockStore(dbPath, network)
 	cache := newDbCache(ldb, store, defaultCacheSize, defaultFlushSecs)
+	cache.enc = enc
 	pdb := &db{store: store, cache: cache}
 
 	// Perform any reconciliation needed between the block and metadata as
 	// well as database initialization, if needed.
-	return reconcileDB(pdb, create)
+	idb, err := reconcileDB(pdb, create)
+	if err != nil {
+		return nil, err
+	}
+
+	// Encrypt newly created databases when requested.  There is very
+	// little to convert at this point, so this is quick.
+	if create && secret != nil {
+		err := pdb.encrypt(secret, defaultEncryptBatchSize, nil)
+		if err != nil {
+			_ = pdb.Close()
+			return nil, err
+		}
+	}
+
+	return idb, nil
 }
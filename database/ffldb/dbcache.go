@@ -289,6 +289,11 @@ type dbCacheSnapshot struct {
 	dbSnapshot    *leveldb.Snapshot
 	pendingKeys   *treap.Immutable
 	pendingRemove *treap.Immutable
+
+	// enc is the encryption state of the underlying database at the time
+	// the snapshot was taken.  It is nil when the database is not
+	// encrypted.
+	enc *encryptionState
 }
 
 // Has returns whether or not the passed key exists.
@@ -322,6 +327,16 @@ func (snap *dbCacheSnapshot) Get(key []byte) []byte {
 	if err != nil {
 		return nil
 	}
+
+	// Decrypt the value as needed.  There is no way to return an error
+	// here, so it is logged and treated as a missing key.
+	if snap.enc != nil && snap.enc.metaEncrypted(key) {
+		value, err = snap.enc.decryptValue(key, value)
+		if err != nil {
+			_ = log.Errorf("%v", err)
+			return nil
+		}
+	}
 	return value
 }
 
@@ -340,8 +355,12 @@ func (snap *dbCacheSnapshot) Release() {
 // The start key is inclusive and the limit key is exclusive.  Either or both
 // can be nil if the functionality is not desired.
 func (snap *dbCacheSnapshot) NewIterator(slice *util.Range) *dbCacheIterator {
+	var dbIter iterator.Iterator = snap.dbSnapshot.NewIterator(slice, nil)
+	if snap.enc != nil {
+		dbIter = &ldbDecryptIter{Iterator: dbIter, enc: snap.enc}
+	}
 	return &dbCacheIterator{
-		dbIter:        snap.dbSnapshot.NewIterator(slice, nil),
+		dbIter:        dbIter,
 		cacheIter:     newLdbCacheIter(snap, slice),
 		cacheSnapshot: snap,
 	}
@@ -390,6 +409,13 @@ type dbCache struct {
 	cacheLock    sync.RWMutex
 	cachedKeys   *treap.Immutable
 	cachedRemove *treap.Immutable
+
+	// enc is the current encryption state of the underlying database or
+	// nil when it is not encrypted.  Values are transparently encrypted as
+	// they are written to the underlying database according to it.  It is
+	// only replaced while both the database write lock and the cache lock
+	// are held.
+	enc *encryptionState
 }
 
 // Snapshot returns a snapshot of the database cache and underlying database at
@@ -397,8 +423,12 @@ type dbCache struct {
 //
 // The snapshot must be released after use by calling Release.
 func (c *dbCache) Snapshot() (*dbCacheSnapshot, error) {
+	// The underlying database snapshot is taken under the cache lock so it
+	// is always consistent with the encryption state.
+	c.cacheLock.RLock()
 	dbSnapshot, err := c.ldb.GetSnapshot()
 	if err != nil {
+		c.cacheLock.RUnlock()
 		str := "failed to open transaction"
 		return nil, convertErr(str, err)
 	}
@@ -406,11 +436,11 @@ func (c *dbCache) Snapshot() (*dbCacheSnapshot, error) {
 	// Since the cached keys to be added and removed use an immutable treap,
 	// a snapshot is simply obtaining the root of the tree under the lock
 	// which is used to atomically swap the root.
-	c.cacheLock.RLock()
 	cacheSnapshot := &dbCacheSnapshot{
 		dbSnapshot:    dbSnapshot,
 		pendingKeys:   c.cachedKeys,
 		pendingRemove: c.cachedRemove,
+		enc:           c.enc,
 	}
 	c.cacheLock.RUnlock()
 	return cacheSnapshot, nil
@@ -455,6 +485,14 @@ func (c *dbCache) commitTreaps(pendingKeys, pendingRemove TreapForEacher) error
 	return c.updateDB(func(ldbTx *leveldb.Transaction) error {
 		var innerErr error
 		pendingKeys.ForEach(func(k, v []byte) bool {
+			if c.enc != nil && c.enc.metaEncrypted(k) {
+				var err error
+				v, err = c.enc.encryptValue(k, v)
+				if err != nil {
+					innerErr = err
+					return false
+				}
+			}
 			if dbErr := ldbTx.Put(k, v, nil); dbErr != nil {
 				str := fmt.Sprintf("failed to put key %q to "+
 					"ldb transaction", k)
@@ -481,6 +519,23 @@ func (c *dbCache) commitTreaps(pendingKeys, pendingRemove TreapForEacher) error
 	})
 }
 
+// commitEncState atomically writes the passed batch, which must include the
+// serialized encryption state, to the underlying database and replaces the
+// current encryption state with the provided one.
+//
+// This function MUST be called with the database write lock held.
+func (c *dbCache) commitEncState(batch *leveldb.Batch, state *encryptionState) error {
+	// The cache lock is held while writing so that no snapshot observes the
+	// updated data with the old encryption state or vice versa.
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+	if err := c.ldb.Write(batch, nil); err != nil {
+		return convertErr("failed to update encryption state", err)
+	}
+	c.enc = state
+	return nil
+}
+
 // flush flushes the database cache to persistent storage.  This involes syncing
 // the block store and replaying all transactions that have been applied to the
 // cache to the underlying database.
@@ -26,5 +26,21 @@ database path as a string and the block network:
 	if err != nil {
 		// Handle error
 	}
+
+Encryption
+
+The metadata and blocks may optionally be encrypted at rest with AES-GCM by
+passing an EncryptionKey or EncryptionPassphrase as an additional parameter.
+The same secret must then be provided every time the database is opened:
+
+	secret := ffldb.EncryptionPassphrase("passphrase")
+	db, err := database.Create("ffldb", "path/to/database", wire.MainNet,
+		secret)
+	if err != nil {
+		// Handle error
+	}
+
+Existing unencrypted databases remain readable and can be converted in place
+with EncryptDatabase.
 */
 package ffldb
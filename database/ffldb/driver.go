@@ -17,51 +17,67 @@ var log = btclog.Disabled
 
 const (
 	dbType = "ffldb"
+
+	// dbVersion is the version of the storage format of the metadata and
+	// flat block files reported by the driver.
+	dbVersion = "1.0.0"
 )
 
-// parseArgs parses the arguments from the database Open/Create methods.
-func parseArgs(funcName string, args ...interface{}) (string, wire.BitcoinNet, error) {
-	if len(args) != 2 {
-		return "", 0, fmt.Errorf("invalid arguments to %s.%s -- "+
-			"expected database path and block network", dbType,
-			funcName)
+// parseArgs parses the arguments from the database Open/Create methods.  The
+// encryption secret is optional and will be nil when it is not provided.
+func parseArgs(funcName string, args ...interface{}) (string, wire.BitcoinNet, EncryptionSecret, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return "", 0, nil, fmt.Errorf("invalid arguments to %s.%s -- "+
+			"expected database path, block network, and optional "+
+			"encryption secret", dbType, funcName)
 	}
 
 	dbPath, ok := args[0].(string)
 	if !ok {
-		return "", 0, fmt.Errorf("first argument to %s.%s is invalid -- "+
-			"expected database path string", dbType, funcName)
+		return "", 0, nil, fmt.Errorf("first argument to %s.%s is "+
+			"invalid -- expected database path string", dbType,
+			funcName)
 	}
 
 	network, ok := args[1].(wire.BitcoinNet)
 	if !ok {
-		return "", 0, fmt.Errorf("second argument to %s.%s is invalid -- "+
-			"expected block network", dbType, funcName)
+		return "", 0, nil, fmt.Errorf("second argument to %s.%s is "+
+			"invalid -- expected block network", dbType, funcName)
+	}
+
+	var secret EncryptionSecret
+	if len(args) == 3 {
+		secret, ok = args[2].(EncryptionSecret)
+		if !ok {
+			return "", 0, nil, fmt.Errorf("third argument to %s.%s "+
+				"is invalid -- expected encryption key or "+
+				"passphrase", dbType, funcName)
+		}
 	}
 
-	return dbPath, network, nil
+	return dbPath, network, secret, nil
 }
 
 // openDBDriver is the callback provided during driver registration that opens
 // an existing database for use.
 func openDBDriver(args ...interface{}) (database.DB, error) {
-	dbPath, network, err := parseArgs("Open", args...)
+	dbPath, network, secret, err := parseArgs("Open", args...)
 	if err != nil {
 		return nil, err
 	}
 
-	return openDB(dbPath, network, false)
+	return openDB(dbPath, network, secret, false)
 }
 
 // createDBDriver is the callback provided during driver registration that
 // creates, initializes, and opens a database for use.
 func createDBDriver(args ...interface{}) (database.DB, error) {
-	dbPath, network, err := parseArgs("Create", args...)
+	dbPath, network, secret, err := parseArgs("Create", args...)
 	if err != nil {
 		return nil, err
 	}
 
-	return openDB(dbPath, network, true)
+	return openDB(dbPath, network, secret, true)
 }
 
 // useLogger is the callback provided during driver registration that sets the
@@ -74,6 +90,7 @@ func init() {
 	// Register the driver.
 	driver := database.Driver{
 		DbType:    dbType,
+		Version:   dbVersion,
 		Create:    createDBDriver,
 		Open:      openDBDriver,
 		UseLogger: useLogger,
@@ -0,0 +1,839 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// This file contains the optional encryption layer which protects the metadata
+// and the flat block files at rest along with the logic to convert an existing
+// unencrypted database in place.
+
+package ffldb
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/btcsuite/golangcrypto/scrypt"
+	"github.com/btcsuite/goleveldb/leveldb"
+	"github.com/btcsuite/goleveldb/leveldb/iterator"
+	"github.com/btcsuite/goleveldb/leveldb/util"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+const (
+	// EncryptionKeySize is the size of the raw AES-256 keys used to
+	// encrypt a database.
+	EncryptionKeySize = 32
+
+	// encVersion is the version of the serialized encryption state and
+	// encrypted value formats written by this package.
+	encVersion = 1
+
+	// encSaltSize is the size of the random salt used when deriving a key
+	// from a passphrase.
+	encSaltSize = 16
+
+	// encNonceSize is the size of the AES-GCM nonces.
+	encNonceSize = 12
+
+	// encTagSize is the size of the AES-GCM authentication tags.  This is
+	// the number of bytes encryption adds to each block.
+	encTagSize = 16
+
+	// encValueOverhead is the number of bytes encryption adds to each
+	// metadata value.
+	//
+	// The serialized encrypted value format is:
+	//   <version><nonce><ciphertext><tag>
+	encValueOverhead = 1 + encNonceSize + encTagSize
+
+	// These flags track which portions of the database have been fully
+	// converted.
+	encFlagMetaDone   = 1 << 0
+	encFlagBlocksDone = 1 << 1
+
+	// These identify the function used to derive the key from the secret
+	// provided by the caller.
+	kdfNone   = 0
+	kdfScrypt = 1
+
+	// These are the scrypt parameters used to derive keys from
+	// passphrases.
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+
+	// defaultEncryptBatchSize is the number of metadata entries or blocks
+	// converted in each batch by EncryptDatabase.  Each batch is committed
+	// atomically, so an interrupted conversion resumes from the last
+	// committed batch.
+	defaultEncryptBatchSize = 500
+)
+
+var (
+	// encStateKeyName is the key used to store the encryption state.  It
+	// does not belong to any bucket and is never encrypted itself.
+	encStateKeyName = []byte("ffldb-encstate")
+
+	// encCheckData is the additional data authenticated with an empty
+	// plaintext to produce the key check value stored in the encryption
+	// state.  It allows an incorrect key to be detected when the database
+	// is opened instead of on the first read.
+	encCheckData = []byte("ffldb-encryption-key-check")
+)
+
+// EncryptionSecret is the secret used to encrypt a database.  It is implemented
+// by EncryptionKey and EncryptionPassphrase and may be passed as an optional
+// final argument when creating or opening a database.
+type EncryptionSecret interface {
+	// kdf returns the identifier of the function used to derive the key.
+	kdf() byte
+
+	// deriveKey returns the AES-256 key for the secret using the provided
+	// salt when applicable.
+	deriveKey(salt []byte) ([]byte, error)
+}
+
+// EncryptionKey is a raw AES-256 key used to encrypt a database.
+type EncryptionKey [EncryptionKeySize]byte
+
+// kdf returns the identifier of the function used to derive the key.
+//
+// This is part of the EncryptionSecret interface implementation.
+func (k EncryptionKey) kdf() byte {
+	return kdfNone
+}
+
+// deriveKey returns the raw key.  The salt is not used.
+//
+// This is part of the EncryptionSecret interface implementation.
+func (k EncryptionKey) deriveKey(salt []byte) ([]byte, error) {
+	key := make([]byte, EncryptionKeySize)
+	copy(key, k[:])
+	return key, nil
+}
+
+// EncryptionPassphrase is a passphrase from which the key used to encrypt a
+// database is derived with scrypt and a random per-database salt.
+type EncryptionPassphrase string
+
+// kdf returns the identifier of the function used to derive the key.
+//
+// This is part of the EncryptionSecret interface implementation.
+func (p EncryptionPassphrase) kdf() byte {
+	return kdfScrypt
+}
+
+// deriveKey derives the key from the passphrase and provided salt.
+//
+// This is part of the EncryptionSecret interface implementation.
+func (p EncryptionPassphrase) deriveKey(salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(p), salt, scryptN, scryptR, scryptP,
+		EncryptionKeySize)
+}
+
+// encryptionState houses the key and conversion progress for an encrypted
+// database.  A state is never modified once it has been published to the
+// database cache, so snapshots may safely keep a reference to the state that
+// was current when they were taken.
+type encryptionState struct {
+	aead  cipher.AEAD
+	kdf   byte
+	salt  [encSaltSize]byte
+	check [encTagSize]byte
+	flags byte
+
+	// startFileNum is the first block file written after encryption was
+	// enabled.  All blocks in it and later files are encrypted while those
+	// in earlier files are not.
+	startFileNum uint32
+
+	// metaCursor is the last metadata key which has been converted.  All
+	// keys up to and including it are encrypted until every key has been
+	// converted, at which point all of them are.
+	metaCursor []byte
+
+	// blockCursor is the last block index key which has been visited while
+	// relocating unencrypted blocks into encrypted block files.
+	blockCursor []byte
+}
+
+// clone returns a copy of the state which may be modified without affecting
+// the original.
+func (s *encryptionState) clone() *encryptionState {
+	c := *s
+	return &c
+}
+
+// metaEncrypted returns whether or not the value stored in the underlying
+// leveldb database for the passed key is encrypted.
+func (s *encryptionState) metaEncrypted(key []byte) bool {
+	if bytes.Equal(key, encStateKeyName) {
+		return false
+	}
+	if s.flags&encFlagMetaDone != 0 {
+		return true
+	}
+	return len(s.metaCursor) > 0 && bytes.Compare(key, s.metaCursor) <= 0
+}
+
+// blockEncrypted returns whether or not the blocks stored in the passed flat
+// file number are encrypted.
+func (s *encryptionState) blockEncrypted(fileNum uint32) bool {
+	return fileNum >= s.startFileNum
+}
+
+// encryptValue returns the encrypted form of the passed metadata value.  The
+// key is authenticated along with the value so encrypted values can't be moved
+// between keys without detection.
+func (s *encryptionState) encryptValue(key, value []byte) ([]byte, error) {
+	out := make([]byte, 1+encNonceSize, len(value)+encValueOverhead)
+	out[0] = encVersion
+	if _, err := io.ReadFull(rand.Reader, out[1:]); err != nil {
+		str := fmt.Sprintf("failed to generate nonce: %v", err)
+		return nil, makeDbErr(database.ErrDriverSpecific, str, err)
+	}
+	return s.aead.Seal(out, out[1:], value, key), nil
+}
+
+// decryptValue returns the plaintext of the passed encrypted metadata value.
+// Returns ErrCorruption when the value can't be authenticated.
+func (s *encryptionState) decryptValue(key, data []byte) ([]byte, error) {
+	if len(data) < encValueOverhead || data[0] != encVersion {
+		str := fmt.Sprintf("encrypted value for key %x is malformed",
+			key)
+		return nil, makeDbErr(database.ErrCorruption, str, nil)
+	}
+	nonce := data[1 : 1+encNonceSize]
+	ciphertext := data[1+encNonceSize:]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, key)
+	if err != nil {
+		str := fmt.Sprintf("failed to decrypt value for key %x: %v",
+			key, err)
+		return nil, makeDbErr(database.ErrCorruption, str, err)
+	}
+	return plaintext, nil
+}
+
+// blockNonce returns the nonce used to encrypt a block stored at the passed
+// file number and offset.  Every block record occupies a distinct location, so
+// the location alone makes the nonce unique.  The first bytes of the block hash
+// are mixed in so data written to a location that was reused after a rollback
+// does not share a nonce with the data previously written there.
+func blockNonce(hash *wire.ShaHash, fileNum, fileOffset uint32) []byte {
+	var nonce [encNonceSize]byte
+	byteOrder.PutUint32(nonce[0:4], fileNum)
+	byteOrder.PutUint32(nonce[4:8], fileOffset)
+	copy(nonce[8:], hash[:4])
+	return nonce[:]
+}
+
+// sealBlock encrypts the passed raw block for storage at the provided location.
+func (s *encryptionState) sealBlock(hash *wire.ShaHash, fileNum, fileOffset uint32, rawBlock []byte) []byte {
+	nonce := blockNonce(hash, fileNum, fileOffset)
+	return s.aead.Seal(nil, nonce, rawBlock, hash[:])
+}
+
+// openBlock decrypts the passed encrypted block read from the provided location.
+// The decryption is done in place to avoid an additional allocation.  Returns
+// ErrCorruption when the block can't be authenticated.
+func (s *encryptionState) openBlock(hash *wire.ShaHash, loc blockLocation, data []byte) ([]byte, error) {
+	nonce := blockNonce(hash, loc.blockFileNum, loc.fileOffset)
+	rawBlock, err := s.aead.Open(data[:0], nonce, data, hash[:])
+	if err != nil {
+		str := fmt.Sprintf("failed to decrypt block %s from file %d, "+
+			"offset %d: %v", hash, loc.blockFileNum, loc.fileOffset,
+			err)
+		return nil, makeDbErr(database.ErrCorruption, str, err)
+	}
+	return rawBlock, nil
+}
+
+// newAEAD returns an AES-GCM cipher for the passed key.
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		str := fmt.Sprintf("invalid encryption key: %v", err)
+		return nil, makeDbErr(database.ErrInvalidKey, str, err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, makeDbErr(database.ErrDriverSpecific, err.Error(), err)
+	}
+	return aead, nil
+}
+
+// keyCheck returns the key check value for the passed cipher.
+func keyCheck(aead cipher.AEAD) []byte {
+	var nonce [encNonceSize]byte
+	return aead.Seal(nil, nonce[:], nil, encCheckData)
+}
+
+// unlock derives the key for the passed secret and ensures it is the one the
+// database was encrypted with.  Returns ErrInvalidKey when it is not.
+func (s *encryptionState) unlock(secret EncryptionSecret) error {
+	if secret.kdf() != s.kdf {
+		str := "the provided encryption secret is not the kind the " +
+			"database was encrypted with"
+		return makeDbErr(database.ErrInvalidKey, str, nil)
+	}
+	key, err := secret.deriveKey(s.salt[:])
+	if err != nil {
+		str := fmt.Sprintf("failed to derive encryption key: %v", err)
+		return makeDbErr(database.ErrInvalidKey, str, err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare(keyCheck(aead), s.check[:]) != 1 {
+		str := "the provided encryption secret does not match the " +
+			"one the database was encrypted with"
+		return makeDbErr(database.ErrInvalidKey, str, nil)
+	}
+	s.aead = aead
+	return nil
+}
+
+// newEncryptionState returns a new encryption state for the passed secret
+// which will encrypt all blocks written to the provided file number and later.
+func newEncryptionState(secret EncryptionSecret, startFileNum uint32) (*encryptionState, error) {
+	s := &encryptionState{kdf: secret.kdf(), startFileNum: startFileNum}
+	if s.kdf != kdfNone {
+		if _, err := io.ReadFull(rand.Reader, s.salt[:]); err != nil {
+			str := fmt.Sprintf("failed to generate salt: %v", err)
+			return nil, makeDbErr(database.ErrDriverSpecific, str,
+				err)
+		}
+	}
+	key, err := secret.deriveKey(s.salt[:])
+	if err != nil {
+		str := fmt.Sprintf("failed to derive encryption key: %v", err)
+		return nil, makeDbErr(database.ErrInvalidKey, str, err)
+	}
+	s.aead, err = newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	copy(s.check[:], keyCheck(s.aead))
+	return s, nil
+}
+
+// serializeEncState returns the serialization of the passed encryption state
+// for storage in the metadata.  The key itself is never stored.
+//
+// The serialized encryption state format is:
+//
+//	<version><flags><kdf><salt><key check><start file><meta cursor len>
+//	<meta cursor><block cursor len><block cursor><checksum>
+//
+//	Field             Type      Size
+//	version           uint8     1
+//	flags             uint8     1
+//	kdf               uint8     1
+//	salt              [16]byte  16
+//	key check         [16]byte  16
+//	start file        uint32    4
+//	meta cursor len   uint32    4
+//	meta cursor       []byte    variable
+//	block cursor len  uint32    4
+//	block cursor      []byte    variable
+//	checksum          uint32    4
+func serializeEncState(s *encryptionState) []byte {
+	size := 3 + encSaltSize + encTagSize + 12 + len(s.metaCursor) +
+		len(s.blockCursor) + 4
+	serialized := make([]byte, size)
+	serialized[0] = encVersion
+	serialized[1] = s.flags
+	serialized[2] = s.kdf
+	offset := 3
+	offset += copy(serialized[offset:], s.salt[:])
+	offset += copy(serialized[offset:], s.check[:])
+	byteOrder.PutUint32(serialized[offset:], s.startFileNum)
+	offset += 4
+	byteOrder.PutUint32(serialized[offset:], uint32(len(s.metaCursor)))
+	offset += 4
+	offset += copy(serialized[offset:], s.metaCursor)
+	byteOrder.PutUint32(serialized[offset:], uint32(len(s.blockCursor)))
+	offset += 4
+	offset += copy(serialized[offset:], s.blockCursor)
+	checksum := crc32.Checksum(serialized[:offset], castagnoli)
+	byteOrder.PutUint32(serialized[offset:], checksum)
+	return serialized
+}
+
+// deserializeEncState deserializes the passed encryption state.  The returned
+// state must be unlocked before it can be used.  Returns ErrCorruption if the
+// serialized data is malformed or the checksum does not match and
+// ErrDriverSpecific if it was written by a newer unsupported version.
+func deserializeEncState(serialized []byte) (*encryptionState, error) {
+	const minSize = 3 + encSaltSize + encTagSize + 12 + 4
+	if len(serialized) < minSize {
+		str := "encryption state is too short"
+		return nil, makeDbErr(database.ErrCorruption, str, nil)
+	}
+	checksumOffset := len(serialized) - 4
+	gotChecksum := crc32.Checksum(serialized[:checksumOffset], castagnoli)
+	wantChecksum := byteOrder.Uint32(serialized[checksumOffset:])
+	if gotChecksum != wantChecksum {
+		str := fmt.Sprintf("encryption state does not match the "+
+			"expected checksum - got %d, want %d", gotChecksum,
+			wantChecksum)
+		return nil, makeDbErr(database.ErrCorruption, str, nil)
+	}
+	if serialized[0] != encVersion {
+		str := fmt.Sprintf("unsupported encryption state version %d",
+			serialized[0])
+		return nil, makeDbErr(database.ErrDriverSpecific, str, nil)
+	}
+
+	s := &encryptionState{flags: serialized[1], kdf: serialized[2]}
+	offset := 3
+	offset += copy(s.salt[:], serialized[offset:])
+	offset += copy(s.check[:], serialized[offset:])
+	s.startFileNum = byteOrder.Uint32(serialized[offset:])
+	offset += 4
+
+	// readCursor reads a length-prefixed cursor at the current offset.
+	readCursor := func() ([]byte, bool) {
+		if checksumOffset-offset < 4 {
+			return nil, false
+		}
+		cursorLen := int(byteOrder.Uint32(serialized[offset:]))
+		offset += 4
+		if cursorLen > checksumOffset-offset {
+			return nil, false
+		}
+		var cursor []byte
+		if cursorLen > 0 {
+			cursor = copySlice(serialized[offset : offset+cursorLen])
+		}
+		offset += cursorLen
+		return cursor, true
+	}
+	var metaOk, blockOk bool
+	s.metaCursor, metaOk = readCursor()
+	if metaOk {
+		s.blockCursor, blockOk = readCursor()
+	}
+	if !metaOk || !blockOk || offset != checksumOffset {
+		str := "encryption state is malformed"
+		return nil, makeDbErr(database.ErrCorruption, str, nil)
+	}
+	return s, nil
+}
+
+// loadEncryptionState loads the encryption state from the passed leveldb
+// database and unlocks it with the provided secret.  It returns nil when the
+// database is not encrypted.
+//
+// Returns ErrInvalidKey when the database is encrypted and the secret is either
+// missing or incorrect.
+func loadEncryptionState(ldb *leveldb.DB, secret EncryptionSecret, create bool) (*encryptionState, error) {
+	serialized, err := ldb.Get(encStateKeyName, nil)
+	if err == leveldb.ErrNotFound {
+		// Refuse to silently open an unencrypted database when the
+		// caller expects it to be encrypted.
+		if secret != nil && !create {
+			str := "database is not encrypted -- use " +
+				"EncryptDatabase to encrypt it"
+			return nil, makeDbErr(database.ErrInvalidKey, str, nil)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, convertErr("failed to load encryption state", err)
+	}
+
+	if secret == nil {
+		str := "database is encrypted -- an encryption key or " +
+			"passphrase is required"
+		return nil, makeDbErr(database.ErrInvalidKey, str, nil)
+	}
+	s, err := deserializeEncState(serialized)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.unlock(secret); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// ldbDecryptIter wraps a leveldb iterator over the underlying database to
+// transparently decrypt the values of encrypted entries.
+type ldbDecryptIter struct {
+	iterator.Iterator
+	enc *encryptionState
+	err error
+}
+
+// Enforce ldbDecryptIter implements the leveldb iterator.Iterator interface.
+var _ iterator.Iterator = (*ldbDecryptIter)(nil)
+
+// Value returns the decrypted value the iterator is pointing to.  Nil is
+// returned and the iterator error is set when the value fails to decrypt.
+//
+// This is part of the leveldb iterator.Iterator interface implementation.
+func (iter *ldbDecryptIter) Value() []byte {
+	value := iter.Iterator.Value()
+	key := iter.Iterator.Key()
+	if value == nil || !iter.enc.metaEncrypted(key) {
+		return value
+	}
+
+	plaintext, err := iter.enc.decryptValue(key, value)
+	if err != nil {
+		_ = log.Errorf("%v", err)
+		iter.err = err
+		return nil
+	}
+	return plaintext
+}
+
+// Error returns any decryption error encountered by the iterator followed by
+// any error from the underlying iterator.
+//
+// This is part of the leveldb iterator.Iterator interface implementation.
+func (iter *ldbDecryptIter) Error() error {
+	if iter.err != nil {
+		return iter.err
+	}
+	return iter.Iterator.Error()
+}
+
+// EncryptDatabase converts the passed ffldb database in place so all metadata
+// and blocks are encrypted at rest with the provided secret.  The database
+// remains usable while it is being converted.
+//
+// The conversion is performed in batches which are each committed atomically,
+// so when it is interrupted, for example by an unexpected shutdown, calling
+// this function again with the same secret resumes from the last committed
+// batch.  Note that once a conversion has started, the database must be opened
+// with the secret.
+//
+// Returns ErrInvalidKey if the database is already fully or partially encrypted
+// with a different secret.
+func EncryptDatabase(idb database.DB, secret EncryptionSecret) error {
+	pdb, ok := idb.(*db)
+	if !ok {
+		str := fmt.Sprintf("database type %q does not support "+
+			"encryption", idb.Type())
+		return makeDbErr(database.ErrInvalid, str, nil)
+	}
+	return pdb.encrypt(secret, defaultEncryptBatchSize, nil)
+}
+
+// encrypt is the implementation function for EncryptDatabase.  The optional
+// interrupt function is invoked before every batch and causes the conversion to
+// stop when it returns true.
+func (db *db) encrypt(secret EncryptionSecret, batchSize int, interrupt func() bool) error {
+	err := db.withEncryptionLock(func() error {
+		return db.beginEncryption(secret)
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		if interrupt != nil && interrupt() {
+			str := "database encryption interrupted"
+			return makeDbErr(database.ErrDriverSpecific, str, nil)
+		}
+
+		var done bool
+		err := db.withEncryptionLock(func() error {
+			var err error
+			done, err = db.encryptBatch(batchSize)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// withEncryptionLock invokes the passed function with the database write lock
+// held and the database cache flushed so the underlying leveldb database holds
+// the full state of the metadata.
+func (db *db) withEncryptionLock(fn func() error) error {
+	db.writeLock.Lock()
+	defer db.writeLock.Unlock()
+	db.closeLock.RLock()
+	defer db.closeLock.RUnlock()
+	if db.closed {
+		return makeDbErr(database.ErrDbNotOpen, errDbNotOpenStr, nil)
+	}
+
+	if err := db.cache.flush(); err != nil {
+		return err
+	}
+	return fn()
+}
+
+// beginEncryption enables encryption for the database with the passed secret
+// when it is not already enabled, or ensures the secret matches the one it was
+// enabled with otherwise.
+//
+// Enabling encryption moves the write cursor to a new block file so every block
+// file from that point on is encrypted.
+//
+// This function MUST be called via withEncryptionLock.
+func (db *db) beginEncryption(secret EncryptionSecret) error {
+	if enc := db.cache.enc; enc != nil {
+		s := enc.clone()
+		return s.unlock(secret)
+	}
+
+	// Start encrypted blocks in a fresh file unless the current one is
+	// still empty.
+	wc := db.store.writeCursor
+	startFileNum := wc.curFileNum
+	if wc.curOffset != 0 {
+		startFileNum++
+	}
+	s, err := newEncryptionState(secret, startFileNum)
+	if err != nil {
+		return err
+	}
+
+	// Move the write cursor to the start file and create it so the files on
+	// disk agree with the write cursor stored in the metadata.  Should the
+	// metadata update below not make it to disk, the new empty file is
+	// removed by the usual reconciliation on the next open.
+	if startFileNum != wc.curFileNum {
+		wc.Lock()
+		wc.curFile.Lock()
+		if wc.curFile.file != nil {
+			_ = wc.curFile.file.Close()
+			wc.curFile.file = nil
+		}
+		wc.curFile.Unlock()
+		wc.curFileNum = startFileNum
+		wc.curOffset = 0
+		wc.Unlock()
+	}
+	wc.curFile.Lock()
+	if wc.curFile.file == nil {
+		file, err := db.store.openWriteFileFunc(wc.curFileNum)
+		if err != nil {
+			wc.curFile.Unlock()
+			return err
+		}
+		wc.curFile.file = file
+	}
+	wc.curFile.Unlock()
+
+	// Nothing has been encrypted yet, so the write cursor is stored as
+	// plaintext.
+	batch := new(leveldb.Batch)
+	batch.Put(bucketizedKey(metadataBucketID, writeLocKeyName),
+		serializeWriteRow(wc.curFileNum, wc.curOffset))
+	batch.Put(encStateKeyName, serializeEncState(s))
+	return db.cache.commitEncState(batch, s)
+}
+
+// encryptBatch converts the next batch of metadata entries or blocks and
+// returns whether or not the conversion is complete.  All metadata is converted
+// before any blocks are.
+//
+// This function MUST be called via withEncryptionLock.
+func (db *db) encryptBatch(batchSize int) (bool, error) {
+	s := db.cache.enc
+	switch {
+	case s.flags&encFlagMetaDone == 0:
+		return false, db.encryptMetaBatch(s, batchSize)
+
+	case s.flags&encFlagBlocksDone == 0:
+		return false, db.encryptBlocksBatch(s, batchSize)
+	}
+
+	return true, nil
+}
+
+// seekPastCursor positions the passed iterator at the first key after the
+// provided cursor, or at the first key when the cursor is empty, and returns
+// whether or not the iterator is valid.
+func seekPastCursor(iter iterator.Iterator, cursor []byte) bool {
+	if len(cursor) == 0 {
+		return iter.First()
+	}
+	if !iter.Seek(cursor) {
+		return false
+	}
+	if bytes.Equal(iter.Key(), cursor) {
+		return iter.Next()
+	}
+	return true
+}
+
+// encryptMetaBatch encrypts the values of the next batch of metadata keys in the
+// underlying leveldb database and advances the metadata cursor accordingly.
+//
+// This function MUST be called via withEncryptionLock.
+func (db *db) encryptMetaBatch(s *encryptionState, batchSize int) error {
+	iter := db.cache.ldb.NewIterator(nil, nil)
+	defer iter.Release()
+
+	newState := s.clone()
+	batch := new(leveldb.Batch)
+	ok := seekPastCursor(iter, s.metaCursor)
+	for n := 0; ok && n < batchSize; ok = iter.Next() {
+		key := iter.Key()
+		if bytes.Equal(key, encStateKeyName) {
+			continue
+		}
+
+		value, err := s.encryptValue(key, iter.Value())
+		if err != nil {
+			return err
+		}
+		batch.Put(key, value)
+		newState.metaCursor = copySlice(key)
+		n++
+	}
+	if err := iter.Error(); err != nil {
+		return convertErr("failed to iterate metadata", err)
+	}
+	if !ok {
+		newState.flags |= encFlagMetaDone
+		newState.metaCursor = nil
+	}
+
+	batch.Put(encStateKeyName, serializeEncState(newState))
+	return db.cache.commitEncState(batch, newState)
+}
+
+// encryptBlocksBatch relocates the next batch of unencrypted blocks into the
+// encrypted block files and updates the block index accordingly.  Once every
+// block has been relocated, the now unused unencrypted block files are
+// truncated.
+//
+// This function MUST be called via withEncryptionLock.
+func (db *db) encryptBlocksBatch(s *encryptionState, batchSize int) error {
+	ldb := db.cache.ldb
+	iter := ldb.NewIterator(util.BytesPrefix(blockIdxBucketID[:]), nil)
+	defer iter.Release()
+
+	// Save the current block store write position for potential rollback
+	// in the same way as a normal commit.
+	store := db.store
+	wc := store.writeCursor
+	oldBlkFileNum := wc.curFileNum
+	oldBlkOffset := wc.curOffset
+	rollback := func() {
+		store.handleRollback(oldBlkFileNum, oldBlkOffset)
+	}
+
+	newState := s.clone()
+	batch := new(leveldb.Batch)
+	numMoved := 0
+	ok := seekPastCursor(iter, s.blockCursor)
+	for ; ok && numMoved < batchSize; ok = iter.Next() {
+		key := iter.Key()
+		newState.blockCursor = copySlice(key)
+		blockRow, err := s.decryptValue(key, iter.Value())
+		if err != nil {
+			rollback()
+			return err
+		}
+		loc := deserializeBlockLoc(blockRow)
+		if loc.blockLen == 0 || s.blockEncrypted(loc.blockFileNum) {
+			continue
+		}
+
+		hash, err := wire.NewShaHash(key[len(blockIdxBucketID):])
+		if err != nil {
+			rollback()
+			return makeDbErr(database.ErrCorruption, err.Error(), err)
+		}
+		rawBlock, err := store.readBlock(hash, loc, s)
+		if err != nil {
+			rollback()
+			return err
+		}
+		newLoc, err := store.writeBlock(hash, rawBlock, s)
+		if err != nil {
+			rollback()
+			return err
+		}
+
+		newRow := serializeBlockRow(newLoc, blockRow[blockHdrOffset:])
+		value, err := s.encryptValue(key, newRow)
+		if err != nil {
+			rollback()
+			return err
+		}
+		batch.Put(key, value)
+		numMoved++
+	}
+	if err := iter.Error(); err != nil {
+		rollback()
+		return convertErr("failed to iterate block index", err)
+	}
+
+	// Truncate the unencrypted block files once every block has been
+	// relocated by previous batches.
+	if !ok && numMoved == 0 {
+		if err := db.truncateUnencryptedFiles(s.startFileNum); err != nil {
+			return err
+		}
+		newState.flags |= encFlagBlocksDone
+		newState.blockCursor = nil
+	}
+
+	// Ensure the relocated blocks are on disk before the block index is
+	// updated to reference them.
+	if numMoved > 0 {
+		if err := store.syncBlocks(); err != nil {
+			rollback()
+			return err
+		}
+		writeLocKey := bucketizedKey(metadataBucketID, writeLocKeyName)
+		writeRow, err := s.encryptValue(writeLocKey,
+			serializeWriteRow(wc.curFileNum, wc.curOffset))
+		if err != nil {
+			rollback()
+			return err
+		}
+		batch.Put(writeLocKey, writeRow)
+
+		// The per-file counts of unpruned blocks no longer match the
+		// relocated blocks, so remove them to have them rebuilt the
+		// next time blocks are pruned.
+		batch.Delete(bucketizedKey(metadataBucketID, fileLiveKeyName))
+	}
+
+	batch.Put(encStateKeyName, serializeEncState(newState))
+	if err := db.cache.commitEncState(batch, newState); err != nil {
+		rollback()
+		return err
+	}
+	return nil
+}
+
+// truncateUnencryptedFiles truncates all block files before the passed file
+// number to zero length.  The files themselves are kept so the scan of the
+// block files on open still finds them in sequence.
+//
+// Transactions opened before the blocks were relocated might still reference
+// the old files, so this waits for all other transactions to finish first.
+//
+// This function MUST be called via withEncryptionLock.
+func (db *db) truncateUnencryptedFiles(startFileNum uint32) error {
+	fileNums := make([]uint32, 0, startFileNum)
+	for fileNum := uint32(0); fileNum < startFileNum; fileNum++ {
+		fileNums = append(fileNums, fileNum)
+	}
+	return db.truncateBlockFiles(fileNums)
+}
@@ -0,0 +1,385 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// This file is part of the ffldb package rather than the ffldb_test package as
+// it needs to interrupt database encryption and inspect the block files.
+
+package ffldb
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// encTestKey is the raw key used to encrypt the test databases.
+var encTestKey = EncryptionKey{0x01, 0x02, 0x03, 0x04}
+
+// storeEncTestData stores the passed blocks along with a metadata entry for each
+// of them in the provided database.
+func storeEncTestData(t *testing.T, idb database.DB, blocks []*colxutil.Block) bool {
+	err := idb.Update(func(tx database.Tx) error {
+		for _, block := range blocks {
+			if err := tx.StoreBlock(block); err != nil {
+				return err
+			}
+			key := []byte(fmt.Sprintf("enctest-%s", block.Sha()))
+			err := tx.Metadata().Put(key, block.Sha()[:])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Update: unexpected error: %v", err)
+		return false
+	}
+	return true
+}
+
+// checkEncTestData ensures the passed blocks and the metadata entries stored
+// for them by storeEncTestData can be read back from the provided database.
+func checkEncTestData(t *testing.T, idb database.DB, blocks []*colxutil.Block) bool {
+	err := idb.View(func(tx database.Tx) error {
+		for _, block := range blocks {
+			blockHash := block.Sha()
+			wantBytes, err := block.Bytes()
+			if err != nil {
+				return err
+			}
+
+			gotBytes, err := tx.FetchBlock(blockHash)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(gotBytes, wantBytes) {
+				return fmt.Errorf("block %s does not match",
+					blockHash)
+			}
+
+			region := database.BlockRegion{
+				Hash:   blockHash,
+				Offset: uint32(len(wantBytes)) - 10,
+				Len:    10,
+			}
+			gotRegion, err := tx.FetchBlockRegion(&region)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(gotRegion, wantBytes[region.Offset:]) {
+				return fmt.Errorf("region of block %s does "+
+					"not match", blockHash)
+			}
+
+			key := []byte(fmt.Sprintf("enctest-%s", blockHash))
+			gotValue := tx.Metadata().Get(key)
+			if !bytes.Equal(gotValue, blockHash[:]) {
+				return fmt.Errorf("metadata for block %s does "+
+					"not match - got %x", blockHash,
+					gotValue)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("View: unexpected error: %v", err)
+		return false
+	}
+	return true
+}
+
+// TestEncryptedRoundTrip ensures data stored in an encrypted database can be
+// read back both before and after the database is reopened and that the block
+// files do not contain the plaintext blocks.
+func TestEncryptedRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	blocks, err := loadBlocks(t, blockDataFile, blockDataNet)
+	if err != nil {
+		t.Errorf("loadBlocks: unexpected error: %v", err)
+		return
+	}
+	blocks = blocks[:20]
+
+	dbPath := filepath.Join(os.TempDir(), "ffldb-encroundtrip")
+	_ = os.RemoveAll(dbPath)
+	idb, err := database.Create(dbType, dbPath, blockDataNet, encTestKey)
+	if err != nil {
+		t.Errorf("Create: unexpected error: %v", err)
+		return
+	}
+	defer os.RemoveAll(dbPath)
+
+	if !storeEncTestData(t, idb, blocks) {
+		idb.Close()
+		return
+	}
+	if !checkEncTestData(t, idb, blocks) {
+		idb.Close()
+		return
+	}
+	if err := idb.Close(); err != nil {
+		t.Errorf("Close: unexpected error: %v", err)
+		return
+	}
+
+	// Ensure neither the serialized blocks nor the metadata appear in
+	// plaintext on disk.
+	fileBytes, err := ioutil.ReadFile(blockFilePath(dbPath, 0))
+	if err != nil {
+		t.Errorf("ReadFile: unexpected error: %v", err)
+		return
+	}
+	for _, block := range blocks {
+		blockBytes, _ := block.Bytes()
+		if bytes.Contains(fileBytes, blockBytes) {
+			t.Errorf("block %s stored in plaintext", block.Sha())
+			return
+		}
+	}
+
+	idb, err = database.Open(dbType, dbPath, blockDataNet, encTestKey)
+	if err != nil {
+		t.Errorf("Open: unexpected error: %v", err)
+		return
+	}
+	defer idb.Close()
+	checkEncTestData(t, idb, blocks)
+}
+
+// TestEncryptedWrongKey ensures opening an encrypted database with a missing or
+// incorrect key, or an unencrypted database with a key, fails cleanly.
+func TestEncryptedWrongKey(t *testing.T) {
+	t.Parallel()
+
+	dbPath := filepath.Join(os.TempDir(), "ffldb-encwrongkey")
+	_ = os.RemoveAll(dbPath)
+	passphrase := EncryptionPassphrase("correct horse battery staple")
+	idb, err := database.Create(dbType, dbPath, blockDataNet, passphrase)
+	if err != nil {
+		t.Errorf("Create: unexpected error: %v", err)
+		return
+	}
+	defer os.RemoveAll(dbPath)
+	idb.Close()
+
+	tests := []struct {
+		name string
+		args []interface{}
+	}{
+		{"no key", []interface{}{dbPath, blockDataNet}},
+		{"wrong passphrase", []interface{}{dbPath, blockDataNet,
+			EncryptionPassphrase("incorrect")}},
+		{"raw key for passphrase", []interface{}{dbPath, blockDataNet,
+			encTestKey}},
+	}
+	for _, test := range tests {
+		idb, err := database.Open(dbType, test.args...)
+		if !checkDbError(t, test.name, err, database.ErrInvalidKey) {
+			if err == nil {
+				idb.Close()
+			}
+			return
+		}
+	}
+
+	// Ensure the correct passphrase still works after the failures.
+	idb, err = database.Open(dbType, dbPath, blockDataNet, passphrase)
+	if err != nil {
+		t.Errorf("Open: unexpected error: %v", err)
+		return
+	}
+	idb.Close()
+
+	// Ensure a key is rejected for an unencrypted database.
+	plainPath := filepath.Join(os.TempDir(), "ffldb-encwrongkey-plain")
+	_ = os.RemoveAll(plainPath)
+	idb, err = database.Create(dbType, plainPath, blockDataNet)
+	if err != nil {
+		t.Errorf("Create: unexpected error: %v", err)
+		return
+	}
+	defer os.RemoveAll(plainPath)
+	idb.Close()
+
+	testName := "key for unencrypted database"
+	idb, err = database.Open(dbType, plainPath, blockDataNet, encTestKey)
+	if !checkDbError(t, testName, err, database.ErrInvalidKey) {
+		if err == nil {
+			idb.Close()
+		}
+	}
+}
+
+// TestEncryptDatabaseResume ensures converting an existing database works when
+// the conversion is interrupted and resumed after reopening the database,
+// including while new data is added in between.
+func TestEncryptDatabaseResume(t *testing.T) {
+	t.Parallel()
+
+	blocks, err := loadBlocks(t, blockDataFile, blockDataNet)
+	if err != nil {
+		t.Errorf("loadBlocks: unexpected error: %v", err)
+		return
+	}
+	blocks = blocks[:60]
+
+	dbPath := filepath.Join(os.TempDir(), "ffldb-encresume")
+	_ = os.RemoveAll(dbPath)
+	idb, err := database.Create(dbType, dbPath, blockDataNet)
+	if err != nil {
+		t.Errorf("Create: unexpected error: %v", err)
+		return
+	}
+	defer os.RemoveAll(dbPath)
+
+	// Force multiple block files so there are several to truncate.
+	idb.(*db).store.maxBlockFileSize = 4096
+	if !storeEncTestData(t, idb, blocks[:40]) {
+		idb.Close()
+		return
+	}
+
+	// Interrupt the conversion part way through each of the metadata and
+	// block phases.
+	for _, numBatches := range []int{2, 10} {
+		var batches int
+		err = idb.(*db).encrypt(encTestKey, 10, func() bool {
+			batches++
+			return batches > numBatches
+		})
+		if err == nil {
+			t.Errorf("encrypt: did not interrupt after %d batches",
+				numBatches)
+			idb.Close()
+			return
+		}
+		if !checkEncTestData(t, idb, blocks[:40]) {
+			idb.Close()
+			return
+		}
+	}
+	enc := idb.(*db).cache.enc
+	if enc.flags&encFlagMetaDone == 0 || enc.flags&encFlagBlocksDone != 0 {
+		t.Errorf("unexpected encryption flags after interruption: %x",
+			enc.flags)
+		idb.Close()
+		return
+	}
+	if err := idb.Close(); err != nil {
+		t.Errorf("Close: unexpected error: %v", err)
+		return
+	}
+
+	// The partially converted database must require the key.
+	testName := "partially encrypted without key"
+	idb, err = database.Open(dbType, dbPath, blockDataNet)
+	if !checkDbError(t, testName, err, database.ErrInvalidKey) {
+		if err == nil {
+			idb.Close()
+		}
+		return
+	}
+	idb, err = database.Open(dbType, dbPath, blockDataNet, encTestKey)
+	if err != nil {
+		t.Errorf("Open: unexpected error: %v", err)
+		return
+	}
+	defer idb.Close()
+	idb.(*db).store.maxBlockFileSize = 4096
+
+	// Add more data while the conversion is incomplete and then resume it.
+	if !storeEncTestData(t, idb, blocks[40:]) {
+		return
+	}
+	if !checkEncTestData(t, idb, blocks) {
+		return
+	}
+	testName = "EncryptDatabase with wrong key"
+	err = EncryptDatabase(idb, EncryptionKey{0xff})
+	if !checkDbError(t, testName, err, database.ErrInvalidKey) {
+		return
+	}
+	if err := EncryptDatabase(idb, encTestKey); err != nil {
+		t.Errorf("EncryptDatabase: unexpected error: %v", err)
+		return
+	}
+	if !checkEncTestData(t, idb, blocks) {
+		return
+	}
+
+	// Ensure all of the unencrypted block files were truncated.
+	enc = idb.(*db).cache.enc
+	if enc.flags&encFlagBlocksDone == 0 {
+		t.Errorf("blocks not marked encrypted: %x", enc.flags)
+		return
+	}
+	if enc.startFileNum == 0 {
+		t.Errorf("blocks were not spread across multiple files")
+		return
+	}
+	for fileNum := uint32(0); fileNum < enc.startFileNum; fileNum++ {
+		fi, err := os.Stat(blockFilePath(dbPath, fileNum))
+		if err != nil {
+			t.Errorf("Stat: unexpected error: %v", err)
+			return
+		}
+		if fi.Size() != 0 {
+			t.Errorf("unencrypted block file %d was not truncated",
+				fileNum)
+			return
+		}
+	}
+
+	// Converting an already encrypted database is a no-op.
+	if err := EncryptDatabase(idb, encTestKey); err != nil {
+		t.Errorf("EncryptDatabase: unexpected error: %v", err)
+		return
+	}
+}
+
+// TestEncStateSerialization ensures serializing and deserializing the
+// encryption state works as expected and malformed states are rejected.
+func TestEncStateSerialization(t *testing.T) {
+	t.Parallel()
+
+	s, err := newEncryptionState(encTestKey, 5)
+	if err != nil {
+		t.Fatalf("newEncryptionState: unexpected error: %v", err)
+	}
+	s.flags = encFlagMetaDone
+	s.metaCursor = []byte("meta")
+	s.blockCursor = []byte("block")
+
+	serialized := serializeEncState(s)
+	got, err := deserializeEncState(serialized)
+	if err != nil {
+		t.Fatalf("deserializeEncState: unexpected error: %v", err)
+	}
+	if err := got.unlock(encTestKey); err != nil {
+		t.Fatalf("unlock: unexpected error: %v", err)
+	}
+	if got.flags != s.flags || got.startFileNum != s.startFileNum ||
+		!bytes.Equal(got.metaCursor, s.metaCursor) ||
+		!bytes.Equal(got.blockCursor, s.blockCursor) {
+
+		t.Fatalf("mismatched state - got %+v, want %+v", got, s)
+	}
+
+	// Ensure corruption and truncation are detected.
+	corrupt := copySlice(serialized)
+	corrupt[5] ^= 0xff
+	_, err = deserializeEncState(corrupt)
+	checkDbError(t, "corrupt state", err, database.ErrCorruption)
+	_, err = deserializeEncState(serialized[:10])
+	checkDbError(t, "short state", err, database.ErrCorruption)
+}
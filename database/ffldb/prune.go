@@ -0,0 +1,283 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package ffldb
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// Pruned blocks keep their row in the block index so the header remains
+// available, but the block length of the stored location is set to zero to
+// indicate the block data is no longer available.  The block data itself is
+// reclaimed once every block stored in a given block file has been pruned, at
+// which point the file is truncated to zero length.  The files themselves are
+// kept so the scan of the block files on open still finds them in sequence.
+//
+// In order to determine when a file no longer houses any blocks, the number of
+// unpruned blocks in each file is tracked under the fileLiveKeyName key in the
+// metadata bucket.  Databases that have never been pruned do not have the key,
+// so it is built from the block index the first time any blocks are pruned and
+// maintained from that point on.
+
+var (
+	// fileLiveKeyName is the name of the key used to store the number of
+	// unpruned blocks in each block file.
+	fileLiveKeyName = []byte("ffldb-filelive")
+)
+
+// serializeFileLiveCounts returns the serialization of the passed per-file
+// unpruned block counts.  Files without any remaining blocks are omitted.
+func serializeFileLiveCounts(counts map[uint32]uint32) []byte {
+	// The serialized format is a series of entries sorted by file number
+	// where each entry is:
+	//
+	//  [0:4]  Block file (4 bytes)
+	//  [4:8]  Number of unpruned blocks in the file (4 bytes)
+	fileNums := make([]uint32, 0, len(counts))
+	for fileNum, count := range counts {
+		if count > 0 {
+			fileNums = append(fileNums, fileNum)
+		}
+	}
+	sort.Sort(uint32Sorter(fileNums))
+
+	serialized := make([]byte, len(fileNums)*8)
+	for i, fileNum := range fileNums {
+		offset := i * 8
+		byteOrder.PutUint32(serialized[offset:], fileNum)
+		byteOrder.PutUint32(serialized[offset+4:], counts[fileNum])
+	}
+	return serialized
+}
+
+// deserializeFileLiveCounts deserializes the passed per-file unpruned block
+// counts.
+func deserializeFileLiveCounts(serialized []byte) (map[uint32]uint32, error) {
+	if len(serialized)%8 != 0 {
+		str := fmt.Sprintf("malformed block file counts of length %d",
+			len(serialized))
+		return nil, makeDbErr(database.ErrCorruption, str, nil)
+	}
+
+	counts := make(map[uint32]uint32, len(serialized)/8)
+	for offset := 0; offset < len(serialized); offset += 8 {
+		fileNum := byteOrder.Uint32(serialized[offset:])
+		counts[fileNum] = byteOrder.Uint32(serialized[offset+4:])
+	}
+	return counts, nil
+}
+
+// uint32Sorter implements sort.Interface to allow a slice of uint32s to be
+// sorted.
+type uint32Sorter []uint32
+
+// Len returns the number of uint32s in the slice.  It is part of the
+// sort.Interface implementation.
+func (s uint32Sorter) Len() int {
+	return len(s)
+}
+
+// Swap swaps the uint32s at the passed indices.  It is part of the
+// sort.Interface implementation.
+func (s uint32Sorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Less returns whether the uint32 with index i should sort before the uint32
+// with index j.  It is part of the sort.Interface implementation.
+func (s uint32Sorter) Less(i, j int) bool {
+	return s[i] < s[j]
+}
+
+// loadFileLiveCounts loads the per-file unpruned block counts into the
+// transaction when they have not already been loaded.  The counts are built
+// from the block index when build is set and the database has never been
+// pruned.  Otherwise, they are left nil since there is nothing to maintain.
+func (tx *transaction) loadFileLiveCounts(build bool) error {
+	if tx.fileLive != nil {
+		return nil
+	}
+
+	if serialized := tx.metaBucket.Get(fileLiveKeyName); serialized != nil {
+		counts, err := deserializeFileLiveCounts(serialized)
+		if err != nil {
+			return err
+		}
+		tx.fileLive = counts
+		return nil
+	}
+	if !build {
+		return nil
+	}
+
+	// Count the unpruned blocks in each file from the block index.  Blocks
+	// that are pending to be written on commit are counted once they are
+	// written.
+	counts := make(map[uint32]uint32)
+	err := tx.blockIdxBucket.ForEach(func(k, v []byte) error {
+		if len(v) < blockLocSize {
+			str := fmt.Sprintf("malformed block index entry for %x",
+				k)
+			return makeDbErr(database.ErrCorruption, str, nil)
+		}
+		loc := deserializeBlockLoc(v)
+		if loc.blockLen != 0 {
+			counts[loc.blockFileNum]++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	tx.fileLive = counts
+	return nil
+}
+
+// PruneBlocks removes the raw serialized data for the blocks identified by the
+// given hashes from the database while retaining their headers.  The space
+// used by the blocks is reclaimed once all of the blocks in the block file that
+// houses them have been pruned.
+//
+// Returns the following errors as required by the interface contract:
+//   - ErrBlockNotFound if any of the requested block hashes do not exist
+//   - ErrTxNotWritable if attempted against a read-only transaction
+//   - ErrTxClosed if the transaction has already been closed
+//   - ErrCorruption if the database has somehow become corrupted
+//
+// This function is part of the database.Tx interface implementation.
+func (tx *transaction) PruneBlocks(hashes []wire.ShaHash) error {
+	// Ensure transaction state is valid.
+	if err := tx.checkClosed(); err != nil {
+		return err
+	}
+
+	// Ensure the transaction is writable.
+	if !tx.writable {
+		str := "prune blocks requires a writable database transaction"
+		return makeDbErr(database.ErrTxNotWritable, str, nil)
+	}
+
+	if err := tx.loadFileLiveCounts(true); err != nil {
+		return err
+	}
+
+	for i := range hashes {
+		hash := &hashes[i]
+
+		// Blocks which have not been written yet can't be pruned since
+		// there is nothing on disk to reclaim.
+		if _, exists := tx.pendingBlocks[*hash]; exists {
+			str := fmt.Sprintf("block %s can not be pruned in the "+
+				"same transaction it is stored in", hash)
+			return makeDbErr(database.ErrDriverSpecific, str, nil)
+		}
+
+		blockRow, err := tx.fetchBlockRow(hash)
+		if err != nil {
+			return err
+		}
+		loc := deserializeBlockLoc(blockRow)
+		if loc.blockLen == 0 {
+			continue
+		}
+
+		// Mark the block pruned in the block index while keeping the
+		// header.
+		if count := tx.fileLive[loc.blockFileNum]; count > 0 {
+			tx.fileLive[loc.blockFileNum] = count - 1
+			if count == 1 {
+				tx.prunedFiles = append(tx.prunedFiles,
+					loc.blockFileNum)
+			}
+		}
+		loc.blockLen = 0
+		newRow := serializeBlockRow(loc, blockRow[blockHdrOffset:])
+		if err := tx.blockIdxBucket.Put(hash[:], newRow); err != nil {
+			return err
+		}
+		log.Tracef("Pruned block %s", hash)
+	}
+
+	return nil
+}
+
+// reclaimPrunedFiles truncates the passed block files which no longer house
+// any unpruned blocks.  The current write file is never truncated.
+//
+// The cache is flushed first so the block index never references the data in
+// the truncated files after a crash.  Also, transactions opened before the
+// blocks were pruned might still reference the files, so this waits for all
+// other transactions to finish first.
+//
+// This function MUST be called with the write lock and a close read lock held.
+func (db *db) reclaimPrunedFiles(fileNums []uint32) error {
+	if err := db.cache.flush(); err != nil {
+		return err
+	}
+
+	wc := db.store.writeCursor
+	wc.RLock()
+	curFileNum := wc.curFileNum
+	wc.RUnlock()
+	reclaim := make([]uint32, 0, len(fileNums))
+	for _, fileNum := range fileNums {
+		if fileNum < curFileNum {
+			reclaim = append(reclaim, fileNum)
+		}
+	}
+	if len(reclaim) == 0 {
+		return nil
+	}
+
+	log.Debugf("Reclaiming %d pruned block files", len(reclaim))
+	return db.truncateBlockFiles(reclaim)
+}
+
+// truncateBlockFiles truncates the passed block files to zero length after
+// waiting for all other transactions to finish.
+//
+// This function MUST be called with the write lock and a close read lock held.
+func (db *db) truncateBlockFiles(fileNums []uint32) error {
+	// Upgrade the close read lock to wait for all other transactions.  New
+	// transactions can't start in the mean time since the write lock is
+	// held and readers are blocked on the close lock.
+	db.closeLock.RUnlock()
+	db.closeLock.Lock()
+	defer func() {
+		db.closeLock.Unlock()
+		db.closeLock.RLock()
+	}()
+	if db.closed {
+		return makeDbErr(database.ErrDbNotOpen, errDbNotOpenStr, nil)
+	}
+
+	store := db.store
+	for _, fileNum := range fileNums {
+		// Close the file if it is open for reads.
+		if blockFile, ok := store.openBlockFiles[fileNum]; ok {
+			_ = blockFile.file.Close()
+			store.lruMutex.Lock()
+			store.openBlocksLRU.Remove(store.fileNumToLRUElem[fileNum])
+			delete(store.fileNumToLRUElem, fileNum)
+			store.lruMutex.Unlock()
+			delete(store.openBlockFiles, fileNum)
+		}
+
+		filePath := blockFilePath(store.basePath, fileNum)
+		err := os.Truncate(filePath, 0)
+		if err != nil && !os.IsNotExist(err) {
+			return makeDbErr(database.ErrDriverSpecific, err.Error(),
+				err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,317 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// This file is part of the ffldb package rather than the ffldb_test package as
+// it needs to inspect the block files and pruning state.
+
+package ffldb
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// blockHashes returns the hashes of the passed blocks.
+func blockHashes(blocks []*colxutil.Block) []wire.ShaHash {
+	hashes := make([]wire.ShaHash, 0, len(blocks))
+	for _, block := range blocks {
+		hashes = append(hashes, *block.Sha())
+	}
+	return hashes
+}
+
+// checkPrunedBlocks ensures the data for all of the passed blocks has been
+// pruned while their headers are still available.
+func checkPrunedBlocks(t *testing.T, idb database.DB, blocks []*colxutil.Block) bool {
+	err := idb.View(func(tx database.Tx) error {
+		for _, block := range blocks {
+			blockHash := block.Sha()
+			testName := "FetchBlock " + blockHash.String()
+			_, err := tx.FetchBlock(blockHash)
+			if !checkDbError(t, testName, err, database.ErrBlockPruned) {
+				return nil
+			}
+
+			testName = "FetchBlockRegion " + blockHash.String()
+			region := database.BlockRegion{
+				Hash:   blockHash,
+				Offset: 0,
+				Len:    1,
+			}
+			_, err = tx.FetchBlockRegion(&region)
+			if !checkDbError(t, testName, err, database.ErrBlockPruned) {
+				return nil
+			}
+
+			testName = "FetchBlockRegions " + blockHash.String()
+			regions := []database.BlockRegion{region}
+			_, err = tx.FetchBlockRegions(regions)
+			if !checkDbError(t, testName, err, database.ErrBlockPruned) {
+				return nil
+			}
+
+			hasBlock, err := tx.HasBlock(blockHash)
+			if err != nil {
+				return err
+			}
+			if !hasBlock {
+				t.Errorf("HasBlock: pruned block %s does not exist",
+					blockHash)
+				return nil
+			}
+
+			var wantHeader bytes.Buffer
+			err = block.MsgBlock().Header.Serialize(&wantHeader)
+			if err != nil {
+				return err
+			}
+			gotHeader, err := tx.FetchBlockHeader(blockHash)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(gotHeader, wantHeader.Bytes()) {
+				t.Errorf("FetchBlockHeader: header for pruned block "+
+					"%s does not match", blockHash)
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("View: unexpected error: %v", err)
+		return false
+	}
+	return !t.Failed()
+}
+
+// checkUnprunedBlocks ensures all of the passed blocks can still be fetched.
+func checkUnprunedBlocks(t *testing.T, idb database.DB, blocks []*colxutil.Block) bool {
+	err := idb.View(func(tx database.Tx) error {
+		for _, block := range blocks {
+			wantBytes, err := block.Bytes()
+			if err != nil {
+				return err
+			}
+			gotBytes, err := tx.FetchBlock(block.Sha())
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(gotBytes, wantBytes) {
+				t.Errorf("FetchBlock: block %s does not match",
+					block.Sha())
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("View: unexpected error: %v", err)
+		return false
+	}
+	return !t.Failed()
+}
+
+// TestPruneBlocks ensures pruning blocks removes their data while keeping their
+// headers, that block files which no longer house any blocks are truncated, and
+// that the pruning state survives reopening the database.
+func TestPruneBlocks(t *testing.T) {
+	t.Parallel()
+
+	blocks, err := loadBlocks(t, blockDataFile, blockDataNet)
+	if err != nil {
+		t.Errorf("loadBlocks: unexpected error: %v", err)
+		return
+	}
+	blocks = blocks[:60]
+
+	dbPath := filepath.Join(os.TempDir(), "ffldb-pruneblocks")
+	_ = os.RemoveAll(dbPath)
+	idb, err := database.Create(dbType, dbPath, blockDataNet)
+	if err != nil {
+		t.Errorf("Create: unexpected error: %v", err)
+		return
+	}
+	defer os.RemoveAll(dbPath)
+
+	// Force multiple block files so there are some to reclaim.
+	idb.(*db).store.maxBlockFileSize = 4096
+	err = idb.Update(func(tx database.Tx) error {
+		for _, block := range blocks[:40] {
+			if err := tx.StoreBlock(block); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Update: unexpected error: %v", err)
+		idb.Close()
+		return
+	}
+
+	// Ensure the expected errors for invalid prune attempts.
+	err = idb.View(func(tx database.Tx) error {
+		testName := "PruneBlocks on read-only transaction"
+		err := tx.PruneBlocks(blockHashes(blocks[:1]))
+		checkDbError(t, testName, err, database.ErrTxNotWritable)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("View: unexpected error: %v", err)
+		idb.Close()
+		return
+	}
+	err = idb.Update(func(tx database.Tx) error {
+		testName := "PruneBlocks for unknown block"
+		err := tx.PruneBlocks(blockHashes(blocks[50:51]))
+		checkDbError(t, testName, err, database.ErrBlockNotFound)
+
+		if err := tx.StoreBlock(blocks[40]); err != nil {
+			return err
+		}
+		testName = "PruneBlocks for pending block"
+		err = tx.PruneBlocks(blockHashes(blocks[40:41]))
+		checkDbError(t, testName, err, database.ErrDriverSpecific)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Update: unexpected error: %v", err)
+		idb.Close()
+		return
+	}
+	if t.Failed() {
+		idb.Close()
+		return
+	}
+
+	// Prune the oldest blocks, including pruning some of them twice.
+	err = idb.Update(func(tx database.Tx) error {
+		return tx.PruneBlocks(blockHashes(blocks[:20]))
+	})
+	if err == nil {
+		err = idb.Update(func(tx database.Tx) error {
+			return tx.PruneBlocks(blockHashes(blocks[:25]))
+		})
+	}
+	if err != nil {
+		t.Errorf("PruneBlocks: unexpected error: %v", err)
+		idb.Close()
+		return
+	}
+	if !checkPrunedBlocks(t, idb, blocks[:25]) ||
+		!checkUnprunedBlocks(t, idb, blocks[25:41]) {
+
+		idb.Close()
+		return
+	}
+
+	// Ensure the first block file, which only contained pruned blocks, was
+	// truncated.
+	fi, err := os.Stat(blockFilePath(dbPath, 0))
+	if err != nil {
+		t.Errorf("Stat: unexpected error: %v", err)
+		idb.Close()
+		return
+	}
+	if fi.Size() != 0 {
+		t.Errorf("fully pruned block file was not truncated")
+		idb.Close()
+		return
+	}
+	if err := idb.Close(); err != nil {
+		t.Errorf("Close: unexpected error: %v", err)
+		return
+	}
+
+	// Ensure the pruned state survives reopening the database and the
+	// counts are maintained for newly stored blocks.
+	idb, err = database.Open(dbType, dbPath, blockDataNet)
+	if err != nil {
+		t.Errorf("Open: unexpected error: %v", err)
+		return
+	}
+	defer idb.Close()
+	idb.(*db).store.maxBlockFileSize = 4096
+	err = idb.Update(func(tx database.Tx) error {
+		for _, block := range blocks[41:] {
+			if err := tx.StoreBlock(block); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Update: unexpected error: %v", err)
+		return
+	}
+	err = idb.Update(func(tx database.Tx) error {
+		return tx.PruneBlocks(blockHashes(blocks[25:50]))
+	})
+	if err != nil {
+		t.Errorf("PruneBlocks: unexpected error: %v", err)
+		return
+	}
+	if !checkPrunedBlocks(t, idb, blocks[:50]) ||
+		!checkUnprunedBlocks(t, idb, blocks[50:]) {
+
+		return
+	}
+
+	err = idb.View(func(tx database.Tx) error {
+		wantLive := uint32(len(blocks[50:]))
+		serialized := tx.Metadata().Get(fileLiveKeyName)
+		counts, err := deserializeFileLiveCounts(serialized)
+		if err != nil {
+			return err
+		}
+		var gotLive uint32
+		for _, count := range counts {
+			gotLive += count
+		}
+		if gotLive != wantLive {
+			t.Errorf("unexpected number of unpruned blocks - got %d, "+
+				"want %d", gotLive, wantLive)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Errorf("View: unexpected error: %v", err)
+	}
+}
+
+// TestFileLiveCountsSerialization ensures serializing and deserializing the
+// per-file unpruned block counts works as expected.
+func TestFileLiveCountsSerialization(t *testing.T) {
+	t.Parallel()
+
+	counts := map[uint32]uint32{0: 0, 1: 5, 3: 1, 10: 200}
+	serialized := serializeFileLiveCounts(counts)
+	if len(serialized) != 24 {
+		t.Fatalf("unexpected serialized length %d", len(serialized))
+	}
+	got, err := deserializeFileLiveCounts(serialized)
+	if err != nil {
+		t.Fatalf("deserializeFileLiveCounts: unexpected error: %v", err)
+	}
+	delete(counts, 0)
+	if len(got) != len(counts) {
+		t.Fatalf("mismatched counts - got %v, want %v", got, counts)
+	}
+	for fileNum, count := range counts {
+		if got[fileNum] != count {
+			t.Fatalf("mismatched counts - got %v, want %v", got,
+				counts)
+		}
+	}
+
+	_, err = deserializeFileLiveCounts(serialized[:5])
+	checkDbError(t, "short counts", err, database.ErrCorruption)
+}
@@ -180,7 +180,7 @@ func TestCornerCases(t *testing.T) {
 	// directory is needed.
 	testName := "openDB: fail due to file at target location"
 	wantErrCode := database.ErrDriverSpecific
-	idb, err := openDB(dbPath, blockDataNet, true)
+	idb, err := openDB(dbPath, blockDataNet, nil, true)
 	if !checkDbError(t, testName, err, wantErrCode) {
 		if err == nil {
 			idb.Close()
@@ -192,7 +192,7 @@ func TestCornerCases(t *testing.T) {
 	// Remove the file and create the database to run tests against.  It
 	// should be successful this time.
 	_ = os.RemoveAll(dbPath)
-	idb, err = openDB(dbPath, blockDataNet, true)
+	idb, err = openDB(dbPath, blockDataNet, nil, true)
 	if err != nil {
 		t.Errorf("openDB: unexpected error: %v", err)
 		return
@@ -209,7 +209,7 @@ func TestCornerCases(t *testing.T) {
 		return
 	}
 	store := idb.(*db).store
-	_, err = store.writeBlock([]byte{0x00})
+	_, err = store.writeBlock(&wire.ShaHash{}, []byte{0x00}, nil)
 	if !checkDbError(t, testName, err, database.ErrDriverSpecific) {
 		return
 	}
@@ -448,12 +448,12 @@ func testBlockFileErrors(tc *testContext) bool {
 		blockFileNum: ^uint32(0),
 		blockLen:     80,
 	}
-	_, err = store.readBlock(block0Hash, invalidLoc)
+	_, err = store.readBlock(block0Hash, invalidLoc, nil)
 	if !checkDbError(tc.t, testName, err, database.ErrDriverSpecific) {
 		return false
 	}
 	testName = "readBlockRegion invalid file number"
-	_, err = store.readBlockRegion(invalidLoc, 0, 80)
+	_, err = store.readBlockRegion(block0Hash, invalidLoc, 0, 80, nil)
 	if !checkDbError(tc.t, testName, err, database.ErrDriverSpecific) {
 		return false
 	}
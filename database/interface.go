@@ -307,6 +307,7 @@ type Tx interface {
 	// The interface contract guarantees at least the following errors will
 	// be returned (other implementation-specific errors are possible):
 	//   - ErrBlockNotFound if the requested block hash does not exist
+	//   - ErrBlockPruned if the requested block data has been pruned
 	//   - ErrTxClosed if the transaction has already been closed
 	//   - ErrCorruption if the database has somehow become corrupted
 	//
@@ -325,6 +326,8 @@ type Tx interface {
 	// be returned (other implementation-specific errors are possible):
 	//   - ErrBlockNotFound if the any of the requested block hashes do not
 	//     exist
+	//   - ErrBlockPruned if the data for any of the requested blocks has
+	//     been pruned
 	//   - ErrTxClosed if the transaction has already been closed
 	//   - ErrCorruption if the database has somehow become corrupted
 	//
@@ -352,6 +355,7 @@ type Tx interface {
 	//   - ErrBlockNotFound if the requested block hash does not exist
 	//   - ErrBlockRegionInvalid if the region exceeds the bounds of the
 	//     associated block
+	//   - ErrBlockPruned if the associated block data has been pruned
 	//   - ErrTxClosed if the transaction has already been closed
 	//   - ErrCorruption if the database has somehow become corrupted
 	//
@@ -380,6 +384,8 @@ type Tx interface {
 	//     exist
 	//   - ErrBlockRegionInvalid if one or more region exceed the bounds of
 	//     the associated block
+	//   - ErrBlockPruned if the data for any of the associated blocks has
+	//     been pruned
 	//   - ErrTxClosed if the transaction has already been closed
 	//   - ErrCorruption if the database has somehow become corrupted
 	//
@@ -390,6 +396,24 @@ type Tx interface {
 	// implementations.
 	FetchBlockRegions(regions []BlockRegion) ([][]byte, error)
 
+	// PruneBlocks removes the raw serialized data for the blocks identified
+	// by the given hashes from the database.  The headers for the blocks
+	// are retained, so HasBlock continues to report the blocks exist and
+	// FetchBlockHeader(s) continues to work, however any attempt to fetch
+	// the block data or regions of it will return ErrBlockPruned.  Pruning
+	// a block that has already been pruned has no effect.
+	//
+	// The interface contract guarantees at least the following errors will
+	// be returned (other implementation-specific errors are possible):
+	//   - ErrBlockNotFound if any of the requested block hashes do not
+	//     exist
+	//   - ErrTxNotWritable if attempted against a read-only transaction
+	//   - ErrTxClosed if the transaction has already been closed
+	//   - ErrCorruption if the database has somehow become corrupted
+	//
+	// Other errors are possible depending on the implementation.
+	PruneBlocks(hashes []wire.ShaHash) error
+
 	// ******************************************************************
 	// Methods related to both atomic metadata storage and block storage.
 	// ******************************************************************
@@ -40,10 +40,26 @@ Application Options:
                             banning misbehaving peers.
       --banduration=        How long to ban misbehaving peers.  Valid time units
                             are {s, m, h}.  Minimum 1 second (24h0m0s)
+      --minprotocolversion= Minimum protocol version remote peers must
+                            advertise to be accepted (209)
+      --maxconnspernetgroup= Max number of automatic outbound connections to
+                            peers in the same network group (such as an IPv4
+                            /16) (1)
+      --maxrecvpayload=     Maximum payload in bytes of messages accepted from
+                            peers -- Peers repeatedly sending larger messages
+                            are disconnected (0 for the protocol maximum)
   -u, --rpcuser=            Username for RPC connections
   -P, --rpcpass=            Password for RPC connections
       --rpclimituser=       Username for limited RPC connections
       --rpclimitpass=       Password for limited RPC connections
+      --rpclimitmethod=     Add a method the limited RPC user is allowed to
+                            call -- NOTE: When specified, only the listed
+                            methods are available to the limited user instead
+                            of the default set
+      --rpcauditmethod=     Add a method whose RPC calls are recorded in the
+                            audit log (default: node, stop, submitblock)
+      --rpcauditfile=       Additionally write the RPC audit log to this file,
+                            which is rotated like the main log file
       --rpclisten=          Add an interface/port to listen for RPC connections
                             (default port: 8334, testnet: 18334)
       --rpccert=            File containing the certificate file
@@ -51,6 +67,15 @@ Application Options:
       --rpcmaxclients=      Max number of RPC clients for standard connections
                             (10)
       --rpcmaxwebsockets=   Max number of RPC websocket connections (25)
+      --rpclongpolltimeout= Maximum time a getblocktemplate long poll request
+                            waits for the template to become stale before the
+                            current template is returned.  Valid time units are
+                            {s, m, h} (1m0s)
+      --rpcnotifyurl=       Post JSON notifications about connected blocks, new
+                            mempool transactions, and reorganizes to the HTTP
+                            endpoint at this URL
+      --rpcnotifykey=       Key used to sign the notifications posted to
+                            --rpcnotifyurl with HMAC-SHA256
       --norpc               Disable built-in RPC server -- NOTE: The RPC server
                             is disabled by default if no rpcuser/rpcpass or
                             rpclimituser/rpclimitpass is specified
@@ -59,6 +84,8 @@ Application Options:
       --nodnsseed           Disable DNS seeding for peers
       --externalip=         Add an ip to the list of local addresses we claim to
                             listen on to peers
+      --noselfadvertise     Do not advertise our own listening address to peers
+                            shortly after connecting to them and once a day
       --proxy=              Connect via SOCKS5 proxy (eg. 127.0.0.1:9050)
       --proxyuser=          Username for proxy server
       --proxypass=          Password for proxy server
@@ -72,6 +99,7 @@ Application Options:
       --testnet             Use the test network
       --regtest             Use the regression test network
       --simnet              Use the simulation test network
+      --addcheckpoint=      Add a custom checkpoint.  Format: '<height>:<hash>'
       --nocheckpoints       Disable built-in checkpoints.  Don't do this unless
                             you know what you're doing.
       --dbtype=             Database backend to use for the Block Chain (ffldb)
@@ -91,9 +119,45 @@ Application Options:
                             minute (15)
       --norelaypriority     Do not require free or low-fee transactions to have
                             high priority for relaying
+      --maxtxversion=       Do not relay or mine transactions with a version
+                            above this value, although blocks containing them
+                            are still accepted (default: the highest
+                            transaction version defined on the network)
+      --datacarriersize=    Maximum size in bytes of the data carrier
+                            (nulldata) output scripts to relay and mine -- 0
+                            disables relaying and mining them (83)
       --maxorphantx=        Max number of orphan transactions to keep in memory
                             (1000)
+      --orphanttl=          How long to keep orphan transactions in memory
+                            before they expire.  Valid time units are {s, m,
+                            h}.  0 disables expiration (20m0s)
+      --limitancestorcount= Do not accept transactions if the number of
+                            unconfirmed transactions in the memory pool they
+                            depend on, including themselves, exceeds this value
+                            -- 0 disables the limit (25)
+      --limitancestorsize=  Do not accept transactions if the total size in
+                            kilobytes of the unconfirmed transactions in the
+                            memory pool they depend on, including themselves,
+                            exceeds this value -- 0 disables the limit (101)
+      --limitdescendantcount=
+                            Do not accept transactions if any unconfirmed
+                            transaction in the memory pool they depend on would
+                            have more than this many transactions depending on
+                            it, including itself -- 0 disables the limit (25)
+      --limitdescendantsize=
+                            Do not accept transactions if any unconfirmed
+                            transaction in the memory pool they depend on would
+                            have more than this many kilobytes of transactions
+                            depending on it, including itself -- 0 disables the
+                            limit (101)
+      --maxmempool=         Evict the transactions with the lowest fee rates
+                            once the total size in megabytes of the
+                            transactions in the memory pool exceeds this value
+                            -- 0 disables the limit (300)
       --generate            Generate (mine) bitcoins using the CPU
+      --genproclimit=       Number of workers which search for a solution of a
+                            block in parallel when generating bitcoins using
+                            the CPU -- -1 uses one per processor core (-1)
       --miningaddr=         Add the specified payment address to the list of
                             addresses to use for generated blocks -- At least
                             one address is required if the generate option is
@@ -104,11 +168,29 @@ Application Options:
                             a block (750000)
       --blockprioritysize=  Size in bytes for high-priority/low-fee transactions
                             when creating a block (50000)
+      --templatefeedelta=   Change in BTC of the total fees of the transactions
+                            in the memory pool which makes block templates stale
+                            for getblocktemplate long poll clients (0.001)
       --getworkkey=         DEPRECATED -- Use the --miningaddr option instead
       --nopeerbloomfilters  Disable bloom filtering support.
       --sigcachemaxsize=    The maximum number of entries in the signature
                             verification cache.
       --blocksonly          Do not accept transactions from remote peers.
+      --persistmempool      Save the memory pool to the data directory on
+                            shutdown and load it on startup
+      --prune=              Reduce storage requirements by deleting the data for
+                            old blocks once the stored block data exceeds the
+                            target size in MiB -- The minimum target is 550 and
+                            0 disables pruning
+      --storeinvalidblocks  Store blocks which fail validation when connecting
+                            them, such as due to a local validation bug,
+                            instead of discarding them so they can be validated
+                            again with the reconsiderblock RPC
+      --assumevalid=        Skip script validation for the ancestors of the
+                            block with the given hash once they are buried
+                            deeply enough under the best known header chain
+                            containing it -- The zero hash disables the
+                            optimization
 
 Help Options:
   -h, --help           Show this help message
@@ -0,0 +1,542 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+const (
+	// feeEstimatorFileName is the name of the file the state of the fee
+	// estimator is saved to within the data directory.
+	feeEstimatorFileName = "feeestimates.dat"
+
+	// feeEstimatorFileVersion is the current version of the serialized fee
+	// estimator format.
+	feeEstimatorFileVersion = 1
+
+	// feeEstMaxConfirms is the maximum number of blocks the fee estimator
+	// tracks a transaction for.  Transactions which are not mined within
+	// this many blocks count as failures for every confirmation target and
+	// it is the highest target fees can be estimated for.
+	feeEstMaxConfirms = 25
+
+	// feeEstMinFeeRate is the lower bound in satoshi per kilobyte of the
+	// lowest fee rate bucket.  Transactions paying less are counted in the
+	// lowest bucket.
+	feeEstMinFeeRate = 1000
+
+	// feeEstMaxFeeRate is the lower bound in satoshi per kilobyte above
+	// which no more fee rate buckets are created.  Transactions paying more
+	// are counted in the highest bucket.
+	feeEstMaxFeeRate = 1e7
+
+	// feeEstBucketSpacing is the ratio between the lower bounds of
+	// consecutive fee rate buckets.
+	feeEstBucketSpacing = 1.1
+
+	// feeEstDecay is the factor the observations are multiplied by every
+	// block, so old observations gradually stop affecting the estimates.
+	// An observation loses half of its weight after roughly 350 blocks.
+	feeEstDecay = 0.998
+
+	// feeEstSuccessThreshold is the fraction of the transactions paying a
+	// fee rate which must have been mined within a confirmation target for
+	// the fee rate to be considered enough to meet the target.
+	feeEstSuccessThreshold = 0.85
+
+	// feeEstMinSamples is the minimum weight of the observations the
+	// success of fee rates is calculated from.  The fee rates of buckets
+	// which are too sparsely populated are grouped with higher buckets
+	// until there is enough data.
+	feeEstMinSamples = 10
+
+	// feeEstMaxUndoBlocks is the number of the most recently registered
+	// blocks the fee estimator keeps the data to roll back for.  Blocks
+	// disconnected by deeper reorganizations leave their observations in
+	// place.
+	feeEstMaxUndoBlocks = 100
+)
+
+// errInsufficientFeeData is returned by EstimateFee when not enough
+// transactions have been observed to estimate a fee rate for the requested
+// confirmation target.
+var errInsufficientFeeData = errors.New("insufficient data to estimate fee")
+
+// feeEstBuckets houses the lower bounds of the fee rate buckets in satoshi per
+// kilobyte in ascending order.
+var feeEstBuckets = func() []float64 {
+	var buckets []float64
+	for rate := float64(feeEstMinFeeRate); rate <= feeEstMaxFeeRate; rate *= feeEstBucketSpacing {
+		buckets = append(buckets, rate)
+	}
+	return buckets
+}()
+
+// feeEstBucket returns the index of the bucket the passed fee rate in satoshi
+// per kilobyte is counted in.
+func feeEstBucket(feeRate float64) int {
+	i := sort.SearchFloat64s(feeEstBuckets, feeRate)
+	if i == len(feeEstBuckets) || feeEstBuckets[i] != feeRate {
+		i--
+	}
+	if i < 0 {
+		i = 0
+	}
+	return i
+}
+
+// feeEstTx describes a transaction which is tracked by the fee estimator until
+// it is mined or expires.
+type feeEstTx struct {
+	height int32
+	bucket int
+}
+
+// feeEstUndoEntry describes a transaction which stopped being tracked when a
+// block was registered.  A zero number of blocks means the transaction expired
+// without being mined.
+type feeEstUndoEntry struct {
+	hash      wire.ShaHash
+	tx        feeEstTx
+	numBlocks int32
+}
+
+// feeEstBlockUndo houses the data needed to roll back the registration of a
+// block.
+type feeEstBlockUndo struct {
+	height  int32
+	entries []feeEstUndoEntry
+}
+
+// feeEstimator estimates the fee rate transactions need to pay to be mined
+// within a number of blocks.  It records the fee rate and the height of every
+// transaction accepted into the memory pool and, as blocks are connected,
+// counts how many blocks it took the transactions of each fee rate bucket to
+// be mined.  The counts decay every block so the estimates follow changes in
+// the demand for block space.
+//
+// It is safe for concurrent access.
+type feeEstimator struct {
+	sync.Mutex
+
+	// lastHeight is the height of the last registered block.
+	lastHeight int32
+
+	// txTotal houses the weight of the transactions of each bucket which
+	// were either mined or expired.  confirmed houses the weight of those
+	// which were mined within each number of blocks up to the maximum, so
+	// confirmed[n-1][bucket] is the weight of the transactions which were
+	// mined within n blocks.
+	txTotal   []float64
+	confirmed [][]float64
+
+	tracked map[wire.ShaHash]feeEstTx
+	undo    []*feeEstBlockUndo
+}
+
+// newFeeEstimator returns a new fee estimator without any observations.
+func newFeeEstimator() *feeEstimator {
+	confirmed := make([][]float64, feeEstMaxConfirms)
+	for i := range confirmed {
+		confirmed[i] = make([]float64, len(feeEstBuckets))
+	}
+	return &feeEstimator{
+		txTotal:   make([]float64, len(feeEstBuckets)),
+		confirmed: confirmed,
+		tracked:   make(map[wire.ShaHash]feeEstTx),
+	}
+}
+
+// ObserveTransaction starts tracking the passed transaction which pays the
+// passed fee and was accepted into the memory pool when the passed height was
+// the best height.  Transactions which are already tracked, such as those
+// returned to the memory pool by a reorganization, keep their original height.
+//
+// This function is safe for concurrent access.
+func (fe *feeEstimator) ObserveTransaction(tx *colxutil.Tx, fee int64, height int32) {
+	fe.Lock()
+	defer fe.Unlock()
+
+	if _, ok := fe.tracked[*tx.Sha()]; ok {
+		return
+	}
+	size := tx.MsgTx().SerializeSize()
+	feeRate := float64(fee) * 1000 / float64(size)
+	fe.tracked[*tx.Sha()] = feeEstTx{height: height, bucket: feeEstBucket(feeRate)}
+}
+
+// RemoveTransaction stops tracking the transaction with the passed hash
+// without counting it, such as when it is removed from the memory pool as a
+// double spend.
+//
+// This function is safe for concurrent access.
+func (fe *feeEstimator) RemoveTransaction(hash *wire.ShaHash) {
+	fe.Lock()
+	delete(fe.tracked, *hash)
+	fe.Unlock()
+}
+
+// record adds the passed weight to the counts of a transaction of the passed
+// bucket which was mined in the passed number of blocks, or expired when it is
+// zero.
+//
+// This function MUST be called with the fee estimator lock held.
+func (fe *feeEstimator) record(bucket int, numBlocks int32, weight float64) {
+	fe.txTotal[bucket] += weight
+	if numBlocks == 0 {
+		return
+	}
+	for i := int(numBlocks) - 1; i < feeEstMaxConfirms; i++ {
+		fe.confirmed[i][bucket] += weight
+	}
+}
+
+// scale multiplies all of the counts by the passed factor.
+//
+// This function MUST be called with the fee estimator lock held.
+func (fe *feeEstimator) scale(factor float64) {
+	for i := range fe.txTotal {
+		fe.txTotal[i] *= factor
+	}
+	for _, counts := range fe.confirmed {
+		for i := range counts {
+			counts[i] *= factor
+		}
+	}
+}
+
+// RegisterBlock counts the tracked transactions mined by the passed block,
+// which was connected to the main chain, and the tracked transactions which
+// expired without being mined, and stops tracking them.
+//
+// This function is safe for concurrent access.
+func (fe *feeEstimator) RegisterBlock(block *colxutil.Block) {
+	fe.Lock()
+	defer fe.Unlock()
+
+	height := block.Height()
+	fe.scale(feeEstDecay)
+	undo := &feeEstBlockUndo{height: height}
+	for _, tx := range block.Transactions()[1:] {
+		hash := *tx.Sha()
+		etx, ok := fe.tracked[hash]
+		if !ok {
+			continue
+		}
+		numBlocks := height - etx.height
+		if numBlocks < 1 {
+			numBlocks = 1
+		}
+		if numBlocks > feeEstMaxConfirms {
+			numBlocks = 0
+		}
+		fe.record(etx.bucket, numBlocks, 1)
+		delete(fe.tracked, hash)
+		undo.entries = append(undo.entries, feeEstUndoEntry{
+			hash:      hash,
+			tx:        etx,
+			numBlocks: numBlocks,
+		})
+	}
+	for hash, etx := range fe.tracked {
+		if height-etx.height <= feeEstMaxConfirms {
+			continue
+		}
+		fe.record(etx.bucket, 0, 1)
+		delete(fe.tracked, hash)
+		undo.entries = append(undo.entries, feeEstUndoEntry{
+			hash: hash,
+			tx:   etx,
+		})
+	}
+
+	fe.lastHeight = height
+	fe.undo = append(fe.undo, undo)
+	if len(fe.undo) > feeEstMaxUndoBlocks {
+		fe.undo[0] = nil
+		fe.undo = fe.undo[1:]
+	}
+}
+
+// RollbackBlock reverts the registration of the passed block, which was
+// disconnected from the main chain, and returns the transactions which stopped
+// being tracked when it was registered to the tracked set.  Only the most
+// recently registered blocks can be rolled back.  See feeEstMaxUndoBlocks.
+//
+// This function is safe for concurrent access.
+func (fe *feeEstimator) RollbackBlock(block *colxutil.Block) {
+	fe.Lock()
+	defer fe.Unlock()
+
+	height := block.Height()
+	if len(fe.undo) == 0 || fe.undo[len(fe.undo)-1].height != height {
+		txmpLog.Debugf("Unable to roll back fee estimates for block %v "+
+			"(height %d)", block.Sha(), height)
+		return
+	}
+	undo := fe.undo[len(fe.undo)-1]
+	fe.undo = fe.undo[:len(fe.undo)-1]
+
+	for _, entry := range undo.entries {
+		fe.record(entry.tx.bucket, entry.numBlocks, -1)
+		fe.tracked[entry.hash] = entry.tx
+	}
+	fe.scale(1 / feeEstDecay)
+
+	// Guard against the counts going slightly negative due to rounding.
+	for i := range fe.txTotal {
+		if fe.txTotal[i] < 0 {
+			fe.txTotal[i] = 0
+		}
+	}
+	for _, counts := range fe.confirmed {
+		for i := range counts {
+			if counts[i] < 0 {
+				counts[i] = 0
+			}
+		}
+	}
+	fe.lastHeight = height - 1
+}
+
+// EstimateFee returns the lowest fee rate per kilobyte with which at least 85%
+// of the observed transactions paying the same or a higher fee rate were mined
+// within the passed number of blocks.  Transactions which are still waiting to
+// be mined after that many blocks count as not being mined in time.  Higher
+// confirmation targets never result in higher estimates.
+//
+// errInsufficientFeeData is returned when not enough transactions have been
+// observed to estimate the fee rate for the target, such as shortly after the
+// estimator was first started, or when even the transactions paying the
+// highest fee rates were not mined quickly enough.
+//
+// This function is safe for concurrent access.
+func (fe *feeEstimator) EstimateFee(numBlocks int) (colxutil.Amount, error) {
+	if numBlocks < 1 || numBlocks > feeEstMaxConfirms {
+		return 0, fmt.Errorf("confirmation target must be between 1 "+
+			"and %d", feeEstMaxConfirms)
+	}
+
+	fe.Lock()
+	defer fe.Unlock()
+
+	waiting := make([]float64, len(feeEstBuckets))
+	for _, etx := range fe.tracked {
+		if fe.lastHeight-etx.height >= int32(numBlocks) {
+			waiting[etx.bucket]++
+		}
+	}
+
+	// Consider the buckets from the highest fee rate down and stop at the
+	// first one which brings the success rate of the buckets considered so
+	// far below the threshold.  Whether there is enough data only depends
+	// on the transactions which were mined or expired, so it is the same
+	// for every target, while the success rates only grow with the target.
+	// Thus, the estimates are monotonic in the target.
+	confirmed := fe.confirmed[numBlocks-1]
+	var numConfirmed, numResolved, numWaiting float64
+	found := -1
+	for i := len(feeEstBuckets) - 1; i >= 0; i-- {
+		numConfirmed += confirmed[i]
+		numResolved += fe.txTotal[i]
+		numWaiting += waiting[i]
+		if numResolved < feeEstMinSamples {
+			continue
+		}
+		if numConfirmed/(numResolved+numWaiting) < feeEstSuccessThreshold {
+			break
+		}
+		if fe.txTotal[i] > 0 {
+			found = i
+		}
+	}
+	if found == -1 {
+		return 0, errInsufficientFeeData
+	}
+	return colxutil.Amount(feeEstBuckets[found]), nil
+}
+
+// Save serializes the observations and the tracked transactions of the fee
+// estimator to the passed writer.  The data to roll back blocks is not saved,
+// so blocks registered before the estimator was saved can't be rolled back
+// after it is restored.
+//
+// The serialized format is:
+//
+//   <version><num buckets><max confirms><last height><totals><confirmed>
+//   <num tracked><tracked 1>...<tracked n>
+//
+//   Field             Type       Size
+//   version           uint32     4 bytes
+//   num buckets       uint32     4 bytes
+//   max confirms      uint32     4 bytes
+//   last height       int32      4 bytes
+//   totals            []float64  8 bytes * num buckets
+//   confirmed         []float64  8 bytes * num buckets * max confirms
+//   num tracked       VarInt     variable
+//   tracked hash      ShaHash    32 bytes
+//   tracked height    int32      4 bytes
+//   tracked bucket    uint32     4 bytes
+//
+// This function is safe for concurrent access.
+func (fe *feeEstimator) Save(w io.Writer) error {
+	fe.Lock()
+	defer fe.Unlock()
+
+	header := []interface{}{
+		uint32(feeEstimatorFileVersion),
+		uint32(len(feeEstBuckets)),
+		uint32(feeEstMaxConfirms),
+		fe.lastHeight,
+		fe.txTotal,
+	}
+	for _, counts := range fe.confirmed {
+		header = append(header, counts)
+	}
+	for _, data := range header {
+		if err := binary.Write(w, binary.LittleEndian, data); err != nil {
+			return err
+		}
+	}
+
+	err := wire.WriteVarInt(w, 0, uint64(len(fe.tracked)))
+	if err != nil {
+		return err
+	}
+	for hash, etx := range fe.tracked {
+		if _, err := w.Write(hash[:]); err != nil {
+			return err
+		}
+		err := binary.Write(w, binary.LittleEndian, etx.height)
+		if err != nil {
+			return err
+		}
+		err = binary.Write(w, binary.LittleEndian, uint32(etx.bucket))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFeeEstimator returns a fee estimator with the state serialized by
+// Save read from the passed reader.  An error is returned when the state was
+// saved with different buckets or a different maximum confirmation target, in
+// which case the caller should start over with a new estimator.
+func restoreFeeEstimator(r io.Reader) (*feeEstimator, error) {
+	var version, numBuckets, maxConfirms uint32
+	for _, data := range []interface{}{&version, &numBuckets, &maxConfirms} {
+		if err := binary.Read(r, binary.LittleEndian, data); err != nil {
+			return nil, err
+		}
+	}
+	if version != feeEstimatorFileVersion {
+		return nil, fmt.Errorf("unsupported fee estimator file version "+
+			"%d", version)
+	}
+	if numBuckets != uint32(len(feeEstBuckets)) ||
+		maxConfirms != feeEstMaxConfirms {
+
+		return nil, fmt.Errorf("fee estimator was saved with %d "+
+			"buckets and %d confirmations rather than %d and %d",
+			numBuckets, maxConfirms, len(feeEstBuckets),
+			feeEstMaxConfirms)
+	}
+
+	fe := newFeeEstimator()
+	data := []interface{}{&fe.lastHeight, fe.txTotal}
+	for _, counts := range fe.confirmed {
+		data = append(data, counts)
+	}
+	for _, d := range data {
+		if err := binary.Read(r, binary.LittleEndian, d); err != nil {
+			return nil, err
+		}
+	}
+
+	numTracked, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, err
+	}
+	for i := uint64(0); i < numTracked; i++ {
+		var hash wire.ShaHash
+		if _, err := io.ReadFull(r, hash[:]); err != nil {
+			return nil, err
+		}
+		var height int32
+		var bucket uint32
+		err := binary.Read(r, binary.LittleEndian, &height)
+		if err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.LittleEndian, &bucket); err != nil {
+			return nil, err
+		}
+		if bucket >= numBuckets {
+			return nil, fmt.Errorf("tracked transaction %v is in "+
+				"bucket %d of %d", hash, bucket, numBuckets)
+		}
+		fe.tracked[hash] = feeEstTx{height: height, bucket: int(bucket)}
+	}
+	return fe, nil
+}
+
+// feeEstimatorFilePath returns the path of the file the state of the fee
+// estimator is saved to.
+func feeEstimatorFilePath() string {
+	return filepath.Join(cfg.DataDir, feeEstimatorFileName)
+}
+
+// saveFeeEstimatorFile saves the state of the passed fee estimator to the file
+// at the passed path.  The state is written to a temporary file which then
+// replaces any existing file so a failure part way through does not leave a
+// truncated file behind.
+func saveFeeEstimatorFile(fe *feeEstimator, path string) error {
+	tmpPath := path + ".new"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+	err = fe.Save(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
+
+// loadFeeEstimatorFile returns a fee estimator with the state saved to the file
+// at the passed path.  See restoreFeeEstimator for details.
+func loadFeeEstimatorFile(path string) (*feeEstimator, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return restoreFeeEstimator(f)
+}
@@ -0,0 +1,308 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// feeEstTestTx returns a distinct transaction for fee estimation tests along
+// with the fee it must pay to pay the passed fee rate in satoshi per kilobyte.
+func feeEstTestTx(id uint32, feeRate int64) (*colxutil.Tx, int64) {
+	msgTx := wire.NewMsgTx()
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&wire.ShaHash{0x01}, id),
+		nil))
+	msgTx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+	fee := feeRate * int64(msgTx.SerializeSize()) / 1000
+	return colxutil.NewTx(msgTx), fee + 1
+}
+
+// feeEstTestBlock returns a block at the passed height which contains a
+// coinbase followed by the passed transactions.
+func feeEstTestBlock(height int32, txns []*colxutil.Tx) *colxutil.Block {
+	coinbase := wire.NewMsgTx()
+	coinbase.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&wire.ShaHash{},
+		wire.MaxPrevOutIndex), []byte{byte(height), byte(height >> 8)}))
+	coinbase.AddTxOut(wire.NewTxOut(5000000000, []byte{0x51}))
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{})
+	msgBlock.AddTransaction(coinbase)
+	for _, tx := range txns {
+		msgBlock.AddTransaction(tx.MsgTx())
+	}
+	block := colxutil.NewBlock(msgBlock)
+	block.SetHeight(height)
+	return block
+}
+
+// feeEstSimulation feeds transactions paying a range of fee rates to a fee
+// estimator over a number of blocks.  The higher the fee rate a transaction
+// pays, the sooner it is mined, while the transactions paying the lowest fee
+// rate are never mined.
+type feeEstSimulation struct {
+	fe      *feeEstimator
+	height  int32
+	nextID  uint32
+	pending map[int32][]*colxutil.Tx
+}
+
+// feeEstSimRates are the fee rates in satoshi per kilobyte paid by the
+// transactions of the simulation along with the number of blocks it takes to
+// mine them, where zero means they are never mined.
+var feeEstSimRates = []struct {
+	feeRate   int64
+	numBlocks int32
+}{
+	{50000, 1},
+	{20000, 2},
+	{10000, 4},
+	{5000, 8},
+	{2000, 0},
+}
+
+// run connects the passed number of blocks.  Before every block, each fee rate
+// is paid by the passed number of new transactions.
+func (sim *feeEstSimulation) run(numBlocks, txnsPerRate int) []*colxutil.Block {
+	var blocks []*colxutil.Block
+	for i := 0; i < numBlocks; i++ {
+		for _, rate := range feeEstSimRates {
+			for j := 0; j < txnsPerRate; j++ {
+				tx, fee := feeEstTestTx(sim.nextID, rate.feeRate)
+				sim.nextID++
+				sim.fe.ObserveTransaction(tx, fee, sim.height)
+				if rate.numBlocks == 0 {
+					continue
+				}
+				minedAt := sim.height + rate.numBlocks
+				sim.pending[minedAt] = append(sim.pending[minedAt],
+					tx)
+			}
+		}
+
+		sim.height++
+		block := feeEstTestBlock(sim.height, sim.pending[sim.height])
+		delete(sim.pending, sim.height)
+		sim.fe.RegisterBlock(block)
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+// TestFeeEstimator ensures the fee estimates based on a simulated fee
+// distribution match the fee rates needed to be mined within each target, are
+// monotonic in the target, and are only made once enough data is available.
+func TestFeeEstimator(t *testing.T) {
+	sim := &feeEstSimulation{
+		fe:      newFeeEstimator(),
+		height:  1000,
+		pending: make(map[int32][]*colxutil.Tx),
+	}
+
+	// Nothing can be estimated without observations.
+	for _, numBlocks := range []int{1, 6, feeEstMaxConfirms} {
+		_, err := sim.fe.EstimateFee(numBlocks)
+		if err != errInsufficientFeeData {
+			t.Fatalf("EstimateFee(%d): unexpected error without data "+
+				"- got %v, want %v", numBlocks, err,
+				errInsufficientFeeData)
+		}
+	}
+
+	// Targets outside of the supported range are rejected.
+	for _, numBlocks := range []int{0, -1, feeEstMaxConfirms + 1} {
+		if _, err := sim.fe.EstimateFee(numBlocks); err == nil {
+			t.Errorf("EstimateFee(%d): unexpected success",
+				numBlocks)
+		}
+	}
+
+	sim.run(100, 5)
+
+	// wantRate returns the fee rate paid by the transactions which are mined
+	// within the passed number of blocks and pay the lowest fee rate.
+	wantRate := func(numBlocks int) int64 {
+		var feeRate int64
+		for _, rate := range feeEstSimRates {
+			if rate.numBlocks != 0 && int(rate.numBlocks) <= numBlocks {
+				feeRate = rate.feeRate
+			}
+		}
+		return feeRate
+	}
+	var prev colxutil.Amount
+	for numBlocks := 1; numBlocks <= feeEstMaxConfirms; numBlocks++ {
+		got, err := sim.fe.EstimateFee(numBlocks)
+		if err != nil {
+			t.Fatalf("EstimateFee(%d): unexpected error: %v",
+				numBlocks, err)
+		}
+
+		// The estimate is the lower bound of the bucket of the fee rate.
+		want := wantRate(numBlocks)
+		if int64(got) > want || float64(got)*feeEstBucketSpacing <= float64(want) {
+			t.Errorf("EstimateFee(%d): got %v, want the bucket of %d "+
+				"satoshi per kilobyte", numBlocks, int64(got), want)
+		}
+		if numBlocks > 1 && got > prev {
+			t.Errorf("EstimateFee(%d): estimate %d is higher than the "+
+				"estimate %d for one block less", numBlocks,
+				int64(got), int64(prev))
+		}
+		prev = got
+	}
+
+	// The transactions paying the lowest fee rate are never mined, so they
+	// expire rather than being tracked forever.
+	maxTracked := (feeEstMaxConfirms + 1) * 5 * len(feeEstSimRates)
+	if len(sim.fe.tracked) > maxTracked {
+		t.Errorf("tracking %d transactions, want at most %d",
+			len(sim.fe.tracked), maxTracked)
+	}
+}
+
+// TestFeeEstimatorRollback ensures rolling back the registration of blocks
+// disconnected by a reorganization returns the transactions they mined to the
+// tracked set and restores the previous estimates.
+func TestFeeEstimatorRollback(t *testing.T) {
+	sim := &feeEstSimulation{
+		fe:      newFeeEstimator(),
+		height:  1000,
+		pending: make(map[int32][]*colxutil.Tx),
+	}
+	sim.run(60, 5)
+
+	// snapshot returns a copy of the counts and tracked transactions.
+	type snapshot struct {
+		txTotal   []float64
+		confirmed [][]float64
+		tracked   map[wire.ShaHash]feeEstTx
+	}
+	takeSnapshot := func() *snapshot {
+		s := &snapshot{
+			txTotal: append([]float64(nil), sim.fe.txTotal...),
+			tracked: make(map[wire.ShaHash]feeEstTx),
+		}
+		for _, counts := range sim.fe.confirmed {
+			s.confirmed = append(s.confirmed,
+				append([]float64(nil), counts...))
+		}
+		for hash, etx := range sim.fe.tracked {
+			s.tracked[hash] = etx
+		}
+		return s
+	}
+	closeTo := func(a, b []float64) bool {
+		for i := range a {
+			if math.Abs(a[i]-b[i]) > 1e-9 {
+				return false
+			}
+		}
+		return true
+	}
+	before := takeSnapshot()
+	beforeHeight := sim.fe.lastHeight
+
+	blocks := sim.run(3, 5)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		sim.fe.RollbackBlock(blocks[i])
+	}
+
+	after := takeSnapshot()
+	if sim.fe.lastHeight != beforeHeight {
+		t.Errorf("unexpected last height - got %d, want %d",
+			sim.fe.lastHeight, beforeHeight)
+	}
+	if !closeTo(after.txTotal, before.txTotal) {
+		t.Errorf("totals were not restored")
+	}
+	for i := range after.confirmed {
+		if !closeTo(after.confirmed[i], before.confirmed[i]) {
+			t.Errorf("confirmed counts within %d blocks were not "+
+				"restored", i+1)
+		}
+	}
+
+	// The transactions observed before the disconnected blocks are tracked
+	// again with their original heights, while those observed after the
+	// last remaining block are still tracked as well.
+	for hash, etx := range before.tracked {
+		if got, ok := after.tracked[hash]; !ok || got != etx {
+			t.Errorf("transaction %v was not returned to the tracked "+
+				"set - got %+v, want %+v", hash, got, etx)
+		}
+	}
+	if len(after.tracked) != len(before.tracked)+3*5*len(feeEstSimRates) {
+		t.Errorf("unexpected number of tracked transactions - got %d, "+
+			"want %d", len(after.tracked),
+			len(before.tracked)+3*5*len(feeEstSimRates))
+	}
+
+	// A block which was not the last one registered is not rolled back.
+	sim.fe.RollbackBlock(blocks[0])
+	if sim.fe.lastHeight != beforeHeight {
+		t.Errorf("unexpected rollback of block at height %d",
+			blocks[0].Height())
+	}
+}
+
+// TestFeeEstimatorSaveRestore ensures the state of a fee estimator survives
+// being saved and restored and that state saved with a different layout is
+// rejected.
+func TestFeeEstimatorSaveRestore(t *testing.T) {
+	sim := &feeEstSimulation{
+		fe:      newFeeEstimator(),
+		height:  1000,
+		pending: make(map[int32][]*colxutil.Tx),
+	}
+	sim.run(50, 3)
+
+	var buf bytes.Buffer
+	if err := sim.fe.Save(&buf); err != nil {
+		t.Fatalf("Save: unexpected error: %v", err)
+	}
+	saved := buf.Bytes()
+	restored, err := restoreFeeEstimator(bytes.NewReader(saved))
+	if err != nil {
+		t.Fatalf("restoreFeeEstimator: unexpected error: %v", err)
+	}
+	if restored.lastHeight != sim.fe.lastHeight {
+		t.Errorf("unexpected last height - got %d, want %d",
+			restored.lastHeight, sim.fe.lastHeight)
+	}
+	if !reflect.DeepEqual(restored.txTotal, sim.fe.txTotal) ||
+		!reflect.DeepEqual(restored.confirmed, sim.fe.confirmed) {
+
+		t.Errorf("restored counts differ")
+	}
+	if !reflect.DeepEqual(restored.tracked, sim.fe.tracked) {
+		t.Errorf("restored tracked transactions differ")
+	}
+	for numBlocks := 1; numBlocks <= feeEstMaxConfirms; numBlocks++ {
+		want, wantErr := sim.fe.EstimateFee(numBlocks)
+		got, err := restored.EstimateFee(numBlocks)
+		if got != want || err != wantErr {
+			t.Errorf("EstimateFee(%d): got %v (%v), want %v (%v)",
+				numBlocks, got, err, want, wantErr)
+		}
+	}
+
+	// Truncated state and state with a different number of buckets are
+	// rejected.
+	if _, err := restoreFeeEstimator(bytes.NewReader(saved[:100])); err == nil {
+		t.Errorf("restoreFeeEstimator: truncated state was restored")
+	}
+	badBuckets := append([]byte(nil), saved...)
+	badBuckets[4]++
+	if _, err := restoreFeeEstimator(bytes.NewReader(badBuckets)); err == nil {
+		t.Errorf("restoreFeeEstimator: state with a different number " +
+			"of buckets was restored")
+	}
+}
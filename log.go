@@ -15,6 +15,7 @@ import (
 	"github.com/tinhnguyenhn/colxd/blockchain"
 	"github.com/tinhnguyenhn/colxd/blockchain/indexers"
 	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/netsync"
 	"github.com/tinhnguyenhn/colxd/peer"
 	"github.com/tinhnguyenhn/colxd/txscript"
 )
@@ -33,6 +34,7 @@ var (
 	backendLog = seelog.Disabled
 	adxrLog    = btclog.Disabled
 	amgrLog    = btclog.Disabled
+	auditLog   = btclog.Disabled
 	bcdbLog    = btclog.Disabled
 	bmgrLog    = btclog.Disabled
 	btcdLog    = btclog.Disabled
@@ -44,6 +46,7 @@ var (
 	rpcsLog    = btclog.Disabled
 	scrpLog    = btclog.Disabled
 	srvrLog    = btclog.Disabled
+	syncLog    = btclog.Disabled
 	txmpLog    = btclog.Disabled
 )
 
@@ -51,6 +54,7 @@ var (
 var subsystemLoggers = map[string]btclog.Logger{
 	"ADXR": adxrLog,
 	"AMGR": amgrLog,
+	"AUDT": auditLog,
 	"BCDB": bcdbLog,
 	"BMGR": bmgrLog,
 	"BTCD": btcdLog,
@@ -62,6 +66,7 @@ var subsystemLoggers = map[string]btclog.Logger{
 	"RPCS": rpcsLog,
 	"SCRP": scrpLog,
 	"SRVR": srvrLog,
+	"SYNC": syncLog,
 	"TXMP": txmpLog,
 }
 
@@ -97,6 +102,9 @@ func useLogger(subsystemID string, logger btclog.Logger) {
 		amgrLog = logger
 		addrmgr.UseLogger(logger)
 
+	case "AUDT":
+		auditLog = logger
+
 	case "BCDB":
 		bcdbLog = logger
 		database.UseLogger(logger)
@@ -135,6 +143,10 @@ func useLogger(subsystemID string, logger btclog.Logger) {
 	case "SRVR":
 		srvrLog = logger
 
+	case "SYNC":
+		syncLog = logger
+		netsync.UseLogger(logger)
+
 	case "TXMP":
 		txmpLog = logger
 	}
@@ -6,6 +6,7 @@
 package main
 
 import (
+	"container/heap"
 	"container/list"
 	"crypto/rand"
 	"fmt"
@@ -27,8 +28,54 @@ const (
 	// mempoolHeight is the height used for the "block" height field of the
 	// contextual transaction information provided in a transaction view.
 	mempoolHeight = 0x7fffffff
+
+	// unbroadcastRetryInterval is the time to wait before re-announcing a
+	// locally submitted transaction which no peer has requested yet.  The
+	// interval doubles after every re-announcement up to
+	// maxUnbroadcastRetryInterval.
+	unbroadcastRetryInterval = 5 * time.Minute
+
+	// maxUnbroadcastRetryInterval is the maximum time to wait between
+	// re-announcements of a locally submitted transaction.
+	maxUnbroadcastRetryInterval = 2 * time.Hour
+
+	// orphanExpireScanInterval is the time between scans of the orphan
+	// pool for expired orphans.  Orphans are therefore removed up to this
+	// long after they expire.
+	orphanExpireScanInterval = time.Minute
+
+	// rollingFeeHalfLife is the time it takes the dynamic minimum relay
+	// fee, which is raised when transactions are evicted to keep the pool
+	// within its size limit, to decay to half its value.
+	rollingFeeHalfLife = 12 * time.Hour
+
+	// maxFeeDeltas is the maximum number of fee deltas set via the
+	// prioritisetransaction RPC which are kept.  Once it is reached, new
+	// fee deltas are only accepted for transactions in the pool.
+	maxFeeDeltas = 10000
+
+	// feeDeltaTTL is the amount of time a fee delta is kept while its
+	// transaction is not in the pool, such as before the transaction is
+	// first seen or after it was evicted.
+	feeDeltaTTL = 24 * time.Hour
 )
 
+// feeDelta houses a fee delta set for a transaction via the
+// prioritisetransaction RPC along with the time it expires.  The expiration is
+// zero while the transaction is in the pool.
+type feeDelta struct {
+	delta      int64
+	expiration time.Time
+}
+
+// orphanTx houses an orphan transaction along with the time it expires and is
+// removed from the orphan pool unless all of its parents were found by then.
+// A zero expiration time means the orphan never expires.
+type orphanTx struct {
+	tx         *colxutil.Tx
+	expiration time.Time
+}
+
 // mempoolTxDesc is a descriptor containing a transaction in the mempool along
 // with additional metadata.
 type mempoolTxDesc struct {
@@ -37,6 +84,60 @@ type mempoolTxDesc struct {
 	// StartingPriority is the priority of the transaction when it was added
 	// to the pool.
 	StartingPriority float64
+
+	// NoRelay indicates the transaction was only accepted because the relay
+	// policy was relaxed for a locally submitted transaction, or depends on
+	// such a transaction, so it must not be announced to peers which
+	// enforce the full policy.
+	NoRelay bool
+
+	// Ancestry houses the totals of the unconfirmed transactions in the
+	// pool the transaction depends on and that depend on it.
+	Ancestry txAncestry
+
+	// evictIndex is the index of the descriptor in the eviction queue of
+	// the pool.
+	evictIndex int
+}
+
+// unbroadcastTx describes a locally submitted transaction in the pool which
+// has not been requested by any peer yet along with when it is due to be
+// announced again.
+type unbroadcastTx struct {
+	tx            *colxutil.Tx
+	nextAnnounce  time.Time
+	retryInterval time.Duration
+}
+
+// txAncestry houses the number of transactions along with their total
+// serialized size and fees for the in-pool ancestors and descendants of a
+// transaction in the memory pool.  Both totals include the transaction itself.
+type txAncestry struct {
+	AncestorCount   int
+	AncestorSize    int64
+	AncestorFees    int64
+	DescendantCount int
+	DescendantSize  int64
+	DescendantFees  int64
+}
+
+// txAcceptOptions houses per-call relaxations of the relay policy for
+// transactions submitted locally by the node operator, such as via the
+// sendrawtransaction RPC.  Consensus rules are always enforced regardless of
+// these options.  Transactions received from the network are processed with a
+// nil options pointer, which applies the full relay policy.
+type txAcceptOptions struct {
+	// AcceptNonStd allows transactions which are not standard, such as
+	// those with non-standard script classes or dust outputs.
+	AcceptNonStd bool
+
+	// AllowHighFees disables the check that rejects transactions paying
+	// an absurdly high fee.
+	AllowHighFees bool
+
+	// SkipFeeLimits disables the minimum fee, priority, and free
+	// transaction rate limiting checks.
+	SkipFeeLimits bool
 }
 
 // mempoolConfig is a descriptor containing the memory pool configuration.
@@ -63,6 +164,25 @@ type mempoolConfig struct {
 	// indexing the unconfirmed transactions in the memory pool.
 	// This can be nil if the address index is not enabled.
 	AddrIndex *indexers.AddrIndex
+
+	// FeeEstimator defines the optional fee estimator to notify about the
+	// transactions added to and removed from the memory pool.  This can be
+	// nil if fees are not estimated.
+	FeeEstimator *feeEstimator
+
+	// OnOrphansResolved defines the optional function to call with the
+	// orphan transactions which ProcessOrphans accepted into the memory
+	// pool because their parents became available.  It is called without
+	// the mempool lock held, so it may access the pool, such as to relay
+	// the transactions.  This can be nil.
+	OnOrphansResolved func(accepted []*colxutil.Tx)
+
+	// OnTxsEvicted defines the optional function to call with the
+	// transactions which were evicted from the memory pool to keep it
+	// within its size limit, including the transactions which depended on
+	// them.  It is called without the mempool lock held, so it may access
+	// the pool.  This can be nil.
+	OnTxsEvicted func(evicted []*colxutil.Tx)
 }
 
 // mempoolPolicy houses the policy (configuration parameters) which is used to
@@ -85,6 +205,15 @@ type mempoolPolicy struct {
 	// of big orphans.
 	MaxOrphanTxSize int
 
+	// MaxOrphanBytes is the maximum total serialized size in bytes of the
+	// transactions in the orphan pool.  The largest orphans are evicted
+	// first to make room.  Zero disables the limit.
+	MaxOrphanBytes int
+
+	// OrphanTTL is the amount of time orphan transactions are kept in the
+	// orphan pool before they expire.  Zero disables expiration.
+	OrphanTTL time.Duration
+
 	// MaxSigOpsPerTx is the maximum number of signature operations
 	// in a single transaction we will relay or mine.  It is a fraction
 	// of the max signature operations for a block.
@@ -93,6 +222,40 @@ type mempoolPolicy struct {
 	// MinRelayTxFee defines the minimum transaction fee in BTC/kB to be
 	// considered a non-zero fee.
 	MinRelayTxFee colxutil.Amount
+
+	// MaxTxVersion is the highest transaction version which is accepted
+	// into the pool.  Transactions with higher versions are valid according
+	// to the consensus rules, so they are still accepted in blocks.  Zero
+	// disables the limit.
+	MaxTxVersion int32
+
+	// MaxAncestorCount is the maximum number of unconfirmed transactions
+	// in the pool, including itself, a new transaction may depend on.
+	// Zero disables the limit.
+	MaxAncestorCount int
+
+	// MaxAncestorSize is the maximum total serialized size in bytes of a
+	// new transaction and the unconfirmed transactions in the pool it
+	// depends on.  Zero disables the limit.
+	MaxAncestorSize int64
+
+	// MaxDescendantCount is the maximum number of unconfirmed
+	// transactions in the pool, including itself, which may depend on a
+	// transaction once a new transaction is added.  Zero disables the
+	// limit.
+	MaxDescendantCount int
+
+	// MaxDescendantSize is the maximum total serialized size in bytes of
+	// a transaction in the pool and the unconfirmed transactions which
+	// depend on it once a new transaction is added.  Zero disables the
+	// limit.
+	MaxDescendantSize int64
+
+	// MaxTxPoolSizeBytes is the maximum total serialized size in bytes of
+	// the transactions in the pool.  The transactions with the lowest fee
+	// rate, along with the transactions which depend on them, are evicted
+	// to make room once it is exceeded.  Zero disables the limit.
+	MaxTxPoolSizeBytes int64
 }
 
 // txMemPool is used as a source of transactions that need to be mined into
@@ -100,16 +263,36 @@ type mempoolPolicy struct {
 // multiple peers.
 type txMemPool struct {
 	// The following variables must only be used atomically.
-	lastUpdated int64 // last time pool was updated
+	lastUpdated    int64 // last time pool was updated
+	acceptCounters txAcceptCounters
 
 	sync.RWMutex
 	cfg           mempoolConfig
 	pool          map[wire.ShaHash]*mempoolTxDesc
-	orphans       map[wire.ShaHash]*colxutil.Tx
+	orphans       map[wire.ShaHash]*orphanTx
 	orphansByPrev map[wire.ShaHash]map[wire.ShaHash]*colxutil.Tx
+	orphanBytes   int // total serialized size of the orphans
 	outpoints     map[wire.OutPoint]*colxutil.Tx
+	unbroadcast   map[wire.ShaHash]*unbroadcastTx
+	feeDeltas     map[wire.ShaHash]*feeDelta
+	poolBytes     int64   // total serialized size of the pool transactions
+	totalFees     int64   // total fees of the pool transactions
 	pennyTotal    float64 // exponentially decaying total for penny spends.
 	lastPennyUnix int64   // unix time of last ``penny spend''
+
+	// rollingMinFeeRate is the dynamic minimum fee rate in Satoshi/1000
+	// bytes which transactions must pay once the pool had to evict
+	// transactions due to its size limit.  It decays over time as of
+	// lastRollingFeeUpdate.
+	rollingMinFeeRate    float64
+	lastRollingFeeUpdate time.Time
+
+	// evictQueue orders the transactions in the pool by the fee rate of
+	// their package of descendants so the next one to evict is found
+	// without scanning the pool.  evicted houses the transactions which
+	// were evicted but not passed to the OnTxsEvicted callback yet.
+	evictQueue txEvictQueue
+	evicted    []*colxutil.Tx
 }
 
 // Ensure the txMemPool type implements the mining.TxSource interface.
@@ -121,10 +304,11 @@ var _ mining.TxSource = (*txMemPool)(nil)
 // This function MUST be called with the mempool lock held (for writes).
 func (mp *txMemPool) removeOrphan(txHash *wire.ShaHash) {
 	// Nothing to do if passed tx is not an orphan.
-	tx, exists := mp.orphans[*txHash]
+	otx, exists := mp.orphans[*txHash]
 	if !exists {
 		return
 	}
+	tx := otx.tx
 
 	// Remove the reference from the previous orphan index.
 	for _, txIn := range tx.MsgTx().TxIn {
@@ -142,6 +326,7 @@ func (mp *txMemPool) removeOrphan(txHash *wire.ShaHash) {
 
 	// Remove the transaction from the orphan pool.
 	delete(mp.orphans, *txHash)
+	mp.orphanBytes -= tx.MsgTx().SerializeSize()
 }
 
 // RemoveOrphan removes the passed orphan transaction from the orphan pool and
@@ -193,15 +378,88 @@ func (mp *txMemPool) limitNumOrphans() error {
 	return nil
 }
 
+// limitOrphanBytes limits the total size of the orphan transactions by
+// evicting the largest orphans until adding a new one of the passed serialized
+// size would no longer cause it to overflow the max allowed.  Evicting the
+// largest ones first frees the space with the fewest evictions.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *txMemPool) limitOrphanBytes(size int) {
+	maxBytes := mp.cfg.Policy.MaxOrphanBytes
+	if maxBytes <= 0 {
+		return
+	}
+	for len(mp.orphans) > 0 && mp.orphanBytes+size > maxBytes {
+		var largest *wire.ShaHash
+		largestSize := -1
+		for txHash, otx := range mp.orphans {
+			txSize := otx.tx.MsgTx().SerializeSize()
+			if txSize > largestSize {
+				hash := txHash
+				largest, largestSize = &hash, txSize
+			}
+		}
+
+		txmpLog.Debugf("Evicting orphan transaction %v to limit the "+
+			"size of the orphan pool", largest)
+		mp.removeOrphan(largest)
+	}
+}
+
+// expireOrphans removes the orphans which expired as of the passed time from
+// the orphan pool and returns the number of removed orphans.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *txMemPool) expireOrphans(now time.Time) int {
+	var expired int
+	for txHash, otx := range mp.orphans {
+		if otx.expiration.IsZero() || now.Before(otx.expiration) {
+			continue
+		}
+		hash := txHash
+		mp.removeOrphan(&hash)
+		expired++
+	}
+	if expired > 0 {
+		txmpLog.Debugf("Expired %d orphan transactions (remaining: %d)",
+			expired, len(mp.orphans))
+	}
+	return expired
+}
+
+// ExpireOrphans removes the orphans which expired as of the passed time from
+// the orphan pool and returns the number of removed orphans.  It is called
+// periodically so orphans whose parents never show up do not linger until
+// they are randomly evicted.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) ExpireOrphans(now time.Time) int {
+	mp.Lock()
+	defer mp.Unlock()
+
+	return mp.expireOrphans(now)
+}
+
 // addOrphan adds an orphan transaction to the orphan pool.
 //
 // This function MUST be called with the mempool lock held (for writes).
 func (mp *txMemPool) addOrphan(tx *colxutil.Tx) {
-	// Limit the number orphan transactions to prevent memory exhaustion.  A
-	// random orphan is evicted to make room if needed.
+	// Limit the number orphan transactions and their total size to prevent
+	// memory exhaustion.  A random orphan is evicted to make room for
+	// another one and the largest orphans are evicted to make room for its
+	// size if needed.
 	mp.limitNumOrphans()
-
-	mp.orphans[*tx.Sha()] = tx
+	size := tx.MsgTx().SerializeSize()
+	mp.limitOrphanBytes(size)
+
+	// Orphans never expire when expiration is disabled, which is denoted
+	// by a zero expiration time.
+	var expiration time.Time
+	if mp.cfg.Policy.OrphanTTL > 0 {
+		expiration = time.Now().Add(mp.cfg.Policy.OrphanTTL)
+	}
+	mp.orphans[*tx.Sha()] = &orphanTx{tx: tx, expiration: expiration}
+	mp.orphanBytes += size
 	for _, txIn := range tx.MsgTx().TxIn {
 		originTxHash := txIn.PreviousOutPoint.Hash
 		if _, exists := mp.orphansByPrev[originTxHash]; !exists {
@@ -225,10 +483,11 @@ func (mp *txMemPool) maybeAddOrphan(tx *colxutil.Tx) error {
 	// it will ultimtely be rebroadcast after the parent transactions
 	// have been mined or otherwise received.
 	//
-	// Note that the number of orphan transactions in the orphan pool is
-	// also limited, so this equates to a maximum memory used of
-	// mp.cfg.Policy.MaxOrphanTxSize * mp.cfg.Policy.MaxOrphanTxs (which is ~5MB
-	// using the default values at the time this comment was written).
+	// Note that the number of orphan transactions in the orphan pool and
+	// their total size are also limited, so this equates to a maximum
+	// memory used of the smaller of mp.cfg.Policy.MaxOrphanBytes and
+	// mp.cfg.Policy.MaxOrphanTxSize * mp.cfg.Policy.MaxOrphanTxs (which is
+	// ~5MB using the default values at the time this comment was written).
 	serializedLen := tx.MsgTx().SerializeSize()
 	if serializedLen > mp.cfg.Policy.MaxOrphanTxSize {
 		str := fmt.Sprintf("orphan transaction size of %d bytes is "+
@@ -329,17 +588,47 @@ func (mp *txMemPool) removeTransaction(tx *colxutil.Tx, removeRedeemers bool) {
 
 	// Remove the transaction if needed.
 	if txDesc, exists := mp.pool[*txHash]; exists {
+		// The ancestry of the in-pool ancestors and any remaining
+		// descendants of the transaction no longer includes it once it
+		// is removed, such as when it was mined.
+		affected := mp.txAncestors(tx)
+		for hash, desc := range mp.txDescendants(tx) {
+			affected[hash] = desc
+		}
+
 		// Remove unconfirmed address index entries associated with the
 		// transaction if enabled.
 		if mp.cfg.AddrIndex != nil {
 			mp.cfg.AddrIndex.RemoveUnconfirmedTx(txHash)
 		}
 
+		// Stop tracking the transaction for fee estimation.  This has
+		// no effect on transactions which were removed because they
+		// were mined since the estimator has already counted them.
+		if mp.cfg.FeeEstimator != nil {
+			mp.cfg.FeeEstimator.RemoveTransaction(txHash)
+		}
+
 		// Mark the referenced outpoints as unspent by the pool.
 		for _, txIn := range txDesc.Tx.MsgTx().TxIn {
 			delete(mp.outpoints, txIn.PreviousOutPoint)
 		}
+		mp.evictQueue.remove(txDesc)
 		delete(mp.pool, *txHash)
+		mp.poolBytes -= int64(tx.MsgTx().SerializeSize())
+		mp.totalFees -= txDesc.Fee
+
+		// There is no longer any need to announce the transaction once
+		// it is no longer in the pool, such as when it was mined.
+		delete(mp.unbroadcast, *txHash)
+		mp.updateAncestry(affected)
+
+		// Keep the fee delta of the transaction for a while in case it
+		// is added back to the pool, such as after it was evicted or
+		// its block was disconnected.
+		if fd, ok := mp.feeDeltas[*txHash]; ok {
+			fd.expiration = time.Now().Add(feeDeltaTTL)
+		}
 		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 	}
 }
@@ -384,10 +673,10 @@ func (mp *txMemPool) RemoveDoubleSpends(tx *colxutil.Tx) {
 // helper for maybeAcceptTransaction.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *txMemPool) addTransaction(utxoView *blockchain.UtxoViewpoint, tx *colxutil.Tx, height int32, fee int64) {
+func (mp *txMemPool) addTransaction(utxoView *blockchain.UtxoViewpoint, tx *colxutil.Tx, height int32, fee int64, noRelay bool) {
 	// Add the transaction to the pool and mark the referenced outpoints
 	// as spent by the pool.
-	mp.pool[*tx.Sha()] = &mempoolTxDesc{
+	txDesc := &mempoolTxDesc{
 		TxDesc: mining.TxDesc{
 			Tx:     tx,
 			Added:  time.Now(),
@@ -395,10 +684,50 @@ func (mp *txMemPool) addTransaction(utxoView *blockchain.UtxoViewpoint, tx *colx
 			Fee:    fee,
 		},
 		StartingPriority: calcPriority(tx.MsgTx(), utxoView, height),
+		NoRelay:          noRelay,
 	}
+
+	// Apply the fee delta set for the transaction before it arrived, if
+	// any, and keep it for as long as the transaction is in the pool.
+	if fd, ok := mp.feeDeltas[*tx.Sha()]; ok {
+		if fd.expiration.IsZero() || txDesc.Added.Before(fd.expiration) {
+			txDesc.FeeDelta = fd.delta
+			fd.expiration = time.Time{}
+		} else {
+			delete(mp.feeDeltas, *tx.Sha())
+		}
+	}
+	mp.pool[*tx.Sha()] = txDesc
+	heap.Push(&mp.evictQueue, txDesc)
 	for _, txIn := range tx.MsgTx().TxIn {
 		mp.outpoints[txIn.PreviousOutPoint] = tx
 	}
+	mp.poolBytes += int64(tx.MsgTx().SerializeSize())
+	mp.totalFees += fee
+
+	// Update the ancestry of the transaction along with its in-pool
+	// ancestors and descendants.  The pool only contains descendants of a
+	// transaction when it is added back from a disconnected block.
+	//
+	// Transactions which depend on a transaction that must not be
+	// announced to peers must not be announced either since the peers
+	// don't have all of their inputs, so the flag is inherited from the
+	// ancestors and passed on to the descendants.
+	affected := mp.txAncestors(tx)
+	for _, desc := range affected {
+		if desc.NoRelay {
+			txDesc.NoRelay = true
+			break
+		}
+	}
+	for hash, desc := range mp.txDescendants(tx) {
+		if txDesc.NoRelay {
+			desc.NoRelay = true
+		}
+		affected[hash] = desc
+	}
+	affected[*tx.Sha()] = txDesc
+	mp.updateAncestry(affected)
 	atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
 
 	// Add unconfirmed address index entries associated with the transaction
@@ -406,6 +735,345 @@ func (mp *txMemPool) addTransaction(utxoView *blockchain.UtxoViewpoint, tx *colx
 	if mp.cfg.AddrIndex != nil {
 		mp.cfg.AddrIndex.AddUnconfirmedTx(tx, utxoView)
 	}
+
+	// Track the transaction for fee estimation if enabled.
+	if mp.cfg.FeeEstimator != nil {
+		mp.cfg.FeeEstimator.ObserveTransaction(tx, fee, height)
+	}
+}
+
+// dynamicMinFeeRate returns the current dynamic minimum fee rate in
+// Satoshi/1000 bytes after decaying it to the passed time.  The rate is reset
+// to zero once it decays below half of the static minimum relay fee.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *txMemPool) dynamicMinFeeRate(now time.Time) float64 {
+	if mp.rollingMinFeeRate == 0 {
+		return 0
+	}
+
+	elapsed := now.Sub(mp.lastRollingFeeUpdate)
+	if elapsed > 0 {
+		halvings := float64(elapsed) / float64(rollingFeeHalfLife)
+		mp.rollingMinFeeRate /= math.Pow(2, halvings)
+		mp.lastRollingFeeUpdate = now
+	}
+	if mp.rollingMinFeeRate < float64(mp.cfg.Policy.MinRelayTxFee)/2 {
+		mp.rollingMinFeeRate = 0
+	}
+	return mp.rollingMinFeeRate
+}
+
+// MinRelayTxFee returns the minimum fee in Satoshi/1000 bytes transactions
+// currently need to pay to be accepted into the pool.  It is the larger of the
+// static minimum relay fee of the policy and the dynamic minimum which is
+// raised when transactions are evicted because the pool is full.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) MinRelayTxFee() colxutil.Amount {
+	mp.Lock()
+	defer mp.Unlock()
+
+	minFee := mp.cfg.Policy.MinRelayTxFee
+	if rate := colxutil.Amount(mp.dynamicMinFeeRate(time.Now())); rate > minFee {
+		minFee = rate
+	}
+	return minFee
+}
+
+// txEvictQueue implements a priority queue of the transactions in the memory
+// pool ordered by the fee rate of their package of descendants, lowest first,
+// so the transaction to evict next when the pool is full is at the front.
+type txEvictQueue []*mempoolTxDesc
+
+// Len returns the number of transactions in the queue.  It is part of the
+// heap.Interface implementation.
+func (q txEvictQueue) Len() int {
+	return len(q)
+}
+
+// Less returns whether the transaction at index i should be evicted before the
+// one at index j.  The fee rates are compared by cross multiplication to avoid
+// rounding.  Ties are broken in favor of evicting the larger package.  It is
+// part of the heap.Interface implementation.
+func (q txEvictQueue) Less(i, j int) bool {
+	a, b := &q[i].Ancestry, &q[j].Ancestry
+	lhs := a.DescendantFees * b.DescendantSize
+	rhs := b.DescendantFees * a.DescendantSize
+	if lhs == rhs {
+		return a.DescendantSize > b.DescendantSize
+	}
+	return lhs < rhs
+}
+
+// Swap swaps the transactions at the passed indices in the queue.  It is part
+// of the heap.Interface implementation.
+func (q txEvictQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].evictIndex = i
+	q[j].evictIndex = j
+}
+
+// Push pushes the passed transaction descriptor onto the queue.  It is part of
+// the heap.Interface implementation.
+func (q *txEvictQueue) Push(x interface{}) {
+	desc := x.(*mempoolTxDesc)
+	desc.evictIndex = len(*q)
+	*q = append(*q, desc)
+}
+
+// Pop removes the last transaction descriptor from the queue and returns it.
+// It is part of the heap.Interface implementation.
+func (q *txEvictQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	desc := old[n-1]
+	desc.evictIndex = -1
+	old[n-1] = nil
+	*q = old[:n-1]
+	return desc
+}
+
+// contains returns whether the passed transaction descriptor is in the queue.
+func (q txEvictQueue) contains(desc *mempoolTxDesc) bool {
+	i := desc.evictIndex
+	return i >= 0 && i < len(q) && q[i] == desc
+}
+
+// fix restores the order of the queue after the ancestry of the passed
+// transaction descriptor changed.  Descriptors which are not in the queue are
+// ignored.
+func (q *txEvictQueue) fix(desc *mempoolTxDesc) {
+	if q.contains(desc) {
+		heap.Fix(q, desc.evictIndex)
+	}
+}
+
+// remove removes the passed transaction descriptor from the queue.
+// Descriptors which are not in the queue are ignored.
+func (q *txEvictQueue) remove(desc *mempoolTxDesc) {
+	if q.contains(desc) {
+		heap.Remove(q, desc.evictIndex)
+	}
+}
+
+// limitPoolSize evicts transactions from the pool until the total size of the
+// transactions in it no longer exceeds the maximum allowed by the policy.  The
+// transaction with the lowest fee rate when taken together with the
+// transactions which depend on it is evicted first along with those
+// dependents, so that a low fee parent can't be kept in the pool by a high fee
+// child.  The dynamic minimum fee rate is raised to the fee rate of every
+// evicted package so that transactions which would be evicted right away are
+// rejected until it decays.  It returns the number of evicted transactions,
+// which are passed to the OnTxsEvicted callback once the lock is released.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *txMemPool) limitPoolSize(now time.Time) int {
+	maxBytes := mp.cfg.Policy.MaxTxPoolSizeBytes
+	if maxBytes <= 0 {
+		return 0
+	}
+
+	var numEvicted int
+	for mp.poolBytes > maxBytes && len(mp.evictQueue) > 0 {
+		// The transaction whose package of descendants has the lowest
+		// fee rate is at the front of the eviction queue.
+		victim := mp.evictQueue[0]
+		ancestry := victim.Ancestry
+		feeRate := float64(ancestry.DescendantFees) * 1000 /
+			float64(ancestry.DescendantSize)
+		if feeRate > mp.dynamicMinFeeRate(now) {
+			mp.rollingMinFeeRate = feeRate
+			mp.lastRollingFeeUpdate = now
+		}
+
+		txmpLog.Debugf("Evicting transaction %v and %d dependent "+
+			"transactions with a fee rate of %.0f satoshi/kB since "+
+			"the pool exceeds %d bytes", victim.Tx.Sha(),
+			ancestry.DescendantCount-1, feeRate, maxBytes)
+
+		// Keep the evicted package for the OnTxsEvicted callback which
+		// is invoked once the mempool lock is released.
+		mp.evicted = append(mp.evicted, victim.Tx)
+		for _, desc := range mp.txDescendants(victim.Tx) {
+			mp.evicted = append(mp.evicted, desc.Tx)
+		}
+
+		// Removing the transaction along with its redeemers updates
+		// the ancestry of the remaining transactions and notifies the
+		// address index and fee estimator about the removals.
+		mp.removeTransaction(victim.Tx, true)
+		numEvicted += ancestry.DescendantCount
+	}
+
+	return numEvicted
+}
+
+// notifyEvicted passes the transactions which were evicted from the pool since
+// the last call to the OnTxsEvicted callback of the pool configuration, if
+// any.
+//
+// This function MUST NOT be called with the mempool lock held.
+func (mp *txMemPool) notifyEvicted() {
+	mp.Lock()
+	evicted := mp.evicted
+	mp.evicted = nil
+	mp.Unlock()
+
+	if len(evicted) > 0 && mp.cfg.OnTxsEvicted != nil {
+		mp.cfg.OnTxsEvicted(evicted)
+	}
+}
+
+// txAncestors returns the descriptors of all transactions in the pool which
+// the passed transaction depends on, either directly or through other
+// transactions in the pool, keyed by their hashes.  The passed transaction
+// does not need to be in the pool.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *txMemPool) txAncestors(tx *colxutil.Tx) map[wire.ShaHash]*mempoolTxDesc {
+	ancestors := make(map[wire.ShaHash]*mempoolTxDesc)
+	toVisit := []*colxutil.Tx{tx}
+	for len(toVisit) > 0 {
+		tx := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		for _, txIn := range tx.MsgTx().TxIn {
+			parentHash := txIn.PreviousOutPoint.Hash
+			if _, ok := ancestors[parentHash]; ok {
+				continue
+			}
+			if parent, ok := mp.pool[parentHash]; ok {
+				ancestors[parentHash] = parent
+				toVisit = append(toVisit, parent.Tx)
+			}
+		}
+	}
+	return ancestors
+}
+
+// txDescendants returns the descriptors of all transactions in the pool which
+// depend on the passed transaction, either directly or through other
+// transactions in the pool, keyed by their hashes.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *txMemPool) txDescendants(tx *colxutil.Tx) map[wire.ShaHash]*mempoolTxDesc {
+	descendants := make(map[wire.ShaHash]*mempoolTxDesc)
+	toVisit := []*colxutil.Tx{tx}
+	for len(toVisit) > 0 {
+		tx := toVisit[len(toVisit)-1]
+		toVisit = toVisit[:len(toVisit)-1]
+		outpoint := wire.OutPoint{Hash: *tx.Sha()}
+		for i := range tx.MsgTx().TxOut {
+			outpoint.Index = uint32(i)
+			child, ok := mp.outpoints[outpoint]
+			if !ok {
+				continue
+			}
+			childHash := *child.Sha()
+			if _, ok := descendants[childHash]; ok {
+				continue
+			}
+			if childDesc, ok := mp.pool[childHash]; ok {
+				descendants[childHash] = childDesc
+				toVisit = append(toVisit, child)
+			}
+		}
+	}
+	return descendants
+}
+
+// updateAncestry recalculates the ancestry of the passed transaction
+// descriptors from the transactions currently in the pool.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *txMemPool) updateAncestry(descs map[wire.ShaHash]*mempoolTxDesc) {
+	for _, txDesc := range descs {
+		size := int64(txDesc.Tx.MsgTx().SerializeSize())
+		ancestry := txAncestry{
+			AncestorCount:   1,
+			AncestorSize:    size,
+			AncestorFees:    txDesc.Fee,
+			DescendantCount: 1,
+			DescendantSize:  size,
+			DescendantFees:  txDesc.Fee,
+		}
+		for _, ancestor := range mp.txAncestors(txDesc.Tx) {
+			ancestry.AncestorCount++
+			ancestry.AncestorSize += int64(
+				ancestor.Tx.MsgTx().SerializeSize())
+			ancestry.AncestorFees += ancestor.Fee
+		}
+		for _, descendant := range mp.txDescendants(txDesc.Tx) {
+			ancestry.DescendantCount++
+			ancestry.DescendantSize += int64(
+				descendant.Tx.MsgTx().SerializeSize())
+			ancestry.DescendantFees += descendant.Fee
+		}
+		txDesc.Ancestry = ancestry
+		mp.evictQueue.fix(txDesc)
+	}
+}
+
+// checkAncestryLimits ensures adding the passed transaction to the pool does
+// not result in a chain of unconfirmed transactions which exceeds the ancestor
+// and descendant limits of the policy.  This prevents long chains of
+// unconfirmed transactions from being used to make the pool do excessive work
+// whenever a transaction in the chain is added, mined, or removed.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *txMemPool) checkAncestryLimits(tx *colxutil.Tx) error {
+	policy := &mp.cfg.Policy
+	txHash := tx.Sha()
+	size := int64(tx.MsgTx().SerializeSize())
+	ancestors := mp.txAncestors(tx)
+
+	ancestorCount := len(ancestors) + 1
+	if policy.MaxAncestorCount > 0 &&
+		ancestorCount > policy.MaxAncestorCount {
+
+		str := fmt.Sprintf("transaction %v has too many unconfirmed "+
+			"ancestors: %d > %d", txHash, ancestorCount,
+			policy.MaxAncestorCount)
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+	ancestorSize := size
+	for _, ancestor := range ancestors {
+		ancestorSize += int64(ancestor.Tx.MsgTx().SerializeSize())
+	}
+	if policy.MaxAncestorSize > 0 &&
+		ancestorSize > policy.MaxAncestorSize {
+
+		str := fmt.Sprintf("transaction %v has unconfirmed ancestors "+
+			"which are too large: %d > %d bytes", txHash,
+			ancestorSize, policy.MaxAncestorSize)
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+
+	// The transaction becomes a descendant of every one of its ancestors.
+	for ancestorHash, ancestor := range ancestors {
+		descendantCount := ancestor.Ancestry.DescendantCount + 1
+		if policy.MaxDescendantCount > 0 &&
+			descendantCount > policy.MaxDescendantCount {
+
+			str := fmt.Sprintf("transaction %v would give unconfirmed "+
+				"ancestor %v too many descendants: %d > %d",
+				txHash, ancestorHash, descendantCount,
+				policy.MaxDescendantCount)
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+		descendantSize := ancestor.Ancestry.DescendantSize + size
+		if policy.MaxDescendantSize > 0 &&
+			descendantSize > policy.MaxDescendantSize {
+
+			str := fmt.Sprintf("transaction %v would give unconfirmed "+
+				"ancestor %v descendants which are too large: "+
+				"%d > %d bytes", txHash, ancestorHash,
+				descendantSize, policy.MaxDescendantSize)
+			return txRuleError(wire.RejectNonstandard, str)
+		}
+	}
+
+	return nil
 }
 
 // checkPoolDoubleSpend checks whether or not the passed transaction is
@@ -469,14 +1137,60 @@ func (mp *txMemPool) FetchTransaction(txHash *wire.ShaHash) (*colxutil.Tx, error
 	return nil, fmt.Errorf("transaction is not in the pool")
 }
 
+// EntryAncestry returns the number of transactions along with their total
+// serialized size and fees for the in-pool ancestors and descendants of the
+// requested transaction, both of which include the transaction itself.  This
+// only considers the main transaction pool and does not include orphans.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) EntryAncestry(txHash *wire.ShaHash) (*txAncestry, error) {
+	// Protect concurrent access.
+	mp.RLock()
+	defer mp.RUnlock()
+
+	if txDesc, exists := mp.pool[*txHash]; exists {
+		ancestry := txDesc.Ancestry
+		return &ancestry, nil
+	}
+
+	return nil, fmt.Errorf("transaction is not in the pool")
+}
+
 // maybeAcceptTransaction is the internal function which implements the public
 // MaybeAcceptTransaction.  See the comment for MaybeAcceptTransaction for
 // more details.
 //
 // This function MUST be called with the mempool lock held (for writes).
-func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bool) ([]*wire.ShaHash, error) {
+func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bool, opts *txAcceptOptions) ([]*wire.ShaHash, error) {
+	track := mp.acceptCounters.track()
+	missingParents, err := mp.acceptTransaction(tx, isNew, rateLimit, opts,
+		&track)
+	track.finish(missingParents, err)
+	return missingParents, err
+}
+
+// acceptTransaction performs the checks for maybeAcceptTransaction while
+// recording the stage of the acceptance pipeline the transaction is in with
+// the passed tracker so any rejection is attributed to the stage responsible
+// for it.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *txMemPool) acceptTransaction(tx *colxutil.Tx, isNew, rateLimit bool, opts *txAcceptOptions, track *txStageTracker) ([]*wire.ShaHash, error) {
 	txHash := tx.Sha()
 
+	// Transactions received from the network are subject to the full relay
+	// policy, while locally submitted transactions are additionally
+	// protected against paying an absurdly high fee unless requested.
+	isLocal := opts != nil
+	if !isLocal {
+		opts = &txAcceptOptions{}
+	}
+
+	// Keep track of whether any relay policy check was bypassed due to the
+	// options so the transaction is not announced to peers which would
+	// reject it.
+	var noRelay bool
+
 	// Don't accept the transaction if it already exists in the pool.  This
 	// applies to orphan transactions as well.  This check is intended to
 	// be a quick check to weed out duplicates.
@@ -507,6 +1221,7 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bo
 	// value for now.  This is an artifact of older bitcoind clients which
 	// treated this field as an int32 and would treat anything larger
 	// incorrectly (as negative).
+	track.enter(txStageFinality)
 	if tx.MsgTx().LockTime > math.MaxInt32 {
 		str := fmt.Sprintf("transaction %v has a lock time after "+
 			"2038 which is not accepted yet", txHash)
@@ -519,12 +1234,65 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bo
 	best := mp.cfg.Chain.BestSnapshot()
 	nextBlockHeight := best.Height + 1
 
+	// Transactions with a time based lock time must be finalized as of the
+	// median time of the blocks prior to the next block rather than the
+	// current time.  This ensures only transactions which can be included
+	// in the next block once BIP0113 applies are accepted.  The same time
+	// is used for relative lock times.
+	medianTime, err := mp.cfg.Chain.CalcPastMedianTime()
+	if err != nil {
+		return nil, err
+	}
+
+	// Don't allow transactions which are not finalized if the network
+	// parameters forbid relaying non-standard transactions unless the
+	// caller explicitly allows them.  This is also part of the standardness
+	// checks below, but it is checked separately first so finality
+	// failures are distinguishable from other standardness failures.
+	if !activeNetParams.RelayNonStdTxs {
+		if !blockchain.IsFinalizedTransaction(tx, nextBlockHeight,
+			medianTime) {
+
+			str := fmt.Sprintf("transaction %v is not standard: "+
+				"transaction is not finalized", txHash)
+			if !opts.AcceptNonStd {
+				return nil, txRuleError(wire.RejectNonstandard,
+					str)
+			}
+			txmpLog.Debugf("Accepting non-standard transaction: %s",
+				str)
+			noRelay = true
+		}
+	}
+
+	// Don't allow transactions with versions above the configured maximum
+	// unless the caller explicitly allows them.  Unlike the other
+	// standardness checks, this applies to all networks since the rules
+	// transactions with unknown versions will be subject to are not known.
+	// It is also part of the standardness checks below, but it is checked
+	// separately first for that reason.
+	track.enter(txStageStandardness)
+	err = checkTransactionVersion(tx, mp.cfg.Policy.MaxTxVersion)
+	if err != nil {
+		if !opts.AcceptNonStd {
+			return nil, err
+		}
+		txmpLog.Debugf("Accepting non-standard transaction %v: %v",
+			txHash, err)
+		noRelay = true
+	}
+
 	// Don't allow non-standard transactions if the network parameters
-	// forbid their relaying.
+	// forbid their relaying unless the caller explicitly allows them.
 	if !activeNetParams.RelayNonStdTxs {
 		err := checkTransactionStandard(tx, nextBlockHeight,
-			mp.cfg.TimeSource, mp.cfg.Policy.MinRelayTxFee)
-		if err != nil {
+			medianTime, mp.cfg.Policy.MinRelayTxFee,
+			mp.cfg.Policy.MaxTxVersion)
+		if err != nil && opts.AcceptNonStd {
+			txmpLog.Debugf("Accepting non-standard transaction %v: %v",
+				txHash, err)
+			noRelay = true
+		} else if err != nil {
 			// Attempt to extract a reject code from the error so
 			// it can be retained.  When not possible, fall back to
 			// a non standard error.
@@ -546,6 +1314,7 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bo
 	// at this point.  There is a more in-depth check that happens later
 	// after fetching the referenced transaction inputs from the main chain
 	// which examines the actual spend data and prevents double spends.
+	track.enter(txStageFetchInputs)
 	err = mp.checkPoolDoubleSpend(tx)
 	if err != nil {
 		return nil, err
@@ -591,10 +1360,28 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bo
 		return missingParents, nil
 	}
 
+	// Don't allow transactions whose relative lock-times, as imposed by the
+	// sequence numbers of their inputs, prevent them from being included
+	// in the next block.
+	track.enter(txStageFinality)
+	sequenceLock, err := mp.cfg.Chain.CalcSequenceLock(tx, utxoView, true)
+	if err != nil {
+		if cerr, ok := err.(blockchain.RuleError); ok {
+			return nil, chainRuleError(cerr)
+		}
+		return nil, err
+	}
+	if !sequenceLock.IsSatisfied(nextBlockHeight, medianTime) {
+		str := fmt.Sprintf("transaction %v has input sequence locks "+
+			"which are not met", txHash)
+		return nil, txRuleError(wire.RejectNonstandard, str)
+	}
+
 	// Perform several checks on the transaction inputs using the invariant
 	// rules in btcchain for what transactions are allowed into blocks.
 	// Also returns the fees associated with the transaction which will be
 	// used later.
+	track.enter(txStageFeeChecks)
 	txFee, err := blockchain.CheckTransactionInputs(tx, nextBlockHeight,
 		utxoView)
 	if err != nil {
@@ -605,10 +1392,16 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bo
 	}
 
 	// Don't allow transactions with non-standard inputs if the network
-	// parameters forbid their relaying.
+	// parameters forbid their relaying unless the caller explicitly allows
+	// them.
+	track.enter(txStageStandardness)
 	if !activeNetParams.RelayNonStdTxs {
 		err := checkInputsStandard(tx, utxoView)
-		if err != nil {
+		if err != nil && opts.AcceptNonStd {
+			txmpLog.Debugf("Accepting transaction %v with a "+
+				"non-standard input: %v", txHash, err)
+			noRelay = true
+		} else if err != nil {
 			// Attempt to extract a reject code from the error so
 			// it can be retained.  When not possible, fall back to
 			// a non standard error.
@@ -631,6 +1424,7 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bo
 	// the coinbase address itself can contain signature operations, the
 	// maximum allowed signature operations per transaction is less than
 	// the maximum allowed signature operations per block.
+	track.enter(txStageSigOps)
 	numSigOps, err := blockchain.CountP2SHSigOps(tx, false, utxoView)
 	if err != nil {
 		if cerr, ok := err.(blockchain.RuleError); ok {
@@ -645,6 +1439,18 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bo
 		return nil, txRuleError(wire.RejectNonstandard, str)
 	}
 
+	// Don't allow new transactions which would create chains of unconfirmed
+	// transactions longer or larger than the policy allows.  Transactions
+	// which are being added back to the memory pool from blocks that have
+	// been disconnected during a reorg are exempted since they were
+	// already accepted into a block.
+	if isNew {
+		track.enter(txStageStandardness)
+		if err := mp.checkAncestryLimits(tx); err != nil {
+			return nil, err
+		}
+	}
+
 	// Don't allow transactions with fees too low to get into a mined block.
 	//
 	// Most miners allow a free transaction area in blocks they mine to go
@@ -656,6 +1462,7 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bo
 	// which is more desirable.  Therefore, as long as the size of the
 	// transaction does not exceeed 1000 less than the reserved space for
 	// high-priority transactions, don't require a fee for it.
+	track.enter(txStageFeeChecks)
 	serializedSize := int64(tx.MsgTx().SerializeSize())
 	minFee := calcMinRequiredTxRelayFee(serializedSize,
 		mp.cfg.Policy.MinRelayTxFee)
@@ -663,7 +1470,11 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bo
 		str := fmt.Sprintf("transaction %v has %d fees which is under "+
 			"the required amount of %d", txHash, txFee,
 			minFee)
-		return nil, txRuleError(wire.RejectInsufficientFee, str)
+		if !opts.SkipFeeLimits {
+			return nil, txRuleError(wire.RejectInsufficientFee, str)
+		}
+		txmpLog.Debugf("Skipping fee limits: %s", str)
+		noRelay = true
 	}
 
 	// Require that free transactions have sufficient priority to be mined
@@ -677,13 +1488,57 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bo
 			str := fmt.Sprintf("transaction %v has insufficient "+
 				"priority (%g <= %g)", txHash,
 				currentPriority, minHighPriority)
-			return nil, txRuleError(wire.RejectInsufficientFee, str)
+			if !opts.SkipFeeLimits {
+				return nil, txRuleError(
+					wire.RejectInsufficientFee, str)
+			}
+			txmpLog.Debugf("Skipping fee limits: %s", str)
+			noRelay = true
+		}
+	}
+
+	// Don't allow new transactions which pay less than the dynamic minimum
+	// fee which is in effect after transactions were evicted from the pool
+	// due to its size limit since they would most likely be evicted right
+	// away.
+	if isNew {
+		feeRate := mp.dynamicMinFeeRate(time.Now())
+		dynamicMinFee := calcMinRequiredTxRelayFee(serializedSize,
+			colxutil.Amount(feeRate))
+		if feeRate > float64(mp.cfg.Policy.MinRelayTxFee) &&
+			txFee < dynamicMinFee {
+
+			str := fmt.Sprintf("transaction %v has %d fees which "+
+				"is under the dynamic minimum of %d required "+
+				"while the memory pool is full (%.0f "+
+				"satoshi/kB)", txHash, txFee, dynamicMinFee,
+				feeRate)
+			if !opts.SkipFeeLimits {
+				return nil, txRuleError(
+					wire.RejectInsufficientFee, str)
+			}
+			txmpLog.Debugf("Skipping fee limits: %s", str)
+			noRelay = true
+		}
+	}
+
+	// Don't allow locally submitted transactions which pay an absurdly
+	// high fee since it is almost certainly a mistake such as forgetting to
+	// add a change output.
+	if isLocal && !opts.AllowHighFees {
+		maxFee := calcMinRequiredTxRelayFee(serializedSize*
+			absurdFeeMultiplier, mp.cfg.Policy.MinRelayTxFee)
+		if txFee > maxFee {
+			str := fmt.Sprintf("transaction %v has %d fees which "+
+				"is above the absurd fee limit of %d", txHash,
+				txFee, maxFee)
+			return nil, txRuleError(wire.RejectNonstandard, str)
 		}
 	}
 
 	// Free-to-relay transactions are rate limited here to prevent
 	// penny-flooding with tiny transactions as a form of attack.
-	if rateLimit && txFee < minFee {
+	if rateLimit && !opts.SkipFeeLimits && txFee < minFee {
 		nowUnix := time.Now().Unix()
 		// we decay passed data with an exponentially decaying ~10
 		// minutes window - matches bitcoind handling.
@@ -707,6 +1562,7 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bo
 
 	// Verify crypto signatures for each input and reject the transaction if
 	// any don't verify.
+	track.enter(txStageScripts)
 	err = blockchain.ValidateTransactionScripts(tx, utxoView,
 		txscript.StandardVerifyFlags, mp.cfg.SigCache)
 	if err != nil {
@@ -716,8 +1572,25 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bo
 		return nil, err
 	}
 
-	// Add to transaction pool.
-	mp.addTransaction(utxoView, tx, best.Height, txFee)
+	// Add to transaction pool and evict the transactions with the lowest
+	// fee rates should the pool exceed its size limit as a result.  The
+	// transaction itself might be among them.
+	mp.addTransaction(utxoView, tx, best.Height, txFee, noRelay)
+	if mp.limitPoolSize(time.Now()) > 0 && !mp.isTransactionInPool(txHash) {
+		// The transaction was never announced, so it is not reported
+		// along with the transactions evicted to make room for it.
+		for i, evicted := range mp.evicted {
+			if evicted.Sha().IsEqual(txHash) {
+				mp.evicted = append(mp.evicted[:i],
+					mp.evicted[i+1:]...)
+				break
+			}
+		}
+
+		str := fmt.Sprintf("transaction %v has insufficient fees to "+
+			"be kept in the full memory pool", txHash)
+		return nil, txRuleError(wire.RejectInsufficientFee, str)
+	}
 
 	txmpLog.Debugf("Accepted transaction %v (pool size: %v)", txHash,
 		len(mp.pool))
@@ -737,11 +1610,15 @@ func (mp *txMemPool) maybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bo
 //
 // This function is safe for concurrent access.
 func (mp *txMemPool) MaybeAcceptTransaction(tx *colxutil.Tx, isNew, rateLimit bool) ([]*wire.ShaHash, error) {
+	// Notify the caller about any transactions evicted to make room once
+	// the lock is released.
+	defer mp.notifyEvicted()
+
 	// Protect concurrent access.
 	mp.Lock()
 	defer mp.Unlock()
 
-	return mp.maybeAcceptTransaction(tx, isNew, rateLimit)
+	return mp.maybeAcceptTransaction(tx, isNew, rateLimit, nil)
 }
 
 // processOrphans is the internal function which implements the public
@@ -789,7 +1666,7 @@ func (mp *txMemPool) processOrphans(hash *wire.ShaHash) []*colxutil.Tx {
 			// Potentially accept the transaction into the
 			// transaction pool.
 			missingParents, err := mp.maybeAcceptTransaction(tx,
-				true, true)
+				true, true, nil)
 			if err != nil {
 				// TODO: Remove orphans that depend on this
 				// failed transaction.
@@ -837,17 +1714,42 @@ func (mp *txMemPool) processOrphans(hash *wire.ShaHash) []*colxutil.Tx {
 // orphans) until there are no more.
 //
 // It returns a slice of transactions added to the mempool.  A nil slice means
-// no transactions were moved from the orphan pool to the mempool.
+// no transactions were moved from the orphan pool to the mempool.  The
+// transactions are also passed to the OnOrphansResolved callback of the pool
+// configuration when there are any.
 //
 // This function is safe for concurrent access.
 func (mp *txMemPool) ProcessOrphans(hash *wire.ShaHash) []*colxutil.Tx {
 	mp.Lock()
 	acceptedTxns := mp.processOrphans(hash)
 	mp.Unlock()
+	mp.notifyEvicted()
+
+	// Notify the caller about the resolved orphans once the lock is
+	// released so it is free to access the pool.
+	if len(acceptedTxns) > 0 && mp.cfg.OnOrphansResolved != nil {
+		mp.cfg.OnOrphansResolved(acceptedTxns)
+	}
 
 	return acceptedTxns
 }
 
+// missingInputs returns the outpoints spent by the passed transaction which
+// reference any of the passed missing parent transactions, in the order of the
+// inputs of the transaction.
+func missingInputs(tx *colxutil.Tx, missingParents []*wire.ShaHash) []wire.OutPoint {
+	var outPoints []wire.OutPoint
+	for _, txIn := range tx.MsgTx().TxIn {
+		for _, parent := range missingParents {
+			if txIn.PreviousOutPoint.Hash.IsEqual(parent) {
+				outPoints = append(outPoints, txIn.PreviousOutPoint)
+				break
+			}
+		}
+	}
+	return outPoints
+}
+
 // ProcessTransaction is the main workhorse for handling insertion of new
 // free-standing transactions into the memory pool.  It includes functionality
 // such as rejecting duplicate transactions, ensuring transactions follow all
@@ -858,8 +1760,16 @@ func (mp *txMemPool) ProcessOrphans(hash *wire.ShaHash) []*colxutil.Tx {
 // with any additional orphan transaactions that were added as a result of
 // the passed one being accepted.
 //
+// The opts parameter relaxes the relay policy for transactions submitted
+// locally by the node operator and must be nil for transactions received from
+// the network.  See txAcceptOptions for details.
+//
 // This function is safe for concurrent access.
-func (mp *txMemPool) ProcessTransaction(tx *colxutil.Tx, allowOrphan, rateLimit bool) ([]*colxutil.Tx, error) {
+func (mp *txMemPool) ProcessTransaction(tx *colxutil.Tx, allowOrphan, rateLimit bool, opts *txAcceptOptions) ([]*colxutil.Tx, error) {
+	// Notify the caller about any transactions evicted to make room once
+	// the lock is released.
+	defer mp.notifyEvicted()
+
 	// Protect concurrent access.
 	mp.Lock()
 	defer mp.Unlock()
@@ -867,7 +1777,8 @@ func (mp *txMemPool) ProcessTransaction(tx *colxutil.Tx, allowOrphan, rateLimit
 	txmpLog.Tracef("Processing transaction %v", tx.Sha())
 
 	// Potentially accept the transaction to the memory pool.
-	missingParents, err := mp.maybeAcceptTransaction(tx, true, rateLimit)
+	missingParents, err := mp.maybeAcceptTransaction(tx, true, rateLimit,
+		opts)
 	if err != nil {
 		return nil, err
 	}
@@ -903,7 +1814,11 @@ func (mp *txMemPool) ProcessTransaction(tx *colxutil.Tx, allowOrphan, rateLimit
 		str := fmt.Sprintf("orphan transaction %v references "+
 			"outputs of unknown or fully-spent "+
 			"transaction %v", tx.Sha(), missingParents[0])
-		return nil, txRuleError(wire.RejectDuplicate, str)
+		return nil, RuleError{Err: TxRuleError{
+			RejectCode:    wire.RejectDuplicate,
+			Description:   str,
+			MissingInputs: missingInputs(tx, missingParents),
+		}}
 	}
 
 	// Potentially add the orphan transaction to the orphan pool.
@@ -915,6 +1830,127 @@ func (mp *txMemPool) ProcessTransaction(tx *colxutil.Tx, allowOrphan, rateLimit
 	return nil, nil
 }
 
+// IsRelayable returns whether or not the transaction with the passed hash may
+// be announced to peers.  Transactions which were only accepted because the
+// relay policy was relaxed by the submitter are not relayable, and neither are
+// the transactions which depend on them.  It returns false when the
+// transaction is not in the main pool.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) IsRelayable(hash *wire.ShaHash) bool {
+	mp.RLock()
+	defer mp.RUnlock()
+
+	txDesc, exists := mp.pool[*hash]
+	return exists && !txDesc.NoRelay
+}
+
+// AddUnbroadcast marks the passed locally submitted transaction as not yet
+// known to have propagated to any peer so it is announced again periodically
+// and to newly connected peers until a peer requests it or it leaves the pool,
+// such as when it is mined.  Transactions which are not in the main pool or
+// are not relayable are ignored.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) AddUnbroadcast(tx *colxutil.Tx) {
+	mp.Lock()
+	defer mp.Unlock()
+
+	txDesc, exists := mp.pool[*tx.Sha()]
+	if !exists || txDesc.NoRelay {
+		return
+	}
+	mp.unbroadcast[*tx.Sha()] = &unbroadcastTx{
+		tx:            tx,
+		nextAnnounce:  time.Now().Add(unbroadcastRetryInterval),
+		retryInterval: unbroadcastRetryInterval,
+	}
+}
+
+// RemoveUnbroadcast marks the transaction with the passed hash as propagated,
+// which is the case once a peer requested it, so it is no longer announced
+// again.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) RemoveUnbroadcast(hash *wire.ShaHash) {
+	mp.Lock()
+	delete(mp.unbroadcast, *hash)
+	mp.Unlock()
+}
+
+// isUnbroadcast returns whether or not the transaction with the passed hash is
+// a locally submitted transaction which has not been requested by any peer yet.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *txMemPool) isUnbroadcast(hash *wire.ShaHash) bool {
+	_, exists := mp.unbroadcast[*hash]
+	return exists
+}
+
+// IsUnbroadcast returns whether or not the transaction with the passed hash is
+// a locally submitted transaction which has not been requested by any peer yet.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) IsUnbroadcast(hash *wire.ShaHash) bool {
+	mp.RLock()
+	defer mp.RUnlock()
+
+	return mp.isUnbroadcast(hash)
+}
+
+// UnbroadcastCount returns the number of locally submitted transactions which
+// have not been requested by any peer yet.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) UnbroadcastCount() int {
+	mp.RLock()
+	defer mp.RUnlock()
+
+	return len(mp.unbroadcast)
+}
+
+// UnbroadcastTxs returns all locally submitted transactions which have not been
+// requested by any peer yet.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) UnbroadcastTxs() []*colxutil.Tx {
+	mp.RLock()
+	defer mp.RUnlock()
+
+	txns := make([]*colxutil.Tx, 0, len(mp.unbroadcast))
+	for _, entry := range mp.unbroadcast {
+		txns = append(txns, entry.tx)
+	}
+	return txns
+}
+
+// DueUnbroadcast returns the locally submitted transactions which have not
+// been requested by any peer yet and are due to be announced again as of the
+// passed time.  The time until the returned transactions are due again is
+// doubled up to a maximum so transactions which never propagate, for example
+// because all peers reject them, are announced less and less often.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) DueUnbroadcast(now time.Time) []*colxutil.Tx {
+	mp.Lock()
+	defer mp.Unlock()
+
+	var txns []*colxutil.Tx
+	for _, entry := range mp.unbroadcast {
+		if now.Before(entry.nextAnnounce) {
+			continue
+		}
+		txns = append(txns, entry.tx)
+
+		entry.retryInterval *= 2
+		if entry.retryInterval > maxUnbroadcastRetryInterval {
+			entry.retryInterval = maxUnbroadcastRetryInterval
+		}
+		entry.nextAnnounce = now.Add(entry.retryInterval)
+	}
+	return txns
+}
+
 // Count returns the number of transactions in the main pool.  It does not
 // include the orphan pool.
 //
@@ -963,6 +1999,76 @@ func (mp *txMemPool) TxDescs() []*mempoolTxDesc {
 	return descs
 }
 
+// TxnsByShortID returns the transactions in the pool keyed by their compact
+// block short IDs for the passed SipHash keys.  It implements the
+// blockchain.CompactBlockTxSource interface so compact blocks can be
+// reconstructed from the transactions in the pool.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) TxnsByShortID(k0, k1 uint64) map[uint64][]*colxutil.Tx {
+	mp.RLock()
+	defer mp.RUnlock()
+
+	txns := make(map[uint64][]*colxutil.Tx, len(mp.pool))
+	for hash, desc := range mp.pool {
+		shortID := blockchain.ShortTxID(k0, k1, &hash)
+		txns[shortID] = append(txns[shortID], desc.Tx)
+	}
+
+	return txns
+}
+
+// DynamicUsage returns an estimate of the number of bytes of memory used by
+// the transactions in the main pool.  The estimate includes the deserialized
+// transactions along with the per entry overhead of the descriptors and the
+// outpoint index.  It does not include the orphan pool.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) DynamicUsage() int64 {
+	// These are rough per item overheads for the structures which are
+	// allocated for every transaction input, output, and pool entry.
+	const (
+		txInOverhead    = 96
+		txOutOverhead   = 64
+		txDescOverhead  = 256
+		outpointEntrySz = 64
+	)
+
+	mp.RLock()
+	defer mp.RUnlock()
+
+	var usage int64
+	for _, desc := range mp.pool {
+		msgTx := desc.Tx.MsgTx()
+		usage += int64(msgTx.SerializeSize()) + txDescOverhead
+		usage += int64(len(msgTx.TxIn)) * (txInOverhead + outpointEntrySz)
+		usage += int64(len(msgTx.TxOut)) * txOutOverhead
+	}
+
+	return usage
+}
+
+// TotalFees returns the total fees in Satoshi paid by the transactions in the
+// main pool.  It does not include the orphan pool.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) TotalFees() int64 {
+	mp.RLock()
+	defer mp.RUnlock()
+
+	return mp.totalFees
+}
+
+// AcceptanceStats returns statistics about the transactions which have been
+// considered for acceptance into the pool, including how many of them reached
+// and were rejected by each stage of the acceptance pipeline along with the
+// time spent in each stage.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) AcceptanceStats() *txAcceptStats {
+	return mp.acceptCounters.snapshot()
+}
+
 // MiningDescs returns a slice of mining descriptors for all the transactions
 // in the pool.
 //
@@ -972,16 +2078,85 @@ func (mp *txMemPool) MiningDescs() []*mining.TxDesc {
 	mp.RLock()
 	defer mp.RUnlock()
 
+	// The descriptors are copied since the fee delta of a transaction in
+	// the pool may be changed while they are being used.
 	descs := make([]*mining.TxDesc, len(mp.pool))
 	i := 0
 	for _, desc := range mp.pool {
-		descs[i] = &desc.TxDesc
+		txDesc := desc.TxDesc
+		descs[i] = &txDesc
 		i++
 	}
 
 	return descs
 }
 
+// expireFeeDeltas removes the fee deltas of transactions which are not in the
+// pool and expired as of the passed time.  It returns the number of removed
+// fee deltas.
+//
+// This function MUST be called with the mempool lock held (for writes).
+func (mp *txMemPool) expireFeeDeltas(now time.Time) int {
+	var numExpired int
+	for hash, fd := range mp.feeDeltas {
+		if !fd.expiration.IsZero() && !now.Before(fd.expiration) {
+			delete(mp.feeDeltas, hash)
+			numExpired++
+		}
+	}
+	return numExpired
+}
+
+// PrioritiseTransaction sets the fee delta of the transaction with the passed
+// hash to the passed amount in Satoshi, replacing any previously set delta.
+// The delta is added to the fee of the transaction when selecting transactions
+// for block templates, so a positive delta makes the transaction more likely
+// to be included and a negative one less likely.  It does not change the fee
+// the transaction actually pays.  A delta of zero clears the fee delta.
+//
+// The transaction does not have to be in the pool.  Its fee delta is applied
+// once it arrives and kept for a while after it leaves the pool, such as when
+// it is evicted, in case it is added back.  An error is returned when the
+// transaction is not in the pool and the maximum number of fee deltas has been
+// reached.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) PrioritiseTransaction(hash *wire.ShaHash, delta int64) error {
+	mp.Lock()
+	defer mp.Unlock()
+
+	desc, inPool := mp.pool[*hash]
+	if inPool {
+		desc.FeeDelta = delta
+		atomic.StoreInt64(&mp.lastUpdated, time.Now().Unix())
+	}
+	if delta == 0 {
+		delete(mp.feeDeltas, *hash)
+		return nil
+	}
+
+	// The fee delta of a transaction which is not in the pool expires
+	// unless the transaction arrives in time.
+	var expiration time.Time
+	if !inPool {
+		expiration = time.Now().Add(feeDeltaTTL)
+	}
+	if fd, ok := mp.feeDeltas[*hash]; ok {
+		fd.delta = delta
+		fd.expiration = expiration
+		return nil
+	}
+	if !inPool && len(mp.feeDeltas) >= maxFeeDeltas &&
+		mp.expireFeeDeltas(time.Now()) == 0 {
+
+		return fmt.Errorf("unable to set fee delta for transaction "+
+			"%v: the maximum of %d fee deltas has been reached",
+			hash, maxFeeDeltas)
+	}
+	mp.feeDeltas[*hash] = &feeDelta{delta: delta, expiration: expiration}
+	return nil
+}
+
 // LastUpdated returns the last time a transaction was added to or removed from
 // the main pool.  It does not include the orphan pool.
 //
@@ -996,9 +2171,11 @@ func newTxMemPool(cfg *mempoolConfig) *txMemPool {
 	memPool := &txMemPool{
 		cfg:           *cfg,
 		pool:          make(map[wire.ShaHash]*mempoolTxDesc),
-		orphans:       make(map[wire.ShaHash]*colxutil.Tx),
+		orphans:       make(map[wire.ShaHash]*orphanTx),
 		orphansByPrev: make(map[wire.ShaHash]map[wire.ShaHash]*colxutil.Tx),
 		outpoints:     make(map[wire.OutPoint]*colxutil.Tx),
+		unbroadcast:   make(map[wire.ShaHash]*unbroadcastTx),
+		feeDeltas:     make(map[wire.ShaHash]*feeDelta),
 	}
 	return memPool
 }
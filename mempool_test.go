@@ -0,0 +1,989 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/btcec"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/mining"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// poolHarness provides a memory pool backed by a fresh chain along with a
+// funding transaction whose outputs can be spent by test transactions.
+type poolHarness struct {
+	chain      *blockchain.BlockChain
+	privKey    *btcec.PrivateKey
+	payScript  []byte
+	fundingTx  *colxutil.Tx
+	nextOutput uint32
+	teardown   func()
+}
+
+// newPoolHarness creates a new chain instance in a temporary database along
+// with a key and a funding transaction paying to it.
+func newPoolHarness(t *testing.T) *poolHarness {
+	dbPath, err := ioutil.TempDir("", "mempooltest")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		activeNetParams.Net)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("unable to create db: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: activeNetParams.Params,
+		TimeSource:  blockchain.NewMedianTime(),
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create chain: %v", err)
+	}
+
+	privKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create private key: %v", err)
+	}
+	pkHash := colxutil.Hash160(privKey.PubKey().SerializeCompressed())
+	addr, err := colxutil.NewAddressPubKeyHash(pkHash,
+		activeNetParams.Params)
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create address: %v", err)
+	}
+	payScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create pay script: %v", err)
+	}
+
+	// Create a transaction with plenty of outputs paying to the key so
+	// each test transaction can spend a distinct one.
+	fundingTx := wire.NewMsgTx()
+	fundingTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 0}, nil))
+	for i := 0; i < 10; i++ {
+		fundingTx.AddTxOut(wire.NewTxOut(colxutil.SatoshiPerBitcoin,
+			payScript))
+	}
+
+	return &poolHarness{
+		chain:     chain,
+		privKey:   privKey,
+		payScript: payScript,
+		fundingTx: colxutil.NewTx(fundingTx),
+		teardown:  teardown,
+	}
+}
+
+// newPool returns a new memory pool which treats the outputs of the funding
+// transaction as confirmed in the main chain.
+func (h *poolHarness) newPool() *txMemPool {
+	fetchUtxoView := func(tx *colxutil.Tx) (*blockchain.UtxoViewpoint, error) {
+		view := blockchain.NewUtxoViewpoint()
+		// Mark any other referenced transactions as missing like the
+		// chain does so the pool can fill them in from its own
+		// transactions.
+		entries := view.Entries()
+		for _, txIn := range tx.MsgTx().TxIn {
+			originHash := txIn.PreviousOutPoint.Hash
+			if originHash == *h.fundingTx.Sha() {
+				view.AddTxOuts(h.fundingTx, 0)
+			} else if _, ok := entries[originHash]; !ok {
+				entries[originHash] = nil
+			}
+		}
+		return view, nil
+	}
+
+	return newTxMemPool(&mempoolConfig{
+		Policy: mempoolPolicy{
+			FreeTxRelayLimit: defaultFreeTxRelayLimit,
+			MaxOrphanTxs:     defaultMaxOrphanTransactions,
+			MaxOrphanTxSize:  defaultMaxOrphanTxSize,
+			MaxSigOpsPerTx:   blockchain.MaxSigOpsPerBlock / 5,
+			MinRelayTxFee:    defaultMinRelayTxFee,
+		},
+		FetchUtxoView: fetchUtxoView,
+		Chain:         h.chain,
+		TimeSource:    blockchain.NewMedianTime(),
+	})
+}
+
+// spendTx creates a signed transaction which spends the next unused output of
+// the funding transaction to a single output with the passed amount and
+// public key script.
+func (h *poolHarness) spendTx(t *testing.T, amount int64, pkScript []byte) *colxutil.Tx {
+	prevOut := wire.NewOutPoint(h.fundingTx.Sha(), h.nextOutput)
+	h.nextOutput++
+
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(prevOut, nil))
+	tx.AddTxOut(wire.NewTxOut(amount, pkScript))
+	sigScript, err := txscript.SignatureScript(tx, 0, h.payScript,
+		txscript.SigHashAll, h.privKey, true)
+	if err != nil {
+		t.Fatalf("unable to sign transaction: %v", err)
+	}
+	tx.TxIn[0].SignatureScript = sigScript
+	return colxutil.NewTx(tx)
+}
+
+// chainedTx creates a signed transaction which spends the first output of the
+// passed transaction, which must pay to the harness key, to a single output
+// with the passed amount paying to the harness key.
+func (h *poolHarness) chainedTx(t *testing.T, parent *colxutil.Tx, amount int64) *colxutil.Tx {
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(parent.Sha(), 0), nil))
+	tx.AddTxOut(wire.NewTxOut(amount, h.payScript))
+	sigScript, err := txscript.SignatureScript(tx, 0, h.payScript,
+		txscript.SigHashAll, h.privKey, true)
+	if err != nil {
+		t.Fatalf("unable to sign transaction: %v", err)
+	}
+	tx.TxIn[0].SignatureScript = sigScript
+	return colxutil.NewTx(tx)
+}
+
+// TestProcessTransactionOptions ensures the per-call relay policy relaxations
+// only apply to locally submitted transactions and that transactions which
+// are only accepted because of them are not announced to peers.
+func TestProcessTransactionOptions(t *testing.T) {
+	h := newPoolHarness(t)
+	defer h.teardown()
+	mp := h.newPool()
+
+	// peerPool simulates a remote peer which enforces the full policy.
+	peerPool := h.newPool()
+
+	// Create a server which only has what is needed to observe relayed
+	// inventory.
+	s := &server{
+		txMemPool:        mp,
+		relayInv:         make(chan relayMsg, 10),
+		templateNotifier: mining.NewTemplateNotifier(0),
+	}
+	relayedInv := func() []*wire.InvVect {
+		var invs []*wire.InvVect
+		for {
+			select {
+			case msg := <-s.relayInv:
+				invs = append(invs, msg.invVect)
+			default:
+				return invs
+			}
+		}
+	}
+
+	const fee = 10000
+	nonStdScript := []byte{txscript.OP_TRUE}
+
+	// Ensure a non-standard transaction is rejected by default, accepted
+	// when the caller allows it, and not announced to peers.
+	nonStdTx := h.spendTx(t, colxutil.SatoshiPerBitcoin-fee, nonStdScript)
+	_, err := mp.ProcessTransaction(nonStdTx, false, false, nil)
+	if rerr, ok := err.(RuleError); !ok {
+		t.Fatalf("ProcessTransaction: expected rule error for "+
+			"non-standard tx - got %v", err)
+	} else if code, _ := extractRejectCode(rerr); code != wire.RejectNonstandard {
+		t.Fatalf("ProcessTransaction: unexpected reject code - got "+
+			"%v, want %v", code, wire.RejectNonstandard)
+	}
+	acceptedTxs, err := mp.ProcessTransaction(nonStdTx, false, false,
+		&txAcceptOptions{AcceptNonStd: true})
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error accepting "+
+			"non-standard tx: %v", err)
+	}
+	if !mp.IsTransactionInPool(nonStdTx.Sha()) {
+		t.Fatalf("non-standard tx is not in the pool")
+	}
+	if mp.IsRelayable(nonStdTx.Sha()) {
+		t.Fatalf("non-standard tx is unexpectedly relayable")
+	}
+	s.AnnounceNewTransactions(acceptedTxs)
+	for _, iv := range relayedInv() {
+		if iv.Hash == *nonStdTx.Sha() {
+			t.Fatalf("non-standard tx was announced to peers")
+		}
+		// Any announced transaction must be acceptable to the peer.
+		tx, _ := mp.FetchTransaction(&iv.Hash)
+		if _, err := peerPool.ProcessTransaction(tx, false, false,
+			nil); err != nil {
+			t.Fatalf("peer rejected announced tx: %v", err)
+		}
+	}
+	if _, err := peerPool.ProcessTransaction(nonStdTx, false, false,
+		nil); err == nil {
+		t.Fatalf("peer unexpectedly accepted non-standard tx")
+	}
+
+	// Ensure a standard transaction submitted locally is announced and
+	// accepted by the peer.
+	stdTx := h.spendTx(t, colxutil.SatoshiPerBitcoin-fee, h.payScript)
+	acceptedTxs, err = mp.ProcessTransaction(stdTx, false, false,
+		&txAcceptOptions{})
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error accepting "+
+			"standard tx: %v", err)
+	}
+	s.AnnounceNewTransactions(acceptedTxs)
+	invs := relayedInv()
+	if len(invs) != 1 || invs[0].Hash != *stdTx.Sha() {
+		t.Fatalf("standard tx was not announced to peers - got %v",
+			invs)
+	}
+	if _, err := peerPool.ProcessTransaction(stdTx, false, false,
+		nil); err != nil {
+		t.Fatalf("peer rejected standard tx: %v", err)
+	}
+
+	// Ensure a locally submitted transaction paying an absurd fee is
+	// rejected unless high fees are allowed, while the same transaction
+	// received from the network is accepted.
+	highFeeTx := h.spendTx(t, colxutil.SatoshiPerBitcoin/2, h.payScript)
+	if _, err := mp.ProcessTransaction(highFeeTx, false, false,
+		&txAcceptOptions{}); err == nil {
+		t.Fatalf("ProcessTransaction: accepted absurd fee tx")
+	}
+	if _, err := mp.ProcessTransaction(highFeeTx, false, false,
+		&txAcceptOptions{AllowHighFees: true}); err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error accepting "+
+			"high fee tx: %v", err)
+	}
+	if !mp.IsRelayable(highFeeTx.Sha()) {
+		t.Fatalf("high fee tx is not relayable")
+	}
+	if _, err := peerPool.ProcessTransaction(highFeeTx, false, false,
+		nil); err != nil {
+		t.Fatalf("peer rejected high fee tx: %v", err)
+	}
+
+	// Ensure a free transaction without enough priority is only accepted
+	// when the fee limits are skipped and is not relayable.
+	freeTx := h.spendTx(t, colxutil.SatoshiPerBitcoin, h.payScript)
+	if _, err := mp.ProcessTransaction(freeTx, false, true,
+		nil); err == nil {
+		t.Fatalf("ProcessTransaction: accepted free tx without " +
+			"priority")
+	}
+	if _, err := mp.ProcessTransaction(freeTx, false, true,
+		&txAcceptOptions{SkipFeeLimits: true}); err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error accepting "+
+			"free tx: %v", err)
+	}
+	if mp.IsRelayable(freeTx.Sha()) {
+		t.Fatalf("free tx is unexpectedly relayable")
+	}
+
+	// Ensure a transaction which satisfies the full policy on its own,
+	// but spends a transaction which is not relayable, is not relayable
+	// either since peers don't have its parent.
+	freeChild := h.chainedTx(t, freeTx, colxutil.SatoshiPerBitcoin-fee)
+	acceptedTxs, err = mp.ProcessTransaction(freeChild, false, false, nil)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error accepting "+
+			"child of free tx: %v", err)
+	}
+	if mp.IsRelayable(freeChild.Sha()) {
+		t.Fatalf("child of free tx is unexpectedly relayable")
+	}
+	s.AnnounceNewTransactions(acceptedTxs)
+	if invs := relayedInv(); len(invs) != 0 {
+		t.Fatalf("child of free tx was announced to peers - got %v",
+			invs)
+	}
+
+	// Ensure options never relax consensus rules by attempting to spend
+	// more than the input is worth.
+	badTx := h.spendTx(t, colxutil.SatoshiPerBitcoin+1, h.payScript)
+	_, err = mp.ProcessTransaction(badTx, false, false, &txAcceptOptions{
+		AcceptNonStd:  true,
+		AllowHighFees: true,
+		SkipFeeLimits: true,
+	})
+	if err == nil {
+		t.Fatalf("ProcessTransaction: accepted tx spending more " +
+			"than its inputs")
+	}
+}
+
+// TestAncestryLimits ensures transactions which would create chains of
+// unconfirmed transactions exceeding the ancestor or descendant limits are
+// rejected, and that the ancestry of the transactions in the pool is kept
+// correct as parts of a chain are mined, added back by a reorg, and removed.
+func TestAncestryLimits(t *testing.T) {
+	h := newPoolHarness(t)
+	defer h.teardown()
+	mp := h.newPool()
+	mp.cfg.Policy.MaxAncestorCount = defaultLimitAncestorCount
+	mp.cfg.Policy.MaxAncestorSize = defaultLimitAncestorSize * 1000
+	mp.cfg.Policy.MaxDescendantCount = defaultLimitDescendantCount
+	mp.cfg.Policy.MaxDescendantSize = defaultLimitDescendantSize * 1000
+
+	// Create a chain of transactions where each one spends the output of
+	// the previous one and pays the same fee.
+	const chainLen = 30
+	const fee = 10000
+	txns := make([]*colxutil.Tx, chainLen+1)
+	txns[0] = h.spendTx(t, colxutil.SatoshiPerBitcoin-fee, h.payScript)
+	for i := 1; i < len(txns); i++ {
+		amount := txns[i-1].MsgTx().TxOut[0].Value - fee
+		txns[i] = h.chainedTx(t, txns[i-1], amount)
+	}
+	txSize := func(i int) int64 {
+		return int64(txns[i].MsgTx().SerializeSize())
+	}
+
+	// checkAncestry ensures the pool contains exactly the transactions of
+	// the chain in the passed range and that their ancestry matches it.
+	checkAncestry := func(name string, first, end int) {
+		if mp.Count() != end-first {
+			t.Fatalf("%s: unexpected pool size - got %d, want %d",
+				name, mp.Count(), end-first)
+		}
+		for i := first; i < end; i++ {
+			got, err := mp.EntryAncestry(txns[i].Sha())
+			if err != nil {
+				t.Fatalf("%s: EntryAncestry #%d: unexpected error: %v",
+					name, i, err)
+			}
+			want := txAncestry{
+				AncestorCount:   i - first + 1,
+				AncestorFees:    int64(i-first+1) * fee,
+				DescendantCount: end - i,
+				DescendantFees:  int64(end-i) * fee,
+			}
+			for j := first; j <= i; j++ {
+				want.AncestorSize += txSize(j)
+			}
+			for j := i; j < end; j++ {
+				want.DescendantSize += txSize(j)
+			}
+			if *got != want {
+				t.Errorf("%s: unexpected ancestry of #%d - got %+v, "+
+					"want %+v", name, i, *got, want)
+			}
+		}
+	}
+
+	// checkRejected ensures the passed transaction is rejected as
+	// non-standard and not added to the pool.
+	checkRejected := func(name string, tx *colxutil.Tx) {
+		_, err := mp.ProcessTransaction(tx, false, false, nil)
+		if rerr, ok := err.(RuleError); !ok {
+			t.Fatalf("%s: expected rule error - got %v", name, err)
+		} else if code, _ := extractRejectCode(rerr); code !=
+			wire.RejectNonstandard {
+
+			t.Fatalf("%s: unexpected reject code - got %v, want %v",
+				name, code, wire.RejectNonstandard)
+		}
+		if mp.HaveTransaction(tx.Sha()) {
+			t.Fatalf("%s: rejected transaction is in the pool", name)
+		}
+	}
+
+	// Transactions are accepted until the chain reaches the ancestor
+	// count limit.
+	for i := 0; i < defaultLimitAncestorCount; i++ {
+		_, err := mp.ProcessTransaction(txns[i], false, false, nil)
+		if err != nil {
+			t.Fatalf("ProcessTransaction #%d: unexpected error: %v",
+				i, err)
+		}
+	}
+	checkAncestry("full chain", 0, defaultLimitAncestorCount)
+	checkRejected("ancestor count limit", txns[defaultLimitAncestorCount])
+
+	// Mining the start of the chain removes it from the ancestry of the
+	// rest of the chain which in turn allows the chain to be extended.
+	const numMined = 10
+	for i := 0; i < numMined; i++ {
+		mp.RemoveTransaction(txns[i], false)
+	}
+	checkAncestry("after mining", numMined, defaultLimitAncestorCount)
+	for i := defaultLimitAncestorCount; i < chainLen; i++ {
+		_, err := mp.ProcessTransaction(txns[i], false, false, nil)
+		if err != nil {
+			t.Fatalf("ProcessTransaction #%d: unexpected error: %v",
+				i, err)
+		}
+	}
+	checkAncestry("extended chain", numMined, chainLen)
+
+	// Extending the chain further would exceed lower descendant and size
+	// limits while staying within the ancestor count limit.
+	var chainSize int64
+	for i := numMined; i < chainLen; i++ {
+		chainSize += txSize(i)
+	}
+	policy := mp.cfg.Policy
+	tests := []struct {
+		name   string
+		modify func(p *mempoolPolicy)
+	}{
+		{
+			name: "descendant count limit",
+			modify: func(p *mempoolPolicy) {
+				p.MaxDescendantCount = chainLen - numMined
+			},
+		},
+		{
+			name: "descendant size limit",
+			modify: func(p *mempoolPolicy) {
+				p.MaxDescendantSize = chainSize + txSize(chainLen) - 1
+			},
+		},
+		{
+			name: "ancestor size limit",
+			modify: func(p *mempoolPolicy) {
+				p.MaxAncestorSize = chainSize + txSize(chainLen) - 1
+			},
+		},
+	}
+	for _, test := range tests {
+		test.modify(&mp.cfg.Policy)
+		checkRejected(test.name, txns[chainLen])
+		mp.cfg.Policy = policy
+	}
+	checkAncestry("after rejections", numMined, chainLen)
+
+	// Transactions added back to the pool by a reorg are not subject to
+	// the limits and become ancestors of the transactions which spend
+	// them.
+	for i := 0; i < numMined; i++ {
+		_, err := mp.MaybeAcceptTransaction(txns[i], false, false)
+		if err != nil {
+			t.Fatalf("MaybeAcceptTransaction #%d: unexpected error: %v",
+				i, err)
+		}
+	}
+	checkAncestry("after reorg", 0, chainLen)
+
+	// Removing a transaction along with its redeemers removes them from
+	// the ancestry of the remaining transactions.
+	mp.RemoveTransaction(txns[20], true)
+	checkAncestry("after removal", 0, 20)
+
+	if _, err := mp.EntryAncestry(txns[20].Sha()); err == nil {
+		t.Errorf("EntryAncestry: unexpected success for a transaction " +
+			"which is not in the pool")
+	}
+}
+
+// TestOrphanPool ensures orphans expire once their time to live elapsed, the
+// resolution callback is notified about orphans accepted once their parents
+// become available, and the total size of the orphans is limited by evicting
+// the largest ones first.
+func TestOrphanPool(t *testing.T) {
+	h := newPoolHarness(t)
+	defer h.teardown()
+
+	// orphanTx returns an unsigned transaction with the passed number of
+	// outputs spending an output of an unknown transaction.
+	var nextParent byte
+	orphanTx := func(numOutputs int) *colxutil.Tx {
+		nextParent++
+		tx := wire.NewMsgTx()
+		prevOut := wire.NewOutPoint(&wire.ShaHash{nextParent}, 0)
+		tx.AddTxIn(wire.NewTxIn(prevOut, nil))
+		for i := 0; i < numOutputs; i++ {
+			tx.AddTxOut(wire.NewTxOut(1000000, h.payScript))
+		}
+		return colxutil.NewTx(tx)
+	}
+	addOrphan := func(mp *txMemPool, tx *colxutil.Tx) {
+		accepted, err := mp.ProcessTransaction(tx, true, false, nil)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+		}
+		if len(accepted) != 0 || !mp.IsOrphanInPool(tx.Sha()) {
+			t.Fatalf("transaction %v is not an orphan", tx.Sha())
+		}
+	}
+
+	// Orphans are only removed once they expired.
+	mp := h.newPool()
+	mp.cfg.Policy.OrphanTTL = time.Minute
+	orphan := orphanTx(1)
+	addOrphan(mp, orphan)
+	if n := mp.ExpireOrphans(time.Now()); n != 0 {
+		t.Fatalf("ExpireOrphans: %d orphans expired early", n)
+	}
+	if n := mp.ExpireOrphans(time.Now().Add(2 * time.Minute)); n != 1 {
+		t.Fatalf("ExpireOrphans: got %d expired orphans, want 1", n)
+	}
+	if mp.IsOrphanInPool(orphan.Sha()) {
+		t.Fatalf("expired orphan is still in the orphan pool")
+	}
+
+	// Orphans never expire when expiration is disabled.
+	mp.cfg.Policy.OrphanTTL = 0
+	addOrphan(mp, orphan)
+	if n := mp.ExpireOrphans(time.Now().Add(24 * time.Hour)); n != 0 {
+		t.Fatalf("ExpireOrphans: %d orphans expired with expiration "+
+			"disabled", n)
+	}
+
+	// Create a chain of a parent, a child, and a grandchild and add the
+	// descendants as orphans.  Accepting the parent into the pool and
+	// processing the orphans which depend on it must accept both of them
+	// and notify the callback.
+	const fee = 10000
+	parent := h.spendTx(t, colxutil.SatoshiPerBitcoin-fee, h.payScript)
+	child := h.chainedTx(t, parent, parent.MsgTx().TxOut[0].Value-fee)
+	grandchild := h.chainedTx(t, child, child.MsgTx().TxOut[0].Value-fee)
+	mp = h.newPool()
+	var resolved []*colxutil.Tx
+	mp.cfg.OnOrphansResolved = func(accepted []*colxutil.Tx) {
+		// The pool must not be locked while calling the callback.
+		for _, tx := range accepted {
+			if !mp.IsTransactionInPool(tx.Sha()) {
+				t.Errorf("resolved orphan %v is not in the pool",
+					tx.Sha())
+			}
+		}
+		resolved = append(resolved, accepted...)
+	}
+	addOrphan(mp, grandchild)
+	addOrphan(mp, child)
+	if _, err := mp.MaybeAcceptTransaction(parent, true, false); err != nil {
+		t.Fatalf("MaybeAcceptTransaction: unexpected error: %v", err)
+	}
+	if len(resolved) != 0 {
+		t.Fatalf("callback notified before orphans were processed")
+	}
+	accepted := mp.ProcessOrphans(parent.Sha())
+	if len(accepted) != 2 || len(resolved) != 2 {
+		t.Fatalf("got %d accepted and %d resolved orphans, want 2",
+			len(accepted), len(resolved))
+	}
+	if *resolved[0].Sha() != *child.Sha() ||
+		*resolved[1].Sha() != *grandchild.Sha() {
+
+		t.Fatalf("unexpected resolved orphans - got %v and %v, want "+
+			"%v and %v", resolved[0].Sha(), resolved[1].Sha(),
+			child.Sha(), grandchild.Sha())
+	}
+	if mp.IsOrphanInPool(child.Sha()) || mp.IsOrphanInPool(grandchild.Sha()) {
+		t.Fatalf("resolved orphans are still in the orphan pool")
+	}
+	if mp.ProcessOrphans(grandchild.Sha()) != nil || len(resolved) != 2 {
+		t.Fatalf("callback notified without any resolved orphans")
+	}
+
+	// Limit the orphan pool to the size of a small and a large orphan and
+	// ensure adding another small one evicts the large one.
+	small, large := orphanTx(1), orphanTx(10)
+	mp = h.newPool()
+	mp.cfg.Policy.MaxOrphanBytes = small.MsgTx().SerializeSize() +
+		large.MsgTx().SerializeSize()
+	addOrphan(mp, small)
+	addOrphan(mp, large)
+	small2 := orphanTx(1)
+	addOrphan(mp, small2)
+	if mp.IsOrphanInPool(large.Sha()) {
+		t.Fatalf("largest orphan was not evicted")
+	}
+	if !mp.IsOrphanInPool(small.Sha()) {
+		t.Fatalf("smaller orphan was evicted")
+	}
+
+	smallSize := small.MsgTx().SerializeSize()
+	if mp.orphanBytes != 2*smallSize {
+		t.Fatalf("unexpected orphan pool size - got %d, want %d",
+			mp.orphanBytes, 2*smallSize)
+	}
+
+	// Lowering the limit to the size of a large orphan requires evicting
+	// all other orphans to make room for another large one.
+	mp.cfg.Policy.MaxOrphanBytes = large.MsgTx().SerializeSize()
+	large2 := orphanTx(10)
+	addOrphan(mp, large2)
+	if mp.IsOrphanInPool(small.Sha()) || mp.IsOrphanInPool(small2.Sha()) {
+		t.Fatalf("small orphans were not evicted")
+	}
+	if mp.orphanBytes != large2.MsgTx().SerializeSize() {
+		t.Fatalf("unexpected orphan pool size - got %d, want %d",
+			mp.orphanBytes, large2.MsgTx().SerializeSize())
+	}
+}
+
+// TestPoolSizeLimit ensures the transactions with the lowest fee rate along
+// with the transactions depending on them are evicted once the pool exceeds
+// its size limit and that the resulting dynamic minimum fee is enforced.
+func TestPoolSizeLimit(t *testing.T) {
+	h := newPoolHarness(t)
+	defer h.teardown()
+	mp := h.newPool()
+
+	// Create a parent paying a low fee with a child paying a higher fee
+	// so the child alone has a higher fee rate than the transactions paying
+	// a medium fee while the package of both has the lowest fee rate.
+	medium1 := h.spendTx(t, colxutil.SatoshiPerBitcoin-5000, h.payScript)
+	parent := h.spendTx(t, colxutil.SatoshiPerBitcoin-2000, h.payScript)
+	child := h.chainedTx(t, parent, parent.MsgTx().TxOut[0].Value-3000)
+	medium2 := h.spendTx(t, colxutil.SatoshiPerBitcoin-4000, h.payScript)
+	var poolSize int64
+	for _, tx := range []*colxutil.Tx{medium1, parent, child, medium2} {
+		_, err := mp.ProcessTransaction(tx, false, false, nil)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+		}
+		poolSize += int64(tx.MsgTx().SerializeSize())
+	}
+
+	// Limit the pool to its current size and add a transaction paying a
+	// high fee which requires evicting the package of the parent.
+	var evicted map[wire.ShaHash]struct{}
+	mp.cfg.OnTxsEvicted = func(txns []*colxutil.Tx) {
+		evicted = make(map[wire.ShaHash]struct{})
+		for _, tx := range txns {
+			evicted[*tx.Sha()] = struct{}{}
+		}
+	}
+	mp.cfg.Policy.MaxTxPoolSizeBytes = poolSize
+	high := h.spendTx(t, colxutil.SatoshiPerBitcoin-10000, h.payScript)
+	_, err := mp.ProcessTransaction(high, false, false, nil)
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	for _, tx := range []*colxutil.Tx{medium1, medium2, high} {
+		if !mp.IsTransactionInPool(tx.Sha()) {
+			t.Fatalf("transaction %v was evicted", tx.Sha())
+		}
+	}
+	for _, tx := range []*colxutil.Tx{parent, child} {
+		if mp.IsTransactionInPool(tx.Sha()) {
+			t.Fatalf("transaction %v was not evicted", tx.Sha())
+		}
+		if _, ok := evicted[*tx.Sha()]; !ok {
+			t.Fatalf("eviction of transaction %v was not notified",
+				tx.Sha())
+		}
+	}
+	if len(evicted) != 2 {
+		t.Fatalf("unexpected number of evicted transactions notified - "+
+			"got %d, want 2", len(evicted))
+	}
+	if len(mp.evictQueue) != len(mp.pool) {
+		t.Fatalf("eviction queue is out of sync with the pool - got %d "+
+			"transactions, want %d", len(mp.evictQueue), len(mp.pool))
+	}
+	if mp.poolBytes > poolSize {
+		t.Fatalf("pool exceeds its size limit - got %d bytes, want "+
+			"at most %d", mp.poolBytes, poolSize)
+	}
+
+	// The dynamic minimum fee is raised to the fee rate of the evicted
+	// package.
+	packageSize := parent.MsgTx().SerializeSize() +
+		child.MsgTx().SerializeSize()
+	wantMinFee := colxutil.Amount(5000 * 1000 / packageSize)
+	if minFee := mp.MinRelayTxFee(); minFee != wantMinFee {
+		t.Fatalf("MinRelayTxFee: unexpected fee - got %v, want %v",
+			minFee, wantMinFee)
+	}
+
+	// A transaction paying more than the static minimum relay fee but less
+	// than the dynamic minimum is rejected with the dynamic minimum in the
+	// error.
+	low := h.spendTx(t, colxutil.SatoshiPerBitcoin-1000, h.payScript)
+	_, err = mp.ProcessTransaction(low, false, false, nil)
+	if code, _ := extractRejectCode(err); code != wire.RejectInsufficientFee {
+		t.Fatalf("ProcessTransaction: unexpected error for low fee "+
+			"transaction - got %v, want code %v", err,
+			wire.RejectInsufficientFee)
+	}
+	if !strings.Contains(err.Error(), "under the dynamic minimum") {
+		t.Fatalf("ProcessTransaction: error does not mention the "+
+			"dynamic minimum fee: %v", err)
+	}
+
+	// The dynamic minimum fee decays back to the static minimum.
+	mp.dynamicMinFeeRate(time.Now().Add(10 * rollingFeeHalfLife))
+	if minFee := mp.MinRelayTxFee(); minFee != defaultMinRelayTxFee {
+		t.Fatalf("MinRelayTxFee: unexpected fee after decay - got %v, "+
+			"want %v", minFee, defaultMinRelayTxFee)
+	}
+
+	// A new transaction which is evicted right away since it has the
+	// lowest fee rate in the full pool is rejected without being
+	// notified as evicted.
+	evicted = nil
+	mp.cfg.Policy.MaxTxPoolSizeBytes = mp.poolBytes
+	tiny := h.spendTx(t, colxutil.SatoshiPerBitcoin-1000, h.payScript)
+	_, err = mp.ProcessTransaction(tiny, false, false, nil)
+	if code, _ := extractRejectCode(err); code != wire.RejectInsufficientFee {
+		t.Fatalf("ProcessTransaction: unexpected error for evicted "+
+			"transaction - got %v, want code %v", err,
+			wire.RejectInsufficientFee)
+	}
+	if evicted != nil {
+		t.Fatalf("rejected transaction was notified as evicted")
+	}
+}
+
+// TestTxVersionPolicy ensures transactions with versions above the configured
+// maximum are not accepted into the pool unless explicitly allowed, while
+// blocks which contain them are still accepted by the chain.
+func TestTxVersionPolicy(t *testing.T) {
+	// The version policy also applies to networks which relay non-standard
+	// transactions.
+	defer func(p *params) { activeNetParams = p }(activeNetParams)
+	activeNetParams = &regressionNetParams
+
+	h := newTemplateHarness(t, 3)
+	defer h.teardown()
+	mp := h.server.txMemPool
+	mp.cfg.Policy.MaxTxVersion = activeNetParams.MaxStandardTxVersion
+	mp.cfg.FetchUtxoView = h.server.blockManager.chain.FetchUtxoView
+
+	// newTx returns a transaction with the passed version which spends the
+	// passed output of the funding transaction.
+	newTx := func(version int32, index uint32) *colxutil.Tx {
+		fundingOut := h.fundingTx.MsgTx().TxOut[index]
+		msgTx := wire.NewMsgTx()
+		msgTx.Version = version
+		msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(h.fundingTx.Sha(),
+			index), nil))
+		msgTx.AddTxOut(wire.NewTxOut(fundingOut.Value-100000,
+			[]byte{txscript.OP_TRUE}))
+		return colxutil.NewTx(msgTx)
+	}
+
+	// A transaction with the highest defined version is accepted.
+	stdTx := newTx(mp.cfg.Policy.MaxTxVersion, 0)
+	if _, err := mp.ProcessTransaction(stdTx, false, false, nil); err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error for version %d: %v",
+			stdTx.MsgTx().Version, err)
+	}
+
+	// A transaction with an unknown future version is rejected as
+	// non-standard unless non-standard transactions are explicitly allowed,
+	// in which case it is not relayed.
+	futureTx := newTx(mp.cfg.Policy.MaxTxVersion+1, 1)
+	_, err := mp.ProcessTransaction(futureTx, false, false, nil)
+	if code, ok := extractRejectCode(err); !ok ||
+		code != wire.RejectNonstandard {
+
+		t.Fatalf("ProcessTransaction: unexpected error for version %d: "+
+			"%v", futureTx.MsgTx().Version, err)
+	}
+	if mp.HaveTransaction(futureTx.Sha()) {
+		t.Fatal("transaction with an unknown version was accepted")
+	}
+	allowedTx := newTx(mp.cfg.Policy.MaxTxVersion+1, 2)
+	_, err = mp.ProcessTransaction(allowedTx, false, false,
+		&txAcceptOptions{AcceptNonStd: true, AllowHighFees: true})
+	if err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error when non-standard "+
+			"transactions are allowed: %v", err)
+	}
+	if mp.IsRelayable(allowedTx.Sha()) {
+		t.Fatal("transaction with an unknown version is relayable")
+	}
+
+	// A block which contains the transaction with the unknown version is
+	// accepted by the chain.
+	policy := &mining.Policy{
+		BlockMaxSize: wire.MaxBlockPayload,
+		TxMinFreeFee: 1000,
+	}
+	template, err := NewBlockTemplate(policy, h.server, nil)
+	if err != nil {
+		t.Fatalf("NewBlockTemplate: unexpected error: %v", err)
+	}
+	msgBlock := template.Block
+	msgBlock.AddTransaction(futureTx.MsgTx())
+	txns := make([]*colxutil.Tx, 0, len(msgBlock.Transactions))
+	for _, msgTx := range msgBlock.Transactions {
+		txns = append(txns, colxutil.NewTx(msgTx))
+	}
+	merkles := blockchain.BuildMerkleTreeStore(txns)
+	msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+	block := colxutil.NewBlock(msgBlock)
+	chain := h.server.blockManager.chain
+	_, err = chain.ProcessBlock(block, blockchain.BFNoPoWCheck)
+	if err != nil {
+		t.Fatalf("ProcessBlock: unexpected error: %v", err)
+	}
+	if best := chain.BestSnapshot(); !best.Hash.IsEqual(block.Sha()) {
+		t.Fatalf("block with a transaction with an unknown version was "+
+			"not connected - best block %v, want %v", best.Hash,
+			block.Sha())
+	}
+}
+
+// TestTxnsByShortID ensures compact blocks are reconstructed from the
+// transactions in the pool.
+func TestTxnsByShortID(t *testing.T) {
+	h := newPoolHarness(t)
+	defer h.teardown()
+	mp := h.newPool()
+
+	// Add one of the two non-coinbase transactions of the block to the
+	// pool so only the other one has to be requested.
+	coinbase := wire.NewMsgTx()
+	coinbase.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: wire.MaxPrevOutIndex},
+		[]byte{0x51, 0x51}))
+	coinbase.AddTxOut(wire.NewTxOut(0, h.payScript))
+	inPool := h.spendTx(t, colxutil.SatoshiPerBitcoin-10000, h.payScript)
+	notInPool := h.spendTx(t, colxutil.SatoshiPerBitcoin-10000, h.payScript)
+	if _, err := mp.ProcessTransaction(inPool, false, false, nil); err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+
+	msgBlock := wire.NewMsgBlock(&activeNetParams.GenesisBlock.Header)
+	for _, tx := range []*wire.MsgTx{coinbase, inPool.MsgTx(),
+		notInPool.MsgTx()} {
+
+		msgBlock.AddTransaction(tx)
+	}
+	block := colxutil.NewBlock(msgBlock)
+	merkles := blockchain.BuildMerkleTreeStore(block.Transactions())
+	msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+
+	partial, err := blockchain.NewPartialBlock(
+		blockchain.NewCompactBlock(block, 42), mp)
+	if err != nil {
+		t.Fatalf("NewPartialBlock: unexpected error: %v", err)
+	}
+	if got := partial.MissingIndexes(); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("unexpected missing indexes - got %v, want [2]", got)
+	}
+	blockHash := block.Sha()
+	err = partial.FillMissing(wire.NewMsgBlockTxn(blockHash,
+		[]*wire.MsgTx{notInPool.MsgTx()}))
+	if err != nil {
+		t.Fatalf("FillMissing: unexpected error: %v", err)
+	}
+	reconstructed, err := partial.Block()
+	if err != nil {
+		t.Fatalf("Block: unexpected error: %v", err)
+	}
+	if !reconstructed.Sha().IsEqual(block.Sha()) {
+		t.Fatalf("reconstructed block %v does not match %v",
+			reconstructed.Sha(), block.Sha())
+	}
+}
+
+// TestPrioritiseTransaction ensures fee deltas are applied to transactions when
+// they arrive, survive the transactions leaving and re-entering the pool,
+// expire when the transactions do not arrive in time, and are bounded.
+func TestPrioritiseTransaction(t *testing.T) {
+	h := newPoolHarness(t)
+	defer h.teardown()
+	mp := h.newPool()
+
+	feeDelta := func(tx *colxutil.Tx) int64 {
+		entry, err := mp.SnapshotEntry(tx.Sha())
+		if err != nil {
+			t.Fatalf("SnapshotEntry: unexpected error: %v", err)
+		}
+		return entry.FeeDelta
+	}
+
+	// A fee delta set before the transaction arrives is applied once it
+	// does and is reflected in the mining descriptors.
+	tx := h.spendTx(t, colxutil.SatoshiPerBitcoin-10000, h.payScript)
+	if err := mp.PrioritiseTransaction(tx.Sha(), 7000); err != nil {
+		t.Fatalf("PrioritiseTransaction: unexpected error: %v", err)
+	}
+	if _, err := mp.ProcessTransaction(tx, false, false, nil); err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	if got := feeDelta(tx); got != 7000 {
+		t.Fatalf("unexpected fee delta - got %d, want 7000", got)
+	}
+	descs := mp.MiningDescs()
+	if len(descs) != 1 || descs[0].Fee != 10000 || descs[0].FeeDelta != 7000 {
+		t.Fatalf("unexpected mining descriptors %v", descs)
+	}
+
+	// The fee delta survives the transaction leaving the pool and being
+	// added back, but is gone once cleared.
+	mp.RemoveTransaction(tx, true)
+	if _, err := mp.ProcessTransaction(tx, false, false, nil); err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	if got := feeDelta(tx); got != 7000 {
+		t.Fatalf("unexpected fee delta after re-adding - got %d, want "+
+			"7000", got)
+	}
+	if err := mp.PrioritiseTransaction(tx.Sha(), 0); err != nil {
+		t.Fatalf("PrioritiseTransaction: unexpected error: %v", err)
+	}
+	if got := feeDelta(tx); got != 0 {
+		t.Fatalf("unexpected fee delta after clearing - got %d, want 0",
+			got)
+	}
+	if len(mp.feeDeltas) != 0 {
+		t.Fatalf("unexpected number of fee deltas after clearing - got "+
+			"%d, want 0", len(mp.feeDeltas))
+	}
+
+	// A fee delta for a transaction which does not arrive before it
+	// expires is not applied.
+	late := h.spendTx(t, colxutil.SatoshiPerBitcoin-10000, h.payScript)
+	if err := mp.PrioritiseTransaction(late.Sha(), 7000); err != nil {
+		t.Fatalf("PrioritiseTransaction: unexpected error: %v", err)
+	}
+	mp.feeDeltas[*late.Sha()].expiration = time.Now().Add(-time.Second)
+	if _, err := mp.ProcessTransaction(late, false, false, nil); err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	if got := feeDelta(late); got != 0 {
+		t.Fatalf("unexpected expired fee delta - got %d, want 0", got)
+	}
+
+	// Fee deltas for transactions which are not in the pool are refused
+	// once the maximum is reached, unless some of them have expired, while
+	// those for transactions in the pool are still accepted.
+	for i := 0; len(mp.feeDeltas) < maxFeeDeltas; i++ {
+		hash := wire.ShaHash{byte(i), byte(i >> 8), 0xff}
+		if err := mp.PrioritiseTransaction(&hash, 1); err != nil {
+			t.Fatalf("PrioritiseTransaction #%d: unexpected error: %v",
+				i, err)
+		}
+	}
+	unknown := wire.ShaHash{0xfe}
+	if err := mp.PrioritiseTransaction(&unknown, 1); err == nil {
+		t.Fatal("PrioritiseTransaction: fee delta accepted beyond the " +
+			"maximum")
+	}
+	if err := mp.PrioritiseTransaction(tx.Sha(), 1); err != nil {
+		t.Fatalf("PrioritiseTransaction: unexpected error for "+
+			"transaction in the pool: %v", err)
+	}
+	expired := wire.ShaHash{0, 0, 0xff}
+	mp.feeDeltas[expired].expiration = time.Now().Add(-time.Second)
+	if err := mp.PrioritiseTransaction(&unknown, 1); err != nil {
+		t.Fatalf("PrioritiseTransaction: unexpected error after "+
+			"expiration: %v", err)
+	}
+	if _, ok := mp.feeDeltas[expired]; ok {
+		t.Fatal("expired fee delta was not removed")
+	}
+}
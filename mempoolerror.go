@@ -34,8 +34,9 @@ func (e RuleError) Error() string {
 // specifically due to a rule violation and access the ErrorCode field to
 // ascertain the specific reason for the rule violation.
 type TxRuleError struct {
-	RejectCode  wire.RejectCode // The code to send with reject messages
-	Description string          // Human readable description of the issue
+	RejectCode    wire.RejectCode // The code to send with reject messages
+	Description   string          // Human readable description of the issue
+	MissingInputs []wire.OutPoint // The unknown outputs spent by an orphan
 }
 
 // Error satisfies the error interface and prints human-readable errors.
@@ -68,6 +69,12 @@ func extractRejectCode(err error) (wire.RejectCode, bool) {
 		err = rerr.Err
 	}
 
+	// Pull the violated rule out of the context of the transaction which
+	// caused a block to be rejected.
+	if txErr, ok := err.(blockchain.TxValidationError); ok {
+		err = txErr.RuleError
+	}
+
 	switch err := err.(type) {
 	case blockchain.RuleError:
 		// Convert the chain error to a reject code.
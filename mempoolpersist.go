@@ -0,0 +1,184 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+const (
+	// mempoolFileName is the name of the file the memory pool is saved to
+	// within the data directory.
+	mempoolFileName = "mempool.dat"
+
+	// mempoolFileVersion is the current version of the serialized memory
+	// pool format.
+	mempoolFileVersion = 1
+)
+
+// mempoolFilePath returns the path of the file the memory pool is saved to.
+func mempoolFilePath() string {
+	return filepath.Join(cfg.DataDir, mempoolFileName)
+}
+
+// sortTxDescsByDependency returns the passed descriptors ordered such that
+// every transaction comes after all of the other transactions in the slice it
+// spends outputs from.  This allows the transactions to be accepted into a
+// memory pool in order without any of them being orphans.
+func sortTxDescsByDependency(descs []*mempoolTxDesc) []*mempoolTxDesc {
+	byHash := make(map[wire.ShaHash]*mempoolTxDesc, len(descs))
+	for _, desc := range descs {
+		byHash[*desc.Tx.Sha()] = desc
+	}
+
+	sorted := make([]*mempoolTxDesc, 0, len(descs))
+	visited := make(map[wire.ShaHash]struct{}, len(descs))
+	var visit func(desc *mempoolTxDesc)
+	visit = func(desc *mempoolTxDesc) {
+		hash := *desc.Tx.Sha()
+		if _, ok := visited[hash]; ok {
+			return
+		}
+		visited[hash] = struct{}{}
+		for _, txIn := range desc.Tx.MsgTx().TxIn {
+			if parent, ok := byHash[txIn.PreviousOutPoint.Hash]; ok {
+				visit(parent)
+			}
+		}
+		sorted = append(sorted, desc)
+	}
+	for _, desc := range descs {
+		visit(desc)
+	}
+	return sorted
+}
+
+// SaveMempool serializes all of the transactions in the memory pool to the
+// passed writer in dependency order and returns the number of transactions
+// written.  The pool is only locked while taking a snapshot of its contents,
+// so serializing a large pool does not block the acceptance of new
+// transactions.
+//
+// The serialized format is:
+//
+//   <version><num txns><tx 1>...<tx n>
+//
+//   Field             Type     Size
+//   version           uint32   4 bytes
+//   num txns          VarInt   variable
+//   txns              MsgTx    variable
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) SaveMempool(w io.Writer) (int, error) {
+	descs := sortTxDescsByDependency(mp.TxDescs())
+
+	var version [4]byte
+	binary.LittleEndian.PutUint32(version[:], mempoolFileVersion)
+	if _, err := w.Write(version[:]); err != nil {
+		return 0, err
+	}
+	err := wire.WriteVarInt(w, 0, uint64(len(descs)))
+	if err != nil {
+		return 0, err
+	}
+	for _, desc := range descs {
+		if err := desc.Tx.MsgTx().Serialize(w); err != nil {
+			return 0, err
+		}
+	}
+	return len(descs), nil
+}
+
+// LoadMempool reads transactions serialized by SaveMempool from the passed
+// reader and attempts to add each of them to the memory pool with the passed
+// options.  Transactions which are no longer valid, such as those which were
+// mined while the pool was saved, are skipped.  It returns the number of
+// transactions which were accepted and the number which were skipped.  An
+// error is only returned when the data can't be read.
+//
+// The opts parameter must be nil to apply the full policy as if the
+// transactions were received from the network.  See txAcceptOptions for
+// details.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) LoadMempool(r io.Reader, opts *txAcceptOptions) (int, int, error) {
+	var version [4]byte
+	if _, err := io.ReadFull(r, version[:]); err != nil {
+		return 0, 0, err
+	}
+	if v := binary.LittleEndian.Uint32(version[:]); v != mempoolFileVersion {
+		return 0, 0, fmt.Errorf("unsupported mempool file version %d", v)
+	}
+	numTxns, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var accepted, skipped int
+	for i := uint64(0); i < numTxns; i++ {
+		var msgTx wire.MsgTx
+		if err := msgTx.Deserialize(r); err != nil {
+			return accepted, skipped, err
+		}
+		tx := colxutil.NewTx(&msgTx)
+		_, err := mp.ProcessTransaction(tx, false, false, opts)
+		if err != nil {
+			txmpLog.Debugf("Skipping saved transaction %v: %v",
+				tx.Sha(), err)
+			skipped++
+			continue
+		}
+		accepted++
+	}
+	return accepted, skipped, nil
+}
+
+// saveMempoolFile saves the passed memory pool to the file at the passed path
+// and returns the number of transactions saved.  The transactions are written
+// to a temporary file which then replaces any existing file so a failure part
+// way through does not leave a truncated file behind.
+func saveMempoolFile(mp *txMemPool, path string) (int, error) {
+	tmpPath := path + ".new"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	numTxns, err := mp.SaveMempool(f)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	return numTxns, nil
+}
+
+// loadMempoolFile loads the transactions saved to the file at the passed path
+// into the passed memory pool.  See LoadMempool for details.
+func loadMempoolFile(mp *txMemPool, path string, opts *txAcceptOptions) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	return mp.LoadMempool(f, opts)
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/btcjson"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestSaveImportMempool ensures the contents of a memory pool which includes
+// a chain of dependent transactions survive a round trip through the
+// savemempool and importmempool handlers and are reported by getmempoolinfo.
+func TestSaveImportMempool(t *testing.T) {
+	h := newPoolHarness(t)
+	defer h.teardown()
+
+	dataDir, err := ioutil.TempDir("", "mempoolpersist")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+	defer func(c *config) { cfg = c }(cfg)
+	cfg = &config{DataDir: dataDir}
+
+	// Populate the pool with an independent transaction and a chain of
+	// transactions where each one spends the output of the previous.
+	const fee = 10000
+	mp := h.newPool()
+	txns := []*colxutil.Tx{h.spendTx(t, colxutil.SatoshiPerBitcoin-fee,
+		h.payScript)}
+	parent := h.spendTx(t, colxutil.SatoshiPerBitcoin-fee, h.payScript)
+	txns = append(txns, parent)
+	for i := 0; i < 3; i++ {
+		tx := wire.NewMsgTx()
+		prevOut := wire.NewOutPoint(parent.Sha(), 0)
+		tx.AddTxIn(wire.NewTxIn(prevOut, nil))
+		tx.AddTxOut(wire.NewTxOut(parent.MsgTx().TxOut[0].Value-fee,
+			h.payScript))
+		sigScript, err := txscript.SignatureScript(tx, 0, h.payScript,
+			txscript.SigHashAll, h.privKey, true)
+		if err != nil {
+			t.Fatalf("unable to sign transaction: %v", err)
+		}
+		tx.TxIn[0].SignatureScript = sigScript
+		parent = colxutil.NewTx(tx)
+		txns = append(txns, parent)
+	}
+	for _, tx := range txns {
+		_, err := mp.ProcessTransaction(tx, false, false, nil)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+		}
+	}
+
+	// Mark the first transaction as locally submitted so the unbroadcast
+	// count is not zero.
+	mp.AddUnbroadcast(txns[0])
+	s := &server{txMemPool: mp}
+	rpcSrv := &rpcServer{server: s}
+
+	result, err := handleGetMempoolInfo(rpcSrv, &btcjson.GetMempoolInfoCmd{}, nil)
+	if err != nil {
+		t.Fatalf("getmempoolinfo: unexpected error: %v", err)
+	}
+	info := result.(*btcjson.GetMempoolInfoResult)
+	if info.Size != int64(len(txns)) {
+		t.Fatalf("getmempoolinfo: unexpected size - got %d, want %d",
+			info.Size, len(txns))
+	}
+	if info.Usage < info.Bytes {
+		t.Fatalf("getmempoolinfo: usage %d is less than the size of "+
+			"the transactions %d", info.Usage, info.Bytes)
+	}
+	if info.UnbroadcastCount != 1 {
+		t.Fatalf("getmempoolinfo: unexpected unbroadcast count - got "+
+			"%d, want 1", info.UnbroadcastCount)
+	}
+	if info.MempoolMinFee != defaultMinRelayTxFee.ToBTC() {
+		t.Fatalf("getmempoolinfo: unexpected min fee - got %v, want "+
+			"%v", info.MempoolMinFee, defaultMinRelayTxFee.ToBTC())
+	}
+
+	result, err = handleSaveMempool(rpcSrv, &btcjson.SaveMempoolCmd{}, nil)
+	if err != nil {
+		t.Fatalf("savemempool: unexpected error: %v", err)
+	}
+	path := result.(*btcjson.SaveMempoolResult).Filename
+	if path != filepath.Join(dataDir, mempoolFileName) {
+		t.Fatalf("savemempool: unexpected filename %q", path)
+	}
+
+	// The saved transactions must be in dependency order.
+	serialized, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unable to read saved mempool: %v", err)
+	}
+	r := bytes.NewReader(serialized[4:])
+	numTxns, err := wire.ReadVarInt(r, 0)
+	if err != nil || numTxns != uint64(len(txns)) {
+		t.Fatalf("unexpected number of saved txns - got %d (%v), "+
+			"want %d", numTxns, err, len(txns))
+	}
+	seen := make(map[wire.ShaHash]struct{})
+	for i := uint64(0); i < numTxns; i++ {
+		var msgTx wire.MsgTx
+		if err := msgTx.Deserialize(r); err != nil {
+			t.Fatalf("unable to deserialize saved tx: %v", err)
+		}
+		prevHash := msgTx.TxIn[0].PreviousOutPoint.Hash
+		if prevHash != *h.fundingTx.Sha() {
+			if _, ok := seen[prevHash]; !ok {
+				t.Fatalf("saved tx %v precedes its parent %v",
+					msgTx.TxSha(), prevHash)
+			}
+		}
+		seen[msgTx.TxSha()] = struct{}{}
+	}
+
+	// Import the saved transactions into an empty pool and ensure all of
+	// them are accepted, including the dependent ones.
+	s.txMemPool = h.newPool()
+	importCmd := btcjson.NewImportMempoolCmd(path, nil)
+	result, err = handleImportMempool(rpcSrv, importCmd, nil)
+	if err != nil {
+		t.Fatalf("importmempool: unexpected error: %v", err)
+	}
+	importResult := result.(*btcjson.ImportMempoolResult)
+	if importResult.Accepted != int64(len(txns)) ||
+		importResult.Skipped != 0 {
+		t.Fatalf("importmempool: unexpected result - got %+v",
+			importResult)
+	}
+	for _, tx := range txns {
+		if !s.txMemPool.IsTransactionInPool(tx.Sha()) {
+			t.Fatalf("importmempool: tx %v is not in the pool",
+				tx.Sha())
+		}
+	}
+
+	// Importing the same file again must skip every transaction since
+	// they are already in the pool.
+	result, err = handleImportMempool(rpcSrv, importCmd, nil)
+	if err != nil {
+		t.Fatalf("importmempool: unexpected error: %v", err)
+	}
+	importResult = result.(*btcjson.ImportMempoolResult)
+	if importResult.Accepted != 0 ||
+		importResult.Skipped != int64(len(txns)) {
+		t.Fatalf("importmempool: unexpected result on reimport - got "+
+			"%+v", importResult)
+	}
+
+	// A missing file must be reported as an RPC error.
+	importCmd = btcjson.NewImportMempoolCmd(path+".missing", nil)
+	_, err = handleImportMempool(rpcSrv, importCmd, nil)
+	if _, ok := err.(*btcjson.RPCError); !ok {
+		t.Fatalf("importmempool: expected RPC error for missing file "+
+			"- got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// mempoolSnapshotEntry describes a transaction in the memory pool as of the
+// time a snapshot was taken.  It must not be modified.
+type mempoolSnapshotEntry struct {
+	// Tx is the transaction.  It is shared with the memory pool and must
+	// not be modified.
+	Tx *colxutil.Tx
+
+	// Hash is the hash of the transaction.
+	Hash wire.ShaHash
+
+	// Size is the serialized size of the transaction in bytes.
+	Size int64
+
+	// Fee is the total fee in Satoshi the transaction pays.
+	Fee int64
+
+	// FeeRate is the fee rate of the transaction in Satoshi/1000 bytes.
+	FeeRate int64
+
+	// FeeDelta is the fee delta in Satoshi set for the transaction via the
+	// prioritisetransaction RPC.  It only applies when selecting
+	// transactions for block templates.
+	FeeDelta int64
+
+	// Added is the time the transaction was added to the pool.
+	Added time.Time
+
+	// Height is the best block height when the transaction was added to
+	// the pool.
+	Height int32
+
+	// StartingPriority is the priority of the transaction when it was
+	// added to the pool.
+	StartingPriority float64
+
+	// Depends houses the hashes of the transactions in the pool which the
+	// transaction spends outputs of.
+	Depends []wire.ShaHash
+
+	// Unbroadcast indicates the transaction was submitted locally and has
+	// not been requested by any peer yet.
+	Unbroadcast bool
+}
+
+// mempoolSnapshot is an immutable view of the transactions in the memory pool
+// along with aggregate statistics about them.  Since it does not reference
+// any mutable state of the pool, it may be read without any locking once it
+// has been taken.
+type mempoolSnapshot struct {
+	// Entries houses the transactions in the pool in no particular order.
+	Entries []mempoolSnapshotEntry
+
+	// Bytes is the total serialized size of the transactions.
+	Bytes int64
+
+	// TotalFee is the total fee in Satoshi paid by the transactions.
+	TotalFee int64
+
+	// Taken is the time the snapshot was taken.
+	Taken time.Time
+}
+
+// Count returns the number of transactions in the snapshot.
+func (s *mempoolSnapshot) Count() int {
+	return len(s.Entries)
+}
+
+// containsHash returns whether the passed hashes include the passed hash.
+func containsHash(hashes []wire.ShaHash, hash *wire.ShaHash) bool {
+	for i := range hashes {
+		if hashes[i] == *hash {
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshot returns an immutable snapshot of the transactions in the pool.  It
+// is built in a single pass while holding the mempool lock for reads, so its
+// cost, and the time it delays the acceptance of new transactions, is linear
+// in the number of transactions in the pool.  It does not include the orphan
+// pool.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) Snapshot() *mempoolSnapshot {
+	mp.RLock()
+	defer mp.RUnlock()
+
+	snapshot := &mempoolSnapshot{
+		Entries: make([]mempoolSnapshotEntry, 0, len(mp.pool)),
+		Taken:   time.Now(),
+	}
+	for hash, desc := range mp.pool {
+		entry := mp.snapshotEntry(hash, desc)
+		snapshot.Entries = append(snapshot.Entries, entry)
+		snapshot.Bytes += entry.Size
+		snapshot.TotalFee += desc.Fee
+	}
+
+	return snapshot
+}
+
+// snapshotEntry returns the snapshot entry describing the passed transaction
+// in the pool.
+//
+// This function MUST be called with the mempool lock held (for reads).
+func (mp *txMemPool) snapshotEntry(hash wire.ShaHash, desc *mempoolTxDesc) mempoolSnapshotEntry {
+	msgTx := desc.Tx.MsgTx()
+	size := int64(msgTx.SerializeSize())
+	entry := mempoolSnapshotEntry{
+		Tx:               desc.Tx,
+		Hash:             hash,
+		Size:             size,
+		Fee:              desc.Fee,
+		FeeRate:          desc.Fee * 1000 / size,
+		FeeDelta:         desc.FeeDelta,
+		Added:            desc.Added,
+		Height:           desc.Height,
+		StartingPriority: desc.StartingPriority,
+		Unbroadcast:      mp.isUnbroadcast(&hash),
+	}
+	for _, txIn := range msgTx.TxIn {
+		parentHash := txIn.PreviousOutPoint.Hash
+		if _, ok := mp.pool[parentHash]; !ok {
+			continue
+		}
+		if !containsHash(entry.Depends, &parentHash) {
+			entry.Depends = append(entry.Depends, parentHash)
+		}
+	}
+	return entry
+}
+
+// SnapshotEntry returns an immutable snapshot entry describing the transaction
+// with the passed hash in the pool.  It returns an error when the transaction
+// is not in the pool.
+//
+// This function is safe for concurrent access.
+func (mp *txMemPool) SnapshotEntry(hash *wire.ShaHash) (*mempoolSnapshotEntry, error) {
+	mp.RLock()
+	defer mp.RUnlock()
+
+	desc, ok := mp.pool[*hash]
+	if !ok {
+		return nil, fmt.Errorf("transaction is not in the pool")
+	}
+	entry := mp.snapshotEntry(*hash, desc)
+	return &entry, nil
+}
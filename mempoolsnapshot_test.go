@@ -0,0 +1,146 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/mining"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestMempoolSnapshot ensures a snapshot describes the transactions in the
+// pool along with their dependencies and is unaffected by later changes to
+// the pool.
+func TestMempoolSnapshot(t *testing.T) {
+	h := newPoolHarness(t)
+	defer h.teardown()
+	mp := h.newPool()
+
+	parent := h.spendTx(t, colxutil.SatoshiPerBitcoin-10000, h.payScript)
+	child := h.chainedTx(t, parent, parent.MsgTx().TxOut[0].Value-5000)
+	for _, tx := range []*colxutil.Tx{parent, child} {
+		_, err := mp.ProcessTransaction(tx, false, false, nil)
+		if err != nil {
+			t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+		}
+	}
+	mp.AddUnbroadcast(parent)
+
+	snapshot := mp.Snapshot()
+	if snapshot.Count() != 2 {
+		t.Fatalf("Count: unexpected number of entries - got %d, want 2",
+			snapshot.Count())
+	}
+	wantBytes := int64(parent.MsgTx().SerializeSize() +
+		child.MsgTx().SerializeSize())
+	if snapshot.Bytes != wantBytes {
+		t.Fatalf("unexpected total size - got %d, want %d",
+			snapshot.Bytes, wantBytes)
+	}
+	if snapshot.TotalFee != 15000 {
+		t.Fatalf("unexpected total fee - got %d, want 15000",
+			snapshot.TotalFee)
+	}
+
+	entries := make(map[wire.ShaHash]*mempoolSnapshotEntry)
+	for i := range snapshot.Entries {
+		entries[snapshot.Entries[i].Hash] = &snapshot.Entries[i]
+	}
+	tests := []struct {
+		tx          *colxutil.Tx
+		fee         int64
+		depends     []wire.ShaHash
+		unbroadcast bool
+	}{
+		{tx: parent, fee: 10000, unbroadcast: true},
+		{tx: child, fee: 5000, depends: []wire.ShaHash{*parent.Sha()}},
+	}
+	for _, test := range tests {
+		entry, ok := entries[*test.tx.Sha()]
+		if !ok {
+			t.Fatalf("transaction %v is missing from the snapshot",
+				test.tx.Sha())
+		}
+		size := int64(test.tx.MsgTx().SerializeSize())
+		if entry.Size != size || entry.Fee != test.fee ||
+			entry.FeeRate != test.fee*1000/size {
+
+			t.Errorf("%v: unexpected size, fee, or fee rate - got "+
+				"%d, %d, %d, want %d, %d, %d", entry.Hash,
+				entry.Size, entry.Fee, entry.FeeRate, size,
+				test.fee, test.fee*1000/size)
+		}
+		if len(entry.Depends) != len(test.depends) ||
+			(len(test.depends) > 0 &&
+				entry.Depends[0] != test.depends[0]) {
+
+			t.Errorf("%v: unexpected dependencies - got %v, want %v",
+				entry.Hash, entry.Depends, test.depends)
+		}
+		if entry.Unbroadcast != test.unbroadcast {
+			t.Errorf("%v: unexpected unbroadcast flag - got %v, "+
+				"want %v", entry.Hash, entry.Unbroadcast,
+				test.unbroadcast)
+		}
+	}
+
+	// Removing transactions from the pool does not affect the snapshot.
+	mp.RemoveTransaction(parent, true)
+	if mp.Count() != 0 {
+		t.Fatalf("transactions were not removed from the pool")
+	}
+	if snapshot.Count() != 2 || len(entries[*child.Sha()].Depends) != 1 {
+		t.Fatalf("snapshot was modified by removing transactions")
+	}
+}
+
+// newBenchPool returns a memory pool which contains the passed number of
+// transactions.  Every other transaction spends an output of the one before
+// it.  The transactions are added directly without validation.
+func newBenchPool(numTxns int) *txMemPool {
+	mp := newTxMemPool(&mempoolConfig{})
+	var prevHash wire.ShaHash
+	for i := 0; i < numTxns; i++ {
+		tx := wire.NewMsgTx()
+		prevOut := wire.OutPoint{Index: uint32(i)}
+		if i%2 == 1 {
+			prevOut.Hash = prevHash
+		}
+		tx.AddTxIn(wire.NewTxIn(&prevOut, make([]byte, 107)))
+		tx.AddTxOut(wire.NewTxOut(100000, make([]byte, 25)))
+		utilTx := colxutil.NewTx(tx)
+		mp.pool[*utilTx.Sha()] = &mempoolTxDesc{
+			TxDesc: mining.TxDesc{
+				Tx:    utilTx,
+				Added: time.Now(),
+				Fee:   1000,
+			},
+		}
+		mp.outpoints[prevOut] = utilTx
+		prevHash = *utilTx.Sha()
+	}
+	return mp
+}
+
+// BenchmarkMempoolSnapshot benchmarks taking a snapshot of pools of various
+// sizes.  The time per operation is also the time the acceptance of new
+// transactions is delayed by the snapshot, so it should scale linearly with
+// the number of transactions.
+func BenchmarkMempoolSnapshot(b *testing.B) {
+	for _, numTxns := range []int{1000, 10000, 50000} {
+		mp := newBenchPool(numTxns)
+		b.Run(fmt.Sprintf("%d", numTxns), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				mp.Snapshot()
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// txAcceptStage identifies a stage of the pipeline transactions go through
+// when they are considered for acceptance into the memory pool.
+type txAcceptStage int
+
+// These constants define the stages of the transaction acceptance pipeline.
+const (
+	// txStageSanity covers the duplicate, context-free sanity, and
+	// coinbase checks.
+	txStageSanity txAcceptStage = iota
+
+	// txStageFinality covers the lock time and finality checks.
+	txStageFinality
+
+	// txStageStandardness covers the checks which ensure the transaction
+	// and its inputs are standard.
+	txStageStandardness
+
+	// txStageFetchInputs covers detecting double spends within the pool
+	// and fetching the outputs referenced by the transaction inputs.
+	txStageFetchInputs
+
+	// txStageFeeChecks covers the input amount checks along with the
+	// minimum fee, priority, absurd fee, and rate limiting checks.
+	txStageFeeChecks
+
+	// txStageSigOps covers counting the signature operations.
+	txStageSigOps
+
+	// txStageScripts covers validating the transaction scripts.
+	txStageScripts
+
+	// numTxAcceptStages is the number of transaction acceptance stages.
+	// It MUST be the last entry.
+	numTxAcceptStages
+)
+
+// txAcceptStageStrings is a map of transaction acceptance stages back to their
+// names for pretty printing.
+var txAcceptStageStrings = map[txAcceptStage]string{
+	txStageSanity:       "sanity",
+	txStageFinality:     "finality",
+	txStageStandardness: "standardness",
+	txStageFetchInputs:  "fetch-inputs",
+	txStageFeeChecks:    "fee-checks",
+	txStageSigOps:       "sigops",
+	txStageScripts:      "scripts",
+}
+
+// String returns the txAcceptStage as a human-readable name.
+func (s txAcceptStage) String() string {
+	if str, ok := txAcceptStageStrings[s]; ok {
+		return str
+	}
+	return "unknown"
+}
+
+// txStageCounters houses the counters for a single transaction acceptance
+// stage.  The fields must only be accessed atomically.
+type txStageCounters struct {
+	reached  uint64
+	rejected uint64
+	nanos    uint64
+}
+
+// txAcceptCounters houses the counters for the transaction acceptance
+// pipeline.  The fields must only be accessed atomically since they are
+// read without the mempool lock.
+type txAcceptCounters struct {
+	processed uint64
+	accepted  uint64
+	orphans   uint64
+	stages    [numTxAcceptStages]txStageCounters
+}
+
+// txStageTracker tracks the stages a single transaction goes through as it is
+// considered for acceptance and accumulates the results into the counters
+// once it finishes.
+type txStageTracker struct {
+	counters *txAcceptCounters
+	stage    txAcceptStage
+	reached  uint32
+	start    time.Time
+}
+
+// track returns a tracker for a new transaction which starts in the sanity
+// stage.
+func (c *txAcceptCounters) track() txStageTracker {
+	t := txStageTracker{counters: c, stage: txStageSanity}
+	t.reached = 1 << uint(txStageSanity)
+	t.start = time.Now()
+	return t
+}
+
+// enter records the time spent in the current stage and moves the transaction
+// to the passed stage.  Stages may be entered more than once, in which case the
+// time spent in each visit is accumulated, but the stage is only counted as
+// reached once.
+func (t *txStageTracker) enter(stage txAcceptStage) {
+	now := time.Now()
+	counters := &t.counters.stages[t.stage]
+	atomic.AddUint64(&counters.nanos, uint64(now.Sub(t.start)))
+	t.stage = stage
+	t.reached |= 1 << uint(stage)
+	t.start = now
+}
+
+// finish records the time spent in the current stage along with the outcome
+// for the transaction.  A rejection is attributed to the current stage.
+func (t *txStageTracker) finish(missingParents []*wire.ShaHash, err error) {
+	t.enter(t.stage)
+
+	c := t.counters
+	atomic.AddUint64(&c.processed, 1)
+	for stage := txAcceptStage(0); stage < numTxAcceptStages; stage++ {
+		if t.reached&(1<<uint(stage)) != 0 {
+			atomic.AddUint64(&c.stages[stage].reached, 1)
+		}
+	}
+	switch {
+	case err != nil:
+		atomic.AddUint64(&c.stages[t.stage].rejected, 1)
+	case len(missingParents) > 0:
+		atomic.AddUint64(&c.orphans, 1)
+	default:
+		atomic.AddUint64(&c.accepted, 1)
+	}
+}
+
+// txStageStats describes the statistics for a single transaction acceptance
+// stage.
+type txStageStats struct {
+	// Stage is the stage the statistics are for.
+	Stage txAcceptStage
+
+	// Reached is the number of transactions which reached the stage.
+	Reached uint64
+
+	// Rejected is the number of transactions which were rejected by the
+	// stage.
+	Rejected uint64
+
+	// TotalTime is the total time spent in the stage.
+	TotalTime time.Duration
+}
+
+// AvgTime returns the average time a transaction which reached the stage spent
+// in it.
+func (s *txStageStats) AvgTime() time.Duration {
+	if s.Reached == 0 {
+		return 0
+	}
+	return s.TotalTime / time.Duration(s.Reached)
+}
+
+// txAcceptStats describes the statistics for the transaction acceptance
+// pipeline since the memory pool was created.
+type txAcceptStats struct {
+	// Processed is the number of transactions considered for acceptance.
+	Processed uint64
+
+	// Accepted is the number of transactions accepted into the pool.
+	Accepted uint64
+
+	// Orphans is the number of transactions which were found to be
+	// orphans.
+	Orphans uint64
+
+	// Stages houses the statistics for each stage indexed by stage.
+	Stages [numTxAcceptStages]txStageStats
+}
+
+// Rejected returns the total number of transactions which were rejected.
+func (s *txAcceptStats) Rejected() uint64 {
+	var rejected uint64
+	for i := range s.Stages {
+		rejected += s.Stages[i].Rejected
+	}
+	return rejected
+}
+
+// snapshot returns the current values of the counters.  The individual values
+// are read atomically, but the snapshot as a whole may be slightly
+// inconsistent when transactions are concurrently being processed.
+func (c *txAcceptCounters) snapshot() *txAcceptStats {
+	stats := &txAcceptStats{
+		Processed: atomic.LoadUint64(&c.processed),
+		Accepted:  atomic.LoadUint64(&c.accepted),
+		Orphans:   atomic.LoadUint64(&c.orphans),
+	}
+	for i := range c.stages {
+		counters := &c.stages[i]
+		stats.Stages[i] = txStageStats{
+			Stage:     txAcceptStage(i),
+			Reached:   atomic.LoadUint64(&counters.reached),
+			Rejected:  atomic.LoadUint64(&counters.rejected),
+			TotalTime: time.Duration(atomic.LoadUint64(&counters.nanos)),
+		}
+	}
+	return stats
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestAcceptanceStats ensures the transaction acceptance statistics attribute
+// accepted, orphan, and rejected transactions to the expected stages of the
+// acceptance pipeline.
+func TestAcceptanceStats(t *testing.T) {
+	h := newPoolHarness(t)
+	defer h.teardown()
+	mp := h.newPool()
+
+	const fee = 10000
+	process := func(name string, tx *colxutil.Tx, wantErr bool) {
+		_, err := mp.ProcessTransaction(tx, true, false, nil)
+		if wantErr && err == nil {
+			t.Fatalf("%s: ProcessTransaction: unexpectedly accepted "+
+				"tx", name)
+		}
+		if !wantErr && err != nil {
+			t.Fatalf("%s: ProcessTransaction: unexpected error: %v",
+				name, err)
+		}
+	}
+
+	// Sanity: a transaction without any inputs.
+	noInputs := wire.NewMsgTx()
+	noInputs.AddTxOut(wire.NewTxOut(fee, h.payScript))
+	process("sanity", colxutil.NewTx(noInputs), true)
+
+	// Finality: a transaction with a lock time after the maximum int32.
+	nonFinal := h.spendTx(t, colxutil.SatoshiPerBitcoin-fee, h.payScript)
+	nonFinal.MsgTx().LockTime = math.MaxInt32 + 1
+	process("finality", colxutil.NewTx(nonFinal.MsgTx()), true)
+
+	// Standardness: a transaction paying to a non-standard script.
+	nonStdScript := []byte{txscript.OP_TRUE}
+	nonStd := h.spendTx(t, colxutil.SatoshiPerBitcoin-fee, nonStdScript)
+	process("standardness", nonStd, true)
+
+	// Accepted: a standard transaction paying a fee.  It is then double
+	// spent by another transaction spending the same output which is
+	// rejected when fetching the inputs.
+	stdTx := h.spendTx(t, colxutil.SatoshiPerBitcoin-fee, h.payScript)
+	process("accepted", stdTx, false)
+	h.nextOutput--
+	doubleSpend := h.spendTx(t, colxutil.SatoshiPerBitcoin-2*fee,
+		h.payScript)
+	process("fetch-inputs", doubleSpend, true)
+
+	// Orphan: a transaction spending an unknown transaction.
+	orphan := wire.NewMsgTx()
+	orphan.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: wire.ShaHash{0x01}},
+		nil))
+	orphan.AddTxOut(wire.NewTxOut(colxutil.SatoshiPerBitcoin, h.payScript))
+	process("orphan", colxutil.NewTx(orphan), false)
+
+	// Fee checks: a transaction spending more than its input is worth.
+	overSpend := h.spendTx(t, colxutil.SatoshiPerBitcoin+1, h.payScript)
+	process("fee-checks", overSpend, true)
+
+	// Sigops: a transaction with more signature operations than allowed by
+	// the temporarily lowered policy limit.
+	maxSigOps := mp.cfg.Policy.MaxSigOpsPerTx
+	mp.cfg.Policy.MaxSigOpsPerTx = 0
+	sigOps := h.spendTx(t, colxutil.SatoshiPerBitcoin-fee, h.payScript)
+	process("sigops", sigOps, true)
+	mp.cfg.Policy.MaxSigOpsPerTx = maxSigOps
+
+	// Scripts: a transaction which was modified after it was signed.
+	badSig := h.spendTx(t, colxutil.SatoshiPerBitcoin-fee, h.payScript)
+	badSig.MsgTx().TxOut[0].Value--
+	process("scripts", colxutil.NewTx(badSig.MsgTx()), true)
+
+	stats := mp.AcceptanceStats()
+	if stats.Processed != 9 || stats.Accepted != 1 || stats.Orphans != 1 ||
+		stats.Rejected() != 7 {
+
+		t.Fatalf("unexpected totals - got processed %d, accepted %d, "+
+			"orphans %d, rejected %d, want 9, 1, 1, 7",
+			stats.Processed, stats.Accepted, stats.Orphans,
+			stats.Rejected())
+	}
+
+	wantReached := [numTxAcceptStages]uint64{
+		txStageSanity:       9,
+		txStageFinality:     8,
+		txStageStandardness: 7,
+		txStageFetchInputs:  6,
+		txStageFeeChecks:    4,
+		txStageSigOps:       3,
+		txStageScripts:      2,
+	}
+	for i := range stats.Stages {
+		stage := &stats.Stages[i]
+		if stage.Stage != txAcceptStage(i) {
+			t.Errorf("stage %d: unexpected stage %v", i, stage.Stage)
+		}
+		if stage.Reached != wantReached[i] {
+			t.Errorf("stage %v: unexpected reached count - got %d, "+
+				"want %d", stage.Stage, stage.Reached,
+				wantReached[i])
+		}
+		if stage.Rejected != 1 {
+			t.Errorf("stage %v: unexpected rejected count - got %d, "+
+				"want 1", stage.Stage, stage.Rejected)
+		}
+	}
+}
+
+// TestAcceptStageStringer tests the stringized output for the
+// txAcceptStage type.
+func TestAcceptStageStringer(t *testing.T) {
+	tests := []struct {
+		in   txAcceptStage
+		want string
+	}{
+		{txStageSanity, "sanity"},
+		{txStageFinality, "finality"},
+		{txStageStandardness, "standardness"},
+		{txStageFetchInputs, "fetch-inputs"},
+		{txStageFeeChecks, "fee-checks"},
+		{txStageSigOps, "sigops"},
+		{txStageScripts, "scripts"},
+		{numTxAcceptStages, "unknown"},
+	}
+
+	// Detect additional stages that don't have the stringer added.
+	if len(tests)-1 != int(numTxAcceptStages) {
+		t.Errorf("It appears a stage was added without adding an " +
+			"associated stringer test")
+	}
+
+	for i, test := range tests {
+		if got := test.in.String(); got != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, got,
+				test.want)
+		}
+	}
+}
+
+// BenchmarkAcceptanceStats benchmarks the overhead of tracking a transaction
+// through every stage of the acceptance pipeline.
+func BenchmarkAcceptanceStats(b *testing.B) {
+	var counters txAcceptCounters
+	rejectErr := errors.New("rejected")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		track := counters.track()
+		for stage := txStageFinality; stage < numTxAcceptStages; stage++ {
+			track.enter(stage)
+		}
+		if i%2 == 0 {
+			track.finish(nil, rejectErr)
+		} else {
+			track.finish(nil, nil)
+		}
+	}
+}
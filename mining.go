@@ -6,9 +6,10 @@
 package main
 
 import (
+	"bytes"
 	"container/heap"
-	"container/list"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/tinhnguyenhn/colxd/blockchain"
@@ -47,7 +48,21 @@ const (
 type txPrioItem struct {
 	tx       *colxutil.Tx
 	fee      int64
+	size     int64
 	priority float64
+
+	// modifiedFee is the fee of the transaction adjusted by its fee delta,
+	// such as one set via the prioritisetransaction RPC.  It is only used
+	// to select transactions, while fee is the fee the transaction
+	// actually pays.
+	modifiedFee int64
+
+	// feePerKB is the modified fee per kilobyte of the transaction along
+	// with its ancestors which have not been included in the block yet.
+	// This allows a transaction which pays a high fee to pull the low-fee
+	// transactions it depends on into the block (child pays for parent).
+	// It is the fee per kilobyte of the transaction itself once it does
+	// not have any such ancestors.
 	feePerKB int64
 
 	// dependsOn holds a map of transaction hashes which this one depends
@@ -55,6 +70,107 @@ type txPrioItem struct {
 	// transactions in the source pool and hence must come after them in
 	// a block.
 	dependsOn map[wire.ShaHash]struct{}
+
+	// ancestors and descendants hold the transactions in the source pool
+	// which this one depends on and which depend on this one, either
+	// directly or indirectly, and have not been included in the block
+	// yet.  ancestorFee and ancestorSize are the total modified fees and
+	// serialized size of the transaction along with its ancestors.
+	ancestors    map[wire.ShaHash]*txPrioItem
+	descendants  map[wire.ShaHash]*txPrioItem
+	ancestorFee  int64
+	ancestorSize int64
+
+	// index is the index of the item in the priority queue, or -1 when it
+	// is not in the queue.
+	index int
+}
+
+// updateFeePerKB recalculates the fee per kilobyte of the item from the total
+// fees and size of the transaction along with its ancestors.
+func (item *txPrioItem) updateFeePerKB() {
+	item.feePerKB = (item.ancestorFee * 1000) / item.ancestorSize
+}
+
+// packageTxns returns the transaction along with its ancestors which have not
+// been included in the block yet in the order they must be added to it.
+func (item *txPrioItem) packageTxns() []*txPrioItem {
+	pkg := make([]*txPrioItem, 0, len(item.ancestors)+1)
+	for _, ancestor := range item.ancestors {
+		pkg = append(pkg, ancestor)
+	}
+	sort.Sort(txPrioItemsByAncestry(pkg))
+	return append(pkg, item)
+}
+
+// txPrioItemsByAncestry provides sorting of transactions such that every one
+// comes after all of its ancestors.  Since the ancestors of a transaction are
+// also ancestors of every transaction which depends on it, this is the case
+// when they are sorted by their number of ancestors.  Transactions with the
+// same number of ancestors are sorted by their hash so the order is
+// deterministic.
+type txPrioItemsByAncestry []*txPrioItem
+
+// Len returns the number of transactions in the slice.  It is part of the
+// sort.Interface implementation.
+func (s txPrioItemsByAncestry) Len() int {
+	return len(s)
+}
+
+// Swap swaps the transactions at the passed indices.  It is part of the
+// sort.Interface implementation.
+func (s txPrioItemsByAncestry) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Less returns whether the transaction with index i should sort before the
+// transaction with index j.  It is part of the sort.Interface implementation.
+func (s txPrioItemsByAncestry) Less(i, j int) bool {
+	if len(s[i].ancestors) == len(s[j].ancestors) {
+		return bytes.Compare(s[i].tx.Sha()[:], s[j].tx.Sha()[:]) < 0
+	}
+	return len(s[i].ancestors) < len(s[j].ancestors)
+}
+
+// resolveAncestry populates the ancestors of the passed item from the passed
+// candidate transactions, adds the item as a descendant of each of them, and
+// calculates its fee per kilobyte along with them.  The item is removed from
+// the candidates when it depends on a transaction which is not a candidate,
+// either directly or through its ancestors, since it can't be included in the
+// block in that case.  It returns whether the item is still a candidate.
+func resolveAncestry(item *txPrioItem, candidates map[wire.ShaHash]*txPrioItem) bool {
+	// The ancestors of items which are still candidates are only resolved
+	// once.
+	if item.ancestors != nil {
+		return true
+	}
+
+	ancestors := make(map[wire.ShaHash]*txPrioItem)
+	for parentHash := range item.dependsOn {
+		parent, ok := candidates[parentHash]
+		if !ok || !resolveAncestry(parent, candidates) {
+			delete(candidates, *item.tx.Sha())
+			return false
+		}
+		ancestors[parentHash] = parent
+		for hash, ancestor := range parent.ancestors {
+			ancestors[hash] = ancestor
+		}
+	}
+
+	item.ancestors = ancestors
+	item.ancestorFee = item.modifiedFee
+	item.ancestorSize = item.size
+	for _, ancestor := range ancestors {
+		item.ancestorFee += ancestor.modifiedFee
+		item.ancestorSize += ancestor.size
+		if ancestor.descendants == nil {
+			ancestor.descendants = make(map[wire.ShaHash]*txPrioItem)
+		}
+		ancestor.descendants[*item.tx.Sha()] = item
+	}
+	item.updateFeePerKB()
+	return true
 }
 
 // txPriorityQueueLessFunc describes a function that can be used as a compare
@@ -85,12 +201,16 @@ func (pq *txPriorityQueue) Less(i, j int) bool {
 // part of the heap.Interface implementation.
 func (pq *txPriorityQueue) Swap(i, j int) {
 	pq.items[i], pq.items[j] = pq.items[j], pq.items[i]
+	pq.items[i].index = i
+	pq.items[j].index = j
 }
 
 // Push pushes the passed item onto the priority queue.  It is part of the
 // heap.Interface implementation.
 func (pq *txPriorityQueue) Push(x interface{}) {
-	pq.items = append(pq.items, x.(*txPrioItem))
+	item := x.(*txPrioItem)
+	item.index = len(pq.items)
+	pq.items = append(pq.items, item)
 }
 
 // Pop removes the highest priority item (according to Less) from the priority
@@ -98,6 +218,7 @@ func (pq *txPriorityQueue) Push(x interface{}) {
 func (pq *txPriorityQueue) Pop() interface{} {
 	n := len(pq.items)
 	item := pq.items[n-1]
+	item.index = -1
 	pq.items[n-1] = nil
 	pq.items = pq.items[0 : n-1]
 	return item
@@ -180,6 +301,11 @@ type BlockTemplate struct {
 	// NewBlockTemplate for details on which this can be useful to generate
 	// templates without a coinbase payment address.
 	ValidPayAddress bool
+
+	// LongPollID identifies the work the template was generated for.  The
+	// template is stale once the template notifier of the server reports
+	// a different one.
+	LongPollID mining.LongPollID
 }
 
 // mergeUtxoView adds all of the entries in view to viewA.  The result is that
@@ -267,20 +393,6 @@ func spendTransaction(utxoView *blockchain.UtxoViewpoint, tx *colxutil.Tx, heigh
 	return nil
 }
 
-// logSkippedDeps logs any dependencies which are also skipped as a result of
-// skipping a transaction while generating a block template at the trace level.
-func logSkippedDeps(tx *colxutil.Tx, deps *list.List) {
-	if deps == nil {
-		return
-	}
-
-	for e := deps.Front(); e != nil; e = e.Next() {
-		item := e.Value.(*txPrioItem)
-		minrLog.Tracef("Skipping tx %s since it depends on %s\n",
-			item.tx.Sha(), tx.Sha())
-	}
-}
-
 // minimumMedianTime returns the minimum allowed timestamp for a block building
 // on the end of the current best chain.  In particular, it is one second after
 // the median timestamp of the last several blocks per the chain consensus
@@ -337,28 +449,40 @@ func medianAdjustedTime(chainState *chainState, timeSource blockchain.MedianTime
 // higher fee per kilobyte are preferred.  Finally, the block generation related
 // policy settings are all taken into account.
 //
-// Transactions which only spend outputs from other transactions already in the
-// block chain are immediately added to a priority queue which either
-// prioritizes based on the priority (then fee per kilobyte) or the fee per
-// kilobyte (then priority) depending on whether or not the BlockPrioritySize
-// policy setting allots space for high-priority transactions.  Transactions
-// which spend outputs from other transactions in the source pool are added to a
-// dependency map so they can be added to the priority queue once the
-// transactions they depend on have been included.
+// Transactions which spend outputs from other transactions in the source pool
+// must come after them in the block, so the fee per kilobyte of every
+// transaction is calculated along with all of the transactions in the source
+// pool it depends on, either directly or indirectly, which have not been
+// included in the block yet (its ancestors).  This allows transactions which
+// pay a high fee to pull the low-fee transactions they depend on into the block
+// (child pays for parent).  The fees per kilobyte of the remaining transactions
+// are updated as their ancestors are included.  Transactions which depend on
+// transactions that are not available are skipped.
 //
-// Once the high-priority area (if configured) has been filled with
-// transactions, or the priority falls below what is considered high-priority,
-// the priority queue is updated to prioritize by fees per kilobyte (then
-// priority).
+// When the BlockPrioritySize policy setting allots space for high-priority
+// transactions, the transactions which do not depend on any transactions in the
+// source pool are added to a priority queue which prioritizes based on the
+// priority (then fee per kilobyte), and the transactions which do are added to
+// it once the transactions they depend on have been included.  Once the
+// high-priority area has been filled with transactions, or the priority falls
+// below what is considered high-priority, all remaining transactions are added
+// to the priority queue, which is updated to prioritize by fees per kilobyte
+// (then priority).  When it does not allot such space, all transactions are
+// prioritized by fees per kilobyte (then priority) from the start.
 //
-// When the fees per kilobyte drop below the TxMinFreeFee policy setting, the
-// transaction will be skipped unless the BlockMinSize policy setting is
-// nonzero, in which case the block will be filled with the low-fee/free
-// transactions until the block size reaches that minimum size.
+// A transaction is selected along with its ancestors, which are added to the
+// block before it.  When the fees per kilobyte drop below the TxMinFreeFee
+// policy setting, the transaction will be skipped unless the BlockMinSize
+// policy setting is nonzero, in which case the block will be filled with the
+// low-fee/free transactions until the block size reaches that minimum size.
 //
-// Any transactions which would cause the block to exceed the BlockMaxSize
-// policy setting, exceed the maximum allowed signature operations per block, or
-// otherwise cause the block to be invalid are skipped.
+// Any transactions which would cause the block to exceed the weight equivalent
+// of the BlockMaxSize policy setting or the maximum block weight of the network,
+// exceed the maximum allowed signature operation cost per block, or otherwise
+// cause the block to be invalid are skipped along with the transactions which
+// depend on them.  Since the ancestors of a selected transaction are added to
+// the block first, they remain in the block when the transaction itself turns
+// out to be invalid.
 //
 // Given the above, a block generated by this function is of the following form:
 //
@@ -388,10 +512,15 @@ func NewBlockTemplate(policy *mining.Policy, server *server, payToAddress colxut
 	timeSource := server.timeSource
 	chainState := &blockManager.chainState
 
+	// Note the current work before selecting the transactions, so the
+	// template is considered stale when it changes while doing so.
+	longPollID := server.templateNotifier.Current()
+
 	// Extend the most recently known best block.
 	chainState.Lock()
 	prevHash := chainState.newestHash
 	nextBlockHeight := chainState.newestHeight + 1
+	medianTimePast := chainState.pastMedianTime
 	chainState.Unlock()
 
 	// Create a standard coinbase transaction paying to the provided
@@ -415,11 +544,12 @@ func NewBlockTemplate(policy *mining.Policy, server *server, payToAddress colxut
 	numCoinbaseSigOps := int64(blockchain.CountSigOps(coinbaseTx))
 
 	// Get the current source transactions and create a priority queue to
-	// hold the transactions which are ready for inclusion into a block
-	// along with some priority related and fee metadata.  Reserve the same
-	// number of items that are available for the priority queue.  Also,
-	// choose the initial sort order for the priority queue based on whether
-	// or not there is an area allocated for high-priority transactions.
+	// hold the transactions which are candidates for inclusion into a
+	// block along with some priority related and fee metadata.  Reserve
+	// the same number of items that are available for the priority queue.
+	// Also, choose the initial sort order for the priority queue based on
+	// whether or not there is an area allocated for high-priority
+	// transactions.
 	sourceTxns := txSource.MiningDescs()
 	sortedByFee := policy.BlockPrioritySize == 0
 	priorityQueue := newTxPriorityQueue(len(sourceTxns), sortedByFee)
@@ -432,12 +562,14 @@ func NewBlockTemplate(policy *mining.Policy, server *server, payToAddress colxut
 	blockTxns = append(blockTxns, coinbaseTx)
 	blockUtxos := blockchain.NewUtxoViewpoint()
 
-	// dependers is used to track transactions which depend on another
-	// transaction in the source pool.  This, in conjunction with the
-	// dependsOn map kept with each dependent transaction helps quickly
-	// determine which dependent transactions are now eligible for inclusion
-	// in the block once each transaction has been included.
-	dependers := make(map[wire.ShaHash]*list.List)
+	// candidates houses the transactions which have neither been included
+	// in the block nor skipped yet.  The dependsOn map along with the
+	// ancestors and descendants kept with each transaction allows quickly
+	// determining which transactions must be included along with a
+	// transaction and updating the ones which depend on it once it has
+	// been included.
+	candidates := make(map[wire.ShaHash]*txPrioItem, len(sourceTxns))
+	candidateList := make([]*txPrioItem, 0, len(sourceTxns))
 
 	// Create slices to hold the fees and number of signature operations
 	// for each of the selected transactions and add an entry for the
@@ -462,8 +594,12 @@ mempoolLoop:
 			minrLog.Tracef("Skipping coinbase tx %s", tx.Sha())
 			continue
 		}
+		// Transactions are only included once they are finalized as
+		// of the median time of the previous blocks, which is never
+		// after the block timestamp, so the block is valid regardless
+		// of whether or not BIP0113 applies.
 		if !blockchain.IsFinalizedTransaction(tx, nextBlockHeight,
-			timeSource.AdjustedTime()) {
+			medianTimePast) {
 
 			minrLog.Tracef("Skipping non-finalized tx %s", tx.Sha())
 			continue
@@ -501,12 +637,6 @@ mempoolLoop:
 				// The transaction is referencing another
 				// transaction in the source pool, so setup an
 				// ordering dependency.
-				depList, exists := dependers[*originHash]
-				if !exists {
-					depList = list.New()
-					dependers[*originHash] = depList
-				}
-				depList.PushBack(prioItem)
 				if prioItem.dependsOn == nil {
 					prioItem.dependsOn = make(
 						map[wire.ShaHash]struct{})
@@ -525,16 +655,15 @@ mempoolLoop:
 		prioItem.priority = calcPriority(tx.MsgTx(), utxos,
 			nextBlockHeight)
 
-		// Calculate the fee in Satoshi/kB.
-		txSize := tx.MsgTx().SerializeSize()
-		prioItem.feePerKB = (txDesc.Fee * 1000) / int64(txSize)
+		// Note the fee and size so the fee in Satoshi/kB can be
+		// calculated along with the ancestors of the transaction once
+		// they are known.
+		prioItem.size = int64(tx.MsgTx().SerializeSize())
 		prioItem.fee = txDesc.Fee
-
-		// Add the transaction to the priority queue to mark it ready
-		// for inclusion in the block unless it has dependencies.
-		if prioItem.dependsOn == nil {
-			heap.Push(priorityQueue, prioItem)
-		}
+		prioItem.modifiedFee = txDesc.Fee + txDesc.FeeDelta
+		prioItem.index = -1
+		candidates[*tx.Sha()] = prioItem
+		candidateList = append(candidateList, prioItem)
 
 		// Merge the referenced outputs from the input transactions to
 		// this transaction into the block utxo view.  This allows the
@@ -542,67 +671,179 @@ mempoolLoop:
 		mergeUtxoView(blockUtxos, utxos)
 	}
 
-	minrLog.Tracef("Priority queue len %d, dependers len %d",
-		priorityQueue.Len(), len(dependers))
+	// Determine the ancestors of the transactions and add them to the
+	// priority queue to mark them ready for inclusion in the block.  When
+	// prioritizing by priority, transactions with dependencies are only
+	// added once the transactions they depend on have been included.
+	for _, prioItem := range candidateList {
+		if !resolveAncestry(prioItem, candidates) {
+			minrLog.Tracef("Skipping tx %s because it depends on "+
+				"transactions which are not available",
+				prioItem.tx.Sha())
+			continue
+		}
+		if sortedByFee || prioItem.dependsOn == nil {
+			heap.Push(priorityQueue, prioItem)
+		}
+	}
+
+	minrLog.Tracef("Priority queue len %d, candidates len %d",
+		priorityQueue.Len(), len(candidates))
+
+	// The block weight is limited by both the policy and the network.
+	// Also, the signature operations of the coinbase count towards the
+	// maximum signature operation cost of the block.
+	maxWeight, maxSigOpsCost := server.chainParams.GetBlockWeightLimits()
+	policyMaxWeight := int64(policy.BlockMaxSize) * blockchain.WitnessScaleFactor
+	if policyMaxWeight < maxWeight {
+		maxWeight = policyMaxWeight
+	}
+	blockSigOpCost := numCoinbaseSigOps * blockchain.WitnessScaleFactor
 
-	// The starting block size is the size of the block header plus the max
-	// possible transaction count size, plus the size of the coinbase
+	// The starting block weight is the weight of the block header plus the
+	// max possible transaction count size, plus the weight of the coinbase
 	// transaction.
-	blockSize := blockHeaderOverhead + uint32(coinbaseTx.MsgTx().SerializeSize())
-	blockSigOps := numCoinbaseSigOps
+	blockWeight := int64(blockHeaderOverhead)*blockchain.WitnessScaleFactor +
+		blockchain.CalcTxWeight(coinbaseTx)
 	totalFees := int64(0)
 
-	// Choose which transactions make it into the block.
-	for priorityQueue.Len() > 0 {
-		// Grab the highest priority (or highest fee per kilobyte
-		// depending on the sort order) transaction.
-		prioItem := heap.Pop(priorityQueue).(*txPrioItem)
+	// skipTx removes the passed transaction along with all transactions
+	// which depend on it from the candidates since none of them can be
+	// included in the block anymore.
+	skipTx := func(prioItem *txPrioItem) {
+		for hash, desc := range prioItem.descendants {
+			if _, ok := candidates[hash]; !ok {
+				continue
+			}
+			minrLog.Tracef("Skipping tx %s since it depends on %s",
+				desc.tx.Sha(), prioItem.tx.Sha())
+			delete(candidates, hash)
+			if desc.index >= 0 {
+				heap.Remove(priorityQueue, desc.index)
+			}
+		}
+		delete(candidates, *prioItem.tx.Sha())
+		if prioItem.index >= 0 {
+			heap.Remove(priorityQueue, prioItem.index)
+		}
+	}
+
+	// addTx adds the passed transaction to the block when it does not
+	// exceed the maximum signature operation cost per block and is valid,
+	// and updates the transactions which depend on it accordingly.  It
+	// returns whether the transaction was added.
+	addTx := func(prioItem *txPrioItem) bool {
 		tx := prioItem.tx
 
-		// Grab the list of transactions which depend on this one (if
-		// any) and remove the entry for this transaction as it will
-		// either be included or skipped, but in either case the deps
-		// are no longer needed.
-		deps := dependers[*tx.Sha()]
-		delete(dependers, *tx.Sha())
-
-		// Enforce maximum block size.  Also check for overflow.
-		txSize := uint32(tx.MsgTx().SerializeSize())
-		blockPlusTxSize := blockSize + txSize
-		if blockPlusTxSize < blockSize || blockPlusTxSize >= policy.BlockMaxSize {
-			minrLog.Tracef("Skipping tx %s because it would exceed "+
-				"the max block size", tx.Sha())
-			logSkippedDeps(tx, deps)
-			continue
+		// Enforce maximum signature operation cost per block.  Also
+		// check for overflow.
+		sigOpCost, err := blockchain.GetSigOpCost(tx, false, blockUtxos,
+			true)
+		if err != nil {
+			minrLog.Tracef("Skipping tx %s due to error in "+
+				"GetSigOpCost: %v", tx.Sha(), err)
+			return false
 		}
-
-		// Enforce maximum signature operations per block.  Also check
-		// for overflow.
-		numSigOps := int64(blockchain.CountSigOps(tx))
-		if blockSigOps+numSigOps < blockSigOps ||
-			blockSigOps+numSigOps > blockchain.MaxSigOpsPerBlock {
+		if blockSigOpCost+int64(sigOpCost) < blockSigOpCost ||
+			blockSigOpCost+int64(sigOpCost) > maxSigOpsCost {
 			minrLog.Tracef("Skipping tx %s because it would "+
-				"exceed the maximum sigops per block", tx.Sha())
-			logSkippedDeps(tx, deps)
-			continue
+				"exceed the maximum sigop cost per block",
+				tx.Sha())
+			return false
 		}
-		numP2SHSigOps, err := blockchain.CountP2SHSigOps(tx, false,
+		numSigOps := int64(sigOpCost / blockchain.WitnessScaleFactor)
+
+		// Ensure the transaction inputs pass all of the necessary
+		// preconditions before allowing it to be added to the block.
+		_, err = blockchain.CheckTransactionInputs(tx, nextBlockHeight,
 			blockUtxos)
 		if err != nil {
 			minrLog.Tracef("Skipping tx %s due to error in "+
-				"CountP2SHSigOps: %v", tx.Sha(), err)
-			logSkippedDeps(tx, deps)
-			continue
+				"CheckTransactionInputs: %v", tx.Sha(), err)
+			return false
 		}
-		numSigOps += int64(numP2SHSigOps)
-		if blockSigOps+numSigOps < blockSigOps ||
-			blockSigOps+numSigOps > blockchain.MaxSigOpsPerBlock {
-			minrLog.Tracef("Skipping tx %s because it would "+
-				"exceed the maximum sigops per block (p2sh)",
-				tx.Sha())
-			logSkippedDeps(tx, deps)
+		err = blockchain.ValidateTransactionScripts(tx, blockUtxos,
+			txscript.StandardVerifyFlags, server.sigCache)
+		if err != nil {
+			minrLog.Tracef("Skipping tx %s due to error in "+
+				"ValidateTransactionScripts: %v", tx.Sha(), err)
+			return false
+		}
+
+		// Spend the transaction inputs in the block utxo view and add
+		// an entry for it to ensure any transactions which reference
+		// this one have it available as an input and can ensure they
+		// aren't double spending.
+		spendTransaction(blockUtxos, tx, nextBlockHeight)
+
+		// Add the transaction to the block, increment counters, and
+		// save the fees and signature operation counts to the block
+		// template.
+		blockTxns = append(blockTxns, tx)
+		blockWeight += blockchain.CalcTxWeight(tx)
+		blockSigOpCost += int64(sigOpCost)
+		totalFees += prioItem.fee
+		txFees = append(txFees, prioItem.fee)
+		txSigOpCounts = append(txSigOpCounts, numSigOps)
+
+		minrLog.Tracef("Adding tx %s (priority %.2f, feePerKB %d)",
+			tx.Sha(), prioItem.priority, prioItem.feePerKB)
+
+		// Remove the transaction from the ancestors of the transactions
+		// which depend on it and update their position in the priority
+		// queue according to their new fee per kilobyte.  When
+		// prioritizing by priority, add the transactions which do not
+		// have any other unsatisfied dependencies to the priority
+		// queue.
+		hash := *tx.Sha()
+		delete(candidates, hash)
+		if prioItem.index >= 0 {
+			heap.Remove(priorityQueue, prioItem.index)
+		}
+		for descHash, desc := range prioItem.descendants {
+			if _, ok := candidates[descHash]; !ok {
+				continue
+			}
+			delete(desc.ancestors, hash)
+			delete(desc.dependsOn, hash)
+			desc.ancestorFee -= prioItem.modifiedFee
+			desc.ancestorSize -= prioItem.size
+			desc.updateFeePerKB()
+			if desc.index >= 0 {
+				heap.Fix(priorityQueue, desc.index)
+			} else if !sortedByFee && len(desc.dependsOn) == 0 {
+				heap.Push(priorityQueue, desc)
+			}
+		}
+		return true
+	}
+
+	// Choose which transactions make it into the block.
+	for priorityQueue.Len() > 0 {
+		// Grab the highest priority (or highest fee per kilobyte
+		// depending on the sort order) transaction along with the
+		// ancestors which must be included before it.
+		prioItem := heap.Pop(priorityQueue).(*txPrioItem)
+		tx := prioItem.tx
+		pkg := prioItem.packageTxns()
+
+		// Enforce maximum block weight for the transaction along with
+		// its ancestors.  Also check for overflow.  The size based
+		// policy settings below are checked against the size the block
+		// weight is equivalent to.
+		var pkgWeight int64
+		for _, item := range pkg {
+			pkgWeight += blockchain.CalcTxWeight(item.tx)
+		}
+		blockPlusTxWeight := blockWeight + pkgWeight
+		if blockPlusTxWeight < blockWeight || blockPlusTxWeight >= maxWeight {
+			minrLog.Tracef("Skipping tx %s because it would exceed "+
+				"the max block weight", tx.Sha())
+			skipTx(prioItem)
 			continue
 		}
+		blockPlusTxSize := uint32(blockPlusTxWeight /
+			blockchain.WitnessScaleFactor)
 
 		// Skip free transactions once the block is larger than the
 		// minimum block size.
@@ -615,7 +856,7 @@ mempoolLoop:
 				"minBlockSize %d", tx.Sha(), prioItem.feePerKB,
 				policy.TxMinFreeFee, blockPlusTxSize,
 				policy.BlockMinSize)
-			logSkippedDeps(tx, deps)
+			skipTx(prioItem)
 			continue
 		}
 
@@ -631,7 +872,16 @@ mempoolLoop:
 				blockPlusTxSize, policy.BlockPrioritySize,
 				prioItem.priority, minHighPriority)
 
+			// Add all remaining transactions to the priority queue,
+			// including the ones which depend on transactions that
+			// have not been included yet, so they are able to pull
+			// them into the block.
 			sortedByFee = true
+			for _, item := range candidates {
+				if item.index < 0 && item != prioItem {
+					heap.Push(priorityQueue, item)
+				}
+			}
 			priorityQueue.SetLessFunc(txPQByFee)
 
 			// Put the transaction back into the priority queue and
@@ -648,66 +898,19 @@ mempoolLoop:
 			}
 		}
 
-		// Ensure the transaction inputs pass all of the necessary
-		// preconditions before allowing it to be added to the block.
-		_, err = blockchain.CheckTransactionInputs(tx, nextBlockHeight,
-			blockUtxos)
-		if err != nil {
-			minrLog.Tracef("Skipping tx %s due to error in "+
-				"CheckTransactionInputs: %v", tx.Sha(), err)
-			logSkippedDeps(tx, deps)
-			continue
-		}
-		err = blockchain.ValidateTransactionScripts(tx, blockUtxos,
-			txscript.StandardVerifyFlags, server.sigCache)
-		if err != nil {
-			minrLog.Tracef("Skipping tx %s due to error in "+
-				"ValidateTransactionScripts: %v", tx.Sha(), err)
-			logSkippedDeps(tx, deps)
-			continue
-		}
-
-		// Spend the transaction inputs in the block utxo view and add
-		// an entry for it to ensure any transactions which reference
-		// this one have it available as an input and can ensure they
-		// aren't double spending.
-		spendTransaction(blockUtxos, tx, nextBlockHeight)
-
-		// Add the transaction to the block, increment counters, and
-		// save the fees and signature operation counts to the block
-		// template.
-		blockTxns = append(blockTxns, tx)
-		blockSize += txSize
-		blockSigOps += numSigOps
-		totalFees += prioItem.fee
-		txFees = append(txFees, prioItem.fee)
-		txSigOpCounts = append(txSigOpCounts, numSigOps)
-
-		minrLog.Tracef("Adding tx %s (priority %.2f, feePerKB %d)",
-			prioItem.tx.Sha(), prioItem.priority, prioItem.feePerKB)
-
-		// Add transactions which depend on this one (and also do not
-		// have any other unsatisified dependencies) to the priority
-		// queue.
-		if deps != nil {
-			for e := deps.Front(); e != nil; e = e.Next() {
-				// Add the transaction to the priority queue if
-				// there are no more dependencies after this
-				// one.
-				item := e.Value.(*txPrioItem)
-				delete(item.dependsOn, *tx.Sha())
-				if len(item.dependsOn) == 0 {
-					heap.Push(priorityQueue, item)
-				}
+		// Add the ancestors of the transaction to the block before it.
+		// When one of them can't be added, neither can the ones which
+		// depend on it, which includes the transaction itself.
+		for _, item := range pkg {
+			if !addTx(item) {
+				skipTx(item)
+				break
 			}
 		}
 	}
 
 	// Now that the actual transactions have been selected, update the
-	// block size for the real transaction count and coinbase value with
-	// the total fees accordingly.
-	blockSize -= wire.MaxVarIntPayload -
-		uint32(wire.VarIntSerializeSize(uint64(len(blockTxns))))
+	// coinbase value with the total fees accordingly.
 	coinbaseTx.MsgTx().TxOut[0].Value += totalFees
 	txFees[0] = -totalFees
 
@@ -749,9 +952,10 @@ mempoolLoop:
 	}
 
 	minrLog.Debugf("Created new block template (%d transactions, %d in "+
-		"fees, %d signature operations, %d bytes, target difficulty "+
-		"%064x)", len(msgBlock.Transactions), totalFees, blockSigOps,
-		blockSize, blockchain.CompactToBig(msgBlock.Header.Bits))
+		"fees, %d signature operation cost, %d weight, target "+
+		"difficulty %064x)", len(msgBlock.Transactions), totalFees,
+		blockSigOpCost, blockchain.CalcBlockWeight(block),
+		blockchain.CompactToBig(msgBlock.Header.Bits))
 
 	return &BlockTemplate{
 		Block:           &msgBlock,
@@ -759,6 +963,7 @@ mempoolLoop:
 		SigOpCounts:     txSigOpCounts,
 		Height:          nextBlockHeight,
 		ValidPayAddress: payToAddress != nil,
+		LongPollID:      longPollID,
 	}, nil
 }
 
@@ -793,6 +998,17 @@ func UpdateBlockTime(msgBlock *wire.MsgBlock, bManager *blockManager) error {
 	return nil
 }
 
+// CheckBlockTemplate fully validates the passed block against the consensus
+// rules as a block connecting to the current tip of the main chain, aside from
+// the proof of work requirement, such as a block proposed by a mining pool
+// before it is solved.  Neither the chain state nor the memory pool are
+// modified and the block is not relayed.  The returned error is a RuleError
+// when the block violates a rule, with the ErrPrevBlockNotBest code when it
+// does not build on the current tip.
+func CheckBlockTemplate(block *colxutil.Block, bManager *blockManager) error {
+	return bManager.chain.CheckConnectBlockTemplate(block)
+}
+
 // UpdateExtraNonce updates the extra nonce in the coinbase script of the passed
 // block by regenerating the coinbase script with the passed value and block
 // height.  It also recalculates and updates the new merkle root that results
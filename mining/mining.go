@@ -27,6 +27,12 @@ type TxDesc struct {
 
 	// Fee is the total fee the transaction associated with the entry pays.
 	Fee int64
+
+	// FeeDelta is an adjustment to the fee which only applies when
+	// selecting transactions for inclusion in new blocks, such as one set
+	// by the prioritisetransaction RPC.  It does not change the fee the
+	// transaction actually pays.
+	FeeDelta int64
 }
 
 // TxSource represents a source of transactions to consider for inclusion in
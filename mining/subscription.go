@@ -0,0 +1,179 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mining
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// LongPollID identifies the work a block template was generated for.  It
+// consists of the hash of the best chain tip the template builds on and a
+// counter which is incremented for every event which warrants generating a new
+// template.  External miners pass it back with long poll requests to wait
+// until the template they are working on is stale.
+type LongPollID struct {
+	PrevHash wire.ShaHash
+	Counter  uint64
+}
+
+// String returns the long poll ID encoded as the previous block hash and the
+// counter separated by a dash.
+func (id LongPollID) String() string {
+	return fmt.Sprintf("%s-%d", id.PrevHash.String(), id.Counter)
+}
+
+// ParseLongPollID decodes a long poll ID encoded by LongPollID.String.
+func ParseLongPollID(s string) (LongPollID, error) {
+	fields := strings.Split(s, "-")
+	if len(fields) != 2 {
+		return LongPollID{}, errors.New("invalid longpollid format")
+	}
+
+	prevHash, err := wire.NewShaHashFromStr(fields[0])
+	if err != nil {
+		return LongPollID{}, errors.New("invalid longpollid format")
+	}
+	counter, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return LongPollID{}, errors.New("invalid longpollid format")
+	}
+
+	return LongPollID{PrevHash: *prevHash, Counter: counter}, nil
+}
+
+// TemplateSubscription delivers a notification whenever the block templates
+// of a TemplateNotifier become stale.  Notifications which are not received
+// before the next one is delivered are coalesced, so a subscriber only learns
+// that at least one event happened since it last received from the channel.
+type TemplateSubscription struct {
+	notifier *TemplateNotifier
+	c        chan LongPollID
+}
+
+// C returns the channel which receives the long poll ID of the new work
+// whenever block templates become stale.
+func (sub *TemplateSubscription) C() <-chan LongPollID {
+	return sub.c
+}
+
+// Stop unsubscribes from notifications.  No notifications are delivered once
+// it returns.  It is safe to call multiple times.
+func (sub *TemplateSubscription) Stop() {
+	n := sub.notifier
+	n.mtx.Lock()
+	delete(n.subscribers, sub)
+	n.mtx.Unlock()
+}
+
+// TemplateNotifier tracks when block templates become stale, which is the
+// case when the best chain tip changes or when the total fees of the
+// transactions available for mining change by at least a configured amount,
+// and notifies subscribers about it.  It is safe for concurrent access.
+type TemplateNotifier struct {
+	mtx         sync.Mutex
+	feeDelta    int64
+	current     LongPollID
+	lastFees    int64
+	subscribers map[*TemplateSubscription]struct{}
+}
+
+// NewTemplateNotifier returns a new template notifier which considers block
+// templates stale once the total fees of the transactions available for
+// mining change by at least the passed amount in Satoshi.
+func NewTemplateNotifier(feeDelta int64) *TemplateNotifier {
+	return &TemplateNotifier{
+		feeDelta:    feeDelta,
+		subscribers: make(map[*TemplateSubscription]struct{}),
+	}
+}
+
+// Subscribe returns a new subscription to notifications about block templates
+// becoming stale.  The Stop method of the subscription must be called once
+// the caller is no longer interested in them.
+func (n *TemplateNotifier) Subscribe() *TemplateSubscription {
+	sub := &TemplateSubscription{
+		notifier: n,
+		c:        make(chan LongPollID, 1),
+	}
+
+	n.mtx.Lock()
+	n.subscribers[sub] = struct{}{}
+	n.mtx.Unlock()
+
+	return sub
+}
+
+// NumSubscribers returns the number of active subscriptions.
+func (n *TemplateNotifier) NumSubscribers() int {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	return len(n.subscribers)
+}
+
+// Current returns the long poll ID of the current work.  Block templates which
+// were generated for a different long poll ID are stale.
+func (n *TemplateNotifier) Current() LongPollID {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	return n.current
+}
+
+// notify advances the long poll ID of the current work and notifies the
+// subscribers about it.
+//
+// This function MUST be called with the notifier lock held.
+func (n *TemplateNotifier) notify(prevHash *wire.ShaHash) {
+	n.current = LongPollID{PrevHash: *prevHash, Counter: n.current.Counter + 1}
+	for sub := range n.subscribers {
+		// Replace any notification the subscriber has not received
+		// yet.
+		select {
+		case <-sub.c:
+		default:
+		}
+		sub.c <- n.current
+	}
+}
+
+// NotifyBlockConnected updates the notifier with the hash of the best chain
+// tip along with the total fees in Satoshi of the transactions available for
+// mining on top of it.  Subscribers are notified when the tip changed.
+func (n *TemplateNotifier) NotifyBlockConnected(hash *wire.ShaHash, totalFees int64) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	if n.current.PrevHash == *hash {
+		return
+	}
+	n.lastFees = totalFees
+	n.notify(hash)
+}
+
+// NotifyFeesChanged updates the notifier with the total fees in Satoshi of the
+// transactions available for mining.  Subscribers are notified when the total
+// changed by at least the configured delta since the last notification.
+func (n *TemplateNotifier) NotifyFeesChanged(totalFees int64) {
+	n.mtx.Lock()
+	defer n.mtx.Unlock()
+
+	delta := totalFees - n.lastFees
+	if delta < 0 {
+		delta = -delta
+	}
+	if delta == 0 || delta < n.feeDelta {
+		return
+	}
+	n.lastFees = totalFees
+	n.notify(&n.current.PrevHash)
+}
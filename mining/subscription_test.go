@@ -0,0 +1,151 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package mining
+
+import (
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// receiveAll returns the notifications which are pending on the passed
+// subscription without blocking.
+func receiveAll(sub *TemplateSubscription) []LongPollID {
+	var ids []LongPollID
+	for {
+		select {
+		case id := <-sub.C():
+			ids = append(ids, id)
+		default:
+			return ids
+		}
+	}
+}
+
+// TestTemplateNotifier ensures subscribers are notified exactly once for every
+// event which warrants generating a new block template and not at all for
+// events which do not.
+func TestTemplateNotifier(t *testing.T) {
+	tip := wire.ShaHash{0x01}
+	newTip := wire.ShaHash{0x02}
+
+	n := NewTemplateNotifier(1000)
+	n.NotifyBlockConnected(&tip, 0)
+	sub := n.Subscribe()
+	defer sub.Stop()
+
+	tests := []struct {
+		name   string
+		event  func()
+		wantID *LongPollID
+	}{
+		{
+			name:   "same tip",
+			event:  func() { n.NotifyBlockConnected(&tip, 5000) },
+			wantID: nil,
+		},
+		{
+			name:   "fee change below delta",
+			event:  func() { n.NotifyFeesChanged(999) },
+			wantID: nil,
+		},
+		{
+			name:   "fee change reaching delta",
+			event:  func() { n.NotifyFeesChanged(1000) },
+			wantID: &LongPollID{PrevHash: tip, Counter: 2},
+		},
+		{
+			name:   "fee decrease reaching delta",
+			event:  func() { n.NotifyFeesChanged(0) },
+			wantID: &LongPollID{PrevHash: tip, Counter: 3},
+		},
+		{
+			name:   "tip change",
+			event:  func() { n.NotifyBlockConnected(&newTip, 0) },
+			wantID: &LongPollID{PrevHash: newTip, Counter: 4},
+		},
+		{
+			name:   "unchanged fees after tip change",
+			event:  func() { n.NotifyFeesChanged(0) },
+			wantID: nil,
+		},
+	}
+
+	for _, test := range tests {
+		test.event()
+		ids := receiveAll(sub)
+		if test.wantID == nil {
+			if len(ids) != 0 {
+				t.Errorf("%s: unexpected notifications %v",
+					test.name, ids)
+			}
+			continue
+		}
+		if len(ids) != 1 {
+			t.Errorf("%s: unexpected number of notifications - "+
+				"got %d, want 1", test.name, len(ids))
+			continue
+		}
+		if ids[0] != *test.wantID {
+			t.Errorf("%s: unexpected long poll ID - got %v, want %v",
+				test.name, ids[0], *test.wantID)
+		}
+		if current := n.Current(); current != *test.wantID {
+			t.Errorf("%s: unexpected current long poll ID - got %v, "+
+				"want %v", test.name, current, *test.wantID)
+		}
+	}
+
+	// Notifications which are not received are coalesced.
+	n.NotifyFeesChanged(5000)
+	n.NotifyFeesChanged(10000)
+	ids := receiveAll(sub)
+	want := LongPollID{PrevHash: newTip, Counter: 6}
+	if len(ids) != 1 || ids[0] != want {
+		t.Errorf("unexpected coalesced notifications - got %v, want %v",
+			ids, want)
+	}
+
+	// No notifications are delivered once a subscription is stopped.
+	sub.Stop()
+	sub.Stop()
+	if n.NumSubscribers() != 0 {
+		t.Fatalf("unexpected number of subscribers - got %d, want 0",
+			n.NumSubscribers())
+	}
+	n.NotifyBlockConnected(&tip, 0)
+	if ids := receiveAll(sub); len(ids) != 0 {
+		t.Errorf("unexpected notifications after stop %v", ids)
+	}
+}
+
+// TestParseLongPollID ensures long poll IDs round trip through their string
+// encoding and malformed IDs are rejected.
+func TestParseLongPollID(t *testing.T) {
+	id := LongPollID{PrevHash: wire.ShaHash{0x01, 0x02}, Counter: 42}
+	got, err := ParseLongPollID(id.String())
+	if err != nil {
+		t.Fatalf("ParseLongPollID: unexpected error: %v", err)
+	}
+	if got != id {
+		t.Fatalf("unexpected long poll ID - got %v, want %v", got, id)
+	}
+
+	tests := []string{
+		"",
+		"00",
+		id.PrevHash.String(),
+		id.PrevHash.String() + "-",
+		id.PrevHash.String() + "-x",
+		id.PrevHash.String() + "-1-2",
+		"zz-1",
+	}
+	for _, test := range tests {
+		if _, err := ParseLongPollID(test); err == nil {
+			t.Errorf("ParseLongPollID(%q): unexpected success", test)
+		}
+	}
+}
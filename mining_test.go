@@ -7,9 +7,21 @@ package main
 
 import (
 	"container/heap"
+	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/blockchain/chaingen"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/mining"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
 	"github.com/tinhnguyenhn/colxutil"
 )
 
@@ -109,3 +121,332 @@ func TestTxFeePrioHeap(t *testing.T) {
 		highest = prioItem
 	}
 }
+
+// templateHarness provides a server backed by a fresh chain along with a
+// confirmed funding transaction whose outputs can be spent by transactions in
+// its memory pool, so block templates can be generated from them.
+type templateHarness struct {
+	server      *server
+	fundingTx   *colxutil.Tx
+	nextOutputs map[wire.ShaHash]uint32
+	teardown    func()
+}
+
+// newTemplateHarness creates a new chain instance in a temporary database which
+// contains a matured funding transaction with the passed number of outputs
+// paying to an OP_TRUE script along with a server which generates block
+// templates from its memory pool.
+func newTemplateHarness(t testing.TB, numOutputs int) *templateHarness {
+	params := &chaincfg.RegressionNetParams
+	dbPath, err := ioutil.TempDir("", "miningtest")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		params.Net)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("unable to create db: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: params,
+		TimeSource:  blockchain.NewMedianTime(),
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create chain: %v", err)
+	}
+
+	// Generate enough blocks for the first coinbase to mature followed by
+	// a block with a transaction which splits it into the funding outputs.
+	g := chaingen.NewGenerator(params)
+	blocks := make([]*wire.MsgBlock, 0, blockchain.CoinbaseMaturity+1)
+	for i := 1; i <= blockchain.CoinbaseMaturity; i++ {
+		blocks = append(blocks, g.NextBlock(fmt.Sprintf("b%d", i), nil))
+	}
+	g.SaveSpendableCoinbaseOuts()
+	out := g.OldestCoinbaseOut()
+	fundingBlock := g.NextBlock("funding", out, func(b *wire.MsgBlock) {
+		tx := b.Transactions[1]
+		amount := tx.TxOut[0].Value / int64(numOutputs)
+		tx.TxOut = nil
+		for i := 0; i < numOutputs; i++ {
+			tx.AddTxOut(wire.NewTxOut(amount,
+				[]byte{txscript.OP_TRUE}))
+		}
+		b.Header.MerkleRoot = chaingen.CalcMerkleRoot(b.Transactions)
+	})
+	blocks = append(blocks, fundingBlock)
+	for _, block := range blocks {
+		_, err := chain.ProcessBlock(colxutil.NewBlock(block),
+			blockchain.BFNone)
+		if err != nil {
+			teardown()
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+
+	s := &server{
+		chainParams:      params,
+		timeSource:       blockchain.NewMedianTime(),
+		templateNotifier: mining.NewTemplateNotifier(0),
+		txMemPool: newTxMemPool(&mempoolConfig{
+			Chain:      chain,
+			TimeSource: blockchain.NewMedianTime(),
+		}),
+	}
+	s.blockManager = &blockManager{server: s, chain: chain}
+	best := chain.BestSnapshot()
+	s.blockManager.updateChainState(best.Hash, best.Height)
+
+	return &templateHarness{
+		server:      s,
+		fundingTx:   colxutil.NewTx(fundingBlock.Transactions[1]),
+		nextOutputs: make(map[wire.ShaHash]uint32),
+		teardown:    teardown,
+	}
+}
+
+// addTx adds a transaction which spends the next unused output of the passed
+// parent transaction, or of the funding transaction when it is nil, to the
+// memory pool with the passed fee and returns it.  The transaction has two
+// outputs paying to an OP_TRUE script, so it is added without any policy
+// checks.
+func (h *templateHarness) addTx(parent *colxutil.Tx, fee int64) *colxutil.Tx {
+	if parent == nil {
+		parent = h.fundingTx
+	}
+	prevOut := wire.NewOutPoint(parent.Sha(), h.nextOutputs[*parent.Sha()])
+	h.nextOutputs[*parent.Sha()]++
+	amount := parent.MsgTx().TxOut[prevOut.Index].Value - fee
+
+	msgTx := wire.NewMsgTx()
+	msgTx.AddTxIn(wire.NewTxIn(prevOut, nil))
+	msgTx.AddTxOut(wire.NewTxOut(amount/2, []byte{txscript.OP_TRUE}))
+	msgTx.AddTxOut(wire.NewTxOut(amount-amount/2,
+		[]byte{txscript.OP_TRUE}))
+	tx := colxutil.NewTx(msgTx)
+
+	mp := h.server.txMemPool
+	mp.Lock()
+	mp.addTransaction(blockchain.NewUtxoViewpoint(), tx,
+		h.server.blockManager.chainState.newestHeight, fee, false)
+	mp.Unlock()
+	return tx
+}
+
+// TestNewBlockTemplateCPFP ensures transactions which pay a high fee pull the
+// low-fee transactions they depend on into block templates along with them
+// both with and without an area for high-priority transactions, while low-fee
+// transactions which are not paid for by anything are left out.
+func TestNewBlockTemplateCPFP(t *testing.T) {
+	const (
+		lowFee  = 0
+		highFee = 100000
+	)
+
+	h := newTemplateHarness(t, 10)
+	defer h.teardown()
+
+	// Create the following transactions where only the ones marked with
+	// an asterisk pay a high fee.
+	//
+	//   lone
+	//   parent -> child*
+	//   grandparent -> middle -> grandchild*
+	//              \-> sibling
+	lone := h.addTx(nil, lowFee)
+	parent := h.addTx(nil, lowFee)
+	child := h.addTx(parent, highFee)
+	grandparent := h.addTx(nil, lowFee)
+	middle := h.addTx(grandparent, lowFee)
+	grandchild := h.addTx(middle, highFee)
+	sibling := h.addTx(grandparent, lowFee)
+
+	tests := []struct {
+		name              string
+		blockPrioritySize uint32
+	}{
+		{"no priority area", 0},
+		{"priority area", 50000},
+	}
+	for _, test := range tests {
+		policy := &mining.Policy{
+			BlockMaxSize:      wire.MaxBlockPayload,
+			BlockPrioritySize: test.blockPrioritySize,
+			TxMinFreeFee:      1000,
+		}
+		template, err := NewBlockTemplate(policy, h.server, nil)
+		if err != nil {
+			t.Fatalf("%s: NewBlockTemplate: unexpected error: %v",
+				test.name, err)
+		}
+
+		// Note the position of every transaction in the block.
+		positions := make(map[wire.ShaHash]int)
+		for i, tx := range template.Block.Transactions[1:] {
+			positions[tx.TxSha()] = i
+		}
+		wantIncluded := []*colxutil.Tx{parent, child, grandparent,
+			middle, grandchild}
+		if len(positions) != len(wantIncluded) {
+			t.Errorf("%s: unexpected number of transactions - got "+
+				"%d, want %d", test.name, len(positions),
+				len(wantIncluded))
+		}
+		for _, tx := range wantIncluded {
+			if _, ok := positions[*tx.Sha()]; !ok {
+				t.Errorf("%s: tx %v is not in the template",
+					test.name, tx.Sha())
+			}
+		}
+		for _, tx := range []*colxutil.Tx{lone, sibling} {
+			if _, ok := positions[*tx.Sha()]; ok {
+				t.Errorf("%s: low-fee tx %v is in the template",
+					test.name, tx.Sha())
+			}
+		}
+
+		// Ancestors must come before the transactions which depend on
+		// them.
+		ordered := [][2]*colxutil.Tx{
+			{parent, child},
+			{grandparent, middle},
+			{middle, grandchild},
+		}
+		for _, pair := range ordered {
+			if positions[*pair[0].Sha()] >= positions[*pair[1].Sha()] {
+				t.Errorf("%s: tx %v is not before tx %v",
+					test.name, pair[0].Sha(), pair[1].Sha())
+			}
+		}
+
+		// The coinbase collects the fees of the included transactions.
+		if got := -template.Fees[0]; got != 2*highFee {
+			t.Errorf("%s: unexpected total fees - got %d, want %d",
+				test.name, got, 2*highFee)
+		}
+	}
+}
+
+// TestNewBlockTemplatePrioritise ensures the fee deltas set via the
+// prioritisetransaction RPC change which transactions are selected for block
+// templates and in which order, including the transactions which depend on
+// them, without changing the fees the block collects.
+func TestNewBlockTemplatePrioritise(t *testing.T) {
+	h := newTemplateHarness(t, 10)
+	defer h.teardown()
+
+	// Create a transaction paying a medium fee, a free one, and a free
+	// parent with a free child.
+	medium := h.addTx(nil, 20000)
+	free := h.addTx(nil, 0)
+	parent := h.addTx(nil, 0)
+	child := h.addTx(parent, 0)
+
+	policy := &mining.Policy{
+		BlockMaxSize: wire.MaxBlockPayload,
+		TxMinFreeFee: 1000,
+	}
+	mp := h.server.txMemPool
+	tests := []struct {
+		name      string
+		deltas    map[*colxutil.Tx]int64
+		wantOrder []*colxutil.Tx
+	}{
+		{
+			name:      "no deltas",
+			wantOrder: []*colxutil.Tx{medium},
+		},
+		{
+			name:      "free transaction prioritised",
+			deltas:    map[*colxutil.Tx]int64{free: 100000},
+			wantOrder: []*colxutil.Tx{free, medium},
+		},
+		{
+			name: "medium transaction deprioritised",
+			deltas: map[*colxutil.Tx]int64{
+				medium: -20000,
+			},
+			wantOrder: []*colxutil.Tx{free},
+		},
+		{
+			name: "child pays for prioritised parent",
+			deltas: map[*colxutil.Tx]int64{
+				free:   0,
+				medium: 0,
+				child:  200000,
+			},
+			wantOrder: []*colxutil.Tx{parent, child, medium},
+		},
+	}
+	for _, test := range tests {
+		for tx, delta := range test.deltas {
+			if err := mp.PrioritiseTransaction(tx.Sha(), delta); err != nil {
+				t.Fatalf("%s: PrioritiseTransaction: unexpected "+
+					"error: %v", test.name, err)
+			}
+		}
+		template, err := NewBlockTemplate(policy, h.server, nil)
+		if err != nil {
+			t.Fatalf("%s: NewBlockTemplate: unexpected error: %v",
+				test.name, err)
+		}
+
+		var gotOrder []wire.ShaHash
+		for _, tx := range template.Block.Transactions[1:] {
+			gotOrder = append(gotOrder, tx.TxSha())
+		}
+		var wantOrder []wire.ShaHash
+		var wantFees int64
+		for _, tx := range test.wantOrder {
+			wantOrder = append(wantOrder, *tx.Sha())
+			if tx == medium {
+				wantFees = 20000
+			}
+		}
+		if !reflect.DeepEqual(gotOrder, wantOrder) {
+			t.Errorf("%s: unexpected transactions - got %v, want %v",
+				test.name, gotOrder, wantOrder)
+		}
+
+		// The fee deltas do not change the fees the block collects.
+		if got := -template.Fees[0]; got != wantFees {
+			t.Errorf("%s: unexpected total fees - got %d, want %d",
+				test.name, got, wantFees)
+		}
+	}
+}
+
+// BenchmarkNewBlockTemplate benchmarks generating a block template from a
+// memory pool which contains a few thousand transactions, many of which are
+// only worth including along with the transactions which depend on them.
+func BenchmarkNewBlockTemplate(b *testing.B) {
+	const numChains = 1000
+	h := newTemplateHarness(b, 2*numChains)
+	defer h.teardown()
+
+	prng := rand.New(rand.NewSource(1))
+	for i := 0; i < numChains; i++ {
+		h.addTx(nil, prng.Int63n(10000))
+		parent := h.addTx(nil, prng.Int63n(100))
+		middle := h.addTx(parent, prng.Int63n(100))
+		h.addTx(middle, prng.Int63n(100000))
+	}
+	policy := &mining.Policy{
+		BlockMaxSize: wire.MaxBlockPayload,
+		TxMinFreeFee: 1000,
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewBlockTemplate(policy, h.server, nil); err != nil {
+			b.Fatalf("NewBlockTemplate: unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+/*
+Package netsync provides coordinators for synchronizing the block chain with
+multiple peers in parallel.
+
+Header Skeleton Sync
+
+HeaderSkeletonSync downloads block headers from multiple peers at once.  It
+first requests a sparse skeleton of every interval'th header from a single sync
+peer and then fills the gaps between the headers of the skeleton from all of
+the available peers.  Every gap is verified to connect to the skeleton headers
+on both sides of it before its headers are processed, in order, by a
+HeaderProcessor such as blockchain.BlockChain.  Peers which serve headers that
+are inconsistent with the skeleton are reported as misbehaving and gaps which
+are not filled in time are reassigned.
+
+The coordinator does not perform any network communication itself.  Instead,
+the caller provides an implementation of HeaderSyncPeer for each peer taking
+part in the sync and delivers the responses to the requests made through it by
+calling HandleHeaders.  Skeletons are requested with the getskeleton message
+(wire.MsgGetSkeleton) from peers which advertise the wire.SFNodeHeaderSkeleton
+service, while the gaps are requested with regular getheaders messages, so any
+full node is able to fill them.  The block manager uses the coordinator to
+download the headers up to the next checkpoint in headers-first mode when its
+sync peer serves skeletons.
+*/
+package netsync
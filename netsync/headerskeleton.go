@@ -0,0 +1,434 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+const (
+	// DefaultHeaderSkeletonInterval is the default number of headers
+	// between the headers of a skeleton, which is the maximum number of
+	// headers a single headers message may carry so every gap can be
+	// filled with a single request.
+	DefaultHeaderSkeletonInterval = wire.MaxBlockHeadersPerMsg
+
+	// MaxHeaderSkeletonLen is the maximum number of headers of a
+	// skeleton.
+	MaxHeaderSkeletonLen = wire.MaxBlockHeadersPerMsg
+
+	// DefaultHeaderSkeletonTimeout is the default amount of time a peer is
+	// given to respond to a skeleton or gap request before the request is
+	// considered stalled.
+	DefaultHeaderSkeletonTimeout = 30 * time.Second
+
+	// headerSkeletonMaxMismatches is the number of distinct peers which
+	// may serve a gap that does not connect to the skeleton before the
+	// skeleton itself is considered to be invalid.
+	headerSkeletonMaxMismatches = 3
+)
+
+var (
+	// ErrHeaderSkeletonTimeout is the error of a header skeleton sync
+	// whose sync peer did not respond to the skeleton request in time.
+	ErrHeaderSkeletonTimeout = errors.New("timeout waiting for header " +
+		"skeleton")
+
+	// ErrHeaderSkeletonPeerGone is the error of a header skeleton sync
+	// whose sync peer disconnected before serving the skeleton.
+	ErrHeaderSkeletonPeerGone = errors.New("sync peer disconnected " +
+		"before serving the header skeleton")
+)
+
+// HeaderSyncPeer describes a peer which serves block headers to a header
+// skeleton sync.  The responses to the requests are delivered to the sync by
+// calling HandleHeaders.
+type HeaderSyncPeer interface {
+	// RequestHeaderSkeleton requests up to count headers which are every
+	// interval'th header following the block with the passed hash.
+	RequestHeaderSkeleton(start *wire.ShaHash, interval, count int) error
+
+	// RequestHeaders requests the headers following the block with the
+	// passed start hash up to and including the block with the passed
+	// stop hash.
+	RequestHeaders(start, stop *wire.ShaHash) error
+
+	// Misbehaving reports that the peer served headers which are
+	// inconsistent with the rest of the chain.
+	Misbehaving(reason string)
+}
+
+// HeaderProcessor describes the validation of chains of block headers which
+// is performed by blockchain.BlockChain.
+type HeaderProcessor interface {
+	ProcessBlockHeaders(headers []*wire.BlockHeader) error
+}
+
+// headerGap is the range of headers between two consecutive headers of a
+// skeleton, excluding the first and including the last one.
+type headerGap struct {
+	start wire.ShaHash
+	end   wire.ShaHash
+
+	// peer is the peer the gap is currently assigned to, if any, and
+	// deadline is the time by which it must respond.
+	peer     *headerSkeletonPeer
+	deadline time.Time
+
+	// tried houses the peers which failed to fill the gap so it is not
+	// assigned to them again, and mismatches is the number of them which
+	// served headers that do not connect to the skeleton.
+	tried      map[HeaderSyncPeer]struct{}
+	mismatches int
+
+	// headers are the verified headers of the gap along with the peer
+	// which served them while they wait for the headers of the earlier
+	// gaps to be processed.
+	headers []*wire.BlockHeader
+	filler  HeaderSyncPeer
+}
+
+// headerSkeletonPeer tracks the state of a peer participating in a header
+// skeleton sync.
+type headerSkeletonPeer struct {
+	peer HeaderSyncPeer
+	gap  *headerGap
+
+	// stalled is set when the peer did not respond to a request in time.
+	// It is not assigned any more gaps until the late response arrives
+	// since it would otherwise be mistaken for the response to the new
+	// request.
+	stalled bool
+}
+
+// HeaderSkeletonSync coordinates downloading block headers from multiple
+// peers in parallel.  A sparse skeleton of every interval'th header is first
+// requested from the sync peer, then the gaps between the headers of the
+// skeleton are assigned to all of the available peers.  Every gap is verified
+// to connect to both of the skeleton headers which surround it before its
+// headers are processed, in order, by the header processor.  Peers which serve
+// a gap that does not connect to the skeleton are reported as misbehaving and
+// their headers are discarded, while gaps which are not filled in time are
+// reassigned to other peers.
+//
+// The headers which follow the last header of the skeleton are not part of
+// the sync and are expected to be downloaded by the regular sync once it is
+// done.
+//
+// The sync is driven by calling its methods as the events occur and is not
+// safe for concurrent access, so it is intended to be owned by the goroutine
+// handling the sync, such as the one of a block manager.
+type HeaderSkeletonSync struct {
+	chain    HeaderProcessor
+	start    wire.ShaHash
+	interval int
+	count    int
+	timeout  time.Duration
+
+	syncPeer         HeaderSyncPeer
+	skeletonDeadline time.Time
+
+	// gaps holds the gaps of the skeleton once it is known and processed
+	// is the number of them whose headers have been processed.
+	gaps      []*headerGap
+	processed int
+
+	peers []*headerSkeletonPeer
+	err   error
+}
+
+// NewHeaderSkeletonSync returns a header skeleton sync which downloads the
+// headers following the block with the passed hash, which must be known to
+// the passed header processor, and feeds them into it.  The skeleton is made
+// of up to count headers which are interval headers apart.  The interval,
+// count, and timeout default to DefaultHeaderSkeletonInterval,
+// MaxHeaderSkeletonLen, and DefaultHeaderSkeletonTimeout when they are not
+// positive.
+func NewHeaderSkeletonSync(chain HeaderProcessor, start *wire.ShaHash, interval, count int, timeout time.Duration) *HeaderSkeletonSync {
+	if interval <= 0 || interval > wire.MaxBlockHeadersPerMsg {
+		interval = DefaultHeaderSkeletonInterval
+	}
+	if count <= 0 || count > MaxHeaderSkeletonLen {
+		count = MaxHeaderSkeletonLen
+	}
+	if timeout <= 0 {
+		timeout = DefaultHeaderSkeletonTimeout
+	}
+	return &HeaderSkeletonSync{
+		chain:    chain,
+		start:    *start,
+		interval: interval,
+		count:    count,
+		timeout:  timeout,
+	}
+}
+
+// Start requests the skeleton from the passed sync peer, which also takes
+// part in filling the gaps once the skeleton is known.
+func (s *HeaderSkeletonSync) Start(syncPeer HeaderSyncPeer, now time.Time) error {
+	s.syncPeer = syncPeer
+	s.peers = append(s.peers, &headerSkeletonPeer{peer: syncPeer})
+	s.skeletonDeadline = now.Add(s.timeout)
+	err := syncPeer.RequestHeaderSkeleton(&s.start, s.interval, s.count)
+	if err != nil {
+		s.fail(err)
+	}
+	return err
+}
+
+// AddPeer adds the passed peer to the peers which fill the gaps of the
+// skeleton.
+func (s *HeaderSkeletonSync) AddPeer(peer HeaderSyncPeer, now time.Time) {
+	if s.findPeer(peer) != nil {
+		return
+	}
+	s.peers = append(s.peers, &headerSkeletonPeer{peer: peer})
+	s.assignGaps(now)
+}
+
+// RemovePeer removes the passed peer, which has disconnected, from the sync
+// and reassigns the gap it was filling, if any.  The sync fails when the sync
+// peer disconnects before serving the skeleton.
+func (s *HeaderSkeletonSync) RemovePeer(peer HeaderSyncPeer, now time.Time) {
+	s.removePeer(peer)
+	if peer == s.syncPeer && s.gaps == nil {
+		s.fail(ErrHeaderSkeletonPeerGone)
+		return
+	}
+	s.assignGaps(now)
+}
+
+// HandleHeaders handles the passed headers served by the passed peer in
+// response to either the skeleton request or a gap request.  Headers which do
+// not answer an outstanding request, such as the late response of a stalled
+// peer, are ignored.
+func (s *HeaderSkeletonSync) HandleHeaders(peer HeaderSyncPeer, headers []*wire.BlockHeader, now time.Time) {
+	if s.err != nil {
+		return
+	}
+	if peer == s.syncPeer && s.gaps == nil {
+		s.handleSkeleton(headers, now)
+		return
+	}
+
+	sp := s.findPeer(peer)
+	if sp == nil {
+		return
+	}
+	if sp.gap == nil {
+		sp.stalled = false
+		s.assignGaps(now)
+		return
+	}
+	gap := sp.gap
+	sp.gap, gap.peer = nil, nil
+
+	if reason := verifyHeaderGap(gap, headers); reason != "" {
+		log.Warnf("Discarding headers for gap %v to %v which do "+
+			"not connect to the skeleton: %s", gap.start, gap.end,
+			reason)
+		peer.Misbehaving(reason)
+		gap.tried[peer] = struct{}{}
+		gap.mismatches++
+		if gap.mismatches >= headerSkeletonMaxMismatches {
+			reason := fmt.Sprintf("%d peers served headers for gap "+
+				"%v to %v which do not connect to the skeleton",
+				gap.mismatches, gap.start, gap.end)
+			s.syncPeer.Misbehaving(reason)
+			s.fail(errors.New(reason))
+			return
+		}
+		s.assignGaps(now)
+		return
+	}
+
+	gap.headers, gap.filler = headers, peer
+	if err := s.processGaps(); err != nil {
+		s.fail(err)
+		return
+	}
+	s.assignGaps(now)
+}
+
+// Tick reassigns the gaps whose peers did not respond in time and fails the
+// sync when the sync peer did not serve the skeleton in time.  It is expected
+// to be called periodically.
+func (s *HeaderSkeletonSync) Tick(now time.Time) {
+	if s.err != nil {
+		return
+	}
+	if s.gaps == nil {
+		if now.After(s.skeletonDeadline) {
+			s.fail(ErrHeaderSkeletonTimeout)
+		}
+		return
+	}
+
+	for _, gap := range s.gaps[s.processed:] {
+		if gap.peer == nil || !now.After(gap.deadline) {
+			continue
+		}
+		log.Debugf("Reassigning headers for gap %v to %v after "+
+			"timeout", gap.start, gap.end)
+		gap.tried[gap.peer.peer] = struct{}{}
+		gap.peer.gap, gap.peer.stalled = nil, true
+		gap.peer = nil
+	}
+	s.assignGaps(now)
+}
+
+// Done returns whether the headers of all of the gaps of the skeleton have
+// been processed.
+func (s *HeaderSkeletonSync) Done() bool {
+	return s.err == nil && s.gaps != nil && s.processed == len(s.gaps)
+}
+
+// Err returns the error which caused the sync to fail, if any.
+func (s *HeaderSkeletonSync) Err() error {
+	return s.err
+}
+
+// handleSkeleton creates the gaps of the passed skeleton and assigns them to
+// the peers.
+func (s *HeaderSkeletonSync) handleSkeleton(headers []*wire.BlockHeader, now time.Time) {
+	if len(headers) > s.count {
+		reason := fmt.Sprintf("header skeleton of %d headers exceeds "+
+			"the requested %d", len(headers), s.count)
+		s.syncPeer.Misbehaving(reason)
+		s.fail(errors.New(reason))
+		return
+	}
+
+	s.gaps = make([]*headerGap, 0, len(headers))
+	prevHash := s.start
+	for _, header := range headers {
+		gap := &headerGap{
+			start: prevHash,
+			end:   header.BlockSha(),
+			tried: make(map[HeaderSyncPeer]struct{}),
+		}
+		s.gaps = append(s.gaps, gap)
+		prevHash = gap.end
+	}
+	log.Debugf("Received header skeleton with %d gaps of %d headers",
+		len(s.gaps), s.interval)
+	s.assignGaps(now)
+}
+
+// verifyHeaderGap returns the reason the passed headers do not fill the passed
+// gap, or an empty string when they connect to the skeleton headers on both
+// sides of it.
+func verifyHeaderGap(gap *headerGap, headers []*wire.BlockHeader) string {
+	if len(headers) == 0 {
+		return "no headers for gap"
+	}
+	prevHash := gap.start
+	for i, header := range headers {
+		if !header.PrevBlock.IsEqual(&prevHash) {
+			return fmt.Sprintf("header %d of gap does not connect to "+
+				"the previous header %v", i, prevHash)
+		}
+		prevHash = header.BlockSha()
+	}
+	if !prevHash.IsEqual(&gap.end) {
+		return fmt.Sprintf("last header %v of gap does not match the "+
+			"skeleton header %v", prevHash, gap.end)
+	}
+	return ""
+}
+
+// processGaps feeds the verified headers of the gaps which directly follow the
+// processed gaps into the header processor in order.  The headers of a gap
+// connect to the skeleton, so headers which fail validation mean both the
+// peer which served them and the sync peer served an invalid chain.
+func (s *HeaderSkeletonSync) processGaps() error {
+	for s.processed < len(s.gaps) && s.gaps[s.processed].headers != nil {
+		gap := s.gaps[s.processed]
+		if err := s.chain.ProcessBlockHeaders(gap.headers); err != nil {
+			reason := fmt.Sprintf("invalid headers for gap %v to %v: "+
+				"%v", gap.start, gap.end, err)
+			gap.filler.Misbehaving(reason)
+			if gap.filler != s.syncPeer {
+				s.syncPeer.Misbehaving(reason)
+			}
+			return err
+		}
+		gap.headers = nil
+		s.processed++
+	}
+	return nil
+}
+
+// assignGaps assigns the unassigned gaps, in order, to the idle peers which
+// have not failed to fill them.  Peers which the request cannot be sent to are
+// removed from the sync.
+func (s *HeaderSkeletonSync) assignGaps(now time.Time) {
+	if s.err != nil || s.gaps == nil {
+		return
+	}
+	for _, gap := range s.gaps[s.processed:] {
+		if gap.peer != nil || gap.headers != nil {
+			continue
+		}
+		for _, sp := range s.peers {
+			if sp.gap != nil || sp.stalled {
+				continue
+			}
+			if _, ok := gap.tried[sp.peer]; ok {
+				continue
+			}
+			err := sp.peer.RequestHeaders(&gap.start, &gap.end)
+			if err != nil {
+				log.Debugf("Failed to request headers: %v",
+					err)
+				s.removePeer(sp.peer)
+				s.assignGaps(now)
+				return
+			}
+			sp.gap, gap.peer = gap, sp
+			gap.deadline = now.Add(s.timeout)
+			break
+		}
+	}
+}
+
+// findPeer returns the state of the passed peer, or nil when it is not taking
+// part in the sync.
+func (s *HeaderSkeletonSync) findPeer(peer HeaderSyncPeer) *headerSkeletonPeer {
+	for _, sp := range s.peers {
+		if sp.peer == peer {
+			return sp
+		}
+	}
+	return nil
+}
+
+// removePeer removes the state of the passed peer and unassigns the gap it was
+// filling, if any.
+func (s *HeaderSkeletonSync) removePeer(peer HeaderSyncPeer) {
+	for i, sp := range s.peers {
+		if sp.peer != peer {
+			continue
+		}
+		if sp.gap != nil {
+			sp.gap.peer = nil
+		}
+		s.peers = append(s.peers[:i], s.peers[i+1:]...)
+		return
+	}
+}
+
+// fail stops the sync with the passed error.
+func (s *HeaderSkeletonSync) fail(err error) {
+	if s.err == nil {
+		s.err = err
+		log.Warnf("Header skeleton sync failed: %v", err)
+	}
+}
@@ -0,0 +1,402 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package netsync
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	_ "github.com/tinhnguyenhn/colxd/database/ffldb"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// headerRequest is a request made to a mockHeaderPeer.  The interval is zero
+// for gap requests.
+type headerRequest struct {
+	start    wire.ShaHash
+	stop     wire.ShaHash
+	interval int
+	count    int
+}
+
+// mockHeaderPeer is a HeaderSyncPeer which records the requests made to it
+// so the test can serve them from the chain of headers it is given.
+type mockHeaderPeer struct {
+	name        string
+	headers     []*wire.BlockHeader
+	pending     []headerRequest
+	misbehavior []string
+}
+
+// RequestHeaderSkeleton records the skeleton request.
+func (p *mockHeaderPeer) RequestHeaderSkeleton(start *wire.ShaHash, interval, count int) error {
+	p.pending = append(p.pending, headerRequest{start: *start,
+		interval: interval, count: count})
+	return nil
+}
+
+// RequestHeaders records the gap request.
+func (p *mockHeaderPeer) RequestHeaders(start, stop *wire.ShaHash) error {
+	p.pending = append(p.pending, headerRequest{start: *start, stop: *stop})
+	return nil
+}
+
+// Misbehaving records the reported misbehavior.
+func (p *mockHeaderPeer) Misbehaving(reason string) {
+	p.misbehavior = append(p.misbehavior, reason)
+}
+
+// serve removes the oldest pending request and returns the response to it
+// from the headers of the peer.  The headers following the start hash are
+// served up to and including the stop hash, or every interval'th one of them
+// for skeleton requests.
+func (p *mockHeaderPeer) serve() []*wire.BlockHeader {
+	req := p.pending[0]
+	p.pending = p.pending[1:]
+
+	first := 0
+	for i, header := range p.headers {
+		if header.PrevBlock.IsEqual(&req.start) {
+			first = i
+			break
+		}
+	}
+	var headers []*wire.BlockHeader
+	for i := first; i < len(p.headers); i++ {
+		header := p.headers[i]
+		if req.interval != 0 {
+			if (i-first+1)%req.interval == 0 {
+				headers = append(headers, header)
+			}
+			continue
+		}
+		headers = append(headers, header)
+		if header.BlockSha() == req.stop {
+			break
+		}
+	}
+	return headers
+}
+
+// newSkeletonTestChain returns a chain in a temporary database which only
+// contains the genesis block of the passed network along with the database and
+// a function which closes and removes it.
+func newSkeletonTestChain(t *testing.T, params *chaincfg.Params) (*blockchain.BlockChain, database.DB, func()) {
+	dbPath, err := ioutil.TempDir("", "netsynctestchain")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		params.Net)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("unable to create database: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: params,
+		TimeSource:  blockchain.NewMedianTime(),
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create chain: %v", err)
+	}
+	return chain, db, teardown
+}
+
+// newSkeletonTestHeaders returns the solved headers of the passed number of
+// blocks which extend the genesis block of the passed network.
+func newSkeletonTestHeaders(t *testing.T, params *chaincfg.Params, numBlocks int) []*wire.BlockHeader {
+	headers := make([]*wire.BlockHeader, 0, numBlocks)
+	prevHash := *params.GenesisHash
+	genesisTime := params.GenesisBlock.Header.Timestamp
+	target := blockchain.CompactToBig(params.PowLimitBits)
+	for height := 1; height <= numBlocks; height++ {
+		header := &wire.BlockHeader{
+			Version:   4,
+			PrevBlock: prevHash,
+			Timestamp: genesisTime.Add(time.Minute * 10 *
+				time.Duration(height)),
+			Bits: params.PowLimitBits,
+		}
+		for {
+			hash := header.BlockSha()
+			if blockchain.ShaHashToBig(&hash).Cmp(target) <= 0 {
+				break
+			}
+			header.Nonce++
+		}
+		headers = append(headers, header)
+		prevHash = header.BlockSha()
+	}
+	return headers
+}
+
+// forkSkeletonTestHeaders returns a copy of the passed headers where every
+// header from the passed index on commits to a different chain.
+func forkSkeletonTestHeaders(headers []*wire.BlockHeader, from int) []*wire.BlockHeader {
+	forked := make([]*wire.BlockHeader, len(headers))
+	for i, header := range headers {
+		forkedHeader := *header
+		if i >= from {
+			forkedHeader.Timestamp = header.Timestamp.Add(time.Second)
+			if i > from {
+				forkedHeader.PrevBlock = forked[i-1].BlockSha()
+			}
+		}
+		forked[i] = &forkedHeader
+	}
+	return forked
+}
+
+// TestHeaderSkeletonSync ensures a header skeleton sync assigns the gaps of
+// the skeleton to multiple peers, reassigns the gap of a peer which does not
+// respond in time while ignoring its late response, and discards the headers
+// of a peer which serves a chain that is inconsistent with the skeleton while
+// reporting it, such that the final header chain is the correct one.
+func TestHeaderSkeletonSync(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chain, _, teardown := newSkeletonTestChain(t, params)
+	defer teardown()
+
+	const interval = 10
+	headers := newSkeletonTestHeaders(t, params, 5*interval+3)
+	syncPeer := &mockHeaderPeer{name: "sync", headers: headers}
+	slowPeer := &mockHeaderPeer{name: "slow", headers: headers}
+	evilPeer := &mockHeaderPeer{name: "evil",
+		headers: forkSkeletonTestHeaders(headers, 0)}
+	goodPeer := &mockHeaderPeer{name: "good", headers: headers}
+	peers := []*mockHeaderPeer{syncPeer, slowPeer, evilPeer, goodPeer}
+
+	now := time.Unix(1500000000, 0)
+	timeout := time.Minute
+	sync := NewHeaderSkeletonSync(chain, params.GenesisHash, interval, 0,
+		timeout)
+	if err := sync.Start(syncPeer, now); err != nil {
+		t.Fatalf("Start: unexpected error: %v", err)
+	}
+	for _, peer := range peers[1:] {
+		sync.AddPeer(peer, now)
+	}
+	if len(syncPeer.pending) != 1 || syncPeer.pending[0].interval != interval {
+		t.Fatalf("unexpected skeleton request %+v", syncPeer.pending)
+	}
+	for _, peer := range peers[1:] {
+		if len(peer.pending) != 0 {
+			t.Fatalf("%s: gap requested before the skeleton is "+
+				"known", peer.name)
+		}
+	}
+
+	// The skeleton is every tenth header, so the trailing headers which
+	// do not fill a gap are not part of it, and every peer is assigned
+	// one of the first gaps once it is known.
+	sync.HandleHeaders(syncPeer, syncPeer.serve(), now)
+	for i, peer := range peers {
+		if len(peer.pending) != 1 {
+			t.Fatalf("%s: unexpected requests %+v", peer.name,
+				peer.pending)
+		}
+		req := peer.pending[0]
+		wantStart := *params.GenesisHash
+		if i > 0 {
+			wantStart = headers[i*interval-1].BlockSha()
+		}
+		wantStop := headers[(i+1)*interval-1].BlockSha()
+		if req.start != wantStart || req.stop != wantStop {
+			t.Fatalf("%s: unexpected gap request %v to %v, want %v "+
+				"to %v", peer.name, req.start, req.stop,
+				wantStart, wantStop)
+		}
+	}
+
+	// serveAll serves the pending requests of all peers but the slow one
+	// until there are none left.
+	serveAll := func() {
+		for {
+			served := false
+			for _, peer := range peers {
+				if peer == slowPeer || len(peer.pending) == 0 {
+					continue
+				}
+				sync.HandleHeaders(peer, peer.serve(), now)
+				served = true
+			}
+			if !served {
+				return
+			}
+		}
+	}
+	serveAll()
+	if sync.Done() {
+		t.Fatalf("sync done while the gap of the slow peer is pending")
+	}
+	if len(evilPeer.misbehavior) == 0 {
+		t.Fatalf("inconsistent headers of evil peer were not reported")
+	}
+	if _, height := chain.BestHeader(); height != interval {
+		t.Fatalf("unexpected best header height %d, want %d", height,
+			interval)
+	}
+
+	// Time out the gap of the slow peer so it is reassigned, then ensure
+	// the late response of the slow peer is ignored.
+	now = now.Add(timeout / 2)
+	sync.Tick(now)
+	if len(slowPeer.pending) != 1 {
+		t.Fatalf("slow peer request canceled before the timeout")
+	}
+	now = now.Add(timeout)
+	sync.Tick(now)
+	serveAll()
+	sync.HandleHeaders(slowPeer, slowPeer.serve(), now)
+	if err := sync.Err(); err != nil {
+		t.Fatalf("unexpected sync error: %v", err)
+	}
+	if !sync.Done() {
+		t.Fatalf("sync not done after all gaps are served")
+	}
+
+	// The header chain must be the correct one up to the last header of
+	// the skeleton and contain none of the headers of the evil peer.
+	wantHash := headers[5*interval-1].BlockSha()
+	bestHash, bestHeight := chain.BestHeader()
+	if !bestHash.IsEqual(&wantHash) || bestHeight != 5*interval {
+		t.Fatalf("unexpected best header %v (%d), want %v (%d)",
+			bestHash, bestHeight, wantHash, 5*interval)
+	}
+	for i := 0; i < 5*interval; i++ {
+		hash := headers[i].BlockSha()
+		if !chain.HaveHeader(&hash) {
+			t.Fatalf("header %d missing from the header chain", i+1)
+		}
+		hash = evilPeer.headers[i].BlockSha()
+		if chain.HaveHeader(&hash) {
+			t.Fatalf("header %d of evil peer in the header chain",
+				i+1)
+		}
+	}
+	for _, peer := range []*mockHeaderPeer{syncPeer, slowPeer, goodPeer} {
+		if len(peer.misbehavior) != 0 {
+			t.Fatalf("%s: unexpected misbehavior %v", peer.name,
+				peer.misbehavior)
+		}
+	}
+}
+
+// TestHeaderSkeletonSyncInvalidSkeleton ensures a header skeleton sync fails
+// and reports the sync peer when multiple peers serve gaps which do not
+// connect to the skeleton.
+func TestHeaderSkeletonSyncInvalidSkeleton(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chain, _, teardown := newSkeletonTestChain(t, params)
+	defer teardown()
+
+	const interval = 10
+	headers := newSkeletonTestHeaders(t, params, 2*interval)
+	bogusHeaders := forkSkeletonTestHeaders(headers, interval/2)
+	syncPeer := &mockHeaderPeer{name: "sync", headers: bogusHeaders}
+	peers := []*mockHeaderPeer{syncPeer}
+	for i := 0; i < headerSkeletonMaxMismatches; i++ {
+		peers = append(peers, &mockHeaderPeer{name: "good",
+			headers: headers})
+	}
+
+	now := time.Unix(1500000000, 0)
+	sync := NewHeaderSkeletonSync(chain, params.GenesisHash, interval, 0, 0)
+	sync.Start(syncPeer, now)
+	sync.HandleHeaders(syncPeer, syncPeer.serve(), now)
+
+	// The sync peer serves the gaps from the correct chain, so none of the
+	// peers are able to fill the gaps of its bogus skeleton.
+	syncPeer.headers = headers
+	for _, peer := range peers[1:] {
+		sync.AddPeer(peer, now)
+	}
+	for sync.Err() == nil {
+		served := false
+		for _, peer := range peers {
+			if len(peer.pending) != 0 {
+				sync.HandleHeaders(peer, peer.serve(), now)
+				served = true
+			}
+		}
+		if !served {
+			t.Fatalf("sync stalled without an error")
+		}
+	}
+	if len(syncPeer.misbehavior) == 0 {
+		t.Fatalf("sync peer serving invalid skeleton not reported")
+	}
+	if _, height := chain.BestHeader(); height != 0 {
+		t.Fatalf("unexpected best header height %d", height)
+	}
+}
+
+// TestHeaderSkeletonSyncSkeletonFailure ensures a header skeleton sync fails
+// when the sync peer does not serve the skeleton in time or disconnects before
+// serving it, that an empty skeleton completes the sync immediately, and that
+// a skeleton longer than requested is rejected.
+func TestHeaderSkeletonSyncSkeletonFailure(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	now := time.Unix(1500000000, 0)
+
+	syncPeer := &mockHeaderPeer{name: "sync"}
+	sync := NewHeaderSkeletonSync(nil, params.GenesisHash, 0, 0, 0)
+	sync.Start(syncPeer, now)
+	if req := syncPeer.pending[0]; req.interval != DefaultHeaderSkeletonInterval ||
+		req.count != MaxHeaderSkeletonLen {
+
+		t.Fatalf("unexpected skeleton request %+v", req)
+	}
+	sync.Tick(now.Add(DefaultHeaderSkeletonTimeout))
+	if sync.Err() != nil {
+		t.Fatalf("sync failed before the timeout")
+	}
+	sync.Tick(now.Add(DefaultHeaderSkeletonTimeout + time.Second))
+	if sync.Err() != ErrHeaderSkeletonTimeout {
+		t.Fatalf("unexpected error - got %v, want %v", sync.Err(),
+			ErrHeaderSkeletonTimeout)
+	}
+
+	sync = NewHeaderSkeletonSync(nil, params.GenesisHash, 0, 0, 0)
+	sync.Start(syncPeer, now)
+	sync.RemovePeer(syncPeer, now)
+	if sync.Err() != ErrHeaderSkeletonPeerGone {
+		t.Fatalf("unexpected error - got %v, want %v", sync.Err(),
+			ErrHeaderSkeletonPeerGone)
+	}
+
+	sync = NewHeaderSkeletonSync(nil, params.GenesisHash, 0, 0, 0)
+	sync.Start(syncPeer, now)
+	sync.HandleHeaders(syncPeer, nil, now)
+	if !sync.Done() || sync.Err() != nil {
+		t.Fatalf("sync with empty skeleton not done")
+	}
+
+	// A skeleton with more headers than requested is rejected.
+	headers := newSkeletonTestHeaders(t, params, 3)
+	syncPeer = &mockHeaderPeer{name: "sync", headers: headers}
+	sync = NewHeaderSkeletonSync(nil, params.GenesisHash, 1, 2, 0)
+	sync.Start(syncPeer, now)
+	if req := syncPeer.pending[0]; req.interval != 1 || req.count != 2 {
+		t.Fatalf("unexpected skeleton request %+v", req)
+	}
+	sync.HandleHeaders(syncPeer, syncPeer.serve(), now)
+	if sync.Err() == nil || len(syncPeer.misbehavior) != 1 {
+		t.Fatalf("oversized skeleton not rejected")
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"bytes"
+	"sync/atomic"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+const (
+	// maxCoalesceBytes is the number of bytes of serialized messages held
+	// back for SendCoalesceDelay after which they are written to the remote
+	// peer right away.
+	maxCoalesceBytes = 64 * 1024
+)
+
+// notifyDone signals the done channel of the message along with the done
+// channels of all messages which were coalesced into it, if any.
+func (m *outMsg) notifyDone() {
+	if m.doneChan != nil {
+		m.doneChan <- struct{}{}
+	}
+	for _, doneChan := range m.coalescedDone {
+		doneChan <- struct{}{}
+	}
+}
+
+// coalesceOutMsg returns a single message which replaces the passed queued
+// message followed by the passed next message when they can be combined.
+// Consecutive inv messages are combined as long as the result does not exceed
+// maxInvTrickleSize inventory vectors, and consecutive headers messages are
+// combined when the first header of the next message builds on the last header
+// of the queued one and the result does not exceed wire.MaxBlockHeadersPerMsg
+// headers.  The done channels of both messages are signaled once the combined
+// message is sent.
+//
+// The passed messages are not modified since they might still be referenced by
+// the callers which queued them.
+func coalesceOutMsg(queued, next outMsg) (outMsg, bool) {
+	var msg wire.Message
+	switch m := queued.msg.(type) {
+	case *wire.MsgInv:
+		nextInv, ok := next.msg.(*wire.MsgInv)
+		if !ok {
+			return outMsg{}, false
+		}
+		numInvs := len(m.InvList) + len(nextInv.InvList)
+		if numInvs > maxInvTrickleSize {
+			return outMsg{}, false
+		}
+		invMsg := wire.NewMsgInvSizeHint(uint(numInvs))
+		invMsg.InvList = append(invMsg.InvList, m.InvList...)
+		invMsg.InvList = append(invMsg.InvList, nextInv.InvList...)
+		msg = invMsg
+
+	case *wire.MsgHeaders:
+		nextHeaders, ok := next.msg.(*wire.MsgHeaders)
+		if !ok || len(m.Headers) == 0 || len(nextHeaders.Headers) == 0 {
+			return outMsg{}, false
+		}
+		numHeaders := len(m.Headers) + len(nextHeaders.Headers)
+		if numHeaders > wire.MaxBlockHeadersPerMsg {
+			return outMsg{}, false
+		}
+		lastHash := m.Headers[len(m.Headers)-1].BlockSha()
+		if !nextHeaders.Headers[0].PrevBlock.IsEqual(&lastHash) {
+			return outMsg{}, false
+		}
+		headersMsg := wire.NewMsgHeaders()
+		headersMsg.Headers = make([]*wire.BlockHeader, 0, numHeaders)
+		headersMsg.Headers = append(headersMsg.Headers, m.Headers...)
+		headersMsg.Headers = append(headersMsg.Headers,
+			nextHeaders.Headers...)
+		msg = headersMsg
+
+	default:
+		return outMsg{}, false
+	}
+
+	coalesced := outMsg{
+		msg:           msg,
+		doneChan:      queued.doneChan,
+		coalescedDone: queued.coalescedDone,
+	}
+	if next.doneChan != nil {
+		coalesced.coalescedDone = append(coalesced.coalescedDone,
+			next.doneChan)
+	}
+	coalesced.coalescedDone = append(coalesced.coalescedDone,
+		next.coalescedDone...)
+	return coalesced, true
+}
+
+// isCoalescableMessage returns whether or not the passed message is a data
+// message which may be held back for up to SendCoalesceDelay so it shares a
+// write with the messages sent right after it.  Control messages, such as
+// pings, and large messages, such as blocks, are written right away.
+func isCoalescableMessage(msg wire.Message) bool {
+	switch msg.(type) {
+	case *wire.MsgInv, *wire.MsgHeaders, *wire.MsgTx, *wire.MsgNotFound:
+		return true
+	}
+	return false
+}
+
+// sendBuffer houses the serialized data messages the output handler holds back
+// for SendCoalesceDelay along with the messages themselves so their done
+// channels can be signaled once they are written.
+type sendBuffer struct {
+	buf   bytes.Buffer
+	msgs  []outMsg
+	timer *time.Timer
+}
+
+// timerChan returns the channel which is notified once the messages held back
+// are due to be written.  It is nil when no messages are held back.
+func (sb *sendBuffer) timerChan() <-chan time.Time {
+	if sb.timer == nil {
+		return nil
+	}
+	return sb.timer.C
+}
+
+// bufferMessage serializes the passed message into the passed send buffer to
+// be written to the remote peer along with the other messages held back once
+// SendCoalesceDelay elapses or the buffer reaches maxCoalesceBytes.
+//
+// This function MUST only be called from the output handler.
+func (p *Peer) bufferMessage(sb *sendBuffer, msg outMsg) {
+	p.notifyStallHandler(sccSendMessage, msg.msg)
+	n := sb.buf.Len()
+	err := p.writeMessageTo(&sb.buf, msg.msg)
+	if err != nil {
+		sb.buf.Truncate(n)
+		if lerr, ok := err.(*wire.PayloadLimitError); ok {
+			// The remote peer would not accept the message, so it
+			// is dropped instead of being sent.
+			log.Warnf("Not sending message to %s: %v", p, lerr)
+		} else {
+			p.Disconnect()
+			log.Errorf("Failed to send message to %s: %v", p, err)
+		}
+		msg.notifyDone()
+		return
+	}
+
+	sb.msgs = append(sb.msgs, msg)
+	if sb.buf.Len() >= maxCoalesceBytes {
+		p.flushSendBuffer(sb)
+		return
+	}
+	if sb.timer == nil {
+		sb.timer = time.NewTimer(p.cfg.SendCoalesceDelay)
+	}
+}
+
+// flushSendBuffer writes the messages held back in the passed send buffer to
+// the remote peer with a single write and signals their done channels.  The
+// done channels are also signaled when the messages can't be written because
+// the peer is disconnecting.
+//
+// This function MUST only be called from the output handler.
+func (p *Peer) flushSendBuffer(sb *sendBuffer) {
+	if sb.timer != nil {
+		sb.timer.Stop()
+		sb.timer = nil
+	}
+	if sb.buf.Len() > 0 && atomic.LoadInt32(&p.disconnect) == 0 {
+		_, err := p.conn.Write(sb.buf.Bytes())
+		if err != nil {
+			p.Disconnect()
+			if p.shouldLogWriteError(err) {
+				log.Errorf("Failed to send messages to %s: %v", p,
+					err)
+			}
+		} else {
+			atomic.StoreInt64(&p.lastSend, time.Now().Unix())
+		}
+	}
+	sb.buf.Reset()
+	for i := range sb.msgs {
+		sb.msgs[i].notifyDone()
+	}
+	sb.msgs = nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// TestCoalesceOutMsg ensures queued messages are only combined when they are
+// of the same type, stay within the limits, and, for headers, chain correctly,
+// and that the combined message signals the done channels of all of the
+// messages it replaces without modifying them.
+func TestCoalesceOutMsg(t *testing.T) {
+	invMsg := func(start, num int) *wire.MsgInv {
+		msg := wire.NewMsgInv()
+		for i := start; i < start+num; i++ {
+			hash := wire.ShaHash{byte(i), byte(i >> 8)}
+			msg.AddInvVect(wire.NewInvVect(wire.InvTypeTx, &hash))
+		}
+		return msg
+	}
+
+	// Create a chain of headers to split between headers messages.
+	headers := make([]*wire.BlockHeader, 0, wire.MaxBlockHeadersPerMsg+1)
+	var prevHash wire.ShaHash
+	for i := 0; i < cap(headers); i++ {
+		header := &wire.BlockHeader{
+			Version:   1,
+			PrevBlock: prevHash,
+			Timestamp: time.Unix(int64(1231006505+i), 0),
+			Nonce:     uint32(i),
+		}
+		headers = append(headers, header)
+		prevHash = header.BlockSha()
+	}
+	headersMsg := func(start, end int) *wire.MsgHeaders {
+		msg := wire.NewMsgHeaders()
+		msg.Headers = append(msg.Headers, headers[start:end]...)
+		return msg
+	}
+
+	tests := []struct {
+		name  string
+		queue wire.Message
+		next  wire.Message
+		want  int // number of items in combined message, 0 if none
+	}{
+		{"inv", invMsg(0, 3), invMsg(3, 4), 7},
+		{"inv at limit", invMsg(0, maxInvTrickleSize-1), invMsg(0, 1),
+			maxInvTrickleSize},
+		{"inv over limit", invMsg(0, maxInvTrickleSize), invMsg(0, 1), 0},
+		{"chained headers", headersMsg(0, 2), headersMsg(2, 5), 5},
+		{"unchained headers", headersMsg(0, 2), headersMsg(3, 5), 0},
+		{"reversed headers", headersMsg(2, 5), headersMsg(0, 2), 0},
+		{"empty headers", headersMsg(0, 0), headersMsg(0, 2), 0},
+		{"headers over limit", headersMsg(0, wire.MaxBlockHeadersPerMsg),
+			headersMsg(wire.MaxBlockHeadersPerMsg,
+				wire.MaxBlockHeadersPerMsg+1), 0},
+		{"inv then headers", invMsg(0, 1), headersMsg(0, 1), 0},
+		{"headers then inv", headersMsg(0, 1), invMsg(0, 1), 0},
+		{"ping", wire.NewMsgPing(1), wire.NewMsgPing(2), 0},
+		{"flush marker", invMsg(0, 1), nil, 0},
+	}
+
+	for _, test := range tests {
+		queuedDone := make(chan struct{}, 1)
+		nextDone := make(chan struct{}, 1)
+		earlierDone := make(chan struct{}, 1)
+		queued := outMsg{
+			msg:           test.queue,
+			doneChan:      queuedDone,
+			coalescedDone: []chan<- struct{}{earlierDone},
+		}
+		next := outMsg{msg: test.next, doneChan: nextDone}
+
+		got, ok := coalesceOutMsg(queued, next)
+		if ok != (test.want != 0) {
+			t.Errorf("%s: unexpected result - got %v, want %v",
+				test.name, ok, test.want != 0)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		// Ensure the combined message holds the items of both messages
+		// in order.
+		var items, wantItems []wire.ShaHash
+		switch msg := got.msg.(type) {
+		case *wire.MsgInv:
+			for _, iv := range msg.InvList {
+				items = append(items, iv.Hash)
+			}
+			for _, m := range []wire.Message{test.queue, test.next} {
+				for _, iv := range m.(*wire.MsgInv).InvList {
+					wantItems = append(wantItems, iv.Hash)
+				}
+			}
+		case *wire.MsgHeaders:
+			for _, header := range msg.Headers {
+				items = append(items, header.BlockSha())
+			}
+			for _, m := range []wire.Message{test.queue, test.next} {
+				for _, h := range m.(*wire.MsgHeaders).Headers {
+					wantItems = append(wantItems, h.BlockSha())
+				}
+			}
+		}
+		if len(items) != test.want {
+			t.Errorf("%s: unexpected number of items - got %d, want %d",
+				test.name, len(items), test.want)
+			continue
+		}
+		for i := range items {
+			if items[i] != wantItems[i] {
+				t.Errorf("%s: unexpected item #%d - got %v, want %v",
+					test.name, i, items[i], wantItems[i])
+				break
+			}
+		}
+
+		// Ensure the original messages were left untouched.
+		if got.msg == test.queue || got.msg == test.next {
+			t.Errorf("%s: combined message reuses a queued message",
+				test.name)
+		}
+		if len(queued.coalescedDone) != 1 || next.coalescedDone != nil {
+			t.Errorf("%s: done channels of queued messages modified",
+				test.name)
+		}
+
+		// Ensure the done channels of every replaced message are
+		// signaled exactly once.
+		got.notifyDone()
+		for i, doneChan := range []chan struct{}{queuedDone, earlierDone,
+			nextDone} {
+
+			if len(doneChan) != 1 {
+				t.Errorf("%s: done channel #%d signaled %d times",
+					test.name, i, len(doneChan))
+			}
+		}
+	}
+}
@@ -32,6 +32,7 @@ A quick overview of the major features peer provides are as follows:
    - Bitcoin network
    - Service support signalling (full nodes, bloom filters, etc)
    - Maximum supported protocol version
+   - Optional TLS encryption and authentication of the connection
    - Ability to register callbacks for handling bitcoin protocol messages
  - Inventory message batching and send trickling with known inventory detection
    and avoidance
@@ -49,6 +50,8 @@ A quick overview of the major features peer provides are as follows:
      but the helpers provide additional nice functionality such as duplicate
      filtering and address randomization
  - Ability to wait for shutdown/disconnect
+ - Lightweight observer mode for network crawlers and monitoring tools which
+   only completes the handshake and collects the data sent by the remote peer
  - Comprehensive test coverage
 
 Peer Configuration
@@ -93,6 +96,12 @@ It is often useful to use closures which encapsulate state when specifying the
 callback handlers.  This provides a clean method for accessing that state when
 callbacks are invoked.
 
+In order to avoid allocating for every small message, ping, pong, and small inv
+messages are decoded into storage which is reused for the next message read
+from the same peer.  Those messages are only valid until the callback returns,
+so callbacks which hand them off to other goroutines must copy them.  All other
+messages may be retained as is.
+
 Queuing Messages and Inventory
 
 The QueueMessage function provides the fundamental means to send messages to the
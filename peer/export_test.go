@@ -12,8 +12,99 @@ The functions are only exported while the tests are being run.
 
 package peer
 
+import (
+	"crypto/tls"
+	"net"
+	"sync"
+	"time"
+)
+
 // TstAllowSelfConns allows the test package to allow self connections by
 // disabling the detection logic.
 func TstAllowSelfConns() {
 	allowSelfConns = true
 }
+
+// TstHandshakeTLS wraps the passed connection in the same manner as Connect
+// does for a peer configured with a TLS configuration and runs the handshake
+// so tests can inspect the resulting error.
+func TstHandshakeTLS(conn net.Conn, cfg *tls.Config, inbound bool, addr string) error {
+	return handshakeTLS(newTLSConn(conn, cfg, inbound, addr))
+}
+
+// TstClock is a virtual clock which only advances when requested so tests can
+// control the time dependent behavior of peers.
+type TstClock struct {
+	mtx     sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []tstClockWaiter
+}
+
+// tstClockWaiter is a channel returned by TstClock.After along with the time it
+// fires at.
+type tstClockWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+// TstNewClock returns a virtual clock which starts at the passed time.
+func TstNewClock(now time.Time) *TstClock {
+	c := &TstClock{now: now}
+	c.cond = sync.NewCond(&c.mtx)
+	return c
+}
+
+// Now returns the current virtual time.
+func (c *TstClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+// After returns a channel which receives the virtual time once the clock has
+// been advanced by the passed duration.
+func (c *TstClock) After(d time.Duration) <-chan time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, tstClockWaiter{c.now.Add(d), ch})
+	c.cond.Broadcast()
+	return ch
+}
+
+// Advance advances the virtual time by the passed duration and fires the
+// channels returned by After which are due.
+func (c *TstClock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.deadline.After(c.now) {
+			waiters = append(waiters, waiter)
+			continue
+		}
+		waiter.c <- c.now
+	}
+	c.waiters = waiters
+}
+
+// WaitForWaiter blocks until a channel returned by After is waiting to fire.
+func (c *TstClock) WaitForWaiter() {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for len(c.waiters) == 0 {
+		c.cond.Wait()
+	}
+}
+
+// TstSetClock replaces the clock of the passed peer with the passed virtual
+// clock.  It must be called before the peer is connected.
+func TstSetClock(p *Peer, c *TstClock) {
+	p.clock = c
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+const (
+	// maxExternalAddrScores is the maximum number of distinct addresses
+	// reported by remote peers that are tracked for scoring.  This limits
+	// the amount of memory a flood of bogus reports can consume.
+	maxExternalAddrScores = 64
+
+	// maxExternalAddrVotes is the maximum number of network groups counted
+	// towards the score of a single address.
+	maxExternalAddrVotes = 64
+)
+
+// ExternalAddrsFunc is a func which returns the externally reachable addresses
+// of the local peer in order of preference, most preferred first.
+type ExternalAddrsFunc func() ([]*wire.NetAddress, error)
+
+// NewExternalAddrCache returns an ExternalAddrsFunc which caches the addresses
+// returned by the passed resolver and only invokes it again once the refresh
+// interval has elapsed.  This is useful when resolving the addresses is
+// expensive, such as when querying a NAT device, since the returned function
+// is invoked for every new connection.  When a refresh fails, the previously
+// resolved addresses continue to be returned if there are any.
+//
+// The returned function is safe for concurrent access.
+func NewExternalAddrCache(resolve ExternalAddrsFunc, refresh time.Duration) ExternalAddrsFunc {
+	var (
+		mtx         sync.Mutex
+		addrs       []*wire.NetAddress
+		lastResolve time.Time
+	)
+	return func() ([]*wire.NetAddress, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		if !lastResolve.IsZero() && time.Since(lastResolve) < refresh {
+			return addrs, nil
+		}
+		newAddrs, err := resolve()
+		if err != nil {
+			if addrs != nil {
+				log.Debugf("Unable to refresh external addresses, "+
+					"using cached addresses: %v", err)
+				return addrs, nil
+			}
+			return nil, err
+		}
+		addrs = newAddrs
+		lastResolve = time.Now()
+		return addrs, nil
+	}
+}
+
+// ExternalAddrScores counts how many remote peers have reported seeing the
+// local peer at a given IP address in their version messages.  Only the first
+// report of an address from each network group, the /16 for IPv4 and the /32
+// for IPv6, is counted, so a single operator controlling many peers in the same
+// network can't easily skew the scores.  The number of tracked addresses is
+// limited and the lowest scored entry is evicted to make room for a new
+// address once the limit is reached.
+//
+// An instance is meant to be shared among all of the peers of a server by way
+// of the ExternalAddrScores field of Config.
+type ExternalAddrScores struct {
+	mtx    sync.Mutex
+	scores map[string]map[string]struct{}
+	limit  int
+}
+
+// reporterGroup returns the key of the network group the passed address of a
+// reporting remote peer is part of.  This is the /16 for IPv4 and the /32 for
+// IPv6.
+func reporterGroup(na *wire.NetAddress) string {
+	if ip := na.IP.To4(); ip != nil {
+		return ip.Mask(net.CIDRMask(16, 32)).String()
+	}
+	return na.IP.Mask(net.CIDRMask(32, 128)).String()
+}
+
+// Add records that the remote peer at the reporter address has seen the local
+// peer at the reported address.  Reports of an address from a network group
+// that has already reported it are ignored.
+//
+// This function is safe for concurrent access.
+func (s *ExternalAddrScores) Add(reporter, reported *wire.NetAddress) {
+	if reporter == nil || reporter.IP == nil || reported == nil ||
+		reported.IP == nil || reported.IP.IsUnspecified() {
+
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	key := reported.IP.String()
+	groups, exists := s.scores[key]
+	if !exists {
+		if len(s.scores) >= s.limit {
+			var lowestKey string
+			lowestScore := -1
+			for k, groups := range s.scores {
+				score := len(groups)
+				if lowestScore == -1 || score < lowestScore {
+					lowestKey, lowestScore = k, score
+				}
+			}
+			delete(s.scores, lowestKey)
+		}
+		groups = make(map[string]struct{})
+		s.scores[key] = groups
+	}
+	if len(groups) < maxExternalAddrVotes {
+		groups[reporterGroup(reporter)] = struct{}{}
+	}
+}
+
+// Score returns the number of network groups remote peers have reported seeing
+// the local peer at the IP address of the passed address from.  Only the IP
+// address is considered since the port a remote peer sees is not necessarily
+// the one the local peer listens on.
+//
+// This function is safe for concurrent access.
+func (s *ExternalAddrScores) Score(na *wire.NetAddress) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return len(s.scores[na.IP.String()])
+}
+
+// NewExternalAddrScores returns a new, empty set of external address scores.
+func NewExternalAddrScores() *ExternalAddrScores {
+	return newExternalAddrScores(maxExternalAddrScores)
+}
+
+// newExternalAddrScores returns a new, empty set of external address scores
+// that is limited to the number of addresses specified by limit.
+func newExternalAddrScores(limit int) *ExternalAddrScores {
+	return &ExternalAddrScores{
+		scores: make(map[string]map[string]struct{}),
+		limit:  limit,
+	}
+}
+
+// bestExternalAddr returns the address from the passed preference ordered
+// list that should be advertised to the remote peer.  Addresses which remote
+// peers have reported seeing most often are preferred, with ties broken by
+// the original ordering, which is all that is considered when the passed scores
+// are nil.  Addresses of a different family than the remote peer are only
+// chosen when there is no address of the same family.  It returns nil when the
+// list is empty.
+func bestExternalAddr(addrs []*wire.NetAddress, remote *wire.NetAddress, scores *ExternalAddrScores) *wire.NetAddress {
+	remoteIsIPv4 := remote != nil && remote.IP.To4() != nil
+
+	var best *wire.NetAddress
+	var bestMatches bool
+	bestScore := -1
+	for _, na := range addrs {
+		if na == nil || na.IP == nil {
+			continue
+		}
+		matches := (na.IP.To4() != nil) == remoteIsIPv4
+		var score int
+		if scores != nil {
+			score = scores.Score(na)
+		}
+		switch {
+		case best == nil:
+		case matches && !bestMatches:
+		case matches == bestMatches && score > bestScore:
+		default:
+			continue
+		}
+		best, bestMatches, bestScore = na, matches, score
+	}
+	return best
+}
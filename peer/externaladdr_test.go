@@ -0,0 +1,166 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// TestBestExternalAddr ensures the external address selection prefers
+// addresses of the same family as the remote peer and the most commonly
+// reported addresses while otherwise keeping the configured order.
+func TestBestExternalAddr(t *testing.T) {
+	newNA := func(ip string) *wire.NetAddress {
+		return wire.NewNetAddressIPPort(net.ParseIP(ip), 8333, 0)
+	}
+	v4First := newNA("203.0.113.1")
+	v4Second := newNA("203.0.113.2")
+	v6 := newNA("2001:db8::1")
+	remoteV4 := newNA("198.51.100.1")
+	remoteV6 := newNA("2001:db8::2")
+
+	scores := NewExternalAddrScores()
+
+	tests := []struct {
+		name   string
+		addrs  []*wire.NetAddress
+		remote *wire.NetAddress
+		echo   *wire.NetAddress
+		want   *wire.NetAddress
+	}{
+		{"no addresses", nil, remoteV4, nil, nil},
+		{"configured order", []*wire.NetAddress{v4First, v4Second},
+			remoteV4, nil, v4First},
+		{"same family", []*wire.NetAddress{v6, v4First}, remoteV4,
+			nil, v4First},
+		{"ipv6 remote", []*wire.NetAddress{v4First, v6}, remoteV6,
+			nil, v6},
+		{"other family fallback", []*wire.NetAddress{v6}, remoteV4,
+			nil, v6},
+		{"most seen", []*wire.NetAddress{v4First, v4Second}, remoteV4,
+			v4Second, v4Second},
+		{"most seen ignores other family", []*wire.NetAddress{v4Second,
+			v6}, remoteV6, v4Second, v6},
+	}
+
+	for _, test := range tests {
+		if test.echo != nil {
+			scores.Add(remoteV4, test.echo)
+		}
+		got := bestExternalAddr(test.addrs, test.remote, scores)
+		if got != test.want {
+			t.Errorf("%s: unexpected address - got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+
+	// Only the configured order is considered without scores.
+	got := bestExternalAddr([]*wire.NetAddress{v4First, v4Second},
+		remoteV4, nil)
+	if got != v4First {
+		t.Errorf("no scores: unexpected address - got %v, want %v", got,
+			v4First)
+	}
+}
+
+// TestExternalAddrScores ensures the external address scores count reports by
+// IP, only once per network group of the reporting peers, and evict the lowest
+// scored entry once the limit is reached.
+func TestExternalAddrScores(t *testing.T) {
+	newNA := func(ip string, port uint16) *wire.NetAddress {
+		return wire.NewNetAddressIPPort(net.ParseIP(ip), port, 0)
+	}
+	s := newExternalAddrScores(2)
+	a := newNA("203.0.113.1", 1)
+	aOtherPort := newNA("203.0.113.1", 2)
+	b := newNA("203.0.113.2", 1)
+	c := newNA("203.0.113.3", 1)
+	reporter1 := newNA("198.51.100.1", 8333)
+	reporter1SameGroup := newNA("198.51.1.1", 8333)
+	reporter2 := newNA("192.0.2.1", 8333)
+	reporter3 := newNA("2001:db8:1::1", 8333)
+	reporter3SameGroup := newNA("2001:db8:2::1", 8333)
+
+	s.Add(reporter1, a)
+	s.Add(reporter1SameGroup, aOtherPort)
+	s.Add(reporter2, aOtherPort)
+	s.Add(reporter3, a)
+	s.Add(reporter3SameGroup, a)
+	s.Add(reporter1, b)
+	s.Add(reporter2, newNA("0.0.0.0", 0))
+	s.Add(nil, b)
+	if got := s.Score(a); got != 3 {
+		t.Fatalf("unexpected score for %v - got %d, want 3", a.IP, got)
+	}
+	if got := s.Score(b); got != 1 {
+		t.Fatalf("unexpected score for %v - got %d, want 1", b.IP, got)
+	}
+
+	// Adding a new address must evict the lowest scored entry.
+	s.Add(reporter1, c)
+	if got := s.Score(b); got != 0 {
+		t.Fatalf("lowest scored entry was not evicted - got %d", got)
+	}
+	if got := s.Score(a); got != 3 {
+		t.Fatalf("unexpected score for %v - got %d, want 3", a.IP, got)
+	}
+	if got := s.Score(c); got != 1 {
+		t.Fatalf("unexpected score for %v - got %d, want 1", c.IP, got)
+	}
+}
+
+// TestExternalAddrCache ensures the external address cache only refreshes the
+// addresses once the refresh interval elapses and keeps serving the cached
+// addresses when a refresh fails.
+func TestExternalAddrCache(t *testing.T) {
+	na := wire.NewNetAddressIPPort(net.ParseIP("203.0.113.1"), 8333, 0)
+	var calls int
+	var fail bool
+	resolve := func() ([]*wire.NetAddress, error) {
+		calls++
+		if fail {
+			return nil, errors.New("resolve failed")
+		}
+		return []*wire.NetAddress{na}, nil
+	}
+
+	// Errors must be returned until addresses have been resolved once.
+	fail = true
+	cached := NewExternalAddrCache(resolve, time.Hour)
+	if _, err := cached(); err == nil {
+		t.Fatalf("expected error from failed initial resolve")
+	}
+
+	fail = false
+	for i := 0; i < 3; i++ {
+		addrs, err := cached()
+		if err != nil || len(addrs) != 1 || addrs[0] != na {
+			t.Fatalf("unexpected result - got %v, %v", addrs, err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("unexpected number of resolves - got %d, want 2", calls)
+	}
+
+	// A zero refresh interval resolves every time and falls back to the
+	// last resolved addresses on failure.
+	calls = 0
+	cached = NewExternalAddrCache(resolve, 0)
+	cached()
+	fail = true
+	addrs, err := cached()
+	if err != nil || len(addrs) != 1 || addrs[0] != na {
+		t.Fatalf("unexpected result - got %v, %v", addrs, err)
+	}
+	if calls != 2 {
+		t.Fatalf("unexpected number of resolves - got %d, want 2", calls)
+	}
+}
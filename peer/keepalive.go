@@ -0,0 +1,131 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peer
+
+import (
+	"errors"
+	"net"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// ErrIdleProbeTimeout is the disconnect reason of a peer which did not send any
+// message in response to an idle probe.
+var ErrIdleProbeTimeout = errors.New("no response to idle probe")
+
+// clock provides the current time and timers for the time dependent behavior
+// of a peer so it can be tested without waiting in real time.
+type clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel which receives the current time once the
+	// passed duration has elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the clock implementation which uses the system time.
+type realClock struct{}
+
+// Now returns the current system time.
+//
+// This is part of the clock interface implementation.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// After returns a channel which receives the current system time once the
+// passed duration has elapsed.
+//
+// This is part of the clock interface implementation.
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// setKeepAlive enables TCP keepalive probes with the configured period on the
+// passed connection when it is a TCP connection.  Connections of other types,
+// such as those through a proxy, are left unchanged.
+func (p *Peer) setKeepAlive(conn net.Conn) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok || p.cfg.TCPKeepAlive <= 0 {
+		return
+	}
+	if err := tcpConn.SetKeepAlive(true); err != nil {
+		log.Debugf("Unable to enable keepalive for %s: %v", p, err)
+		return
+	}
+	err := tcpConn.SetKeepAlivePeriod(p.cfg.TCPKeepAlive)
+	if err != nil {
+		log.Debugf("Unable to set keepalive period for %s: %v", p, err)
+	}
+}
+
+// idleProbeHandler sends a ping to the remote peer as soon as no message has
+// been received from it for the configured idle probe interval and disconnects
+// the peer when it does not send any message within another interval after the
+// ping.  It must be run as a goroutine.
+func (p *Peer) idleProbeHandler() {
+	interval := p.cfg.IdleProbeInterval
+
+	// The peer is not considered idle before the handler starts, even when
+	// no message has been received yet.
+	started := p.clock.Now()
+	var probeSent time.Time
+out:
+	for {
+		now := p.clock.Now()
+		lastRecv := p.LastRecv()
+		if lastRecv.Before(started) {
+			lastRecv = started
+		}
+
+		// Any message received since the probe was sent answers it.
+		// The receive time only has a resolution of one second, however
+		// the probe is only sent after a full interval without any
+		// messages, so earlier messages can't be mistaken for answers.
+		var wait time.Duration
+		if !probeSent.IsZero() {
+			deadline := probeSent.Add(interval)
+			switch {
+			case lastRecv.Unix() >= probeSent.Unix():
+				probeSent = time.Time{}
+			case !now.Before(deadline):
+				log.Warnf("Peer %s no answer to idle probe for %s "+
+					"-- disconnecting", p, interval)
+				p.disconnectWithReason(ErrIdleProbeTimeout)
+				break out
+			default:
+				wait = deadline.Sub(now)
+			}
+		}
+		if probeSent.IsZero() {
+			idle := now.Sub(lastRecv)
+			if idle < interval {
+				wait = interval - idle
+			} else {
+				nonce, err := wire.RandomUint64()
+				if err != nil {
+					log.Errorf("Not sending idle probe to %s: %v",
+						p, err)
+					break out
+				}
+				log.Debugf("Peer %s idle for %s -- sending probe", p,
+					idle)
+				p.QueueMessage(wire.NewMsgPing(nonce), nil)
+				probeSent = now
+				wait = interval
+			}
+		}
+
+		select {
+		case <-p.clock.After(wait):
+		case <-p.quit:
+			break out
+		}
+	}
+	log.Tracef("Peer idle probe handler done for %s", p)
+}
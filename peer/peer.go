@@ -8,6 +8,7 @@ package peer
 import (
 	"bytes"
 	"container/list"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
@@ -29,9 +30,18 @@ const (
 	// MaxProtocolVersion is the max protocol version the peer supports.
 	MaxProtocolVersion = wire.SendHeadersVersion
 
+	// DefaultMinAcceptableProtocolVersion is the default minimum protocol
+	// version a remote peer must advertise for the connection to be
+	// accepted.
+	DefaultMinAcceptableProtocolVersion = wire.MultipleAddressVersion
+
 	// outputBufferSize is the number of elements the output channels use.
 	outputBufferSize = 50
 
+	// observerOutputBufferSize is the number of elements the output
+	// channel of observer peers uses.
+	observerOutputBufferSize = 4
+
 	// invTrickleSize is the maximum amount of inventory to send in a single
 	// message when trickling inventory to remote peers.
 	maxInvTrickleSize = 1000
@@ -44,6 +54,15 @@ const (
 	// messages.
 	pingInterval = 2 * time.Minute
 
+	// maxPingSamples is the maximum number of the most recent ping round
+	// trip times kept for each peer.
+	maxPingSamples = 32
+
+	// otherCommand is the command the bytes of messages which could not be
+	// read, such as those with an unknown command, are counted under in
+	// the per message byte counters.
+	otherCommand = "*other*"
+
 	// negotiateTimeout is the duration of inactivity before we timeout a
 	// peer that hasn't completed the initial version negotiation.
 	negotiateTimeout = 30 * time.Second
@@ -64,6 +83,17 @@ const (
 	// trickleTimeout is the duration of the ticker which trickles down the
 	// inventory to a peer.
 	trickleTimeout = 10 * time.Second
+
+	// maxRecvPayloadViolations is the number of messages exceeding the
+	// configured maximum receive payload a remote peer may send before it
+	// is disconnected.
+	maxRecvPayloadViolations = 3
+
+	// maxProtocolMessageLength is the maximum message payload accepted by
+	// remote peers which advertise at least the reject protocol version.
+	// Those peers are based on reference implementations which refuse
+	// messages larger than this regardless of the protocol maximum.
+	maxProtocolMessageLength = 2 * 1024 * 1024
 )
 
 var (
@@ -94,6 +124,12 @@ var (
 // blocking calls (such as WaitForShutdown) on the peer instance since the input
 // handler goroutine blocks until the callback has completed.  Doing so will
 // result in a deadlock.
+//
+// NOTE: Ping, pong, and small inv messages are decoded into storage which is
+// reused for the next message read from the peer as described by
+// wire.MessageReader.  Listeners which retain those messages or their
+// inventory vectors after they return, such as by handing them off to another
+// goroutine, must copy them.  All other messages may be retained as is.
 type MessageListeners struct {
 	// OnGetAddr is invoked when a peer receives a getaddr bitcoin message.
 	OnGetAddr func(p *Peer, msg *wire.MsgGetAddr)
@@ -101,10 +137,12 @@ type MessageListeners struct {
 	// OnAddr is invoked when a peer receives an addr bitcoin message.
 	OnAddr func(p *Peer, msg *wire.MsgAddr)
 
-	// OnPing is invoked when a peer receives a ping bitcoin message.
+	// OnPing is invoked when a peer receives a ping bitcoin message.  The
+	// message is only valid until the listener returns.
 	OnPing func(p *Peer, msg *wire.MsgPing)
 
-	// OnPong is invoked when a peer receives a pong bitcoin message.
+	// OnPong is invoked when a peer receives a pong bitcoin message.  The
+	// message is only valid until the listener returns.
 	OnPong func(p *Peer, msg *wire.MsgPong)
 
 	// OnAlert is invoked when a peer receives an alert bitcoin message.
@@ -116,10 +154,19 @@ type MessageListeners struct {
 	// OnTx is invoked when a peer receives a tx bitcoin message.
 	OnTx func(p *Peer, msg *wire.MsgTx)
 
+	// OnTxWithSha is invoked instead of OnTx when it is set and a peer
+	// receives a tx bitcoin message.  The hash of the transaction is
+	// passed along with it.  It is usually known from verifying the
+	// message checksum, so the listener does not need to hash the
+	// transaction again.
+	OnTxWithSha func(p *Peer, msg *wire.MsgTx, txHash *wire.ShaHash)
+
 	// OnBlock is invoked when a peer receives a block bitcoin message.
 	OnBlock func(p *Peer, msg *wire.MsgBlock, buf []byte)
 
-	// OnInv is invoked when a peer receives an inv bitcoin message.
+	// OnInv is invoked when a peer receives an inv bitcoin message.  The
+	// message and its inventory vectors are only valid until the listener
+	// returns.
 	OnInv func(p *Peer, msg *wire.MsgInv)
 
 	// OnHeaders is invoked when a peer receives a headers bitcoin message.
@@ -140,6 +187,10 @@ type MessageListeners struct {
 	// message.
 	OnGetHeaders func(p *Peer, msg *wire.MsgGetHeaders)
 
+	// OnGetSkeleton is invoked when a peer receives a getskeleton bitcoin
+	// message.
+	OnGetSkeleton func(p *Peer, msg *wire.MsgGetSkeleton)
+
 	// OnFilterAdd is invoked when a peer receives a filteradd bitcoin message.
 	// Peers that do not advertise support for bloom filters and negotiate to a
 	// protocol version before BIP0111 will simply ignore the message while
@@ -173,6 +224,11 @@ type MessageListeners struct {
 	// OnVerAck is invoked when a peer receives a verack bitcoin message.
 	OnVerAck func(p *Peer, msg *wire.MsgVerAck)
 
+	// OnHandshakeComplete is invoked when the version handshake with the
+	// remote peer is complete, which is when its verack message has been
+	// received.  It is passed the version message of the remote peer.
+	OnHandshakeComplete func(p *Peer, msg *wire.MsgVersion)
+
 	// OnReject is invoked when a peer receives a reject bitcoin message.
 	OnReject func(p *Peer, msg *wire.MsgReject)
 
@@ -186,7 +242,8 @@ type MessageListeners struct {
 	// the callbacks for the specific message types, however this can be
 	// useful for circumstances such as keeping track of server-wide byte
 	// counts or working with custom message types for which the peer does
-	// not directly provide a callback.
+	// not directly provide a callback.  The message may only be valid until
+	// the listener returns as described by MessageListeners.
 	OnRead func(p *Peer, bytesRead int, msg wire.Message, err error)
 
 	// OnWrite is invoked when we write a bitcoin message to a peer.  It
@@ -208,6 +265,23 @@ type Config struct {
 	// BestLocalAddress returns the best local address for a given address.
 	BestLocalAddress AddrFunc
 
+	// ExternalAddrs returns the externally reachable addresses of the local
+	// peer in order of preference.  When set, the best of these addresses
+	// for the remote peer is advertised in the version message instead of
+	// the one provided by BestLocalAddress, which is only used as a
+	// fallback when no addresses are returned.  The function is invoked
+	// for every connection, so NewExternalAddrCache should be used to
+	// periodically refresh expensive lookups.  This field can be omitted.
+	ExternalAddrs ExternalAddrsFunc
+
+	// ExternalAddrScores tracks the addresses outbound peers report seeing
+	// the local peer at in their version messages.  When set, the most
+	// commonly reported of the addresses returned by ExternalAddrs is
+	// advertised.  It should be shared among all of the peers of a server
+	// and can be omitted, in which case reports are ignored and the
+	// preference order of ExternalAddrs is used.
+	ExternalAddrScores *ExternalAddrScores
+
 	// HostToNetAddress returns the netaddress for the given host. This can be
 	// nil in  which case the host will be parsed as an IP address.
 	HostToNetAddress HostToNetAddrFunc
@@ -245,6 +319,64 @@ type Config struct {
 	// not send inv messages for transactions.
 	DisableRelayTx bool
 
+	// MinAcceptableProtocolVersion specifies the minimum protocol version
+	// the remote peer must advertise in its version message.  Peers which
+	// advertise an older version are sent a reject message and
+	// disconnected.  This field can be omitted in which case
+	// peer.DefaultMinAcceptableProtocolVersion will be used.
+	MinAcceptableProtocolVersion uint32
+
+	// MaxRecvPayload specifies the maximum payload of messages accepted
+	// from the remote peer.  It is checked against the length declared in
+	// the message header before anything is allocated for the payload, so
+	// memory constrained nodes can refuse large messages early.  Messages
+	// exceeding it are discarded and counted as misbehavior, and the peer
+	// is disconnected after repeated violations.  This field can be
+	// omitted in which case wire.MaxMessagePayload will be used.
+	MaxRecvPayload uint32
+
+	// TLSConfig specifies the TLS configuration used to encrypt and
+	// authenticate the connection.  When set, the connection passed to
+	// Connect is wrapped in a TLS client (outbound) or server (inbound)
+	// and the TLS handshake must complete before the version handshake
+	// begins.  This field can be omitted in which case the connection is
+	// used unencrypted.
+	TLSConfig *tls.Config
+
+	// TCPKeepAlive specifies the period between TCP keepalive probes for
+	// the connection passed to Connect.  The probes allow the operating
+	// system to detect dead connections, and keep stateful firewalls from
+	// silently dropping idle ones.  It only applies to TCP connections and
+	// can be omitted in which case the keepalive settings of the
+	// connection are left unchanged.
+	TCPKeepAlive time.Duration
+
+	// IdleProbeInterval specifies the duration without receiving any
+	// message from the remote peer after which a ping is sent right away
+	// instead of waiting for the next regular ping.  The peer is
+	// disconnected when no message is received within the same duration
+	// after the ping.  This field can be omitted in which case idle peers
+	// are only pinged at the regular interval.
+	IdleProbeInterval time.Duration
+
+	// SendCoalesceDelay specifies the duration data messages, such as
+	// inventory, headers, and transaction messages, are held back before
+	// being written to the remote peer so that bursts of them share a
+	// single write to the connection.  Other messages are written right
+	// away along with any data messages held back before them.  This field
+	// can be omitted in which case every message is written right away.
+	SendCoalesceDelay time.Duration
+
+	// Observer specifies that the peer is a lightweight observer, such as
+	// used by network crawlers and monitoring tools, which completes the
+	// version handshake, collects the data sent by the remote peer and is
+	// then typically disconnected.  The only messages an observer sends
+	// automatically are verack and pong messages, and an empty addr
+	// message in response to getaddr messages.  It does not track or relay
+	// inventory, send periodic pings or reject messages, or detect stalled
+	// responses, which considerably reduces the resources used per peer.
+	Observer bool
+
 	// Listeners houses callback functions to be invoked on receiving peer
 	// messages.
 	Listeners MessageListeners
@@ -304,6 +436,11 @@ func newNetAddress(addr net.Addr, services wire.ServiceFlag) (*wire.NetAddress,
 type outMsg struct {
 	msg      wire.Message
 	doneChan chan<- struct{}
+
+	// coalescedDone houses the done channels of the messages which were
+	// combined into msg while they were queued.  They are signaled along
+	// with doneChan.
+	coalescedDone []chan<- struct{}
 }
 
 // stallControlCmd represents the command of a stall control message.
@@ -351,8 +488,43 @@ type StatsSnap struct {
 	LastPingNonce  uint64
 	LastPingTime   time.Time
 	LastPingMicros int64
+	Encrypted      bool
+
+	// PingSamples houses the round trip times in microseconds of the most
+	// recent pings answered by the peer, oldest first.
+	PingSamples []int64
+
+	// StallCount is the number of responses the peer did not deliver
+	// before their deadline.
+	StallCount uint32
+
+	// BytesSentPerMsg and BytesRecvPerMsg house the number of bytes sent
+	// to and received from the peer keyed by message command.  The bytes
+	// of messages which could not be read are counted under "*other*".
+	BytesSentPerMsg map[string]uint64
+	BytesRecvPerMsg map[string]uint64
+}
+
+// TLSHandshakeError describes a failure to establish a TLS session with the
+// remote peer, such as when its certificate could not be verified.  It is
+// returned before any bitcoin protocol messages are exchanged.
+type TLSHandshakeError struct {
+	Err error // Underlying error returned by the TLS handshake
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e *TLSHandshakeError) Error() string {
+	return fmt.Sprintf("TLS handshake failed: %v", e.Err)
 }
 
+// ErrFlushTimeout is returned by DisconnectAfterFlush when the queued messages
+// could not be written to the remote peer before the timeout elapsed.
+var ErrFlushTimeout = errors.New("timeout flushing queued messages")
+
+// ErrNegotiateTimeout is the disconnect reason of a peer which did not
+// complete the version handshake within the negotiation timeout.
+var ErrNegotiateTimeout = errors.New("protocol negotiation timeout")
+
 // ShaFunc is a function which returns a block sha, height and error
 // It is used as a callback to get newest block details.
 type ShaFunc func() (sha *wire.ShaHash, height int32, err error)
@@ -399,17 +571,26 @@ type Peer struct {
 	lastSend      int64
 	connected     int32
 	disconnect    int32
+	flushing      int32
+	stallCount    uint32
 
 	conn net.Conn
 
+	// msgReader reads messages from conn reusing its buffers across reads.
+	// It is only accessed by the goroutine reading from conn.
+	msgReader *wire.MessageReader
+
 	// These fields are set at creation time and never modified, so they are
 	// safe to read from concurrently without a mutex.
 	addr    string
 	cfg     Config
 	inbound bool
+	clock   clock
 
 	flagsMtx             sync.Mutex // protects the peer flags below
 	na                   *wire.NetAddress
+	advertisedAddr       *wire.NetAddress
+	disconnectReason     error
 	id                   int32
 	userAgent            string
 	services             wire.ServiceFlag
@@ -418,6 +599,12 @@ type Peer struct {
 	sendHeadersPreferred bool // peer sent a sendheaders message
 	versionSent          bool
 	verAckReceived       bool
+	encrypted            bool
+
+	// remoteVersionMsg is the version message of the remote peer.  It is
+	// only kept until the handshake is complete and only when the
+	// OnHandshakeComplete listener is set.
+	remoteVersionMsg *wire.MsgVersion
 
 	knownInventory     *mruInventoryMap
 	prevGetBlocksMtx   sync.Mutex
@@ -438,6 +625,14 @@ type Peer struct {
 	lastPingNonce      uint64    // Set to nonce if we have a pending ping.
 	lastPingTime       time.Time // Time we sent last ping.
 	lastPingMicros     int64     // Time for last ping to return.
+	pingSamples        []int64   // Recent ping round trip times in usec.
+
+	// These fields count the bytes sent and received by message command.
+	// They are protected by the msgBytesMtx mutex and only allocated once
+	// a message is counted.
+	msgBytesMtx     sync.Mutex
+	bytesSentPerMsg map[string]uint64
+	bytesRecvPerMsg map[string]uint64
 
 	stallControl  chan stallControlMsg
 	outputQueue   chan outMsg
@@ -486,6 +681,11 @@ func (p *Peer) UpdateLastAnnouncedBlock(blkSha *wire.ShaHash) {
 //
 // This function is safe for concurrent access.
 func (p *Peer) AddKnownInventory(invVect *wire.InvVect) {
+	// Observers don't track inventory.
+	if p.knownInventory == nil {
+		return
+	}
+
 	p.knownInventory.Add(invVect)
 }
 
@@ -502,28 +702,66 @@ func (p *Peer) StatsSnapshot() *StatsSnap {
 	userAgent := p.userAgent
 	services := p.services
 	protocolVersion := p.protocolVersion
+	encrypted := p.encrypted
 	p.flagsMtx.Unlock()
 
 	// Get a copy of all relevant flags and stats.
 	return &StatsSnap{
-		ID:             id,
-		Addr:           addr,
-		UserAgent:      userAgent,
-		Services:       services,
-		LastSend:       p.LastSend(),
-		LastRecv:       p.LastRecv(),
-		BytesSent:      p.BytesSent(),
-		BytesRecv:      p.BytesReceived(),
-		ConnTime:       p.timeConnected,
-		TimeOffset:     p.timeOffset,
-		Version:        protocolVersion,
-		Inbound:        p.inbound,
-		StartingHeight: p.startingHeight,
-		LastBlock:      p.lastBlock,
-		LastPingNonce:  p.lastPingNonce,
-		LastPingMicros: p.lastPingMicros,
-		LastPingTime:   p.lastPingTime,
+		ID:              id,
+		Addr:            addr,
+		UserAgent:       userAgent,
+		Services:        services,
+		LastSend:        p.LastSend(),
+		LastRecv:        p.LastRecv(),
+		BytesSent:       p.BytesSent(),
+		BytesRecv:       p.BytesReceived(),
+		ConnTime:        p.timeConnected,
+		TimeOffset:      p.timeOffset,
+		Version:         protocolVersion,
+		Inbound:         p.inbound,
+		StartingHeight:  p.startingHeight,
+		LastBlock:       p.lastBlock,
+		LastPingNonce:   p.lastPingNonce,
+		LastPingMicros:  p.lastPingMicros,
+		LastPingTime:    p.lastPingTime,
+		Encrypted:       encrypted,
+		PingSamples:     append([]int64(nil), p.pingSamples...),
+		StallCount:      atomic.LoadUint32(&p.stallCount),
+		BytesSentPerMsg: p.msgBytes(p.bytesSentPerMsg),
+		BytesRecvPerMsg: p.msgBytes(p.bytesRecvPerMsg),
+	}
+}
+
+// msgBytes returns a copy of the passed per message byte counters of the peer.
+func (p *Peer) msgBytes(counters map[string]uint64) map[string]uint64 {
+	p.msgBytesMtx.Lock()
+	defer p.msgBytesMtx.Unlock()
+
+	msgBytes := make(map[string]uint64, len(counters))
+	for command, n := range counters {
+		msgBytes[command] = n
+	}
+	return msgBytes
+}
+
+// addMsgBytes counts the passed number of bytes sent or received for the
+// passed message, which is counted under otherCommand when it is nil, in the
+// passed per message byte counters.
+func (p *Peer) addMsgBytes(counters *map[string]uint64, msg wire.Message, n int) {
+	if n == 0 {
+		return
 	}
+	command := otherCommand
+	if msg != nil {
+		command = msg.Command()
+	}
+
+	p.msgBytesMtx.Lock()
+	if *counters == nil {
+		*counters = make(map[string]uint64)
+	}
+	(*counters)[command] += uint64(n)
+	p.msgBytesMtx.Unlock()
 }
 
 // ID returns the peer id.
@@ -546,6 +784,68 @@ func (p *Peer) NA() *wire.NetAddress {
 	return p.na
 }
 
+// AdvertisedAddr returns the local address that was advertised to the remote
+// peer in the version message.  It is nil until the version message has been
+// sent.  This is useful for advertising the same address when replying to
+// getaddr messages.
+//
+// This function is safe for concurrent access.
+func (p *Peer) AdvertisedAddr() *wire.NetAddress {
+	p.flagsMtx.Lock()
+	defer p.flagsMtx.Unlock()
+
+	return p.advertisedAddr
+}
+
+// DisconnectReason returns the reason the peer disconnected the remote peer
+// when it did so due to the remote peer misbehaving or the connection failing,
+// or nil otherwise.  A remote peer which repeatedly sent messages exceeding the
+// configured maximum receive payload has a reason of type
+// *wire.PayloadLimitError, and the reason for a failed version handshake is
+// the error which caused it, such as ErrNegotiateTimeout.
+//
+// This function is safe for concurrent access.
+func (p *Peer) DisconnectReason() error {
+	p.flagsMtx.Lock()
+	defer p.flagsMtx.Unlock()
+
+	return p.disconnectReason
+}
+
+// disconnectWithReason records the passed reason the peer is being
+// disconnected, unless a reason was already recorded, and disconnects it.
+func (p *Peer) disconnectWithReason(reason error) {
+	p.flagsMtx.Lock()
+	if p.disconnectReason == nil {
+		p.disconnectReason = reason
+	}
+	p.flagsMtx.Unlock()
+
+	p.Disconnect()
+}
+
+// maxRecvPayload returns the maximum payload accepted from the remote peer.
+func (p *Peer) maxRecvPayload() uint32 {
+	if p.cfg.MaxRecvPayload != 0 {
+		return minUint32(p.cfg.MaxRecvPayload, wire.MaxMessagePayload)
+	}
+	return wire.MaxMessagePayload
+}
+
+// maxSendPayload returns the maximum payload the remote peer is expected to
+// accept based on the negotiated protocol version.
+func (p *Peer) maxSendPayload() uint32 {
+	p.flagsMtx.Lock()
+	versionKnown := p.versionKnown
+	protocolVersion := p.protocolVersion
+	p.flagsMtx.Unlock()
+
+	if versionKnown && protocolVersion >= wire.RejectVersion {
+		return maxProtocolMessageLength
+	}
+	return wire.MaxMessagePayload
+}
+
 // Addr returns the peer address.
 //
 // This function is safe for concurrent access.
@@ -725,6 +1025,17 @@ func (p *Peer) StartingHeight() int32 {
 	return p.startingHeight
 }
 
+// Encrypted returns whether or not the connection to the peer is protected by
+// a successfully negotiated TLS session.
+//
+// This function is safe for concurrent access.
+func (p *Peer) Encrypted() bool {
+	p.flagsMtx.Lock()
+	defer p.flagsMtx.Unlock()
+
+	return p.encrypted
+}
+
 // WantsHeaders returns if the peer wants header messages instead of
 // inventory vectors for blocks.
 //
@@ -764,12 +1075,30 @@ func (p *Peer) localVersionMsg() (*wire.MsgVersion, error) {
 		}
 	}
 
-	// TODO(tuxcanfly): In case BestLocalAddress is nil, ourNA defaults to
-	// remote NA, which is wrong. Need to fix this.
-	ourNA := p.na
-	if p.cfg.BestLocalAddress != nil {
+	// Advertise the most suitable configured external address, falling
+	// back to the best local address when there are none.
+	//
+	// TODO(tuxcanfly): In case neither ExternalAddrs nor BestLocalAddress
+	// provide an address, ourNA defaults to remote NA, which is wrong.
+	// Need to fix this.
+	var ourNA *wire.NetAddress
+	if p.cfg.ExternalAddrs != nil {
+		addrs, err := p.cfg.ExternalAddrs()
+		if err != nil {
+			log.Debugf("Unable to resolve external addresses for "+
+				"peer %s: %v", p, err)
+		}
+		ourNA = bestExternalAddr(addrs, p.na, p.cfg.ExternalAddrScores)
+	}
+	if ourNA == nil && p.cfg.BestLocalAddress != nil {
 		ourNA = p.cfg.BestLocalAddress(p.na)
 	}
+	if ourNA == nil {
+		ourNA = p.na
+	}
+	p.flagsMtx.Lock()
+	p.advertisedAddr = ourNA
+	p.flagsMtx.Unlock()
 
 	// Generate a unique nonce for this peer so self connections can be
 	// detected.  This is accomplished by adding it to a size-limited map of
@@ -849,6 +1178,25 @@ func (p *Peer) PushAddrMsg(addresses []*wire.NetAddress) ([]*wire.NetAddress, er
 	return msg.AddrList, nil
 }
 
+// QueueSelfAdvertisement queues an addr message which only contains the passed
+// local address so the remote peer learns how to reach us without having to
+// ask via getaddr.  The advertised address is stamped with the current time so
+// the remote peer treats it as fresh.  Nothing is queued when the address is
+// nil.
+//
+// This function is safe for concurrent access.
+func (p *Peer) QueueSelfAdvertisement(na *wire.NetAddress) {
+	if na == nil {
+		return
+	}
+
+	self := *na
+	self.Timestamp = time.Unix(p.clock.Now().Unix(), 0)
+	msg := wire.NewMsgAddr()
+	msg.AddrList = []*wire.NetAddress{&self}
+	p.QueueMessage(msg, nil)
+}
+
 // PushGetBlocksMsg sends a getblocks message for the provided block locator
 // and stop hash.  It will ignore back-to-back duplicate requests.
 //
@@ -941,10 +1289,16 @@ func (p *Peer) PushGetHeadersMsg(locator blockchain.BlockLocator, stopHash *wire
 // PushRejectMsg sends a reject message for the provided command, reject code,
 // reject reason, and hash.  The hash will only be used when the command is a tx
 // or block and should be nil in other cases.  The wait parameter will cause the
-// function to block until the reject message has actually been sent.
+// function to block until the reject message has actually been sent.  Nothing
+// is sent by observer peers.
 //
 // This function is safe for concurrent access.
 func (p *Peer) PushRejectMsg(command string, code wire.RejectCode, reason string, hash *wire.ShaHash, wait bool) {
+	// Observers never send reject messages.
+	if p.cfg.Observer {
+		return
+	}
+
 	// Don't bother sending the reject message if the protocol version
 	// is too low.
 	if p.VersionKnown() && p.ProtocolVersion() < wire.RejectVersion {
@@ -985,15 +1339,34 @@ func (p *Peer) handleRemoteVersionMsg(msg *wire.MsgVersion) error {
 
 	// Notify and disconnect clients that have a protocol version that is
 	// too old.
-	if msg.ProtocolVersion < int32(wire.MultipleAddressVersion) {
+	minProtocolVersion := uint32(DefaultMinAcceptableProtocolVersion)
+	if p.cfg.MinAcceptableProtocolVersion != 0 {
+		minProtocolVersion = p.cfg.MinAcceptableProtocolVersion
+	}
+	if msg.ProtocolVersion < int32(minProtocolVersion) {
 		// Send a reject message indicating the protocol version is
 		// obsolete and wait for the message to be sent before
 		// disconnecting.
-		reason := fmt.Sprintf("protocol version must be %d or greater",
-			wire.MultipleAddressVersion)
-		rejectMsg := wire.NewMsgReject(msg.Command(), wire.RejectObsolete,
-			reason)
-		return p.writeMessage(rejectMsg)
+		if !p.cfg.Observer {
+			reason := fmt.Sprintf("protocol version must be %d "+
+				"or greater", minProtocolVersion)
+			rejectMsg := wire.NewMsgReject(msg.Command(),
+				wire.RejectObsolete, reason)
+			if err := p.writeMessage(rejectMsg); err != nil {
+				return err
+			}
+		}
+		return fmt.Errorf("protocol version %d is below the minimum "+
+			"acceptable version %d", msg.ProtocolVersion,
+			minProtocolVersion)
+	}
+
+	// Keep track of the address outbound peers report seeing us at so the
+	// most commonly seen external address can be preferred.  Reports from
+	// inbound peers are ignored since anyone can connect and claim any
+	// address, while outbound peers are chosen by the local peer.
+	if !p.inbound && p.cfg.ExternalAddrScores != nil {
+		p.cfg.ExternalAddrScores.Add(p.na, &msg.AddrYou)
 	}
 
 	// Updating a bunch of stats.
@@ -1072,14 +1445,23 @@ func (p *Peer) handlePongMsg(msg *wire.MsgPong) {
 		p.lastPingMicros = time.Now().Sub(p.lastPingTime).Nanoseconds()
 		p.lastPingMicros /= 1000 // convert to usec.
 		p.lastPingNonce = 0
+
+		// Keep the most recent round trip times for the ping
+		// statistics.
+		if len(p.pingSamples) == maxPingSamples {
+			copy(p.pingSamples, p.pingSamples[1:])
+			p.pingSamples = p.pingSamples[:maxPingSamples-1]
+		}
+		p.pingSamples = append(p.pingSamples, p.lastPingMicros)
 	}
 }
 
 // readMessage reads the next bitcoin message from the peer with logging.
 func (p *Peer) readMessage() (wire.Message, []byte, error) {
-	n, msg, buf, err := wire.ReadMessageN(p.conn, p.ProtocolVersion(),
-		p.cfg.ChainParams.Net)
+	n, msg, buf, err := p.msgReader.ReadMessage(p.ProtocolVersion(),
+		p.cfg.ChainParams.Net, p.maxRecvPayload())
 	atomic.AddUint64(&p.bytesReceived, uint64(n))
+	p.addMsgBytes(&p.bytesRecvPerMsg, msg, n)
 	if p.cfg.Listeners.OnRead != nil {
 		p.cfg.Listeners.OnRead(p, n, msg, err)
 	}
@@ -1110,6 +1492,13 @@ func (p *Peer) readMessage() (wire.Message, []byte, error) {
 
 // writeMessage sends a bitcoin message to the peer with logging.
 func (p *Peer) writeMessage(msg wire.Message) error {
+	return p.writeMessageTo(p.conn, msg)
+}
+
+// writeMessageTo writes a bitcoin message for the peer to the passed writer
+// with logging.  It is used to write messages to the connection of the peer
+// directly or to a buffer which is written to the connection later.
+func (p *Peer) writeMessageTo(w io.Writer, msg wire.Message) error {
 	// Don't do anything if we're disconnecting.
 	if atomic.LoadInt32(&p.disconnect) != 0 {
 		return nil
@@ -1140,9 +1529,10 @@ func (p *Peer) writeMessage(msg wire.Message) error {
 	}))
 
 	// Write the message to the peer.
-	n, err := wire.WriteMessageN(p.conn, msg, p.ProtocolVersion(),
-		p.cfg.ChainParams.Net)
+	n, err := wire.WriteMessageLimitN(w, msg, p.ProtocolVersion(),
+		p.cfg.ChainParams.Net, p.maxSendPayload())
 	atomic.AddUint64(&p.bytesSent, uint64(n))
+	p.addMsgBytes(&p.bytesSentPerMsg, msg, n)
 	if p.cfg.Listeners.OnWrite != nil {
 		p.cfg.Listeners.OnWrite(p, n, msg, err)
 	}
@@ -1235,6 +1625,13 @@ func (p *Peer) maybeAddDeadline(pendingResponses map[string]time.Time, msgCmd st
 		// headers.
 		deadline = time.Now().Add(stallResponseTimeout * 3)
 		pendingResponses[wire.CmdHeaders] = deadline
+
+	case wire.CmdGetSkeleton:
+		// Expects a headers message.  Use a longer deadline since it
+		// can take a while for the remote peer to load all of the
+		// headers.
+		deadline = time.Now().Add(stallResponseTimeout * 3)
+		pendingResponses[wire.CmdHeaders] = deadline
 	}
 }
 
@@ -1279,18 +1676,30 @@ out:
 				// response map.  Since certain commands expect
 				// one of a group of responses, remove
 				// everything in the expected group accordingly.
-				switch msgCmd := msg.message.Command(); msgCmd {
-				case wire.CmdBlock:
-					fallthrough
-				case wire.CmdTx:
-					fallthrough
-				case wire.CmdNotFound:
-					delete(pendingResponses, wire.CmdBlock)
-					delete(pendingResponses, wire.CmdTx)
-					delete(pendingResponses, wire.CmdNotFound)
-
-				default:
-					delete(pendingResponses, msgCmd)
+				responses := []string{msg.message.Command()}
+				switch responses[0] {
+				case wire.CmdBlock, wire.CmdTx, wire.CmdNotFound:
+					responses = []string{wire.CmdBlock,
+						wire.CmdTx, wire.CmdNotFound}
+				}
+
+				// Responses which arrive after their deadline,
+				// but before the next tick notices, count as a
+				// stall.
+				late := false
+				now := time.Now()
+				for _, command := range responses {
+					deadline, ok := pendingResponses[command]
+					if !ok {
+						continue
+					}
+					if !now.Before(deadline.Add(deadlineOffset)) {
+						late = true
+					}
+					delete(pendingResponses, command)
+				}
+				if late {
+					atomic.AddUint32(&p.stallCount, 1)
 				}
 
 			case sccHandlerStart:
@@ -1345,6 +1754,7 @@ out:
 				log.Debugf("Peer %s appears to be stalled or "+
 					"misbehaving, %s timeout -- "+
 					"disconnecting", p, command)
+				atomic.AddUint32(&p.stallCount, 1)
 				p.Disconnect()
 				break
 			}
@@ -1383,6 +1793,15 @@ cleanup:
 	log.Tracef("Peer stall handler done for %s", p)
 }
 
+// notifyStallHandler signals the stall handler about the passed event.  Nothing
+// is done for observer peers since they don't run a stall handler.
+func (p *Peer) notifyStallHandler(command stallControlCmd, msg wire.Message) {
+	if p.cfg.Observer {
+		return
+	}
+	p.stallControl <- stallControlMsg{command, msg}
+}
+
 // inHandler handles all incoming messages for the peer.  It must be run as a
 // goroutine.
 func (p *Peer) inHandler() {
@@ -1394,6 +1813,7 @@ func (p *Peer) inHandler() {
 		p.Disconnect()
 	})
 
+	var payloadViolations int
 out:
 	for atomic.LoadInt32(&p.disconnect) == 0 {
 		// Read a message and stop the idle timer as soon as the read
@@ -1401,6 +1821,25 @@ out:
 		// needed.
 		rmsg, buf, err := p.readMessage()
 		idleTimer.Stop()
+
+		// Messages exceeding the maximum receive payload have already
+		// been discarded, so the peer is only disconnected once it has
+		// repeatedly sent them.
+		if lerr, ok := err.(*wire.PayloadLimitError); ok {
+			payloadViolations++
+			if payloadViolations >= maxRecvPayloadViolations {
+				log.Warnf("Peer %s sent %d messages exceeding the "+
+					"maximum payload -- disconnecting", p,
+					payloadViolations)
+				p.disconnectWithReason(lerr)
+				break out
+			}
+			log.Debugf("Discarded message from %s: %v", p, lerr)
+			p.PushRejectMsg(lerr.Command, wire.RejectInvalid,
+				lerr.Error(), nil, false)
+			idleTimer.Reset(idleTimeout)
+			continue
+		}
 		if err != nil {
 			// In order to allow regression tests with malformed messages, don't
 			// disconnect the peer when we're in regression test mode and the
@@ -1427,14 +1866,15 @@ out:
 				// command.
 				p.PushRejectMsg("malformed", wire.RejectMalformed, errMsg, nil,
 					true)
+				p.disconnectWithReason(err)
 			}
 			break out
 		}
-		atomic.StoreInt64(&p.lastRecv, time.Now().Unix())
-		p.stallControl <- stallControlMsg{sccReceiveMessage, rmsg}
+		atomic.StoreInt64(&p.lastRecv, p.clock.Now().Unix())
+		p.notifyStallHandler(sccReceiveMessage, rmsg)
 
 		// Handle each supported message type.
-		p.stallControl <- stallControlMsg{sccHandlerStart, rmsg}
+		p.notifyStallHandler(sccHandlerStart, rmsg)
 		switch msg := rmsg.(type) {
 		case *wire.MsgVersion:
 
@@ -1453,12 +1893,22 @@ out:
 			}
 			p.flagsMtx.Lock()
 			p.verAckReceived = true
+			remoteVersionMsg := p.remoteVersionMsg
+			p.remoteVersionMsg = nil
 			p.flagsMtx.Unlock()
 			if p.cfg.Listeners.OnVerAck != nil {
 				p.cfg.Listeners.OnVerAck(p, msg)
 			}
+			if p.cfg.Listeners.OnHandshakeComplete != nil {
+				p.cfg.Listeners.OnHandshakeComplete(p,
+					remoteVersionMsg)
+			}
 
 		case *wire.MsgGetAddr:
+			// Observers don't serve any addresses.
+			if p.cfg.Observer {
+				p.QueueMessage(wire.NewMsgAddr(), nil)
+			}
 			if p.cfg.Listeners.OnGetAddr != nil {
 				p.cfg.Listeners.OnGetAddr(p, msg)
 			}
@@ -1491,7 +1941,16 @@ out:
 			}
 
 		case *wire.MsgTx:
-			if p.cfg.Listeners.OnTx != nil {
+			if p.cfg.Listeners.OnTxWithSha != nil {
+				// The message reader knows the hash of the
+				// transaction unless the payload contained
+				// trailing bytes.
+				txHash, ok := p.msgReader.TxSha()
+				if !ok {
+					txHash = msg.TxSha()
+				}
+				p.cfg.Listeners.OnTxWithSha(p, msg, &txHash)
+			} else if p.cfg.Listeners.OnTx != nil {
 				p.cfg.Listeners.OnTx(p, msg)
 			}
 
@@ -1530,6 +1989,11 @@ out:
 				p.cfg.Listeners.OnGetHeaders(p, msg)
 			}
 
+		case *wire.MsgGetSkeleton:
+			if p.cfg.Listeners.OnGetSkeleton != nil {
+				p.cfg.Listeners.OnGetSkeleton(p, msg)
+			}
+
 		case *wire.MsgFilterAdd:
 			if p.isValidBIP0111(msg.Command()) && p.cfg.Listeners.OnFilterAdd != nil {
 				p.cfg.Listeners.OnFilterAdd(p, msg)
@@ -1568,7 +2032,7 @@ out:
 			log.Debugf("Received unhandled message of type %v "+
 				"from %v", rmsg.Command(), p)
 		}
-		p.stallControl <- stallControlMsg{sccHandlerDone, rmsg}
+		p.notifyStallHandler(sccHandlerDone, rmsg)
 
 		// A message was received so reset the idle timer.
 		idleTimer.Reset(idleTimeout)
@@ -1591,8 +2055,14 @@ out:
 func (p *Peer) queueHandler() {
 	pendingMsgs := list.New()
 	invSendQueue := list.New()
-	trickleTicker := time.NewTicker(trickleTimeout)
-	defer trickleTicker.Stop()
+
+	// Observers don't relay inventory, so there is nothing to trickle.
+	var trickleChan <-chan time.Time
+	if !p.cfg.Observer {
+		trickleTicker := time.NewTicker(trickleTimeout)
+		defer trickleTicker.Stop()
+		trickleChan = trickleTicker.C
+	}
 
 	// We keep the waiting flag so that we know if we have a message queued
 	// to the outHandler or not.  We could use the presence of a head of
@@ -1603,13 +2073,23 @@ func (p *Peer) queueHandler() {
 	// passed to outHandler.
 	waiting := false
 
-	// To avoid duplication below.
+	// To avoid duplication below.  Messages which have to wait are
+	// combined with the message queued before them when possible, so
+	// bursts of inventory and headers announcements are sent in as few
+	// messages as possible.
 	queuePacket := func(msg outMsg, list *list.List, waiting bool) bool {
 		if !waiting {
 			p.sendQueue <- msg
-		} else {
-			list.PushBack(msg)
+			return true
+		}
+		if back := list.Back(); back != nil {
+			queued := back.Value.(outMsg)
+			if coalesced, ok := coalesceOutMsg(queued, msg); ok {
+				back.Value = coalesced
+				return true
+			}
 		}
+		list.PushBack(msg)
 		// we are always waiting now.
 		return true
 	}
@@ -1641,7 +2121,7 @@ out:
 				invSendQueue.PushBack(iv)
 			}
 
-		case <-trickleTicker.C:
+		case <-trickleChan:
 			// Don't send anything if we're disconnecting or there
 			// is no queued inventory.
 			// version is known if send queue has any entries.
@@ -1689,17 +2169,13 @@ out:
 	for e := pendingMsgs.Front(); e != nil; e = pendingMsgs.Front() {
 		val := pendingMsgs.Remove(e)
 		msg := val.(outMsg)
-		if msg.doneChan != nil {
-			msg.doneChan <- struct{}{}
-		}
+		msg.notifyDone()
 	}
 cleanup:
 	for {
 		select {
 		case msg := <-p.outputQueue:
-			if msg.doneChan != nil {
-				msg.doneChan <- struct{}{}
-			}
+			msg.notifyDone()
 		case <-p.outputInvChan:
 			// Just drain channel
 		// sendDoneQueue is buffered so doesn't need draining.
@@ -1736,8 +2212,17 @@ func (p *Peer) shouldLogWriteError(err error) bool {
 // allowing the sender to continue running asynchronously.
 func (p *Peer) outHandler() {
 	// pingTicker is used to periodically send pings to the remote peer.
-	pingTicker := time.NewTicker(pingInterval)
-	defer pingTicker.Stop()
+	// Observers don't send periodic pings.
+	var pingChan <-chan time.Time
+	if !p.cfg.Observer {
+		pingTicker := time.NewTicker(pingInterval)
+		defer pingTicker.Stop()
+		pingChan = pingTicker.C
+	}
+
+	// sendBuf holds the data messages which are held back for
+	// SendCoalesceDelay.
+	var sendBuf sendBuffer
 
 out:
 	for {
@@ -1755,16 +2240,46 @@ out:
 				}
 			}
 
-			p.stallControl <- stallControlMsg{sccSendMessage, msg.msg}
-			if err := p.writeMessage(msg.msg); err != nil {
+			// A nil message marks the point in the queue a flush
+			// is waiting for, so there is nothing to write other
+			// than the messages held back before it.
+			if msg.msg == nil {
+				p.flushSendBuffer(&sendBuf)
+				msg.notifyDone()
+				p.sendDoneQueue <- struct{}{}
+				continue
+			}
+
+			// Data messages are held back so bursts of them share
+			// a write when enabled.  Any other message is written
+			// after the messages held back before it.
+			if p.cfg.SendCoalesceDelay > 0 &&
+				isCoalescableMessage(msg.msg) {
+
+				p.bufferMessage(&sendBuf, msg)
+				p.sendDoneQueue <- struct{}{}
+				continue
+			}
+			p.flushSendBuffer(&sendBuf)
+
+			p.notifyStallHandler(sccSendMessage, msg.msg)
+			err := p.writeMessage(msg.msg)
+			if lerr, ok := err.(*wire.PayloadLimitError); ok {
+				// The remote peer would not accept the message,
+				// so it is dropped instead of being sent.
+				log.Warnf("Not sending message to %s: %v", p,
+					lerr)
+				msg.notifyDone()
+				p.sendDoneQueue <- struct{}{}
+				continue
+			}
+			if err != nil {
 				p.Disconnect()
 				if p.shouldLogWriteError(err) {
 					log.Errorf("Failed to send message to "+
 						"%s: %v", p, err)
 				}
-				if msg.doneChan != nil {
-					msg.doneChan <- struct{}{}
-				}
+				msg.notifyDone()
 				continue
 			}
 
@@ -1774,12 +2289,14 @@ out:
 			// signal the send queue to the deliver the next queued
 			// message.
 			atomic.StoreInt64(&p.lastSend, time.Now().Unix())
-			if msg.doneChan != nil {
-				msg.doneChan <- struct{}{}
-			}
+			msg.notifyDone()
 			p.sendDoneQueue <- struct{}{}
 
-		case <-pingTicker.C:
+		case <-sendBuf.timerChan():
+			sendBuf.timer = nil
+			p.flushSendBuffer(&sendBuf)
+
+		case <-pingChan:
 			nonce, err := wire.RandomUint64()
 			if err != nil {
 				log.Errorf("Not sending ping to %s: %v", p, err)
@@ -1794,6 +2311,10 @@ out:
 
 	<-p.queueQuit
 
+	// The peer is disconnected, so the messages held back are not written,
+	// however, their done channels are still notified.
+	p.flushSendBuffer(&sendBuf)
+
 	// Drain any wait channels before we go away so we don't leave something
 	// waiting for us. We have waited on queueQuit and thus we can be sure
 	// that we will not miss anything sent on sendQueue.
@@ -1801,9 +2322,7 @@ cleanup:
 	for {
 		select {
 		case msg := <-p.sendQueue:
-			if msg.doneChan != nil {
-				msg.doneChan <- struct{}{}
-			}
+			msg.notifyDone()
 			// no need to send on sendDoneQueue since queueHandler
 			// has been waited on and already exited.
 		default:
@@ -1821,7 +2340,9 @@ func (p *Peer) QueueMessage(msg wire.Message, doneChan chan<- struct{}) {
 	// Avoid risk of deadlock if goroutine already exited.  The goroutine
 	// we will be sending to hangs around until it knows for a fact that
 	// it is marked as disconnected and *then* it drains the channels.
-	if !p.Connected() {
+	// Messages are also no longer accepted once the peer is flushing its
+	// queue in preparation of disconnecting.
+	if !p.Connected() || atomic.LoadInt32(&p.flushing) != 0 {
 		if doneChan != nil {
 			go func() {
 				doneChan <- struct{}{}
@@ -1838,6 +2359,11 @@ func (p *Peer) QueueMessage(msg wire.Message, doneChan chan<- struct{}) {
 //
 // This function is safe for concurrent access.
 func (p *Peer) QueueInventory(invVect *wire.InvVect) {
+	// Observers don't relay inventory.
+	if p.cfg.Observer {
+		return
+	}
+
 	// Don't add the inventory to the send queue if the peer is already
 	// known to have it.
 	if p.knownInventory.Exists(invVect) {
@@ -1847,7 +2373,7 @@ func (p *Peer) QueueInventory(invVect *wire.InvVect) {
 	// Avoid risk of deadlock if goroutine already exited.  The goroutine
 	// we will be sending to hangs around until it knows for a fact that
 	// it is marked as disconnected and *then* it drains the channels.
-	if !p.Connected() {
+	if !p.Connected() || atomic.LoadInt32(&p.flushing) != 0 {
 		return
 	}
 
@@ -1862,7 +2388,12 @@ func (p *Peer) Connect(conn net.Conn) {
 		return
 	}
 
+	p.setKeepAlive(conn)
 	p.conn = conn
+	if p.cfg.TLSConfig != nil {
+		p.conn = newTLSConn(conn, p.cfg.TLSConfig, p.inbound, p.addr)
+	}
+	p.msgReader = wire.NewMessageReader(p.conn)
 	p.timeConnected = time.Now()
 
 	if p.inbound {
@@ -1883,7 +2414,7 @@ func (p *Peer) Connect(conn net.Conn) {
 	go func() {
 		if err := p.start(); err != nil {
 			log.Warnf("Cannot start peer %v: %v", p, err)
-			p.Disconnect()
+			p.disconnectWithReason(err)
 		}
 	}()
 }
@@ -1911,12 +2442,65 @@ func (p *Peer) Disconnect() {
 	close(p.quit)
 }
 
+// DisconnectAfterFlush stops accepting new messages and waits for all
+// messages that were already queued via QueueMessage to be written before
+// disconnecting the peer.  This is useful for ensuring a final message, such
+// as a reject, is actually sent before the connection is closed.  The done
+// channels of the flushed messages are notified as usual, while messages
+// queued after this function is called are discarded and their done channels
+// notified immediately.  Inventory waiting to be trickled is not flushed.
+//
+// The peer is disconnected once the timeout elapses even when the queue has
+// not been flushed, in which case ErrFlushTimeout is returned.  Calling this
+// function when the peer is already disconnected or in the process of
+// disconnecting will have no effect.
+//
+// This function is safe for concurrent access.
+func (p *Peer) DisconnectAfterFlush(timeout time.Duration) error {
+	if !p.Connected() || !atomic.CompareAndSwapInt32(&p.flushing, 0, 1) {
+		return nil
+	}
+	defer p.Disconnect()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	// Queue a marker behind all of the currently queued messages and wait
+	// for the output handler to reach it.
+	flushed := make(chan struct{}, 1)
+	select {
+	case p.outputQueue <- outMsg{doneChan: flushed}:
+	case <-p.quit:
+		return nil
+	case <-timer.C:
+		return ErrFlushTimeout
+	}
+	select {
+	case <-flushed:
+	case <-timer.C:
+		return ErrFlushTimeout
+	}
+	return nil
+}
+
 // start begins processing input and output messages.
 func (p *Peer) start() error {
 	log.Tracef("Starting peer %s", p)
 
 	negotiateErr := make(chan error)
 	go func() {
+		// The TLS session, when configured, must be established before
+		// any bitcoin protocol messages are exchanged.
+		if tlsConn, ok := p.conn.(*tls.Conn); ok {
+			if err := handshakeTLS(tlsConn); err != nil {
+				negotiateErr <- err
+				return
+			}
+			p.flagsMtx.Lock()
+			p.encrypted = true
+			p.flagsMtx.Unlock()
+		}
+
 		if p.inbound {
 			negotiateErr <- p.negotiateInboundProtocol()
 		} else {
@@ -1931,16 +2515,21 @@ func (p *Peer) start() error {
 			return err
 		}
 	case <-time.After(negotiateTimeout):
-		return errors.New("protocol negotiation timeout")
+		return ErrNegotiateTimeout
 	}
 	log.Debugf("Connected to %s", p.Addr())
 
 	// The protocol has been negotiated successfully so start processing input
 	// and output messages.
-	go p.stallHandler()
+	if !p.cfg.Observer {
+		go p.stallHandler()
+	}
 	go p.inHandler()
 	go p.queueHandler()
 	go p.outHandler()
+	if p.cfg.IdleProbeInterval > 0 {
+		go p.idleProbeHandler()
+	}
 
 	// Send our verack message now that the IO processing machinery has started.
 	p.QueueMessage(wire.NewMsgVerAck(), nil)
@@ -1970,6 +2559,9 @@ func (p *Peer) readRemoteVersionMsg() error {
 	if !ok {
 		errStr := "A version message must precede all others"
 		log.Errorf(errStr)
+		if p.cfg.Observer {
+			return errors.New(errStr)
+		}
 
 		rejectMsg := wire.NewMsgReject(msg.Command(), wire.RejectMalformed,
 			errStr)
@@ -1979,6 +2571,11 @@ func (p *Peer) readRemoteVersionMsg() error {
 	if err := p.handleRemoteVersionMsg(remoteVerMsg); err != nil {
 		return err
 	}
+	if p.cfg.Listeners.OnHandshakeComplete != nil {
+		p.flagsMtx.Lock()
+		p.remoteVersionMsg = remoteVerMsg
+		p.flagsMtx.Unlock()
+	}
 
 	if p.cfg.Listeners.OnVersion != nil {
 		p.cfg.Listeners.OnVersion(p, remoteVerMsg)
@@ -2004,6 +2601,34 @@ func (p *Peer) writeLocalVersionMsg() error {
 	return nil
 }
 
+// newTLSConn wraps the passed connection in a TLS client or server connection
+// depending on the direction of the peer.  Outbound connections which do not
+// specify a server name in the configuration verify the remote certificate
+// against the host portion of the address being connected to.
+func newTLSConn(conn net.Conn, cfg *tls.Config, inbound bool, addr string) *tls.Conn {
+	if inbound {
+		return tls.Server(conn, cfg)
+	}
+
+	if cfg.ServerName == "" && !cfg.InsecureSkipVerify {
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			cfg = cfg.Clone()
+			cfg.ServerName = host
+		}
+	}
+	return tls.Client(conn, cfg)
+}
+
+// handshakeTLS runs the TLS handshake on the passed connection and wraps any
+// resulting error in a TLSHandshakeError so callers can distinguish it from
+// failures during the version negotiation.
+func handshakeTLS(conn *tls.Conn) error {
+	if err := conn.Handshake(); err != nil {
+		return &TLSHandshakeError{Err: err}
+	}
+	return nil
+}
+
 // negotiateInboundProtocol waits to receive a version message from the peer
 // then sends our version message. If the events do not occur in that order then
 // it returns an error.
@@ -2046,20 +2671,28 @@ func newPeerBase(cfg *Config, inbound bool) *Peer {
 
 	p := Peer{
 		inbound:         inbound,
-		knownInventory:  newMruInventoryMap(maxKnownInventory),
-		stallControl:    make(chan stallControlMsg, 1), // nonblocking sync
-		outputQueue:     make(chan outMsg, outputBufferSize),
 		sendQueue:       make(chan outMsg, 1),   // nonblocking sync
 		sendDoneQueue:   make(chan struct{}, 1), // nonblocking sync
-		outputInvChan:   make(chan *wire.InvVect, outputBufferSize),
 		inQuit:          make(chan struct{}),
 		queueQuit:       make(chan struct{}),
 		outQuit:         make(chan struct{}),
 		quit:            make(chan struct{}),
 		cfg:             *cfg, // Copy so caller can't mutate.
+		clock:           realClock{},
 		services:        cfg.Services,
 		protocolVersion: protocolVersion,
 	}
+
+	// Observers only send a handful of messages and neither track nor
+	// relay inventory, so the related structures are not needed.
+	if cfg.Observer {
+		p.outputQueue = make(chan outMsg, observerOutputBufferSize)
+		return &p
+	}
+	p.knownInventory = newMruInventoryMap(maxKnownInventory)
+	p.stallControl = make(chan stallControlMsg, 1) // nonblocking sync
+	p.outputQueue = make(chan outMsg, outputBufferSize)
+	p.outputInvChan = make(chan *wire.InvVect, outputBufferSize)
 	return &p
 }
 
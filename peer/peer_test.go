@@ -6,17 +6,27 @@
 package peer_test
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
+	"reflect"
+	"runtime"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/btcsuite/go-socks/socks"
 	"github.com/tinhnguyenhn/colxd/chaincfg"
 	"github.com/tinhnguyenhn/colxd/peer"
+	"github.com/tinhnguyenhn/colxd/peer/peertest"
 	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
 )
 
 // conn mocks a network connection by implementing the net.Conn interface.  It
@@ -87,6 +97,50 @@ func pipe(c1, c2 *conn) (*conn, *conn) {
 	return c1, c2
 }
 
+// pipeConn wraps one end of a net.Pipe so it reports a (fake) remote address
+// while retaining the deadline and close semantics of the real pipe.  It is
+// used for TLS tests since the TLS layer relies on writes failing once the
+// remote end has gone away.
+type pipeConn struct {
+	net.Conn
+	raddr string
+}
+
+// RemoteAddr returns the fake remote address for the connection.
+func (c *pipeConn) RemoteAddr() net.Addr {
+	return &addr{"tcp", c.raddr}
+}
+
+// tlsPipe returns two ends of a net.Pipe with the provided fake remote
+// addresses.
+func tlsPipe(raddr1, raddr2 string) (*pipeConn, *pipeConn) {
+	c1, c2 := net.Pipe()
+	return &pipeConn{c1, raddr1}, &pipeConn{c2, raddr2}
+}
+
+// newTestTLSConfigs creates a self-signed certificate and returns a server
+// configuration which presents it along with a client configuration which
+// trusts it.
+func newTestTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	certPEM, keyPEM, err := colxutil.NewTLSCertPair("peer test",
+		time.Now().Add(time.Hour), nil)
+	if err != nil {
+		t.Fatalf("NewTLSCertPair: unexpected err %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("X509KeyPair: unexpected err %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(certPEM) {
+		t.Fatalf("AppendCertsFromPEM: unable to add certificate")
+	}
+
+	serverCfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	clientCfg := &tls.Config{RootCAs: pool}
+	return serverCfg, clientCfg
+}
+
 // peerStats holds the expected peer stats used for testing peer.
 type peerStats struct {
 	wantUserAgent       string
@@ -103,6 +157,7 @@ type peerStats struct {
 	wantTimeOffset      int64
 	wantBytesSent       uint64
 	wantBytesReceived   uint64
+	wantBytesPerMsg     map[string]uint64
 }
 
 // testPeer tests the given peer's flags and stats
@@ -201,6 +256,16 @@ func testPeer(t *testing.T, p *peer.Peer, s peerStats) {
 		t.Errorf("testPeer: wrong LastRecv - got %v, want %v", p.LastRecv(), stats.LastRecv)
 		return
 	}
+
+	if !reflect.DeepEqual(stats.BytesSentPerMsg, s.wantBytesPerMsg) {
+		t.Errorf("testPeer: wrong BytesSentPerMsg - got %v, want %v", stats.BytesSentPerMsg, s.wantBytesPerMsg)
+		return
+	}
+
+	if !reflect.DeepEqual(stats.BytesRecvPerMsg, s.wantBytesPerMsg) {
+		t.Errorf("testPeer: wrong BytesRecvPerMsg - got %v, want %v", stats.BytesRecvPerMsg, s.wantBytesPerMsg)
+		return
+	}
 }
 
 // TestPeerConnection tests connection between inbound and outbound peers.
@@ -236,6 +301,10 @@ func TestPeerConnection(t *testing.T) {
 		wantTimeOffset:      int64(0),
 		wantBytesSent:       158, // 134 version + 24 verack
 		wantBytesReceived:   158,
+		wantBytesPerMsg: map[string]uint64{
+			wire.CmdVersion: 134,
+			wire.CmdVerAck:  24,
+		},
 	}
 	tests := []struct {
 		name  string
@@ -313,7 +382,6 @@ func TestPeerConnection(t *testing.T) {
 
 // TestPeerListeners tests that the peer listeners are called as expected.
 func TestPeerListeners(t *testing.T) {
-	verack := make(chan struct{}, 1)
 	ok := make(chan wire.Message, 20)
 	peerCfg := &peer.Config{
 		Listeners: peer.MessageListeners{
@@ -375,7 +443,7 @@ func TestPeerListeners(t *testing.T) {
 				ok <- msg
 			},
 			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
-				verack <- struct{}{}
+				ok <- msg
 			},
 			OnReject: func(p *peer.Peer, msg *wire.MsgReject) {
 				ok <- msg
@@ -383,39 +451,22 @@ func TestPeerListeners(t *testing.T) {
 			OnSendHeaders: func(p *peer.Peer, msg *wire.MsgSendHeaders) {
 				ok <- msg
 			},
+			OnGetSkeleton: func(p *peer.Peer, msg *wire.MsgGetSkeleton) {
+				ok <- msg
+			},
 		},
 		UserAgentName:    "peer",
 		UserAgentVersion: "1.0",
 		ChainParams:      &chaincfg.MainNetParams,
 		Services:         wire.SFNodeBloom,
 	}
-	inConn, outConn := pipe(
-		&conn{raddr: "10.0.0.1:8333"},
-		&conn{raddr: "10.0.0.2:8333"},
-	)
+	localConn, remoteConn := peertest.Pipe("10.0.0.2:8333",
+		"10.0.0.1:8333")
 	inPeer := peer.NewInboundPeer(peerCfg)
-	inPeer.Connect(inConn)
-
-	peerCfg.Listeners = peer.MessageListeners{
-		OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
-			verack <- struct{}{}
-		},
-	}
-	outPeer, err := peer.NewOutboundPeer(peerCfg, "10.0.0.1:8333")
-	if err != nil {
-		t.Errorf("NewOutboundPeer: unexpected err %v\n", err)
-		return
-	}
-	outPeer.Connect(outConn)
-
-	for i := 0; i < 2; i++ {
-		select {
-		case <-verack:
-		case <-time.After(time.Second * 1):
-			t.Errorf("TestPeerListeners: verack timeout\n")
-			return
-		}
-	}
+	inPeer.Connect(localConn)
+	sp := peertest.New(remoteConn, peer.MaxProtocolVersion,
+		chaincfg.MainNetParams.Net)
+	defer sp.Close()
 
 	tests := []struct {
 		listener string
@@ -503,20 +554,48 @@ func TestPeerListeners(t *testing.T) {
 			"OnSendHeaders",
 			wire.NewMsgSendHeaders(),
 		},
+		{
+			"OnGetSkeleton",
+			wire.NewMsgGetSkeleton(&wire.ShaHash{}, 2000, 1),
+		},
 	}
-	t.Logf("Running %d tests", len(tests))
+
+	// listenerCalled returns a step which waits for the listener with the
+	// passed name to be called with a message with the passed command.
+	listenerCalled := func(listener, command string) peertest.Step {
+		return peertest.Assert(listener+" called", time.Second,
+			func() error {
+				select {
+				case msg := <-ok:
+					if msg.Command() != command {
+						return fmt.Errorf("%s called "+
+							"with %s message", listener,
+							msg.Command())
+					}
+					return nil
+				default:
+					return fmt.Errorf("%s not called", listener)
+				}
+			})
+	}
+
+	// The handshake calls the version and verack listeners.  The scripted
+	// peer advertises bloom filter support so the filter messages are
+	// handled rather than causing a disconnect.
+	version := sp.NewVersionMsg()
+	version.Services = wire.SFNodeBloom
+	script := peertest.InboundHandshake(version, time.Second)
+	script = append(script, listenerCalled("OnVersion", wire.CmdVersion),
+		listenerCalled("OnVerAck", wire.CmdVerAck))
 	for _, test := range tests {
-		// Queue the test message
-		outPeer.QueueMessage(test.msg, nil)
-		select {
-		case <-ok:
-		case <-time.After(time.Second * 1):
-			t.Errorf("TestPeerListeners: %s timeout", test.listener)
-			return
-		}
+		script = append(script, peertest.Send(test.msg),
+			listenerCalled(test.listener, test.msg.Command()))
+	}
+	t.Logf("Running %d tests", len(tests))
+	if err := sp.Run(script); err != nil {
+		t.Errorf("TestPeerListeners: %v", err)
 	}
 	inPeer.Disconnect()
-	outPeer.Disconnect()
 }
 
 // TestOutboundPeer tests that the outbound peer works as expected.
@@ -660,6 +739,1342 @@ func TestOutboundPeer(t *testing.T) {
 	p2.Disconnect()
 }
 
+// TestPeerTLS tests that peers configured with a TLS configuration negotiate
+// an encrypted connection before the version handshake and refuse to talk to
+// peers presenting a certificate which can't be verified.
+func TestPeerTLS(t *testing.T) {
+	serverCfg, clientCfg := newTestTLSConfigs(t)
+
+	verack := make(chan struct{}, 2)
+	inCfg := &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+		},
+		UserAgentName:    "peer",
+		UserAgentVersion: "1.0",
+		ChainParams:      &chaincfg.MainNetParams,
+		TLSConfig:        serverCfg,
+	}
+	outCfg := *inCfg
+	outCfg.TLSConfig = clientCfg
+
+	inConn, outConn := tlsPipe("127.0.0.1:18555", "127.0.0.1:8333")
+	inPeer := peer.NewInboundPeer(inCfg)
+	inPeer.Connect(inConn)
+	outPeer, err := peer.NewOutboundPeer(&outCfg, "127.0.0.1:8333")
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err %v", err)
+	}
+	outPeer.Connect(outConn)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-verack:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("TestPeerTLS: verack timeout")
+		}
+	}
+	for _, p := range []*peer.Peer{inPeer, outPeer} {
+		if !p.Encrypted() {
+			t.Errorf("TestPeerTLS: %v not encrypted", p)
+		}
+		if !p.StatsSnapshot().Encrypted {
+			t.Errorf("TestPeerTLS: %v stats snapshot not "+
+				"encrypted", p)
+		}
+	}
+	inPeer.Disconnect()
+	outPeer.Disconnect()
+	inPeer.WaitForDisconnect()
+	outPeer.WaitForDisconnect()
+
+	// Ensure a client which doesn't trust the certificate presented by the
+	// server fails with a TLS handshake error on both ends.
+	_, otherClientCfg := newTestTLSConfigs(t)
+	inConn, outConn = tlsPipe("127.0.0.1:18555", "127.0.0.1:8333")
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- peer.TstHandshakeTLS(inConn, serverCfg, true,
+			"127.0.0.1:18555")
+	}()
+	err = peer.TstHandshakeTLS(outConn, otherClientCfg, false,
+		"127.0.0.1:8333")
+	if _, ok := err.(*peer.TLSHandshakeError); !ok {
+		t.Errorf("TestPeerTLS: unexpected client error - got %T(%v), "+
+			"want %T", err, err, &peer.TLSHandshakeError{})
+	}
+	outConn.Close()
+	err = <-errChan
+	if _, ok := err.(*peer.TLSHandshakeError); !ok {
+		t.Errorf("TestPeerTLS: unexpected server error - got %T(%v), "+
+			"want %T", err, err, &peer.TLSHandshakeError{})
+	}
+	inConn.Close()
+
+	// Ensure peers with a mismatched certificate are disconnected without
+	// ever exchanging a bitcoin protocol message.
+	written := make(chan wire.Message, 2)
+	inCfg.Listeners.OnWrite = func(p *peer.Peer, bytesWritten int,
+		msg wire.Message, err error) {
+		written <- msg
+	}
+	outCfg.Listeners.OnWrite = inCfg.Listeners.OnWrite
+	outCfg.TLSConfig = otherClientCfg
+
+	inConn, outConn = tlsPipe("127.0.0.1:18555", "127.0.0.1:8333")
+	inPeer = peer.NewInboundPeer(inCfg)
+	inPeer.Connect(inConn)
+	outPeer, err = peer.NewOutboundPeer(&outCfg, "127.0.0.1:8333")
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err %v", err)
+	}
+	outPeer.Connect(outConn)
+
+	for _, p := range []*peer.Peer{inPeer, outPeer} {
+		disconnected := make(chan struct{})
+		go func() {
+			p.WaitForDisconnect()
+			close(disconnected)
+		}()
+		select {
+		case <-disconnected:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("TestPeerTLS: %v did not disconnect", p)
+		}
+		if p.Encrypted() {
+			t.Errorf("TestPeerTLS: %v unexpectedly encrypted", p)
+		}
+	}
+	select {
+	case msg := <-written:
+		t.Errorf("TestPeerTLS: unexpected %s message written",
+			msg.Command())
+	default:
+	}
+}
+
+// TestPeerExternalAddrs ensures the version message advertises the best
+// configured external address rather than the address of the connection, that
+// the advertised address is made available via AdvertisedAddr, and that only
+// outbound peers score the address the remote peer reports seeing them at.
+func TestPeerExternalAddrs(t *testing.T) {
+	extIPv4 := wire.NewNetAddressIPPort(net.ParseIP("203.0.113.5"), 9999,
+		wire.SFNodeNetwork)
+	extIPv6 := wire.NewNetAddressIPPort(net.ParseIP("2001:db8::5"), 9999,
+		wire.SFNodeNetwork)
+	inScores := peer.NewExternalAddrScores()
+	outScores := peer.NewExternalAddrScores()
+
+	version := make(chan *wire.MsgVersion, 1)
+	verack := make(chan struct{}, 2)
+	inCfg := &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVersion: func(p *peer.Peer, msg *wire.MsgVersion) {
+				version <- msg
+			},
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+		},
+		ExternalAddrScores: inScores,
+		ChainParams:        &chaincfg.MainNetParams,
+	}
+	outCfg := &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+		},
+		ExternalAddrs: func() ([]*wire.NetAddress, error) {
+			return []*wire.NetAddress{extIPv6, extIPv4}, nil
+		},
+		BestLocalAddress: func(remoteAddr *wire.NetAddress) *wire.NetAddress {
+			t.Errorf("TestPeerExternalAddrs: BestLocalAddress " +
+				"unexpectedly called")
+			return remoteAddr
+		},
+		ExternalAddrScores: outScores,
+		ChainParams:        &chaincfg.MainNetParams,
+	}
+
+	inConn, outConn := pipe(
+		&conn{raddr: "10.0.0.1:8333"},
+		&conn{raddr: "10.0.0.2:8333"},
+	)
+	inPeer := peer.NewInboundPeer(inCfg)
+	inPeer.Connect(inConn)
+	outPeer, err := peer.NewOutboundPeer(outCfg, "10.0.0.2:8333")
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err %v", err)
+	}
+	outPeer.Connect(outConn)
+	defer func() {
+		inPeer.Disconnect()
+		outPeer.Disconnect()
+		inPeer.WaitForDisconnect()
+		outPeer.WaitForDisconnect()
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-verack:
+		case <-time.After(time.Second):
+			t.Fatalf("TestPeerExternalAddrs: verack timeout")
+		}
+	}
+
+	// The remote peer is reached over IPv4, so the IPv4 external address
+	// must be advertised instead of the address of the connection.
+	var msg *wire.MsgVersion
+	select {
+	case msg = <-version:
+	case <-time.After(time.Second):
+		t.Fatalf("TestPeerExternalAddrs: version timeout")
+	}
+	if !msg.AddrMe.IP.Equal(extIPv4.IP) || msg.AddrMe.Port != extIPv4.Port {
+		t.Errorf("TestPeerExternalAddrs: unexpected advertised address "+
+			"- got %v:%d, want %v:%d", msg.AddrMe.IP, msg.AddrMe.Port,
+			extIPv4.IP, extIPv4.Port)
+	}
+	if got := outPeer.AdvertisedAddr(); got != extIPv4 {
+		t.Errorf("TestPeerExternalAddrs: unexpected AdvertisedAddr - "+
+			"got %v, want %v", got, extIPv4)
+	}
+
+	// The inbound peer only has the connection address to advertise.
+	if got := inPeer.AdvertisedAddr(); got == nil ||
+		!got.IP.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("TestPeerExternalAddrs: unexpected inbound "+
+			"AdvertisedAddr - got %v", got)
+	}
+
+	// Only the outbound peer scores the address reported by the remote
+	// peer.
+	outSeen := wire.NewNetAddressIPPort(net.ParseIP("10.0.0.1"), 0, 0)
+	if got := outScores.Score(outSeen); got != 1 {
+		t.Errorf("TestPeerExternalAddrs: unexpected outbound score - "+
+			"got %d, want 1", got)
+	}
+	inSeen := wire.NewNetAddressIPPort(net.ParseIP("10.0.0.2"), 0, 0)
+	if got := inScores.Score(inSeen); got != 0 {
+		t.Errorf("TestPeerExternalAddrs: unexpected inbound score - "+
+			"got %d, want 0", got)
+	}
+}
+
+// TestPeerDisconnectAfterFlush ensures that all messages queued before
+// DisconnectAfterFlush is called are written before the connection is closed,
+// that their done channels are notified, and that a flush which can't complete
+// in time reports a timeout.
+func TestPeerDisconnectAfterFlush(t *testing.T) {
+	verack := make(chan struct{}, 2)
+	var writeMtx sync.Mutex
+	var pings []uint64
+	outCfg := &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+			OnWrite: func(p *peer.Peer, bytesWritten int, msg wire.Message,
+				err error) {
+				if ping, ok := msg.(*wire.MsgPing); ok && err == nil {
+					writeMtx.Lock()
+					pings = append(pings, ping.Nonce)
+					writeMtx.Unlock()
+				}
+			},
+		},
+		ChainParams: &chaincfg.MainNetParams,
+	}
+	inCfg := &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+		},
+		ChainParams: &chaincfg.MainNetParams,
+	}
+
+	inConn, outConn := pipe(
+		&conn{raddr: "10.0.0.1:8333"},
+		&conn{raddr: "10.0.0.2:8333"},
+	)
+	inPeer := peer.NewInboundPeer(inCfg)
+	inPeer.Connect(inConn)
+	outPeer, err := peer.NewOutboundPeer(outCfg, "10.0.0.2:8333")
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err %v", err)
+	}
+	outPeer.Connect(outConn)
+	defer inPeer.Disconnect()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-verack:
+		case <-time.After(time.Second):
+			t.Fatalf("TestPeerDisconnectAfterFlush: verack timeout")
+		}
+	}
+
+	// Queue a bunch of messages followed by a flush and ensure they were
+	// all written, in order, by the time the flush returns.
+	const numMsgs = 20
+	done := make(chan struct{}, numMsgs)
+	for i := uint64(0); i < numMsgs; i++ {
+		outPeer.QueueMessage(wire.NewMsgPing(i), done)
+	}
+	if err := outPeer.DisconnectAfterFlush(time.Second); err != nil {
+		t.Fatalf("DisconnectAfterFlush: unexpected err %v", err)
+	}
+	writeMtx.Lock()
+	if len(pings) != numMsgs {
+		t.Fatalf("DisconnectAfterFlush: unexpected number of written "+
+			"messages - got %d, want %d", len(pings), numMsgs)
+	}
+	for i, nonce := range pings {
+		if nonce != uint64(i) {
+			t.Fatalf("DisconnectAfterFlush: message #%d written out "+
+				"of order - got nonce %d", i, nonce)
+		}
+	}
+	writeMtx.Unlock()
+	for i := 0; i < numMsgs; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("DisconnectAfterFlush: done channel #%d not "+
+				"notified", i)
+		}
+	}
+	if outPeer.Connected() {
+		t.Fatalf("DisconnectAfterFlush: peer still connected")
+	}
+
+	// Messages queued after the flush must not be written, but their done
+	// channels must still be notified.
+	outPeer.QueueMessage(wire.NewMsgPing(numMsgs), done)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("DisconnectAfterFlush: done channel for discarded " +
+			"message not notified")
+	}
+	outPeer.WaitForDisconnect()
+	writeMtx.Lock()
+	if len(pings) != numMsgs {
+		t.Fatalf("DisconnectAfterFlush: discarded message was written")
+	}
+	writeMtx.Unlock()
+
+	// Create a peer whose remote end completes the handshake and then stops
+	// reading so queued messages can't be written.
+	localConn, remoteConn := peertest.Pipe("10.0.0.1:8333",
+		"10.0.0.2:8333")
+	stalledPeer, err := peer.NewOutboundPeer(inCfg, "10.0.0.2:8333")
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err %v", err)
+	}
+	stalledPeer.Connect(localConn)
+	sp := peertest.New(remoteConn, peer.MaxProtocolVersion,
+		chaincfg.MainNetParams.Net)
+	defer sp.Close()
+	if err := sp.Run(peertest.OutboundHandshake(nil, time.Second)); err != nil {
+		t.Fatalf("DisconnectAfterFlush: %v", err)
+	}
+
+	stalledPeer.QueueMessage(wire.NewMsgPing(0), nil)
+	err = stalledPeer.DisconnectAfterFlush(50 * time.Millisecond)
+	if err != peer.ErrFlushTimeout {
+		t.Fatalf("DisconnectAfterFlush: unexpected err - got %v, want %v",
+			err, peer.ErrFlushTimeout)
+	}
+	if stalledPeer.Connected() {
+		t.Fatalf("DisconnectAfterFlush: stalled peer still connected")
+	}
+	stalledPeer.WaitForDisconnect()
+
+	// The ping which could not be flushed must not have been written.
+	err = sp.Run([]peertest.Step{peertest.ExpectDisconnect(time.Second)})
+	if err != nil {
+		t.Fatalf("DisconnectAfterFlush: %v", err)
+	}
+}
+
+// TestPeerMinAcceptableProtocolVersion ensures peers reject and disconnect
+// remote peers which advertise a protocol version below the configured
+// minimum while accepting those at or above it.
+func TestPeerMinAcceptableProtocolVersion(t *testing.T) {
+	pver := peer.MaxProtocolVersion
+	btcnet := chaincfg.MainNetParams.Net
+
+	tests := []struct {
+		name          string
+		inbound       bool
+		minVersion    uint32
+		remoteVersion int32
+		wantReject    bool
+	}{
+		{"inbound default floor below", true, 0,
+			int32(peer.DefaultMinAcceptableProtocolVersion) - 1, true},
+		{"inbound default floor at", true, 0,
+			int32(peer.DefaultMinAcceptableProtocolVersion), false},
+		{"inbound configured floor below", true,
+			wire.SendHeadersVersion, int32(wire.SendHeadersVersion) - 1,
+			true},
+		{"inbound configured floor at", true, wire.SendHeadersVersion,
+			int32(wire.SendHeadersVersion), false},
+		{"outbound configured floor below", false,
+			wire.SendHeadersVersion, int32(wire.BIP0037Version), true},
+		{"outbound configured floor above", false,
+			wire.BIP0037Version, int32(wire.SendHeadersVersion), false},
+	}
+
+	for _, test := range tests {
+		peerCfg := &peer.Config{
+			ChainParams:                  &chaincfg.MainNetParams,
+			MinAcceptableProtocolVersion: test.minVersion,
+		}
+		localConn, remoteConn := peertest.Pipe("10.0.0.1:8333",
+			"10.0.0.2:8333")
+
+		var p *peer.Peer
+		if test.inbound {
+			p = peer.NewInboundPeer(peerCfg)
+		} else {
+			var err error
+			p, err = peer.NewOutboundPeer(peerCfg, "10.0.0.2:8333")
+			if err != nil {
+				t.Fatalf("%s: NewOutboundPeer: unexpected err %v",
+					test.name, err)
+			}
+		}
+		p.Connect(localConn)
+		sp := peertest.New(remoteConn, pver, btcnet)
+
+		// Outbound peers send their version first.
+		var script []peertest.Step
+		if !test.inbound {
+			script = append(script, peertest.Expect(wire.CmdVersion,
+				time.Second, nil))
+		}
+		remoteVersion := sp.NewVersionMsg()
+		remoteVersion.ProtocolVersion = test.remoteVersion
+		script = append(script, peertest.Send(remoteVersion))
+
+		// The next message from the peer is either a reject followed by
+		// a disconnect or, when the version was acceptable, the version
+		// of an inbound peer or the verack of an outbound peer.
+		switch {
+		case test.wantReject:
+			script = append(script,
+				peertest.Expect(wire.CmdReject, time.Second,
+					func(msg wire.Message) error {
+						rejectMsg := msg.(*wire.MsgReject)
+						if rejectMsg.Code != wire.RejectObsolete ||
+							rejectMsg.Cmd != wire.CmdVersion {
+							return fmt.Errorf("unexpected "+
+								"reject %v", rejectMsg)
+						}
+						return nil
+					}),
+				peertest.ExpectDisconnect(time.Second),
+				peertest.Assert("version not negotiated", 0,
+					func() error {
+						if p.VersionKnown() {
+							return errors.New("version " +
+								"negotiated")
+						}
+						return nil
+					}))
+		case test.inbound:
+			script = append(script, peertest.Expect(wire.CmdVersion,
+				time.Second, nil))
+		default:
+			script = append(script, peertest.Expect(wire.CmdVerAck,
+				time.Second, nil))
+		}
+		if !test.wantReject {
+			script = append(script, peertest.Assert("connected", 0,
+				func() error {
+					if !p.Connected() {
+						return errors.New("peer " +
+							"unexpectedly disconnected")
+					}
+					return nil
+				}))
+		}
+		if err := sp.Run(script); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		p.Disconnect()
+		sp.Close()
+		p.WaitForDisconnect()
+	}
+}
+
+// TestPeerMaxRecvPayload ensures messages with a declared payload over the
+// configured maximum receive payload are discarded without allocating the
+// payload, are rejected, and that the peer is disconnected with the structured
+// reason after repeated violations.
+func TestPeerMaxRecvPayload(t *testing.T) {
+	pver := peer.MaxProtocolVersion
+	btcnet := chaincfg.MainNetParams.Net
+	const maxRecvPayload = 1000
+	const declared = 8 * 1024 * 1024
+
+	var readErrsMtx sync.Mutex
+	var limitErrs int
+	peerCfg := &peer.Config{
+		ChainParams:    &chaincfg.MainNetParams,
+		MaxRecvPayload: maxRecvPayload,
+		Listeners: peer.MessageListeners{
+			OnRead: func(p *peer.Peer, n int, msg wire.Message, err error) {
+				if _, ok := err.(*wire.PayloadLimitError); ok {
+					readErrsMtx.Lock()
+					limitErrs++
+					readErrsMtx.Unlock()
+				}
+			},
+		},
+	}
+	remoteConn, localConn := tlsPipe("10.0.0.1:8333", "10.0.0.2:8333")
+	defer remoteConn.Close()
+	p := peer.NewInboundPeer(peerCfg)
+	p.Connect(localConn)
+
+	// Complete the version handshake.
+	nonce, _ := wire.RandomUint64()
+	na := wire.NewNetAddressIPPort(net.ParseIP("10.0.0.2"), 8333, 0)
+	remoteVersion := wire.NewMsgVersion(na, na, nonce, 0)
+	if err := wire.WriteMessage(remoteConn, remoteVersion, pver,
+		btcnet); err != nil {
+		t.Fatalf("WriteMessage: unexpected err %v", err)
+	}
+	for {
+		msg, _, err := wire.ReadMessage(remoteConn, pver, btcnet)
+		if err != nil {
+			t.Fatalf("ReadMessage: unexpected err %v", err)
+		}
+		if _, ok := msg.(*wire.MsgVerAck); ok {
+			break
+		}
+	}
+	if err := wire.WriteMessage(remoteConn, wire.NewMsgVerAck(), pver,
+		btcnet); err != nil {
+		t.Fatalf("WriteMessage: unexpected err %v", err)
+	}
+
+	// Collect the reject messages sent by the peer until it disconnects.
+	rejects := make(chan *wire.MsgReject, 10)
+	go func() {
+		defer close(rejects)
+		for {
+			msg, _, err := wire.ReadMessage(remoteConn, pver, btcnet)
+			if err != nil {
+				return
+			}
+			if rejectMsg, ok := msg.(*wire.MsgReject); ok {
+				rejects <- rejectMsg
+			}
+		}
+	}()
+
+	// Send inv message headers declaring a payload far over the limit
+	// followed by the declared number of bytes.
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	header := make([]byte, 24)
+	binary.LittleEndian.PutUint32(header, uint32(btcnet))
+	copy(header[4:], wire.CmdInv)
+	binary.LittleEndian.PutUint32(header[16:], declared)
+	zeros := make([]byte, 10*1024)
+	for i := 0; i < 3; i++ {
+		if _, err := remoteConn.Write(header); err != nil {
+			t.Fatalf("Write: unexpected err %v", err)
+		}
+		for remaining := declared; remaining > 0; {
+			n := len(zeros)
+			if remaining < n {
+				n = remaining
+			}
+			if _, err := remoteConn.Write(zeros[:n]); err != nil {
+				t.Fatalf("Write: unexpected err %v", err)
+			}
+			remaining -= n
+		}
+	}
+
+	disconnected := make(chan struct{})
+	go func() {
+		p.WaitForDisconnect()
+		close(disconnected)
+	}()
+	select {
+	case <-disconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("peer did not disconnect")
+	}
+	runtime.ReadMemStats(&after)
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > declared/2 {
+		t.Fatalf("allocated %d bytes for rejected payloads", allocated)
+	}
+
+	lerr, ok := p.DisconnectReason().(*wire.PayloadLimitError)
+	if !ok {
+		t.Fatalf("unexpected disconnect reason - got %v <%T>, want "+
+			"*wire.PayloadLimitError", p.DisconnectReason(),
+			p.DisconnectReason())
+	}
+	if lerr.Command != wire.CmdInv || lerr.Length != declared ||
+		lerr.Limit != maxRecvPayload {
+		t.Fatalf("unexpected disconnect reason %+v", lerr)
+	}
+	readErrsMtx.Lock()
+	if limitErrs != 3 {
+		t.Fatalf("unexpected number of payload limit errors - got %d, "+
+			"want 3", limitErrs)
+	}
+	readErrsMtx.Unlock()
+
+	// Every violation before the disconnect must be rejected.
+	remoteConn.Close()
+	var numRejects int
+	for rejectMsg := range rejects {
+		if rejectMsg.Cmd != wire.CmdInv ||
+			rejectMsg.Code != wire.RejectInvalid {
+			t.Fatalf("unexpected reject %v", rejectMsg)
+		}
+		numRejects++
+	}
+	if numRejects != 2 {
+		t.Fatalf("unexpected number of rejects - got %d, want 2",
+			numRejects)
+	}
+}
+
+// TestPeerIdleProbe ensures a peer which has not received any message for the
+// idle probe interval sends a ping right away, that receiving messages resets
+// the interval, and that the peer is disconnected when a probe is unanswered.
+func TestPeerIdleProbe(t *testing.T) {
+	pver := peer.MaxProtocolVersion
+	btcnet := chaincfg.MainNetParams.Net
+	const interval = time.Minute
+
+	received := make(chan struct{}, 1)
+	peerCfg := &peer.Config{
+		ChainParams:       &chaincfg.MainNetParams,
+		IdleProbeInterval: interval,
+		Listeners: peer.MessageListeners{
+			OnPing: func(p *peer.Peer, msg *wire.MsgPing) {
+				received <- struct{}{}
+			},
+			OnPong: func(p *peer.Peer, msg *wire.MsgPong) {
+				received <- struct{}{}
+			},
+		},
+	}
+	clock := peer.TstNewClock(time.Unix(1400000000, 0))
+	remoteConn, localConn := tlsPipe("10.0.0.1:8333", "10.0.0.2:8333")
+	defer remoteConn.Close()
+	p := peer.NewInboundPeer(peerCfg)
+	peer.TstSetClock(p, clock)
+	p.Connect(localConn)
+	defer p.Disconnect()
+
+	// Complete the version handshake.
+	nonce, _ := wire.RandomUint64()
+	na := wire.NewNetAddressIPPort(net.ParseIP("10.0.0.2"), 8333, 0)
+	remoteVersion := wire.NewMsgVersion(na, na, nonce, 0)
+	writeMsg := func(msg wire.Message) {
+		if err := wire.WriteMessage(remoteConn, msg, pver,
+			btcnet); err != nil {
+			t.Fatalf("WriteMessage: unexpected err %v", err)
+		}
+	}
+	writeMsg(remoteVersion)
+	for {
+		msg, _, err := wire.ReadMessage(remoteConn, pver, btcnet)
+		if err != nil {
+			t.Fatalf("ReadMessage: unexpected err %v", err)
+		}
+		if _, ok := msg.(*wire.MsgVerAck); ok {
+			break
+		}
+	}
+	writeMsg(wire.NewMsgVerAck())
+
+	// Collect the pings sent by the peer until it disconnects.
+	pings := make(chan *wire.MsgPing, 10)
+	go func() {
+		for {
+			msg, _, err := wire.ReadMessage(remoteConn, pver, btcnet)
+			if err != nil {
+				return
+			}
+			if pingMsg, ok := msg.(*wire.MsgPing); ok {
+				pings <- pingMsg
+			}
+		}
+	}()
+	waitReceived := func() {
+		select {
+		case <-received:
+		case <-time.After(time.Second * 2):
+			t.Fatal("peer did not receive message")
+		}
+	}
+	expectPing := func() *wire.MsgPing {
+		select {
+		case pingMsg := <-pings:
+			return pingMsg
+		case <-time.After(time.Second * 2):
+			t.Fatal("peer did not send idle probe")
+		}
+		return nil
+	}
+
+	// Receiving a message halfway through the interval resets it, so no
+	// probe is sent once the original interval has elapsed.
+	clock.WaitForWaiter()
+	clock.Advance(interval / 2)
+	writeMsg(wire.NewMsgPing(1))
+	waitReceived()
+	clock.Advance(interval / 2)
+	clock.WaitForWaiter()
+	select {
+	case <-pings:
+		t.Fatal("peer sent idle probe before the interval elapsed")
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	// The probe is sent once a full interval has elapsed without any
+	// messages.  Answering it keeps the peer connected, while the next
+	// probe is sent after another interval without any messages.
+	clock.Advance(interval / 2)
+	pingMsg := expectPing()
+	clock.WaitForWaiter()
+	writeMsg(wire.NewMsgPong(pingMsg.Nonce))
+	waitReceived()
+	clock.Advance(interval)
+	expectPing()
+	if !p.Connected() {
+		t.Fatal("peer disconnected after answering idle probe")
+	}
+
+	// The peer is disconnected when the probe is not answered within the
+	// interval.
+	disconnected := make(chan struct{})
+	go func() {
+		p.WaitForDisconnect()
+		close(disconnected)
+	}()
+	clock.WaitForWaiter()
+	clock.Advance(interval)
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second * 2):
+		t.Fatal("peer was not disconnected after unanswered probe")
+	}
+	if err := p.DisconnectReason(); err != peer.ErrIdleProbeTimeout {
+		t.Fatalf("unexpected disconnect reason - got %v, want %v", err,
+			peer.ErrIdleProbeTimeout)
+	}
+}
+
+// TestPeerObserver ensures observer peers complete the handshake, report the
+// version of the remote peer and the data it sends, and suppress the automatic
+// behaviors of normal peers other than answering pings and getaddr requests,
+// while normal peers are unaffected.
+func TestPeerObserver(t *testing.T) {
+	pver := peer.MaxProtocolVersion
+	btcnet := chaincfg.MainNetParams.Net
+
+	tests := []struct {
+		name       string
+		observer   bool
+		wantBefore []string // commands sent before the duplicate version
+		wantAfter  []string // commands sent after the duplicate version
+	}{
+		{
+			name:       "observer",
+			observer:   true,
+			wantBefore: []string{wire.CmdAddr, wire.CmdPong},
+		},
+		{
+			name:       "normal",
+			observer:   false,
+			wantBefore: []string{wire.CmdPong},
+			wantAfter:  []string{wire.CmdReject},
+		},
+	}
+
+	for _, test := range tests {
+		handshakes := make(chan *wire.MsgVersion, 1)
+		addrs := make(chan *wire.MsgAddr, 1)
+		peerCfg := &peer.Config{
+			ChainParams: &chaincfg.MainNetParams,
+			Observer:    test.observer,
+			Listeners: peer.MessageListeners{
+				OnHandshakeComplete: func(p *peer.Peer, msg *wire.MsgVersion) {
+					handshakes <- msg
+				},
+				OnAddr: func(p *peer.Peer, msg *wire.MsgAddr) {
+					addrs <- msg
+				},
+			},
+		}
+		remoteConn, localConn := tlsPipe("10.0.0.1:8333",
+			"10.0.0.2:8333")
+		p, err := peer.NewOutboundPeer(peerCfg, "10.0.0.1:8333")
+		if err != nil {
+			t.Fatalf("%s: NewOutboundPeer: unexpected err %v",
+				test.name, err)
+		}
+		p.Connect(localConn)
+
+		writeMsg := func(msg wire.Message) {
+			if err := wire.WriteMessage(remoteConn, msg, pver,
+				btcnet); err != nil {
+				t.Fatalf("%s: WriteMessage: unexpected err %v",
+					test.name, err)
+			}
+		}
+		readMsg := func() wire.Message {
+			msg, _, err := wire.ReadMessage(remoteConn, pver, btcnet)
+			if err != nil {
+				t.Fatalf("%s: ReadMessage: unexpected err %v",
+					test.name, err)
+			}
+			return msg
+		}
+
+		// Complete the version handshake and ensure the version of the
+		// remote peer is reported.
+		if _, ok := readMsg().(*wire.MsgVersion); !ok {
+			t.Fatalf("%s: peer did not send version", test.name)
+		}
+		nonce, _ := wire.RandomUint64()
+		na := wire.NewNetAddressIPPort(net.ParseIP("10.0.0.1"), 8333, 0)
+		remoteVersion := wire.NewMsgVersion(na, na, nonce, 1234)
+		remoteVersion.UserAgent = "/crawled:1.0/"
+		writeMsg(remoteVersion)
+		if _, ok := readMsg().(*wire.MsgVerAck); !ok {
+			t.Fatalf("%s: peer did not send verack", test.name)
+		}
+		writeMsg(wire.NewMsgVerAck())
+		select {
+		case msg := <-handshakes:
+			if msg.UserAgent != remoteVersion.UserAgent ||
+				msg.LastBlock != remoteVersion.LastBlock {
+
+				t.Fatalf("%s: unexpected remote version %v",
+					test.name, msg)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("%s: handshake was not reported", test.name)
+		}
+
+		// Collect the commands sent by the peer until it disconnects.
+		sent := make(chan string, 10)
+		go func() {
+			defer close(sent)
+			for {
+				msg, _, err := wire.ReadMessage(remoteConn, pver,
+					btcnet)
+				if err != nil {
+					return
+				}
+				sent <- msg.Command()
+			}
+		}()
+
+		// The addresses sent by the remote peer are reported.
+		addrMsg := wire.NewMsgAddr()
+		addrMsg.AddAddress(na)
+		writeMsg(addrMsg)
+		select {
+		case msg := <-addrs:
+			if len(msg.AddrList) != 1 {
+				t.Fatalf("%s: unexpected addresses %v",
+					test.name, msg.AddrList)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("%s: addresses were not reported", test.name)
+		}
+
+		// Request addresses, ping the peer and queue inventory, which
+		// observers ignore and normal peers trickle later on.
+		writeMsg(wire.NewMsgGetAddr())
+		writeMsg(wire.NewMsgPing(nonce))
+		p.QueueInventory(wire.NewInvVect(wire.InvTypeBlock,
+			&wire.ShaHash{0x01}))
+
+		var got []string
+		for len(got) < len(test.wantBefore) {
+			select {
+			case command := <-sent:
+				got = append(got, command)
+			case <-time.After(time.Second * 2):
+				t.Fatalf("%s: peer did not respond - got %v, "+
+					"want %v", test.name, got, test.wantBefore)
+			}
+		}
+		if !reflect.DeepEqual(got, test.wantBefore) {
+			t.Fatalf("%s: unexpected responses - got %v, want %v",
+				test.name, got, test.wantBefore)
+		}
+
+		// A duplicate version message disconnects the peer, which
+		// rejects it first unless it is an observer.
+		writeMsg(remoteVersion)
+		got = nil
+		for command := range sent {
+			got = append(got, command)
+		}
+		if !reflect.DeepEqual(got, test.wantAfter) {
+			t.Fatalf("%s: unexpected messages before disconnecting "+
+				"- got %v, want %v", test.name, got, test.wantAfter)
+		}
+		p.WaitForDisconnect()
+		remoteConn.Close()
+	}
+}
+
+// benchmarkNewPeers benchmarks creating 10000 outbound peers in either normal
+// or observer mode.
+func benchmarkNewPeers(b *testing.B, observer bool) {
+	peerCfg := &peer.Config{
+		ChainParams: &chaincfg.MainNetParams,
+		Observer:    observer,
+	}
+	peers := make([]*peer.Peer, 10000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range peers {
+			p, err := peer.NewOutboundPeer(peerCfg,
+				"10.0.0.1:8333")
+			if err != nil {
+				b.Fatalf("NewOutboundPeer: unexpected err %v",
+					err)
+			}
+			peers[j] = p
+		}
+	}
+}
+
+// BenchmarkNewPeers benchmarks creating normal peers.
+func BenchmarkNewPeers(b *testing.B) {
+	benchmarkNewPeers(b, false)
+}
+
+// BenchmarkNewObserverPeers benchmarks creating observer peers.
+func BenchmarkNewObserverPeers(b *testing.B) {
+	benchmarkNewPeers(b, true)
+}
+
+// TestPeerMessageReuse ensures there is no cross-message data corruption when
+// messages are processed asynchronously after the listeners return, both for
+// listeners which copy the messages the peer reuses and for listeners which
+// retain other messages as is.
+func TestPeerMessageReuse(t *testing.T) {
+	pver := peer.MaxProtocolVersion
+	btcnet := chaincfg.MainNetParams.Net
+	const numRounds = 50
+
+	// The inv and ping listeners copy what they retain since those
+	// messages are reused, while the tx listener retains the message.
+	invs := make(chan []wire.InvVect, numRounds)
+	pings := make(chan uint64, numRounds)
+	txns := make(chan *wire.MsgTx, numRounds)
+	peerCfg := &peer.Config{
+		ChainParams: &chaincfg.MainNetParams,
+		Listeners: peer.MessageListeners{
+			OnInv: func(p *peer.Peer, msg *wire.MsgInv) {
+				invVects := make([]wire.InvVect, 0, len(msg.InvList))
+				for _, iv := range msg.InvList {
+					invVects = append(invVects, *iv)
+				}
+				invs <- invVects
+			},
+			OnPing: func(p *peer.Peer, msg *wire.MsgPing) {
+				pings <- msg.Nonce
+			},
+			OnTx: func(p *peer.Peer, msg *wire.MsgTx) {
+				txns <- msg
+			},
+		},
+	}
+	remoteConn, localConn := tlsPipe("10.0.0.1:8333", "10.0.0.2:8333")
+	defer remoteConn.Close()
+	p := peer.NewInboundPeer(peerCfg)
+	p.Connect(localConn)
+	defer p.Disconnect()
+
+	// Complete the version handshake and discard everything the peer
+	// sends afterwards.
+	nonce, _ := wire.RandomUint64()
+	na := wire.NewNetAddressIPPort(net.ParseIP("10.0.0.2"), 8333, 0)
+	writeMsg := func(msg wire.Message) {
+		if err := wire.WriteMessage(remoteConn, msg, pver,
+			btcnet); err != nil {
+			t.Fatalf("WriteMessage: unexpected err %v", err)
+		}
+	}
+	writeMsg(wire.NewMsgVersion(na, na, nonce, 0))
+	for {
+		msg, _, err := wire.ReadMessage(remoteConn, pver, btcnet)
+		if err != nil {
+			t.Fatalf("ReadMessage: unexpected err %v", err)
+		}
+		if _, ok := msg.(*wire.MsgVerAck); ok {
+			break
+		}
+	}
+	writeMsg(wire.NewMsgVerAck())
+	go io.Copy(ioutil.Discard, remoteConn)
+
+	// Send messages with distinct contents which are only examined once
+	// all of them were read by the peer.
+	for i := 0; i < numRounds; i++ {
+		inv := wire.NewMsgInv()
+		for j := 0; j <= i%3; j++ {
+			hash := wire.ShaHash{byte(i), byte(j)}
+			inv.AddInvVect(wire.NewInvVect(wire.InvTypeTx, &hash))
+		}
+		writeMsg(inv)
+		tx := wire.NewMsgTx()
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: uint32(i)}, nil))
+		tx.LockTime = uint32(i)
+		writeMsg(tx)
+		writeMsg(wire.NewMsgPing(uint64(i)))
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(pings) != numRounds {
+		if time.Now().After(deadline) {
+			t.Fatalf("peer only received %d of %d rounds of messages",
+				len(pings), numRounds)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for i := 0; i < numRounds; i++ {
+		invVects := <-invs
+		if len(invVects) != i%3+1 {
+			t.Fatalf("inv #%d: unexpected number of inventory vectors "+
+				"- got %d, want %d", i, len(invVects), i%3+1)
+		}
+		for j, iv := range invVects {
+			if want := (wire.ShaHash{byte(i), byte(j)}); iv.Hash != want {
+				t.Fatalf("inv #%d: unexpected hash - got %v, want %v",
+					i, iv.Hash, want)
+			}
+		}
+		tx := <-txns
+		if tx.LockTime != uint32(i) || len(tx.TxIn) != 1 ||
+			tx.TxIn[0].PreviousOutPoint.Index != uint32(i) {
+
+			t.Fatalf("tx #%d: unexpected transaction %v", i, tx)
+		}
+		if nonce := <-pings; nonce != uint64(i) {
+			t.Fatalf("ping #%d: unexpected nonce - got %d, want %d",
+				i, nonce, i)
+		}
+	}
+}
+
+// TestPeerOnTxWithSha ensures the OnTxWithSha listener is invoked instead of
+// OnTx with the hash of the received transaction.
+func TestPeerOnTxWithSha(t *testing.T) {
+	pver := peer.MaxProtocolVersion
+	btcnet := chaincfg.MainNetParams.Net
+
+	type txWithSha struct {
+		msg  *wire.MsgTx
+		hash wire.ShaHash
+	}
+	txns := make(chan txWithSha, 1)
+	onTxCalled := make(chan struct{}, 1)
+	peerCfg := &peer.Config{
+		ChainParams: &chaincfg.MainNetParams,
+		Listeners: peer.MessageListeners{
+			OnTx: func(p *peer.Peer, msg *wire.MsgTx) {
+				onTxCalled <- struct{}{}
+			},
+			OnTxWithSha: func(p *peer.Peer, msg *wire.MsgTx, txHash *wire.ShaHash) {
+				txns <- txWithSha{msg: msg, hash: *txHash}
+			},
+		},
+	}
+	remoteConn, localConn := tlsPipe("10.0.0.1:8333", "10.0.0.2:8333")
+	defer remoteConn.Close()
+	p := peer.NewInboundPeer(peerCfg)
+	p.Connect(localConn)
+	defer p.Disconnect()
+
+	// Complete the version handshake and discard everything the peer
+	// sends afterwards.
+	nonce, _ := wire.RandomUint64()
+	na := wire.NewNetAddressIPPort(net.ParseIP("10.0.0.2"), 8333, 0)
+	writeMsg := func(msg wire.Message) {
+		if err := wire.WriteMessage(remoteConn, msg, pver,
+			btcnet); err != nil {
+			t.Fatalf("WriteMessage: unexpected err %v", err)
+		}
+	}
+	writeMsg(wire.NewMsgVersion(na, na, nonce, 0))
+	for {
+		msg, _, err := wire.ReadMessage(remoteConn, pver, btcnet)
+		if err != nil {
+			t.Fatalf("ReadMessage: unexpected err %v", err)
+		}
+		if _, ok := msg.(*wire.MsgVerAck); ok {
+			break
+		}
+	}
+	writeMsg(wire.NewMsgVerAck())
+	go io.Copy(ioutil.Discard, remoteConn)
+
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 1}, nil))
+	tx.AddTxOut(wire.NewTxOut(5000, []byte{0x51}))
+	writeMsg(tx)
+
+	var got txWithSha
+	select {
+	case got = <-txns:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnTxWithSha was not called")
+	}
+	if wantHash := tx.TxSha(); got.hash != wantHash {
+		t.Fatalf("OnTxWithSha: unexpected hash - got %v, want %v",
+			got.hash, wantHash)
+	}
+	if hash := got.msg.TxSha(); hash != got.hash {
+		t.Fatalf("OnTxWithSha: hash %v does not match the hash %v of "+
+			"the received transaction", got.hash, hash)
+	}
+	select {
+	case <-onTxCalled:
+		t.Fatal("OnTx was called along with OnTxWithSha")
+	default:
+	}
+}
+
+// countingWriter wraps a writer and counts the calls to Write.
+type countingWriter struct {
+	io.Writer
+
+	mtx    sync.Mutex
+	writes int
+}
+
+// Write counts the call and writes the passed data to the wrapped writer.
+func (w *countingWriter) Write(b []byte) (int, error) {
+	w.mtx.Lock()
+	w.writes++
+	w.mtx.Unlock()
+	return w.Writer.Write(b)
+}
+
+// numWrites returns the number of calls to Write so far.
+func (w *countingWriter) numWrites() int {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+	return w.writes
+}
+
+// TestPeerSendCoalesce ensures bursts of queued inventory and chained headers
+// announcements are combined into fewer messages, that bursts of data messages
+// which can't be combined share fewer writes when SendCoalesceDelay is set,
+// that every item still arrives in order, and that the done channel of every
+// queued message is signaled.
+func TestPeerSendCoalesce(t *testing.T) {
+	const numMsgs = 50
+	verack := make(chan struct{}, 2)
+	var mtx sync.Mutex
+	var invHashes, headerHashes []wire.ShaHash
+	var invMsgs, headersMsgs int
+	var txLockTimes []uint32
+	inCfg := &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+			OnInv: func(p *peer.Peer, msg *wire.MsgInv) {
+				mtx.Lock()
+				invMsgs++
+				for _, iv := range msg.InvList {
+					invHashes = append(invHashes, iv.Hash)
+				}
+				mtx.Unlock()
+			},
+			OnHeaders: func(p *peer.Peer, msg *wire.MsgHeaders) {
+				mtx.Lock()
+				headersMsgs++
+				for _, header := range msg.Headers {
+					headerHashes = append(headerHashes,
+						header.BlockSha())
+				}
+				mtx.Unlock()
+			},
+			OnTx: func(p *peer.Peer, msg *wire.MsgTx) {
+				mtx.Lock()
+				txLockTimes = append(txLockTimes, msg.LockTime)
+				mtx.Unlock()
+			},
+		},
+		ChainParams: &chaincfg.MainNetParams,
+	}
+	outCfg := &peer.Config{
+		Listeners: peer.MessageListeners{
+			OnVerAck: func(p *peer.Peer, msg *wire.MsgVerAck) {
+				verack <- struct{}{}
+			},
+		},
+		ChainParams:       &chaincfg.MainNetParams,
+		SendCoalesceDelay: 20 * time.Millisecond,
+	}
+
+	inConn, outConn := pipe(
+		&conn{raddr: "10.0.0.1:8333"},
+		&conn{raddr: "10.0.0.2:8333"},
+	)
+	writer := &countingWriter{Writer: outConn.Writer}
+	outConn.Writer = writer
+	inPeer := peer.NewInboundPeer(inCfg)
+	inPeer.Connect(inConn)
+	defer inPeer.Disconnect()
+	outPeer, err := peer.NewOutboundPeer(outCfg, "10.0.0.2:8333")
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err %v", err)
+	}
+	outPeer.Connect(outConn)
+	defer outPeer.Disconnect()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-verack:
+		case <-time.After(time.Second):
+			t.Fatalf("TestPeerSendCoalesce: verack timeout")
+		}
+	}
+
+	// Queue a burst of single item inv messages followed by a burst of
+	// headers messages which each announce the block after the last one.
+	writesBefore := writer.numWrites()
+	done := make(chan struct{}, 2*numMsgs)
+	wantInvHashes := make([]wire.ShaHash, 0, numMsgs)
+	for i := 0; i < numMsgs; i++ {
+		hash := wire.ShaHash{byte(i), 0x01}
+		invMsg := wire.NewMsgInv()
+		invMsg.AddInvVect(wire.NewInvVect(wire.InvTypeTx, &hash))
+		outPeer.QueueMessage(invMsg, done)
+		wantInvHashes = append(wantInvHashes, hash)
+	}
+	wantHeaderHashes := make([]wire.ShaHash, 0, numMsgs)
+	var prevHash wire.ShaHash
+	for i := 0; i < numMsgs; i++ {
+		header := &wire.BlockHeader{
+			Version:   1,
+			PrevBlock: prevHash,
+			Timestamp: time.Unix(int64(1231006505+i), 0),
+			Nonce:     uint32(i),
+		}
+		headersMsg := wire.NewMsgHeaders()
+		headersMsg.AddBlockHeader(header)
+		outPeer.QueueMessage(headersMsg, done)
+		prevHash = header.BlockSha()
+		wantHeaderHashes = append(wantHeaderHashes, prevHash)
+	}
+
+	// waitForBurst waits for the done channels of the passed number of
+	// queued messages and for the remote peer to receive the passed number
+	// of items.
+	waitForBurst := func(numDone int, received func() bool) {
+		for i := 0; i < numDone; i++ {
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatalf("TestPeerSendCoalesce: done channel #%d "+
+					"not notified", i)
+			}
+		}
+		deadline := time.Now().Add(time.Second)
+		for {
+			mtx.Lock()
+			ok := received()
+			mtx.Unlock()
+			if ok {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("TestPeerSendCoalesce: timeout waiting " +
+					"for messages")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitForBurst(2*numMsgs, func() bool {
+		return len(invHashes) == numMsgs && len(headerHashes) == numMsgs
+	})
+	announceWrites := writer.numWrites() - writesBefore
+
+	// Queue a burst of transactions which can't be combined into fewer
+	// messages, so they are only able to share writes.
+	writesBefore = writer.numWrites()
+	for i := 0; i < numMsgs; i++ {
+		tx := wire.NewMsgTx()
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: uint32(i)}, nil))
+		tx.LockTime = uint32(i)
+		outPeer.QueueMessage(tx, done)
+	}
+	waitForBurst(numMsgs, func() bool {
+		return len(txLockTimes) == numMsgs
+	})
+	txWrites := writer.numWrites() - writesBefore
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if !reflect.DeepEqual(invHashes, wantInvHashes) {
+		t.Errorf("TestPeerSendCoalesce: unexpected inventory - got %v, "+
+			"want %v", invHashes, wantInvHashes)
+	}
+	if !reflect.DeepEqual(headerHashes, wantHeaderHashes) {
+		t.Errorf("TestPeerSendCoalesce: unexpected headers - got %v, "+
+			"want %v", headerHashes, wantHeaderHashes)
+	}
+	if invMsgs >= numMsgs {
+		t.Errorf("TestPeerSendCoalesce: inv messages not coalesced - "+
+			"got %d messages for %d queued", invMsgs, numMsgs)
+	}
+	if headersMsgs >= numMsgs {
+		t.Errorf("TestPeerSendCoalesce: headers messages not coalesced "+
+			"- got %d messages for %d queued", headersMsgs, numMsgs)
+	}
+	if announceWrites > invMsgs+headersMsgs {
+		t.Errorf("TestPeerSendCoalesce: unexpected number of writes - "+
+			"got %d writes for %d messages", announceWrites,
+			invMsgs+headersMsgs)
+	}
+	for i, lockTime := range txLockTimes {
+		if lockTime != uint32(i) {
+			t.Errorf("TestPeerSendCoalesce: tx #%d received out of "+
+				"order - got lock time %d", i, lockTime)
+			break
+		}
+	}
+	if txWrites >= numMsgs {
+		t.Errorf("TestPeerSendCoalesce: transactions do not share "+
+			"writes - got %d writes for %d messages", txWrites,
+			numMsgs)
+	}
+}
+
 func init() {
 	// Allow self connection when running the tests.
 	peer.TstAllowSelfConns()
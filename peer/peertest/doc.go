@@ -0,0 +1,43 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+/*
+Package peertest provides a harness for testing the behavior of peers built on
+the peer package against a remote peer whose side of the conversation is
+scripted.
+
+Applications which embed a Peer typically customize it through its
+configuration and message listeners.  This package allows their tests to drive
+such a peer over an in-memory connection without a real network or a second
+fully functional peer, and to check the exact sequence of messages it sends.
+
+The Pipe function returns both ends of an in-memory connection which report the
+provided fake addresses.  The local end is handed to the peer under test via
+Connect while the remote end is handed to a ScriptedPeer, which executes a
+script of steps against it.  A script is a slice of steps created with the
+following functions:
+
+  - Send writes a message to the peer under test
+  - Expect waits for the next message from the peer under test and checks
+    its command and, optionally, its contents
+  - Assert waits for a condition, such as the state reported by Peer
+    accessors, to hold
+  - ExpectDisconnect waits for the peer under test to close the connection
+
+The InboundHandshake and OutboundHandshake functions return the steps which
+complete the version handshake with an inbound or outbound peer respectively.
+
+The scripted peer only reads from the connection while an Expect or
+ExpectDisconnect step runs, so a script which ends without reading simulates a
+remote peer which has stalled.
+
+Every message sent and received by a scripted peer is recorded in its
+transcript along with any notes added by the test, for example from message
+listeners running concurrently with the script.  A failing script returns a
+ScriptError which includes the full transcript.  Transcripts only record
+deterministic details of the messages, so they may be compared against golden
+transcripts with CompareGolden and CompareGoldenFile.
+*/
+package peertest
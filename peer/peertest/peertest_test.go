@@ -0,0 +1,267 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peertest_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/peer"
+	"github.com/tinhnguyenhn/colxd/peer/peertest"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// newInboundPeer returns an inbound peer for the main network connected to a
+// scripted peer over an in-memory pipe.
+func newInboundPeer() (*peer.Peer, *peertest.ScriptedPeer) {
+	localConn, remoteConn := peertest.Pipe("10.0.0.1:8333",
+		"10.0.0.2:8333")
+	p := peer.NewInboundPeer(&peer.Config{
+		ChainParams: &chaincfg.MainNetParams,
+	})
+	p.Connect(localConn)
+	sp := peertest.New(remoteConn, peer.MaxProtocolVersion,
+		chaincfg.MainNetParams.Net)
+	return p, sp
+}
+
+// TestPipe ensures both ends of a pipe report the expected addresses and
+// messages written to one end can be read from the other.
+func TestPipe(t *testing.T) {
+	localConn, remoteConn := peertest.Pipe("10.0.0.1:8333",
+		"10.0.0.2:8333")
+	defer localConn.Close()
+	defer remoteConn.Close()
+
+	if got := localConn.LocalAddr().String(); got != "10.0.0.1:8333" {
+		t.Fatalf("unexpected local address of local end - got %s", got)
+	}
+	if got := localConn.RemoteAddr().String(); got != "10.0.0.2:8333" {
+		t.Fatalf("unexpected remote address of local end - got %s", got)
+	}
+	if got := remoteConn.LocalAddr().String(); got != "10.0.0.2:8333" {
+		t.Fatalf("unexpected local address of remote end - got %s", got)
+	}
+	if got := remoteConn.RemoteAddr().String(); got != "10.0.0.1:8333" {
+		t.Fatalf("unexpected remote address of remote end - got %s",
+			got)
+	}
+
+	go localConn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	if _, err := remoteConn.Read(buf); err != nil {
+		t.Fatalf("Read: unexpected err %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Fatalf("unexpected data read - got %q, want %q", buf, "ping")
+	}
+
+	// Addresses which are not TCP addresses are reported as is.
+	onionConn, _ := peertest.Pipe("local", "example.onion")
+	if got := onionConn.RemoteAddr().String(); got != "example.onion" {
+		t.Fatalf("unexpected remote address - got %s", got)
+	}
+}
+
+// TestScriptedPeerHandshake ensures a scripted peer completes the version
+// handshake with inbound and outbound peers and records the exchanged messages
+// in its transcript.
+func TestScriptedPeerHandshake(t *testing.T) {
+	// Complete the handshake with an inbound peer and compare the
+	// transcript against the golden transcript.
+	p, sp := newInboundPeer()
+	defer sp.Close()
+	script := peertest.InboundHandshake(nil, time.Second)
+	script = append(script,
+		peertest.Assert("verack received", time.Second, func() error {
+			if !p.VerAckReceived() {
+				return errors.New("verack not received")
+			}
+			return nil
+		}),
+		peertest.Send(wire.NewMsgPing(1)),
+		peertest.Expect(wire.CmdPong, time.Second, func(msg wire.Message) error {
+			if nonce := msg.(*wire.MsgPong).Nonce; nonce != 1 {
+				return fmt.Errorf("unexpected nonce %d", nonce)
+			}
+			return nil
+		}),
+	)
+	if err := sp.Run(script); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if err := sp.Transcript().CompareGoldenFile("testdata/handshake.golden"); err != nil {
+		t.Fatalf("CompareGoldenFile: %v", err)
+	}
+	p.Disconnect()
+	if err := sp.Run([]peertest.Step{peertest.ExpectDisconnect(time.Second)}); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	// Complete the handshake with an outbound peer using a custom version
+	// message.
+	localConn, remoteConn := peertest.Pipe("10.0.0.1:8333",
+		"10.0.0.2:8333")
+	p, err := peer.NewOutboundPeer(&peer.Config{
+		ChainParams: &chaincfg.MainNetParams,
+	}, "10.0.0.2:8333")
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected err %v", err)
+	}
+	p.Connect(localConn)
+	defer p.Disconnect()
+	sp = peertest.New(remoteConn, peer.MaxProtocolVersion,
+		chaincfg.MainNetParams.Net)
+	defer sp.Close()
+	version := sp.NewVersionMsg()
+	version.UserAgent = "/scripted:1.0/"
+	script = peertest.OutboundHandshake(version, time.Second)
+	script = append(script, peertest.Assert("user agent", time.Second,
+		func() error {
+			if p.UserAgent() != version.UserAgent {
+				return fmt.Errorf("unexpected user agent %q",
+					p.UserAgent())
+			}
+			return nil
+		}))
+	if err := sp.Run(script); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	err = sp.Transcript().CompareGolden(`
+		recv version
+		send version
+		recv verack
+		send verack
+	`)
+	if err != nil {
+		t.Fatalf("CompareGolden: %v", err)
+	}
+}
+
+// TestScriptedPeerFailure ensures a failing script reports the failed step
+// along with the transcript up to the failure.
+func TestScriptedPeerFailure(t *testing.T) {
+	tests := []struct {
+		name  string
+		step  peertest.Step
+		index int
+		want  string
+	}{
+		{
+			name:  "timeout",
+			step:  peertest.Expect(wire.CmdPing, 50*time.Millisecond, nil),
+			index: 4,
+			want:  "no ping message within 50ms",
+		},
+		{
+			name:  "unexpected command",
+			step:  peertest.Expect(wire.CmdVerAck, time.Second, nil),
+			index: 5,
+			want:  "unexpected pong message",
+		},
+		{
+			name: "failed check",
+			step: peertest.Expect(wire.CmdPong, time.Second,
+				func(msg wire.Message) error {
+					return errors.New("bad pong")
+				}),
+			index: 5,
+			want:  "bad pong",
+		},
+		{
+			name: "failed assertion",
+			step: peertest.Assert("never holds", 50*time.Millisecond,
+				func() error {
+					return errors.New("condition not met")
+				}),
+			index: 4,
+			want:  "condition not met",
+		},
+		{
+			name:  "no disconnect",
+			step:  peertest.ExpectDisconnect(50 * time.Millisecond),
+			index: 4,
+			want:  "peer did not disconnect within 50ms",
+		},
+	}
+	for _, test := range tests {
+		p, sp := newInboundPeer()
+		script := peertest.InboundHandshake(nil, time.Second)
+		if test.index == 5 {
+			script = append(script, peertest.Send(wire.NewMsgPing(1)))
+		}
+		script = append(script, test.step)
+		err := sp.Run(script)
+		p.Disconnect()
+		sp.Close()
+
+		serr, ok := err.(*peertest.ScriptError)
+		if !ok {
+			t.Errorf("%s: unexpected error - got %v <%T>, want "+
+				"*peertest.ScriptError", test.name, err, err)
+			continue
+		}
+		if serr.Index != test.index || serr.Step != test.step.String() {
+			t.Errorf("%s: unexpected failed step - got #%d (%s), "+
+				"want #%d (%s)", test.name, serr.Index, serr.Step,
+				test.index, test.step)
+			continue
+		}
+		if serr.Err.Error() != test.want {
+			t.Errorf("%s: unexpected reason - got %q, want %q",
+				test.name, serr.Err, test.want)
+			continue
+		}
+		if !strings.Contains(serr.Error(), "recv verack\nsend verack\n") {
+			t.Errorf("%s: transcript missing from error %q",
+				test.name, serr.Error())
+		}
+	}
+}
+
+// TestTranscriptGolden ensures notes may be added to transcripts concurrently
+// and transcripts are compared against golden transcripts as expected.
+func TestTranscriptGolden(t *testing.T) {
+	var transcript peertest.Transcript
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			transcript.Notef("listener %s called", "OnPing")
+		}()
+	}
+	wg.Wait()
+	if got := len(transcript.Entries()); got != 10 {
+		t.Fatalf("unexpected number of entries - got %d, want 10", got)
+	}
+
+	golden := strings.Repeat("note listener OnPing called\n", 10)
+	if err := transcript.CompareGolden(golden); err != nil {
+		t.Fatalf("CompareGolden: unexpected err %v", err)
+	}
+	commented := "# comment\n\n  " + strings.Replace(golden, "\n",
+		"\n\n", -1)
+	if err := transcript.CompareGolden(commented); err != nil {
+		t.Fatalf("CompareGolden: unexpected err with comments %v", err)
+	}
+	if err := transcript.CompareGolden(golden + "send ping\n"); err == nil {
+		t.Fatal("CompareGolden: missing entry not detected")
+	}
+	short := strings.Repeat("note listener OnPing called\n", 9)
+	if err := transcript.CompareGolden(short); err == nil {
+		t.Fatal("CompareGolden: extra entry not detected")
+	}
+	changed := strings.Replace(golden, "OnPing", "OnPong", 1)
+	if err := transcript.CompareGolden(changed); err == nil {
+		t.Fatal("CompareGolden: changed entry not detected")
+	}
+}
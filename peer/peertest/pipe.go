@@ -0,0 +1,64 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peertest
+
+import (
+	"net"
+)
+
+// addr is a net.Addr for fake addresses which can't be parsed as TCP
+// addresses.
+type addr struct {
+	address string
+}
+
+// Network returns the network of the address.
+func (a addr) Network() string { return "tcp" }
+
+// String returns the address.
+func (a addr) String() string { return a.address }
+
+// newAddr returns a net.Addr for the passed fake address.  Addresses which can
+// be parsed as a TCP address are returned as a *net.TCPAddr so callers which
+// inspect the address behave as they would for a real connection.
+func newAddr(address string) net.Addr {
+	if tcpAddr, err := net.ResolveTCPAddr("tcp", address); err == nil {
+		return tcpAddr
+	}
+	return addr{address}
+}
+
+// Conn is one end of an in-memory, full duplex connection returned by Pipe.
+// It retains the deadline and close semantics of net.Pipe while reporting fake
+// local and remote addresses.
+type Conn struct {
+	net.Conn
+	laddr, raddr net.Addr
+}
+
+// LocalAddr returns the fake local address of the connection.
+//
+// This is part of the net.Conn interface.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.laddr
+}
+
+// RemoteAddr returns the fake remote address of the connection.
+//
+// This is part of the net.Conn interface.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.raddr
+}
+
+// Pipe returns both ends of an in-memory, full duplex connection.  The local
+// end reports the passed local and remote addresses and the remote end reports
+// them the other way around.  The local end is typically handed to the peer
+// under test while the remote end is handed to a ScriptedPeer.
+func Pipe(localAddr, remoteAddr string) (*Conn, *Conn) {
+	c1, c2 := net.Pipe()
+	laddr, raddr := newAddr(localAddr), newAddr(remoteAddr)
+	return &Conn{c1, laddr, raddr}, &Conn{c2, raddr, laddr}
+}
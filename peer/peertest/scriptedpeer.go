@@ -0,0 +1,309 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peertest
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// DefaultTimeout is the amount of time steps created with a zero timeout wait
+// for the peer under test.  It also limits how long the scripted peer waits
+// for the peer under test to read the messages it sends.
+const DefaultTimeout = time.Second * 2
+
+// assertPollInterval is the interval at which the conditions of assert steps
+// are checked until they hold.
+const assertPollInterval = time.Millisecond * 10
+
+// ScriptError identifies a step of a script which failed.  It includes the
+// transcript of the scripted peer at the time of the failure.
+type ScriptError struct {
+	// Index is the index of the failed step in the script.
+	Index int
+
+	// Step is the description of the failed step.
+	Step string
+
+	// Err is the reason the step failed.
+	Err error
+
+	// Transcript is the transcript of the scripted peer at the time of the
+	// failure.
+	Transcript string
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("step #%d (%s) failed: %v\ntranscript:\n%s", e.Index,
+		e.Step, e.Err, e.Transcript)
+}
+
+// Step is a single step of a script executed by a scripted peer.  Steps are
+// created with functions such as Send, Expect, and Assert.
+type Step struct {
+	desc string
+	run  func(sp *ScriptedPeer) error
+}
+
+// String returns a description of the step.
+func (s Step) String() string {
+	return s.desc
+}
+
+// ScriptedPeer is the remote end of a connection to a peer under test which
+// executes scripts of steps.  It only reads messages from the connection while
+// a step which expects them is running, so it stalls the peer under test once
+// it stops reading.
+type ScriptedPeer struct {
+	conn       net.Conn
+	pver       uint32
+	btcnet     wire.BitcoinNet
+	ignored    map[string]struct{}
+	transcript Transcript
+}
+
+// New returns a scripted peer which exchanges messages with the peer under
+// test over the passed connection using the passed protocol version and
+// bitcoin network.
+func New(conn net.Conn, pver uint32, btcnet wire.BitcoinNet) *ScriptedPeer {
+	return &ScriptedPeer{
+		conn:    conn,
+		pver:    pver,
+		btcnet:  btcnet,
+		ignored: make(map[string]struct{}),
+	}
+}
+
+// Ignore causes steps which expect messages to skip messages with the passed
+// commands.  Ignored messages are still recorded in the transcript.  It must
+// not be called while a script is running.
+func (sp *ScriptedPeer) Ignore(commands ...string) {
+	for _, command := range commands {
+		sp.ignored[command] = struct{}{}
+	}
+}
+
+// Transcript returns the transcript of the messages exchanged by the scripted
+// peer.
+func (sp *ScriptedPeer) Transcript() *Transcript {
+	return &sp.transcript
+}
+
+// Close closes the connection to the peer under test.
+func (sp *ScriptedPeer) Close() error {
+	return sp.conn.Close()
+}
+
+// NewVersionMsg returns a version message for the scripted peer which
+// advertises its protocol version and the addresses of its connection.
+func (sp *ScriptedPeer) NewVersionMsg() *wire.MsgVersion {
+	netAddr := func(addr net.Addr) *wire.NetAddress {
+		if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+			return wire.NewNetAddressIPPort(tcpAddr.IP,
+				uint16(tcpAddr.Port), 0)
+		}
+		return wire.NewNetAddressIPPort(net.IPv4zero, 0, 0)
+	}
+
+	nonce, _ := wire.RandomUint64()
+	msg := wire.NewMsgVersion(netAddr(sp.conn.RemoteAddr()),
+		netAddr(sp.conn.LocalAddr()), nonce, 0)
+	msg.ProtocolVersion = int32(sp.pver)
+	return msg
+}
+
+// Run executes the steps of the passed script in order.  It returns a
+// ScriptError for the first step which fails.
+func (sp *ScriptedPeer) Run(script []Step) error {
+	for i, step := range script {
+		if err := step.run(sp); err != nil {
+			return &ScriptError{
+				Index:      i,
+				Step:       step.desc,
+				Err:        err,
+				Transcript: sp.transcript.String(),
+			}
+		}
+	}
+	return nil
+}
+
+// send writes the passed message to the peer under test and records it in the
+// transcript.
+func (sp *ScriptedPeer) send(msg wire.Message) error {
+	sp.conn.SetWriteDeadline(time.Now().Add(DefaultTimeout))
+	defer sp.conn.SetWriteDeadline(time.Time{})
+
+	err := wire.WriteMessage(sp.conn, msg, sp.pver, sp.btcnet)
+	if err != nil {
+		return fmt.Errorf("unable to write %s message: %v",
+			msg.Command(), err)
+	}
+	sp.transcript.add(Entry{Direction: Sent, Msg: msg})
+	return nil
+}
+
+// receive reads the next message from the peer under test which is not
+// ignored and records every message read in the transcript.  An error which
+// reports a timeout is returned when no such message arrives by the passed
+// deadline.
+func (sp *ScriptedPeer) receive(deadline time.Time) (wire.Message, error) {
+	sp.conn.SetReadDeadline(deadline)
+	defer sp.conn.SetReadDeadline(time.Time{})
+
+	for {
+		msg, _, err := wire.ReadMessage(sp.conn, sp.pver, sp.btcnet)
+		if err != nil {
+			return nil, err
+		}
+		sp.transcript.add(Entry{Direction: Received, Msg: msg})
+		if _, ok := sp.ignored[msg.Command()]; !ok {
+			return msg, nil
+		}
+	}
+}
+
+// isTimeout returns whether or not the passed error reports a timeout.
+func isTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
+
+// stepTimeout returns the passed timeout or the default timeout when it is
+// zero.
+func stepTimeout(timeout time.Duration) time.Duration {
+	if timeout == 0 {
+		return DefaultTimeout
+	}
+	return timeout
+}
+
+// Send returns a step which sends the passed message to the peer under test.
+func Send(msg wire.Message) Step {
+	return Step{
+		desc: fmt.Sprintf("send %s", msg.Command()),
+		run: func(sp *ScriptedPeer) error {
+			return sp.send(msg)
+		},
+	}
+}
+
+// Expect returns a step which waits for the next message from the peer under
+// test and fails unless it arrives within the passed timeout and has the
+// passed command.  The passed check function, when not nil, is then called
+// with the message and the step fails when it returns an error.
+func Expect(command string, timeout time.Duration, check func(wire.Message) error) Step {
+	timeout = stepTimeout(timeout)
+	return Step{
+		desc: fmt.Sprintf("expect %s", command),
+		run: func(sp *ScriptedPeer) error {
+			msg, err := sp.receive(time.Now().Add(timeout))
+			if err != nil {
+				if isTimeout(err) {
+					return fmt.Errorf("no %s message within %v",
+						command, timeout)
+				}
+				return fmt.Errorf("unable to read %s message: %v",
+					command, err)
+			}
+			if msg.Command() != command {
+				return fmt.Errorf("unexpected %s message",
+					msg.Command())
+			}
+			if check != nil {
+				return check(msg)
+			}
+			return nil
+		},
+	}
+}
+
+// ExpectDisconnect returns a step which fails unless the peer under test
+// closes the connection within the passed timeout without sending any messages
+// which are not ignored.
+func ExpectDisconnect(timeout time.Duration) Step {
+	timeout = stepTimeout(timeout)
+	return Step{
+		desc: "expect disconnect",
+		run: func(sp *ScriptedPeer) error {
+			msg, err := sp.receive(time.Now().Add(timeout))
+			if err == nil {
+				return fmt.Errorf("unexpected %s message",
+					msg.Command())
+			}
+			if isTimeout(err) {
+				return fmt.Errorf("peer did not disconnect within %v",
+					timeout)
+			}
+			return nil
+		},
+	}
+}
+
+// Assert returns a step which fails unless the passed condition holds within
+// the passed timeout.  The condition holds once it returns nil and the error
+// it returned last is reported otherwise.  It is typically used to check the
+// state of the peer under test via its accessors.
+func Assert(desc string, timeout time.Duration, condition func() error) Step {
+	timeout = stepTimeout(timeout)
+	return Step{
+		desc: fmt.Sprintf("assert %s", desc),
+		run: func(sp *ScriptedPeer) error {
+			deadline := time.Now().Add(timeout)
+			for {
+				err := condition()
+				if err == nil || time.Now().After(deadline) {
+					return err
+				}
+				time.Sleep(assertPollInterval)
+			}
+		},
+	}
+}
+
+// sendVersion returns a step which sends the passed version message or, when
+// it is nil, the default version message of the scripted peer.
+func sendVersion(version *wire.MsgVersion) Step {
+	return Step{
+		desc: fmt.Sprintf("send %s", wire.CmdVersion),
+		run: func(sp *ScriptedPeer) error {
+			if version == nil {
+				return sp.send(sp.NewVersionMsg())
+			}
+			return sp.send(version)
+		},
+	}
+}
+
+// InboundHandshake returns the steps which complete the version handshake
+// with a peer under test which accepted the connection from the scripted peer.
+// The passed version message is sent to the peer under test or, when it is
+// nil, the default version message of the scripted peer.
+func InboundHandshake(version *wire.MsgVersion, timeout time.Duration) []Step {
+	return []Step{
+		sendVersion(version),
+		Expect(wire.CmdVersion, timeout, nil),
+		Expect(wire.CmdVerAck, timeout, nil),
+		Send(wire.NewMsgVerAck()),
+	}
+}
+
+// OutboundHandshake returns the steps which complete the version handshake
+// with a peer under test which made the connection to the scripted peer.  The
+// passed version message is sent to the peer under test or, when it is nil,
+// the default version message of the scripted peer.
+func OutboundHandshake(version *wire.MsgVersion, timeout time.Duration) []Step {
+	return []Step{
+		Expect(wire.CmdVersion, timeout, nil),
+		sendVersion(version),
+		Expect(wire.CmdVerAck, timeout, nil),
+		Send(wire.NewMsgVerAck()),
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package peertest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// Direction identifies the kind of a transcript entry.
+type Direction int
+
+// These constants define the kinds of transcript entries.
+const (
+	// Sent identifies a message sent by the scripted peer to the peer
+	// under test.
+	Sent Direction = iota
+
+	// Received identifies a message received by the scripted peer from
+	// the peer under test.
+	Received
+
+	// Note identifies a note added to the transcript by the test.
+	Note
+)
+
+// Map of directions back to their prefix in transcripts.
+var directionStrings = map[Direction]string{
+	Sent:     "send",
+	Received: "recv",
+	Note:     "note",
+}
+
+// String returns the Direction in human-readable form.
+func (d Direction) String() string {
+	if s, ok := directionStrings[d]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown Direction (%d)", int(d))
+}
+
+// Entry is a single entry in a transcript.
+type Entry struct {
+	// Direction is the kind of the entry.
+	Direction Direction
+
+	// Msg is the message sent or received.  It is nil for notes.
+	Msg wire.Message
+
+	// Text is the text of a note.
+	Text string
+}
+
+// String returns the entry as it appears in transcripts.  Messages are
+// described by their command along with the details of the message which are
+// the same every time a test runs, so the transcript of a deterministic test
+// can be compared against a golden transcript.
+func (e *Entry) String() string {
+	if e.Direction == Note {
+		return fmt.Sprintf("%s %s", e.Direction, e.Text)
+	}
+
+	desc := fmt.Sprintf("%s %s", e.Direction, e.Msg.Command())
+	switch msg := e.Msg.(type) {
+	case *wire.MsgReject:
+		desc += fmt.Sprintf(" %s %v: %s", msg.Cmd, msg.Code, msg.Reason)
+	case *wire.MsgInv:
+		desc += fmt.Sprintf(" (%d items)", len(msg.InvList))
+	case *wire.MsgGetData:
+		desc += fmt.Sprintf(" (%d items)", len(msg.InvList))
+	case *wire.MsgNotFound:
+		desc += fmt.Sprintf(" (%d items)", len(msg.InvList))
+	case *wire.MsgHeaders:
+		desc += fmt.Sprintf(" (%d headers)", len(msg.Headers))
+	case *wire.MsgAddr:
+		desc += fmt.Sprintf(" (%d addresses)", len(msg.AddrList))
+	}
+	return desc
+}
+
+// Transcript records the messages exchanged between a scripted peer and the
+// peer under test along with notes added by the test.
+//
+// The transcript is safe for concurrent access so notes may be added from
+// message listeners while a script is running.
+type Transcript struct {
+	mtx     sync.Mutex
+	entries []Entry
+}
+
+// add appends the passed entry to the transcript.
+//
+// This function is safe for concurrent access.
+func (t *Transcript) add(entry Entry) {
+	t.mtx.Lock()
+	t.entries = append(t.entries, entry)
+	t.mtx.Unlock()
+}
+
+// Notef adds a note formatted according to the passed format specifier to the
+// transcript.
+//
+// This function is safe for concurrent access.
+func (t *Transcript) Notef(format string, args ...interface{}) {
+	t.add(Entry{Direction: Note, Text: fmt.Sprintf(format, args...)})
+}
+
+// Entries returns a copy of the entries in the transcript.
+//
+// This function is safe for concurrent access.
+func (t *Transcript) Entries() []Entry {
+	t.mtx.Lock()
+	entries := make([]Entry, len(t.entries))
+	copy(entries, t.entries)
+	t.mtx.Unlock()
+	return entries
+}
+
+// Lines returns the entries in the transcript as they appear in transcripts.
+//
+// This function is safe for concurrent access.
+func (t *Transcript) Lines() []string {
+	entries := t.Entries()
+	lines := make([]string, 0, len(entries))
+	for i := range entries {
+		lines = append(lines, entries[i].String())
+	}
+	return lines
+}
+
+// String returns the transcript with one entry per line.
+//
+// This function is safe for concurrent access.
+func (t *Transcript) String() string {
+	lines := t.Lines()
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// goldenLines returns the entries of the passed golden transcript.  Leading
+// and trailing whitespace, empty lines, and lines starting with # are
+// ignored.
+func goldenLines(golden string) []string {
+	var lines []string
+	for _, line := range strings.Split(golden, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// CompareGolden compares the transcript against the passed golden transcript
+// and returns an error describing the first difference along with both
+// transcripts when they differ.  Empty lines and lines starting with # in the
+// golden transcript are ignored.
+//
+// This function is safe for concurrent access.
+func (t *Transcript) CompareGolden(golden string) error {
+	got, want := t.Lines(), goldenLines(golden)
+	for i := 0; i < len(got) || i < len(want); i++ {
+		var gotLine, wantLine string
+		if i < len(got) {
+			gotLine = got[i]
+		}
+		if i < len(want) {
+			wantLine = want[i]
+		}
+		if gotLine == wantLine {
+			continue
+		}
+
+		return fmt.Errorf("transcript differs from golden transcript "+
+			"at entry #%d - got %q, want %q\ngot:\n%s\nwant:\n%s", i,
+			gotLine, wantLine, strings.Join(got, "\n"),
+			strings.Join(want, "\n"))
+	}
+	return nil
+}
+
+// CompareGoldenFile compares the transcript against the golden transcript in
+// the file at the passed path in the same manner as CompareGolden.
+//
+// This function is safe for concurrent access.
+func (t *Transcript) CompareGoldenFile(path string) error {
+	golden, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return t.CompareGolden(string(golden))
+}
+
+// WriteGoldenFile writes the transcript to the file at the passed path so it
+// can be used as a golden transcript.  It is intended to create or update the
+// golden transcripts of tests.
+//
+// This function is safe for concurrent access.
+func (t *Transcript) WriteGoldenFile(path string) error {
+	return ioutil.WriteFile(path, []byte(t.String()), 0644)
+}
@@ -0,0 +1,288 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// The peer quality score is the sum of four components which together range
+// from 0, the worst possible peer, to 100, the best possible peer.  Each
+// component is weighted as follows:
+//
+//	Component    Weight   Derived from
+//	latency      40       90th percentile of the recent ping round trips
+//	usefulness   30       bytes of blocks and transactions received
+//	stalls       15       responses not delivered before their deadline
+//	behavior     15       misbehavior (ban) score against the ban threshold
+//
+// Latency gets full credit at or below qualityFastPing and no credit at or
+// above qualitySlowPing, scaling linearly in between.  A peer which has not
+// answered any pings yet gets half of the credit so new peers are neither
+// favored nor penalized.  Usefulness scales linearly with the bytes of useful
+// data received up to qualityUsefulBytes.  Every stall costs qualityStallCost
+// of the stall credit, and the behavior credit shrinks linearly as the ban
+// score approaches the ban threshold.
+const (
+	qualityLatencyWeight    = 40
+	qualityUsefulnessWeight = 30
+	qualityStallsWeight     = 15
+	qualityBehaviorWeight   = 15
+
+	// qualityFastPing and qualitySlowPing are the round trip times in
+	// microseconds at or below which a peer gets the full latency credit
+	// and at or above which it gets none.
+	qualityFastPing = 50000
+	qualitySlowPing = 2000000
+
+	// qualityUsefulBytes is the number of bytes of useful data received
+	// from a peer at which it gets the full usefulness credit.
+	qualityUsefulBytes = 1 << 20
+
+	// qualityStallCost is the stall credit lost for every stall.
+	qualityStallCost = 5
+
+	// peerQualityTTL is the amount of time a computed peer quality score is
+	// reused before it is recomputed from the latest peer stats.
+	peerQualityTTL = 10 * time.Second
+
+	// evictionProtectLongest is the number of the longest connected
+	// inbound peers which are protected from eviction in favor of new
+	// inbound peers.
+	evictionProtectLongest = 4
+)
+
+// qualityUsefulCommands houses the commands of the messages which carry data
+// that makes a peer useful to the node.
+var qualityUsefulCommands = []string{
+	wire.CmdBlock,
+	wire.CmdTx,
+	wire.CmdHeaders,
+	wire.CmdMerkleBlock,
+}
+
+// peerQualityStats houses the peer statistics a peer quality score is computed
+// from.
+type peerQualityStats struct {
+	PingSamples     []int64
+	BytesRecvPerMsg map[string]uint64
+	StallCount      uint32
+	BanScore        uint32
+	BanThreshold    uint32
+}
+
+// peerQuality houses a peer quality score along with the components it is made
+// of.
+type peerQuality struct {
+	Score      float64
+	Latency    float64
+	Usefulness float64
+	Stalls     float64
+	Behavior   float64
+}
+
+// int64Sorter implements sort.Interface to allow a slice of 64-bit integers to
+// be sorted.
+type int64Sorter []int64
+
+// Len returns the number of 64-bit integers in the slice.  It is part of the
+// sort.Interface implementation.
+func (s int64Sorter) Len() int {
+	return len(s)
+}
+
+// Swap swaps the 64-bit integers at the passed indices.  It is part of the
+// sort.Interface implementation.
+func (s int64Sorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+// Less returns whether the 64-bit integer with index i should sort before the
+// 64-bit integer with index j.  It is part of the sort.Interface
+// implementation.
+func (s int64Sorter) Less(i, j int) bool {
+	return s[i] < s[j]
+}
+
+// pingPercentile returns the passed percentile of the passed ping round trip
+// times using the nearest rank method.  It returns 0 when there are no samples.
+func pingPercentile(samples []int64, pct int) int64 {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	sorted := make([]int64, len(samples))
+	copy(sorted, samples)
+	sort.Sort(int64Sorter(sorted))
+
+	rank := (pct*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+// calcPeerQuality returns the peer quality score for the passed peer stats
+// according to the weighting documented above.
+func calcPeerQuality(stats *peerQualityStats) peerQuality {
+	var q peerQuality
+
+	// Latency.
+	if len(stats.PingSamples) == 0 {
+		q.Latency = qualityLatencyWeight / 2
+	} else {
+		p90 := pingPercentile(stats.PingSamples, 90)
+		switch {
+		case p90 <= qualityFastPing:
+			q.Latency = qualityLatencyWeight
+		case p90 < qualitySlowPing:
+			q.Latency = qualityLatencyWeight *
+				float64(qualitySlowPing-p90) /
+				(qualitySlowPing - qualityFastPing)
+		}
+	}
+
+	// Usefulness.
+	var useful uint64
+	for _, command := range qualityUsefulCommands {
+		useful += stats.BytesRecvPerMsg[command]
+	}
+	if useful >= qualityUsefulBytes {
+		q.Usefulness = qualityUsefulnessWeight
+	} else {
+		q.Usefulness = qualityUsefulnessWeight * float64(useful) /
+			qualityUsefulBytes
+	}
+
+	// Stalls.
+	if stats.StallCount < qualityStallsWeight/qualityStallCost {
+		q.Stalls = qualityStallsWeight -
+			float64(stats.StallCount*qualityStallCost)
+	}
+
+	// Behavior.
+	if stats.BanThreshold == 0 {
+		q.Behavior = qualityBehaviorWeight
+	} else if stats.BanScore < stats.BanThreshold {
+		q.Behavior = qualityBehaviorWeight *
+			(1 - float64(stats.BanScore)/float64(stats.BanThreshold))
+	}
+
+	q.Score = q.Latency + q.Usefulness + q.Stalls + q.Behavior
+	return q
+}
+
+// Quality returns the quality score of the peer.  The score is computed lazily
+// from the latest stats of the peer once the previously computed one is older
+// than peerQualityTTL.
+//
+// This function is safe for concurrent access.
+func (sp *serverPeer) Quality() peerQuality {
+	sp.qualityMtx.Lock()
+	defer sp.qualityMtx.Unlock()
+
+	now := time.Now()
+	if !sp.qualityTime.IsZero() && now.Sub(sp.qualityTime) < peerQualityTTL {
+		return sp.quality
+	}
+
+	stats := sp.StatsSnapshot()
+	var banThreshold uint32
+	if !cfg.DisableBanning {
+		banThreshold = cfg.BanThreshold
+	}
+	sp.quality = calcPeerQuality(&peerQualityStats{
+		PingSamples:     stats.PingSamples,
+		BytesRecvPerMsg: stats.BytesRecvPerMsg,
+		StallCount:      stats.StallCount,
+		BanScore:        sp.banScore.Int(),
+		BanThreshold:    banThreshold,
+	})
+	sp.qualityTime = now
+	return sp.quality
+}
+
+// evictionCandidate houses the details of an inbound peer considered for
+// eviction.
+type evictionCandidate struct {
+	sp       *serverPeer
+	id       int32
+	connTime time.Time
+	quality  float64
+	syncPeer bool
+}
+
+// connectedBefore returns whether the candidate connected before the passed
+// candidate, using the peer ids, which are assigned in order, to break ties.
+func (c *evictionCandidate) connectedBefore(other *evictionCandidate) bool {
+	if !c.connTime.Equal(other.connTime) {
+		return c.connTime.Before(other.connTime)
+	}
+	return c.id < other.id
+}
+
+// candidatesByAge implements sort.Interface to allow the indices of eviction
+// candidates to be sorted from the longest to the most recently connected
+// candidate.
+type candidatesByAge struct {
+	candidates []evictionCandidate
+	indices    []int
+}
+
+// Len returns the number of candidate indices.  It is part of the
+// sort.Interface implementation.
+func (s candidatesByAge) Len() int {
+	return len(s.indices)
+}
+
+// Swap swaps the candidate indices at the passed indices.  It is part of the
+// sort.Interface implementation.
+func (s candidatesByAge) Swap(i, j int) {
+	s.indices[i], s.indices[j] = s.indices[j], s.indices[i]
+}
+
+// Less returns whether the candidate with index i connected before the
+// candidate with index j.  It is part of the sort.Interface implementation.
+func (s candidatesByAge) Less(i, j int) bool {
+	return s.candidates[s.indices[i]].connectedBefore(
+		&s.candidates[s.indices[j]])
+}
+
+// selectEvictionCandidate returns the index of the candidate to evict in favor
+// of a new inbound peer, or -1 when every candidate is protected.  The sync
+// peer and the evictionProtectLongest longest connected candidates are
+// protected, and the candidate with the lowest quality score among the rest is
+// selected, preferring the most recently connected one on ties.
+func selectEvictionCandidate(candidates []evictionCandidate) int {
+	var unprotected []int
+	for i := range candidates {
+		if !candidates[i].syncPeer {
+			unprotected = append(unprotected, i)
+		}
+	}
+	sort.Sort(candidatesByAge{candidates, unprotected})
+	if len(unprotected) <= evictionProtectLongest {
+		return -1
+	}
+	unprotected = unprotected[evictionProtectLongest:]
+
+	evict := unprotected[0]
+	for _, i := range unprotected[1:] {
+		c, worst := &candidates[i], &candidates[evict]
+		if c.quality < worst.quality ||
+			(c.quality == worst.quality && worst.connectedBefore(c)) {
+
+			evict = i
+		}
+	}
+	return evict
+}
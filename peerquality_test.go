@@ -0,0 +1,307 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// TestPingPercentile ensures the ping round trip time percentiles are
+// calculated with the nearest rank method.
+func TestPingPercentile(t *testing.T) {
+	hundred := make([]int64, 100)
+	for i := range hundred {
+		hundred[i] = int64(100 - i)
+	}
+
+	tests := []struct {
+		name    string
+		samples []int64
+		pct     int
+		want    int64
+	}{
+		{"no samples", nil, 50, 0},
+		{"single sample p50", []int64{700}, 50, 700},
+		{"single sample p99", []int64{700}, 99, 700},
+		{"unsorted p50", []int64{30, 10, 20}, 50, 20},
+		{"unsorted p90", []int64{30, 10, 20}, 90, 30},
+		{"even count p50", []int64{4, 1, 3, 2}, 50, 2},
+		{"hundred p50", hundred, 50, 50},
+		{"hundred p90", hundred, 90, 90},
+		{"hundred p99", hundred, 99, 99},
+		{"zero percentile", []int64{5, 1, 3}, 0, 1},
+	}
+
+	for _, test := range tests {
+		samples := append([]int64(nil), test.samples...)
+		got := pingPercentile(test.samples, test.pct)
+		if got != test.want {
+			t.Errorf("%s: unexpected percentile - got %d, want %d",
+				test.name, got, test.want)
+		}
+		for i := range samples {
+			if samples[i] != test.samples[i] {
+				t.Errorf("%s: samples were modified", test.name)
+				break
+			}
+		}
+	}
+}
+
+// TestCalcPeerQuality ensures the peer quality score and its components are
+// calculated as documented from constructed peer stats.
+func TestCalcPeerQuality(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats peerQualityStats
+		want  peerQuality
+	}{
+		{
+			name:  "new peer",
+			stats: peerQualityStats{BanThreshold: 100},
+			want: peerQuality{
+				Score:    20 + 15 + 15,
+				Latency:  20,
+				Stalls:   15,
+				Behavior: 15,
+			},
+		},
+		{
+			name: "ideal peer",
+			stats: peerQualityStats{
+				PingSamples: []int64{10000, 50000, 20000},
+				BytesRecvPerMsg: map[string]uint64{
+					wire.CmdBlock: 1 << 20,
+				},
+				BanThreshold: 100,
+			},
+			want: peerQuality{
+				Score:      100,
+				Latency:    40,
+				Usefulness: 30,
+				Stalls:     15,
+				Behavior:   15,
+			},
+		},
+		{
+			name: "useful data is summed over commands",
+			stats: peerQualityStats{
+				PingSamples: []int64{50000},
+				BytesRecvPerMsg: map[string]uint64{
+					wire.CmdBlock:       1 << 17,
+					wire.CmdTx:          1 << 17,
+					wire.CmdHeaders:     1 << 17,
+					wire.CmdMerkleBlock: 1 << 17,
+					wire.CmdInv:         1 << 30,
+					wire.CmdAddr:        1 << 30,
+				},
+				BanThreshold: 100,
+			},
+			want: peerQuality{
+				Score:      40 + 15 + 15 + 15,
+				Latency:    40,
+				Usefulness: 15,
+				Stalls:     15,
+				Behavior:   15,
+			},
+		},
+		{
+			name: "latency scales with the 90th percentile",
+			stats: peerQualityStats{
+				// The 90th percentile of ten samples is the ninth
+				// lowest, which is halfway between the fast and
+				// slow round trip times.
+				PingSamples: []int64{1, 1, 1, 1, 1, 1, 1, 1,
+					1025000, 10000000},
+				BanThreshold: 100,
+			},
+			want: peerQuality{
+				Score:    20 + 15 + 15,
+				Latency:  20,
+				Stalls:   15,
+				Behavior: 15,
+			},
+		},
+		{
+			name: "slow peer",
+			stats: peerQualityStats{
+				PingSamples:  []int64{2000000, 3000000},
+				BanThreshold: 100,
+			},
+			want: peerQuality{
+				Score:    15 + 15,
+				Stalls:   15,
+				Behavior: 15,
+			},
+		},
+		{
+			name: "stalls",
+			stats: peerQualityStats{
+				StallCount:   2,
+				BanThreshold: 100,
+			},
+			want: peerQuality{
+				Score:    20 + 5 + 15,
+				Latency:  20,
+				Stalls:   5,
+				Behavior: 15,
+			},
+		},
+		{
+			name: "stall credit floors at zero",
+			stats: peerQualityStats{
+				StallCount:   100,
+				BanThreshold: 100,
+			},
+			want: peerQuality{
+				Score:    20 + 15,
+				Latency:  20,
+				Behavior: 15,
+			},
+		},
+		{
+			name: "misbehavior",
+			stats: peerQualityStats{
+				BanScore:     60,
+				BanThreshold: 100,
+			},
+			want: peerQuality{
+				Score:    20 + 15 + 6,
+				Latency:  20,
+				Stalls:   15,
+				Behavior: 6,
+			},
+		},
+		{
+			name: "ban score at the threshold",
+			stats: peerQualityStats{
+				BanScore:     150,
+				BanThreshold: 100,
+			},
+			want: peerQuality{
+				Score:   20 + 15,
+				Latency: 20,
+				Stalls:  15,
+			},
+		},
+		{
+			name:  "banning disabled",
+			stats: peerQualityStats{BanScore: 150},
+			want: peerQuality{
+				Score:    20 + 15 + 15,
+				Latency:  20,
+				Stalls:   15,
+				Behavior: 15,
+			},
+		},
+	}
+
+	const epsilon = 1e-9
+	equal := func(a, b float64) bool { return math.Abs(a-b) < epsilon }
+	for _, test := range tests {
+		got := calcPeerQuality(&test.stats)
+		if !equal(got.Score, test.want.Score) ||
+			!equal(got.Latency, test.want.Latency) ||
+			!equal(got.Usefulness, test.want.Usefulness) ||
+			!equal(got.Stalls, test.want.Stalls) ||
+			!equal(got.Behavior, test.want.Behavior) {
+
+			t.Errorf("%s: unexpected quality - got %+v, want %+v",
+				test.name, got, test.want)
+		}
+	}
+}
+
+// TestSelectEvictionCandidate ensures the inbound peer selected for eviction is
+// the lowest quality peer which is neither the sync peer nor one of the longest
+// connected peers.
+func TestSelectEvictionCandidate(t *testing.T) {
+	base := time.Unix(1460000000, 0)
+
+	// candidates returns candidates with the passed quality scores which
+	// connected one second apart in order.
+	candidates := func(scores ...float64) []evictionCandidate {
+		c := make([]evictionCandidate, len(scores))
+		for i, score := range scores {
+			c[i] = evictionCandidate{
+				id:       int32(i),
+				connTime: base.Add(time.Duration(i) * time.Second),
+				quality:  score,
+			}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name       string
+		candidates []evictionCandidate
+		sync       int
+		want       int
+	}{
+		{
+			name:       "all protected",
+			candidates: candidates(10, 20, 30, 40),
+			sync:       -1,
+			want:       -1,
+		},
+		{
+			name:       "lowest unprotected score",
+			candidates: candidates(10, 20, 30, 40, 70, 50, 60),
+			sync:       -1,
+			want:       5,
+		},
+		{
+			name:       "longest connected are protected",
+			candidates: candidates(1, 2, 3, 4, 90, 80),
+			sync:       -1,
+			want:       5,
+		},
+		{
+			name:       "ties evict the newest",
+			candidates: candidates(10, 20, 30, 40, 50, 50, 50),
+			sync:       -1,
+			want:       6,
+		},
+		{
+			name:       "sync peer is protected",
+			candidates: candidates(10, 20, 30, 40, 50, 5),
+			sync:       5,
+			want:       4,
+		},
+		{
+			name:       "sync peer does not take a protected slot",
+			candidates: candidates(10, 20, 30, 40, 50),
+			sync:       0,
+			want:       -1,
+		},
+	}
+
+	for _, test := range tests {
+		if test.sync != -1 {
+			test.candidates[test.sync].syncPeer = true
+		}
+		got := selectEvictionCandidate(test.candidates)
+		if got != test.want {
+			t.Errorf("%s: unexpected candidate - got %d, want %d",
+				test.name, got, test.want)
+		}
+	}
+
+	// Peers which connected at the same time are ordered by their ids.
+	c := candidates(10, 20, 30, 40, 50, 50)
+	for i := range c {
+		c[i].connTime = base
+	}
+	c[4].id, c[5].id = 5, 4
+	if got := selectEvictionCandidate(c); got != 4 {
+		t.Errorf("same connection time: unexpected candidate - got %d, "+
+			"want 4", got)
+	}
+}
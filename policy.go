@@ -7,6 +7,7 @@ package main
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/tinhnguyenhn/colxd/blockchain"
 	"github.com/tinhnguyenhn/colxd/txscript"
@@ -50,6 +51,11 @@ const (
 	// in a multi-signature transaction output script for it to be
 	// considered standard.
 	maxStandardMultiSigKeys = 3
+
+	// absurdFeeMultiplier is the multiple of the minimum required relay fee
+	// above which the fee paid by a locally submitted transaction is
+	// considered absurdly high.  This matches the reference implementation.
+	absurdFeeMultiplier = 10000
 )
 
 // calcMinRequiredTxRelayFee returns the minimum transaction fee required for a
@@ -207,17 +213,11 @@ func checkInputsStandard(tx *colxutil.Tx, utxoView *blockchain.UtxoViewpoint) er
 // script (public key script) to ensure it is a "standard" public key script.
 // A standard public key script is one that is a recognized form, and for
 // multi-signature scripts, only contains from 1 to maxStandardMultiSigKeys
-// public keys.
-func checkPkScriptStandard(pkScript []byte, scriptClass txscript.ScriptClass) error {
+// public keys.  The passed class and multi-signature statistics are those
+// returned by txscript.ClassifyScript for the script.
+func checkPkScriptStandard(scriptClass txscript.ScriptClass, numPubKeys, numSigs int) error {
 	switch scriptClass {
 	case txscript.MultiSigTy:
-		numPubKeys, numSigs, err := txscript.CalcMultiSigStats(pkScript)
-		if err != nil {
-			str := fmt.Sprintf("multi-signature script parse "+
-				"failure: %v", err)
-			return txRuleError(wire.RejectNonstandard, str)
-		}
-
 		// A standard multi-signature public key script must contain
 		// from 1 to maxStandardMultiSigKeys public keys.
 		if numPubKeys < 1 {
@@ -323,6 +323,22 @@ func isDust(txOut *wire.TxOut, minRelayTxFee colxutil.Amount) bool {
 	return txOut.Value*1000/(3*int64(totalSize)) < int64(minRelayTxFee)
 }
 
+// checkTransactionVersion returns an error when the passed transaction has a
+// version which is not relayed, which is a version below 1 or above the passed
+// maximum.  A maximum of zero disables the upper limit.  Transactions with
+// higher versions are still valid according to the consensus rules, but they
+// are not relayed since the rules they will be subject to once their version
+// is defined are not known yet.
+func checkTransactionVersion(tx *colxutil.Tx, maxTxVersion int32) error {
+	version := tx.MsgTx().Version
+	if version < 1 || (maxTxVersion != 0 && version > maxTxVersion) {
+		str := fmt.Sprintf("transaction version %d is not in the "+
+			"valid range of %d-%d", version, 1, maxTxVersion)
+		return txRuleError(wire.RejectNonstandard, str)
+	}
+	return nil
+}
+
 // checkTransactionStandard performs a series of checks on a transaction to
 // ensure it is a "standard" transaction.  A standard transaction is one that
 // conforms to several additional limiting cases over what is considered a
@@ -330,20 +346,21 @@ func isDust(txOut *wire.TxOut, minRelayTxFee colxutil.Amount) bool {
 // finalized, conforming to more stringent size constraints, having scripts
 // of recognized forms, and not containing "dust" outputs (those that are
 // so small it costs more to process them than they are worth).
-func checkTransactionStandard(tx *colxutil.Tx, height int32, timeSource blockchain.MedianTimeSource, minRelayTxFee colxutil.Amount) error {
+//
+// The passed height and median time past are those the transaction must be
+// finalized as of, which are the height of the next block and the median time
+// of the blocks prior to it.  The passed maximum transaction version is the
+// highest version which is considered standard.
+func checkTransactionStandard(tx *colxutil.Tx, height int32, medianTimePast time.Time, minRelayTxFee colxutil.Amount, maxTxVersion int32) error {
 	// The transaction must be a currently supported version.
 	msgTx := tx.MsgTx()
-	if msgTx.Version > wire.TxVersion || msgTx.Version < 1 {
-		str := fmt.Sprintf("transaction version %d is not in the "+
-			"valid range of %d-%d", msgTx.Version, 1,
-			wire.TxVersion)
-		return txRuleError(wire.RejectNonstandard, str)
+	if err := checkTransactionVersion(tx, maxTxVersion); err != nil {
+		return err
 	}
 
 	// The transaction must be finalized to be standard and therefore
 	// considered for inclusion in a block.
-	adjustedTime := timeSource.AdjustedTime()
-	if !blockchain.IsFinalizedTransaction(tx, height, adjustedTime) {
+	if !blockchain.IsFinalizedTransaction(tx, height, medianTimePast) {
 		return txRuleError(wire.RejectNonstandard,
 			"transaction is not finalized")
 	}
@@ -385,8 +402,9 @@ func checkTransactionStandard(tx *colxutil.Tx, height int32, timeSource blockcha
 	// be "dust" (except when the script is a null data script).
 	numNullDataOutputs := 0
 	for i, txOut := range msgTx.TxOut {
-		scriptClass := txscript.GetScriptClass(txOut.PkScript)
-		err := checkPkScriptStandard(txOut.PkScript, scriptClass)
+		scriptClass, numPubKeys, numSigs := txscript.ClassifyScript(
+			txOut.PkScript)
+		err := checkPkScriptStandard(scriptClass, numPubKeys, numSigs)
 		if err != nil {
 			// Attempt to extract a reject code from the error so
 			// it can be retained.  When not possible, fall back to
@@ -0,0 +1,160 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/btcsuite/seelog"
+)
+
+const (
+	// maxAuditParamLen is the maximum number of bytes of each parameter of
+	// an audited RPC call which is recorded.  Longer parameters such as
+	// serialized blocks and transactions are truncated.
+	maxAuditParamLen = 64
+
+	// maxAuditOutcomeLen is the maximum number of bytes of the outcome of an
+	// audited RPC call which is recorded.  Errors may quote the parameters
+	// they refer to, so they are truncated as well.
+	maxAuditOutcomeLen = 128
+)
+
+// defaultRPCAuditMethods are the RPC methods which are audited when no methods
+// are configured.
+var defaultRPCAuditMethods = []string{"node", "stop", "submitblock"}
+
+// isRPCMethod returns whether the passed method is handled by the RPC server
+// either over HTTP or websockets.
+func isRPCMethod(method string) bool {
+	if _, ok := rpcHandlers[method]; ok {
+		return true
+	}
+	_, ok := wsHandlers[method]
+	return ok
+}
+
+// newRPCMethodSet returns a set of the passed RPC methods.  An error is
+// returned when one of them is not handled by the RPC server.
+func newRPCMethodSet(methods []string) (map[string]struct{}, error) {
+	set := make(map[string]struct{}, len(methods))
+	for _, method := range methods {
+		if !isRPCMethod(method) {
+			return nil, fmt.Errorf("unknown RPC method %q", method)
+		}
+		set[method] = struct{}{}
+	}
+	return set, nil
+}
+
+// rpcAuditor records calls of sensitive RPC methods along with the user who
+// made them and their outcome.  The entries are logged by the audit subsystem
+// logger and also written to a separate audit file when one is configured.
+type rpcAuditor struct {
+	methods map[string]struct{}
+	fileLog seelog.LoggerInterface
+}
+
+// newRPCAuditor returns an auditor for calls of the passed methods.  When the
+// passed audit file is not empty, the entries are also written to that file,
+// which is rotated the same way as the main log file.
+func newRPCAuditor(methods []string, auditFile string) (*rpcAuditor, error) {
+	set, err := newRPCMethodSet(methods)
+	if err != nil {
+		return nil, err
+	}
+	a := rpcAuditor{methods: set}
+	if auditFile != "" {
+		config := `
+		<seelog type="sync" minlevel="info">
+			<outputs formatid="audit">
+				<rollingfile type="size" filename="%s" maxsize="10485760" maxrolls="3" />
+			</outputs>
+			<formats>
+				<format id="audit" format="%%Msg%%n" />
+			</formats>
+		</seelog>`
+		config = fmt.Sprintf(config, auditFile)
+		a.fileLog, err = seelog.LoggerFromConfigAsString(config)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &a, nil
+}
+
+// audits returns whether calls of the passed method are audited.  A nil
+// auditor does not audit any methods.
+func (a *rpcAuditor) audits(method string) bool {
+	if a == nil {
+		return false
+	}
+	_, ok := a.methods[method]
+	return ok
+}
+
+// record writes an audit entry for a call of the passed method with the passed
+// parameters by the passed user from the passed remote address when the method
+// is audited.  The passed error is the error the call failed with, if any.
+func (a *rpcAuditor) record(user, remoteAddr, method string, params []json.RawMessage, jsonErr error) {
+	if !a.audits(method) {
+		return
+	}
+
+	entry := formatAuditEntry(time.Now(), user, remoteAddr, method, params,
+		jsonErr)
+	auditLog.Info(entry)
+	if a.fileLog != nil {
+		a.fileLog.Info(entry)
+	}
+}
+
+// close flushes and closes the audit file, if any.
+func (a *rpcAuditor) close() {
+	if a != nil && a.fileLog != nil {
+		a.fileLog.Close()
+	}
+}
+
+// truncateAuditField returns the passed field truncated to the passed maximum
+// number of bytes along with a note about its full length when it is longer.
+func truncateAuditField(field string, maxLen int) string {
+	if len(field) <= maxLen {
+		return field
+	}
+	return fmt.Sprintf("%s...(%d bytes)", field[:maxLen], len(field))
+}
+
+// formatAuditEntry returns an audit entry of key=value pairs for the passed
+// call.  Every parameter and the outcome are truncated so that large parameters
+// such as raw transactions are never recorded in full.
+func formatAuditEntry(t time.Time, user, remoteAddr, method string, params []json.RawMessage, jsonErr error) string {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, param := range params {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(truncateAuditField(string(param),
+			maxAuditParamLen))
+	}
+	buf.WriteByte(']')
+
+	outcome := "ok"
+	if jsonErr != nil {
+		outcome = truncateAuditField("error: "+jsonErr.Error(),
+			maxAuditOutcomeLen)
+	}
+
+	return fmt.Sprintf("time=%s user=%s remote=%s method=%s params=%s "+
+		"outcome=%s", t.UTC().Format(time.RFC3339), strconv.Quote(user),
+		remoteAddr, method, strconv.Quote(buf.String()),
+		strconv.Quote(outcome))
+}
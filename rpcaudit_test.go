@@ -0,0 +1,402 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btclog"
+	"github.com/tinhnguyenhn/colxd/btcjson"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+)
+
+// auditTestServer is an HTTP server which handles RPC requests with an RPC
+// server along with the client used to send them.
+type auditTestServer struct {
+	t          *testing.T
+	httpServer *httptest.Server
+	client     *http.Client
+}
+
+// newAuditTestServer returns an HTTP server which handles requests with the
+// passed RPC server as a user with the passed access level.
+func newAuditTestServer(t *testing.T, s *rpcServer, isAdmin bool) *auditTestServer {
+	httpServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			s.jsonRPCRead(w, r, isAdmin)
+		}))
+	return &auditTestServer{
+		t:          t,
+		httpServer: httpServer,
+		client: &http.Client{
+			Transport: &http.Transport{DisableKeepAlives: true},
+		},
+	}
+}
+
+// call sends the passed command to the server and returns the error of the
+// reply, if any.
+func (ts *auditTestServer) call(cmd interface{}) *btcjson.RPCError {
+	body, err := btcjson.MarshalCmd(1, cmd)
+	if err != nil {
+		ts.t.Fatalf("MarshalCmd: unexpected error: %v", err)
+	}
+	resp, err := ts.client.Post(ts.httpServer.URL, "application/json",
+		bytes.NewReader(body))
+	if err != nil {
+		ts.t.Fatalf("Post: unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		Error *btcjson.RPCError `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		ts.t.Fatalf("unable to decode reply: %v", err)
+	}
+	return reply.Error
+}
+
+// auditBuffer is a concurrent safe buffer the audit log is written to by
+// tests.
+type auditBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+// Write appends the passed bytes to the buffer.
+func (b *auditBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+// lines returns the lines written to the buffer.
+func (b *auditBuffer) lines() []string {
+	b.Lock()
+	defer b.Unlock()
+	s := strings.TrimSpace(b.buf.String())
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// parseAuditEntry returns the fields of the passed audit entry with quoted
+// values unquoted.
+func parseAuditEntry(entry string) (map[string]string, error) {
+	fields := make(map[string]string)
+	for entry != "" {
+		idx := strings.IndexByte(entry, '=')
+		if idx == -1 {
+			return nil, fmt.Errorf("missing value in %q", entry)
+		}
+		key := entry[:idx]
+		entry = entry[idx+1:]
+
+		var value string
+		if strings.HasPrefix(entry, `"`) {
+			quoted, err := strconv.QuotedPrefix(entry)
+			if err != nil {
+				return nil, err
+			}
+			value, _ = strconv.Unquote(quoted)
+			entry = entry[len(quoted):]
+		} else {
+			value = entry
+			if idx := strings.IndexByte(entry, ' '); idx != -1 {
+				value = entry[:idx]
+			}
+			entry = entry[len(value):]
+		}
+		fields[key] = value
+		entry = strings.TrimPrefix(entry, " ")
+	}
+	return fields, nil
+}
+
+// TestRPCLimitedMethods ensures limited users may only call the configured
+// methods, or the default ones when none are configured, while admin users may
+// call every method.
+func TestRPCLimitedMethods(t *testing.T) {
+	defer func(c *config) { cfg = c }(cfg)
+	cfg = &config{}
+
+	if _, err := newRPCMethodSet([]string{"getcurrentnet", "nosuchmethod"}); err == nil {
+		t.Fatal("newRPCMethodSet: did not reject an unknown method")
+	}
+	configured, err := newRPCMethodSet([]string{"getcurrentnet"})
+	if err != nil {
+		t.Fatalf("newRPCMethodSet: unexpected error: %v", err)
+	}
+
+	srvr := &server{chainParams: &chaincfg.RegressionNetParams}
+	tests := []struct {
+		name    string
+		limited map[string]struct{}
+		isAdmin bool
+		cmd     interface{}
+		allowed bool
+	}{
+		{
+			name:    "configured method",
+			limited: configured,
+			cmd:     btcjson.NewGetCurrentNetCmd(),
+			allowed: true,
+		},
+		{
+			name:    "method not configured",
+			limited: configured,
+			cmd:     btcjson.NewDebugLevelCmd("show"),
+			allowed: false,
+		},
+		{
+			name:    "method only in default set",
+			limited: configured,
+			cmd:     btcjson.NewGetBlockCountCmd(),
+			allowed: false,
+		},
+		{
+			name:    "default set",
+			limited: rpcLimited,
+			cmd:     btcjson.NewGetCurrentNetCmd(),
+			allowed: true,
+		},
+		{
+			name:    "method not in default set",
+			limited: rpcLimited,
+			cmd:     btcjson.NewDebugLevelCmd("show"),
+			allowed: false,
+		},
+		{
+			name:    "admin",
+			limited: configured,
+			isAdmin: true,
+			cmd:     btcjson.NewDebugLevelCmd("show"),
+			allowed: true,
+		},
+	}
+	for _, test := range tests {
+		s := &rpcServer{
+			server:      srvr,
+			limited:     test.limited,
+			statusLines: make(map[int]string),
+		}
+		ts := newAuditTestServer(t, s, test.isAdmin)
+		rpcErr := ts.call(test.cmd)
+		ts.httpServer.Close()
+
+		denied := rpcErr != nil && strings.Contains(rpcErr.Message,
+			"not authorized")
+		if denied == test.allowed {
+			t.Errorf("%s: unexpected authorization - got error %v, "+
+				"want allowed %v", test.name, rpcErr, test.allowed)
+		}
+	}
+}
+
+// TestRPCAudit ensures calls of audited methods are recorded by the audit
+// logger and in the audit file with all of their fields, while the parameters
+// and outcomes are truncated so raw data is never recorded in full.
+func TestRPCAudit(t *testing.T) {
+	defer func(c *config) { cfg = c }(cfg)
+	cfg = &config{RPCUser: "admin", RPCLimitUser: "limited"}
+
+	var logBuf auditBuffer
+	logger, err := btclog.NewLoggerFromWriter(&logBuf, btclog.InfoLvl)
+	if err != nil {
+		t.Fatalf("unable to create logger: %v", err)
+	}
+	defer func(logger btclog.Logger) { auditLog = logger }(auditLog)
+	auditLog = logger
+
+	dir, err := ioutil.TempDir("", "rpcaudit")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	auditFile := filepath.Join(dir, "audit.log")
+	if _, err := newRPCAuditor([]string{"nosuchmethod"}, ""); err == nil {
+		t.Fatal("newRPCAuditor: did not reject an unknown method")
+	}
+	auditor, err := newRPCAuditor(defaultRPCAuditMethods, auditFile)
+	if err != nil {
+		t.Fatalf("newRPCAuditor: unexpected error: %v", err)
+	}
+
+	srvr := &server{chainParams: &chaincfg.RegressionNetParams}
+	s := &rpcServer{
+		server:      srvr,
+		limited:     rpcLimited,
+		auditor:     auditor,
+		statusLines: make(map[int]string),
+	}
+	admin := newAuditTestServer(t, s, true)
+	defer admin.httpServer.Close()
+	limited := newAuditTestServer(t, s, false)
+	defer limited.httpServer.Close()
+
+	// Submit a large block which fails to deserialize, an invalid hex
+	// string which is quoted in the error, and call an audited method as
+	// a limited user who is not authorized to call it.  Calls of methods
+	// which are not audited are not recorded.
+	rawBlock := strings.Repeat("ff", 1000)
+	badHex := strings.Repeat("zz", 1000)
+	start := time.Now().Add(-time.Second)
+	if rpcErr := admin.call(btcjson.NewSubmitBlockCmd(rawBlock, nil)); rpcErr == nil {
+		t.Fatal("submitblock: unexpectedly accepted an invalid block")
+	}
+	if rpcErr := admin.call(btcjson.NewGetCurrentNetCmd()); rpcErr != nil {
+		t.Fatalf("getcurrentnet: unexpected error: %v", rpcErr)
+	}
+	if rpcErr := limited.call(btcjson.NewSubmitBlockCmd(badHex, nil)); rpcErr == nil {
+		t.Fatal("submitblock: unexpectedly accepted an invalid hex string")
+	}
+	if rpcErr := limited.call(btcjson.NewStopCmd()); rpcErr == nil {
+		t.Fatal("stop: limited user unexpectedly authorized")
+	}
+	s.auditor.close()
+
+	wantEntries := []struct {
+		user    string
+		method  string
+		params  string
+		outcome string
+	}{
+		{
+			user:    "admin",
+			method:  "submitblock",
+			params:  `["` + rawBlock[:maxAuditParamLen-1] + "...(2002 bytes)]",
+			outcome: "error: -22: Block decode failed: ",
+		},
+		{
+			user:    "limited",
+			method:  "submitblock",
+			params:  `["` + badHex[:maxAuditParamLen-1] + "...(2002 bytes)]",
+			outcome: "error: -22: Argument must be hexadecimal string",
+		},
+		{
+			user:    "limited",
+			method:  "stop",
+			params:  "[]",
+			outcome: "error: -32602: limited user not authorized for this method",
+		},
+	}
+	checkEntries := func(source string, lines []string) {
+		if len(lines) != len(wantEntries) {
+			t.Fatalf("%s: unexpected number of audit entries - got %d, "+
+				"want %d:\n%s", source, len(lines), len(wantEntries),
+				strings.Join(lines, "\n"))
+		}
+		for i, want := range wantEntries {
+			// Skip the timestamp and level the logger prefixes
+			// the entries with.
+			line := lines[i]
+			if idx := strings.Index(line, "time="); idx != -1 {
+				line = line[idx:]
+			}
+			if strings.Contains(line, rawBlock) ||
+				strings.Contains(line, badHex) {
+
+				t.Fatalf("%s: audit entry #%d contains the full raw "+
+					"data: %s", source, i, line)
+			}
+
+			fields, err := parseAuditEntry(line)
+			if err != nil {
+				t.Fatalf("%s: unable to parse audit entry #%d %q: %v",
+					source, i, line, err)
+			}
+			if len(fields) != 6 {
+				t.Fatalf("%s: unexpected fields in audit entry "+
+					"#%d: %s", source, i, line)
+			}
+
+			ts, err := time.Parse(time.RFC3339, fields["time"])
+			if err != nil || ts.Before(start) ||
+				ts.After(time.Now().Add(time.Second)) {
+
+				t.Errorf("%s: audit entry #%d has unexpected "+
+					"time %q", source, i, fields["time"])
+			}
+			if !strings.HasPrefix(fields["remote"], "127.0.0.1:") {
+				t.Errorf("%s: audit entry #%d has unexpected "+
+					"remote address %q", source, i, fields["remote"])
+			}
+			if fields["user"] != want.user {
+				t.Errorf("%s: audit entry #%d has unexpected "+
+					"user - got %s, want %s", source, i,
+					fields["user"], want.user)
+			}
+			if fields["method"] != want.method {
+				t.Errorf("%s: audit entry #%d has unexpected "+
+					"method - got %s, want %s", source, i,
+					fields["method"], want.method)
+			}
+			if fields["params"] != want.params {
+				t.Errorf("%s: audit entry #%d has unexpected "+
+					"params - got %s, want %s", source, i,
+					fields["params"], want.params)
+			}
+			if !strings.HasPrefix(fields["outcome"], want.outcome) {
+				t.Errorf("%s: audit entry #%d has unexpected "+
+					"outcome - got %s, want prefix %s", source, i,
+					fields["outcome"], want.outcome)
+			}
+		}
+	}
+	checkEntries("audit log", logBuf.lines())
+
+	fileContents, err := ioutil.ReadFile(auditFile)
+	if err != nil {
+		t.Fatalf("unable to read audit file: %v", err)
+	}
+	fileLines := strings.Split(strings.TrimSpace(string(fileContents)), "\n")
+	checkEntries("audit file", fileLines)
+}
+
+// TestFormatAuditEntry ensures short parameters and outcomes are recorded as
+// they are while long ones are truncated.
+func TestFormatAuditEntry(t *testing.T) {
+	longParam := `"` + hex.EncodeToString(make([]byte, 100)) + `"`
+	params := []json.RawMessage{
+		json.RawMessage(`"1.2.3.4"`),
+		json.RawMessage(longParam),
+		json.RawMessage(`true`),
+	}
+	ts := time.Unix(1500000000, 0)
+	got := formatAuditEntry(ts, "admin", "127.0.0.1:1234", "node", params,
+		nil)
+	want := `time=2017-07-14T02:40:00Z user="admin" remote=127.0.0.1:1234 ` +
+		`method=node params="[\"1.2.3.4\",` +
+		strings.Replace(longParam[:maxAuditParamLen], `"`, `\"`, -1) +
+		`...(202 bytes),true]" outcome="ok"`
+	if got != want {
+		t.Fatalf("unexpected audit entry:\ngot:  %s\nwant: %s", got, want)
+	}
+
+	jsonErr := btcjson.NewRPCError(btcjson.ErrRPCMisc,
+		strings.Repeat("x", 1000))
+	got = formatAuditEntry(ts, "admin", "127.0.0.1:1234", "node", nil,
+		jsonErr)
+	if !strings.HasSuffix(got, `bytes)"`) ||
+		len(got) > 200+maxAuditOutcomeLen {
+
+		t.Fatalf("outcome was not truncated: %s", got)
+	}
+}
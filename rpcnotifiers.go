@@ -0,0 +1,382 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/btcjson"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+const (
+	// notifierQueueSize is the number of notifications which may be queued
+	// for a notifier before further notifications for it are dropped.
+	notifierQueueSize = 100
+
+	// httpNotifierMaxRetries is the number of times the HTTP notifier
+	// retries delivering a notification after a failed attempt.
+	httpNotifierMaxRetries = 3
+
+	// httpNotifierRetryDelay is the delay before the first retry of the
+	// HTTP notifier.  The delay doubles with every further retry.
+	httpNotifierRetryDelay = time.Second
+
+	// httpNotifierTimeout is the timeout of a single delivery attempt of
+	// the HTTP notifier.
+	httpNotifierTimeout = 10 * time.Second
+
+	// httpNotifierSignatureHeader is the HTTP header which holds the hex
+	// encoded HMAC-SHA256 of the payload posted by the HTTP notifier.
+	httpNotifierSignatureHeader = "X-Colxd-Signature"
+)
+
+// Notifier is the interface implemented by in-process plugins which are
+// notified about chain and mempool events.  They are registered with the RPC
+// server when it is created and each of them is called from its own goroutine,
+// so a slow notifier neither delays the others nor block processing.  The
+// methods return an error when the notification could not be handled, which is
+// accounted for in the getrpcinfo result.
+type Notifier interface {
+	// Name returns the name which identifies the notifier in getrpcinfo.
+	Name() string
+
+	// OnBlockConnected is called when a block is connected to the main
+	// chain.
+	OnBlockConnected(hash *wire.ShaHash, height int32) error
+
+	// OnTxAcceptedVerbose is called when a new transaction is accepted to
+	// the mempool.
+	OnTxAcceptedVerbose(tx *btcjson.TxRawResult) error
+
+	// OnReorg is called when the main chain is reorganized, before the
+	// blocks of the new main chain are connected.
+	OnReorg(reorg *blockchain.ReorgData) error
+}
+
+// notifierRunner delivers notifications to a single notifier from a bounded
+// queue and keeps statistics about the deliveries.
+type notifierRunner struct {
+	notifier Notifier
+	queue    chan func(Notifier) error
+
+	statsMtx     sync.Mutex
+	delivered    uint64
+	failed       uint64
+	dropped      uint64
+	totalLatency time.Duration
+	maxLatency   time.Duration
+	lastError    string
+}
+
+// enqueue queues the passed notification for the notifier without blocking.
+// The notification is dropped when the queue is full.
+func (r *notifierRunner) enqueue(ntfn func(Notifier) error) {
+	select {
+	case r.queue <- ntfn:
+	default:
+		r.statsMtx.Lock()
+		r.dropped++
+		r.statsMtx.Unlock()
+	}
+}
+
+// run delivers queued notifications until the passed quit channel is closed.
+//
+// This must be run as a goroutine.
+func (r *notifierRunner) run(quit <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case ntfn := <-r.queue:
+			start := time.Now()
+			err := ntfn(r.notifier)
+			latency := time.Since(start)
+
+			r.statsMtx.Lock()
+			if err != nil {
+				r.failed++
+				r.lastError = err.Error()
+			} else {
+				r.delivered++
+			}
+			r.totalLatency += latency
+			if latency > r.maxLatency {
+				r.maxLatency = latency
+			}
+			r.statsMtx.Unlock()
+
+			if err != nil {
+				rpcsLog.Warnf("Notifier %s failed: %v",
+					r.notifier.Name(), err)
+			}
+
+		case <-quit:
+			return
+		}
+	}
+}
+
+// info returns the getrpcinfo result for the notifier.
+func (r *notifierRunner) info() btcjson.NotifierInfoResult {
+	r.statsMtx.Lock()
+	defer r.statsMtx.Unlock()
+
+	var avgLatency time.Duration
+	if calls := r.delivered + r.failed; calls > 0 {
+		avgLatency = r.totalLatency / time.Duration(calls)
+	}
+	return btcjson.NotifierInfoResult{
+		Name:         r.notifier.Name(),
+		Queued:       len(r.queue),
+		Delivered:    r.delivered,
+		Failed:       r.failed,
+		Dropped:      r.dropped,
+		AvgLatencyMs: avgLatency.Seconds() * 1000,
+		MaxLatencyMs: r.maxLatency.Seconds() * 1000,
+		LastError:    r.lastError,
+	}
+}
+
+// notifierManager dispatches notifications to the registered notifiers.
+type notifierManager struct {
+	runners []*notifierRunner
+	wg      sync.WaitGroup
+	quit    chan struct{}
+}
+
+// newNotifierManager returns a notifier manager which dispatches notifications
+// to the passed notifiers.
+func newNotifierManager(notifiers []Notifier) *notifierManager {
+	runners := make([]*notifierRunner, 0, len(notifiers))
+	for _, notifier := range notifiers {
+		runners = append(runners, &notifierRunner{
+			notifier: notifier,
+			queue:    make(chan func(Notifier) error, notifierQueueSize),
+		})
+	}
+	return &notifierManager{
+		runners: runners,
+		quit:    make(chan struct{}),
+	}
+}
+
+// Start starts the goroutines which deliver notifications to the notifiers.
+func (m *notifierManager) Start() {
+	for _, r := range m.runners {
+		m.wg.Add(1)
+		go r.run(m.quit, &m.wg)
+	}
+}
+
+// Stop stops delivering notifications and waits for the notifications being
+// delivered to finish.  Queued notifications are discarded.
+func (m *notifierManager) Stop() {
+	close(m.quit)
+	m.wg.Wait()
+}
+
+// HasNotifiers returns whether any notifiers are registered.
+func (m *notifierManager) HasNotifiers() bool {
+	return len(m.runners) != 0
+}
+
+// dispatch queues the passed notification for every notifier.  It never
+// blocks.
+func (m *notifierManager) dispatch(ntfn func(Notifier) error) {
+	for _, r := range m.runners {
+		r.enqueue(ntfn)
+	}
+}
+
+// NotifyBlockConnected queues a block connected notification for every
+// notifier.
+func (m *notifierManager) NotifyBlockConnected(hash *wire.ShaHash, height int32) {
+	m.dispatch(func(n Notifier) error {
+		return n.OnBlockConnected(hash, height)
+	})
+}
+
+// NotifyTxAccepted queues a transaction accepted notification for every
+// notifier.
+func (m *notifierManager) NotifyTxAccepted(tx *btcjson.TxRawResult) {
+	m.dispatch(func(n Notifier) error {
+		return n.OnTxAcceptedVerbose(tx)
+	})
+}
+
+// NotifyReorg queues a reorganize notification for every notifier.
+func (m *notifierManager) NotifyReorg(reorg *blockchain.ReorgData) {
+	m.dispatch(func(n Notifier) error {
+		return n.OnReorg(reorg)
+	})
+}
+
+// Info returns the getrpcinfo results of the notifiers.
+func (m *notifierManager) Info() []btcjson.NotifierInfoResult {
+	infos := make([]btcjson.NotifierInfoResult, 0, len(m.runners))
+	for _, r := range m.runners {
+		infos = append(infos, r.info())
+	}
+	return infos
+}
+
+// httpNotification is the JSON payload posted by the HTTP notifier.  Only the
+// fields of the notification type are set.
+type httpNotification struct {
+	Type      string               `json:"type"`
+	Hash      string               `json:"hash,omitempty"`
+	Height    int32                `json:"height,omitempty"`
+	Tx        *btcjson.TxRawResult `json:"tx,omitempty"`
+	OldTip    string               `json:"oldtip,omitempty"`
+	OldHeight int32                `json:"oldheight,omitempty"`
+	NewTip    string               `json:"newtip,omitempty"`
+	NewHeight int32                `json:"newheight,omitempty"`
+	Detached  []string             `json:"detached,omitempty"`
+	Attached  []string             `json:"attached,omitempty"`
+}
+
+// httpNotifier is a notifier which posts notifications as JSON to an HTTP
+// endpoint.  The payload is signed with an HMAC-SHA256 of a shared key which
+// is sent in the X-Colxd-Signature header, so the endpoint is able to verify
+// the notifications are authentic.  Failed deliveries due to network errors or
+// server errors are retried with an exponential backoff.
+type httpNotifier struct {
+	url        string
+	key        []byte
+	client     *http.Client
+	maxRetries int
+	retryDelay time.Duration
+}
+
+// Ensure httpNotifier implements the Notifier interface.
+var _ Notifier = (*httpNotifier)(nil)
+
+// newHTTPNotifier returns a notifier which posts notifications to the passed
+// URL and signs them with the passed key.
+func newHTTPNotifier(url string, key []byte) *httpNotifier {
+	return &httpNotifier{
+		url:        url,
+		key:        key,
+		client:     &http.Client{Timeout: httpNotifierTimeout},
+		maxRetries: httpNotifierMaxRetries,
+		retryDelay: httpNotifierRetryDelay,
+	}
+}
+
+// Name returns the name of the notifier.
+//
+// This is part of the Notifier interface.
+func (n *httpNotifier) Name() string {
+	return "http " + n.url
+}
+
+// signPayload returns the hex encoded HMAC-SHA256 of the passed payload.
+func (n *httpNotifier) signPayload(payload []byte) string {
+	mac := hmac.New(sha256.New, n.key)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// post attempts to deliver the passed payload once.  It returns whether a
+// failed attempt should be retried.
+func (n *httpNotifier) post(payload []byte, signature string) (bool, error) {
+	req, err := http.NewRequest("POST", n.url, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(httpNotifierSignatureHeader, signature)
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500:
+		return true, fmt.Errorf("server error: %s", resp.Status)
+	case resp.StatusCode >= 300:
+		return false, fmt.Errorf("request rejected: %s", resp.Status)
+	}
+	return false, nil
+}
+
+// send signs and posts the passed notification, retrying failed attempts.
+func (n *httpNotifier) send(ntfn *httpNotification) error {
+	payload, err := json.Marshal(ntfn)
+	if err != nil {
+		return err
+	}
+	signature := n.signPayload(payload)
+
+	delay := n.retryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(payload, signature)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt == n.maxRetries {
+			return fmt.Errorf("unable to deliver %s notification "+
+				"after %d attempts: %v", ntfn.Type, attempt+1, err)
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// OnBlockConnected posts a blockconnected notification.
+//
+// This is part of the Notifier interface.
+func (n *httpNotifier) OnBlockConnected(hash *wire.ShaHash, height int32) error {
+	return n.send(&httpNotification{
+		Type:   "blockconnected",
+		Hash:   hash.String(),
+		Height: height,
+	})
+}
+
+// OnTxAcceptedVerbose posts a txaccepted notification.
+//
+// This is part of the Notifier interface.
+func (n *httpNotifier) OnTxAcceptedVerbose(tx *btcjson.TxRawResult) error {
+	return n.send(&httpNotification{
+		Type: "txaccepted",
+		Tx:   tx,
+	})
+}
+
+// OnReorg posts a reorg notification.
+//
+// This is part of the Notifier interface.
+func (n *httpNotifier) OnReorg(reorg *blockchain.ReorgData) error {
+	hashStrings := func(hashes []*wire.ShaHash) []string {
+		strs := make([]string, 0, len(hashes))
+		for _, hash := range hashes {
+			strs = append(strs, hash.String())
+		}
+		return strs
+	}
+	return n.send(&httpNotification{
+		Type:      "reorg",
+		OldTip:    reorg.OldTip.String(),
+		OldHeight: reorg.OldHeight,
+		NewTip:    reorg.NewTip.String(),
+		NewHeight: reorg.NewHeight,
+		Detached:  hashStrings(reorg.Detached),
+		Attached:  hashStrings(reorg.Attached),
+	})
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/btcjson"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// notifierTestServer is an HTTP endpoint for the HTTP notifier which records
+// the requests it receives and responds with the queued status codes.
+type notifierTestServer struct {
+	*httptest.Server
+
+	mtx        sync.Mutex
+	statuses   []int
+	payloads   [][]byte
+	signatures []string
+}
+
+// newNotifierTestServer returns a started notifier test server which responds
+// with the passed status codes in order and with 200 once they are used up.
+func newNotifierTestServer(statuses ...int) *notifierTestServer {
+	s := &notifierTestServer{statuses: statuses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := ioutil.ReadAll(r.Body)
+
+		s.mtx.Lock()
+		s.payloads = append(s.payloads, payload)
+		s.signatures = append(s.signatures,
+			r.Header.Get(httpNotifierSignatureHeader))
+		status := http.StatusOK
+		if len(s.statuses) > 0 {
+			status = s.statuses[0]
+			s.statuses = s.statuses[1:]
+		}
+		s.mtx.Unlock()
+
+		w.WriteHeader(status)
+	}))
+	return s
+}
+
+// requests returns the payloads and signatures of the received requests.
+func (s *notifierTestServer) requests() ([][]byte, []string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.payloads, s.signatures
+}
+
+// newTestHTTPNotifier returns an HTTP notifier for the passed URL which retries
+// without a noticeable delay.
+func newTestHTTPNotifier(url string) *httpNotifier {
+	n := newHTTPNotifier(url, []byte("notifier test key"))
+	n.retryDelay = time.Millisecond
+	return n
+}
+
+// TestHTTPNotifierDelivery ensures the HTTP notifier posts the expected JSON
+// payloads along with a valid HMAC-SHA256 signature.
+func TestHTTPNotifierDelivery(t *testing.T) {
+	server := newNotifierTestServer()
+	defer server.Close()
+	n := newTestHTTPNotifier(server.URL)
+
+	hash := wire.ShaHash{0x01}
+	oldTip := wire.ShaHash{0x02}
+	if err := n.OnBlockConnected(&hash, 100); err != nil {
+		t.Fatalf("OnBlockConnected: unexpected error: %v", err)
+	}
+	tx := &btcjson.TxRawResult{Txid: "1234", Version: 1}
+	if err := n.OnTxAcceptedVerbose(tx); err != nil {
+		t.Fatalf("OnTxAcceptedVerbose: unexpected error: %v", err)
+	}
+	reorg := &blockchain.ReorgData{
+		OldTip:    &oldTip,
+		OldHeight: 100,
+		NewTip:    &hash,
+		NewHeight: 101,
+		Detached:  []*wire.ShaHash{&oldTip},
+		Attached:  []*wire.ShaHash{&hash},
+	}
+	if err := n.OnReorg(reorg); err != nil {
+		t.Fatalf("OnReorg: unexpected error: %v", err)
+	}
+
+	payloads, signatures := server.requests()
+	if len(payloads) != 3 {
+		t.Fatalf("unexpected number of requests - got %d, want 3",
+			len(payloads))
+	}
+
+	want := []httpNotification{
+		{Type: "blockconnected", Hash: hash.String(), Height: 100},
+		{Type: "txaccepted", Tx: tx},
+		{
+			Type:      "reorg",
+			OldTip:    oldTip.String(),
+			OldHeight: 100,
+			NewTip:    hash.String(),
+			NewHeight: 101,
+			Detached:  []string{oldTip.String()},
+			Attached:  []string{hash.String()},
+		},
+	}
+	for i, payload := range payloads {
+		// The signature must be the HMAC-SHA256 of the payload.
+		mac := hmac.New(sha256.New, []byte("notifier test key"))
+		mac.Write(payload)
+		wantSig := hex.EncodeToString(mac.Sum(nil))
+		if signatures[i] != wantSig {
+			t.Errorf("request #%d: unexpected signature - got %s, "+
+				"want %s", i, signatures[i], wantSig)
+		}
+
+		wantPayload, err := json.Marshal(&want[i])
+		if err != nil {
+			t.Fatalf("unable to marshal expected payload: %v", err)
+		}
+		if string(payload) != string(wantPayload) {
+			t.Errorf("request #%d: unexpected payload - got %s, "+
+				"want %s", i, payload, wantPayload)
+		}
+	}
+}
+
+// TestHTTPNotifierRetry ensures the HTTP notifier retries deliveries which fail
+// with server errors and gives up on rejected ones.
+func TestHTTPNotifierRetry(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		requests int
+		wantErr  bool
+	}{
+		{
+			name:     "retried until delivered",
+			statuses: []int{500, 503},
+			requests: 3,
+		},
+		{
+			name:     "retries exhausted",
+			statuses: []int{500, 500, 500, 500},
+			requests: httpNotifierMaxRetries + 1,
+			wantErr:  true,
+		},
+		{
+			name:     "rejected without retry",
+			statuses: []int{400},
+			requests: 1,
+			wantErr:  true,
+		},
+	}
+
+	hash := wire.ShaHash{0x01}
+	for _, test := range tests {
+		server := newNotifierTestServer(test.statuses...)
+		n := newTestHTTPNotifier(server.URL)
+		err := n.OnBlockConnected(&hash, 1)
+		payloads, _ := server.requests()
+		server.Close()
+
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: unexpected error - got %v, want error %v",
+				test.name, err, test.wantErr)
+		}
+		if len(payloads) != test.requests {
+			t.Errorf("%s: unexpected number of requests - got %d, "+
+				"want %d", test.name, len(payloads), test.requests)
+		}
+	}
+}
+
+// recordingNotifier is a notifier which sends the heights of the connected
+// blocks it is notified about to a channel.
+type recordingNotifier struct {
+	heights chan int32
+}
+
+func (n *recordingNotifier) Name() string { return "recording" }
+
+func (n *recordingNotifier) OnBlockConnected(hash *wire.ShaHash, height int32) error {
+	n.heights <- height
+	return nil
+}
+
+func (n *recordingNotifier) OnTxAcceptedVerbose(tx *btcjson.TxRawResult) error {
+	return nil
+}
+
+func (n *recordingNotifier) OnReorg(reorg *blockchain.ReorgData) error {
+	return nil
+}
+
+// TestNotifierManagerSlowNotifier ensures a notifier with a slow endpoint never
+// blocks dispatching notifications or delivering them to other notifiers, and
+// that the notifications which overflow its queue are accounted for.
+func TestNotifierManagerSlowNotifier(t *testing.T) {
+	release := make(chan struct{})
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slowServer.Close()
+
+	// The server waits for the slow requests to finish when it is closed,
+	// so they must be released even when the test fails.
+	var releaseOnce sync.Once
+	releaseSlow := func() { releaseOnce.Do(func() { close(release) }) }
+	defer releaseSlow()
+
+	recorder := &recordingNotifier{heights: make(chan int32, notifierQueueSize*2)}
+	slow := newTestHTTPNotifier(slowServer.URL)
+	mgr := newNotifierManager([]Notifier{slow, recorder})
+	mgr.Start()
+
+	// Dispatch more notifications than fit in the queue of the slow
+	// notifier.  Dispatching must not wait for the slow endpoint, while
+	// the other notifier receives every notification in order.  Waiting
+	// for each of them ensures its own queue never overflows.
+	numNtfns := notifierQueueSize + 10
+	hash := wire.ShaHash{0x01}
+	for i := 0; i < numNtfns; i++ {
+		start := time.Now()
+		mgr.NotifyBlockConnected(&hash, int32(i))
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("dispatching notification %d took %v", i,
+				elapsed)
+		}
+
+		select {
+		case height := <-recorder.heights:
+			if height != int32(i) {
+				t.Fatalf("unexpected height - got %d, want %d",
+					height, i)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for notification %d", i)
+		}
+	}
+
+	// The statistics are updated once the notifier returns, so wait for
+	// the last delivery to be accounted for.
+	var infos []btcjson.NotifierInfoResult
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		infos = mgr.Info()
+		if infos[1].Delivered == uint64(numNtfns) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected recording notifier info %+v",
+				infos[1])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if infos[1].Dropped != 0 || infos[1].Failed != 0 {
+		t.Fatalf("unexpected recording notifier info %+v", infos[1])
+	}
+
+	// The slow notifier is handling one notification and has a full
+	// queue, so the rest were dropped.
+	if infos[0].Dropped == 0 || infos[0].Delivered != 0 {
+		t.Fatalf("unexpected slow notifier info %+v", infos[0])
+	}
+
+	releaseSlow()
+	mgr.Stop()
+}
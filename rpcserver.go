@@ -7,6 +7,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"crypto/subtle"
 	"crypto/tls"
 	"encoding/base64"
@@ -17,13 +18,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
 	"math/big"
 	"math/rand"
 	"net"
 	"net/http"
 	"os"
+	"sort"
 	"strconv"
-	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -36,8 +38,10 @@ import (
 	"github.com/tinhnguyenhn/colxd/chaincfg"
 	"github.com/tinhnguyenhn/colxd/database"
 	"github.com/tinhnguyenhn/colxd/mining"
+	"github.com/tinhnguyenhn/colxd/peer"
 	"github.com/tinhnguyenhn/colxd/txscript"
 	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxd/wire/bloom"
 	"github.com/tinhnguyenhn/colxutil"
 )
 
@@ -73,25 +77,31 @@ const (
 	// RPC.
 	gbtNonceRange = "00000000ffffffff"
 
-	// gbtRegenerateSeconds is the number of seconds that must pass before
-	// a new template is generated when the previous block hash has not
-	// changed and there have been changes to the available transactions
-	// in the memory pool.
-	gbtRegenerateSeconds = 60
-
 	// maxProtocolVersion is the max protocol version the server supports.
 	maxProtocolVersion = 70002
 )
 
 var (
 	// gbtMutableFields are the manipulations the server allows to be made
-	// to block templates generated by the getblocktemplate RPC.  It is
-	// declared here to avoid the overhead of creating the slice on every
-	// invocation for constant data.
+	// to block templates generated by the getblocktemplate RPC which
+	// include a coinbase transaction.  They match what the server does when
+	// it updates a template: the time is updated, transactions are added,
+	// a template is generated for a new previous block, and the extra nonce
+	// is appended to the coinbase.  It is declared here to avoid the
+	// overhead of creating the slice on every invocation for constant data.
 	gbtMutableFields = []string{
 		"time", "transactions/add", "prevblock", "coinbase/append",
 	}
 
+	// gbtCoinbaseValueMutableFields are the manipulations the server
+	// allows to be made to block templates which only include the value of
+	// the coinbase.  Since the caller creates the entire coinbase of such
+	// templates, it may also replace the coinbase and its outputs.
+	gbtCoinbaseValueMutableFields = []string{
+		"time", "transactions/add", "prevblock", "coinbase/append",
+		"coinbase", "generation",
+	}
+
 	// gbtCoinbaseAux describes additional data that miners should include
 	// in the coinbase signature script.  It is declared here to avoid the
 	// overhead of creating a new object on every invocation for constant
@@ -102,10 +112,31 @@ var (
 	}
 
 	// gbtCapabilities describes additional capabilities returned with a
-	// block template generated by the getblocktemplate RPC.    It is
+	// block template generated by the getblocktemplate RPC.  The
+	// coinbasetxn capability is only added when the server has payment
+	// addresses to pay the coinbase of templates to.  It is declared here
+	// to avoid the overhead of creating the slice on every invocation for
+	// constant data.
+	gbtCapabilities = []string{
+		"coinbasevalue", "longpoll", "proposal", "workid",
+	}
+
+	// gbtCoinbaseTxnCapabilities describes the capabilities returned with a
+	// block template when the server has payment addresses.  It is
 	// declared here to avoid the overhead of creating the slice on every
 	// invocation for constant data.
-	gbtCapabilities = []string{"proposal"}
+	gbtCoinbaseTxnCapabilities = []string{
+		"coinbasetxn", "coinbasevalue", "longpoll", "proposal", "workid",
+	}
+
+	// gbtUnsupportedCapabilities are the capabilities defined by BIP0022 and
+	// BIP0023 which the server does not support.  Requesting any of them
+	// results in an error, while capabilities the server does not know
+	// about, such as the mutations a client supports, are ignored as the
+	// BIPs require.
+	gbtUnsupportedCapabilities = map[string]struct{}{
+		"serverlist": {},
+	}
 )
 
 // Errors
@@ -137,8 +168,12 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"debuglevel":            handleDebugLevel,
 	"decoderawtransaction":  handleDecodeRawTransaction,
 	"decodescript":          handleDecodeScript,
+	"estimatefee":           handleEstimateFee,
 	"generate":              handleGenerate,
 	"getaddednodeinfo":      handleGetAddedNodeInfo,
+	"getaddressbalance":     handleGetAddressBalance,
+	"getaddressdeltas":      handleGetAddressDeltas,
+	"getaddressutxos":       handleGetAddressUtxos,
 	"getbestblock":          handleGetBestBlock,
 	"getbestblockhash":      handleGetBestBlockHash,
 	"getblock":              handleGetBlock,
@@ -152,6 +187,7 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"getgenerate":           handleGetGenerate,
 	"gethashespersec":       handleGetHashesPerSec,
 	"getinfo":               handleGetInfo,
+	"getmempoolentry":       handleGetMempoolEntry,
 	"getmempoolinfo":        handleGetMempoolInfo,
 	"getmininginfo":         handleGetMiningInfo,
 	"getnettotals":          handleGetNetTotals,
@@ -159,11 +195,20 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"getpeerinfo":           handleGetPeerInfo,
 	"getrawmempool":         handleGetRawMempool,
 	"getrawtransaction":     handleGetRawTransaction,
+	"getrpcinfo":            handleGetRPCInfo,
 	"gettxout":              handleGetTxOut,
+	"gettxoutproof":         handleGetTxOutProof,
+	"gettxoutsetinfo":       handleGetTxOutSetInfo,
 	"getwork":               handleGetWork,
 	"help":                  handleHelp,
+	"importmempool":         handleImportMempool,
 	"node":                  handleNode,
 	"ping":                  handlePing,
+	"preciousblock":         handlePreciousBlock,
+	"prioritisetransaction": handlePrioritiseTransaction,
+	"reconsiderblock":       handleReconsiderBlock,
+	"savemempool":           handleSaveMempool,
+	"scantxoutset":          handleScanTxOutSet,
 	"searchrawtransactions": handleSearchRawTransactions,
 	"sendrawtransaction":    handleSendRawTransaction,
 	"setgenerate":           handleSetGenerate,
@@ -172,6 +217,7 @@ var rpcHandlersBeforeInit = map[string]commandHandler{
 	"validateaddress":       handleValidateAddress,
 	"verifychain":           handleVerifyChain,
 	"verifymessage":         handleVerifyMessage,
+	"version":               handleVersion,
 }
 
 // list of commands that we recognize, but for which btcd has no support because
@@ -194,7 +240,6 @@ var rpcAskWallet = map[string]struct{}{
 	"getreceivedbyaccount":   {},
 	"getreceivedbyaddress":   {},
 	"gettransaction":         {},
-	"gettxoutsetinfo":        {},
 	"getunconfirmedbalance":  {},
 	"getwalletinfo":          {},
 	"importprivkey":          {},
@@ -224,14 +269,14 @@ var rpcAskWallet = map[string]struct{}{
 
 // Commands that are currently unimplemented, but should ultimately be.
 var rpcUnimplemented = map[string]struct{}{
-	"estimatefee":       {},
 	"estimatepriority":  {},
 	"getblockchaininfo": {},
 	"getchaintips":      {},
 	"getnetworkinfo":    {},
 }
 
-// Commands that are available to a limited user
+// Commands that are available to a limited user by default.  The set can be
+// replaced with the --rpclimitmethod option.
 var rpcLimited = map[string]struct{}{
 	// Websockets commands
 	"notifyblocks":          {},
@@ -248,6 +293,10 @@ var rpcLimited = map[string]struct{}{
 	"createrawtransaction":  {},
 	"decoderawtransaction":  {},
 	"decodescript":          {},
+	"estimatefee":           {},
+	"getaddressbalance":     {},
+	"getaddressdeltas":      {},
+	"getaddressutxos":       {},
 	"getbestblock":          {},
 	"getbestblockhash":      {},
 	"getblock":              {},
@@ -256,16 +305,19 @@ var rpcLimited = map[string]struct{}{
 	"getcurrentnet":         {},
 	"getdifficulty":         {},
 	"getinfo":               {},
+	"getmempoolentry":       {},
 	"getnettotals":          {},
 	"getnetworkhashps":      {},
 	"getrawmempool":         {},
 	"getrawtransaction":     {},
 	"gettxout":              {},
+	"gettxoutproof":         {},
 	"searchrawtransactions": {},
 	"sendrawtransaction":    {},
 	"submitblock":           {},
 	"validateaddress":       {},
 	"verifymessage":         {},
+	"version":               {},
 }
 
 // builderScript is a convenience function which is used for hard-coded scripts
@@ -311,6 +363,47 @@ func rpcNoTxInfoError(txHash *wire.ShaHash) *btcjson.RPCError {
 			txHash))
 }
 
+// rpcTxRejectedError is a convenience function for returning an RPC error which
+// indicates the memory pool rejected a transaction with the passed error.  The
+// details of the rejection are carried as data.  Transactions which spend
+// unknown outputs and failures which are not rule violations are returned with
+// the verify error code, while all other rejections use the verify rejected
+// error code.
+func rpcTxRejectedError(err error) *btcjson.RPCError {
+	rejectCode, _ := extractRejectCode(err)
+	data := &btcjson.TxRejectErrorData{RejectCode: rejectCode.String()}
+	code := btcjson.ErrRPCVerify
+	if rerr, ok := err.(RuleError); ok {
+		code = btcjson.ErrRPCVerifyRejected
+		switch rerr := rerr.Err.(type) {
+		case blockchain.RuleError:
+			data.ErrorCode = rerr.ErrorCode.String()
+
+		case TxRuleError:
+			for _, outPoint := range rerr.MissingInputs {
+				data.MissingInputs = append(data.MissingInputs,
+					outPoint.String())
+			}
+			if len(data.MissingInputs) > 0 {
+				code = btcjson.ErrRPCVerify
+			}
+		}
+	}
+	return btcjson.NewRPCErrorWithData(code, "TX rejected: "+err.Error(),
+		data)
+}
+
+// rpcBlockRejectedError is a convenience function for returning an RPC error
+// which indicates a submitted block failed to be processed with the passed
+// error.  The BIP0022 rejection reason is carried as data.
+func rpcBlockRejectedError(err error) *btcjson.RPCError {
+	data := &btcjson.BlockRejectErrorData{
+		Reason: chainErrToGBTErrString(err),
+	}
+	return btcjson.NewRPCErrorWithData(btcjson.ErrRPCVerify, err.Error(),
+		data)
+}
+
 // workStateBlockInfo houses information about how to reconstruct a block given
 // its template and signature script.
 type workStateBlockInfo struct {
@@ -338,24 +431,40 @@ func newWorkState() *workState {
 	}
 }
 
+// gbtRequestOptions houses the options of a getblocktemplate request which
+// determine the variant of the block template returned to the caller.
+type gbtRequestOptions struct {
+	// useCoinbaseValue is whether the template only includes the value of
+	// the coinbase rather than the entire coinbase transaction.
+	useCoinbaseValue bool
+
+	// workID is whether the caller supports the workid capability, in
+	// which case the template includes a work ID.
+	workID bool
+}
+
 // gbtWorkState houses state that is used in between multiple RPC invocations to
 // getblocktemplate.
 type gbtWorkState struct {
 	sync.Mutex
-	lastTxUpdate  time.Time
-	lastGenerated time.Time
-	prevHash      *wire.ShaHash
-	minTimestamp  time.Time
-	template      *BlockTemplate
-	notifyMap     map[wire.ShaHash]map[int64]chan struct{}
-	timeSource    blockchain.MedianTimeSource
+	prevHash     *wire.ShaHash
+	minTimestamp time.Time
+	template     *BlockTemplate
+	timeSource   blockchain.MedianTimeSource
+
+	// results caches the results returned for the current template keyed
+	// by the request options they were returned for, so the transactions
+	// of the template are not encoded again for every request.  The cache
+	// is reset once the template or its timestamp changes.
+	results         map[gbtRequestOptions]*btcjson.GetBlockTemplateResult
+	resultsTemplate *BlockTemplate
+	resultsTime     time.Time
 }
 
 // newGbtWorkState returns a new instance of a gbtWorkState with all internal
 // fields initialized and ready to use.
 func newGbtWorkState(timeSource blockchain.MedianTimeSource) *gbtWorkState {
 	return &gbtWorkState{
-		notifyMap:  make(map[wire.ShaHash]map[int64]chan struct{}),
 		timeSource: timeSource,
 	}
 }
@@ -837,6 +946,38 @@ func handleDecodeScript(s *rpcServer, cmd interface{}, closeChan <-chan struct{}
 	return reply, nil
 }
 
+// handleEstimateFee handles estimatefee commands.
+func handleEstimateFee(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.EstimateFeeCmd)
+
+	if c.NumBlocks < 1 {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Number of blocks must be positive",
+		}
+	}
+
+	// Fees can't be estimated for targets beyond the number of blocks the
+	// estimator tracks transactions for, so use the highest target it
+	// supports for them.
+	numBlocks := int(c.NumBlocks)
+	if c.NumBlocks > feeEstMaxConfirms {
+		numBlocks = feeEstMaxConfirms
+	}
+
+	// Return -1 when there is not enough data to estimate the fee like the
+	// reference implementation.
+	feeRate, err := s.server.feeEstimator.EstimateFee(numBlocks)
+	if err == errInsufficientFeeData {
+		return -1.0, nil
+	}
+	if err != nil {
+		context := "Failed to estimate fee"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	return feeRate.ToBTC(), nil
+}
+
 // handleGenerate handles generate commands.
 func handleGenerate(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// Respond with an error if there are no addresses to pay the
@@ -897,7 +1038,7 @@ func handleGetAddedNodeInfo(s *rpcServer, cmd interface{}, closeChan <-chan stru
 		}
 		if !found {
 			return nil, &btcjson.RPCError{
-				Code:    -24, // TODO: ErrRPCClientNodeNotAdded
+				Code:    btcjson.ErrRPCClientNodeNotAdded,
 				Message: "Node has not been added",
 			}
 		}
@@ -962,6 +1103,497 @@ func handleGetAddedNodeInfo(s *rpcServer, cmd interface{}, closeChan <-chan stru
 	return results, nil
 }
 
+// addressTxo describes an output which pays to an address as found by
+// fetchAddressHistory.
+type addressTxo struct {
+	outPoint   wire.OutPoint
+	pkScript   []byte
+	amount     int64
+	height     int32
+	txOffset   uint32
+	isCoinBase bool
+	spent      bool
+}
+
+// addressDebit describes an input which spends an output paying to an address
+// as found by fetchAddressHistory.
+type addressDebit struct {
+	txHash   wire.ShaHash
+	inputIdx uint32
+	amount   int64
+	height   int32
+	txOffset uint32
+}
+
+// addressTx houses a transaction listed by the address index along with the
+// height of the block it is part of and its offset within the block.
+type addressTx struct {
+	tx       *wire.MsgTx
+	height   int32
+	txOffset uint32
+}
+
+// addressTxns is a slice of address index transactions which implements
+// sort.Interface to sort them in the order they appear in the main chain.
+type addressTxns []addressTx
+
+// Len returns the number of transactions in the slice.  It is part of the
+// sort.Interface implementation.
+func (s addressTxns) Len() int { return len(s) }
+
+// Swap swaps the transactions at the passed indices.  It is part of the
+// sort.Interface implementation.
+func (s addressTxns) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// Less returns whether the transaction with index i comes before the one with
+// index j in the main chain.  It is part of the sort.Interface implementation.
+func (s addressTxns) Less(i, j int) bool {
+	if s[i].height != s[j].height {
+		return s[i].height < s[j].height
+	}
+	return s[i].txOffset < s[j].txOffset
+}
+
+// addressDelta houses a credit or debit returned by the getaddressdeltas
+// command along with what is needed to order it.
+type addressDelta struct {
+	btcjson.AddressDeltaResult
+	txOffset uint32
+	isCredit bool
+}
+
+// addressDeltas is a slice of address deltas which implements sort.Interface
+// to sort them in the order they appear in the main chain.  The inputs of a
+// transaction come before its outputs, and the deltas of the same input or
+// output are ordered by address.
+type addressDeltas []addressDelta
+
+// Len returns the number of deltas in the slice.  It is part of the
+// sort.Interface implementation.
+func (s addressDeltas) Len() int { return len(s) }
+
+// Swap swaps the deltas at the passed indices.  It is part of the
+// sort.Interface implementation.
+func (s addressDeltas) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// Less returns whether the delta with index i should sort before the delta
+// with index j.  It is part of the sort.Interface implementation.
+func (s addressDeltas) Less(i, j int) bool {
+	a, b := &s[i], &s[j]
+	switch {
+	case a.Height != b.Height:
+		return a.Height < b.Height
+	case a.txOffset != b.txOffset:
+		return a.txOffset < b.txOffset
+	case a.isCredit != b.isCredit:
+		return !a.isCredit
+	case a.Index != b.Index:
+		return a.Index < b.Index
+	}
+	return a.Address < b.Address
+}
+
+// addressUtxo houses an unspent output returned by the getaddressutxos command
+// along with the address it pays to.
+type addressUtxo struct {
+	address string
+	txo     *addressTxo
+}
+
+// addressUtxos is a slice of unspent outputs which implements sort.Interface
+// to sort them in the order they appear in the main chain, with the entries of
+// the same output ordered by address.
+type addressUtxos []addressUtxo
+
+// Len returns the number of outputs in the slice.  It is part of the
+// sort.Interface implementation.
+func (s addressUtxos) Len() int { return len(s) }
+
+// Swap swaps the outputs at the passed indices.  It is part of the
+// sort.Interface implementation.
+func (s addressUtxos) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+// Less returns whether the output with index i should sort before the output
+// with index j.  It is part of the sort.Interface implementation.
+func (s addressUtxos) Less(i, j int) bool {
+	a, b := s[i].txo, s[j].txo
+	switch {
+	case a.height != b.height:
+		return a.height < b.height
+	case a.txOffset != b.txOffset:
+		return a.txOffset < b.txOffset
+	case a.outPoint.Index != b.outPoint.Index:
+		return a.outPoint.Index < b.outPoint.Index
+	}
+	return s[i].address < s[j].address
+}
+
+// addressHistory houses the outputs which pay to an address along with the
+// inputs which spend them in the main chain of a chain query.
+type addressHistory struct {
+	address string
+	credits []*addressTxo
+	debits  []addressDebit
+}
+
+// indexedAddress returns the address which identifies the same entries in
+// the address index as the passed address.  Pay-to-pubkey addresses are
+// indexed by the hash of their public key.
+func indexedAddress(addr colxutil.Address) colxutil.Address {
+	if pubKeyAddr, ok := addr.(*colxutil.AddressPubKey); ok {
+		return pubKeyAddr.AddressPubKeyHash()
+	}
+	return addr
+}
+
+// decodeIndexedAddresses returns the passed encoded addresses decoded, with
+// the addresses which identify the same address index entries removed, and
+// sorted by their encoding so the results built from them are deterministic.
+func decodeIndexedAddresses(s *rpcServer, encoded []string) ([]colxutil.Address, error) {
+	if len(encoded) == 0 {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "At least one address must be specified",
+		}
+	}
+
+	byEncoding := make(map[string]colxutil.Address, len(encoded))
+	keys := make([]string, 0, len(encoded))
+	for _, str := range encoded {
+		addr, err := colxutil.DecodeAddress(str, s.server.chainParams)
+		if err != nil {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidAddressOrKey,
+				Message: "Invalid address or key: " + err.Error(),
+			}
+		}
+		addr = indexedAddress(addr)
+		key := addr.EncodeAddress()
+		if _, ok := byEncoding[key]; ok {
+			continue
+		}
+		byEncoding[key] = addr
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	addrs := make([]colxutil.Address, 0, len(keys))
+	for _, key := range keys {
+		addrs = append(addrs, byEncoding[key])
+	}
+	return addrs, nil
+}
+
+// paysToAddress returns whether or not the passed public key script pays to
+// the passed normalized address.
+func paysToAddress(pkScript []byte, addr string, params *chaincfg.Params) bool {
+	_, addrs, _, err := txscript.ExtractPkScriptAddrs(pkScript, params)
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if indexedAddress(a).EncodeAddress() == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchAddressHistory returns the outputs which pay to the passed normalized
+// address along with the inputs which spend them as of the main chain of the
+// passed chain query.  Every input which spends an output paying to the address
+// is part of a transaction the address index lists for the address, so the
+// history is built from the indexed transactions alone.
+func fetchAddressHistory(s *rpcServer, q *blockchain.ChainQuery, addr colxutil.Address) (*addressHistory, error) {
+	regions, _, err := s.server.addrIndex.TxRegionsForAddress(q.DBTx(),
+		addr, 0, math.MaxUint32, false)
+	if err != nil {
+		return nil, err
+	}
+	serializedTxns, err := q.DBTx().FetchBlockRegions(regions)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the transactions and sort them in the order they appear in the
+	// main chain so outputs are always seen before the inputs which spend
+	// them.
+	txns := make(addressTxns, 0, len(regions))
+	for i, serializedTx := range serializedTxns {
+		height, err := q.BlockHeightByHash(regions[i].Hash)
+		if err != nil {
+			return nil, err
+		}
+		var msgTx wire.MsgTx
+		err = msgTx.Deserialize(bytes.NewReader(serializedTx))
+		if err != nil {
+			return nil, err
+		}
+		txns = append(txns, addressTx{&msgTx, height, regions[i].Offset})
+	}
+	sort.Sort(txns)
+
+	encoded := addr.EncodeAddress()
+	history := &addressHistory{address: encoded}
+	credits := make(map[wire.OutPoint]*addressTxo)
+	for _, itx := range txns {
+		txHash := itx.tx.TxSha()
+		isCoinBase := blockchain.IsCoinBaseTx(itx.tx)
+		if !isCoinBase {
+			for i, txIn := range itx.tx.TxIn {
+				txo, ok := credits[txIn.PreviousOutPoint]
+				if !ok || txo.spent {
+					continue
+				}
+				txo.spent = true
+				history.debits = append(history.debits, addressDebit{
+					txHash:   txHash,
+					inputIdx: uint32(i),
+					amount:   txo.amount,
+					height:   itx.height,
+					txOffset: itx.txOffset,
+				})
+			}
+		}
+		for i, txOut := range itx.tx.TxOut {
+			if !paysToAddress(txOut.PkScript, encoded,
+				s.server.chainParams) {
+
+				continue
+			}
+			txo := &addressTxo{
+				outPoint:   *wire.NewOutPoint(&txHash, uint32(i)),
+				pkScript:   txOut.PkScript,
+				amount:     txOut.Value,
+				height:     itx.height,
+				txOffset:   itx.txOffset,
+				isCoinBase: isCoinBase,
+			}
+			credits[txo.outPoint] = txo
+			history.credits = append(history.credits, txo)
+		}
+	}
+
+	return history, nil
+}
+
+// fetchAddressHistories returns the histories of the passed normalized
+// addresses as of a single snapshot of the main chain along with the hash and
+// height of the tip of that chain.  An error is returned when the address
+// index is not enabled.
+func fetchAddressHistories(s *rpcServer, addrs []colxutil.Address) ([]*addressHistory, *wire.ShaHash, int32, error) {
+	var histories []*addressHistory
+	var bestHash *wire.ShaHash
+	var bestHeight int32
+	err := s.chain.Query(func(q *blockchain.ChainQuery) error {
+		bestHash = q.BestHash()
+		bestHeight = q.BestHeight()
+		for _, addr := range addrs {
+			history, err := fetchAddressHistory(s, q, addr)
+			if err != nil {
+				return err
+			}
+			histories = append(histories, history)
+		}
+		return nil
+	})
+	if err != nil {
+		context := "Failed to load address index entries"
+		return nil, nil, 0, internalRPCError(err.Error(), context)
+	}
+	return histories, bestHash, bestHeight, nil
+}
+
+// errAddrIndexDisabled is the error returned by the commands which require the
+// address index when it is not enabled.
+var errAddrIndexDisabled = &btcjson.RPCError{
+	Code:    btcjson.ErrRPCMisc,
+	Message: "Address index must be enabled (--addrindex)",
+}
+
+// handleGetAddressBalance implements the getaddressbalance command.
+func handleGetAddressBalance(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	if s.server.addrIndex == nil {
+		return nil, errAddrIndexDisabled
+	}
+
+	c := cmd.(*btcjson.GetAddressBalanceCmd)
+	addrs, err := decodeIndexedAddresses(s, c.Addresses)
+	if err != nil {
+		return nil, err
+	}
+	histories, bestHash, bestHeight, err := fetchAddressHistories(s, addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &btcjson.GetAddressBalanceResult{
+		Hash:   bestHash.String(),
+		Height: bestHeight,
+	}
+	for _, history := range histories {
+		for _, txo := range history.credits {
+			result.Received += txo.amount
+			if !txo.spent {
+				result.Balance += txo.amount
+			}
+		}
+	}
+	return result, nil
+}
+
+// handleGetAddressDeltas implements the getaddressdeltas command.
+func handleGetAddressDeltas(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	if s.server.addrIndex == nil {
+		return nil, errAddrIndexDisabled
+	}
+
+	c := cmd.(*btcjson.GetAddressDeltasCmd)
+	start, end := int32(0), int32(math.MaxInt32)
+	if c.Start != nil {
+		start = *c.Start
+	}
+	if c.End != nil {
+		end = *c.End
+	}
+	if start < 0 || end < start {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidParameter,
+			Message: fmt.Sprintf("Invalid height range %d to %d",
+				start, end),
+		}
+	}
+	addrs, err := decodeIndexedAddresses(s, c.Addresses)
+	if err != nil {
+		return nil, err
+	}
+	histories, bestHash, bestHeight, err := fetchAddressHistories(s, addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the credits and debits in the height range and sort them in
+	// the order they appear in the main chain.
+	var deltas addressDeltas
+	inRange := func(height int32) bool {
+		return height >= start && height <= end
+	}
+	for _, history := range histories {
+		for _, txo := range history.credits {
+			if !inRange(txo.height) {
+				continue
+			}
+			deltas = append(deltas, addressDelta{
+				AddressDeltaResult: btcjson.AddressDeltaResult{
+					Address:  history.address,
+					TxID:     txo.outPoint.Hash.String(),
+					Index:    txo.outPoint.Index,
+					Satoshis: txo.amount,
+					Height:   txo.height,
+				},
+				txOffset: txo.txOffset,
+				isCredit: true,
+			})
+		}
+		for _, debit := range history.debits {
+			if !inRange(debit.height) {
+				continue
+			}
+			deltas = append(deltas, addressDelta{
+				AddressDeltaResult: btcjson.AddressDeltaResult{
+					Address:  history.address,
+					TxID:     debit.txHash.String(),
+					Index:    debit.inputIdx,
+					Satoshis: -debit.amount,
+					Height:   debit.height,
+				},
+				txOffset: debit.txOffset,
+			})
+		}
+	}
+	sort.Sort(deltas)
+
+	result := &btcjson.GetAddressDeltasResult{
+		Deltas: make([]btcjson.AddressDeltaResult, 0, len(deltas)),
+		Hash:   bestHash.String(),
+		Height: bestHeight,
+	}
+	for _, d := range deltas {
+		result.Deltas = append(result.Deltas, d.AddressDeltaResult)
+	}
+	return result, nil
+}
+
+// handleGetAddressUtxos implements the getaddressutxos command.
+func handleGetAddressUtxos(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	if s.server.addrIndex == nil {
+		return nil, errAddrIndexDisabled
+	}
+
+	c := cmd.(*btcjson.GetAddressUtxosCmd)
+	var numToSkip int
+	if c.Skip != nil && *c.Skip > 0 {
+		numToSkip = *c.Skip
+	}
+	numRequested := 100
+	if c.Count != nil {
+		numRequested = *c.Count
+		if numRequested < 0 {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: "Count must not be negative",
+			}
+		}
+	}
+	addrs, err := decodeIndexedAddresses(s, c.Addresses)
+	if err != nil {
+		return nil, err
+	}
+	histories, bestHash, bestHeight, err := fetchAddressHistories(s, addrs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the unspent outputs and sort them in the order they appear in
+	// the main chain so the pages are stable as long as the chain only
+	// grows.
+	var utxos addressUtxos
+	for _, history := range histories {
+		for _, txo := range history.credits {
+			if !txo.spent {
+				utxos = append(utxos, addressUtxo{history.address, txo})
+			}
+		}
+	}
+	sort.Sort(utxos)
+
+	result := &btcjson.GetAddressUtxosResult{
+		Utxos:  []btcjson.AddressUtxoResult{},
+		Total:  len(utxos),
+		Hash:   bestHash.String(),
+		Height: bestHeight,
+	}
+	if numToSkip > len(utxos) {
+		numToSkip = len(utxos)
+	}
+	utxos = utxos[numToSkip:]
+	if numRequested < len(utxos) {
+		utxos = utxos[:numRequested]
+	}
+	for _, u := range utxos {
+		result.Utxos = append(result.Utxos, btcjson.AddressUtxoResult{
+			Address:     u.address,
+			TxID:        u.txo.outPoint.Hash.String(),
+			OutputIndex: u.txo.outPoint.Index,
+			Script:      hex.EncodeToString(u.txo.pkScript),
+			Satoshis:    u.txo.amount,
+			Height:      u.txo.height,
+			Coinbase:    u.txo.isCoinBase,
+		})
+	}
+	return result, nil
+}
+
 // handleGetBestBlock implements the getbestblock command.
 func handleGetBestBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// All other "get block" commands give either the height, the
@@ -1016,10 +1648,25 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 		blkBytes, err = dbTx.FetchBlock(hash)
 		return err
 	})
-	if err != nil {
+	if dbErr, ok := err.(database.Error); ok &&
+		dbErr.ErrorCode == database.ErrBlockPruned {
+
 		return nil, &btcjson.RPCError{
 			Code:    btcjson.ErrRPCBlockNotFound,
-			Message: "Block not found",
+			Message: "Block not available (pruned data)",
+		}
+	}
+	if err != nil {
+		// Side chain blocks are only held in memory by the chain.
+		block, sideErr := s.chain.SideChainBlock(hash)
+		if sideErr == nil {
+			blkBytes, err = block.Bytes()
+		}
+		if sideErr != nil || err != nil {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCBlockNotFound,
+				Message: "Block not found",
+			}
 		}
 	}
 
@@ -1038,24 +1685,21 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 		return nil, internalRPCError(err.Error(), context)
 	}
 
-	// Get the block height from chain.
-	blockHeight, err := s.chain.BlockHeightByHash(hash)
+	// Get the details of the block which depend on its position in the
+	// chain.  This works for side chain blocks as well.
+	info, err := s.chain.BlockHeaderInfo(hash)
 	if err != nil {
-		context := "Failed to obtain block height"
+		context := "Failed to obtain block details"
 		return nil, internalRPCError(err.Error(), context)
 	}
+	blockHeight := info.Height
 	blk.SetHeight(blockHeight)
 	best := s.chain.BestSnapshot()
 
 	// Get next block hash unless there are none.
 	var nextHashString string
-	if blockHeight < best.Height {
-		nextHash, err := s.chain.BlockHashByHeight(blockHeight + 1)
-		if err != nil {
-			context := "No next block"
-			return nil, internalRPCError(err.Error(), context)
-		}
-		nextHashString = nextHash.String()
+	if info.NextHash != nil {
+		nextHashString = info.NextHash.String()
 	}
 
 	blockHeader := &blk.MsgBlock().Header
@@ -1066,11 +1710,13 @@ func handleGetBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 		PreviousHash:  blockHeader.PrevBlock.String(),
 		Nonce:         blockHeader.Nonce,
 		Time:          blockHeader.Timestamp.Unix(),
-		Confirmations: uint64(1 + best.Height - blockHeight),
+		MedianTime:    info.MedianTime.Unix(),
+		Confirmations: info.Confirmations,
 		Height:        int64(blockHeight),
 		Size:          int32(len(blkBytes)),
 		Bits:          strconv.FormatInt(int64(blockHeader.Bits), 16),
 		Difficulty:    getDifficultyRatio(blockHeader.Bits),
+		ChainWork:     fmt.Sprintf("%064x", info.ChainWork),
 		NextHash:      nextHashString,
 	}
 
@@ -1136,10 +1782,21 @@ func handleGetBlockHeader(s *rpcServer, cmd interface{}, closeChan <-chan struct
 		return err
 	})
 	if err != nil {
-		return nil, &btcjson.RPCError{
-			Code:    btcjson.ErrRPCBlockNotFound,
-			Message: "Block not found",
+		// Side chain blocks are only held in memory by the chain.
+		block, sideErr := s.chain.SideChainBlock(hash)
+		if sideErr != nil {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCBlockNotFound,
+				Message: "Block not found",
+			}
 		}
+		var headerBuf bytes.Buffer
+		err := block.MsgBlock().Header.Serialize(&headerBuf)
+		if err != nil {
+			context := "Failed to serialize block header"
+			return nil, internalRPCError(err.Error(), context)
+		}
+		headerBytes = headerBuf.Bytes()
 	}
 
 	// When the verbose flag isn't set, simply return the serialized block
@@ -1150,220 +1807,63 @@ func handleGetBlockHeader(s *rpcServer, cmd interface{}, closeChan <-chan struct
 
 	// The verbose flag is set, so generate the JSON object and return it.
 
-	// Deserialize the header.
-	var blockHeader wire.BlockHeader
-	err = blockHeader.Deserialize(bytes.NewReader(headerBytes))
-	if err != nil {
-		context := "Failed to deserialize block header"
-		return nil, internalRPCError(err.Error(), context)
-	}
-
-	// Get the block height from chain.
-	blockHeight, err := s.chain.BlockHeightByHash(hash)
+	// Get the header along with the details of the block which depend on
+	// its position in the chain without loading the full block.  This works
+	// for side chain blocks as well.
+	info, err := s.chain.BlockHeaderInfo(hash)
 	if err != nil {
-		context := "Failed to obtain block height"
+		context := "Failed to obtain block details"
 		return nil, internalRPCError(err.Error(), context)
 	}
-	best := s.chain.BestSnapshot()
 
 	// Get next block hash unless there are none.
 	var nextHashString string
-	if blockHeight < best.Height {
-		nextHash, err := s.chain.BlockHashByHeight(blockHeight + 1)
-		if err != nil {
-			context := "No next block"
-			return nil, internalRPCError(err.Error(), context)
-		}
-		nextHashString = nextHash.String()
+	if info.NextHash != nil {
+		nextHashString = info.NextHash.String()
 	}
 
+	blockHeader := &info.Header
 	blockHeaderReply := btcjson.GetBlockHeaderVerboseResult{
 		Hash:          c.Hash,
-		Confirmations: uint64(1 + best.Height - blockHeight),
-		Height:        int32(blockHeight),
+		Confirmations: info.Confirmations,
+		Height:        info.Height,
 		Version:       blockHeader.Version,
 		MerkleRoot:    blockHeader.MerkleRoot.String(),
 		NextHash:      nextHashString,
 		PreviousHash:  blockHeader.PrevBlock.String(),
 		Nonce:         uint64(blockHeader.Nonce),
 		Time:          blockHeader.Timestamp.Unix(),
+		MedianTime:    info.MedianTime.Unix(),
 		Bits:          strconv.FormatInt(int64(blockHeader.Bits), 16),
 		Difficulty:    getDifficultyRatio(blockHeader.Bits),
+		ChainWork:     fmt.Sprintf("%064x", info.ChainWork),
 	}
 	return blockHeaderReply, nil
 }
 
-// encodeTemplateID encodes the passed details into an ID that can be used to
-// uniquely identify a block template.
-func encodeTemplateID(prevHash *wire.ShaHash, lastGenerated time.Time) string {
-	return fmt.Sprintf("%s-%d", prevHash.String(), lastGenerated.Unix())
-}
-
-// decodeTemplateID decodes an ID that is used to uniquely identify a block
-// template.  This is mainly used as a mechanism to track when to update clients
-// that are using long polling for block templates.  The ID consists of the
-// previous block hash for the associated template and the time the associated
-// template was generated.
-func decodeTemplateID(templateID string) (*wire.ShaHash, int64, error) {
-	fields := strings.Split(templateID, "-")
-	if len(fields) != 2 {
-		return nil, 0, errors.New("invalid longpollid format")
-	}
-
-	prevHash, err := wire.NewShaHashFromStr(fields[0])
-	if err != nil {
-		return nil, 0, errors.New("invalid longpollid format")
-	}
-	lastGenerated, err := strconv.ParseInt(fields[1], 10, 64)
-	if err != nil {
-		return nil, 0, errors.New("invalid longpollid format")
-	}
-
-	return prevHash, lastGenerated, nil
-}
-
-// notifyLongPollers notifies any channels that have been registered to be
-// notified when block templates are stale.
-//
-// This function MUST be called with the state locked.
-func (state *gbtWorkState) notifyLongPollers(latestHash *wire.ShaHash, lastGenerated time.Time) {
-	// Notify anything that is waiting for a block template update from a
-	// hash which is not the hash of the tip of the best chain since their
-	// work is now invalid.
-	for hash, channels := range state.notifyMap {
-		if !hash.IsEqual(latestHash) {
-			for _, c := range channels {
-				close(c)
-			}
-			delete(state.notifyMap, hash)
-		}
-	}
-
-	// Return now if the provided last generated timestamp has not been
-	// initialized.
-	if lastGenerated.IsZero() {
-		return
-	}
-
-	// Return now if there is nothing registered for updates to the current
-	// best block hash.
-	channels, ok := state.notifyMap[*latestHash]
-	if !ok {
-		return
-	}
-
-	// Notify anything that is waiting for a block template update from a
-	// block template generated before the most recently generated block
-	// template.
-	lastGeneratedUnix := lastGenerated.Unix()
-	for lastGen, c := range channels {
-		if lastGen < lastGeneratedUnix {
-			close(c)
-			delete(channels, lastGen)
-		}
-	}
-
-	// Remove the entry altogether if there are no more registered
-	// channels.
-	if len(channels) == 0 {
-		delete(state.notifyMap, *latestHash)
-	}
-}
-
-// NotifyBlockConnected uses the newly-connected block to notify any long poll
-// clients with a new block template when their existing block template is
-// stale due to the newly connected block.
-func (state *gbtWorkState) NotifyBlockConnected(blockSha *wire.ShaHash) {
-	go func() {
-		state.Lock()
-		defer state.Unlock()
-
-		state.notifyLongPollers(blockSha, state.lastTxUpdate)
-	}()
-}
-
-// NotifyMempoolTx uses the new last updated time for the transaction memory
-// pool to notify any long poll clients with a new block template when their
-// existing block template is stale due to enough time passing and the contents
-// of the memory pool changing.
-func (state *gbtWorkState) NotifyMempoolTx(lastUpdated time.Time) {
-	go func() {
-		state.Lock()
-		defer state.Unlock()
-
-		// No need to notify anything if no block templates have been generated
-		// yet.
-		if state.prevHash == nil || state.lastGenerated.IsZero() {
-			return
-		}
-
-		if time.Now().After(state.lastGenerated.Add(time.Second *
-			gbtRegenerateSeconds)) {
-
-			state.notifyLongPollers(state.prevHash, lastUpdated)
-		}
-	}()
-}
-
-// templateUpdateChan returns a channel that will be closed once the block
-// template associated with the passed previous hash and last generated time
-// is stale.  The function will return existing channels for duplicate
-// parameters which allows multiple clients to wait for the same block template
-// without requiring a different channel for each client.
-//
-// This function MUST be called with the state locked.
-func (state *gbtWorkState) templateUpdateChan(prevHash *wire.ShaHash, lastGenerated int64) chan struct{} {
-	// Either get the current list of channels waiting for updates about
-	// changes to block template for the previous hash or create a new one.
-	channels, ok := state.notifyMap[*prevHash]
-	if !ok {
-		m := make(map[int64]chan struct{})
-		state.notifyMap[*prevHash] = m
-		channels = m
-	}
-
-	// Get the current channel associated with the time the block template
-	// was last generated or create a new one.
-	c, ok := channels[lastGenerated]
-	if !ok {
-		c = make(chan struct{})
-		channels[lastGenerated] = c
-	}
-
-	return c
-}
-
 // updateBlockTemplate creates or updates a block template for the work state.
 // A new block template will be generated when the current best block has
-// changed or the transactions in the memory pool have been updated and it has
-// been long enough since the last template was generated.  Otherwise, the
+// changed or the template notifier of the server reports the existing template
+// is stale, such as when the fees of the transactions in the memory pool
+// changed enough.  Otherwise, the
 // timestamp for the existing block template is updated (and possibly the
-// difficulty on testnet per the consesus rules).  Finally, if the
-// useCoinbaseValue flag is false and the existing block template does not
+// difficulty on testnet per the consesus rules).  Finally, if the request
+// options ask for a coinbase transaction and the existing block template does not
 // already contain a valid payment address, the block template will be updated
 // with a randomly selected payment address from the list of configured
 // addresses.
 //
 // This function MUST be called with the state locked.
-func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bool) error {
-	lastTxUpdate := s.server.txMemPool.LastUpdated()
-	if lastTxUpdate.IsZero() {
-		lastTxUpdate = time.Now()
-	}
-
+func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, opts gbtRequestOptions) error {
 	// Generate a new block template when the current best block has
-	// changed or the transactions in the memory pool have been updated and
-	// it has been at least gbtRegenerateSecond since the last template was
-	// generated.
+	// changed or the existing template is stale.
 	var msgBlock *wire.MsgBlock
 	var targetDifficulty string
 	latestHash, _ := s.server.blockManager.chainState.Best()
 	template := state.template
 	if template == nil || state.prevHash == nil ||
 		!state.prevHash.IsEqual(latestHash) ||
-		(state.lastTxUpdate != lastTxUpdate &&
-			time.Now().After(state.lastGenerated.Add(time.Second*
-				gbtRegenerateSeconds))) {
+		template.LongPollID != s.server.templateNotifier.Current() {
 
 		// Reset the previous best hash the block template was generated
 		// against so any errors below cause the next invocation to try
@@ -1374,7 +1874,7 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 		// full coinbase as opposed to only the pertinent details needed
 		// to create their own coinbase.
 		var payAddr colxutil.Address
-		if !useCoinbaseValue {
+		if !opts.useCoinbaseValue {
 			payAddr = cfg.miningAddrs[rand.Intn(len(cfg.miningAddrs))]
 		}
 
@@ -1406,8 +1906,6 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 		// Update work state to ensure another block template isn't
 		// generated until needed.
 		state.template = template
-		state.lastGenerated = time.Now()
-		state.lastTxUpdate = lastTxUpdate
 		state.prevHash = latestHash
 		state.minTimestamp = minTimestamp
 
@@ -1415,10 +1913,6 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 			"target %s, merkle root %s)",
 			msgBlock.Header.Timestamp, targetDifficulty,
 			msgBlock.Header.MerkleRoot)
-
-		// Notify any clients that are long polling about the new
-		// template.
-		state.notifyLongPollers(latestHash, lastTxUpdate)
 	} else {
 		// At this point, there is a saved block template and another
 		// request for a template was made, but either the available
@@ -1432,7 +1926,7 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 		// template if it doesn't already have one.  Since this requires
 		// mining addresses to be specified via the config, an error is
 		// returned if none have been specified.
-		if !useCoinbaseValue && !template.ValidPayAddress {
+		if !opts.useCoinbaseValue && !template.ValidPayAddress {
 			// Choose a payment address at random.
 			payToAddr := cfg.miningAddrs[rand.Intn(len(cfg.miningAddrs))]
 
@@ -1473,10 +1967,12 @@ func (state *gbtWorkState) updateBlockTemplate(s *rpcServer, useCoinbaseValue bo
 
 // blockTemplateResult returns the current block template associated with the
 // state as a btcjson.GetBlockTemplateResult that is ready to be encoded to JSON
-// and returned to the caller.
+// and returned to the caller.  The variant of the template is determined by the
+// passed request options, and the results are cached per variant until the
+// template or its timestamp changes.
 //
 // This function MUST be called with the state locked.
-func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld *bool) (*btcjson.GetBlockTemplateResult, error) {
+func (state *gbtWorkState) blockTemplateResult(opts gbtRequestOptions, submitOld *bool) (*btcjson.GetBlockTemplateResult, error) {
 	// Ensure the timestamps are still in valid range for the template.
 	// This should really only ever happen if the local clock is changed
 	// after the template is generated, but it's important to avoid serving
@@ -1496,6 +1992,23 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 		}
 	}
 
+	// Return a copy of the cached result for the requested variant when
+	// the template has not changed since it was created.  Only the maximum
+	// time and whether old work may be submitted differ between requests.
+	if state.resultsTemplate != template ||
+		!state.resultsTime.Equal(header.Timestamp) {
+
+		state.results = make(map[gbtRequestOptions]*btcjson.GetBlockTemplateResult)
+		state.resultsTemplate = template
+		state.resultsTime = header.Timestamp
+	}
+	if cached, ok := state.results[opts]; ok {
+		reply := *cached
+		reply.MaxTime = maxTime.Unix()
+		reply.SubmitOld = submitOld
+		return &reply, nil
+	}
+
 	// Convert each transaction in the block template to a template result
 	// transaction.  The result does not include the coinbase, so notice
 	// the adjustments to the various lengths and indices.
@@ -1545,23 +2058,21 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 		transactions = append(transactions, resultTx)
 	}
 
-	// Generate the block template reply.  Note that following mutations are
-	// implied by the included or omission of fields:
-	//  Including MinTime -> time/decrement
-	//  Omitting CoinbaseTxn -> coinbase, generation
+	// Generate the block template reply.  Note that the time/decrement
+	// mutation is implied by including MinTime.
 	targetDifficulty := fmt.Sprintf("%064x", blockchain.CompactToBig(header.Bits))
-	templateID := encodeTemplateID(state.prevHash, state.lastGenerated)
+	templateID := template.LongPollID.String()
+	maxWeight, maxSigOpsCost := activeNetParams.GetBlockWeightLimits()
 	reply := btcjson.GetBlockTemplateResult{
 		Bits:         strconv.FormatInt(int64(header.Bits), 16),
 		CurTime:      header.Timestamp.Unix(),
 		Height:       int64(template.Height),
 		PreviousHash: header.PrevBlock.String(),
-		SigOpLimit:   blockchain.MaxSigOpsPerBlock,
-		SizeLimit:    wire.MaxBlockPayload,
+		SigOpLimit:   maxSigOpsCost / blockchain.WitnessScaleFactor,
+		SizeLimit:    maxWeight / blockchain.WitnessScaleFactor,
 		Transactions: transactions,
 		Version:      header.Version,
 		LongPollID:   templateID,
-		SubmitOld:    submitOld,
 		Target:       targetDifficulty,
 		MinTime:      state.minTimestamp.Unix(),
 		MaxTime:      maxTime.Unix(),
@@ -1569,7 +2080,14 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 		NonceRange:   gbtNonceRange,
 		Capabilities: gbtCapabilities,
 	}
-	if useCoinbaseValue {
+	if len(cfg.miningAddrs) > 0 {
+		reply.Capabilities = gbtCoinbaseTxnCapabilities
+	}
+	if opts.workID {
+		reply.WorkID = templateID
+	}
+	if opts.useCoinbaseValue {
+		reply.Mutable = gbtCoinbaseValueMutableFields
 		reply.CoinbaseAux = gbtCoinbaseAux
 		reply.CoinbaseValue = &msgBlock.Transactions[0].TxOut[0].Value
 	} else {
@@ -1604,6 +2122,9 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 		reply.CoinbaseTxn = &resultTx
 	}
 
+	cached := reply
+	state.results[opts] = &cached
+	reply.SubmitOld = submitOld
 	return &reply, nil
 }
 
@@ -1611,29 +2132,37 @@ func (state *gbtWorkState) blockTemplateResult(useCoinbaseValue bool, submitOld
 // which deals with handling long polling for block templates.  When a caller
 // sends a request with a long poll ID that was previously returned, a response
 // is not sent until the caller should stop working on the previous block
-// template in favor of the new one.  In particular, this is the case when the
-// old block template is no longer valid due to a solution already being found
-// and added to the block chain, or new transactions have shown up and some time
-// has passed without finding a solution.
+// template in favor of the new one as reported by the template notifier of the
+// server.  In particular, this is the case when the old block template is no
+// longer valid due to a solution already being found and added to the block
+// chain, or the fees of the transactions in the memory pool changed enough to
+// make working on a new template worthwhile.  The current block template is
+// returned once the long poll timeout passes without that happening, and the
+// request is abandoned as soon as the client disconnects.
 //
 // See https://en.bitcoin.it/wiki/BIP_0022 for more details.
-func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbaseValue bool, closeChan <-chan struct{}) (interface{}, error) {
+func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, opts gbtRequestOptions, closeChan <-chan struct{}) (interface{}, error) {
+	// Subscribe before examining the current block template so no
+	// notification about it becoming stale can be missed.
+	sub := s.server.templateNotifier.Subscribe()
+	defer sub.Stop()
+
 	state := s.gbtWorkState
 	state.Lock()
 	// The state unlock is intentionally not deferred here since it needs to
 	// be manually unlocked before waiting for a notification about block
 	// template changes.
 
-	if err := state.updateBlockTemplate(s, useCoinbaseValue); err != nil {
+	if err := state.updateBlockTemplate(s, opts); err != nil {
 		state.Unlock()
 		return nil, err
 	}
 
 	// Just return the current block template if the long poll ID provided by
 	// the caller is invalid.
-	prevHash, lastGenerated, err := decodeTemplateID(longPollID)
+	id, err := mining.ParseLongPollID(longPollID)
 	if err != nil {
-		result, err := state.blockTemplateResult(useCoinbaseValue, nil)
+		result, err := state.blockTemplateResult(opts, nil)
 		if err != nil {
 			state.Unlock()
 			return nil, err
@@ -1647,14 +2176,12 @@ func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbase
 	// identified by the long poll ID no longer matches the current block
 	// template as this means the provided template is stale.
 	prevTemplateHash := &state.template.Block.Header.PrevBlock
-	if !prevHash.IsEqual(prevTemplateHash) ||
-		lastGenerated != state.lastGenerated.Unix() {
-
+	if id != state.template.LongPollID {
 		// Include whether or not it is valid to submit work against the
 		// old block template depending on whether or not a solution has
 		// already been found and added to the block chain.
-		submitOld := prevHash.IsEqual(prevTemplateHash)
-		result, err := state.blockTemplateResult(useCoinbaseValue,
+		submitOld := id.PrevHash.IsEqual(prevTemplateHash)
+		result, err := state.blockTemplateResult(opts,
 			&submitOld)
 		if err != nil {
 			state.Unlock()
@@ -1665,37 +2192,39 @@ func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbase
 		return result, nil
 	}
 
-	// Register the previous hash and last generated time for notifications
-	// Get a channel that will be notified when the template associated with
-	// the provided ID is stale and a new block template should be returned to
-	// the caller.
-	longPollChan := state.templateUpdateChan(prevHash, lastGenerated)
 	state.Unlock()
 
+	timeout := time.NewTimer(cfg.RPCLongPollTimeout)
+	defer timeout.Stop()
+
 	select {
 	// When the client closes before it's time to send a reply, just return
 	// now so the goroutine doesn't hang around.
 	case <-closeChan:
 		return nil, ErrClientQuit
 
-	// Wait until signal received to send the reply.
-	case <-longPollChan:
-		// Fallthrough
+	// Return the current block template once the client has waited for
+	// the maximum allowed time.
+	case <-timeout.C:
+
+	// Wait until the template notifier reports the template associated
+	// with the provided ID is stale.
+	case <-sub.C():
 	}
 
 	// Get the lastest block template
 	state.Lock()
 	defer state.Unlock()
 
-	if err := state.updateBlockTemplate(s, useCoinbaseValue); err != nil {
+	if err := state.updateBlockTemplate(s, opts); err != nil {
 		return nil, err
 	}
 
 	// Include whether or not it is valid to submit work against the old
 	// block template depending on whether or not a solution has already
 	// been found and added to the block chain.
-	submitOld := prevHash.IsEqual(&state.template.Block.Header.PrevBlock)
-	result, err := state.blockTemplateResult(useCoinbaseValue, &submitOld)
+	submitOld := id.PrevHash.IsEqual(&state.template.Block.Header.PrevBlock)
+	result, err := state.blockTemplateResult(opts, &submitOld)
 	if err != nil {
 		return nil, err
 	}
@@ -1703,18 +2232,17 @@ func handleGetBlockTemplateLongPoll(s *rpcServer, longPollID string, useCoinbase
 	return result, nil
 }
 
-// handleGetBlockTemplateRequest is a helper for handleGetBlockTemplate which
-// deals with generating and returning block templates to the caller.  It
-// handles both long poll requests as specified by BIP 0022 as well as regular
-// requests.  In addition, it detects the capabilities reported by the caller
-// in regards to whether or not it supports creating its own coinbase (the
-// coinbasetxn and coinbasevalue capabilities) and modifies the returned block
-// template accordingly.
-func handleGetBlockTemplateRequest(s *rpcServer, request *btcjson.TemplateRequest, closeChan <-chan struct{}) (interface{}, error) {
-	// Extract the relevant passed capabilities and restrict the result to
-	// either a coinbase value or a coinbase transaction object depending on
-	// the request.  Default to only providing a coinbase value.
-	useCoinbaseValue := true
+// parseGbtRequestOptions returns the options of the passed getblocktemplate
+// request which determine the variant of the returned block template.  The
+// capabilities reported by the caller determine whether it supports creating
+// its own coinbase (the coinbasetxn and coinbasevalue capabilities) and work
+// IDs.  An error is returned when the caller requests a capability the server
+// does not support.
+func parseGbtRequestOptions(request *btcjson.TemplateRequest) (gbtRequestOptions, error) {
+	// Restrict the result to either a coinbase value or a coinbase
+	// transaction object depending on the request.  Default to only
+	// providing a coinbase value.
+	opts := gbtRequestOptions{useCoinbaseValue: true}
 	if request != nil {
 		var hasCoinbaseValue, hasCoinbaseTxn bool
 		for _, capability := range request.Capabilities {
@@ -1723,18 +2251,28 @@ func handleGetBlockTemplateRequest(s *rpcServer, request *btcjson.TemplateReques
 				hasCoinbaseTxn = true
 			case "coinbasevalue":
 				hasCoinbaseValue = true
+			case "workid":
+				opts.workID = true
+			}
+
+			if _, ok := gbtUnsupportedCapabilities[capability]; ok {
+				return opts, &btcjson.RPCError{
+					Code: btcjson.ErrRPCInvalidParameter,
+					Message: fmt.Sprintf("The %s capability "+
+						"is not supported", capability),
+				}
 			}
 		}
 
 		if hasCoinbaseTxn && !hasCoinbaseValue {
-			useCoinbaseValue = false
+			opts.useCoinbaseValue = false
 		}
 	}
 
 	// When a coinbase transaction has been requested, respond with an error
 	// if there are no addresses to pay the created block template to.
-	if !useCoinbaseValue && len(cfg.miningAddrs) == 0 {
-		return nil, &btcjson.RPCError{
+	if !opts.useCoinbaseValue && len(cfg.miningAddrs) == 0 {
+		return opts, &btcjson.RPCError{
 			Code: btcjson.ErrRPCInternal.Code,
 			Message: "A coinbase transaction has been requested, " +
 				"but the server has not been configured with " +
@@ -1742,6 +2280,21 @@ func handleGetBlockTemplateRequest(s *rpcServer, request *btcjson.TemplateReques
 		}
 	}
 
+	return opts, nil
+}
+
+// handleGetBlockTemplateRequest is a helper for handleGetBlockTemplate which
+// deals with generating and returning block templates to the caller.  It
+// handles both long poll requests as specified by BIP 0022 as well as regular
+// requests.  In addition, it returns the variant of the block template which
+// matches the capabilities reported by the caller.  See parseGbtRequestOptions
+// for details.
+func handleGetBlockTemplateRequest(s *rpcServer, request *btcjson.TemplateRequest, closeChan <-chan struct{}) (interface{}, error) {
+	opts, err := parseGbtRequestOptions(request)
+	if err != nil {
+		return nil, err
+	}
+
 	// Return an error if there are no peers connected since there is no
 	// way to relay a found block or receive transactions to work on.
 	// However, allow this state when running in the regression test or
@@ -1767,7 +2320,7 @@ func handleGetBlockTemplateRequest(s *rpcServer, request *btcjson.TemplateReques
 	// be replaced with a new one.
 	if request != nil && request.LongPollID != "" {
 		return handleGetBlockTemplateLongPoll(s, request.LongPollID,
-			useCoinbaseValue, closeChan)
+			opts, closeChan)
 	}
 
 	// Protect concurrent access when updating block templates.
@@ -1781,10 +2334,10 @@ func handleGetBlockTemplateRequest(s *rpcServer, request *btcjson.TemplateReques
 	// seconds since the last template was generated.  Otherwise, the
 	// timestamp for the existing block template is updated (and possibly
 	// the difficulty on testnet per the consesus rules).
-	if err := state.updateBlockTemplate(s, useCoinbaseValue); err != nil {
+	if err := state.updateBlockTemplate(s, opts); err != nil {
 		return nil, err
 	}
-	return state.blockTemplateResult(useCoinbaseValue, nil)
+	return state.blockTemplateResult(opts, nil)
 }
 
 // chainErrToGBTErrString converts an error returned from btcchain to a string
@@ -1793,8 +2346,8 @@ func handleGetBlockTemplateRequest(s *rpcServer, request *btcjson.TemplateReques
 func chainErrToGBTErrString(err error) string {
 	// When the passed error is not a RuleError, just return a generic
 	// rejected string with the error text.
-	ruleErr, ok := err.(blockchain.RuleError)
-	if !ok {
+	var ruleErr blockchain.RuleError
+	if !errors.As(err, &ruleErr) {
 		return "rejected: " + err.Error()
 	}
 
@@ -1875,6 +2428,8 @@ func chainErrToGBTErrString(err error) string {
 		return "bad-script-malformed"
 	case blockchain.ErrScriptValidation:
 		return "bad-script-validate"
+	case blockchain.ErrPrevBlockNotBest:
+		return "bad-prevblk"
 	}
 
 	return "rejected: " + err.Error()
@@ -1916,31 +2471,18 @@ func handleGetBlockTemplateProposal(s *rpcServer, request *btcjson.TemplateReque
 	}
 	block := colxutil.NewBlock(&msgBlock)
 
-	// Ensure the block is building from the expected previous block.
-	expectedPrevHash, _ := s.server.blockManager.chainState.Best()
-	prevHash := &block.MsgBlock().Header.PrevBlock
-	if expectedPrevHash == nil || !expectedPrevHash.IsEqual(prevHash) {
-		return "bad-prevblk", nil
-	}
-
-	flags := blockchain.BFDryRun | blockchain.BFNoPoWCheck
-	isOrphan, err := s.server.blockManager.ProcessBlock(block, flags)
-	if err != nil {
-		if _, ok := err.(blockchain.RuleError); !ok {
-			err := rpcsLog.Errorf("Failed to process block "+
-				"proposal: %v", err)
-			return nil, &btcjson.RPCError{
-				Code:    -25, // TODO: ErrRpcVerify
-				Message: err.Error(),
-			}
+	// Validate the block against the current tip without modifying the
+	// chain state or relaying it.
+	if err := CheckBlockTemplate(block, s.server.blockManager); err != nil {
+		if !errors.As(err, new(blockchain.RuleError)) {
+			rpcsLog.Errorf("Failed to check block proposal: %v",
+				err)
+			return nil, rpcBlockRejectedError(err)
 		}
 
 		rpcsLog.Infof("Rejected block proposal: %v", err)
 		return chainErrToGBTErrString(err), nil
 	}
-	if isOrphan {
-		return "orphan", nil
-	}
 
 	return nil, nil
 }
@@ -2019,16 +2561,41 @@ func handleGetInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (in
 
 // handleGetMempoolInfo implements the getmempoolinfo command.
 func handleGetMempoolInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
-	mempoolTxns := s.server.txMemPool.TxDescs()
+	c := cmd.(*btcjson.GetMempoolInfoCmd)
+	mp := s.server.txMemPool
+	snapshot := mp.Snapshot()
 
-	var numBytes int64
-	for _, txD := range mempoolTxns {
-		numBytes += int64(txD.Tx.MsgTx().SerializeSize())
+	ret := &btcjson.GetMempoolInfoResult{
+		Size:             int64(snapshot.Count()),
+		Bytes:            snapshot.Bytes,
+		Usage:            mp.DynamicUsage(),
+		MaxMempool:       mp.cfg.Policy.MaxTxPoolSizeBytes,
+		MempoolMinFee:    mp.MinRelayTxFee().ToBTC(),
+		UnbroadcastCount: int64(mp.UnbroadcastCount()),
 	}
 
-	ret := &btcjson.GetMempoolInfoResult{
-		Size:  int64(len(mempoolTxns)),
-		Bytes: numBytes,
+	if c.Verbose != nil && *c.Verbose {
+		stats := mp.AcceptanceStats()
+		acceptStats := &btcjson.MempoolAcceptanceStats{
+			Processed: stats.Processed,
+			Accepted:  stats.Accepted,
+			Orphans:   stats.Orphans,
+			Rejected:  stats.Rejected(),
+			Stages: make([]btcjson.MempoolAcceptanceStage, 0,
+				len(stats.Stages)),
+		}
+		for i := range stats.Stages {
+			stage := &stats.Stages[i]
+			avgTime := float64(stage.AvgTime()) / float64(time.Microsecond)
+			acceptStats.Stages = append(acceptStats.Stages,
+				btcjson.MempoolAcceptanceStage{
+					Name:     stage.Stage.String(),
+					Reached:  stage.Reached,
+					Rejected: stage.Rejected,
+					AvgTime:  avgTime,
+				})
+		}
+		ret.AcceptanceStats = acceptStats
 	}
 
 	return ret, nil
@@ -2037,21 +2604,13 @@ func handleGetMempoolInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct
 // handleGetMiningInfo implements the getmininginfo command. We only return the
 // fields that are not related to wallet functionality.
 func handleGetMiningInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
-	// Create a default getnetworkhashps command to use defaults and make
-	// use of the existing getnetworkhashps handler.
-	gnhpsCmd := btcjson.NewGetNetworkHashPSCmd(nil, nil)
-	networkHashesPerSecIface, err := handleGetNetworkHashPS(s, gnhpsCmd,
-		closeChan)
+	// Estimate the network hashes per second the same way as the
+	// getnetworkhashps command does with its default parameters.
+	networkHashPS, err := networkHashesPerSec(s.chain, -1,
+		defaultNetworkHashPSBlocks)
 	if err != nil {
 		return nil, err
 	}
-	networkHashesPerSec, ok := networkHashesPerSecIface.(int64)
-	if !ok {
-		return nil, &btcjson.RPCError{
-			Code:    btcjson.ErrRPCInternal.Code,
-			Message: "networkHashesPerSec is not an int64",
-		}
-	}
 
 	best := s.chain.BestSnapshot()
 	result := btcjson.GetMiningInfoResult{
@@ -2062,7 +2621,7 @@ func handleGetMiningInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{
 		Generate:         s.server.cpuMiner.IsMining(),
 		GenProcLimit:     s.server.cpuMiner.NumWorkers(),
 		HashesPerSec:     int64(s.server.cpuMiner.HashesPerSecond()),
-		NetworkHashPS:    networkHashesPerSec,
+		NetworkHashPS:    networkHashPS,
 		PooledTx:         uint64(s.server.txMemPool.Count()),
 		TestNet:          cfg.TestNet3,
 	}
@@ -2082,36 +2641,49 @@ func handleGetNetTotals(s *rpcServer, cmd interface{}, closeChan <-chan struct{}
 
 // handleGetNetworkHashPS implements the getnetworkhashps command.
 func handleGetNetworkHashPS(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
-	// Note: All valid error return paths should return an int64.
-	// Literal zeros are inferred as int, and won't coerce to int64
-	// because the return value is an interface{}.
-
 	c := cmd.(*btcjson.GetNetworkHashPSCmd)
 
-	// When the passed height is too high or zero, just return 0 now
-	// since we can't reasonably calculate the number of network hashes
-	// per second from invalid values.  When it's negative, use the current
-	// best block height.
-	best := s.chain.BestSnapshot()
 	endHeight := int32(-1)
 	if c.Height != nil {
 		endHeight = int32(*c.Height)
 	}
+	numBlocks := int32(defaultNetworkHashPSBlocks)
+	if c.Blocks != nil {
+		numBlocks = int32(*c.Blocks)
+	}
+	hashesPerSec, err := networkHashesPerSec(s.chain, endHeight, numBlocks)
+	if err != nil {
+		return nil, err
+	}
+	return hashesPerSec, nil
+}
+
+// defaultNetworkHashPSBlocks is the number of blocks the network hashes per
+// second are estimated over when the number is not specified.
+const defaultNetworkHashPSBlocks = 120
+
+// networkHashesPerSec returns the estimated number of network hashes per second
+// over the passed number of main chain blocks ending with the block at the
+// passed height.  A negative height means the current best block, and a passed
+// number of blocks which is not positive means the blocks since the last
+// difficulty retarget.  The window is clamped so it does not start before the
+// genesis block.
+//
+// The estimate is the difference between the chain work of the blocks at the
+// end and the start of the window divided by the number of seconds between the
+// lowest and highest block timestamps in the window, including the start block.
+// The result is 0 when it can't reasonably be calculated, that is for the
+// genesis block, heights past the best block, and windows which have no time
+// difference.
+func networkHashesPerSec(chain *blockchain.BlockChain, endHeight, numBlocks int32) (int64, error) {
+	best := chain.BestSnapshot()
 	if endHeight > best.Height || endHeight == 0 {
-		return int64(0), nil
+		return 0, nil
 	}
 	if endHeight < 0 {
 		endHeight = best.Height
 	}
 
-	// Calculate the starting block height based on the passed number of
-	// blocks.  When the passed value is negative, use the last block the
-	// difficulty changed as the starting height.  Also make sure the
-	// starting height is not before the beginning of the chain.
-	numBlocks := int32(120)
-	if c.Blocks != nil {
-		numBlocks = int32(*c.Blocks)
-	}
 	var startHeight int32
 	if numBlocks <= 0 {
 		startHeight = endHeight - ((endHeight % blockchain.BlocksPerRetarget) + 1)
@@ -2124,61 +2696,48 @@ func handleGetNetworkHashPS(s *rpcServer, cmd interface{}, closeChan <-chan stru
 	rpcsLog.Debugf("Calculating network hashes per second from %d to %d",
 		startHeight, endHeight)
 
-	// Find the min and max block timestamps as well as calculate the total
-	// amount of work that happened between the start and end blocks.
+	// Find the min and max block timestamps along with the chain work of
+	// the start and end blocks.
 	var minTimestamp, maxTimestamp time.Time
-	totalWork := big.NewInt(0)
+	var startWork, endWork *big.Int
 	for curHeight := startHeight; curHeight <= endHeight; curHeight++ {
-		hash, err := s.chain.BlockHashByHeight(curHeight)
+		hash, err := chain.BlockHashByHeight(curHeight)
 		if err != nil {
 			context := "Failed to fetch block hash"
-			return nil, internalRPCError(err.Error(), context)
+			return 0, internalRPCError(err.Error(), context)
 		}
-
-		// Load the raw header bytes.
-		var headerBytes []byte
-		err = s.server.db.View(func(dbTx database.Tx) error {
-			var err error
-			headerBytes, err = dbTx.FetchBlockHeader(hash)
-			return err
-		})
+		info, err := chain.BlockHeaderInfo(hash)
 		if err != nil {
 			context := "Failed to fetch block header"
-			return nil, internalRPCError(err.Error(), context)
-		}
-
-		// Deserialize the header.
-		var header wire.BlockHeader
-		err = header.Deserialize(bytes.NewReader(headerBytes))
-		if err != nil {
-			context := "Failed to deserialize block header"
-			return nil, internalRPCError(err.Error(), context)
+			return 0, internalRPCError(err.Error(), context)
 		}
 
+		timestamp := info.Header.Timestamp
 		if curHeight == startHeight {
-			minTimestamp = header.Timestamp
-			maxTimestamp = minTimestamp
-		} else {
-			totalWork.Add(totalWork, blockchain.CalcWork(header.Bits))
-
-			if minTimestamp.After(header.Timestamp) {
-				minTimestamp = header.Timestamp
-			}
-			if maxTimestamp.Before(header.Timestamp) {
-				maxTimestamp = header.Timestamp
-			}
+			startWork = info.ChainWork
+			minTimestamp = timestamp
+			maxTimestamp = timestamp
+		}
+		if curHeight == endHeight {
+			endWork = info.ChainWork
+		}
+		if minTimestamp.After(timestamp) {
+			minTimestamp = timestamp
+		}
+		if maxTimestamp.Before(timestamp) {
+			maxTimestamp = timestamp
 		}
 	}
 
-	// Calculate the difference in seconds between the min and max block
-	// timestamps and avoid division by zero in the case where there is no
-	// time difference.
+	// Avoid division by zero in the case where there is no time
+	// difference.
 	timeDiff := int64(maxTimestamp.Sub(minTimestamp) / time.Second)
 	if timeDiff == 0 {
-		return int64(0), nil
+		return 0, nil
 	}
 
-	hashesPerSec := new(big.Int).Div(totalWork, big.NewInt(timeDiff))
+	workDiff := new(big.Int).Sub(endWork, startWork)
+	hashesPerSec := workDiff.Div(workDiff, big.NewInt(timeDiff))
 	return hashesPerSec.Int64(), nil
 }
 
@@ -2208,6 +2767,24 @@ func handleGetPeerInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 			BanScore:       int32(p.banScore.Int()),
 			SyncNode:       p == syncPeer,
 		}
+		info.BytesSentPerMsg = statsSnap.BytesSentPerMsg
+		info.BytesRecvPerMsg = statsSnap.BytesRecvPerMsg
+		info.StallCount = statsSnap.StallCount
+		if len(statsSnap.PingSamples) > 0 {
+			info.PingPercentiles = &btcjson.PingPercentilesResult{
+				P50: float64(pingPercentile(statsSnap.PingSamples, 50)),
+				P90: float64(pingPercentile(statsSnap.PingSamples, 90)),
+				P99: float64(pingPercentile(statsSnap.PingSamples, 99)),
+			}
+		}
+		quality := p.Quality()
+		info.Quality = &btcjson.PeerQualityResult{
+			Score:      quality.Score,
+			Latency:    quality.Latency,
+			Usefulness: quality.Usefulness,
+			Stalls:     quality.Stalls,
+			Behavior:   quality.Behavior,
+		}
 		if p.LastPingNonce() != 0 {
 			wait := float64(time.Now().Sub(statsSnap.LastPingTime).Nanoseconds())
 			// We actually want microseconds.
@@ -2218,50 +2795,70 @@ func handleGetPeerInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 	return infos, nil
 }
 
+// mempoolEntryResult returns the verbose result describing the passed memory
+// pool snapshot entry as of the passed next block height.
+func mempoolEntryResult(mp *txMemPool, entry *mempoolSnapshotEntry, nextBlockHeight int32) *btcjson.GetRawMempoolVerboseResult {
+	// Calculate the current priority based on the inputs to the
+	// transaction.  Use zero if one or more of the input transactions
+	// can't be found for some reason.  Inputs which spend other
+	// transactions in the pool have no age, so only the main chain is
+	// consulted.  This avoids holding the mempool lock.
+	var currentPriority float64
+	utxos, err := mp.cfg.FetchUtxoView(entry.Tx)
+	if err == nil {
+		currentPriority = calcPriority(entry.Tx.MsgTx(), utxos,
+			nextBlockHeight)
+	}
+
+	mpd := &btcjson.GetRawMempoolVerboseResult{
+		Size:             int32(entry.Size),
+		Fee:              colxutil.Amount(entry.Fee).ToBTC(),
+		ModifiedFee:      colxutil.Amount(entry.Fee + entry.FeeDelta).ToBTC(),
+		Time:             entry.Added.Unix(),
+		Height:           int64(entry.Height),
+		StartingPriority: entry.StartingPriority,
+		CurrentPriority:  currentPriority,
+		Depends:          make([]string, 0, len(entry.Depends)),
+		Unbroadcast:      entry.Unbroadcast,
+	}
+	for i := range entry.Depends {
+		mpd.Depends = append(mpd.Depends, entry.Depends[i].String())
+	}
+	return mpd
+}
+
+// handleGetMempoolEntry implements the getmempoolentry command.
+func handleGetMempoolEntry(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetMempoolEntryCmd)
+	txHash, err := wire.NewShaHashFromStr(c.TxID)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.TxID)
+	}
+
+	mp := s.server.txMemPool
+	entry, err := mp.SnapshotEntry(txHash)
+	if err != nil {
+		return nil, rpcNoTxInfoError(txHash)
+	}
+	best := s.chain.BestSnapshot()
+	return mempoolEntryResult(mp, entry, best.Height+1), nil
+}
+
 // handleGetRawMempool implements the getrawmempool command.
 func handleGetRawMempool(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.GetRawMempoolCmd)
 	mp := s.server.txMemPool
-	descs := mp.TxDescs()
+	snapshot := mp.Snapshot()
 
 	if c.Verbose != nil && *c.Verbose {
 		result := make(map[string]*btcjson.GetRawMempoolVerboseResult,
-			len(descs))
+			snapshot.Count())
 
 		best := s.chain.BestSnapshot()
-
-		mp.RLock()
-		defer mp.RUnlock()
-		for _, desc := range descs {
-			// Calculate the current priority based on the inputs to
-			// the transaction.  Use zero if one or more of the
-			// input transactions can't be found for some reason.
-			tx := desc.Tx
-			var currentPriority float64
-			utxos, err := mp.fetchInputUtxos(tx)
-			if err == nil {
-				currentPriority = calcPriority(tx.MsgTx(),
-					utxos, best.Height+1)
-			}
-
-			mpd := &btcjson.GetRawMempoolVerboseResult{
-				Size:             int32(tx.MsgTx().SerializeSize()),
-				Fee:              colxutil.Amount(desc.Fee).ToBTC(),
-				Time:             desc.Added.Unix(),
-				Height:           int64(desc.Height),
-				StartingPriority: desc.StartingPriority,
-				CurrentPriority:  currentPriority,
-				Depends:          make([]string, 0),
-			}
-			for _, txIn := range tx.MsgTx().TxIn {
-				hash := &txIn.PreviousOutPoint.Hash
-				if s.server.txMemPool.haveTransaction(hash) {
-					mpd.Depends = append(mpd.Depends,
-						hash.String())
-				}
-			}
-
-			result[tx.Sha().String()] = mpd
+		for i := range snapshot.Entries {
+			entry := &snapshot.Entries[i]
+			result[entry.Hash.String()] = mempoolEntryResult(mp,
+				entry, best.Height+1)
 		}
 
 		return result, nil
@@ -2269,9 +2866,9 @@ func handleGetRawMempool(s *rpcServer, cmd interface{}, closeChan <-chan struct{
 
 	// The response is simply an array of the transaction hashes if the
 	// verbose flag is not set.
-	hashStrings := make([]string, len(descs))
-	for i := range hashStrings {
-		hashStrings[i] = descs[i].Tx.Sha().String()
+	hashStrings := make([]string, snapshot.Count())
+	for i := range snapshot.Entries {
+		hashStrings[i] = snapshot.Entries[i].Hash.String()
 	}
 
 	return hashStrings, nil
@@ -2445,6 +3042,13 @@ func reverseUint32Array(b []byte) {
 	}
 }
 
+// handleGetRPCInfo implements the getrpcinfo command.
+func handleGetRPCInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	return &btcjson.GetRPCInfoResult{
+		Notifiers: s.notifiers.Info(),
+	}, nil
+}
+
 // handleGetTxOut handles gettxout commands.
 func handleGetTxOut(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	c := cmd.(*btcjson.GetTxOutCmd)
@@ -2554,6 +3158,133 @@ func handleGetTxOut(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (i
 	return txOutReply, nil
 }
 
+// handleGetTxOutProof implements the gettxoutproof command.
+func handleGetTxOutProof(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.GetTxOutProofCmd)
+
+	if len(c.TxIDs) == 0 {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Parameter 'txids' cannot be empty",
+		}
+	}
+	txHashes := make(map[wire.ShaHash]struct{}, len(c.TxIDs))
+	var firstTxHash *wire.ShaHash
+	for _, txid := range c.TxIDs {
+		txHash, err := wire.NewShaHashFromStr(txid)
+		if err != nil {
+			return nil, rpcDecodeHexError(txid)
+		}
+		if _, ok := txHashes[*txHash]; ok {
+			return nil, &btcjson.RPCError{
+				Code: btcjson.ErrRPCInvalidParameter,
+				Message: "Invalid parameter, duplicated txid: " +
+					txid,
+			}
+		}
+		txHashes[*txHash] = struct{}{}
+		if firstTxHash == nil {
+			firstTxHash = txHash
+		}
+	}
+
+	// Look up the block which contains the transactions in the transaction
+	// index when it isn't specified.
+	var blockHash *wire.ShaHash
+	if c.BlockHash != nil {
+		hash, err := wire.NewShaHashFromStr(*c.BlockHash)
+		if err != nil {
+			return nil, rpcDecodeHexError(*c.BlockHash)
+		}
+		blockHash = hash
+	} else {
+		txIndex := s.server.txIndex
+		if txIndex == nil {
+			return nil, &btcjson.RPCError{
+				Code: btcjson.ErrRPCNoTxInfo,
+				Message: "The transaction index must be " +
+					"enabled to find the block of the " +
+					"transactions (specify --txindex)",
+			}
+		}
+		blockRegion, err := txIndex.TxBlockRegion(firstTxHash)
+		if err != nil {
+			context := "Failed to retrieve transaction location"
+			return nil, internalRPCError(err.Error(), context)
+		}
+		if blockRegion == nil {
+			return nil, rpcNoTxInfoError(firstTxHash)
+		}
+		blockHash = blockRegion.Hash
+	}
+
+	// Only blocks in the main chain are loaded, so no proof is created for
+	// a block which was disconnected by a reorganize.
+	best := s.chain.BestSnapshot()
+	block, err := s.chain.BlockByHash(blockHash)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCBlockNotFound,
+			Message: "Block not found in the main chain",
+		}
+	}
+
+	// Create the proof from the cached merkle tree of the block when it is
+	// a recent one.
+	merkles := s.txProofCache.merkleTree(block, best.Height)
+	mBlock, matchedIndices := bloom.NewMerkleBlockWithTxs(block, txHashes,
+		merkles)
+	if len(matchedIndices) != len(txHashes) {
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCInvalidAddressOrKey,
+			Message: "Not all transactions found in specified or " +
+				"retrieved block",
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := mBlock.BtcEncode(&buf, maxProtocolVersion); err != nil {
+		context := "Failed to encode merkle block"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// handleGetTxOutSetInfo implements the gettxoutsetinfo command.
+func handleGetTxOutSetInfo(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	// The utxo set is scanned in its entirety, so stop the scan when the
+	// client disconnects or the server shuts down.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-closeChan:
+		case <-s.quit:
+		case <-ctx.Done():
+		}
+		cancel()
+	}()
+
+	stats, err := s.chain.UtxoSetStats(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ErrClientQuit
+		}
+		return nil, internalRPCError(err.Error(),
+			"Failed to calculate utxo set statistics")
+	}
+
+	return &btcjson.GetTxOutSetInfoResult{
+		Height:          stats.Height,
+		BestBlock:       stats.Hash.String(),
+		Transactions:    stats.Transactions,
+		TxOuts:          stats.Outputs,
+		BytesSerialized: stats.SerializedSize,
+		HashSerialized:  stats.SetHash.String(),
+		TotalAmount:     colxutil.Amount(stats.TotalAmount).ToBTC(),
+	}, nil
+}
+
 // handleGetWorkRequest is a helper for handleGetWork which deals with
 // generating and returning work to the caller.
 //
@@ -2784,7 +3515,7 @@ func handleGetWorkSubmission(s *rpcServer, hexData string) (interface{}, error)
 	if err != nil {
 		// Anything other than a rule violation is an unexpected error,
 		// so return that error as an internal error.
-		if _, ok := err.(blockchain.RuleError); !ok {
+		if !errors.As(err, new(blockchain.RuleError)) {
 			return false, internalRPCError("Unexpected error "+
 				"while checking proof of work: "+err.Error(),
 				"")
@@ -2808,7 +3539,7 @@ func handleGetWorkSubmission(s *rpcServer, hexData string) (interface{}, error)
 	if err != nil || isOrphan {
 		// Anything other than a rule violation is an unexpected error,
 		// so return that error as an internal error.
-		if _, ok := err.(blockchain.RuleError); !ok {
+		if !errors.As(err, new(blockchain.RuleError)) {
 			return false, internalRPCError("Unexpected error "+
 				"while processing block: "+err.Error(), "")
 		}
@@ -2910,6 +3641,36 @@ func handleHelp(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (inter
 	return help, nil
 }
 
+// handleImportMempool implements the importmempool command.
+func handleImportMempool(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.ImportMempoolCmd)
+
+	// Apply the full policy unless the caller asked to skip the fee
+	// checks, in which case the transactions are treated as locally
+	// submitted.
+	var opts *txAcceptOptions
+	if c.SkipFeeLimits != nil && *c.SkipFeeLimits {
+		opts = &txAcceptOptions{
+			AllowHighFees: true,
+			SkipFeeLimits: true,
+		}
+	}
+
+	accepted, skipped, err := loadMempoolFile(s.server.txMemPool,
+		c.FilePath, opts)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "Unable to import mempool: " + err.Error(),
+		}
+	}
+
+	return &btcjson.ImportMempoolResult{
+		Accepted: int64(accepted),
+		Skipped:  int64(skipped),
+	}, nil
+}
+
 // handlePing implements the ping command.
 func handlePing(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// Ask server to ping \o_
@@ -2923,6 +3684,106 @@ func handlePing(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (inter
 	return nil, nil
 }
 
+// handlePreciousBlock implements the preciousblock command.
+func handlePreciousBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.PreciousBlockCmd)
+	hash, err := wire.NewShaHashFromStr(c.BlockHash)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.BlockHash)
+	}
+
+	chain := s.server.blockManager.chain
+	haveBlock, err := chain.HaveBlock(hash)
+	if err != nil {
+		return nil, internalRPCError(err.Error(), "Failed to look up block")
+	}
+	if !haveBlock {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCBlockNotFound,
+			Message: "Block not found",
+		}
+	}
+
+	if err := chain.PreciousBlock(hash); err != nil {
+		return nil, internalRPCError(err.Error(),
+			"Failed to prefer block")
+	}
+
+	return nil, nil
+}
+
+// handleReconsiderBlock implements the reconsiderblock command.
+func handleReconsiderBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.ReconsiderBlockCmd)
+	hash, err := wire.NewShaHashFromStr(c.BlockHash)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.BlockHash)
+	}
+
+	chain := s.server.blockManager.chain
+	if _, ok := chain.InvalidBlockReason(hash); !ok {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCBlockNotFound,
+			Message: "Block not marked invalid",
+		}
+	}
+
+	blocks, err := chain.ReconsiderBlock(hash)
+	if err != nil {
+		return nil, internalRPCError(err.Error(),
+			"Failed to reconsider block")
+	}
+
+	// Validate the block and its descendants again through the block
+	// manager like any other block.  Descendants which are still invalid
+	// are only logged.
+	var blockErr error
+	for i, block := range blocks {
+		_, err := s.server.blockManager.ProcessBlock(block,
+			blockchain.BFNone)
+		if i == 0 {
+			blockErr = err
+		} else if err != nil {
+			rpcsLog.Debugf("Reconsidered block %v rejected: %v",
+				block.Sha(), err)
+		}
+	}
+
+	// Report blocks which are still invalid once validated again along
+	// with the violated rule.
+	if err := blockErr; err != nil {
+		if errors.As(err, new(blockchain.RuleError)) {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCVerify,
+				Message: "Block is still invalid: " + err.Error(),
+			}
+		}
+		return nil, internalRPCError(err.Error(),
+			"Failed to reconsider block")
+	}
+
+	return nil, nil
+}
+
+// handlePrioritiseTransaction implements the prioritisetransaction command.
+func handlePrioritiseTransaction(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.PrioritiseTransactionCmd)
+	txHash, err := wire.NewShaHashFromStr(c.TxID)
+	if err != nil {
+		return nil, rpcDecodeHexError(c.TxID)
+	}
+
+	err = s.server.txMemPool.PrioritiseTransaction(txHash, c.FeeDelta)
+	if err != nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCMisc,
+			Message: err.Error(),
+		}
+	}
+
+	return true, nil
+}
+
 // retrievedTx represents a transaction that was either loaded from the
 // transaction memory pool or from the database.  When a transaction is loaded
 // from the database, it is loaded with the raw serialized bytes while the
@@ -3140,6 +4001,185 @@ func fetchMempoolTxnsForAddress(s *rpcServer, addr colxutil.Address, numToSkip,
 	return mpTxns[numToSkip:rangeEnd], numToSkip
 }
 
+// handleSaveMempool implements the savemempool command.
+func handleSaveMempool(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	path := mempoolFilePath()
+	if _, err := saveMempoolFile(s.server.txMemPool, path); err != nil {
+		return nil, internalRPCError("Unable to save mempool: "+
+			err.Error(), "")
+	}
+
+	return &btcjson.SaveMempoolResult{Filename: path}, nil
+}
+
+// utxoScanChunkSize is the number of utxo set entries scanned by scantxoutset
+// in between checks for whether or not the scan has been aborted.
+const utxoScanChunkSize = 1000
+
+// utxoScanState houses the state of the scan started by scantxoutset which is
+// used in between multiple RPC invocations to report its progress and abort it.
+type utxoScanState struct {
+	sync.Mutex
+	running   bool
+	aborted   bool
+	progress  float64
+	chunkSize int
+
+	// chunkHook is invoked without the lock held after every scanned
+	// chunk when it is set.  It is only used by the tests.
+	chunkHook func()
+}
+
+// newUtxoScanState returns a new instance of a utxoScanState with all internal
+// fields initialized and ready to use.
+func newUtxoScanState() *utxoScanState {
+	return &utxoScanState{
+		chunkSize: utxoScanChunkSize,
+	}
+}
+
+// handleScanTxOutSet implements the scantxoutset command.
+func handleScanTxOutSet(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	c := cmd.(*btcjson.ScanTxOutSetCmd)
+	state := s.utxoScanState
+
+	switch c.Action {
+	case "start":
+		return scanTxOutSet(s, c.ScanObjects, closeChan)
+
+	case "abort":
+		state.Lock()
+		defer state.Unlock()
+		if !state.running {
+			return false, nil
+		}
+		state.aborted = true
+		return true, nil
+
+	case "status":
+		state.Lock()
+		defer state.Unlock()
+		if !state.running {
+			return nil, nil
+		}
+		return &btcjson.ScanTxOutSetStatusResult{
+			Progress: state.progress,
+		}, nil
+	}
+
+	return nil, &btcjson.RPCError{
+		Code:    btcjson.ErrRPCInvalidParameter,
+		Message: fmt.Sprintf("Invalid action %q", c.Action),
+	}
+}
+
+// scanTxOutSet is a helper for handleScanTxOutSet which scans the utxo set for
+// the outputs matching the passed descriptors in chunks until either the end
+// of the utxo set is reached or the scan is aborted.  Only a single scan may
+// run at a time.
+func scanTxOutSet(s *rpcServer, scanObjects *[]string, closeChan <-chan struct{}) (interface{}, error) {
+	if scanObjects == nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParameter,
+			Message: "The scanobjects argument is required for the start action",
+		}
+	}
+	pkScripts := make(map[string]struct{}, len(*scanObjects))
+	for _, desc := range *scanObjects {
+		pkScript, err := parseScanDescriptor(desc, s.server.chainParams)
+		if err != nil {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParameter,
+				Message: err.Error(),
+			}
+		}
+		pkScripts[string(pkScript)] = struct{}{}
+	}
+	match := func(pkScript []byte) bool {
+		_, ok := pkScripts[string(pkScript)]
+		return ok
+	}
+
+	state := s.utxoScanState
+	state.Lock()
+	if state.running {
+		state.Unlock()
+		return nil, &btcjson.RPCError{
+			Code: btcjson.ErrRPCMisc,
+			Message: "Scan already in progress, use action " +
+				"\"abort\" or \"status\"",
+		}
+	}
+	state.running = true
+	state.aborted = false
+	state.progress = 0
+	state.Unlock()
+	defer func() {
+		state.Lock()
+		state.running = false
+		state.Unlock()
+	}()
+
+	result := &btcjson.ScanTxOutSetResult{
+		Success:  true,
+		Unspents: []btcjson.ScanTxOutSetUnspent{},
+	}
+	var totalAmount int64
+	var resumeKey []byte
+	for {
+		state.Lock()
+		aborted := state.aborted
+		chunkSize := state.chunkSize
+		state.Unlock()
+		if aborted {
+			result.Success = false
+			break
+		}
+		select {
+		case <-closeChan:
+			return nil, ErrClientQuit
+		case <-s.quit:
+			return nil, ErrClientQuit
+		default:
+		}
+
+		chunk, err := s.chain.ScanUtxoSet(resumeKey, chunkSize, match)
+		if err != nil {
+			context := "Failed to scan utxo set"
+			return nil, internalRPCError(err.Error(), context)
+		}
+		result.SearchedItems += int64(chunk.Searched)
+		for i := range chunk.Outputs {
+			output := &chunk.Outputs[i]
+			totalAmount += output.Amount
+			result.Unspents = append(result.Unspents,
+				btcjson.ScanTxOutSetUnspent{
+					Txid:         output.Hash.String(),
+					Vout:         output.Index,
+					ScriptPubKey: hex.EncodeToString(output.PkScript),
+					Amount:       colxutil.Amount(output.Amount).ToBTC(),
+					Height:       output.Height,
+				})
+		}
+
+		state.Lock()
+		state.progress = blockchain.UtxoScanProgress(chunk.NextKey) * 100
+		hook := state.chunkHook
+		state.Unlock()
+		if hook != nil {
+			hook()
+		}
+
+		if chunk.NextKey == nil {
+			break
+		}
+		resumeKey = chunk.NextKey
+	}
+	result.TotalAmount = colxutil.Amount(totalAmount).ToBTC()
+
+	return result, nil
+}
+
 // handleSearchRawTransactions implements the searchrawtransactions command.
 func handleSearchRawTransactions(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
 	// Respond with an error if the address index is not enabled.
@@ -3438,15 +4478,24 @@ func handleSendRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan st
 		}
 	}
 
+	// Relax the relay policy as requested since the transaction is being
+	// submitted locally by the node operator.
+	opts := &txAcceptOptions{
+		AllowHighFees: c.AllowHighFees != nil && *c.AllowHighFees,
+		AcceptNonStd:  c.AcceptNonStd != nil && *c.AcceptNonStd,
+		SkipFeeLimits: c.SkipFeeLimits != nil && *c.SkipFeeLimits,
+	}
+
 	tx := colxutil.NewTx(msgtx)
-	acceptedTxs, err := s.server.txMemPool.ProcessTransaction(tx, false, false)
+	acceptedTxs, err := s.server.txMemPool.ProcessTransaction(tx, false,
+		false, opts)
 	if err != nil {
 		// When the error is a rule error, it means the transaction was
 		// simply rejected as opposed to something actually going wrong,
 		// so log it as such.  Otherwise, something really did go wrong,
 		// so log it as an actual error.  In both cases, a JSON-RPC
-		// error is returned to the client with the deserialization
-		// error code (to match bitcoind behavior).
+		// error is returned to the client with the details of the
+		// rejection.
 		if _, ok := err.(RuleError); ok {
 			rpcsLog.Debugf("Rejected transaction %v: %v", tx.Sha(),
 				err)
@@ -3454,18 +4503,16 @@ func handleSendRawTransaction(s *rpcServer, cmd interface{}, closeChan <-chan st
 			rpcsLog.Errorf("Failed to process transaction %v: %v",
 				tx.Sha(), err)
 		}
-		return nil, &btcjson.RPCError{
-			Code:    btcjson.ErrRPCDeserialization,
-			Message: "TX rejected: " + err.Error(),
-		}
+		return nil, rpcTxRejectedError(err)
 	}
 
 	s.server.AnnounceNewTransactions(acceptedTxs)
 
 	// Keep track of all the sendrawtransaction request txns so that they
-	// can be rebroadcast if they don't make their way into a block.
-	iv := wire.NewInvVect(wire.InvTypeTx, tx.Sha())
-	s.server.AddRebroadcastInventory(iv, tx)
+	// can be rebroadcast until a peer requests them or they make their way
+	// into a block.  Transactions which were only accepted due to the
+	// relaxed policy are never relayed, so they are not tracked.
+	s.server.txMemPool.AddUnbroadcast(tx)
 
 	return tx.Sha().String(), nil
 }
@@ -3534,8 +4581,15 @@ func handleSubmitBlock(s *rpcServer, cmd interface{}, closeChan <-chan struct{})
 		}
 	}
 
+	// Blocks which violate a rule are rejected with a BIP0022 result while
+	// failures to process the block are returned as errors.
 	_, err = s.server.blockManager.ProcessBlock(block, blockchain.BFNone)
 	if err != nil {
+		if !errors.As(err, new(blockchain.RuleError)) {
+			rpcsLog.Errorf("Failed to process submitted block %v: %v",
+				block.Sha(), err)
+			return nil, rpcBlockRejectedError(err)
+		}
 		return fmt.Sprintf("rejected: %s", err.Error()), nil
 	}
 
@@ -3581,7 +4635,7 @@ func verifyChain(s *rpcServer, level, depth int32) error {
 		// Level 1 does basic chain sanity checks.
 		if level > 0 {
 			err := blockchain.CheckBlockSanity(block,
-				activeNetParams.PowLimit, s.server.timeSource)
+				activeNetParams.Params, s.server.timeSource)
 			if err != nil {
 				rpcsLog.Errorf("Verify is unable to validate "+
 					"block at hash %v height %d: %v",
@@ -3675,26 +4729,94 @@ func handleVerifyMessage(s *rpcServer, cmd interface{}, closeChan <-chan struct{
 	return address.EncodeAddress() == c.Address, nil
 }
 
+// parseVersionResult returns the version result for the passed semantic version
+// string, which must consist of the major, minor, and patch versions only.
+func parseVersionResult(version string) (btcjson.VersionResult, error) {
+	result := btcjson.VersionResult{VersionString: version}
+	var extra string
+	n, _ := fmt.Sscanf(version, "%d.%d.%d%s", &result.Major, &result.Minor,
+		&result.Patch, &extra)
+	if n != 3 {
+		return btcjson.VersionResult{}, fmt.Errorf("malformed version "+
+			"%q", version)
+	}
+	return result, nil
+}
+
+// handleVersion implements the version command.
+func handleVersion(s *rpcServer, cmd interface{}, closeChan <-chan struct{}) (interface{}, error) {
+	dbType := s.server.db.Type()
+	dbVersion, err := database.DriverVersion(dbType)
+	if err != nil {
+		context := "Failed to look up database version"
+		return nil, internalRPCError(err.Error(), context)
+	}
+	dbResult, err := parseVersionResult(dbVersion)
+	if err != nil {
+		context := "Failed to parse database version"
+		return nil, internalRPCError(err.Error(), context)
+	}
+
+	// The wire protocol is versioned by a single number, which is reported
+	// as the major version.  It is the highest version negotiated with
+	// peers.
+	protocolVersion := peer.MaxProtocolVersion
+	result := &btcjson.NodeVersionResult{
+		Colxd: btcjson.VersionResult{
+			VersionString: version(),
+			Major:         uint32(appMajor),
+			Minor:         uint32(appMinor),
+			Patch:         uint32(appPatch),
+			Prerelease:    normalizeVerString(appPreRelease),
+			BuildMetadata: normalizeVerString(appBuild),
+		},
+		Protocol: btcjson.VersionResult{
+			VersionString: fmt.Sprintf("%d.0.0", protocolVersion),
+			Major:         protocolVersion,
+		},
+		Database:        dbResult,
+		DatabaseBackend: dbType,
+
+		// The indexes are only created when they are enabled, including
+		// when they are required by other indexes, and the chain only
+		// tracks its prune state once pruning is enabled.  Neither the
+		// database nor the RPC server compress data and there is no REST
+		// interface, so those are never enabled.
+		Features: btcjson.VersionFeaturesResult{
+			TxIndex:   s.server.txIndex != nil,
+			AddrIndex: s.server.addrIndex != nil,
+			CfIndex:   s.server.cfIndex != nil,
+			Pruning:   s.chain.IsPruned(),
+		},
+	}
+	return result, nil
+}
+
 // rpcServer holds the items the rpc server may need to access (config,
 // shutdown, main server, etc.)
 type rpcServer struct {
-	started      int32
-	shutdown     int32
-	policy       *mining.Policy
-	server       *server
-	chain        *blockchain.BlockChain
-	authsha      [fastsha256.Size]byte
-	limitauthsha [fastsha256.Size]byte
-	ntfnMgr      *wsNotificationManager
-	numClients   int32
-	statusLines  map[int]string
-	statusLock   sync.RWMutex
-	wg           sync.WaitGroup
-	listeners    []net.Listener
-	workState    *workState
-	gbtWorkState *gbtWorkState
-	helpCacher   *helpCacher
-	quit         chan int
+	started       int32
+	shutdown      int32
+	policy        *mining.Policy
+	server        *server
+	chain         *blockchain.BlockChain
+	authsha       [fastsha256.Size]byte
+	limitauthsha  [fastsha256.Size]byte
+	limited       map[string]struct{}
+	auditor       *rpcAuditor
+	ntfnMgr       *wsNotificationManager
+	numClients    int32
+	statusLines   map[int]string
+	statusLock    sync.RWMutex
+	wg            sync.WaitGroup
+	listeners     []net.Listener
+	workState     *workState
+	gbtWorkState  *gbtWorkState
+	helpCacher    *helpCacher
+	utxoScanState *utxoScanState
+	txProofCache  *txProofCache
+	notifiers     *notifierManager
+	quit          chan int
 }
 
 // httpStatusLine returns a response Status-Line (RFC 2616 Section 6.1)
@@ -3772,8 +4894,10 @@ func (s *rpcServer) Stop() error {
 	}
 	s.ntfnMgr.Shutdown()
 	s.ntfnMgr.WaitForShutdown()
+	s.notifiers.Stop()
 	close(s.quit)
 	s.wg.Wait()
+	s.auditor.close()
 	rpcsLog.Infof("RPC server shutdown complete")
 	return nil
 }
@@ -3855,12 +4979,33 @@ func (s *rpcServer) checkAuth(r *http.Request, require bool) (bool, bool, error)
 	return false, false, errors.New("auth failure")
 }
 
+// authorized returns whether a user with the passed access level may call the
+// passed method.  Admin users may call every method while limited users may
+// only call the configured set of methods.
+func (s *rpcServer) authorized(method string, isAdmin bool) bool {
+	if isAdmin {
+		return true
+	}
+	_, ok := s.limited[method]
+	return ok
+}
+
+// rpcUser returns the configured name of the user with the passed access level
+// for use in the audit log.
+func rpcUser(isAdmin bool) string {
+	if isAdmin {
+		return cfg.RPCUser
+	}
+	return cfg.RPCLimitUser
+}
+
 // parsedRPCCmd represents a JSON-RPC request object that has been parsed into
 // a known concrete command along with any error that might have happened while
 // parsing it.
 type parsedRPCCmd struct {
 	id     interface{}
 	method string
+	params []json.RawMessage
 	cmd    interface{}
 	err    *btcjson.RPCError
 }
@@ -3898,6 +5043,7 @@ func parseCmd(request *btcjson.Request) *parsedRPCCmd {
 	var parsedCmd parsedRPCCmd
 	parsedCmd.id = request.ID
 	parsedCmd.method = request.Method
+	parsedCmd.params = request.Params
 
 	cmd, err := btcjson.UnmarshalCmd(request)
 	if err != nil {
@@ -4014,12 +5160,10 @@ func (s *rpcServer) jsonRPCRead(w http.ResponseWriter, r *http.Request, isAdmin
 		}()
 
 		// Check if the user is limited and set error if method unauthorized
-		if !isAdmin {
-			if _, ok := rpcLimited[request.Method]; !ok {
-				jsonErr = &btcjson.RPCError{
-					Code:    btcjson.ErrRPCInvalidParams.Code,
-					Message: "limited user not authorized for this method",
-				}
+		if !s.authorized(request.Method, isAdmin) {
+			jsonErr = &btcjson.RPCError{
+				Code:    btcjson.ErrRPCInvalidParams.Code,
+				Message: "limited user not authorized for this method",
 			}
 		}
 
@@ -4033,6 +5177,9 @@ func (s *rpcServer) jsonRPCRead(w http.ResponseWriter, r *http.Request, isAdmin
 				result, jsonErr = s.standardCmdResult(parsedCmd, closeChan)
 			}
 		}
+
+		s.auditor.record(rpcUser(isAdmin), r.RemoteAddr, request.Method,
+			request.Params, jsonErr)
 	}
 
 	// Marshal the response.
@@ -4129,6 +5276,7 @@ func (s *rpcServer) Start() {
 		}(listener)
 	}
 
+	s.notifiers.Start()
 	s.ntfnMgr.Start()
 }
 
@@ -4156,18 +5304,24 @@ func genCertPair(certFile, keyFile string) error {
 	return nil
 }
 
-// newRPCServer returns a new instance of the rpcServer struct.
-func newRPCServer(listenAddrs []string, policy *mining.Policy, s *server) (*rpcServer, error) {
+// newRPCServer returns a new instance of the rpcServer struct.  The passed
+// notifiers are notified about chain and mempool events while the server runs.
+func newRPCServer(listenAddrs []string, policy *mining.Policy, notifiers []Notifier, s *server) (*rpcServer, error) {
 	rpc := rpcServer{
-		policy:       policy,
-		server:       s,
-		chain:        s.blockManager.chain,
-		statusLines:  make(map[int]string),
-		workState:    newWorkState(),
-		gbtWorkState: newGbtWorkState(s.timeSource),
-		helpCacher:   newHelpCacher(),
-		quit:         make(chan int),
-	}
+		policy:        policy,
+		server:        s,
+		chain:         s.blockManager.chain,
+		statusLines:   make(map[int]string),
+		workState:     newWorkState(),
+		gbtWorkState:  newGbtWorkState(s.timeSource),
+		helpCacher:    newHelpCacher(),
+		quit:          make(chan int),
+		utxoScanState: newUtxoScanState(),
+		txProofCache:  newTxProofCache(txProofCacheDepth),
+		notifiers:     newNotifierManager(notifiers),
+	}
+	rpc.chain.RegisterCacheInvalidator("transaction proof merkle trees",
+		rpc.txProofCache.invalidate)
 	if cfg.RPCUser != "" && cfg.RPCPass != "" {
 		login := cfg.RPCUser + ":" + cfg.RPCPass
 		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(login))
@@ -4178,6 +5332,20 @@ func newRPCServer(listenAddrs []string, policy *mining.Policy, s *server) (*rpcS
 		auth := "Basic " + base64.StdEncoding.EncodeToString([]byte(login))
 		rpc.limitauthsha = fastsha256.Sum256([]byte(auth))
 	}
+	if len(cfg.RPCLimitMethods) > 0 {
+		limited, err := newRPCMethodSet(cfg.RPCLimitMethods)
+		if err != nil {
+			return nil, err
+		}
+		rpc.limited = limited
+	} else {
+		rpc.limited = rpcLimited
+	}
+	auditor, err := newRPCAuditor(cfg.RPCAuditMethods, cfg.RPCAuditFile)
+	if err != nil {
+		return nil, err
+	}
+	rpc.auditor = auditor
 	rpc.ntfnMgr = newWsNotificationManager(&rpc)
 
 	// Setup TLS if not disabled.
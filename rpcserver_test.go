@@ -0,0 +1,2003 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/blockchain/indexers"
+	"github.com/tinhnguyenhn/colxd/btcec"
+	"github.com/tinhnguyenhn/colxd/btcjson"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/database"
+	"github.com/tinhnguyenhn/colxd/mining"
+	"github.com/tinhnguyenhn/colxd/peer"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// newRPCTestChain returns a chain in a temporary database which only contains
+// the genesis block of the passed network along with the database and a
+// function which closes and removes it.
+func newRPCTestChain(t *testing.T, params *chaincfg.Params) (*blockchain.BlockChain, database.DB, func()) {
+	return newRPCIndexedTestChain(t, params, nil)
+}
+
+// newRPCIndexedTestChain returns a chain like newRPCTestChain which maintains
+// the indexes returned by the passed function for the database of the chain,
+// if any.
+func newRPCIndexedTestChain(t *testing.T, params *chaincfg.Params, newIndexes func(db database.DB) []indexers.Indexer) (*blockchain.BlockChain, database.DB, func()) {
+	dbPath, err := ioutil.TempDir("", "rpctestchain")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	db, err := database.Create("ffldb", filepath.Join(dbPath, "db"),
+		params.Net)
+	if err != nil {
+		os.RemoveAll(dbPath)
+		t.Fatalf("unable to create db: %v", err)
+	}
+	teardown := func() {
+		db.Close()
+		os.RemoveAll(dbPath)
+	}
+	var indexManager blockchain.IndexManager
+	if newIndexes != nil {
+		indexManager = indexers.NewManager(db, newIndexes(db), nil, nil)
+	}
+	chain, err := blockchain.New(&blockchain.Config{
+		DB:           db,
+		ChainParams:  params,
+		TimeSource:   blockchain.NewMedianTime(),
+		IndexManager: indexManager,
+	})
+	if err != nil {
+		teardown()
+		t.Fatalf("unable to create chain: %v", err)
+	}
+
+	return chain, db, teardown
+}
+
+// newRPCTestBlock returns a solved block at the passed height which extends
+// the block with the passed hash and has the passed timestamp.  The coinbase
+// pays to the passed scripts followed by an output with the rest of the
+// subsidy paying to a script which is not matched by the tests.
+func newRPCTestBlock(params *chaincfg.Params, prevHash *wire.ShaHash, timestamp time.Time, height int32, payScripts [][]byte) (*colxutil.Block, error) {
+	coinbaseScript, err := txscript.NewScriptBuilder().
+		AddInt64(int64(height)).AddInt64(0).Script()
+	if err != nil {
+		return nil, err
+	}
+	coinbaseTx := wire.NewMsgTx()
+	coinbaseTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&wire.ShaHash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: coinbaseScript,
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	remaining := blockchain.CalcBlockSubsidy(height, params)
+	for i, pkScript := range payScripts {
+		amount := int64(i+1) * colxutil.SatoshiPerBitcoin
+		coinbaseTx.AddTxOut(wire.NewTxOut(amount, pkScript))
+		remaining -= amount
+	}
+	coinbaseTx.AddTxOut(wire.NewTxOut(remaining, []byte{txscript.OP_TRUE}))
+
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{
+		Version:   4,
+		PrevBlock: *prevHash,
+		Timestamp: timestamp,
+		Bits:      params.PowLimitBits,
+	})
+	msgBlock.AddTransaction(coinbaseTx)
+	merkles := blockchain.BuildMerkleTreeStore(
+		[]*colxutil.Tx{colxutil.NewTx(coinbaseTx)})
+	msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+	target := blockchain.CompactToBig(msgBlock.Header.Bits)
+	for {
+		hash := msgBlock.Header.BlockSha()
+		if blockchain.ShaHashToBig(&hash).Cmp(target) <= 0 {
+			break
+		}
+		msgBlock.Header.Nonce++
+	}
+
+	return colxutil.NewBlock(msgBlock), nil
+}
+
+// newScanTestChain returns a chain in a temporary database with the passed
+// number of blocks connected on top of the genesis block of the passed
+// network.  The blocks are ten minutes apart and the coinbase of every block
+// pays to the scripts returned by the passed function for its height as
+// described by newRPCTestBlock.
+func newScanTestChain(t *testing.T, params *chaincfg.Params, numBlocks int, payScripts func(height int32) [][]byte) (*blockchain.BlockChain, []*colxutil.Block, func()) {
+	chain, _, teardown := newRPCTestChain(t, params)
+
+	blocks := make([]*colxutil.Block, 0, numBlocks)
+	prevHash := params.GenesisHash
+	prevTime := params.GenesisBlock.Header.Timestamp
+	for height := int32(1); height <= int32(numBlocks); height++ {
+		prevTime = prevTime.Add(time.Minute * 10)
+		block, err := newRPCTestBlock(params, prevHash, prevTime, height,
+			payScripts(height))
+		if err != nil {
+			teardown()
+			t.Fatalf("unable to create block: %v", err)
+		}
+		_, err = chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			teardown()
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+		blocks = append(blocks, block)
+		prevHash = block.Sha()
+	}
+
+	return chain, blocks, teardown
+}
+
+// TestHandleScanTxOutSet ensures the scantxoutset command finds the unspent
+// outputs paying to the requested descriptors, reports the progress of a
+// running scan, only allows a single scan to run at a time, and stops an
+// aborted scan within one chunk.
+func TestHandleScanTxOutSet(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	newAddrScript := func(b byte) (colxutil.Address, []byte) {
+		pkHash := make([]byte, 20)
+		pkHash[0] = b
+		addr, err := colxutil.NewAddressPubKeyHash(pkHash, params)
+		if err != nil {
+			t.Fatalf("unable to create address: %v", err)
+		}
+		pkScript, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			t.Fatalf("unable to create script: %v", err)
+		}
+		return addr, pkScript
+	}
+	addrA, scriptA := newAddrScript(1)
+	addrB, scriptB := newAddrScript(2)
+
+	// Pay to the first address in every block and to the second address in
+	// every third block.
+	const numBlocks = 12
+	chain, blocks, teardown := newScanTestChain(t, params, numBlocks,
+		func(height int32) [][]byte {
+			if height%3 == 0 {
+				return [][]byte{scriptA, scriptB}
+			}
+			return [][]byte{scriptA}
+		})
+	defer teardown()
+
+	type outpoint struct {
+		txid string
+		vout uint32
+	}
+	wantUnspents := make(map[outpoint]btcjson.ScanTxOutSetUnspent)
+	var wantTotal int64
+	for i, block := range blocks {
+		coinbase := block.Transactions()[0]
+		for vout, txOut := range coinbase.MsgTx().TxOut {
+			if txOut.PkScript[0] == txscript.OP_TRUE {
+				continue
+			}
+			op := outpoint{coinbase.Sha().String(), uint32(vout)}
+			wantUnspents[op] = btcjson.ScanTxOutSetUnspent{
+				Txid:         op.txid,
+				Vout:         op.vout,
+				ScriptPubKey: hex.EncodeToString(txOut.PkScript),
+				Amount:       colxutil.Amount(txOut.Value).ToBTC(),
+				Height:       int32(i + 1),
+			}
+			wantTotal += txOut.Value
+		}
+	}
+
+	s := &rpcServer{
+		server:        &server{chainParams: params},
+		chain:         chain,
+		utxoScanState: newUtxoScanState(),
+		quit:          make(chan int),
+	}
+	s.utxoScanState.chunkSize = 5
+	scanObjects := []string{
+		"addr(" + addrA.EncodeAddress() + ")",
+		"raw(" + hex.EncodeToString(scriptB) + ")",
+	}
+	call := func(action string) (interface{}, error) {
+		cmd := btcjson.NewScanTxOutSetCmd(action, &scanObjects)
+		return handleScanTxOutSet(s, cmd, nil)
+	}
+
+	// Ensure the status and abort actions report there is no scan running.
+	if reply, err := call("status"); err != nil || reply != nil {
+		t.Fatalf("status: unexpected reply %v (err %v) without a scan",
+			reply, err)
+	}
+	if reply, err := call("abort"); err != nil || reply != false {
+		t.Fatalf("abort: unexpected reply %v (err %v) without a scan",
+			reply, err)
+	}
+
+	// Ensure the progress reported while the scan is running increases and
+	// another scan can't be started until the running one finishes.
+	var progress []float64
+	s.utxoScanState.chunkHook = func() {
+		reply, err := call("status")
+		if err != nil {
+			t.Fatalf("status: unexpected error: %v", err)
+		}
+		status, ok := reply.(*btcjson.ScanTxOutSetStatusResult)
+		if !ok {
+			t.Fatalf("status: unexpected reply type %T", reply)
+		}
+		progress = append(progress, status.Progress)
+
+		_, err = call("start")
+		rpcErr, ok := err.(*btcjson.RPCError)
+		if !ok || rpcErr.Code != btcjson.ErrRPCMisc {
+			t.Fatalf("start: unexpected error during a running "+
+				"scan: %v", err)
+		}
+	}
+	reply, err := call("start")
+	if err != nil {
+		t.Fatalf("start: unexpected error: %v", err)
+	}
+	result, ok := reply.(*btcjson.ScanTxOutSetResult)
+	if !ok {
+		t.Fatalf("start: unexpected reply type %T", reply)
+	}
+
+	wantChunks := (numBlocks + 4) / 5
+	if len(progress) != wantChunks {
+		t.Fatalf("unexpected number of progress reports - got %d, "+
+			"want %d", len(progress), wantChunks)
+	}
+	for i, pct := range progress {
+		if pct < 0 || pct > 100 || (i > 0 && pct < progress[i-1]) {
+			t.Fatalf("unexpected progress reports %v", progress)
+		}
+	}
+	if progress[len(progress)-1] != 100 {
+		t.Fatalf("unexpected final progress - got %v, want 100",
+			progress[len(progress)-1])
+	}
+
+	if !result.Success || result.SearchedItems != numBlocks {
+		t.Fatalf("unexpected result - got success %v, searched %d, "+
+			"want true, %d", result.Success, result.SearchedItems,
+			numBlocks)
+	}
+	if len(result.Unspents) != len(wantUnspents) {
+		t.Fatalf("unexpected number of unspents - got %d, want %d",
+			len(result.Unspents), len(wantUnspents))
+	}
+	for _, unspent := range result.Unspents {
+		want, ok := wantUnspents[outpoint{unspent.Txid, unspent.Vout}]
+		if !ok || unspent != want {
+			t.Fatalf("unexpected unspent - got %+v, want %+v",
+				unspent, want)
+		}
+	}
+	if result.TotalAmount != colxutil.Amount(wantTotal).ToBTC() {
+		t.Fatalf("unexpected total amount - got %v, want %v",
+			result.TotalAmount, colxutil.Amount(wantTotal).ToBTC())
+	}
+
+	// Ensure the scan is no longer reported as running.
+	if reply, err := call("status"); err != nil || reply != nil {
+		t.Fatalf("status: unexpected reply %v (err %v) after the scan",
+			reply, err)
+	}
+
+	// Ensure an aborted scan stops after the chunk being scanned.
+	s.utxoScanState.chunkHook = func() {
+		if reply, err := call("abort"); err != nil || reply != true {
+			t.Fatalf("abort: unexpected reply %v (err %v) during "+
+				"a running scan", reply, err)
+		}
+	}
+	reply, err = call("start")
+	if err != nil {
+		t.Fatalf("start: unexpected error: %v", err)
+	}
+	result = reply.(*btcjson.ScanTxOutSetResult)
+	if result.Success || result.SearchedItems != 5 {
+		t.Fatalf("unexpected aborted result - got success %v, searched "+
+			"%d, want false, 5", result.Success, result.SearchedItems)
+	}
+
+	// Ensure invalid actions and descriptors are rejected.
+	if _, err := call("restart"); err == nil {
+		t.Fatal("start: unexpectedly accepted invalid action")
+	}
+	scanObjects = []string{"pkh(" + addrB.EncodeAddress() + ")"}
+	if _, err := call("start"); err == nil {
+		t.Fatal("start: unexpectedly accepted invalid descriptor")
+	}
+}
+
+// TestHandleGetBlockHeaderVerbose ensures the verbose results of the
+// getblockheader and getblock commands describe the position of the end of the
+// main chain, a block in the middle of it, and a side chain block, including
+// the next block hash, chain work, confirmations, and median time.
+func TestHandleGetBlockHeaderVerbose(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chain, db, teardown := newRPCTestChain(t, params)
+	defer teardown()
+
+	// Create a main chain of five blocks which are ten minutes apart and a
+	// side chain block which extends the second block at the same height as
+	// the third one.
+	genesisTime := params.GenesisBlock.Header.Timestamp
+	var blocks []*colxutil.Block
+	prevHash := params.GenesisHash
+	for height := int32(1); height <= 5; height++ {
+		block, err := newRPCTestBlock(params, prevHash,
+			genesisTime.Add(time.Minute*10*time.Duration(height)),
+			height, nil)
+		if err != nil {
+			t.Fatalf("unable to create block: %v", err)
+		}
+		blocks = append(blocks, block)
+		prevHash = block.Sha()
+	}
+	sideBlock, err := newRPCTestBlock(params, blocks[1].Sha(),
+		genesisTime.Add(time.Minute*31), 3, nil)
+	if err != nil {
+		t.Fatalf("unable to create block: %v", err)
+	}
+	for _, block := range append(blocks, sideBlock) {
+		_, err := chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+	}
+
+	s := &rpcServer{
+		server: &server{chainParams: params, db: db},
+		chain:  chain,
+		quit:   make(chan int),
+	}
+
+	// The work of every block, including the genesis block, is the same
+	// since they all have the minimum difficulty.
+	chainWork := func(height int64) string {
+		work := blockchain.CalcWork(params.PowLimitBits)
+		work.Mul(work, big.NewInt(height+1))
+		return fmt.Sprintf("%064x", work)
+	}
+	tests := []struct {
+		name          string
+		block         *colxutil.Block
+		height        int32
+		confirmations int64
+		nextHash      string
+		medianTime    time.Time
+	}{
+		{
+			name:          "tip",
+			block:         blocks[4],
+			height:        5,
+			confirmations: 1,
+			medianTime:    genesisTime.Add(time.Minute * 30),
+		},
+		{
+			name:          "mid-chain",
+			block:         blocks[2],
+			height:        3,
+			confirmations: 3,
+			nextHash:      blocks[3].Sha().String(),
+			medianTime:    genesisTime.Add(time.Minute * 20),
+		},
+		{
+			name:          "side chain",
+			block:         sideBlock,
+			height:        3,
+			confirmations: -1,
+			medianTime:    genesisTime.Add(time.Minute * 20),
+		},
+	}
+
+	for _, test := range tests {
+		header := &test.block.MsgBlock().Header
+		hash := test.block.Sha().String()
+		result, err := handleGetBlockHeader(s,
+			&btcjson.GetBlockHeaderCmd{Hash: hash}, nil)
+		if err != nil {
+			t.Errorf("%s: getblockheader: unexpected error: %v",
+				test.name, err)
+			continue
+		}
+		want := btcjson.GetBlockHeaderVerboseResult{
+			Hash:          hash,
+			Confirmations: test.confirmations,
+			Height:        test.height,
+			Version:       header.Version,
+			MerkleRoot:    header.MerkleRoot.String(),
+			Time:          header.Timestamp.Unix(),
+			MedianTime:    test.medianTime.Unix(),
+			Nonce:         uint64(header.Nonce),
+			Bits:          "207fffff",
+			Difficulty:    getDifficultyRatio(header.Bits),
+			ChainWork:     chainWork(int64(test.height)),
+			PreviousHash:  header.PrevBlock.String(),
+			NextHash:      test.nextHash,
+		}
+		if result != want {
+			t.Errorf("%s: getblockheader: unexpected result - got "+
+				"%+v, want %+v", test.name, result, want)
+		}
+
+		// The verbose getblock result must describe the block the same
+		// way.
+		result, err = handleGetBlock(s, &btcjson.GetBlockCmd{Hash: hash},
+			nil)
+		if err != nil {
+			t.Errorf("%s: getblock: unexpected error: %v", test.name,
+				err)
+			continue
+		}
+		blockResult := result.(btcjson.GetBlockVerboseResult)
+		if blockResult.Height != int64(want.Height) ||
+			blockResult.Confirmations != want.Confirmations ||
+			blockResult.MedianTime != want.MedianTime ||
+			blockResult.ChainWork != want.ChainWork ||
+			blockResult.NextHash != want.NextHash {
+
+			t.Errorf("%s: getblock: unexpected result - got %+v, "+
+				"want %+v", test.name, blockResult, want)
+		}
+	}
+}
+
+// TestHandleGetNetworkHashPS ensures the getnetworkhashps command estimates the
+// network hashes per second from the chain work and block timestamps of the
+// requested window, including windows which span the genesis block or a
+// difficulty retarget and windows without any time difference.
+func TestHandleGetNetworkHashPS(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chain, db, teardown := newRPCTestChain(t, params)
+	defer teardown()
+
+	// Create a main chain with blocks which are two seconds apart, except
+	// the block at height 3, which has the same timestamp as its parent,
+	// and the block at height 5, which is a second before its parent.  The
+	// blocks are generated so quickly that the difficulty increases at the
+	// first retarget.
+	const tipHeight = blockchain.BlocksPerRetarget + 4
+	genesisTime := params.GenesisBlock.Header.Timestamp
+	timestamps := map[int32]time.Time{
+		3: genesisTime.Add(time.Second * 4),
+		5: genesisTime.Add(time.Second * 7),
+	}
+	prevHash := params.GenesisHash
+	for height := int32(1); height <= tipHeight; height++ {
+		timestamp, ok := timestamps[height]
+		if !ok {
+			timestamp = genesisTime.Add(time.Second * 2 *
+				time.Duration(height))
+		}
+		block, err := newRPCTestBlock(params, prevHash, timestamp, height,
+			nil)
+		if err != nil {
+			t.Fatalf("unable to create block: %v", err)
+		}
+		bits, err := chain.CalcNextRequiredDifficulty(timestamp)
+		if err != nil {
+			t.Fatalf("CalcNextRequiredDifficulty: unexpected error: %v",
+				err)
+		}
+		if bits != params.PowLimitBits {
+			header := &block.MsgBlock().Header
+			header.Bits = bits
+			target := blockchain.CompactToBig(bits)
+			for {
+				hash := header.BlockSha()
+				if blockchain.ShaHashToBig(&hash).Cmp(target) <= 0 {
+					break
+				}
+				header.Nonce++
+			}
+			block = colxutil.NewBlock(block.MsgBlock())
+		}
+		_, err = chain.ProcessBlock(block, blockchain.BFNone)
+		if err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+		prevHash = block.Sha()
+	}
+
+	retargetHash, err := chain.BlockHashByHeight(blockchain.BlocksPerRetarget)
+	if err != nil {
+		t.Fatalf("BlockHashByHeight: unexpected error: %v", err)
+	}
+	retargetInfo, err := chain.BlockHeaderInfo(retargetHash)
+	if err != nil {
+		t.Fatalf("BlockHeaderInfo: unexpected error: %v", err)
+	}
+	if retargetInfo.Header.Bits == params.PowLimitBits {
+		t.Fatal("difficulty did not change at the retarget")
+	}
+
+	// hashesPerSec returns the expected estimate for a window with the
+	// passed number of blocks before and after the retarget and the passed
+	// number of seconds between its lowest and highest timestamps.
+	minWork := blockchain.CalcWork(params.PowLimitBits)
+	retargetWork := blockchain.CalcWork(retargetInfo.Header.Bits)
+	hashesPerSec := func(numMinBlocks, numRetargetBlocks, seconds int64) int64 {
+		work := new(big.Int).Mul(minWork, big.NewInt(numMinBlocks))
+		work.Add(work, new(big.Int).Mul(retargetWork,
+			big.NewInt(numRetargetBlocks)))
+		return work.Div(work, big.NewInt(seconds)).Int64()
+	}
+
+	s := &rpcServer{
+		server: &server{chainParams: params, db: db},
+		chain:  chain,
+		quit:   make(chan int),
+	}
+
+	intPtr := func(i int) *int {
+		return &i
+	}
+	tests := []struct {
+		name   string
+		blocks *int
+		height *int
+		want   int64
+	}{
+		{
+			name: "defaults",
+			want: hashesPerSec(115, 5, 240),
+		},
+		{
+			name:   "since retarget",
+			blocks: intPtr(-1),
+			want:   hashesPerSec(0, 5, 10),
+		},
+		{
+			name:   "retarget block",
+			blocks: intPtr(-1),
+			height: intPtr(int(blockchain.BlocksPerRetarget)),
+			want:   hashesPerSec(0, 1, 2),
+		},
+		{
+			name:   "before first retarget",
+			blocks: intPtr(-1),
+			height: intPtr(int(blockchain.BlocksPerRetarget - 1)),
+			want: hashesPerSec(int64(blockchain.BlocksPerRetarget-1),
+				0, int64(blockchain.BlocksPerRetarget-1)*2),
+		},
+		{
+			name:   "spans genesis",
+			blocks: intPtr(120),
+			height: intPtr(10),
+			want:   hashesPerSec(10, 0, 20),
+		},
+		{
+			name:   "timestamp before parent",
+			blocks: intPtr(1),
+			height: intPtr(5),
+			want:   hashesPerSec(1, 0, 1),
+		},
+		{
+			name:   "no time difference",
+			blocks: intPtr(1),
+			height: intPtr(3),
+			want:   0,
+		},
+		{
+			name:   "genesis",
+			height: intPtr(0),
+			want:   0,
+		},
+		{
+			name:   "past best block",
+			height: intPtr(int(tipHeight + 1)),
+			want:   0,
+		},
+	}
+
+	for _, test := range tests {
+		cmd := btcjson.NewGetNetworkHashPSCmd(test.blocks, test.height)
+		result, err := handleGetNetworkHashPS(s, cmd, nil)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if got, ok := result.(int64); !ok || got != test.want {
+			t.Errorf("%s: unexpected result - got %v, want %d",
+				test.name, result, test.want)
+		}
+	}
+}
+
+// TestRPCErrorCodes ensures representative failures of the major handler
+// families are returned with stable error codes and data over the JSON-RPC
+// interface, which is shared by HTTP POST and websocket clients.
+func TestRPCErrorCodes(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chain, db, teardown := newRPCTestChain(t, params)
+	defer teardown()
+
+	s := &rpcServer{
+		server: &server{chainParams: params, db: db},
+		chain:  chain,
+		quit:   make(chan int),
+	}
+
+	// reply returns the marshalled error of the reply to the passed
+	// request, or of the reply to the passed error when there is no
+	// request.
+	reply := func(method string, params []interface{}, replyErr error) *btcjson.RPCError {
+		if method != "" {
+			request, err := btcjson.NewRequest(1, method, params)
+			if err != nil {
+				t.Fatalf("%s: unable to create request: %v", method,
+					err)
+			}
+			cmd := parseCmd(request)
+			if cmd.err != nil {
+				replyErr = cmd.err
+			} else {
+				_, replyErr = s.standardCmdResult(cmd, nil)
+			}
+		}
+		marshalled, err := createMarshalledReply(1, nil, replyErr)
+		if err != nil {
+			t.Fatalf("%s: unable to marshal reply: %v", method, err)
+		}
+		var response struct {
+			Error *btcjson.RPCError `json:"error"`
+		}
+		if err := json.Unmarshal(marshalled, &response); err != nil {
+			t.Fatalf("%s: unable to unmarshal reply: %v", method, err)
+		}
+		return response.Error
+	}
+
+	missingHash := wire.ShaHash{0x01}
+	orphanErr := RuleError{Err: TxRuleError{
+		RejectCode:  wire.RejectDuplicate,
+		Description: "orphan transaction",
+		MissingInputs: []wire.OutPoint{
+			{Hash: missingHash, Index: 2},
+		},
+	}}
+	missingTxErr := chainRuleError(blockchain.RuleError{
+		ErrorCode:   blockchain.ErrMissingTx,
+		Description: "missing transaction",
+	})
+	tests := []struct {
+		name     string
+		method   string
+		params   []interface{}
+		err      error
+		wantCode btcjson.RPCErrorCode
+		wantData string
+	}{
+		{
+			name:     "unknown method",
+			method:   "nosuchmethod",
+			wantCode: btcjson.ErrRPCMethodNotFound.Code,
+		},
+		{
+			name:     "missing parameters",
+			method:   "getblock",
+			wantCode: btcjson.ErrRPCInvalidParams.Code,
+		},
+		{
+			name:     "wallet method",
+			method:   "getbalance",
+			wantCode: btcjson.ErrRPCNoWallet,
+		},
+		{
+			name:     "blockchain: block not found",
+			method:   "getblock",
+			params:   []interface{}{missingHash.String()},
+			wantCode: btcjson.ErrRPCBlockNotFound,
+		},
+		{
+			name:     "raw transactions: invalid hex",
+			method:   "decoderawtransaction",
+			params:   []interface{}{"zz"},
+			wantCode: btcjson.ErrRPCDecodeHexString,
+		},
+		{
+			name:     "raw transactions: malformed transaction",
+			method:   "sendrawtransaction",
+			params:   []interface{}{"00"},
+			wantCode: btcjson.ErrRPCDeserialization,
+		},
+		{
+			name:     "mining: malformed block",
+			method:   "submitblock",
+			params:   []interface{}{"00"},
+			wantCode: btcjson.ErrRPCDeserialization,
+		},
+		{
+			name:     "network: invalid subcommand",
+			method:   "addnode",
+			params:   []interface{}{"127.0.0.1", "bogus"},
+			wantCode: btcjson.ErrRPCInvalidParameter,
+		},
+		{
+			name:     "utility: invalid address",
+			method:   "verifymessage",
+			params:   []interface{}{"bogus", "sig", "msg"},
+			wantCode: btcjson.ErrRPCInvalidAddressOrKey,
+		},
+		{
+			name:     "transaction rejected by policy",
+			err:      rpcTxRejectedError(txRuleError(wire.RejectInsufficientFee, "insufficient fee")),
+			wantCode: btcjson.ErrRPCVerifyRejected,
+			wantData: `{"rejectcode":"REJECT_INSUFFICIENTFEE"}`,
+		},
+		{
+			name:     "transaction rejected by consensus rule",
+			err:      rpcTxRejectedError(missingTxErr),
+			wantCode: btcjson.ErrRPCVerifyRejected,
+			wantData: `{"errorcode":"ErrMissingTx","rejectcode":"REJECT_INVALID"}`,
+		},
+		{
+			name:     "transaction with missing inputs",
+			err:      rpcTxRejectedError(orphanErr),
+			wantCode: btcjson.ErrRPCVerify,
+			wantData: `{"missinginputs":["` + missingHash.String() +
+				`:2"],"rejectcode":"REJECT_DUPLICATE"}`,
+		},
+		{
+			name:     "transaction processing failure",
+			err:      rpcTxRejectedError(errors.New("database failure")),
+			wantCode: btcjson.ErrRPCVerify,
+			wantData: `{"rejectcode":"REJECT_INVALID"}`,
+		},
+		{
+			name:     "block processing failure",
+			err:      rpcBlockRejectedError(errors.New("database failure")),
+			wantCode: btcjson.ErrRPCVerify,
+			wantData: `{"reason":"rejected: database failure"}`,
+		},
+	}
+
+	for _, test := range tests {
+		rpcErr := reply(test.method, test.params, test.err)
+		if rpcErr == nil {
+			t.Errorf("%s: expected an error", test.name)
+			continue
+		}
+		if rpcErr.Code != test.wantCode {
+			t.Errorf("%s: unexpected error code - got %d, want %d",
+				test.name, rpcErr.Code, test.wantCode)
+		}
+		if _, ok := btcjson.LookupErrorCode(rpcErr.Code); !ok {
+			t.Errorf("%s: error code %d is not in the catalog",
+				test.name, rpcErr.Code)
+		}
+
+		var data string
+		if rpcErr.Data != nil {
+			marshalledData, err := json.Marshal(rpcErr.Data)
+			if err != nil {
+				t.Fatalf("%s: unable to marshal data: %v",
+					test.name, err)
+			}
+			data = string(marshalledData)
+		}
+		if data != test.wantData {
+			t.Errorf("%s: unexpected error data - got %s, want %s",
+				test.name, data, test.wantData)
+		}
+	}
+}
+
+// TestParseGbtRequestOptions ensures the capabilities reported by callers of
+// getblocktemplate select the expected variant of the block template and that
+// unsupported capabilities and coinbase transactions the server is unable to
+// pay are rejected.
+func TestParseGbtRequestOptions(t *testing.T) {
+	payAddr, err := colxutil.NewAddressPubKeyHash(make([]byte, 20),
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+
+	defer func(c *config) { cfg = c }(cfg)
+	tests := []struct {
+		name         string
+		capabilities []string
+		miningAddrs  []colxutil.Address
+		want         gbtRequestOptions
+		wantCode     btcjson.RPCErrorCode
+	}{
+		{
+			name: "no capabilities",
+			want: gbtRequestOptions{useCoinbaseValue: true},
+		},
+		{
+			name:         "coinbasevalue",
+			capabilities: []string{"coinbasevalue"},
+			want:         gbtRequestOptions{useCoinbaseValue: true},
+		},
+		{
+			name:         "coinbasetxn",
+			capabilities: []string{"coinbasetxn"},
+			miningAddrs:  []colxutil.Address{payAddr},
+			want:         gbtRequestOptions{},
+		},
+		{
+			name:         "coinbasetxn and coinbasevalue",
+			capabilities: []string{"coinbasetxn", "coinbasevalue"},
+			miningAddrs:  []colxutil.Address{payAddr},
+			want:         gbtRequestOptions{useCoinbaseValue: true},
+		},
+		{
+			name:         "coinbasetxn without mining addresses",
+			capabilities: []string{"coinbasetxn"},
+			wantCode:     btcjson.ErrRPCInternal.Code,
+		},
+		{
+			name:         "workid",
+			capabilities: []string{"longpoll", "workid"},
+			want: gbtRequestOptions{
+				useCoinbaseValue: true,
+				workID:           true,
+			},
+		},
+		{
+			name:         "unknown capabilities are ignored",
+			capabilities: []string{"proposal", "time/increment"},
+			want:         gbtRequestOptions{useCoinbaseValue: true},
+		},
+		{
+			name:         "serverlist",
+			capabilities: []string{"coinbasevalue", "serverlist"},
+			wantCode:     btcjson.ErrRPCInvalidParameter,
+		},
+	}
+
+	for _, test := range tests {
+		cfg = &config{miningAddrs: test.miningAddrs}
+		got, err := parseGbtRequestOptions(&btcjson.TemplateRequest{
+			Capabilities: test.capabilities,
+		})
+		if test.wantCode != 0 {
+			rpcErr, ok := err.(*btcjson.RPCError)
+			if !ok || rpcErr.Code != test.wantCode {
+				t.Errorf("%s: unexpected error - got %v, want "+
+					"code %d", test.name, err, test.wantCode)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: unexpected options - got %+v, want %+v",
+				test.name, got, test.want)
+		}
+	}
+
+	// A missing request is treated like a request without capabilities.
+	cfg = &config{}
+	got, err := parseGbtRequestOptions(nil)
+	if err != nil || got != (gbtRequestOptions{useCoinbaseValue: true}) {
+		t.Errorf("nil request: unexpected result - got %+v, %v", got,
+			err)
+	}
+}
+
+// TestGbtBlockTemplateResult ensures the block templates returned for the
+// different request options report the capabilities and mutations the server
+// supports for them, include a coinbase transaction or value and a work ID as
+// requested, and are cached separately until the template changes.
+func TestGbtBlockTemplateResult(t *testing.T) {
+	payAddr, err := colxutil.NewAddressPubKeyHash(make([]byte, 20),
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+	defer func(c *config) { cfg = c }(cfg)
+	cfg = &config{miningAddrs: []colxutil.Address{payAddr}}
+
+	coinbaseTx := wire.NewMsgTx()
+	coinbaseTx.AddTxIn(&wire.TxIn{
+		PreviousOutPoint: *wire.NewOutPoint(&wire.ShaHash{},
+			wire.MaxPrevOutIndex),
+		SignatureScript: []byte{0x51, 0x00},
+		Sequence:        wire.MaxTxInSequenceNum,
+	})
+	coinbaseTx.AddTxOut(wire.NewTxOut(5000000000, []byte{0x51}))
+	spendTx := wire.NewMsgTx()
+	spendTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: wire.ShaHash{0x01}},
+		nil))
+	spendTx.AddTxOut(wire.NewTxOut(1000, []byte{0x51}))
+
+	now := time.Unix(time.Now().Unix(), 0)
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{
+		Version:   4,
+		PrevBlock: wire.ShaHash{0x02},
+		Timestamp: now,
+		Bits:      0x207fffff,
+	})
+	msgBlock.AddTransaction(coinbaseTx)
+	msgBlock.AddTransaction(spendTx)
+	state := newGbtWorkState(blockchain.NewMedianTime())
+	state.template = &BlockTemplate{
+		Block:           msgBlock,
+		Fees:            []int64{-100, 100},
+		SigOpCounts:     []int64{1, 2},
+		Height:          1,
+		ValidPayAddress: true,
+	}
+	state.prevHash = &msgBlock.Header.PrevBlock
+	state.minTimestamp = now.Add(-time.Hour)
+
+	tests := []struct {
+		name        string
+		opts        gbtRequestOptions
+		wantMutable []string
+	}{
+		{
+			name:        "coinbase value",
+			opts:        gbtRequestOptions{useCoinbaseValue: true},
+			wantMutable: gbtCoinbaseValueMutableFields,
+		},
+		{
+			name:        "coinbase transaction",
+			opts:        gbtRequestOptions{},
+			wantMutable: gbtMutableFields,
+		},
+		{
+			name: "coinbase value with work id",
+			opts: gbtRequestOptions{
+				useCoinbaseValue: true,
+				workID:           true,
+			},
+			wantMutable: gbtCoinbaseValueMutableFields,
+		},
+	}
+
+	results := make(map[gbtRequestOptions]*btcjson.GetBlockTemplateResult)
+	for _, test := range tests {
+		result, err := state.blockTemplateResult(test.opts, nil)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		results[test.opts] = result
+
+		if !reflect.DeepEqual(result.Mutable, test.wantMutable) {
+			t.Errorf("%s: unexpected mutable fields - got %v, want "+
+				"%v", test.name, result.Mutable, test.wantMutable)
+		}
+		if !reflect.DeepEqual(result.Capabilities,
+			gbtCoinbaseTxnCapabilities) {
+
+			t.Errorf("%s: unexpected capabilities - got %v, want %v",
+				test.name, result.Capabilities,
+				gbtCoinbaseTxnCapabilities)
+		}
+		if test.opts.useCoinbaseValue {
+			if result.CoinbaseTxn != nil || result.CoinbaseValue == nil ||
+				*result.CoinbaseValue != 5000000000 {
+
+				t.Errorf("%s: unexpected coinbase - got txn %v, "+
+					"value %v", test.name, result.CoinbaseTxn,
+					result.CoinbaseValue)
+			}
+		} else {
+			if result.CoinbaseValue != nil || result.CoinbaseTxn == nil ||
+				result.CoinbaseTxn.Hash != coinbaseTx.TxSha().String() {
+
+				t.Errorf("%s: unexpected coinbase - got txn %v, "+
+					"value %v", test.name, result.CoinbaseTxn,
+					result.CoinbaseValue)
+			}
+		}
+		wantWorkID := ""
+		if test.opts.workID {
+			wantWorkID = result.LongPollID
+		}
+		if result.WorkID != wantWorkID {
+			t.Errorf("%s: unexpected work id - got %q, want %q",
+				test.name, result.WorkID, wantWorkID)
+		}
+		if len(result.Transactions) != 1 ||
+			result.Transactions[0].Hash != spendTx.TxSha().String() {
+
+			t.Errorf("%s: unexpected transactions %v", test.name,
+				result.Transactions)
+		}
+	}
+
+	// Repeated requests return the cached result of their variant along
+	// with whether old work may be submitted.
+	submitOld := true
+	for _, test := range tests {
+		result, err := state.blockTemplateResult(test.opts, &submitOld)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if result.SubmitOld == nil || !*result.SubmitOld {
+			t.Errorf("%s: submitold was not set", test.name)
+		}
+		result.SubmitOld = nil
+		result.MaxTime = results[test.opts].MaxTime
+		if !reflect.DeepEqual(result, results[test.opts]) {
+			t.Errorf("%s: cached result differs - got %+v, want %+v",
+				test.name, result, results[test.opts])
+		}
+		if &result.Transactions[0] != &results[test.opts].Transactions[0] {
+			t.Errorf("%s: result was not cached", test.name)
+		}
+	}
+
+	// Updating the template timestamp resets the cache.
+	msgBlock.Header.Timestamp = now.Add(time.Second)
+	opts := gbtRequestOptions{useCoinbaseValue: true}
+	result, err := state.blockTemplateResult(opts, nil)
+	if err != nil {
+		t.Fatalf("updated timestamp: unexpected error: %v", err)
+	}
+	if result.CurTime != msgBlock.Header.Timestamp.Unix() {
+		t.Errorf("updated timestamp: unexpected time - got %d, want %d",
+			result.CurTime, msgBlock.Header.Timestamp.Unix())
+	}
+	if &result.Transactions[0] == &results[opts].Transactions[0] {
+		t.Errorf("updated timestamp: stale result was returned")
+	}
+}
+
+// TestHandleGetBlockTemplateModes ensures getblocktemplate rejects unknown
+// modes and malformed block proposals with the expected error codes.
+func TestHandleGetBlockTemplateModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  *btcjson.TemplateRequest
+		wantCode btcjson.RPCErrorCode
+	}{
+		{
+			name:     "invalid mode",
+			request:  &btcjson.TemplateRequest{Mode: "bogus"},
+			wantCode: btcjson.ErrRPCInvalidParameter,
+		},
+		{
+			name:     "proposal without data",
+			request:  &btcjson.TemplateRequest{Mode: "proposal"},
+			wantCode: btcjson.ErrRPCType,
+		},
+		{
+			name: "proposal with invalid hex",
+			request: &btcjson.TemplateRequest{
+				Mode: "proposal",
+				Data: "zz",
+			},
+			wantCode: btcjson.ErrRPCDeserialization,
+		},
+		{
+			name: "proposal with malformed block",
+			request: &btcjson.TemplateRequest{
+				Mode: "proposal",
+				Data: "0000",
+			},
+			wantCode: btcjson.ErrRPCDeserialization,
+		},
+		{
+			name: "template with unsupported capability",
+			request: &btcjson.TemplateRequest{
+				Mode:         "template",
+				Capabilities: []string{"serverlist"},
+			},
+			wantCode: btcjson.ErrRPCInvalidParameter,
+		},
+	}
+
+	s := &rpcServer{}
+	for _, test := range tests {
+		cmd := btcjson.NewGetBlockTemplateCmd(test.request)
+		_, err := handleGetBlockTemplate(s, cmd, nil)
+		rpcErr, ok := err.(*btcjson.RPCError)
+		if !ok || rpcErr.Code != test.wantCode {
+			t.Errorf("%s: unexpected error - got %v, want code %d",
+				test.name, err, test.wantCode)
+		}
+	}
+}
+
+// TestHandleVersion ensures the version command reports the versions of the
+// components of the server and that the enabled features reflect the indexes
+// the server was created with and the prune state of the chain.
+func TestHandleVersion(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chain, db, teardown := newRPCTestChain(t, params)
+	defer teardown()
+
+	txIndex := indexers.NewTxIndex(db)
+	addrIndex := indexers.NewAddrIndex(db, params)
+	cfIndex := indexers.NewCfIndex(db, params)
+	tests := []struct {
+		name   string
+		server *server
+		want   btcjson.VersionFeaturesResult
+	}{
+		{
+			name:   "no indexes",
+			server: &server{},
+		},
+		{
+			name:   "transaction index",
+			server: &server{txIndex: txIndex},
+			want:   btcjson.VersionFeaturesResult{TxIndex: true},
+		},
+		{
+			name: "address index",
+			server: &server{
+				txIndex:   txIndex,
+				addrIndex: addrIndex,
+			},
+			want: btcjson.VersionFeaturesResult{
+				TxIndex:   true,
+				AddrIndex: true,
+			},
+		},
+		{
+			name: "all indexes",
+			server: &server{
+				txIndex:   txIndex,
+				addrIndex: addrIndex,
+				cfIndex:   cfIndex,
+			},
+			want: btcjson.VersionFeaturesResult{
+				TxIndex:   true,
+				AddrIndex: true,
+				CfIndex:   true,
+			},
+		},
+	}
+
+	// checkVersion ensures the version command run against the passed
+	// chain and server reports the expected features along with the
+	// versions of the components.
+	checkVersion := func(name string, chain *blockchain.BlockChain, srvr *server, want btcjson.VersionFeaturesResult) {
+		srvr.chainParams = params
+		srvr.db = db
+		s := &rpcServer{server: srvr, chain: chain}
+		result, err := handleVersion(s, &btcjson.VersionCmd{}, nil)
+		if err != nil {
+			t.Fatalf("%s: handleVersion: unexpected error: %v", name,
+				err)
+		}
+		got := result.(*btcjson.NodeVersionResult)
+		if got.Features != want {
+			t.Errorf("%s: unexpected features - got %+v, want %+v",
+				name, got.Features, want)
+		}
+		if got.Colxd.VersionString != version() ||
+			got.Colxd.Major != uint32(appMajor) ||
+			got.Colxd.Minor != uint32(appMinor) ||
+			got.Colxd.Patch != uint32(appPatch) {
+
+			t.Errorf("%s: unexpected server version %+v", name,
+				got.Colxd)
+		}
+		if got.Protocol.Major != peer.MaxProtocolVersion {
+			t.Errorf("%s: unexpected protocol version %+v", name,
+				got.Protocol)
+		}
+		dbVersion, _ := database.DriverVersion("ffldb")
+		if got.DatabaseBackend != "ffldb" ||
+			got.Database.VersionString != dbVersion {
+
+			t.Errorf("%s: unexpected database version %s %+v", name,
+				got.DatabaseBackend, got.Database)
+		}
+	}
+	for _, test := range tests {
+		checkVersion(test.name, chain, test.server, test.want)
+	}
+
+	// A chain which is pruned is reported even without a prune target in
+	// the configuration of the server.
+	prunedChain, err := blockchain.New(&blockchain.Config{
+		DB:          db,
+		ChainParams: params,
+		TimeSource:  blockchain.NewMedianTime(),
+		PruneTarget: 550,
+	})
+	if err != nil {
+		t.Fatalf("unable to create pruned chain: %v", err)
+	}
+	checkVersion("pruned chain", prunedChain, &server{txIndex: txIndex},
+		btcjson.VersionFeaturesResult{TxIndex: true, Pruning: true})
+
+	// The version command is available to limited users.
+	if _, ok := rpcLimited["version"]; !ok {
+		t.Errorf("version is not available to limited users")
+	}
+}
+
+// TestParseVersionResult ensures semantic version strings are parsed into
+// version results and that malformed versions are rejected.
+func TestParseVersionResult(t *testing.T) {
+	tests := []struct {
+		version string
+		want    btcjson.VersionResult
+		valid   bool
+	}{
+		{"1.0.0", btcjson.VersionResult{VersionString: "1.0.0", Major: 1}, true},
+		{"2.13.4", btcjson.VersionResult{VersionString: "2.13.4", Major: 2,
+			Minor: 13, Patch: 4}, true},
+		{"1.0", btcjson.VersionResult{}, false},
+		{"1.0.0-beta", btcjson.VersionResult{}, false},
+		{"", btcjson.VersionResult{}, false},
+	}
+
+	for _, test := range tests {
+		got, err := parseVersionResult(test.version)
+		if (err == nil) != test.valid {
+			t.Errorf("parseVersionResult(%q): unexpected error %v",
+				test.version, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseVersionResult(%q): got %+v, want %+v",
+				test.version, got, test.want)
+		}
+	}
+}
+
+// TestGbtLongPoll ensures getblocktemplate long poll requests made over HTTP
+// are held open until the template they refer to is stale or the long poll
+// timeout passes, and that requests of clients which disconnect are released
+// promptly.
+func TestGbtLongPoll(t *testing.T) {
+	defer func(c *config) { cfg = c }(cfg)
+	cfg = &config{SimNet: true, RPCLongPollTimeout: time.Minute}
+
+	// newTemplate returns a block template building on the passed hash
+	// which was generated for the passed long poll ID.
+	newTemplate := func(prevHash *wire.ShaHash, longPollID mining.LongPollID) *BlockTemplate {
+		coinbaseTx := wire.NewMsgTx()
+		coinbaseTx.AddTxIn(&wire.TxIn{
+			PreviousOutPoint: *wire.NewOutPoint(&wire.ShaHash{},
+				wire.MaxPrevOutIndex),
+			SignatureScript: []byte{0x51, 0x00},
+			Sequence:        wire.MaxTxInSequenceNum,
+		})
+		coinbaseTx.AddTxOut(wire.NewTxOut(5000000000, []byte{0x51}))
+		msgBlock := wire.NewMsgBlock(&wire.BlockHeader{
+			Version:   4,
+			PrevBlock: *prevHash,
+			Timestamp: time.Unix(time.Now().Unix(), 0),
+			Bits:      0x207fffff,
+		})
+		msgBlock.AddTransaction(coinbaseTx)
+		return &BlockTemplate{
+			Block:       msgBlock,
+			Fees:        []int64{0},
+			SigOpCounts: []int64{1},
+			Height:      1,
+			LongPollID:  longPollID,
+		}
+	}
+
+	// Create a server whose best chain tip matches the current template
+	// so requests don't generate new templates.
+	timeSource := blockchain.NewMedianTime()
+	notifier := mining.NewTemplateNotifier(0)
+	srvr := &server{
+		txMemPool:        newTxMemPool(&mempoolConfig{}),
+		timeSource:       timeSource,
+		templateNotifier: notifier,
+	}
+	srvr.blockManager = &blockManager{server: srvr}
+	chainState := &srvr.blockManager.chainState
+	tip := wire.ShaHash{0x01}
+	chainState.newestHash = &tip
+	notifier.NotifyBlockConnected(&tip, 0)
+
+	state := newGbtWorkState(timeSource)
+	state.template = newTemplate(&tip, notifier.Current())
+	state.prevHash = &tip
+	state.minTimestamp = time.Now().Add(-time.Hour)
+	s := &rpcServer{
+		server:       srvr,
+		gbtWorkState: state,
+		statusLines:  make(map[int]string),
+	}
+	httpServer := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			s.jsonRPCRead(w, r, true)
+		}))
+	defer httpServer.Close()
+	client := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+
+	// marshalRequest returns a getblocktemplate request with the passed
+	// long poll ID.
+	marshalRequest := func(longPollID string) []byte {
+		cmd := btcjson.NewGetBlockTemplateCmd(&btcjson.TemplateRequest{
+			LongPollID: longPollID,
+		})
+		body, err := btcjson.MarshalCmd(1, cmd)
+		if err != nil {
+			t.Fatalf("MarshalCmd: unexpected error: %v", err)
+		}
+		return body
+	}
+
+	// getTemplate requests a block template with the passed long poll ID
+	// and returns it along with how long the request took.
+	getTemplate := func(longPollID string) (*btcjson.GetBlockTemplateResult, time.Duration) {
+		start := time.Now()
+		resp, err := client.Post(httpServer.URL, "application/json",
+			bytes.NewReader(marshalRequest(longPollID)))
+		if err != nil {
+			t.Fatalf("Post: unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+		var reply struct {
+			Result *btcjson.GetBlockTemplateResult `json:"result"`
+			Error  *btcjson.RPCError               `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+			t.Fatalf("unable to decode reply: %v", err)
+		}
+		if reply.Error != nil || reply.Result == nil {
+			t.Fatalf("getblocktemplate: unexpected error: %v",
+				reply.Error)
+		}
+		return reply.Result, time.Since(start)
+	}
+
+	// waitForPollers waits until the passed number of long poll requests
+	// are subscribed to template notifications.
+	waitForPollers := func(want int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got := notifier.NumSubscribers()
+			if got == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("unexpected number of long poll "+
+					"requests - got %d, want %d", got, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	result, _ := getTemplate("")
+	wantID := mining.LongPollID{PrevHash: tip, Counter: 1}.String()
+	if result.LongPollID != wantID {
+		t.Fatalf("unexpected long poll ID - got %s, want %s",
+			result.LongPollID, wantID)
+	}
+
+	// A long poll request is answered once the chain tip changes.
+	type longPollResult struct {
+		result  *btcjson.GetBlockTemplateResult
+		elapsed time.Duration
+	}
+	results := make(chan longPollResult)
+	go func() {
+		result, elapsed := getTemplate(wantID)
+		results <- longPollResult{result, elapsed}
+	}()
+	waitForPollers(1)
+	const tipChangeDelay = 200 * time.Millisecond
+	time.Sleep(tipChangeDelay)
+	newTip := wire.ShaHash{0x02}
+	chainState.Lock()
+	chainState.newestHash = &newTip
+	chainState.Unlock()
+	state.Lock()
+	state.template = newTemplate(&newTip,
+		mining.LongPollID{PrevHash: newTip, Counter: 2})
+	state.prevHash = &newTip
+	state.Unlock()
+	notifier.NotifyBlockConnected(&newTip, 0)
+
+	select {
+	case r := <-results:
+		wantID = mining.LongPollID{PrevHash: newTip, Counter: 2}.String()
+		if r.result.LongPollID != wantID {
+			t.Fatalf("unexpected long poll ID after tip change - "+
+				"got %s, want %s", r.result.LongPollID, wantID)
+		}
+		if r.result.SubmitOld == nil || *r.result.SubmitOld {
+			t.Fatalf("submitold was not false after tip change")
+		}
+		if r.elapsed < tipChangeDelay {
+			t.Fatalf("long poll returned before the tip changed "+
+				"(%v)", r.elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("long poll was not answered after tip change")
+	}
+	waitForPollers(0)
+
+	// A long poll request is answered with the current template once the
+	// long poll timeout passes.
+	cfg.RPCLongPollTimeout = 300 * time.Millisecond
+	result, elapsed := getTemplate(wantID)
+	if elapsed < cfg.RPCLongPollTimeout {
+		t.Fatalf("long poll returned before the timeout (%v)", elapsed)
+	}
+	if result.LongPollID != wantID {
+		t.Fatalf("unexpected long poll ID after timeout - got %s, "+
+			"want %s", result.LongPollID, wantID)
+	}
+	if result.SubmitOld == nil || !*result.SubmitOld {
+		t.Fatalf("submitold was not true after timeout")
+	}
+	waitForPollers(0)
+
+	// The request of a client which disconnects while long polling is
+	// released without leaking goroutines.
+	cfg.RPCLongPollTimeout = time.Minute
+	numGoroutines := runtime.NumGoroutine()
+	conn, err := net.Dial("tcp", httpServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: unexpected error: %v", err)
+	}
+	body := marshalRequest(wantID)
+	fmt.Fprintf(conn, "POST / HTTP/1.1\r\nHost: localhost\r\n"+
+		"Content-Type: application/json\r\nContent-Length: %d\r\n\r\n%s",
+		len(body), body)
+	waitForPollers(1)
+	conn.Close()
+	waitForPollers(0)
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > numGoroutines {
+		if time.Now().After(deadline) {
+			t.Fatalf("goroutines leaked after the client "+
+				"disconnected - got %d, want %d",
+				runtime.NumGoroutine(), numGoroutines)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestHandleGetBlockTemplateProposal ensures block proposals are validated
+// against the current tip of the main chain and rejected with the reasons
+// described in BIP0022 without modifying the chain or the memory pool.
+func TestHandleGetBlockTemplateProposal(t *testing.T) {
+	h := newTemplateHarness(t, 1)
+	defer h.teardown()
+	tx := h.addTx(nil, 10000)
+
+	policy := &mining.Policy{
+		BlockMaxSize: wire.MaxBlockPayload,
+		TxMinFreeFee: 1000,
+	}
+	template, err := NewBlockTemplate(policy, h.server, nil)
+	if err != nil {
+		t.Fatalf("NewBlockTemplate: unexpected error: %v", err)
+	}
+	if len(template.Block.Transactions) != 2 {
+		t.Fatalf("unexpected number of template transactions - got %d, "+
+			"want 2", len(template.Block.Transactions))
+	}
+
+	chain := h.server.blockManager.chain
+	best := chain.BestSnapshot()
+	tipBlock, err := chain.BlockByHash(best.Hash)
+	if err != nil {
+		t.Fatalf("BlockByHash: unexpected error: %v", err)
+	}
+	stalePrev := tipBlock.MsgBlock().Header.PrevBlock
+	badMerkleRoot := wire.ShaHash{0x01}
+
+	tests := []struct {
+		name   string
+		modify func(header *wire.BlockHeader)
+		want   interface{}
+	}{
+		{
+			name:   "valid",
+			modify: func(header *wire.BlockHeader) {},
+			want:   nil,
+		},
+		{
+			name: "bad merkle root",
+			modify: func(header *wire.BlockHeader) {
+				header.MerkleRoot = badMerkleRoot
+			},
+			want: "bad-txnmrklroot",
+		},
+		{
+			name: "stale previous block",
+			modify: func(header *wire.BlockHeader) {
+				header.PrevBlock = stalePrev
+			},
+			want: "bad-prevblk",
+		},
+	}
+
+	s := &rpcServer{server: h.server, chain: chain}
+	for _, test := range tests {
+		msgBlock := *template.Block
+		test.modify(&msgBlock.Header)
+		var buf bytes.Buffer
+		if err := msgBlock.Serialize(&buf); err != nil {
+			t.Fatalf("%s: Serialize: unexpected error: %v", test.name,
+				err)
+		}
+		request := &btcjson.TemplateRequest{
+			Mode: "proposal",
+			Data: hex.EncodeToString(buf.Bytes()),
+		}
+		result, err := handleGetBlockTemplate(s,
+			btcjson.NewGetBlockTemplateCmd(request), nil)
+		if err != nil {
+			t.Fatalf("%s: handleGetBlockTemplate: unexpected error: %v",
+				test.name, err)
+		}
+		if result != test.want {
+			t.Fatalf("%s: unexpected result - got %v, want %v",
+				test.name, result, test.want)
+		}
+
+		// The proposal neither changes the chain nor the memory pool.
+		msgBlock.Header = template.Block.Header
+		test.modify(&msgBlock.Header)
+		blockHash := msgBlock.Header.BlockSha()
+		if have, err := chain.HaveBlock(&blockHash); err != nil || have {
+			t.Fatalf("%s: proposed block was added to the chain",
+				test.name)
+		}
+		if got := chain.BestSnapshot(); !got.Hash.IsEqual(best.Hash) {
+			t.Fatalf("%s: best chain changed to %v", test.name,
+				got.Hash)
+		}
+		if !h.server.txMemPool.HaveTransaction(tx.Sha()) {
+			t.Fatalf("%s: transaction was removed from the memory "+
+				"pool", test.name)
+		}
+	}
+
+	// The rule error for a stale previous block has its own code.
+	msgBlock := *template.Block
+	msgBlock.Header.PrevBlock = stalePrev
+	err = CheckBlockTemplate(colxutil.NewBlock(&msgBlock),
+		h.server.blockManager)
+	var ruleErr blockchain.RuleError
+	if !errors.As(err, &ruleErr) ||
+		ruleErr.ErrorCode != blockchain.ErrPrevBlockNotBest {
+
+		t.Fatalf("CheckBlockTemplate: unexpected error %v, want %v", err,
+			blockchain.ErrPrevBlockNotBest)
+	}
+}
+
+// TestHandlePrioritiseTransaction ensures the prioritisetransaction RPC sets
+// fee deltas, including for transactions which are not in the memory pool yet,
+// and that getmempoolentry and getrawmempool report the modified fee.
+func TestHandlePrioritiseTransaction(t *testing.T) {
+	h := newTemplateHarness(t, 2)
+	defer h.teardown()
+	chain := h.server.blockManager.chain
+	mp := h.server.txMemPool
+	mp.cfg.FetchUtxoView = chain.FetchUtxoView
+	s := &rpcServer{server: h.server, chain: chain}
+
+	// Set a fee delta for a transaction which is not in the pool yet.
+	tx := h.addTx(nil, 10000)
+	mp.RemoveTransaction(tx, false)
+	prioritise := func(delta int64) {
+		cmd := btcjson.NewPrioritiseTransactionCmd(tx.Sha().String(),
+			delta)
+		result, err := handlePrioritiseTransaction(s, cmd, nil)
+		if err != nil {
+			t.Fatalf("handlePrioritiseTransaction: unexpected error: "+
+				"%v", err)
+		}
+		if result != true {
+			t.Fatalf("handlePrioritiseTransaction: unexpected result "+
+				"%v", result)
+		}
+	}
+	prioritise(5000)
+
+	// The entry is not found while the transaction is not in the pool.
+	entryCmd := btcjson.NewGetMempoolEntryCmd(tx.Sha().String())
+	_, err := handleGetMempoolEntry(s, entryCmd, nil)
+	if jerr, ok := err.(*btcjson.RPCError); !ok ||
+		jerr.Code != btcjson.ErrRPCNoTxInfo {
+
+		t.Fatalf("handleGetMempoolEntry: unexpected error for missing "+
+			"transaction - got %v, want code %v", err,
+			btcjson.ErrRPCNoTxInfo)
+	}
+
+	// The fee delta applies once the transaction arrives.
+	mp.Lock()
+	mp.addTransaction(blockchain.NewUtxoViewpoint(), tx,
+		h.server.blockManager.chainState.newestHeight, 10000, false)
+	mp.Unlock()
+	checkFees := func(wantModifiedFee float64) {
+		result, err := handleGetMempoolEntry(s, entryCmd, nil)
+		if err != nil {
+			t.Fatalf("handleGetMempoolEntry: unexpected error: %v",
+				err)
+		}
+		entry := result.(*btcjson.GetRawMempoolVerboseResult)
+		if entry.Fee != 0.0001 || entry.ModifiedFee != wantModifiedFee {
+			t.Fatalf("handleGetMempoolEntry: unexpected fees - got "+
+				"%v and modified %v, want 0.0001 and modified %v",
+				entry.Fee, entry.ModifiedFee, wantModifiedFee)
+		}
+
+		verbose := true
+		result, err = handleGetRawMempool(s,
+			btcjson.NewGetRawMempoolCmd(&verbose), nil)
+		if err != nil {
+			t.Fatalf("handleGetRawMempool: unexpected error: %v", err)
+		}
+		entries := result.(map[string]*btcjson.GetRawMempoolVerboseResult)
+		if !reflect.DeepEqual(entries[tx.Sha().String()], entry) {
+			t.Fatalf("handleGetRawMempool: unexpected entry %v, want "+
+				"%v", entries[tx.Sha().String()], entry)
+		}
+	}
+	checkFees(0.00015)
+
+	// The fee delta is replaced by a new one and cleared by zero.
+	prioritise(-10000)
+	checkFees(0)
+	prioritise(0)
+	checkFees(0.0001)
+
+	// Invalid transaction hashes are rejected.
+	cmd := btcjson.NewPrioritiseTransactionCmd("nothex", 1)
+	_, err = handlePrioritiseTransaction(s, cmd, nil)
+	if jerr, ok := err.(*btcjson.RPCError); !ok ||
+		jerr.Code != btcjson.ErrRPCDecodeHexString {
+
+		t.Fatalf("handlePrioritiseTransaction: unexpected error for "+
+			"invalid hash - got %v, want code %v", err,
+			btcjson.ErrRPCDecodeHexString)
+	}
+}
+
+// TestHandleGetAddressQueries ensures the getaddressbalance, getaddressutxos,
+// and getaddressdeltas commands report the history of an address which is
+// paid to and spent from repeatedly both before and after a reorganize which
+// replaces a spend, that multiple addresses are deduplicated and sorted, and
+// that invalid requests are rejected.
+func TestHandleGetAddressQueries(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	var addrIndex *indexers.AddrIndex
+	chain, db, teardown := newRPCIndexedTestChain(t, params,
+		func(db database.DB) []indexers.Indexer {
+			addrIndex = indexers.NewAddrIndex(db, params)
+			return []indexers.Indexer{indexers.NewTxIndex(db), addrIndex}
+		})
+	defer teardown()
+
+	s := &rpcServer{
+		server: &server{chainParams: params, db: db, addrIndex: addrIndex},
+		chain:  chain,
+		quit:   make(chan int),
+	}
+
+	// Address A is controlled by a key so its outputs can be spent, and
+	// also has a pay-to-pubkey form which refers to the same outputs.
+	privKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatalf("unable to create key: %v", err)
+	}
+	serializedPubKey := privKey.PubKey().SerializeCompressed()
+	addrA, err := colxutil.NewAddressPubKeyHash(
+		colxutil.Hash160(serializedPubKey), params)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+	addrAPubKey, err := colxutil.NewAddressPubKey(serializedPubKey, params)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+	addrB, err := colxutil.NewAddressPubKeyHash(make([]byte, 20), params)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+	scriptA, _ := txscript.PayToAddrScript(addrA)
+	scriptB, _ := txscript.PayToAddrScript(addrB)
+	a, b := addrA.EncodeAddress(), addrB.EncodeAddress()
+
+	// addBlock connects a block at the passed height on top of the block
+	// with the passed hash whose coinbase pays to the passed scripts and
+	// which includes the passed transactions.
+	genesisTime := params.GenesisBlock.Header.Timestamp
+	addBlock := func(prevHash *wire.ShaHash, height int32, offset time.Duration, payScripts [][]byte, txns ...*wire.MsgTx) *colxutil.Block {
+		block, err := newRPCTestBlock(params, prevHash,
+			genesisTime.Add(time.Minute*10*time.Duration(height)+
+				offset), height, payScripts)
+		if err != nil {
+			t.Fatalf("unable to create block: %v", err)
+		}
+		if len(txns) > 0 {
+			msgBlock := block.MsgBlock()
+			utilTxns := []*colxutil.Tx{block.Transactions()[0]}
+			for _, tx := range txns {
+				msgBlock.AddTransaction(tx)
+				utilTxns = append(utilTxns, colxutil.NewTx(tx))
+			}
+			merkles := blockchain.BuildMerkleTreeStore(utilTxns)
+			msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+			target := blockchain.CompactToBig(msgBlock.Header.Bits)
+			for {
+				hash := msgBlock.Header.BlockSha()
+				if blockchain.ShaHashToBig(&hash).Cmp(target) <= 0 {
+					break
+				}
+				msgBlock.Header.Nonce++
+			}
+			block = colxutil.NewBlock(msgBlock)
+		}
+		if _, err := chain.ProcessBlock(block, blockchain.BFNone); err != nil {
+			t.Fatalf("ProcessBlock: unexpected error: %v", err)
+		}
+		return block
+	}
+
+	// spend returns a transaction which spends the passed output paying to
+	// address A to 0.4 and 0.5 coins paying to addresses A and B
+	// respectively when the passed flag is set, or 0.3 and 0.6 otherwise.
+	spend := func(prevOut *wire.OutPoint, first bool) *wire.MsgTx {
+		tx := wire.NewMsgTx()
+		tx.AddTxIn(wire.NewTxIn(prevOut, nil))
+		amountA, amountB := int64(3e7), int64(6e7)
+		if first {
+			amountA, amountB = 4e7, 5e7
+		}
+		tx.AddTxOut(wire.NewTxOut(amountA, scriptA))
+		tx.AddTxOut(wire.NewTxOut(amountB, scriptB))
+		sigScript, err := txscript.SignatureScript(tx, 0, scriptA,
+			txscript.SigHashAll, privKey, true)
+		if err != nil {
+			t.Fatalf("unable to sign transaction: %v", err)
+		}
+		tx.TxIn[0].SignatureScript = sigScript
+		return tx
+	}
+
+	// Pay to address A in the first block and to both addresses in the
+	// second one, then spend the output of the first block once it is
+	// mature and pay to address A once more.
+	blocks := []*colxutil.Block{addBlock(params.GenesisHash, 1, 0,
+		[][]byte{scriptA})}
+	blocks = append(blocks, addBlock(blocks[0].Sha(), 2, 0,
+		[][]byte{scriptA, scriptB}))
+	for height := int32(3); height <= 101; height++ {
+		blocks = append(blocks, addBlock(blocks[height-2].Sha(), height,
+			0, nil))
+	}
+	coinbase1 := blocks[0].Transactions()[0].Sha()
+	coinbase2 := blocks[1].Transactions()[0].Sha()
+	tx1 := spend(wire.NewOutPoint(coinbase1, 0), true)
+	block102 := addBlock(blocks[100].Sha(), 102, 0, nil, tx1)
+	block103 := addBlock(block102.Sha(), 103, 0, [][]byte{scriptA})
+	tx1Hash := tx1.TxSha()
+	coinbase103 := block103.Transactions()[0].Sha()
+
+	assertResult := func(desc string, result interface{}, err error, want interface{}) {
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", desc, err)
+		}
+		if !reflect.DeepEqual(result, want) {
+			gotJSON, _ := json.Marshal(result)
+			wantJSON, _ := json.Marshal(want)
+			t.Fatalf("%s: unexpected result -\ngot  %s\nwant %s", desc,
+				gotJSON, wantJSON)
+		}
+	}
+	best := func() (string, int32) {
+		snapshot := chain.BestSnapshot()
+		return snapshot.Hash.String(), snapshot.Height
+	}
+	balance := func(addresses ...string) (interface{}, error) {
+		return handleGetAddressBalance(s,
+			btcjson.NewGetAddressBalanceCmd(addresses), nil)
+	}
+	utxos := func(skip, count int, addresses ...string) (interface{}, error) {
+		return handleGetAddressUtxos(s, btcjson.NewGetAddressUtxosCmd(
+			addresses, &skip, &count), nil)
+	}
+	deltas := func(start, end *int32, addresses ...string) (interface{}, error) {
+		return handleGetAddressDeltas(s, btcjson.NewGetAddressDeltasCmd(
+			addresses, start, end), nil)
+	}
+	utxo := func(addr string, hash *wire.ShaHash, index uint32, amount int64, height int32, coinbase bool) btcjson.AddressUtxoResult {
+		pkScript := scriptA
+		if addr == b {
+			pkScript = scriptB
+		}
+		return btcjson.AddressUtxoResult{
+			Address:     addr,
+			TxID:        hash.String(),
+			OutputIndex: index,
+			Script:      hex.EncodeToString(pkScript),
+			Satoshis:    amount,
+			Height:      height,
+			Coinbase:    coinbase,
+		}
+	}
+	delta := func(addr string, hash *wire.ShaHash, index uint32, amount int64, height int32) btcjson.AddressDeltaResult {
+		return btcjson.AddressDeltaResult{
+			Address:  addr,
+			TxID:     hash.String(),
+			Index:    index,
+			Satoshis: amount,
+			Height:   height,
+		}
+	}
+
+	// Balances of the main chain, both for the addresses on their own and
+	// combined, where the pay-to-pubkey form of address A is a duplicate.
+	hash, height := best()
+	result, err := balance(a)
+	assertResult("balance of A", result, err, &btcjson.GetAddressBalanceResult{
+		Balance: 24e7, Received: 34e7, Hash: hash, Height: height})
+	result, err = balance(b)
+	assertResult("balance of B", result, err, &btcjson.GetAddressBalanceResult{
+		Balance: 25e7, Received: 25e7, Hash: hash, Height: height})
+	result, err = balance(b, a, addrAPubKey.String())
+	assertResult("combined balance", result, err, &btcjson.GetAddressBalanceResult{
+		Balance: 49e7, Received: 59e7, Hash: hash, Height: height})
+
+	// Unspent outputs of the main chain along with a page of them.
+	wantUtxos := []btcjson.AddressUtxoResult{
+		utxo(a, coinbase2, 0, 1e8, 2, true),
+		utxo(b, coinbase2, 1, 2e8, 2, true),
+		utxo(a, &tx1Hash, 0, 4e7, 102, false),
+		utxo(b, &tx1Hash, 1, 5e7, 102, false),
+		utxo(a, coinbase103, 0, 1e8, 103, true),
+	}
+	result, err = utxos(0, 100, b, addrAPubKey.String(), a)
+	assertResult("utxos", result, err, &btcjson.GetAddressUtxosResult{
+		Utxos: wantUtxos, Total: 5, Hash: hash, Height: height})
+	result, err = utxos(1, 2, a, b)
+	assertResult("utxos page", result, err, &btcjson.GetAddressUtxosResult{
+		Utxos: wantUtxos[1:3], Total: 5, Hash: hash, Height: height})
+	result, err = utxos(10, 2, a, b)
+	assertResult("utxos past end", result, err, &btcjson.GetAddressUtxosResult{
+		Utxos: []btcjson.AddressUtxoResult{}, Total: 5, Hash: hash,
+		Height: height})
+
+	// Deltas of the main chain, where the spend of the first output comes
+	// before the outputs of the same transaction.
+	result, err = deltas(nil, nil, a, b)
+	assertResult("deltas", result, err, &btcjson.GetAddressDeltasResult{
+		Deltas: []btcjson.AddressDeltaResult{
+			delta(a, coinbase1, 0, 1e8, 1),
+			delta(a, coinbase2, 0, 1e8, 2),
+			delta(b, coinbase2, 1, 2e8, 2),
+			delta(a, &tx1Hash, 0, -1e8, 102),
+			delta(a, &tx1Hash, 0, 4e7, 102),
+			delta(b, &tx1Hash, 1, 5e7, 102),
+			delta(a, coinbase103, 0, 1e8, 103),
+		},
+		Hash:   hash,
+		Height: height,
+	})
+	start, end := int32(102), int32(102)
+	result, err = deltas(&start, &end, a)
+	assertResult("deltas in range", result, err, &btcjson.GetAddressDeltasResult{
+		Deltas: []btcjson.AddressDeltaResult{
+			delta(a, &tx1Hash, 0, -1e8, 102),
+			delta(a, &tx1Hash, 0, 4e7, 102),
+		},
+		Hash:   hash,
+		Height: height,
+	})
+
+	// Reorganize to a side chain which forks after block 101 and spends
+	// the output of the second block instead of the first one.
+	tx2 := spend(wire.NewOutPoint(coinbase2, 0), false)
+	side102 := addBlock(blocks[100].Sha(), 102, time.Minute, nil, tx2)
+	side103 := addBlock(side102.Sha(), 103, time.Minute, nil)
+	addBlock(side103.Sha(), 104, time.Minute, nil)
+	tx2Hash := tx2.TxSha()
+	hash, height = best()
+	if height != 104 {
+		t.Fatalf("chain did not reorganize to the side chain")
+	}
+
+	result, err = balance(a, b)
+	assertResult("balance after reorganize", result, err,
+		&btcjson.GetAddressBalanceResult{Balance: 39e7, Received: 49e7,
+			Hash: hash, Height: height})
+	result, err = balance(a)
+	assertResult("balance of A after reorganize", result, err,
+		&btcjson.GetAddressBalanceResult{Balance: 13e7, Received: 23e7,
+			Hash: hash, Height: height})
+	result, err = utxos(0, 100, a)
+	assertResult("utxos after reorganize", result, err,
+		&btcjson.GetAddressUtxosResult{
+			Utxos: []btcjson.AddressUtxoResult{
+				utxo(a, coinbase1, 0, 1e8, 1, true),
+				utxo(a, &tx2Hash, 0, 3e7, 102, false),
+			},
+			Total:  2,
+			Hash:   hash,
+			Height: height,
+		})
+	result, err = deltas(nil, nil, b, a)
+	assertResult("deltas after reorganize", result, err,
+		&btcjson.GetAddressDeltasResult{
+			Deltas: []btcjson.AddressDeltaResult{
+				delta(a, coinbase1, 0, 1e8, 1),
+				delta(a, coinbase2, 0, 1e8, 2),
+				delta(b, coinbase2, 1, 2e8, 2),
+				delta(a, &tx2Hash, 0, -1e8, 102),
+				delta(a, &tx2Hash, 0, 3e7, 102),
+				delta(b, &tx2Hash, 1, 6e7, 102),
+			},
+			Hash:   hash,
+			Height: height,
+		})
+
+	// Invalid requests.
+	assertError := func(desc string, err error, code btcjson.RPCErrorCode) {
+		rpcErr, ok := err.(*btcjson.RPCError)
+		if !ok || rpcErr.Code != code {
+			t.Fatalf("%s: unexpected error - got %v, want code %v",
+				desc, err, code)
+		}
+	}
+	_, err = balance()
+	assertError("no addresses", err, btcjson.ErrRPCInvalidParameter)
+	_, err = utxos(0, 10, a, "bogus")
+	assertError("invalid address", err, btcjson.ErrRPCInvalidAddressOrKey)
+	_, err = utxos(0, -1, a)
+	assertError("negative count", err, btcjson.ErrRPCInvalidParameter)
+	start, end = 10, 5
+	_, err = deltas(&start, &end, a)
+	assertError("inverted range", err, btcjson.ErrRPCInvalidParameter)
+
+	// The commands require the address index.
+	s.server.addrIndex = nil
+	for name, handler := range map[string]commandHandler{
+		"getaddressbalance": handleGetAddressBalance,
+		"getaddressdeltas":  handleGetAddressDeltas,
+		"getaddressutxos":   handleGetAddressUtxos,
+	} {
+		cmd, err := btcjson.NewCmd(name, []string{a})
+		if err != nil {
+			t.Fatalf("%s: unable to create command: %v", name, err)
+		}
+		_, err = handler(s, cmd, nil)
+		assertError(name+" without index", err, btcjson.ErrRPCMisc)
+		if _, ok := rpcLimited[name]; !ok {
+			t.Errorf("%s is not available to limited users", name)
+		}
+	}
+}
@@ -113,6 +113,12 @@ var helpDescsEnUS = map[string]string{
 	"decodescript--synopsis": "Returns a JSON object with information about the provided hex-encoded script.",
 	"decodescript-hexscript": "Hex-encoded script",
 
+	// EstimateFeeCmd help.
+	"estimatefee--synopsis": "Estimates the fee per kilobyte a transaction needs to pay to be mined within the provided number of blocks.\n" +
+		"The estimate is based on how long it took the transactions observed in the memory pool to be mined.",
+	"estimatefee-numblocks": "The desired maximum number of blocks until the transaction is mined (targets above 25 blocks are treated as 25)",
+	"estimatefee--result0":  "The estimated fee in bitcoins per kilobyte, or -1 when not enough transactions have been observed",
+
 	// GenerateCmd help
 	"generate--synopsis": "Generates a set number of blocks (simnet or regtest only) and returns a JSON\n" +
 		" array of their hashes.",
@@ -136,6 +142,58 @@ var helpDescsEnUS = map[string]string{
 	"getaddednodeinfo--condition1": "dns=true",
 	"getaddednodeinfo--result0":    "List of added peers",
 
+	// GetAddressBalanceCmd help.
+	"getaddressbalance--synopsis": "Returns the balance of the addresses along with the total they have received, in satoshis.\n" +
+		"Requires the address index (--addrindex).",
+	"getaddressbalance-addresses": "The addresses, duplicates of which are ignored",
+
+	// GetAddressBalanceResult help.
+	"getaddressbalanceresult-balance":  "The total of the unspent outputs paying to the addresses, in satoshis",
+	"getaddressbalanceresult-received": "The total of all outputs ever paying to the addresses, in satoshis",
+	"getaddressbalanceresult-hash":     "The hash of the block the balance is as of",
+	"getaddressbalanceresult-height":   "The height of the block the balance is as of",
+
+	// GetAddressDeltasCmd help.
+	"getaddressdeltas--synopsis": "Returns the credits to and debits from the addresses in the main chain, in the order they appear in it.\n" +
+		"Requires the address index (--addrindex).",
+	"getaddressdeltas-addresses": "The addresses, duplicates of which are ignored",
+	"getaddressdeltas-start":     "The height of the first block to return deltas for",
+	"getaddressdeltas-end":       "The height of the last block to return deltas for (default: the best block)",
+
+	// AddressDeltaResult help.
+	"addressdeltaresult-address":  "The address credited or debited",
+	"addressdeltaresult-txid":     "The hash of the transaction",
+	"addressdeltaresult-index":    "The index of the output for a credit or of the input for a debit",
+	"addressdeltaresult-satoshis": "The amount credited, which is negative for a debit",
+	"addressdeltaresult-height":   "The height of the block which contains the transaction",
+
+	// GetAddressDeltasResult help.
+	"getaddressdeltasresult-deltas": "The credits and debits",
+	"getaddressdeltasresult-hash":   "The hash of the block the deltas are as of",
+	"getaddressdeltasresult-height": "The height of the block the deltas are as of",
+
+	// GetAddressUtxosCmd help.
+	"getaddressutxos--synopsis": "Returns the unspent outputs paying to the addresses in the order they appear in the main chain.\n" +
+		"Requires the address index (--addrindex).",
+	"getaddressutxos-addresses": "The addresses, duplicates of which are ignored",
+	"getaddressutxos-skip":      "The number of leading outputs to skip",
+	"getaddressutxos-count":     "The maximum number of outputs to return",
+
+	// AddressUtxoResult help.
+	"addressutxoresult-address":     "The address the output pays to",
+	"addressutxoresult-txid":        "The hash of the transaction",
+	"addressutxoresult-outputIndex": "The index of the output",
+	"addressutxoresult-script":      "The hex-encoded public key script of the output",
+	"addressutxoresult-satoshis":    "The amount of the output in satoshis",
+	"addressutxoresult-height":      "The height of the block which contains the transaction",
+	"addressutxoresult-coinbase":    "Whether or not the output is part of a coinbase",
+
+	// GetAddressUtxosResult help.
+	"getaddressutxosresult-utxos":  "The unspent outputs",
+	"getaddressutxosresult-total":  "The number of unspent outputs before skipping and limiting them",
+	"getaddressutxosresult-hash":   "The hash of the block the outputs are as of",
+	"getaddressutxosresult-height": "The height of the block the outputs are as of",
+
 	// GetBestBlockResult help.
 	"getbestblockresult-hash":   "Hex-encoded bytes of the best block hash",
 	"getbestblockresult-height": "Height of the best block",
@@ -183,7 +241,7 @@ var helpDescsEnUS = map[string]string{
 
 	// GetBlockVerboseResult help.
 	"getblockverboseresult-hash":              "The hash of the block (same as provided)",
-	"getblockverboseresult-confirmations":     "The number of confirmations",
+	"getblockverboseresult-confirmations":     "The number of confirmations, or -1 if the block is not in the main chain",
 	"getblockverboseresult-size":              "The size of the block",
 	"getblockverboseresult-height":            "The height of the block in the block chain",
 	"getblockverboseresult-version":           "The block version",
@@ -191,11 +249,13 @@ var helpDescsEnUS = map[string]string{
 	"getblockverboseresult-tx":                "The transaction hashes (only when verbosetx=false)",
 	"getblockverboseresult-rawtx":             "The transactions as JSON objects (only when verbosetx=true)",
 	"getblockverboseresult-time":              "The block time in seconds since 1 Jan 1970 GMT",
+	"getblockverboseresult-mediantime":        "The median block time of the previous few blocks, including this one, in seconds since 1 Jan 1970 GMT",
 	"getblockverboseresult-nonce":             "The block nonce",
 	"getblockverboseresult-bits":              "The bits which represent the block difficulty",
 	"getblockverboseresult-difficulty":        "The proof-of-work difficulty as a multiple of the minimum difficulty",
+	"getblockverboseresult-chainwork":         "The expected number of hashes required to produce the chain up to this block (in hex)",
 	"getblockverboseresult-previousblockhash": "The hash of the previous block",
-	"getblockverboseresult-nextblockhash":     "The hash of the next block (only if there is one)",
+	"getblockverboseresult-nextblockhash":     "The hash of the next main chain block (only if there is one)",
 
 	// GetBlockCountCmd help.
 	"getblockcount--synopsis": "Returns the number of blocks in the longest block chain.",
@@ -216,27 +276,29 @@ var helpDescsEnUS = map[string]string{
 
 	// GetBlockHeaderVerboseResult help.
 	"getblockheaderverboseresult-hash":              "The hash of the block (same as provided)",
-	"getblockheaderverboseresult-confirmations":     "The number of confirmations",
+	"getblockheaderverboseresult-confirmations":     "The number of confirmations, or -1 if the block is not in the main chain",
 	"getblockheaderverboseresult-height":            "The height of the block in the block chain",
 	"getblockheaderverboseresult-version":           "The block version",
 	"getblockheaderverboseresult-merkleroot":        "Root hash of the merkle tree",
 	"getblockheaderverboseresult-time":              "The block time in seconds since 1 Jan 1970 GMT",
+	"getblockheaderverboseresult-mediantime":        "The median block time of the previous few blocks, including this one, in seconds since 1 Jan 1970 GMT",
 	"getblockheaderverboseresult-nonce":             "The block nonce",
 	"getblockheaderverboseresult-bits":              "The bits which represent the block difficulty",
 	"getblockheaderverboseresult-difficulty":        "The proof-of-work difficulty as a multiple of the minimum difficulty",
+	"getblockheaderverboseresult-chainwork":         "The expected number of hashes required to produce the chain up to this block (in hex)",
 	"getblockheaderverboseresult-previousblockhash": "The hash of the previous block",
-	"getblockheaderverboseresult-nextblockhash":     "The hash of the next block (only if there is one)",
+	"getblockheaderverboseresult-nextblockhash":     "The hash of the next main chain block (only if there is one)",
 
 	// TemplateRequest help.
 	"templaterequest-mode":         "This is 'template', 'proposal', or omitted",
-	"templaterequest-capabilities": "List of capabilities",
+	"templaterequest-capabilities": "List of client capabilities; 'coinbasetxn' and 'coinbasevalue' choose how the coinbase is provided, 'workid' requests a work ID, and 'serverlist' is not supported",
 	"templaterequest-longpollid":   "The long poll ID of a job to monitor for expiration; required and valid only for long poll requests ",
 	"templaterequest-sigoplimit":   "Number of signature operations allowed in blocks (this parameter is ignored)",
 	"templaterequest-sizelimit":    "Number of bytes allowed in blocks (this parameter is ignored)",
 	"templaterequest-maxversion":   "Highest supported block version number (this parameter is ignored)",
 	"templaterequest-target":       "The desired target for the block template (this parameter is ignored)",
 	"templaterequest-data":         "Hex-encoded block data (only for mode=proposal)",
-	"templaterequest-workid":       "The server provided workid if provided in block template (not applicable)",
+	"templaterequest-workid":       "The server provided workid if provided in block template (this parameter is ignored)",
 
 	// GetBlockTemplateResultTx help.
 	"getblocktemplateresulttx-data":    "Hex-encoded transaction data (byte-for-byte)",
@@ -260,7 +322,7 @@ var helpDescsEnUS = map[string]string{
 	"getblocktemplateresult-coinbaseaux":       "Data that should be included in the coinbase signature script",
 	"getblocktemplateresult-coinbasetxn":       "Information about the coinbase transaction",
 	"getblocktemplateresult-coinbasevalue":     "Total amount available for the coinbase in Satoshi",
-	"getblocktemplateresult-workid":            "This value must be returned with result if provided (not provided)",
+	"getblocktemplateresult-workid":            "This value must be returned with result if provided (only provided when the 'workid' capability is requested)",
 	"getblocktemplateresult-longpollid":        "Identifier for long poll request which allows monitoring for expiration",
 	"getblocktemplateresult-longpolluri":       "An alternate URI to use for long poll requests if provided (not provided)",
 	"getblocktemplateresult-submitold":         "Not applicable",
@@ -335,12 +397,35 @@ var helpDescsEnUS = map[string]string{
 	// GetInfoCmd help.
 	"getinfo--synopsis": "Returns a JSON object containing various state info.",
 
+	// GetMempoolEntryCmd help.
+	"getmempoolentry--synopsis": "Returns information about a transaction in the memory pool.",
+	"getmempoolentry-txid":      "The hash of the transaction",
+
 	// GetMempoolInfoCmd help.
 	"getmempoolinfo--synopsis": "Returns memory pool information",
+	"getmempoolinfo-verbose":   "Include statistics about the transactions considered for acceptance into the memory pool",
 
 	// GetMempoolInfoResult help.
-	"getmempoolinforesult-bytes": "Size in bytes of the mempool",
-	"getmempoolinforesult-size":  "Number of transactions in the mempool",
+	"getmempoolinforesult-bytes":            "Size in bytes of the mempool",
+	"getmempoolinforesult-size":             "Number of transactions in the mempool",
+	"getmempoolinforesult-usage":            "Estimated memory usage in bytes of the mempool",
+	"getmempoolinforesult-maxmempool":       "Maximum total size in bytes of the transactions in the mempool (0 when unlimited)",
+	"getmempoolinforesult-mempoolminfee":    "Minimum fee rate in BTC/kB for a transaction to be accepted, including the dynamic minimum in effect after transactions were evicted because the mempool was full",
+	"getmempoolinforesult-unbroadcastcount": "Number of locally submitted transactions which have not been requested by any peer yet",
+	"getmempoolinforesult-acceptancestats":  "Statistics about the transactions considered for acceptance into the mempool (only when verbose is true)",
+
+	// MempoolAcceptanceStats help.
+	"mempoolacceptancestats-processed": "Number of transactions considered for acceptance",
+	"mempoolacceptancestats-accepted":  "Number of transactions accepted into the mempool",
+	"mempoolacceptancestats-orphans":   "Number of transactions found to be orphans",
+	"mempoolacceptancestats-rejected":  "Number of transactions rejected",
+	"mempoolacceptancestats-stages":    "Statistics for each stage of the acceptance pipeline in the order they are performed",
+
+	// MempoolAcceptanceStage help.
+	"mempoolacceptancestage-name":     "The name of the stage",
+	"mempoolacceptancestage-reached":  "Number of transactions which reached the stage",
+	"mempoolacceptancestage-rejected": "Number of transactions rejected by the stage",
+	"mempoolacceptancestage-avgtime":  "Average time in microseconds transactions which reached the stage spent in it",
 
 	// GetMiningInfoResult help.
 	"getmininginforesult-blocks":           "Height of the latest best block",
@@ -373,25 +458,48 @@ var helpDescsEnUS = map[string]string{
 	"getnettotalsresult-timemillis":     "Number of milliseconds since 1 Jan 1970 GMT",
 
 	// GetPeerInfoResult help.
-	"getpeerinforesult-id":             "A unique node ID",
-	"getpeerinforesult-addr":           "The ip address and port of the peer",
-	"getpeerinforesult-addrlocal":      "Local address",
-	"getpeerinforesult-services":       "Services bitmask which represents the services supported by the peer",
-	"getpeerinforesult-lastsend":       "Time the last message was received in seconds since 1 Jan 1970 GMT",
-	"getpeerinforesult-lastrecv":       "Time the last message was sent in seconds since 1 Jan 1970 GMT",
-	"getpeerinforesult-bytessent":      "Total bytes sent",
-	"getpeerinforesult-bytesrecv":      "Total bytes received",
-	"getpeerinforesult-conntime":       "Time the connection was made in seconds since 1 Jan 1970 GMT",
-	"getpeerinforesult-timeoffset":     "The time offset of the peer",
-	"getpeerinforesult-pingtime":       "Number of microseconds the last ping took",
-	"getpeerinforesult-pingwait":       "Number of microseconds a queued ping has been waiting for a response",
-	"getpeerinforesult-version":        "The protocol version of the peer",
-	"getpeerinforesult-subver":         "The user agent of the peer",
-	"getpeerinforesult-inbound":        "Whether or not the peer is an inbound connection",
-	"getpeerinforesult-startingheight": "The latest block height the peer knew about when the connection was established",
-	"getpeerinforesult-currentheight":  "The current height of the peer",
-	"getpeerinforesult-banscore":       "The ban score",
-	"getpeerinforesult-syncnode":       "Whether or not the peer is the sync peer",
+	"getpeerinforesult-id":                       "A unique node ID",
+	"getpeerinforesult-addr":                     "The ip address and port of the peer",
+	"getpeerinforesult-addrlocal":                "Local address",
+	"getpeerinforesult-services":                 "Services bitmask which represents the services supported by the peer",
+	"getpeerinforesult-lastsend":                 "Time the last message was received in seconds since 1 Jan 1970 GMT",
+	"getpeerinforesult-lastrecv":                 "Time the last message was sent in seconds since 1 Jan 1970 GMT",
+	"getpeerinforesult-bytessent":                "Total bytes sent",
+	"getpeerinforesult-bytesrecv":                "Total bytes received",
+	"getpeerinforesult-bytessent_per_msg":        "Total bytes sent by message command",
+	"getpeerinforesult-bytessent_per_msg--key":   "command",
+	"getpeerinforesult-bytessent_per_msg--value": "n",
+	"getpeerinforesult-bytessent_per_msg--desc":  "The message command as the key and the total bytes sent as the value, with the bytes of unreadable messages under *other*",
+	"getpeerinforesult-bytesrecv_per_msg":        "Total bytes received by message command",
+	"getpeerinforesult-bytesrecv_per_msg--key":   "command",
+	"getpeerinforesult-bytesrecv_per_msg--value": "n",
+	"getpeerinforesult-bytesrecv_per_msg--desc":  "The message command as the key and the total bytes received as the value, with the bytes of unreadable messages under *other*",
+	"getpeerinforesult-conntime":                 "Time the connection was made in seconds since 1 Jan 1970 GMT",
+	"getpeerinforesult-timeoffset":               "The time offset of the peer",
+	"getpeerinforesult-pingtime":                 "Number of microseconds the last ping took",
+	"getpeerinforesult-pingwait":                 "Number of microseconds a queued ping has been waiting for a response",
+	"getpeerinforesult-pingpercentiles":          "Percentiles of the round trip times of the recent pings (only when the peer answered a ping)",
+	"getpeerinforesult-version":                  "The protocol version of the peer",
+	"getpeerinforesult-subver":                   "The user agent of the peer",
+	"getpeerinforesult-inbound":                  "Whether or not the peer is an inbound connection",
+	"getpeerinforesult-startingheight":           "The latest block height the peer knew about when the connection was established",
+	"getpeerinforesult-currentheight":            "The current height of the peer",
+	"getpeerinforesult-banscore":                 "The ban score",
+	"getpeerinforesult-stallcount":               "Number of responses the peer did not deliver before their deadline",
+	"getpeerinforesult-quality":                  "The quality score of the peer used to choose the peers to evict and sync from",
+	"getpeerinforesult-syncnode":                 "Whether or not the peer is the sync peer",
+
+	// PingPercentilesResult help.
+	"pingpercentilesresult-p50": "Median round trip time in microseconds",
+	"pingpercentilesresult-p90": "90th percentile round trip time in microseconds",
+	"pingpercentilesresult-p99": "99th percentile round trip time in microseconds",
+
+	// PeerQualityResult help.
+	"peerqualityresult-score":      "The quality score from 0 (worst) to 100 (best), which is the sum of the components",
+	"peerqualityresult-latency":    "The latency component from 0 to 40 based on the 90th percentile ping round trip time",
+	"peerqualityresult-usefulness": "The usefulness component from 0 to 30 based on the bytes of blocks and transactions received",
+	"peerqualityresult-stalls":     "The stalls component from 0 to 15 which shrinks with every stall",
+	"peerqualityresult-behavior":   "The behavior component from 0 to 15 which shrinks as the ban score approaches the ban threshold",
 
 	// GetPeerInfoCmd help.
 	"getpeerinfo--synopsis": "Returns data about each connected network peer as an array of json objects.",
@@ -399,11 +507,13 @@ var helpDescsEnUS = map[string]string{
 	// GetRawMempoolVerboseResult help.
 	"getrawmempoolverboseresult-size":             "Transaction size in bytes",
 	"getrawmempoolverboseresult-fee":              "Transaction fee in bitcoins",
+	"getrawmempoolverboseresult-modifiedfee":      "Transaction fee in bitcoins adjusted by the fee delta set via prioritisetransaction, which is used when selecting transactions for block templates",
 	"getrawmempoolverboseresult-time":             "Local time transaction entered pool in seconds since 1 Jan 1970 GMT",
 	"getrawmempoolverboseresult-height":           "Block height when transaction entered the pool",
 	"getrawmempoolverboseresult-startingpriority": "Priority when transaction entered the pool",
 	"getrawmempoolverboseresult-currentpriority":  "Current priority",
 	"getrawmempoolverboseresult-depends":          "Unconfirmed transactions used as inputs for this transaction",
+	"getrawmempoolverboseresult-unbroadcast":      "Whether the transaction was submitted locally and has not been requested by any peer yet",
 
 	// GetRawMempoolCmd help.
 	"getrawmempool--synopsis":   "Returns information about all of the transactions currently in the memory pool.",
@@ -420,6 +530,22 @@ var helpDescsEnUS = map[string]string{
 	"getrawtransaction--condition1": "verbose=true",
 	"getrawtransaction--result0":    "Hex-encoded bytes of the serialized transaction",
 
+	// GetRPCInfoCmd help.
+	"getrpcinfo--synopsis": "Returns information about the RPC server, such as the delivery statistics of the registered notifiers.",
+
+	// GetRPCInfoResult help.
+	"getrpcinforesult-notifiers": "The notifiers which are notified about chain and mempool events",
+
+	// NotifierInfoResult help.
+	"notifierinforesult-name":         "The name of the notifier",
+	"notifierinforesult-queued":       "Number of notifications waiting to be delivered",
+	"notifierinforesult-delivered":    "Number of notifications delivered successfully",
+	"notifierinforesult-failed":       "Number of notifications the notifier failed to handle",
+	"notifierinforesult-dropped":      "Number of notifications dropped because the queue of the notifier was full",
+	"notifierinforesult-avglatencyms": "Average time in milliseconds the notifier took to handle a notification",
+	"notifierinforesult-maxlatencyms": "Maximum time in milliseconds the notifier took to handle a notification",
+	"notifierinforesult-lasterror":    "The error of the last notification the notifier failed to handle",
+
 	// GetTxOutResult help.
 	"gettxoutresult-bestblock":     "The block hash that contains the transaction output",
 	"gettxoutresult-confirmations": "The number of confirmations",
@@ -434,6 +560,26 @@ var helpDescsEnUS = map[string]string{
 	"gettxout-vout":           "The index of the output",
 	"gettxout-includemempool": "Include the mempool when true",
 
+	// GetTxOutProofCmd help.
+	"gettxoutproof--synopsis": "Returns a hex-encoded merkle block proving the inclusion of the transactions in a block of the main chain.\n" +
+		"The block is looked up in the transaction index when it is not specified.",
+	"gettxoutproof-txids":     "The hashes of the transactions to prove, all of which must be in the same block",
+	"gettxoutproof-blockhash": "The hash of the block which contains the transactions",
+	"gettxoutproof--result0":  "Hex-encoded serialized merkle block",
+
+	// GetTxOutSetInfoResult help.
+	"gettxoutsetinforesult-height":           "The height of the block the statistics are as of",
+	"gettxoutsetinforesult-bestblock":        "The hash of the block the statistics are as of",
+	"gettxoutsetinforesult-transactions":     "The number of transactions with unspent outputs",
+	"gettxoutsetinforesult-txouts":           "The number of unspent transaction outputs",
+	"gettxoutsetinforesult-bytes_serialized": "The size of the unspent transaction output set as stored in the database",
+	"gettxoutsetinforesult-hash_serialized":  "The hash which commits to the contents of the unspent transaction output set",
+	"gettxoutsetinforesult-total_amount":     "The total amount of all unspent transaction outputs in BTC",
+
+	// GetTxOutSetInfoCmd help.
+	"gettxoutsetinfo--synopsis": "Returns statistics about the unspent transaction output set.\n" +
+		"The entire set is scanned, so the call may take a long time.",
+
 	// GetWorkResult help.
 	"getworkresult-data":     "Hex-encoded block data",
 	"getworkresult-hash1":    "(DEPRECATED) Hex-encoded formatted hash buffer",
@@ -455,10 +601,70 @@ var helpDescsEnUS = map[string]string{
 	"help--result0":    "List of commands",
 	"help--result1":    "Help for specified command",
 
+	// ImportMempoolCmd help.
+	"importmempool--synopsis":     "Loads transactions from a file written by savemempool into the mempool.\nTransactions which are no longer valid are skipped.",
+	"importmempool-filepath":      "The path of the file to load transactions from",
+	"importmempool-skipfeelimits": "Accept the transactions without applying the minimum and absurd fee checks",
+
+	// ImportMempoolResult help.
+	"importmempoolresult-accepted": "Number of transactions added to the mempool",
+	"importmempoolresult-skipped":  "Number of transactions which were rejected",
+
 	// PingCmd help.
 	"ping--synopsis": "Queues a ping to be sent to each connected peer.\n" +
 		"Ping times are provided by getpeerinfo via the pingtime and pingwait fields.",
 
+	// PreciousBlockCmd help.
+	"preciousblock--synopsis": "Treats a block as if it were received before others with the same work.\n" +
+		"A later preciousblock call can override the effect of an earlier one.",
+	"preciousblock-blockhash": "The hash of the block to mark as precious",
+
+	// ReconsiderBlockCmd help.
+	"reconsiderblock--synopsis": "Validates a block which was stored as invalid, along with its stored descendants, again.\n" +
+		"Blocks are only stored as invalid when the server is started with --storeinvalidblocks.\n" +
+		"Blocks which are still invalid are stored as invalid again.",
+	"reconsiderblock-blockhash": "The hash of the block to reconsider",
+
+	// PrioritiseTransactionCmd help.
+	"prioritisetransaction--synopsis": "Sets a fee delta which is added to the fee of a transaction when selecting transactions for block templates, without changing the fee the transaction actually pays.\n" +
+		"The delta replaces any previously set delta and a delta of zero clears it.\n" +
+		"It may be set before the transaction arrives and is kept for a while when the transaction leaves the memory pool.",
+	"prioritisetransaction-txid":     "The hash of the transaction",
+	"prioritisetransaction-feedelta": "The fee delta in satoshi, which is negative to deprioritize the transaction",
+	"prioritisetransaction--result0": "Always true",
+
+	// SaveMempoolCmd help.
+	"savemempool--synopsis": "Saves the transactions in the mempool to the data directory.",
+
+	// SaveMempoolResult help.
+	"savemempoolresult-filename": "The path of the file the mempool was saved to",
+
+	// ScanTxOutSetCmd help.
+	"scantxoutset--synopsis": "Scans the unspent transaction output set for outputs matching the provided output descriptors.\n" +
+		"Only one scan may run at a time and the result of a scan reflects the outputs as the set is traversed, so it may not be a consistent snapshot when blocks are connected during the scan.",
+	"scantxoutset-action":      "The action to perform: \"start\" to begin a scan, \"abort\" to stop the running scan, or \"status\" to report the progress of the running scan",
+	"scantxoutset-scanobjects": "The output descriptors to scan for when starting a scan.  Supported forms are addr(<address>) and raw(<hex-encoded script>)",
+	"scantxoutset--condition0": "action=start",
+	"scantxoutset--condition1": "action=status",
+	"scantxoutset--condition2": "action=abort",
+	"scantxoutset--result2":    "Whether or not a running scan was asked to stop",
+
+	// ScanTxOutSetResult help.
+	"scantxoutsetresult-success":        "Whether or not the scan completed without being aborted",
+	"scantxoutsetresult-searched_items": "The number of utxo set entries which were searched",
+	"scantxoutsetresult-unspents":       "The unspent outputs which matched the descriptors",
+	"scantxoutsetresult-total_amount":   "The total amount of the matching unspent outputs in bitcoins",
+
+	// ScanTxOutSetUnspent help.
+	"scantxoutsetunspent-txid":         "The hash of the transaction",
+	"scantxoutsetunspent-vout":         "The index of the output",
+	"scantxoutsetunspent-scriptPubKey": "Hex-encoded public key script of the output",
+	"scantxoutsetunspent-amount":       "The value of the output in bitcoins",
+	"scantxoutsetunspent-height":       "The height of the block which contains the transaction",
+
+	// ScanTxOutSetStatusResult help.
+	"scantxoutsetstatusresult-progress": "The approximate percentage of the utxo set which has been scanned",
+
 	// SearchRawTransactionsCmd help.
 	"searchrawtransactions--synopsis": "Returns raw data for transactions involving the passed address.\n" +
 		"Returned transactions are pulled from both the database, and transactions currently in the mempool.\n" +
@@ -479,7 +685,9 @@ var helpDescsEnUS = map[string]string{
 	// SendRawTransactionCmd help.
 	"sendrawtransaction--synopsis":     "Submits the serialized, hex-encoded transaction to the local peer and relays it to the network.",
 	"sendrawtransaction-hextx":         "Serialized, hex-encoded signed transaction",
-	"sendrawtransaction-allowhighfees": "Whether or not to allow insanely high fees (btcd does not yet implement this parameter, so it has no effect)",
+	"sendrawtransaction-allowhighfees": "Whether or not to allow insanely high fees",
+	"sendrawtransaction-acceptnonstd":  "Whether or not to accept the transaction into the local memory pool even though it is not standard (it will not be relayed)",
+	"sendrawtransaction-skipfeelimits": "Whether or not to skip the minimum fee, priority, and rate limiting checks (a transaction which only passes due to this will not be relayed)",
 	"sendrawtransaction--result0":      "The hash of the transaction",
 
 	// SetGenerateCmd help.
@@ -527,6 +735,32 @@ var helpDescsEnUS = map[string]string{
 	"verifymessage-message":   "The signed message",
 	"verifymessage--result0":  "Whether or not the signature verified",
 
+	// VersionCmd help.
+	"version--synopsis": "Returns the versions of the components of the server and the optional subsystems which are enabled.",
+
+	// NodeVersionResult help.
+	"nodeversionresult-colxd":           "The version of the server software",
+	"nodeversionresult-protocol":        "The highest version of the peer-to-peer protocol supported by the server",
+	"nodeversionresult-database":        "The version of the storage format of the database",
+	"nodeversionresult-databasebackend": "The type of the database backend",
+	"nodeversionresult-features":        "The optional subsystems which are enabled",
+
+	// VersionResult help.
+	"versionresult-versionstring": "The version as a semantic version string",
+	"versionresult-major":         "The major version",
+	"versionresult-minor":         "The minor version",
+	"versionresult-patch":         "The patch version",
+	"versionresult-prerelease":    "The pre-release version, if any",
+	"versionresult-buildmetadata": "The build metadata, if any",
+
+	// VersionFeaturesResult help.
+	"versionfeaturesresult-txindex":     "Whether or not the transaction index is enabled",
+	"versionfeaturesresult-addrindex":   "Whether or not the address index is enabled",
+	"versionfeaturesresult-cfindex":     "Whether or not the committed filter index is enabled",
+	"versionfeaturesresult-pruning":     "Whether or not the data of old blocks is pruned",
+	"versionfeaturesresult-compression": "Whether or not stored data is compressed",
+	"versionfeaturesresult-rest":        "Whether or not the REST interface is enabled",
+
 	// -------- Websocket-specific help --------
 
 	// Session help.
@@ -540,7 +774,7 @@ var helpDescsEnUS = map[string]string{
 	"stopnotifyblocks--synopsis": "Cancel registered notifications for whenever a block is connected or disconnected from the main (best) chain.",
 
 	// NotifyNewTransactionsCmd help.
-	"notifynewtransactions--synopsis": "Send either a txaccepted or a txacceptedverbose notification when a new transaction is accepted into the mempool.",
+	"notifynewtransactions--synopsis": "Send either a txaccepted or a txacceptedverbose notification when a new transaction is accepted into the mempool and a txevicted notification when a transaction is evicted from the full mempool.",
 	"notifynewtransactions-verbose":   "Specifies which type of notification to receive. If verbose is true, then the caller receives txacceptedverbose, otherwise the caller receives txaccepted",
 
 	// StopNotifyNewTransactionsCmd help.
@@ -576,6 +810,23 @@ var helpDescsEnUS = map[string]string{
 	"rescan-addresses":  "List of addresses to include in the rescan",
 	"rescan-outpoints":  "List of transaction outpoints to include in the rescan",
 	"rescan-endblock":   "Hash of final block to rescan",
+
+	// LoadTxFilterCmd help.
+	"loadtxfilter--synopsis": "Load, add to, or reload a websocket client's transaction filter for mempool transactions, new blocks and rescanblocks.\n" +
+		"Mempool transactions matching the filter are sent as relevanttxaccepted notifications and block transactions matching it are included in filteredblockconnected notifications.",
+	"loadtxfilter-reload":    "Load a new filter instead of adding data to an existing one",
+	"loadtxfilter-addresses": "Array of addresses to add to the transaction filter",
+	"loadtxfilter-outpoints": "Array of outpoints to add to the transaction filter",
+
+	// RescanBlocksCmd help.
+	"rescanblocks--synopsis": "Rescan blocks identified by hashes, in order, for transactions matching the client's loaded transaction filter.\n" +
+		"The outpoints of matching outputs are added to the filter so later transactions spending them match as well.",
+	"rescanblocks-blockhashes": "List of hashes to rescan.  Each next block must be a child of the previous.",
+	"rescanblocks--result0":    "List of matching blocks.",
+
+	// RescannedBlock help.
+	"rescannedblock-hash":         "Hash of the matching block.",
+	"rescannedblock-transactions": "List of matching transactions, serialized and hex-encoded.",
 }
 
 // rpcResultTypes specifies the result types that each RPC command can return.
@@ -587,8 +838,12 @@ var rpcResultTypes = map[string][]interface{}{
 	"debuglevel":            {(*string)(nil), (*string)(nil)},
 	"decoderawtransaction":  {(*btcjson.TxRawDecodeResult)(nil)},
 	"decodescript":          {(*btcjson.DecodeScriptResult)(nil)},
+	"estimatefee":           {(*float64)(nil)},
 	"generate":              {(*[]string)(nil)},
 	"getaddednodeinfo":      {(*[]string)(nil), (*[]btcjson.GetAddedNodeInfoResult)(nil)},
+	"getaddressbalance":     {(*btcjson.GetAddressBalanceResult)(nil)},
+	"getaddressdeltas":      {(*btcjson.GetAddressDeltasResult)(nil)},
+	"getaddressutxos":       {(*btcjson.GetAddressUtxosResult)(nil)},
 	"getbestblock":          {(*btcjson.GetBestBlockResult)(nil)},
 	"getbestblockhash":      {(*string)(nil)},
 	"getblock":              {(*string)(nil), (*btcjson.GetBlockVerboseResult)(nil)},
@@ -602,6 +857,7 @@ var rpcResultTypes = map[string][]interface{}{
 	"getgenerate":           {(*bool)(nil)},
 	"gethashespersec":       {(*float64)(nil)},
 	"getinfo":               {(*btcjson.InfoChainResult)(nil)},
+	"getmempoolentry":       {(*btcjson.GetRawMempoolVerboseResult)(nil)},
 	"getmempoolinfo":        {(*btcjson.GetMempoolInfoResult)(nil)},
 	"getmininginfo":         {(*btcjson.GetMiningInfoResult)(nil)},
 	"getnettotals":          {(*btcjson.GetNetTotalsResult)(nil)},
@@ -609,11 +865,20 @@ var rpcResultTypes = map[string][]interface{}{
 	"getpeerinfo":           {(*[]btcjson.GetPeerInfoResult)(nil)},
 	"getrawmempool":         {(*[]string)(nil), (*btcjson.GetRawMempoolVerboseResult)(nil)},
 	"getrawtransaction":     {(*string)(nil), (*btcjson.TxRawResult)(nil)},
+	"getrpcinfo":            {(*btcjson.GetRPCInfoResult)(nil)},
 	"gettxout":              {(*btcjson.GetTxOutResult)(nil)},
+	"gettxoutproof":         {(*string)(nil)},
+	"gettxoutsetinfo":       {(*btcjson.GetTxOutSetInfoResult)(nil)},
 	"getwork":               {(*btcjson.GetWorkResult)(nil), (*bool)(nil)},
 	"node":                  nil,
 	"help":                  {(*string)(nil), (*string)(nil)},
+	"importmempool":         {(*btcjson.ImportMempoolResult)(nil)},
 	"ping":                  nil,
+	"preciousblock":         nil,
+	"prioritisetransaction": {(*bool)(nil)},
+	"reconsiderblock":       nil,
+	"savemempool":           {(*btcjson.SaveMempoolResult)(nil)},
+	"scantxoutset":          {(*btcjson.ScanTxOutSetResult)(nil), (*btcjson.ScanTxOutSetStatusResult)(nil), (*bool)(nil)},
 	"searchrawtransactions": {(*string)(nil), (*[]btcjson.SearchRawTransactionsResult)(nil)},
 	"sendrawtransaction":    {(*string)(nil)},
 	"setgenerate":           nil,
@@ -622,6 +887,7 @@ var rpcResultTypes = map[string][]interface{}{
 	"validateaddress":       {(*btcjson.ValidateAddressChainResult)(nil)},
 	"verifychain":           {(*bool)(nil)},
 	"verifymessage":         {(*bool)(nil)},
+	"version":               {(*btcjson.NodeVersionResult)(nil)},
 
 	// Websocket commands.
 	"session":                   {(*btcjson.SessionResult)(nil)},
@@ -634,6 +900,8 @@ var rpcResultTypes = map[string][]interface{}{
 	"notifyspent":               nil,
 	"stopnotifyspent":           nil,
 	"rescan":                    nil,
+	"loadtxfilter":              nil,
+	"rescanblocks":              {(*[]btcjson.RescannedBlock)(nil)},
 }
 
 // helpCacher provides a concurrent safe type that provides help and usage for
@@ -24,6 +24,7 @@ import (
 	"github.com/btcsuite/websocket"
 	"github.com/tinhnguyenhn/colxd/blockchain"
 	"github.com/tinhnguyenhn/colxd/btcjson"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
 	"github.com/tinhnguyenhn/colxd/database"
 	"github.com/tinhnguyenhn/colxd/txscript"
 	"github.com/tinhnguyenhn/colxd/wire"
@@ -63,6 +64,8 @@ var wsHandlersBeforeInit = map[string]wsCommandHandler{
 	"stopnotifyspent":           handleStopNotifySpent,
 	"stopnotifyreceived":        handleStopNotifyReceived,
 	"rescan":                    handleRescan,
+	"loadtxfilter":              handleLoadTxFilter,
+	"rescanblocks":              handleRescanBlocks,
 }
 
 // wsAsyncHandlers holds the websocket commands which should be run
@@ -70,7 +73,8 @@ var wsHandlersBeforeInit = map[string]wsCommandHandler{
 // operations to run concurrently (and one at a time) while still responding
 // to the majority of normal requests which can be answered quickly.
 var wsAsyncHandlers = map[string]struct{}{
-	"rescan": {},
+	"rescan":       {},
+	"rescanblocks": {},
 }
 
 // WebsocketHandler handles a new websocket client by creating a new wsClient,
@@ -241,13 +245,42 @@ func (m *wsNotificationManager) NotifyMempoolTx(tx *colxutil.Tx, isNew bool) {
 	}
 }
 
+// NotifyMempoolTxEvicted passes a transaction evicted from the mempool because
+// it exceeded its size limit to the notification manager for transaction
+// notification processing.
+func (m *wsNotificationManager) NotifyMempoolTxEvicted(tx *colxutil.Tx) {
+	// As NotifyMempoolTxEvicted will be called by the server and the RPC
+	// server may no longer be running, use a select statement to unblock
+	// enqueuing the notification once the RPC server has begun shutting
+	// down.
+	select {
+	case m.queueNotification <- (*notificationTxEvictedFromMempool)(tx):
+	case <-m.quit:
+	}
+}
+
+// NotifyChainReorg passes a reorganize of the main chain to the notification
+// manager for notification processing.
+func (m *wsNotificationManager) NotifyChainReorg(reorg *blockchain.ReorgData) {
+	// As NotifyChainReorg will be called by the block manager and the RPC
+	// server may no longer be running, use a select statement to unblock
+	// enqueuing the notification once the RPC server has begun shutting
+	// down.
+	select {
+	case m.queueNotification <- (*notificationChainReorg)(reorg):
+	case <-m.quit:
+	}
+}
+
 // Notification types
 type notificationBlockConnected colxutil.Block
 type notificationBlockDisconnected colxutil.Block
+type notificationChainReorg blockchain.ReorgData
 type notificationTxAcceptedByMempool struct {
 	isNew bool
 	tx    *colxutil.Tx
 }
+type notificationTxEvictedFromMempool colxutil.Tx
 
 // Notification control requests
 type notificationRegisterClient wsClient
@@ -315,17 +348,38 @@ out:
 				if len(blockNotifications) != 0 {
 					m.notifyBlockConnected(blockNotifications,
 						block)
+					m.notifyFilteredBlockConnected(blockNotifications,
+						block)
 				}
 
+				m.server.notifiers.NotifyBlockConnected(block.Sha(),
+					int32(block.Height()))
+
 			case *notificationBlockDisconnected:
-				m.notifyBlockDisconnected(blockNotifications,
-					(*colxutil.Block)(n))
+				block := (*colxutil.Block)(n)
+				m.notifyBlockDisconnected(blockNotifications, block)
+				m.notifyFilteredBlockDisconnected(blockNotifications,
+					block)
 
 			case *notificationTxAcceptedByMempool:
 				if n.isNew && len(txNotifications) != 0 {
 					m.notifyForNewTx(txNotifications, n.tx)
 				}
 				m.notifyForTx(watchedOutPoints, watchedAddrs, n.tx, nil)
+				if n.isNew {
+					m.notifyRelevantTxAccepted(clients, n.tx)
+					m.notifyNotifiersForNewTx(n.tx)
+				}
+
+			case *notificationTxEvictedFromMempool:
+				if len(txNotifications) != 0 {
+					m.notifyForEvictedTx(txNotifications,
+						(*colxutil.Tx)(n))
+				}
+
+			case *notificationChainReorg:
+				m.server.notifiers.NotifyReorg(
+					(*blockchain.ReorgData)(n))
 
 			case *notificationRegisterBlocks:
 				wsc := (*wsClient)(n)
@@ -413,6 +467,24 @@ func (m *wsNotificationManager) UnregisterBlockUpdates(wsc *wsClient) {
 	m.queueNotification <- (*notificationUnregisterBlocks)(wsc)
 }
 
+// notifyNotifiersForNewTx passes the verbose form of a transaction newly
+// accepted to the mempool to the notifiers registered with the RPC server.
+func (m *wsNotificationManager) notifyNotifiersForNewTx(tx *colxutil.Tx) {
+	if !m.server.notifiers.HasNotifiers() {
+		return
+	}
+
+	net := m.server.server.chainParams
+	rawTx, err := createTxRawResult(net, tx.MsgTx(), tx.Sha().String(),
+		nil, "", 0, 0)
+	if err != nil {
+		rpcsLog.Errorf("Failed to create verbose transaction for "+
+			"notifiers: %v", err)
+		return
+	}
+	m.server.notifiers.NotifyTxAccepted(rawTx)
+}
+
 // notifyBlockConnected notifies websocket clients that have registered for
 // block updates when a block is connected to the main chain.
 func (*wsNotificationManager) notifyBlockConnected(clients map[chan struct{}]*wsClient,
@@ -456,6 +528,115 @@ func (*wsNotificationManager) notifyBlockDisconnected(clients map[chan struct{}]
 	}
 }
 
+// serializedHeaderHex returns the serialized header of the passed block encoded
+// in hexadecimal.
+func serializedHeaderHex(block *colxutil.Block) (string, error) {
+	var buf bytes.Buffer
+	buf.Grow(wire.MaxBlockHeaderPayload)
+	if err := block.MsgBlock().Header.Serialize(&buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// notifyFilteredBlockConnected notifies websocket clients that have registered
+// for block updates when a block is connected to the main chain.  The
+// notification includes the transactions of the block which are relevant to
+// the filter loaded by each client.
+func (*wsNotificationManager) notifyFilteredBlockConnected(clients map[chan struct{}]*wsClient,
+	block *colxutil.Block) {
+
+	headerHex, err := serializedHeaderHex(block)
+	if err != nil {
+		rpcsLog.Errorf("Failed to serialize header for filtered block "+
+			"connected notification: %v", err)
+		return
+	}
+
+	for _, wsc := range clients {
+		// Include the transactions relevant to the filter loaded by
+		// the client, if any.
+		wsc.Lock()
+		filter := wsc.filterData
+		wsc.Unlock()
+		var subscribedTxs []string
+		if filter != nil {
+			subscribedTxs = filter.filterBlock(block)
+		}
+
+		ntfn := btcjson.NewFilteredBlockConnectedNtfn(
+			int32(block.Height()), headerHex, subscribedTxs)
+		marshalledJSON, err := btcjson.MarshalCmd(nil, ntfn)
+		if err != nil {
+			rpcsLog.Errorf("Failed to marshal filtered block "+
+				"connected notification: %v", err)
+			return
+		}
+		wsc.QueueNotification(marshalledJSON)
+	}
+}
+
+// notifyFilteredBlockDisconnected notifies websocket clients that have
+// registered for block updates when a block is disconnected from the main
+// chain (due to a reorganize).
+func (*wsNotificationManager) notifyFilteredBlockDisconnected(clients map[chan struct{}]*wsClient,
+	block *colxutil.Block) {
+
+	// Skip notification creation if no clients have requested block
+	// connected/disconnected notifications.
+	if len(clients) == 0 {
+		return
+	}
+
+	headerHex, err := serializedHeaderHex(block)
+	if err != nil {
+		rpcsLog.Errorf("Failed to serialize header for filtered block "+
+			"disconnected notification: %v", err)
+		return
+	}
+	ntfn := btcjson.NewFilteredBlockDisconnectedNtfn(int32(block.Height()),
+		headerHex)
+	marshalledJSON, err := btcjson.MarshalCmd(nil, ntfn)
+	if err != nil {
+		rpcsLog.Errorf("Failed to marshal filtered block disconnected "+
+			"notification: %v", err)
+		return
+	}
+	for _, wsc := range clients {
+		wsc.QueueNotification(marshalledJSON)
+	}
+}
+
+// notifyRelevantTxAccepted notifies websocket clients which have loaded a
+// transaction filter when a transaction relevant to it is accepted to the
+// memory pool.
+func (*wsNotificationManager) notifyRelevantTxAccepted(clients map[chan struct{}]*wsClient,
+	tx *colxutil.Tx) {
+
+	var marshalledJSON []byte
+	for _, wsc := range clients {
+		wsc.Lock()
+		filter := wsc.filterData
+		wsc.Unlock()
+		if filter == nil || !filter.filterTx(tx) {
+			continue
+		}
+
+		// Only create the notification once it is needed.
+		if marshalledJSON == nil {
+			ntfn := btcjson.NewRelevantTxAcceptedNtfn(txHexString(tx))
+			var err error
+			marshalledJSON, err = btcjson.MarshalCmd(nil, ntfn)
+			if err != nil {
+				rpcsLog.Errorf("Failed to marshal relevant tx "+
+					"accepted notification: %v", err)
+				return
+			}
+		}
+		wsc.QueueNotification(marshalledJSON)
+	}
+}
+
 // RegisterNewMempoolTxsUpdates requests notifications to the passed websocket
 // client when new transactions are added to the memory pool.
 func (m *wsNotificationManager) RegisterNewMempoolTxsUpdates(wsc *wsClient) {
@@ -517,6 +698,22 @@ func (m *wsNotificationManager) notifyForNewTx(clients map[chan struct{}]*wsClie
 	}
 }
 
+// notifyForEvictedTx notifies websocket clients that have registered for
+// updates about new transactions when a transaction is evicted from the memory
+// pool because it exceeded its size limit.
+func (m *wsNotificationManager) notifyForEvictedTx(clients map[chan struct{}]*wsClient, tx *colxutil.Tx) {
+	ntfn := btcjson.NewTxEvictedNtfn(tx.Sha().String())
+	marshalledJSON, err := btcjson.MarshalCmd(nil, ntfn)
+	if err != nil {
+		rpcsLog.Errorf("Failed to marshal tx evicted notification: %v",
+			err)
+		return
+	}
+	for _, wsc := range clients {
+		wsc.QueueNotification(marshalledJSON)
+	}
+}
+
 // RegisterSpentRequests requests a notification when each of the passed
 // outpoints is confirmed spent (contained in a block connected to the main
 // chain) for the passed websocket client.  The request is automatically
@@ -897,6 +1094,11 @@ type wsClient struct {
 	// Owned by the notification manager.
 	spentRequests map[wire.OutPoint]struct{}
 
+	// filterData is the filter loaded by the client via the loadtxfilter
+	// command, or nil when no filter is loaded.  It is protected by the
+	// client mutex.
+	filterData *wsClientFilter
+
 	// Networking infrastructure.
 	asyncStarted bool
 	asyncChan    chan *parsedRPCCmd
@@ -986,27 +1188,31 @@ func (c *wsClient) handleMessage(msg []byte) {
 	}
 
 	// Check if the user is limited and disconnect client if unauthorized
-	if !c.isAdmin {
-		if _, ok := rpcLimited[request.Method]; !ok {
-			jsonErr := &btcjson.RPCError{
-				Code:    btcjson.ErrRPCInvalidParams.Code,
-				Message: "limited user not authorized for this method",
-			}
-			// Marshal and send response.
-			reply, err := createMarshalledReply(request.ID, nil, jsonErr)
-			if err != nil {
-				rpcsLog.Errorf("Failed to marshal parse failure "+
-					"reply: %v", err)
-				return
-			}
-			c.SendMessage(reply, nil)
+	if !c.server.authorized(request.Method, c.isAdmin) {
+		jsonErr := &btcjson.RPCError{
+			Code:    btcjson.ErrRPCInvalidParams.Code,
+			Message: "limited user not authorized for this method",
+		}
+		c.server.auditor.record(rpcUser(c.isAdmin), c.addr,
+			request.Method, request.Params, jsonErr)
+
+		// Marshal and send response.
+		reply, err := createMarshalledReply(request.ID, nil, jsonErr)
+		if err != nil {
+			rpcsLog.Errorf("Failed to marshal parse failure "+
+				"reply: %v", err)
 			return
 		}
+		c.SendMessage(reply, nil)
+		return
 	}
 
 	// Attempt to parse the JSON-RPC request into a known concrete command.
 	cmd := parseCmd(&request)
 	if cmd.err != nil {
+		c.server.auditor.record(rpcUser(c.isAdmin), c.addr, cmd.method,
+			cmd.params, cmd.err)
+
 		// Marshal and send response.
 		reply, err := createMarshalledReply(cmd.id, nil, cmd.err)
 		if err != nil {
@@ -1050,6 +1256,8 @@ func (c *wsClient) handleMessage(msg []byte) {
 		// No websocket-specific handler so handle like a legacy
 		// RPC connection.
 		result, jsonErr := c.server.standardCmdResult(cmd, nil)
+		c.server.auditor.record(rpcUser(c.isAdmin), c.addr, cmd.method,
+			cmd.params, jsonErr)
 		reply, err := createMarshalledReply(cmd.id, result, jsonErr)
 		if err != nil {
 			rpcsLog.Errorf("Failed to marshal reply for <%s> "+
@@ -1063,6 +1271,8 @@ func (c *wsClient) handleMessage(msg []byte) {
 
 	// Invoke the handler and marshal and send response.
 	result, jsonErr := wsHandler(c, cmd.cmd)
+	c.server.auditor.record(rpcUser(c.isAdmin), c.addr, cmd.method,
+		cmd.params, jsonErr)
 	reply, err := createMarshalledReply(cmd.id, result, jsonErr)
 	if err != nil {
 		rpcsLog.Errorf("Failed to marshal reply for <%s> command: %v",
@@ -1242,6 +1452,8 @@ func (c *wsClient) asyncHandler() {
 
 		// Invoke the handler and marshal and send response.
 		result, jsonErr := wsHandler(c, parsedCmd.cmd)
+		c.server.auditor.record(rpcUser(c.isAdmin), c.addr,
+			parsedCmd.method, parsedCmd.params, jsonErr)
 		reply, err := createMarshalledReply(parsedCmd.id, result,
 			jsonErr)
 		if err != nil {
@@ -1586,6 +1798,117 @@ func handleStopNotifyReceived(wsc *wsClient, icmd interface{}) (interface{}, err
 	return nil, nil
 }
 
+// handleLoadTxFilter implements the loadtxfilter command extension for
+// websocket connections.  The filter is replaced when reload is set or no
+// filter is loaded yet, and augmented with the passed addresses and outpoints
+// otherwise.  All parameters are validated before the filter is modified so
+// the update is atomic.
+func handleLoadTxFilter(wsc *wsClient, icmd interface{}) (interface{}, error) {
+	cmd, ok := icmd.(*btcjson.LoadTxFilterCmd)
+	if !ok {
+		return nil, btcjson.ErrRPCInternal
+	}
+
+	outPoints, err := deserializeOutpoints(cmd.OutPoints)
+	if err != nil {
+		return nil, err
+	}
+
+	params := activeNetParams.Params
+	addrs := make([]colxutil.Address, 0, len(cmd.Addresses))
+	for _, addrStr := range cmd.Addresses {
+		addr, err := colxutil.DecodeAddress(addrStr, params)
+		if err != nil {
+			return nil, &btcjson.RPCError{
+				Code: btcjson.ErrRPCInvalidAddressOrKey,
+				Message: fmt.Sprintf("Invalid address or key: %v",
+					addrStr),
+			}
+		}
+		addrs = append(addrs, addr)
+	}
+
+	wsc.Lock()
+	defer wsc.Unlock()
+	if cmd.Reload || wsc.filterData == nil {
+		wsc.filterData = newWSClientFilter(addrs, outPoints, params)
+		return nil, nil
+	}
+
+	wsc.filterData.mu.Lock()
+	for _, addr := range addrs {
+		wsc.filterData.addAddress(addr)
+	}
+	for _, op := range outPoints {
+		wsc.filterData.addUnspentOutPoint(op)
+	}
+	wsc.filterData.mu.Unlock()
+	return nil, nil
+}
+
+// handleRescanBlocks implements the rescanblocks command extension for
+// websocket connections.  It returns the transactions of each passed block
+// which are relevant to the filter loaded by the client, updating the filter
+// with the outpoints they create.  Each block must be the child of the block
+// before it.
+func handleRescanBlocks(wsc *wsClient, icmd interface{}) (interface{}, error) {
+	cmd, ok := icmd.(*btcjson.RescanBlocksCmd)
+	if !ok {
+		return nil, btcjson.ErrRPCInternal
+	}
+
+	wsc.Lock()
+	filter := wsc.filterData
+	wsc.Unlock()
+	if filter == nil {
+		return nil, &btcjson.RPCError{
+			Code:    btcjson.ErrRPCMisc,
+			Message: "Client has not loaded a transaction filter",
+		}
+	}
+
+	blockHashes := make([]*wire.ShaHash, 0, len(cmd.BlockHashes))
+	for _, hashStr := range cmd.BlockHashes {
+		hash, err := wire.NewShaHashFromStr(hashStr)
+		if err != nil {
+			return nil, rpcDecodeHexError(hashStr)
+		}
+		blockHashes = append(blockHashes, hash)
+	}
+
+	chain := wsc.server.chain
+	results := make([]btcjson.RescannedBlock, 0, len(blockHashes))
+	var lastBlockHash *wire.ShaHash
+	for _, hash := range blockHashes {
+		block, err := chain.BlockByHash(hash)
+		if err != nil {
+			return nil, &btcjson.RPCError{
+				Code:    btcjson.ErrRPCBlockNotFound,
+				Message: "Failed to fetch block: " + err.Error(),
+			}
+		}
+		prevHash := &block.MsgBlock().Header.PrevBlock
+		if lastBlockHash != nil && !lastBlockHash.IsEqual(prevHash) {
+			return nil, &btcjson.RPCError{
+				Code: btcjson.ErrRPCInvalidParameter,
+				Message: fmt.Sprintf("Block %v is not a child of "+
+					"block %v", hash, lastBlockHash),
+			}
+		}
+		lastBlockHash = hash
+
+		transactions := filter.filterBlock(block)
+		if len(transactions) != 0 {
+			results = append(results, btcjson.RescannedBlock{
+				Hash:         hash.String(),
+				Transactions: transactions,
+			})
+		}
+	}
+
+	return results, nil
+}
+
 // checkAddressValidity checks the validity of each address in the passed
 // string slice. It does this by attempting to decode each address using the
 // current active network parameters. If any single address fails to decode
@@ -1640,6 +1963,212 @@ func (r *rescanKeys) unspentSlice() []*wire.OutPoint {
 	return ops
 }
 
+// wsClientFilter tracks relevant addresses and unspent outpoints for a
+// websocket client loaded via the loadtxfilter command.  It is used both to
+// determine which mempool transactions and block transactions the client is
+// notified about and to perform the rescanblocks command, so the live and
+// rescan paths always agree on which transactions are relevant.
+//
+// The filter is safe for concurrent access.
+type wsClientFilter struct {
+	mu sync.Mutex
+
+	// Address lookup maps keyed by the decoded address type.  Addresses of
+	// types which do not have a fast path are kept in otherAddresses using
+	// their encoded form.
+	pubKeyHashes        map[[ripemd160.Size]byte]struct{}
+	scriptHashes        map[[ripemd160.Size]byte]struct{}
+	compressedPubKeys   map[[33]byte]struct{}
+	uncompressedPubKeys map[[65]byte]struct{}
+	otherAddresses      map[string]struct{}
+
+	// unspent holds the outpoints which are relevant to the client.  The
+	// outputs of matching transactions are added automatically so
+	// transactions spending them match as well.
+	unspent map[wire.OutPoint]struct{}
+
+	// params are the network parameters used to extract the addresses
+	// paid to by transaction outputs.
+	params *chaincfg.Params
+}
+
+// newWSClientFilter returns a new websocket client filter for the passed
+// decoded addresses and unspent outpoints.
+func newWSClientFilter(addrs []colxutil.Address, unspentOutPoints []*wire.OutPoint, params *chaincfg.Params) *wsClientFilter {
+	filter := &wsClientFilter{
+		pubKeyHashes:        map[[ripemd160.Size]byte]struct{}{},
+		scriptHashes:        map[[ripemd160.Size]byte]struct{}{},
+		compressedPubKeys:   map[[33]byte]struct{}{},
+		uncompressedPubKeys: map[[65]byte]struct{}{},
+		otherAddresses:      map[string]struct{}{},
+		unspent:             make(map[wire.OutPoint]struct{}, len(unspentOutPoints)),
+		params:              params,
+	}
+	for _, addr := range addrs {
+		filter.addAddress(addr)
+	}
+	for _, op := range unspentOutPoints {
+		filter.addUnspentOutPoint(op)
+	}
+	return filter
+}
+
+// addAddress adds the passed address to the filter.
+//
+// This function MUST be called with the filter lock held.
+func (f *wsClientFilter) addAddress(a colxutil.Address) {
+	switch a := a.(type) {
+	case *colxutil.AddressPubKeyHash:
+		f.pubKeyHashes[*a.Hash160()] = struct{}{}
+		return
+
+	case *colxutil.AddressScriptHash:
+		f.scriptHashes[*a.Hash160()] = struct{}{}
+		return
+
+	case *colxutil.AddressPubKey:
+		serializedPubKey := a.ScriptAddress()
+		switch len(serializedPubKey) {
+		case 33: // Compressed
+			var compressedPubKey [33]byte
+			copy(compressedPubKey[:], serializedPubKey)
+			f.compressedPubKeys[compressedPubKey] = struct{}{}
+			return
+
+		case 65: // Uncompressed
+			var uncompressedPubKey [65]byte
+			copy(uncompressedPubKey[:], serializedPubKey)
+			f.uncompressedPubKeys[uncompressedPubKey] = struct{}{}
+			return
+		}
+	}
+
+	f.otherAddresses[a.EncodeAddress()] = struct{}{}
+}
+
+// existsAddress returns whether the passed address is relevant to the filter.
+// A pubkey address is also relevant when the filter contains its P2PKH
+// address.
+//
+// This function MUST be called with the filter lock held.
+func (f *wsClientFilter) existsAddress(a colxutil.Address) bool {
+	switch a := a.(type) {
+	case *colxutil.AddressPubKeyHash:
+		_, ok := f.pubKeyHashes[*a.Hash160()]
+		return ok
+
+	case *colxutil.AddressScriptHash:
+		_, ok := f.scriptHashes[*a.Hash160()]
+		return ok
+
+	case *colxutil.AddressPubKey:
+		serializedPubKey := a.ScriptAddress()
+		switch len(serializedPubKey) {
+		case 33: // Compressed
+			var compressedPubKey [33]byte
+			copy(compressedPubKey[:], serializedPubKey)
+			if _, ok := f.compressedPubKeys[compressedPubKey]; ok {
+				return true
+			}
+
+		case 65: // Uncompressed
+			var uncompressedPubKey [65]byte
+			copy(uncompressedPubKey[:], serializedPubKey)
+			if _, ok := f.uncompressedPubKeys[uncompressedPubKey]; ok {
+				return true
+			}
+		}
+
+		_, ok := f.pubKeyHashes[*a.AddressPubKeyHash().Hash160()]
+		return ok
+	}
+
+	_, ok := f.otherAddresses[a.EncodeAddress()]
+	return ok
+}
+
+// addUnspentOutPoint adds the passed outpoint to the filter.
+//
+// This function MUST be called with the filter lock held.
+func (f *wsClientFilter) addUnspentOutPoint(op *wire.OutPoint) {
+	f.unspent[*op] = struct{}{}
+}
+
+// existsUnspentOutPoint returns whether the passed outpoint is relevant to the
+// filter.
+//
+// This function MUST be called with the filter lock held.
+func (f *wsClientFilter) existsUnspentOutPoint(op *wire.OutPoint) bool {
+	_, ok := f.unspent[*op]
+	return ok
+}
+
+// matchAndUpdate returns whether the passed transaction is relevant to the
+// filter, which is the case when it spends a relevant outpoint or pays to a
+// relevant address.  The outpoints of all outputs paying to a relevant address
+// are added to the filter so later transactions spending them match as well.
+// Spent outpoints remain in the filter since the same transaction may be seen
+// both when it is accepted to the mempool and when it is mined.
+//
+// This is the only place transactions are matched against a filter, for both
+// the live notifications and the rescanblocks command.
+//
+// This function MUST be called with the filter lock held.
+func (f *wsClientFilter) matchAndUpdate(tx *colxutil.Tx) bool {
+	matched := false
+	for _, txIn := range tx.MsgTx().TxIn {
+		if f.existsUnspentOutPoint(&txIn.PreviousOutPoint) {
+			matched = true
+			break
+		}
+	}
+
+	for i, txOut := range tx.MsgTx().TxOut {
+		_, addrs, _, _ := txscript.ExtractPkScriptAddrs(txOut.PkScript,
+			f.params)
+		for _, addr := range addrs {
+			if !f.existsAddress(addr) {
+				continue
+			}
+			matched = true
+			f.addUnspentOutPoint(wire.NewOutPoint(tx.Sha(), uint32(i)))
+			break
+		}
+	}
+
+	return matched
+}
+
+// filterTx returns whether the passed transaction is relevant to the filter
+// while updating the filter with its relevant outputs.
+//
+// This function is safe for concurrent access.
+func (f *wsClientFilter) filterTx(tx *colxutil.Tx) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.matchAndUpdate(tx)
+}
+
+// filterBlock returns the hex-encoded serializations of the transactions in the
+// passed block which are relevant to the filter, in block order, while updating
+// the filter with their relevant outputs.  Since the filter is updated as the
+// transactions are examined, transactions spending the outputs of earlier
+// relevant transactions in the same block match as well.
+//
+// This function is safe for concurrent access.
+func (f *wsClientFilter) filterBlock(block *colxutil.Block) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var txs []string
+	for _, tx := range block.Transactions() {
+		if f.matchAndUpdate(tx) {
+			txs = append(txs, txHexString(tx))
+		}
+	}
+	return txs
+}
+
 // ErrRescanReorg defines the error that is returned when an unrecoverable
 // reorganize is detected during a rescan.
 var ErrRescanReorg = btcjson.RPCError{
@@ -0,0 +1,361 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/btcjson"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// newFilterTestAddr returns a pay-to-pubkey-hash address for the passed network
+// which is unique for the passed byte along with a script paying to it.
+func newFilterTestAddr(t *testing.T, params *chaincfg.Params, b byte) (string, []byte) {
+	pkHash := make([]byte, 20)
+	pkHash[0] = b
+	addr, err := colxutil.NewAddressPubKeyHash(pkHash, params)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+	pkScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+	return addr.EncodeAddress(), pkScript
+}
+
+// newFilterTestTx returns a transaction spending the passed outpoint with a
+// single output paying to the passed script.
+func newFilterTestTx(prevOut *wire.OutPoint, pkScript []byte) *colxutil.Tx {
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(prevOut, nil))
+	tx.AddTxOut(wire.NewTxOut(colxutil.SatoshiPerBitcoin, pkScript))
+	return colxutil.NewTx(tx)
+}
+
+// loadTestTxFilter loads a transaction filter for the passed websocket client
+// via the loadtxfilter command.
+func loadTestTxFilter(t *testing.T, wsc *wsClient, reload bool, addrs []string, ops []btcjson.OutPoint) {
+	cmd := btcjson.NewLoadTxFilterCmd(reload, addrs, ops)
+	if _, err := handleLoadTxFilter(wsc, cmd); err != nil {
+		t.Fatalf("loadtxfilter: unexpected error: %v", err)
+	}
+}
+
+// queuedNotifications returns the notifications queued for the passed
+// websocket client, which must have a buffered notification channel.
+func queuedNotifications(t *testing.T, wsc *wsClient) []interface{} {
+	var ntfns []interface{}
+	for {
+		select {
+		case marshalled := <-wsc.ntfnChan:
+			var request btcjson.Request
+			if err := json.Unmarshal(marshalled, &request); err != nil {
+				t.Fatalf("unable to unmarshal notification: %v",
+					err)
+			}
+			ntfn, err := btcjson.UnmarshalCmd(&request)
+			if err != nil {
+				t.Fatalf("unable to unmarshal notification: %v",
+					err)
+			}
+			ntfns = append(ntfns, ntfn)
+		default:
+			return ntfns
+		}
+	}
+}
+
+// TestHandleLoadTxFilter ensures the loadtxfilter command replaces the filter
+// of a client when reloading or when no filter is loaded, augments it
+// otherwise, and leaves it untouched when the parameters are invalid.
+func TestHandleLoadTxFilter(t *testing.T) {
+	defer func(p *params) { activeNetParams = p }(activeNetParams)
+	activeNetParams = &regressionNetParams
+	netParams := activeNetParams.Params
+
+	addrA, scriptA := newFilterTestAddr(t, netParams, 1)
+	addrB, scriptB := newFilterTestAddr(t, netParams, 2)
+	prevOut := wire.NewOutPoint(&wire.ShaHash{0x01}, 0)
+	ops := []btcjson.OutPoint{{Hash: prevOut.Hash.String(), Index: 0}}
+	txA := newFilterTestTx(&wire.OutPoint{}, scriptA)
+	txB := newFilterTestTx(&wire.OutPoint{}, scriptB)
+	txSpend := newFilterTestTx(prevOut, []byte{txscript.OP_TRUE})
+
+	wsc := &wsClient{}
+	assertMatches := func(name string, want []bool) {
+		t.Helper()
+		wsc.Lock()
+		filter := wsc.filterData
+		wsc.Unlock()
+		for i, tx := range []*colxutil.Tx{txA, txB, txSpend} {
+			if got := filter.filterTx(tx); got != want[i] {
+				t.Fatalf("%s: tx %d: got match %v, want %v",
+					name, i, got, want[i])
+			}
+		}
+	}
+
+	// Loading without reload creates the filter when none exists.
+	loadTestTxFilter(t, wsc, false, []string{addrA}, nil)
+	assertMatches("initial load", []bool{true, false, false})
+
+	// Loading without reload augments the existing filter.
+	loadTestTxFilter(t, wsc, false, []string{addrB}, ops)
+	assertMatches("augment", []bool{true, true, true})
+
+	// Invalid parameters do not modify the filter.
+	cmd := btcjson.NewLoadTxFilterCmd(true, []string{addrA, "invalid"}, nil)
+	if _, err := handleLoadTxFilter(wsc, cmd); err == nil {
+		t.Fatal("loadtxfilter: unexpectedly accepted invalid address")
+	}
+	badOps := []btcjson.OutPoint{{Hash: "zz", Index: 0}}
+	cmd = btcjson.NewLoadTxFilterCmd(true, nil, badOps)
+	if _, err := handleLoadTxFilter(wsc, cmd); err == nil {
+		t.Fatal("loadtxfilter: unexpectedly accepted invalid outpoint")
+	}
+	assertMatches("invalid reload", []bool{true, true, true})
+
+	// Reloading replaces the filter.
+	loadTestTxFilter(t, wsc, true, []string{addrB}, nil)
+	assertMatches("reload", []bool{false, true, false})
+}
+
+// TestWSClientFilterMatching ensures transactions are matched when they pay to
+// a filtered address or spend a filtered outpoint, including outpoints added
+// automatically for earlier matching transactions, and that the transactions
+// reported by the live mempool and block notifications match.
+func TestWSClientFilterMatching(t *testing.T) {
+	defer func(p *params) { activeNetParams = p }(activeNetParams)
+	activeNetParams = &regressionNetParams
+	netParams := activeNetParams.Params
+
+	addrA, scriptA := newFilterTestAddr(t, netParams, 1)
+	_, scriptOther := newFilterTestAddr(t, netParams, 2)
+
+	// Create a block with a transaction paying to the filtered address, a
+	// transaction spending it, a transaction spending that one, a
+	// transaction spending a filtered outpoint, and an unrelated
+	// transaction.
+	loadedOut := wire.NewOutPoint(&wire.ShaHash{0x01}, 1)
+	payTx := newFilterTestTx(&wire.OutPoint{}, scriptA)
+	spendTx := newFilterTestTx(wire.NewOutPoint(payTx.Sha(), 0),
+		scriptOther)
+	chainedTx := newFilterTestTx(wire.NewOutPoint(spendTx.Sha(), 0),
+		scriptOther)
+	loadedTx := newFilterTestTx(loadedOut, scriptOther)
+	unrelatedTx := newFilterTestTx(wire.NewOutPoint(&wire.ShaHash{0x02}, 0),
+		scriptOther)
+	txns := []*colxutil.Tx{payTx, spendTx, chainedTx, loadedTx, unrelatedTx}
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{
+		Version:   4,
+		Timestamp: time.Unix(1400000000, 0),
+	})
+	for _, tx := range txns {
+		msgBlock.AddTransaction(tx.MsgTx())
+	}
+	block := colxutil.NewBlock(msgBlock)
+	block.SetHeight(10)
+	wantTxs := []string{txHexString(payTx), txHexString(spendTx),
+		txHexString(loadedTx)}
+
+	ops := []btcjson.OutPoint{{Hash: loadedOut.Hash.String(),
+		Index: loadedOut.Index}}
+	newClient := func() *wsClient {
+		wsc := &wsClient{ntfnChan: make(chan []byte, len(txns))}
+		loadTestTxFilter(t, wsc, false, []string{addrA}, ops)
+		return wsc
+	}
+
+	// Ensure the block notification includes the expected transactions
+	// and the output paying to the filtered address is added to the
+	// filter.
+	m := &wsNotificationManager{}
+	blockClient := newClient()
+	m.notifyFilteredBlockConnected(map[chan struct{}]*wsClient{
+		nil: blockClient}, block)
+	ntfns := queuedNotifications(t, blockClient)
+	if len(ntfns) != 1 {
+		t.Fatalf("got %d block notifications, want 1", len(ntfns))
+	}
+	blockNtfn, ok := ntfns[0].(*btcjson.FilteredBlockConnectedNtfn)
+	if !ok {
+		t.Fatalf("unexpected block notification type %T", ntfns[0])
+	}
+	if blockNtfn.Height != 10 {
+		t.Fatalf("unexpected height - got %d, want 10",
+			blockNtfn.Height)
+	}
+	if !reflect.DeepEqual(blockNtfn.SubscribedTxs, wantTxs) {
+		t.Fatalf("unexpected block transactions - got %v, want %v",
+			blockNtfn.SubscribedTxs, wantTxs)
+	}
+	payOut := wire.NewOutPoint(payTx.Sha(), 0)
+	if !blockClient.filterData.existsUnspentOutPoint(payOut) {
+		t.Fatal("matching output was not added to the filter")
+	}
+
+	// Ensure the same transactions are reported when they are accepted to
+	// the mempool one at a time.
+	mempoolClient := newClient()
+	clients := map[chan struct{}]*wsClient{nil: mempoolClient}
+	for _, tx := range txns {
+		m.notifyRelevantTxAccepted(clients, tx)
+	}
+	var mempoolTxs []string
+	for _, ntfn := range queuedNotifications(t, mempoolClient) {
+		txNtfn, ok := ntfn.(*btcjson.RelevantTxAcceptedNtfn)
+		if !ok {
+			t.Fatalf("unexpected mempool notification type %T", ntfn)
+		}
+		mempoolTxs = append(mempoolTxs, txNtfn.Transaction)
+	}
+	if !reflect.DeepEqual(mempoolTxs, wantTxs) {
+		t.Fatalf("unexpected mempool transactions - got %v, want %v",
+			mempoolTxs, wantTxs)
+	}
+
+	// Ensure seeing the transactions again once mined, after they were
+	// accepted to the mempool, reports them again.
+	m.notifyFilteredBlockConnected(clients, block)
+	ntfns = queuedNotifications(t, mempoolClient)
+	if len(ntfns) != 1 {
+		t.Fatalf("got %d block notifications, want 1", len(ntfns))
+	}
+	blockNtfn = ntfns[0].(*btcjson.FilteredBlockConnectedNtfn)
+	if !reflect.DeepEqual(blockNtfn.SubscribedTxs, wantTxs) {
+		t.Fatalf("unexpected mined transactions - got %v, want %v",
+			blockNtfn.SubscribedTxs, wantTxs)
+	}
+
+	// Ensure clients without a filter receive block notifications without
+	// transactions and no mempool notifications.
+	plainClient := &wsClient{ntfnChan: make(chan []byte, len(txns))}
+	clients = map[chan struct{}]*wsClient{nil: plainClient}
+	m.notifyRelevantTxAccepted(clients, payTx)
+	m.notifyFilteredBlockConnected(clients, block)
+	ntfns = queuedNotifications(t, plainClient)
+	if len(ntfns) != 1 {
+		t.Fatalf("got %d notifications without a filter, want 1",
+			len(ntfns))
+	}
+	blockNtfn = ntfns[0].(*btcjson.FilteredBlockConnectedNtfn)
+	if len(blockNtfn.SubscribedTxs) != 0 {
+		t.Fatalf("unexpected transactions without a filter: %v",
+			blockNtfn.SubscribedTxs)
+	}
+}
+
+// TestHandleRescanBlocks ensures the rescanblocks command returns the matching
+// transactions of the requested blocks using the filter loaded by the client,
+// that it adds the outpoints it discovers to the filter, and that it rejects
+// requests without a filter, with unknown blocks, or with blocks which are not
+// consecutive.
+func TestHandleRescanBlocks(t *testing.T) {
+	defer func(p *params) { activeNetParams = p }(activeNetParams)
+	activeNetParams = &regressionNetParams
+	netParams := activeNetParams.Params
+
+	addrA, scriptA := newFilterTestAddr(t, netParams, 1)
+
+	// Pay to the filtered address in every other block.
+	chain, blocks, teardown := newScanTestChain(t, netParams, 4,
+		func(height int32) [][]byte {
+			if height%2 == 1 {
+				return [][]byte{scriptA}
+			}
+			return nil
+		})
+	defer teardown()
+
+	wsc := &wsClient{server: &rpcServer{
+		server: &server{chainParams: netParams},
+		chain:  chain,
+	}}
+	hashes := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		hashes = append(hashes, block.Sha().String())
+	}
+	rescan := func(hashes []string) ([]btcjson.RescannedBlock, error) {
+		reply, err := handleRescanBlocks(wsc,
+			btcjson.NewRescanBlocksCmd(hashes))
+		if err != nil {
+			return nil, err
+		}
+		return reply.([]btcjson.RescannedBlock), nil
+	}
+	assertRPCError := func(name string, err error, code btcjson.RPCErrorCode) {
+		t.Helper()
+		rpcErr, ok := err.(*btcjson.RPCError)
+		if !ok || rpcErr.Code != code {
+			t.Fatalf("%s: unexpected error - got %v, want code %d",
+				name, err, code)
+		}
+	}
+
+	// A filter must be loaded first.
+	_, err := rescan(hashes)
+	assertRPCError("no filter", err, btcjson.ErrRPCMisc)
+
+	loadTestTxFilter(t, wsc, false, []string{addrA}, nil)
+	results, err := rescan(hashes)
+	if err != nil {
+		t.Fatalf("rescanblocks: unexpected error: %v", err)
+	}
+	var want []btcjson.RescannedBlock
+	for _, i := range []int{0, 2} {
+		coinbase := blocks[i].Transactions()[0]
+		want = append(want, btcjson.RescannedBlock{
+			Hash:         blocks[i].Sha().String(),
+			Transactions: []string{txHexString(coinbase)},
+		})
+		op := wire.NewOutPoint(coinbase.Sha(), 0)
+		if !wsc.filterData.existsUnspentOutPoint(op) {
+			t.Fatalf("outpoint %v was not added to the filter", op)
+		}
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Fatalf("unexpected results - got %+v, want %+v", results,
+			want)
+	}
+
+	// Ensure the rescan agrees with the block notifications for a client
+	// with the same filter.
+	ntfnClient := &wsClient{ntfnChan: make(chan []byte, len(blocks))}
+	loadTestTxFilter(t, ntfnClient, false, []string{addrA}, nil)
+	m := &wsNotificationManager{}
+	for _, block := range blocks {
+		m.notifyFilteredBlockConnected(map[chan struct{}]*wsClient{
+			nil: ntfnClient}, block)
+	}
+	var ntfnResults []btcjson.RescannedBlock
+	for i, ntfn := range queuedNotifications(t, ntfnClient) {
+		txs := ntfn.(*btcjson.FilteredBlockConnectedNtfn).SubscribedTxs
+		if len(txs) != 0 {
+			ntfnResults = append(ntfnResults, btcjson.RescannedBlock{
+				Hash:         blocks[i].Sha().String(),
+				Transactions: txs,
+			})
+		}
+	}
+	if !reflect.DeepEqual(ntfnResults, results) {
+		t.Fatalf("rescan and notifications differ - got %+v, want %+v",
+			ntfnResults, results)
+	}
+
+	// Blocks must be consecutive and known.
+	_, err = rescan([]string{hashes[0], hashes[2]})
+	assertRPCError("non-consecutive", err, btcjson.ErrRPCInvalidParameter)
+	_, err = rescan([]string{(&wire.ShaHash{0x01}).String()})
+	assertRPCError("unknown block", err, btcjson.ErrRPCBlockNotFound)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// parseScanDescriptor parses the passed output descriptor and returns the
+// public key script it describes.  Only the addr(<address>) form, which
+// describes outputs paying to an address for the passed network, and the
+// raw(<hex-encoded script>) form, which describes outputs with exactly the
+// passed script, are supported.
+func parseScanDescriptor(desc string, params *chaincfg.Params) ([]byte, error) {
+	open := strings.IndexByte(desc, '(')
+	if open < 0 || !strings.HasSuffix(desc, ")") {
+		return nil, fmt.Errorf("descriptor %q is not of the form "+
+			"name(argument)", desc)
+	}
+	name, arg := desc[:open], desc[open+1:len(desc)-1]
+	if arg == "" {
+		return nil, fmt.Errorf("descriptor %q has an empty argument", desc)
+	}
+
+	switch name {
+	case "addr":
+		addr, err := colxutil.DecodeAddress(arg, params)
+		if err != nil {
+			return nil, fmt.Errorf("descriptor %q has an invalid "+
+				"address: %v", desc, err)
+		}
+		if !addr.IsForNet(params) {
+			return nil, fmt.Errorf("descriptor %q has an address "+
+				"for the wrong network", desc)
+		}
+		return txscript.PayToAddrScript(addr)
+
+	case "raw":
+		pkScript, err := hex.DecodeString(arg)
+		if err != nil {
+			return nil, fmt.Errorf("descriptor %q has an invalid "+
+				"hex-encoded script: %v", desc, err)
+		}
+		return pkScript, nil
+	}
+
+	return nil, fmt.Errorf("descriptor %q is not supported, only addr and "+
+		"raw descriptors are", desc)
+}
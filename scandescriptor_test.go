@@ -0,0 +1,90 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestParseScanDescriptor ensures the output descriptors accepted by the
+// scantxoutset command are parsed into the expected scripts and malformed or
+// unsupported descriptors are rejected.
+func TestParseScanDescriptor(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	addr, err := colxutil.NewAddressPubKeyHash(make([]byte, 20), params)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+	addrScript, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+	mainAddr, err := colxutil.NewAddressPubKeyHash(make([]byte, 20),
+		&chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatalf("unable to create address: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		desc  string
+		want  []byte
+		valid bool
+	}{
+		{
+			name:  "address",
+			desc:  "addr(" + addr.EncodeAddress() + ")",
+			want:  addrScript,
+			valid: true,
+		},
+		{
+			name:  "raw script",
+			desc:  "raw(" + hex.EncodeToString(addrScript) + ")",
+			want:  addrScript,
+			valid: true,
+		},
+		{
+			name:  "raw op_true",
+			desc:  "raw(51)",
+			want:  []byte{txscript.OP_TRUE},
+			valid: true,
+		},
+		{name: "empty", desc: ""},
+		{name: "no parens", desc: "addr"},
+		{name: "unterminated", desc: "addr(" + addr.EncodeAddress()},
+		{name: "trailing data", desc: "raw(51)#checksum"},
+		{name: "empty argument", desc: "raw()"},
+		{name: "invalid address", desc: "addr(notanaddress)"},
+		{name: "wrong network", desc: "addr(" + mainAddr.EncodeAddress() + ")"},
+		{name: "invalid hex", desc: "raw(5z)"},
+		{name: "odd hex", desc: "raw(515)"},
+		{name: "unsupported", desc: "pkh(" + addr.EncodeAddress() + ")"},
+	}
+	for _, test := range tests {
+		got, err := parseScanDescriptor(test.desc, params)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: unexpectedly parsed %q", test.name,
+					test.desc)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if !bytes.Equal(got, test.want) {
+			t.Errorf("%s: unexpected script - got %x, want %x",
+				test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/addrmgr"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+const (
+	// selfAdvertiseMaxDelay is the maximum randomized delay after the
+	// handshake of an outbound connection before our own address is
+	// advertised to the peer.  The delay keeps the advertisement from
+	// being trivially linked to the handshake and spreads the messages out
+	// when many connections are made at once.
+	selfAdvertiseMaxDelay = 30 * time.Second
+
+	// selfAdvertiseInterval is the interval at which our own address is
+	// advertised again to all connected peers so the network keeps a fresh
+	// timestamp for it.
+	selfAdvertiseInterval = 24 * time.Hour
+)
+
+// clock provides the current time and timers for the time dependent behavior
+// of the server so it can be tested without waiting in real time.
+type clock interface {
+	// Now returns the current time.
+	Now() time.Time
+
+	// After returns a channel which receives the current time once the
+	// passed duration has elapsed.
+	After(d time.Duration) <-chan time.Time
+}
+
+// realClock is the clock implementation which uses the system time.
+type realClock struct{}
+
+// Now returns the current system time.
+//
+// This is part of the clock interface implementation.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// After returns a channel which receives the current system time once the
+// passed duration has elapsed.
+//
+// This is part of the clock interface implementation.
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// selfAdvertisementEnabled returns whether our own address is advertised to
+// peers at all.  It is not when disabled by the configuration, when we are not
+// listening for inbound connections, or on the simulation test network which
+// actively avoids advertising addresses.
+func selfAdvertisementEnabled() bool {
+	return !cfg.NoSelfAdvertise && !cfg.DisableListen && !cfg.SimNet
+}
+
+// selfAdvertisement returns our own address to advertise to the passed peer,
+// or nil when it must not be advertised because self advertisement is not
+// enabled or the best local address for the peer is not routable.
+func (s *server) selfAdvertisement(sp *serverPeer) *wire.NetAddress {
+	if !selfAdvertisementEnabled() {
+		return nil
+	}
+	na := s.addrManager.GetBestLocalAddress(sp.NA())
+	if !addrmgr.IsRoutable(na) {
+		return nil
+	}
+	return na
+}
+
+// advertiseSelf queues an addr message with our own address to the passed peer
+// when it is still connected and the address may be advertised to it.  It
+// returns whether the address was advertised.
+func (s *server) advertiseSelf(sp *serverPeer) bool {
+	if !sp.Connected() {
+		return false
+	}
+	na := s.selfAdvertisement(sp)
+	if na == nil {
+		return false
+	}
+	srvrLog.Debugf("Advertising local address %s to %s",
+		addrmgr.NetAddressKey(na), sp)
+	sp.QueueSelfAdvertisement(na)
+	return true
+}
+
+// scheduleSelfAdvertisement advertises our own address to the passed peer once
+// a randomized delay after the handshake has elapsed.  It only schedules one
+// advertisement per connection, and nothing is scheduled when the address may
+// not be advertised to the peer.
+func (s *server) scheduleSelfAdvertisement(sp *serverPeer) {
+	if !atomic.CompareAndSwapInt32(&sp.selfAdvertised, 0, 1) {
+		return
+	}
+	if s.selfAdvertisement(sp) == nil {
+		return
+	}
+
+	delay := time.Duration(rand.Int63n(int64(selfAdvertiseMaxDelay)))
+	timer := s.clock.After(delay)
+	go func() {
+		select {
+		case <-timer:
+			s.advertiseSelf(sp)
+		case <-sp.quit:
+		case <-s.quit:
+		}
+	}()
+}
+
+// refreshSelfAdvertisements advertises our own address again to all of the
+// passed peers it may be advertised to and returns how many of them it was
+// advertised to.
+func (s *server) refreshSelfAdvertisements(peers []*serverPeer) int {
+	var advertised int
+	for _, sp := range peers {
+		if s.advertiseSelf(sp) {
+			advertised++
+		}
+	}
+	return advertised
+}
+
+// selfAdvertiseHandler periodically advertises our own address again to all
+// connected peers.  It must be run as a goroutine.
+func (s *server) selfAdvertiseHandler() {
+out:
+	for {
+		select {
+		case <-s.clock.After(selfAdvertiseInterval):
+			n := s.refreshSelfAdvertisements(s.Peers())
+			srvrLog.Debugf("Advertised local address to %d peers", n)
+
+		case <-s.quit:
+			break out
+		}
+	}
+
+	s.wg.Done()
+}
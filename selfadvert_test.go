@@ -0,0 +1,291 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/addrmgr"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/peer"
+	"github.com/tinhnguyenhn/colxd/peer/peertest"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// fakeClock is a virtual clock which only advances when requested so tests
+// can control the time dependent behavior of the server.
+type fakeClock struct {
+	mtx     sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	waiters []fakeClockWaiter
+}
+
+// fakeClockWaiter is a channel returned by fakeClock.After along with the time
+// it fires at.
+type fakeClockWaiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+// newFakeClock returns a virtual clock which starts at the passed time.
+func newFakeClock(now time.Time) *fakeClock {
+	c := &fakeClock{now: now}
+	c.cond = sync.NewCond(&c.mtx)
+	return c
+}
+
+// Now returns the current virtual time.
+//
+// This is part of the clock interface implementation.
+func (c *fakeClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.now
+}
+
+// After returns a channel which receives the virtual time once the clock has
+// been advanced by the passed duration.
+//
+// This is part of the clock interface implementation.
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeClockWaiter{c.now.Add(d), ch})
+	c.cond.Broadcast()
+	return ch
+}
+
+// Advance advances the virtual time by the passed duration and fires the
+// channels returned by After which are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.now = c.now.Add(d)
+	waiters := c.waiters[:0]
+	for _, waiter := range c.waiters {
+		if waiter.deadline.After(c.now) {
+			waiters = append(waiters, waiter)
+			continue
+		}
+		waiter.c <- c.now
+	}
+	c.waiters = waiters
+}
+
+// NumWaiters returns the number of channels returned by After which have not
+// fired yet.
+func (c *fakeClock) NumWaiters() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.waiters)
+}
+
+// WaitForWaiters blocks until the passed number of channels returned by After
+// are waiting to fire.
+func (c *fakeClock) WaitForWaiters(n int) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// TestSelfAdvertisement ensures our own address is advertised exactly once to
+// each eligible outbound peer after a randomized delay, that it is suppressed
+// when disabled, when not listening, or when the address is not routable, and
+// that it is advertised again to all peers once a day.
+func TestSelfAdvertisement(t *testing.T) {
+	defer func(c *config) { cfg = c }(cfg)
+	cfg = &config{}
+
+	dataDir, err := ioutil.TempDir("", "selfadvert")
+	if err != nil {
+		t.Fatalf("TempDir: unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	localNA := wire.NewNetAddressIPPort(net.ParseIP("13.1.1.1"), 8333,
+		wire.SFNodeNetwork)
+	amgr := addrmgr.New(dataDir, nil)
+	if err := amgr.AddLocalAddress(localNA, addrmgr.ManualPrio); err != nil {
+		t.Fatalf("AddLocalAddress: unexpected error: %v", err)
+	}
+	clock := newFakeClock(time.Unix(1400000000, 0))
+	s := &server{
+		addrManager: amgr,
+		clock:       clock,
+		query:       make(chan interface{}),
+		quit:        make(chan struct{}),
+	}
+
+	// connectPeer connects an outbound peer of the server to the passed
+	// remote address and returns it along with a channel which receives
+	// the addr messages the remote side reads.
+	params := &chaincfg.RegressionNetParams
+	connectPeer := func(remoteAddr string) (*serverPeer, <-chan *wire.MsgAddr) {
+		localConn, remoteConn := peertest.Pipe("13.1.1.1:8333",
+			remoteAddr)
+		addrMsgs := make(chan *wire.MsgAddr, 10)
+		go func() {
+			for {
+				msg, _, err := wire.ReadMessage(remoteConn,
+					wire.ProtocolVersion, params.Net)
+				if err != nil {
+					return
+				}
+				switch msg := msg.(type) {
+				case *wire.MsgVersion:
+					na := wire.NewNetAddressIPPort(
+						net.ParseIP("10.0.0.1"), 8333, 0)
+					version := wire.NewMsgVersion(na, na,
+						0x0102030405060708, 0)
+					for _, reply := range []wire.Message{version,
+						wire.NewMsgVerAck()} {
+						err := wire.WriteMessage(remoteConn,
+							reply, wire.ProtocolVersion,
+							params.Net)
+						if err != nil {
+							return
+						}
+					}
+				case *wire.MsgAddr:
+					addrMsgs <- msg
+				}
+			}
+		}()
+
+		sp := newServerPeer(s, false)
+		p, err := peer.NewOutboundPeer(&peer.Config{
+			ChainParams: params,
+		}, remoteAddr)
+		if err != nil {
+			t.Fatalf("NewOutboundPeer: unexpected error: %v", err)
+		}
+		sp.Peer = p
+		sp.Connect(localConn)
+		for start := time.Now(); !sp.VerAckReceived(); {
+			if time.Since(start) > time.Second*5 {
+				t.Fatalf("verack not received from %s", remoteAddr)
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+		return sp, addrMsgs
+	}
+	expectAdvertisement := func(addrMsgs <-chan *wire.MsgAddr) {
+		select {
+		case msg := <-addrMsgs:
+			if len(msg.AddrList) != 1 {
+				t.Fatalf("got %d advertised addresses, want 1",
+					len(msg.AddrList))
+			}
+			got := addrmgr.NetAddressKey(msg.AddrList[0])
+			if want := addrmgr.NetAddressKey(localNA); got != want {
+				t.Fatalf("unexpected advertised address - got "+
+					"%s, want %s", got, want)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatalf("address not advertised")
+		}
+	}
+	expectNoAdvertisement := func(addrMsgs <-chan *wire.MsgAddr) {
+		select {
+		case <-addrMsgs:
+			t.Fatalf("unexpected address advertisement")
+		case <-time.After(time.Millisecond * 100):
+		}
+	}
+
+	// The address is advertised once the randomized delay has elapsed, and
+	// only once no matter how often it is scheduled for the connection.
+	sp1, addrMsgs1 := connectPeer("12.1.1.1:8333")
+	defer sp1.Disconnect()
+	s.scheduleSelfAdvertisement(sp1)
+	s.scheduleSelfAdvertisement(sp1)
+	if n := clock.NumWaiters(); n != 1 {
+		t.Fatalf("got %d scheduled advertisements, want 1", n)
+	}
+	clock.Advance(selfAdvertiseMaxDelay)
+	expectAdvertisement(addrMsgs1)
+	expectNoAdvertisement(addrMsgs1)
+	s.scheduleSelfAdvertisement(sp1)
+	clock.Advance(selfAdvertiseMaxDelay)
+	expectNoAdvertisement(addrMsgs1)
+
+	// Nothing is scheduled when self advertisement is disabled, when not
+	// listening for inbound connections, or when the best local address
+	// for the peer is not routable.
+	sp2, addrMsgs2 := connectPeer("14.1.1.1:8333")
+	defer sp2.Disconnect()
+	suppressTests := []struct {
+		name string
+		cfg  config
+		amgr *addrmgr.AddrManager
+	}{
+		{"disabled", config{NoSelfAdvertise: true}, amgr},
+		{"not listening", config{DisableListen: true}, amgr},
+		{"not routable", config{}, addrmgr.New(dataDir, nil)},
+	}
+	for _, test := range suppressTests {
+		*cfg = test.cfg
+		s.addrManager = test.amgr
+		sp, addrMsgs := connectPeer("15.1.1.1:8333")
+		s.scheduleSelfAdvertisement(sp)
+		if n := clock.NumWaiters(); n != 0 {
+			t.Fatalf("%s: got %d scheduled advertisements, want 0",
+				test.name, n)
+		}
+		clock.Advance(selfAdvertiseMaxDelay)
+		expectNoAdvertisement(addrMsgs)
+		sp.Disconnect()
+	}
+	*cfg = config{}
+	s.addrManager = amgr
+
+	// The address is advertised again to all connected peers once a day,
+	// including the ones which were not eligible when they connected.
+	peers := []*serverPeer{sp1, sp2}
+	go func() {
+		for msg := range s.query {
+			if msg, ok := msg.(getPeersMsg); ok {
+				msg.reply <- peers
+			}
+		}
+	}()
+	s.wg.Add(1)
+	go s.selfAdvertiseHandler()
+	for i := 0; i < 2; i++ {
+		clock.WaitForWaiters(1)
+		clock.Advance(selfAdvertiseInterval / 2)
+		expectNoAdvertisement(addrMsgs1)
+		clock.Advance(selfAdvertiseInterval / 2)
+		expectAdvertisement(addrMsgs1)
+		expectAdvertisement(addrMsgs2)
+		expectNoAdvertisement(addrMsgs1)
+		expectNoAdvertisement(addrMsgs2)
+	}
+
+	// Peers which disconnected are skipped by the refresh.
+	sp2.Disconnect()
+	sp2.WaitForDisconnect()
+	clock.WaitForWaiters(1)
+	clock.Advance(selfAdvertiseInterval)
+	expectAdvertisement(addrMsgs1)
+	expectNoAdvertisement(addrMsgs2)
+
+	close(s.quit)
+	s.wg.Wait()
+}
@@ -14,6 +14,7 @@ import (
 	"math"
 	mrand "math/rand"
 	"net"
+	"os"
 	"runtime"
 	"strconv"
 	"strings"
@@ -30,8 +31,8 @@ import (
 	"github.com/tinhnguyenhn/colxd/peer"
 	"github.com/tinhnguyenhn/colxd/txscript"
 	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxd/wire/bloom"
 	"github.com/tinhnguyenhn/colxutil"
-	"github.com/tinhnguyenhn/colxutil/bloom"
 )
 
 const (
@@ -44,7 +45,8 @@ const (
 const (
 	// defaultServices describes the default services that are supported by
 	// the server.
-	defaultServices = wire.SFNodeNetwork | wire.SFNodeBloom
+	defaultServices = wire.SFNodeNetwork | wire.SFNodeBloom |
+		wire.SFNodeHeaderSkeleton
 
 	// defaultMaxOutbound is the default number of max outbound peers.
 	defaultMaxOutbound = 8
@@ -59,6 +61,17 @@ const (
 	// retry logic uses a backoff mechanism which increases the interval
 	// base done the number of retries that have been done.
 	maxConnectionRetryInterval = time.Minute * 5
+
+	// headerAnnounceDelay is the amount of time block headers which are
+	// announced to peers that prefer headers are held back so the headers
+	// of several blocks connected in quick succession are sent together.
+	headerAnnounceDelay = time.Millisecond * 100
+
+	// maxAnnounceCatchUpHeaders is the max number of headers a peer that
+	// prefers headers is sent ahead of a new block to connect it to the
+	// last block the peer is known to have.  Blocks further ahead of the
+	// peer are announced with an inventory message instead.
+	maxAnnounceCatchUpHeaders = 8
 )
 
 var (
@@ -78,14 +91,6 @@ type broadcastMsg struct {
 	excludePeers []*serverPeer
 }
 
-// broadcastInventoryAdd is a type used to declare that the InvVect it contains
-// needs to be added to the rebroadcast map
-type broadcastInventoryAdd relayMsg
-
-// broadcastInventoryDel is a type used to declare that the InvVect it contains
-// needs to be removed from the rebroadcast map
-type broadcastInventoryDel *wire.InvVect
-
 // relayMsg packages an inventory vector along with the newly discovered
 // inventory so the relay has access to that information.
 type relayMsg struct {
@@ -115,6 +120,10 @@ type peerState struct {
 	banned           map[string]time.Time
 	outboundGroups   map[string]int
 	maxOutboundPeers int
+
+	// headersScheduled is set while a wakeup to send the pending header
+	// announcements of the peers is scheduled.
+	headersScheduled bool
 }
 
 // Count returns the count of all known peers.
@@ -134,6 +143,12 @@ func (ps *peerState) NeedMoreOutbound() bool {
 		ps.Count() < cfg.MaxPeers
 }
 
+// NetGroupFull returns true if the number of outbound peers in the passed
+// network group has reached the configured maximum.
+func (ps *peerState) NetGroupFull(key string) bool {
+	return ps.outboundGroups[key] >= cfg.MaxConnsPerNetGroup
+}
+
 // NeedMoreTries returns true if more outbound peer attempts can be tried.
 func (ps *peerState) NeedMoreTries() bool {
 	return len(ps.pendingPeers) < 2*(ps.maxOutboundPeers-ps.OutboundCount())
@@ -178,31 +193,45 @@ type server struct {
 	shutdown      int32
 	shutdownSched int32
 
-	listeners            []net.Listener
-	chainParams          *chaincfg.Params
-	addrManager          *addrmgr.AddrManager
-	sigCache             *txscript.SigCache
-	rpcServer            *rpcServer
-	blockManager         *blockManager
-	txMemPool            *txMemPool
-	cpuMiner             *CPUMiner
-	modifyRebroadcastInv chan interface{}
-	pendingPeers         chan *serverPeer
-	newPeers             chan *serverPeer
-	donePeers            chan *serverPeer
-	banPeers             chan *serverPeer
-	retryPeers           chan *serverPeer
-	wakeup               chan struct{}
-	query                chan interface{}
-	relayInv             chan relayMsg
-	broadcast            chan broadcastMsg
-	peerHeightsUpdate    chan updatePeerHeightsMsg
-	wg                   sync.WaitGroup
-	quit                 chan struct{}
-	nat                  NAT
-	db                   database.DB
-	timeSource           blockchain.MedianTimeSource
-	services             wire.ServiceFlag
+	listeners         []net.Listener
+	chainParams       *chaincfg.Params
+	addrManager       *addrmgr.AddrManager
+	sigCache          *txscript.SigCache
+	rpcServer         *rpcServer
+	blockManager      *blockManager
+	txMemPool         *txMemPool
+	feeEstimator      *feeEstimator
+	templateNotifier  *mining.TemplateNotifier
+	cpuMiner          *CPUMiner
+	pendingPeers      chan *serverPeer
+	newPeers          chan *serverPeer
+	donePeers         chan *serverPeer
+	banPeers          chan *serverPeer
+	retryPeers        chan *serverPeer
+	wakeup            chan struct{}
+	query             chan interface{}
+	relayInv          chan relayMsg
+	broadcast         chan broadcastMsg
+	peerHeightsUpdate chan updatePeerHeightsMsg
+	wg                sync.WaitGroup
+	quit              chan struct{}
+	nat               NAT
+	db                database.DB
+	timeSource        blockchain.MedianTimeSource
+	clock             clock
+
+	// services houses the services advertised to peers.  The services of
+	// the optional indexes are only set while the index is synced with the
+	// best chain, so they are protected by the services mutex.
+	servicesMtx sync.RWMutex
+	services    wire.ServiceFlag
+
+	// externalAddrs houses the external addresses specified with the
+	// externalip option in the order they were specified.  The addresses
+	// outbound peers report seeing the server at are scored by
+	// extAddrScores so the most commonly seen of them is advertised.
+	externalAddrs []*wire.NetAddress
+	extAddrScores *peer.ExternalAddrScores
 
 	// The following fields are used for optional indexes.  They will be nil
 	// if the associated index is not enabled.  These fields are set during
@@ -210,6 +239,11 @@ type server struct {
 	// do not need to be protected for concurrent access.
 	txIndex   *indexers.TxIndex
 	addrIndex *indexers.AddrIndex
+
+	utxoByScriptIndex *indexers.UtxoByScriptIndex
+	cfIndex           *indexers.CfIndex
+	spendIndex        *indexers.SpendIndex
+	timeIndex         *indexers.TimeIndex
 }
 
 // serverPeer extends the peer to maintain state shared by the server and
@@ -227,7 +261,14 @@ type serverPeer struct {
 	requestedBlocks map[wire.ShaHash]struct{}
 	filter          *bloom.Filter
 	knownAddresses  map[string]struct{}
+	announcedBlock  *wire.ShaHash
+	pendingHeaders  []wire.BlockHeader
 	banScore        dynamicBanScore
+	isSyncPeer      int32 // Set atomically by the blockmanager.
+	selfAdvertised  int32 // Set atomically once our address is scheduled.
+	qualityMtx      sync.Mutex
+	quality         peerQuality
+	qualityTime     time.Time
 	quit            chan struct{}
 	// The following chans are used to sync blockmanager and server.
 	txProcessed    chan struct{}
@@ -289,6 +330,36 @@ func (sp *serverPeer) relayTxDisabled() bool {
 	return sp.disableRelayTx
 }
 
+// wantsTxRelay returns whether or not the transaction being relayed by the
+// passed message should be announced to the peer.  It is not announced when the
+// peer has transaction relaying disabled or has a bloom filter loaded which the
+// transaction does not match.  A match updates the filter according to its
+// update flags so transactions spending the matched outputs are announced too.
+//
+// It is safe for concurrent access.
+func (sp *serverPeer) wantsTxRelay(msg relayMsg) bool {
+	// Don't relay the transaction to the peer when it has transaction
+	// relaying disabled.
+	if sp.relayTxDisabled() {
+		return false
+	}
+
+	// Don't relay the transaction if there is a bloom filter loaded and the
+	// transaction doesn't match it.
+	if sp.filter.IsLoaded() {
+		tx, ok := msg.data.(*colxutil.Tx)
+		if !ok {
+			peerLog.Warnf("Underlying data for tx inv relay is not " +
+				"a transaction")
+			return false
+		}
+
+		return sp.filter.MatchTxAndUpdate(tx)
+	}
+
+	return true
+}
+
 // pushAddrMsg sends an addr message to the connected peer using the provided
 // addresses.
 func (sp *serverPeer) pushAddrMsg(addresses []*wire.NetAddress) {
@@ -342,6 +413,30 @@ func (sp *serverPeer) addBanScore(persistent, transient uint32, reason string) {
 	}
 }
 
+// RequestHeaderSkeleton requests up to count headers which are every
+// interval'th header following the block with the passed hash from the peer.
+// This is part of the netsync.HeaderSyncPeer interface implementation.
+func (sp *serverPeer) RequestHeaderSkeleton(start *wire.ShaHash, interval, count int) error {
+	msg := wire.NewMsgGetSkeleton(start, uint32(interval), uint32(count))
+	sp.QueueMessage(msg, nil)
+	return nil
+}
+
+// RequestHeaders requests the headers following the block with the passed
+// start hash up to and including the block with the passed stop hash from the
+// peer.  This is part of the netsync.HeaderSyncPeer interface implementation.
+func (sp *serverPeer) RequestHeaders(start, stop *wire.ShaHash) error {
+	locator := blockchain.BlockLocator([]*wire.ShaHash{start})
+	return sp.PushGetHeadersMsg(locator, stop)
+}
+
+// Misbehaving increases the ban score of the peer for serving headers which
+// are inconsistent with the rest of the chain.  This is part of the
+// netsync.HeaderSyncPeer interface implementation.
+func (sp *serverPeer) Misbehaving(reason string) {
+	sp.addBanScore(0, 50, reason)
+}
+
 // OnVersion is invoked when a peer receives a version bitcoin message
 // and is used to negotiate the protocol version details as well as kick start
 // the communications.
@@ -366,17 +461,9 @@ func (sp *serverPeer) OnVersion(p *peer.Peer, msg *wire.MsgVersion) {
 		addrManager := sp.server.addrManager
 		// Outbound connections.
 		if !p.Inbound() {
-			// TODO(davec): Only do this if not doing the initial block
-			// download and the local address is routable.
-			if !cfg.DisableListen /* && isCurrent? */ {
-				// Get address that best matches.
-				lna := addrManager.GetBestLocalAddress(p.NA())
-				if addrmgr.IsRoutable(lna) {
-					// Filter addresses the peer already knows about.
-					addresses := []*wire.NetAddress{lna}
-					sp.pushAddrMsg(addresses)
-				}
-			}
+			// Advertise our own address to the peer shortly after the
+			// handshake so the network learns how to reach us.
+			sp.server.scheduleSelfAdvertisement(sp)
 
 			// Request known addresses if the server address manager needs
 			// more and the peer has a protocol version new enough to
@@ -389,6 +476,7 @@ func (sp *serverPeer) OnVersion(p *peer.Peer, msg *wire.MsgVersion) {
 
 			// Mark the address as a known good address.
 			addrManager.Good(p.NA())
+			addrManager.MarkOutcome(p.NA(), addrmgr.OutcomeSuccess)
 		} else {
 			// A peer might not be advertising the same address that it
 			// actually connected from.  One example of why this can happen
@@ -433,6 +521,12 @@ func (sp *serverPeer) OnMemPool(p *peer.Peer, msg *wire.MsgMemPool) {
 			continue
 		}
 
+		// Don't announce transactions that were only accepted due to a
+		// relaxed relay policy.
+		if txDesc.NoRelay {
+			continue
+		}
+
 		// Either add all transactions when there is no bloom filter,
 		// or only the transactions that match the filter when there is
 		// one.
@@ -451,14 +545,16 @@ func (sp *serverPeer) OnMemPool(p *peer.Peer, msg *wire.MsgMemPool) {
 	}
 }
 
-// OnTx is invoked when a peer receives a tx bitcoin message.  It blocks
-// until the bitcoin transaction has been fully processed.  Unlock the block
-// handler this does not serialize all transactions through a single thread
-// transactions don't rely on the previous one in a linear fashion like blocks.
-func (sp *serverPeer) OnTx(p *peer.Peer, msg *wire.MsgTx) {
+// OnTxWithSha is invoked when a peer receives a tx bitcoin message along with
+// the hash of the transaction, which is known from the message checksum.  It
+// blocks until the bitcoin transaction has been fully processed.  Unlock the
+// block handler this does not serialize all transactions through a single
+// thread transactions don't rely on the previous one in a linear fashion like
+// blocks.
+func (sp *serverPeer) OnTxWithSha(p *peer.Peer, msg *wire.MsgTx, txHash *wire.ShaHash) {
 	if cfg.BlocksOnly {
 		peerLog.Tracef("Ignoring tx %v from %v - blocksonly enabled",
-			msg.TxSha(), p)
+			txHash, p)
 		return
 	}
 
@@ -466,7 +562,7 @@ func (sp *serverPeer) OnTx(p *peer.Peer, msg *wire.MsgTx) {
 	// Convert the raw MsgTx to a colxutil.Tx which provides some convenience
 	// methods and things such as hash caching.
 	tx := colxutil.NewTx(msg)
-	iv := wire.NewInvVect(wire.InvTypeTx, tx.Sha())
+	iv := wire.NewInvVect(wire.InvTypeTx, txHash)
 	p.AddKnownInventory(iv)
 
 	// Queue the transaction up to be handled by the block manager and
@@ -474,7 +570,7 @@ func (sp *serverPeer) OnTx(p *peer.Peer, msg *wire.MsgTx) {
 	// processed and known good or bad.  This helps prevent a malicious peer
 	// from queuing up a bunch of bad transactions before disconnecting (or
 	// being disconnected) and wasting memory.
-	sp.server.blockManager.QueueTx(tx, sp)
+	sp.server.blockManager.QueueTx(tx, txHash, sp)
 	<-sp.txProcessed
 }
 
@@ -506,19 +602,14 @@ func (sp *serverPeer) OnBlock(p *peer.Peer, msg *wire.MsgBlock, buf []byte) {
 
 // OnInv is invoked when a peer receives an inv bitcoin message and is
 // used to examine the inventory being advertised by the remote peer and react
-// accordingly.  We pass the message down to blockmanager which will call
-// QueueMessage with any appropriate responses.
+// accordingly.  We pass a copy of the message down to blockmanager which will
+// call QueueMessage with any appropriate responses.  A copy is required since
+// the peer reuses the message once this returns.
 func (sp *serverPeer) OnInv(p *peer.Peer, msg *wire.MsgInv) {
-	if !cfg.BlocksOnly {
-		if len(msg.InvList) > 0 {
-			sp.server.blockManager.QueueInv(msg, sp)
-		}
-		return
-	}
-
+	invVects := make([]wire.InvVect, 0, len(msg.InvList))
 	newInv := wire.NewMsgInvSizeHint(uint(len(msg.InvList)))
 	for _, invVect := range msg.InvList {
-		if invVect.Type == wire.InvTypeTx {
+		if cfg.BlocksOnly && invVect.Type == wire.InvTypeTx {
 			peerLog.Tracef("Ignoring tx %v in inv from %v -- "+
 				"blocksonly enabled", invVect.Hash, p)
 			if p.ProtocolVersion() >= wire.BIP0037Version {
@@ -529,7 +620,8 @@ func (sp *serverPeer) OnInv(p *peer.Peer, msg *wire.MsgInv) {
 			}
 			continue
 		}
-		err := newInv.AddInvVect(invVect)
+		invVects = append(invVects, *invVect)
+		err := newInv.AddInvVect(&invVects[len(invVects)-1])
 		if err != nil {
 			peerLog.Errorf("Failed to add inventory vector: %v", err)
 			break
@@ -795,12 +887,70 @@ func (sp *serverPeer) OnGetHeaders(p *peer.Peer, msg *wire.MsgGetHeaders) {
 	p.QueueMessage(headersMsg, nil)
 }
 
+// OnGetSkeleton is invoked when a peer receives a getskeleton bitcoin
+// message.  It responds with a headers message containing every requested
+// interval'th header of the main chain which follows the start block.
+func (sp *serverPeer) OnGetSkeleton(p *peer.Peer, msg *wire.MsgGetSkeleton) {
+	// Respond with an empty skeleton when not in sync or when the start
+	// block is not part of the main chain so the peer falls back to
+	// downloading the headers without a skeleton right away.
+	headersMsg := wire.NewMsgHeaders()
+	chain := sp.server.blockManager.chain
+	startHeight, err := chain.BlockHeightByHash(&msg.HashStart)
+	if err != nil || !sp.server.blockManager.IsCurrent() {
+		p.QueueMessage(headersMsg, nil)
+		return
+	}
+
+	// Don't attempt to fetch more than we can put into a single message
+	// or past the end of the main chain.
+	count := int64(msg.Count)
+	if count > wire.MaxBlockHeadersPerMsg {
+		count = wire.MaxBlockHeadersPerMsg
+	}
+	best := chain.BestSnapshot()
+	available := (int64(best.Height) - int64(startHeight)) /
+		int64(msg.Interval)
+	if count > available {
+		count = available
+	}
+
+	err = sp.server.db.View(func(dbTx database.Tx) error {
+		for i := int64(1); i <= count; i++ {
+			height := int64(startHeight) + i*int64(msg.Interval)
+			hash, err := chain.BlockHashByHeight(int32(height))
+			if err != nil {
+				return err
+			}
+			headerBytes, err := dbTx.FetchBlockHeader(hash)
+			if err != nil {
+				return err
+			}
+
+			var header wire.BlockHeader
+			err = header.Deserialize(bytes.NewReader(headerBytes))
+			if err != nil {
+				return err
+			}
+			headersMsg.AddBlockHeader(&header)
+		}
+
+		return nil
+	})
+	if err != nil {
+		peerLog.Warnf("Failed to build header skeleton: %v", err)
+		return
+	}
+
+	p.QueueMessage(headersMsg, nil)
+}
+
 // OnFilterAdd is invoked when a peer receives a filteradd bitcoin
 // message and is used by remote peers to add data to an already loaded bloom
 // filter.  The peer will be disconnected if a filter is not loaded when this
 // message is received.
 func (sp *serverPeer) OnFilterAdd(p *peer.Peer, msg *wire.MsgFilterAdd) {
-	if sp.filter.IsLoaded() {
+	if !sp.filter.IsLoaded() {
 		peerLog.Debugf("%s sent a filteradd request with no filter "+
 			"loaded -- disconnecting", p)
 		p.Disconnect()
@@ -855,6 +1005,14 @@ func (sp *serverPeer) OnGetAddr(p *peer.Peer, msg *wire.MsgGetAddr) {
 	// Get the current known addresses from the address manager.
 	addrCache := sp.server.addrManager.AddressCache()
 
+	// Include the address advertised in our version message so the peer
+	// learns how to reach us as well.
+	if !cfg.DisableListen {
+		if lna := p.AdvertisedAddr(); lna != nil && addrmgr.IsRoutable(lna) {
+			addrCache = append([]*wire.NetAddress{lna}, addrCache...)
+		}
+	}
+
 	// Push the addresses.
 	sp.pushAddrMsg(addrCache)
 }
@@ -913,6 +1071,13 @@ func (sp *serverPeer) OnAddr(p *peer.Peer, msg *wire.MsgAddr) {
 // the bytes received by the server.
 func (sp *serverPeer) OnRead(p *peer.Peer, bytesRead int, msg wire.Message, err error) {
 	sp.server.AddBytesReceived(uint64(bytesRead))
+
+	// Sending messages which exceed the maximum receive payload wastes
+	// bandwidth of constrained nodes, so it is treated as misbehavior.
+	if lerr, ok := err.(*wire.PayloadLimitError); ok {
+		sp.addBanScore(0, 34, fmt.Sprintf("oversized %s message",
+			lerr.Command))
+	}
 }
 
 // OnWrite is invoked when a peer sends a message and it is used to update
@@ -939,28 +1104,6 @@ func randomUint16Number(max uint16) uint16 {
 	}
 }
 
-// AddRebroadcastInventory adds 'iv' to the list of inventories to be
-// rebroadcasted at random intervals until they show up in a block.
-func (s *server) AddRebroadcastInventory(iv *wire.InvVect, data interface{}) {
-	// Ignore if shutting down.
-	if atomic.LoadInt32(&s.shutdown) != 0 {
-		return
-	}
-
-	s.modifyRebroadcastInv <- broadcastInventoryAdd{invVect: iv, data: data}
-}
-
-// RemoveRebroadcastInventory removes 'iv' from the list of items to be
-// rebroadcasted if present.
-func (s *server) RemoveRebroadcastInventory(iv *wire.InvVect) {
-	// Ignore if shutting down.
-	if atomic.LoadInt32(&s.shutdown) != 0 {
-		return
-	}
-
-	s.modifyRebroadcastInv <- broadcastInventoryDel(iv)
-}
-
 // AnnounceNewTransactions generates and relays inventory vectors and notifies
 // both websocket and getblocktemplate long poll clients of the passed
 // transactions.  This function should be called whenever new transactions
@@ -970,20 +1113,44 @@ func (s *server) AnnounceNewTransactions(newTxs []*colxutil.Tx) {
 	// transactions into the memory pool due to the original being
 	// accepted.
 	for _, tx := range newTxs {
-		// Generate the inventory vector and relay it.
-		iv := wire.NewInvVect(wire.InvTypeTx, tx.Sha())
-		s.RelayInventory(iv, tx)
+		// Generate the inventory vector and relay it unless the
+		// transaction was only accepted due to a relaxed relay policy
+		// since peers enforcing the full policy would reject it.
+		if s.txMemPool.IsRelayable(tx.Sha()) {
+			iv := wire.NewInvVect(wire.InvTypeTx, tx.Sha())
+			s.RelayInventory(iv, tx)
+		}
 
+		// Notify websocket clients about mempool transactions.
 		if s.rpcServer != nil {
-			// Notify websocket clients about mempool transactions.
 			s.rpcServer.ntfnMgr.NotifyMempoolTx(tx, true)
+		}
+	}
 
-			// Potentially notify any getblocktemplate long poll clients
-			// about stale block templates due to the new transaction.
-			s.rpcServer.gbtWorkState.NotifyMempoolTx(
-				s.txMemPool.LastUpdated())
+	// Potentially notify any getblocktemplate long poll clients about
+	// stale block templates due to the fees of the new transactions.
+	s.templateNotifier.NotifyFeesChanged(s.txMemPool.TotalFees())
+}
+
+// TransactionsEvicted stops announcing the passed transactions to peers and
+// notifies both websocket and getblocktemplate long poll clients about them.
+// This function should be called whenever transactions are evicted from the
+// mempool because it exceeded its size limit.
+func (s *server) TransactionsEvicted(evicted []*colxutil.Tx) {
+	// The evicted transactions can no longer be served to peers, so drop
+	// any pending announcements of them.
+	s.query <- evictedTxsMsg{txs: evicted}
+
+	// Notify websocket clients about the evicted transactions.
+	if s.rpcServer != nil {
+		for _, tx := range evicted {
+			s.rpcServer.ntfnMgr.NotifyMempoolTxEvicted(tx)
 		}
 	}
+
+	// Potentially notify any getblocktemplate long poll clients about
+	// stale block templates due to the fees of the evicted transactions.
+	s.templateNotifier.NotifyFeesChanged(s.txMemPool.TotalFees())
 }
 
 // pushTxMsg sends a tx message for the provided transaction hash to the
@@ -992,15 +1159,31 @@ func (s *server) pushTxMsg(sp *serverPeer, sha *wire.ShaHash, doneChan chan<- st
 	// Attempt to fetch the requested transaction from the pool.  A
 	// call could be made to check for existence first, but simply trying
 	// to fetch a missing transaction results in the same behavior.
+	var msgTx *wire.MsgTx
 	tx, err := s.txMemPool.FetchTransaction(sha)
-	if err != nil {
+	if err == nil {
+		msgTx = tx.MsgTx()
+
+		// The peer requesting the transaction is evidence that it has
+		// propagated, so there is no longer any need to announce it
+		// again when it was submitted locally.
+		s.txMemPool.RemoveUnbroadcast(sha)
+	} else {
 		peerLog.Tracef("Unable to fetch tx %v from transaction "+
 			"pool: %v", sha, err)
 
-		if doneChan != nil {
-			doneChan <- struct{}{}
+		// Fall back to loading the transaction from the main chain
+		// when serving transactions from the transaction index is
+		// advertised.
+		if s.Services()&wire.SFNodeTxIndex == wire.SFNodeTxIndex {
+			msgTx, err = s.fetchIndexedTx(sha)
+		}
+		if err != nil {
+			if doneChan != nil {
+				doneChan <- struct{}{}
+			}
+			return err
 		}
-		return err
 	}
 
 	// Once we have fetched data wait for any previous operation to finish.
@@ -1008,11 +1191,93 @@ func (s *server) pushTxMsg(sp *serverPeer, sha *wire.ShaHash, doneChan chan<- st
 		<-waitChan
 	}
 
-	sp.QueueMessage(tx.MsgTx(), doneChan)
+	sp.QueueMessage(msgTx, doneChan)
 
 	return nil
 }
 
+// fetchIndexedTx loads the transaction with the passed hash from the main chain
+// by looking up its location in the transaction index.
+func (s *server) fetchIndexedTx(hash *wire.ShaHash) (*wire.MsgTx, error) {
+	blockRegion, err := s.txIndex.TxBlockRegion(hash)
+	if err != nil {
+		return nil, err
+	}
+	if blockRegion == nil {
+		return nil, fmt.Errorf("transaction %v is not in the "+
+			"transaction index", hash)
+	}
+
+	var txBytes []byte
+	err = s.db.View(func(dbTx database.Tx) error {
+		var err error
+		txBytes, err = dbTx.FetchBlockRegion(blockRegion)
+		return err
+	})
+	if err != nil {
+		peerLog.Tracef("Unable to fetch indexed tx %v: %v", hash, err)
+		return nil, err
+	}
+
+	var msgTx wire.MsgTx
+	if err := msgTx.Deserialize(bytes.NewReader(txBytes)); err != nil {
+		return nil, err
+	}
+	return &msgTx, nil
+}
+
+// indexServices returns the service flag advertised while the passed index is
+// synced with the best chain, or zero when the index does not serve peers.
+func indexServices(indexer indexers.Indexer) wire.ServiceFlag {
+	switch indexer.(type) {
+	case *indexers.TxIndex:
+		return wire.SFNodeTxIndex
+	case *indexers.AddrIndex:
+		return wire.SFNodeAddrIndex
+	}
+	return 0
+}
+
+// indexReady sets the service flag of the passed index in the advertised
+// services when the index is synced with the best chain and clears it while it
+// is catching up, so peers are never told about services which can't be
+// served.  It is the ready callback of the index manager.
+//
+// This function is safe for concurrent access.
+func (s *server) indexReady(indexer indexers.Indexer, ready bool) {
+	flag := indexServices(indexer)
+	if flag == 0 {
+		return
+	}
+
+	s.servicesMtx.Lock()
+	if ready {
+		s.services |= flag
+	} else {
+		s.services &^= flag
+	}
+	s.servicesMtx.Unlock()
+
+	srvrLog.Debugf("%s ready to serve peers: %v", indexer.Name(), ready)
+}
+
+// indexProgress logs the progress of an index which is catching up to the best
+// chain.  It is the progress callback of the index manager.
+func indexProgress(indexName string, height, bestHeight int32) {
+	percent := float64(height+1) * 100 / float64(bestHeight+1)
+	indxLog.Infof("Caught up %s to height %d of %d (%.2f%%)", indexName,
+		height, bestHeight, percent)
+}
+
+// Services returns the services currently advertised to peers.
+//
+// This function is safe for concurrent access.
+func (s *server) Services() wire.ServiceFlag {
+	s.servicesMtx.RLock()
+	defer s.servicesMtx.RUnlock()
+	return s.services
+}
+
 // pushBlockMsg sends a block message for the provided block hash to the
 // connected peer.  An error is returned if the block hash is not known.
 func (s *server) pushBlockMsg(sp *serverPeer, hash *wire.ShaHash, doneChan chan<- struct{}, waitChan <-chan struct{}) error {
@@ -1208,14 +1473,37 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 		}
 	}
 
-	// Limit max number of total peers.
+	// Limit the number of automatic outbound peers in the same network
+	// group so a single network segment can't make up a large portion of
+	// the outbound peers.  Persistent peers were explicitly requested by
+	// the user, so they are not subject to the limit.
+	if !sp.Inbound() && !sp.persistent {
+		key := addrmgr.GroupKey(sp.NA())
+		if state.NetGroupFull(key) {
+			srvrLog.Debugf("Max outbound peers in network group %s "+
+				"reached [%d] - disconnecting peer %s", key,
+				cfg.MaxConnsPerNetGroup, sp)
+			sp.Disconnect()
+			return false
+		}
+	}
+
+	// Limit max number of total peers.  New inbound peers take the place
+	// of the lowest quality inbound peer which is not protected from
+	// eviction when there is one.
 	if state.Count() >= cfg.MaxPeers {
-		srvrLog.Infof("Max peers reached [%d] - disconnecting peer %s",
-			cfg.MaxPeers, sp)
-		sp.Disconnect()
-		// TODO(oga) how to handle permanent peers here?
-		// they should be rescheduled.
-		return false
+		evicted := false
+		if sp.Inbound() {
+			evicted = s.evictInboundPeer(state)
+		}
+		if !evicted {
+			srvrLog.Infof("Max peers reached [%d] - disconnecting "+
+				"peer %s", cfg.MaxPeers, sp)
+			sp.Disconnect()
+			// TODO(oga) how to handle permanent peers here?
+			// they should be rescheduled.
+			return false
+		}
 	}
 
 	// Add the new peer and start it.
@@ -1233,6 +1521,37 @@ func (s *server) handleAddPeerMsg(state *peerState, sp *serverPeer) bool {
 		delete(state.pendingPeers, sp.Addr())
 	}
 
+	// Announce the locally submitted transactions which no peer requested
+	// yet to the new peer.
+	s.announceUnbroadcast(sp)
+
+	return true
+}
+
+// evictInboundPeer disconnects and removes the inbound peer chosen by
+// selectEvictionCandidate to make room for a new inbound peer.  It returns
+// whether a peer was evicted.  It is invoked from the peerHandler goroutine.
+func (s *server) evictInboundPeer(state *peerState) bool {
+	candidates := make([]evictionCandidate, 0, len(state.inboundPeers))
+	for id, sp := range state.inboundPeers {
+		candidates = append(candidates, evictionCandidate{
+			sp:       sp,
+			id:       id,
+			connTime: sp.StatsSnapshot().ConnTime,
+			quality:  sp.Quality().Score,
+			syncPeer: atomic.LoadInt32(&sp.isSyncPeer) != 0,
+		})
+	}
+	evict := selectEvictionCandidate(candidates)
+	if evict == -1 {
+		return false
+	}
+
+	c := &candidates[evict]
+	srvrLog.Debugf("Evicting inbound peer %s with quality score %.2f to "+
+		"make room for a new peer", c.sp, c.quality)
+	delete(state.inboundPeers, c.id)
+	c.sp.Disconnect()
 	return true
 }
 
@@ -1304,8 +1623,8 @@ func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
 		}
 
 		// If the inventory is a block and the peer prefers headers,
-		// generate and send a headers message instead of an inventory
-		// message.
+		// queue the header to be sent with the next batch of header
+		// announcements instead of an inventory message.
 		if msg.invVect.Type == wire.InvTypeBlock && sp.WantsHeaders() {
 			blockHeader, ok := msg.data.(wire.BlockHeader)
 			if !ok {
@@ -1313,36 +1632,13 @@ func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
 					" is not a block header")
 				return
 			}
-			msgHeaders := wire.NewMsgHeaders()
-			if err := msgHeaders.AddBlockHeader(&blockHeader); err != nil {
-				peerLog.Errorf("Failed to add block"+
-					" header: %v", err)
-				return
-			}
-			sp.QueueMessage(msgHeaders, nil)
+			sp.pendingHeaders = append(sp.pendingHeaders, blockHeader)
+			s.scheduleHeaderAnnouncements(state)
 			return
 		}
 
-		if msg.invVect.Type == wire.InvTypeTx {
-			// Don't relay the transaction to the peer when it has
-			// transaction relaying disabled.
-			if sp.relayTxDisabled() {
-				return
-			}
-			// Don't relay the transaction if there is a bloom
-			// filter loaded and the transaction doesn't match it.
-			if sp.filter.IsLoaded() {
-				tx, ok := msg.data.(*colxutil.Tx)
-				if !ok {
-					peerLog.Warnf("Underlying data for tx" +
-						" inv relay is not a transaction")
-					return
-				}
-
-				if !sp.filter.MatchTxAndUpdate(tx) {
-					return
-				}
-			}
+		if msg.invVect.Type == wire.InvTypeTx && !sp.wantsTxRelay(msg) {
+			return
 		}
 
 		// Queue the inventory to be relayed with the next batch.
@@ -1352,6 +1648,145 @@ func (s *server) handleRelayInvMsg(state *peerState, msg relayMsg) {
 	})
 }
 
+// scheduleHeaderAnnouncements arranges for the peer handler to be woken up to
+// send the pending header announcements of the peers unless it already is.  It
+// is invoked from the peerHandler goroutine.
+func (s *server) scheduleHeaderAnnouncements(state *peerState) {
+	if state.headersScheduled {
+		return
+	}
+	state.headersScheduled = true
+	time.AfterFunc(headerAnnounceDelay, func() { s.wakeup <- struct{}{} })
+}
+
+// handleAnnounceHeaders sends the pending header announcements of all peers.
+// It is invoked from the peerHandler goroutine.
+func (s *server) handleAnnounceHeaders(state *peerState) {
+	state.headersScheduled = false
+	state.forAllPeers(func(sp *serverPeer) {
+		if len(sp.pendingHeaders) == 0 {
+			return
+		}
+		if !sp.Connected() {
+			sp.pendingHeaders = nil
+			return
+		}
+		sp.announcePendingHeaders(s.fetchBlockHeader)
+	})
+}
+
+// fetchBlockHeader returns the header of the block with the passed hash from
+// the database.
+func (s *server) fetchBlockHeader(hash *wire.ShaHash) (*wire.BlockHeader, error) {
+	var headerBytes []byte
+	err := s.db.View(func(dbTx database.Tx) error {
+		var err error
+		headerBytes, err = dbTx.FetchBlockHeader(hash)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var header wire.BlockHeader
+	err = header.Deserialize(bytes.NewReader(headerBytes))
+	if err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+// announcePendingHeaders announces the blocks with the pending headers to the
+// peer.  They are sent in a single headers message which starts with the
+// headers needed to connect them to the last block the peer is known to have
+// when there are few enough of those.  Otherwise, or when the pending headers
+// do not form a chain, the blocks are announced with inventory vectors.
+//
+// The last block the peer is known to have is the last block that was
+// announced to it, or the most recent block it announced when nothing has been
+// announced to it yet.
+func (sp *serverPeer) announcePendingHeaders(fetchHeader func(*wire.ShaHash) (*wire.BlockHeader, error)) {
+	headers := sp.pendingHeaders
+	sp.pendingHeaders = nil
+
+	knownBlock := sp.announcedBlock
+	if knownBlock == nil {
+		knownBlock = sp.LastAnnouncedBlock()
+	}
+
+	// Skip any of the headers the peer already has.
+	if knownBlock != nil {
+		for i := range headers {
+			if headers[i].BlockSha() == *knownBlock {
+				headers = headers[i+1:]
+				break
+			}
+		}
+	}
+	if len(headers) == 0 {
+		return
+	}
+	lastHash := headers[len(headers)-1].BlockSha()
+	sp.announcedBlock = &lastHash
+
+	// Send the inventory right away rather than trickling it since the
+	// announcement has already been held back to batch the headers.
+	msgHeaders := headersAnnouncement(headers, knownBlock, fetchHeader)
+	if msgHeaders == nil {
+		invMsg := wire.NewMsgInvSizeHint(uint(len(headers)))
+		for i := range headers {
+			hash := headers[i].BlockSha()
+			iv := wire.NewInvVect(wire.InvTypeBlock, &hash)
+			invMsg.AddInvVect(iv)
+			sp.AddKnownInventory(iv)
+		}
+		sp.QueueMessage(invMsg, nil)
+		return
+	}
+	sp.QueueMessage(msgHeaders, nil)
+}
+
+// headersAnnouncement returns a headers message which announces the passed
+// chain of headers along with the headers needed to connect them to the passed
+// known block.  It returns nil when the blocks should be announced with
+// inventory vectors instead because the headers do not form a chain or the
+// known block is too far back or not an ancestor of them.  There is nothing to
+// connect to when the known block is nil.
+func headersAnnouncement(headers []wire.BlockHeader, knownBlock *wire.ShaHash, fetchHeader func(*wire.ShaHash) (*wire.BlockHeader, error)) *wire.MsgHeaders {
+	for i := 1; i < len(headers); i++ {
+		if headers[i].PrevBlock != headers[i-1].BlockSha() {
+			return nil
+		}
+	}
+
+	// Work backwards from the first header to find the headers which
+	// connect it to the known block.
+	var catchUp []*wire.BlockHeader
+	if knownBlock != nil {
+		prevHash := headers[0].PrevBlock
+		for prevHash != *knownBlock {
+			if len(catchUp) == maxAnnounceCatchUpHeaders {
+				return nil
+			}
+			header, err := fetchHeader(&prevHash)
+			if err != nil {
+				return nil
+			}
+			catchUp = append(catchUp, header)
+			prevHash = header.PrevBlock
+		}
+	}
+
+	msgHeaders := wire.NewMsgHeaders()
+	for i := len(catchUp) - 1; i >= 0; i-- {
+		msgHeaders.AddBlockHeader(catchUp[i])
+	}
+	for i := range headers {
+		msgHeaders.AddBlockHeader(&headers[i])
+	}
+	return msgHeaders
+}
+
 // handleBroadcastMsg deals with broadcasting messages to peers.  It is invoked
 // from the peerHandler goroutine.
 func (s *server) handleBroadcastMsg(state *peerState, bmsg *broadcastMsg) {
@@ -1382,6 +1817,10 @@ type getAddedNodesMsg struct {
 	reply chan []*serverPeer
 }
 
+type getOutboundGroupsMsg struct {
+	reply chan map[string]int
+}
+
 type disconnectNodeMsg struct {
 	cmp   func(*serverPeer) bool
 	reply chan error
@@ -1398,6 +1837,10 @@ type removeNodeMsg struct {
 	reply chan error
 }
 
+type evictedTxsMsg struct {
+	txs []*colxutil.Tx
+}
+
 // handleQuery is the central handler for all queries and commands from other
 // goroutines related to peer state.
 func (s *server) handleQuery(state *peerState, querymsg interface{}) {
@@ -1411,6 +1854,25 @@ func (s *server) handleQuery(state *peerState, querymsg interface{}) {
 		})
 		msg.reply <- nconnected
 
+	case getOutboundGroupsMsg:
+		groups := make(map[string]int, len(state.outboundGroups))
+		for key, count := range state.outboundGroups {
+			if count > 0 {
+				groups[key] = count
+			}
+		}
+		msg.reply <- groups
+
+	case evictedTxsMsg:
+		// Marking the transactions as known to the peers keeps them
+		// from being announced with the next batch of inventory.
+		for _, tx := range msg.txs {
+			iv := wire.NewInvVect(wire.InvTypeTx, tx.Sha())
+			state.forAllPeers(func(sp *serverPeer) {
+				sp.AddKnownInventory(iv)
+			})
+		}
+
 	case getPeersMsg:
 		peers := make([]*serverPeer, 0, state.Count())
 		state.forAllPeers(func(sp *serverPeer) {
@@ -1520,17 +1982,25 @@ func disconnectPeer(peerList map[int32]*serverPeer, compareFunc func(*serverPeer
 
 // newPeerConfig returns the configuration for the given serverPeer.
 func newPeerConfig(sp *serverPeer) *peer.Config {
+	var externalAddrs peer.ExternalAddrsFunc
+	if len(sp.server.externalAddrs) != 0 {
+		externalAddrs = func() ([]*wire.NetAddress, error) {
+			return sp.server.externalAddrs, nil
+		}
+	}
+
 	return &peer.Config{
 		Listeners: peer.MessageListeners{
 			OnVersion:     sp.OnVersion,
 			OnMemPool:     sp.OnMemPool,
-			OnTx:          sp.OnTx,
+			OnTxWithSha:   sp.OnTxWithSha,
 			OnBlock:       sp.OnBlock,
 			OnInv:         sp.OnInv,
 			OnHeaders:     sp.OnHeaders,
 			OnGetData:     sp.OnGetData,
 			OnGetBlocks:   sp.OnGetBlocks,
 			OnGetHeaders:  sp.OnGetHeaders,
+			OnGetSkeleton: sp.OnGetSkeleton,
 			OnFilterAdd:   sp.OnFilterAdd,
 			OnFilterClear: sp.OnFilterClear,
 			OnFilterLoad:  sp.OnFilterLoad,
@@ -1545,16 +2015,20 @@ func newPeerConfig(sp *serverPeer) *peer.Config {
 			// other implementations' alert messages, we will not relay theirs.
 			OnAlert: nil,
 		},
-		NewestBlock:      sp.newestBlock,
-		BestLocalAddress: sp.server.addrManager.GetBestLocalAddress,
-		HostToNetAddress: sp.server.addrManager.HostToNetAddress,
-		Proxy:            cfg.Proxy,
-		UserAgentName:    userAgentName,
-		UserAgentVersion: userAgentVersion,
-		ChainParams:      sp.server.chainParams,
-		Services:         sp.server.services,
-		DisableRelayTx:   cfg.BlocksOnly,
-		ProtocolVersion:  wire.SendHeadersVersion,
+		NewestBlock:                  sp.newestBlock,
+		BestLocalAddress:             sp.server.addrManager.GetBestLocalAddress,
+		ExternalAddrs:                externalAddrs,
+		ExternalAddrScores:           sp.server.extAddrScores,
+		HostToNetAddress:             sp.server.addrManager.HostToNetAddress,
+		Proxy:                        cfg.Proxy,
+		UserAgentName:                userAgentName,
+		UserAgentVersion:             userAgentVersion,
+		ChainParams:                  sp.server.chainParams,
+		Services:                     sp.server.Services(),
+		DisableRelayTx:               cfg.BlocksOnly,
+		ProtocolVersion:              wire.SendHeadersVersion,
+		MinAcceptableProtocolVersion: cfg.MinProtocolVersion,
+		MaxRecvPayload:               cfg.MaxRecvPayload,
 	}
 }
 
@@ -1654,6 +2128,18 @@ func (s *server) peerConnHandler(sp *serverPeer) {
 // done.
 func (s *server) peerDoneHandler(sp *serverPeer) {
 	sp.WaitForDisconnect()
+
+	// Record failed version handshakes with outbound peers so the
+	// address is less likely to be selected again.
+	if reason := sp.DisconnectReason(); reason != nil && !sp.Inbound() &&
+		!sp.VersionKnown() && sp.NA() != nil {
+
+		outcome := addrmgr.OutcomeRejected
+		if reason == peer.ErrNegotiateTimeout {
+			outcome = addrmgr.OutcomeTimeout
+		}
+		s.addrManager.MarkOutcome(sp.NA(), outcome)
+	}
 	s.donePeers <- sp
 
 	// Only tell block manager we are gone if we ever told it we existed.
@@ -1668,6 +2154,13 @@ func (s *server) establishConn(sp *serverPeer) error {
 	srvrLog.Debugf("Attempting to connect to %s", sp.Addr())
 	conn, err := btcdDial("tcp", sp.Addr())
 	if err != nil {
+		outcome := addrmgr.OutcomeRefused
+		if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+			outcome = addrmgr.OutcomeTimeout
+		}
+		if sp.NA() != nil {
+			s.addrManager.MarkOutcome(sp.NA(), outcome)
+		}
 		return err
 	}
 	sp.Connect(conn)
@@ -1780,9 +2273,11 @@ out:
 		case bmsg := <-s.broadcast:
 			s.handleBroadcastMsg(state, &bmsg)
 
-		// Used by timers below to wake us back up.
+		// Used by timers below to wake us back up.  Send any pending
+		// header announcements since the timers include the one which
+		// batches them.
 		case <-s.wakeup:
-			// this page left intentionally blank
+			s.handleAnnounceHeaders(state)
 
 		case qmsg := <-s.query:
 			s.handleQuery(state, qmsg)
@@ -1821,16 +2316,6 @@ out:
 			if addr == nil {
 				break
 			}
-			key := addrmgr.GroupKey(addr.NetAddress())
-			// Address will not be invalid, local or unroutable
-			// because addrmanager rejects those on addition.
-			// Just check that we don't already have an address
-			// in the same group so that we are not connecting
-			// to the same network segment at the expense of
-			// others.
-			if state.outboundGroups[key] != 0 {
-				break
-			}
 
 			// Check that we don't have a pending connection to this addr.
 			addrStr := addrmgr.NetAddressKey(addr.NetAddress())
@@ -1845,6 +2330,17 @@ out:
 				break
 			}
 
+			// Address will not be invalid, local or unroutable
+			// because addrmanager rejects those on addition.
+			// Just check that we don't already have the maximum
+			// number of peers in the same group so that we are not
+			// connecting to the same network segment at the
+			// expense of others.  Ask for another address instead.
+			key := addrmgr.GroupKey(addr.NetAddress())
+			if state.NetGroupFull(key) {
+				continue
+			}
+
 			// XXX if we have limited that address skip
 
 			// only allow recent nodes (10mins) after we failed 30
@@ -1932,6 +2428,14 @@ func (s *server) ConnectedCount() int32 {
 	return <-replyChan
 }
 
+// OutboundGroupCounts returns the number of established outbound peers keyed by
+// their network group.  It is primarily useful for debugging peer selection.
+func (s *server) OutboundGroupCounts() map[string]int {
+	replyChan := make(chan map[string]int)
+	s.query <- getOutboundGroupsMsg{reply: replyChan}
+	return <-replyChan
+}
+
 // AddedNodeInfo returns an array of btcjson.GetAddedNodeInfoResult structures
 // describing the persistent (added) nodes.
 func (s *server) AddedNodeInfo() []*serverPeer {
@@ -2045,43 +2549,46 @@ func (s *server) UpdatePeerHeights(latestBlkSha *wire.ShaHash, latestHeight int3
 	}
 }
 
-// rebroadcastHandler keeps track of user submitted inventories that we have
-// sent out but have not yet made it into a block. We periodically rebroadcast
-// them in case our peers restarted or otherwise lost track of them.
+// relayUnbroadcast relays the locally submitted transactions in the memory
+// pool which have not been requested by any peer yet and are due to be
+// announced again as of the passed time.
+func (s *server) relayUnbroadcast(now time.Time) {
+	for _, tx := range s.txMemPool.DueUnbroadcast(now) {
+		iv := wire.NewInvVect(wire.InvTypeTx, tx.Sha())
+		s.RelayInventory(iv, tx)
+	}
+}
+
+// announceUnbroadcast queues inventory for the locally submitted transactions
+// in the memory pool which have not been requested by any peer yet to the
+// passed peer.  It is used to announce them to newly connected peers since the
+// peers they were announced to before might have lost track of them.
+func (s *server) announceUnbroadcast(sp *serverPeer) {
+	for _, tx := range s.txMemPool.UnbroadcastTxs() {
+		iv := wire.NewInvVect(wire.InvTypeTx, tx.Sha())
+		if sp.wantsTxRelay(relayMsg{invVect: iv, data: tx}) {
+			sp.QueueInventory(iv)
+		}
+	}
+}
+
+// rebroadcastHandler periodically re-announces the locally submitted
+// transactions in the memory pool which have not been requested by any peer
+// yet in case our peers restarted or otherwise lost track of them.
 func (s *server) rebroadcastHandler() {
-	// Wait 5 min before first tx rebroadcast.
-	timer := time.NewTimer(5 * time.Minute)
-	pendingInvs := make(map[wire.InvVect]interface{})
+	timer := time.NewTimer(time.Minute)
 
 out:
 	for {
 		select {
-		case riv := <-s.modifyRebroadcastInv:
-			switch msg := riv.(type) {
-			// Incoming InvVects are added to our map of RPC txs.
-			case broadcastInventoryAdd:
-				pendingInvs[*msg.invVect] = msg.data
-
-			// When an InvVect has been added to a block, we can
-			// now remove it, if it was present.
-			case broadcastInventoryDel:
-				if _, ok := pendingInvs[*msg]; ok {
-					delete(pendingInvs, *msg)
-				}
-			}
-
-		case <-timer.C:
-			// Any inventory we have has not made it into a block
-			// yet. We periodically resubmit them until they have.
-			for iv, data := range pendingInvs {
-				ivCopy := iv
-				s.RelayInventory(&ivCopy, data)
-			}
+		case now := <-timer.C:
+			s.relayUnbroadcast(now)
 
-			// Process at a random time up to 30mins (in seconds)
-			// in the future.
+			// Check again at a random time up to 2 mins (in seconds)
+			// in the future so the re-announcements are not
+			// predictable.
 			timer.Reset(time.Second *
-				time.Duration(randomUint16Number(1800)))
+				time.Duration(randomUint16Number(120)+1))
 
 		case <-s.quit:
 			break out
@@ -2089,17 +2596,26 @@ out:
 	}
 
 	timer.Stop()
+	s.wg.Done()
+}
 
-	// Drain channels before exiting so nothing is left waiting around
-	// to send.
-cleanup:
+// orphanExpiryHandler periodically removes the orphan transactions which
+// expired from the memory pool.
+func (s *server) orphanExpiryHandler() {
+	ticker := time.NewTicker(orphanExpireScanInterval)
+
+out:
 	for {
 		select {
-		case <-s.modifyRebroadcastInv:
-		default:
-			break cleanup
+		case now := <-ticker.C:
+			s.txMemPool.ExpireOrphans(now)
+
+		case <-s.quit:
+			break out
 		}
 	}
+
+	ticker.Stop()
 	s.wg.Done()
 }
 
@@ -2112,6 +2628,24 @@ func (s *server) Start() {
 
 	srvrLog.Trace("Starting server")
 
+	// Load the memory pool saved on the last shutdown if persistence is
+	// enabled.  This is done before any peers are connected so the saved
+	// transactions are not requested from them again.
+	if cfg.PersistMempool {
+		path := mempoolFilePath()
+		accepted, skipped, err := loadMempoolFile(s.txMemPool, path,
+			nil)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			srvrLog.Errorf("Unable to load mempool from %s: %v", path,
+				err)
+		default:
+			srvrLog.Infof("Loaded %d transactions from %s (%d "+
+				"skipped)", accepted, path, skipped)
+		}
+	}
+
 	// Start all the listeners.  There will not be any if listening is
 	// disabled.
 	for _, listener := range s.listeners {
@@ -2129,11 +2663,24 @@ func (s *server) Start() {
 		go s.upnpUpdateThread()
 	}
 
+	// Start the handler which expires orphan transactions whose parents
+	// never show up.
+	s.wg.Add(1)
+	go s.orphanExpiryHandler()
+
+	// Start the handler which advertises our own address to all peers
+	// again once a day.
+	if selfAdvertisementEnabled() {
+		s.wg.Add(1)
+		go s.selfAdvertiseHandler()
+	}
+
 	if !cfg.DisableRPC {
 		s.wg.Add(1)
 
 		// Start the rebroadcastHandler, which ensures user tx received by
-		// the RPC server are rebroadcast until being included in a block.
+		// the RPC server are rebroadcast until a peer requests them or
+		// they are included in a block.
 		go s.rebroadcastHandler()
 
 		s.rpcServer.Start()
@@ -2173,6 +2720,26 @@ func (s *server) Stop() error {
 		s.rpcServer.Stop()
 	}
 
+	// Save the memory pool so it can be loaded on the next startup if
+	// persistence is enabled.
+	if cfg.PersistMempool {
+		path := mempoolFilePath()
+		numTxns, err := saveMempoolFile(s.txMemPool, path)
+		if err != nil {
+			srvrLog.Errorf("Unable to save mempool to %s: %v", path,
+				err)
+		} else {
+			srvrLog.Infof("Saved %d transactions to %s", numTxns, path)
+		}
+	}
+
+	// Save the fee estimator so its observations survive restarts.
+	path := feeEstimatorFilePath()
+	if err := saveFeeEstimatorFile(s.feeEstimator, path); err != nil {
+		srvrLog.Errorf("Unable to save fee estimates to %s: %v", path,
+			err)
+	}
+
 	// Signal the remaining goroutines to quit.
 	close(s.quit)
 	return nil
@@ -2302,7 +2869,7 @@ out:
 					continue out
 				}
 				na := wire.NewNetAddressIPPort(externalip, uint16(listenPort),
-					s.services)
+					s.Services())
 				err = s.addrManager.AddLocalAddress(na, addrmgr.UpnpPrio)
 				if err != nil {
 					// XXX DeletePortMapping?
@@ -2329,17 +2896,22 @@ out:
 
 // newServer returns a new btcd server configured to listen on addr for the
 // bitcoin network type specified by chainParams.  Use start to begin accepting
-// connections from peers.
-func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Params) (*server, error) {
+// connections from peers.  Closing the passed interrupt channel stops the
+// optional indexes from catching up while the server is being created.
+func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Params, interrupt <-chan struct{}) (*server, error) {
 	services := defaultServices
 	if cfg.NoPeerBloomFilters {
 		services &^= wire.SFNodeBloom
 	}
+	if cfg.Prune != 0 {
+		services &^= wire.SFNodeNetwork
+	}
 
 	amgr := addrmgr.New(cfg.DataDir, btcdLookup)
 
 	var listeners []net.Listener
 	var nat NAT
+	var externalAddrs []*wire.NetAddress
 	if !cfg.DisableListen {
 		ipv4Addrs, ipv6Addrs, wildcard, err :=
 			parseListeners(listenAddrs)
@@ -2383,7 +2955,9 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 				err = amgr.AddLocalAddress(na, addrmgr.ManualPrio)
 				if err != nil {
 					amgrLog.Warnf("Skipping specified external IP: %v", err)
+					continue
 				}
+				externalAddrs = append(externalAddrs, na)
 			}
 		} else if discover && cfg.Upnp {
 			nat, err = Discover()
@@ -2463,25 +3037,27 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	}
 
 	s := server{
-		listeners:            listeners,
-		chainParams:          chainParams,
-		addrManager:          amgr,
-		newPeers:             make(chan *serverPeer, cfg.MaxPeers),
-		donePeers:            make(chan *serverPeer, cfg.MaxPeers),
-		banPeers:             make(chan *serverPeer, cfg.MaxPeers),
-		retryPeers:           make(chan *serverPeer, cfg.MaxPeers),
-		wakeup:               make(chan struct{}),
-		query:                make(chan interface{}),
-		relayInv:             make(chan relayMsg, cfg.MaxPeers),
-		broadcast:            make(chan broadcastMsg, cfg.MaxPeers),
-		quit:                 make(chan struct{}),
-		modifyRebroadcastInv: make(chan interface{}),
-		peerHeightsUpdate:    make(chan updatePeerHeightsMsg),
-		nat:                  nat,
-		db:                   db,
-		timeSource:           blockchain.NewMedianTime(),
-		services:             services,
-		sigCache:             txscript.NewSigCache(cfg.SigCacheMaxSize),
+		listeners:         listeners,
+		chainParams:       chainParams,
+		addrManager:       amgr,
+		clock:             realClock{},
+		newPeers:          make(chan *serverPeer, cfg.MaxPeers),
+		donePeers:         make(chan *serverPeer, cfg.MaxPeers),
+		banPeers:          make(chan *serverPeer, cfg.MaxPeers),
+		retryPeers:        make(chan *serverPeer, cfg.MaxPeers),
+		wakeup:            make(chan struct{}),
+		query:             make(chan interface{}),
+		relayInv:          make(chan relayMsg, cfg.MaxPeers),
+		broadcast:         make(chan broadcastMsg, cfg.MaxPeers),
+		quit:              make(chan struct{}),
+		peerHeightsUpdate: make(chan updatePeerHeightsMsg),
+		nat:               nat,
+		db:                db,
+		timeSource:        blockchain.NewMedianTime(),
+		services:          services,
+		sigCache:          txscript.NewSigCache(cfg.SigCacheMaxSize),
+		externalAddrs:     externalAddrs,
+		extAddrScores:     peer.NewExternalAddrScores(),
 	}
 
 	// Create the transaction and address indexes if needed.
@@ -2491,12 +3067,15 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 	// addrindex is run first, it may not have the transactions from the
 	// current block indexed.
 	var indexes []indexers.Indexer
-	if cfg.TxIndex || cfg.AddrIndex {
-		// Enable transaction index if address index is enabled since it
-		// requires it.
+	if cfg.TxIndex || cfg.AddrIndex || cfg.UtxoByScriptIndex || cfg.CFIndex {
+		// Enable transaction index if the address index, the unspent
+		// outputs by script index, or the committed filter index is
+		// enabled since they require it.
 		if !cfg.TxIndex {
 			indxLog.Infof("Transaction index enabled because it " +
-				"is required by the address index")
+				"is required by the address index, the unspent " +
+				"outputs by script index, or the committed filter " +
+				"index")
 			cfg.TxIndex = true
 		} else {
 			indxLog.Info("Transaction index is enabled")
@@ -2510,35 +3089,108 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		s.addrIndex = indexers.NewAddrIndex(db, chainParams)
 		indexes = append(indexes, s.addrIndex)
 	}
+	if cfg.UtxoByScriptIndex {
+		indxLog.Info("Unspent outputs by script index is enabled")
+		s.utxoByScriptIndex = indexers.NewUtxoByScriptIndex(db,
+			chainParams)
+		indexes = append(indexes, s.utxoByScriptIndex)
+	}
+	if cfg.CFIndex {
+		indxLog.Info("Committed filter index is enabled")
+		s.cfIndex = indexers.NewCfIndex(db, chainParams)
+		indexes = append(indexes, s.cfIndex)
+	}
+	if cfg.SpendIndex {
+		indxLog.Info("Spent outputs index is enabled")
+		s.spendIndex = indexers.NewSpendIndex(db, chainParams)
+		indexes = append(indexes, s.spendIndex)
+	}
+	if cfg.TimeIndex {
+		indxLog.Info("Block time index is enabled")
+		s.timeIndex = indexers.NewTimeIndex(db, chainParams)
+		indexes = append(indexes, s.timeIndex)
+	}
 
 	// Create an index manager if any of the optional indexes are enabled.
 	var indexManager blockchain.IndexManager
 	if len(indexes) > 0 {
-		indexManager = indexers.NewManager(db, indexes)
+		indexManager = indexers.NewManager(db, indexes, s.indexReady,
+			indexProgress)
+	}
+	// Restore the fee estimator saved on the last shutdown.  It starts
+	// over without any observations when there is no saved state or it
+	// can't be used.
+	feePath := feeEstimatorFilePath()
+	feeEstimator, err := loadFeeEstimatorFile(feePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			srvrLog.Warnf("Unable to load fee estimates from %s: %v",
+				feePath, err)
+		}
+		feeEstimator = newFeeEstimator()
 	}
-	bm, err := newBlockManager(&s, indexManager)
+	s.feeEstimator = feeEstimator
+
+	bm, err := newBlockManager(&s, indexManager, interrupt)
 	if err != nil {
 		return nil, err
 	}
 	s.blockManager = bm
 
+	// The data for old blocks is not available when the chain has been
+	// pruned, even when pruning is no longer enabled, so don't advertise
+	// serving the full block chain.
+	if bm.chain.IsPruned() {
+		s.servicesMtx.Lock()
+		s.services &^= wire.SFNodeNetwork
+		s.servicesMtx.Unlock()
+	}
+
+	// Nulldata scripts larger than the configured data carrier size are not
+	// considered standard, so they are neither relayed nor mined.
+	txscript.SetMaxNullDataSize(cfg.DataCarrierSize)
+
 	txC := mempoolConfig{
 		Policy: mempoolPolicy{
 			DisableRelayPriority: cfg.NoRelayPriority,
 			FreeTxRelayLimit:     cfg.FreeTxRelayLimit,
 			MaxOrphanTxs:         cfg.MaxOrphanTxs,
 			MaxOrphanTxSize:      defaultMaxOrphanTxSize,
+			MaxOrphanBytes:       defaultMaxOrphanBytes,
+			OrphanTTL:            cfg.OrphanTTL,
 			MaxSigOpsPerTx:       blockchain.MaxSigOpsPerBlock / 5,
 			MinRelayTxFee:        cfg.minRelayTxFee,
+			MaxTxVersion:         cfg.MaxTxVersion,
+			MaxAncestorCount:     cfg.AncestorLimit,
+			MaxAncestorSize:      int64(cfg.AncestorSizeLimit) * 1000,
+			MaxDescendantCount:   cfg.DescendantLimit,
+			MaxDescendantSize:    int64(cfg.DescendantSizeLimit) * 1000,
+			MaxTxPoolSizeBytes:   int64(cfg.MaxMempool) * 1000000,
 		},
 		FetchUtxoView: s.blockManager.chain.FetchUtxoView,
 		Chain:         s.blockManager.chain,
 		SigCache:      s.sigCache,
 		TimeSource:    s.timeSource,
 		AddrIndex:     s.addrIndex,
+		FeeEstimator:  s.feeEstimator,
+
+		// Relay the orphans accepted once the parents they were
+		// waiting for were mined.
+		OnOrphansResolved: s.AnnounceNewTransactions,
+
+		// Stop announcing the transactions evicted from the full
+		// pool and let the websocket clients know about them.
+		OnTxsEvicted: s.TransactionsEvicted,
 	}
 	s.txMemPool = newTxMemPool(&txC)
 
+	// Track when block templates become stale starting from the current
+	// best chain tip.
+	s.templateNotifier = mining.NewTemplateNotifier(
+		int64(cfg.templateFeeDelta))
+	bestHash, _ := s.blockManager.chainState.Best()
+	s.templateNotifier.NotifyBlockConnected(bestHash, 0)
+
 	// Create the mining policy based on the configuration options.
 	// NOTE: The CPU miner relies on the mempool, so the mempool has to be
 	// created before calling the function to create the CPU miner.
@@ -2548,10 +3200,20 @@ func newServer(listenAddrs []string, db database.DB, chainParams *chaincfg.Param
 		BlockPrioritySize: cfg.BlockPrioritySize,
 		TxMinFreeFee:      cfg.minRelayTxFee,
 	}
-	s.cpuMiner = newCPUMiner(&policy, &s)
+	numWorkers := defaultNumWorkers
+	if cfg.GenProcLimit > 0 {
+		numWorkers = uint32(cfg.GenProcLimit)
+	}
+	s.cpuMiner = newCPUMiner(&policy, &s, numWorkers)
 
 	if !cfg.DisableRPC {
-		s.rpcServer, err = newRPCServer(cfg.RPCListeners, &policy, &s)
+		var notifiers []Notifier
+		if cfg.RPCNotifyURL != "" {
+			notifiers = append(notifiers, newHTTPNotifier(
+				cfg.RPCNotifyURL, []byte(cfg.RPCNotifyKey)))
+		}
+		s.rpcServer, err = newRPCServer(cfg.RPCListeners, &policy,
+			notifiers, &s)
 		if err != nil {
 			return nil, err
 		}
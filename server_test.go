@@ -0,0 +1,843 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain/indexers"
+	"github.com/tinhnguyenhn/colxd/btcjson"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/mining"
+	"github.com/tinhnguyenhn/colxd/peer"
+	"github.com/tinhnguyenhn/colxd/peer/peertest"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestMaxConnsPerNetGroup ensures automatic outbound peers are limited to the
+// configured number per network group while peers in other groups and
+// persistent peers are still accepted.
+func TestMaxConnsPerNetGroup(t *testing.T) {
+	defer func(c *config) { cfg = c }(cfg)
+	cfg = &config{MaxPeers: defaultMaxPeers, MaxConnsPerNetGroup: 2}
+
+	s := &server{txMemPool: newTxMemPool(&mempoolConfig{})}
+	state := &peerState{
+		pendingPeers:     make(map[string]*serverPeer),
+		inboundPeers:     make(map[int32]*serverPeer),
+		persistentPeers:  make(map[int32]*serverPeer),
+		outboundPeers:    make(map[int32]*serverPeer),
+		banned:           make(map[string]time.Time),
+		maxOutboundPeers: defaultMaxOutbound,
+		outboundGroups:   make(map[string]int),
+	}
+
+	// addPeer simulates a successful dial of the passed address and hands
+	// the resulting peer to the server.
+	addPeer := func(addr string, persistent bool) bool {
+		p, err := peer.NewOutboundPeer(&peer.Config{}, addr)
+		if err != nil {
+			t.Fatalf("NewOutboundPeer: unexpected error: %v", err)
+		}
+		sp := &serverPeer{Peer: p, persistent: persistent}
+		state.pendingPeers[sp.Addr()] = sp
+		return s.handleAddPeerMsg(state, sp)
+	}
+	groupCounts := func() map[string]int {
+		reply := make(chan map[string]int, 1)
+		s.handleQuery(state, getOutboundGroupsMsg{reply: reply})
+		return <-reply
+	}
+
+	tests := []struct {
+		name       string
+		addr       string
+		persistent bool
+		accepted   bool
+	}{
+		{"first in group", "12.1.1.1:8333", false, true},
+		{"second in group", "12.1.2.2:8333", false, true},
+		{"third in group", "12.1.3.3:8333", false, false},
+		{"different group", "13.1.1.1:8333", false, true},
+		{"persistent in full group", "12.1.4.4:8333", true, true},
+	}
+	for _, test := range tests {
+		if got := addPeer(test.addr, test.persistent); got != test.accepted {
+			t.Errorf("%s: unexpected acceptance - got %v, want %v",
+				test.name, got, test.accepted)
+		}
+	}
+
+	want := map[string]int{"12.1.0.0": 3, "13.1.0.0": 1}
+	got := groupCounts()
+	if len(got) != len(want) {
+		t.Fatalf("unexpected group counts - got %v, want %v", got, want)
+	}
+	for key, count := range want {
+		if got[key] != count {
+			t.Fatalf("unexpected group counts - got %v, want %v",
+				got, want)
+		}
+	}
+}
+
+// TestInboundPeerEviction ensures new inbound peers take the place of the
+// lowest quality inbound peer once the maximum number of peers is reached, that
+// the evicted peer changes along with the quality scores, and that the sync peer
+// and the longest connected peers are never evicted.
+func TestInboundPeerEviction(t *testing.T) {
+	defer func(c *config) { cfg = c }(cfg)
+	cfg = &config{
+		MaxPeers:            evictionProtectLongest + 2,
+		MaxConnsPerNetGroup: defaultMaxConnsPerNetGroup,
+	}
+
+	s := &server{txMemPool: newTxMemPool(&mempoolConfig{})}
+	state := &peerState{
+		pendingPeers:     make(map[string]*serverPeer),
+		inboundPeers:     make(map[int32]*serverPeer),
+		persistentPeers:  make(map[int32]*serverPeer),
+		outboundPeers:    make(map[int32]*serverPeer),
+		banned:           make(map[string]time.Time),
+		maxOutboundPeers: defaultMaxOutbound,
+		outboundGroups:   make(map[string]int),
+	}
+
+	// newInboundPeer returns a server peer which accepted a connection
+	// from a scripted remote peer and completed the version handshake.
+	params := &chaincfg.RegressionNetParams
+	var scripted []*peertest.ScriptedPeer
+	defer func() {
+		for _, remote := range scripted {
+			remote.Close()
+		}
+	}()
+	newInboundPeer := func() *serverPeer {
+		localConn, remoteConn := peertest.Pipe("10.0.0.1:18444",
+			fmt.Sprintf("10.0.1.%d:18444", len(scripted)+1))
+		sp := newServerPeer(s, false)
+		sp.Peer = peer.NewInboundPeer(&peer.Config{ChainParams: params})
+		sp.Connect(localConn)
+		remote := peertest.New(remoteConn, peer.MaxProtocolVersion,
+			params.Net)
+		scripted = append(scripted, remote)
+		err := remote.Run(peertest.InboundHandshake(nil, time.Second*5))
+		if err != nil {
+			t.Fatalf("unable to complete handshake: %v", err)
+		}
+		return sp
+	}
+	setQuality := func(sp *serverPeer, score float64) {
+		sp.qualityMtx.Lock()
+		sp.quality = peerQuality{Score: score}
+		sp.qualityTime = time.Now()
+		sp.qualityMtx.Unlock()
+	}
+
+	// Fill all peer slots with inbound peers.  The longest connected peers
+	// have the lowest scores, but they are protected from eviction.
+	peers := make([]*serverPeer, cfg.MaxPeers)
+	for i := range peers {
+		peers[i] = newInboundPeer()
+		if !s.handleAddPeerMsg(state, peers[i]) {
+			t.Fatalf("inbound peer %d not accepted", i)
+		}
+		// Ensure the peers connect at distinct times.
+		time.Sleep(time.Millisecond * 10)
+	}
+	for i := 0; i < evictionProtectLongest; i++ {
+		setQuality(peers[i], 0)
+	}
+	p4, p5 := peers[evictionProtectLongest], peers[evictionProtectLongest+1]
+	setQuality(p4, 40)
+	setQuality(p5, 30)
+
+	// addInbound adds a new inbound peer with the passed score and ensures
+	// it takes the place of the passed peer.
+	addInbound := func(name string, score float64, evicted *serverPeer) *serverPeer {
+		sp := newInboundPeer()
+		setQuality(sp, score)
+		if !s.handleAddPeerMsg(state, sp) {
+			t.Fatalf("%s: new inbound peer not accepted", name)
+		}
+		if _, ok := state.inboundPeers[evicted.ID()]; ok {
+			t.Fatalf("%s: peer %d not evicted", name, evicted.ID())
+		}
+		if evicted.Connected() {
+			t.Fatalf("%s: evicted peer %d still connected", name,
+				evicted.ID())
+		}
+		if len(state.inboundPeers) != cfg.MaxPeers {
+			t.Fatalf("%s: unexpected number of inbound peers - got "+
+				"%d, want %d", name, len(state.inboundPeers),
+				cfg.MaxPeers)
+		}
+		return sp
+	}
+
+	// The lowest scored unprotected peer is evicted.
+	p6 := addInbound("lowest score", 60, p5)
+
+	// Lowering the score of the remaining peer makes it the one evicted.
+	setQuality(p4, 20)
+	p7 := addInbound("changed score", 50, p4)
+
+	// The sync peer is not evicted even when it has the lowest score.
+	setQuality(p6, 10)
+	atomic.StoreInt32(&p6.isSyncPeer, 1)
+	addInbound("sync peer", 70, p7)
+
+	// New outbound peers never evict inbound peers.
+	p, err := peer.NewOutboundPeer(&peer.Config{}, "12.1.1.1:8333")
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected error: %v", err)
+	}
+	outbound := &serverPeer{Peer: p}
+	state.pendingPeers[outbound.Addr()] = outbound
+	if s.handleAddPeerMsg(state, outbound) {
+		t.Fatalf("outbound peer accepted with max peers reached")
+	}
+
+	// The longest connected peers were never evicted.
+	for i := 0; i < evictionProtectLongest; i++ {
+		if _, ok := state.inboundPeers[peers[i].ID()]; !ok {
+			t.Fatalf("protected peer %d evicted", i)
+		}
+	}
+}
+
+// TestRelayTxBloomFilter ensures transactions are only announced to peers with
+// a bloom filter loaded when they match it, that the filters are updated as
+// matched outputs are discovered, and that the filter messages are handled.
+func TestRelayTxBloomFilter(t *testing.T) {
+	pkHash := bytes.Repeat([]byte{0x11}, 20)
+	pkScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).
+		AddOp(txscript.OP_HASH160).AddData(pkHash).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).
+		Script()
+	if err != nil {
+		t.Fatalf("unable to build script: %v", err)
+	}
+
+	// Create a transaction which pays to the public key hash and another
+	// which only spends its output.
+	fundingTx := wire.NewMsgTx()
+	fundingTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&wire.ShaHash{0x01},
+		0), nil))
+	fundingTx.AddTxOut(wire.NewTxOut(1000, pkScript))
+	funding := colxutil.NewTx(fundingTx)
+	spendingTx := wire.NewMsgTx()
+	spendingTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(funding.Sha(), 0),
+		nil))
+	spendingTx.AddTxOut(wire.NewTxOut(500, []byte{txscript.OP_TRUE}))
+	spending := colxutil.NewTx(spendingTx)
+	relayTx := func(tx *colxutil.Tx) relayMsg {
+		iv := wire.NewInvVect(wire.InvTypeTx, tx.Sha())
+		return relayMsg{invVect: iv, data: tx}
+	}
+
+	// newMockPeer returns a server peer which is not connected to anything
+	// and optionally has the passed filter loaded.
+	newMockPeer := func(data []byte, flags wire.BloomUpdateType) *serverPeer {
+		sp := newServerPeer(&server{}, false)
+		sp.Peer = peer.NewInboundPeer(&peer.Config{})
+		if data != nil {
+			filterLoad := wire.NewMsgFilterLoad(make([]byte, 512), 10,
+				0, flags)
+			sp.OnFilterLoad(sp.Peer, filterLoad)
+			sp.OnFilterAdd(sp.Peer, wire.NewMsgFilterAdd(data))
+		}
+		return sp
+	}
+
+	unfiltered := newMockPeer(nil, wire.BloomUpdateNone)
+	updateAll := newMockPeer(pkHash, wire.BloomUpdateAll)
+	updateNone := newMockPeer(pkHash, wire.BloomUpdateNone)
+	unrelated := newMockPeer(bytes.Repeat([]byte{0x22}, 20),
+		wire.BloomUpdateAll)
+	relayDisabled := newMockPeer(nil, wire.BloomUpdateNone)
+	relayDisabled.setDisableRelayTx(true)
+
+	tests := []struct {
+		name    string
+		sp      *serverPeer
+		funding bool
+		spend   bool
+	}{
+		{"no filter", unfiltered, true, true},
+		{"update all", updateAll, true, true},
+		{"update none", updateNone, true, false},
+		{"unrelated filter", unrelated, false, false},
+		{"relay disabled", relayDisabled, false, false},
+	}
+	for _, test := range tests {
+		if got := test.sp.wantsTxRelay(relayTx(funding)); got != test.funding {
+			t.Errorf("%s: unexpected funding tx relay - got %v, "+
+				"want %v", test.name, got, test.funding)
+		}
+		if got := test.sp.wantsTxRelay(relayTx(spending)); got != test.spend {
+			t.Errorf("%s: unexpected spending tx relay - got %v, "+
+				"want %v", test.name, got, test.spend)
+		}
+	}
+
+	// Clearing the filter must result in all transactions being relayed.
+	unrelated.OnFilterClear(unrelated.Peer, wire.NewMsgFilterClear())
+	if !unrelated.wantsTxRelay(relayTx(funding)) {
+		t.Errorf("transaction not relayed after clearing the filter")
+	}
+
+	// Relaying to a peer while it updates its filter must be safe.
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			updateNone.wantsTxRelay(relayTx(funding))
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			updateNone.OnFilterAdd(updateNone.Peer,
+				wire.NewMsgFilterAdd([]byte{byte(i)}))
+		}
+	}()
+	wg.Wait()
+}
+
+// TestRelayBlockHeaders ensures new blocks are announced with headers messages
+// to peers which sent sendheaders, including the headers needed to connect the
+// blocks to the last one the peer is known to have, and with inventory to
+// peers which did not or when the peer is too far behind.
+func TestRelayBlockHeaders(t *testing.T) {
+	// Create a chain of headers which can be looked up by hash.
+	headers := make([]wire.BlockHeader, 20)
+	headersByHash := make(map[wire.ShaHash]*wire.BlockHeader)
+	for i := range headers {
+		headers[i].Timestamp = time.Unix(1401292357+int64(i)*600, 0)
+		headers[i].Nonce = uint32(i)
+		if i > 0 {
+			headers[i].PrevBlock = headers[i-1].BlockSha()
+		}
+		headersByHash[headers[i].BlockSha()] = &headers[i]
+	}
+	fetchHeader := func(hash *wire.ShaHash) (*wire.BlockHeader, error) {
+		header, ok := headersByHash[*hash]
+		if !ok {
+			return nil, errors.New("header not found")
+		}
+		return header, nil
+	}
+
+	// newConnectedPeer returns a server peer connected to a simulated
+	// remote peer which completes the version handshake, optionally sends
+	// sendheaders, and then sends all headers and inv messages it receives
+	// to the returned channel.
+	params := &chaincfg.RegressionNetParams
+	newConnectedPeer := func(sendHeaders bool) (*serverPeer, chan wire.Message) {
+		localConn, remoteConn := peertest.Pipe("10.0.0.2:18444",
+			"10.0.0.1:18444")
+		msgs := make(chan wire.Message, 20)
+		writeQueue := make(chan wire.Message, 5)
+		go func() {
+			for msg := range writeQueue {
+				err := wire.WriteMessage(remoteConn, msg,
+					wire.ProtocolVersion, params.Net)
+				if err != nil {
+					return
+				}
+			}
+		}()
+		go func() {
+			defer close(writeQueue)
+			for {
+				msg, _, err := wire.ReadMessage(remoteConn,
+					wire.ProtocolVersion, params.Net)
+				if err != nil {
+					return
+				}
+				switch msg.(type) {
+				case *wire.MsgVersion:
+					addr := wire.NewNetAddressIPPort(
+						net.ParseIP("10.0.0.1"), 18444, 0)
+					writeQueue <- wire.NewMsgVersion(addr, addr,
+						0x0102030405060708, 0)
+					writeQueue <- wire.NewMsgVerAck()
+					if sendHeaders {
+						writeQueue <- wire.NewMsgSendHeaders()
+					}
+				case *wire.MsgHeaders, *wire.MsgInv:
+					msgs <- msg
+				}
+			}
+		}()
+
+		sp := newServerPeer(&server{}, false)
+		p, err := peer.NewOutboundPeer(&peer.Config{ChainParams: params},
+			"10.0.0.1:18444")
+		if err != nil {
+			t.Fatalf("NewOutboundPeer: unexpected error: %v", err)
+		}
+		sp.Peer = p
+		sp.Connect(localConn)
+		return sp, msgs
+	}
+
+	headersPeer, headersMsgs := newConnectedPeer(true)
+	defer headersPeer.Disconnect()
+	invPeer, invMsgs := newConnectedPeer(false)
+	defer invPeer.Disconnect()
+	for start := time.Now(); !headersPeer.WantsHeaders(); {
+		if time.Since(start) > time.Second*5 {
+			t.Fatalf("sendheaders not received")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	s := &server{wakeup: make(chan struct{}, 1)}
+	state := &peerState{
+		inboundPeers:    make(map[int32]*serverPeer),
+		persistentPeers: make(map[int32]*serverPeer),
+		outboundPeers: map[int32]*serverPeer{
+			headersPeer.ID(): headersPeer,
+			invPeer.ID():     invPeer,
+		},
+	}
+
+	// The peer announced the first block to us.
+	headersPeer.UpdateLastAnnouncedBlock(ptrHash(headers[0].BlockSha()))
+
+	tests := []struct {
+		name     string
+		relay    []int // indexes of the relayed headers
+		wantHdrs []int // indexes of the announced headers or nil for inv
+	}{
+		{"next block", []int{1}, []int{1}},
+		{"batched blocks", []int{2, 3}, []int{2, 3}},
+		{"catch up", []int{6}, []int{4, 5, 6}},
+		{"too far behind", []int{16}, nil},
+		{"next block after inv", []int{17, 18}, []int{17, 18}},
+	}
+	for _, test := range tests {
+		for _, idx := range test.relay {
+			hash := headers[idx].BlockSha()
+			s.handleRelayInvMsg(state, relayMsg{
+				invVect: wire.NewInvVect(wire.InvTypeBlock, &hash),
+				data:    headers[idx],
+			})
+		}
+		if len(invPeer.pendingHeaders) != 0 {
+			t.Fatalf("%s: headers queued for peer which did not "+
+				"send sendheaders", test.name)
+		}
+		if !state.headersScheduled {
+			t.Fatalf("%s: header announcement not scheduled",
+				test.name)
+		}
+		state.headersScheduled = false
+		headersPeer.announcePendingHeaders(fetchHeader)
+
+		var msg wire.Message
+		select {
+		case msg = <-headersMsgs:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("%s: announcement not received", test.name)
+		}
+		var gotHashes []wire.ShaHash
+		switch m := msg.(type) {
+		case *wire.MsgHeaders:
+			if test.wantHdrs == nil {
+				t.Fatalf("%s: unexpected headers announcement",
+					test.name)
+			}
+			for _, header := range m.Headers {
+				gotHashes = append(gotHashes, header.BlockSha())
+			}
+		case *wire.MsgInv:
+			if test.wantHdrs != nil {
+				t.Fatalf("%s: unexpected inv announcement",
+					test.name)
+			}
+			for _, iv := range m.InvList {
+				gotHashes = append(gotHashes, iv.Hash)
+			}
+		}
+		want := test.wantHdrs
+		if want == nil {
+			want = test.relay
+		}
+		if len(gotHashes) != len(want) {
+			t.Fatalf("%s: got %d announced blocks, want %d",
+				test.name, len(gotHashes), len(want))
+		}
+		for i, idx := range want {
+			if gotHashes[i] != headers[idx].BlockSha() {
+				t.Fatalf("%s: announced block #%d is %v, want %v",
+					test.name, i, gotHashes[i],
+					headers[idx].BlockSha())
+			}
+		}
+	}
+
+	// The peer which did not send sendheaders must only receive inventory.
+	select {
+	case msg := <-invMsgs:
+		if _, ok := msg.(*wire.MsgHeaders); ok {
+			t.Fatalf("headers sent to peer which did not send " +
+				"sendheaders")
+		}
+	default:
+	}
+}
+
+// TestIndexReadyServices ensures the services of the optional indexes are only
+// advertised in the version message while the index manager reports them as
+// synced with the best chain.
+func TestIndexReadyServices(t *testing.T) {
+	defer func(c *config) { cfg = c }(cfg)
+	cfg = &config{}
+
+	// versionServices returns the services advertised in the version
+	// message a new outbound peer of the server sends.
+	params := &chaincfg.RegressionNetParams
+	s := &server{services: defaultServices}
+	versionServices := func() wire.ServiceFlag {
+		localConn, remoteConn := peertest.Pipe("10.0.0.2:18444",
+			"10.0.0.1:18444")
+		defer remoteConn.Close()
+
+		peerCfg := newPeerConfig(newServerPeer(s, false))
+		peerCfg.NewestBlock = nil
+		peerCfg.BestLocalAddress = nil
+		peerCfg.ChainParams = params
+		p, err := peer.NewOutboundPeer(peerCfg, "10.0.0.1:18444")
+		if err != nil {
+			t.Fatalf("NewOutboundPeer: unexpected error: %v", err)
+		}
+		p.Connect(localConn)
+		defer p.Disconnect()
+
+		msg, _, err := wire.ReadMessage(remoteConn, wire.ProtocolVersion,
+			params.Net)
+		if err != nil {
+			t.Fatalf("ReadMessage: unexpected error: %v", err)
+		}
+		version, ok := msg.(*wire.MsgVersion)
+		if !ok {
+			t.Fatalf("unexpected message %T, want version", msg)
+		}
+		return version.Services
+	}
+
+	txIndex := indexers.NewTxIndex(nil)
+	addrIndex := indexers.NewAddrIndex(nil, params)
+	utxoIndex := indexers.NewUtxoByScriptIndex(nil, params)
+	tests := []struct {
+		name    string
+		indexer indexers.Indexer
+		ready   bool
+		want    wire.ServiceFlag
+	}{
+		{
+			name:    "tx index catching up",
+			indexer: txIndex,
+			ready:   false,
+			want:    defaultServices,
+		},
+		{
+			name:    "tx index synced",
+			indexer: txIndex,
+			ready:   true,
+			want:    defaultServices | wire.SFNodeTxIndex,
+		},
+		{
+			name:    "addr index synced",
+			indexer: addrIndex,
+			ready:   true,
+			want: defaultServices | wire.SFNodeTxIndex |
+				wire.SFNodeAddrIndex,
+		},
+		{
+			name:    "index without service synced",
+			indexer: utxoIndex,
+			ready:   true,
+			want: defaultServices | wire.SFNodeTxIndex |
+				wire.SFNodeAddrIndex,
+		},
+		{
+			name:    "tx index catching up after restart",
+			indexer: txIndex,
+			ready:   false,
+			want:    defaultServices | wire.SFNodeAddrIndex,
+		},
+	}
+	for _, test := range tests {
+		s.indexReady(test.indexer, test.ready)
+		if got := s.Services(); got != test.want {
+			t.Errorf("%s: unexpected services - got %v, want %v",
+				test.name, got, test.want)
+		}
+		if got := versionServices(); got != test.want {
+			t.Errorf("%s: unexpected version message services - "+
+				"got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// ptrHash returns a pointer to a copy of the passed hash.
+func ptrHash(hash wire.ShaHash) *wire.ShaHash {
+	return &hash
+}
+
+// TestUnbroadcastTransactions ensures locally submitted transactions are
+// tracked as unbroadcast until a peer requests them or they leave the pool,
+// such as when they are mined, and that they are announced again with backoff
+// and to newly connected peers in the mean time.
+func TestUnbroadcastTransactions(t *testing.T) {
+	defer func(c *config) { cfg = c }(cfg)
+	cfg = &config{
+		MaxPeers:            defaultMaxPeers,
+		MaxConnsPerNetGroup: defaultMaxConnsPerNetGroup,
+		DisableBanning:      true,
+	}
+
+	h := newPoolHarness(t)
+	defer h.teardown()
+	mp := h.newPool()
+	s := &server{
+		txMemPool:        mp,
+		relayInv:         make(chan relayMsg, 10),
+		templateNotifier: mining.NewTemplateNotifier(0),
+	}
+	rpcSrv := &rpcServer{server: s, chain: h.chain}
+	relayedTxns := func() map[wire.ShaHash]struct{} {
+		hashes := make(map[wire.ShaHash]struct{})
+		for {
+			select {
+			case msg := <-s.relayInv:
+				hashes[msg.invVect.Hash] = struct{}{}
+			default:
+				return hashes
+			}
+		}
+	}
+	isUnbroadcast := func(tx *colxutil.Tx) bool {
+		verbose := true
+		result, err := handleGetRawMempool(rpcSrv,
+			&btcjson.GetRawMempoolCmd{Verbose: &verbose}, nil)
+		if err != nil {
+			t.Fatalf("getrawmempool: unexpected error: %v", err)
+		}
+		entries := result.(map[string]*btcjson.GetRawMempoolVerboseResult)
+		entry, ok := entries[tx.Sha().String()]
+		if !ok {
+			t.Fatalf("getrawmempool: tx %v not found", tx.Sha())
+		}
+		return entry.Unbroadcast
+	}
+	unbroadcastCount := func() int64 {
+		result, err := handleGetMempoolInfo(rpcSrv,
+			&btcjson.GetMempoolInfoCmd{}, nil)
+		if err != nil {
+			t.Fatalf("getmempoolinfo: unexpected error: %v", err)
+		}
+		return result.(*btcjson.GetMempoolInfoResult).UnbroadcastCount
+	}
+
+	// Submit two transactions while there are no peers to announce them
+	// to along with one received from the network.
+	const fee = 10000
+	var txns []*colxutil.Tx
+	for i := 0; i < 2; i++ {
+		tx := h.spendTx(t, colxutil.SatoshiPerBitcoin-fee, h.payScript)
+		var buf bytes.Buffer
+		if err := tx.MsgTx().Serialize(&buf); err != nil {
+			t.Fatalf("Serialize: unexpected error: %v", err)
+		}
+		cmd := btcjson.NewSendRawTransactionCmd(
+			hex.EncodeToString(buf.Bytes()), nil, nil, nil)
+		if _, err := handleSendRawTransaction(rpcSrv, cmd, nil); err != nil {
+			t.Fatalf("sendrawtransaction: unexpected error: %v", err)
+		}
+		txns = append(txns, tx)
+	}
+	remoteTx := h.spendTx(t, colxutil.SatoshiPerBitcoin-fee, h.payScript)
+	if _, err := mp.ProcessTransaction(remoteTx, false, false, nil); err != nil {
+		t.Fatalf("ProcessTransaction: unexpected error: %v", err)
+	}
+	relayedTxns()
+	if !isUnbroadcast(txns[0]) || !isUnbroadcast(txns[1]) {
+		t.Fatalf("submitted transactions are not unbroadcast")
+	}
+	if isUnbroadcast(remoteTx) {
+		t.Fatalf("transaction from the network is unbroadcast")
+	}
+	if count := unbroadcastCount(); count != 2 {
+		t.Fatalf("unexpected unbroadcast count - got %d, want 2", count)
+	}
+
+	// The transactions are only announced again once they are due, and
+	// the time until they are due again doubles every time.
+	now := time.Now()
+	s.relayUnbroadcast(now)
+	if relayed := relayedTxns(); len(relayed) != 0 {
+		t.Fatalf("%d transactions announced before they are due",
+			len(relayed))
+	}
+	now = now.Add(unbroadcastRetryInterval)
+	s.relayUnbroadcast(now)
+	if relayed := relayedTxns(); len(relayed) != 2 {
+		t.Fatalf("got %d announced transactions, want 2", len(relayed))
+	}
+	now = now.Add(unbroadcastRetryInterval)
+	s.relayUnbroadcast(now)
+	if relayed := relayedTxns(); len(relayed) != 0 {
+		t.Fatalf("%d transactions announced before backoff elapsed",
+			len(relayed))
+	}
+	now = now.Add(unbroadcastRetryInterval)
+	s.relayUnbroadcast(now)
+	if relayed := relayedTxns(); len(relayed) != 2 {
+		t.Fatalf("got %d announced transactions after backoff, want 2",
+			len(relayed))
+	}
+
+	// Connect a peer which sends any transaction inventory it receives to
+	// the returned channel and requests the first announced transaction.
+	params := &chaincfg.RegressionNetParams
+	localConn, remoteConn := peertest.Pipe("10.0.0.2:18444",
+		"10.0.0.1:18444")
+	msgs := make(chan wire.Message, 10)
+	writeQueue := make(chan wire.Message, 5)
+	go func() {
+		for msg := range writeQueue {
+			err := wire.WriteMessage(remoteConn, msg,
+				wire.ProtocolVersion, params.Net)
+			if err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		defer close(writeQueue)
+		for {
+			msg, _, err := wire.ReadMessage(remoteConn,
+				wire.ProtocolVersion, params.Net)
+			if err != nil {
+				return
+			}
+			switch msg.(type) {
+			case *wire.MsgVersion:
+				addr := wire.NewNetAddressIPPort(
+					net.ParseIP("10.0.0.1"), 18444, 0)
+				writeQueue <- wire.NewMsgVersion(addr, addr,
+					0x0102030405060708, 0)
+				writeQueue <- wire.NewMsgVerAck()
+			case *wire.MsgInv, *wire.MsgTx:
+				msgs <- msg
+			}
+		}
+	}()
+	sp := newServerPeer(s, false)
+	p, err := peer.NewOutboundPeer(&peer.Config{
+		ChainParams: params,
+		Listeners:   peer.MessageListeners{OnGetData: sp.OnGetData},
+	}, "10.0.0.1:18444")
+	if err != nil {
+		t.Fatalf("NewOutboundPeer: unexpected error: %v", err)
+	}
+	sp.Peer = p
+	sp.Connect(localConn)
+	defer sp.Disconnect()
+	for start := time.Now(); !sp.VerAckReceived(); {
+		if time.Since(start) > time.Second*5 {
+			t.Fatalf("verack not received")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	receive := func() wire.Message {
+		select {
+		case msg := <-msgs:
+			return msg
+		case <-time.After(time.Second * 30):
+			t.Fatalf("message not received")
+		}
+		return nil
+	}
+
+	// The newly connected peer must be sent inventory for the unbroadcast
+	// transactions.
+	state := &peerState{
+		pendingPeers:     make(map[string]*serverPeer),
+		inboundPeers:     make(map[int32]*serverPeer),
+		persistentPeers:  make(map[int32]*serverPeer),
+		outboundPeers:    make(map[int32]*serverPeer),
+		banned:           make(map[string]time.Time),
+		maxOutboundPeers: defaultMaxOutbound,
+		outboundGroups:   make(map[string]int),
+	}
+	if !s.handleAddPeerMsg(state, sp) {
+		t.Fatalf("peer not accepted")
+	}
+	inv, ok := receive().(*wire.MsgInv)
+	if !ok {
+		t.Fatalf("unexpected message, want inv")
+	}
+	announced := make(map[wire.ShaHash]struct{})
+	for _, iv := range inv.InvList {
+		announced[iv.Hash] = struct{}{}
+	}
+	if len(announced) != 2 {
+		t.Fatalf("got %d announced transactions, want 2", len(announced))
+	}
+	for _, tx := range txns {
+		if _, ok := announced[*tx.Sha()]; !ok {
+			t.Fatalf("unbroadcast tx %v not announced", tx.Sha())
+		}
+	}
+
+	// The peer requesting the first transaction clears its flag.
+	getData := wire.NewMsgGetData()
+	getData.AddInvVect(wire.NewInvVect(wire.InvTypeTx, txns[0].Sha()))
+	writeQueue <- getData
+	msgTx, ok := receive().(*wire.MsgTx)
+	if !ok || msgTx.TxSha() != *txns[0].Sha() {
+		t.Fatalf("requested transaction not received")
+	}
+	if isUnbroadcast(txns[0]) {
+		t.Fatalf("requested transaction is still unbroadcast")
+	}
+	if !isUnbroadcast(txns[1]) {
+		t.Fatalf("transaction which was not requested is no longer " +
+			"unbroadcast")
+	}
+
+	// The second transaction being mined clears its flag.
+	mp.RemoveTransaction(txns[1], false)
+	if mp.IsUnbroadcast(txns[1].Sha()) {
+		t.Fatalf("mined transaction is still unbroadcast")
+	}
+	if count := unbroadcastCount(); count != 0 {
+		t.Fatalf("unexpected unbroadcast count - got %d, want 0", count)
+	}
+	now = now.Add(maxUnbroadcastRetryInterval)
+	s.relayUnbroadcast(now)
+	if relayed := relayedTxns(); len(relayed) != 0 {
+		t.Fatalf("%d transactions announced after they propagated",
+			len(relayed))
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// txProofCacheDepth is the number of blocks from the tip of the main chain
+// whose merkle trees are cached to build transaction inclusion proofs for the
+// gettxoutproof RPC.  Those are the blocks explorers and wallets request
+// proofs for the most.
+const txProofCacheDepth = 20
+
+// txProofCacheEntry houses the merkle tree store of a block in the transaction
+// proof cache.
+type txProofCacheEntry struct {
+	hash    wire.ShaHash
+	merkles []*wire.ShaHash
+}
+
+// txProofCache provides a concurrency safe cache of the merkle trees of recent
+// main chain blocks which is limited to a maximum number of blocks with
+// eviction of the least recently used entry when the limit is exceeded.  The
+// entries for blocks which are disconnected from the main chain are removed
+// by the cache invalidator registered with the chain.
+type txProofCache struct {
+	mtx     sync.Mutex
+	entries map[wire.ShaHash]*list.Element // nearly O(1) lookups
+	lru     *list.List                     // O(1) insert, update, delete
+	limit   int
+}
+
+// newTxProofCache returns a new transaction proof cache which holds the merkle
+// trees of at most the passed number of blocks.
+func newTxProofCache(limit int) *txProofCache {
+	return &txProofCache{
+		entries: make(map[wire.ShaHash]*list.Element),
+		lru:     list.New(),
+		limit:   limit,
+	}
+}
+
+// merkleTree returns the merkle tree store of the passed main chain block as
+// returned by blockchain.BuildMerkleTreeStore.  The tree of a block within the
+// cached depth of the passed best height is served from the cache or added to
+// it, while nil is returned for older blocks so the caller computes only the
+// hashes it needs.
+//
+// This function is safe for concurrent access.
+func (c *txProofCache) merkleTree(block *colxutil.Block, bestHeight int32) []*wire.ShaHash {
+	if c.limit <= 0 || block.Height() <= bestHeight-int32(c.limit) {
+		return nil
+	}
+
+	c.mtx.Lock()
+	if elem, ok := c.entries[*block.Sha()]; ok {
+		c.lru.MoveToFront(elem)
+		c.mtx.Unlock()
+		return elem.Value.(*txProofCacheEntry).merkles
+	}
+	c.mtx.Unlock()
+
+	// Build the tree without holding the lock since it is expensive for
+	// large blocks.  Concurrent requests for the same block might build it
+	// more than once, which is harmless since the result is the same.
+	merkles := blockchain.BuildMerkleTreeStore(block.Transactions())
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if elem, ok := c.entries[*block.Sha()]; ok {
+		c.lru.MoveToFront(elem)
+		return merkles
+	}
+	if c.lru.Len() >= c.limit {
+		oldest := c.lru.Back()
+		delete(c.entries, oldest.Value.(*txProofCacheEntry).hash)
+		c.lru.Remove(oldest)
+	}
+	c.entries[*block.Sha()] = c.lru.PushFront(&txProofCacheEntry{
+		hash:    *block.Sha(),
+		merkles: merkles,
+	})
+	return merkles
+}
+
+// invalidate removes the merkle trees of the passed blocks which are being
+// disconnected from the main chain by a reorganize so no proof for them is
+// served from the cache.  It implements blockchain.CacheInvalidator.
+//
+// This function is safe for concurrent access.
+func (c *txProofCache) invalidate(detached, attached []*colxutil.Block) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	for _, block := range detached {
+		if elem, ok := c.entries[*block.Sha()]; ok {
+			delete(c.entries, *block.Sha())
+			c.lru.Remove(elem)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/btcjson"
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxd/wire/bloom"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// TestTxProofCache ensures the transaction proof cache serves the merkle trees
+// of recent blocks, evicts the least recently used tree when full, skips
+// blocks deeper than its depth, and drops the trees of disconnected blocks.
+func TestTxProofCache(t *testing.T) {
+	// Create blocks at heights 1 through 5 with a varying number of
+	// transactions.
+	var blocks []*colxutil.Block
+	for height := int32(1); height <= 5; height++ {
+		msgBlock := wire.NewMsgBlock(&wire.BlockHeader{Nonce: uint32(height)})
+		for i := 0; i < int(height)*3; i++ {
+			tx := wire.NewMsgTx()
+			tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: uint32(i)},
+				nil))
+			tx.LockTime = uint32(height)
+			msgBlock.AddTransaction(tx)
+		}
+		block := colxutil.NewBlock(msgBlock)
+		block.SetHeight(height)
+		blocks = append(blocks, block)
+	}
+
+	cache := newTxProofCache(3)
+	assertCached := func(desc string, want ...*colxutil.Block) {
+		if len(cache.entries) != len(want) || cache.lru.Len() != len(want) {
+			t.Fatalf("%s: unexpected number of cached trees - got "+
+				"%d/%d, want %d", desc, len(cache.entries),
+				cache.lru.Len(), len(want))
+		}
+		for _, block := range want {
+			if _, ok := cache.entries[*block.Sha()]; !ok {
+				t.Fatalf("%s: tree of block %d not cached", desc,
+					block.Height())
+			}
+		}
+	}
+
+	// The cached trees must be the ones built from scratch.
+	for _, block := range blocks[2:] {
+		got := cache.merkleTree(block, 5)
+		want := blockchain.BuildMerkleTreeStore(block.Transactions())
+		if len(got) != len(want) {
+			t.Fatalf("block %d: unexpected tree size - got %d, want %d",
+				block.Height(), len(got), len(want))
+		}
+		for i := range want {
+			if (got[i] == nil) != (want[i] == nil) ||
+				(want[i] != nil && *got[i] != *want[i]) {
+
+				t.Fatalf("block %d: unexpected tree node %d",
+					block.Height(), i)
+			}
+		}
+	}
+	assertCached("recent blocks", blocks[2:]...)
+
+	// Requesting a cached tree again serves the same tree.
+	first := cache.merkleTree(blocks[2], 5)
+	if again := cache.merkleTree(blocks[2], 5); &again[0] != &first[0] {
+		t.Fatalf("cached tree was not reused")
+	}
+
+	// Blocks deeper than the cached depth are not cached.
+	if merkles := cache.merkleTree(blocks[1], 5); merkles != nil {
+		t.Fatalf("tree of deep block returned")
+	}
+	assertCached("deep block", blocks[2:]...)
+
+	// Adding a tree to the full cache evicts the least recently used one,
+	// which is block 4 since block 3 was just used.
+	cache.merkleTree(blocks[1], 4)
+	assertCached("eviction", blocks[1], blocks[2], blocks[4])
+
+	// The trees of disconnected blocks are dropped.
+	cache.invalidate([]*colxutil.Block{blocks[4], blocks[3]},
+		[]*colxutil.Block{blocks[0]})
+	assertCached("invalidate", blocks[1], blocks[2])
+}
+
+// TestHandleGetTxOutProof ensures the gettxoutproof command returns proofs
+// which equal the freshly computed ones for both cached and uncached blocks,
+// rejects invalid requests, and refuses to serve proofs for blocks which were
+// disconnected by a reorganize.
+func TestHandleGetTxOutProof(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	chain, db, teardown := newRPCTestChain(t, params)
+	defer teardown()
+
+	s := &rpcServer{
+		server:       &server{chainParams: params, db: db},
+		chain:        chain,
+		txProofCache: newTxProofCache(2),
+		quit:         make(chan int),
+	}
+	chain.RegisterCacheInvalidator("transaction proof merkle trees",
+		s.txProofCache.invalidate)
+
+	// Create a main chain of four blocks along with a side chain which
+	// forks from the second block and ends up with more work.
+	genesisTime := params.GenesisBlock.Header.Timestamp
+	createChain := func(prevHash *wire.ShaHash, startHeight, numBlocks int32, offset time.Duration) []*colxutil.Block {
+		var blocks []*colxutil.Block
+		for height := startHeight; height < startHeight+numBlocks; height++ {
+			block, err := newRPCTestBlock(params, prevHash,
+				genesisTime.Add(time.Minute*10*
+					time.Duration(height)+offset),
+				height, nil)
+			if err != nil {
+				t.Fatalf("unable to create block: %v", err)
+			}
+			_, err = chain.ProcessBlock(block, blockchain.BFNone)
+			if err != nil {
+				t.Fatalf("ProcessBlock: unexpected error: %v", err)
+			}
+			blocks = append(blocks, block)
+			prevHash = block.Sha()
+		}
+		return blocks
+	}
+	mainBlocks := createChain(params.GenesisHash, 1, 4, 0)
+
+	getProof := func(block *colxutil.Block, txHashes ...*wire.ShaHash) (interface{}, error) {
+		txIDs := make([]string, 0, len(txHashes))
+		for _, txHash := range txHashes {
+			txIDs = append(txIDs, txHash.String())
+		}
+		blockHash := block.Sha().String()
+		cmd := btcjson.NewGetTxOutProofCmd(txIDs, &blockHash)
+		return handleGetTxOutProof(s, cmd, nil)
+	}
+	assertProof := func(desc string, block *colxutil.Block) {
+		txHash := block.Transactions()[0].Sha()
+		result, err := getProof(block, txHash)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", desc, err)
+		}
+		want, _ := bloom.NewMerkleBlockWithTxs(block,
+			map[wire.ShaHash]struct{}{*txHash: {}}, nil)
+		var buf bytes.Buffer
+		if err := want.BtcEncode(&buf, maxProtocolVersion); err != nil {
+			t.Fatalf("%s: unable to encode merkle block: %v", desc,
+				err)
+		}
+		if result != hex.EncodeToString(buf.Bytes()) {
+			t.Fatalf("%s: unexpected proof - got %v, want %x", desc,
+				result, buf.Bytes())
+		}
+	}
+	assertError := func(desc string, err error, code btcjson.RPCErrorCode) {
+		rpcErr, ok := err.(*btcjson.RPCError)
+		if !ok || rpcErr.Code != code {
+			t.Fatalf("%s: unexpected error - got %v, want code %v",
+				desc, err, code)
+		}
+	}
+
+	// Proofs for recent blocks are served from the cache, proofs for older
+	// blocks are not, and both equal the freshly computed ones.  Request
+	// the tip twice to ensure the cached tree is used.
+	for i := len(mainBlocks) - 1; i >= 0; i-- {
+		assertProof("main chain", mainBlocks[i])
+	}
+	assertProof("cached tip", mainBlocks[3])
+	if _, ok := s.txProofCache.entries[*mainBlocks[3].Sha()]; !ok {
+		t.Fatalf("tree of the tip is not cached")
+	}
+	if _, ok := s.txProofCache.entries[*mainBlocks[0].Sha()]; ok {
+		t.Fatalf("tree of a deep block is cached")
+	}
+
+	// Invalid requests.
+	_, err := handleGetTxOutProof(s,
+		btcjson.NewGetTxOutProofCmd(nil, nil), nil)
+	assertError("no txids", err, btcjson.ErrRPCInvalidParameter)
+	_, err = getProof(mainBlocks[3], mainBlocks[3].Transactions()[0].Sha(),
+		mainBlocks[3].Transactions()[0].Sha())
+	assertError("duplicate txid", err, btcjson.ErrRPCInvalidParameter)
+	_, err = getProof(mainBlocks[3], mainBlocks[2].Transactions()[0].Sha())
+	assertError("tx not in block", err, btcjson.ErrRPCInvalidAddressOrKey)
+	_, err = handleGetTxOutProof(s, btcjson.NewGetTxOutProofCmd(
+		[]string{mainBlocks[3].Transactions()[0].Sha().String()}, nil),
+		nil)
+	assertError("no tx index", err, btcjson.ErrRPCNoTxInfo)
+
+	// Reorganize to the side chain, which disconnects the cached blocks,
+	// and ensure proofs for them are refused while the blocks of the new
+	// main chain and the common ancestor are still served.
+	sideBlocks := createChain(mainBlocks[1].Sha(), 3, 3, time.Minute)
+	if best := chain.BestSnapshot(); !best.Hash.IsEqual(sideBlocks[2].Sha()) {
+		t.Fatalf("chain did not reorganize to the side chain")
+	}
+	for _, block := range mainBlocks[2:] {
+		if _, ok := s.txProofCache.entries[*block.Sha()]; ok {
+			t.Fatalf("tree of disconnected block %v still cached",
+				block.Sha())
+		}
+		_, err := getProof(block, block.Transactions()[0].Sha())
+		assertError("disconnected block", err,
+			btcjson.ErrRPCBlockNotFound)
+	}
+	for _, block := range append([]*colxutil.Block{mainBlocks[1]},
+		sideBlocks...) {
+
+		assertProof("side chain", block)
+	}
+}
@@ -39,6 +39,12 @@ const (
 	// This is BIP0065.
 	ScriptVerifyCheckLockTimeVerify
 
+	// ScriptVerifyCheckSequenceVerify defines whether to verify that
+	// a transaction output is spendable based on the relative lock-time
+	// encoded in the sequence number of the input spending it.
+	// This is BIP0112.
+	ScriptVerifyCheckSequenceVerify
+
 	// ScriptVerifyCleanStack defines that the stack must contain only
 	// one stack element after evaluation and that the element must be
 	// true if interpreted as a boolean.  This is rule 6 of BIP0062.
@@ -46,25 +52,37 @@ const (
 	ScriptVerifyCleanStack
 
 	// ScriptVerifyDERSignatures defines that signatures are required
-	// to compily with the DER format.
+	// to compily with the DER format.  This is BIP0066.
 	ScriptVerifyDERSignatures
 
-	// ScriptVerifyLowS defines that signtures are required to comply with
-	// the DER format and whose S value is <= order / 2.  This is rule 5
-	// of BIP0062.
+	// ScriptVerifyLowS defines that signtures are required to have an S
+	// value which is <= order / 2.  This is rule 5 of BIP0062.  It does not
+	// require the DER format on its own, so it is usually combined with
+	// ScriptVerifyDERSignatures.
 	ScriptVerifyLowS
 
-	// ScriptVerifyMinimalData defines that signatures must use the smallest
-	// push operator. This is both rules 3 and 4 of BIP0062.
+	// ScriptVerifyMinimalData defines that data pushes must use the smallest
+	// push operator and that numeric arguments must be minimally encoded.
+	// This is both rules 3 and 4 of BIP0062.  It is only enforced by policy
+	// and must not be used when validating blocks since historical blocks
+	// contain non-minimal pushes.
 	ScriptVerifyMinimalData
 
 	// ScriptVerifySigPushOnly defines that signature scripts must contain
 	// only pushed data.  This is rule 2 of BIP0062.
 	ScriptVerifySigPushOnly
 
-	// ScriptVerifyStrictEncoding defines that signature scripts and
-	// public keys must follow the strict encoding requirements.
+	// ScriptVerifyStrictEncoding defines that signature hash types and
+	// public keys must follow the strict encoding requirements.  The
+	// encoding of the signatures themselves is governed by
+	// ScriptVerifyDERSignatures and ScriptVerifyLowS.
 	ScriptVerifyStrictEncoding
+
+	// ScriptVerifyNullFail defines that signatures must be empty when an
+	// OP_CHECKSIG or OP_CHECKMULTISIG fails, so the only way to make a
+	// signature check fail without failing the script is to provide an
+	// empty signature.
+	ScriptVerifyNullFail
 )
 
 const (
@@ -93,8 +111,12 @@ type Engine struct {
 	numOps          int
 	flags           ScriptFlags
 	sigCache        *SigCache
+	sigHashes       *TxSigHashes
+	limits          EngineLimits
+	stats           EngineStats
 	bip16           bool     // treat execution as pay-to-script-hash
 	savedFirstStack [][]byte // stack from first script for bip16 scripts
+	stepCallback    func(step *StepInfo)
 }
 
 // hasFlag returns whether the script engine instance has the passed flag set.
@@ -154,7 +176,26 @@ func (vm *Engine) executeOpcode(pop *parsedOpcode) error {
 		}
 	}
 
-	return pop.opcode.opfunc(pop, vm)
+	if err := vm.countExecutedOp(); err != nil {
+		return err
+	}
+	if err := pop.opcode.opfunc(pop, vm); err != nil {
+		return err
+	}
+
+	// Account for the size of the item pushed by data push opcodes, which
+	// include the small integer opcodes.  Note that OP_RESERVED is not a
+	// data push even though it falls within the range.
+	if vm.isBranchExecuting() && pop.opcode.value <= OP_16 &&
+		pop.opcode.value != OP_RESERVED {
+
+		item, err := vm.dstack.PeekByteArray(0)
+		if err != nil {
+			return err
+		}
+		return vm.countPushedBytes(len(item))
+	}
+	return nil
 }
 
 // disasm is a helper function to produce the output for DisasmPC and
@@ -201,6 +242,45 @@ func (vm *Engine) DisasmPC() (string, error) {
 	return vm.disasm(scriptIdx, scriptOff), nil
 }
 
+// DisasmPCStep returns the index of the script and the disassembly step of the
+// opcode that will be next to execute when Step() is called.  Unlike the
+// opcode index reported by DisasmPC, the Offset of the returned step is the
+// byte offset of the opcode within the script, which allows debugger UIs to
+// highlight the opcode in the raw script.
+func (vm *Engine) DisasmPCStep() (int, DisasmStep, error) {
+	scriptIdx, scriptOff, err := vm.curPC()
+	if err != nil {
+		return 0, DisasmStep{}, err
+	}
+	step, err := vm.disasmStep(scriptIdx, scriptOff)
+	if err != nil {
+		return 0, DisasmStep{}, err
+	}
+	return scriptIdx, step, nil
+}
+
+// disasmStep is a helper function to produce the disassembly step for
+// DisasmPCStep and the execution trace.  It does no error checking of the
+// position and leaves that to the caller to provide a valid offset.
+func (vm *Engine) disasmStep(scriptIdx int, scriptOff int) (DisasmStep, error) {
+	script := vm.scripts[scriptIdx]
+	var offset int
+	for i := 0; i < scriptOff; i++ {
+		offset += parsedOpcodeLen(&script[i])
+	}
+	pop := script[scriptOff]
+	popBytes, err := pop.bytes()
+	if err != nil {
+		return DisasmStep{}, err
+	}
+	return DisasmStep{
+		Name:   pop.opcode.name,
+		Bytes:  popBytes,
+		Offset: offset,
+		pop:    pop,
+	}, nil
+}
+
 // DisasmScript returns the disassembly string for the script at the requested
 // offset index.  Index 0 is the signature script and 1 is the public key
 // script.
@@ -268,6 +348,9 @@ func (vm *Engine) Step() (done bool, err error) {
 	// disabled opcodes, illegal opcodes, maximum allowed operations per
 	// script, maximum script element sizes, and conditionals.
 	err = vm.executeOpcode(opcode)
+	if vm.stepCallback != nil {
+		vm.traceStep(err)
+	}
 	if err != nil {
 		return true, err
 	}
@@ -277,6 +360,9 @@ func (vm *Engine) Step() (done bool, err error) {
 	if vm.dstack.Depth()+vm.astack.Depth() > maxStackSize {
 		return false, ErrStackOverflow
 	}
+	if err := vm.countStackDepth(); err != nil {
+		return false, err
+	}
 
 	// Prepare for next instruction.
 	vm.scriptOff++
@@ -378,7 +464,7 @@ func (vm *Engine) checkHashTypeEncoding(hashType SigHashType) error {
 
 	sigHashType := hashType & ^SigHashAnyOneCanPay
 	if sigHashType < SigHashAll || sigHashType > SigHashSingle {
-		return fmt.Errorf("invalid hashtype: 0x%x\n", hashType)
+		return ErrStackInvalidHashType
 	}
 	return nil
 }
@@ -402,15 +488,46 @@ func (vm *Engine) checkPubKeyEncoding(pubKey []byte) error {
 }
 
 // checkSignatureEncoding returns whether or not the passed signature adheres to
-// the strict encoding requirements if enabled.
+// the DER and low S value requirements if enabled.  Each of the requirements is
+// only enforced by its own flag so they can be enabled independently.
 func (vm *Engine) checkSignatureEncoding(sig []byte) error {
-	if !vm.hasFlag(ScriptVerifyDERSignatures) &&
-		!vm.hasFlag(ScriptVerifyLowS) &&
-		!vm.hasFlag(ScriptVerifyStrictEncoding) {
+	if vm.hasFlag(ScriptVerifyDERSignatures) && !isStrictDERSignature(sig) {
+		return ErrStackInvalidDERSignature
+	}
 
-		return nil
+	// Verify the S value is <= half the order of the curve.  This check is
+	// done because when it is higher, the complement modulo the order can
+	// be used instead which is a shorter encoding by 1 byte.  Further,
+	// without enforcing this, it is possible to replace a signature in a
+	// valid transaction with the complement while still being a valid
+	// signature that verifies.  This would result in changing the
+	// transaction hash and thus is source of malleability.
+	if vm.hasFlag(ScriptVerifyLowS) {
+		var sValue *big.Int
+		if isStrictDERSignature(sig) {
+			rLen := int(sig[3])
+			sLen := int(sig[rLen+5])
+			sValue = new(big.Int).SetBytes(sig[rLen+6 : rLen+6+sLen])
+		} else {
+			// A signature which can't be parsed can't be checked
+			// either, and it fails to verify regardless.
+			signature, err := btcec.ParseSignature(sig, btcec.S256())
+			if err != nil {
+				return nil
+			}
+			sValue = signature.S
+		}
+		if sValue.Cmp(halfOrder) > 0 {
+			return ErrStackInvalidLowSSignature
+		}
 	}
 
+	return nil
+}
+
+// isStrictDERSignature returns whether or not the passed signature, without the
+// hash type, is encoded with the strict DER encoding of BIP0066.
+func isStrictDERSignature(sig []byte) bool {
 	// The format of a DER encoded signature is as follows:
 	//
 	// 0x30 <total length> 0x02 <length of R> <R> 0x02 <length of S> <S>
@@ -434,8 +551,7 @@ func (vm *Engine) checkSignatureEncoding(sig []byte) error {
 	// 0x30 + <1-byte> + 0x02 + 0x01 + <byte> + 0x2 + 0x01 + <byte>
 	if len(sig) < 8 {
 		// Too short
-		return fmt.Errorf("malformed signature: too short: %d < 8",
-			len(sig))
+		return false
 	}
 
 	// Maximum length is when both numbers are 33 bytes each.  It is 33
@@ -444,25 +560,22 @@ func (vm *Engine) checkSignatureEncoding(sig []byte) error {
 	// 0x30 + <1-byte> + 0x02 + 0x21 + <33 bytes> + 0x2 + 0x21 + <33 bytes>
 	if len(sig) > 72 {
 		// Too long
-		return fmt.Errorf("malformed signature: too long: %d > 72",
-			len(sig))
+		return false
 	}
 	if sig[0] != 0x30 {
 		// Wrong type
-		return fmt.Errorf("malformed signature: format has wrong type: 0x%x",
-			sig[0])
+		return false
 	}
 	if int(sig[1]) != len(sig)-2 {
 		// Invalid length
-		return fmt.Errorf("malformed signature: bad length: %d != %d",
-			sig[1], len(sig)-2)
+		return false
 	}
 
 	rLen := int(sig[3])
 
 	// Make sure S is inside the signature.
 	if rLen+5 > len(sig) {
-		return fmt.Errorf("malformed signature: S out of bounds")
+		return false
 	}
 
 	sLen := int(sig[rLen+5])
@@ -470,66 +583,52 @@ func (vm *Engine) checkSignatureEncoding(sig []byte) error {
 	// The length of the elements does not match the length of the
 	// signature.
 	if rLen+sLen+6 != len(sig) {
-		return fmt.Errorf("malformed signature: invalid R length")
+		return false
 	}
 
 	// R elements must be integers.
 	if sig[2] != 0x02 {
-		return fmt.Errorf("malformed signature: missing first integer marker")
+		return false
 	}
 
 	// Zero-length integers are not allowed for R.
 	if rLen == 0 {
-		return fmt.Errorf("malformed signature: R length is zero")
+		return false
 	}
 
 	// R must not be negative.
 	if sig[4]&0x80 != 0 {
-		return fmt.Errorf("malformed signature: R value is negative")
+		return false
 	}
 
 	// Null bytes at the start of R are not allowed, unless R would
 	// otherwise be interpreted as a negative number.
 	if rLen > 1 && sig[4] == 0x00 && sig[5]&0x80 == 0 {
-		return fmt.Errorf("malformed signature: invalid R value")
+		return false
 	}
 
 	// S elements must be integers.
 	if sig[rLen+4] != 0x02 {
-		return fmt.Errorf("malformed signature: missing second integer marker")
+		return false
 	}
 
 	// Zero-length integers are not allowed for S.
 	if sLen == 0 {
-		return fmt.Errorf("malformed signature: S length is zero")
+		return false
 	}
 
 	// S must not be negative.
 	if sig[rLen+6]&0x80 != 0 {
-		return fmt.Errorf("malformed signature: S value is negative")
+		return false
 	}
 
 	// Null bytes at the start of S are not allowed, unless S would
 	// otherwise be interpreted as a negative number.
 	if sLen > 1 && sig[rLen+6] == 0x00 && sig[rLen+7]&0x80 == 0 {
-		return fmt.Errorf("malformed signature: invalid S value")
+		return false
 	}
 
-	// Verify the S value is <= half the order of the curve.  This check is
-	// done because when it is higher, the complement modulo the order can
-	// be used instead which is a shorter encoding by 1 byte.  Further,
-	// without enforcing this, it is possible to replace a signature in a
-	// valid transaction with the complement while still being a valid
-	// signature that verifies.  This would result in changing the
-	// transaction hash and thus is source of malleability.
-	if vm.hasFlag(ScriptVerifyLowS) {
-		sValue := new(big.Int).SetBytes(sig[rLen+6 : rLen+6+sLen])
-		if sValue.Cmp(halfOrder) > 0 {
-			return ErrStackInvalidLowSSignature
-		}
-	}
-
-	return nil
+	return true
 }
 
 // getStack returns the contents of stack as a byte array bottom up
@@ -577,10 +676,41 @@ func (vm *Engine) SetAltStack(data [][]byte) {
 	setStack(&vm.astack, data)
 }
 
+// EngineOptions houses optional parameters for creating a script engine with
+// NewEngineWithOptions.  The zero value creates an engine which behaves the
+// same as one created by NewEngine without a signature cache.
+type EngineOptions struct {
+	// SigCache is the signature cache to consult before and update after
+	// verifying signatures.
+	SigCache *SigCache
+
+	// SigHashes is the cached serialization of the transaction, as created
+	// by NewTxSigHashes, used to compute signature hashes.  Callers which
+	// validate several inputs of the same transaction should share a
+	// single instance between the engines of all of its inputs.
+	SigHashes *TxSigHashes
+
+	// Limits defines limits on the resources used while executing the
+	// scripts in addition to those imposed by the consensus rules.  The
+	// resources used are available from Engine.Stats.
+	Limits EngineLimits
+}
+
 // NewEngine returns a new script engine for the provided public key script,
 // transaction, and input index.  The flags modify the behavior of the script
 // engine according to the description provided by each flag.
 func NewEngine(scriptPubKey []byte, tx *wire.MsgTx, txIdx int, flags ScriptFlags, sigCache *SigCache) (*Engine, error) {
+	return NewEngineWithOptions(scriptPubKey, tx, txIdx, flags,
+		&EngineOptions{SigCache: sigCache})
+}
+
+// NewEngineWithOptions returns a new script engine like NewEngine with the
+// optional parameters set by the passed options, which may be nil.
+func NewEngineWithOptions(scriptPubKey []byte, tx *wire.MsgTx, txIdx int, flags ScriptFlags, opts *EngineOptions) (*Engine, error) {
+	if opts == nil {
+		opts = &EngineOptions{}
+	}
+
 	// The provided transaction input index must refer to a valid input.
 	if txIdx < 0 || txIdx >= len(tx.TxIn) {
 		return nil, ErrInvalidIndex
@@ -595,7 +725,12 @@ func NewEngine(scriptPubKey []byte, tx *wire.MsgTx, txIdx int, flags ScriptFlags
 	// allowing the clean stack flag without the P2SH flag would make it
 	// possible to have a situation where P2SH would not be a soft fork when
 	// it should be.
-	vm := Engine{flags: flags, sigCache: sigCache}
+	vm := Engine{
+		flags:     flags,
+		sigCache:  opts.SigCache,
+		sigHashes: opts.SigHashes,
+		limits:    opts.Limits,
+	}
 	if vm.hasFlag(ScriptVerifyCleanStack) && !vm.hasFlag(ScriptBip16) {
 		return nil, ErrInvalidFlags
 	}
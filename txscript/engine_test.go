@@ -6,10 +6,12 @@
 package txscript_test
 
 import (
+	"bytes"
 	"testing"
 
 	"github.com/tinhnguyenhn/colxd/txscript"
 	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
 )
 
 // TestBadPC sets the pc to a deliberately bad result then confirms that Step()
@@ -82,9 +84,270 @@ func TestBadPC(t *testing.T) {
 			t.Errorf("DisasmPC with invalid pc (%v) succeeds!",
 				test)
 		}
+
+		_, _, err = vm.DisasmPCStep()
+		if err == nil {
+			t.Errorf("DisasmPCStep with invalid pc (%v) succeeds!",
+				test)
+		}
 	}
 }
 
+// TestDisasmPCStep ensures DisasmPCStep reports the script index and the byte
+// offset of each opcode as the engine steps through the scripts.
+func TestDisasmPCStep(t *testing.T) {
+	t.Parallel()
+
+	tx := &wire.MsgTx{
+		Version: 1,
+		TxIn: []*wire.TxIn{
+			{
+				PreviousOutPoint: wire.OutPoint{Index: 0},
+				SignatureScript: []byte{txscript.OP_DATA_2, 0xaa,
+					0xbb, txscript.OP_TRUE},
+				Sequence: 4294967295,
+			},
+		},
+		TxOut: []*wire.TxOut{
+			{
+				Value:    1000000000,
+				PkScript: nil,
+			},
+		},
+		LockTime: 0,
+	}
+	pkScript := []byte{txscript.OP_DROP, txscript.OP_DROP, txscript.OP_TRUE}
+
+	vm, err := txscript.NewEngine(pkScript, tx, 0, 0, nil)
+	if err != nil {
+		t.Fatalf("failed to create script: %v", err)
+	}
+
+	tests := []struct {
+		scriptIdx int
+		name      string
+		bytes     []byte
+		offset    int
+	}{
+		{0, "OP_DATA_2", []byte{txscript.OP_DATA_2, 0xaa, 0xbb}, 0},
+		{0, "OP_1", []byte{txscript.OP_TRUE}, 3},
+		{1, "OP_DROP", []byte{txscript.OP_DROP}, 0},
+		{1, "OP_DROP", []byte{txscript.OP_DROP}, 1},
+		{1, "OP_1", []byte{txscript.OP_TRUE}, 2},
+	}
+	for i, test := range tests {
+		scriptIdx, step, err := vm.DisasmPCStep()
+		if err != nil {
+			t.Fatalf("#%d: unexpected DisasmPCStep error: %v", i, err)
+		}
+		if scriptIdx != test.scriptIdx || step.Name != test.name ||
+			step.Offset != test.offset || step.Failed ||
+			!bytes.Equal(step.Bytes, test.bytes) {
+
+			t.Errorf("#%d: unexpected step - got %d %v, want "+
+				"%d %04x: %s", i, scriptIdx, step.String(),
+				test.scriptIdx, test.offset, test.name)
+		}
+
+		done, err := vm.Step()
+		if err != nil {
+			t.Fatalf("#%d: unexpected Step error: %v", i, err)
+		}
+		if done != (i == len(tests)-1) {
+			t.Fatalf("#%d: unexpected done %v", i, done)
+		}
+	}
+	if err := vm.CheckErrorCondition(true); err != nil {
+		t.Errorf("unexpected CheckErrorCondition error: %v", err)
+	}
+}
+
+// traceTestScripts returns a pay-to-script-hash public key script along with a
+// signature script which redeems it.  The redeem script moves an item through
+// the alternate stack inside of a conditional so the trace covers all of the
+// stacks.
+func traceTestScripts(t testing.TB) ([]byte, []byte) {
+	redeemScript, err := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_TOALTSTACK).AddOp(txscript.OP_IF).
+		AddOp(txscript.OP_FROMALTSTACK).AddOp(txscript.OP_ENDIF).
+		AddOp(txscript.OP_EQUAL).Script()
+	if err != nil {
+		t.Fatalf("failed to build redeem script: %v", err)
+	}
+	pkScript, err := txscript.NewScriptBuilder().
+		AddOp(txscript.OP_HASH160).
+		AddData(colxutil.Hash160(redeemScript)).
+		AddOp(txscript.OP_EQUAL).Script()
+	if err != nil {
+		t.Fatalf("failed to build public key script: %v", err)
+	}
+	sigScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_1).
+		AddOp(txscript.OP_2).AddOp(txscript.OP_1).AddData(redeemScript).
+		Script()
+	if err != nil {
+		t.Fatalf("failed to build signature script: %v", err)
+	}
+	return pkScript, sigScript
+}
+
+// TestExecuteWithTrace ensures the execution trace of a pay-to-script-hash
+// redemption reports every executed opcode along with the expected stacks.
+func TestExecuteWithTrace(t *testing.T) {
+	t.Parallel()
+
+	pkScript, sigScript := traceTestScripts(t)
+	tx := createSpendingTx(sigScript, pkScript)
+	vm, err := txscript.NewEngine(pkScript, tx, 0, txscript.ScriptBip16,
+		nil)
+	if err != nil {
+		t.Fatalf("failed to create script: %v", err)
+	}
+
+	tests := []struct {
+		scriptIdx int
+		opcodeIdx int
+		name      string
+		stack     int // height of data stack
+		altStack  int // height of alternate stack
+		condStack []int
+	}{
+		// Signature script.
+		{0, 0, "OP_1", 1, 0, nil},
+		{0, 1, "OP_2", 2, 0, nil},
+		{0, 2, "OP_1", 3, 0, nil},
+		{0, 3, "OP_DATA_5", 4, 0, nil},
+
+		// Public key script.
+		{1, 0, "OP_HASH160", 4, 0, nil},
+		{1, 1, "OP_DATA_20", 5, 0, nil},
+		{1, 2, "OP_EQUAL", 4, 0, nil},
+
+		// Redeem script executed with the stack of the signature
+		// script minus the redeem script itself.
+		{2, 0, "OP_TOALTSTACK", 2, 1, nil},
+		{2, 1, "OP_IF", 1, 1, []int{txscript.OpCondTrue}},
+		{2, 2, "OP_FROMALTSTACK", 2, 0, []int{txscript.OpCondTrue}},
+		{2, 3, "OP_ENDIF", 2, 0, nil},
+		{2, 4, "OP_EQUAL", 1, 0, nil},
+	}
+
+	var steps []*txscript.StepInfo
+	err = vm.ExecuteWithTrace(func(step *txscript.StepInfo) {
+		steps = append(steps, step)
+	})
+	if err != nil {
+		t.Fatalf("unexpected ExecuteWithTrace error: %v", err)
+	}
+	if len(steps) != len(tests) {
+		t.Fatalf("unexpected number of steps - got %d, want %d",
+			len(steps), len(tests))
+	}
+	for i, test := range tests {
+		step := steps[i]
+		if step.ScriptIdx != test.scriptIdx ||
+			step.OpcodeIdx != test.opcodeIdx ||
+			step.Opcode.Name != test.name {
+
+			t.Errorf("#%d: unexpected opcode - got %02x:%04x %s, "+
+				"want %02x:%04x %s", i, step.ScriptIdx,
+				step.OpcodeIdx, step.Opcode.Name, test.scriptIdx,
+				test.opcodeIdx, test.name)
+		}
+		if len(step.Stack) != test.stack ||
+			len(step.AltStack) != test.altStack {
+
+			t.Errorf("#%d (%s): unexpected stack heights - got "+
+				"%d/%d, want %d/%d", i, test.name,
+				len(step.Stack), len(step.AltStack), test.stack,
+				test.altStack)
+		}
+		if len(step.CondStack) != len(test.condStack) {
+			t.Errorf("#%d (%s): unexpected conditional stack - got "+
+				"%v, want %v", i, test.name, step.CondStack,
+				test.condStack)
+			continue
+		}
+		for j := range test.condStack {
+			if step.CondStack[j] != test.condStack[j] {
+				t.Errorf("#%d (%s): unexpected conditional "+
+					"stack - got %v, want %v", i, test.name,
+					step.CondStack, test.condStack)
+				break
+			}
+		}
+		if step.Err != nil {
+			t.Errorf("#%d (%s): unexpected error %v", i, test.name,
+				step.Err)
+		}
+	}
+
+	// The redeem script is pushed by the last opcode of the signature
+	// script and ends up on the stack.
+	redeemStep := steps[3]
+	if redeemStep.Opcode.Offset != 3 ||
+		!bytes.Equal(redeemStep.Stack[3], redeemStep.Opcode.Bytes[1:]) {
+
+		t.Errorf("unexpected redeem script step %v with stack %x",
+			redeemStep.Opcode.String(), redeemStep.Stack)
+	}
+
+	// Ensure a failing opcode is reported with its error.
+	pkScript = []byte{txscript.OP_VERIFY, txscript.OP_VERIFY}
+	tx = createSpendingTx([]byte{txscript.OP_1}, pkScript)
+	vm, err = txscript.NewEngine(pkScript, tx, 0, 0, nil)
+	if err != nil {
+		t.Fatalf("failed to create script: %v", err)
+	}
+	var lastStep *txscript.StepInfo
+	numSteps := 0
+	err = vm.ExecuteWithTrace(func(step *txscript.StepInfo) {
+		lastStep = step
+		numSteps++
+	})
+	if err == nil {
+		t.Fatalf("ExecuteWithTrace: failing script succeeded")
+	}
+	if numSteps != 3 || lastStep.Err != err ||
+		lastStep.Opcode.Name != "OP_VERIFY" || lastStep.OpcodeIdx != 1 {
+
+		t.Errorf("unexpected failing step after %d steps - got %+v, "+
+			"want error %v", numSteps, lastStep, err)
+	}
+}
+
+// benchmarkExecute benchmarks executing a pay-to-script-hash redemption with
+// the passed step callback.
+func benchmarkExecute(b *testing.B, callback func(step *txscript.StepInfo)) {
+	pkScript, sigScript := traceTestScripts(b)
+	tx := createSpendingTx(sigScript, pkScript)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vm, err := txscript.NewEngine(pkScript, tx, 0,
+			txscript.ScriptBip16, nil)
+		if err != nil {
+			b.Fatalf("failed to create script: %v", err)
+		}
+		vm.SetStepCallback(callback)
+		if err := vm.Execute(); err != nil {
+			b.Fatalf("unexpected Execute error: %v", err)
+		}
+	}
+}
+
+// BenchmarkExecute benchmarks script execution without tracing, which must not
+// be any slower or allocate any more than before tracing was supported.
+func BenchmarkExecute(b *testing.B) {
+	benchmarkExecute(b, nil)
+}
+
+// BenchmarkExecuteWithTrace benchmarks script execution while tracing it in
+// order to compare it against BenchmarkExecute.
+func BenchmarkExecuteWithTrace(b *testing.B) {
+	benchmarkExecute(b, func(step *txscript.StepInfo) {})
+}
+
 // TestCheckErrorCondition tests the execute early test in CheckErrorCondition()
 // since most code paths are tested elsewhere.
 func TestCheckErrorCondition(t *testing.T) {
@@ -440,7 +703,7 @@ func TestCheckSignatureEncoding(t *testing.T) {
 		},
 	}
 
-	flags := txscript.ScriptVerifyStrictEncoding
+	flags := txscript.ScriptVerifyDERSignatures
 	for _, test := range tests {
 		err := txscript.TstCheckSignatureEncoding(test.sig, flags)
 		if err != nil && test.isValid {
@@ -453,3 +716,207 @@ func TestCheckSignatureEncoding(t *testing.T) {
 		}
 	}
 }
+
+// TestEncodingFlagsIsolation ensures each of the DER, low S, and strict
+// encoding flags enforces only its own rule and fails with its own error.
+func TestEncodingFlagsIsolation(t *testing.T) {
+	t.Parallel()
+
+	// Signatures which violate the strict DER encoding, the low S value
+	// requirement, or both.
+	paddedR := decodeHex("304402200060558477337b9022e70534f1fea71a318ca" +
+		"f836812465a2509931c5e7c4987022078ec32bd50ac9e03a349ba953dfd9" +
+		"fe1c8d2dd8bdb1d38ddca844d3d5c78c118")
+	highS := decodeHex("304502203e4516da7253cf068effec6b95c41221c0cf3a8" +
+		"e6ccb8cbf1725b562e9afde2c022100ab1e3da73d67e32045a20e0b999e0" +
+		"49978ea8d6ee5480d485fcf2ce0d03b2ef0")
+	paddedRHighS := decodeHex("30460221003e4516da7253cf068effec6b95c4122" +
+		"1c0cf3a8e6ccb8cbf1725b562e9afde2c022100ab1e3da73d67e32045a" +
+		"20e0b999e049978ea8d6ee5480d485fcf2ce0d03b2ef0")
+	hybridKey := decodeHex("0679be667ef9dcbbac55a06295ce870b07029bfcdb2" +
+		"dce28d959f2815b16f81798483ada7726a3c4655da4fbfc0e1108a8fd17b" +
+		"448a68554199c47d08ffb10d4b8")
+	const badHashType = txscript.SigHashType(0x21)
+
+	tests := []struct {
+		name        string
+		flags       txscript.ScriptFlags
+		paddedR     error
+		highS       error
+		paddedRHigh error
+		hashType    error
+		pubKey      error
+	}{
+		{
+			name: "no flags",
+		},
+		{
+			name:        "DERSIG",
+			flags:       txscript.ScriptVerifyDERSignatures,
+			paddedR:     txscript.ErrStackInvalidDERSignature,
+			paddedRHigh: txscript.ErrStackInvalidDERSignature,
+		},
+		{
+			name:        "LOW_S",
+			flags:       txscript.ScriptVerifyLowS,
+			highS:       txscript.ErrStackInvalidLowSSignature,
+			paddedRHigh: txscript.ErrStackInvalidLowSSignature,
+		},
+		{
+			name:     "STRICTENC",
+			flags:    txscript.ScriptVerifyStrictEncoding,
+			hashType: txscript.ErrStackInvalidHashType,
+			pubKey:   txscript.ErrStackInvalidPubKey,
+		},
+		{
+			name: "DERSIG,LOW_S",
+			flags: txscript.ScriptVerifyDERSignatures |
+				txscript.ScriptVerifyLowS,
+			paddedR:     txscript.ErrStackInvalidDERSignature,
+			highS:       txscript.ErrStackInvalidLowSSignature,
+			paddedRHigh: txscript.ErrStackInvalidDERSignature,
+		},
+	}
+
+	for _, test := range tests {
+		sigTests := []struct {
+			name string
+			sig  []byte
+			want error
+		}{
+			{"padded R", paddedR, test.paddedR},
+			{"high S", highS, test.highS},
+			{"padded R with high S", paddedRHighS, test.paddedRHigh},
+		}
+		for _, sigTest := range sigTests {
+			err := txscript.TstCheckSignatureEncoding(sigTest.sig,
+				test.flags)
+			if err != sigTest.want {
+				t.Errorf("%s: unexpected error for %s signature - "+
+					"got %v, want %v", test.name, sigTest.name,
+					err, sigTest.want)
+			}
+		}
+
+		err := txscript.TstCheckHashTypeEncoding(badHashType, test.flags)
+		if err != test.hashType {
+			t.Errorf("%s: unexpected error for undefined hash type - "+
+				"got %v, want %v", test.name, err, test.hashType)
+		}
+		err = txscript.TstCheckPubKeyEncoding(hybridKey, test.flags)
+		if err != test.pubKey {
+			t.Errorf("%s: unexpected error for hybrid pubkey - got %v, "+
+				"want %v", test.name, err, test.pubKey)
+		}
+	}
+}
+
+// TestMinimalDataPush ensures every class of data push is rejected when it does
+// not use the minimal encoding and the minimal data flag is set, while the
+// same scripts are accepted without the flag.
+func TestMinimalDataPush(t *testing.T) {
+	t.Parallel()
+
+	// pushData returns a script which pushes data of the given length with
+	// the passed opcode and little-endian length prefix.
+	pushData := func(opcode byte, lenPrefix []byte, dataLen int) []byte {
+		script := append([]byte{opcode}, lenPrefix...)
+		return append(script, bytes.Repeat([]byte{0x11}, dataLen)...)
+	}
+
+	tests := []struct {
+		name      string
+		sigScript []byte
+		minimal   bool
+	}{
+		// Non-minimal encodings.
+		{"empty via OP_PUSHDATA1", pushData(txscript.OP_PUSHDATA1,
+			[]byte{0x00}, 0), false},
+		{"empty via OP_PUSHDATA2", pushData(txscript.OP_PUSHDATA2,
+			[]byte{0x00, 0x00}, 0), false},
+		{"empty via OP_PUSHDATA4", pushData(txscript.OP_PUSHDATA4,
+			[]byte{0x00, 0x00, 0x00, 0x00}, 0), false},
+		{"1 via OP_DATA_1", []byte{txscript.OP_DATA_1, 0x01}, false},
+		{"16 via OP_DATA_1", []byte{txscript.OP_DATA_1, 0x10}, false},
+		{"5 via OP_PUSHDATA1", []byte{txscript.OP_PUSHDATA1, 0x01,
+			0x05}, false},
+		{"-1 via OP_DATA_1", []byte{txscript.OP_DATA_1, 0x81}, false},
+		{"-1 via OP_PUSHDATA2", []byte{txscript.OP_PUSHDATA2, 0x01,
+			0x00, 0x81}, false},
+		{"1 byte via OP_PUSHDATA1", pushData(txscript.OP_PUSHDATA1,
+			[]byte{0x01}, 1), false},
+		{"75 bytes via OP_PUSHDATA1", pushData(txscript.OP_PUSHDATA1,
+			[]byte{0x4b}, 75), false},
+		{"255 bytes via OP_PUSHDATA2", pushData(txscript.OP_PUSHDATA2,
+			[]byte{0xff, 0x00}, 255), false},
+		{"520 bytes via OP_PUSHDATA4", pushData(txscript.OP_PUSHDATA4,
+			[]byte{0x08, 0x02, 0x00, 0x00}, 520), false},
+
+		// Minimal encodings.
+		{"empty via OP_0", []byte{txscript.OP_0}, true},
+		{"-1 via OP_1NEGATE", []byte{txscript.OP_1NEGATE}, true},
+		{"1 via OP_1", []byte{txscript.OP_1}, true},
+		{"16 via OP_16", []byte{txscript.OP_16}, true},
+		{"0 via OP_DATA_1", []byte{txscript.OP_DATA_1, 0x00}, true},
+		{"17 via OP_DATA_1", []byte{txscript.OP_DATA_1, 0x11}, true},
+		{"0x80 via OP_DATA_1", []byte{txscript.OP_DATA_1, 0x80}, true},
+		{"75 bytes via OP_DATA_75", pushData(txscript.OP_DATA_75, nil,
+			75), true},
+		{"76 bytes via OP_PUSHDATA1", pushData(txscript.OP_PUSHDATA1,
+			[]byte{0x4c}, 76), true},
+		{"256 bytes via OP_PUSHDATA2", pushData(txscript.OP_PUSHDATA2,
+			[]byte{0x00, 0x01}, 256), true},
+		{"520 bytes via OP_PUSHDATA2", pushData(txscript.OP_PUSHDATA2,
+			[]byte{0x08, 0x02}, 520), true},
+
+		// Non-minimal pushes in branches which are not executed are
+		// not checked.
+		{"non-minimal push in unexecuted branch", []byte{
+			txscript.OP_0, txscript.OP_IF, txscript.OP_PUSHDATA1,
+			0x00, txscript.OP_ENDIF, txscript.OP_1}, true},
+	}
+
+	tx := &wire.MsgTx{
+		Version: 1,
+		TxIn: []*wire.TxIn{{
+			PreviousOutPoint: wire.OutPoint{Index: 0},
+			Sequence:         wire.MaxTxInSequenceNum,
+		}},
+		TxOut: []*wire.TxOut{{Value: 0}},
+	}
+	pkScript := []byte{txscript.OP_DROP, txscript.OP_TRUE}
+
+	for _, test := range tests {
+		tx.TxIn[0].SignatureScript = test.sigScript
+
+		// The script must always be valid without the flag.
+		vm, err := txscript.NewEngine(pkScript, tx, 0, 0, nil)
+		if err != nil {
+			t.Errorf("%s: failed to create script: %v", test.name, err)
+			continue
+		}
+		if err := vm.Execute(); err != nil {
+			t.Errorf("%s: unexpected error without flag: %v",
+				test.name, err)
+			continue
+		}
+
+		vm, err = txscript.NewEngine(pkScript, tx, 0,
+			txscript.ScriptVerifyMinimalData, nil)
+		if err != nil {
+			t.Errorf("%s: failed to create script: %v", test.name, err)
+			continue
+		}
+		err = vm.Execute()
+		if test.minimal && err != nil {
+			t.Errorf("%s: unexpected error with flag: %v", test.name,
+				err)
+			continue
+		}
+		if !test.minimal && err != txscript.ErrStackMinimalData {
+			t.Errorf("%s: unexpected error with flag - got %v, "+
+				"want %v", test.name, err, txscript.ErrStackMinimalData)
+			continue
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+// EngineStats houses statistics about the resources used by a script engine
+// while executing all of its scripts, including the redeem script of a
+// pay-to-script-hash input.
+type EngineStats struct {
+	// ExecutedOps is the number of opcodes executed, including data pushes.
+	// Opcodes in branches which are not executing are not counted, with the
+	// exception of the conditional opcodes, which are always executed.
+	ExecutedOps int
+
+	// MaxStackDepth is the maximum combined number of items on the data
+	// and alt stacks reached.
+	MaxStackDepth int
+
+	// PushedBytes is the combined size of the items pushed to the data
+	// stack by data push opcodes, including the small integer opcodes.
+	PushedBytes int
+
+	// SigOps is the number of signature operations executed.  Each
+	// OP_CHECKSIG and OP_CHECKSIGVERIFY counts as one, while each
+	// OP_CHECKMULTISIG and OP_CHECKMULTISIGVERIFY counts as the number of
+	// public keys it provides.
+	SigOps int
+}
+
+// EngineLimits defines limits on the resources used by a script engine in
+// addition to those imposed by the consensus rules, such as MaxOpsPerScript.
+// Exceeding one of them results in execution failing with the associated
+// error.  A limit of zero disables it.
+type EngineLimits struct {
+	// MaxExecutedOps is the maximum number of opcodes executed as counted
+	// by EngineStats.ExecutedOps.  Exceeding it results in
+	// ErrStackExecutedOpsLimit.
+	MaxExecutedOps int
+
+	// MaxStackDepth is the maximum combined number of items on the data and
+	// alt stacks.  Exceeding it results in ErrStackDepthLimit.
+	MaxStackDepth int
+
+	// MaxPushedBytes is the maximum combined size of the items pushed by
+	// data push opcodes as counted by EngineStats.PushedBytes.  Exceeding
+	// it results in ErrStackPushedBytesLimit.
+	MaxPushedBytes int
+
+	// MaxSigOps is the maximum number of signature operations executed as
+	// counted by EngineStats.SigOps.  Exceeding it results in
+	// ErrStackSigOpsLimit.
+	MaxSigOps int
+}
+
+// Stats returns the statistics about the resources used by the engine so far.
+// It is typically called after Execute to examine the resources used by the
+// scripts.
+func (vm *Engine) Stats() EngineStats {
+	return vm.stats
+}
+
+// countExecutedOp accounts for executing an opcode and ensures the executed
+// opcode limit is not exceeded.
+func (vm *Engine) countExecutedOp() error {
+	vm.stats.ExecutedOps++
+	limit := vm.limits.MaxExecutedOps
+	if limit > 0 && vm.stats.ExecutedOps > limit {
+		return ErrStackExecutedOpsLimit
+	}
+	return nil
+}
+
+// countPushedBytes accounts for pushing an item of the passed size by a data
+// push opcode and ensures the pushed bytes limit is not exceeded.
+func (vm *Engine) countPushedBytes(size int) error {
+	vm.stats.PushedBytes += size
+	limit := vm.limits.MaxPushedBytes
+	if limit > 0 && vm.stats.PushedBytes > limit {
+		return ErrStackPushedBytesLimit
+	}
+	return nil
+}
+
+// countSigOps accounts for executing the passed number of signature operations
+// and ensures the signature operation limit is not exceeded.
+func (vm *Engine) countSigOps(n int) error {
+	vm.stats.SigOps += n
+	limit := vm.limits.MaxSigOps
+	if limit > 0 && vm.stats.SigOps > limit {
+		return ErrStackSigOpsLimit
+	}
+	return nil
+}
+
+// countStackDepth accounts for the current combined depth of the data and alt
+// stacks and ensures the stack depth limit is not exceeded.
+func (vm *Engine) countStackDepth() error {
+	depth := int(vm.dstack.Depth() + vm.astack.Depth())
+	if depth > vm.stats.MaxStackDepth {
+		vm.stats.MaxStackDepth = depth
+	}
+	limit := vm.limits.MaxStackDepth
+	if limit > 0 && depth > limit {
+		return ErrStackDepthLimit
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// engineStatsTest describes a script pair along with the resources executing
+// it is expected to use.
+type engineStatsTest struct {
+	name      string
+	sigScript []byte
+	pkScript  []byte
+	stats     txscript.EngineStats
+}
+
+// engineStatsTests returns tests with pathological scripts such as deeply
+// nested conditionals and many data pushes.
+func engineStatsTests(t *testing.T) []engineStatsTest {
+	mustScript := func(b *txscript.ScriptBuilder) []byte {
+		script, err := b.Script()
+		if err != nil {
+			t.Fatalf("unable to create script: %v", err)
+		}
+		return script
+	}
+
+	// 100 pushes of 10 bytes each, all of which remain on the stack.
+	manyPushes := txscript.NewScriptBuilder()
+	for i := 0; i < 100; i++ {
+		manyPushes.AddData(bytes.Repeat([]byte{0x01}, 10))
+	}
+
+	// 50 nested conditionals which are all executed.
+	deepIf := txscript.NewScriptBuilder()
+	for i := 0; i < 50; i++ {
+		deepIf.AddOp(txscript.OP_1).AddOp(txscript.OP_IF)
+	}
+	deepIf.AddOp(txscript.OP_1)
+	for i := 0; i < 50; i++ {
+		deepIf.AddOp(txscript.OP_ENDIF)
+	}
+
+	// A branch which is not executed does not count.
+	skipped := txscript.NewScriptBuilder().AddOp(txscript.OP_0).
+		AddOp(txscript.OP_IF)
+	for i := 0; i < 20; i++ {
+		skipped.AddData(bytes.Repeat([]byte{0x01}, 20))
+	}
+	skipped.AddOp(txscript.OP_CHECKSIG).AddOp(txscript.OP_ENDIF).
+		AddOp(txscript.OP_1)
+
+	// A failed signature check followed by a 0-of-3 multisig.
+	sigOps := txscript.NewScriptBuilder().AddOp(txscript.OP_0).
+		AddOp(txscript.OP_0).AddOp(txscript.OP_CHECKSIG).
+		AddOp(txscript.OP_DROP).AddOp(txscript.OP_0).
+		AddOp(txscript.OP_0).AddOp(txscript.OP_0).AddOp(txscript.OP_0).
+		AddOp(txscript.OP_0).AddOp(txscript.OP_3).
+		AddOp(txscript.OP_CHECKMULTISIG)
+
+	return []engineStatsTest{
+		{
+			name:     "many pushes",
+			pkScript: mustScript(manyPushes),
+			stats: txscript.EngineStats{
+				ExecutedOps:   100,
+				MaxStackDepth: 100,
+				PushedBytes:   1000,
+			},
+		},
+		{
+			name:     "deep if nesting",
+			pkScript: mustScript(deepIf),
+			stats: txscript.EngineStats{
+				ExecutedOps:   151,
+				MaxStackDepth: 1,
+				PushedBytes:   51,
+			},
+		},
+		{
+			name:     "skipped branch",
+			pkScript: mustScript(skipped),
+			stats: txscript.EngineStats{
+				ExecutedOps:   4,
+				MaxStackDepth: 1,
+				PushedBytes:   1,
+			},
+		},
+		{
+			name:     "signature operations",
+			pkScript: mustScript(sigOps),
+			stats: txscript.EngineStats{
+				ExecutedOps:   11,
+				MaxStackDepth: 6,
+				PushedBytes:   1,
+				SigOps:        4,
+			},
+		},
+		{
+			name: "both scripts",
+			sigScript: mustScript(txscript.NewScriptBuilder().
+				AddOp(txscript.OP_1).AddOp(txscript.OP_2)),
+			pkScript: mustScript(txscript.NewScriptBuilder().
+				AddOp(txscript.OP_ADD).AddOp(txscript.OP_3).
+				AddOp(txscript.OP_EQUAL)),
+			stats: txscript.EngineStats{
+				ExecutedOps:   5,
+				MaxStackDepth: 2,
+				PushedBytes:   3,
+			},
+		},
+	}
+}
+
+// executeStatsTest executes the script pair of the passed test with the passed
+// limits and returns the resulting statistics and error.
+func executeStatsTest(t *testing.T, test *engineStatsTest, limits txscript.EngineLimits) (txscript.EngineStats, error) {
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&wire.ShaHash{}, 0),
+		test.sigScript))
+	vm, err := txscript.NewEngineWithOptions(test.pkScript, tx, 0, 0,
+		&txscript.EngineOptions{Limits: limits})
+	if err != nil {
+		t.Fatalf("%s: unable to create engine: %v", test.name, err)
+	}
+	err = vm.Execute()
+	return vm.Stats(), err
+}
+
+// TestEngineStats ensures the statistics about the resources used by the
+// engine are accurate once the scripts are executed.
+func TestEngineStats(t *testing.T) {
+	t.Parallel()
+
+	tests := engineStatsTests(t)
+	for i := range tests {
+		test := &tests[i]
+		stats, err := executeStatsTest(t, test, txscript.EngineLimits{})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if stats != test.stats {
+			t.Errorf("%s: unexpected stats - got %+v, want %+v",
+				test.name, stats, test.stats)
+		}
+	}
+}
+
+// TestEngineLimits ensures each of the configurable limits results in its
+// associated error once exceeded and that limits which are not exceeded do not
+// affect execution.
+func TestEngineLimits(t *testing.T) {
+	t.Parallel()
+
+	tests := engineStatsTests(t)
+	for i := range tests {
+		test := &tests[i]
+
+		// Limits which are exactly met do not fail the scripts.
+		limits := txscript.EngineLimits{
+			MaxExecutedOps: test.stats.ExecutedOps,
+			MaxStackDepth:  test.stats.MaxStackDepth,
+			MaxPushedBytes: test.stats.PushedBytes,
+			MaxSigOps:      test.stats.SigOps,
+		}
+		if _, err := executeStatsTest(t, test, limits); err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+		}
+
+		// Each limit which is one less than the resources used results in
+		// its error.
+		exceeded := []struct {
+			name  string
+			used  int
+			limit txscript.EngineLimits
+			err   error
+		}{
+			{"executed ops", test.stats.ExecutedOps,
+				txscript.EngineLimits{
+					MaxExecutedOps: test.stats.ExecutedOps - 1,
+				}, txscript.ErrStackExecutedOpsLimit},
+			{"stack depth", test.stats.MaxStackDepth,
+				txscript.EngineLimits{
+					MaxStackDepth: test.stats.MaxStackDepth - 1,
+				}, txscript.ErrStackDepthLimit},
+			{"pushed bytes", test.stats.PushedBytes,
+				txscript.EngineLimits{
+					MaxPushedBytes: test.stats.PushedBytes - 1,
+				}, txscript.ErrStackPushedBytesLimit},
+			{"sig ops", test.stats.SigOps,
+				txscript.EngineLimits{
+					MaxSigOps: test.stats.SigOps - 1,
+				}, txscript.ErrStackSigOpsLimit},
+		}
+		for _, limit := range exceeded {
+			// A limit of zero is disabled, so it can't be exceeded
+			// by a single unit.
+			if limit.used <= 1 {
+				continue
+			}
+			_, err := executeStatsTest(t, test, limit.limit)
+			if err != limit.err {
+				t.Errorf("%s: %s: unexpected error - got %v, want %v",
+					test.name, limit.name, err, limit.err)
+			}
+		}
+	}
+}
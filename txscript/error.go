@@ -125,6 +125,21 @@ var (
 	// flag is set and the script contains invalid pubkeys.
 	ErrStackInvalidPubKey = errors.New("invalid strict pubkey")
 
+	// ErrStackInvalidDERSignature is returned when the
+	// ScriptVerifyDERSignatures flag is set and a signature is not encoded
+	// with the strict DER encoding.
+	ErrStackInvalidDERSignature = errors.New("non-canonical DER signature")
+
+	// ErrStackInvalidHashType is returned when the
+	// ScriptVerifyStrictEncoding flag is set and the hash type of a
+	// signature is not one of the defined types.
+	ErrStackInvalidHashType = errors.New("invalid signature hash type")
+
+	// ErrStackNullFail is returned when the ScriptVerifyNullFail flag is
+	// set and a signature check fails with a signature which is not empty.
+	ErrStackNullFail = errors.New("signature must be empty if the " +
+		"signature check fails")
+
 	// ErrStackCleanStack is returned when the ScriptVerifyCleanStack flag
 	// is set and after evalution the stack does not contain only one element,
 	// which also must be true if interpreted as a boolean.
@@ -134,6 +149,27 @@ var (
 	// is set and the script contains push operations that do not use
 	// the minimal opcode required.
 	ErrStackMinimalData = errors.New("non-minimally encoded script number")
+
+	// ErrStackExecutedOpsLimit is returned when the number of opcodes
+	// executed exceeds EngineLimits.MaxExecutedOps.
+	ErrStackExecutedOpsLimit = errors.New("executed operations exceed " +
+		"the configured limit")
+
+	// ErrStackDepthLimit is returned when the combined depth of the data
+	// and alt stacks exceeds EngineLimits.MaxStackDepth.
+	ErrStackDepthLimit = errors.New("stack depth exceeds the configured " +
+		"limit")
+
+	// ErrStackPushedBytesLimit is returned when the combined size of the
+	// items pushed by data push opcodes exceeds
+	// EngineLimits.MaxPushedBytes.
+	ErrStackPushedBytesLimit = errors.New("pushed bytes exceed the " +
+		"configured limit")
+
+	// ErrStackSigOpsLimit is returned when the number of signature
+	// operations executed exceeds EngineLimits.MaxSigOps.
+	ErrStackSigOpsLimit = errors.New("signature operations exceed the " +
+		"configured limit")
 )
 
 var (
@@ -154,4 +190,37 @@ var (
 	ErrBadNumRequired = errors.New("more signatures required than keys present")
 
 	ErrTooMuchNullData = errors.New("Err Too Much Null Data")
+
+	// ErrNotMultisigScript is returned from ExtractMultisigDetails when the
+	// passed script is not a multi-signature script.
+	ErrNotMultisigScript = errors.New("not a multisig script")
+
+	// ErrMultiSigInvalidPubKey is returned from ExtractMultisigDetails when
+	// the passed multi-signature script contains a public key which does
+	// not parse.
+	ErrMultiSigInvalidPubKey = errors.New("multisig script contains an " +
+		"invalid public key")
+
+	// ErrMissingRedeemScript is returned from AnalyzeSignedInput when the
+	// signature script of a pay-to-script-hash input does not contain the
+	// redeem script.
+	ErrMissingRedeemScript = errors.New("signature script does not " +
+		"contain the redeem script")
+
+	// ErrRedeemScriptMismatch is returned from AnalyzeSignedInput when the
+	// redeem script in the signature script of a pay-to-script-hash input
+	// does not hash to the script hash of the output.
+	ErrRedeemScriptMismatch = errors.New("redeem script does not match " +
+		"the script hash")
+
+	// ErrUnsupportedSignedScript is returned from AnalyzeSignedInput when
+	// the script being spent is not a pay-to-pubkey, pay-to-pubkey-hash,
+	// or multi-signature script.
+	ErrUnsupportedSignedScript = errors.New("signatures of script type " +
+		"can not be analyzed")
+
+	// ErrScriptTemplateRegistered is returned from RegisterScriptTemplate
+	// when a script template with the same name is already registered.
+	ErrScriptTemplateRegistered = errors.New("script template already " +
+		"registered")
 )
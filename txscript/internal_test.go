@@ -50,6 +50,14 @@ func TstCheckPubKeyEncoding(pubKey []byte, flags ScriptFlags) error {
 	return vm.checkPubKeyEncoding(pubKey)
 }
 
+// TstCheckHashTypeEncoding makes the internal checkHashTypeEncoding function
+// available to the test package.  Since it only really needs from the engine
+// for the flags, just accept the flags and create a new engine skeleton.
+func TstCheckHashTypeEncoding(hashType SigHashType, flags ScriptFlags) error {
+	vm := Engine{flags: flags}
+	return vm.checkHashTypeEncoding(hashType)
+}
+
 // TstCheckSignatureEncoding makes the internal checkSignatureEncoding function
 // available to the test package.  Since it only really needs from the engine
 // for the flags, just accept the flags and create a new engine skeleton with
@@ -5,6 +5,9 @@
 package txscript
 
 import (
+	"errors"
+	"io"
+
 	"github.com/btcsuite/btclog"
 )
 
@@ -19,16 +22,41 @@ func init() {
 }
 
 // DisableLog disables all library log output.  Logging output is disabled
-// by default until UseLogger is called.
+// by default until either UseLogger or SetLogWriter are called.
 func DisableLog() {
 	log = btclog.Disabled
 }
 
 // UseLogger uses a specified Logger to output package logging info.
+// This should be used in preference to SetLogWriter if the caller is also
+// using btclog.
 func UseLogger(logger btclog.Logger) {
 	log = logger
 }
 
+// SetLogWriter uses a specified io.Writer to output package logging info.
+// This allows a caller to direct package logging output without needing a
+// dependency on seelog.  If the caller is also using btclog, UseLogger should
+// be used instead.
+func SetLogWriter(w io.Writer, level string) error {
+	if w == nil {
+		return errors.New("nil writer")
+	}
+
+	lvl, ok := btclog.LogLevelFromString(level)
+	if !ok {
+		return errors.New("invalid log level")
+	}
+
+	l, err := btclog.NewLoggerFromWriter(w, lvl)
+	if err != nil {
+		return err
+	}
+
+	UseLogger(l)
+	return nil
+}
+
 // LogClosure is a closure that can be printed with %v to be used to
 // generate expensive-to-create data for a detailed log level and avoid doing
 // the work if the data isn't printed.
@@ -216,6 +216,7 @@ const (
 	OP_NOP2                = 0xb1 // 177
 	OP_CHECKLOCKTIMEVERIFY = 0xb1 // 177 - AKA OP_NOP2
 	OP_NOP3                = 0xb2 // 178
+	OP_CHECKSEQUENCEVERIFY = 0xb2 // 178 - AKA OP_NOP3
 	OP_NOP4                = 0xb3 // 179
 	OP_NOP5                = 0xb4 // 180
 	OP_NOP6                = 0xb5 // 181
@@ -417,6 +418,7 @@ var opcodeArray = [256]opcode{
 	OP_VERIFY:              {OP_VERIFY, "OP_VERIFY", 1, opcodeVerify},
 	OP_RETURN:              {OP_RETURN, "OP_RETURN", 1, opcodeReturn},
 	OP_CHECKLOCKTIMEVERIFY: {OP_CHECKLOCKTIMEVERIFY, "OP_CHECKLOCKTIMEVERIFY", 1, opcodeCheckLockTimeVerify},
+	OP_CHECKSEQUENCEVERIFY: {OP_CHECKSEQUENCEVERIFY, "OP_CHECKSEQUENCEVERIFY", 1, opcodeCheckSequenceVerify},
 
 	// Stack opcodes.
 	OP_TOALTSTACK:   {OP_TOALTSTACK, "OP_TOALTSTACK", 1, opcodeToAltStack},
@@ -499,7 +501,6 @@ var opcodeArray = [256]opcode{
 
 	// Reserved opcodes.
 	OP_NOP1:  {OP_NOP1, "OP_NOP1", 1, opcodeNop},
-	OP_NOP3:  {OP_NOP3, "OP_NOP3", 1, opcodeNop},
 	OP_NOP4:  {OP_NOP4, "OP_NOP4", 1, opcodeNop},
 	OP_NOP5:  {OP_NOP5, "OP_NOP5", 1, opcodeNop},
 	OP_NOP6:  {OP_NOP6, "OP_NOP6", 1, opcodeNop},
@@ -697,6 +698,7 @@ func (pop *parsedOpcode) checkMinimalDataPush() error {
 	opcode := pop.opcode.value
 
 	if dataLen == 0 && opcode != OP_0 {
+		// Should have used OP_0
 		return ErrStackMinimalData
 	} else if dataLen == 1 && data[0] >= 1 && data[0] <= 16 {
 		if opcode != OP_1+data[0]-1 {
@@ -705,6 +707,7 @@ func (pop *parsedOpcode) checkMinimalDataPush() error {
 		}
 	} else if dataLen == 1 && data[0] == 0x81 {
 		if opcode != OP_1NEGATE {
+			// Should have used OP_1NEGATE
 			return ErrStackMinimalData
 		}
 	} else if dataLen <= 75 {
@@ -714,10 +717,12 @@ func (pop *parsedOpcode) checkMinimalDataPush() error {
 		}
 	} else if dataLen <= 255 {
 		if opcode != OP_PUSHDATA1 {
+			// Should have used OP_PUSHDATA1
 			return ErrStackMinimalData
 		}
 	} else if dataLen <= 65535 {
 		if opcode != OP_PUSHDATA2 {
+			// Should have used OP_PUSHDATA2
 			return ErrStackMinimalData
 		}
 	}
@@ -857,7 +862,7 @@ func opcodePushData(op *parsedOpcode, vm *Engine) error {
 
 // opcode1Negate pushes -1, encoded as a number, to the data stack.
 func opcode1Negate(op *parsedOpcode, vm *Engine) error {
-	vm.dstack.PushInt(scriptNum(-1))
+	vm.dstack.PushInt(ScriptNum(-1))
 	return nil
 }
 
@@ -867,7 +872,7 @@ func opcode1Negate(op *parsedOpcode, vm *Engine) error {
 func opcodeN(op *parsedOpcode, vm *Engine) error {
 	// The opcodes are all defined consecutively, so the numeric value is
 	// the difference.
-	vm.dstack.PushInt(scriptNum((op.opcode.value - (OP_1 - 1))))
+	vm.dstack.PushInt(ScriptNum((op.opcode.value - (OP_1 - 1))))
 	return nil
 }
 
@@ -876,8 +881,8 @@ func opcodeN(op *parsedOpcode, vm *Engine) error {
 // the flag to discourage use of NOPs is set for select opcodes.
 func opcodeNop(op *parsedOpcode, vm *Engine) error {
 	switch op.opcode.value {
-	case OP_NOP1, OP_NOP3, OP_NOP4, OP_NOP5,
-		OP_NOP6, OP_NOP7, OP_NOP8, OP_NOP9, OP_NOP10:
+	case OP_NOP1, OP_NOP4, OP_NOP5, OP_NOP6, OP_NOP7, OP_NOP8, OP_NOP9,
+		OP_NOP10:
 		if vm.hasFlag(ScriptDiscourageUpgradableNops) {
 			return fmt.Errorf("OP_NOP%d reserved for soft-fork "+
 				"upgrades", op.opcode.value-(OP_NOP1-1))
@@ -1009,6 +1014,26 @@ func opcodeReturn(op *parsedOpcode, vm *Engine) error {
 	return ErrStackEarlyReturn
 }
 
+// verifyLockTime ensures the passed lock time from the stack is satisfied by
+// the passed lock time of the transaction.  Lock times below the passed
+// threshold are interpreted differently than the ones at or above it, so the
+// lock times in both the script and the transaction must be of the same type.
+func verifyLockTime(txLockTime, threshold, lockTime int64) error {
+	if !((txLockTime < threshold && lockTime < threshold) ||
+		(txLockTime >= threshold && lockTime >= threshold)) {
+		return fmt.Errorf("mismatched locktime types -- tx locktime %d, stack "+
+			"locktime %d", txLockTime, lockTime)
+	}
+
+	if lockTime > txLockTime {
+		str := "locktime requirement not satisfied -- locktime is " +
+			"greater than the transaction locktime: %d > %d"
+		return fmt.Errorf(str, lockTime, txLockTime)
+	}
+
+	return nil
+}
+
 // opcodeCheckLockTimeVerify compares the top item on the data stack to the
 // LockTime field of the transaction containing the script signature
 // validating if the transaction outputs are spendable yet.  If flag
@@ -1026,9 +1051,9 @@ func opcodeCheckLockTimeVerify(op *parsedOpcode, vm *Engine) error {
 	}
 
 	// The current transaction locktime is a uint32 resulting in a maximum
-	// locktime of 2^32-1 (the year 2106).  However, scriptNums are signed
-	// and therefore a standard 4-byte scriptNum would only support up to a
-	// maximum of 2^31-1 (the year 2038).  Thus, a 5-byte scriptNum is used
+	// locktime of 2^32-1 (the year 2106).  However, script numbers are
+	// signed and therefore a standard 4-byte ScriptNum would only support up
+	// to a maximum of 2^31-1 (the year 2038).  Thus, a 5-byte ScriptNum is used
 	// here since it will support up to 2^39-1 which allows dates beyond the
 	// current locktime limit.
 	//
@@ -1038,7 +1063,8 @@ func opcodeCheckLockTimeVerify(op *parsedOpcode, vm *Engine) error {
 	if err != nil {
 		return err
 	}
-	lockTime, err := makeScriptNum(so, vm.dstack.verifyMinimalData, 5)
+	lockTime, err := MakeScriptNum(so, vm.dstack.verifyMinimalData,
+		LockTimeScriptNumLen)
 	if err != nil {
 		return err
 	}
@@ -1053,19 +1079,10 @@ func opcodeCheckLockTimeVerify(op *parsedOpcode, vm *Engine) error {
 	// which the transaction is finalized or a timestamp depending on if the
 	// value is before the txscript.LockTimeThreshold.  When it is under the
 	// threshold it is a block height.
-	//
-	// The lockTimes in both the script and transaction must be of the same
-	// type.
-	if !((vm.tx.LockTime < LockTimeThreshold && int64(lockTime) < int64(LockTimeThreshold)) ||
-		(vm.tx.LockTime >= LockTimeThreshold && int64(lockTime) >= int64(LockTimeThreshold))) {
-		return fmt.Errorf("mismatched locktime types -- tx locktime %d, stack "+
-			"locktime %d", vm.tx.LockTime, lockTime)
-	}
-
-	if int64(lockTime) > int64(vm.tx.LockTime) {
-		str := "locktime requirement not satisfied -- locktime is " +
-			"greater than the transaction locktime: %d > %d"
-		return fmt.Errorf(str, lockTime, vm.tx.LockTime)
+	err = verifyLockTime(int64(vm.tx.LockTime), int64(LockTimeThreshold),
+		int64(lockTime))
+	if err != nil {
+		return err
 	}
 
 	// The lock time feature can also be disabled, thereby bypassing
@@ -1089,6 +1106,78 @@ func opcodeCheckLockTimeVerify(op *parsedOpcode, vm *Engine) error {
 	return nil
 }
 
+// opcodeCheckSequenceVerify compares the top item on the data stack to the
+// sequence number of the transaction input being validated to verify the
+// relative lock-time of the output it spends has elapsed.  If flag
+// ScriptVerifyCheckSequenceVerify is not set, the code continues as if OP_NOP3
+// were executed.  This is BIP0112.
+func opcodeCheckSequenceVerify(op *parsedOpcode, vm *Engine) error {
+	// If the ScriptVerifyCheckSequenceVerify script flag is not set, treat
+	// opcode as OP_NOP3 instead.
+	if !vm.hasFlag(ScriptVerifyCheckSequenceVerify) {
+		if vm.hasFlag(ScriptDiscourageUpgradableNops) {
+			return errors.New("OP_NOP3 reserved for soft-fork " +
+				"upgrades")
+		}
+		return nil
+	}
+
+	// The sequence number of a transaction input is a uint32 while script
+	// numbers are signed, so a 5-byte ScriptNum is used for the same
+	// reasons as the lock time operand of OP_CHECKLOCKTIMEVERIFY.
+	so, err := vm.dstack.PeekByteArray(0)
+	if err != nil {
+		return err
+	}
+	stackSequence, err := MakeScriptNum(so, vm.dstack.verifyMinimalData,
+		LockTimeScriptNumLen)
+	if err != nil {
+		return err
+	}
+
+	// In the rare event that the argument may be < 0 due to some arithmetic
+	// being done first, you can always use
+	// 0 OP_MAX OP_CHECKSEQUENCEVERIFY.
+	if stackSequence < 0 {
+		return fmt.Errorf("negative sequence: %d", stackSequence)
+	}
+	sequence := int64(stackSequence)
+
+	// To provide for future soft-fork extensibility, the opcode behaves as
+	// a NOP when the disable flag is set on the operand.
+	if sequence&int64(wire.SequenceLockTimeDisabled) != 0 {
+		return nil
+	}
+
+	// The relative lock-time of the input is only enforced for
+	// transactions with a version which supports sequence locks, so the
+	// opcode must fail for earlier versions.  Note that the version is
+	// compared as unsigned, so negative versions support them.
+	if !wire.TxVersionSupports(wire.TxFeatureCheckSequenceVerify,
+		vm.tx.Version) {
+
+		return fmt.Errorf("invalid transaction version: %d",
+			vm.tx.Version)
+	}
+
+	// Sequence numbers with the disable flag set are not subject to the
+	// relative lock-time consensus rules, so an input with the flag set
+	// could otherwise be used to bypass the check.
+	txSequence := int64(vm.tx.TxIn[vm.txIdx].Sequence)
+	if txSequence&int64(wire.SequenceLockTimeDisabled) != 0 {
+		return fmt.Errorf("transaction sequence has sequence locktime "+
+			"disabled bit set: 0x%x", txSequence)
+	}
+
+	// Mask off the bits which are not part of the relative lock-time
+	// before comparing the sequence numbers.  The type flag is kept so the
+	// comparison fails for mismatched lock-time types.
+	lockTimeMask := int64(wire.SequenceLockTimeIsSeconds |
+		wire.SequenceLockTimeMask)
+	return verifyLockTime(txSequence&lockTimeMask,
+		int64(wire.SequenceLockTimeIsSeconds), sequence&lockTimeMask)
+}
+
 // opcodeToAltStack removes the top item from the main data stack and pushes it
 // onto the alternate data stack.
 //
@@ -1187,7 +1276,7 @@ func opcodeIfDup(op *parsedOpcode, vm *Engine) error {
 // Example with 2 items: [x1 x2] -> [x1 x2 2]
 // Example with 3 items: [x1 x2 x3] -> [x1 x2 x3 3]
 func opcodeDepth(op *parsedOpcode, vm *Engine) error {
-	vm.dstack.PushInt(scriptNum(vm.dstack.Depth()))
+	vm.dstack.PushInt(ScriptNum(vm.dstack.Depth()))
 	return nil
 }
 
@@ -1281,7 +1370,7 @@ func opcodeSize(op *parsedOpcode, vm *Engine) error {
 		return err
 	}
 
-	vm.dstack.PushInt(scriptNum(len(so)))
+	vm.dstack.PushInt(ScriptNum(len(so)))
 	return nil
 }
 
@@ -1396,9 +1485,9 @@ func opcodeNot(op *parsedOpcode, vm *Engine) error {
 	}
 
 	if m == 0 {
-		vm.dstack.PushInt(scriptNum(1))
+		vm.dstack.PushInt(ScriptNum(1))
 	} else {
-		vm.dstack.PushInt(scriptNum(0))
+		vm.dstack.PushInt(ScriptNum(0))
 	}
 	return nil
 }
@@ -1480,9 +1569,9 @@ func opcodeBoolAnd(op *parsedOpcode, vm *Engine) error {
 	}
 
 	if v0 != 0 && v1 != 0 {
-		vm.dstack.PushInt(scriptNum(1))
+		vm.dstack.PushInt(ScriptNum(1))
 	} else {
-		vm.dstack.PushInt(scriptNum(0))
+		vm.dstack.PushInt(ScriptNum(0))
 	}
 
 	return nil
@@ -1507,9 +1596,9 @@ func opcodeBoolOr(op *parsedOpcode, vm *Engine) error {
 	}
 
 	if v0 != 0 || v1 != 0 {
-		vm.dstack.PushInt(scriptNum(1))
+		vm.dstack.PushInt(ScriptNum(1))
 	} else {
-		vm.dstack.PushInt(scriptNum(0))
+		vm.dstack.PushInt(ScriptNum(0))
 	}
 
 	return nil
@@ -1532,9 +1621,9 @@ func opcodeNumEqual(op *parsedOpcode, vm *Engine) error {
 	}
 
 	if v0 == v1 {
-		vm.dstack.PushInt(scriptNum(1))
+		vm.dstack.PushInt(ScriptNum(1))
 	} else {
-		vm.dstack.PushInt(scriptNum(0))
+		vm.dstack.PushInt(ScriptNum(0))
 	}
 
 	return nil
@@ -1573,9 +1662,9 @@ func opcodeNumNotEqual(op *parsedOpcode, vm *Engine) error {
 	}
 
 	if v0 != v1 {
-		vm.dstack.PushInt(scriptNum(1))
+		vm.dstack.PushInt(ScriptNum(1))
 	} else {
-		vm.dstack.PushInt(scriptNum(0))
+		vm.dstack.PushInt(ScriptNum(0))
 	}
 
 	return nil
@@ -1598,9 +1687,9 @@ func opcodeLessThan(op *parsedOpcode, vm *Engine) error {
 	}
 
 	if v1 < v0 {
-		vm.dstack.PushInt(scriptNum(1))
+		vm.dstack.PushInt(ScriptNum(1))
 	} else {
-		vm.dstack.PushInt(scriptNum(0))
+		vm.dstack.PushInt(ScriptNum(0))
 	}
 
 	return nil
@@ -1623,9 +1712,9 @@ func opcodeGreaterThan(op *parsedOpcode, vm *Engine) error {
 	}
 
 	if v1 > v0 {
-		vm.dstack.PushInt(scriptNum(1))
+		vm.dstack.PushInt(ScriptNum(1))
 	} else {
-		vm.dstack.PushInt(scriptNum(0))
+		vm.dstack.PushInt(ScriptNum(0))
 	}
 	return nil
 }
@@ -1647,9 +1736,9 @@ func opcodeLessThanOrEqual(op *parsedOpcode, vm *Engine) error {
 	}
 
 	if v1 <= v0 {
-		vm.dstack.PushInt(scriptNum(1))
+		vm.dstack.PushInt(ScriptNum(1))
 	} else {
-		vm.dstack.PushInt(scriptNum(0))
+		vm.dstack.PushInt(ScriptNum(0))
 	}
 	return nil
 }
@@ -1671,9 +1760,9 @@ func opcodeGreaterThanOrEqual(op *parsedOpcode, vm *Engine) error {
 	}
 
 	if v1 >= v0 {
-		vm.dstack.PushInt(scriptNum(1))
+		vm.dstack.PushInt(ScriptNum(1))
 	} else {
-		vm.dstack.PushInt(scriptNum(0))
+		vm.dstack.PushInt(ScriptNum(0))
 	}
 
 	return nil
@@ -1752,9 +1841,9 @@ func opcodeWithin(op *parsedOpcode, vm *Engine) error {
 	}
 
 	if x >= minVal && x < maxVal {
-		vm.dstack.PushInt(scriptNum(1))
+		vm.dstack.PushInt(ScriptNum(1))
 	} else {
-		vm.dstack.PushInt(scriptNum(0))
+		vm.dstack.PushInt(ScriptNum(0))
 	}
 	return nil
 }
@@ -1862,6 +1951,10 @@ func opcodeCodeSeparator(op *parsedOpcode, vm *Engine) error {
 //
 // Stack transformation: [... signature pubkey] -> [... bool]
 func opcodeCheckSig(op *parsedOpcode, vm *Engine) error {
+	if err := vm.countSigOps(1); err != nil {
+		return err
+	}
+
 	pkBytes, err := vm.dstack.PopByteArray()
 	if err != nil {
 		return err
@@ -1912,25 +2005,22 @@ func opcodeCheckSig(op *parsedOpcode, vm *Engine) error {
 	subScript = removeOpcodeByData(subScript, fullSigBytes)
 
 	// Generate the signature hash based on the signature hash type.
-	hash := calcSignatureHash(subScript, hashType, &vm.tx, vm.txIdx)
+	hash := calcSignatureHashCached(subScript, hashType, &vm.tx,
+		vm.txIdx, vm.sigHashes)
 
 	pubKey, err := btcec.ParsePubKey(pkBytes, btcec.S256())
 	if err != nil {
-		vm.dstack.PushBool(false)
-		return nil
+		return vm.pushSigCheckResult(false, fullSigBytes)
 	}
 
 	var signature *btcec.Signature
-	if vm.hasFlag(ScriptVerifyStrictEncoding) ||
-		vm.hasFlag(ScriptVerifyDERSignatures) {
-
+	if vm.hasFlag(ScriptVerifyDERSignatures) {
 		signature, err = btcec.ParseDERSignature(sigBytes, btcec.S256())
 	} else {
 		signature, err = btcec.ParseSignature(sigBytes, btcec.S256())
 	}
 	if err != nil {
-		vm.dstack.PushBool(false)
-		return nil
+		return vm.pushSigCheckResult(false, fullSigBytes)
 	}
 
 	var valid bool
@@ -1947,6 +2037,16 @@ func opcodeCheckSig(op *parsedOpcode, vm *Engine) error {
 		valid = signature.Verify(hash, pubKey)
 	}
 
+	return vm.pushSigCheckResult(valid, fullSigBytes)
+}
+
+// pushSigCheckResult pushes the passed result of checking the passed signature
+// to the data stack.  A failed check with a signature which is not empty
+// results in an error instead when the ScriptVerifyNullFail flag is set.
+func (vm *Engine) pushSigCheckResult(valid bool, sig []byte) error {
+	if !valid && len(sig) != 0 && vm.hasFlag(ScriptVerifyNullFail) {
+		return ErrStackNullFail
+	}
 	vm.dstack.PushBool(valid)
 	return nil
 }
@@ -2006,6 +2106,9 @@ func opcodeCheckMultiSig(op *parsedOpcode, vm *Engine) error {
 	if vm.numOps > MaxOpsPerScript {
 		return ErrStackTooManyOperations
 	}
+	if err := vm.countSigOps(numPubKeys); err != nil {
+		return err
+	}
 
 	pubKeys := make([][]byte, 0, numPubKeys)
 	for i := 0; i < numPubKeys; i++ {
@@ -2110,9 +2213,7 @@ func opcodeCheckMultiSig(op *parsedOpcode, vm *Engine) error {
 
 			// Parse the signature.
 			var err error
-			if vm.hasFlag(ScriptVerifyStrictEncoding) ||
-				vm.hasFlag(ScriptVerifyDERSignatures) {
-
+			if vm.hasFlag(ScriptVerifyDERSignatures) {
 				parsedSig, err = btcec.ParseDERSignature(signature,
 					btcec.S256())
 			} else {
@@ -2145,7 +2246,8 @@ func opcodeCheckMultiSig(op *parsedOpcode, vm *Engine) error {
 		}
 
 		// Generate the signature hash based on the signature hash type.
-		hash := calcSignatureHash(script, hashType, &vm.tx, vm.txIdx)
+		hash := calcSignatureHashCached(script, hashType, &vm.tx,
+			vm.txIdx, vm.sigHashes)
 
 		var valid bool
 		if vm.sigCache != nil {
@@ -2168,6 +2270,16 @@ func opcodeCheckMultiSig(op *parsedOpcode, vm *Engine) error {
 		}
 	}
 
+	// A failed multisig must only have empty signatures when the null fail
+	// flag is set.
+	if !success && vm.hasFlag(ScriptVerifyNullFail) {
+		for _, sigInfo := range signatures {
+			if len(sigInfo.signature) != 0 {
+				return ErrStackNullFail
+			}
+		}
+	}
+
 	vm.dstack.PushBool(success)
 	return nil
 }
@@ -2192,13 +2304,14 @@ var OpcodeByName = make(map[string]byte)
 
 func init() {
 	// Initialize the opcode name to value map using the contents of the
-	// opcode array.  Also add entries for "OP_FALSE", "OP_TRUE", and
-	// "OP_NOP2" since they are aliases for "OP_0", "OP_1",
-	// and "OP_CHECKLOCKTIMEVERIFY" respectively.
+	// opcode array.  Also add entries for "OP_FALSE", "OP_TRUE",
+	// "OP_NOP2", and "OP_NOP3" since they are aliases for "OP_0", "OP_1",
+	// "OP_CHECKLOCKTIMEVERIFY", and "OP_CHECKSEQUENCEVERIFY" respectively.
 	for _, op := range opcodeArray {
 		OpcodeByName[op.name] = op.value
 	}
 	OpcodeByName["OP_FALSE"] = OP_FALSE
 	OpcodeByName["OP_TRUE"] = OP_TRUE
 	OpcodeByName["OP_NOP2"] = OP_CHECKLOCKTIMEVERIFY
+	OpcodeByName["OP_NOP3"] = OP_CHECKSEQUENCEVERIFY
 }
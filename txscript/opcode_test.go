@@ -110,9 +110,12 @@ func TestOpcodeDisasm(t *testing.T) {
 
 		// OP_NOP1 through OP_NOP10.
 		case opcodeVal >= 0xb0 && opcodeVal <= 0xb9:
-			// OP_NOP2 is an alias of OP_CHECKLOCKTIMEVERIFY
+			// OP_NOP2 is an alias of OP_CHECKLOCKTIMEVERIFY and
+			// OP_NOP3 is an alias of OP_CHECKSEQUENCEVERIFY.
 			if opcodeVal == 0xb1 {
 				expectedStr = "OP_CHECKLOCKTIMEVERIFY"
+			} else if opcodeVal == 0xb2 {
+				expectedStr = "OP_CHECKSEQUENCEVERIFY"
 			} else {
 				val := byte(opcodeVal - (0xb0 - 1))
 				expectedStr = "OP_NOP" + strconv.Itoa(int(val))
@@ -172,9 +175,12 @@ func TestOpcodeDisasm(t *testing.T) {
 
 		// OP_NOP1 through OP_NOP10.
 		case opcodeVal >= 0xb0 && opcodeVal <= 0xb9:
-			// OP_NOP2 is an alias of OP_CHECKLOCKTIMEVERIFY
+			// OP_NOP2 is an alias of OP_CHECKLOCKTIMEVERIFY and
+			// OP_NOP3 is an alias of OP_CHECKSEQUENCEVERIFY.
 			if opcodeVal == 0xb1 {
 				expectedStr = "OP_CHECKLOCKTIMEVERIFY"
+			} else if opcodeVal == 0xb2 {
+				expectedStr = "OP_CHECKSEQUENCEVERIFY"
 			} else {
 				val := byte(opcodeVal - (0xb0 - 1))
 				expectedStr = "OP_NOP" + strconv.Itoa(int(val))
@@ -3,8 +3,6 @@ package txscript
 import (
 	"bytes"
 	"testing"
-
-	"github.com/tinhnguyenhn/colxd/wire"
 )
 
 // TestParsePkScript ensures that the supported script types can be parsed
@@ -98,8 +96,10 @@ func TestParsePkScript(t *testing.T) {
 			},
 			valid: false,
 		},
+		// Segregated witness is not supported, so P2WSH scripts
+		// are rejected.
 		{
-			name: "valid v0 P2WSH",
+			name: "unsupported v0 P2WSH",
 			pkScript: []byte{
 				// OP_0
 				0x00,
@@ -111,7 +111,7 @@ func TestParsePkScript(t *testing.T) {
 				0x06, 0xf6, 0x96, 0xcd, 0x06, 0xf6, 0x96, 0xcd,
 				0x06, 0xf6, 0x96, 0xcd, 0x06, 0xf6, 0x96, 0xcd,
 			},
-			valid: true,
+			valid: false,
 		},
 		// Invalid v0 P2WSH - same as above but missing one byte.
 		{
@@ -129,8 +129,10 @@ func TestParsePkScript(t *testing.T) {
 			},
 			valid: false,
 		},
+		// Segregated witness is not supported, so P2WPKH scripts
+		// are rejected.
 		{
-			name: "valid v0 P2WPKH",
+			name: "unsupported v0 P2WPKH",
 			pkScript: []byte{
 				// OP_0
 				0x00,
@@ -141,7 +143,7 @@ func TestParsePkScript(t *testing.T) {
 				0xa5, 0x15, 0x04, 0x52, 0x3a, 0x60, 0xd4, 0x03,
 				0x06, 0xf6, 0x96, 0xcd,
 			},
-			valid: true,
+			valid: false,
 		},
 		// Invalid v0 P2WPKH - same as above but missing one byte.
 		{
@@ -228,7 +230,7 @@ func TestComputePkScript(t *testing.T) {
 				0x1f, 0x1f, 0x7b, 0x73, 0x7d, 0x9a, 0x24, 0x49,
 				0x90,
 			},
-			class:   PubKeyHashTy,
+			class: PubKeyHashTy,
 			pkScript: []byte{
 				// OP_DUP
 				0x76,
@@ -260,7 +262,7 @@ func TestComputePkScript(t *testing.T) {
 			},
 			// NP2PKH outputs include a witness, but it is not
 			// needed to reconstruct the pkScript.
-			class:   ScriptHashTy,
+			class: ScriptHashTy,
 			pkScript: []byte{
 				// OP_HASH160
 				0xa9,
@@ -306,7 +308,7 @@ func TestComputePkScript(t *testing.T) {
 				0x3e, 0xfd, 0x9d, 0x41, 0x03, 0xb5, 0x59, 0xeb,
 				0x67, 0xcd, 0x52, 0xae,
 			},
-			class:   ScriptHashTy,
+			class: ScriptHashTy,
 			pkScript: []byte{
 				// OP_HASH160
 				0xA9,
@@ -333,7 +335,7 @@ func TestComputePkScript(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			valid := test.pkScript != nil
 			pkScript, err := ComputePkScript(
-				test.sigScript
+				test.sigScript,
 			)
 			if err != nil && valid {
 				t.Fatalf("unable to compute pkScript: %v", err)
@@ -128,6 +128,8 @@ func parseScriptFlags(flagStr string) (ScriptFlags, error) {
 			// Nothing.
 		case "CHECKLOCKTIMEVERIFY":
 			flags |= ScriptVerifyCheckLockTimeVerify
+		case "CHECKSEQUENCEVERIFY":
+			flags |= ScriptVerifyCheckSequenceVerify
 		case "CLEANSTACK":
 			flags |= ScriptVerifyCleanStack
 		case "DERSIG":
@@ -142,6 +144,8 @@ func parseScriptFlags(flagStr string) (ScriptFlags, error) {
 			// Nothing.
 		case "NULLDUMMY":
 			flags |= ScriptStrictMultiSig
+		case "NULLFAIL":
+			flags |= ScriptVerifyNullFail
 		case "P2SH":
 			flags |= ScriptBip16
 		case "SIGPUSHONLY":
@@ -662,5 +666,19 @@ func TestCalcSignatureHash(t *testing.T) {
 			t.Errorf("TestCalcSignatureHash failed test #%d: "+
 				"Signature hash mismatch.", i)
 		}
+
+		// The signature hash computed with the cached serialization
+		// of the transaction must be identical.
+		cachedHash, err := CalcSignatureHashCached(subScript, hashType,
+			tx, int(test[2].(float64)), NewTxSigHashes(tx))
+		if err != nil {
+			t.Errorf("TestCalcSignatureHash failed test #%d: "+
+				"CalcSignatureHashCached: %v", i, err)
+			continue
+		}
+		if !bytes.Equal(cachedHash, expectedHash.Bytes()) {
+			t.Errorf("TestCalcSignatureHash failed test #%d: "+
+				"Cached signature hash mismatch.", i)
+		}
 	}
 }
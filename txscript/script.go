@@ -193,16 +193,98 @@ func unparseScript(pops []parsedOpcode) ([]byte, error) {
 	return script, nil
 }
 
+// DisasmStep describes a single opcode of a disassembled script as returned by
+// DisasmDetailed.
+type DisasmStep struct {
+	// Name is the name of the opcode, such as OP_DUP or OP_DATA_20.
+	Name string
+
+	// Bytes is the raw encoding of the opcode including any pushed data.
+	// For a step which failed to parse, it is the remainder of the script
+	// starting at the opcode.
+	Bytes []byte
+
+	// Offset is the byte offset of the opcode within the script.
+	Offset int
+
+	// Failed is set when the script failed to parse at this opcode, for
+	// example due to a data push which runs past the end of the script.
+	// It is only ever set on the final step.
+	Failed bool
+
+	// pop is the parsed opcode used to format successfully parsed steps.
+	pop parsedOpcode
+}
+
+// String returns the step formatted as its byte offset followed by the full
+// disassembly of the opcode.  Steps which failed to parse are marked with the
+// string '[error]'.
+func (s *DisasmStep) String() string {
+	if s.Failed {
+		return fmt.Sprintf("%04x: %s [error]", s.Offset, s.Name)
+	}
+	return fmt.Sprintf("%04x: %s", s.Offset, s.pop.print(false))
+}
+
+// parsedOpcodeLen returns the number of bytes the passed parsed opcode occupies
+// in the script it was parsed from.
+func parsedOpcodeLen(pop *parsedOpcode) int {
+	switch {
+	case pop.opcode.length > 0:
+		return pop.opcode.length
+	case pop.opcode.length < 0:
+		return 1 - pop.opcode.length + len(pop.data)
+	}
+	return 1
+}
+
+// DisasmDetailed disassembles the passed script into a step per opcode which
+// records the opcode name, its raw bytes, and its byte offset within the
+// script.  When the script fails to parse, the returned steps contain the
+// opcodes up to the point the failure occurred followed by a step for the
+// offending opcode with Failed set, and the reason the script failed to parse
+// is returned.
+func DisasmDetailed(script []byte) ([]DisasmStep, error) {
+	opcodes, err := parseScript(script)
+	steps := make([]DisasmStep, 0, len(opcodes)+1)
+	var offset int
+	for _, pop := range opcodes {
+		popLen := parsedOpcodeLen(&pop)
+		steps = append(steps, DisasmStep{
+			Name:   pop.opcode.name,
+			Bytes:  script[offset : offset+popLen],
+			Offset: offset,
+			pop:    pop,
+		})
+		offset += popLen
+	}
+	if err != nil && offset < len(script) {
+		op := &opcodeArray[script[offset]]
+		steps = append(steps, DisasmStep{
+			Name:   op.name,
+			Bytes:  script[offset:],
+			Offset: offset,
+			Failed: true,
+			pop:    parsedOpcode{opcode: op},
+		})
+	}
+	return steps, err
+}
+
 // DisasmString formats a disassembled script for one line printing.  When the
 // script fails to parse, the returned string will contain the disassembled
 // script up to the point the failure occurred along with the string '[error]'
 // appended.  In addition, the reason the script failed to parse is returned
-// if the caller wants more information about the failure.
+// if the caller wants more information about the failure.  DisasmDetailed
+// provides the location of the failure.
 func DisasmString(buf []byte) (string, error) {
 	var disbuf bytes.Buffer
-	opcodes, err := parseScript(buf)
-	for _, pop := range opcodes {
-		disbuf.WriteString(pop.print(true))
+	steps, err := DisasmDetailed(buf)
+	for i := range steps {
+		if steps[i].Failed {
+			break
+		}
+		disbuf.WriteString(steps[i].pop.print(true))
 		disbuf.WriteByte(' ')
 	}
 	if disbuf.Len() > 0 {
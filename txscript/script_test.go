@@ -522,3 +522,128 @@ func TestIsUnspendable(t *testing.T) {
 		}
 	}
 }
+
+// TestDisasmDetailed ensures DisasmDetailed reports the expected opcodes along
+// with their raw bytes and byte offsets, including the offset at which scripts
+// with truncated data pushes fail to parse, and that DisasmString formats the
+// same scripts as expected.
+func TestDisasmDetailed(t *testing.T) {
+	t.Parallel()
+
+	type step struct {
+		name   string
+		bytes  []byte
+		offset int
+		failed bool
+	}
+	tests := []struct {
+		name    string
+		script  []byte
+		steps   []step
+		disasm  string
+		wantErr bool
+	}{
+		{
+			name:   "empty script",
+			script: nil,
+			steps:  nil,
+			disasm: "",
+		},
+		{
+			name:   "push and opcodes",
+			script: []byte{0x00, 0x02, 0xaa, 0xbb, 0x76, 0x51},
+			steps: []step{
+				{"OP_0", []byte{0x00}, 0, false},
+				{"OP_DATA_2", []byte{0x02, 0xaa, 0xbb}, 1, false},
+				{"OP_DUP", []byte{0x76}, 4, false},
+				{"OP_1", []byte{0x51}, 5, false},
+			},
+			disasm: "0 aabb OP_DUP 1",
+		},
+		{
+			name:   "pushdata2",
+			script: []byte{0x76, 0x4d, 0x01, 0x00, 0xcc, 0x87},
+			steps: []step{
+				{"OP_DUP", []byte{0x76}, 0, false},
+				{"OP_PUSHDATA2", []byte{0x4d, 0x01, 0x00, 0xcc}, 1, false},
+				{"OP_EQUAL", []byte{0x87}, 5, false},
+			},
+			disasm: "OP_DUP cc OP_EQUAL",
+		},
+		{
+			name:   "truncated data push",
+			script: []byte{0x76, 0x05, 0x01, 0x02},
+			steps: []step{
+				{"OP_DUP", []byte{0x76}, 0, false},
+				{"OP_DATA_5", []byte{0x05, 0x01, 0x02}, 1, true},
+			},
+			disasm:  "OP_DUP[error]",
+			wantErr: true,
+		},
+		{
+			name:   "pushdata1 missing length",
+			script: []byte{0x51, 0x52, 0x4c},
+			steps: []step{
+				{"OP_1", []byte{0x51}, 0, false},
+				{"OP_2", []byte{0x52}, 1, false},
+				{"OP_PUSHDATA1", []byte{0x4c}, 2, true},
+			},
+			disasm:  "1 2[error]",
+			wantErr: true,
+		},
+		{
+			name:   "pushdata2 truncated length",
+			script: []byte{0x4d, 0x01},
+			steps: []step{
+				{"OP_PUSHDATA2", []byte{0x4d, 0x01}, 0, true},
+			},
+			disasm:  "[error]",
+			wantErr: true,
+		},
+		{
+			name:   "pushdata4 truncated data",
+			script: []byte{0x01, 0xff, 0x4e, 0x03, 0x00, 0x00, 0x00, 0x01},
+			steps: []step{
+				{"OP_DATA_1", []byte{0x01, 0xff}, 0, false},
+				{"OP_PUSHDATA4", []byte{0x4e, 0x03, 0x00, 0x00, 0x00,
+					0x01}, 2, true},
+			},
+			disasm:  "ff[error]",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		steps, err := txscript.DisasmDetailed(test.script)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: unexpected error - got %v, want error %v",
+				test.name, err, test.wantErr)
+			continue
+		}
+		if len(steps) != len(test.steps) {
+			t.Errorf("%s: unexpected number of steps - got %d, "+
+				"want %d", test.name, len(steps), len(test.steps))
+			continue
+		}
+		for i, want := range test.steps {
+			got := steps[i]
+			if got.Name != want.name || got.Offset != want.offset ||
+				got.Failed != want.failed ||
+				!bytes.Equal(got.Bytes, want.bytes) {
+
+				t.Errorf("%s: unexpected step #%d - got {%s %x %d "+
+					"%v}, want {%s %x %d %v}", test.name, i,
+					got.Name, got.Bytes, got.Offset, got.Failed,
+					want.name, want.bytes, want.offset,
+					want.failed)
+			}
+		}
+
+		disasm, err := txscript.DisasmString(test.script)
+		if (err != nil) != test.wantErr || disasm != test.disasm {
+			t.Errorf("%s: unexpected DisasmString result - got %q "+
+				"(err %v), want %q", test.name, disasm, err,
+				test.disasm)
+		}
+	}
+}
@@ -7,9 +7,11 @@ package txscript_test
 
 import (
 	"bytes"
+	"fmt"
 	"testing"
 
 	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
 )
 
 // TestScriptBuilderAddOp tests that pushing opcodes to a script via the
@@ -266,6 +268,66 @@ func TestScriptBuilderAddData(t *testing.T) {
 	}
 }
 
+// TestScriptBuilderMinimalPushes ensures all data pushes created by the
+// ScriptBuilder use the minimal encoding so scripts it constructs are valid
+// when the minimal data flag is enforced.
+func TestScriptBuilderMinimalPushes(t *testing.T) {
+	t.Parallel()
+
+	tx := &wire.MsgTx{
+		Version: 1,
+		TxIn: []*wire.TxIn{{
+			PreviousOutPoint: wire.OutPoint{Index: 0},
+			Sequence:         wire.MaxTxInSequenceNum,
+		}},
+		TxOut: []*wire.TxOut{{Value: 0}},
+	}
+	pkScript := []byte{txscript.OP_DROP, txscript.OP_TRUE}
+	checkScript := func(desc string, script []byte) {
+		tx.TxIn[0].SignatureScript = script
+		vm, err := txscript.NewEngine(pkScript, tx, 0,
+			txscript.ScriptVerifyMinimalData, nil)
+		if err != nil {
+			t.Fatalf("%s: failed to create script: %v", desc, err)
+		}
+		if err := vm.Execute(); err != nil {
+			t.Fatalf("%s: unexpected error: %v", desc, err)
+		}
+	}
+
+	// Ensure single byte pushes of every value are minimal.
+	for i := 0; i <= 0xff; i++ {
+		script, err := txscript.NewScriptBuilder().
+			AddData([]byte{byte(i)}).Script()
+		if err != nil {
+			t.Fatalf("AddData: unexpected error for byte %d: %v", i,
+				err)
+		}
+		checkScript(fmt.Sprintf("single byte %#x", i), script)
+	}
+
+	// Ensure pushes of every length are minimal.
+	for dataLen := 0; dataLen <= txscript.MaxScriptElementSize; dataLen++ {
+		data := bytes.Repeat([]byte{0x49}, dataLen)
+		script, err := txscript.NewScriptBuilder().AddData(data).Script()
+		if err != nil {
+			t.Fatalf("AddData: unexpected error for length %d: %v",
+				dataLen, err)
+		}
+		checkScript(fmt.Sprintf("data length %d", dataLen), script)
+	}
+
+	// Ensure integer pushes around the small integer boundaries are
+	// minimal.
+	for i := int64(-0x81); i <= 0x81; i++ {
+		script, err := txscript.NewScriptBuilder().AddInt64(i).Script()
+		if err != nil {
+			t.Fatalf("AddInt64: unexpected error for %d: %v", i, err)
+		}
+		checkScript(fmt.Sprintf("integer %d", i), script)
+	}
+}
+
 // TestExceedMaxScriptSize ensures that all of the functions that can be used
 // to add data to a script don't allow the script to exceed the max allowed
 // size.
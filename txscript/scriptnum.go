@@ -9,13 +9,28 @@ const (
 	maxInt32 = 1<<31 - 1
 	minInt32 = -1 << 31
 
-	// defaultScriptNumLen is the default number of bytes
-	// data being interpreted as an integer may be.
-	defaultScriptNumLen = 4
+	// DefaultScriptNumLen is the default number of bytes data being
+	// interpreted as an integer may be.  It applies to the operands of all
+	// numeric opcodes and limits them to the range [-2^31 + 1, 2^31 - 1].
+	DefaultScriptNumLen = 4
+
+	// LockTimeScriptNumLen is the number of bytes the lock time operand of
+	// OP_CHECKLOCKTIMEVERIFY may be.  It is larger than the default since
+	// lock times are unsigned 32-bit values, which do not fit in a signed
+	// 4-byte script number.  It limits the operand to the range
+	// [-2^39 + 1, 2^39 - 1].
+	LockTimeScriptNumLen = 5
+
+	// maxScriptNumLen is the maximum number of bytes a script number may be
+	// decoded from regardless of the length requested by the caller, since
+	// larger values can't be represented.
+	maxScriptNumLen = 8
 )
 
-// scriptNum represents a numeric value used in the scripting engine with
+// ScriptNum represents a numeric value used in the scripting engine with
 // special handling to deal with the subtle semantics required by consensus.
+// It is exported so that tooling which builds or inspects scripts can use the
+// exact rules the engine applies to numbers.
 //
 // All numbers are stored on the data and alternate stacks encoded as little
 // endian with a sign bit.  All numeric opcodes such as OP_ADD, OP_SUB,
@@ -36,11 +51,11 @@ const (
 // method to get the serialized representation (including values that overflow).
 //
 // Then, whenever data is interpreted as an integer, it is converted to this
-// type by using the makeScriptNum function which will return an error if the
+// type by using the MakeScriptNum function which will return an error if the
 // number is out of range or not minimally encoded depending on parameters.
 // Since all numeric opcodes involve pulling data from the stack and
 // interpreting it as an integer, it provides the required behavior.
-type scriptNum int64
+type ScriptNum int64
 
 // checkMinimalDataEncoding returns whether or not the passed byte array adheres
 // to the minimal encoding requirements.
@@ -84,25 +99,31 @@ func checkMinimalDataEncoding(v []byte) error {
 //    -32767 -> [0xff 0xff]
 //     32768 -> [0x00 0x80 0x00]
 //    -32768 -> [0x00 0x80 0x80]
-func (n scriptNum) Bytes() []byte {
+//
+// Any value, including those which overflow the range allowed for operands of
+// numeric opcodes, is serialized.  Such values are rejected when the result is
+// interpreted as a number again with MakeScriptNum.
+func (n ScriptNum) Bytes() []byte {
 	// Zero encodes as an empty byte slice.
 	if n == 0 {
 		return nil
 	}
 
 	// Take the absolute value and keep track of whether it was originally
-	// negative.
+	// negative.  The absolute value is unsigned since the absolute value
+	// of the minimum int64 does not fit in an int64.
 	isNegative := n < 0
+	abs := uint64(n)
 	if isNegative {
-		n = -n
+		abs = uint64(-n)
 	}
 
 	// Encode to little endian.  The maximum number of encoded bytes is 9
 	// (8 bytes for max int64 plus a potential byte for sign extension).
 	result := make([]byte, 0, 9)
-	for n > 0 {
-		result = append(result, byte(n&0xff))
-		n >>= 8
+	for abs > 0 {
+		result = append(result, byte(abs&0xff))
+		abs >>= 8
 	}
 
 	// When the most significant byte already has the high bit set, an
@@ -134,12 +155,12 @@ func (n scriptNum) Bytes() []byte {
 // provide this behavior.
 //
 // In practice, for most opcodes, the number should never be out of range since
-// it will have been created with makeScriptNum using the defaultScriptLen
+// it will have been created with MakeScriptNum using the DefaultScriptNumLen
 // value, which rejects them.  In case something in the future ends up calling
 // this function against the result of some arithmetic, which IS allowed to be
 // out of range before being reinterpreted as an integer, this will provide the
 // correct behavior.
-func (n scriptNum) Int32() int32 {
+func (n ScriptNum) Int32() int32 {
 	if n > maxInt32 {
 		return maxInt32
 	}
@@ -151,7 +172,7 @@ func (n scriptNum) Int32() int32 {
 	return int32(n)
 }
 
-// makeScriptNum interprets the passed serialized bytes as an encoded integer
+// MakeScriptNum interprets the passed serialized bytes as an encoded integer
 // and returns the result as a script number.
 //
 // Since the consensus rules dictate that serialized bytes interpreted as ints
@@ -167,20 +188,25 @@ func (n scriptNum) Int32() int32 {
 // number of bytes or is the negative 0 encoding, [0x80].  For example, consider
 // the number 127.  It could be encoded as [0x7f], [0x7f 0x00],
 // [0x7f 0x00 0x00 ...], etc.  All forms except [0x7f] will return an error with
-// requireMinimal enabled.
+// requireMinimal enabled.  Otherwise, all of them decode to the same number and
+// the negative 0 encodings, such as [0x80] and [0x00 0x80], decode to 0.
+//
+// The maxLen is the maximum number of bytes the encoded value can be before an
+// ErrStackNumberTooBig is returned.  This effectively limits the range of
+// allowed values to [-2^(8*maxLen-1) + 1, 2^(8*maxLen-1) - 1].  The engine uses
+// DefaultScriptNumLen for all numeric opcodes and LockTimeScriptNumLen for the
+// operand of OP_CHECKLOCKTIMEVERIFY.  Encodings longer than 8 bytes are always
+// rejected since they can't be represented.
 //
-// The scriptNumLen is the maximum number of bytes the encoded value can be
-// before an ErrStackNumberTooBig is returned.  This effectively limits the
-// range of allowed values.
 // WARNING:  Great care should be taken if passing a value larger than
-// defaultScriptNumLen, which could lead to addition and multiplication
+// DefaultScriptNumLen, which could lead to addition and multiplication
 // overflows.
 //
 // See the Bytes function documentation for example encodings.
-func makeScriptNum(v []byte, requireMinimal bool, scriptNumLen int) (scriptNum, error) {
-	// Interpreting data requires that it is not larger than
-	// the the passed scriptNumLen value.
-	if len(v) > scriptNumLen {
+func MakeScriptNum(v []byte, requireMinimal bool, maxLen int) (ScriptNum, error) {
+	// Interpreting data requires that it is not larger than the passed
+	// maxLen value.
+	if len(v) > maxLen || len(v) > maxScriptNumLen {
 		return 0, ErrStackNumberTooBig
 	}
 
@@ -206,12 +232,12 @@ func makeScriptNum(v []byte, requireMinimal bool, scriptNumLen int) (scriptNum,
 	// set, the result is negative.  So, remove the sign bit from the result
 	// and make it negative.
 	if v[len(v)-1]&0x80 != 0 {
-		// The maximum length of v has already been determined to be 4
+		// The maximum length of v has already been determined to be 8
 		// above, so uint8 is enough to cover the max possible shift
-		// value of 24.
+		// value of 56.
 		result &= ^(int64(0x80) << uint8(8*(len(v)-1)))
-		return scriptNum(-result), nil
+		return ScriptNum(-result), nil
 	}
 
-	return scriptNum(result), nil
+	return ScriptNum(result), nil
 }
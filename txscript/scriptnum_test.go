@@ -8,6 +8,7 @@ package txscript
 import (
 	"bytes"
 	"encoding/hex"
+	"math/rand"
 	"testing"
 )
 
@@ -29,7 +30,7 @@ func TestScriptNumBytes(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
-		num        scriptNum
+		num        ScriptNum
 		serialized []byte
 	}{
 		{0, nil},
@@ -74,6 +75,7 @@ func TestScriptNumBytes(t *testing.T) {
 		{-72057594037927935, hexToBytes("ffffffffffffff80")},
 		{9223372036854775807, hexToBytes("ffffffffffffff7f")},
 		{-9223372036854775807, hexToBytes("ffffffffffffffff")},
+		{-9223372036854775808, hexToBytes("000000000000008080")},
 	}
 
 	for _, test := range tests {
@@ -94,112 +96,115 @@ func TestMakeScriptNum(t *testing.T) {
 
 	tests := []struct {
 		serialized      []byte
-		num             scriptNum
+		num             ScriptNum
 		numLen          int
 		minimalEncoding bool
 		err             error
 	}{
 		// Minimal encoding must reject negative 0.
-		{hexToBytes("80"), 0, defaultScriptNumLen, true, ErrStackMinimalData},
+		{hexToBytes("80"), 0, DefaultScriptNumLen, true, ErrStackMinimalData},
 
 		// Minimally encoded valid values with minimal encoding flag.
 		// Should not error and return expected integral number.
-		{nil, 0, defaultScriptNumLen, true, nil},
-		{hexToBytes("01"), 1, defaultScriptNumLen, true, nil},
-		{hexToBytes("81"), -1, defaultScriptNumLen, true, nil},
-		{hexToBytes("7f"), 127, defaultScriptNumLen, true, nil},
-		{hexToBytes("ff"), -127, defaultScriptNumLen, true, nil},
-		{hexToBytes("8000"), 128, defaultScriptNumLen, true, nil},
-		{hexToBytes("8080"), -128, defaultScriptNumLen, true, nil},
-		{hexToBytes("8100"), 129, defaultScriptNumLen, true, nil},
-		{hexToBytes("8180"), -129, defaultScriptNumLen, true, nil},
-		{hexToBytes("0001"), 256, defaultScriptNumLen, true, nil},
-		{hexToBytes("0081"), -256, defaultScriptNumLen, true, nil},
-		{hexToBytes("ff7f"), 32767, defaultScriptNumLen, true, nil},
-		{hexToBytes("ffff"), -32767, defaultScriptNumLen, true, nil},
-		{hexToBytes("008000"), 32768, defaultScriptNumLen, true, nil},
-		{hexToBytes("008080"), -32768, defaultScriptNumLen, true, nil},
-		{hexToBytes("ffff00"), 65535, defaultScriptNumLen, true, nil},
-		{hexToBytes("ffff80"), -65535, defaultScriptNumLen, true, nil},
-		{hexToBytes("000008"), 524288, defaultScriptNumLen, true, nil},
-		{hexToBytes("000088"), -524288, defaultScriptNumLen, true, nil},
-		{hexToBytes("000070"), 7340032, defaultScriptNumLen, true, nil},
-		{hexToBytes("0000f0"), -7340032, defaultScriptNumLen, true, nil},
-		{hexToBytes("00008000"), 8388608, defaultScriptNumLen, true, nil},
-		{hexToBytes("00008080"), -8388608, defaultScriptNumLen, true, nil},
-		{hexToBytes("ffffff7f"), 2147483647, defaultScriptNumLen, true, nil},
-		{hexToBytes("ffffffff"), -2147483647, defaultScriptNumLen, true, nil},
+		{nil, 0, DefaultScriptNumLen, true, nil},
+		{hexToBytes("01"), 1, DefaultScriptNumLen, true, nil},
+		{hexToBytes("81"), -1, DefaultScriptNumLen, true, nil},
+		{hexToBytes("7f"), 127, DefaultScriptNumLen, true, nil},
+		{hexToBytes("ff"), -127, DefaultScriptNumLen, true, nil},
+		{hexToBytes("8000"), 128, DefaultScriptNumLen, true, nil},
+		{hexToBytes("8080"), -128, DefaultScriptNumLen, true, nil},
+		{hexToBytes("8100"), 129, DefaultScriptNumLen, true, nil},
+		{hexToBytes("8180"), -129, DefaultScriptNumLen, true, nil},
+		{hexToBytes("0001"), 256, DefaultScriptNumLen, true, nil},
+		{hexToBytes("0081"), -256, DefaultScriptNumLen, true, nil},
+		{hexToBytes("ff7f"), 32767, DefaultScriptNumLen, true, nil},
+		{hexToBytes("ffff"), -32767, DefaultScriptNumLen, true, nil},
+		{hexToBytes("008000"), 32768, DefaultScriptNumLen, true, nil},
+		{hexToBytes("008080"), -32768, DefaultScriptNumLen, true, nil},
+		{hexToBytes("ffff00"), 65535, DefaultScriptNumLen, true, nil},
+		{hexToBytes("ffff80"), -65535, DefaultScriptNumLen, true, nil},
+		{hexToBytes("000008"), 524288, DefaultScriptNumLen, true, nil},
+		{hexToBytes("000088"), -524288, DefaultScriptNumLen, true, nil},
+		{hexToBytes("000070"), 7340032, DefaultScriptNumLen, true, nil},
+		{hexToBytes("0000f0"), -7340032, DefaultScriptNumLen, true, nil},
+		{hexToBytes("00008000"), 8388608, DefaultScriptNumLen, true, nil},
+		{hexToBytes("00008080"), -8388608, DefaultScriptNumLen, true, nil},
+		{hexToBytes("ffffff7f"), 2147483647, DefaultScriptNumLen, true, nil},
+		{hexToBytes("ffffffff"), -2147483647, DefaultScriptNumLen, true, nil},
 		{hexToBytes("ffffffff7f"), 549755813887, 5, true, nil},
 		{hexToBytes("ffffffffff"), -549755813887, 5, true, nil},
 		{hexToBytes("ffffffffffffff7f"), 9223372036854775807, 8, true, nil},
 		{hexToBytes("ffffffffffffffff"), -9223372036854775807, 8, true, nil},
-		{hexToBytes("ffffffffffffffff7f"), -1, 9, true, nil},
-		{hexToBytes("ffffffffffffffffff"), 1, 9, true, nil},
-		{hexToBytes("ffffffffffffffffff7f"), -1, 10, true, nil},
-		{hexToBytes("ffffffffffffffffffff"), 1, 10, true, nil},
 
 		// Minimally encoded values that are out of range for data that
 		// is interpreted as script numbers with the minimal encoding
 		// flag set.  Should error and return 0.
-		{hexToBytes("0000008000"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
-		{hexToBytes("0000008080"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
-		{hexToBytes("0000009000"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
-		{hexToBytes("0000009080"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
-		{hexToBytes("ffffffff00"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
-		{hexToBytes("ffffffff80"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
-		{hexToBytes("0000000001"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
-		{hexToBytes("0000000081"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
-		{hexToBytes("ffffffffffff00"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
-		{hexToBytes("ffffffffffff80"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
-		{hexToBytes("ffffffffffffff00"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
-		{hexToBytes("ffffffffffffff80"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
-		{hexToBytes("ffffffffffffff7f"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
-		{hexToBytes("ffffffffffffffff"), 0, defaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("0000008000"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("0000008080"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("0000009000"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("0000009080"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("ffffffff00"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("ffffffff80"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("0000000001"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("0000000081"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("ffffffffffff00"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("ffffffffffff80"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("ffffffffffffff00"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("ffffffffffffff80"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("ffffffffffffff7f"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+		{hexToBytes("ffffffffffffffff"), 0, DefaultScriptNumLen, true, ErrStackNumberTooBig},
+
+		// Encodings longer than 8 bytes can't be represented regardless
+		// of the requested length.  Should error and return 0.
+		{hexToBytes("ffffffffffffffff7f"), 0, 9, true, ErrStackNumberTooBig},
+		{hexToBytes("ffffffffffffffffff"), 0, 9, true, ErrStackNumberTooBig},
+		{hexToBytes("ffffffffffffffffff7f"), 0, 10, true, ErrStackNumberTooBig},
+		{hexToBytes("ffffffffffffffffffff"), 0, 10, true, ErrStackNumberTooBig},
 
 		// Non-minimally encoded, but otherwise valid values with
 		// minimal encoding flag.  Should error and return 0.
-		{hexToBytes("00"), 0, defaultScriptNumLen, true, ErrStackMinimalData},       // 0
-		{hexToBytes("0100"), 0, defaultScriptNumLen, true, ErrStackMinimalData},     // 1
-		{hexToBytes("7f00"), 0, defaultScriptNumLen, true, ErrStackMinimalData},     // 127
-		{hexToBytes("800000"), 0, defaultScriptNumLen, true, ErrStackMinimalData},   // 128
-		{hexToBytes("810000"), 0, defaultScriptNumLen, true, ErrStackMinimalData},   // 129
-		{hexToBytes("000100"), 0, defaultScriptNumLen, true, ErrStackMinimalData},   // 256
-		{hexToBytes("ff7f00"), 0, defaultScriptNumLen, true, ErrStackMinimalData},   // 32767
-		{hexToBytes("00800000"), 0, defaultScriptNumLen, true, ErrStackMinimalData}, // 32768
-		{hexToBytes("ffff0000"), 0, defaultScriptNumLen, true, ErrStackMinimalData}, // 65535
-		{hexToBytes("00000800"), 0, defaultScriptNumLen, true, ErrStackMinimalData}, // 524288
-		{hexToBytes("00007000"), 0, defaultScriptNumLen, true, ErrStackMinimalData}, // 7340032
+		{hexToBytes("00"), 0, DefaultScriptNumLen, true, ErrStackMinimalData},       // 0
+		{hexToBytes("0100"), 0, DefaultScriptNumLen, true, ErrStackMinimalData},     // 1
+		{hexToBytes("7f00"), 0, DefaultScriptNumLen, true, ErrStackMinimalData},     // 127
+		{hexToBytes("800000"), 0, DefaultScriptNumLen, true, ErrStackMinimalData},   // 128
+		{hexToBytes("810000"), 0, DefaultScriptNumLen, true, ErrStackMinimalData},   // 129
+		{hexToBytes("000100"), 0, DefaultScriptNumLen, true, ErrStackMinimalData},   // 256
+		{hexToBytes("ff7f00"), 0, DefaultScriptNumLen, true, ErrStackMinimalData},   // 32767
+		{hexToBytes("00800000"), 0, DefaultScriptNumLen, true, ErrStackMinimalData}, // 32768
+		{hexToBytes("ffff0000"), 0, DefaultScriptNumLen, true, ErrStackMinimalData}, // 65535
+		{hexToBytes("00000800"), 0, DefaultScriptNumLen, true, ErrStackMinimalData}, // 524288
+		{hexToBytes("00007000"), 0, DefaultScriptNumLen, true, ErrStackMinimalData}, // 7340032
 		{hexToBytes("0009000100"), 0, 5, true, ErrStackMinimalData},                 // 16779520
 
 		// Non-minimally encoded, but otherwise valid values without
 		// minimal encoding flag.  Should not error and return expected
 		// integral number.
-		{hexToBytes("00"), 0, defaultScriptNumLen, false, nil},
-		{hexToBytes("0100"), 1, defaultScriptNumLen, false, nil},
-		{hexToBytes("7f00"), 127, defaultScriptNumLen, false, nil},
-		{hexToBytes("800000"), 128, defaultScriptNumLen, false, nil},
-		{hexToBytes("810000"), 129, defaultScriptNumLen, false, nil},
-		{hexToBytes("000100"), 256, defaultScriptNumLen, false, nil},
-		{hexToBytes("ff7f00"), 32767, defaultScriptNumLen, false, nil},
-		{hexToBytes("00800000"), 32768, defaultScriptNumLen, false, nil},
-		{hexToBytes("ffff0000"), 65535, defaultScriptNumLen, false, nil},
-		{hexToBytes("00000800"), 524288, defaultScriptNumLen, false, nil},
-		{hexToBytes("00007000"), 7340032, defaultScriptNumLen, false, nil},
+		{hexToBytes("00"), 0, DefaultScriptNumLen, false, nil},
+		{hexToBytes("0100"), 1, DefaultScriptNumLen, false, nil},
+		{hexToBytes("7f00"), 127, DefaultScriptNumLen, false, nil},
+		{hexToBytes("800000"), 128, DefaultScriptNumLen, false, nil},
+		{hexToBytes("810000"), 129, DefaultScriptNumLen, false, nil},
+		{hexToBytes("000100"), 256, DefaultScriptNumLen, false, nil},
+		{hexToBytes("ff7f00"), 32767, DefaultScriptNumLen, false, nil},
+		{hexToBytes("00800000"), 32768, DefaultScriptNumLen, false, nil},
+		{hexToBytes("ffff0000"), 65535, DefaultScriptNumLen, false, nil},
+		{hexToBytes("00000800"), 524288, DefaultScriptNumLen, false, nil},
+		{hexToBytes("00007000"), 7340032, DefaultScriptNumLen, false, nil},
 		{hexToBytes("0009000100"), 16779520, 5, false, nil},
 	}
 
 	for _, test := range tests {
-		gotNum, err := makeScriptNum(test.serialized, test.minimalEncoding,
+		gotNum, err := MakeScriptNum(test.serialized, test.minimalEncoding,
 			test.numLen)
 		if err != test.err {
-			t.Errorf("makeScriptNum: did not received expected "+
+			t.Errorf("MakeScriptNum: did not received expected "+
 				"error for %x - got %v, want %v",
 				test.serialized, err, test.err)
 			continue
 		}
 
 		if gotNum != test.num {
-			t.Errorf("makeScriptNum: did not get expected number "+
+			t.Errorf("MakeScriptNum: did not get expected number "+
 				"for %x - got %d, want %d", test.serialized,
 				gotNum, test.num)
 			continue
@@ -213,7 +218,7 @@ func TestScriptNumInt32(t *testing.T) {
 	t.Parallel()
 
 	tests := []struct {
-		in   scriptNum
+		in   ScriptNum
 		want int32
 	}{
 		// Values inside the valid int32 range are just the values
@@ -267,3 +272,177 @@ func TestScriptNumInt32(t *testing.T) {
 		}
 	}
 }
+
+// TestMakeScriptNumBoundaries ensures MakeScriptNum handles the negative zero
+// encodings, the largest and smallest values encodable with the default and
+// lock time lengths, and non-minimal encodings as expected both with and
+// without minimal encoding required.
+func TestMakeScriptNumBoundaries(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		serialized []byte
+		maxLen     int
+		minimal    ScriptNum // expected value with minimal encoding
+		minimalErr error
+		relaxed    ScriptNum // expected value without minimal encoding
+		relaxedErr error
+	}{
+		// Negative zero in all lengths which are allowed at the lock
+		// time length.
+		{"negative zero 1 byte", hexToBytes("80"), LockTimeScriptNumLen,
+			0, ErrStackMinimalData, 0, nil},
+		{"negative zero 2 bytes", hexToBytes("0080"), LockTimeScriptNumLen,
+			0, ErrStackMinimalData, 0, nil},
+		{"negative zero 3 bytes", hexToBytes("000080"), LockTimeScriptNumLen,
+			0, ErrStackMinimalData, 0, nil},
+		{"negative zero 4 bytes", hexToBytes("00000080"), LockTimeScriptNumLen,
+			0, ErrStackMinimalData, 0, nil},
+		{"negative zero 5 bytes", hexToBytes("0000000080"), LockTimeScriptNumLen,
+			0, ErrStackMinimalData, 0, nil},
+		{"positive zero 5 bytes", hexToBytes("0000000000"), LockTimeScriptNumLen,
+			0, ErrStackMinimalData, 0, nil},
+
+		// Largest and smallest values at the default length and the
+		// shortest values which are too big for it.
+		{"max 4 bytes", hexToBytes("ffffff7f"), DefaultScriptNumLen,
+			2147483647, nil, 2147483647, nil},
+		{"min 4 bytes", hexToBytes("ffffffff"), DefaultScriptNumLen,
+			-2147483647, nil, -2147483647, nil},
+		{"max 4 bytes plus one", hexToBytes("0000008000"),
+			DefaultScriptNumLen, 0, ErrStackNumberTooBig, 0,
+			ErrStackNumberTooBig},
+		{"min 4 bytes minus one", hexToBytes("0000008080"),
+			DefaultScriptNumLen, 0, ErrStackNumberTooBig, 0,
+			ErrStackNumberTooBig},
+		{"non-minimal 5 byte one at 4 bytes", hexToBytes("0100000000"),
+			DefaultScriptNumLen, 0, ErrStackNumberTooBig, 0,
+			ErrStackNumberTooBig},
+
+		// Largest and smallest values at the lock time length, which
+		// include all lock times, and the shortest values which are too
+		// big for it.
+		{"max lock time", hexToBytes("ffffffff00"), LockTimeScriptNumLen,
+			4294967295, nil, 4294967295, nil},
+		{"max 5 bytes", hexToBytes("ffffffff7f"), LockTimeScriptNumLen,
+			549755813887, nil, 549755813887, nil},
+		{"min 5 bytes", hexToBytes("ffffffffff"), LockTimeScriptNumLen,
+			-549755813887, nil, -549755813887, nil},
+		{"max 5 bytes plus one", hexToBytes("000000008000"),
+			LockTimeScriptNumLen, 0, ErrStackNumberTooBig, 0,
+			ErrStackNumberTooBig},
+		{"min 5 bytes minus one", hexToBytes("000000008080"),
+			LockTimeScriptNumLen, 0, ErrStackNumberTooBig, 0,
+			ErrStackNumberTooBig},
+
+		// Non-minimal encodings which only decode without minimal
+		// encoding required.
+		{"non-minimal max 4 bytes", hexToBytes("ffffff7f00"),
+			LockTimeScriptNumLen, 0, ErrStackMinimalData, 2147483647,
+			nil},
+		{"non-minimal min 4 bytes", hexToBytes("ffffff7f80"),
+			LockTimeScriptNumLen, 0, ErrStackMinimalData, -2147483647,
+			nil},
+		{"non-minimal negative one", hexToBytes("0180"),
+			DefaultScriptNumLen, 0, ErrStackMinimalData, -1, nil},
+		{"non-minimal 255", hexToBytes("ff0000"), DefaultScriptNumLen,
+			0, ErrStackMinimalData, 255, nil},
+
+		// Encodings which require the extra sign byte are minimal.
+		{"255", hexToBytes("ff00"), DefaultScriptNumLen, 255, nil, 255,
+			nil},
+		{"-255", hexToBytes("ff80"), DefaultScriptNumLen, -255, nil,
+			-255, nil},
+
+		// Encodings longer than 8 bytes can't be represented regardless
+		// of the requested length.
+		{"max 8 bytes", hexToBytes("ffffffffffffff7f"), 8,
+			9223372036854775807, nil, 9223372036854775807, nil},
+		{"9 bytes", hexToBytes("000000000000008000"), 9, 0,
+			ErrStackNumberTooBig, 0, ErrStackNumberTooBig},
+	}
+
+	for _, test := range tests {
+		got, err := MakeScriptNum(test.serialized, true, test.maxLen)
+		if err != test.minimalErr || got != test.minimal {
+			t.Errorf("%s: unexpected result with minimal encoding - "+
+				"got %d (%v), want %d (%v)", test.name, got, err,
+				test.minimal, test.minimalErr)
+		}
+		got, err = MakeScriptNum(test.serialized, false, test.maxLen)
+		if err != test.relaxedErr || got != test.relaxed {
+			t.Errorf("%s: unexpected result without minimal "+
+				"encoding - got %d (%v), want %d (%v)", test.name,
+				got, err, test.relaxed, test.relaxedErr)
+		}
+	}
+}
+
+// TestScriptNumRoundTrip ensures the serialization of all values encodable
+// with the default and lock time lengths is minimally encoded and decodes back
+// to the same value, while values just outside of those ranges are rejected.
+// All values up to three bytes are checked along with a random sample of the
+// longer ones.
+func TestScriptNumRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	// roundTrip ensures the passed value round trips through its
+	// serialization at the passed length.
+	roundTrip := func(n ScriptNum, maxLen int) bool {
+		serialized := n.Bytes()
+		if len(serialized) > maxLen {
+			t.Errorf("Bytes: %d serialized to %d bytes, want at "+
+				"most %d", n, len(serialized), maxLen)
+			return false
+		}
+		for _, requireMinimal := range []bool{true, false} {
+			got, err := MakeScriptNum(serialized, requireMinimal,
+				maxLen)
+			if err != nil || got != n {
+				t.Errorf("MakeScriptNum(%x, %v, %d): got %d (%v), "+
+					"want %d", serialized, requireMinimal,
+					maxLen, got, err, n)
+				return false
+			}
+		}
+		return true
+	}
+
+	// Exhaustively check all values which serialize to at most three
+	// bytes.
+	const max3Bytes = 1<<23 - 1
+	for n := ScriptNum(-max3Bytes); n <= max3Bytes; n++ {
+		if !roundTrip(n, 3) {
+			return
+		}
+	}
+
+	// Check a random sample of the remaining values encodable with the
+	// default and lock time lengths along with the boundaries of each
+	// range.  The seed is fixed so failures are reproducible.
+	rng := rand.New(rand.NewSource(1))
+	for _, maxLen := range []int{DefaultScriptNumLen, LockTimeScriptNumLen} {
+		maxNum := ScriptNum(1)<<uint(8*maxLen-1) - 1
+		for _, n := range []ScriptNum{maxNum, -maxNum} {
+			if !roundTrip(n, maxLen) {
+				return
+			}
+		}
+		for i := 0; i < 100000; i++ {
+			n := ScriptNum(rng.Int63n(int64(maxNum)*2+1)) - maxNum
+			if !roundTrip(n, maxLen) {
+				return
+			}
+		}
+
+		for _, n := range []ScriptNum{maxNum + 1, -maxNum - 1} {
+			_, err := MakeScriptNum(n.Bytes(), true, maxLen)
+			if err != ErrStackNumberTooBig {
+				t.Errorf("MakeScriptNum: unexpected error for "+
+					"%d at %d bytes - got %v, want %v", n,
+					maxLen, err, ErrStackNumberTooBig)
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"sync"
+
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// ParsedOpcode describes a single parsed opcode of a script as passed to a
+// ScriptTemplateMatcher.
+type ParsedOpcode struct {
+	// Value is the opcode, such as OP_CHECKSIG or OP_DATA_33.
+	Value byte
+
+	// Data is the data pushed by the opcode, if any.  It references the
+	// script being matched and must not be modified.
+	Data []byte
+}
+
+// ScriptTemplateMatcher is the signature of a function which extracts the
+// addresses from a nonstandard public key script.  It is passed the parsed
+// opcodes of the script along with the network the addresses are for, and
+// returns the addresses, the number of signatures required to spend the script,
+// and whether or not the script matched the template.
+type ScriptTemplateMatcher func(pops []ParsedOpcode, chainParams *chaincfg.Params) ([]colxutil.Address, int, bool)
+
+// scriptTemplate is a script template registered via RegisterScriptTemplate.
+type scriptTemplate struct {
+	name    string
+	matcher ScriptTemplateMatcher
+}
+
+var (
+	// scriptTemplatesMtx protects scriptTemplates.
+	scriptTemplatesMtx sync.RWMutex
+
+	// scriptTemplates houses the registered script templates in the order
+	// they were registered, which is the order they are consulted in.
+	scriptTemplates []scriptTemplate
+)
+
+// RegisterScriptTemplate registers a script template with the passed name which
+// ExtractPkScriptAddrs consults to extract the addresses from public key
+// scripts which do not match any of the standard script classes.  This allows
+// best-effort address extraction for nonstandard scripts, for instance by
+// block explorers.  The templates are consulted in the order they were
+// registered and the first one which matches is used.  The class of the script
+// remains NonStandardTy.
+//
+// ErrScriptTemplateRegistered is returned if a template with the same name is
+// already registered.
+//
+// This function is safe for concurrent access.
+func RegisterScriptTemplate(name string, matcher ScriptTemplateMatcher) error {
+	scriptTemplatesMtx.Lock()
+	defer scriptTemplatesMtx.Unlock()
+
+	for _, template := range scriptTemplates {
+		if template.name == name {
+			return ErrScriptTemplateRegistered
+		}
+	}
+	scriptTemplates = append(scriptTemplates, scriptTemplate{
+		name:    name,
+		matcher: matcher,
+	})
+	return nil
+}
+
+// UnregisterScriptTemplate removes the script template with the passed name
+// and returns whether or not it was registered.
+//
+// This function is safe for concurrent access.
+func UnregisterScriptTemplate(name string) bool {
+	scriptTemplatesMtx.Lock()
+	defer scriptTemplatesMtx.Unlock()
+
+	for i, template := range scriptTemplates {
+		if template.name == name {
+			scriptTemplates = append(scriptTemplates[:i:i],
+				scriptTemplates[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// RegisteredScriptTemplates returns the names of the registered script
+// templates in the order they are consulted.
+//
+// This function is safe for concurrent access.
+func RegisteredScriptTemplates() []string {
+	scriptTemplatesMtx.RLock()
+	defer scriptTemplatesMtx.RUnlock()
+
+	names := make([]string, 0, len(scriptTemplates))
+	for _, template := range scriptTemplates {
+		names = append(names, template.name)
+	}
+	return names
+}
+
+// matchScriptTemplates returns the addresses and number of required signatures
+// extracted by the first registered script template which matches the passed
+// parsed script, and whether or not any template matched.
+//
+// This function is safe for concurrent access.
+func matchScriptTemplates(pops []parsedOpcode, chainParams *chaincfg.Params) ([]colxutil.Address, int, bool) {
+	scriptTemplatesMtx.RLock()
+	templates := scriptTemplates
+	scriptTemplatesMtx.RUnlock()
+	if len(templates) == 0 {
+		return nil, 0, false
+	}
+
+	exported := make([]ParsedOpcode, 0, len(pops))
+	for _, pop := range pops {
+		exported = append(exported, ParsedOpcode{
+			Value: pop.opcode.value,
+			Data:  pop.data,
+		})
+	}
+	for _, template := range templates {
+		addrs, requiredSigs, ok := template.matcher(exported,
+			chainParams)
+		if ok {
+			return addrs, requiredSigs, true
+		}
+	}
+	return nil, 0, false
+}
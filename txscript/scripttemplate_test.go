@@ -0,0 +1,169 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// matchWrappedPubKey is a script template matcher which extracts the public
+// keys which are checked by an OP_CHECKSIG directly following their push,
+// regardless of the opcodes surrounding them.
+func matchWrappedPubKey(pops []txscript.ParsedOpcode, chainParams *chaincfg.Params) ([]colxutil.Address, int, bool) {
+	var addrs []colxutil.Address
+	for i := 0; i < len(pops)-1; i++ {
+		if pops[i+1].Value != txscript.OP_CHECKSIG {
+			continue
+		}
+		addr, err := colxutil.NewAddressPubKey(pops[i].Data, chainParams)
+		if err == nil {
+			addrs = append(addrs, addr)
+		}
+	}
+	return addrs, len(addrs), len(addrs) != 0
+}
+
+// TestScriptTemplates ensures registered script templates are consulted in
+// registration order by ExtractPkScriptAddrs for nonstandard scripts only, that
+// they can be listed and unregistered, and that names must be unique.
+//
+// The test must not run in parallel since the script templates are global.
+func TestScriptTemplates(t *testing.T) {
+	pubKey := decodeHex("02192d74d0cb94344c9569c2e77901573d8d7903c3ebec" +
+		"3a957724895dca52c6b4")
+	wrapped := mustParseShortForm("NOP DATA_33 0x" +
+		"02192d74d0cb94344c9569c2e77901573d8d7903c3ebec3a957724895dca52c6b4" +
+		" CHECKSIG")
+	unmatched := mustParseShortForm("NOP NOP")
+	p2pkh := mustParseShortForm("DUP HASH160 DATA_20 0x" +
+		"ad06dd6ddee55cbca9a9e3713bd7587509a30564 EQUALVERIFY CHECKSIG")
+	wrappedAddrs := []colxutil.Address{newAddressPubKey(pubKey)}
+	p2pkhAddrs := []colxutil.Address{newAddressPubKeyHash(decodeHex(
+		"ad06dd6ddee55cbca9a9e3713bd7587509a30564"))}
+
+	// catchAll matches every script it is consulted for while recording
+	// that it was.
+	var catchAllCalls int
+	catchAllAddrs := []colxutil.Address{newAddressPubKeyHash(
+		make([]byte, 20))}
+	catchAll := func(pops []txscript.ParsedOpcode, chainParams *chaincfg.Params) ([]colxutil.Address, int, bool) {
+		catchAllCalls++
+		return catchAllAddrs, 2, true
+	}
+
+	type extractTest struct {
+		name    string
+		script  []byte
+		addrs   []colxutil.Address
+		reqSigs int
+		class   txscript.ScriptClass
+	}
+	runTests := func(stage string, tests []extractTest) {
+		t.Helper()
+		for _, test := range tests {
+			class, addrs, reqSigs, err := txscript.ExtractPkScriptAddrs(
+				test.script, &chaincfg.MainNetParams)
+			if err != nil {
+				t.Fatalf("%s: %s: unexpected error: %v", stage,
+					test.name, err)
+			}
+			if !reflect.DeepEqual(addrs, test.addrs) {
+				t.Fatalf("%s: %s: unexpected addresses - got %v, "+
+					"want %v", stage, test.name, addrs,
+					test.addrs)
+			}
+			if reqSigs != test.reqSigs {
+				t.Fatalf("%s: %s: unexpected required signatures "+
+					"- got %d, want %d", stage, test.name,
+					reqSigs, test.reqSigs)
+			}
+			if class != test.class {
+				t.Fatalf("%s: %s: unexpected class - got %v, "+
+					"want %v", stage, test.name, class,
+					test.class)
+			}
+		}
+	}
+	assertRegistered := func(stage string, want []string) {
+		t.Helper()
+		got := txscript.RegisteredScriptTemplates()
+		if len(got) != len(want) || (len(want) != 0 &&
+			!reflect.DeepEqual(got, want)) {
+
+			t.Fatalf("%s: unexpected templates - got %v, want %v",
+				stage, got, want)
+		}
+	}
+
+	// Nothing is extracted from nonstandard scripts without templates.
+	assertRegistered("none", nil)
+	runTests("none", []extractTest{
+		{"wrapped", wrapped, nil, 0, txscript.NonStandardTy},
+		{"p2pkh", p2pkh, p2pkhAddrs, 1, txscript.PubKeyHashTy},
+	})
+
+	// Register the templates, ensuring they are removed once the test
+	// completes.
+	defer txscript.UnregisterScriptTemplate("wrappedpubkey")
+	defer txscript.UnregisterScriptTemplate("catchall")
+	err := txscript.RegisterScriptTemplate("wrappedpubkey",
+		matchWrappedPubKey)
+	if err != nil {
+		t.Fatalf("RegisterScriptTemplate: unexpected error: %v", err)
+	}
+	err = txscript.RegisterScriptTemplate("catchall", catchAll)
+	if err != nil {
+		t.Fatalf("RegisterScriptTemplate: unexpected error: %v", err)
+	}
+	err = txscript.RegisterScriptTemplate("wrappedpubkey", catchAll)
+	if err != txscript.ErrScriptTemplateRegistered {
+		t.Fatalf("RegisterScriptTemplate: unexpected error for a "+
+			"duplicate name - got %v, want %v", err,
+			txscript.ErrScriptTemplateRegistered)
+	}
+	assertRegistered("registered", []string{"wrappedpubkey", "catchall"})
+
+	// The first matching template is used for nonstandard scripts, while
+	// standard scripts and scripts which do not parse are unaffected.
+	runTests("registered", []extractTest{
+		{"wrapped", wrapped, wrappedAddrs, 1, txscript.NonStandardTy},
+		{"unmatched", unmatched, catchAllAddrs, 2,
+			txscript.NonStandardTy},
+		{"p2pkh", p2pkh, p2pkhAddrs, 1, txscript.PubKeyHashTy},
+	})
+	if catchAllCalls != 1 {
+		t.Fatalf("catch-all template consulted %d times, want 1",
+			catchAllCalls)
+	}
+	class, addrs, _, err := txscript.ExtractPkScriptAddrs(
+		[]byte{txscript.OP_DATA_45}, &chaincfg.MainNetParams)
+	if err == nil || addrs != nil || class != txscript.NonStandardTy {
+		t.Fatalf("unexpected result for a script which does not parse "+
+			"- got class %v, addresses %v, error %v", class, addrs,
+			err)
+	}
+	if catchAllCalls != 1 {
+		t.Fatal("catch-all template consulted for a script which " +
+			"does not parse")
+	}
+
+	// Unregistering a template removes it from the templates consulted.
+	if !txscript.UnregisterScriptTemplate("wrappedpubkey") {
+		t.Fatal("UnregisterScriptTemplate: template not registered")
+	}
+	if txscript.UnregisterScriptTemplate("wrappedpubkey") {
+		t.Fatal("UnregisterScriptTemplate: template still registered")
+	}
+	assertRegistered("unregistered", []string{"catchall"})
+	runTests("unregistered", []extractTest{
+		{"wrapped", wrapped, catchAllAddrs, 2, txscript.NonStandardTy},
+	})
+}
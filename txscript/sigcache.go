@@ -12,15 +12,37 @@ import (
 	"github.com/tinhnguyenhn/colxd/wire"
 )
 
-// sigCacheEntry represents an entry in the SigCache. Entries within the
-// SigCache are keyed according to the sigHash of the signature. In the
-// scenario of a cache-hit (according to the sigHash), an additional comparison
-// of the signature, and public key will be executed in order to ensure a complete
-// match. In the occasion that two sigHashes collide, the newer sigHash will
-// simply overwrite the existing entry.
-type sigCacheEntry struct {
-	sig    *btcec.Signature
-	pubKey *btcec.PublicKey
+// sigCacheShards is the number of independently locked shards the entries of a
+// SigCache are distributed across in order to reduce lock contention between
+// the goroutines validating scripts concurrently.
+const sigCacheShards = 16
+
+// sigCacheKey identifies an entry in the SigCache.  Entries are keyed by the
+// complete triplet of the sigHash, the serialized signature, and the serialized
+// public key, so distinct signatures over the same sigHash never overwrite each
+// other.
+type sigCacheKey struct {
+	sigHash wire.ShaHash
+	sig     string
+	pubKey  string
+}
+
+// newSigCacheKey returns the key of the entry for a signature over 'sigHash'
+// under public key 'pubKey'.
+func newSigCacheKey(sigHash *wire.ShaHash, sig *btcec.Signature, pubKey *btcec.PublicKey) sigCacheKey {
+	return sigCacheKey{
+		sigHash: *sigHash,
+		sig:     string(sig.Serialize()),
+		pubKey:  string(pubKey.SerializeCompressed()),
+	}
+}
+
+// sigCacheShard houses a subset of the entries of a SigCache along with the
+// lock protecting them.
+type sigCacheShard struct {
+	sync.RWMutex
+	validSigs  map[sigCacheKey]struct{}
+	maxEntries uint
 }
 
 // SigCache implements an ECDSA signature verification cache with a randomized
@@ -33,10 +55,16 @@ type sigCacheEntry struct {
 // Secondly, usage of the SigCache introduces a signature verification
 // optimization which speeds up the validation of transactions within a block,
 // if they've already been seen and verified within the mempool.
+//
+// Since entries are keyed by the signature hash and also record the signature
+// and public key, they do not depend on the state of the chain and remain valid
+// across chain reorganizations.
+//
+// The entries are distributed across independently locked shards by their
+// sigHash, so concurrent script validation goroutines rarely contend for the
+// same lock.
 type SigCache struct {
-	sync.RWMutex
-	validSigs  map[wire.ShaHash]sigCacheEntry
-	maxEntries uint
+	shards [sigCacheShards]sigCacheShard
 }
 
 // NewSigCache creates and initializes a new instance of SigCache. Its sole
@@ -45,46 +73,69 @@ type SigCache struct {
 // to make room for new entries that would cause the number of entries in the
 // cache to exceed the max.
 func NewSigCache(maxEntries uint) *SigCache {
-	return &SigCache{
-		validSigs:  make(map[wire.ShaHash]sigCacheEntry, maxEntries),
-		maxEntries: maxEntries,
+	// Split the entries evenly across the shards and hand the remainder
+	// to the first shards so the total is exactly the requested maximum.
+	var s SigCache
+	for i := range s.shards {
+		shardEntries := maxEntries / sigCacheShards
+		if uint(i) < maxEntries%sigCacheShards {
+			shardEntries++
+		}
+		s.shards[i].validSigs = make(map[sigCacheKey]struct{},
+			shardEntries)
+		s.shards[i].maxEntries = shardEntries
 	}
+	return &s
+}
+
+// shard returns the shard responsible for the entries over 'sigHash'.  The
+// sigHash is the output of a hash function, so its first byte distributes the
+// entries evenly.
+func (s *SigCache) shard(sigHash *wire.ShaHash) *sigCacheShard {
+	return &s.shards[int(sigHash[0])%sigCacheShards]
 }
 
 // Exists returns true if an existing entry of 'sig' over 'sigHash' for public
 // key 'pubKey' is found within the SigCache. Otherwise, false is returned.
 //
 // NOTE: This function is safe for concurrent access. Readers won't be blocked
-// unless there exists a writer, adding an entry to the SigCache.
+// unless there exists a writer, adding an entry to the same shard of the
+// SigCache.
 func (s *SigCache) Exists(sigHash wire.ShaHash, sig *btcec.Signature, pubKey *btcec.PublicKey) bool {
-	s.RLock()
-	defer s.RUnlock()
+	key := newSigCacheKey(&sigHash, sig, pubKey)
+	shard := s.shard(&sigHash)
+	shard.RLock()
+	_, ok := shard.validSigs[key]
+	shard.RUnlock()
 
-	if entry, ok := s.validSigs[sigHash]; ok {
-		return entry.pubKey.IsEqual(pubKey) && entry.sig.IsEqual(sig)
-	}
-
-	return false
+	return ok
 }
 
 // Add adds an entry for a signature over 'sigHash' under public key 'pubKey'
-// to the signature cache. In the event that the SigCache is 'full', an
-// existing entry is randomly chosen to be evicted in order to make space for
-// the new entry.
+// to the signature cache. In the event that the shard of the SigCache the
+// entry belongs to is 'full', an existing entry of the shard is randomly
+// chosen to be evicted in order to make space for the new entry.
 //
 // NOTE: This function is safe for concurrent access. Writers will block
-// simultaneous readers until function execution has concluded.
+// simultaneous readers of the same shard until function execution has
+// concluded.
 func (s *SigCache) Add(sigHash wire.ShaHash, sig *btcec.Signature, pubKey *btcec.PublicKey) {
-	s.Lock()
-	defer s.Unlock()
+	shard := s.shard(&sigHash)
+	if shard.maxEntries == 0 {
+		return
+	}
+
+	key := newSigCacheKey(&sigHash, sig, pubKey)
+	shard.Lock()
+	defer shard.Unlock()
 
-	if s.maxEntries <= 0 {
+	if _, ok := shard.validSigs[key]; ok {
 		return
 	}
 
 	// If adding this new entry will put us over the max number of allowed
 	// entries, then evict an entry.
-	if uint(len(s.validSigs)+1) > s.maxEntries {
+	if uint(len(shard.validSigs)+1) > shard.maxEntries {
 		// Remove a random entry from the map. Relying on the random
 		// starting point of Go's map iteration. It's worth noting that
 		// the random iteration starting point is not 100% guaranteed
@@ -94,10 +145,24 @@ func (s *SigCache) Add(sigHash wire.ShaHash, sig *btcec.Signature, pubKey *btcec
 		// would need to be able to execute preimage attacks on the
 		// hashing function in order to start eviction at a specific
 		// entry.
-		for sigEntry := range s.validSigs {
-			delete(s.validSigs, sigEntry)
+		for sigEntry := range shard.validSigs {
+			delete(shard.validSigs, sigEntry)
 			break
 		}
 	}
-	s.validSigs[sigHash] = sigCacheEntry{sig, pubKey}
+	shard.validSigs[key] = struct{}{}
+}
+
+// Len returns the number of entries in the SigCache.
+//
+// NOTE: This function is safe for concurrent access.
+func (s *SigCache) Len() int {
+	var n int
+	for i := range s.shards {
+		shard := &s.shards[i]
+		shard.RLock()
+		n += len(shard.validSigs)
+		shard.RUnlock()
+	}
+	return n
 }
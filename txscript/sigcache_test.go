@@ -7,6 +7,7 @@ package txscript
 
 import (
 	"crypto/rand"
+	"sync"
 	"testing"
 
 	"github.com/tinhnguyenhn/colxd/btcec"
@@ -65,12 +66,15 @@ func TestSigCacheAddEvictEntry(t *testing.T) {
 	sigCacheSize := uint(100)
 	sigCache := NewSigCache(sigCacheSize)
 
-	// Fill the sigcache up with some random sig triplets.
+	// Fill the sigcache up with some random sig triplets.  The first byte
+	// of the sigHashes is chosen so the triplets are spread evenly across
+	// the shards and fill every shard exactly.
 	for i := uint(0); i < sigCacheSize; i++ {
 		msg, sig, key, err := genRandomSig()
 		if err != nil {
 			t.Fatalf("unable to generate random signature test data")
 		}
+		msg[0] = byte(i)
 
 		sigCache.Add(*msg, sig, key)
 
@@ -83,9 +87,9 @@ func TestSigCacheAddEvictEntry(t *testing.T) {
 	}
 
 	// The sigcache should now have sigCacheSize entries within it.
-	if uint(len(sigCache.validSigs)) != sigCacheSize {
+	if uint(sigCache.Len()) != sigCacheSize {
 		t.Fatalf("sigcache should now have %v entries, instead it has %v",
-			sigCacheSize, len(sigCache.validSigs))
+			sigCacheSize, sigCache.Len())
 	}
 
 	// Add a new entry, this should cause eviction of a randomly chosen
@@ -97,9 +101,9 @@ func TestSigCacheAddEvictEntry(t *testing.T) {
 	sigCache.Add(*msgNew, sigNew, keyNew)
 
 	// The sigcache should still have sigCache entries.
-	if uint(len(sigCache.validSigs)) != sigCacheSize {
+	if uint(sigCache.Len()) != sigCacheSize {
 		t.Fatalf("sigcache should now have %v entries, instead it has %v",
-			sigCacheSize, len(sigCache.validSigs))
+			sigCacheSize, sigCache.Len())
 	}
 
 	// The entry added above should be found within the sigcache.
@@ -134,8 +138,101 @@ func TestSigCacheAddMaxEntriesZeroOrNegative(t *testing.T) {
 	}
 
 	// There shouldn't be any entries in the sigCache.
-	if len(sigCache.validSigs) != 0 {
+	if sigCache.Len() != 0 {
 		t.Errorf("%v items found in sigcache, no items should have"+
-			"been added", len(sigCache.validSigs))
+			"been added", sigCache.Len())
+	}
+}
+
+// TestSigCacheSameSigHash ensures entries for different signatures and public
+// keys over the same sigHash are kept independently and only match the exact
+// triplet which was added.
+func TestSigCacheSameSigHash(t *testing.T) {
+	sigCache := NewSigCache(100)
+
+	msg1, sig1, key1, err := genRandomSig()
+	if err != nil {
+		t.Fatalf("unable to generate random signature test data")
+	}
+	_, sig2, key2, err := genRandomSig()
+	if err != nil {
+		t.Fatalf("unable to generate random signature test data")
+	}
+	sigCache.Add(*msg1, sig1, key1)
+	sigCache.Add(*msg1, sig2, key2)
+	if sigCache.Len() != 2 {
+		t.Fatalf("sigcache should have 2 entries, instead it has %v",
+			sigCache.Len())
+	}
+
+	tests := []struct {
+		name string
+		sig  *btcec.Signature
+		key  *btcec.PublicKey
+		want bool
+	}{
+		{"first triplet", sig1, key1, true},
+		{"second triplet", sig2, key2, true},
+		{"first sig with second key", sig1, key2, false},
+		{"second sig with first key", sig2, key1, false},
+	}
+	for _, test := range tests {
+		if got := sigCache.Exists(*msg1, test.sig, test.key); got != test.want {
+			t.Errorf("%s: unexpected result - got %v, want %v",
+				test.name, got, test.want)
+		}
+	}
+
+	// Adding an existing triplet again must not add another entry.
+	sigCache.Add(*msg1, sig1, key1)
+	if sigCache.Len() != 2 {
+		t.Fatalf("sigcache should still have 2 entries, instead it has %v",
+			sigCache.Len())
+	}
+}
+
+// TestSigCacheConcurrent ensures the signature cache can be accessed from
+// multiple goroutines concurrently while it is evicting entries.  It is most
+// useful when run with the race detector.
+func TestSigCacheConcurrent(t *testing.T) {
+	const (
+		numGoroutines = 8
+		numSigs       = 10
+		numRounds     = 50
+	)
+
+	sigCache := NewSigCache(numGoroutines * numSigs / 2)
+	var wg sync.WaitGroup
+	for i := 0; i < numGoroutines; i++ {
+		msgs := make([]*wire.ShaHash, numSigs)
+		sigs := make([]*btcec.Signature, numSigs)
+		keys := make([]*btcec.PublicKey, numSigs)
+		for j := 0; j < numSigs; j++ {
+			var err error
+			msgs[j], sigs[j], keys[j], err = genRandomSig()
+			if err != nil {
+				t.Fatalf("unable to generate random signature " +
+					"test data")
+			}
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for round := 0; round < numRounds; round++ {
+				for j := range msgs {
+					if !sigCache.Exists(*msgs[j], sigs[j], keys[j]) {
+						sigCache.Add(*msgs[j], sigs[j], keys[j])
+					}
+					sigCache.Len()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := sigCache.Len(); n > numGoroutines*numSigs/2 {
+		t.Fatalf("sigcache exceeds its maximum size - got %d entries, "+
+			"want at most %d", n, numGoroutines*numSigs/2)
 	}
 }
@@ -0,0 +1,219 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash"
+
+	"github.com/btcsuite/fastsha256"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// sigHashInputSize is the size of a serialized transaction input with an empty
+// signature script: the previous outpoint hash and index, a single byte for
+// the length of the empty script, and the sequence number.
+const sigHashInputSize = wire.HashSize + 4 + 1 + 4
+
+// TxSigHashes houses the parts of the serialization of a transaction which
+// are shared by the signature hashes of all of its inputs.  Computing the
+// signature hash of an input requires serializing the entire transaction with
+// the signature scripts of all other inputs removed, so computing them for
+// every input of a transaction from scratch takes time quadratic in the size
+// of the transaction.  Reusing the cached serialization avoids copying and
+// reserializing the transaction for each input.
+//
+// A TxSigHashes is immutable once created and is therefore safe for
+// concurrent access.
+type TxSigHashes struct {
+	// version and lockTime are the serialized version and lock time of
+	// the transaction.
+	version  [4]byte
+	lockTime [4]byte
+
+	// inputs is the serialization of every input with an empty signature
+	// script, so the input at index i starts at i * sigHashInputSize.
+	inputs []byte
+
+	// outputs is the serialization of the outputs including their count.
+	outputs []byte
+
+	// outputOffsets holds the offset of each output within outputs.
+	outputOffsets []int
+}
+
+// NewTxSigHashes returns the cached serialization of the passed transaction
+// to be used for computing the signature hashes of its inputs.
+func NewTxSigHashes(tx *wire.MsgTx) *TxSigHashes {
+	h := TxSigHashes{
+		inputs:        make([]byte, 0, len(tx.TxIn)*sigHashInputSize),
+		outputOffsets: make([]int, len(tx.TxOut)),
+	}
+	binary.LittleEndian.PutUint32(h.version[:], uint32(tx.Version))
+	binary.LittleEndian.PutUint32(h.lockTime[:], tx.LockTime)
+
+	var buf [8]byte
+	for _, txIn := range tx.TxIn {
+		prevOut := &txIn.PreviousOutPoint
+		h.inputs = append(h.inputs, prevOut.Hash[:]...)
+		binary.LittleEndian.PutUint32(buf[:4], prevOut.Index)
+		h.inputs = append(h.inputs, buf[:4]...)
+		h.inputs = append(h.inputs, 0x00)
+		binary.LittleEndian.PutUint32(buf[:4], txIn.Sequence)
+		h.inputs = append(h.inputs, buf[:4]...)
+	}
+
+	var outputs bytes.Buffer
+	wire.WriteVarInt(&outputs, 0, uint64(len(tx.TxOut)))
+	for i, txOut := range tx.TxOut {
+		h.outputOffsets[i] = outputs.Len()
+		binary.LittleEndian.PutUint64(buf[:], uint64(txOut.Value))
+		outputs.Write(buf[:])
+		wire.WriteVarBytes(&outputs, 0, txOut.PkScript)
+	}
+	h.outputs = outputs.Bytes()
+
+	return &h
+}
+
+// matches returns whether the cached serialization can belong to the passed
+// transaction.  It guards against using a cache created for a different
+// transaction, which would result in incorrect signature hashes.
+func (h *TxSigHashes) matches(tx *wire.MsgTx) bool {
+	return len(h.inputs) == len(tx.TxIn)*sigHashInputSize &&
+		len(h.outputOffsets) == len(tx.TxOut)
+}
+
+// output returns the serialization of the output at the passed index.
+func (h *TxSigHashes) output(idx int) []byte {
+	end := len(h.outputs)
+	if idx+1 < len(h.outputOffsets) {
+		end = h.outputOffsets[idx+1]
+	}
+	return h.outputs[h.outputOffsets[idx]:end]
+}
+
+// CalcSignatureHashCached calculates the same signature hash as
+// CalcSignatureHash while reusing the passed serialization of the transaction,
+// which must have been created by NewTxSigHashes for the same transaction.
+func CalcSignatureHashCached(script []byte, hashType SigHashType, tx *wire.MsgTx, idx int, cache *TxSigHashes) ([]byte, error) {
+	parsedScript, err := parseScript(script)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse output script: %v", err)
+	}
+	if idx < 0 || idx >= len(tx.TxIn) {
+		return nil, ErrInvalidIndex
+	}
+	return calcSignatureHashCached(parsedScript, hashType, tx, idx, cache), nil
+}
+
+// calcSignatureHashCached calculates the same signature hash as
+// calcSignatureHash by writing the serialization of the modified transaction
+// directly to the hasher, using the cached serialization of the parts which
+// are not specific to the input being signed.  It falls back to
+// calcSignatureHash when no cache is provided or the cache does not belong to
+// the transaction.
+func calcSignatureHashCached(script []parsedOpcode, hashType SigHashType, tx *wire.MsgTx, idx int, cache *TxSigHashes) []byte {
+	if cache == nil || !cache.matches(tx) {
+		return calcSignatureHash(script, hashType, tx, idx)
+	}
+
+	// See calcSignatureHash for details on the signature hash of 1 for
+	// SigHashSingle without a corresponding output.
+	if hashType&sigHashMask == SigHashSingle && idx >= len(tx.TxOut) {
+		var sigHash wire.ShaHash
+		sigHash[0] = 0x01
+		return sigHash[:]
+	}
+
+	// Remove all instances of OP_CODESEPARATOR from the script.
+	// UnparseScript cannot fail here because removeOpcode only returns a
+	// valid script.
+	script = removeOpcode(script, OP_CODESEPARATOR)
+	sigScript, _ := unparseScript(script)
+
+	// The sequence numbers of the other inputs are zeroed for SigHashNone
+	// and SigHashSingle, while the remaining hash types are treated like
+	// SigHashAll.
+	sigHashBase := hashType & sigHashMask
+	zeroSequences := sigHashBase == SigHashNone ||
+		sigHashBase == SigHashSingle
+
+	hasher := fastsha256.New()
+	hasher.Write(cache.version[:])
+
+	// Write the input being signed with the script in place of its
+	// signature script, and all other inputs with an empty signature
+	// script unless only the input being signed is committed to.
+	cur := cache.inputs[idx*sigHashInputSize : (idx+1)*sigHashInputSize]
+	if hashType&SigHashAnyOneCanPay != 0 {
+		wire.WriteVarInt(hasher, 0, 1)
+		writeSigHashInput(hasher, cur, sigScript)
+	} else {
+		wire.WriteVarInt(hasher, 0, uint64(len(tx.TxIn)))
+		writeSigHashInputs(hasher, cache.inputs[:len(cur)*idx],
+			zeroSequences)
+		writeSigHashInput(hasher, cur, sigScript)
+		writeSigHashInputs(hasher, cache.inputs[len(cur)*(idx+1):],
+			zeroSequences)
+	}
+
+	switch sigHashBase {
+	case SigHashNone:
+		wire.WriteVarInt(hasher, 0, 0)
+
+	case SigHashSingle:
+		// All outputs before the one at the index of the input being
+		// signed are committed to as a value of -1 and an empty
+		// script.
+		wire.WriteVarInt(hasher, 0, uint64(idx+1))
+		var blankOutput [9]byte
+		binary.LittleEndian.PutUint64(blankOutput[:8], ^uint64(0))
+		for i := 0; i < idx; i++ {
+			hasher.Write(blankOutput[:])
+		}
+		hasher.Write(cache.output(idx))
+
+	default:
+		hasher.Write(cache.outputs)
+	}
+
+	hasher.Write(cache.lockTime[:])
+	var hashTypeBytes [4]byte
+	binary.LittleEndian.PutUint32(hashTypeBytes[:], uint32(hashType))
+	hasher.Write(hashTypeBytes[:])
+
+	first := hasher.Sum(nil)
+	sigHash := fastsha256.Sum256(first)
+	return sigHash[:]
+}
+
+// writeSigHashInput writes the passed serialized input, which has an empty
+// signature script, to the hasher with the passed signature script in its
+// place.
+func writeSigHashInput(hasher hash.Hash, input []byte, sigScript []byte) {
+	hasher.Write(input[:wire.HashSize+4])
+	wire.WriteVarBytes(hasher, 0, sigScript)
+	hasher.Write(input[wire.HashSize+5:])
+}
+
+// writeSigHashInputs writes the passed serialized inputs, which have empty
+// signature scripts, to the hasher, with their sequence numbers zeroed when
+// requested.
+func writeSigHashInputs(hasher hash.Hash, inputs []byte, zeroSequences bool) {
+	if !zeroSequences {
+		hasher.Write(inputs)
+		return
+	}
+
+	var zeroSequence [4]byte
+	for off := 0; off < len(inputs); off += sigHashInputSize {
+		hasher.Write(inputs[off : off+wire.HashSize+5])
+		hasher.Write(zeroSequence[:])
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/btcec"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// newSigHashTestTx returns a transaction with the passed number of inputs and
+// outputs which have distinct previous outpoints, sequence numbers, values,
+// and scripts.
+func newSigHashTestTx(numInputs, numOutputs int) *wire.MsgTx {
+	tx := wire.NewMsgTx()
+	tx.Version = 2
+	tx.LockTime = 500000
+	for i := 0; i < numInputs; i++ {
+		var hash wire.ShaHash
+		hash[0], hash[1] = byte(i), byte(i>>8)
+		txIn := wire.NewTxIn(wire.NewOutPoint(&hash, uint32(i%3)),
+			[]byte{txscript.OP_DATA_1, byte(i)})
+		txIn.Sequence = uint32(i) * 7
+		tx.AddTxIn(txIn)
+	}
+	for i := 0; i < numOutputs; i++ {
+		pkScript := bytes.Repeat([]byte{txscript.OP_NOP}, i+1)
+		tx.AddTxOut(wire.NewTxOut(int64(i+1)*1000, pkScript))
+	}
+	return tx
+}
+
+// TestCalcSignatureHashCached ensures the signature hashes computed with a
+// cached serialization of a transaction are identical to those computed by
+// CalcSignatureHash for all hash types, including undefined ones, for every
+// input of transactions with fewer, the same number of, and more outputs than
+// inputs.
+func TestCalcSignatureHashCached(t *testing.T) {
+	t.Parallel()
+
+	// The script includes an OP_CODESEPARATOR which must be removed.
+	script := []byte{txscript.OP_DUP, txscript.OP_CODESEPARATOR,
+		txscript.OP_DATA_2, 0x01, 0x02, txscript.OP_CHECKSIG}
+
+	hashTypes := []txscript.SigHashType{
+		txscript.SigHashOld,
+		txscript.SigHashAll,
+		txscript.SigHashNone,
+		txscript.SigHashSingle,
+		0x04,
+		0xff,
+	}
+	for _, numOutputs := range []int{0, 1, 4, 7} {
+		tx := newSigHashTestTx(4, numOutputs)
+		cache := txscript.NewTxSigHashes(tx)
+		for _, hashType := range hashTypes {
+			for _, anyOneCanPay := range []bool{false, true} {
+				if anyOneCanPay {
+					hashType |= txscript.SigHashAnyOneCanPay
+				}
+				for idx := range tx.TxIn {
+					want, err := txscript.CalcSignatureHash(script,
+						hashType, tx, idx)
+					if err != nil {
+						t.Fatalf("CalcSignatureHash: %v", err)
+					}
+					got, err := txscript.CalcSignatureHashCached(
+						script, hashType, tx, idx, cache)
+					if err != nil {
+						t.Fatalf("CalcSignatureHashCached: %v",
+							err)
+					}
+					if !bytes.Equal(got, want) {
+						t.Errorf("outputs %d, hash type %x, "+
+							"input %d: got %x, want %x",
+							numOutputs, hashType, idx,
+							got, want)
+					}
+				}
+			}
+		}
+	}
+
+	// A cache created for a different transaction must not be used.
+	tx := newSigHashTestTx(4, 4)
+	want, _ := txscript.CalcSignatureHash(script, txscript.SigHashAll, tx, 1)
+	for _, cache := range []*txscript.TxSigHashes{
+		nil,
+		txscript.NewTxSigHashes(newSigHashTestTx(3, 4)),
+		txscript.NewTxSigHashes(newSigHashTestTx(4, 5)),
+	} {
+		got, err := txscript.CalcSignatureHashCached(script,
+			txscript.SigHashAll, tx, 1, cache)
+		if err != nil {
+			t.Fatalf("CalcSignatureHashCached: %v", err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("mismatched cache: got %x, want %x", got, want)
+		}
+	}
+
+	// Invalid input indices and scripts are rejected.
+	cache := txscript.NewTxSigHashes(tx)
+	for _, idx := range []int{-1, 4} {
+		_, err := txscript.CalcSignatureHashCached(script,
+			txscript.SigHashAll, tx, idx, cache)
+		if err != txscript.ErrInvalidIndex {
+			t.Errorf("input %d: unexpected error - got %v, want %v",
+				idx, err, txscript.ErrInvalidIndex)
+		}
+	}
+	_, err := txscript.CalcSignatureHashCached([]byte{txscript.OP_DATA_2},
+		txscript.SigHashAll, tx, 0, cache)
+	if err == nil {
+		t.Errorf("invalid script: expected an error")
+	}
+}
+
+// TestEngineSigHashes ensures an engine created with a cached serialization of
+// the transaction validates the signatures of all of its inputs.
+func TestEngineSigHashes(t *testing.T) {
+	t.Parallel()
+
+	privKey, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatalf("unable to create key: %v", err)
+	}
+	pubKey := privKey.PubKey().SerializeCompressed()
+	pkScript, err := txscript.NewScriptBuilder().AddData(pubKey).
+		AddOp(txscript.OP_CHECKSIG).Script()
+	if err != nil {
+		t.Fatalf("unable to create script: %v", err)
+	}
+
+	tx := newSigHashTestTx(5, 3)
+	hashTypes := []txscript.SigHashType{txscript.SigHashAll,
+		txscript.SigHashNone, txscript.SigHashSingle,
+		txscript.SigHashAll | txscript.SigHashAnyOneCanPay,
+		txscript.SigHashSingle | txscript.SigHashAnyOneCanPay}
+	for idx := range tx.TxIn {
+		sig, err := txscript.RawTxInSignature(tx, idx, pkScript,
+			hashTypes[idx], privKey)
+		if err != nil {
+			t.Fatalf("unable to sign input %d: %v", idx, err)
+		}
+		tx.TxIn[idx].SignatureScript, err = txscript.NewScriptBuilder().
+			AddData(sig).Script()
+		if err != nil {
+			t.Fatalf("unable to create script: %v", err)
+		}
+	}
+
+	opts := &txscript.EngineOptions{
+		SigCache:  txscript.NewSigCache(10),
+		SigHashes: txscript.NewTxSigHashes(tx),
+	}
+	for idx := range tx.TxIn {
+		vm, err := txscript.NewEngineWithOptions(pkScript, tx, idx,
+			txscript.StandardVerifyFlags, opts)
+		if err != nil {
+			t.Fatalf("input %d: unable to create engine: %v", idx, err)
+		}
+		if err := vm.Execute(); err != nil {
+			t.Errorf("input %d: unexpected error: %v", idx, err)
+		}
+	}
+}
+
+// benchmarkCalcSignatureHashes benchmarks computing the signature hashes of
+// all inputs of a transaction with 1000 inputs, using a cached serialization
+// of the transaction when requested.
+func benchmarkCalcSignatureHashes(b *testing.B, cached bool) {
+	tx := newSigHashTestTx(1000, 2)
+	pkScript, err := txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).
+		AddOp(txscript.OP_HASH160).AddData(make([]byte, 20)).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).
+		Script()
+	if err != nil {
+		b.Fatalf("unable to create script: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var cache *txscript.TxSigHashes
+		if cached {
+			cache = txscript.NewTxSigHashes(tx)
+		}
+		for idx := range tx.TxIn {
+			var err error
+			if cached {
+				_, err = txscript.CalcSignatureHashCached(pkScript,
+					txscript.SigHashAll, tx, idx, cache)
+			} else {
+				_, err = txscript.CalcSignatureHash(pkScript,
+					txscript.SigHashAll, tx, idx)
+			}
+			if err != nil {
+				b.Fatalf("unable to compute signature hash: %v", err)
+			}
+		}
+	}
+}
+
+// BenchmarkCalcSignatureHash benchmarks computing the signature hashes of all
+// inputs of a large transaction by reserializing it for each input.
+func BenchmarkCalcSignatureHash(b *testing.B) {
+	benchmarkCalcSignatureHashes(b, false)
+}
+
+// BenchmarkCalcSignatureHashCached benchmarks computing the signature hashes
+// of all inputs of a large transaction with its cached serialization in order
+// to compare it against BenchmarkCalcSignatureHash.
+func BenchmarkCalcSignatureHashCached(b *testing.B) {
+	benchmarkCalcSignatureHashes(b, true)
+}
@@ -6,6 +6,7 @@
 package txscript
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 
@@ -182,7 +183,7 @@ func mergeScripts(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
 			return prevScript
 		}
 		prevPops, err := parseScript(prevScript)
-		if err != nil || len(prevPops) == 0 {
+		if err != nil {
 			return sigScript
 		}
 
@@ -194,6 +195,13 @@ func mergeScripts(chainParams *chaincfg.Params, tx *wire.MsgTx, idx int,
 		class, addresses, nrequired, err :=
 			ExtractPkScriptAddrs(script, chainParams)
 
+		// Multisig redeem scripts are merged even without a previous
+		// script so the signatures are normalized the same way as
+		// when they are merged again later.
+		if len(prevPops) == 0 && class != MultiSigTy {
+			return sigScript
+		}
+
 		// regenerate scripts.
 		sigScript, _ := unparseScript(sigPops)
 		prevScript, _ := unparseScript(prevPops)
@@ -240,13 +248,16 @@ func mergeMultiSig(tx *wire.MsgTx, idx int, addresses []colxutil.Address,
 	// all assumptions are broken and who knows which way is up?
 	pkPops, _ := parseScript(pkScript)
 
+	// Either of the scripts may be empty, in which case the other one is
+	// still normalized below so merging the result again does not change
+	// it.
 	sigPops, err := parseScript(sigScript)
-	if err != nil || len(sigPops) == 0 {
+	if err != nil {
 		return prevScript
 	}
 
 	prevPops, err := parseScript(prevScript)
-	if err != nil || len(prevPops) == 0 {
+	if err != nil {
 		return sigScript
 	}
 
@@ -264,15 +275,55 @@ func mergeMultiSig(tx *wire.MsgTx, idx int, addresses []colxutil.Address,
 	possibleSigs = extractSigs(sigPops, possibleSigs)
 	possibleSigs = extractSigs(prevPops, possibleSigs)
 
-	// Now we need to match the signatures to pubkeys, the only real way to
-	// do that is to try to verify them all and match it to the pubkey
-	// that verifies it. we then can go through the addresses in order
-	// to build our script. Anything that doesn't parse or doesn't verify we
-	// throw away.
-	addrToSig := make(map[string][]byte)
-sigLoop:
-	for _, sig := range possibleSigs {
+	// All multisig addresses should be pubkey addresses, it is an error
+	// to call this internal function with bad input.
+	pubKeys := make([]*btcec.PublicKey, 0, len(addresses))
+	for _, addr := range addresses {
+		pubKeys = append(pubKeys, addr.(*colxutil.AddressPubKey).PubKey())
+	}
+	sigs := matchMultiSigSignatures(tx, idx, pkPops, pubKeys, possibleSigs)
+
+	// Extra opcode to handle the extra arg consumed (due to previous bugs
+	// in the reference implementation).
+	builder := NewScriptBuilder().AddOp(OP_FALSE)
+	doneSigs := 0
+	// This assumes that addresses are in the same order as in the script.
+	for _, sig := range sigs {
+		if sig == nil {
+			continue
+		}
+		builder.AddData(sig)
+		doneSigs++
+		if doneSigs == nRequired {
+			break
+		}
+	}
+
+	// padding for missing ones.
+	for i := doneSigs; i < nRequired; i++ {
+		builder.AddOp(OP_0)
+	}
+
+	script, _ := builder.Script()
+	return script
+}
 
+// matchMultiSigSignatures matches the passed candidate signatures to the
+// passed public keys by verifying them against the signature hash of the
+// passed script, which is the only real way to do so.  The returned
+// signatures are in the same order as the public keys, with nil for the keys
+// which have not signed.  Candidates which don't parse or don't verify are
+// thrown away.
+//
+// Only one signature is taken per public key.  When there are several valid
+// signatures for the same key, such as signatures with different hash types,
+// the one which sorts first bytewise is taken so the result does not depend
+// on the order of the candidates.
+func matchMultiSigSignatures(tx *wire.MsgTx, idx int, pkPops []parsedOpcode,
+	pubKeys []*btcec.PublicKey, candidates [][]byte) [][]byte {
+
+	sigs := make([][]byte, len(pubKeys))
+	for _, sig := range candidates {
 		// can't have a valid signature that doesn't at least have a
 		// hashtype, in practise it is even longer than this. but
 		// that'll be checked next.
@@ -294,51 +345,164 @@ sigLoop:
 		// MultiSigTy, so we just need to hash the full thing.
 		hash := calcSignatureHash(pkPops, hashType, tx, idx)
 
-		for _, addr := range addresses {
-			// All multisig addresses should be pubkey addreses
-			// it is an error to call this internal function with
-			// bad input.
-			pkaddr := addr.(*colxutil.AddressPubKey)
-
-			pubKey := pkaddr.PubKey()
-
-			// If it matches we put it in the map. We only
-			// can take one signature per public key so if we
-			// already have one, we can throw this away.
-			if pSig.Verify(hash, pubKey) {
-				aStr := addr.EncodeAddress()
-				if _, ok := addrToSig[aStr]; !ok {
-					addrToSig[aStr] = sig
-				}
-				continue sigLoop
+		for i, pubKey := range pubKeys {
+			if !pSig.Verify(hash, pubKey) {
+				continue
+			}
+			if sigs[i] == nil || bytes.Compare(sig, sigs[i]) < 0 {
+				sigs[i] = sig
 			}
+			break
 		}
 	}
+	return sigs
+}
 
-	// Extra opcode to handle the extra arg consumed (due to previous bugs
-	// in the reference implementation).
-	builder := NewScriptBuilder().AddOp(OP_FALSE)
-	doneSigs := 0
-	// This assumes that addresses are in the same order as in the script.
-	for _, addr := range addresses {
-		sig, ok := addrToSig[addr.EncodeAddress()]
-		if !ok {
-			continue
+// InputSigStatus describes the signatures provided by the signature script of
+// a transaction input as reported by AnalyzeSignedInput.
+type InputSigStatus struct {
+	// Class is the class of the script the signatures are for, which is
+	// the class of the redeem script for pay-to-script-hash outputs.
+	Class ScriptClass
+
+	// RedeemScript is the redeem script of a pay-to-script-hash output or
+	// nil for other outputs.
+	RedeemScript []byte
+
+	// Required is the number of signatures needed to spend the output.
+	Required int
+
+	// PubKeys holds the public keys which are able to sign, in the order
+	// they appear in the script.  It is empty for a pay-to-pubkey-hash
+	// output until the public key is provided by the signature script.
+	PubKeys []*btcec.PublicKey
+
+	// Signatures holds the valid signature, including the hash type, of
+	// each of the public keys, or nil for the keys which have not signed.
+	Signatures [][]byte
+
+	// Present is the number of valid signatures.
+	Present int
+}
+
+// Complete returns whether the input has all of the signatures it requires.
+func (s *InputSigStatus) Complete() bool {
+	return s.Present >= s.Required
+}
+
+// MissingPubKeys returns the public keys which have not signed the input.
+func (s *InputSigStatus) MissingPubKeys() []*btcec.PublicKey {
+	var missing []*btcec.PublicKey
+	for i, sig := range s.Signatures {
+		if sig == nil {
+			missing = append(missing, s.PubKeys[i])
 		}
-		builder.AddData(sig)
-		doneSigs++
-		if doneSigs == nRequired {
-			break
+	}
+	return missing
+}
+
+// AnalyzeSignedInput reports which of the signatures required to spend the
+// output with the passed public key script are provided by the passed
+// signature script for input idx of the transaction.  It supports
+// pay-to-pubkey, pay-to-pubkey-hash, and multi-signature scripts, either bare
+// or as the redeem script of a pay-to-script-hash output, which makes it
+// possible to find out which signers still need to contribute to a partially
+// signed multi-signature input.
+//
+// Only the signatures which verify against one of the public keys are counted
+// as present, so signatures for a different transaction or the padding of
+// missing signatures are ignored.
+func AnalyzeSignedInput(pkScript, sigScript []byte, tx *wire.MsgTx, idx int) (*InputSigStatus, error) {
+	if idx < 0 || idx >= len(tx.TxIn) {
+		return nil, ErrInvalidIndex
+	}
+	pkPops, err := parseScript(pkScript)
+	if err != nil {
+		return nil, err
+	}
+	sigPops, err := parseScript(sigScript)
+	if err != nil {
+		return nil, err
+	}
+	if !isPushOnly(sigPops) {
+		return nil, ErrStackNonPushOnly
+	}
+
+	// The signatures of a pay-to-script-hash output are for the redeem
+	// script, which is the final push of the signature script.
+	status := &InputSigStatus{}
+	if isScriptHash(pkPops) {
+		if len(sigPops) == 0 {
+			return nil, ErrMissingRedeemScript
+		}
+		redeemScript := sigPops[len(sigPops)-1].data
+		if !bytes.Equal(colxutil.Hash160(redeemScript), pkPops[1].data) {
+			return nil, ErrRedeemScriptMismatch
+		}
+		status.RedeemScript = redeemScript
+		sigPops = sigPops[:len(sigPops)-1]
+		pkPops, err = parseScript(redeemScript)
+		if err != nil {
+			return nil, err
 		}
 	}
 
-	// padding for missing ones.
-	for i := doneSigs; i < nRequired; i++ {
-		builder.AddOp(OP_0)
+	var candidates [][]byte
+	for _, pop := range sigPops {
+		if len(pop.data) != 0 {
+			candidates = append(candidates, pop.data)
+		}
 	}
 
-	script, _ := builder.Script()
-	return script
+	script, _ := unparseScript(pkPops)
+	numSigs, _, pubKeys, err := ExtractMultisigDetails(script)
+	switch {
+	case err == nil:
+		status.Class = MultiSigTy
+		status.Required = numSigs
+		status.PubKeys = pubKeys
+
+	case err != ErrNotMultisigScript:
+		return nil, err
+
+	case isPubkey(pkPops):
+		pubKey, err := btcec.ParsePubKey(pkPops[0].data, btcec.S256())
+		if err != nil {
+			return nil, err
+		}
+		status.Class = PubKeyTy
+		status.Required = 1
+		status.PubKeys = []*btcec.PublicKey{pubKey}
+
+	case isPubkeyHash(pkPops):
+		// The public key is the second push of the signature script
+		// once it is signed.
+		status.Class = PubKeyHashTy
+		status.Required = 1
+		if len(sigPops) != 2 || !bytes.Equal(
+			colxutil.Hash160(sigPops[1].data), pkPops[2].data) {
+
+			return status, nil
+		}
+		pubKey, err := btcec.ParsePubKey(sigPops[1].data, btcec.S256())
+		if err != nil {
+			return status, nil
+		}
+		status.PubKeys = []*btcec.PublicKey{pubKey}
+		candidates = [][]byte{sigPops[0].data}
+
+	default:
+		return nil, ErrUnsupportedSignedScript
+	}
+
+	status.Signatures = matchMultiSigSignatures(tx, idx, pkPops,
+		status.PubKeys, candidates)
+	for _, sig := range status.Signatures {
+		if sig != nil {
+			status.Present++
+		}
+	}
+	return status, nil
 }
 
 // KeyDB is an interface type provided to SignTxOutput, it encapsulates
@@ -6,6 +6,7 @@
 package txscript_test
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"testing"
@@ -1713,3 +1714,280 @@ nexttest:
 		}
 	}
 }
+
+// TestPartialMultiSigSigning runs a 2-of-3 multi-signature workflow where two
+// independent signers, each only holding their own key, contribute signatures
+// to a pay-to-script-hash input.  It ensures AnalyzeSignedInput reports the
+// signatures which are present and missing after each step, that merging the
+// signatures is independent of their order and idempotent, and that the final
+// script validates.
+func TestPartialMultiSigSigning(t *testing.T) {
+	t.Parallel()
+
+	params := &chaincfg.TestNet3Params
+	keys := make([]*btcec.PrivateKey, 3)
+	addrs := make([]*colxutil.AddressPubKey, 3)
+	for i := range keys {
+		key, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatalf("failed to make key %d: %v", i, err)
+		}
+		keys[i] = key
+		addrs[i], err = colxutil.NewAddressPubKey(
+			key.PubKey().SerializeCompressed(), params)
+		if err != nil {
+			t.Fatalf("failed to make address %d: %v", i, err)
+		}
+	}
+	redeemScript, err := txscript.MultiSigScript(addrs, 2)
+	if err != nil {
+		t.Fatalf("failed to make redeem script: %v", err)
+	}
+	scriptAddr, err := colxutil.NewAddressScriptHash(redeemScript, params)
+	if err != nil {
+		t.Fatalf("failed to make p2sh address: %v", err)
+	}
+	pkScript, err := txscript.PayToAddrScript(scriptAddr)
+	if err != nil {
+		t.Fatalf("failed to make pkscript: %v", err)
+	}
+	getScript := mkGetScript(map[string][]byte{
+		scriptAddr.EncodeAddress(): redeemScript,
+	})
+
+	tx := &wire.MsgTx{
+		Version: 1,
+		TxIn: []*wire.TxIn{{
+			PreviousOutPoint: wire.OutPoint{Hash: wire.ShaHash{1}},
+			Sequence:         wire.MaxTxInSequenceNum,
+		}},
+		TxOut: []*wire.TxOut{{Value: 1, PkScript: pkScript}},
+	}
+
+	// signWith signs the input with the key at the passed index only and
+	// merges the result with the passed previous signature script.
+	signWith := func(keyIdx int, hashType txscript.SigHashType, prev []byte) []byte {
+		sigScript, err := txscript.SignTxOutput(params, tx, 0, pkScript,
+			hashType, mkGetKey(map[string]addressToKey{
+				addrs[keyIdx].EncodeAddress(): {keys[keyIdx], true},
+			}), getScript, prev)
+		if err != nil {
+			t.Fatalf("failed to sign with key %d: %v", keyIdx, err)
+		}
+		return sigScript
+	}
+
+	// assertStatus ensures the passed signature script provides
+	// signatures for exactly the keys at the passed indices.
+	assertStatus := func(desc string, sigScript []byte, signed ...int) {
+		status, err := txscript.AnalyzeSignedInput(pkScript, sigScript,
+			tx, 0)
+		if err != nil {
+			t.Fatalf("%s: AnalyzeSignedInput: %v", desc, err)
+		}
+		if status.Class != txscript.MultiSigTy || status.Required != 2 ||
+			len(status.PubKeys) != 3 ||
+			!bytes.Equal(status.RedeemScript, redeemScript) {
+
+			t.Fatalf("%s: unexpected status %+v", desc, status)
+		}
+		if status.Present != len(signed) {
+			t.Fatalf("%s: unexpected number of signatures - got "+
+				"%d, want %d", desc, status.Present, len(signed))
+		}
+		for i, pubKey := range status.PubKeys {
+			if !pubKey.IsEqual(keys[i].PubKey()) {
+				t.Fatalf("%s: unexpected public key %d", desc, i)
+			}
+			want := false
+			for _, idx := range signed {
+				want = want || idx == i
+			}
+			if got := status.Signatures[i] != nil; got != want {
+				t.Fatalf("%s: key %d signed %v, want %v", desc,
+					i, got, want)
+			}
+		}
+		if status.Complete() != (len(signed) >= 2) {
+			t.Fatalf("%s: unexpected completeness %v", desc,
+				status.Complete())
+		}
+		if len(status.MissingPubKeys()) != 3-len(signed) {
+			t.Fatalf("%s: unexpected missing public keys %d", desc,
+				len(status.MissingPubKeys()))
+		}
+	}
+
+	// The first signer signs with the third key and the result is
+	// normalized so merging it again does not change it.
+	sigScript := signWith(2, txscript.SigHashAll, nil)
+	assertStatus("first signer", sigScript, 2)
+	if checkScripts("first signer", tx, 0, sigScript, pkScript) == nil {
+		t.Fatalf("partially signed script is valid")
+	}
+	again := signWith(2, txscript.SigHashAll, sigScript)
+	if !bytes.Equal(again, sigScript) {
+		t.Fatalf("merging the same signature changed the script")
+	}
+
+	// The second signer independently signs with the first key, and
+	// merging both partial scripts in either order gives the same script.
+	otherScript := signWith(0, txscript.SigHashAll, nil)
+	assertStatus("second signer", otherScript, 0)
+	merged := signWith(0, txscript.SigHashAll, sigScript)
+	reversed := signWith(2, txscript.SigHashAll, otherScript)
+	if !bytes.Equal(merged, reversed) {
+		t.Fatalf("merged scripts depend on the order of the merge")
+	}
+	assertStatus("merged", merged, 0, 2)
+	if !bytes.Equal(signWith(0, txscript.SigHashAll, merged), merged) {
+		t.Fatalf("repeated merge changed the script")
+	}
+
+	// Signatures of the same key with different hash types are merged to
+	// the same one regardless of which is merged into the other.
+	singleScript := signWith(0, txscript.SigHashSingle, sigScript)
+	if !bytes.Equal(signWith(0, txscript.SigHashAll, singleScript),
+		signWith(0, txscript.SigHashSingle, merged)) {
+
+		t.Fatalf("merged signatures with different hash types " +
+			"depend on the order of the merge")
+	}
+
+	if err := checkScripts("merged", tx, 0, merged, pkScript); err != nil {
+		t.Fatalf("fully signed script is invalid: %v", err)
+	}
+}
+
+// TestAnalyzeSignedInput ensures AnalyzeSignedInput reports the signatures of
+// pay-to-pubkey and pay-to-pubkey-hash inputs, ignores signatures which do not
+// verify, and rejects scripts it can not analyze.
+func TestAnalyzeSignedInput(t *testing.T) {
+	t.Parallel()
+
+	params := &chaincfg.TestNet3Params
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatalf("failed to make key: %v", err)
+	}
+	pubKey := key.PubKey().SerializeCompressed()
+	pkAddr, err := colxutil.NewAddressPubKey(pubKey, params)
+	if err != nil {
+		t.Fatalf("failed to make address: %v", err)
+	}
+	pkhAddr := pkAddr.AddressPubKeyHash()
+	p2pkScript, _ := txscript.PayToAddrScript(pkAddr)
+	p2pkhScript, _ := txscript.PayToAddrScript(pkhAddr)
+
+	tx := &wire.MsgTx{
+		Version: 1,
+		TxIn: []*wire.TxIn{{
+			PreviousOutPoint: wire.OutPoint{Hash: wire.ShaHash{1}},
+			Sequence:         wire.MaxTxInSequenceNum,
+		}},
+		TxOut: []*wire.TxOut{{Value: 1, PkScript: p2pkhScript}},
+	}
+	otherTx := tx.Copy()
+	otherTx.TxOut[0].Value = 2
+
+	getKey := mkGetKey(map[string]addressToKey{
+		pkAddr.EncodeAddress():  {key, true},
+		pkhAddr.EncodeAddress(): {key, true},
+	})
+	sign := func(tx *wire.MsgTx, pkScript []byte) []byte {
+		sigScript, err := txscript.SignTxOutput(params, tx, 0, pkScript,
+			txscript.SigHashAll, getKey, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to sign: %v", err)
+		}
+		return sigScript
+	}
+
+	tests := []struct {
+		name      string
+		pkScript  []byte
+		sigScript []byte
+		class     txscript.ScriptClass
+		pubKeys   int
+		present   int
+	}{
+		{"unsigned p2pk", p2pkScript, nil, txscript.PubKeyTy, 1, 0},
+		{"signed p2pk", p2pkScript, sign(tx, p2pkScript),
+			txscript.PubKeyTy, 1, 1},
+		{"p2pk signed for other tx", p2pkScript,
+			sign(otherTx, p2pkScript), txscript.PubKeyTy, 1, 0},
+		{"unsigned p2pkh", p2pkhScript, nil, txscript.PubKeyHashTy,
+			0, 0},
+		{"signed p2pkh", p2pkhScript, sign(tx, p2pkhScript),
+			txscript.PubKeyHashTy, 1, 1},
+		{"p2pkh signed for other tx", p2pkhScript,
+			sign(otherTx, p2pkhScript), txscript.PubKeyHashTy, 1, 0},
+	}
+	for _, test := range tests {
+		status, err := txscript.AnalyzeSignedInput(test.pkScript,
+			test.sigScript, tx, 0)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		if status.Class != test.class || status.Required != 1 ||
+			len(status.PubKeys) != test.pubKeys ||
+			status.Present != test.present {
+
+			t.Errorf("%s: unexpected status %+v", test.name, status)
+		}
+	}
+
+	p2shScript, _ := txscript.PayToAddrScript(mustScriptHashAddr(t,
+		p2pkScript))
+	pushScript := func(data []byte) []byte {
+		script, _ := txscript.NewScriptBuilder().AddData(data).Script()
+		return script
+	}
+	errTests := []struct {
+		name      string
+		pkScript  []byte
+		sigScript []byte
+		idx       int
+		err       error
+	}{
+		{"invalid index", p2pkScript, nil, 1, txscript.ErrInvalidIndex},
+		{"non push only", p2pkScript, []byte{txscript.OP_NOP}, 0,
+			txscript.ErrStackNonPushOnly},
+		{"missing redeem script", p2shScript, nil, 0,
+			txscript.ErrMissingRedeemScript},
+		{"mismatched redeem script", p2shScript,
+			pushScript(p2pkhScript), 0,
+			txscript.ErrRedeemScriptMismatch},
+		{"nulldata", []byte{txscript.OP_RETURN}, nil, 0,
+			txscript.ErrUnsupportedSignedScript},
+	}
+	for _, test := range errTests {
+		_, err := txscript.AnalyzeSignedInput(test.pkScript,
+			test.sigScript, tx, test.idx)
+		if err != test.err {
+			t.Errorf("%s: unexpected error - got %v, want %v",
+				test.name, err, test.err)
+		}
+	}
+
+	// A pay-to-pubkey redeem script is analyzed like a bare one.
+	status, err := txscript.AnalyzeSignedInput(p2shScript,
+		pushScript(p2pkScript), tx, 0)
+	if err != nil || status.Class != txscript.PubKeyTy ||
+		status.Present != 0 || !bytes.Equal(status.RedeemScript, p2pkScript) {
+
+		t.Errorf("p2sh p2pk: unexpected status %+v (%v)", status, err)
+	}
+}
+
+// mustScriptHashAddr returns the pay-to-script-hash address of the passed
+// redeem script.
+func mustScriptHashAddr(t *testing.T, redeemScript []byte) colxutil.Address {
+	addr, err := colxutil.NewAddressScriptHash(redeemScript,
+		&chaincfg.TestNet3Params)
+	if err != nil {
+		t.Fatalf("failed to make p2sh address: %v", err)
+	}
+	return addr
+}
@@ -6,6 +6,9 @@
 package txscript
 
 import (
+	"sync/atomic"
+
+	"github.com/tinhnguyenhn/colxd/btcec"
 	"github.com/tinhnguyenhn/colxd/chaincfg"
 	"github.com/tinhnguyenhn/colxutil"
 )
@@ -15,6 +18,11 @@ const (
 	// data to be considered a nulldata transaction
 	MaxDataCarrierSize = 80
 
+	// DefaultMaxNullDataSize is the default maximum size in bytes of a
+	// script to be considered a nulldata script.  It allows an OP_RETURN
+	// followed by a push of MaxDataCarrierSize bytes of data.
+	DefaultMaxNullDataSize = MaxDataCarrierSize + 3
+
 	// StandardVerifyFlags are the script flags which are used when
 	// executing transaction scripts to enforce additional checks which
 	// are required for the script to be considered standard.  These checks
@@ -33,6 +41,7 @@ const (
 		ScriptDiscourageUpgradableNops |
 		ScriptVerifyCleanStack |
 		ScriptVerifyCheckLockTimeVerify |
+		ScriptVerifyCheckSequenceVerify |
 		ScriptVerifyLowS
 )
 
@@ -125,21 +134,45 @@ func isMultiSig(pops []parsedOpcode) bool {
 	return true
 }
 
+// maxNullDataSize is the maximum size in bytes of a script to be considered a
+// nulldata script.  It is accessed atomically.
+var maxNullDataSize int32 = DefaultMaxNullDataSize
+
+// SetMaxNullDataSize sets the maximum size in bytes of a script to be considered
+// a nulldata script to the passed value.  Larger scripts which would otherwise
+// be nulldata scripts are considered non-standard.  This allows the memory
+// pool policy to limit the amount of data carried by transaction outputs.  The
+// limit defaults to DefaultMaxNullDataSize.
+//
+// This function is safe for concurrent access.
+func SetMaxNullDataSize(size int) {
+	atomic.StoreInt32(&maxNullDataSize, int32(size))
+}
+
+// MaxNullDataSize returns the maximum size in bytes of a script to be
+// considered a nulldata script as set by SetMaxNullDataSize.
+//
+// This function is safe for concurrent access.
+func MaxNullDataSize() int {
+	return int(atomic.LoadInt32(&maxNullDataSize))
+}
+
 // isNullData returns true if the passed script is a null data transaction,
 // false otherwise.
 func isNullData(pops []parsedOpcode) bool {
 	// A nulldata transaction is either a single OP_RETURN or an
-	// OP_RETURN SMALLDATA (where SMALLDATA is a data push up to
-	// MaxDataCarrierSize bytes).
+	// OP_RETURN SMALLDATA (where SMALLDATA is a data push) which is no
+	// larger than MaxNullDataSize bytes.
 	l := len(pops)
+	maxSize := MaxNullDataSize()
 	if l == 1 && pops[0].opcode.value == OP_RETURN {
-		return true
+		return maxSize >= 1
 	}
 
 	return l == 2 &&
 		pops[0].opcode.value == OP_RETURN &&
 		pops[1].opcode.value <= OP_PUSHDATA4 &&
-		len(pops[1].data) <= MaxDataCarrierSize
+		1+parsedOpcodeLen(&pops[1]) <= maxSize
 }
 
 // scriptType returns the type of the script being inspected from the known
@@ -170,6 +203,26 @@ func GetScriptClass(script []byte) ScriptClass {
 	return typeOfScript(pops)
 }
 
+// ClassifyScript returns the class of the script passed along with, for
+// multi-signature scripts, the number of public keys and signatures.  The
+// numbers are taken from the opcodes parsed to classify the script, so unlike
+// calling GetScriptClass followed by CalcMultiSigStats, the script is only
+// parsed once.  They are zero for scripts of other classes.
+//
+// NonStandardTy will be returned when the script does not parse.
+func ClassifyScript(script []byte) (ScriptClass, int, int) {
+	pops, err := parseScript(script)
+	if err != nil {
+		return NonStandardTy, 0, 0
+	}
+	class := typeOfScript(pops)
+	if class != MultiSigTy {
+		return class, 0, 0
+	}
+	numPubKeys, numSigs := calcMultiSigStats(pops)
+	return class, numPubKeys, numSigs
+}
+
 // expectedInputs returns the number of arguments required by a script.
 // If the script is of unknown type such that the number can not be determined
 // then -1 is returned. We are an internal function and thus assume that class
@@ -276,31 +329,104 @@ func CalcScriptInfo(sigScript, pkScript []byte, bip16 bool) (*ScriptInfo, error)
 	return si, nil
 }
 
+// calcMultiSigStats returns the number of public keys and signatures from the
+// passed parsed opcodes of a multi-signature transaction script.  The opcodes
+// MUST already be known to be a multi-signature script of at least 4 opcodes.
+func calcMultiSigStats(pops []parsedOpcode) (int, int) {
+	// A multi-signature script is of the pattern:
+	//  NUM_SIGS PUBKEY PUBKEY PUBKEY... NUM_PUBKEYS OP_CHECKMULTISIG
+	// Therefore the number of signatures is the oldest item on the stack
+	// and the number of pubkeys is the 2nd to last.
+	numSigs := asSmallInt(pops[0].opcode)
+	numPubKeys := asSmallInt(pops[len(pops)-2].opcode)
+	return numPubKeys, numSigs
+}
+
 // CalcMultiSigStats returns the number of public keys and signatures from
 // a multi-signature transaction script.  The passed script MUST already be
-// known to be a multi-signature script.
+// known to be a multi-signature script.  ClassifyScript avoids parsing the
+// script again when its class is not known yet.
 func CalcMultiSigStats(script []byte) (int, int, error) {
 	pops, err := parseScript(script)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	// A multi-signature script is of the pattern:
-	//  NUM_SIGS PUBKEY PUBKEY PUBKEY... NUM_PUBKEYS OP_CHECKMULTISIG
-	// Therefore the number of signatures is the oldest item on the stack
-	// and the number of pubkeys is the 2nd to last.  Also, the absolute
-	// minimum for a multi-signature script is 1 pubkey, so at least 4
-	// items must be on the stack per:
+	// The absolute minimum for a multi-signature script is 1 pubkey, so at
+	// least 4 items must be on the stack per:
 	//  OP_1 PUBKEY OP_1 OP_CHECKMULTISIG
 	if len(pops) < 4 {
 		return 0, 0, ErrStackUnderflow
 	}
 
-	numSigs := asSmallInt(pops[0].opcode)
-	numPubKeys := asSmallInt(pops[len(pops)-2].opcode)
+	numPubKeys, numSigs := calcMultiSigStats(pops)
 	return numPubKeys, numSigs, nil
 }
 
+// multiSigCount returns the number pushed by the passed parsed opcode of a
+// multi-signature script, which is either a small integer opcode or, for
+// numbers above 16, a minimally encoded data push.  False is returned when
+// the opcode does not push a number in either form.
+func multiSigCount(pop *parsedOpcode) (int, bool) {
+	if isSmallInt(pop.opcode) {
+		return asSmallInt(pop.opcode), true
+	}
+	if pop.opcode.value > OP_PUSHDATA4 || pop.checkMinimalDataPush() != nil {
+		return 0, false
+	}
+	num, err := MakeScriptNum(pop.data, true, DefaultScriptNumLen)
+	if err != nil {
+		return 0, false
+	}
+	return int(num.Int32()), true
+}
+
+// ExtractMultisigDetails returns the number of required signatures, the number
+// of public keys, and the parsed public keys of the passed multi-signature
+// script, such as the redeem script of a multi-signature pay-to-script-hash
+// output.  Unlike the scripts classified as MultiSigTy, the numbers of
+// signatures and public keys may be up to MaxPubKeysPerMultiSig, so numbers
+// above 16 may be pushed as minimally encoded data, which is how
+// MultiSigScript encodes them.
+//
+// ErrNotMultisigScript is returned when the script is not of the form
+// <m> <pubkey>... <n> OP_CHECKMULTISIG with at least one required signature
+// and 1 to MaxPubKeysPerMultiSig public keys, ErrBadNumRequired when m is
+// larger than n, and ErrMultiSigInvalidPubKey when any of the public keys does
+// not parse.
+func ExtractMultisigDetails(script []byte) (int, int, []*btcec.PublicKey, error) {
+	pops, err := parseScript(script)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	l := len(pops)
+	if l < 4 || pops[l-1].opcode.value != OP_CHECKMULTISIG {
+		return 0, 0, nil, ErrNotMultisigScript
+	}
+	numSigs, ok := multiSigCount(&pops[0])
+	if !ok || numSigs < 1 {
+		return 0, 0, nil, ErrNotMultisigScript
+	}
+	numPubKeys, ok := multiSigCount(&pops[l-2])
+	if !ok || numPubKeys != l-3 || numPubKeys > MaxPubKeysPerMultiSig {
+		return 0, 0, nil, ErrNotMultisigScript
+	}
+	if numSigs > numPubKeys {
+		return 0, 0, nil, ErrBadNumRequired
+	}
+
+	pubKeys := make([]*btcec.PublicKey, 0, numPubKeys)
+	for _, pop := range pops[1 : l-2] {
+		pubKey, err := btcec.ParsePubKey(pop.data, btcec.S256())
+		if err != nil {
+			return 0, 0, nil, ErrMultiSigInvalidPubKey
+		}
+		pubKeys = append(pubKeys, pubKey)
+	}
+	return numSigs, numPubKeys, pubKeys, nil
+}
+
 // payToPubKeyHashScript creates a new script to pay a transaction
 // output to a 20-byte pubkey hash. It is expected that the input is a valid
 // hash.
@@ -401,8 +527,9 @@ func PushedData(script []byte) ([][]byte, error) {
 
 // ExtractPkScriptAddrs returns the type of script, addresses and required
 // signatures associated with the passed PkScript.  Note that it only works for
-// 'standard' transaction script types.  Any data such as public keys which are
-// invalid are omitted from the results.
+// 'standard' transaction script types, along with nonstandard scripts which
+// match a template registered via RegisterScriptTemplate.  Any data such as
+// public keys which are invalid are omitted from the results.
 func ExtractPkScriptAddrs(pkScript []byte, chainParams *chaincfg.Params) (ScriptClass, []colxutil.Address, int, error) {
 	var addrs []colxutil.Address
 	var requiredSigs int
@@ -475,8 +602,14 @@ func ExtractPkScriptAddrs(pkScript []byte, chainParams *chaincfg.Params) (Script
 		// signatures.
 
 	case NonStandardTy:
-		// Don't attempt to extract addresses or required signatures for
-		// nonstandard transactions.
+		// Only extract addresses and required signatures from
+		// nonstandard scripts which match a registered script
+		// template.
+		if tplAddrs, tplSigs, ok := matchScriptTemplates(pops,
+			chainParams); ok {
+
+			addrs, requiredSigs = tplAddrs, tplSigs
+		}
 	}
 
 	return scriptClass, addrs, requiredSigs, nil
@@ -11,6 +11,7 @@ import (
 	"reflect"
 	"testing"
 
+	"github.com/tinhnguyenhn/colxd/btcec"
 	"github.com/tinhnguyenhn/colxd/chaincfg"
 	"github.com/tinhnguyenhn/colxd/txscript"
 	"github.com/tinhnguyenhn/colxutil"
@@ -805,6 +806,244 @@ func TestCalcMultiSigStats(t *testing.T) {
 	}
 }
 
+// TestExtractMultisigDetails ensures ExtractMultisigDetails returns the expected
+// details for multi-signature scripts with up to MaxPubKeysPerMultiSig public
+// keys and the expected errors for scripts which are not multi-signature
+// scripts or contain invalid public keys.
+func TestExtractMultisigDetails(t *testing.T) {
+	t.Parallel()
+
+	pubKeys := make([]*btcec.PublicKey, txscript.MaxPubKeysPerMultiSig)
+	addrs := make([]*colxutil.AddressPubKey, txscript.MaxPubKeysPerMultiSig)
+	for i := range pubKeys {
+		privKey, err := btcec.NewPrivateKey(btcec.S256())
+		if err != nil {
+			t.Fatalf("NewPrivateKey: unexpected error: %v", err)
+		}
+		pubKeys[i] = privKey.PubKey()
+		addrs[i], err = colxutil.NewAddressPubKey(
+			pubKeys[i].SerializeCompressed(), &chaincfg.MainNetParams)
+		if err != nil {
+			t.Fatalf("NewAddressPubKey: unexpected error: %v", err)
+		}
+	}
+
+	// Every combination from 1-of-1 through 20-of-20 is extracted.
+	for n := 1; n <= txscript.MaxPubKeysPerMultiSig; n++ {
+		for m := 1; m <= n; m++ {
+			script, err := txscript.MultiSigScript(addrs[:n], m)
+			if err != nil {
+				t.Fatalf("MultiSigScript %d-of-%d: unexpected "+
+					"error: %v", m, n, err)
+			}
+			gotM, gotN, gotKeys, err :=
+				txscript.ExtractMultisigDetails(script)
+			if err != nil {
+				t.Errorf("%d-of-%d: unexpected error: %v", m, n,
+					err)
+				continue
+			}
+			if gotM != m || gotN != n || len(gotKeys) != n {
+				t.Errorf("%d-of-%d: unexpected details - got "+
+					"%d-of-%d with %d keys", m, n, gotM, gotN,
+					len(gotKeys))
+				continue
+			}
+			for i, key := range gotKeys {
+				if !key.IsEqual(pubKeys[i]) {
+					t.Errorf("%d-of-%d: unexpected key #%d",
+						m, n, i)
+				}
+			}
+		}
+	}
+
+	key := pubKeys[0].SerializeCompressed()
+	badPrefix := append([]byte{0x05}, key[1:]...)
+	notOnCurve := append([]byte{0x04}, bytes.Repeat([]byte{0x01}, 64)...)
+	tests := []struct {
+		name   string
+		script *txscript.ScriptBuilder
+		err    error
+	}{
+		{
+			name: "invalid pubkey prefix",
+			script: txscript.NewScriptBuilder().AddOp(txscript.OP_1).
+				AddData(key).AddData(badPrefix).
+				AddOp(txscript.OP_2).AddOp(txscript.OP_CHECKMULTISIG),
+			err: txscript.ErrMultiSigInvalidPubKey,
+		},
+		{
+			name: "pubkey not on curve",
+			script: txscript.NewScriptBuilder().AddOp(txscript.OP_1).
+				AddData(notOnCurve).
+				AddOp(txscript.OP_1).AddOp(txscript.OP_CHECKMULTISIG),
+			err: txscript.ErrMultiSigInvalidPubKey,
+		},
+		{
+			name: "truncated pubkey",
+			script: txscript.NewScriptBuilder().AddOp(txscript.OP_1).
+				AddData(key[:32]).
+				AddOp(txscript.OP_1).AddOp(txscript.OP_CHECKMULTISIG),
+			err: txscript.ErrMultiSigInvalidPubKey,
+		},
+		{
+			name: "more signatures than pubkeys",
+			script: txscript.NewScriptBuilder().AddOp(txscript.OP_2).
+				AddData(key).
+				AddOp(txscript.OP_1).AddOp(txscript.OP_CHECKMULTISIG),
+			err: txscript.ErrBadNumRequired,
+		},
+		{
+			name: "no signatures",
+			script: txscript.NewScriptBuilder().AddOp(txscript.OP_0).
+				AddData(key).
+				AddOp(txscript.OP_1).AddOp(txscript.OP_CHECKMULTISIG),
+			err: txscript.ErrNotMultisigScript,
+		},
+		{
+			name: "pubkey count mismatch",
+			script: txscript.NewScriptBuilder().AddOp(txscript.OP_1).
+				AddData(key).
+				AddOp(txscript.OP_2).AddOp(txscript.OP_CHECKMULTISIG),
+			err: txscript.ErrNotMultisigScript,
+		},
+		{
+			name: "non-minimal pubkey count",
+			script: txscript.NewScriptBuilder().AddOp(txscript.OP_1).
+				AddData(key).
+				AddOp(txscript.OP_DATA_1).AddOp(0x01).
+				AddOp(txscript.OP_CHECKMULTISIG),
+			err: txscript.ErrNotMultisigScript,
+		},
+		{
+			name: "no checkmultisig",
+			script: txscript.NewScriptBuilder().AddOp(txscript.OP_1).
+				AddData(key).
+				AddOp(txscript.OP_1).AddOp(txscript.OP_CHECKSIG),
+			err: txscript.ErrNotMultisigScript,
+		},
+		{
+			name:   "too short",
+			script: txscript.NewScriptBuilder().AddOp(txscript.OP_1),
+			err:    txscript.ErrNotMultisigScript,
+		},
+	}
+
+	// More than MaxPubKeysPerMultiSig public keys are not allowed.
+	builder := txscript.NewScriptBuilder().AddOp(txscript.OP_1)
+	for i := 0; i <= txscript.MaxPubKeysPerMultiSig; i++ {
+		builder.AddData(key)
+	}
+	builder.AddInt64(txscript.MaxPubKeysPerMultiSig + 1).
+		AddOp(txscript.OP_CHECKMULTISIG)
+	tests = append(tests, struct {
+		name   string
+		script *txscript.ScriptBuilder
+		err    error
+	}{"too many pubkeys", builder, txscript.ErrNotMultisigScript})
+
+	for _, test := range tests {
+		script, err := test.script.Script()
+		if err != nil {
+			t.Fatalf("%s: unexpected script error: %v", test.name, err)
+		}
+		_, _, _, err = txscript.ExtractMultisigDetails(script)
+		if err != test.err {
+			t.Errorf("%s: unexpected error - got %v, want %v",
+				test.name, err, test.err)
+		}
+	}
+}
+
+// TestClassifyScript ensures ClassifyScript returns the same class as
+// GetScriptClass along with the multi-signature statistics of multi-signature
+// scripts.
+func TestClassifyScript(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range scriptClassTests {
+		script := mustParseShortForm(test.script)
+		class, numPubKeys, numSigs := txscript.ClassifyScript(script)
+		if class != test.class {
+			t.Errorf("%s: unexpected class - got %v, want %v",
+				test.name, class, test.class)
+			continue
+		}
+		if class != txscript.MultiSigTy {
+			if numPubKeys != 0 || numSigs != 0 {
+				t.Errorf("%s: unexpected multisig stats %d, %d",
+					test.name, numPubKeys, numSigs)
+			}
+			continue
+		}
+		wantPubKeys, wantSigs, err := txscript.CalcMultiSigStats(script)
+		if err != nil {
+			t.Fatalf("%s: CalcMultiSigStats: unexpected error: %v",
+				test.name, err)
+		}
+		if numPubKeys != wantPubKeys || numSigs != wantSigs {
+			t.Errorf("%s: unexpected multisig stats - got %d, %d, "+
+				"want %d, %d", test.name, numPubKeys, numSigs,
+				wantPubKeys, wantSigs)
+		}
+	}
+}
+
+// TestMaxNullDataSize ensures scripts are only classified as nulldata scripts
+// when they are no larger than the configured maximum size.
+//
+// NOTE: This test is not run in parallel since it changes the limit used by
+// all script classification.
+func TestMaxNullDataSize(t *testing.T) {
+	defer txscript.SetMaxNullDataSize(txscript.MaxNullDataSize())
+
+	nullData := func(payloadSize int) []byte {
+		script, err := txscript.NewScriptBuilder().
+			AddOp(txscript.OP_RETURN).
+			AddData(bytes.Repeat([]byte{0x01}, payloadSize)).Script()
+		if err != nil {
+			t.Fatalf("unable to build script: %v", err)
+		}
+		return script
+	}
+
+	tests := []struct {
+		name    string
+		maxSize int
+		script  []byte
+		class   txscript.ScriptClass
+	}{
+		{"default at limit",
+			txscript.DefaultMaxNullDataSize,
+			nullData(txscript.MaxDataCarrierSize),
+			txscript.NullDataTy},
+		{"default above limit",
+			txscript.DefaultMaxNullDataSize,
+			nullData(txscript.MaxDataCarrierSize + 1),
+			txscript.NonStandardTy},
+		{"small at limit", 40, nullData(38), txscript.NullDataTy},
+		{"small above limit", 40, nullData(39), txscript.NonStandardTy},
+		{"bare return", 1, []byte{txscript.OP_RETURN},
+			txscript.NullDataTy},
+		{"empty push above limit", 1, nullData(0),
+			txscript.NonStandardTy},
+		{"disabled", 0, []byte{txscript.OP_RETURN},
+			txscript.NonStandardTy},
+		{"non-minimal push above limit", txscript.DefaultMaxNullDataSize,
+			append([]byte{txscript.OP_RETURN, txscript.OP_PUSHDATA2,
+				0x50, 0x00}, bytes.Repeat([]byte{0x01}, 80)...),
+			txscript.NonStandardTy},
+	}
+	for _, test := range tests {
+		txscript.SetMaxNullDataSize(test.maxSize)
+		if got := txscript.GetScriptClass(test.script); got != test.class {
+			t.Errorf("%s: unexpected class - got %v, want %v",
+				test.name, got, test.class)
+		}
+	}
+}
+
 // scriptClassTest houses a test used to ensure various scripts have the
 // expected class.
 type scriptClassTest struct {
@@ -0,0 +1,83 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package txscript
+
+// StepInfo describes the state of the script engine right after it executed an
+// opcode.  It is passed to the callback provided to ExecuteWithTrace or
+// SetStepCallback.
+type StepInfo struct {
+	// ScriptIdx is the index of the script the opcode is part of.  Index 0
+	// is the signature script, 1 is the public key script, and 2 is the
+	// redeem script of a pay-to-script-hash input.
+	ScriptIdx int
+
+	// OpcodeIdx is the index of the opcode within the script, which is the
+	// program counter as reported by DisasmPC.
+	OpcodeIdx int
+
+	// Opcode is the disassembly of the executed opcode.  Its Offset is the
+	// byte offset of the opcode within the script.
+	Opcode DisasmStep
+
+	// Stack and AltStack are snapshots of the data and alternate stacks
+	// where the last item is the top of the stack.
+	Stack    [][]byte
+	AltStack [][]byte
+
+	// CondStack is a snapshot of the conditional execution state where the
+	// last item is the innermost conditional.  Each item is one of
+	// OpCondFalse, OpCondTrue, or OpCondSkip.
+	CondStack []int
+
+	// Err is the error the opcode failed with, if any.  The stacks reflect
+	// the state at the time of the failure.
+	Err error
+}
+
+// SetStepCallback sets a callback which is invoked with the state of the
+// engine after every opcode executed by Step, including the opcode which
+// failed, if any.  Passing nil disables tracing.  The trace is only produced
+// while a callback is set, so normal validation is not slowed down.
+func (vm *Engine) SetStepCallback(callback func(step *StepInfo)) {
+	vm.stepCallback = callback
+}
+
+// ExecuteWithTrace executes all scripts in the script engine the same way as
+// Execute while invoking the passed callback with the state of the engine
+// after every executed opcode.
+func (vm *Engine) ExecuteWithTrace(callback func(step *StepInfo)) error {
+	prevCallback := vm.stepCallback
+	vm.stepCallback = callback
+	defer func() {
+		vm.stepCallback = prevCallback
+	}()
+	return vm.Execute()
+}
+
+// traceStep invokes the step callback with the state of the engine after the
+// opcode at the current script position was executed with the passed result.
+//
+// This function MUST only be called with a step callback set.
+func (vm *Engine) traceStep(execErr error) {
+	opcode, err := vm.disasmStep(vm.scriptIdx, vm.scriptOff)
+	if err != nil {
+		// The opcode was parsed from the script, so it can always be
+		// serialized again, but fall back to its name regardless.
+		pop := &vm.scripts[vm.scriptIdx][vm.scriptOff]
+		opcode = DisasmStep{Name: pop.opcode.name}
+	}
+	condStack := make([]int, len(vm.condStack))
+	copy(condStack, vm.condStack)
+	vm.stepCallback(&StepInfo{
+		ScriptIdx: vm.scriptIdx,
+		OpcodeIdx: vm.scriptOff,
+		Opcode:    opcode,
+		Stack:     vm.GetStack(),
+		AltStack:  vm.GetAltStack(),
+		CondStack: condStack,
+		Err:       execErr,
+	})
+}
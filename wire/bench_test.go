@@ -660,6 +660,32 @@ func BenchmarkTxSha(b *testing.B) {
 	}
 }
 
+// BenchmarkReadMessageTx performs a benchmark on how long it takes to read a
+// tx message with a message reader and get the hash of the transaction it
+// contains.
+func BenchmarkReadMessageTx(b *testing.B) {
+	var buf bytes.Buffer
+	err := WriteMessage(&buf, &genesisCoinbaseTx, ProtocolVersion, MainNet)
+	if err != nil {
+		b.Fatalf("WriteMessage: unexpected error: %v", err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+	mr := NewMessageReader(r)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.Seek(0, 0)
+		_, msg, _, err := mr.ReadMessage(ProtocolVersion, MainNet,
+			MaxMessagePayload)
+		if err != nil {
+			b.Fatalf("ReadMessage: unexpected error: %v", err)
+		}
+		if _, ok := mr.TxSha(); !ok {
+			msg.(*MsgTx).TxSha()
+		}
+	}
+}
+
 // BenchmarkDoubleSha256 performs a benchmark on how long it takes to perform a
 // double sha 256 returning a byte slice.
 func BenchmarkDoubleSha256(b *testing.B) {
@@ -691,3 +717,78 @@ func BenchmarkDoubleSha256SH(b *testing.B) {
 		_ = DoubleSha256SH(txBytes)
 	}
 }
+
+// repeatReader is an io.Reader which endlessly repeats the bytes of a message.
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+// Read reads the next bytes of the repeated message into p.
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data[r.off:])
+	r.off = (r.off + n) % len(r.data)
+	return n, nil
+}
+
+// benchmarkMessageReader performs a benchmark on how long it takes to read the
+// passed message with a MessageReader and how much it allocates.
+func benchmarkMessageReader(b *testing.B, msg Message) {
+	var buf bytes.Buffer
+	err := WriteMessage(&buf, msg, ProtocolVersion, MainNet)
+	if err != nil {
+		b.Fatalf("WriteMessage: unexpected error: %v", err)
+	}
+	mr := NewMessageReader(&repeatReader{data: buf.Bytes()})
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, _, err := mr.ReadMessage(ProtocolVersion, MainNet,
+			MaxMessagePayload)
+		if err != nil {
+			b.Fatalf("ReadMessage: unexpected error: %v", err)
+		}
+	}
+}
+
+// BenchmarkMessageReaderPing performs a benchmark on reading ping messages
+// with a MessageReader.
+func BenchmarkMessageReaderPing(b *testing.B) {
+	benchmarkMessageReader(b, NewMsgPing(123123))
+}
+
+// BenchmarkMessageReaderPong performs a benchmark on reading pong messages
+// with a MessageReader.
+func BenchmarkMessageReaderPong(b *testing.B) {
+	benchmarkMessageReader(b, NewMsgPong(123123))
+}
+
+// BenchmarkMessageReaderInv1 performs a benchmark on reading inv messages with
+// a single inventory vector with a MessageReader.
+func BenchmarkMessageReaderInv1(b *testing.B) {
+	txHash := genesisCoinbaseTx.TxSha()
+	msg := NewMsgInv()
+	msg.AddInvVect(NewInvVect(InvTypeTx, &txHash))
+	benchmarkMessageReader(b, msg)
+}
+
+// BenchmarkReadMessagePing performs a benchmark on reading ping messages with
+// ReadMessageN for comparison with BenchmarkMessageReaderPing.
+func BenchmarkReadMessagePing(b *testing.B) {
+	var buf bytes.Buffer
+	err := WriteMessage(&buf, NewMsgPing(123123), ProtocolVersion, MainNet)
+	if err != nil {
+		b.Fatalf("WriteMessage: unexpected error: %v", err)
+	}
+	r := &repeatReader{data: buf.Bytes()}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, _, _, err := ReadMessageN(r, ProtocolVersion, MainNet)
+		if err != nil {
+			b.Fatalf("ReadMessageN: unexpected error: %v", err)
+		}
+	}
+}
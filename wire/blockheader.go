@@ -32,7 +32,8 @@ type BlockHeader struct {
 	MerkleRoot ShaHash
 
 	// Time the block was created.  This is, unfortunately, encoded as a
-	// uint32 on the wire and therefore is limited to 2106.
+	// uint32 on the wire and therefore is limited to 2106.  Encoding a
+	// header with a time outside of that range fails.
 	Timestamp time.Time
 
 	// Difficulty target for the block.
@@ -51,9 +52,11 @@ func (h *BlockHeader) BlockSha() ShaHash {
 	// Encode the header and double sha256 everything prior to the number of
 	// transactions.  Ignore the error returns since there is no way the
 	// encode could fail except being out of memory which would cause a
-	// run-time panic.
+	// run-time panic.  The hash is defined for every header, so a
+	// timestamp which does not fit in the header is truncated rather than
+	// rejected like it is when the header is encoded.
 	var buf bytes.Buffer
-	_ = writeBlockHeader(&buf, 0, h)
+	_ = putBlockHeader(&buf, h, uint32(h.Timestamp.Unix()))
 
 	return DoubleSha256SH(buf.Bytes())
 }
@@ -128,8 +131,20 @@ func readBlockHeader(r io.Reader, pver uint32, bh *BlockHeader) error {
 // writeBlockHeader writes a bitcoin block header to w.  See Serialize for
 // encoding block headers to be stored to disk, such as in a database, as
 // opposed to encoding for the wire.
+//
+// A MessageError is returned when the timestamp of the header does not fit in
+// the uint32 it is encoded as.
 func writeBlockHeader(w io.Writer, pver uint32, bh *BlockHeader) error {
-	sec := uint32(bh.Timestamp.Unix())
+	sec, err := uint32Timestamp("writeBlockHeader", bh.Timestamp)
+	if err != nil {
+		return err
+	}
+	return putBlockHeader(w, bh, sec)
+}
+
+// putBlockHeader writes the passed block header to w with the passed timestamp
+// in seconds in place of the timestamp of the header.
+func putBlockHeader(w io.Writer, bh *BlockHeader, sec uint32) error {
 	err := writeElements(w, bh.Version, &bh.PrevBlock, &bh.MerkleRoot,
 		sec, bh.Bits, bh.Nonce)
 	if err != nil {
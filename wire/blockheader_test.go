@@ -255,3 +255,114 @@ func TestBlockHeaderSerialize(t *testing.T) {
 		}
 	}
 }
+
+// TestBlockHeaderTimestampRange ensures the boundary values of the timestamp
+// of a block header round trip through the header and every message which
+// contains one, and that timestamps which don't fit in the uint32 they are
+// encoded as are rejected rather than truncated.
+func TestBlockHeaderTimestampRange(t *testing.T) {
+	pver := wire.ProtocolVersion
+	newHeader := func(sec int64) *wire.BlockHeader {
+		bh := wire.NewBlockHeader(&wire.ShaHash{0x01},
+			&wire.ShaHash{0x02}, 0x1d00ffff, 0x9962e301)
+		bh.Timestamp = time.Unix(sec, 0)
+		return bh
+	}
+
+	for _, sec := range []int64{0, 1 << 31, 1<<32 - 1} {
+		bh := newHeader(sec)
+
+		var buf bytes.Buffer
+		if err := bh.Serialize(&buf); err != nil {
+			t.Errorf("Serialize(%d): unexpected error: %v", sec, err)
+			continue
+		}
+		var gotHeader wire.BlockHeader
+		err := gotHeader.Deserialize(bytes.NewReader(buf.Bytes()))
+		if err != nil || !gotHeader.Timestamp.Equal(bh.Timestamp) {
+			t.Errorf("Deserialize(%d): got %v (%v), want %v", sec,
+				gotHeader.Timestamp, err, bh.Timestamp)
+		}
+
+		msgs := []struct {
+			name   string
+			msg    wire.Message
+			decode wire.Message
+			header func(wire.Message) *wire.BlockHeader
+		}{
+			{
+				name:   "block",
+				msg:    wire.NewMsgBlock(bh),
+				decode: &wire.MsgBlock{},
+				header: func(msg wire.Message) *wire.BlockHeader {
+					return &msg.(*wire.MsgBlock).Header
+				},
+			},
+			{
+				name: "headers",
+				msg: func() wire.Message {
+					msg := wire.NewMsgHeaders()
+					msg.AddBlockHeader(bh)
+					return msg
+				}(),
+				decode: &wire.MsgHeaders{},
+				header: func(msg wire.Message) *wire.BlockHeader {
+					return msg.(*wire.MsgHeaders).Headers[0]
+				},
+			},
+			{
+				name:   "merkleblock",
+				msg:    wire.NewMsgMerkleBlock(bh),
+				decode: &wire.MsgMerkleBlock{},
+				header: func(msg wire.Message) *wire.BlockHeader {
+					return &msg.(*wire.MsgMerkleBlock).Header
+				},
+			},
+		}
+		for _, test := range msgs {
+			var buf bytes.Buffer
+			if err := test.msg.BtcEncode(&buf, pver); err != nil {
+				t.Errorf("%s BtcEncode(%d): unexpected error: %v",
+					test.name, sec, err)
+				continue
+			}
+			err := test.decode.BtcDecode(bytes.NewReader(buf.Bytes()),
+				pver)
+			if err != nil {
+				t.Errorf("%s BtcDecode(%d): unexpected error: %v",
+					test.name, sec, err)
+				continue
+			}
+			got := test.header(test.decode).Timestamp
+			if got.Unix() != sec {
+				t.Errorf("%s (%d): unexpected timestamp %d",
+					test.name, sec, got.Unix())
+			}
+		}
+	}
+
+	// Timestamps before the unix epoch and after 2106 can't be encoded.
+	for _, sec := range []int64{-1, 1 << 32} {
+		bh := newHeader(sec)
+
+		var buf bytes.Buffer
+		err := bh.Serialize(&buf)
+		if _, ok := err.(*wire.MessageError); !ok {
+			t.Errorf("Serialize(%d): unexpected error - got %v, want "+
+				"MessageError", sec, err)
+		}
+		err = bh.BtcEncode(&buf, pver)
+		if _, ok := err.(*wire.MessageError); !ok {
+			t.Errorf("BtcEncode(%d): unexpected error - got %v, want "+
+				"MessageError", sec, err)
+		}
+		err = wire.NewMsgBlock(bh).BtcEncode(&buf, pver)
+		if _, ok := err.(*wire.MessageError); !ok {
+			t.Errorf("MsgBlock.BtcEncode(%d): unexpected error - got "+
+				"%v, want MessageError", sec, err)
+		}
+
+		// The hash of the header is still defined.
+		bh.BlockSha()
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+/*
+Package bloom implements the bitcoin-specific bloom filters defined by BIP0037.
+
+A bloom filter is loaded by a remote peer via a filterload message in order to
+only be sent the transactions it is interested in.  The Filter type houses the
+loaded filter along with the functions needed to serve such a peer:
+
+  - Matches, MatchesOutPoint, and MatchTxAndUpdate determine whether or not
+    data, an outpoint, or a transaction might be contained in the filter
+  - Add, AddHash, and AddOutPoint insert data into the filter as requested by a
+    filteradd message
+  - Reload and Unload replace and remove the filter in response to filterload
+    and filterclear messages
+
+All of the exported methods of Filter are safe for concurrent access, so a
+single instance may be attached to a peer and shared by the goroutines which
+handle its messages and relay inventory to it.
+
+Filter Updates
+
+When a transaction matches the filter because a data element in the public key
+script of one of its outputs matches, the outpoint of that output is added to
+the filter depending on the update flags of the loaded filter:
+
+  - BloomUpdateNone: the filter is never updated
+  - BloomUpdateAll: the outpoint is always added
+  - BloomUpdateP2PubkeyOnly: the outpoint is only added when the output is a
+    pay-to-pubkey or bare multisig script
+
+This ensures transactions which later spend the matched outputs also match the
+filter without the remote peer having to update the filter itself.
+
+Hashing
+
+The bit offsets for each element are calculated with MurmurHash3 using a seed of
+the hash function number multiplied by 0xfba4c795 plus the tweak provided with
+the filter.
+*/
+package bloom
@@ -0,0 +1,274 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bloom_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/rand"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxd/wire/bloom"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// randBytes returns a slice of the passed size filled with data from the
+// passed random source.
+func randBytes(r *rand.Rand, size int) []byte {
+	b := make([]byte, size)
+	r.Read(b)
+	return b
+}
+
+// TestFilterFalsePositiveRate ensures filters created for a given number of
+// elements and false positive rate match every inserted element and have an
+// observed false positive rate close to the requested one.
+func TestFilterFalsePositiveRate(t *testing.T) {
+	const numElements = 1000
+	const numProbes = 20000
+
+	tests := []struct {
+		fprate float64
+	}{
+		{0.1},
+		{0.01},
+		{0.001},
+	}
+
+	r := rand.New(rand.NewSource(0x8ba4c795))
+	for _, test := range tests {
+		f := bloom.NewFilter(numElements, r.Uint32(), test.fprate,
+			wire.BloomUpdateNone)
+
+		inserted := make(map[string]struct{}, numElements)
+		for i := 0; i < numElements; i++ {
+			data := randBytes(r, 32)
+			inserted[string(data)] = struct{}{}
+			f.Add(data)
+		}
+		for data := range inserted {
+			if !f.Matches([]byte(data)) {
+				t.Fatalf("fprate %v: inserted element %x does not "+
+					"match", test.fprate, data)
+			}
+		}
+
+		// Probe with elements which were never inserted.  Allow twice
+		// the requested rate to avoid spurious failures since the
+		// observed rate is itself a random variable.
+		var falsePositives int
+		for i := 0; i < numProbes; i++ {
+			data := randBytes(r, 32)
+			if _, ok := inserted[string(data)]; ok {
+				continue
+			}
+			if f.Matches(data) {
+				falsePositives++
+			}
+		}
+		rate := float64(falsePositives) / numProbes
+		if rate > test.fprate*2 {
+			t.Errorf("fprate %v: observed false positive rate %v is "+
+				"too high", test.fprate, rate)
+		}
+	}
+}
+
+// TestFilterInsertTweak ensures inserting data into filters created with and
+// without a tweak sets the same bits as the reference implementation.
+func TestFilterInsertTweak(t *testing.T) {
+	elements := []string{
+		"99108ad8ed9bb6274d3980bab5a85c048f0950c8",
+		"b5a2c786d9ef4658287ced5914b37a1b4aa32eee",
+		"b9300670b4c5366e95b2699e8b18bc75e5f729c5",
+	}
+
+	tests := []struct {
+		tweak uint32
+		want  string
+	}{
+		{0, "03614e9b050000000000000001"},
+		{2147483649, "03ce4299050000000100008001"},
+	}
+
+	for _, test := range tests {
+		f := bloom.NewFilter(3, test.tweak, 0.01, wire.BloomUpdateAll)
+		for _, element := range elements {
+			data, err := hex.DecodeString(element)
+			if err != nil {
+				t.Fatalf("unable to decode element: %v", err)
+			}
+			f.Add(data)
+			if !f.Matches(data) {
+				t.Fatalf("tweak %d: inserted element %s does not "+
+					"match", test.tweak, element)
+			}
+		}
+
+		var buf bytes.Buffer
+		err := f.MsgFilterLoad().BtcEncode(&buf, wire.ProtocolVersion)
+		if err != nil {
+			t.Fatalf("unable to serialize filter: %v", err)
+		}
+		if got := hex.EncodeToString(buf.Bytes()); got != test.want {
+			t.Errorf("tweak %d: unexpected serialized filter - got "+
+				"%s, want %s", test.tweak, got, test.want)
+		}
+	}
+}
+
+// TestFilterEmpty ensures unloaded and empty filters never match and can be
+// added to without panicking.
+func TestFilterEmpty(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter *bloom.Filter
+	}{
+		{"unloaded", bloom.LoadFilter(nil)},
+		{"empty", bloom.LoadFilter(wire.NewMsgFilterLoad(nil, 10, 0,
+			wire.BloomUpdateAll))},
+	}
+
+	data := []byte{0x01, 0x02, 0x03}
+	for _, test := range tests {
+		test.filter.Add(data)
+		if test.filter.Matches(data) {
+			t.Errorf("%s: filter unexpectedly matches", test.name)
+		}
+		outpoint := wire.NewOutPoint(&wire.ShaHash{}, 0)
+		if test.filter.MatchesOutPoint(outpoint) {
+			t.Errorf("%s: filter unexpectedly matches outpoint",
+				test.name)
+		}
+	}
+}
+
+// fundingTx returns a transaction which pays to a pay-to-pubkey-hash and a
+// pay-to-pubkey script along with the data pushed by each of those scripts.
+func fundingTx(t *testing.T) (*colxutil.Tx, []byte, []byte) {
+	pkHash := bytes.Repeat([]byte{0x11}, 20)
+	p2pkh, err := txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).
+		AddOp(txscript.OP_HASH160).AddData(pkHash).
+		AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).
+		Script()
+	if err != nil {
+		t.Fatalf("unable to build script: %v", err)
+	}
+	pubKey := append([]byte{0x02}, bytes.Repeat([]byte{0x22}, 32)...)
+	p2pk, err := txscript.NewScriptBuilder().AddData(pubKey).
+		AddOp(txscript.OP_CHECKSIG).Script()
+	if err != nil {
+		t.Fatalf("unable to build script: %v", err)
+	}
+
+	msgTx := wire.NewMsgTx()
+	prevOut := wire.NewOutPoint(&wire.ShaHash{0x01}, 0)
+	msgTx.AddTxIn(wire.NewTxIn(prevOut, nil))
+	msgTx.AddTxOut(wire.NewTxOut(1000, p2pkh))
+	msgTx.AddTxOut(wire.NewTxOut(1000, p2pk))
+	return colxutil.NewTx(msgTx), pkHash, pubKey
+}
+
+// spendTx returns a transaction which only spends the passed outpoint and
+// contains no other data which could match a filter.
+func spendTx(outpoint *wire.OutPoint) *colxutil.Tx {
+	msgTx := wire.NewMsgTx()
+	msgTx.AddTxIn(wire.NewTxIn(outpoint, nil))
+	msgTx.AddTxOut(wire.NewTxOut(500, []byte{txscript.OP_TRUE}))
+	return colxutil.NewTx(msgTx)
+}
+
+// TestFilterUpdateFlags ensures matching a transaction adds the outpoints of
+// the matched outputs to the filter according to its update flags so
+// transactions which spend them also match.
+func TestFilterUpdateFlags(t *testing.T) {
+	tx, pkHash, pubKey := fundingTx(t)
+	p2pkhOut := wire.NewOutPoint(tx.Sha(), 0)
+	p2pkOut := wire.NewOutPoint(tx.Sha(), 1)
+
+	tests := []struct {
+		name       string
+		flags      wire.BloomUpdateType
+		data       []byte
+		p2pkhSpend bool
+		p2pkSpend  bool
+	}{
+		{"none p2pkh", wire.BloomUpdateNone, pkHash, false, false},
+		{"none p2pk", wire.BloomUpdateNone, pubKey, false, false},
+		{"all p2pkh", wire.BloomUpdateAll, pkHash, true, false},
+		{"all p2pk", wire.BloomUpdateAll, pubKey, false, true},
+		{"p2pubkeyonly p2pkh", wire.BloomUpdateP2PubkeyOnly, pkHash,
+			false, false},
+		{"p2pubkeyonly p2pk", wire.BloomUpdateP2PubkeyOnly, pubKey,
+			false, true},
+	}
+
+	for _, test := range tests {
+		f := bloom.NewFilter(10, 0, 0.000001, test.flags)
+		f.Add(test.data)
+
+		if f.MatchesOutPoint(p2pkhOut) || f.MatchesOutPoint(p2pkOut) {
+			t.Fatalf("%s: outpoint matches before the funding "+
+				"transaction", test.name)
+		}
+		if !f.MatchTxAndUpdate(tx) {
+			t.Fatalf("%s: funding transaction does not match",
+				test.name)
+		}
+
+		if got := f.MatchesOutPoint(p2pkhOut); got != test.p2pkhSpend {
+			t.Errorf("%s: p2pkh outpoint match - got %v, want %v",
+				test.name, got, test.p2pkhSpend)
+		}
+		if got := f.MatchTxAndUpdate(spendTx(p2pkhOut)); got != test.p2pkhSpend {
+			t.Errorf("%s: p2pkh spend match - got %v, want %v",
+				test.name, got, test.p2pkhSpend)
+		}
+		if got := f.MatchesOutPoint(p2pkOut); got != test.p2pkSpend {
+			t.Errorf("%s: p2pk outpoint match - got %v, want %v",
+				test.name, got, test.p2pkSpend)
+		}
+		if got := f.MatchTxAndUpdate(spendTx(p2pkOut)); got != test.p2pkSpend {
+			t.Errorf("%s: p2pk spend match - got %v, want %v",
+				test.name, got, test.p2pkSpend)
+		}
+	}
+}
+
+// TestFilterMatchTxHash ensures transactions match by hash and by data pushed
+// in their signature scripts without updating the filter.
+func TestFilterMatchTxHash(t *testing.T) {
+	tx, _, _ := fundingTx(t)
+
+	f := bloom.NewFilter(10, 0, 0.000001, wire.BloomUpdateAll)
+	f.AddHash(tx.Sha())
+	if !f.MatchTxAndUpdate(tx) {
+		t.Fatalf("transaction does not match by hash")
+	}
+	if f.MatchesOutPoint(wire.NewOutPoint(tx.Sha(), 0)) {
+		t.Fatalf("outpoint added for a transaction matched by hash")
+	}
+
+	sigData := bytes.Repeat([]byte{0x33}, 33)
+	sigScript, err := txscript.NewScriptBuilder().AddData(sigData).Script()
+	if err != nil {
+		t.Fatalf("unable to build script: %v", err)
+	}
+	spend := spendTx(wire.NewOutPoint(&wire.ShaHash{0x02}, 0))
+	spend.MsgTx().TxIn[0].SignatureScript = sigScript
+	spend = colxutil.NewTx(spend.MsgTx())
+
+	f = bloom.NewFilter(10, 0, 0.000001, wire.BloomUpdateAll)
+	if f.MatchTxAndUpdate(spend) {
+		t.Fatalf("transaction matches an empty filter")
+	}
+	f.Add(sigData)
+	if !f.MatchTxAndUpdate(spend) {
+		t.Fatalf("transaction does not match by signature script data")
+	}
+}
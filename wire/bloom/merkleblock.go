@@ -0,0 +1,198 @@
+// Copyright (c) 2013-2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bloom
+
+import (
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// merkleBlock is used to house intermediate information needed to generate a
+// wire.MsgMerkleBlock according to a filter.
+type merkleBlock struct {
+	numTx       uint32
+	allHashes   []*wire.ShaHash
+	finalHashes []*wire.ShaHash
+	matchedBits []byte
+	bits        []byte
+
+	// merkles is the merkle tree store of the block as returned by
+	// blockchain.BuildMerkleTreeStore when it is available, and
+	// levelOffsets houses the index of the first node of each level of it.
+	// The hashes of the tree are looked up in it instead of being
+	// calculated when it is set.
+	merkles      []*wire.ShaHash
+	levelOffsets []uint32
+}
+
+// setMerkleTree sets the passed merkle tree store to be used to look up the
+// hashes of the tree.  The store is ignored when it does not have the size of
+// a tree for the number of transactions of the block.
+func (m *merkleBlock) setMerkleTree(merkles []*wire.ShaHash) {
+	// The tree store has the next power of two leaves followed by each
+	// level of the tree.
+	width := uint32(1)
+	for width < m.numTx {
+		width <<= 1
+	}
+	if m.numTx == 0 || uint32(len(merkles)) != width*2-1 {
+		return
+	}
+
+	m.merkles = merkles
+	m.levelOffsets = m.levelOffsets[:0]
+	for offset := uint32(0); width > 0; width >>= 1 {
+		m.levelOffsets = append(m.levelOffsets, offset)
+		offset += width
+	}
+}
+
+// calcTreeWidth calculates and returns the the number of nodes (width) or a
+// merkle tree at the given depth-first height.
+func (m *merkleBlock) calcTreeWidth(height uint32) uint32 {
+	return (m.numTx + (1 << height) - 1) >> height
+}
+
+// calcHash returns the hash for a sub-tree given a depth-first height and
+// node position.
+func (m *merkleBlock) calcHash(height, pos uint32) *wire.ShaHash {
+	if height == 0 {
+		return m.allHashes[pos]
+	}
+	if m.merkles != nil {
+		return m.merkles[m.levelOffsets[height]+pos]
+	}
+
+	var right *wire.ShaHash
+	left := m.calcHash(height-1, pos*2)
+	if pos*2+1 < m.calcTreeWidth(height-1) {
+		right = m.calcHash(height-1, pos*2+1)
+	} else {
+		right = left
+	}
+	return blockchain.HashMerkleBranches(left, right)
+}
+
+// traverseAndBuild builds a partial merkle tree using a recursive depth-first
+// approach.  As it calculates the hashes, it also saves whether or not each
+// node is a parent node and a list of final hashes to be included in the
+// merkle block.
+func (m *merkleBlock) traverseAndBuild(height, pos uint32) {
+	// Determine whether this node is a parent of a matched node.
+	var isParent byte
+	for i := pos << height; i < (pos+1)<<height && i < m.numTx; i++ {
+		isParent |= m.matchedBits[i]
+	}
+	m.bits = append(m.bits, isParent)
+
+	// When the node is a leaf node or not a parent of a matched node,
+	// append the hash to the list that will be part of the final merkle
+	// block.
+	if height == 0 || isParent == 0x00 {
+		m.finalHashes = append(m.finalHashes, m.calcHash(height, pos))
+		return
+	}
+
+	// At this point, the node is an internal node and it is the parent of
+	// of an included leaf node.
+
+	// Descend into the left child and process its sub-tree.
+	m.traverseAndBuild(height-1, pos*2)
+
+	// Descend into the right child and process its sub-tree if
+	// there is one.
+	if pos*2+1 < m.calcTreeWidth(height-1) {
+		m.traverseAndBuild(height-1, pos*2+1)
+	}
+}
+
+// NewMerkleBlock returns a new *wire.MsgMerkleBlock and an array of the matched
+// transaction index numbers based on the passed block and filter.
+func NewMerkleBlock(block *colxutil.Block, filter *Filter) (*wire.MsgMerkleBlock, []uint32) {
+	numTx := uint32(len(block.Transactions()))
+	mBlock := merkleBlock{
+		numTx:       numTx,
+		allHashes:   make([]*wire.ShaHash, 0, numTx),
+		matchedBits: make([]byte, 0, numTx),
+	}
+
+	// Find and keep track of any transactions that match the filter.
+	var matchedIndices []uint32
+	for txIndex, tx := range block.Transactions() {
+		if filter.MatchTxAndUpdate(tx) {
+			mBlock.matchedBits = append(mBlock.matchedBits, 0x01)
+			matchedIndices = append(matchedIndices, uint32(txIndex))
+		} else {
+			mBlock.matchedBits = append(mBlock.matchedBits, 0x00)
+		}
+		mBlock.allHashes = append(mBlock.allHashes, tx.Sha())
+	}
+
+	return mBlock.build(&block.MsgBlock().Header), matchedIndices
+}
+
+// NewMerkleBlockWithTxs returns a new *wire.MsgMerkleBlock which proves the
+// inclusion of the transactions with the passed hashes in the passed block, as
+// used by the gettxoutproof RPC, along with an array of their transaction
+// index numbers.
+//
+// The merkle tree store of the block as returned by
+// blockchain.BuildMerkleTreeStore may be passed so the hashes of the tree are
+// looked up in it instead of being calculated, which is considerably faster
+// when proofs for many transactions of the same block are requested.
+// Otherwise, nil may be passed.
+func NewMerkleBlockWithTxs(block *colxutil.Block, txHashes map[wire.ShaHash]struct{}, merkles []*wire.ShaHash) (*wire.MsgMerkleBlock, []uint32) {
+	numTx := uint32(len(block.Transactions()))
+	mBlock := merkleBlock{
+		numTx:       numTx,
+		allHashes:   make([]*wire.ShaHash, 0, numTx),
+		matchedBits: make([]byte, 0, numTx),
+	}
+	mBlock.setMerkleTree(merkles)
+
+	// Find and keep track of the requested transactions.
+	var matchedIndices []uint32
+	for txIndex, tx := range block.Transactions() {
+		if _, ok := txHashes[*tx.Sha()]; ok {
+			mBlock.matchedBits = append(mBlock.matchedBits, 0x01)
+			matchedIndices = append(matchedIndices, uint32(txIndex))
+		} else {
+			mBlock.matchedBits = append(mBlock.matchedBits, 0x00)
+		}
+		mBlock.allHashes = append(mBlock.allHashes, tx.Sha())
+	}
+
+	return mBlock.build(&block.MsgBlock().Header), matchedIndices
+}
+
+// build builds the depth-first partial merkle tree for the matched
+// transactions and returns the merkle block with the passed header for it.
+func (m *merkleBlock) build(header *wire.BlockHeader) *wire.MsgMerkleBlock {
+	// Calculate the number of merkle branches (height) in the tree.
+	height := uint32(0)
+	for m.calcTreeWidth(height) > 1 {
+		height++
+	}
+
+	// Build the depth-first partial merkle tree.
+	m.traverseAndBuild(height, 0)
+
+	// Create and return the merkle block.
+	msgMerkleBlock := wire.MsgMerkleBlock{
+		Header:       *header,
+		Transactions: m.numTx,
+		Hashes:       make([]*wire.ShaHash, 0, len(m.finalHashes)),
+		Flags:        make([]byte, (len(m.bits)+7)/8),
+	}
+	for _, hash := range m.finalHashes {
+		msgMerkleBlock.AddTxHash(hash)
+	}
+	for i := uint32(0); i < uint32(len(m.bits)); i++ {
+		msgMerkleBlock.Flags[i/8] |= m.bits[i] << (i % 8)
+	}
+	return &msgMerkleBlock
+}
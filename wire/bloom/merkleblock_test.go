@@ -0,0 +1,166 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package bloom_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/tinhnguyenhn/colxd/blockchain"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxd/wire/bloom"
+	"github.com/tinhnguyenhn/colxutil"
+)
+
+// merkleTestBlock returns a block with the passed number of distinct
+// transactions and a correct merkle root.
+func merkleTestBlock(numTx int) *colxutil.Block {
+	msgBlock := wire.NewMsgBlock(&wire.BlockHeader{
+		Version:   1,
+		Timestamp: time.Unix(1231006505, 0),
+	})
+	txns := make([]*colxutil.Tx, 0, numTx)
+	for i := 0; i < numTx; i++ {
+		tx := wire.NewMsgTx()
+		tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: uint32(i)}, nil))
+		tx.AddTxOut(wire.NewTxOut(int64(i), nil))
+		msgBlock.AddTransaction(tx)
+		txns = append(txns, colxutil.NewTx(tx))
+	}
+	merkles := blockchain.BuildMerkleTreeStore(txns)
+	msgBlock.Header.MerkleRoot = *merkles[len(merkles)-1]
+	return colxutil.NewBlock(msgBlock)
+}
+
+// serializeMerkleBlock returns the serialized passed merkle block.
+func serializeMerkleBlock(t *testing.T, msg *wire.MsgMerkleBlock) []byte {
+	var buf bytes.Buffer
+	if err := msg.BtcEncode(&buf, wire.ProtocolVersion); err != nil {
+		t.Fatalf("BtcEncode: unexpected error: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestNewMerkleBlockWithTxs ensures the merkle blocks proving the inclusion of
+// the requested transactions are the same whether or not the merkle tree
+// store of the block is provided and match the ones created from a filter
+// which matches the same transactions.
+func TestNewMerkleBlockWithTxs(t *testing.T) {
+	selections := []struct {
+		name  string
+		match func(i, numTx int) bool
+	}{
+		{"first", func(i, numTx int) bool { return i == 0 }},
+		{"last", func(i, numTx int) bool { return i == numTx-1 }},
+		{"every third", func(i, numTx int) bool { return i%3 == 1 }},
+		{"all", func(i, numTx int) bool { return true }},
+	}
+
+	for _, numTx := range []int{1, 2, 3, 7, 8, 9, 100} {
+		block := merkleTestBlock(numTx)
+		merkles := blockchain.BuildMerkleTreeStore(block.Transactions())
+		for _, sel := range selections {
+			txHashes := make(map[wire.ShaHash]struct{})
+			filter := bloom.NewFilter(uint32(numTx), 0, 0.0000001,
+				wire.BloomUpdateNone)
+			var wantIndices []uint32
+			for i, tx := range block.Transactions() {
+				if !sel.match(i, numTx) {
+					continue
+				}
+				txHashes[*tx.Sha()] = struct{}{}
+				filter.AddHash(tx.Sha())
+				wantIndices = append(wantIndices, uint32(i))
+			}
+
+			fresh, freshIndices := bloom.NewMerkleBlockWithTxs(block,
+				txHashes, nil)
+			cached, cachedIndices := bloom.NewMerkleBlockWithTxs(block,
+				txHashes, merkles)
+			filtered, _ := bloom.NewMerkleBlock(block, filter)
+
+			want := serializeMerkleBlock(t, filtered)
+			if got := serializeMerkleBlock(t, fresh); !bytes.Equal(got, want) {
+				t.Errorf("%d txns, %s: unexpected merkle block without "+
+					"tree - got %x, want %x", numTx, sel.name,
+					got, want)
+			}
+			if got := serializeMerkleBlock(t, cached); !bytes.Equal(got, want) {
+				t.Errorf("%d txns, %s: unexpected merkle block with "+
+					"tree - got %x, want %x", numTx, sel.name,
+					got, want)
+			}
+			for _, indices := range [][]uint32{freshIndices, cachedIndices} {
+				if len(indices) != len(wantIndices) {
+					t.Errorf("%d txns, %s: unexpected matched "+
+						"indices - got %v, want %v", numTx,
+						sel.name, indices, wantIndices)
+					continue
+				}
+				for i := range indices {
+					if indices[i] != wantIndices[i] {
+						t.Errorf("%d txns, %s: unexpected "+
+							"matched indices - got %v, "+
+							"want %v", numTx, sel.name,
+							indices, wantIndices)
+						break
+					}
+				}
+			}
+		}
+	}
+
+	// A tree store which does not belong to the block is ignored.
+	block := merkleTestBlock(9)
+	txHashes := map[wire.ShaHash]struct{}{*block.Transactions()[4].Sha(): {}}
+	want, _ := bloom.NewMerkleBlockWithTxs(block, txHashes, nil)
+	otherMerkles := blockchain.BuildMerkleTreeStore(
+		merkleTestBlock(3).Transactions())
+	got, _ := bloom.NewMerkleBlockWithTxs(block, txHashes, otherMerkles)
+	if !bytes.Equal(serializeMerkleBlock(t, got),
+		serializeMerkleBlock(t, want)) {
+
+		t.Errorf("merkle block built from mismatched tree store")
+	}
+}
+
+// benchmarkTxProofs benchmarks creating a proof for every transaction of a
+// block with 2,000 transactions either with or without the merkle tree store
+// of the block.
+func benchmarkTxProofs(b *testing.B, useTree bool) {
+	block := merkleTestBlock(2000)
+	var merkles []*wire.ShaHash
+	if useTree {
+		merkles = blockchain.BuildMerkleTreeStore(block.Transactions())
+	}
+	txHashes := make([]map[wire.ShaHash]struct{}, 0, 2000)
+	for _, tx := range block.Transactions() {
+		txHashes = append(txHashes, map[wire.ShaHash]struct{}{
+			*tx.Sha(): {},
+		})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, hashes := range txHashes {
+			bloom.NewMerkleBlockWithTxs(block, hashes, merkles)
+		}
+	}
+}
+
+// BenchmarkTxProofs benchmarks creating proofs for every transaction of a
+// large block by recomputing the merkle tree for every proof.
+func BenchmarkTxProofs(b *testing.B) {
+	benchmarkTxProofs(b, false)
+}
+
+// BenchmarkTxProofsCachedTree benchmarks creating proofs for every transaction
+// of a large block with the merkle tree of the block cached.
+func BenchmarkTxProofsCachedTree(b *testing.B) {
+	benchmarkTxProofs(b, true)
+}
@@ -187,6 +187,20 @@ type uint32Time time.Time
 // time.Time since it is otherwise ambiguous.
 type int64Time time.Time
 
+// uint32Timestamp returns the passed time as the number of seconds since the
+// unix epoch encoded in a uint32.  A MessageError is returned for times which
+// can't be represented, namely times before the unix epoch and after the year
+// 2106, rather than silently truncating them.
+func uint32Timestamp(funcName string, t time.Time) (uint32, error) {
+	sec := t.Unix()
+	if sec < 0 || sec > math.MaxUint32 {
+		str := fmt.Sprintf("timestamp %v (%d) does not fit in a uint32",
+			t, sec)
+		return 0, messageError(funcName, str)
+	}
+	return uint32(sec), nil
+}
+
 // readElement reads the next sequence of bytes from r using little endian
 // depending on the concrete type of element pointed to.
 func readElement(r io.Reader, element interface{}) error {
@@ -122,6 +122,13 @@ a remote node running a bitcoin peer.  Example syntax is:
 		// Log and handle the error
 	}
 
+Connections which receive a large number of messages can use a MessageReader
+instead, which reuses its buffers across reads so that reading small messages
+such as ping, pong, and inv messages does not allocate.  Some of the messages
+and raw payloads it returns are only valid until the next read, so see the
+MessageReader documentation for details on which must be copied before they
+are retained.
+
 Writing Messages
 
 In order to marshall bitcoin messages to the wire, use the WriteMessage
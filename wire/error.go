@@ -33,3 +33,21 @@ func (e *MessageError) Error() string {
 func messageError(f string, desc string) *MessageError {
 	return &MessageError{Func: f, Description: desc}
 }
+
+// PayloadLimitError describes a message which was not read or written because
+// its payload exceeds a limit imposed by the caller which is lower than the
+// protocol maximum.  When reading, the payload is discarded without being
+// buffered, so the connection can continue to be used.
+type PayloadLimitError struct {
+	Func    string // Function name
+	Command string // Command of the message
+	Length  uint32 // Payload length declared or encoded
+	Limit   uint32 // Limit imposed by the caller
+}
+
+// Error satisfies the error interface and prints human-readable errors.
+func (e *PayloadLimitError) Error() string {
+	return fmt.Sprintf("%v: payload of %d bytes for message of type [%v] "+
+		"exceeds limit of %d bytes", e.Func, e.Length, e.Command,
+		e.Limit)
+}
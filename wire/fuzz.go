@@ -0,0 +1,78 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+)
+
+// fuzzRoundTrip checks the serialization round trip of the message decoded from
+// the passed data by the passed function.  Data which fails to decode is not
+// interesting and results in 0.  Otherwise, the decoded message must encode to
+// exactly the bytes it was decoded from, and decoding those bytes again must
+// result in the same message, or it panics since either is a bug.  It returns 1
+// to tell the fuzzer the input is interesting.
+func fuzzRoundTrip(data []byte, newMsg func() interface{}, decode func(interface{}, *bytes.Reader) error, encode func(interface{}, *bytes.Buffer) error) int {
+	r := bytes.NewReader(data)
+	msg := newMsg()
+	if err := decode(msg, r); err != nil {
+		return 0
+	}
+	consumed := data[:len(data)-r.Len()]
+
+	var buf bytes.Buffer
+	if err := encode(msg, &buf); err != nil {
+		panic(fmt.Sprintf("unable to encode decoded %T: %v", msg, err))
+	}
+	if !bytes.Equal(buf.Bytes(), consumed) {
+		panic(fmt.Sprintf("%T encodes to %x, but was decoded from %x",
+			msg, buf.Bytes(), consumed))
+	}
+
+	msg2 := newMsg()
+	r = bytes.NewReader(buf.Bytes())
+	if err := decode(msg2, r); err != nil {
+		panic(fmt.Sprintf("unable to decode encoded %T %x: %v", msg,
+			buf.Bytes(), err))
+	}
+	if r.Len() != 0 {
+		panic(fmt.Sprintf("decoding encoded %T %x left %d bytes", msg,
+			buf.Bytes(), r.Len()))
+	}
+	if !reflect.DeepEqual(msg, msg2) {
+		panic(fmt.Sprintf("%T %x decodes differently the second time",
+			msg, buf.Bytes()))
+	}
+	return 1
+}
+
+// fuzzMsgBlock checks the serialization round trip of the block decoded from
+// the passed data.  See fuzzRoundTrip for details.
+func fuzzMsgBlock(data []byte) int {
+	return fuzzRoundTrip(data,
+		func() interface{} { return new(MsgBlock) },
+		func(msg interface{}, r *bytes.Reader) error {
+			return msg.(*MsgBlock).Deserialize(r)
+		},
+		func(msg interface{}, w *bytes.Buffer) error {
+			return msg.(*MsgBlock).Serialize(w)
+		})
+}
+
+// fuzzMsgTx checks the serialization round trip of the transaction decoded from
+// the passed data.  See fuzzRoundTrip for details.
+func fuzzMsgTx(data []byte) int {
+	return fuzzRoundTrip(data,
+		func() interface{} { return new(MsgTx) },
+		func(msg interface{}, r *bytes.Reader) error {
+			return msg.(*MsgTx).Deserialize(r)
+		},
+		func(msg interface{}, w *bytes.Buffer) error {
+			return msg.(*MsgTx).Serialize(w)
+		})
+}
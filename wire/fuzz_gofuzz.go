@@ -0,0 +1,22 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+// This file is ignored during the regular build due to the following build tag.
+// The tag is set by go-fuzz-build.
+// +build gofuzz
+
+package wire
+
+// FuzzMsgBlock is the go-fuzz entry point which checks that blocks round trip
+// through their serialization.
+func FuzzMsgBlock(data []byte) int {
+	return fuzzMsgBlock(data)
+}
+
+// FuzzMsgTx is the go-fuzz entry point which checks that transactions round
+// trip through their serialization.
+func FuzzMsgTx(data []byte) int {
+	return fuzzMsgTx(data)
+}
@@ -0,0 +1,253 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire_test
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// fuzzCorpus is the directory the seed corpus of the go-fuzz entry points is
+// written to when it is set.  The seeds of each entry point are written to the
+// corpus subdirectory of a directory named after it, which is the layout of the
+// go-fuzz working directory.
+var fuzzCorpus = flag.String("fuzzcorpus", "", "write the seed corpus of "+
+	"the fuzz entry points to the passed directory")
+
+// fuzzSeed describes a seed input of a fuzz entry point.
+type fuzzSeed struct {
+	name string
+	data []byte
+}
+
+// fuzzTxSeeds returns the serialized test transactions used to seed the fuzzing
+// of transactions.
+func fuzzTxSeeds(t *testing.T) []fuzzSeed {
+	// tx bb41a757f405890fb0f5856228e23b715702d714d59bf2b1feb70d8b2b4e3e08
+	// from the main block chain.
+	fi, err := os.Open("testdata/megatx.bin.bz2")
+	if err != nil {
+		t.Fatalf("Failed to read transaction data: %v", err)
+	}
+	defer fi.Close()
+	megaTx, err := ioutil.ReadAll(bzip2.NewReader(fi))
+	if err != nil {
+		t.Fatalf("Failed to read transaction data: %v", err)
+	}
+
+	seeds := []fuzzSeed{
+		{"multitx", multiTxEncoded},
+		{"megatx", megaTx},
+	}
+	for i, tx := range blockOne.Transactions {
+		var buf bytes.Buffer
+		if err := tx.Serialize(&buf); err != nil {
+			t.Fatalf("Serialize #%d: %v", i, err)
+		}
+		seeds = append(seeds, fuzzSeed{"blockonetx", buf.Bytes()})
+	}
+	return seeds
+}
+
+// fuzzBlockSeeds returns the serialized test blocks used to seed the fuzzing of
+// blocks.
+func fuzzBlockSeeds() []fuzzSeed {
+	return []fuzzSeed{{"blockone", blockOneBytes}}
+}
+
+// writeFuzzCorpus writes the passed seeds to the corpus of the named fuzz entry
+// point when a corpus directory was requested.
+func writeFuzzCorpus(t *testing.T, entryPoint string, seeds []fuzzSeed) {
+	if *fuzzCorpus == "" {
+		return
+	}
+
+	dir := filepath.Join(*fuzzCorpus, entryPoint, "corpus")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatalf("unable to create corpus dir: %v", err)
+	}
+	for i, seed := range seeds {
+		name := filepath.Join(dir, fmt.Sprintf("%s-%d", seed.name, i))
+		if err := ioutil.WriteFile(name, seed.data, 0600); err != nil {
+			t.Fatalf("unable to write seed %s: %v", name, err)
+		}
+	}
+}
+
+// TestFuzzSeeds ensures the seed corpus of the fuzz entry points decodes and
+// round trips through the serialization, and writes it out when requested.
+func TestFuzzSeeds(t *testing.T) {
+	tests := []struct {
+		entryPoint string
+		fuzz       func([]byte) int
+		seeds      []fuzzSeed
+	}{
+		{"FuzzMsgTx", wire.TstFuzzMsgTx, fuzzTxSeeds(t)},
+		{"FuzzMsgBlock", wire.TstFuzzMsgBlock, fuzzBlockSeeds()},
+	}
+
+	for _, test := range tests {
+		for _, seed := range test.seeds {
+			if got := test.fuzz(seed.data); got != 1 {
+				t.Errorf("%s: seed %s was rejected", test.entryPoint,
+					seed.name)
+			}
+		}
+		writeFuzzCorpus(t, test.entryPoint, test.seeds)
+	}
+}
+
+// TestFuzzNonCanonicalVarInt ensures transactions which encode any of their
+// variable length integers with more bytes than required are rejected by the
+// fuzz entry point rather than failing the round trip, since they would
+// otherwise serialize differently than they were read.
+func TestFuzzNonCanonicalVarInt(t *testing.T) {
+	// Encode the input count, the signature script length, the output count
+	// and the public key script length of a transaction with a single empty
+	// input and output with the passed encodings.
+	tx := func(inCount, sigLen, outCount, pkLen []byte) []byte {
+		var b []byte
+		b = append(b, 0x01, 0x00, 0x00, 0x00) // Version
+		b = append(b, inCount...)
+		b = append(b, make([]byte, 36)...) // Previous outpoint
+		b = append(b, sigLen...)
+		b = append(b, 0xff, 0xff, 0xff, 0xff) // Sequence
+		b = append(b, outCount...)
+		b = append(b, make([]byte, 8)...) // Value
+		b = append(b, pkLen...)
+		b = append(b, 0x00, 0x00, 0x00, 0x00) // Lock time
+		return b
+	}
+	one := []byte{0x01}
+	zero := []byte{0x00}
+	oneFD := []byte{0xfd, 0x01, 0x00}
+	zeroFE := []byte{0xfe, 0x00, 0x00, 0x00, 0x00}
+	zeroFF := []byte{0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+
+	if got := wire.TstFuzzMsgTx(tx(one, zero, one, zero)); got != 1 {
+		t.Fatalf("canonical transaction was rejected")
+	}
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"input count", tx(oneFD, zero, one, zero)},
+		{"signature script length", tx(one, zeroFE, one, zero)},
+		{"output count", tx(one, zero, oneFD, zero)},
+		{"public key script length", tx(one, zero, one, zeroFF)},
+	}
+	for _, test := range tests {
+		if got := wire.TstFuzzMsgTx(test.data); got != 0 {
+			t.Errorf("%s: non-canonical var int was not rejected",
+				test.name)
+		}
+	}
+}
+
+// TestTxDecodeAllocBound ensures decoding a tiny transaction which claims huge
+// numbers of inputs or outputs, or a huge script, fails without allocating
+// memory for them up front, which would otherwise allow a few bytes to force
+// the allocation of hundreds of megabytes.
+func TestTxDecodeAllocBound(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "786432 inputs",
+			data: []byte{
+				0x01, 0x00, 0x00, 0x00, // Version
+				0xfe, 0x00, 0x00, 0x0c, 0x00, // Input count
+			},
+		},
+		{
+			name: "3670016 outputs",
+			data: []byte{
+				0x01, 0x00, 0x00, 0x00, // Version
+				0x00,                         // Input count
+				0xfe, 0x00, 0x00, 0x38, 0x00, // Output count
+			},
+		},
+		{
+			name: "33554432 byte signature script",
+			data: append(append([]byte{
+				0x01, 0x00, 0x00, 0x00, // Version
+				0x01, // Input count
+			}, make([]byte, 36)...), // Previous outpoint
+				0xfe, 0x00, 0x00, 0x00, 0x02), // Script length
+		},
+	}
+
+	// maxAlloc is far more than decoding any of the test transactions
+	// needs and far less than preallocating what they claim.
+	const maxAlloc = 1 << 20
+	for _, test := range tests {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		var tx wire.MsgTx
+		err := tx.Deserialize(bytes.NewReader(test.data))
+		runtime.ReadMemStats(&after)
+		if err == nil {
+			t.Errorf("%s: truncated transaction was decoded", test.name)
+			continue
+		}
+		if alloc := after.TotalAlloc - before.TotalAlloc; alloc > maxAlloc {
+			t.Errorf("%s: decoding allocated %d bytes, want at most %d",
+				test.name, alloc, maxAlloc)
+		}
+	}
+}
+
+// TestTxDecodeLargeScript ensures a script larger than the chunks it is read in
+// is decoded intact and that its truncation is reported the same way as the
+// truncation of any other script.
+func TestTxDecodeLargeScript(t *testing.T) {
+	script := make([]byte, 100000)
+	for i := range script {
+		script[i] = byte(i)
+	}
+	tx := wire.NewMsgTx()
+	tx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, script))
+	tx.AddTxOut(wire.NewTxOut(0, script[:1000]))
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		t.Fatalf("Serialize: %v", err)
+	}
+	serialized := buf.Bytes()
+
+	var got wire.MsgTx
+	if err := got.Deserialize(bytes.NewReader(serialized)); err != nil {
+		t.Fatalf("Deserialize: %v", err)
+	}
+	if !bytes.Equal(got.TxIn[0].SignatureScript, script) {
+		t.Errorf("Deserialize: large signature script mismatch")
+	}
+
+	// Truncate the transaction right after the script length, in the
+	// middle of the first chunk and in the middle of the second chunk.
+	scriptStart := 4 + 1 + 36 + 5
+	for _, n := range []int{scriptStart, scriptStart + 10, scriptStart + 70000} {
+		err := got.Deserialize(bytes.NewReader(serialized[:n]))
+		want := io.ErrUnexpectedEOF
+		if n == scriptStart {
+			want = io.EOF
+		}
+		if err != want {
+			t.Errorf("Deserialize truncated at %d: got error %v, "+
+				"want %v", n, err, want)
+		}
+	}
+}
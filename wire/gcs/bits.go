@@ -0,0 +1,76 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gcs
+
+import (
+	"io"
+)
+
+// bitWriter writes bits to a byte slice starting with the most significant bit
+// of each byte.
+type bitWriter struct {
+	bytes []byte
+
+	// free is the number of unused bits in the last byte.
+	free uint
+}
+
+// writeBit appends the passed bit.
+func (w *bitWriter) writeBit(bit bool) {
+	if w.free == 0 {
+		w.bytes = append(w.bytes, 0)
+		w.free = 8
+	}
+	w.free--
+	if bit {
+		w.bytes[len(w.bytes)-1] |= 1 << w.free
+	}
+}
+
+// writeBits appends the passed number of least significant bits of the passed
+// value, most significant bit first.
+func (w *bitWriter) writeBits(value uint64, numBits uint) {
+	for numBits > 0 {
+		numBits--
+		w.writeBit(value&(1<<numBits) != 0)
+	}
+}
+
+// bitReader reads bits from a byte slice starting with the most significant
+// bit of each byte.
+type bitReader struct {
+	bytes []byte
+
+	// pos is the index of the next bit to read.
+	pos uint
+}
+
+// readBit reads the next bit.  It returns io.EOF when all bits have been read.
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= uint(len(r.bytes))*8 {
+		return false, io.EOF
+	}
+	bit := r.bytes[r.pos/8]&(0x80>>(r.pos%8)) != 0
+	r.pos++
+	return bit, nil
+}
+
+// readBits reads the passed number of bits as the least significant bits of
+// the returned value, most significant bit first.
+func (r *bitReader) readBits(numBits uint) (uint64, error) {
+	var value uint64
+	for i := uint(0); i < numBits; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		value <<= 1
+		if bit {
+			value |= 1
+		}
+	}
+	return value, nil
+}
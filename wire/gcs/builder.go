@@ -0,0 +1,91 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gcs
+
+import (
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+const (
+	// DefaultP is the number of bits of the remainder of the Golomb coded
+	// values of the basic filter as defined by BIP0158.
+	DefaultP = 19
+
+	// DefaultM is the inverse of the false positive rate of the basic
+	// filter as defined by BIP0158.
+	DefaultM = 784931
+)
+
+// DeriveKey returns the key used to hash the data elements of the filter of the
+// block with the passed hash, which is the first KeySize bytes of the hash.
+func DeriveKey(blockHash *wire.ShaHash) [KeySize]byte {
+	var key [KeySize]byte
+	copy(key[:], blockHash[:KeySize])
+	return key
+}
+
+// BuildBasicFilter returns the basic filter of the passed block as defined by
+// BIP0158.  It contains the public key scripts of all outputs of the block
+// except those which are empty or start with OP_RETURN along with the passed
+// public key scripts of the previous outputs spent by the block.  Empty
+// previous output scripts, such as the nil entry for the coinbase input, are
+// skipped, and each distinct script is only added once.
+func BuildBasicFilter(block *wire.MsgBlock, prevOutScripts [][]byte) (*Filter, error) {
+	blockHash := block.BlockSha()
+	seen := make(map[string]struct{})
+	var data [][]byte
+	addScript := func(script []byte) {
+		if len(script) == 0 {
+			return
+		}
+		if _, ok := seen[string(script)]; ok {
+			return
+		}
+		seen[string(script)] = struct{}{}
+		data = append(data, script)
+	}
+
+	for _, tx := range block.Transactions {
+		for _, txOut := range tx.TxOut {
+			if len(txOut.PkScript) > 0 &&
+				txOut.PkScript[0] == txscript.OP_RETURN {
+
+				continue
+			}
+			addScript(txOut.PkScript)
+		}
+	}
+	for _, script := range prevOutScripts {
+		addScript(script)
+	}
+
+	return BuildGCSFilter(DefaultP, DefaultM, DeriveKey(&blockHash), data)
+}
+
+// FilterHash returns the hash of the passed filter, which is the double sha256
+// of its serialization as returned by NBytes.
+func FilterHash(filter *Filter) wire.ShaHash {
+	return wire.DoubleSha256SH(filter.NBytes())
+}
+
+// MakeHeaderForFilter returns the header of the passed filter which commits to
+// the header of the filter of the previous block.  The headers form a chain
+// which allows clients to verify the filters they are served.  The previous
+// header of the genesis block is all zeros.
+func MakeHeaderForFilter(filter *Filter, prevHeader *wire.ShaHash) wire.ShaHash {
+	filterHash := FilterHash(filter)
+	return MakeHeaderForFilterHash(&filterHash, prevHeader)
+}
+
+// MakeHeaderForFilterHash returns the header of the filter with the passed hash
+// which commits to the header of the filter of the previous block.
+func MakeHeaderForFilterHash(filterHash, prevHeader *wire.ShaHash) wire.ShaHash {
+	var data [wire.HashSize * 2]byte
+	copy(data[:], filterHash[:])
+	copy(data[wire.HashSize:], prevHeader[:])
+	return wire.DoubleSha256SH(data[:])
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gcs_test
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/chaincfg"
+	"github.com/tinhnguyenhn/colxd/txscript"
+	"github.com/tinhnguyenhn/colxd/wire"
+	"github.com/tinhnguyenhn/colxd/wire/gcs"
+)
+
+// TestBasicFilterGenesis ensures the basic filter and filter header of the
+// testnet3 genesis block match the test vectors of BIP0158.
+func TestBasicFilterGenesis(t *testing.T) {
+	block := chaincfg.TestNet3Params.GenesisBlock
+	filter, err := gcs.BuildBasicFilter(block, nil)
+	if err != nil {
+		t.Fatalf("BuildBasicFilter: unexpected error: %v", err)
+	}
+	if got := hex.EncodeToString(filter.NBytes()); got != "019dfca8" {
+		t.Fatalf("unexpected basic filter - got %s, want 019dfca8", got)
+	}
+
+	header := gcs.MakeHeaderForFilter(filter, &wire.ShaHash{})
+	want := "21584579b7eb08997773e5aeff3a7f932700042d0ed2a6129012b7d7ae81b750"
+	if header.String() != want {
+		t.Fatalf("unexpected filter header - got %s, want %s", header,
+			want)
+	}
+}
+
+// TestBasicFilterContents ensures the basic filter of a block contains the
+// public key scripts of its outputs and spent previous outputs, but not the
+// scripts it is supposed to skip.
+func TestBasicFilterContents(t *testing.T) {
+	outScript := []byte{txscript.OP_DUP, txscript.OP_HASH160, 0x01}
+	prevScript := []byte{txscript.OP_TRUE}
+	nullData := []byte{txscript.OP_RETURN, 0x01, 0x02}
+
+	coinbaseTx := wire.NewMsgTx()
+	coinbaseTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{}, nil))
+	coinbaseTx.AddTxOut(wire.NewTxOut(5000, outScript))
+	coinbaseTx.AddTxOut(wire.NewTxOut(0, nullData))
+	coinbaseTx.AddTxOut(wire.NewTxOut(0, nil))
+	spendTx := wire.NewMsgTx()
+	spendTx.AddTxIn(wire.NewTxIn(&wire.OutPoint{Hash: wire.ShaHash{0x01}},
+		nil))
+	spendTx.AddTxOut(wire.NewTxOut(1000, outScript))
+	block := wire.NewMsgBlock(&wire.BlockHeader{})
+	block.AddTransaction(coinbaseTx)
+	block.AddTransaction(spendTx)
+
+	// The coinbase input has no previous output script.
+	filter, err := gcs.BuildBasicFilter(block, [][]byte{nil, prevScript})
+	if err != nil {
+		t.Fatalf("BuildBasicFilter: unexpected error: %v", err)
+	}
+
+	// The output script is only added once and the empty and null data
+	// scripts are skipped.
+	if filter.N() != 2 {
+		t.Fatalf("unexpected number of filter elements - got %d, "+
+			"want 2", filter.N())
+	}
+	blockHash := block.BlockSha()
+	key := gcs.DeriveKey(&blockHash)
+	tests := []struct {
+		name   string
+		script []byte
+		want   bool
+	}{
+		{"output script", outScript, true},
+		{"previous output script", prevScript, true},
+		{"null data script", nullData, false},
+	}
+	for _, test := range tests {
+		match, err := filter.Match(key, test.script)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		if match != test.want {
+			t.Errorf("%s: unexpected match - got %v, want %v",
+				test.name, match, test.want)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+/*
+Package gcs implements the Golomb-coded set filters defined by BIP0158.
+
+A Golomb-coded set is a compact probabilistic structure similar to a bloom
+filter.  Light clients download the filter of each block and match the scripts
+they are interested in against it locally, so unlike with the bloom filters of
+BIP0037 they don't reveal those scripts to the peers serving them.
+
+The Filter type houses a filter along with the functions to serialize it and
+match data elements against it.  BuildGCSFilter creates a filter with arbitrary
+parameters, while BuildBasicFilter creates the basic filter of a block, which
+contains the public key scripts of its outputs and of the previous outputs it
+spends.
+
+Filter Headers
+
+The hash of each filter is committed to by a filter header along with the header
+of the filter of the previous block.  The resulting chain of filter headers
+allows clients to verify the filters they are served against the headers they
+obtained from multiple peers.  MakeHeaderForFilter computes the header of a
+filter.
+*/
+package gcs
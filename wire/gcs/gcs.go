@@ -0,0 +1,304 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gcs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+const (
+	// KeySize is the size of the key used to hash the data elements of a
+	// filter.
+	KeySize = 16
+
+	// MaxP is the maximum number of bits of the remainder of the Golomb
+	// coded values supported by filters.
+	MaxP = 32
+)
+
+var (
+	// ErrNTooBig is returned when the number of data elements of a filter
+	// does not fit into a uint32.
+	ErrNTooBig = errors.New("N is too big to fit in uint32")
+
+	// ErrPTooBig is returned when the number of bits of the remainder of
+	// the Golomb coded values exceeds MaxP.
+	ErrPTooBig = errors.New("P is too big")
+
+	// ErrMisserialized is returned when a serialized filter does not
+	// contain the number of values it claims to.
+	ErrMisserialized = errors.New("filter is misserialized")
+)
+
+// Filter describes a Golomb-coded set of data elements as defined by BIP0158.
+// Each data element is hashed to a uniformly distributed value in the range of
+// N*M, where N is the number of elements and M is the inverse of the false
+// positive rate.  The sorted values are then stored as the Golomb-Rice coded
+// differences between them using P bits for the remainder.  A filter may
+// report a data element which was not added to it as a match with a
+// probability of 1/M, but it never fails to report a data element which was
+// added to it.
+//
+// Filters are immutable and thus safe for concurrent access.
+type Filter struct {
+	n          uint32
+	p          uint8
+	modulusNM  uint64
+	filterData []byte
+}
+
+// mulHi64 returns the upper 64 bits of the 128-bit product of the passed
+// values.
+func mulHi64(a, b uint64) uint64 {
+	aHi, aLo := a>>32, a&0xffffffff
+	bHi, bLo := b>>32, b&0xffffffff
+	loLo := aLo * bLo
+	hiLo := aHi * bLo
+	loHi := aLo * bHi
+	hiHi := aHi * bHi
+	cross := loLo>>32 + hiLo&0xffffffff + loHi
+	return hiHi + hiLo>>32 + cross>>32
+}
+
+// hashToRange hashes the passed data element with the passed key and maps the
+// result to the range of the passed modulus without a division.
+func hashToRange(k0, k1 uint64, data []byte, modulus uint64) uint64 {
+	return mulHi64(SipHash(k0, k1, data), modulus)
+}
+
+// splitKey returns the passed key as two little-endian 64-bit halves.
+func splitKey(key [KeySize]byte) (uint64, uint64) {
+	return binary.LittleEndian.Uint64(key[:8]),
+		binary.LittleEndian.Uint64(key[8:])
+}
+
+// uint64s implements sort.Interface to sort a slice of uint64 values.
+type uint64s []uint64
+
+func (s uint64s) Len() int           { return len(s) }
+func (s uint64s) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64s) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// BuildGCSFilter returns a filter of the passed data elements, which are hashed
+// with the passed key.  P is the number of bits of the remainder of the Golomb
+// coded values and M is the inverse of the false positive rate.  Duplicate data
+// elements are not removed, so callers which need a set must remove them
+// first.
+func BuildGCSFilter(P uint8, M uint64, key [KeySize]byte, data [][]byte) (*Filter, error) {
+	if uint64(len(data)) > math.MaxUint32 {
+		return nil, ErrNTooBig
+	}
+	if P > MaxP {
+		return nil, ErrPTooBig
+	}
+
+	f := &Filter{
+		n:         uint32(len(data)),
+		p:         P,
+		modulusNM: uint64(len(data)) * M,
+	}
+	if f.n == 0 {
+		return f, nil
+	}
+
+	// Hash the data elements to the range of the filter and sort them.
+	k0, k1 := splitKey(key)
+	values := make([]uint64, 0, len(data))
+	for _, d := range data {
+		values = append(values, hashToRange(k0, k1, d, f.modulusNM))
+	}
+	sort.Sort(uint64s(values))
+
+	// Write the differences between the values Golomb-Rice coded, which
+	// is the quotient in unary followed by the remainder in P bits.
+	var w bitWriter
+	var lastValue uint64
+	for _, v := range values {
+		delta := v - lastValue
+		lastValue = v
+		for q := delta >> P; q > 0; q-- {
+			w.writeBit(true)
+		}
+		w.writeBit(false)
+		w.writeBits(delta, uint(P))
+	}
+	f.filterData = w.bytes
+	return f, nil
+}
+
+// FromBytes returns a filter with the passed number of data elements and
+// parameters which is deserialized from the passed Golomb coded values.  The
+// passed bytes are copied.
+func FromBytes(N uint32, P uint8, M uint64, d []byte) (*Filter, error) {
+	if P > MaxP {
+		return nil, ErrPTooBig
+	}
+
+	f := &Filter{
+		n:         N,
+		p:         P,
+		modulusNM: uint64(N) * M,
+	}
+	if len(d) > 0 {
+		f.filterData = make([]byte, len(d))
+		copy(f.filterData, d)
+	}
+
+	// Ensure all of the values can be decoded so matching against the
+	// filter never runs out of data.
+	r := bitReader{bytes: f.filterData}
+	for i := uint32(0); i < N; i++ {
+		if _, err := f.readDelta(&r); err != nil {
+			return nil, ErrMisserialized
+		}
+	}
+	return f, nil
+}
+
+// FromNBytes returns a filter with the passed parameters which is deserialized
+// from the passed bytes as produced by NBytes.
+func FromNBytes(P uint8, M uint64, d []byte) (*Filter, error) {
+	r := bytes.NewReader(d)
+	n, err := wire.ReadVarInt(r, 0)
+	if err != nil {
+		return nil, ErrMisserialized
+	}
+	if n > math.MaxUint32 {
+		return nil, ErrNTooBig
+	}
+	return FromBytes(uint32(n), P, M, d[len(d)-r.Len():])
+}
+
+// N returns the number of data elements of the filter.
+func (f *Filter) N() uint32 {
+	return f.n
+}
+
+// P returns the number of bits of the remainder of the Golomb coded values.
+func (f *Filter) P() uint8 {
+	return f.p
+}
+
+// Bytes returns the serialized Golomb coded values of the filter without the
+// number of data elements.
+func (f *Filter) Bytes() []byte {
+	filterData := make([]byte, len(f.filterData))
+	copy(filterData, f.filterData)
+	return filterData
+}
+
+// NBytes returns the serialized filter prefixed with the number of data
+// elements as a variable length integer.  This is the serialization defined by
+// BIP0158.
+func (f *Filter) NBytes() []byte {
+	var buf bytes.Buffer
+	buf.Grow(wire.VarIntSerializeSize(uint64(f.n)) + len(f.filterData))
+	wire.WriteVarInt(&buf, 0, uint64(f.n))
+	buf.Write(f.filterData)
+	return buf.Bytes()
+}
+
+// readDelta reads the next Golomb-Rice coded difference from the passed bit
+// reader.
+func (f *Filter) readDelta(r *bitReader) (uint64, error) {
+	var quotient uint64
+	for {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		quotient++
+	}
+	remainder, err := r.readBits(uint(f.p))
+	if err != nil {
+		return 0, err
+	}
+	return quotient<<f.p | remainder, nil
+}
+
+// Match returns whether or not the passed data element, hashed with the passed
+// key, is likely contained in the filter.
+func (f *Filter) Match(key [KeySize]byte, data []byte) (bool, error) {
+	if f.n == 0 {
+		return false, nil
+	}
+
+	k0, k1 := splitKey(key)
+	target := hashToRange(k0, k1, data, f.modulusNM)
+	r := bitReader{bytes: f.filterData}
+	var value uint64
+	for i := uint32(0); i < f.n; i++ {
+		delta, err := f.readDelta(&r)
+		if err == io.EOF {
+			return false, ErrMisserialized
+		}
+		if err != nil {
+			return false, err
+		}
+		value += delta
+		switch {
+		case value == target:
+			return true, nil
+		case value > target:
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+// MatchAny returns whether or not any of the passed data elements, hashed with
+// the passed key, is likely contained in the filter.  It is more efficient than
+// calling Match for each data element since the filter is only decoded once.
+func (f *Filter) MatchAny(key [KeySize]byte, data [][]byte) (bool, error) {
+	if f.n == 0 || len(data) == 0 {
+		return false, nil
+	}
+
+	// Hash the data elements to the range of the filter and sort them so
+	// they can be compared with the values of the filter in a single pass.
+	k0, k1 := splitKey(key)
+	targets := make([]uint64, 0, len(data))
+	for _, d := range data {
+		targets = append(targets, hashToRange(k0, k1, d, f.modulusNM))
+	}
+	sort.Sort(uint64s(targets))
+
+	r := bitReader{bytes: f.filterData}
+	var value uint64
+	for i := uint32(0); i < f.n; i++ {
+		delta, err := f.readDelta(&r)
+		if err == io.EOF {
+			return false, ErrMisserialized
+		}
+		if err != nil {
+			return false, err
+		}
+		value += delta
+
+		// Skip the targets which are less than the current value
+		// since they can't be contained in the filter.
+		for len(targets) > 0 && targets[0] < value {
+			targets = targets[1:]
+		}
+		if len(targets) == 0 {
+			return false, nil
+		}
+		if targets[0] == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gcs_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/wire/gcs"
+)
+
+// testKey is the key used to build the filters of the tests.
+var testKey = [gcs.KeySize]byte{0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06,
+	0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f}
+
+// testData returns the passed number of distinct data elements.
+func testData(n int) [][]byte {
+	data := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		data = append(data, []byte(fmt.Sprintf("element %d", i)))
+	}
+	return data
+}
+
+// TestBuildGCSFilter ensures filters are serialized as expected.  The expected
+// serializations were computed with an independent implementation.
+func TestBuildGCSFilter(t *testing.T) {
+	data := [][]byte{[]byte("alpha"), []byte("beta"), []byte("gamma"),
+		[]byte("delta"), []byte("epsilon")}
+	tests := []struct {
+		name string
+		p    uint8
+		m    uint64
+		data [][]byte
+		want string
+	}{
+		{
+			name: "basic filter parameters",
+			p:    gcs.DefaultP,
+			m:    gcs.DefaultM,
+			data: data,
+			want: "05e4595819d4ac8fbe44b4c73e04",
+		},
+		{
+			name: "small parameters",
+			p:    10,
+			m:    1024,
+			data: data,
+			want: "05c5e8454322e09a80",
+		},
+		{
+			name: "empty filter",
+			p:    gcs.DefaultP,
+			m:    gcs.DefaultM,
+			want: "00",
+		},
+	}
+
+	for _, test := range tests {
+		filter, err := gcs.BuildGCSFilter(test.p, test.m, testKey,
+			test.data)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.name, err)
+			continue
+		}
+		got := hex.EncodeToString(filter.NBytes())
+		if got != test.want {
+			t.Errorf("%s: unexpected serialized filter - got %s, "+
+				"want %s", test.name, got, test.want)
+			continue
+		}
+		if filter.N() != uint32(len(test.data)) || filter.P() != test.p {
+			t.Errorf("%s: unexpected parameters N=%d P=%d", test.name,
+				filter.N(), filter.P())
+		}
+
+		// Ensure the filter survives a round trip through its
+		// serialization.
+		filter2, err := gcs.FromNBytes(test.p, test.m, filter.NBytes())
+		if err != nil {
+			t.Errorf("%s: FromNBytes: unexpected error: %v",
+				test.name, err)
+			continue
+		}
+		if !bytes.Equal(filter2.Bytes(), filter.Bytes()) ||
+			filter2.N() != filter.N() {
+
+			t.Errorf("%s: deserialized filter does not match",
+				test.name)
+		}
+	}
+
+	// Ensure invalid parameters are rejected.
+	_, err := gcs.BuildGCSFilter(gcs.MaxP+1, gcs.DefaultM, testKey, data)
+	if err != gcs.ErrPTooBig {
+		t.Errorf("BuildGCSFilter: unexpected error - got %v, want %v",
+			err, gcs.ErrPTooBig)
+	}
+}
+
+// TestFilterMatch ensures every data element added to a filter matches it and
+// that data elements which were not added only rarely do.
+func TestFilterMatch(t *testing.T) {
+	data := testData(1000)
+	filter, err := gcs.BuildGCSFilter(gcs.DefaultP, gcs.DefaultM, testKey,
+		data)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter: unexpected error: %v", err)
+	}
+
+	for _, d := range data {
+		match, err := filter.Match(testKey, d)
+		if err != nil {
+			t.Fatalf("Match: unexpected error: %v", err)
+		}
+		if !match {
+			t.Fatalf("Match: %q does not match the filter", d)
+		}
+	}
+
+	// The false positive rate is 1/M, so none of the other elements are
+	// expected to match.
+	others := testData(2000)[1000:]
+	for _, d := range others {
+		match, err := filter.Match(testKey, d)
+		if err != nil {
+			t.Fatalf("Match: unexpected error: %v", err)
+		}
+		if match {
+			t.Errorf("Match: %q unexpectedly matches the filter", d)
+		}
+	}
+	match, err := filter.MatchAny(testKey, others)
+	if err != nil || match {
+		t.Errorf("MatchAny: unexpected result for elements not in the "+
+			"filter - got %v, %v", match, err)
+	}
+
+	// Matching any element requires a single matching element.
+	match, err = filter.MatchAny(testKey, append(others, data[500]))
+	if err != nil || !match {
+		t.Errorf("MatchAny: unexpected result for an element in the "+
+			"filter - got %v, %v", match, err)
+	}
+
+	// Nothing matches when a different key is used.
+	otherKey := testKey
+	otherKey[0] ^= 0xff
+	match, err = filter.MatchAny(otherKey, data[:10])
+	if err != nil || match {
+		t.Errorf("MatchAny: unexpected result with another key - got "+
+			"%v, %v", match, err)
+	}
+
+	// Nothing matches an empty filter.
+	empty, err := gcs.BuildGCSFilter(gcs.DefaultP, gcs.DefaultM, testKey,
+		nil)
+	if err != nil {
+		t.Fatalf("BuildGCSFilter: unexpected error: %v", err)
+	}
+	if match, _ := empty.Match(testKey, data[0]); match {
+		t.Errorf("Match: element unexpectedly matches empty filter")
+	}
+	if match, _ := empty.MatchAny(testKey, data); match {
+		t.Errorf("MatchAny: element unexpectedly matches empty filter")
+	}
+}
+
+// TestFilterMisserialized ensures filters which don't contain the number of
+// values they claim to are rejected.
+func TestFilterMisserialized(t *testing.T) {
+	filter, err := gcs.BuildGCSFilter(gcs.DefaultP, gcs.DefaultM, testKey,
+		testData(20))
+	if err != nil {
+		t.Fatalf("BuildGCSFilter: unexpected error: %v", err)
+	}
+	serialized := filter.NBytes()
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"truncated values", serialized[:len(serialized)-3]},
+		{"N too large", append([]byte{21}, serialized[1:]...)},
+		{"missing values", []byte{0x01}},
+	}
+	for _, test := range tests {
+		_, err := gcs.FromNBytes(gcs.DefaultP, gcs.DefaultM, test.data)
+		if err != gcs.ErrMisserialized {
+			t.Errorf("%s: unexpected error - got %v, want %v",
+				test.name, err, gcs.ErrMisserialized)
+		}
+	}
+}
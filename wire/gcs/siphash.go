@@ -0,0 +1,78 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gcs
+
+import (
+	"encoding/binary"
+)
+
+// The following constants are the initialization values of the SipHash state.
+const (
+	sipInit0 = 0x736f6d6570736575
+	sipInit1 = 0x646f72616e646f6d
+	sipInit2 = 0x6c7967656e657261
+	sipInit3 = 0x7465646279746573
+)
+
+// sipRound performs a single SipRound on the passed state.
+func sipRound(v0, v1, v2, v3 uint64) (uint64, uint64, uint64, uint64) {
+	v0 += v1
+	v1 = v1<<13 | v1>>(64-13)
+	v1 ^= v0
+	v0 = v0<<32 | v0>>(64-32)
+	v2 += v3
+	v3 = v3<<16 | v3>>(64-16)
+	v3 ^= v2
+	v0 += v3
+	v3 = v3<<21 | v3>>(64-21)
+	v3 ^= v0
+	v2 += v1
+	v1 = v1<<17 | v1>>(64-17)
+	v1 ^= v2
+	v2 = v2<<32 | v2>>(64-32)
+	return v0, v1, v2, v3
+}
+
+// SipHash implements the SipHash-2-4 keyed pseudorandom function for the
+// passed 128-bit key, which is passed as two little-endian 64-bit halves, and
+// data.  It yields a 64-bit hash value and is used to map the data elements of a
+// filter to a uniformly distributed range which is unpredictable without the
+// key.
+func SipHash(k0, k1 uint64, data []byte) uint64 {
+	v0 := k0 ^ sipInit0
+	v1 := k1 ^ sipInit1
+	v2 := k0 ^ sipInit2
+	v3 := k1 ^ sipInit3
+
+	// Compress the data in 8-byte chunks.
+	dataLen := len(data)
+	numBlocks := dataLen / 8
+	for i := 0; i < numBlocks; i++ {
+		m := binary.LittleEndian.Uint64(data[i*8:])
+		v3 ^= m
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+		v0 ^= m
+	}
+
+	// The final chunk consists of the remaining bytes and the length of
+	// the data in its most significant byte.
+	m := uint64(dataLen) << 56
+	for i, b := range data[numBlocks*8:] {
+		m |= uint64(b) << (8 * uint(i))
+	}
+	v3 ^= m
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	v0 ^= m
+
+	// Finalization.
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		v0, v1, v2, v3 = sipRound(v0, v1, v2, v3)
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package gcs_test
+
+import (
+	"testing"
+
+	"github.com/tinhnguyenhn/colxd/wire/gcs"
+)
+
+// TestSipHash ensures the SipHash function produces the correct hash for the
+// reference vectors of SipHash-2-4, which use the key 00..0f and messages
+// consisting of the bytes 00 up to the length of the message.
+func TestSipHash(t *testing.T) {
+	var tests = []struct {
+		dataLen int
+		out     uint64
+	}{
+		{0, 0x726fdb47dd0e0e31},
+		{1, 0x74f839c593dc67fd},
+		{7, 0xab0200f58b01d137},
+		{8, 0x93f5f5799a932462},
+		{9, 0x9e0082df0ba9e4b0},
+		{15, 0xa129ca6149be45e5},
+		{16, 0x3f2acc7f57c29bdb},
+		{63, 0x958a324ceb064572},
+	}
+
+	const k0, k1 = 0x0706050403020100, 0x0f0e0d0c0b0a0908
+	for i, test := range tests {
+		data := make([]byte, test.dataLen)
+		for j := range data {
+			data[j] = byte(j)
+		}
+		result := gcs.SipHash(k0, k1, data)
+		if result != test.out {
+			t.Errorf("SipHash test #%d unexpected result - got %x, "+
+				"want %x", i, result, test.out)
+			continue
+		}
+	}
+}
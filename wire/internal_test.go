@@ -117,3 +117,15 @@ func TstReadBlockHeader(r io.Reader, pver uint32, bh *BlockHeader) error {
 func TstWriteBlockHeader(w io.Writer, pver uint32, bh *BlockHeader) error {
 	return writeBlockHeader(w, pver, bh)
 }
+
+// TstFuzzMsgBlock makes the internal fuzzMsgBlock function available to the
+// test package.
+func TstFuzzMsgBlock(data []byte) int {
+	return fuzzMsgBlock(data)
+}
+
+// TstFuzzMsgTx makes the internal fuzzMsgTx function available to the test
+// package.
+func TstFuzzMsgTx(data []byte) int {
+	return fuzzMsgTx(data)
+}
@@ -7,6 +7,7 @@ package wire
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"unicode/utf8"
@@ -49,6 +50,10 @@ const (
 	CmdMerkleBlock = "merkleblock"
 	CmdReject      = "reject"
 	CmdSendHeaders = "sendheaders"
+	CmdCmpctBlock  = "cmpctblock"
+	CmdGetBlockTxn = "getblocktxn"
+	CmdBlockTxn    = "blocktxn"
+	CmdGetSkeleton = "getskeleton"
 )
 
 // Message is an interface that describes a bitcoin message.  A type that
@@ -62,6 +67,38 @@ type Message interface {
 	MaxPayloadLength(uint32) uint32
 }
 
+// knownCommands maps the commands of all supported messages to themselves so
+// the commands in received message headers can be converted to strings
+// without allocating.
+var knownCommands = map[string]string{
+	CmdVersion:     CmdVersion,
+	CmdVerAck:      CmdVerAck,
+	CmdGetAddr:     CmdGetAddr,
+	CmdAddr:        CmdAddr,
+	CmdGetBlocks:   CmdGetBlocks,
+	CmdInv:         CmdInv,
+	CmdGetData:     CmdGetData,
+	CmdNotFound:    CmdNotFound,
+	CmdBlock:       CmdBlock,
+	CmdTx:          CmdTx,
+	CmdGetHeaders:  CmdGetHeaders,
+	CmdHeaders:     CmdHeaders,
+	CmdPing:        CmdPing,
+	CmdPong:        CmdPong,
+	CmdAlert:       CmdAlert,
+	CmdMemPool:     CmdMemPool,
+	CmdFilterAdd:   CmdFilterAdd,
+	CmdFilterClear: CmdFilterClear,
+	CmdFilterLoad:  CmdFilterLoad,
+	CmdMerkleBlock: CmdMerkleBlock,
+	CmdReject:      CmdReject,
+	CmdSendHeaders: CmdSendHeaders,
+	CmdCmpctBlock:  CmdCmpctBlock,
+	CmdGetBlockTxn: CmdGetBlockTxn,
+	CmdBlockTxn:    CmdBlockTxn,
+	CmdGetSkeleton: CmdGetSkeleton,
+}
+
 // makeEmptyMessage creates a message of the appropriate concrete type based
 // on the command.
 func makeEmptyMessage(command string) (Message, error) {
@@ -133,6 +170,18 @@ func makeEmptyMessage(command string) (Message, error) {
 	case CmdSendHeaders:
 		msg = &MsgSendHeaders{}
 
+	case CmdCmpctBlock:
+		msg = &MsgCmpctBlock{}
+
+	case CmdGetBlockTxn:
+		msg = &MsgGetBlockTxn{}
+
+	case CmdBlockTxn:
+		msg = &MsgBlockTxn{}
+
+	case CmdGetSkeleton:
+		msg = &MsgGetSkeleton{}
+
 	default:
 		return nil, fmt.Errorf("unhandled command [%s]", command)
 	}
@@ -147,28 +196,33 @@ type messageHeader struct {
 	checksum [4]byte    // 4 bytes
 }
 
-// readMessageHeader reads a bitcoin message header from r.
-func readMessageHeader(r io.Reader) (int, *messageHeader, error) {
-	// Since readElements doesn't return the amount of bytes read, attempt
-	// to read the entire header into a buffer first in case there is a
-	// short read so the proper amount of read bytes are known.  This works
-	// since the header is a fixed size.
-	var headerBytes [MessageHeaderSize]byte
-	n, err := io.ReadFull(r, headerBytes[:])
+// readMessageHeader reads a bitcoin message header from r into headerBytes,
+// which must be MessageHeaderSize bytes long, and parses it.  Reading the
+// entire header first ensures the proper amount of read bytes is known in case
+// there is a short read.  Parsing the header does not allocate unless the
+// command is not one of the supported commands.
+func readMessageHeader(r io.Reader, headerBytes []byte) (int, messageHeader, error) {
+	n, err := io.ReadFull(r, headerBytes)
 	if err != nil {
-		return n, nil, err
+		return n, messageHeader{}, err
 	}
-	hr := bytes.NewReader(headerBytes[:])
 
-	// Create and populate a messageHeader struct from the raw header bytes.
-	hdr := messageHeader{}
-	var command [CommandSize]byte
-	readElements(hr, &hdr.magic, &command, &hdr.length, &hdr.checksum)
+	// Populate a messageHeader struct from the raw header bytes.
+	hdr := messageHeader{
+		magic:  BitcoinNet(binary.LittleEndian.Uint32(headerBytes[0:4])),
+		length: binary.LittleEndian.Uint32(headerBytes[16:20]),
+	}
+	copy(hdr.checksum[:], headerBytes[20:24])
 
 	// Strip trailing zeros from command string.
-	hdr.command = string(bytes.TrimRight(command[:], string(0)))
+	command := bytes.TrimRight(headerBytes[4:16], "\x00")
+	if cmd, ok := knownCommands[string(command)]; ok {
+		hdr.command = cmd
+	} else {
+		hdr.command = string(command)
+	}
 
-	return n, &hdr, nil
+	return n, hdr, nil
 }
 
 // discardInput reads n bytes from reader r in chunks and discards the read
@@ -195,6 +249,16 @@ func discardInput(r io.Reader, n uint32) {
 // information and returns the number of bytes written.    This function is the
 // same as WriteMessage except it also returns the number of bytes written.
 func WriteMessageN(w io.Writer, msg Message, pver uint32, btcnet BitcoinNet) (int, error) {
+	return WriteMessageLimitN(w, msg, pver, btcnet, MaxMessagePayload)
+}
+
+// WriteMessageLimitN writes a bitcoin Message to w including the necessary
+// header information and returns the number of bytes written.  This function is
+// the same as WriteMessageN except a *PayloadLimitError is returned without
+// writing anything when the encoded payload exceeds maxPayload bytes.  This is
+// useful for callers which know the remote peer will not accept messages up to
+// the protocol maximum.
+func WriteMessageLimitN(w io.Writer, msg Message, pver uint32, btcnet BitcoinNet, maxPayload uint32) (int, error) {
 	totalBytes := 0
 
 	// Enforce max command size.
@@ -233,6 +297,16 @@ func WriteMessageN(w io.Writer, msg Message, pver uint32, btcnet BitcoinNet) (in
 		return totalBytes, messageError("WriteMessage", str)
 	}
 
+	// Enforce the maximum payload imposed by the caller.
+	if uint32(lenp) > maxPayload {
+		return totalBytes, &PayloadLimitError{
+			Func:    "WriteMessage",
+			Command: cmd,
+			Length:  uint32(lenp),
+			Limit:   maxPayload,
+		}
+	}
+
 	// Create header for the message.
 	hdr := messageHeader{}
 	hdr.magic = btcnet
@@ -279,8 +353,35 @@ func WriteMessage(w io.Writer, msg Message, pver uint32, btcnet BitcoinNet) erro
 // message.  This function is the same as ReadMessage except it also returns the
 // number of bytes read.
 func ReadMessageN(r io.Reader, pver uint32, btcnet BitcoinNet) (int, Message, []byte, error) {
+	return ReadMessageLimitN(r, pver, btcnet, MaxMessagePayload)
+}
+
+// ReadMessageLimitN reads, validates, and parses the next bitcoin Message from
+// r for the provided protocol version and bitcoin network.  This function is
+// the same as ReadMessageN except the payload is limited to maxPayload bytes.
+// When the header indicates a larger payload, the payload is discarded without
+// ever being buffered in its entirety and a *PayloadLimitError is returned.
+// The reader is positioned at the next message in that case, so the caller may
+// continue reading from it.
+func ReadMessageLimitN(r io.Reader, pver uint32, btcnet BitcoinNet, maxPayload uint32) (int, Message, []byte, error) {
+	return readMessage(r, pver, btcnet, maxPayload, nil)
+}
+
+// readMessage implements ReadMessageLimitN and MessageReader.ReadMessage.  When
+// the passed message reader is not nil, the header, small payloads, and the
+// messages which are decoded in place are read into its storage as described
+// by MessageReader instead of being allocated.
+func readMessage(r io.Reader, pver uint32, btcnet BitcoinNet, maxPayload uint32, mr *MessageReader) (int, Message, []byte, error) {
+	var headerBytes []byte
+	if mr != nil {
+		headerBytes = mr.header[:]
+		mr.txShaKnown = false
+	} else {
+		headerBytes = make([]byte, MessageHeaderSize)
+	}
+
 	totalBytes := 0
-	n, hdr, err := readMessageHeader(r)
+	n, hdr, err := readMessageHeader(r, headerBytes)
 	totalBytes += n
 	if err != nil {
 		return totalBytes, nil, nil, err
@@ -295,6 +396,19 @@ func ReadMessageN(r io.Reader, pver uint32, btcnet BitcoinNet) (int, Message, []
 
 	}
 
+	// Enforce the maximum payload imposed by the caller before anything
+	// is allocated for the payload.
+	if hdr.length > maxPayload {
+		discardInput(r, hdr.length)
+		totalBytes += int(hdr.length)
+		return totalBytes, nil, nil, &PayloadLimitError{
+			Func:    "ReadMessage",
+			Command: hdr.command,
+			Length:  hdr.length,
+			Limit:   maxPayload,
+		}
+	}
+
 	// Check for messages from the wrong bitcoin network.
 	if hdr.magic != btcnet {
 		discardInput(r, hdr.length)
@@ -310,12 +424,20 @@ func ReadMessageN(r io.Reader, pver uint32, btcnet BitcoinNet) (int, Message, []
 		return totalBytes, nil, nil, messageError("ReadMessage", str)
 	}
 
-	// Create struct of appropriate message type based on the command.
-	msg, err := makeEmptyMessage(command)
-	if err != nil {
-		discardInput(r, hdr.length)
-		return totalBytes, nil, nil, messageError("ReadMessage",
-			err.Error())
+	// Create struct of appropriate message type based on the command
+	// unless the message reader decodes messages of that type in place.
+	reuse := mr != nil && hdr.length <= MaxReusablePayload
+	var msg Message
+	if reuse {
+		msg = mr.reusableMessage(command)
+	}
+	if msg == nil {
+		msg, err = makeEmptyMessage(command)
+		if err != nil {
+			discardInput(r, hdr.length)
+			return totalBytes, nil, nil, messageError("ReadMessage",
+				err.Error())
+		}
 	}
 
 	// Check for maximum length based on the message type as a malicious client
@@ -330,17 +452,26 @@ func ReadMessageN(r io.Reader, pver uint32, btcnet BitcoinNet) (int, Message, []
 		return totalBytes, nil, nil, messageError("ReadMessage", str)
 	}
 
-	// Read payload.
-	payload := make([]byte, hdr.length)
+	// Read payload.  Block payloads are never read into the reusable
+	// buffer since blocks are commonly retained along with their raw bytes.
+	var payload []byte
+	if reuse && command != CmdBlock {
+		payload = mr.payload[:hdr.length]
+	} else {
+		payload = make([]byte, hdr.length)
+	}
 	n, err = io.ReadFull(r, payload)
 	totalBytes += n
 	if err != nil {
 		return totalBytes, nil, nil, err
 	}
 
-	// Test checksum.
-	checksum := DoubleSha256(payload)[0:4]
-	if !bytes.Equal(checksum[:], hdr.checksum[:]) {
+	// Test checksum.  It is compared as an array so neither it nor the
+	// payload hash escapes to the heap.
+	payloadHash := DoubleSha256SH(payload)
+	var checksum [4]byte
+	copy(checksum[:], payloadHash[0:4])
+	if checksum != hdr.checksum {
 		str := fmt.Sprintf("payload checksum failed - header "+
 			"indicates %v, but actual checksum is %v.",
 			hdr.checksum, checksum)
@@ -349,12 +480,33 @@ func ReadMessageN(r io.Reader, pver uint32, btcnet BitcoinNet) (int, Message, []
 
 	// Unmarshal message.  NOTE: This must be a *bytes.Buffer since the
 	// MsgVersion BtcDecode function requires it.
-	pr := bytes.NewBuffer(payload)
-	err = msg.BtcDecode(pr, pver)
+	var pr *bytes.Buffer
+	if mr != nil {
+		mr.buf = *bytes.NewBuffer(payload)
+		pr = &mr.buf
+	} else {
+		pr = bytes.NewBuffer(payload)
+	}
+	if mr != nil && msg == Message(&mr.inv) {
+		err = mr.inv.decodeInto(pr, pver, mr.invVects[:], mr.invList[:0])
+	} else {
+		err = msg.BtcDecode(pr, pver)
+	}
 	if err != nil {
 		return totalBytes, nil, nil, err
 	}
 
+	// The hash of the payload of a tx message is the hash of the
+	// transaction when the payload is exactly the serialized transaction,
+	// so make it available to the caller of the message reader to avoid
+	// hashing the transaction again.  Any trailing bytes are part of the
+	// payload hash, but not the transaction.
+	if mr != nil {
+		_, isTx := msg.(*MsgTx)
+		mr.txSha = payloadHash
+		mr.txShaKnown = isTx && pr.Len() == 0
+	}
+
 	return totalBytes, msg, payload, nil
 }
 
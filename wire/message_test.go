@@ -11,6 +11,7 @@ import (
 	"io"
 	"net"
 	"reflect"
+	"runtime"
 	"testing"
 	"time"
 
@@ -451,3 +452,91 @@ func TestWriteMessageWireErrors(t *testing.T) {
 		}
 	}
 }
+
+// zeroReader is an io.Reader which returns an endless stream of zeros without
+// allocating.
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+// TestMessageLimit ensures ReadMessageLimitN rejects messages with a declared
+// payload over the limit before allocating it and leaves the reader at the next
+// message, and that WriteMessageLimitN refuses to write such messages.
+func TestMessageLimit(t *testing.T) {
+	pver := wire.ProtocolVersion
+	btcnet := wire.MainNet
+
+	// Create a stream of an inv message header declaring a payload which
+	// is well over the limit followed by a valid ping message.
+	const declared = 16 * 1024 * 1024
+	const limit = 1000
+	var ping bytes.Buffer
+	pingMsg := wire.NewMsgPing(1)
+	if err := wire.WriteMessage(&ping, pingMsg, pver, btcnet); err != nil {
+		t.Fatalf("WriteMessage: unexpected error %v", err)
+	}
+	r := io.MultiReader(bytes.NewReader(makeHeader(btcnet, "inv",
+		declared, 0)), io.LimitReader(zeroReader{}, declared),
+		bytes.NewReader(ping.Bytes()))
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	n, msg, _, err := wire.ReadMessageLimitN(r, pver, btcnet, limit)
+	runtime.ReadMemStats(&after)
+
+	lerr, ok := err.(*wire.PayloadLimitError)
+	if !ok {
+		t.Fatalf("ReadMessageLimitN: unexpected error - got %v <%T>, "+
+			"want *wire.PayloadLimitError", err, err)
+	}
+	if msg != nil {
+		t.Fatalf("ReadMessageLimitN: unexpected message %v", msg)
+	}
+	want := wire.PayloadLimitError{Func: "ReadMessage", Command: "inv",
+		Length: declared, Limit: limit}
+	if *lerr != want {
+		t.Fatalf("ReadMessageLimitN: unexpected error - got %+v, "+
+			"want %+v", *lerr, want)
+	}
+	if n != 24+declared {
+		t.Fatalf("ReadMessageLimitN: unexpected num bytes read - got "+
+			"%d, want %d", n, 24+declared)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1024*1024 {
+		t.Fatalf("ReadMessageLimitN: allocated %d bytes for a "+
+			"rejected payload", allocated)
+	}
+
+	// The reader must be positioned at the next message.
+	_, msg, _, err = wire.ReadMessageLimitN(r, pver, btcnet, limit)
+	if err != nil {
+		t.Fatalf("ReadMessageLimitN: unexpected error %v", err)
+	}
+	if !reflect.DeepEqual(msg, pingMsg) {
+		t.Fatalf("ReadMessageLimitN: unexpected message - got %v, "+
+			"want %v", spew.Sdump(msg), spew.Sdump(pingMsg))
+	}
+
+	// Writing a message over the limit must fail without writing.
+	invMsg := wire.NewMsgInv()
+	for i := 0; i < 100; i++ {
+		invMsg.AddInvVect(wire.NewInvVect(wire.InvTypeTx,
+			&wire.ShaHash{byte(i)}))
+	}
+	var buf bytes.Buffer
+	n, err = wire.WriteMessageLimitN(&buf, invMsg, pver, btcnet, limit)
+	if _, ok := err.(*wire.PayloadLimitError); !ok {
+		t.Fatalf("WriteMessageLimitN: unexpected error - got %v <%T>, "+
+			"want *wire.PayloadLimitError", err, err)
+	}
+	if n != 0 || buf.Len() != 0 {
+		t.Fatalf("WriteMessageLimitN: wrote %d bytes for a message "+
+			"over the limit", buf.Len())
+	}
+}
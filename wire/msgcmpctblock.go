@@ -0,0 +1,225 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+)
+
+// ShortIDSize is the number of bytes of a transaction short ID in a compact
+// block.
+const ShortIDSize = 6
+
+// MaxShortID is the largest value a transaction short ID can have.
+const MaxShortID = 1<<(ShortIDSize*8) - 1
+
+// PrefilledTx is a transaction which is sent in full as part of a compact
+// block along with its index in the block.
+type PrefilledTx struct {
+	Index uint32
+	Tx    *MsgTx
+}
+
+// MsgCmpctBlock implements the Message interface and represents a bitcoin
+// cmpctblock message as defined by BIP0152.  It is used to relay a block by
+// its header and the short IDs of its transactions, so the receiver can
+// reconstruct it from the transactions it already has and only needs to
+// request the missing ones via a getblocktxn message (MsgGetBlockTxn).
+//
+// The transactions the receiver is unlikely to have, such as the coinbase,
+// are sent in full as prefilled transactions.  The short IDs are in the order
+// of the remaining transactions of the block.  On the wire the index of each
+// prefilled transaction is encoded as the difference to the index following
+// the one of the previous prefilled transaction, so the indexes must be
+// strictly increasing.
+type MsgCmpctBlock struct {
+	Header       BlockHeader
+	Nonce        uint64
+	ShortIDs     []uint64
+	PrefilledTxs []PrefilledTx
+}
+
+// TxCount returns the number of transactions in the block the message
+// describes.
+func (msg *MsgCmpctBlock) TxCount() int {
+	return len(msg.ShortIDs) + len(msg.PrefilledTxs)
+}
+
+// readDifferentialIndex reads an index which is encoded as the difference to
+// the index following the passed previous one, where a negative previous index
+// denotes the first one, and returns the absolute index.  Indexes which would
+// exceed the maximum number of transactions in a block are rejected.
+func readDifferentialIndex(r io.Reader, pver uint32, prev int64, caller string) (uint32, error) {
+	diff, err := ReadVarInt(r, pver)
+	if err != nil {
+		return 0, err
+	}
+	if diff >= maxTxPerBlock || uint64(prev+1)+diff >= maxTxPerBlock {
+		str := fmt.Sprintf("differentially encoded index is too high "+
+			"[previous %d, difference %d, max %d]", prev, diff,
+			maxTxPerBlock-1)
+		return 0, messageError(caller, str)
+	}
+	return uint32(uint64(prev+1) + diff), nil
+}
+
+// writeDifferentialIndex writes the passed index as the difference to the
+// index following the passed previous one, where a negative previous index
+// denotes the first one.  The index must be higher than the previous one.
+func writeDifferentialIndex(w io.Writer, pver uint32, prev int64, index uint32, caller string) error {
+	if int64(index) <= prev {
+		str := fmt.Sprintf("indexes are not strictly increasing "+
+			"[previous %d, index %d]", prev, index)
+		return messageError(caller, str)
+	}
+	return WriteVarInt(w, pver, uint64(int64(index)-(prev+1)))
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BtcDecode(r io.Reader, pver uint32) error {
+	err := readBlockHeader(r, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+	err = readElement(r, &msg.Nonce)
+	if err != nil {
+		return err
+	}
+
+	// Prevent more short IDs than transactions could possibly fit into a
+	// block.
+	count, err := ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > maxTxPerBlock {
+		str := fmt.Sprintf("too many short IDs to fit into a block "+
+			"[count %d, max %d]", count, maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BtcDecode", str)
+	}
+	msg.ShortIDs = make([]uint64, 0, count)
+	var buf [8]byte
+	for i := uint64(0); i < count; i++ {
+		if _, err := io.ReadFull(r, buf[:ShortIDSize]); err != nil {
+			return err
+		}
+		msg.ShortIDs = append(msg.ShortIDs, littleEndian.Uint64(buf[:]))
+	}
+
+	// The prefilled transactions together with the short IDs must fit into
+	// a block as well.
+	count, err = ReadVarInt(r, pver)
+	if err != nil {
+		return err
+	}
+	if count > maxTxPerBlock-uint64(len(msg.ShortIDs)) {
+		str := fmt.Sprintf("too many transactions to fit into a block "+
+			"[count %d, max %d]", count+uint64(len(msg.ShortIDs)),
+			maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BtcDecode", str)
+	}
+	msg.PrefilledTxs = make([]PrefilledTx, 0, count)
+	prev := int64(-1)
+	for i := uint64(0); i < count; i++ {
+		index, err := readDifferentialIndex(r, pver, prev,
+			"MsgCmpctBlock.BtcDecode")
+		if err != nil {
+			return err
+		}
+		tx := MsgTx{}
+		if err := tx.BtcDecode(r, pver); err != nil {
+			return err
+		}
+		msg.PrefilledTxs = append(msg.PrefilledTxs, PrefilledTx{
+			Index: index,
+			Tx:    &tx,
+		})
+		prev = int64(index)
+	}
+
+	return nil
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) BtcEncode(w io.Writer, pver uint32) error {
+	if msg.TxCount() > maxTxPerBlock {
+		str := fmt.Sprintf("too many transactions to fit into a block "+
+			"[count %d, max %d]", msg.TxCount(), maxTxPerBlock)
+		return messageError("MsgCmpctBlock.BtcEncode", str)
+	}
+
+	err := writeBlockHeader(w, pver, &msg.Header)
+	if err != nil {
+		return err
+	}
+	err = writeElement(w, msg.Nonce)
+	if err != nil {
+		return err
+	}
+
+	err = WriteVarInt(w, pver, uint64(len(msg.ShortIDs)))
+	if err != nil {
+		return err
+	}
+	var buf [8]byte
+	for _, shortID := range msg.ShortIDs {
+		if shortID > MaxShortID {
+			str := fmt.Sprintf("short ID %x does not fit into %d "+
+				"bytes", shortID, ShortIDSize)
+			return messageError("MsgCmpctBlock.BtcEncode", str)
+		}
+		littleEndian.PutUint64(buf[:], shortID)
+		if _, err := w.Write(buf[:ShortIDSize]); err != nil {
+			return err
+		}
+	}
+
+	err = WriteVarInt(w, pver, uint64(len(msg.PrefilledTxs)))
+	if err != nil {
+		return err
+	}
+	prev := int64(-1)
+	for _, prefilled := range msg.PrefilledTxs {
+		err := writeDifferentialIndex(w, pver, prev, prefilled.Index,
+			"MsgCmpctBlock.BtcEncode")
+		if err != nil {
+			return err
+		}
+		if err := prefilled.Tx.BtcEncode(w, pver); err != nil {
+			return err
+		}
+		prev = int64(prefilled.Index)
+	}
+
+	return nil
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgCmpctBlock) Command() string {
+	return CmdCmpctBlock
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgCmpctBlock) MaxPayloadLength(pver uint32) uint32 {
+	// The prefilled transactions and short IDs are never bigger than the
+	// full block.
+	return MaxBlockPayload
+}
+
+// NewMsgCmpctBlock returns a new bitcoin cmpctblock message that conforms to
+// the Message interface using the passed parameters and defaults for the
+// remaining fields.  See MsgCmpctBlock for details.
+func NewMsgCmpctBlock(header *BlockHeader, nonce uint64) *MsgCmpctBlock {
+	return &MsgCmpctBlock{
+		Header: *header,
+		Nonce:  nonce,
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// TestCompactBlockMessagesWire tests the wire encoding and decoding of the
+// cmpctblock, getblocktxn, and blocktxn messages, including the differential
+// encoding of their transaction indexes, both directly and as complete
+// messages.
+func TestCompactBlockMessagesWire(t *testing.T) {
+	header := blockOne.Header
+	tx := blockOne.Transactions[0]
+	blockHash := header.BlockSha()
+
+	cmpctBlock := wire.NewMsgCmpctBlock(&header, 0x0102030405060708)
+	cmpctBlock.ShortIDs = []uint64{0x060504030201, 0, wire.MaxShortID}
+	cmpctBlock.PrefilledTxs = []wire.PrefilledTx{
+		{Index: 0, Tx: tx},
+		{Index: 3, Tx: tx},
+	}
+	if got := cmpctBlock.TxCount(); got != 5 {
+		t.Fatalf("TxCount: got %d, want 5", got)
+	}
+
+	// The indexes 1, 2, 5, and 300 are encoded as the differences 1, 0, 2,
+	// and 294 to the index following the previous one.
+	getBlockTxn := wire.NewMsgGetBlockTxn(&blockHash,
+		[]uint32{1, 2, 5, 300})
+	getBlockTxnEncoded := append(blockHash.Bytes(),
+		0x04,             // Varint for number of indexes
+		0x01,             // Index 1
+		0x00,             // Index 2
+		0x02,             // Index 5
+		0xfd, 0x26, 0x01, // Index 300
+	)
+
+	blockTxn := wire.NewMsgBlockTxn(&blockHash, []*wire.MsgTx{tx, tx})
+
+	// Ensure the getblocktxn message encodes to the expected bytes.
+	var buf bytes.Buffer
+	if err := getBlockTxn.BtcEncode(&buf, wire.ProtocolVersion); err != nil {
+		t.Fatalf("BtcEncode getblocktxn: unexpected error: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), getBlockTxnEncoded) {
+		t.Fatalf("BtcEncode getblocktxn: got %s want %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(getBlockTxnEncoded))
+	}
+
+	// Ensure the prefilled transaction indexes of the cmpctblock message
+	// are differentially encoded after the header, nonce, and short IDs.
+	buf.Reset()
+	if err := cmpctBlock.BtcEncode(&buf, wire.ProtocolVersion); err != nil {
+		t.Fatalf("BtcEncode cmpctblock: unexpected error: %v", err)
+	}
+	encoded := buf.Bytes()
+	offset := 80 + 8
+	wantShortIDs := []byte{
+		0x03,                               // Varint for number of short IDs
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, // Short ID 0x060504030201
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // Short ID 0
+		0xff, 0xff, 0xff, 0xff, 0xff, 0xff, // Short ID MaxShortID
+		0x02, // Varint for number of prefilled transactions
+		0x00, // Index 0
+	}
+	if got := encoded[offset : offset+len(wantShortIDs)]; !bytes.Equal(got,
+		wantShortIDs) {
+
+		t.Fatalf("BtcEncode cmpctblock: got %s want %s", spew.Sdump(got),
+			spew.Sdump(wantShortIDs))
+	}
+	txSize := tx.SerializeSize()
+	if got := encoded[offset+len(wantShortIDs)+txSize]; got != 0x02 {
+		t.Fatalf("BtcEncode cmpctblock: second prefilled index "+
+			"encoded as %d, want 2", got)
+	}
+
+	// Ensure all of the messages survive a round trip through the wire
+	// protocol as complete messages.
+	msgs := []wire.Message{cmpctBlock, getBlockTxn, blockTxn}
+	for _, msg := range msgs {
+		buf.Reset()
+		_, err := wire.WriteMessageN(&buf, msg, wire.ProtocolVersion,
+			wire.MainNet)
+		if err != nil {
+			t.Fatalf("WriteMessageN %s: unexpected error: %v",
+				msg.Command(), err)
+		}
+		_, got, _, err := wire.ReadMessageN(&buf, wire.ProtocolVersion,
+			wire.MainNet)
+		if err != nil {
+			t.Fatalf("ReadMessageN %s: unexpected error: %v",
+				msg.Command(), err)
+		}
+		if !reflect.DeepEqual(got, msg) {
+			t.Errorf("ReadMessageN %s: mismatched message - got %v "+
+				"want %v", msg.Command(), spew.Sdump(got),
+				spew.Sdump(msg))
+		}
+	}
+}
+
+// TestCompactBlockMessagesWireErrors ensures the cmpctblock and getblocktxn
+// messages refuse to encode indexes which are not strictly increasing and
+// short IDs which do not fit, and refuse to decode indexes beyond the maximum
+// number of transactions in a block.
+func TestCompactBlockMessagesWireErrors(t *testing.T) {
+	header := blockOne.Header
+	tx := blockOne.Transactions[0]
+	blockHash := header.BlockSha()
+
+	tooBigShortID := wire.NewMsgCmpctBlock(&header, 0)
+	tooBigShortID.ShortIDs = []uint64{wire.MaxShortID + 1}
+	unorderedPrefilled := wire.NewMsgCmpctBlock(&header, 0)
+	unorderedPrefilled.PrefilledTxs = []wire.PrefilledTx{
+		{Index: 1, Tx: tx},
+		{Index: 1, Tx: tx},
+	}
+	encodeTests := []struct {
+		name string
+		msg  wire.Message
+	}{
+		{"short ID too big", tooBigShortID},
+		{"duplicate prefilled index", unorderedPrefilled},
+		{"decreasing requested index", wire.NewMsgGetBlockTxn(&blockHash,
+			[]uint32{5, 4})},
+	}
+	for _, test := range encodeTests {
+		var buf bytes.Buffer
+		err := test.msg.BtcEncode(&buf, wire.ProtocolVersion)
+		if _, ok := err.(*wire.MessageError); !ok {
+			t.Errorf("%s: unexpected error - got %v, want "+
+				"MessageError", test.name, err)
+		}
+	}
+
+	// A single index which is too high and an index which becomes too high
+	// after adding it to the previous one are both rejected.
+	decodeTests := []struct {
+		name string
+		buf  []byte
+	}{
+		{"index too high", append(blockHash.Bytes(), 0x01,
+			0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)},
+		{"accumulated index too high", append(blockHash.Bytes(), 0x02,
+			0xfe, 0x00, 0x00, 0x01, 0x00,
+			0xfe, 0x00, 0x00, 0x01, 0x00)},
+	}
+	for _, test := range decodeTests {
+		var msg wire.MsgGetBlockTxn
+		err := msg.BtcDecode(bytes.NewReader(test.buf),
+			wire.ProtocolVersion)
+		if _, ok := err.(*wire.MessageError); !ok {
+			t.Errorf("%s: unexpected error - got %v, want "+
+				"MessageError", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+	"io"
+)
+
+// MsgGetSkeleton implements the Message interface and represents a bitcoin
+// getskeleton message.  It is used to request a sparse skeleton of the block
+// headers of the main chain which follow the block with the start hash.  The
+// skeleton is made of up to Count headers which are Interval blocks apart,
+// that is the headers at the heights of the start block plus Interval, plus
+// twice the Interval, and so on.  The headers are returned via a headers
+// message (MsgHeaders).
+//
+// Only peers which advertise the SFNodeHeaderSkeleton service flag serve
+// getskeleton requests.
+type MsgGetSkeleton struct {
+	HashStart ShaHash
+	Interval  uint32
+	Count     uint32
+}
+
+// validate returns an error when the interval or the count of the message is
+// out of range.
+func (msg *MsgGetSkeleton) validate(op string) error {
+	if msg.Interval == 0 {
+		return messageError(op, "skeleton interval must not be zero")
+	}
+	if msg.Count > MaxBlockHeadersPerMsg {
+		str := fmt.Sprintf("too many skeleton headers requested "+
+			"[count %d, max %d]", msg.Count, MaxBlockHeadersPerMsg)
+		return messageError(op, str)
+	}
+	return nil
+}
+
+// BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
+// This is part of the Message interface implementation.
+func (msg *MsgGetSkeleton) BtcDecode(r io.Reader, pver uint32) error {
+	err := readElements(r, &msg.HashStart, &msg.Interval, &msg.Count)
+	if err != nil {
+		return err
+	}
+
+	return msg.validate("MsgGetSkeleton.BtcDecode")
+}
+
+// BtcEncode encodes the receiver to w using the bitcoin protocol encoding.
+// This is part of the Message interface implementation.
+func (msg *MsgGetSkeleton) BtcEncode(w io.Writer, pver uint32) error {
+	if err := msg.validate("MsgGetSkeleton.BtcEncode"); err != nil {
+		return err
+	}
+
+	return writeElements(w, &msg.HashStart, msg.Interval, msg.Count)
+}
+
+// Command returns the protocol command string for the message.  This is part
+// of the Message interface implementation.
+func (msg *MsgGetSkeleton) Command() string {
+	return CmdGetSkeleton
+}
+
+// MaxPayloadLength returns the maximum length the payload can be for the
+// receiver.  This is part of the Message interface implementation.
+func (msg *MsgGetSkeleton) MaxPayloadLength(pver uint32) uint32 {
+	// Start hash + interval 4 bytes + count 4 bytes.
+	return HashSize + 8
+}
+
+// NewMsgGetSkeleton returns a new bitcoin getskeleton message that conforms to
+// the Message interface using the passed parameters.  See MsgGetSkeleton for
+// details.
+func NewMsgGetSkeleton(hashStart *ShaHash, interval, count uint32) *MsgGetSkeleton {
+	return &MsgGetSkeleton{
+		HashStart: *hashStart,
+		Interval:  interval,
+		Count:     count,
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire_test
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// TestGetSkeleton tests the MsgGetSkeleton API.
+func TestGetSkeleton(t *testing.T) {
+	pver := wire.ProtocolVersion
+
+	hashStart := blockOne.Header.BlockSha()
+	msg := wire.NewMsgGetSkeleton(&hashStart, 2000, 100)
+	if !msg.HashStart.IsEqual(&hashStart) || msg.Interval != 2000 ||
+		msg.Count != 100 {
+
+		t.Errorf("NewMsgGetSkeleton: wrong fields - got %v",
+			spew.Sdump(msg))
+	}
+
+	// Ensure the command is expected value.
+	wantCmd := "getskeleton"
+	if cmd := msg.Command(); cmd != wantCmd {
+		t.Errorf("NewMsgGetSkeleton: wrong command - got %v want %v",
+			cmd, wantCmd)
+	}
+
+	// Ensure max payload is expected value for latest protocol version.
+	// Start hash 32 bytes + interval 4 bytes + count 4 bytes.
+	wantPayload := uint32(40)
+	maxPayload := msg.MaxPayloadLength(pver)
+	if maxPayload != wantPayload {
+		t.Errorf("MaxPayloadLength: wrong max payload length for "+
+			"protocol version %d - got %v, want %v", pver,
+			maxPayload, wantPayload)
+	}
+}
+
+// TestGetSkeletonWire tests the MsgGetSkeleton wire encode and decode.
+func TestGetSkeletonWire(t *testing.T) {
+	hashStart := blockOne.Header.BlockSha()
+	msg := wire.NewMsgGetSkeleton(&hashStart, 2000, 100)
+	msgEncoded := append(hashStart.Bytes(),
+		0xd0, 0x07, 0x00, 0x00, // Interval 2000
+		0x64, 0x00, 0x00, 0x00, // Count 100
+	)
+
+	// Encode the message to wire format.
+	var buf bytes.Buffer
+	err := msg.BtcEncode(&buf, wire.ProtocolVersion)
+	if err != nil {
+		t.Fatalf("BtcEncode error %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), msgEncoded) {
+		t.Fatalf("BtcEncode\n got: %s want: %s",
+			spew.Sdump(buf.Bytes()), spew.Sdump(msgEncoded))
+	}
+
+	// Decode the message from wire format.
+	var readMsg wire.MsgGetSkeleton
+	err = readMsg.BtcDecode(bytes.NewReader(msgEncoded),
+		wire.ProtocolVersion)
+	if err != nil {
+		t.Fatalf("BtcDecode error %v", err)
+	}
+	if !reflect.DeepEqual(&readMsg, msg) {
+		t.Fatalf("BtcDecode\n got: %s want: %s", spew.Sdump(&readMsg),
+			spew.Sdump(msg))
+	}
+}
+
+// TestGetSkeletonWireErrors performs negative tests against wire encode and
+// decode of MsgGetSkeleton to confirm error paths work correctly.
+func TestGetSkeletonWireErrors(t *testing.T) {
+	pver := wire.ProtocolVersion
+	wireErr := &wire.MessageError{}
+
+	hashStart := blockOne.Header.BlockSha()
+	baseGetSkeleton := wire.NewMsgGetSkeleton(&hashStart, 2000, 100)
+	baseGetSkeletonEncoded := append(hashStart.Bytes(),
+		0xd0, 0x07, 0x00, 0x00, // Interval 2000
+		0x64, 0x00, 0x00, 0x00, // Count 100
+	)
+
+	// Message with a zero interval.
+	zeroInterval := wire.NewMsgGetSkeleton(&hashStart, 0, 100)
+	zeroIntervalEncoded := append(hashStart.Bytes(),
+		0x00, 0x00, 0x00, 0x00, // Interval 0
+		0x64, 0x00, 0x00, 0x00, // Count 100
+	)
+
+	// Message requesting more headers than a headers message can carry.
+	maxCount := wire.NewMsgGetSkeleton(&hashStart, 2000,
+		wire.MaxBlockHeadersPerMsg+1)
+	maxCountEncoded := append(hashStart.Bytes(),
+		0xd0, 0x07, 0x00, 0x00, // Interval 2000
+		0xd1, 0x07, 0x00, 0x00, // Count 2001
+	)
+
+	tests := []struct {
+		in       *wire.MsgGetSkeleton // Value to encode
+		buf      []byte               // Wire encoding
+		pver     uint32               // Protocol version for wire encoding
+		max      int                  // Max size of fixed buffer to induce errors
+		writeErr error                // Expected write error
+		readErr  error                // Expected read error
+	}{
+		// Force error in start hash.
+		{baseGetSkeleton, baseGetSkeletonEncoded, pver, 0, io.ErrShortWrite, io.EOF},
+		// Force error in interval.
+		{baseGetSkeleton, baseGetSkeletonEncoded, pver, 32, io.ErrShortWrite, io.EOF},
+		// Force error in count.
+		{baseGetSkeleton, baseGetSkeletonEncoded, pver, 36, io.ErrShortWrite, io.EOF},
+		// Force error with zero interval.
+		{zeroInterval, zeroIntervalEncoded, pver, 40, wireErr, wireErr},
+		// Force error with greater than max count.
+		{maxCount, maxCountEncoded, pver, 40, wireErr, wireErr},
+	}
+
+	t.Logf("Running %d tests", len(tests))
+	for i, test := range tests {
+		// Encode to wire format.
+		w := newFixedWriter(test.max)
+		err := test.in.BtcEncode(w, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.writeErr) {
+			t.Errorf("BtcEncode #%d wrong error got: %v, want: %v",
+				i, err, test.writeErr)
+			continue
+		}
+
+		// For errors which are not of type wire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*wire.MessageError); !ok {
+			if err != test.writeErr {
+				t.Errorf("BtcEncode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.writeErr)
+				continue
+			}
+		}
+
+		// Decode from wire format.
+		var msg wire.MsgGetSkeleton
+		r := newFixedReader(test.max, test.buf)
+		err = msg.BtcDecode(r, test.pver)
+		if reflect.TypeOf(err) != reflect.TypeOf(test.readErr) {
+			t.Errorf("BtcDecode #%d wrong error got: %v, want: %v",
+				i, err, test.readErr)
+			continue
+		}
+
+		// For errors which are not of type wire.MessageError, check
+		// them for equality.
+		if _, ok := err.(*wire.MessageError); !ok {
+			if err != test.readErr {
+				t.Errorf("BtcDecode #%d wrong error got: %v, "+
+					"want: %v", i, err, test.readErr)
+				continue
+			}
+		}
+	}
+}
@@ -47,6 +47,14 @@ func (msg *MsgInv) AddInvVect(iv *InvVect) error {
 // BtcDecode decodes r using the bitcoin protocol encoding into the receiver.
 // This is part of the Message interface implementation.
 func (msg *MsgInv) BtcDecode(r io.Reader, pver uint32) error {
+	return msg.decodeInto(r, pver, nil, nil)
+}
+
+// decodeInto decodes r like BtcDecode, except the inventory vectors and the
+// list of pointers to them are decoded into the passed slices when they have
+// enough capacity rather than newly allocated ones.  The inventory list of the
+// message aliases the passed slices in that case.
+func (msg *MsgInv) decodeInto(r io.Reader, pver uint32, invVects []InvVect, invList []*InvVect) error {
 	count, err := ReadVarInt(r, pver)
 	if err != nil {
 		return err
@@ -58,12 +66,18 @@ func (msg *MsgInv) BtcDecode(r io.Reader, pver uint32) error {
 		return messageError("MsgInv.BtcDecode", str)
 	}
 
-	// Create a contiguous slice of inventory vectors to deserialize into in
+	// Use a contiguous slice of inventory vectors to deserialize into in
 	// order to reduce the number of allocations.
-	invList := make([]InvVect, count)
-	msg.InvList = make([]*InvVect, 0, count)
+	if uint64(cap(invVects)) < count {
+		invVects = make([]InvVect, count)
+	}
+	if invList == nil || uint64(cap(invList)) < count {
+		invList = make([]*InvVect, 0, count)
+	}
+	invVects = invVects[:count]
+	msg.InvList = invList[:0]
 	for i := uint64(0); i < count; i++ {
-		iv := &invList[i]
+		iv := &invVects[i]
 		err := readInvVect(r, pver, iv)
 		if err != nil {
 			return err
@@ -0,0 +1,107 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"bytes"
+	"io"
+)
+
+// MaxReusablePayload is the maximum size in bytes of the payloads a
+// MessageReader reads into its reusable buffer.  Larger payloads are read into
+// dedicated slices.
+const MaxReusablePayload = 4096
+
+// maxReusableInvVects is the maximum number of inventory vectors which fit in
+// a payload of MaxReusablePayload bytes.
+const maxReusableInvVects = MaxReusablePayload / 36
+
+// MessageReader reads bitcoin messages from a reader like ReadMessageLimitN,
+// but reuses its storage across reads so that reading small messages does not
+// allocate.  This is intended for connections which receive a large number of
+// small messages such as ping, pong, and inv messages.
+//
+// Since the storage is reused, some of the data returned by ReadMessage is
+// only valid until the next call to it:
+//
+//   - The raw payload aliases the reusable buffer when it is at most
+//     MaxReusablePayload bytes, except for block messages, which always get a
+//     dedicated slice since blocks are commonly retained along with their raw
+//     bytes
+//   - MsgPing, MsgPong, and MsgInv messages with payloads of at most
+//     MaxReusablePayload bytes are decoded in place into storage owned by the
+//     reader, so callers which retain them, or the inventory vectors of an inv
+//     message, must copy them
+//
+// All other messages are newly allocated and copy everything they need out of
+// the payload while they are decoded, so they may be retained without copying.
+//
+// A MessageReader is not safe for concurrent access.
+type MessageReader struct {
+	r       io.Reader
+	header  [MessageHeaderSize]byte
+	payload [MaxReusablePayload]byte
+	buf     bytes.Buffer
+
+	// These fields house the messages which are decoded in place.
+	ping     MsgPing
+	pong     MsgPong
+	inv      MsgInv
+	invVects [maxReusableInvVects]InvVect
+	invList  [maxReusableInvVects]*InvVect
+
+	// txSha is the hash of the transaction in the most recently read
+	// message when txShaKnown is set.  See TxSha.
+	txSha      ShaHash
+	txShaKnown bool
+}
+
+// NewMessageReader returns a new message reader which reads messages from the
+// passed reader.
+func NewMessageReader(r io.Reader) *MessageReader {
+	return &MessageReader{r: r}
+}
+
+// ReadMessage reads, validates, and parses the next bitcoin Message for the
+// provided protocol version and bitcoin network.  It returns the number of
+// bytes read in addition to the parsed Message and raw bytes which comprise the
+// message.  It behaves like ReadMessageLimitN except the returned message and
+// raw bytes may only be valid until the next call as described by
+// MessageReader.
+func (mr *MessageReader) ReadMessage(pver uint32, btcnet BitcoinNet, maxPayload uint32) (int, Message, []byte, error) {
+	return readMessage(mr.r, pver, btcnet, maxPayload, mr)
+}
+
+// TxSha returns the hash of the transaction in the tx message most recently
+// read by ReadMessage.  The hash of the payload of a tx message is the hash of
+// the transaction, so it is already known from verifying the message checksum
+// and the transaction does not need to be hashed again.  The second return
+// value is false when the most recent message was not a tx message or its
+// payload contained bytes beyond the serialized transaction, since the payload
+// hash is not the hash of the transaction then.
+func (mr *MessageReader) TxSha() (ShaHash, bool) {
+	return mr.txSha, mr.txShaKnown
+}
+
+// reusableMessage returns the reset message owned by the reader which messages
+// with the passed command are decoded into, or nil when messages with the
+// command are newly allocated.
+func (mr *MessageReader) reusableMessage(command string) Message {
+	switch command {
+	case CmdPing:
+		mr.ping = MsgPing{}
+		return &mr.ping
+
+	case CmdPong:
+		mr.pong = MsgPong{}
+		return &mr.pong
+
+	case CmdInv:
+		mr.inv = MsgInv{}
+		return &mr.inv
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/tinhnguyenhn/colxd/wire"
+)
+
+// writeMessages returns the passed messages encoded one after another.
+func writeMessages(t *testing.T, pver uint32, msgs ...wire.Message) []byte {
+	var buf bytes.Buffer
+	for _, msg := range msgs {
+		if err := wire.WriteMessage(&buf, msg, pver, wire.MainNet); err != nil {
+			t.Fatalf("WriteMessage: unexpected error: %v", err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// newInvMsg returns an inv message which advertises the passed transaction
+// hashes.
+func newInvMsg(hashes ...wire.ShaHash) *wire.MsgInv {
+	msg := wire.NewMsgInv()
+	for i := range hashes {
+		msg.AddInvVect(wire.NewInvVect(wire.InvTypeTx, &hashes[i]))
+	}
+	return msg
+}
+
+// TestMessageReader ensures a MessageReader reads the same messages, payloads,
+// and byte counts as ReadMessageLimitN, including messages with payloads
+// larger than the reusable buffer, and handles payload limits the same way.
+func TestMessageReader(t *testing.T) {
+	pver := wire.ProtocolVersion
+
+	bigInv := wire.NewMsgInv()
+	for i := 0; i < wire.MaxReusablePayload/36+10; i++ {
+		hash := wire.ShaHash{byte(i), byte(i >> 8)}
+		bigInv.AddInvVect(wire.NewInvVect(wire.InvTypeBlock, &hash))
+	}
+	msgs := []wire.Message{
+		wire.NewMsgPing(1),
+		wire.NewMsgPong(2),
+		newInvMsg(wire.ShaHash{0x01}),
+		bigInv,
+		multiTx,
+		&blockOne,
+		wire.NewMsgVerAck(),
+		newInvMsg(),
+		wire.NewMsgPing(3),
+	}
+	data := writeMessages(t, pver, msgs...)
+
+	mr := wire.NewMessageReader(bytes.NewReader(data))
+	r := bytes.NewReader(data)
+	for i := range msgs {
+		wantN, wantMsg, wantBuf, err := wire.ReadMessageLimitN(r, pver,
+			wire.MainNet, wire.MaxMessagePayload)
+		if err != nil {
+			t.Fatalf("ReadMessageLimitN #%d: unexpected error: %v", i,
+				err)
+		}
+		n, msg, buf, err := mr.ReadMessage(pver, wire.MainNet,
+			wire.MaxMessagePayload)
+		if err != nil {
+			t.Fatalf("ReadMessage #%d: unexpected error: %v", i, err)
+		}
+		if n != wantN {
+			t.Errorf("ReadMessage #%d: unexpected byte count - got "+
+				"%d, want %d", i, n, wantN)
+		}
+		if !bytes.Equal(buf, wantBuf) {
+			t.Errorf("ReadMessage #%d: unexpected payload - got %x, "+
+				"want %x", i, buf, wantBuf)
+		}
+		if !reflect.DeepEqual(msg, wantMsg) {
+			t.Errorf("ReadMessage #%d: unexpected message - got %v, "+
+				"want %v", i, spew.Sdump(msg), spew.Sdump(wantMsg))
+		}
+	}
+
+	// Payloads exceeding the limit are discarded and the reader is
+	// positioned at the next message.
+	data = writeMessages(t, pver, bigInv, wire.NewMsgPing(4))
+	mr = wire.NewMessageReader(bytes.NewReader(data))
+	_, _, _, err := mr.ReadMessage(pver, wire.MainNet, 100)
+	if _, ok := err.(*wire.PayloadLimitError); !ok {
+		t.Fatalf("ReadMessage: unexpected error - got %v, want "+
+			"*PayloadLimitError", err)
+	}
+	_, msg, _, err := mr.ReadMessage(pver, wire.MainNet, 100)
+	if err != nil {
+		t.Fatalf("ReadMessage: unexpected error: %v", err)
+	}
+	if ping, ok := msg.(*wire.MsgPing); !ok || ping.Nonce != 4 {
+		t.Fatalf("ReadMessage: unexpected message %v", spew.Sdump(msg))
+	}
+}
+
+// TestMessageReaderAliasing ensures the messages and payloads which a
+// MessageReader documents as reused are overwritten by later reads, while
+// copies of them and all other messages are never affected by later reads, so
+// there is no cross-message data corruption for callers which copy reused
+// messages or retain other messages as is.
+func TestMessageReaderAliasing(t *testing.T) {
+	pver := wire.ProtocolVersion
+
+	hashA := wire.ShaHash{0xaa}
+	hashB := wire.ShaHash{0xbb}
+	data := writeMessages(t, pver, newInvMsg(hashA), multiTx, &blockOne,
+		newInvMsg(hashB), wire.NewMsgTx(), wire.NewMsgPing(1))
+	data = append(data, writeMessages(t, wire.BIP0031Version,
+		wire.NewMsgPing(2))...)
+	mr := wire.NewMessageReader(bytes.NewReader(data))
+	read := func(pver uint32) (wire.Message, []byte) {
+		_, msg, buf, err := mr.ReadMessage(pver, wire.MainNet,
+			wire.MaxMessagePayload)
+		if err != nil {
+			t.Fatalf("ReadMessage: unexpected error: %v", err)
+		}
+		return msg, buf
+	}
+
+	// Copy the inventory vector of the first inv message, but also keep
+	// the message itself.
+	msg, _ := read(pver)
+	invA := msg.(*wire.MsgInv)
+	invACopy := *invA.InvList[0]
+	txMsg, txBuf := read(pver)
+	blockMsg, blockBuf := read(pver)
+	blockBufCopy := append([]byte(nil), blockBuf...)
+	msg, _ = read(pver)
+	invB := msg.(*wire.MsgInv)
+	_, emptyTxBuf := read(pver)
+
+	// The inv message itself is reused, so it now describes the second inv
+	// message, but the copy is unaffected.
+	if invA != invB || invA.InvList[0].Hash != hashB {
+		t.Errorf("inv message was not reused")
+	}
+	if invACopy.Hash != hashA {
+		t.Errorf("copied inventory vector was corrupted - got %v, want "+
+			"%v", invACopy.Hash, hashA)
+	}
+
+	// Other messages are retained as is without corruption.
+	if !reflect.DeepEqual(txMsg.(*wire.MsgTx).TxIn, multiTx.TxIn) ||
+		!reflect.DeepEqual(txMsg.(*wire.MsgTx).TxOut, multiTx.TxOut) {
+
+		t.Errorf("retained tx message was corrupted - got %v",
+			spew.Sdump(txMsg))
+	}
+	if !reflect.DeepEqual(blockMsg, &blockOne) {
+		t.Errorf("retained block message was corrupted - got %v",
+			spew.Sdump(blockMsg))
+	}
+
+	// Block payloads are never read into the reusable buffer, so they may
+	// be retained along with the block, while the payload of the tx message
+	// aliases the reusable buffer and is overwritten by later payloads.
+	if !bytes.Equal(blockBuf, blockBufCopy) {
+		t.Errorf("retained block payload was corrupted")
+	}
+	if !bytes.Equal(txBuf[:len(emptyTxBuf)], emptyTxBuf) {
+		t.Errorf("tx payload does not alias the reusable buffer")
+	}
+
+	// Reused messages are reset before they are decoded, so values of
+	// previous messages never leak into later ones.
+	msg, _ = read(pver)
+	if ping := msg.(*wire.MsgPing); ping.Nonce != 1 {
+		t.Fatalf("unexpected ping nonce - got %d, want 1", ping.Nonce)
+	}
+	msg, _ = read(wire.BIP0031Version)
+	if ping := msg.(*wire.MsgPing); ping.Nonce != 0 {
+		t.Fatalf("nonce of previous ping leaked into ping without "+
+			"nonce - got %d", ping.Nonce)
+	}
+}
+
+// repeatReader is an io.Reader which endlessly repeats the bytes of a message.
+type repeatReader struct {
+	data []byte
+	off  int
+}
+
+// Read reads the next bytes of the repeated message into p.
+func (r *repeatReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data[r.off:])
+	r.off = (r.off + n) % len(r.data)
+	return n, nil
+}
+
+// TestMessageReaderAllocs ensures reading ping, pong, and inv messages with a
+// single inventory vector with a MessageReader does not allocate.
+func TestMessageReaderAllocs(t *testing.T) {
+	pver := wire.ProtocolVersion
+
+	tests := []struct {
+		name string
+		msg  wire.Message
+	}{
+		{"ping", wire.NewMsgPing(1)},
+		{"pong", wire.NewMsgPong(2)},
+		{"inv of 1", newInvMsg(wire.ShaHash{0x01})},
+	}
+	for _, test := range tests {
+		data := writeMessages(t, pver, test.msg)
+		mr := wire.NewMessageReader(&repeatReader{data: data})
+		allocs := testing.AllocsPerRun(100, func() {
+			_, _, _, err := mr.ReadMessage(pver, wire.MainNet,
+				wire.MaxMessagePayload)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %v", test.name, err)
+			}
+		})
+		if allocs != 0 {
+			t.Errorf("%s: unexpected allocations per message - got "+
+				"%v, want 0", test.name, allocs)
+		}
+	}
+}
+
+// TestMessageReaderTxSha ensures the hash of a transaction read as a tx message
+// is returned by the message reader from the message checksum, and that it is
+// only returned when the payload is exactly the serialized transaction.
+func TestMessageReaderTxSha(t *testing.T) {
+	pver := wire.ProtocolVersion
+	btcnet := wire.MainNet
+
+	var serialized bytes.Buffer
+	if err := multiTx.Serialize(&serialized); err != nil {
+		t.Fatalf("Serialize: unexpected error: %v", err)
+	}
+	wantHash := multiTx.TxSha()
+
+	// A payload with trailing bytes after the transaction has a checksum
+	// which is not over the transaction alone.
+	payload := append(serialized.Bytes(), 0x00)
+	payloadHash := wire.DoubleSha256SH(payload)
+	checksum := binary.LittleEndian.Uint32(payloadHash[:4])
+	var trailing bytes.Buffer
+	trailing.Write(makeHeader(btcnet, wire.CmdTx, uint32(len(payload)),
+		checksum))
+	trailing.Write(payload)
+
+	var buf bytes.Buffer
+	buf.Write(writeMessages(t, pver, multiTx, wire.NewMsgPing(1)))
+	buf.Write(trailing.Bytes())
+	mr := wire.NewMessageReader(&buf)
+
+	// The hash of a tx message is known from its checksum.
+	_, msg, _, err := mr.ReadMessage(pver, btcnet, wire.MaxMessagePayload)
+	if err != nil {
+		t.Fatalf("ReadMessage: unexpected error: %v", err)
+	}
+	hash, ok := mr.TxSha()
+	if !ok || hash != wantHash {
+		t.Fatalf("TxSha: unexpected hash - got %v (known %v), want %v",
+			hash, ok, wantHash)
+	}
+	if hash := msg.(*wire.MsgTx).TxSha(); hash != wantHash {
+		t.Fatalf("TxSha: unexpected hash of the read transaction - got "+
+			"%v, want %v", hash, wantHash)
+	}
+
+	// Other messages have no transaction hash.
+	if _, _, _, err := mr.ReadMessage(pver, btcnet,
+		wire.MaxMessagePayload); err != nil {
+
+		t.Fatalf("ReadMessage: unexpected error: %v", err)
+	}
+	if _, ok := mr.TxSha(); ok {
+		t.Fatal("TxSha: hash known after reading a ping message")
+	}
+
+	// The hash of a tx message with trailing bytes is not known.
+	_, msg, _, err = mr.ReadMessage(pver, btcnet, wire.MaxMessagePayload)
+	if err != nil {
+		t.Fatalf("ReadMessage: unexpected error: %v", err)
+	}
+	if hash, ok := mr.TxSha(); ok {
+		t.Fatalf("TxSha: unexpected hash %v for payload with trailing "+
+			"bytes", hash)
+	}
+	if hash := msg.(*wire.MsgTx).TxSha(); hash != wantHash {
+		t.Fatalf("TxSha: unexpected hash of the read transaction - got "+
+			"%v, want %v", hash, wantHash)
+	}
+}
@@ -20,6 +20,27 @@ const (
 	// of a transaction input can be.
 	MaxTxInSequenceNum uint32 = 0xffffffff
 
+	// SequenceLockTimeDisabled is a flag that, when set on the sequence
+	// number of a transaction input, disables the interpretation of the
+	// sequence number as a relative lock time.  This is part of BIP0068.
+	SequenceLockTimeDisabled uint32 = 1 << 31
+
+	// SequenceLockTimeIsSeconds is a flag that, when set on the sequence
+	// number of a transaction input, causes the relative lock time to be
+	// interpreted in units of SequenceLockTimeGranularity seconds instead
+	// of blocks.  This is part of BIP0068.
+	SequenceLockTimeIsSeconds uint32 = 1 << 22
+
+	// SequenceLockTimeMask is the mask which extracts the relative lock
+	// time from the sequence number of a transaction input.  This is part
+	// of BIP0068.
+	SequenceLockTimeMask uint32 = 0x0000ffff
+
+	// SequenceLockTimeGranularity is the base 2 logarithm of the number of
+	// seconds each unit of a time based relative lock time represents,
+	// which results in units of 512 seconds.  This is part of BIP0068.
+	SequenceLockTimeGranularity = 9
+
 	// MaxPrevOutIndex is the maximum index the index field of a previous
 	// outpoint can be.
 	MaxPrevOutIndex uint32 = 0xffffffff
@@ -73,6 +94,19 @@ const (
 	// peers.  Thus, the peak usage of the free list is 12,500 * 512 =
 	// 6,400,000 bytes.
 	freeListMaxItems = 12500
+
+	// maxTxInOutPrealloc is the maximum number of transaction inputs or
+	// outputs that are allocated before any of them have been read.  The
+	// counts are only bounded by what could possibly fit into a message,
+	// so a few bytes claiming the maximum count would otherwise cause
+	// hundreds of megabytes to be allocated.  Inputs and outputs beyond
+	// this number are allocated in chunks of this size as they are read.
+	maxTxInOutPrealloc = 1024
+
+	// maxScriptPrealloc is the maximum number of bytes of a script that are
+	// allocated before any of them have been read for the same reason.
+	// Larger scripts are read in chunks of this size.
+	maxScriptPrealloc = 1 << 16
 )
 
 // scriptFreeList defines a free list of byte slices (up to the maximum number
@@ -356,15 +390,22 @@ func (msg *MsgTx) BtcDecode(r io.Reader, pver uint32) error {
 		}
 	}
 
-	// Deserialize the inputs.
+	// Deserialize the inputs.  They are allocated in chunks of at most
+	// maxTxInOutPrealloc so the memory used is proportional to the data
+	// actually read rather than the count claimed by the message.
 	var totalScriptSize uint64
-	txIns := make([]TxIn, count)
-	msg.TxIn = make([]*TxIn, count)
+	var txIns []TxIn
+	msg.TxIn = make([]*TxIn, 0, txInOutPrealloc(count))
 	for i := uint64(0); i < count; i++ {
+		if len(txIns) == 0 {
+			txIns = make([]TxIn, txInOutPrealloc(count-i))
+		}
+
 		// The pointer is set now in case a script buffer is borrowed
 		// and needs to be returned to the pool on error.
-		ti := &txIns[i]
-		msg.TxIn[i] = ti
+		ti := &txIns[0]
+		txIns = txIns[1:]
+		msg.TxIn = append(msg.TxIn, ti)
 		err = readTxIn(r, pver, msg.Version, ti)
 		if err != nil {
 			returnScriptBuffers()
@@ -390,14 +431,20 @@ func (msg *MsgTx) BtcDecode(r io.Reader, pver uint32) error {
 		return messageError("MsgTx.BtcDecode", str)
 	}
 
-	// Deserialize the outputs.
-	txOuts := make([]TxOut, count)
-	msg.TxOut = make([]*TxOut, count)
+	// Deserialize the outputs.  They are allocated in chunks the same way
+	// as the inputs.
+	var txOuts []TxOut
+	msg.TxOut = make([]*TxOut, 0, txInOutPrealloc(count))
 	for i := uint64(0); i < count; i++ {
+		if len(txOuts) == 0 {
+			txOuts = make([]TxOut, txInOutPrealloc(count-i))
+		}
+
 		// The pointer is set now in case a script buffer is borrowed
 		// and needs to be returned to the pool on error.
-		to := &txOuts[i]
-		msg.TxOut[i] = to
+		to := &txOuts[0]
+		txOuts = txOuts[1:]
+		msg.TxOut = append(msg.TxOut, to)
 		err = readTxOut(r, pver, msg.Version, to)
 		if err != nil {
 			returnScriptBuffers()
@@ -654,6 +701,15 @@ func writeOutPoint(w io.Writer, pver uint32, version int32, op *OutPoint) error
 	return nil
 }
 
+// txInOutPrealloc returns the number of transaction inputs or outputs to
+// allocate ahead of reading them when the passed number remain to be read.
+func txInOutPrealloc(remaining uint64) uint64 {
+	if remaining > maxTxInOutPrealloc {
+		return maxTxInOutPrealloc
+	}
+	return remaining
+}
+
 // readScript reads a variable length byte array that represents a transaction
 // script.  It is encoded as a varInt containing the length of the array
 // followed by the bytes themselves.  An error is returned if the length is
@@ -676,6 +732,13 @@ func readScript(r io.Reader, pver uint32, maxAllowed uint32, fieldName string) (
 		return nil, messageError("readScript", str)
 	}
 
+	// Scripts larger than maxScriptPrealloc are read in chunks so the
+	// memory used is proportional to the data actually read rather than
+	// the size claimed by the message.
+	if count > maxScriptPrealloc {
+		return readLargeScript(r, count)
+	}
+
 	b := scriptPool.Borrow(count)
 	_, err = io.ReadFull(r, b)
 	if err != nil {
@@ -685,6 +748,31 @@ func readScript(r io.Reader, pver uint32, maxAllowed uint32, fieldName string) (
 	return b, nil
 }
 
+// readLargeScript reads a script of the passed size from r in chunks of at most
+// maxScriptPrealloc bytes, growing the returned buffer as the chunks are read.
+// Like io.ReadFull, it returns io.EOF only when no bytes were read and
+// io.ErrUnexpectedEOF when the script is cut short.
+func readLargeScript(r io.Reader, size uint64) ([]byte, error) {
+	b := make([]byte, 0, maxScriptPrealloc)
+	for uint64(len(b)) < size {
+		chunk := size - uint64(len(b))
+		if chunk > maxScriptPrealloc {
+			chunk = maxScriptPrealloc
+		}
+
+		start := len(b)
+		b = append(b, make([]byte, chunk)...)
+		_, err := io.ReadFull(r, b[start:])
+		if err != nil {
+			if err == io.EOF && start > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
 // readTxIn reads the next sequence of bytes from r as a transaction input
 // (TxIn).
 func readTxIn(r io.Reader, pver uint32, version int32, ti *TxIn) error {
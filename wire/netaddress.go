@@ -23,8 +23,12 @@ func maxNetAddressPayload(pver uint32) uint32 {
 	// Services 8 bytes + ip 16 bytes + port 2 bytes.
 	plen := uint32(26)
 
-	// NetAddressTimeVersion added a timestamp field.
-	if pver >= NetAddressTimeVersion {
+	// NetAddressTimeVersion added a timestamp field, which was widened by
+	// NetAddressTime64Version.
+	if pver >= NetAddressTime64Version {
+		// Timestamp 8 bytes.
+		plen += 8
+	} else if pver >= NetAddressTimeVersion {
 		// Timestamp 4 bytes.
 		plen += 4
 	}
@@ -36,9 +40,11 @@ func maxNetAddressPayload(pver uint32) uint32 {
 // it was last seen, the services it supports, its IP address, and port.
 type NetAddress struct {
 	// Last time the address was seen.  This is, unfortunately, encoded as a
-	// uint32 on the wire and therefore is limited to 2106.  This field is
-	// not present in the bitcoin version message (MsgVersion) nor was it
-	// added until protocol version >= NetAddressTimeVersion.
+	// uint32 on the wire and therefore is limited to 2106 unless the
+	// protocol version is >= NetAddressTime64Version, which encodes it as
+	// an int64.  This field is not present in the bitcoin version message
+	// (MsgVersion) nor was it added until protocol version >=
+	// NetAddressTimeVersion.
 	Timestamp time.Time
 
 	// Bitfield which identifies the services supported by the address.
@@ -110,9 +116,15 @@ func readNetAddress(r io.Reader, pver uint32, na *NetAddress, ts bool) error {
 	var ip [16]byte
 
 	// NOTE: The bitcoin protocol uses a uint32 for the timestamp so it will
-	// stop working somewhere around 2106.  Also timestamp wasn't added until
-	// protocol version >= NetAddressTimeVersion
-	if ts && pver >= NetAddressTimeVersion {
+	// stop working somewhere around 2106 unless the protocol version is >=
+	// NetAddressTime64Version.  Also timestamp wasn't added until protocol
+	// version >= NetAddressTimeVersion
+	if ts && pver >= NetAddressTime64Version {
+		err := readElement(r, (*int64Time)(&na.Timestamp))
+		if err != nil {
+			return err
+		}
+	} else if ts && pver >= NetAddressTimeVersion {
 		err := readElement(r, (*uint32Time)(&na.Timestamp))
 		if err != nil {
 			return err
@@ -139,13 +151,23 @@ func readNetAddress(r io.Reader, pver uint32, na *NetAddress, ts bool) error {
 // like version do not include the timestamp.
 func writeNetAddress(w io.Writer, pver uint32, na *NetAddress, ts bool) error {
 	// NOTE: The bitcoin protocol uses a uint32 for the timestamp so it will
-	// stop working somewhere around 2106.  Also timestamp wasn't added until
-	// until protocol version >= NetAddressTimeVersion.
-	if ts && pver >= NetAddressTimeVersion {
-		err := writeElement(w, uint32(na.Timestamp.Unix()))
+	// stop working somewhere around 2106 unless the protocol version is >=
+	// NetAddressTime64Version.  Timestamps which don't fit are rejected
+	// rather than truncated.  Also timestamp wasn't added until
+	// protocol version >= NetAddressTimeVersion.
+	if ts && pver >= NetAddressTime64Version {
+		err := writeElement(w, na.Timestamp.Unix())
+		if err != nil {
+			return err
+		}
+	} else if ts && pver >= NetAddressTimeVersion {
+		sec, err := uint32Timestamp("writeNetAddress", na.Timestamp)
 		if err != nil {
 			return err
 		}
+		if err := writeElement(w, sec); err != nil {
+			return err
+		}
 	}
 
 	// Ensure to always write 16 bytes even if the ip is nil.
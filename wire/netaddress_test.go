@@ -293,3 +293,86 @@ func TestNetAddressWireErrors(t *testing.T) {
 		}
 	}
 }
+
+// TestNetAddressTimestampRange ensures the boundary values of the timestamp of
+// an address round trip through the addr message with both the uint32 and the
+// int64 encodings, that the encoding is selected by the protocol version, and
+// that timestamps which don't fit in a uint32 are only rejected when they are
+// encoded as one.
+func TestNetAddressTimestampRange(t *testing.T) {
+	pver := wire.ProtocolVersion
+	pver64 := wire.NetAddressTime64Version
+
+	newAddr := func(sec int64) *wire.NetAddress {
+		na := wire.NewNetAddressIPPort(net.ParseIP("127.0.0.1"), 8333,
+			wire.SFNodeNetwork)
+		na.Timestamp = time.Unix(sec, 0)
+		return na
+	}
+	roundTrip := func(sec int64, pver uint32) (int64, int, error) {
+		msg := wire.NewMsgAddr()
+		msg.AddAddress(newAddr(sec))
+		var buf bytes.Buffer
+		if err := msg.BtcEncode(&buf, pver); err != nil {
+			return 0, 0, err
+		}
+		var got wire.MsgAddr
+		err := got.BtcDecode(bytes.NewReader(buf.Bytes()), pver)
+		if err != nil {
+			return 0, 0, err
+		}
+		return got.AddrList[0].Timestamp.Unix(), buf.Len(), nil
+	}
+
+	for _, sec := range []int64{0, 1 << 31, 1<<32 - 1} {
+		for _, pver := range []uint32{pver, pver64} {
+			got, _, err := roundTrip(sec, pver)
+			if err != nil || got != sec {
+				t.Errorf("addr (%d, pver %d): got %d (%v)", sec,
+					pver, got, err)
+			}
+		}
+	}
+
+	// The timestamp is 4 bytes wider with the int64 encoding.
+	_, size, _ := roundTrip(0, pver)
+	_, size64, _ := roundTrip(0, pver64)
+	if size64 != size+4 {
+		t.Errorf("unexpected size of int64 encoding - got %d, want %d",
+			size64, size+4)
+	}
+
+	// Timestamps before the unix epoch and after 2106 can only be encoded
+	// as an int64.
+	for _, sec := range []int64{-1, 1 << 32} {
+		_, _, err := roundTrip(sec, pver)
+		if _, ok := err.(*wire.MessageError); !ok {
+			t.Errorf("addr (%d, pver %d): unexpected error - got %v, "+
+				"want MessageError", sec, pver, err)
+		}
+		got, _, err := roundTrip(sec, pver64)
+		if err != nil || got != sec {
+			t.Errorf("addr (%d, pver %d): got %d (%v)", sec, pver64,
+				got, err)
+		}
+	}
+
+	// The version message encodes its timestamp as an int64 and does not
+	// include the timestamps of its addresses.
+	for _, sec := range []int64{0, 1 << 31, 1<<32 - 1, 1 << 32} {
+		msg := wire.NewMsgVersion(newAddr(-1), newAddr(-1), 123, 0)
+		msg.Timestamp = time.Unix(sec, 0)
+		var buf bytes.Buffer
+		if err := msg.BtcEncode(&buf, pver); err != nil {
+			t.Errorf("version BtcEncode(%d): unexpected error: %v",
+				sec, err)
+			continue
+		}
+		var got wire.MsgVersion
+		err := got.BtcDecode(&buf, pver)
+		if err != nil || got.Timestamp.Unix() != sec {
+			t.Errorf("version (%d): got %d (%v)", sec,
+				got.Timestamp.Unix(), err)
+		}
+	}
+}
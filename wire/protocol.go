@@ -47,6 +47,13 @@ const (
 	// RejectVersion is the protocol version which added a new reject
 	// message.
 	RejectVersion uint32 = 70002
+
+	// NetAddressTime64Version is the future protocol version which encodes
+	// the timestamp of the addresses in the addr message as an int64
+	// rather than a uint32 so it does not overflow in 2106
+	// (pver >= NetAddressTime64Version).  It is higher than ProtocolVersion
+	// since it is not negotiated with peers yet.
+	NetAddressTime64Version uint32 = 80000
 )
 
 // ServiceFlag identifies services supported by a bitcoin peer.
@@ -63,13 +70,38 @@ const (
 	// SFNodeBloom is a flag used to indiciate a peer supports bloom
 	// filtering.
 	SFNodeBloom
+
+	// SFNodeCF is a flag used to indicate a peer serves committed filters
+	// for the blocks of the main chain.
+	SFNodeCF ServiceFlag = 1 << 6
+
+	// SFNodeTxIndex is a flag used to indicate a peer serves getdata
+	// requests for any transaction in the main chain rather than only the
+	// ones in its memory pool.  It lives in the range of bits reserved
+	// for experimental services.
+	SFNodeTxIndex ServiceFlag = 1 << 24
+
+	// SFNodeAddrIndex is a flag used to indicate a peer serves queries for
+	// the transactions which involve an address.  It lives in the range of
+	// bits reserved for experimental services.
+	SFNodeAddrIndex ServiceFlag = 1 << 25
+
+	// SFNodeHeaderSkeleton is a flag used to indicate a peer serves sparse
+	// skeletons of the headers of its main chain via the getskeleton
+	// command.  It lives in the range of bits reserved for experimental
+	// services.
+	SFNodeHeaderSkeleton ServiceFlag = 1 << 26
 )
 
 // Map of service flags back to their constant names for pretty printing.
 var sfStrings = map[ServiceFlag]string{
-	SFNodeNetwork: "SFNodeNetwork",
-	SFNodeGetUTXO: "SFNodeGetUTXO",
-	SFNodeBloom:   "SFNodeBloom",
+	SFNodeNetwork:        "SFNodeNetwork",
+	SFNodeGetUTXO:        "SFNodeGetUTXO",
+	SFNodeBloom:          "SFNodeBloom",
+	SFNodeCF:             "SFNodeCF",
+	SFNodeTxIndex:        "SFNodeTxIndex",
+	SFNodeAddrIndex:      "SFNodeAddrIndex",
+	SFNodeHeaderSkeleton: "SFNodeHeaderSkeleton",
 }
 
 // orderedSFStrings is an ordered list of service flags from highest to
@@ -78,6 +110,10 @@ var orderedSFStrings = []ServiceFlag{
 	SFNodeNetwork,
 	SFNodeGetUTXO,
 	SFNodeBloom,
+	SFNodeCF,
+	SFNodeTxIndex,
+	SFNodeAddrIndex,
+	SFNodeHeaderSkeleton,
 }
 
 // String returns the ServiceFlag in human-readable form.
@@ -21,7 +21,13 @@ func TestServiceFlagStringer(t *testing.T) {
 		{wire.SFNodeNetwork, "SFNodeNetwork"},
 		{wire.SFNodeGetUTXO, "SFNodeGetUTXO"},
 		{wire.SFNodeBloom, "SFNodeBloom"},
-		{0xffffffff, "SFNodeNetwork|SFNodeGetUTXO|SFNodeBloom|0xfffffff8"},
+		{wire.SFNodeCF, "SFNodeCF"},
+		{wire.SFNodeTxIndex, "SFNodeTxIndex"},
+		{wire.SFNodeAddrIndex, "SFNodeAddrIndex"},
+		{wire.SFNodeHeaderSkeleton, "SFNodeHeaderSkeleton"},
+		{0xffffffff, "SFNodeNetwork|SFNodeGetUTXO|SFNodeBloom|SFNodeCF|" +
+			"SFNodeTxIndex|SFNodeAddrIndex|SFNodeHeaderSkeleton|" +
+			"0xf8ffffb8"},
 	}
 
 	t.Logf("Running %d tests", len(tests))
@@ -0,0 +1,64 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"fmt"
+)
+
+// TxFeature identifies a set of transaction rules which only apply to
+// transactions with a minimum version.
+type TxFeature uint32
+
+const (
+	// TxFeatureSequenceLocks identifies the relative lock-time semantics of
+	// transaction input sequence numbers defined by BIP0068.
+	TxFeatureSequenceLocks TxFeature = iota
+
+	// TxFeatureCheckSequenceVerify identifies the verification of the
+	// relative lock-time of an input by OP_CHECKSEQUENCEVERIFY defined by
+	// BIP0112.
+	TxFeatureCheckSequenceVerify
+)
+
+// txFeatureMinVersions maps each transaction feature to the minimum version
+// of the transactions it applies to.
+var txFeatureMinVersions = map[TxFeature]uint32{
+	TxFeatureSequenceLocks:       2,
+	TxFeatureCheckSequenceVerify: 2,
+}
+
+// Map of TxFeature values back to their constant names for pretty printing.
+var txFeatureStrings = map[TxFeature]string{
+	TxFeatureSequenceLocks:       "TxFeatureSequenceLocks",
+	TxFeatureCheckSequenceVerify: "TxFeatureCheckSequenceVerify",
+}
+
+// String returns the TxFeature in human-readable form.
+func (f TxFeature) String() string {
+	if s, ok := txFeatureStrings[f]; ok {
+		return s
+	}
+	return fmt.Sprintf("Unknown TxFeature (%d)", uint32(f))
+}
+
+// TxVersionSupports returns whether the rules of the passed feature apply to
+// transactions with the passed version.  Features apply to every version from
+// the one they were introduced with onwards, including versions which are not
+// defined yet, so transactions with unknown future versions are subject to all
+// of the known rules.  Unknown features are not supported by any version.
+//
+// The version is compared as an unsigned integer, so negative versions are
+// treated as large future versions which support every known feature.  This
+// matches how the reference implementation interprets the version for these
+// rules and must not be changed since doing so would fork the chain.
+//
+// Note that this only reports the version requirement.  Whether a feature is
+// enforced for a transaction also depends on its deployment on the network.
+func TxVersionSupports(feature TxFeature, version int32) bool {
+	minVersion, ok := txFeatureMinVersions[feature]
+	return ok && uint32(version) >= minVersion
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2016 The btcsuite developers
+// Copyright (c) 2016 The Dash developers
+// Use of this source code is governed by an ISC
+// license that can be found in the LICENSE file.
+
+package wire
+
+import (
+	"testing"
+)
+
+// TestTxVersionSupports ensures transaction features apply to the versions
+// they were introduced with and every later version, including unknown future
+// ones and negative versions which are compared as unsigned, and that unknown
+// features are not supported by any version.
+func TestTxVersionSupports(t *testing.T) {
+	const unknownFeature = TxFeature(0xffff)
+	tests := []struct {
+		feature TxFeature
+		version int32
+		want    bool
+	}{
+		{TxFeatureSequenceLocks, -1, true},
+		{TxFeatureSequenceLocks, -0x80000000, true},
+		{TxFeatureSequenceLocks, 0, false},
+		{TxFeatureSequenceLocks, 1, false},
+		{TxFeatureSequenceLocks, 2, true},
+		{TxFeatureSequenceLocks, 1000, true},
+		{TxFeatureCheckSequenceVerify, 1, false},
+		{TxFeatureCheckSequenceVerify, 2, true},
+		{TxFeatureCheckSequenceVerify, -1, true},
+		{unknownFeature, 1, false},
+		{unknownFeature, 2, false},
+		{unknownFeature, 1000, false},
+	}
+
+	for i, test := range tests {
+		got := TxVersionSupports(test.feature, test.version)
+		if got != test.want {
+			t.Errorf("TxVersionSupports #%d (%v, version %d): got %v, "+
+				"want %v", i, test.feature, test.version, got,
+				test.want)
+		}
+	}
+}
+
+// TestTxFeatureStringer tests the stringized output for the TxFeature type.
+func TestTxFeatureStringer(t *testing.T) {
+	tests := []struct {
+		in   TxFeature
+		want string
+	}{
+		{TxFeatureSequenceLocks, "TxFeatureSequenceLocks"},
+		{TxFeatureCheckSequenceVerify, "TxFeatureCheckSequenceVerify"},
+		{0xffff, "Unknown TxFeature (65535)"},
+	}
+
+	for i, test := range tests {
+		result := test.in.String()
+		if result != test.want {
+			t.Errorf("String #%d\n got: %s want: %s", i, result,
+				test.want)
+		}
+	}
+}